@@ -0,0 +1,146 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package audit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+// Files whose encoding newt or the toolchain depends on.
+var encodingCheckedExts = map[string]bool{
+	".c":    true,
+	".cc":   true,
+	".cpp":  true,
+	".cxx":  true,
+	".h":    true,
+	".hpp":  true,
+	".s":    true,
+	".S":    true,
+	".ld":   true,
+	".yml":  true,
+	".yaml": true,
+}
+
+// A file that is not plain UTF-8.
+type EncodingProblem struct {
+	Package string `json:"package"`
+	Repo    string `json:"repo"`
+	File    string `json:"file"`
+
+	// Whether the file starts with a UTF-8 byte order mark.
+	Bom bool `json:"bom,omitempty"`
+
+	// Bytes that aren't valid UTF-8 (usually Windows-1252 characters), and
+	// the line of the first.
+	InvalidBytes int `json:"invalid_bytes,omitempty"`
+	InvalidLine  int `json:"invalid_line,omitempty"`
+}
+
+func (ep EncodingProblem) String() string {
+	parts := []string{}
+	if ep.Bom {
+		parts = append(parts, "UTF-8 byte order mark")
+	}
+	if ep.InvalidBytes > 0 {
+		parts = append(parts, fmt.Sprintf(
+			"%d non-UTF-8 bytes, first on line %d (Windows-1252?)",
+			ep.InvalidBytes, ep.InvalidLine))
+	}
+	return strings.Join(parts, "; ")
+}
+
+type EncodingReport struct {
+	Files    int               `json:"files"`
+	Problems []EncodingProblem `json:"problems"`
+}
+
+// Returns the files of a package that are checked for encoding problems.
+// Packages nested in the package's directory are left to themselves.
+func encodingCheckedFiles(lpkg *pkg.LocalPackage) []string {
+	base := lpkg.BasePath()
+
+	files := []string{}
+	filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if info.IsDir() {
+			if path == base {
+				return nil
+			}
+			if strings.HasPrefix(info.Name(), ".") || info.Name() == "bin" ||
+				util.NodeExist(filepath.Join(path, pkg.PACKAGE_FILE_NAME)) {
+
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if encodingCheckedExts[filepath.Ext(path)] {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	return files
+}
+
+// Reports the source, header, linker script and YAML files of the specified
+// packages that have a byte order mark or aren't valid UTF-8.  newt reads
+// such files as UTF-8 or Windows-1252, but other tools may not.
+func AuditEncoding(lpkgs []*pkg.LocalPackage, projDir string) *EncodingReport {
+	report := &EncodingReport{
+		Problems: []EncodingProblem{},
+	}
+
+	for _, lpkg := range pkg.SortLclPkgs(lpkgs) {
+		for _, path := range encodingCheckedFiles(lpkg) {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			report.Files++
+
+			issues := util.CheckTextEncoding(data)
+			if !issues.Any() {
+				continue
+			}
+
+			report.Problems = append(report.Problems, EncodingProblem{
+				Package:      lpkg.FullName(),
+				Repo:         lpkg.Repo().Name(),
+				File:         relPath(projDir, path),
+				Bom:          issues.Bom,
+				InvalidBytes: issues.InvalidBytes,
+				InvalidLine:  issues.InvalidLine,
+			})
+		}
+	}
+
+	return report
+}
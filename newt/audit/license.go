@@ -0,0 +1,356 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package audit checks the packages a target uses against project policy.
+package audit
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+// Optional file at the root of a project restricting the licenses its
+// targets may use.
+const LICENSE_POLICY_FILENAME = "license-policy.yml"
+
+const (
+	LICENSE_STATUS_OK         = "ok"
+	LICENSE_STATUS_EXEMPT     = "exempt"
+	LICENSE_STATUS_MISSING    = "missing"
+	LICENSE_STATUS_DENIED     = "denied"
+	LICENSE_STATUS_UNAPPROVED = "unapproved"
+	LICENSE_STATUS_NO_FILE    = "no-file"
+)
+
+const (
+	LICENSE_SOURCE_PKG      = "pkg.yml"
+	LICENSE_SOURCE_PKG_FILE = "package file"
+	LICENSE_SOURCE_REPO     = "repo file"
+)
+
+// The licenses a project accepts.  Read from license-policy.yml:
+//
+//	license.allowed:
+//	    - Apache-2.0
+//	    - BSD-3-Clause
+//	license.denied:
+//	    - GPL-3.0-only
+//	license.require_file: true
+//	license.exceptions:
+//	    "@vendor/hw/drivers/blob": "Covered by the vendor agreement"
+//
+// If the allowed list is empty, any license that is not denied is accepted.
+type LicensePolicy struct {
+	Path        string            `json:"path"`
+	Allowed     []string          `json:"allowed,omitempty"`
+	Denied      []string          `json:"denied,omitempty"`
+	RequireFile bool              `json:"require_file"`
+	Exceptions  map[string]string `json:"exceptions,omitempty"`
+}
+
+type RepoLicense struct {
+	Repo    string `json:"repo"`
+	License string `json:"license,omitempty"`
+	File    string `json:"file,omitempty"`
+}
+
+type PackageLicense struct {
+	Package string `json:"package"`
+	Repo    string `json:"repo"`
+
+	// SPDX license expression; "" if none could be determined.
+	License string `json:"license,omitempty"`
+
+	// Where the license was determined from; one of the LICENSE_SOURCE_
+	// constants.
+	Source string `json:"source,omitempty"`
+
+	// License file covering the package, if any.
+	File string `json:"file,omitempty"`
+
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type LicenseReport struct {
+	Policy   *LicensePolicy   `json:"policy,omitempty"`
+	Repos    []RepoLicense    `json:"repos"`
+	Packages []PackageLicense `json:"packages"`
+	Problems int              `json:"problems"`
+}
+
+// Reads a project's license policy.  Returns nil if the project does not
+// have one.
+func ReadLicensePolicy(projDir string) (*LicensePolicy, error) {
+	path := filepath.Join(projDir, LICENSE_POLICY_FILENAME)
+	if util.NodeNotExist(path) {
+		return nil, nil
+	}
+
+	v, err := util.ReadConfig(projDir,
+		strings.TrimSuffix(LICENSE_POLICY_FILENAME, ".yml"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &LicensePolicy{
+		Path:        path,
+		Allowed:     v.GetStringSlice("license.allowed"),
+		Denied:      v.GetStringSlice("license.denied"),
+		RequireFile: v.GetBool("license.require_file"),
+		Exceptions:  v.GetStringMapString("license.exceptions"),
+	}, nil
+}
+
+var licenseFileRe = regexp.MustCompile(`(?i)^(LICEN[CS]E|COPYING)(\..*)?$`)
+
+// Finds the license file in a directory; "" if there is none.
+func findLicenseFile(dir string) string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	for _, info := range infos {
+		if !info.IsDir() && licenseFileRe.MatchString(info.Name()) {
+			return filepath.Join(dir, info.Name())
+		}
+	}
+
+	return ""
+}
+
+// Recognizes common licenses by distinctive phrases in their text, most
+// specific first.
+var licenseTextIds = []struct {
+	re *regexp.Regexp
+	id string
+}{
+	{regexp.MustCompile(`(?i)apache license,?\s+version 2\.0`), "Apache-2.0"},
+	{regexp.MustCompile(`(?i)gnu lesser general public license\s+` +
+		`version 2\.1`), "LGPL-2.1-only"},
+	{regexp.MustCompile(`(?i)gnu lesser general public license\s+` +
+		`version 3`), "LGPL-3.0-only"},
+	{regexp.MustCompile(`(?i)gnu general public license\s+version 2`),
+		"GPL-2.0-only"},
+	{regexp.MustCompile(`(?i)gnu general public license\s+version 3`),
+		"GPL-3.0-only"},
+	{regexp.MustCompile(`(?i)mozilla public license,?\s+v(ersion)?\.?\s*` +
+		`2\.0`), "MPL-2.0"},
+	{regexp.MustCompile(`(?i)neither the name of`), "BSD-3-Clause"},
+	{regexp.MustCompile(`(?i)redistributions in binary form must ` +
+		`reproduce`), "BSD-2-Clause"},
+	{regexp.MustCompile(`(?i)permission is hereby granted, free of ` +
+		`charge`), "MIT"},
+}
+
+// Identifies the license in a license file; "" if it is not recognized.
+func identifyLicenseFile(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	for _, l := range licenseTextIds {
+		if l.re.Match(data) {
+			return l.id
+		}
+	}
+
+	return ""
+}
+
+func containsLicense(ids []string, id string) bool {
+	for _, i := range ids {
+		if strings.EqualFold(i, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluates an SPDX license expression against the policy.  An "OR"
+// expression is acceptable if any alternative is; an "AND" expression only
+// if all of its terms are.  Parentheses are ignored.
+func (p *LicensePolicy) check(expr string) (string, string) {
+	if p == nil {
+		return LICENSE_STATUS_OK, ""
+	}
+
+	expr = strings.NewReplacer("(", " ", ")", " ").Replace(expr)
+
+	var lastStatus, lastReason string
+	for _, alt := range strings.Split(expr, " OR ") {
+		lastStatus, lastReason = LICENSE_STATUS_OK, ""
+		for _, term := range strings.Split(alt, " AND ") {
+			term = strings.TrimSpace(term)
+			if containsLicense(p.Denied, term) {
+				lastStatus = LICENSE_STATUS_DENIED
+				lastReason = term + " is denied by the license policy"
+				break
+			}
+			if len(p.Allowed) > 0 && !containsLicense(p.Allowed, term) {
+				lastStatus = LICENSE_STATUS_UNAPPROVED
+				lastReason = term + " is not in the allowed list"
+				break
+			}
+		}
+
+		if lastStatus == LICENSE_STATUS_OK {
+			break
+		}
+	}
+
+	return lastStatus, lastReason
+}
+
+func relPath(base string, path string) string {
+	if rel, err := filepath.Rel(base, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+type repoLicenseSorter struct {
+	lics []RepoLicense
+}
+
+func (s repoLicenseSorter) Len() int {
+	return len(s.lics)
+}
+func (s repoLicenseSorter) Swap(i, j int) {
+	s.lics[i], s.lics[j] = s.lics[j], s.lics[i]
+}
+func (s repoLicenseSorter) Less(i, j int) bool {
+	return s.lics[i].Repo < s.lics[j].Repo
+}
+
+type pkgLicenseSorter struct {
+	lics []PackageLicense
+}
+
+func (s pkgLicenseSorter) Len() int {
+	return len(s.lics)
+}
+func (s pkgLicenseSorter) Swap(i, j int) {
+	s.lics[i], s.lics[j] = s.lics[j], s.lics[i]
+}
+func (s pkgLicenseSorter) Less(i, j int) bool {
+	return s.lics[i].Package < s.lics[j].Package
+}
+
+// Determines the license of each of the specified packages and checks it
+// against the policy.  A package's license is taken from pkg.license in its
+// pkg.yml, else from a license file in the package directory, else from its
+// repo's license file.  policy may be nil.
+func AuditLicenses(lpkgs []*pkg.LocalPackage, policy *LicensePolicy,
+	projDir string) *LicenseReport {
+
+	report := &LicenseReport{
+		Policy:   policy,
+		Repos:    []RepoLicense{},
+		Packages: []PackageLicense{},
+	}
+
+	repos := map[string]RepoLicense{}
+	for _, lpkg := range lpkgs {
+		r := lpkg.Repo()
+		rl, ok := repos[r.Name()]
+		if !ok {
+			rl = RepoLicense{Repo: r.Name()}
+			if file := findLicenseFile(r.Path()); file != "" {
+				rl.File = relPath(projDir, file)
+				rl.License = identifyLicenseFile(file)
+			}
+			repos[r.Name()] = rl
+		}
+
+		pl := PackageLicense{
+			Package: lpkg.FullName(),
+			Repo:    r.Name(),
+		}
+
+		file := findLicenseFile(lpkg.BasePath())
+		if file != "" {
+			pl.File = relPath(projDir, file)
+		}
+
+		if lic := strings.TrimSpace(
+			lpkg.PkgV.GetString("pkg.license")); lic != "" {
+
+			pl.License = lic
+			pl.Source = LICENSE_SOURCE_PKG
+			if pl.File == "" {
+				pl.File = rl.File
+			}
+		} else if file != "" {
+			pl.License = identifyLicenseFile(file)
+			pl.Source = LICENSE_SOURCE_PKG_FILE
+		} else if rl.File != "" {
+			pl.License = rl.License
+			pl.File = rl.File
+			pl.Source = LICENSE_SOURCE_REPO
+		}
+
+		switch {
+		case policy != nil && policy.Exceptions[pl.Package] != "":
+			pl.Status = LICENSE_STATUS_EXEMPT
+			pl.Reason = policy.Exceptions[pl.Package]
+
+		case pl.License == "" && pl.File != "":
+			pl.Status = LICENSE_STATUS_MISSING
+			pl.Reason = "unrecognized license in " + pl.File
+
+		case pl.License == "":
+			pl.Status = LICENSE_STATUS_MISSING
+			pl.Reason = "no pkg.license and no license file"
+
+		default:
+			pl.Status, pl.Reason = policy.check(pl.License)
+			if pl.Status == LICENSE_STATUS_OK && policy != nil &&
+				policy.RequireFile && pl.File == "" {
+
+				pl.Status = LICENSE_STATUS_NO_FILE
+				pl.Reason = "no license file"
+			}
+		}
+
+		if pl.Status != LICENSE_STATUS_OK &&
+			pl.Status != LICENSE_STATUS_EXEMPT {
+
+			report.Problems++
+		}
+		report.Packages = append(report.Packages, pl)
+	}
+
+	sort.Sort(pkgLicenseSorter{report.Packages})
+
+	for _, rl := range repos {
+		report.Repos = append(report.Repos, rl)
+	}
+	sort.Sort(repoLicenseSorter{report.Repos})
+
+	return report
+}
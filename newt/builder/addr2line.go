@@ -0,0 +1,265 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"debug/elf"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+// An address to symbolicate, optionally labeled with the register or field
+// it was read from (e.g., "pc").
+type CrashAddr struct {
+	Label string `json:"label,omitempty"`
+	Addr  uint64 `json:"address"`
+}
+
+type AddrFrame struct {
+	Func string `json:"function"`
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+}
+
+type AddrLocation struct {
+	CrashAddr
+
+	// Build whose executable contains the address (app or loader); empty if
+	// it is not in any executable section.
+	Image string `json:"image,omitempty"`
+
+	// Innermost frame first; later frames are the functions it was inlined
+	// into.
+	Frames []AddrFrame `json:"frames,omitempty"`
+}
+
+var faultLabeledRe = regexp.MustCompile(
+	`([A-Za-z][A-Za-z0-9_]*)\s*[:=]\s*(0x[0-9a-fA-F]+)`)
+var faultAddrRe = regexp.MustCompile(`\b0x[0-9a-fA-F]+\b|\b[0-9a-fA-F]{8}\b`)
+
+// Extracts addresses from text, such as a fault dump pasted from a console.
+// Values labeled "name:0x..." or "name=0x..." keep their label.
+func ParseCrashAddrs(text string) []CrashAddr {
+	addrs := []CrashAddr{}
+
+	for _, line := range strings.Split(text, "\n") {
+		labeled := faultLabeledRe.FindAllStringSubmatchIndex(line, -1)
+		if len(labeled) > 0 {
+			for _, m := range labeled {
+				val, err := strconv.ParseUint(line[m[4]+2:m[5]], 16, 64)
+				if err == nil {
+					addrs = append(addrs, CrashAddr{
+						Label: strings.ToLower(line[m[2]:m[3]]),
+						Addr:  val,
+					})
+				}
+			}
+			continue
+		}
+
+		for _, tok := range faultAddrRe.FindAllString(line, -1) {
+			val, err := strconv.ParseUint(strings.TrimPrefix(tok, "0x"),
+				16, 64)
+			if err == nil {
+				addrs = append(addrs, CrashAddr{Addr: val})
+			}
+		}
+	}
+
+	return addrs
+}
+
+type textRange struct {
+	start uint64
+	end   uint64
+}
+
+// An executable to look addresses up in.
+type addrImage struct {
+	name   string
+	b      *Builder
+	elf    string
+	thumb  bool
+	ranges []textRange
+}
+
+func newAddrImage(name string, b *Builder) (*addrImage, error) {
+	path := b.AppElfPath()
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, util.FmtNewtError("Failed to read %s: %s; has the "+
+			"target been built?", path, err.Error())
+	}
+	defer f.Close()
+
+	img := &addrImage{
+		name:  name,
+		b:     b,
+		elf:   path,
+		thumb: f.Machine == elf.EM_ARM,
+	}
+	for _, sec := range f.Sections {
+		if sec.Flags&elf.SHF_EXECINSTR != 0 && sec.Size > 0 {
+			img.ranges = append(img.ranges,
+				textRange{sec.Addr, sec.Addr + sec.Size})
+		}
+	}
+
+	return img, nil
+}
+
+func (img *addrImage) contains(addr uint64) bool {
+	for _, r := range img.ranges {
+		if addr >= r.start && addr < r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses "addr2line -a -f -i" output: each address line is followed by
+// function / file:line pairs, innermost first.
+func parseAddr2Line(out string) [][]AddrFrame {
+	results := [][]AddrFrame{}
+
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		if strings.HasPrefix(lines[i], "0x") {
+			results = append(results, []AddrFrame{})
+			continue
+		}
+		if len(results) == 0 || i+1 >= len(lines) {
+			break
+		}
+
+		frame := AddrFrame{Func: lines[i]}
+		loc := lines[i+1]
+		i++
+
+		// Discriminators follow the line number (e.g., "x.c:12 (discr 1)").
+		if sp := strings.Index(loc, " "); sp >= 0 {
+			loc = loc[:sp]
+		}
+		if c := strings.LastIndex(loc, ":"); c >= 0 {
+			frame.File = loc[:c]
+			frame.Line, _ = strconv.Atoi(loc[c+1:])
+		}
+		if frame.File == "??" {
+			frame.File = ""
+		}
+
+		cur := len(results) - 1
+		results[cur] = append(results[cur], frame)
+	}
+
+	return results
+}
+
+// Maps addresses to functions and source lines using the target's
+// executables.  In a split image setup, each address is looked up in the
+// executable (app or loader) whose code contains it.  Labeled addresses that
+// are not in any executable's code (e.g., register values holding data) are
+// omitted; unlabeled addresses are always reported.
+func (t *TargetBuilder) Addr2Line(addrs []CrashAddr) (
+	[]*AddrLocation, error) {
+
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	images := []*addrImage{}
+	app, err := newAddrImage(BUILD_NAME_APP, t.AppBuilder)
+	if err != nil {
+		return nil, err
+	}
+	images = append(images, app)
+	if t.LoaderBuilder != nil {
+		loader, err := newAddrImage(BUILD_NAME_LOADER, t.LoaderBuilder)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, loader)
+	}
+
+	locs := []*AddrLocation{}
+	byImage := map[*addrImage][]*AddrLocation{}
+	for _, a := range addrs {
+		loc := &AddrLocation{CrashAddr: a}
+
+		var img *addrImage
+		for _, i := range images {
+			if i.contains(a.Addr &^ 1) {
+				img = i
+				break
+			}
+		}
+		if img == nil && a.Label != "" {
+			continue
+		}
+
+		locs = append(locs, loc)
+		if img != nil {
+			loc.Image = img.name
+			byImage[img] = append(byImage[img], loc)
+		}
+	}
+
+	for _, img := range images {
+		imgLocs := byImage[img]
+		if len(imgLocs) == 0 {
+			continue
+		}
+
+		hexAddrs := make([]string, len(imgLocs))
+		for i, loc := range imgLocs {
+			addr := loc.Addr
+			if img.thumb {
+				addr &^= 1
+			}
+			hexAddrs[i] = fmt.Sprintf("0x%x", addr)
+		}
+
+		c, err := t.NewCompiler(img.b.BinDir())
+		if err != nil {
+			return nil, err
+		}
+		cmd, env := newtutil.ToolchainCmd(
+			c.Addr2LineCmd(img.elf, hexAddrs), t.target.Env())
+		out, err := util.ShellCommand(cmd, env)
+		if err != nil {
+			return nil, err
+		}
+
+		frames := parseAddr2Line(string(out))
+		if len(frames) != len(imgLocs) {
+			return nil, util.FmtNewtError("Unexpected addr2line output "+
+				"for %s", img.elf)
+		}
+		for i, loc := range imgLocs {
+			loc.Frames = frames[i]
+		}
+	}
+
+	return locs, nil
+}
@@ -0,0 +1,459 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+const ANALYZER_CLANG_TIDY = "clang-tidy"
+const ANALYZER_CPPCHECK = "cppcheck"
+
+// The command that compiles a single source file of a target.
+type CompileCommand struct {
+	Package string
+	PkgDir  string
+	File    string
+	Args    []string
+
+	// Whether the file belongs to a package in the project's local repo.
+	Local bool
+}
+
+func (b *Builder) compileCommands() ([]CompileCommand, error) {
+	cmds := []CompileCommand{}
+
+	for _, bpkg := range b.sortedBuildPackages() {
+		entries, err := b.collectCompileEntriesBpkg(bpkg)
+		if err != nil {
+			return nil, err
+		}
+
+		lpkg := bpkg.rpkg.Lpkg
+		for _, entry := range entries {
+			if entry.CompilerType != toolchain.COMPILER_TYPE_C &&
+				entry.CompilerType != toolchain.COMPILER_TYPE_CPP {
+
+				continue
+			}
+
+			args, err := entry.Compiler.CompileFileCmd(entry.Filename,
+				entry.CompilerType)
+			if err != nil {
+				return nil, err
+			}
+
+			cmds = append(cmds, CompileCommand{
+				Package: lpkg.FullName(),
+				PkgDir:  lpkg.BasePath(),
+				File:    args[len(args)-1],
+				Args:    args,
+				Local:   lpkg.Repo().IsLocal(),
+			})
+		}
+	}
+
+	return cmds, nil
+}
+
+// Returns the compile command of every C and C++ source file in the target's
+// app and loader images.  The commands are meant to be run from the project
+// base directory.
+func (t *TargetBuilder) CompileCommands() ([]CompileCommand, error) {
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	cmds := []CompileCommand{}
+	for _, b := range []*Builder{t.LoaderBuilder, t.AppBuilder} {
+		if b == nil {
+			continue
+		}
+
+		bcmds, err := b.compileCommands()
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, bcmds...)
+	}
+
+	return cmds, nil
+}
+
+// A problem reported by a static analyzer.
+type Finding struct {
+	toolchain.Diagnostic
+	Rule string `json:"rule,omitempty"`
+
+	// The package suppression entry matching the finding, if any.
+	Suppression *Suppression `json:"suppression,omitempty"`
+}
+
+type AnalyzeResult struct {
+	Findings []Finding
+
+	// Suppression entries of the analyzed packages that matched no
+	// findings.
+	Unused []*Suppression
+}
+
+type AnalyzeOptions struct {
+	// ANALYZER_CLANG_TIDY, ANALYZER_CPPCHECK, or the name of a checker
+	// declared in project.yml.
+	Tool string
+
+	// Command template of a project checker; see checkerCmd().
+	Cmd string
+
+	// Analyzer executable; defaults to the tool name.
+	Path string
+
+	// Additional arguments passed to the analyzer.
+	Args []string
+
+	// Analyze files of packages from all repos, and report findings located
+	// anywhere; by default, only the project's own packages are considered.
+	AllPkgs bool
+}
+
+// Strips the compiler, output and input arguments from a compile command,
+// leaving only the flags.
+func compileFlags(cc CompileCommand) []string {
+	flags := []string{}
+	args := cc.Args[1 : len(cc.Args)-1]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-c":
+		case "-o":
+			i++
+		default:
+			flags = append(flags, args[i])
+		}
+	}
+
+	return flags
+}
+
+// Converts compiler flags to the cppcheck options with the same effect.
+// Flags cppcheck has no equivalent for are dropped.
+func cppcheckFlags(flags []string) []string {
+	result := []string{}
+	for i := 0; i < len(flags); i++ {
+		f := flags[i]
+		switch {
+		case f == "-include" && i+1 < len(flags):
+			i++
+			result = append(result, "--include="+flags[i])
+		case (f == "-D" || f == "-U" || f == "-I") && i+1 < len(flags):
+			i++
+			result = append(result, f+flags[i])
+		case strings.HasPrefix(f, "-D"), strings.HasPrefix(f, "-U"),
+			strings.HasPrefix(f, "-I"):
+
+			result = append(result, f)
+		}
+	}
+
+	return result
+}
+
+// Returns the include directories (-I) of a set of compiler flags.
+func compileIncludes(flags []string) []string {
+	return compileFlagValues(flags, "-I")
+}
+
+// Returns the macro definitions (-D) of a set of compiler flags.
+func compileDefines(flags []string) []string {
+	return compileFlagValues(flags, "-D")
+}
+
+func compileFlagValues(flags []string, opt string) []string {
+	vals := []string{}
+	for i := 0; i < len(flags); i++ {
+		switch {
+		case flags[i] == opt && i+1 < len(flags):
+			i++
+			vals = append(vals, flags[i])
+		case strings.HasPrefix(flags[i], opt) && len(flags[i]) > len(opt):
+			vals = append(vals, flags[i][len(opt):])
+		}
+	}
+
+	return vals
+}
+
+// Builds the command line of a project checker from its template.  The
+// template is split on whitespace, and {file} and {pkg} in each word are
+// replaced with the source file and its package name.  A word containing
+// {includes}, {defines} or {flags} is repeated once per include directory,
+// macro definition (without the -D) or compiler flag respectively, so
+// "-I{includes}" yields one -I option per include directory.  Any extra
+// arguments are appended.
+func checkerCmd(opts AnalyzeOptions, cc CompileCommand) []string {
+	flags := compileFlags(cc)
+	lists := map[string][]string{
+		"{includes}": compileIncludes(flags),
+		"{defines}":  compileDefines(flags),
+		"{flags}":    flags,
+	}
+
+	cmd := []string{}
+	for _, word := range strings.Fields(opts.Cmd) {
+		word = strings.Replace(word, "{file}", cc.File, -1)
+		word = strings.Replace(word, "{pkg}", cc.Package, -1)
+
+		expanded := false
+		for ph, vals := range lists {
+			if strings.Contains(word, ph) {
+				for _, v := range vals {
+					cmd = append(cmd, strings.Replace(word, ph, v, -1))
+				}
+				expanded = true
+				break
+			}
+		}
+		if !expanded {
+			cmd = append(cmd, word)
+		}
+	}
+
+	if opts.Path != "" && len(cmd) > 0 {
+		cmd[0] = opts.Path
+	}
+	cmd = append(cmd, opts.Args...)
+
+	return cmd
+}
+
+func analyzerCmd(opts AnalyzeOptions, cc CompileCommand) []string {
+	if opts.Cmd != "" {
+		return checkerCmd(opts, cc)
+	}
+
+	path := opts.Path
+	if path == "" {
+		path = opts.Tool
+	}
+
+	switch opts.Tool {
+	case ANALYZER_CPPCHECK:
+		cmd := []string{path, "--quiet",
+			"--enable=warning,style,performance,portability",
+			"--template={file}:{line}:{column}: {severity}: {message} [{id}]"}
+		cmd = append(cmd, cppcheckFlags(compileFlags(cc))...)
+		cmd = append(cmd, opts.Args...)
+		return append(cmd, cc.File)
+
+	default:
+		cmd := []string{path, "--quiet"}
+		cmd = append(cmd, opts.Args...)
+		cmd = append(cmd, cc.File, "--")
+		return append(cmd, compileFlags(cc)...)
+	}
+}
+
+var findingRe = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)?\s*` +
+	`(fatal error|error|warning|note|style|performance|portability|` +
+	`information):\s*(.*?)(?:\s+\[([\w.,:-]+)\])?$`)
+
+func parseFindings(output string) []Finding {
+	findings := []Finding{}
+
+	for _, line := range strings.Split(output, "\n") {
+		m := findingRe.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil {
+			continue
+		}
+
+		f := Finding{Rule: m[6]}
+		f.File = filepath.Clean(m[1])
+		f.Line, _ = strconv.Atoi(m[2])
+		f.Column, _ = strconv.Atoi(m[3])
+		f.Message = m[5]
+
+		switch m[4] {
+		case "fatal error", "error":
+			f.Severity = toolchain.DIAG_SEVERITY_ERROR
+		case "note", "information":
+			f.Severity = toolchain.DIAG_SEVERITY_NOTE
+		default:
+			f.Severity = toolchain.DIAG_SEVERITY_WARNING
+		}
+
+		findings = append(findings, f)
+	}
+
+	return findings
+}
+
+func pathWithin(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && !strings.HasPrefix(rel, "..")
+}
+
+// Indicates whether a file, relative to the project base directory, belongs
+// to the project itself rather than to an installed repo or to the generated
+// code in the bin directory.
+func isProjectFile(path string) bool {
+	projPath := project.GetProject().Path()
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(projPath, path)
+	}
+
+	return pathWithin(projPath, path) &&
+		!pathWithin(repo.ReposDir(), path) &&
+		!pathWithin(BinRoot(), path)
+}
+
+// Returns the package owning the specified file: the one with the deepest
+// base directory containing it.
+func fileOwner(cmds []CompileCommand, file string) string {
+	owner := ""
+	ownerDir := ""
+	for _, cc := range cmds {
+		if len(cc.PkgDir) > len(ownerDir) && pathWithin(cc.PkgDir, file) {
+			owner = cc.Package
+			ownerDir = cc.PkgDir
+		}
+	}
+
+	return owner
+}
+
+type findingSorter struct {
+	findings []Finding
+}
+
+func (s findingSorter) Len() int {
+	return len(s.findings)
+}
+func (s findingSorter) Swap(i, j int) {
+	s.findings[i], s.findings[j] = s.findings[j], s.findings[i]
+}
+func (s findingSorter) Less(i, j int) bool {
+	a, b := s.findings[i], s.findings[j]
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	return a.Column < b.Column
+}
+
+// Runs the analyzer on each compile command in parallel and returns the
+// unique findings, sorted by location.  Each finding is attributed to the
+// package containing the offending file and checked against that package's
+// suppression file.
+func Analyze(cmds []CompileCommand,
+	opts AnalyzeOptions) (*AnalyzeResult, error) {
+
+	if opts.Cmd == "" &&
+		opts.Tool != ANALYZER_CLANG_TIDY && opts.Tool != ANALYZER_CPPCHECK {
+
+		return nil, util.FmtNewtError("Unknown analyzer \"%s\"; must be "+
+			"%s, %s or a checker from project.checkers", opts.Tool,
+			ANALYZER_CLANG_TIDY, ANALYZER_CPPCHECK)
+	}
+
+	if !opts.AllPkgs {
+		local := []CompileCommand{}
+		for _, cc := range cmds {
+			if cc.Local && isProjectFile(cc.File) {
+				local = append(local, cc)
+			}
+		}
+		cmds = local
+	}
+
+	projPath := project.GetProject().Path()
+
+	var mtx sync.Mutex
+	seen := map[Finding]bool{}
+	findings := []Finding{}
+
+	err := runJobs(len(cmds), func(idx int) error {
+		cc := cmds[idx]
+		cmdStrs := analyzerCmd(opts, cc)
+
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "Analyzing %s\n", cc.File)
+		log.Debugf("%s", strings.Join(cmdStrs, " "))
+
+		cmd := exec.Command(cmdStrs[0], cmdStrs[1:]...)
+		cmd.Dir = projPath
+		out, err := cmd.CombinedOutput()
+		if _, ok := err.(*exec.ExitError); err != nil && !ok {
+			return util.FmtNewtError("Failed to run %s: %s", cmdStrs[0],
+				err.Error())
+		}
+
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		for _, f := range parseFindings(string(out)) {
+			if !opts.AllPkgs && !isProjectFile(f.File) {
+				continue
+			}
+			if seen[f] {
+				continue
+			}
+			seen[f] = true
+
+			path := f.File
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(projPath, path)
+			}
+			f.Package = fileOwner(cmds, path)
+			if f.Package == "" {
+				f.Package = cc.Package
+			}
+			findings = append(findings, f)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(findingSorter{findings})
+
+	sups, err := readSuppressions(cmds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AnalyzeResult{
+		Findings: findings,
+		Unused:   applySuppressions(findings, sups),
+	}, nil
+}
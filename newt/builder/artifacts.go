@@ -0,0 +1,177 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
+)
+
+// Directory, inside the target's bin directory, that named copies of the
+// target's images are written to.
+const ARTIFACTS_DIR = "artifacts"
+
+var artifactVarRe = regexp.MustCompile(`\{([a-z_]*)\}`)
+
+// Extensions of the artifacts a name template applies to.  A template ending
+// in one of them has it replaced with the artifact's.
+var artifactExts = []string{".img", ".hex", ".zip"}
+
+func ArtifactsDir(targetName string) string {
+	return filepath.Join(TargetBinDir(targetName), ARTIFACTS_DIR)
+}
+
+// Returns the template that the target's artifacts are named with:
+// target.artifact_name, or project.yml's project.artifact_name.  Returns ""
+// if neither is set.
+func (t *TargetBuilder) ArtifactNameTemplate() string {
+	if tmpl := t.target.ArtifactNameTemplate(); tmpl != "" {
+		return tmpl
+	}
+	return project.GetProject().ArtifactNameTemplate()
+}
+
+// Returns the abbreviated commit of the project's working copy, or
+// "nogit" if it is not a git repository.
+func projectGitSha() string {
+	out, err := util.ShellCommand([]string{"git", "-C",
+		project.GetProject().Path(), "rev-parse", "--short", "HEAD"}, nil)
+	if err != nil {
+		return "nogit"
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Expands an artifact name template for an artifact of the specified
+// builder.  Supported placeholders are {app}, {target}, {bsp}, {version},
+// {gitsha}, {build_profile}, {date} and {ext}.  The extension is appended if
+// the template does not place it.
+func (b *Builder) expandArtifactName(tmpl string, version string,
+	ext string) (string, error) {
+
+	for _, e := range artifactExts {
+		if strings.HasSuffix(tmpl, e) {
+			tmpl = strings.TrimSuffix(tmpl, e)
+			break
+		}
+	}
+	if !strings.Contains(tmpl, "{ext}") {
+		tmpl += "{ext}"
+	}
+
+	t := b.targetBuilder.target
+	vals := map[string]string{
+		"app":           filepath.Base(b.appPkg.rpkg.Lpkg.Name()),
+		"target":        filepath.Base(t.Name()),
+		"bsp":           filepath.Base(t.BspName),
+		"version":       version,
+		"build_profile": t.BuildProfile,
+		"date":          time.Now().Format("20060102"),
+		"ext":           ext,
+	}
+
+	var err error
+	name := artifactVarRe.ReplaceAllStringFunc(tmpl, func(s string) string {
+		key := strings.Trim(s, "{}")
+		if key == "gitsha" {
+			if _, ok := vals[key]; !ok {
+				vals[key] = projectGitSha()
+			}
+		}
+
+		val, ok := vals[key]
+		if !ok && err == nil {
+			err = util.FmtNewtError(
+				"Unknown placeholder %s in artifact name template \"%s\"",
+				s, tmpl)
+		}
+		return val
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if strings.ContainsAny(name, "/\\") {
+		return "", util.FmtNewtError(
+			"Artifact name \"%s\" contains a path separator", name)
+	}
+
+	return name, nil
+}
+
+// Returns the name of the target's artifact with the specified version and
+// extension, or "" if no name template is configured.
+func (t *TargetBuilder) ArtifactName(version string,
+	ext string) (string, error) {
+
+	tmpl := t.ArtifactNameTemplate()
+	if tmpl == "" {
+		return "", nil
+	}
+
+	return t.AppBuilder.expandArtifactName(tmpl, version, ext)
+}
+
+// Copies the images and hex files just created to the target's artifacts
+// directory under the names the template gives them.  Does nothing if no
+// template is configured.
+func (t *TargetBuilder) nameArtifacts(version string) error {
+	tmpl := t.ArtifactNameTemplate()
+	if tmpl == "" {
+		return nil
+	}
+
+	dir := ArtifactsDir(t.target.Name())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	for _, b := range t.builders() {
+		srcs := map[string]string{
+			".img": b.AppImgPath(),
+			".hex": b.AppHexPath(),
+		}
+		for _, ext := range []string{".img", ".hex"} {
+			src := srcs[ext]
+			if util.NodeNotExist(src) {
+				continue
+			}
+
+			name, err := b.expandArtifactName(tmpl, version, ext)
+			if err != nil {
+				return err
+			}
+			dst := filepath.Join(dir, name)
+			if err := util.CopyFile(src, dst); err != nil {
+				return err
+			}
+
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "Artifact: %s\n", dst)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,354 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/flash"
+	"mynewt.apache.org/newt/util"
+)
+
+const BUDGET_SNAPSHOT_FILENAME = "budget.json"
+
+// Totals of the sections reported by the "size" utility.
+type elfSectionSizes struct {
+	Text int
+	Data int
+	Bss  int
+}
+
+// Per-package sizes recorded after the most recent build that satisfied its
+// budgets.
+type budgetSnapshot struct {
+	Pkgs map[string]int `json:"pkgs"`
+}
+
+func budgetErr(name string, format string, args ...interface{}) error {
+	return util.FmtNewtError("invalid budget \"%s\": %s", name,
+		fmt.Sprintf(format, args...))
+}
+
+func parseBudgetSize(name string, val string) (int, error) {
+	lower := strings.ToLower(strings.TrimSpace(val))
+
+	multiplier := 1
+	if strings.HasSuffix(lower, "kb") {
+		multiplier = 1024
+		lower = strings.TrimSuffix(lower, "kb")
+	}
+
+	num, err := util.AtoiNoOct(strings.TrimSpace(lower))
+	if err != nil {
+		return 0, budgetErr(name, "invalid size: %s", val)
+	}
+
+	return num * multiplier, nil
+}
+
+func parseBudgetPercent(name string, val string) (int, error) {
+	trimmed := strings.TrimSpace(val)
+	if !strings.HasSuffix(trimmed, "%") {
+		return 0, budgetErr(name, "value must be a percentage: %s", val)
+	}
+
+	num, err := util.AtoiNoOct(strings.TrimSuffix(trimmed, "%"))
+	if err != nil || num < 0 || num > 100 {
+		return 0, budgetErr(name, "invalid percentage: %s", val)
+	}
+
+	return num, nil
+}
+
+// Parses the berkeley-format output of the "size" utility:
+//
+//	 text    data     bss     dec     hex filename
+//	12345     123    4567   17035    428b app.elf
+func parseElfSectionSizes(output string) (elfSectionSizes, error) {
+	sizes := elfSectionSizes{}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) < 2 {
+		return sizes, util.FmtNewtError(
+			"unexpected output from size utility: %s", output)
+	}
+
+	fields := strings.Fields(lines[1])
+	if len(fields) < 3 {
+		return sizes, util.FmtNewtError(
+			"unexpected output from size utility: %s", output)
+	}
+
+	vals := make([]int, 3)
+	for i := 0; i < 3; i++ {
+		var err error
+		vals[i], err = util.AtoiNoOct(fields[i])
+		if err != nil {
+			return sizes, util.FmtNewtError(
+				"unexpected output from size utility: %s", output)
+		}
+	}
+
+	sizes.Text = vals[0]
+	sizes.Data = vals[1]
+	sizes.Bss = vals[2]
+
+	return sizes, nil
+}
+
+func (b *Builder) budgetSnapshotPath() string {
+	return filepath.Join(b.PkgBinDir(b.appPkg), BUDGET_SNAPSHOT_FILENAME)
+}
+
+// Calculates the total size of each package from the linker map file.  An
+// empty map is returned if the map file is unavailable.
+func (b *Builder) pkgTotalSizes() map[string]int {
+	totals := map[string]int{}
+
+	libs, err := ParseMapFileSizes(b.AppElfPath() + ".map")
+	if err != nil {
+		log.Debugf("Failed to read package sizes: %s", err.Error())
+		return totals
+	}
+
+	for _, ps := range libs {
+		name := b.FindPkgNameByArName(ps.Name)
+		for _, sz := range ps.Sizes {
+			totals[name] += int(sz)
+		}
+	}
+
+	return totals
+}
+
+func (b *Builder) readBudgetSnapshot() *budgetSnapshot {
+	data, err := ioutil.ReadFile(b.budgetSnapshotPath())
+	if err != nil {
+		return nil
+	}
+
+	snapshot := &budgetSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		log.Debugf("Ignoring corrupt budget snapshot (%s): %s",
+			b.budgetSnapshotPath(), err.Error())
+		return nil
+	}
+
+	return snapshot
+}
+
+func (b *Builder) writeBudgetSnapshot(pkgSizes map[string]int) error {
+	data, err := json.MarshalIndent(budgetSnapshot{Pkgs: pkgSizes}, "", "  ")
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	if err := ioutil.WriteFile(b.budgetSnapshotPath(), data,
+		0644); err != nil {
+
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Produces a description of each package that grew since the last build that
+// met its budgets.
+func growthText(prev *budgetSnapshot, cur map[string]int) string {
+	if prev == nil {
+		return ""
+	}
+
+	names := make([]string, 0, len(cur))
+	for name, sz := range cur {
+		if sz > prev.Pkgs[name] {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+
+	str := "Packages that grew since the last successful build:\n"
+	for _, name := range names {
+		str += fmt.Sprintf("    %+7d %s\n", cur[name]-prev.Pkgs[name],
+			filepath.Base(name))
+	}
+
+	return str
+}
+
+// Determines how much of an image slot the build's flash footprint may
+// occupy.
+func (b *Builder) slotSize() int {
+	areaName := flash.FLASH_AREA_NAME_IMAGE_0
+	if b.buildName == BUILD_NAME_APP && b.targetBuilder.LoaderBuilder != nil {
+		areaName = flash.FLASH_AREA_NAME_IMAGE_1
+	}
+
+	return b.targetBuilder.bspPkg.FlashMap.Areas[areaName].Size
+}
+
+// Checks the linked elf file against each of the target's memory budgets.
+//
+// @return                      [budget violation descriptions], error
+func (b *Builder) budgetViolations(
+	budgets map[string]string) ([]string, error) {
+
+	c, err := b.newCompiler(b.appPkg, b.FileBinDir(b.AppElfPath()))
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := c.PrintSize(b.AppElfPath())
+	if err != nil {
+		return nil, err
+	}
+
+	sizes, err := parseElfSectionSizes(output)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(budgets))
+	for name, _ := range budgets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	violations := []string{}
+	for _, name := range names {
+		val := budgets[name]
+
+		var used int
+		switch name {
+		case "text":
+			used = sizes.Text
+		case "data":
+			used = sizes.Data
+		case "bss":
+			used = sizes.Bss
+		case "flash":
+			used = sizes.Text + sizes.Data
+		case "ram":
+			used = sizes.Data + sizes.Bss
+
+		case "slot":
+			pct, err := parseBudgetPercent(name, val)
+			if err != nil {
+				return nil, err
+			}
+
+			slotSz := b.slotSize()
+			if slotSz == 0 {
+				log.Debugf("Ignoring slot budget; no image slot in flash map")
+				continue
+			}
+
+			used = sizes.Text + sizes.Data
+			if used*100 > slotSz*pct {
+				violations = append(violations, fmt.Sprintf(
+					"%s: slot usage exceeds %d%% (used=%d slot-size=%d)",
+					b.buildName, pct, used, slotSz))
+			}
+			continue
+
+		default:
+			return nil, budgetErr(name, "unknown budget; must be one of "+
+				"text, data, bss, flash, ram, slot")
+		}
+
+		limit, err := parseBudgetSize(name, val)
+		if err != nil {
+			return nil, err
+		}
+
+		if used > limit {
+			violations = append(violations, fmt.Sprintf(
+				"%s: %s exceeds budget by %d bytes (used=%d max=%d)",
+				b.buildName, name, used-limit, used, limit))
+		}
+	}
+
+	return violations, nil
+}
+
+// Verifies that the linked image satisfies the target's memory budgets.  If
+// the check passes, the package sizes are remembered so that future
+// violations can report which packages grew.
+func (b *Builder) checkBudgets(budgets map[string]string,
+	strict bool) error {
+
+	if len(budgets) == 0 || b.appPkg == nil {
+		return nil
+	}
+
+	violations, err := b.budgetViolations(budgets)
+	if err != nil {
+		return err
+	}
+
+	pkgSizes := b.pkgTotalSizes()
+
+	if len(violations) == 0 {
+		if len(pkgSizes) > 0 {
+			return b.writeBudgetSnapshot(pkgSizes)
+		}
+		return nil
+	}
+
+	growth := growthText(b.readBudgetSnapshot(), pkgSizes)
+
+	if strict {
+		return util.NewNewtError("Memory budget exceeded:\n    " +
+			strings.Join(violations, "\n    ") + "\n" + growth)
+	}
+
+	for _, v := range violations {
+		util.StatusMessage(util.VERBOSITY_QUIET,
+			"* Warning: memory budget exceeded; %s\n", v)
+	}
+	if growth != "" {
+		util.StatusMessage(util.VERBOSITY_QUIET, "%s", growth)
+	}
+
+	return nil
+}
+
+func (t *TargetBuilder) checkBudgets() error {
+	budgets := t.target.Budgets()
+
+	if t.LoaderBuilder != nil {
+		if err := t.LoaderBuilder.checkBudgets(budgets,
+			!t.BudgetWarnOnly); err != nil {
+
+			return err
+		}
+	}
+
+	return t.AppBuilder.checkBudgets(budgets, !t.BudgetWarnOnly)
+}
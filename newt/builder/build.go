@@ -20,9 +20,12 @@
 package builder
 
 import (
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -198,7 +201,7 @@ func collectCompileEntriesDir(srcDir string, c *toolchain.Compiler,
 	}
 	entries = append(entries, subEntries...)
 
-	archDir := srcDir + "/arch/" + arch + "/"
+	archDir := filepath.Join(srcDir, "arch", arch)
 	if util.NodeExist(archDir) {
 		util.StatusMessage(util.VERBOSITY_VERBOSE,
 			"Compiling architecture specific src pkgs in directory: %s\n",
@@ -259,6 +262,14 @@ func (b *Builder) newCompiler(bpkg *BuildPackage,
 			return nil, err
 		}
 		c.AddInfo(ci)
+
+		localCi, err := bpkg.LocalCompilerInfo(b)
+		if err != nil {
+			return nil, err
+		}
+		c.AddInfo(localCi)
+
+		c.SetPkgName(bpkg.rpkg.Lpkg.FullName())
 	}
 
 	return c, nil
@@ -272,11 +283,20 @@ func (b *Builder) collectCompileEntriesBpkg(bpkg *BuildPackage) (
 		return nil, err
 	}
 
+	if isPrebuilt(bpkg) {
+		return b.collectPrebuiltEntries(c, bpkg)
+	}
+
+	// An explicit list of source files replaces the usual directory scan.
+	if len(bpkg.SourceFiles) > 0 {
+		return c.CollectFileEntries(bpkg.SourceFiles)
+	}
+
 	srcDirs := []string{}
 
 	if len(bpkg.SourceDirectories) > 0 {
 		for _, relDir := range bpkg.SourceDirectories {
-			dir := bpkg.rpkg.Lpkg.BasePath() + "/" + relDir
+			dir := filepath.Join(bpkg.rpkg.Lpkg.BasePath(), relDir)
 			if util.NodeNotExist(dir) {
 				return nil, util.NewNewtError(fmt.Sprintf(
 					"Specified source directory %s, does not exist.",
@@ -285,13 +305,16 @@ func (b *Builder) collectCompileEntriesBpkg(bpkg *BuildPackage) (
 			srcDirs = append(srcDirs, dir)
 		}
 	} else {
-		srcDir := bpkg.rpkg.Lpkg.BasePath() + "/src"
-		if util.NodeNotExist(srcDir) {
-			// Nothing to compile.
-			return nil, nil
+		srcDir := filepath.Join(bpkg.rpkg.Lpkg.BasePath(), "src")
+		if util.NodeExist(srcDir) {
+			srcDirs = append(srcDirs, srcDir)
 		}
+	}
 
-		srcDirs = append(srcDirs, srcDir)
+	srcDirs = append(srcDirs, bpkg.extraSrcDirs...)
+	if len(srcDirs) == 0 {
+		// Nothing to compile.
+		return nil, nil
 	}
 
 	entries := []toolchain.CompilerJob{}
@@ -346,30 +369,95 @@ func (b *Builder) ExtractSymbolInfo() (error, *symbol.SymbolMap) {
 	return nil, syms
 }
 
+// Creates the compiler that links the builder's executable.
+func (b *Builder) linkCompiler(dstDir string,
+	linkerScripts []string) (*toolchain.Compiler, error) {
+
+	c, err := b.newCompiler(b.appPkg, dstDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Public linker flags of every package in the build get applied to the
+	// final link.  Packages are processed in name order so that the link
+	// command is reproducible.
+	bpkgs := make([]*BuildPackage, 0, len(b.PkgMap))
+	for _, bpkg := range b.PkgMap {
+		bpkgs = append(bpkgs, bpkg)
+	}
+	sort.Sort(bpkgFullNameSorter{bpkgs})
+
+	lflagsCi := toolchain.NewCompilerInfo()
+	for _, bpkg := range bpkgs {
+		lflagsCi.Lflags = append(lflagsCi.Lflags,
+			bpkg.pkgFlags(b, "pkg.public_lflags")...)
+	}
+
+	ls, err := b.linkSymbols(bpkgs)
+	if err != nil {
+		return nil, err
+	}
+	lflagsCi.Lflags = append(lflagsCi.Lflags, ls.lflags()...)
+	c.AddInfo(lflagsCi)
+
+	c.LinkerScripts = linkerScripts
+	c.LinkerIncludes = b.targetBuilder.generatedLinkerScripts()
+
+	return c, nil
+}
+
 func (b *Builder) link(elfName string, linkerScripts []string,
 	keepSymbols []string) error {
 
-	c, err := b.newCompiler(b.appPkg, b.FileBinDir(elfName))
+	c, err := b.linkCompiler(b.FileBinDir(elfName), linkerScripts)
 	if err != nil {
 		return err
 	}
 
 	/* Always used the trimmed archive files. */
 	pkgNames := []string{}
+	archivePkgs := map[string]string{}
 
 	for _, bpkg := range b.PkgMap {
-		archiveNames, _ := filepath.Glob(b.PkgBinDir(bpkg) + "/*.a")
+		archiveNames, _ := filepath.Glob(
+			filepath.Join(b.PkgBinDir(bpkg), "*.a"))
 		for i, archiveName := range archiveNames {
 			archiveNames[i] = filepath.ToSlash(archiveName)
+			archivePkgs[archiveNames[i]] = bpkg.rpkg.Lpkg.FullName()
 		}
 		pkgNames = append(pkgNames, archiveNames...)
 	}
 
-	c.LinkerScripts = linkerScripts
-	err = c.CompileElf(elfName, pkgNames, keepSymbols, b.linkElf)
+	// Multiple definition errors from the linker only name object files;
+	// look for duplicate definitions first so they can be attributed to
+	// packages.
+	var collisions []SymbolCollision
+	linkRequired, err := c.ElfLinkRequired(elfName, pkgNames, keepSymbols,
+		b.linkElf)
 	if err != nil {
 		return err
 	}
+	if linkRequired {
+		collisions, err = b.findSymbolCollisions(c, archivePkgs)
+		if err != nil {
+			return err
+		}
+		recordSymbolCollisions(collisions)
+	}
+
+	err = c.CompileElf(elfName, pkgNames, keepSymbols, b.linkElf)
+	if err != nil {
+		if len(collisions) > 0 {
+			err = symbolCollisionError(elfName, collisions, err)
+		}
+		return util.ClassifyError(err, util.ERR_CLASS_LINK)
+	}
+
+	newtutil.EmitEvent(newtutil.EVENT_LINK, map[string]interface{}{
+		"target": b.targetPkg.rpkg.Lpkg.Name(),
+		"build":  b.buildName,
+		"elf":    elfName,
+	})
 
 	return nil
 }
@@ -402,7 +490,7 @@ func (b *Builder) PrepBuild() error {
 	if err != nil {
 		return err
 	}
-	baseCi.AddCompilerInfo(targetCi)
+	baseCi.AddCompilerInfo(b.globalCompilerInfo(targetCi))
 
 	// App flags.
 	if b.appPkg != nil {
@@ -413,7 +501,7 @@ func (b *Builder) PrepBuild() error {
 			return err
 		}
 
-		baseCi.AddCompilerInfo(appCi)
+		baseCi.AddCompilerInfo(b.globalCompilerInfo(appCi))
 	}
 
 	// Bsp flags.
@@ -451,6 +539,10 @@ func (b *Builder) PrepBuild() error {
 	// package being built are calculated.
 	b.compilerInfo = baseCi
 
+	if err := b.verifyPrebuilts(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -469,13 +561,14 @@ func (b *Builder) addSysinitBpkg() (*BuildPackage, error) {
 	return b.addPackage(rpkg)
 }
 
-// Runs build jobs while any remain.  On failure, signals the other workers to
-// stop via the stop channel.  On error, the error object is signaled via the
-// results channel.  On successful completion, nil is signaled via the results
-// channel.
+// Runs build jobs while any remain.  Each job is identified by its index into
+// the caller's job list.  On failure, signals the other workers to stop via the
+// stop channel.  On error, the error object is signaled via the results
+// channel.  On successful completion, nil is signaled via the results channel.
 func buildWorker(
 	id int,
-	jobs <-chan toolchain.CompilerJob,
+	jobs <-chan int,
+	fn func(idx int) error,
 	stop chan struct{},
 	results chan error) {
 
@@ -491,7 +584,7 @@ func buildWorker(
 			return
 
 		case j := <-jobs:
-			if err := toolchain.RunJob(j); err != nil {
+			if err := fn(j); err != nil {
 				// Stop the other routines.
 				stop <- struct{}{}
 
@@ -508,14 +601,49 @@ func buildWorker(
 	}
 }
 
+// Applies the specified function to each index in [0, numJobs) in parallel.
+// Returns the first error encountered.
+func runJobs(numJobs int, fn func(idx int) error) error {
+	jobs := make(chan int, numJobs)
+	defer close(jobs)
+
+	stop := make(chan struct{}, newtutil.NewtNumJobs)
+	defer close(stop)
+
+	errors := make(chan error, newtutil.NewtNumJobs)
+	defer close(errors)
+
+	for i := 0; i < numJobs; i++ {
+		jobs <- i
+	}
+
+	for i := 0; i < newtutil.NewtNumJobs; i++ {
+		go buildWorker(i, jobs, fn, stop, errors)
+	}
+
+	var err error
+	for i := 0; i < newtutil.NewtNumJobs; i++ {
+		subErr := <-errors
+		if err == nil && subErr != nil {
+			err = subErr
+		}
+	}
+
+	return err
+}
+
 func (b *Builder) Build() error {
 	b.CleanArtifacts()
 
 	// Build the packages alphabetically to ensure a consistent order.
 	bpkgs := b.sortedBuildPackages()
 
+	if err := b.reportIncludePruning(); err != nil {
+		return err
+	}
+
 	// Calculate the list of jobs.  Each record represents a single file that
-	// needs to be compiled.
+	// may need to be compiled.
 	entries := []toolchain.CompilerJob{}
 	bpkgCompilerMap := map[*BuildPackage]*toolchain.Compiler{}
 	for _, bpkg := range bpkgs {
@@ -525,38 +653,52 @@ func (b *Builder) Build() error {
 		}
 		entries = append(entries, subEntries...)
 
-		if len(subEntries) > 0 {
+		// A prebuilt package's archive is used as is.
+		if len(subEntries) > 0 && !isPrebuilt(bpkg) {
 			bpkgCompilerMap[bpkg] = subEntries[0].Compiler
 		}
 	}
 
-	// Build each file in parallel.
-	jobs := make(chan toolchain.CompilerJob, len(entries))
-	defer close(jobs)
-
-	stop := make(chan struct{}, newtutil.NewtNumJobs)
-	defer close(stop)
+	// Consult the dependency tracker up front so that the total amount of
+	// work is known before compilation starts.
+	util.StatusMessage(util.VERBOSITY_VERBOSE,
+		"Checking dependencies of %d source files\n", len(entries))
 
-	errors := make(chan error, newtutil.NewtNumJobs)
-	defer close(errors)
+	required := make([]bool, len(entries))
+	err := runJobs(len(entries), func(idx int) error {
+		var err error
+		required[idx], err = toolchain.JobRequired(entries[idx])
+		return err
+	})
+	if err != nil {
+		return err
+	}
 
-	for _, entry := range entries {
-		jobs <- entry
+	work := []toolchain.CompilerJob{}
+	for i, entry := range entries {
+		if required[i] {
+			work = append(work, entry)
+		}
 	}
 
-	for i := 0; i < newtutil.NewtNumJobs; i++ {
-		go buildWorker(i, jobs, stop, errors)
+	// Build each out-of-date file in parallel.
+	toolchain.StartProgress(len(work))
+	err = runJobs(len(work), func(idx int) error {
+		return toolchain.RunRequiredJob(work[idx])
+	})
+	toolchain.FinishProgress()
+	if err != nil {
+		return util.ClassifyError(err, util.ERR_CLASS_COMPILE)
 	}
 
-	var err error
-	for i := 0; i < newtutil.NewtNumJobs; i++ {
-		subErr := <-errors
-		if err == nil && subErr != nil {
-			err = subErr
+	if b.targetBuilder.strictIncludes {
+		if err := b.checkIncludes(bpkgs); err != nil {
+			return err
 		}
 	}
-	if err != nil {
-		return err
+
+	if b.targetBuilder.stopsAfter(BUILD_STAGE_COMPILE) {
+		return nil
 	}
 
 	for _, bpkg := range bpkgs {
@@ -611,6 +753,39 @@ func (b *Builder) pkgWithPath(path string) *BuildPackage {
 	return nil
 }
 
+// Returns the command that compiles the specified source file as part of this
+// build.  The command is meant to be run from the project base directory.  A
+// nil command is returned if the file does not belong to any package in the
+// build.
+func (b *Builder) CompileCmd(srcPath string) ([]string, error) {
+	srcPath = filepath.ToSlash(srcPath)
+
+	var bpkg *BuildPackage
+	bpkgBase := ""
+	for _, p := range b.PkgMap {
+		base := filepath.ToSlash(p.rpkg.Lpkg.BasePath()) + "/"
+		if strings.HasPrefix(srcPath, base) && len(base) > len(bpkgBase) {
+			bpkg = p
+			bpkgBase = base
+		}
+	}
+	if bpkg == nil {
+		return nil, nil
+	}
+
+	cType, err := toolchain.CompilerTypeForFile(srcPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := b.newCompiler(bpkg, b.PkgBinDir(bpkg))
+	if err != nil {
+		return nil, err
+	}
+
+	return c.CompileFileCmd(srcPath, cType)
+}
+
 func (b *Builder) FetchSymbolMap() (error, *symbol.SymbolMap) {
 	loaderSm := symbol.NewSymbolMap()
 
@@ -647,7 +822,8 @@ func (b *Builder) buildRomElf(common *symbol.SymbolMap) error {
 
 	// build the set of archive file names
 	for _, bpkg := range b.PkgMap {
-		archiveNames, _ := filepath.Glob(b.PkgBinDir(bpkg) + "/*.a")
+		archiveNames, _ := filepath.Glob(
+			filepath.Join(b.PkgBinDir(bpkg), "*.a"))
 		archNames = append(archNames, archiveNames...)
 	}
 
@@ -701,8 +877,14 @@ func (b *Builder) buildRomElf(common *symbol.SymbolMap) error {
 	return nil
 }
 
-func (b *Builder) CreateImage(version string,
-	keystr string, keyId uint8, loaderImg *image.Image) (*image.Image, error) {
+// Creates an image that is to be written to the specified flash area.
+func (b *Builder) CreateImage(version string, keystr string, keyId uint8,
+	loaderImg *image.Image, areaName string) (*image.Image, error) {
+
+	layout, err := b.targetBuilder.ImageLayout()
+	if err != nil {
+		return nil, err
+	}
 
 	img, err := image.NewImage(b.AppBinPath(), b.AppImgPath())
 	if err != nil {
@@ -714,6 +896,11 @@ func (b *Builder) CreateImage(version string,
 		return nil, err
 	}
 
+	err = b.targetBuilder.applyImageLayout(layout, img, areaName)
+	if err != nil {
+		return nil, err
+	}
+
 	if keystr != "" {
 		err = img.SetSigningKey(keystr, keyId)
 		if err != nil {
@@ -729,6 +916,15 @@ func (b *Builder) CreateImage(version string,
 	util.StatusMessage(util.VERBOSITY_DEFAULT,
 		"App image succesfully generated: %s\n", img.TargetImg)
 
+	newtutil.EmitEvent(newtutil.EVENT_IMAGE, map[string]interface{}{
+		"target":  b.targetPkg.rpkg.Lpkg.Name(),
+		"build":   b.buildName,
+		"path":    img.TargetImg,
+		"version": version,
+		"hash":    hex.EncodeToString(img.Hash),
+		"size":    img.TotalSize,
+	})
+
 	return img, nil
 }
 
@@ -23,6 +23,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 
 	"mynewt.apache.org/newt/newt/newtutil"
 	"mynewt.apache.org/newt/newt/pkg"
@@ -35,7 +36,13 @@ import (
 type BuildPackage struct {
 	rpkg              *resolve.ResolvePackage
 	SourceDirectories []string
+	SourceFiles       []string
 	ci                *toolchain.CompilerInfo
+	localCi           *toolchain.CompilerInfo
+
+	// Absolute paths of directories compiled in addition to the package's
+	// own sources (e.g., a fuzz harness).
+	extraSrcDirs []string
 }
 
 func NewBuildPackage(rpkg *resolve.ResolvePackage) *BuildPackage {
@@ -61,7 +68,7 @@ func (bpkg *BuildPackage) collectDepsAux(b *Builder,
 		dbpkg := b.PkgMap[dep.Rpkg]
 		if dbpkg == nil {
 			return util.FmtNewtError("Package not found %s; required by %s",
-				dbpkg.rpkg.Lpkg.Name(), bpkg.rpkg.Lpkg.Name())
+				dep.Rpkg.Lpkg.Name(), bpkg.rpkg.Lpkg.Name())
 		}
 
 		if err := dbpkg.collectDepsAux(b, set); err != nil {
@@ -102,12 +109,58 @@ func (bpkg *BuildPackage) recursiveIncludePaths(
 
 	incls := []string{}
 	for _, p := range deps {
-		incls = append(incls, p.publicIncludeDirs(b.targetBuilder.bspPkg)...)
+		incls = append(incls, p.publicIncludeDirs(b)...)
 	}
 
 	return incls, nil
 }
 
+// Calculates the public compiler flags exported by the specified pkg's
+// recursive dependencies (not including the pkg itself).  Dependencies are
+// processed in name order so that conflicting flags are resolved
+// consistently.
+func (bpkg *BuildPackage) recursivePublicCflags(b *Builder) ([]string, error) {
+	deps, err := bpkg.collectDeps(b)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(bpkgFullNameSorter{deps})
+
+	ci := toolchain.NewCompilerInfo()
+	for _, p := range deps {
+		if p != bpkg {
+			ci.AddCflags(p.pkgFlags(b, "pkg.public_cflags"))
+		}
+	}
+
+	return ci.Cflags, nil
+}
+
+// Reads a list of flags from the package's pkg.yml, taking syscfg features
+// into account, and expands repo designators ("@<repo-name>") into paths.
+func (bpkg *BuildPackage) pkgFlags(b *Builder, key string) []string {
+	features := b.cfg.FeaturesForLpkg(bpkg.rpkg.Lpkg)
+	flags := newtutil.GetStringSliceFeatures(bpkg.rpkg.Lpkg.PkgV, features,
+		key)
+	expandFlags(flags)
+
+	return flags
+}
+
+// Reads a list of paths (e.g., include directories) from the package's
+// pkg.yml.  Relative paths are relative to the package.
+func (bpkg *BuildPackage) pkgPaths(b *Builder, key string) []string {
+	dirs := bpkg.pkgFlags(b, key)
+	for i, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			dirs[i] = filepath.Join(bpkg.rpkg.Lpkg.BasePath(), dir)
+		}
+	}
+
+	return dirs
+}
+
 // Replaces instances of "@<repo-name>" with repo paths.
 func expandFlags(flags []string) {
 	for i, f := range flags {
@@ -132,17 +185,9 @@ func (bpkg *BuildPackage) CompilerInfo(
 
 	// Read each set of flags and expand repo designators ("@<repo-nme>") into
 	// paths.
-	ci.Cflags = newtutil.GetStringSliceFeatures(bpkg.rpkg.Lpkg.PkgV, features,
-		"pkg.cflags")
-	expandFlags(ci.Cflags)
-
-	ci.Lflags = newtutil.GetStringSliceFeatures(bpkg.rpkg.Lpkg.PkgV, features,
-		"pkg.lflags")
-	expandFlags(ci.Lflags)
-
-	ci.Aflags = newtutil.GetStringSliceFeatures(bpkg.rpkg.Lpkg.PkgV, features,
-		"pkg.aflags")
-	expandFlags(ci.Aflags)
+	ci.Cflags = bpkg.pkgFlags(b, "pkg.cflags")
+	ci.Lflags = bpkg.pkgFlags(b, "pkg.lflags")
+	ci.Aflags = bpkg.pkgFlags(b, "pkg.aflags")
 
 	// Package-specific injected settings get specified as C flags on the
 	// command line.
@@ -177,6 +222,7 @@ func (bpkg *BuildPackage) CompilerInfo(
 	bpkg.SourceDirectories = newtutil.GetStringSliceFeatures(
 		bpkg.rpkg.Lpkg.PkgV,
 		features, "pkg.src_dirs")
+	bpkg.SourceFiles = bpkg.pkgPaths(b, "pkg.src_files")
 
 	includePaths, err := bpkg.recursiveIncludePaths(b)
 	if err != nil {
@@ -189,8 +235,39 @@ func (bpkg *BuildPackage) CompilerInfo(
 	return bpkg.ci, nil
 }
 
+// Generates the compiler info that applies only when building this package's
+// own sources.  Unlike the info returned by CompilerInfo(), this is never
+// promoted to the global set, even for the target, app, and BSP packages.
+// The package's own flags take precedence over the public flags of its
+// dependencies.
+func (bpkg *BuildPackage) LocalCompilerInfo(
+	b *Builder) (*toolchain.CompilerInfo, error) {
+
+	// As with CompilerInfo(), the result is cached.  The dependency graph is
+	// not available once shared packages have been removed from a split
+	// app.
+	if bpkg.localCi != nil {
+		return bpkg.localCi, nil
+	}
+
+	ci := toolchain.NewCompilerInfo()
+	ci.AddCflags(bpkg.pkgFlags(b, "pkg.private_cflags"))
+	ci.AddCflags(bpkg.pkgFlags(b, "pkg.public_cflags"))
+
+	depCflags, err := bpkg.recursivePublicCflags(b)
+	if err != nil {
+		return nil, err
+	}
+	ci.AddCflags(depCflags)
+
+	ci.Includes = bpkg.pkgPaths(b, "pkg.private_include_dirs")
+	bpkg.localCi = ci
+
+	return bpkg.localCi, nil
+}
+
 func (bpkg *BuildPackage) findSdkIncludes() []string {
-	sdkDir := bpkg.rpkg.Lpkg.BasePath() + "/src/ext/"
+	sdkDir := filepath.Join(bpkg.rpkg.Lpkg.BasePath(), "src", "ext")
 
 	sdkPathList := []string{}
 	err := filepath.Walk(sdkDir,
@@ -209,17 +286,20 @@ func (bpkg *BuildPackage) findSdkIncludes() []string {
 	return sdkPathList
 }
 
-func (bpkg *BuildPackage) publicIncludeDirs(bspPkg *pkg.BspPackage) []string {
+func (bpkg *BuildPackage) publicIncludeDirs(b *Builder) []string {
+	bspPkg := b.targetBuilder.bspPkg
 	pkgBase := filepath.Base(bpkg.rpkg.Lpkg.Name())
 	bp := bpkg.rpkg.Lpkg.BasePath()
 
 	incls := []string{
-		bp + "/include",
-		bp + "/include/" + pkgBase + "/arch/" + bspPkg.Arch,
+		filepath.Join(bp, "include"),
+		filepath.Join(bp, "include", pkgBase, "arch", bspPkg.Arch),
 	}
+	incls = append(incls, bpkg.pkgPaths(b, "pkg.public_include_dirs")...)
 
 	if bpkg.rpkg.Lpkg.Type() == pkg.PACKAGE_TYPE_SDK {
-		incls = append(incls, bspPkg.BasePath()+"/include/bsp/")
+		incls = append(incls, filepath.Join(bspPkg.BasePath(), "include",
+			"bsp"))
 
 		sdkIncls := bpkg.findSdkIncludes()
 		incls = append(incls, sdkIncls...)
@@ -229,17 +309,19 @@ func (bpkg *BuildPackage) publicIncludeDirs(bspPkg *pkg.BspPackage) []string {
 }
 
 func (bpkg *BuildPackage) privateIncludeDirs(b *Builder) []string {
-	srcDir := bpkg.rpkg.Lpkg.BasePath() + "/src/"
+	srcDir := filepath.Join(bpkg.rpkg.Lpkg.BasePath(), "src")
 
 	incls := []string{}
 	incls = append(incls, srcDir)
-	incls = append(incls, srcDir+"/arch/"+b.targetBuilder.bspPkg.Arch)
+	incls = append(incls, filepath.Join(srcDir, "arch",
+		b.targetBuilder.bspPkg.Arch))
 
 	switch bpkg.rpkg.Lpkg.Type() {
 	case pkg.PACKAGE_TYPE_SDK:
 		// If pkgType == SDK, include all the items in "ext" directly into the
 		// include path
-		incls = append(incls, b.bspPkg.rpkg.Lpkg.BasePath()+"/include/bsp/")
+		incls = append(incls, filepath.Join(b.bspPkg.rpkg.Lpkg.BasePath(),
+			"include", "bsp"))
 
 		sdkIncls := bpkg.findSdkIncludes()
 		incls = append(incls, sdkIncls...)
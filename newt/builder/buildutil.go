@@ -67,6 +67,20 @@ func (b bpkgSorter) Less(i, j int) bool {
 	return b.bpkgs[i].rpkg.Lpkg.Name() < b.bpkgs[j].rpkg.Lpkg.Name()
 }
 
+type bpkgFullNameSorter struct {
+	bpkgs []*BuildPackage
+}
+
+func (s bpkgFullNameSorter) Len() int {
+	return len(s.bpkgs)
+}
+func (s bpkgFullNameSorter) Swap(i, j int) {
+	s.bpkgs[i], s.bpkgs[j] = s.bpkgs[j], s.bpkgs[i]
+}
+func (s bpkgFullNameSorter) Less(i, j int) bool {
+	return s.bpkgs[i].rpkg.Lpkg.FullName() < s.bpkgs[j].rpkg.Lpkg.FullName()
+}
+
 func (b *Builder) sortedBuildPackages() []*BuildPackage {
 	sorter := bpkgSorter{
 		bpkgs: make([]*BuildPackage, 0, len(b.PkgMap)),
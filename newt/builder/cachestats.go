@@ -0,0 +1,73 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"path/filepath"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+// File in the target's bin directory to which ccache logs the result of each
+// of the build's compilations.
+const CCACHE_STATS_FILENAME = "ccache-stats.log"
+
+// Returns the command that compilations are run through, as given by the
+// compiler_launcher setting; nil if there is none.
+func compilerLauncher() []string {
+	return strings.Fields(newtutil.NewtSettings.String("compiler_launcher"))
+}
+
+func (t *TargetBuilder) startCacheStats() {
+	// ccache may run in a different directory than newt.
+	statsLog, _ := filepath.Abs(filepath.Join(TargetBinDir(t.target.Name()),
+		CCACHE_STATS_FILENAME))
+
+	t.cacheStats = toolchain.NewCacheStats(compilerLauncher(),
+		t.sharedObjects, statsLog)
+}
+
+// Returns the compilation cache statistics of the most recent build; nil if
+// the target hasn't been built.
+func (t *TargetBuilder) CacheStats() *toolchain.CacheStats {
+	return t.cacheStats
+}
+
+// Reports how effective the compilation caches were during the build, both
+// to the user and in the event log.  Nothing is reported if no cache is in
+// use or nothing needed compiling.
+func (t *TargetBuilder) reportCacheStats() {
+	if !t.cacheStats.Active() {
+		return
+	}
+
+	t.cacheStats.Collect()
+
+	for _, line := range t.cacheStats.Lines() {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "Cache %s\n", line)
+	}
+
+	fields := t.cacheStats.EventFields()
+	fields["target"] = t.target.Name()
+	newtutil.EmitEvent(newtutil.EVENT_CACHE, fields)
+}
@@ -0,0 +1,191 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+// A strong global symbol defined in more than one object file among the
+// archives being linked.
+type SymbolCollision struct {
+	Name string
+	Defs []SymbolDef
+}
+
+type SymbolDef struct {
+	Package string
+	Object  string
+}
+
+var objdumpMemberRe = regexp.MustCompile(`^(.+):\s+file format `)
+
+// Lists the strong global symbols defined by each object file in the
+// specified archive, as "member name" pairs.
+func archiveDefinitions(c *toolchain.Compiler,
+	archive string) ([][2]string, error) {
+
+	err, out := c.ParseLibrary(archive)
+	if err != nil {
+		return nil, err
+	}
+	err, r := getParseRexeg()
+	if err != nil {
+		return nil, err
+	}
+
+	defs := [][2]string{}
+	member := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		if m := objdumpMemberRe.FindStringSubmatch(line); m != nil {
+			member = m[1]
+			continue
+		}
+
+		m := r.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		// Flags: [lgu! ][w ][C ][W ][Ii ][Dd ][FfO ].  Common symbols
+		// are merged by the linker, so they don't collide.
+		flags, section := m[2], m[3]
+		if (flags[0] != 'g' && flags[0] != 'u') || flags[1] == 'w' ||
+			flags[5] == 'd' || flags[6] == 'f' ||
+			section == "*UND*" || section == "*COM*" {
+
+			continue
+		}
+
+		// The name is the last field; visibility (e.g., ".hidden") may
+		// precede it.
+		fields := strings.Fields(line)
+		defs = append(defs, [2]string{member, fields[len(fields)-1]})
+	}
+
+	return defs, nil
+}
+
+type collisionSorter struct {
+	collisions []SymbolCollision
+}
+
+func (s collisionSorter) Len() int {
+	return len(s.collisions)
+}
+func (s collisionSorter) Swap(i, j int) {
+	s.collisions[i], s.collisions[j] = s.collisions[j], s.collisions[i]
+}
+func (s collisionSorter) Less(i, j int) bool {
+	return s.collisions[i].Name < s.collisions[j].Name
+}
+
+// Scans the specified package archives for strong global symbols that are
+// defined more than once.  Such symbols make the link fail with a multiple
+// definition error if both object files get pulled in.
+func (b *Builder) findSymbolCollisions(c *toolchain.Compiler,
+	archives map[string]string) ([]SymbolCollision, error) {
+
+	names := make([]string, 0, len(archives))
+	for ar, _ := range archives {
+		names = append(names, ar)
+	}
+	sort.Strings(names)
+
+	defMap := map[string][]SymbolDef{}
+	for _, ar := range names {
+		defs, err := archiveDefinitions(c, ar)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range defs {
+			defMap[d[1]] = append(defMap[d[1]], SymbolDef{
+				Package: archives[ar],
+				Object:  filepath.Base(d[0]),
+			})
+		}
+	}
+
+	collisions := []SymbolCollision{}
+	for name, defs := range defMap {
+		if len(defs) > 1 {
+			collisions = append(collisions, SymbolCollision{
+				Name: name,
+				Defs: defs,
+			})
+		}
+	}
+	sort.Sort(collisionSorter{collisions})
+
+	return collisions, nil
+}
+
+// Describes where a colliding symbol is defined and how to fix it.
+func (sc *SymbolCollision) message() string {
+	locs := make([]string, len(sc.Defs))
+	for i, d := range sc.Defs {
+		locs[i] = fmt.Sprintf("%s (%s)", d.Package, d.Object)
+	}
+
+	return fmt.Sprintf("global symbol `%s' is defined more than once: %s; "+
+		"make all but one definition weak (__attribute__((weak))), make "+
+		"it static, or rename it", sc.Name, strings.Join(locs, ", "))
+}
+
+// Records a warning diagnostic for each collision, attributed to each
+// package that defines the symbol.
+func recordSymbolCollisions(collisions []SymbolCollision) {
+	diags := []toolchain.Diagnostic{}
+	for _, sc := range collisions {
+		msg := sc.message()
+		for _, d := range sc.Defs {
+			diags = append(diags, toolchain.Diagnostic{
+				Package:  d.Package,
+				File:     d.Object,
+				Severity: toolchain.DIAG_SEVERITY_WARNING,
+				Message:  msg,
+			})
+		}
+	}
+
+	toolchain.AddDiagnostics(diags)
+}
+
+// Converts a failed link into an error naming the symbol collisions that
+// likely caused it.
+func symbolCollisionError(elfName string, collisions []SymbolCollision,
+	linkErr error) error {
+
+	lines := make([]string, len(collisions))
+	for i, sc := range collisions {
+		lines[i] = "    " + sc.message()
+	}
+
+	return util.FmtNewtError("Failed to link %s: %s\n"+
+		"%d global symbol(s) are defined in more than one place:\n%s",
+		filepath.Base(elfName), strings.TrimSpace(linkErr.Error()),
+		len(collisions), strings.Join(lines, "\n"))
+}
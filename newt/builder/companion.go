@@ -0,0 +1,212 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"mynewt.apache.org/newt/newt/flash"
+	"mynewt.apache.org/newt/newt/ihex"
+	"mynewt.apache.org/newt/util"
+)
+
+// Path of the Intel HEX file combining a target's images with those of its
+// companion targets.
+func CombinedHexPath(targetName string) string {
+	return filepath.Join(TargetBinDir(targetName), "combined.hex")
+}
+
+// Creates a builder for each of the target's companion targets
+// ("target.companions").
+func (t *TargetBuilder) companionBuilders() ([]*TargetBuilder, error) {
+	companions, err := t.target.Companions()
+	if err != nil {
+		return nil, err
+	}
+
+	builders := make([]*TargetBuilder, 0, len(companions))
+	for _, c := range companions {
+		cb, err := NewTargetBuilder(c)
+		if err != nil {
+			return nil, err
+		}
+		builders = append(builders, cb)
+	}
+
+	return builders, nil
+}
+
+// Returns the loadable contents of the target's app elf file, placed at
+// their load addresses.
+func (t *TargetBuilder) elfSegments() ([]ihex.Segment, error) {
+	path := t.AppBuilder.AppElfPath()
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, util.FmtNewtError("Can't read %s: %s", path,
+			err.Error())
+	}
+	defer f.Close()
+
+	segs := []ihex.Segment{}
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_LOAD || p.Filesz == 0 {
+			continue
+		}
+
+		data := make([]byte, p.Filesz)
+		if _, err := p.ReadAt(data, 0); err != nil {
+			return nil, util.FmtNewtError("Can't read %s: %s", path,
+				err.Error())
+		}
+
+		segs = append(segs, ihex.Segment{
+			Addr:   uint32(p.Paddr),
+			Data:   data,
+			Source: path,
+		})
+	}
+
+	return segs, nil
+}
+
+// Returns the target's images placed at the addresses of the flash areas
+// create-image puts them in.
+func (t *TargetBuilder) imageSegments() ([]ihex.Segment, error) {
+	segs := []ihex.Segment{}
+
+	add := func(b *Builder, areaName string) error {
+		area, ok := t.bspPkg.FlashMap.Areas[areaName]
+		if !ok {
+			return util.FmtNewtError(
+				"BSP %s does not define flash area %s", t.bspPkg.Name(),
+				areaName)
+		}
+
+		data, err := ioutil.ReadFile(b.AppImgPath())
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+
+		segs = append(segs, ihex.Segment{
+			Addr:   uint32(t.bspPkg.FlashMap.AreaAddress(area)),
+			Data:   data,
+			Source: b.AppImgPath(),
+		})
+		return nil
+	}
+
+	appArea := flash.FLASH_AREA_NAME_IMAGE_0
+	if t.LoaderBuilder != nil {
+		err := add(t.LoaderBuilder, flash.FLASH_AREA_NAME_IMAGE_0)
+		if err != nil {
+			return nil, err
+		}
+		appArea = flash.FLASH_AREA_NAME_IMAGE_1
+	}
+	if err := add(t.AppBuilder, appArea); err != nil {
+		return nil, err
+	}
+
+	return segs, nil
+}
+
+// Writes the combined programming file of a target and its companions.
+func (t *TargetBuilder) writeCombinedHex(companions []*TargetBuilder,
+	images bool) error {
+
+	segs := []ihex.Segment{}
+	for _, b := range append([]*TargetBuilder{t}, companions...) {
+		var s []ihex.Segment
+		var err error
+		if images {
+			s, err = b.imageSegments()
+		} else {
+			s, err = b.elfSegments()
+		}
+		if err != nil {
+			return err
+		}
+		segs = append(segs, s...)
+	}
+
+	data, err := ihex.Encode(segs)
+	if err != nil {
+		return util.FmtNewtError("Can't combine images of %s and its "+
+			"companions: %s", t.target.FullName(), err.Error())
+	}
+
+	path := CombinedHexPath(t.target.Name())
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Combined image: %s\n", path)
+	return nil
+}
+
+// Builds the target's companion targets, if any, and writes a hex file
+// containing the linked executables of the target and all its companions.
+// The target itself must already be built.
+func (t *TargetBuilder) BuildCompanions() error {
+	companions, err := t.companionBuilders()
+	if err != nil || len(companions) == 0 {
+		return err
+	}
+
+	for _, cb := range companions {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Building companion target %s\n", cb.target.FullName())
+		if err := cb.Build(); err != nil {
+			return err
+		}
+	}
+
+	return t.writeCombinedHex(companions, false)
+}
+
+// Creates the images of the target's companion targets, if any, with the
+// same version and signing key, and writes a hex file containing the images
+// of the target and all its companions.  The target's own images must
+// already be created.
+func (t *TargetBuilder) CreateCompanionImages(version string, keystr string,
+	keyId uint8) error {
+
+	companions, err := t.companionBuilders()
+	if err != nil || len(companions) == 0 {
+		return err
+	}
+
+	for _, cb := range companions {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Creating images of companion target %s\n",
+			cb.target.FullName())
+		if _, _, err := cb.CreateImages(version, keystr, keyId); err != nil {
+			return err
+		}
+	}
+
+	return t.writeCombinedHex(companions, true)
+}
@@ -0,0 +1,208 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Categories of target properties that CompareTargets() reports on.  Set
+// categories list the items present in one target but not the other; the
+// others list the items whose values differ.
+const (
+	TARGET_CMP_VARS     = "Target variables"
+	TARGET_CMP_PACKAGES = "Packages"
+	TARGET_CMP_SYSCFG   = "Syscfg settings"
+	TARGET_CMP_CFLAGS   = "Compiler flags"
+	TARGET_CMP_LFLAGS   = "Linker flags"
+	TARGET_CMP_FLASH    = "Flash areas"
+)
+
+var targetCmpCategories = []struct {
+	name string
+	set  bool
+}{
+	{TARGET_CMP_VARS, false},
+	{TARGET_CMP_PACKAGES, true},
+	{TARGET_CMP_SYSCFG, false},
+	{TARGET_CMP_CFLAGS, true},
+	{TARGET_CMP_LFLAGS, true},
+	{TARGET_CMP_FLASH, false},
+}
+
+// An item that differs between two targets.  Items of set categories have
+// the value "yes".
+type TargetDiffEntry struct {
+	Name string `json:"name"`
+	InA  bool   `json:"in_a"`
+	InB  bool   `json:"in_b"`
+	A    string `json:"a"`
+	B    string `json:"b"`
+}
+
+type TargetDiffCategory struct {
+	Name    string            `json:"name"`
+	Set     bool              `json:"set"`
+	Entries []TargetDiffEntry `json:"entries"`
+}
+
+// The differences between two targets' effective configurations.
+type TargetComparison struct {
+	TargetA    string               `json:"target_a"`
+	TargetB    string               `json:"target_b"`
+	Categories []TargetDiffCategory `json:"categories"`
+}
+
+// Indicates whether the targets are equivalent in every compared respect.
+func (tc *TargetComparison) Identical() bool {
+	for _, c := range tc.Categories {
+		if len(c.Entries) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Adds flags to a snapshot.  The paths of the target's bin directory and
+// package are abstracted away, since they always differ.
+func (t *TargetBuilder) flagSet(m map[string]string, flags []string) {
+	r := strings.NewReplacer(
+		TargetBinDir(t.target.Name()), "bin/<target>",
+		t.target.Package().BasePath(), "<target>")
+
+	for _, f := range flags {
+		m[r.Replace(f)] = "yes"
+	}
+}
+
+// Collects the properties of the target that CompareTargets() compares,
+// keyed by category.  The target's build is prepared, i.e., its generated
+// files are written.
+func (t *TargetBuilder) compareSnapshot() (map[string]map[string]string,
+	error) {
+
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	snap := map[string]map[string]string{}
+	for _, c := range targetCmpCategories {
+		snap[c.name] = map[string]string{}
+	}
+
+	for k, v := range t.target.EffectiveVars() {
+		snap[TARGET_CMP_VARS][strings.TrimPrefix(k, "target.")] = v
+	}
+
+	for _, rpkg := range t.res.MasterSet.Rpkgs {
+		if rpkg.Lpkg != t.target.Package() {
+			snap[TARGET_CMP_PACKAGES][rpkg.Lpkg.FullName()] = "yes"
+		}
+	}
+
+	for name, entry := range t.res.Cfg.Settings {
+		snap[TARGET_CMP_SYSCFG][name] = entry.Value
+	}
+
+	// The app package's flags include the global ones.
+	b := t.AppBuilder
+	c, err := b.newCompiler(b.appPkg, b.BinDir())
+	if err != nil {
+		return nil, err
+	}
+	t.flagSet(snap[TARGET_CMP_CFLAGS], c.Cflags())
+
+	lc, err := b.linkCompiler(b.BinDir(), t.bspPkg.LinkerScripts)
+	if err != nil {
+		return nil, err
+	}
+	// The flags preceding the objects are the compiler flags.
+	_, lflags := lc.LinkFlags()
+	t.flagSet(snap[TARGET_CMP_LFLAGS], lflags)
+
+	for name, area := range t.bspPkg.FlashMap.Areas {
+		snap[TARGET_CMP_FLASH][name] = fmt.Sprintf(
+			"device %d, offset 0x%x, size 0x%x", area.Device, area.Offset,
+			area.Size)
+	}
+
+	return snap, nil
+}
+
+// Compares the effective configurations of two targets: their variables
+// (including inherited ones), package sets (apart from the targets
+// themselves), syscfg values, app compiler and linker flags, and flash
+// maps.  The targets are resolved one after the
+// other, since resolution relies on global state.
+func CompareTargets(a *TargetBuilder, b *TargetBuilder) (
+	*TargetComparison, error) {
+
+	snapA, err := a.compareSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	snapB, err := b.compareSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &TargetComparison{
+		TargetA: a.target.FullName(),
+		TargetB: b.target.FullName(),
+	}
+
+	for _, c := range targetCmpCategories {
+		mA := snapA[c.name]
+		mB := snapB[c.name]
+
+		names := map[string]bool{}
+		for name, _ := range mA {
+			names[name] = true
+		}
+		for name, _ := range mB {
+			names[name] = true
+		}
+
+		cat := TargetDiffCategory{
+			Name:    c.name,
+			Set:     c.set,
+			Entries: []TargetDiffEntry{},
+		}
+		for _, name := range sortedKeys(names) {
+			valA, inA := mA[name]
+			valB, inB := mB[name]
+			if inA != inB || valA != valB {
+				cat.Entries = append(cat.Entries, TargetDiffEntry{
+					Name: name,
+					InA:  inA,
+					InB:  inB,
+					A:    valA,
+					B:    valB,
+				})
+			}
+		}
+
+		tc.Categories = append(tc.Categories, cat)
+	}
+
+	return tc, nil
+}
@@ -0,0 +1,138 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+// Files in the target's bin directory recording how long each file took to
+// compile, the last time it was compiled without and with include pruning.
+const COMPILE_TIMES_FILENAME = "compile-times.json"
+const COMPILE_TIMES_PRUNED_FILENAME = "compile-times-pruned.json"
+
+// Compile time of each object file, in microseconds.
+type compileTimesRecord struct {
+	Files map[string]int64 `json:"files"`
+}
+
+func (t *TargetBuilder) compileTimesPath(pruned bool) string {
+	filename := COMPILE_TIMES_FILENAME
+	if pruned {
+		filename = COMPILE_TIMES_PRUNED_FILENAME
+	}
+
+	return filepath.Join(TargetBinDir(t.target.Name()), filename)
+}
+
+func (t *TargetBuilder) readCompileTimes(pruned bool) *compileTimesRecord {
+	path := t.compileTimesPath(pruned)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	rec := &compileTimesRecord{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		log.Debugf("Ignoring corrupt compile times (%s): %s", path,
+			err.Error())
+		return nil
+	}
+
+	return rec
+}
+
+func (t *TargetBuilder) writeCompileTimes(pruned bool,
+	rec *compileTimesRecord) error {
+
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return util.WriteFile(t.compileTimesPath(pruned), data, 0644)
+}
+
+func (t *TargetBuilder) startCompileTimes() {
+	t.compileTimes = toolchain.NewCompileTimes()
+}
+
+// Saves the compile times of the files compiled during the build.  Times are
+// kept separately for builds with and without include pruning; if pruning is
+// enabled, the time it took to compile the files that were also compiled
+// without pruning is compared against that record.
+func (t *TargetBuilder) recordCompileTimes() {
+	if t.compileTimes == nil || len(t.compileTimes.Files) == 0 {
+		return
+	}
+
+	rec := t.readCompileTimes(t.pruneIncludes)
+	if rec == nil || rec.Files == nil {
+		rec = &compileTimesRecord{Files: map[string]int64{}}
+	}
+	for file, d := range t.compileTimes.Files {
+		rec.Files[file] = int64(d / time.Microsecond)
+	}
+
+	if err := t.writeCompileTimes(t.pruneIncludes, rec); err != nil {
+		log.Debugf("Failed to save compile times: %s", err.Error())
+	}
+
+	if !t.pruneIncludes {
+		return
+	}
+
+	unpruned := t.readCompileTimes(false)
+	if unpruned == nil {
+		return
+	}
+
+	var n int
+	var withUs int64
+	var withoutUs int64
+	for file, d := range t.compileTimes.Files {
+		if prev, ok := unpruned.Files[file]; ok {
+			n++
+			withUs += int64(d / time.Microsecond)
+			withoutUs += prev
+		}
+	}
+	if n == 0 || withoutUs == 0 {
+		return
+	}
+
+	with := time.Duration(withUs) * time.Microsecond
+	without := time.Duration(withoutUs) * time.Microsecond
+	change := 100 * float64(withUs-withoutUs) / float64(withoutUs)
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Include path pruning: %d files compiled in %.2fs (%.2fs without "+
+			"pruning, %+.1f%%)\n", n, with.Seconds(), without.Seconds(),
+		change)
+}
@@ -0,0 +1,226 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/fcb"
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newt/yaml"
+)
+
+// Defaults of sys/config's FCB storage, used when the target's syscfg
+// doesn't say otherwise.
+const CONFIG_FCB_DEFAULT_MAGIC = 0xc09f6e5e
+const CONFIG_FCB_VERSION = 1
+const CONFIG_FCB_SCRATCH_CNT = 1
+const CONFIG_FCB_DEFAULT_SECTOR_SIZE = 4096
+
+type ConfImageOptions struct {
+	// Flash area holding the config FCB.  Empty means the area named by
+	// CONFIG_FCB_FLASH_AREA.
+	Area string
+
+	// FCB sector size; 0 means the default.
+	SectorSize int
+
+	// Flash write alignment; 0 means MCU_FLASH_MIN_WRITE_SIZE.
+	Align int
+
+	// Output file; empty means bin/targets/<target>/config-<area>.bin.
+	Out string
+}
+
+// Flattens a YAML mapping into config lines.  Nested mappings form
+// slash-separated names ("ble_hs: {id: 3}" becomes "ble_hs/id=3").
+func flattenConfYaml(prefix string, m map[string]interface{},
+	lines map[string]string) error {
+
+	for k, v := range m {
+		name := k
+		if prefix != "" {
+			name = prefix + "/" + k
+		}
+		if strings.Contains(name, "=") {
+			return util.FmtNewtError("Config setting name %s contains '='",
+				name)
+		}
+
+		switch val := v.(type) {
+		case map[string]interface{}, map[interface{}]interface{}:
+			if err := flattenConfYaml(name, cast.ToStringMap(val),
+				lines); err != nil {
+
+				return err
+			}
+
+		case []interface{}:
+			return util.FmtNewtError("Config setting %s: lists are not "+
+				"supported", name)
+
+		case nil:
+			return util.FmtNewtError("Config setting %s has no value", name)
+
+		case bool:
+			// sys/config parses booleans as integers.
+			if val {
+				lines[name] = "1"
+			} else {
+				lines[name] = "0"
+			}
+
+		default:
+			lines[name] = cast.ToString(val)
+		}
+	}
+
+	return nil
+}
+
+// Reads a YAML file of config settings and returns its "name=value" lines,
+// sorted by name.
+func readConfYaml(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, util.FmtNewtError("Error parsing %s: %s", path,
+			err.Error())
+	}
+
+	lines := map[string]string{}
+	if err := flattenConfYaml("", m, lines); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(lines))
+	for name, _ := range lines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	strs := make([]string, len(names))
+	for i, name := range names {
+		strs[i] = fmt.Sprintf("%s=%s", name, lines[name])
+	}
+	return strs, nil
+}
+
+// Builds an image of the target's config FCB holding the settings in a YAML
+// file, so that devices boot with them already stored.  Returns the path of
+// the image written.
+func (t *TargetBuilder) ConfImage(yamlPath string,
+	opts ConfImageOptions) (string, error) {
+
+	if err := t.PrepBuild(); err != nil {
+		return "", err
+	}
+
+	if val, ok := t.settingValue("CONFIG_FCB"); ok && val == "0" {
+		return "", util.FmtNewtError("Target %s does not store its "+
+			"configuration in an FCB (CONFIG_FCB=0)", t.target.FullName())
+	}
+
+	areaName := opts.Area
+	if areaName == "" {
+		val, ok := t.settingValue("CONFIG_FCB_FLASH_AREA")
+		if !ok {
+			return "", util.FmtNewtError("Target %s does not set "+
+				"CONFIG_FCB_FLASH_AREA; specify --area", t.target.FullName())
+		}
+		areaName = val
+	}
+
+	area, ok := t.bspPkg.FlashMap.Areas[areaName]
+	if !ok {
+		return "", util.FmtNewtError("BSP %s has no flash area %s",
+			t.bspPkg.FullName(), areaName)
+	}
+
+	params := fcb.Params{
+		Size:       area.Size,
+		SectorSize: CONFIG_FCB_DEFAULT_SECTOR_SIZE,
+		Align:      1,
+		Magic:      CONFIG_FCB_DEFAULT_MAGIC,
+		Version:    CONFIG_FCB_VERSION,
+		ScratchCnt: CONFIG_FCB_SCRATCH_CNT,
+	}
+	if n, ok := t.intSetting("CONFIG_FCB_MAGIC"); ok {
+		params.Magic = uint32(n)
+	}
+	if n, ok := t.intSetting("MCU_FLASH_MIN_WRITE_SIZE"); ok && n > 0 {
+		params.Align = n
+	}
+	if opts.SectorSize != 0 {
+		params.SectorSize = opts.SectorSize
+	}
+	if opts.Align != 0 {
+		params.Align = opts.Align
+	}
+
+	lines, err := readConfYaml(yamlPath)
+	if err != nil {
+		return "", err
+	}
+
+	entries := make([][]byte, len(lines))
+	for i, line := range lines {
+		entries[i] = []byte(line)
+	}
+
+	data, sectors, err := fcb.Build(entries, params)
+	if err != nil {
+		return "", err
+	}
+
+	out := opts.Out
+	if out == "" {
+		out = filepath.Join(TargetBinDir(t.target.Name()),
+			"config-"+areaName+".bin")
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return "", util.ChildNewtError(err)
+	}
+	if err := ioutil.WriteFile(out, data, 0644); err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Config FCB for %s (0x%x, %d bytes, %d-byte sectors): %d "+
+			"settings in %d sectors\n", areaName,
+		t.bspPkg.FlashMap.AreaAddress(area), area.Size, params.SectorSize,
+		len(lines), sectors)
+	for _, line := range lines {
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "    %s\n", line)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,62 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
+)
+
+// Returns the connection profile named by the target's target.connection
+// setting, or nil if the target doesn't name one.
+func (t *TargetBuilder) ConnProfile() (*newtutil.ConnProfile, error) {
+	name := t.target.ConnectionName()
+	if name == "" {
+		return nil, nil
+	}
+
+	projDir := project.GetProject().Path()
+	profiles, err := newtutil.ReadConnProfiles(projDir)
+	if err != nil {
+		return nil, err
+	}
+
+	cp := profiles[name]
+	if cp == nil {
+		return nil, util.FmtNewtError("Target %s uses connection \"%s\", "+
+			"which is not defined in %s; add it with \"newt conn add\"",
+			t.target.FullName(), name,
+			newtutil.ConnProfilesPath(projDir))
+	}
+
+	return cp, nil
+}
+
+// Returns the connection profile with the specified name, or nil if the
+// project doesn't define one.
+func namedConnProfile(name string) (*newtutil.ConnProfile, error) {
+	profiles, err := newtutil.ReadConnProfiles(project.GetProject().Path())
+	if err != nil {
+		return nil, err
+	}
+
+	return profiles[name], nil
+}
@@ -0,0 +1,460 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+const CONSOLE_SOURCE_UART = "uart"
+const CONSOLE_SOURCE_RTT = "rtt"
+
+const CONSOLE_DEFAULT_BAUD = 115200
+
+// Partial lines (e.g., a shell prompt) are shown once no more output has
+// arrived for this long.
+const CONSOLE_PARTIAL_LINE_DELAY = 100 * time.Millisecond
+
+// Suffix of the syscfg settings that assign log module IDs (e.g.,
+// BLE_HS_LOG_MOD).
+const CONSOLE_LOG_MOD_SUFFIX = "_LOG_MOD"
+
+// Log module IDs predefined by mynewt's log package.
+var consoleLogModules = map[int]string{
+	0: "DEFAULT",
+	1: "OS",
+	2: "NEWTMGR",
+	3: "NIMBLE_CTLR",
+	4: "NIMBLE_HOST",
+	5: "NFFS",
+	6: "REBOOT",
+	7: "IOTIVITY",
+	8: "TEST",
+}
+
+var consoleAnsiRe = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+var consoleLogModRe = regexp.MustCompile(`\bmod=(\d+)`)
+
+type ConsoleOptions struct {
+	// uart or rtt; empty selects rtt if the target's console is RTT-only.
+	Source string
+
+	// uart: serial device and baud rate; detected if unset.
+	Port string
+	Baud int
+
+	// Prefix each line with the time it was received.
+	Timestamps bool
+
+	// Remove ANSI escape sequences from the output.
+	StripAnsi bool
+
+	// Also write the output (without ANSI sequences) to this file.
+	LogFile string
+
+	// Replace numeric log module IDs with their names.
+	LogModules bool
+}
+
+// Processes console output line by line.
+type consoleWriter struct {
+	out      io.Writer
+	log      io.Writer
+	opts     ConsoleOptions
+	modNames map[int]string
+	pending  []byte
+	midLine  bool
+}
+
+func (cw *consoleWriter) emit(s string) {
+	if cw.opts.LogModules {
+		s = consoleLogModRe.ReplaceAllStringFunc(s, func(m string) string {
+			id, _ := strconv.Atoi(m[len("mod="):])
+			if name, ok := cw.modNames[id]; ok {
+				return "mod=" + name
+			}
+			return m
+		})
+	}
+	if cw.opts.Timestamps && !cw.midLine {
+		s = time.Now().Format("15:04:05.000") + " " + s
+	}
+	cw.midLine = !strings.HasSuffix(s, "\n")
+
+	stripped := consoleAnsiRe.ReplaceAllString(s, "")
+	if cw.opts.StripAnsi {
+		io.WriteString(cw.out, stripped)
+	} else {
+		io.WriteString(cw.out, s)
+	}
+	if cw.log != nil {
+		io.WriteString(cw.log, stripped)
+	}
+}
+
+// Emits the complete lines in data; the remainder is held until the rest of
+// its line arrives or flush() is called.
+func (cw *consoleWriter) feed(data []byte) {
+	cw.pending = append(cw.pending, data...)
+	for {
+		i := bytes.IndexByte(cw.pending, '\n')
+		if i < 0 {
+			break
+		}
+		cw.emit(string(cw.pending[:i+1]))
+		cw.pending = cw.pending[i+1:]
+	}
+}
+
+func (cw *consoleWriter) flush() {
+	if len(cw.pending) > 0 {
+		cw.emit(string(cw.pending))
+		cw.pending = nil
+	}
+}
+
+// Copies console output from r until it reports an error or EOF.
+func (cw *consoleWriter) pump(r io.Reader) error {
+	type chunk struct {
+		data []byte
+		err  error
+	}
+	ch := make(chan chunk)
+	go func() {
+		for {
+			buf := make([]byte, 1024)
+			n, err := r.Read(buf)
+			ch <- chunk{buf[:n], err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case c := <-ch:
+			cw.feed(c.data)
+			if c.err != nil {
+				cw.flush()
+				if c.err == io.EOF {
+					return nil
+				}
+				return util.ChildNewtError(c.err)
+			}
+		case <-time.After(CONSOLE_PARTIAL_LINE_DELAY):
+			cw.flush()
+		}
+	}
+}
+
+// Returns log module names by ID: mynewt's predefined modules, plus those
+// assigned by the target's *_LOG_MOD settings.
+func (t *TargetBuilder) logModuleNames() map[int]string {
+	names := map[int]string{}
+	for id, name := range consoleLogModules {
+		names[id] = name
+	}
+
+	settings := make([]string, 0, len(t.res.Cfg.Settings))
+	for name, _ := range t.res.Cfg.Settings {
+		if strings.HasSuffix(name, CONSOLE_LOG_MOD_SUFFIX) {
+			settings = append(settings, name)
+		}
+	}
+	sort.Strings(settings)
+
+	for _, name := range settings {
+		id, err := util.AtoiNoOct(t.res.Cfg.Settings[name].Value)
+		if err == nil {
+			names[id] = strings.TrimSuffix(name, CONSOLE_LOG_MOD_SUFFIX)
+		}
+	}
+
+	return names
+}
+
+func (t *TargetBuilder) settingEnabled(name string) bool {
+	entry, ok := t.res.Cfg.Settings[name]
+	return ok && entry.Value != "" && entry.Value != "0"
+}
+
+// Returns the serial devices that look like a board's console on this host.
+func consolePortCandidates() []string {
+	patterns := []string{"/dev/ttyACM*", "/dev/ttyUSB*"}
+	if runtime.GOOS == "darwin" {
+		patterns = []string{"/dev/cu.usbmodem*", "/dev/cu.usbserial*"}
+	}
+
+	ports := []string{}
+	for _, p := range patterns {
+		matches, _ := filepath.Glob(p)
+		ports = append(ports, matches...)
+	}
+	sort.Strings(ports)
+
+	return ports
+}
+
+// Determines the console's serial device: the one specified, else the one
+// in the target's serial connection profile, else the BSP's
+// (bsp.console.port), else the only USB serial device attached.
+func (t *TargetBuilder) consolePort(port string) (string, error) {
+	if port != "" {
+		return port, nil
+	}
+
+	cp, err := t.ConnProfile()
+	if err != nil {
+		return "", err
+	}
+	if cp != nil && cp.Type == newtutil.CONN_TYPE_SERIAL {
+		return cp.Port, nil
+	}
+
+	var ports []string
+	if pattern := t.bspPkg.Console.Port; pattern != "" {
+		ports, _ = filepath.Glob(pattern)
+		if len(ports) == 0 {
+			return "", util.FmtNewtError("No serial device matches the "+
+				"BSP's console port: %s", pattern)
+		}
+	} else {
+		ports = consolePortCandidates()
+		if len(ports) == 0 {
+			return "", util.NewNewtError("No serial devices found; " +
+				"specify one with --port")
+		}
+	}
+
+	if len(ports) > 1 {
+		return "", util.FmtNewtError("Multiple candidate serial devices "+
+			"(%s); specify one with --port", strings.Join(ports, ", "))
+	}
+
+	return ports[0], nil
+}
+
+// Opens a serial device in raw mode at the specified baud rate.
+func openConsoleUart(port string, baud int) (*os.File, error) {
+	sttyDevFlag := "-F"
+	switch runtime.GOOS {
+	case "windows":
+		return nil, util.NewNewtError(
+			"UART consoles are not supported on Windows")
+	case "darwin", "freebsd", "openbsd", "netbsd":
+		sttyDevFlag = "-f"
+	}
+
+	cmd := []string{"stty", sttyDevFlag, port, strconv.Itoa(baud), "raw",
+		"-echo", "clocal"}
+	if _, err := util.ShellCommand(cmd, nil); err != nil {
+		return nil, util.PreNewtError(err, "Failed to configure %s", port)
+	}
+
+	f, err := os.OpenFile(port, os.O_RDWR, 0)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	return f, nil
+}
+
+// Returns the command that streams the target's RTT console through the
+// probe backend.
+func (t *TargetBuilder) rttCmd() ([]string, error) {
+	probe, err := t.selectedProbe()
+	if err != nil {
+		return nil, err
+	}
+	if probe == nil {
+		return nil, util.NewNewtError("An RTT console requires a probe " +
+			"backend; select one with --jtag or the BSP's " +
+			"bsp.probe_default")
+	}
+
+	var cmd []string
+	if probe.Name == PROBE_PROBE_RS {
+		// probe-rs finds the RTT control block through the executable's
+		// symbols.
+		cmd = []string{probeBinary(probe), "attach"}
+		cmd = append(cmd, probeTargetArgs(probe)...)
+		cmd = append(cmd, t.AppBuilder.AppElfPath())
+	} else {
+		cmd = []string{probeBinary(probe), "rtt"}
+		cmd = append(cmd, probeTargetArgs(probe)...)
+	}
+	cmd = append(cmd, probe.Args...)
+
+	if err := lookPathCmd(probe.Name, cmd); err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
+}
+
+// Returns the console source to use: the one specified, else RTT if the
+// target's console is RTT-only, else the UART.
+func (t *TargetBuilder) consoleSource(opts ConsoleOptions) string {
+	if opts.Source != "" {
+		return opts.Source
+	}
+	if t.settingEnabled("CONSOLE_RTT") && !t.settingEnabled("CONSOLE_UART") {
+		return CONSOLE_SOURCE_RTT
+	}
+	return CONSOLE_SOURCE_UART
+}
+
+// Returns the UART console's baud rate: the one specified, else the
+// target's connection profile's, else the BSP's (bsp.console.baud), else the
+// target's CONSOLE_UART_BAUD setting.
+func (t *TargetBuilder) consoleBaud(opts ConsoleOptions) int {
+	baud := opts.Baud
+	if baud == 0 {
+		cp, _ := t.ConnProfile()
+		if cp != nil && cp.Type == newtutil.CONN_TYPE_SERIAL {
+			baud = cp.Baud
+		}
+	}
+	if baud == 0 {
+		baud = t.bspPkg.Console.Baud
+	}
+	if baud == 0 {
+		entry, ok := t.res.Cfg.Settings["CONSOLE_UART_BAUD"]
+		if ok {
+			baud, _ = util.AtoiNoOct(entry.Value)
+		}
+	}
+	if baud == 0 {
+		baud = CONSOLE_DEFAULT_BAUD
+	}
+
+	return baud
+}
+
+// Creates the writer that shows console output on stdout, and in the log
+// file if one is specified.  The returned function closes the log file.
+func (t *TargetBuilder) newConsoleWriter(
+	opts ConsoleOptions) (*consoleWriter, func(), error) {
+
+	cw := &consoleWriter{
+		out:  os.Stdout,
+		opts: opts,
+	}
+	if opts.LogModules {
+		cw.modNames = t.logModuleNames()
+	}
+	if opts.LogFile == "" {
+		return cw, func() {}, nil
+	}
+
+	f, err := os.Create(opts.LogFile)
+	if err != nil {
+		return nil, nil, util.ChildNewtError(err)
+	}
+	cw.log = f
+
+	return cw, func() { f.Close() }, nil
+}
+
+// Opens the target's console and copies it to stdout until the connection
+// closes or newt is interrupted.  Input typed on stdin is sent to the
+// target.
+func (t *TargetBuilder) Console(opts ConsoleOptions) error {
+	if err := t.PrepBuild(); err != nil {
+		return err
+	}
+
+	source := t.consoleSource(opts)
+
+	cw, closeLog, err := t.newConsoleWriter(opts)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	switch source {
+	case CONSOLE_SOURCE_UART:
+		port, err := t.consolePort(opts.Port)
+		if err != nil {
+			return err
+		}
+
+		baud := t.consoleBaud(opts)
+		f, err := openConsoleUart(port, baud)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Console on %s at %d baud; press Ctrl-C to exit\n", port, baud)
+		go io.Copy(f, os.Stdin)
+		return cw.pump(f)
+
+	case CONSOLE_SOURCE_RTT:
+		cmdStrs, err := t.rttCmd()
+		if err != nil {
+			return err
+		}
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "%s\n",
+			strings.Join(cmdStrs, " "))
+
+		cmd := exec.Command(cmdStrs[0], cmdStrs[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+		if err := cmd.Start(); err != nil {
+			return util.ChildNewtError(err)
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"RTT console via %s; press Ctrl-C to exit\n", cmdStrs[0])
+		if err := cw.pump(stdout); err != nil {
+			util.KillProcessTree(cmd.Process)
+			cmd.Wait()
+			return err
+		}
+		if err := cmd.Wait(); err != nil {
+			return util.FmtNewtError("%s failed: %s", cmdStrs[0],
+				err.Error())
+		}
+		return nil
+
+	default:
+		return util.FmtNewtError("Invalid console source \"%s\"; must be "+
+			"%s or %s", source, CONSOLE_SOURCE_UART, CONSOLE_SOURCE_RTT)
+	}
+}
@@ -0,0 +1,374 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/coredump"
+	"mynewt.apache.org/newt/newt/flash"
+	"mynewt.apache.org/newt/util"
+)
+
+// Flash area mynewt's coredump package writes to unless COREDUMP_FLASH_AREA
+// says otherwise.
+const COREDUMP_DEFAULT_AREA = flash.FLASH_AREA_NAME_IMAGE_1
+
+// Symbol pointing at the running task, and the offsets of the os_task fields
+// the fault summary reports.
+const COREDUMP_CUR_TASK_SYM = "g_current_task"
+const COREDUMP_TASK_ID_OFF = 10
+const COREDUMP_TASK_PRIO_OFF = 11
+const COREDUMP_TASK_NAME_OFF = 16
+
+type CoredumpReg struct {
+	Name  string `json:"name"`
+	Value uint32 `json:"value"`
+}
+
+type CoredumpTask struct {
+	Name string `json:"name"`
+	Id   int    `json:"id"`
+	Prio int    `json:"prio"`
+}
+
+// Decoded summary of a core dump.
+type CoredumpSummary struct {
+	RawPath  string `json:"raw_file"`
+	CorePath string `json:"core_file"`
+	ElfPath  string `json:"elf_file"`
+
+	ImageHash string `json:"image_hash,omitempty"`
+
+	// Whether the dump was produced by an image other than the current
+	// build; symbols may then be wrong.
+	BuildMismatch bool `json:"build_mismatch"`
+
+	Regs      []CoredumpReg `json:"registers"`
+	Exception string        `json:"exception,omitempty"`
+	Pc        uint32        `json:"pc"`
+	PcSymbol  string        `json:"pc_symbol,omitempty"`
+	Lr        uint32        `json:"lr"`
+	LrSymbol  string        `json:"lr_symbol,omitempty"`
+
+	// The task that was running; nil if it can't be determined from the
+	// captured memory.
+	Task *CoredumpTask `json:"task,omitempty"`
+}
+
+type elfFunc struct {
+	name string
+	addr uint32
+	size uint32
+}
+
+// Symbol information needed to interpret a core dump.
+type coredumpSyms struct {
+	funcs   []elfFunc
+	objects map[string]uint32
+
+	// Contents of the executable's allocated sections, for constant data
+	// (e.g., task names) that is not in the dump.
+	image *coredump.Coredump
+}
+
+type elfFuncSorter struct {
+	funcs []elfFunc
+}
+
+func (s elfFuncSorter) Len() int {
+	return len(s.funcs)
+}
+func (s elfFuncSorter) Swap(i, j int) {
+	s.funcs[i], s.funcs[j] = s.funcs[j], s.funcs[i]
+}
+func (s elfFuncSorter) Less(i, j int) bool {
+	return s.funcs[i].addr < s.funcs[j].addr
+}
+
+func readCoredumpSyms(elfPath string) (*coredumpSyms, error) {
+	f, err := elf.Open(elfPath)
+	if err != nil {
+		return nil, util.FmtNewtError("Failed to read %s: %s", elfPath,
+			err.Error())
+	}
+	defer f.Close()
+
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, util.FmtNewtError("Failed to read symbols from %s: %s",
+			elfPath, err.Error())
+	}
+
+	cs := &coredumpSyms{
+		objects: map[string]uint32{},
+		image:   &coredump.Coredump{},
+	}
+	for _, sec := range f.Sections {
+		if sec.Flags&elf.SHF_ALLOC == 0 || sec.Type != elf.SHT_PROGBITS {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			continue
+		}
+		cs.image.Mem = append(cs.image.Mem,
+			coredump.MemRegion{Addr: uint32(sec.Addr), Data: data})
+	}
+
+	for _, s := range syms {
+		switch elf.ST_TYPE(s.Info) {
+		case elf.STT_FUNC:
+			// Clear the Thumb bit.
+			cs.funcs = append(cs.funcs, elfFunc{
+				name: s.Name,
+				addr: uint32(s.Value) &^ 1,
+				size: uint32(s.Size),
+			})
+		case elf.STT_OBJECT:
+			cs.objects[s.Name] = uint32(s.Value)
+		}
+	}
+	sort.Sort(elfFuncSorter{cs.funcs})
+
+	return cs, nil
+}
+
+// Returns "func+0xoff" for the function containing the address, or "" if
+// there is none.
+func (cs *coredumpSyms) symbolize(addr uint32) string {
+	addr &^= 1
+
+	i := sort.Search(len(cs.funcs), func(i int) bool {
+		return cs.funcs[i].addr > addr
+	})
+	if i == 0 {
+		return ""
+	}
+
+	fn := cs.funcs[i-1]
+	if addr >= fn.addr+fn.size && fn.size != 0 {
+		return ""
+	}
+	if addr == fn.addr {
+		return fn.name
+	}
+	return fmt.Sprintf("%s+0x%x", fn.name, addr-fn.addr)
+}
+
+// Determines the flash area holding the core dump.
+func (t *TargetBuilder) coredumpArea() (flash.FlashArea, error) {
+	name := COREDUMP_DEFAULT_AREA
+	if entry, ok := t.res.Cfg.Settings["COREDUMP_FLASH_AREA"]; ok &&
+		entry.Value != "" {
+
+		name = entry.Value
+	}
+
+	area, ok := t.bspPkg.FlashMap.Areas[name]
+	if !ok {
+		return flash.FlashArea{}, util.FmtNewtError(
+			"BSP %s has no flash area %s for the core dump",
+			t.bspPkg.FullName(), name)
+	}
+
+	return area, nil
+}
+
+// Reads the core dump's flash area through the debug probe.
+func (t *TargetBuilder) fetchCoredumpProbe(rawPath string) error {
+	probe, err := t.selectedProbe()
+	if err != nil {
+		return err
+	}
+	if probe == nil {
+		return util.NewNewtError("Fetching a core dump requires a probe " +
+			"backend (--jtag or the BSP's bsp.probe_default) or " +
+			"--method serial")
+	}
+
+	area, err := t.coredumpArea()
+	if err != nil {
+		return err
+	}
+	addr := t.bspPkg.FlashMap.AreaAddress(area)
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Reading core dump from %s (0x%x, %d bytes)\n", area.Name, addr,
+		area.Size)
+
+	data, err := probeReadMem(probe, []memRange{{uint32(addr), area.Size}})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(rawPath, data[0], 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+func (t *TargetBuilder) fetchCoredumpMcumgr(rawPath string) error {
+	cmd := t.mcumgrCmd(*t.serialLoad, "image", "coredownload", rawPath)
+
+	if err := lookPathCmd(SERIAL_PROTO_MCUMGR, cmd); err != nil {
+		return err
+	}
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Downloading core dump over %s\n", t.serialLoad.Port)
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "%s\n",
+		strings.Join(cmd, " "))
+	if _, err := util.ShellCommand(cmd, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Retrieves the core dump stored on the device, through mcumgr if serial
+// loading is selected (see SetSerialLoad()) or the debug probe otherwise.
+// The dump is written to rawPath, or next to the app's executable if rawPath
+// is empty; returns the path written.
+func (t *TargetBuilder) FetchCoredump(rawPath string) (string, error) {
+	if err := t.PrepBuild(); err != nil {
+		return "", err
+	}
+
+	if rawPath == "" {
+		rawPath = t.AppBuilder.AppBinBasePath() + ".core"
+	}
+
+	var err error
+	if t.serialLoad != nil {
+		err = t.fetchCoredumpMcumgr(rawPath)
+	} else {
+		err = t.fetchCoredumpProbe(rawPath)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return rawPath, nil
+}
+
+// Decodes a raw core dump against the target's build: converts it to an ELF
+// core file at corePath and summarizes the fault.
+func (t *TargetBuilder) DecodeCoredump(rawPath string,
+	corePath string) (*CoredumpSummary, error) {
+
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(rawPath)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	cd, err := coredump.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(corePath)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	err = cd.WriteElfCore(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	elfPath := t.AppBuilder.AppElfPath()
+	summary := &CoredumpSummary{
+		RawPath:   rawPath,
+		CorePath:  corePath,
+		ElfPath:   elfPath,
+		ImageHash: hex.EncodeToString(cd.ImageHash),
+	}
+
+	if summary.ImageHash != "" {
+		hash, err := manifestImageHash(t.AppBuilder.ManifestPath())
+		if err == nil && !strings.HasPrefix(hash, summary.ImageHash) {
+			summary.BuildMismatch = true
+		}
+	}
+
+	for i, val := range cd.Regs {
+		name := fmt.Sprintf("r%d", i)
+		if i < len(coredump.RegNames) {
+			name = coredump.RegNames[i]
+		}
+		summary.Regs = append(summary.Regs, CoredumpReg{name, val})
+	}
+	if xpsr, ok := cd.Reg(coredump.REG_XPSR); ok {
+		summary.Exception = coredump.ExceptionName(xpsr)
+	}
+	summary.Pc, _ = cd.Reg(coredump.REG_PC)
+	summary.Lr, _ = cd.Reg(coredump.REG_LR)
+
+	syms, err := readCoredumpSyms(elfPath)
+	if err != nil {
+		return nil, err
+	}
+	summary.PcSymbol = syms.symbolize(summary.Pc)
+	summary.LrSymbol = syms.symbolize(summary.Lr)
+
+	if addr, ok := syms.objects[COREDUMP_CUR_TASK_SYM]; ok {
+		summary.Task = coredumpTask(cd, syms.image, addr)
+	}
+
+	return summary, nil
+}
+
+// Reads the running task's identity from the captured memory; returns nil if
+// it was not captured.  The task name may also be in the executable image.
+func coredumpTask(cd *coredump.Coredump, image *coredump.Coredump,
+	curTaskSym uint32) *CoredumpTask {
+
+	task, ok := cd.ReadUint32(curTaskSym)
+	if !ok || task == 0 {
+		return nil
+	}
+
+	fields, ok := cd.ReadMem(task, COREDUMP_TASK_NAME_OFF+4)
+	if !ok {
+		return nil
+	}
+
+	ct := &CoredumpTask{
+		Id:   int(fields[COREDUMP_TASK_ID_OFF]),
+		Prio: int(fields[COREDUMP_TASK_PRIO_OFF]),
+	}
+	namePtr := binary.LittleEndian.Uint32(fields[COREDUMP_TASK_NAME_OFF:])
+	if name, ok := cd.ReadString(namePtr, 32); ok {
+		ct.Name = name
+	} else if name, ok := image.ReadString(namePtr, 32); ok {
+		ct.Name = name
+	}
+
+	return ct
+}
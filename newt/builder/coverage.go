@@ -0,0 +1,350 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+const COVERAGE_DIR_NAME = "coverage"
+const COVERAGE_INFO_FILENAME = "coverage.info"
+const COVERAGE_HTML_FILENAME = "index.html"
+
+// Line execution counts of a single source file.
+type FileCoverage struct {
+	Path  string
+	Lines map[int]int64
+}
+
+func (fc *FileCoverage) LinesFound() int {
+	return len(fc.Lines)
+}
+
+func (fc *FileCoverage) LinesHit() int {
+	hit := 0
+	for _, count := range fc.Lines {
+		if count > 0 {
+			hit++
+		}
+	}
+	return hit
+}
+
+// Coverage data gathered from a test run, keyed by absolute source path.
+type CoverageReport struct {
+	Files map[string]*FileCoverage
+}
+
+func (cr *CoverageReport) SortedFiles() []*FileCoverage {
+	paths := make([]string, 0, len(cr.Files))
+	for path, _ := range cr.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	files := make([]*FileCoverage, len(paths))
+	for i, path := range paths {
+		files[i] = cr.Files[path]
+	}
+	return files
+}
+
+func (cr *CoverageReport) LinesFound() int {
+	found := 0
+	for _, fc := range cr.Files {
+		found += fc.LinesFound()
+	}
+	return found
+}
+
+func (cr *CoverageReport) LinesHit() int {
+	hit := 0
+	for _, fc := range cr.Files {
+		hit += fc.LinesHit()
+	}
+	return hit
+}
+
+// Returns the percentage of instrumented lines that were executed.  A report
+// with no instrumented lines is considered fully covered.
+func (cr *CoverageReport) Percent() float64 {
+	found := cr.LinesFound()
+	if found == 0 {
+		return 100
+	}
+	return 100 * float64(cr.LinesHit()) / float64(found)
+}
+
+// Instructs the target builder to instrument all compiled code for gcov.
+func (t *TargetBuilder) EnableCoverage() {
+	t.coverage = true
+}
+
+func coverageCompilerInfo() *toolchain.CompilerInfo {
+	ci := toolchain.NewCompilerInfo()
+	ci.Cflags = []string{"--coverage"}
+	ci.Lflags = []string{"--coverage"}
+	return ci
+}
+
+func walkGcda(dir string, fn func(path string) error) error {
+	return filepath.Walk(dir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if !info.IsDir() && strings.HasSuffix(path, ".gcda") {
+				return fn(path)
+			}
+			return nil
+		})
+}
+
+// Deletes the execution counts left in the specified build directory by a
+// previous run.
+func ResetCoverage(binDir string) error {
+	return walkGcda(binDir, func(path string) error {
+		if err := os.Remove(path); err != nil {
+			return util.ChildNewtError(err)
+		}
+		return nil
+	})
+}
+
+// Subset of the JSON document emitted by "gcov --json-format".
+type gcovJson struct {
+	Cwd   string `json:"current_working_directory"`
+	Files []struct {
+		File  string `json:"file"`
+		Lines []struct {
+			LineNumber int   `json:"line_number"`
+			Count      int64 `json:"count"`
+		} `json:"lines"`
+	} `json:"files"`
+}
+
+func (cr *CoverageReport) addGcov(gj *gcovJson, srcDir string) {
+	for _, f := range gj.Files {
+		path := f.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(gj.Cwd, path)
+		}
+		path = filepath.Clean(path)
+
+		if !strings.HasPrefix(path, srcDir+string(filepath.Separator)) {
+			continue
+		}
+
+		fc := cr.Files[path]
+		if fc == nil {
+			fc = &FileCoverage{Path: path, Lines: map[int]int64{}}
+			cr.Files[path] = fc
+		}
+		for _, l := range f.Lines {
+			fc.Lines[l.LineNumber] += l.Count
+		}
+	}
+}
+
+// Runs gcov on every data file in the specified build directory and merges
+// the results.  Only source files located under srcDir are included.
+func CollectCoverage(gcovPath string, binDir string,
+	srcDir string) (*CoverageReport, error) {
+
+	cr := &CoverageReport{Files: map[string]*FileCoverage{}}
+	srcDir = filepath.Clean(srcDir)
+
+	err := walkGcda(binDir, func(path string) error {
+		cmd := exec.Command(gcovPath, "--json-format", "--stdout",
+			filepath.Base(path))
+		cmd.Dir = filepath.Dir(path)
+
+		log.Debugf("%s %s", strings.Join(cmd.Args, " "), cmd.Dir)
+		o, err := cmd.Output()
+		if err != nil {
+			return util.FmtNewtError("gcov failed on %s: %s", path,
+				err.Error())
+		}
+
+		// gcov emits one JSON document per line.
+		scanner := bufio.NewScanner(bytes.NewReader(o))
+		scanner.Buffer(nil, 64*1024*1024)
+		for scanner.Scan() {
+			gj := &gcovJson{}
+			if err := json.Unmarshal(scanner.Bytes(), gj); err != nil {
+				return util.FmtNewtError(
+					"Failure parsing gcov output for %s: %s", path,
+					err.Error())
+			}
+			cr.addGcov(gj, srcDir)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// Writes the report as an lcov tracefile.
+func (cr *CoverageReport) WriteLcov(path string, testName string) error {
+	buf := &bytes.Buffer{}
+
+	for _, fc := range cr.SortedFiles() {
+		fmt.Fprintf(buf, "TN:%s\n", testName)
+		fmt.Fprintf(buf, "SF:%s\n", fc.Path)
+
+		lines := make([]int, 0, len(fc.Lines))
+		for line, _ := range fc.Lines {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+		for _, line := range lines {
+			fmt.Fprintf(buf, "DA:%d,%d\n", line, fc.Lines[line])
+		}
+
+		fmt.Fprintf(buf, "LF:%d\n", fc.LinesFound())
+		fmt.Fprintf(buf, "LH:%d\n", fc.LinesHit())
+		fmt.Fprintf(buf, "end_of_record\n")
+	}
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+type coverageHtmlLine struct {
+	Number int
+	Text   string
+	Class  string
+	Count  string
+}
+
+type coverageHtmlFile struct {
+	Path    string
+	Found   int
+	Hit     int
+	Percent string
+	Lines   []coverageHtmlLine
+}
+
+var coverageHtmlTmpl = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage: {{.Name}}</title>
+<style>
+body { font-family: sans-serif; }
+table.summary td, table.summary th { padding: 2px 12px; text-align: left; }
+pre { margin: 0; }
+.hit { background: #d0f0d0; }
+.miss { background: #f0d0d0; }
+.count { color: #808080; text-align: right; padding-right: 8px; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}: {{.Percent}}% ({{.Hit}} / {{.Found}} lines)</h1>
+<table class="summary">
+<tr><th>File</th><th>Lines</th><th>Coverage</th></tr>
+{{range $i, $f := .Files}}<tr><td><a href="#f{{$i}}">{{$f.Path}}</a></td><td>{{$f.Hit}} / {{$f.Found}}</td><td>{{$f.Percent}}%</td></tr>
+{{end}}</table>
+{{range $i, $f := .Files}}<h2 id="f{{$i}}">{{$f.Path}}</h2>
+<table>
+{{range $f.Lines}}<tr class="{{.Class}}"><td class="count">{{.Number}}</td><td class="count">{{.Count}}</td><td><pre>{{.Text}}</pre></td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+func percentString(hit int, found int) string {
+	if found == 0 {
+		return "100.0"
+	}
+	return fmt.Sprintf("%.1f", 100*float64(hit)/float64(found))
+}
+
+// Writes the report as a single HTML page containing a per-file summary
+// followed by annotated source listings.
+func (cr *CoverageReport) WriteHtml(path string, name string) error {
+	files := []coverageHtmlFile{}
+	for _, fc := range cr.SortedFiles() {
+		hf := coverageHtmlFile{
+			Path:    fc.Path,
+			Found:   fc.LinesFound(),
+			Hit:     fc.LinesHit(),
+			Percent: percentString(fc.LinesHit(), fc.LinesFound()),
+		}
+
+		src, err := ioutil.ReadFile(fc.Path)
+		if err != nil {
+			log.Debugf("Can't read %s: %s", fc.Path, err.Error())
+		}
+		for i, text := range strings.Split(string(src), "\n") {
+			hl := coverageHtmlLine{Number: i + 1, Text: text}
+			if count, ok := fc.Lines[i+1]; ok {
+				hl.Count = fmt.Sprintf("%d", count)
+				if count > 0 {
+					hl.Class = "hit"
+				} else {
+					hl.Class = "miss"
+				}
+			}
+			hf.Lines = append(hf.Lines, hl)
+		}
+
+		files = append(files, hf)
+	}
+
+	buf := &bytes.Buffer{}
+	err := coverageHtmlTmpl.Execute(buf, map[string]interface{}{
+		"Name":    name,
+		"Found":   cr.LinesFound(),
+		"Hit":     cr.LinesHit(),
+		"Percent": percentString(cr.LinesHit(), cr.LinesFound()),
+		"Files":   files,
+	})
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
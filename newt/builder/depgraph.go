@@ -22,7 +22,9 @@ package builder
 import (
 	"bytes"
 	"fmt"
+	"strings"
 
+	"mynewt.apache.org/newt/newt/pkg"
 	"mynewt.apache.org/newt/newt/resolve"
 )
 
@@ -172,3 +174,227 @@ func FilterDepGraph(dg DepGraph, parents []*resolve.ResolvePackage) (
 
 	return newDg, missing
 }
+
+// Describes a single dependency edge in the JSON form of a dependency graph.
+type DepGraphEdge struct {
+	From     string   `json:"from"`
+	To       string   `json:"to"`
+	Api      string   `json:"api,omitempty"`
+	Settings []string `json:"settings,omitempty"`
+}
+
+type DepGraphNode struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Seed bool   `json:"seed"`
+}
+
+type DepGraphJson struct {
+	Target   string         `json:"target"`
+	Packages []DepGraphNode `json:"packages"`
+	Deps     []DepGraphEdge `json:"deps"`
+}
+
+func depEdge(parent *resolve.ResolvePackage,
+	dep *resolve.ResolveDep) DepGraphEdge {
+
+	return DepGraphEdge{
+		From:     parent.Lpkg.FullName(),
+		To:       dep.Rpkg.Lpkg.FullName(),
+		Api:      dep.Api,
+		Settings: dep.Settings,
+	}
+}
+
+func sortedParents(graph DepGraph) []*resolve.ResolvePackage {
+	parents := make([]*resolve.ResolvePackage, 0, len(graph))
+	for rpkg, _ := range graph {
+		parents = append(parents, rpkg)
+	}
+
+	return resolve.SortResolvePkgs(parents)
+}
+
+func isSeed(seeds []*resolve.ResolvePackage, rpkg *resolve.ResolvePackage) bool {
+	for _, s := range seeds {
+		if s == rpkg {
+			return true
+		}
+	}
+
+	return false
+}
+
+func DepGraphToJson(graph DepGraph, targetName string,
+	seeds []*resolve.ResolvePackage) DepGraphJson {
+
+	dj := DepGraphJson{
+		Target:   targetName,
+		Packages: []DepGraphNode{},
+		Deps:     []DepGraphEdge{},
+	}
+
+	for _, parent := range sortedParents(graph) {
+		dj.Packages = append(dj.Packages, DepGraphNode{
+			Name: parent.Lpkg.FullName(),
+			Type: pkg.PackageTypeNames[parent.Lpkg.Type()],
+			Seed: isSeed(seeds, parent),
+		})
+
+		for _, dep := range resolve.SortResolveDeps(graph[parent]) {
+			dj.Deps = append(dj.Deps, depEdge(parent, dep))
+		}
+	}
+
+	return dj
+}
+
+// Produces a Graphviz DOT description of a dependency graph.  Seed packages
+// are drawn as boxes; API dependencies are labelled with the API name and
+// conditional dependencies are dashed and labelled with the enabling
+// settings.
+func DepGraphDot(graph DepGraph, targetName string,
+	seeds []*resolve.ResolvePackage) string {
+
+	buffer := bytes.NewBufferString("")
+
+	fmt.Fprintf(buffer, "digraph %q {\n", targetName)
+	fmt.Fprintf(buffer, "    rankdir=LR;\n")
+	fmt.Fprintf(buffer, "    node [shape=ellipse];\n")
+
+	parents := sortedParents(graph)
+	for _, parent := range parents {
+		if isSeed(seeds, parent) {
+			fmt.Fprintf(buffer, "    %q [shape=box];\n",
+				parent.Lpkg.FullName())
+		}
+	}
+
+	for _, parent := range parents {
+		for _, dep := range resolve.SortResolveDeps(graph[parent]) {
+			var attrs []string
+			var labels []string
+
+			if dep.Api != "" {
+				labels = append(labels, "api:"+dep.Api)
+				attrs = append(attrs, "color=blue")
+			}
+			if len(dep.Settings) > 0 {
+				labels = append(labels, strings.Join(dep.Settings, "|"))
+				attrs = append(attrs, "style=dashed")
+			}
+			if len(labels) > 0 {
+				attrs = append(attrs,
+					fmt.Sprintf("label=%q", strings.Join(labels, " ")))
+			}
+
+			fmt.Fprintf(buffer, "    %q -> %q", parent.Lpkg.FullName(),
+				dep.Rpkg.Lpkg.FullName())
+			if len(attrs) > 0 {
+				fmt.Fprintf(buffer, " [%s]", strings.Join(attrs, ", "))
+			}
+			fmt.Fprintf(buffer, ";\n")
+		}
+	}
+
+	fmt.Fprintf(buffer, "}\n")
+
+	return buffer.String()
+}
+
+// A sequence of dependencies leading from a seed package to some other
+// package.
+type DepChain struct {
+	Seed *resolve.ResolvePackage
+	Deps []*resolve.ResolveDep
+}
+
+// Finds every acyclic dependency chain from one of the seed packages to the
+// specified package.  At most max chains are returned (0 means no limit); the
+// second return value indicates whether the search stopped early.
+func DepChains(graph DepGraph, seeds []*resolve.ResolvePackage,
+	dst *resolve.ResolvePackage, max int) ([]DepChain, bool) {
+
+	// Only descend into packages that can reach the destination.
+	revGraph := map[*resolve.ResolvePackage][]*resolve.ResolvePackage{}
+	for parent, deps := range graph {
+		for _, dep := range deps {
+			revGraph[dep.Rpkg] = append(revGraph[dep.Rpkg], parent)
+		}
+	}
+
+	reaches := map[*resolve.ResolvePackage]bool{dst: true}
+	queue := []*resolve.ResolvePackage{dst}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, parent := range revGraph[cur] {
+			if !reaches[parent] {
+				reaches[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+
+	var chains []DepChain
+	truncated := false
+
+	onPath := map[*resolve.ResolvePackage]bool{}
+	var path []*resolve.ResolveDep
+
+	var visit func(seed *resolve.ResolvePackage, cur *resolve.ResolvePackage)
+	visit = func(seed *resolve.ResolvePackage, cur *resolve.ResolvePackage) {
+		if truncated {
+			return
+		}
+
+		if cur == dst {
+			if max > 0 && len(chains) >= max {
+				truncated = true
+				return
+			}
+			chains = append(chains, DepChain{
+				Seed: seed,
+				Deps: append([]*resolve.ResolveDep{}, path...),
+			})
+			return
+		}
+
+		onPath[cur] = true
+		for _, dep := range resolve.SortResolveDeps(graph[cur]) {
+			if onPath[dep.Rpkg] || !reaches[dep.Rpkg] {
+				continue
+			}
+
+			path = append(path, dep)
+			visit(seed, dep.Rpkg)
+			path = path[:len(path)-1]
+		}
+		delete(onPath, cur)
+	}
+
+	for _, seed := range resolve.SortResolvePkgs(seeds) {
+		if reaches[seed] {
+			visit(seed, seed)
+		}
+	}
+
+	return chains, truncated
+}
+
+// Describes the reason a dependency exists, e.g., "(api:log)" or
+// "(if BLE_HOST)".
+func DepReason(dep *resolve.ResolveDep) string {
+	var reasons []string
+	if dep.Api != "" {
+		reasons = append(reasons, "api:"+dep.Api)
+	}
+	if len(dep.Settings) > 0 {
+		reasons = append(reasons, "if "+strings.Join(dep.Settings, "|"))
+	}
+
+	if len(reasons) == 0 {
+		return ""
+	}
+	return "(" + strings.Join(reasons, ", ") + ")"
+}
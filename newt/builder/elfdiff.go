@@ -0,0 +1,450 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"debug/elf"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+const (
+	ELF_DIFF_ADDED   = "added"
+	ELF_DIFF_REMOVED = "removed"
+	ELF_DIFF_CHANGED = "changed"
+	ELF_DIFF_MOVED   = "moved"
+)
+
+type ElfDiffOptions struct {
+	// Attribute symbols to packages using the linker map next to each elf
+	// file (<file>.map).
+	Packages bool
+
+	// Maximum number of changed symbols to report; negative means no
+	// limit.
+	MaxSyms int
+}
+
+type elfSym struct {
+	section string
+	size    uint64
+	isFunc  bool
+	pkg     string
+}
+
+type elfSection struct {
+	addr uint64
+	size uint64
+}
+
+type ElfSectionDelta struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	OldAddr uint64 `json:"old_addr"`
+	NewAddr uint64 `json:"new_addr"`
+	OldSize uint64 `json:"old_size"`
+	NewSize uint64 `json:"new_size"`
+	Delta   int64  `json:"delta"`
+}
+
+type ElfSymbolDelta struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Func       bool   `json:"func"`
+	Section    string `json:"section"`
+	OldPackage string `json:"old_package,omitempty"`
+	NewPackage string `json:"new_package,omitempty"`
+	Old        int64  `json:"old"`
+	New        int64  `json:"new"`
+	Delta      int64  `json:"delta"`
+}
+
+type ElfPkgDelta struct {
+	Name  string `json:"name"`
+	Old   int64  `json:"old"`
+	New   int64  `json:"new"`
+	Delta int64  `json:"delta"`
+}
+
+type ElfDiff struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+
+	// Total size of the allocated sections.
+	OldSize int64 `json:"old_size"`
+	NewSize int64 `json:"new_size"`
+
+	// Allocated sections that were added, removed, resized or relocated.
+	Sections []ElfSectionDelta `json:"sections"`
+
+	// Symbols that were added, removed, resized or moved between packages,
+	// largest change first.
+	Symbols []ElfSymbolDelta `json:"symbols"`
+
+	// Number of added and removed functions, including those beyond
+	// MaxSyms.
+	FuncsAdded   int `json:"funcs_added"`
+	FuncsRemoved int `json:"funcs_removed"`
+
+	// Packages whose size changed, largest change first; only with
+	// ElfDiffOptions.Packages.
+	Packages []ElfPkgDelta `json:"packages,omitempty"`
+}
+
+// Derives a package name from the path of an archive in a linker map.  newt
+// places a package's archive in bin/<target>/<build>/<package>/; other
+// archives are named by their file name.
+func elfArchivePkgName(arName string) string {
+	dir := filepath.ToSlash(filepath.Dir(arName))
+	comps := strings.Split(dir, "/")
+	for i := len(comps) - 1; i > 0; i-- {
+		if comps[i] != BUILD_NAME_APP && comps[i] != BUILD_NAME_LOADER {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			if comps[j] == "bin" && i+1 < len(comps) {
+				return strings.Join(comps[i+1:], "/")
+			}
+		}
+	}
+
+	return filepath.Base(arName)
+}
+
+// Reads the symbol-to-package mapping from the linker map next to an elf
+// file.
+func elfSymPkgs(elfPath string) (map[string]string, error) {
+	mapPath := elfPath + ".map"
+	if util.NodeNotExist(mapPath) {
+		return nil, util.FmtNewtError(
+			"No linker map for %s; expected %s", elfPath, mapPath)
+	}
+
+	libs, err := ParseMapFileSizes(mapPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := map[string]string{}
+	for _, lib := range libs {
+		name := elfArchivePkgName(lib.Name)
+		for _, sym := range lib.Syms {
+			pkgs[sym.Name] = name
+		}
+	}
+
+	return pkgs, nil
+}
+
+// Reads an elf file's allocated sections and sized function and object
+// symbols.  Symbols with the same name (e.g., file-local statics) are
+// combined.
+func readElfSyms(path string) (map[string]*elfSym,
+	map[string]elfSection, error) {
+
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, nil, util.FmtNewtError("Can't open %s: %s", path,
+			err.Error())
+	}
+	defer f.Close()
+
+	sections := map[string]elfSection{}
+	for _, s := range f.Sections {
+		if s.Flags&elf.SHF_ALLOC != 0 && s.Size != 0 {
+			sections[s.Name] = elfSection{addr: s.Addr, size: s.Size}
+		}
+	}
+
+	esyms, err := f.Symbols()
+	if err != nil {
+		return nil, nil, util.FmtNewtError("Can't read symbols of %s: %s",
+			path, err.Error())
+	}
+
+	syms := map[string]*elfSym{}
+	for _, es := range esyms {
+		typ := elf.ST_TYPE(es.Info)
+		if typ != elf.STT_FUNC && typ != elf.STT_OBJECT {
+			continue
+		}
+		if es.Size == 0 || es.Section == elf.SHN_UNDEF ||
+			es.Section >= elf.SHN_LORESERVE ||
+			int(es.Section) >= len(f.Sections) {
+
+			continue
+		}
+
+		sym := syms[es.Name]
+		if sym == nil {
+			sym = &elfSym{
+				section: f.Sections[es.Section].Name,
+				isFunc:  typ == elf.STT_FUNC,
+			}
+			syms[es.Name] = sym
+		}
+		sym.size += es.Size
+	}
+
+	return syms, sections, nil
+}
+
+type sectionDeltaSorter struct {
+	deltas []ElfSectionDelta
+}
+
+func (s sectionDeltaSorter) Len() int {
+	return len(s.deltas)
+}
+func (s sectionDeltaSorter) Swap(i, j int) {
+	s.deltas[i], s.deltas[j] = s.deltas[j], s.deltas[i]
+}
+func (s sectionDeltaSorter) Less(i, j int) bool {
+	a, b := s.deltas[i], s.deltas[j]
+	aAddr, bAddr := a.NewAddr, b.NewAddr
+	if a.Status == ELF_DIFF_REMOVED {
+		aAddr = a.OldAddr
+	}
+	if b.Status == ELF_DIFF_REMOVED {
+		bAddr = b.OldAddr
+	}
+	if aAddr != bAddr {
+		return aAddr < bAddr
+	}
+	return a.Name < b.Name
+}
+
+func diffElfSections(old map[string]elfSection,
+	cur map[string]elfSection) []ElfSectionDelta {
+
+	names := map[string]bool{}
+	for name, _ := range old {
+		names[name] = true
+	}
+	for name, _ := range cur {
+		names[name] = true
+	}
+
+	deltas := []ElfSectionDelta{}
+	for name, _ := range names {
+		o, inOld := old[name]
+		n, inNew := cur[name]
+
+		d := ElfSectionDelta{
+			Name:    name,
+			OldAddr: o.addr,
+			NewAddr: n.addr,
+			OldSize: o.size,
+			NewSize: n.size,
+			Delta:   int64(n.size) - int64(o.size),
+		}
+		switch {
+		case !inOld:
+			d.Status = ELF_DIFF_ADDED
+		case !inNew:
+			d.Status = ELF_DIFF_REMOVED
+		case o != n:
+			d.Status = ELF_DIFF_CHANGED
+		default:
+			continue
+		}
+		deltas = append(deltas, d)
+	}
+
+	sort.Sort(sectionDeltaSorter{deltas})
+
+	return deltas
+}
+
+type pkgDeltaSorter struct {
+	deltas []ElfPkgDelta
+}
+
+func (s pkgDeltaSorter) Len() int {
+	return len(s.deltas)
+}
+func (s pkgDeltaSorter) Swap(i, j int) {
+	s.deltas[i], s.deltas[j] = s.deltas[j], s.deltas[i]
+}
+func (s pkgDeltaSorter) Less(i, j int) bool {
+	a, b := s.deltas[i], s.deltas[j]
+	if absDelta(a.Delta) != absDelta(b.Delta) {
+		return absDelta(a.Delta) > absDelta(b.Delta)
+	}
+	return a.Name < b.Name
+}
+
+func diffElfPkgs(old map[string]*elfSym,
+	cur map[string]*elfSym) []ElfPkgDelta {
+
+	totals := map[string]*ElfPkgDelta{}
+	get := func(name string) *ElfPkgDelta {
+		if totals[name] == nil {
+			totals[name] = &ElfPkgDelta{Name: name}
+		}
+		return totals[name]
+	}
+
+	for _, sym := range old {
+		get(sym.pkg).Old += int64(sym.size)
+	}
+	for _, sym := range cur {
+		get(sym.pkg).New += int64(sym.size)
+	}
+
+	deltas := []ElfPkgDelta{}
+	for _, d := range totals {
+		d.Delta = d.New - d.Old
+		if d.Delta != 0 {
+			deltas = append(deltas, *d)
+		}
+	}
+
+	sort.Sort(pkgDeltaSorter{deltas})
+
+	return deltas
+}
+
+type symbolDeltaSorter struct {
+	deltas []ElfSymbolDelta
+}
+
+func (s symbolDeltaSorter) Len() int {
+	return len(s.deltas)
+}
+func (s symbolDeltaSorter) Swap(i, j int) {
+	s.deltas[i], s.deltas[j] = s.deltas[j], s.deltas[i]
+}
+func (s symbolDeltaSorter) Less(i, j int) bool {
+	a, b := s.deltas[i], s.deltas[j]
+	if absDelta(a.Delta) != absDelta(b.Delta) {
+		return absDelta(a.Delta) > absDelta(b.Delta)
+	}
+	return a.Name < b.Name
+}
+
+// Compares two elf files by their section layout and symbol sizes.  The
+// files need not belong to a target of the current project.
+func DiffElfs(oldPath string, newPath string,
+	opts ElfDiffOptions) (*ElfDiff, error) {
+
+	old, oldSecs, err := readElfSyms(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	cur, curSecs, err := readElfSyms(newPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Packages {
+		for path, syms := range map[string]map[string]*elfSym{
+			oldPath: old,
+			newPath: cur,
+		} {
+			pkgs, err := elfSymPkgs(path)
+			if err != nil {
+				return nil, err
+			}
+			for name, sym := range syms {
+				sym.pkg = pkgs[name]
+				if sym.pkg == "" {
+					sym.pkg = "(unknown)"
+				}
+			}
+		}
+	}
+
+	diff := &ElfDiff{
+		Old:      oldPath,
+		New:      newPath,
+		Sections: diffElfSections(oldSecs, curSecs),
+		Symbols:  []ElfSymbolDelta{},
+	}
+	for _, s := range oldSecs {
+		diff.OldSize += int64(s.size)
+	}
+	for _, s := range curSecs {
+		diff.NewSize += int64(s.size)
+	}
+
+	names := map[string]bool{}
+	for name, _ := range old {
+		names[name] = true
+	}
+	for name, _ := range cur {
+		names[name] = true
+	}
+
+	for name, _ := range names {
+		o := old[name]
+		n := cur[name]
+
+		d := ElfSymbolDelta{Name: name}
+		switch {
+		case o == nil:
+			d.Status = ELF_DIFF_ADDED
+			if n.isFunc {
+				diff.FuncsAdded++
+			}
+		case n == nil:
+			d.Status = ELF_DIFF_REMOVED
+			if o.isFunc {
+				diff.FuncsRemoved++
+			}
+		case o.pkg != n.pkg:
+			d.Status = ELF_DIFF_MOVED
+		case o.size != n.size:
+			d.Status = ELF_DIFF_CHANGED
+		default:
+			continue
+		}
+
+		if o != nil {
+			d.Func = o.isFunc
+			d.Section = o.section
+			d.OldPackage = o.pkg
+			d.Old = int64(o.size)
+		}
+		if n != nil {
+			d.Func = n.isFunc
+			d.Section = n.section
+			d.NewPackage = n.pkg
+			d.New = int64(n.size)
+		}
+		d.Delta = d.New - d.Old
+
+		diff.Symbols = append(diff.Symbols, d)
+	}
+
+	sort.Sort(symbolDeltaSorter{diff.Symbols})
+	if opts.MaxSyms >= 0 && len(diff.Symbols) > opts.MaxSyms {
+		diff.Symbols = diff.Symbols[:opts.MaxSyms]
+	}
+
+	if opts.Packages {
+		diff.Packages = diffElfPkgs(old, cur)
+	}
+
+	return diff, nil
+}
@@ -0,0 +1,277 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+// Describes the output of "newt emit-headers"; written to the output
+// directory as EMIT_CONFIG_FILENAME.
+const EMIT_CONFIG_FILENAME = "newt-config.json"
+
+// The include paths and defines one package is compiled with.  Only the
+// defines that not all packages share are listed.
+type EmitPkgConfig struct {
+	Name        string   `json:"name"`
+	IncludeDirs []string `json:"include_dirs"`
+	Defines     []string `json:"defines"`
+}
+
+// The configuration of a target, for use by other build systems.  All paths
+// are absolute; generated files are referred to by their copies in the output
+// directory.
+type EmitConfig struct {
+	Target string `json:"target"`
+	App    string `json:"app,omitempty"`
+	Bsp    string `json:"bsp"`
+
+	// Generated files, relative to the output directory.
+	Files []string `json:"files"`
+
+	// The union of all packages' include paths, and the defines common to
+	// all packages.
+	IncludeDirs []string `json:"include_dirs"`
+	Defines     []string `json:"defines"`
+
+	Packages []EmitPkgConfig `json:"packages"`
+}
+
+// Returns the default directory that a target's headers are emitted to.
+func (t *TargetBuilder) EmitHeadersDir() string {
+	return TargetBinDir(t.target.Name()) + "/headers"
+}
+
+// Maps a path used by the build to an absolute path, redirecting generated
+// files to their copies in the output directory.
+func emitPath(path string, projDir string, genDir string,
+	outDir string) string {
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(projDir, path)
+	}
+	path = filepath.Clean(path)
+
+	if path == genDir {
+		return outDir
+	}
+	if strings.HasPrefix(path, genDir+string(filepath.Separator)) {
+		return filepath.Join(outDir, strings.TrimPrefix(path, genDir))
+	}
+
+	return path
+}
+
+// Extracts the include paths and defines from a list of compiler flags.
+func emitFlags(flags []string) ([]string, []string) {
+	incs := []string{}
+	defs := []string{}
+
+	for i := 0; i < len(flags); i++ {
+		f := flags[i]
+		switch {
+		case (f == "-I" || f == "-D") && i+1 < len(flags):
+			i++
+			if f == "-I" {
+				incs = append(incs, flags[i])
+			} else {
+				defs = append(defs, flags[i])
+			}
+		case strings.HasPrefix(f, "-I"):
+			incs = append(incs, f[2:])
+		case strings.HasPrefix(f, "-D"):
+			defs = append(defs, f[2:])
+		}
+	}
+
+	return incs, defs
+}
+
+// Copies the target's generated sources, headers and linker scripts into
+// outDir.  Compiled objects are left behind.
+func (t *TargetBuilder) copyGenerated(outDir string) ([]string, error) {
+	genDir := GeneratedBaseDir(t.target.Name())
+	skip := GeneratedBinDir(t.target.Name())
+
+	files := []string{}
+	err := filepath.Walk(genDir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return util.ChildNewtError(err)
+			}
+			if info.IsDir() {
+				if path == skip {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			rel, _ := filepath.Rel(genDir, path)
+			if err := util.CopyFile(path,
+				filepath.Join(outDir, rel)); err != nil {
+
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// Resolves the target and generates its configuration (syscfg.h, sysinit,
+// flash map sources, GATT tables and linker scripts) without compiling
+// anything.  The generated files are copied to outDir along with a JSON
+// description of the include paths and defines each package is compiled
+// with.  An existing directory is only replaced if newt created it.
+func (t *TargetBuilder) EmitHeaders(outDir string) (*EmitConfig, error) {
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	outDir, err := filepath.Abs(outDir)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	projDir := project.GetProject().Path()
+	genDir, err := filepath.Abs(GeneratedBaseDir(t.target.Name()))
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	if err := prepareExportDir(outDir, t.target.FullName()); err != nil {
+		return nil, err
+	}
+
+	cfg := &EmitConfig{
+		Target:      t.target.FullName(),
+		Bsp:         t.bspPkg.FullName(),
+		IncludeDirs: []string{},
+		Defines:     []string{},
+		Packages:    []EmitPkgConfig{},
+	}
+	if t.appPkg != nil {
+		cfg.App = t.appPkg.FullName()
+	}
+
+	cfg.Files, err = t.copyGenerated(outDir)
+	if err != nil {
+		return nil, err
+	}
+
+	units, _, err := t.AppBuilder.exportUnits()
+	if err != nil {
+		return nil, err
+	}
+
+	incSeen := map[string]bool{}
+	defCounts := map[string]int{}
+	pkgs := map[string]*EmitPkgConfig{}
+	pkgNames := []string{}
+
+	// Flags already listed for a package, keyed by package name and flag.
+	pkgSeen := map[string]bool{}
+	addOnce := func(list []string, pkgName string, kind string,
+		val string) []string {
+
+		key := pkgName + "\x00" + kind + "\x00" + val
+		if pkgSeen[key] {
+			return list
+		}
+		pkgSeen[key] = true
+		return append(list, val)
+	}
+
+	for _, u := range units {
+		if u.compType == toolchain.COMPILER_TYPE_ASM {
+			continue
+		}
+
+		pc := pkgs[u.pkgName]
+		if pc == nil {
+			pc = &EmitPkgConfig{
+				Name:        u.pkgName,
+				IncludeDirs: []string{},
+				Defines:     []string{},
+			}
+			pkgs[u.pkgName] = pc
+			pkgNames = append(pkgNames, u.pkgName)
+		}
+
+		incs, defs := emitFlags(u.flags)
+		for _, inc := range incs {
+			inc = emitPath(inc, projDir, genDir, outDir)
+			pc.IncludeDirs = addOnce(pc.IncludeDirs, u.pkgName, "I", inc)
+			if !incSeen[inc] {
+				incSeen[inc] = true
+				cfg.IncludeDirs = append(cfg.IncludeDirs, inc)
+			}
+		}
+		for _, def := range defs {
+			pc.Defines = addOnce(pc.Defines, u.pkgName, "D", def)
+		}
+	}
+
+	for _, pc := range pkgs {
+		for _, def := range pc.Defines {
+			defCounts[def]++
+		}
+	}
+	for def, count := range defCounts {
+		if count == len(pkgs) {
+			cfg.Defines = append(cfg.Defines, def)
+		}
+	}
+	sort.Strings(cfg.Defines)
+
+	sort.Strings(pkgNames)
+	for _, name := range pkgNames {
+		pc := pkgs[name]
+
+		own := []string{}
+		for _, def := range pc.Defines {
+			if defCounts[def] != len(pkgs) {
+				own = append(own, def)
+			}
+		}
+		pc.Defines = own
+
+		cfg.Packages = append(cfg.Packages, *pc)
+	}
+
+	if err := writeJsonFile(filepath.Join(outDir, EMIT_CONFIG_FILENAME),
+		cfg); err != nil {
+
+		return nil, err
+	}
+
+	return cfg, nil
+}
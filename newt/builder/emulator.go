@@ -0,0 +1,261 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+const EMULATOR_QEMU = "qemu"
+const EMULATOR_RENODE = "renode"
+
+const QEMU_DEFAULT_BINARY = "qemu-system-arm"
+const RENODE_DEFAULT_BINARY = "renode"
+const RENODE_DEFAULT_UART = "sysbus.uart0"
+
+func qemuCmd(emu *pkg.BspEmulator, elfPath string, gdbPort int,
+	semihosting bool) []string {
+
+	binary := emu.Binary
+	if binary == "" {
+		binary = QEMU_DEFAULT_BINARY
+	}
+
+	cmd := []string{binary, "-M", emu.Machine}
+	if emu.Cpu != "" {
+		cmd = append(cmd, "-cpu", emu.Cpu)
+	}
+	cmd = append(cmd, "-nographic", "-kernel", elfPath)
+	if semihosting {
+		// The image's semihosting exit call ends qemu with its exit code.
+		cmd = append(cmd, "-semihosting-config", "enable=on,target=native")
+	}
+	if gdbPort > 0 {
+		// Wait for the debugger to attach before starting the CPU.
+		cmd = append(cmd, "-gdb", "tcp::"+strconv.Itoa(gdbPort), "-S")
+	}
+
+	return append(cmd, emu.Args...)
+}
+
+func renodeCmd(emu *pkg.BspEmulator, elfPath string, gdbPort int) []string {
+	binary := emu.Binary
+	if binary == "" {
+		binary = RENODE_DEFAULT_BINARY
+	}
+
+	uart := emu.Uart
+	if uart == "" {
+		uart = RENODE_DEFAULT_UART
+	}
+
+	monitor := []string{}
+	if emu.Script != "" {
+		monitor = append(monitor, "$bin=@"+elfPath, "include @"+emu.Script)
+	} else {
+		monitor = append(monitor,
+			"mach create",
+			"machine LoadPlatformDescription @"+emu.Machine,
+			"sysbus LoadELF @"+elfPath)
+	}
+
+	// Without a GUI, the logging analyzer prints the UART's output to the
+	// terminal.
+	monitor = append(monitor, "showAnalyzer "+uart+
+		" Antmicro.Renode.Analyzers.LoggingUartAnalyzer")
+
+	if gdbPort > 0 {
+		monitor = append(monitor,
+			"machine StartGdbServer "+strconv.Itoa(gdbPort))
+	} else {
+		monitor = append(monitor, "start")
+	}
+
+	cmd := []string{binary, "--disable-xwt", "--console", "-e",
+		strings.Join(monitor, "; ")}
+
+	return append(cmd, emu.Args...)
+}
+
+// Returns the names of the emulators the BSP supports.
+func EmulatorNames(bsp *pkg.BspPackage) []string {
+	names := make([]string, 0, len(bsp.Emulators))
+	for name, _ := range bsp.Emulators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Boots the target's app in the specified emulator, using the machine
+// description from the BSP.  If gdbPort is nonzero, the emulator starts
+// halted with a GDB server listening on that port.
+//
+// Without watch.Until or semihosting, the emulator's console is attached to
+// the terminal, and a timeout is not treated as an error.  Otherwise its
+// output is watched as in LoadAndWatch() and the emulator is stopped once
+// watch ends; with semihosting (qemu only), the image's exit code is the
+// emulator's.
+func (t *TargetBuilder) Emulate(emuName string, gdbPort int, watch RunWatch,
+	semihosting bool) (*RunResult, error) {
+
+	emu := t.bspPkg.Emulators[emuName]
+	if emu == nil {
+		supported := "none"
+		if names := EmulatorNames(t.bspPkg); len(names) > 0 {
+			supported = strings.Join(names, ", ")
+		}
+		return nil, util.FmtNewtError("BSP %s does not support emulator "+
+			"\"%s\"; supported emulators: %s", t.bspPkg.FullName(),
+			emuName, supported)
+	}
+
+	elfPath := t.AppBuilder.AppElfPath()
+	if util.NodeNotExist(elfPath) {
+		return nil, util.FmtNewtError("No app image to emulate: %s",
+			elfPath)
+	}
+
+	var cmdStrs []string
+	switch emuName {
+	case EMULATOR_QEMU:
+		cmdStrs = qemuCmd(emu, elfPath, gdbPort, semihosting)
+	case EMULATOR_RENODE:
+		if semihosting {
+			return nil, util.NewNewtError(
+				"Semihosting is only supported with qemu")
+		}
+		cmdStrs = renodeCmd(emu, elfPath, gdbPort)
+	default:
+		return nil, util.FmtNewtError("Unknown emulator \"%s\"; must be "+
+			"%s or %s", emuName, EMULATOR_QEMU, EMULATOR_RENODE)
+	}
+
+	binPath, err := exec.LookPath(cmdStrs[0])
+	if err != nil {
+		return nil, util.FmtNewtError("Can't find %s executable \"%s\": %s",
+			emuName, cmdStrs[0], err.Error()).WithClass(
+			util.ERR_CLASS_TOOL_MISSING)
+	}
+	cmdStrs[0] = binPath
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Starting %s: %s\n",
+		emuName, elfPath)
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "%s\n",
+		strings.Join(cmdStrs, " "))
+	if gdbPort > 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"GDB server listening on port %d; attach with: "+
+				"gdb %s -ex \"target remote :%d\"\n",
+			gdbPort, elfPath, gdbPort)
+	}
+
+	res := &RunResult{ExitCode: -1}
+	if watch.Until == "" && !semihosting {
+		return res, runEmulator(emuName, cmdStrs, watch.Timeout)
+	}
+
+	re, err := compileRunUntil(watch.Until)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(cmdStrs[0], cmdStrs[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	cw := &consoleWriter{out: os.Stdout}
+	res, err = watchConsole(stdout, cw, re, watch.Timeout)
+	if err != nil || !res.Closed {
+		util.KillProcessTree(cmd.Process)
+		cmd.Wait()
+		return res, err
+	}
+
+	// The emulator exited by itself.  With semihosting, that is how the
+	// image reports its exit code.
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok && semihosting {
+		ws, ok := exitErr.Sys().(syscall.WaitStatus)
+		if ok && ws.ExitStatus() >= 0 {
+			res.ExitCode = ws.ExitStatus()
+			return res, nil
+		}
+	}
+	if err != nil {
+		return nil, util.FmtNewtError("%s failed: %s", emuName, err.Error())
+	}
+	if semihosting {
+		res.ExitCode = 0
+		return res, nil
+	}
+
+	return res, runWatchError(watch, res)
+}
+
+// Runs the emulator attached to the terminal.  If timeout is nonzero, it is
+// stopped after running for that long; this is not treated as an error.
+func runEmulator(emuName string, cmdStrs []string,
+	timeout time.Duration) error {
+
+	if timeout == 0 {
+		return util.ShellInteractiveCommand(cmdStrs, nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, cmdStrs[0], cmdStrs[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Debugf("Emulator stopped after %s", timeout)
+		fmt.Println()
+		return nil
+	}
+	if err != nil {
+		return util.FmtNewtError("%s failed: %s", emuName, err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,624 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+const (
+	EXPORT_BUILD_SYSTEM_CMAKE = "cmake"
+	EXPORT_BUILD_SYSTEM_MAKE  = "make"
+)
+
+var ExportBuildSystems = []string{
+	EXPORT_BUILD_SYSTEM_CMAKE,
+	EXPORT_BUILD_SYSTEM_MAKE,
+}
+
+// Marks a directory as written by "newt target export", so that a later
+// export may replace it.
+const EXPORT_MARKER_FILENAME = ".newt-export"
+
+// The source files of one package that are compiled by the same tool with
+// the same flags.
+type exportUnit struct {
+	pkgName  string
+	ident    string
+	compType int
+	srcs     []string
+	flags    []string
+}
+
+// Everything needed to build a target's app image outside of newt.
+type exportTree struct {
+	target  string
+	name    string
+	projDir string
+	genDir  string
+
+	units    []*exportUnit
+	archives []string
+
+	// Link flags preceding and following the object files.
+	preLink    []string
+	postLink   []string
+	linkGroups bool
+
+	cc  string
+	cxx string
+	as  string
+
+	// Files and directories to copy into the tree, keyed by their path
+	// relative to the tree's root.
+	files map[string]string
+	dirs  map[string]string
+}
+
+var exportIdentRe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func exportIdent(pkgName string, compType int) string {
+	ident := exportIdentRe.ReplaceAllString(
+		strings.TrimPrefix(pkgName, "@"), "_")
+
+	switch compType {
+	case toolchain.COMPILER_TYPE_CPP:
+		ident += "_cxx"
+	case toolchain.COMPILER_TYPE_ASM:
+		ident += "_asm"
+	}
+
+	return ident
+}
+
+// Maps a path used by the build to its location in the exported tree.
+// Returns false if the path is outside the project (e.g., a toolchain
+// file); such paths are used as is.
+func (et *exportTree) relPath(path string) (string, bool) {
+	abs := path
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(et.projDir, abs)
+	}
+	abs = filepath.ToSlash(filepath.Clean(abs))
+
+	if abs == et.genDir || strings.HasPrefix(abs, et.genDir+"/") {
+		return "generated" + strings.TrimPrefix(abs, et.genDir), true
+	}
+	if strings.HasPrefix(abs, et.projDir+"/") {
+		return strings.TrimPrefix(abs, et.projDir+"/"), true
+	}
+
+	return "", false
+}
+
+// Registers a file for copying and returns its path in the exported tree,
+// prefixed with root.
+func (et *exportTree) filePath(root string, path string) string {
+	rel, ok := et.relPath(path)
+	if !ok {
+		return path
+	}
+
+	et.files[rel] = path
+	return root + "/" + rel
+}
+
+// Registers a directory for copying and returns its path in the exported
+// tree, prefixed with root.
+func (et *exportTree) dirPath(root string, path string) string {
+	rel, ok := et.relPath(path)
+	if !ok {
+		return path
+	}
+
+	et.dirs[rel] = path
+	return root + "/" + rel
+}
+
+// Rewrites the paths in a list of compiler or linker flags.  Options and
+// their path arguments are joined into a single flag (e.g., "-T" "x.ld"
+// becomes "-Tx.ld"), except for -include, whose argument stays separate.
+func (et *exportTree) mapFlags(root string, flags []string) []string {
+	mapped := []string{}
+	for i := 0; i < len(flags); i++ {
+		f := flags[i]
+		switch {
+		case (f == "-I" || f == "-L") && i+1 < len(flags):
+			i++
+			mapped = append(mapped, f+et.dirPath(root, flags[i]))
+		case f == "-T" && i+1 < len(flags):
+			i++
+			mapped = append(mapped, f+et.filePath(root, flags[i]))
+		case f == "-include" && i+1 < len(flags):
+			i++
+			mapped = append(mapped, f, et.filePath(root, flags[i]))
+		case strings.HasPrefix(f, "-include"):
+			mapped = append(mapped, "-include", et.filePath(root, f[8:]))
+		case strings.HasPrefix(f, "-I"), strings.HasPrefix(f, "-L"):
+			mapped = append(mapped, f[:2]+et.dirPath(root, f[2:]))
+		case strings.HasPrefix(f, "-T"):
+			mapped = append(mapped, f[:2]+et.filePath(root, f[2:]))
+		default:
+			mapped = append(mapped, f)
+		}
+	}
+
+	return mapped
+}
+
+func (b *Builder) exportUnits() ([]*exportUnit, []string, error) {
+	units := []*exportUnit{}
+	archives := []string{}
+
+	for _, bpkg := range b.sortedBuildPackages() {
+		entries, err := b.collectCompileEntriesBpkg(bpkg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pkgName := bpkg.rpkg.Lpkg.FullName()
+		pkgUnits := map[int]*exportUnit{}
+		for _, entry := range entries {
+			if entry.CompilerType == toolchain.COMPILER_TYPE_ARCHIVE {
+				archives = append(archives, entry.Filename)
+				continue
+			}
+
+			args, err := entry.Compiler.CompileFileCmd(entry.Filename,
+				entry.CompilerType)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			u := pkgUnits[entry.CompilerType]
+			if u == nil {
+				u = &exportUnit{
+					pkgName:  pkgName,
+					ident:    exportIdent(pkgName, entry.CompilerType),
+					compType: entry.CompilerType,
+					flags:    compileFlags(CompileCommand{Args: args}),
+				}
+				pkgUnits[entry.CompilerType] = u
+				units = append(units, u)
+			}
+			u.srcs = append(u.srcs, entry.Filename)
+		}
+	}
+
+	return units, archives, nil
+}
+
+// Collects the sources, flags and link settings of the target's app image.
+func (t *TargetBuilder) exportTree() (*exportTree, error) {
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	if t.LoaderBuilder != nil {
+		return nil, util.NewNewtError(
+			"Exporting split images is not supported")
+	}
+	if t.appPkg == nil {
+		return nil, util.FmtNewtError("Target %s has no app",
+			t.target.FullName())
+	}
+
+	b := t.AppBuilder
+	et := &exportTree{
+		target:  t.target.FullName(),
+		name:    filepath.Base(t.appPkg.Name()),
+		projDir: filepath.ToSlash(project.GetProject().Path()),
+		genDir:  filepath.ToSlash(GeneratedBaseDir(t.target.Name())),
+		files:   map[string]string{},
+		dirs:    map[string]string{},
+	}
+
+	var err error
+	et.units, et.archives, err = b.exportUnits()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := b.linkCompiler(b.BinDir(), t.bspPkg.LinkerScripts)
+	if err != nil {
+		return nil, err
+	}
+	et.preLink, et.postLink = c.LinkFlags()
+	et.linkGroups = c.LinkGroups()
+	et.cc, et.cxx, et.as = c.ToolPaths()
+
+	return et, nil
+}
+
+// Quotes a CMake argument if necessary.  References to CMake variables are
+// left intact.
+func cmakeArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"();#\\") {
+		return s
+	}
+
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `;`, `\;`)
+	return `"` + r.Replace(s) + `"`
+}
+
+func cmakeList(buf *bytes.Buffer, cmd string, target string, scope string,
+	items []string) {
+
+	if len(items) == 0 {
+		return
+	}
+
+	fmt.Fprintf(buf, "%s(%s %s\n", cmd, target, scope)
+	for _, item := range items {
+		fmt.Fprintf(buf, "    %s\n", cmakeArg(item))
+	}
+	buf.WriteString(")\n")
+}
+
+func (et *exportTree) writeCmake(dir string) error {
+	const root = "${CMAKE_CURRENT_SOURCE_DIR}"
+
+	langs := []string{"C"}
+	hasLang := map[int]bool{}
+	for _, u := range et.units {
+		hasLang[u.compType] = true
+	}
+	if hasLang[toolchain.COMPILER_TYPE_CPP] {
+		langs = append(langs, "CXX")
+	}
+	if hasLang[toolchain.COMPILER_TYPE_ASM] {
+		langs = append(langs, "ASM")
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "# Generated by newt from target %s.\n", et.target)
+	fmt.Fprintf(buf, "# Configure with -DCMAKE_TOOLCHAIN_FILE=toolchain.cmake "+
+		"unless the\n# enclosing build selects the compiler.\n\n")
+	buf.WriteString("cmake_minimum_required(VERSION 3.13)\n")
+	fmt.Fprintf(buf, "project(%s %s)\n", et.name, strings.Join(langs, " "))
+
+	objs := []string{}
+	for _, u := range et.units {
+		buf.WriteString("\n")
+		fmt.Fprintf(buf, "# %s\n", u.pkgName)
+
+		srcs := make([]string, len(u.srcs))
+		for i, src := range u.srcs {
+			srcs[i] = et.filePath(root, src)
+		}
+		cmakeList(buf, "add_library", u.ident, "OBJECT", srcs)
+
+		includes := []string{}
+		defines := []string{}
+		options := []string{}
+		flags := et.mapFlags(root, u.flags)
+		for i := 0; i < len(flags); i++ {
+			f := flags[i]
+			switch {
+			case strings.HasPrefix(f, "-I"):
+				includes = append(includes, f[2:])
+			case strings.HasPrefix(f, "-D"):
+				defines = append(defines, f[2:])
+			case f == "-include" && i+1 < len(flags):
+				// Keep CMake from de-duplicating repeated options.
+				i++
+				options = append(options,
+					"SHELL:-include "+cmakeArg(flags[i]))
+			default:
+				options = append(options, f)
+			}
+		}
+		cmakeList(buf, "target_include_directories", u.ident, "PRIVATE",
+			includes)
+		cmakeList(buf, "target_compile_definitions", u.ident, "PRIVATE",
+			defines)
+		cmakeList(buf, "target_compile_options", u.ident, "PRIVATE",
+			options)
+
+		objs = append(objs, "$<TARGET_OBJECTS:"+u.ident+">")
+	}
+
+	buf.WriteString("\n")
+	cmakeList(buf, "add_executable", et.name, "", objs)
+	fmt.Fprintf(buf, "set_target_properties(%s PROPERTIES SUFFIX \".elf\" "+
+		"LINKER_LANGUAGE C)\n", et.name)
+	cmakeList(buf, "target_link_options", et.name, "PRIVATE",
+		et.mapFlags(root, et.preLink))
+
+	libs := []string{}
+	if et.linkGroups {
+		libs = append(libs, "-Wl,--start-group")
+	}
+	for _, a := range et.archives {
+		libs = append(libs, et.filePath(root, a))
+	}
+	if et.linkGroups {
+		libs = append(libs, "-Wl,--end-group")
+	}
+	libs = append(libs, et.mapFlags(root, et.postLink)...)
+	cmakeList(buf, "target_link_libraries", et.name, "PRIVATE", libs)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "CMakeLists.txt"),
+		buf.Bytes(), 0644); err != nil {
+
+		return util.ChildNewtError(err)
+	}
+
+	tc := &bytes.Buffer{}
+	fmt.Fprintf(tc, "# Generated by newt from target %s.\n\n", et.target)
+	tc.WriteString("set(CMAKE_SYSTEM_NAME Generic)\n")
+	fmt.Fprintf(tc, "set(CMAKE_C_COMPILER %s)\n", cmakeArg(et.cc))
+	if et.cxx != "" {
+		fmt.Fprintf(tc, "set(CMAKE_CXX_COMPILER %s)\n", cmakeArg(et.cxx))
+	}
+	if et.as != "" {
+		fmt.Fprintf(tc, "set(CMAKE_ASM_COMPILER %s)\n", cmakeArg(et.as))
+	}
+	tc.WriteString("set(CMAKE_TRY_COMPILE_TARGET_TYPE STATIC_LIBRARY)\n")
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "toolchain.cmake"),
+		tc.Bytes(), 0644); err != nil {
+
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Quotes a make recipe argument for the shell if necessary.  References to
+// the tree's root are left for make to expand.
+func makeArg(s string) string {
+	const rootRef = "$(NEWT_EXPORT_ROOT)"
+
+	s = strings.Replace(s, "$", "$$", -1)
+	s = strings.Replace(s, "$"+rootRef, rootRef, -1)
+	if s != "" && !strings.ContainsAny(strings.Replace(s, rootRef, "", -1),
+		" \t\"';\\&|<>*?#`()") {
+
+		return s
+	}
+
+	s = strings.Replace(s, "#", `\#`, -1)
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Writes a make variable holding a list of words.  The words are written as
+// is; flags must already be quoted with makeArg().
+func makeVar(buf *bytes.Buffer, name string, words []string) {
+	fmt.Fprintf(buf, "%s :=", name)
+	for _, w := range words {
+		fmt.Fprintf(buf, " \\\n    %s", w)
+	}
+	buf.WriteString("\n")
+}
+
+func makeArgs(args []string) []string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = makeArg(arg)
+	}
+	return quoted
+}
+
+func (et *exportTree) writeMake(dir string) error {
+	const root = "$(NEWT_EXPORT_ROOT)"
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "# Generated by newt from target %s.\n\n", et.target)
+	buf.WriteString("NEWT_EXPORT_ROOT := " +
+		"$(patsubst %/,%,$(dir $(abspath $(lastword $(MAKEFILE_LIST)))))\n")
+	buf.WriteString("BUILD_DIR ?= build\n\n")
+	fmt.Fprintf(buf, "CC := %s\n", makeArg(et.cc))
+	if et.cxx != "" {
+		fmt.Fprintf(buf, "CXX := %s\n", makeArg(et.cxx))
+	}
+	if et.as != "" {
+		fmt.Fprintf(buf, "AS := %s\n", makeArg(et.as))
+	}
+	fmt.Fprintf(buf, "\nAPP := $(BUILD_DIR)/%s.elf\n\n", et.name)
+	buf.WriteString(".PHONY: all clean\n\nall: $(APP)\n")
+
+	tools := map[int]string{
+		toolchain.COMPILER_TYPE_C:   "$(CC)",
+		toolchain.COMPILER_TYPE_CPP: "$(CXX)",
+		toolchain.COMPILER_TYPE_ASM: "$(AS)",
+	}
+
+	objVars := []string{}
+	for _, u := range et.units {
+		ident := strings.ToUpper(u.ident)
+
+		srcs := make([]string, len(u.srcs))
+		for i, src := range u.srcs {
+			srcs[i] = et.filePath(root, src)
+		}
+
+		fmt.Fprintf(buf, "\n# %s\n", u.pkgName)
+		makeVar(buf, ident+"_SRCS", srcs)
+		makeVar(buf, ident+"_FLAGS", makeArgs(et.mapFlags(root, u.flags)))
+		fmt.Fprintf(buf, "%s_OBJS := $(patsubst $(NEWT_EXPORT_ROOT)/%%,"+
+			"$(BUILD_DIR)/%%.o,$(%s_SRCS))\n", ident, ident)
+		fmt.Fprintf(buf, "$(%s_OBJS): $(BUILD_DIR)/%%.o: "+
+			"$(NEWT_EXPORT_ROOT)/%%\n", ident)
+		buf.WriteString("\t@mkdir -p $(dir $@)\n")
+		fmt.Fprintf(buf, "\t%s $(%s_FLAGS) -c -o $@ $<\n",
+			tools[u.compType], ident)
+
+		objVars = append(objVars, "$("+ident+"_OBJS)")
+	}
+
+	archives := make([]string, len(et.archives))
+	for i, a := range et.archives {
+		archives[i] = et.filePath(root, a)
+	}
+
+	buf.WriteString("\n")
+	makeVar(buf, "OBJS", objVars)
+	makeVar(buf, "LIBS", archives)
+	makeVar(buf, "LDFLAGS_PRE", makeArgs(et.mapFlags(root, et.preLink)))
+	makeVar(buf, "LDFLAGS", makeArgs(et.mapFlags(root, et.postLink)))
+
+	buf.WriteString("\n$(APP): $(OBJS) $(LIBS)\n")
+	if et.linkGroups {
+		buf.WriteString("\t$(CC) -o $@ $(LDFLAGS_PRE) -Wl,--start-group " +
+			"$(OBJS) $(LIBS) -Wl,--end-group $(LDFLAGS)\n")
+	} else {
+		buf.WriteString("\t$(CC) -o $@ $(LDFLAGS_PRE) $(OBJS) $(LIBS) " +
+			"$(LDFLAGS)\n")
+	}
+	buf.WriteString("\nclean:\n\trm -rf $(BUILD_DIR)\n")
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "Makefile"),
+		buf.Bytes(), 0644); err != nil {
+
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+func sortedExportKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k, _ := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Copies the registered files and directories into the exported tree.
+func (et *exportTree) copyFiles(dir string) error {
+	for _, rel := range sortedExportKeys(et.dirs) {
+		src := et.dirs[rel]
+		if util.NodeNotExist(src) {
+			continue
+		}
+
+		err := filepath.Walk(src,
+			func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return util.ChildNewtError(err)
+				}
+				if info.IsDir() {
+					return nil
+				}
+
+				sub, _ := filepath.Rel(src, path)
+				return util.CopyFile(path, filepath.Join(dir, rel, sub))
+			})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, rel := range sortedExportKeys(et.files) {
+		if err := util.CopyFile(et.files[rel],
+			filepath.Join(dir, rel)); err != nil {
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Empties a directory that a target is to be exported to, and marks it as
+// newt's.  A non-empty directory is only emptied if newt created it.
+func prepareExportDir(dir string, targetName string) error {
+	if util.NodeExist(dir) {
+		infos, _ := ioutil.ReadDir(dir)
+		if len(infos) > 0 &&
+			util.NodeNotExist(filepath.Join(dir, EXPORT_MARKER_FILENAME)) {
+
+			return util.FmtNewtError(
+				"Export directory %s is not empty and was not created by "+
+					"newt", dir)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return util.ChildNewtError(err)
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, EXPORT_MARKER_FILENAME),
+		[]byte(targetName+"\n"), 0644); err != nil {
+
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Returns the default location of the target's exported build tree.
+func (t *TargetBuilder) ExportDir() string {
+	return TargetBinDir(t.target.Name()) + "/export"
+}
+
+// Writes a standalone build tree for the target's app image: copies of all
+// sources, headers, generated files and linker scripts, and a CMake or make
+// build description that compiles each package with the flags newt would
+// use.  An existing tree is only replaced if a previous export created it.
+func (t *TargetBuilder) Export(buildSystem string, dir string) error {
+	if buildSystem != EXPORT_BUILD_SYSTEM_CMAKE &&
+		buildSystem != EXPORT_BUILD_SYSTEM_MAKE {
+
+		return util.FmtNewtError("Unknown build system \"%s\"; must be "+
+			"one of: %s", buildSystem, strings.Join(ExportBuildSystems, ", "))
+	}
+
+	et, err := t.exportTree()
+	if err != nil {
+		return err
+	}
+
+	if err := prepareExportDir(dir, et.target); err != nil {
+		return err
+	}
+
+	if buildSystem == EXPORT_BUILD_SYSTEM_CMAKE {
+		err = et.writeCmake(dir)
+	} else {
+		err = et.writeMake(dir)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := et.copyFiles(dir); err != nil {
+		return err
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Exported target %s to %s\n", et.target, dir)
+
+	return nil
+}
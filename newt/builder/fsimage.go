@@ -0,0 +1,177 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"mynewt.apache.org/newt/newt/fsimage"
+	"mynewt.apache.org/newt/util"
+)
+
+// Geometry used when neither the command line nor the target's syscfg
+// specifies it.
+const FS_IMAGE_DEFAULT_BLOCK_SIZE = 4096
+const FS_IMAGE_DEFAULT_PROG_SIZE = 16
+
+// Syscfg settings naming the flash area each file system is mounted from.
+var fsImageAreaSettings = map[string]string{
+	fsimage.FS_TYPE_LITTLEFS: "LITTLEFS_FLASH_AREA",
+	fsimage.FS_TYPE_NFFS:     "NFFS_FLASH_AREA",
+}
+
+type FsImageOptions struct {
+	// Flash area to build the image for.  Empty means the area named by the
+	// file system's syscfg setting.
+	Area string
+
+	// Host directory holding the image's contents.
+	Dir string
+
+	// File system type; empty means infer it from the target's syscfg.
+	Type string
+
+	// littlefs block size or NFFS area size; 0 means syscfg or default.
+	BlockSize int
+
+	// littlefs program size; 0 means syscfg or default.
+	ProgSize int
+
+	// Output file; empty means bin/targets/<target>/fs-<area>.bin.
+	Out string
+}
+
+// Determines the file system type from the target's syscfg: the file system
+// mounted from the specified area, or else the only one the target uses.
+func (t *TargetBuilder) fsImageType(area string) (string, error) {
+	found := []string{}
+	for _, fsType := range fsimage.FsTypes {
+		val, ok := t.settingValue(fsImageAreaSettings[fsType])
+		if !ok {
+			continue
+		}
+		if area != "" && val == area {
+			return fsType, nil
+		}
+		found = append(found, fsType)
+	}
+
+	if area == "" && len(found) == 1 {
+		return found[0], nil
+	}
+
+	return "", util.FmtNewtError("Can't determine the file system type "+
+		"for target %s; specify --type (%s or %s)", t.target.FullName(),
+		fsimage.FS_TYPE_LITTLEFS, fsimage.FS_TYPE_NFFS)
+}
+
+// Builds a file system image from a host directory, sized to a flash area of
+// the target's BSP.  Returns the path of the image written.
+func (t *TargetBuilder) FsImage(opts FsImageOptions) (string, error) {
+	if err := t.PrepBuild(); err != nil {
+		return "", err
+	}
+
+	fsType := opts.Type
+	if fsType == "" {
+		var err error
+		fsType, err = t.fsImageType(opts.Area)
+		if err != nil {
+			return "", err
+		}
+	} else if _, ok := fsImageAreaSettings[fsType]; !ok {
+		return "", util.FmtNewtError(
+			"Unknown file system type \"%s\"; must be %s or %s", fsType,
+			fsimage.FS_TYPE_LITTLEFS, fsimage.FS_TYPE_NFFS)
+	}
+
+	areaName := opts.Area
+	if areaName == "" {
+		setting := fsImageAreaSettings[fsType]
+		val, ok := t.settingValue(setting)
+		if !ok {
+			return "", util.FmtNewtError("Target %s does not set %s; "+
+				"specify --area", t.target.FullName(), setting)
+		}
+		areaName = val
+	}
+
+	area, ok := t.bspPkg.FlashMap.Areas[areaName]
+	if !ok {
+		return "", util.FmtNewtError("BSP %s has no flash area %s",
+			t.bspPkg.FullName(), areaName)
+	}
+
+	params := fsimage.Params{
+		Size:      area.Size,
+		BlockSize: FS_IMAGE_DEFAULT_BLOCK_SIZE,
+		ProgSize:  FS_IMAGE_DEFAULT_PROG_SIZE,
+	}
+	if fsType == fsimage.FS_TYPE_LITTLEFS {
+		if n, ok := t.intSetting("LITTLEFS_BLOCK_SIZE"); ok {
+			params.BlockSize = n
+		}
+		if n, ok := t.intSetting("LITTLEFS_PROG_SIZE"); ok {
+			params.ProgSize = n
+		}
+
+		// Inlined files are read through the cache, so they can't be
+		// larger than it.
+		if n, ok := t.intSetting("LITTLEFS_CACHE_SIZE"); ok {
+			params.InlineMax = n
+		} else {
+			params.InlineMax = params.ProgSize
+		}
+	}
+	if opts.BlockSize != 0 {
+		params.BlockSize = opts.BlockSize
+	}
+	if opts.ProgSize != 0 {
+		params.ProgSize = opts.ProgSize
+	}
+
+	data, stats, err := fsimage.Build(fsType, opts.Dir, params)
+	if err != nil {
+		return "", err
+	}
+
+	out := opts.Out
+	if out == "" {
+		out = filepath.Join(TargetBinDir(t.target.Name()),
+			"fs-"+areaName+".bin")
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return "", util.ChildNewtError(err)
+	}
+	if err := ioutil.WriteFile(out, data, 0644); err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"%s image for %s (0x%x, %d bytes, %d-byte blocks): %d files, "+
+			"%d directories, %d bytes of data, %d bytes used\n",
+		fsType, areaName, t.bspPkg.FlashMap.AreaAddress(area), area.Size,
+		params.BlockSize, stats.Files, stats.Dirs, stats.DataBytes,
+		stats.Used)
+
+	return out, nil
+}
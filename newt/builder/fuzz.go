@@ -0,0 +1,411 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+const FUZZ_ENGINE_LIBFUZZER = "libfuzzer"
+const FUZZ_ENGINE_AFL = "afl"
+
+// Subdirectory of a harness's source directory containing its seed inputs.
+const FUZZ_SEED_CORPUS_DIR = "corpus"
+
+// A fuzz entry point declared by a package in the pkg.fuzz map of its
+// pkg.yml.  Each entry maps a harness name to a directory, relative to the
+// package, containing the sources that define LLVMFuzzerTestOneInput().
+type FuzzHarness struct {
+	Name string
+	Dir  string
+}
+
+// How a fuzz harness is built and run.
+type FuzzConfig struct {
+	Harness *FuzzHarness
+	Engine  string
+
+	// C compiler to build with; defaults to the engine's compiler.
+	Cc string
+}
+
+type harnessSorter struct {
+	harnesses []*FuzzHarness
+}
+
+func (s harnessSorter) Len() int {
+	return len(s.harnesses)
+}
+func (s harnessSorter) Swap(i, j int) {
+	s.harnesses[i], s.harnesses[j] = s.harnesses[j], s.harnesses[i]
+}
+func (s harnessSorter) Less(i, j int) bool {
+	return s.harnesses[i].Name < s.harnesses[j].Name
+}
+
+// Returns the fuzz harnesses declared by the specified package, sorted by
+// name.
+func FuzzHarnesses(lpkg *pkg.LocalPackage) ([]*FuzzHarness, error) {
+	section := newtutil.GetStringMapFeatures(lpkg.PkgV, nil, "pkg.fuzz")
+
+	harnesses := []*FuzzHarness{}
+	for name, val := range section {
+		dir := filepath.Join(lpkg.BasePath(), cast.ToString(val))
+		if util.NodeNotExist(dir) {
+			return nil, util.FmtNewtError("Fuzz harness \"%s\" of package "+
+				"%s: directory %s does not exist", name, lpkg.FullName(),
+				dir)
+		}
+
+		harnesses = append(harnesses, &FuzzHarness{Name: name, Dir: dir})
+	}
+
+	sort.Sort(harnessSorter{harnesses})
+
+	return harnesses, nil
+}
+
+func FindFuzzHarness(lpkg *pkg.LocalPackage,
+	name string) (*FuzzHarness, error) {
+
+	harnesses, err := FuzzHarnesses(lpkg)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, h := range harnesses {
+		if h.Name == name {
+			return h, nil
+		}
+		names = append(names, h.Name)
+	}
+
+	if len(names) == 0 {
+		return nil, util.FmtNewtError("Package %s declares no fuzz "+
+			"harnesses (pkg.fuzz)", lpkg.FullName())
+	}
+	return nil, util.FmtNewtError("Package %s has no fuzz harness \"%s\"; "+
+		"available harnesses: %s", lpkg.FullName(), name,
+		strings.Join(names, ", "))
+}
+
+func fuzzDefaultCc(engine string) (string, error) {
+	switch engine {
+	case FUZZ_ENGINE_LIBFUZZER:
+		return "clang", nil
+	case FUZZ_ENGINE_AFL:
+		return "afl-clang-fast", nil
+	default:
+		return "", util.FmtNewtError("Unknown fuzzing engine \"%s\"; must "+
+			"be %s or %s", engine, FUZZ_ENGINE_LIBFUZZER, FUZZ_ENGINE_AFL)
+	}
+}
+
+// Creates a target builder that compiles the fuzzed package, its
+// dependencies and the harness with fuzzing instrumentation.  The harness's
+// package is passed as the test package so that it is seeded into the build.
+func NewTargetFuzzer(target *target.Target, lpkg *pkg.LocalPackage,
+	cfg FuzzConfig) (*TargetBuilder, error) {
+
+	if cfg.Cc == "" {
+		cc, err := fuzzDefaultCc(cfg.Engine)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Cc = cc
+	}
+
+	t, err := NewTargetTester(target, lpkg)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.bspPkg.Arch != SANITIZER_ARCH {
+		return nil, util.FmtNewtError(
+			"Fuzzing requires a %s BSP (bsp arch=%s)", SANITIZER_ARCH,
+			t.bspPkg.Arch)
+	}
+
+	t.fuzz = &cfg
+	return t, nil
+}
+
+func (t *TargetBuilder) fuzzCompilerInfo() *toolchain.CompilerInfo {
+	ci := toolchain.NewCompilerInfo()
+	ci.Cflags = []string{"-fsanitize=fuzzer-no-link,address", "-g",
+		"-fno-omit-frame-pointer"}
+	ci.Lflags = []string{"-fsanitize=fuzzer,address"}
+	return ci
+}
+
+// Returns the directory holding the fuzzer's corpus and findings.
+func (t *TargetBuilder) FuzzDir() string {
+	return TargetBinDir(t.target.Name()) + "/fuzz"
+}
+
+func (t *TargetBuilder) fuzzExePath(bpkg *BuildPackage) string {
+	return t.AppBuilder.PkgBinDir(bpkg) + "/fuzz_" + t.fuzz.Harness.Name +
+		".elf"
+}
+
+// Builds the fuzzing executable and returns its path.
+func (t *TargetBuilder) FuzzCreateExe() (string, error) {
+	if err := t.PrepBuild(); err != nil {
+		return "", err
+	}
+
+	rpkg, err := t.getTestRpkg()
+	if err != nil {
+		return "", err
+	}
+	bpkg, err := t.AppBuilder.getTestBpkg(rpkg)
+	if err != nil {
+		return "", err
+	}
+	bpkg.extraSrcDirs = append(bpkg.extraSrcDirs, t.fuzz.Harness.Dir)
+
+	if err := t.AppBuilder.Build(); err != nil {
+		return "", err
+	}
+
+	exePath := t.fuzzExePath(bpkg)
+	if err := t.AppBuilder.link(exePath, nil, nil); err != nil {
+		return "", err
+	}
+
+	return exePath, nil
+}
+
+// Environment for running an instrumented executable.  Stack traces are
+// symbolicated if llvm-symbolizer is available.
+func fuzzEnv() []string {
+	env := []string{}
+	if path, err := exec.LookPath("llvm-symbolizer"); err == nil {
+		env = append(env, "ASAN_SYMBOLIZER_PATH="+path)
+	}
+	if os.Getenv("ASAN_OPTIONS") == "" {
+		env = append(env, "ASAN_OPTIONS=symbolize=1")
+	}
+	return env
+}
+
+// Runs the fuzzer until it finds a crash, it is interrupted or the duration
+// elapses (if nonzero).  Inputs that cause crashes are collected in the
+// crash directory returned by FuzzCrashes().
+func (t *TargetBuilder) RunFuzzer(exePath string, duration time.Duration,
+	extraArgs []string) error {
+
+	dir := t.FuzzDir()
+	corpusDir := dir + "/corpus"
+	if err := os.MkdirAll(corpusDir, 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := os.MkdirAll(t.fuzzCrashDir(), 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	seedDir := filepath.Join(t.fuzz.Harness.Dir, FUZZ_SEED_CORPUS_DIR)
+
+	var cmd []string
+	switch t.fuzz.Engine {
+	case FUZZ_ENGINE_LIBFUZZER:
+		// New inputs are written to the first corpus directory; the seeds
+		// are only read.
+		cmd = []string{exePath, corpusDir}
+		if util.NodeExist(seedDir) {
+			cmd = append(cmd, seedDir)
+		}
+		cmd = append(cmd, "-artifact_prefix="+t.fuzzCrashDir()+"/")
+		if duration > 0 {
+			cmd = append(cmd, "-max_total_time="+
+				strconv.Itoa(int(duration.Seconds())))
+		}
+
+	case FUZZ_ENGINE_AFL:
+		aflPath, err := exec.LookPath("afl-fuzz")
+		if err != nil {
+			return util.NewNewtError("Can't find afl-fuzz in PATH").WithClass(
+				util.ERR_CLASS_TOOL_MISSING)
+		}
+
+		if err := seedAflCorpus(corpusDir, seedDir); err != nil {
+			return err
+		}
+
+		cmd = []string{aflPath, "-i", corpusDir, "-o", dir + "/afl"}
+		if duration > 0 {
+			cmd = append(cmd, "-V", strconv.Itoa(int(duration.Seconds())))
+		}
+	}
+	cmd = append(cmd, extraArgs...)
+	if t.fuzz.Engine == FUZZ_ENGINE_AFL {
+		cmd = append(cmd, "--", exePath)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Fuzzing %s (%s)\n",
+		t.fuzz.Harness.Name, t.fuzz.Engine)
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "%s\n", strings.Join(cmd, " "))
+
+	// The fuzzer exits with an error status when it finds a crash; that is
+	// reported from the collected crash inputs instead.
+	if err := util.ShellInteractiveCommand(cmd, fuzzEnv()); err != nil {
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "Fuzzer exited: %s\n",
+			err.Error())
+	}
+
+	if t.fuzz.Engine == FUZZ_ENGINE_AFL {
+		return collectAflCrashes(dir+"/afl", t.fuzzCrashDir())
+	}
+	return nil
+}
+
+// AFL refuses to start with an empty input directory.  Copy in the seed
+// corpus, or a single trivial input if there are no seeds.
+func seedAflCorpus(corpusDir string, seedDir string) error {
+	entries, _ := ioutil.ReadDir(corpusDir)
+	if len(entries) > 0 {
+		return nil
+	}
+
+	if util.NodeExist(seedDir) {
+		seeds, _ := ioutil.ReadDir(seedDir)
+		for _, seed := range seeds {
+			if err := util.CopyFile(filepath.Join(seedDir, seed.Name()),
+				filepath.Join(corpusDir, seed.Name())); err != nil {
+
+				return err
+			}
+		}
+		if len(seeds) > 0 {
+			return nil
+		}
+	}
+
+	if err := ioutil.WriteFile(corpusDir+"/seed", []byte("\n"),
+		0644); err != nil {
+
+		return util.ChildNewtError(err)
+	}
+	return nil
+}
+
+// Copies the crashing inputs found by AFL into the crash directory so that
+// both engines report crashes from the same place.
+func collectAflCrashes(aflDir string, crashDir string) error {
+	paths, _ := filepath.Glob(aflDir + "/*/crashes/id:*")
+	for _, path := range paths {
+		name := "crash-" + strings.Replace(filepath.Base(path), ":", "_", -1)
+		if err := util.CopyFile(path,
+			filepath.Join(crashDir, name)); err != nil {
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *TargetBuilder) fuzzCrashDir() string {
+	return t.FuzzDir() + "/crashes"
+}
+
+// A crashing input and the stack trace obtained by replaying it.
+type FuzzCrash struct {
+	Input  string
+	Report string
+	Frames []string
+}
+
+var fuzzFrameRe = regexp.MustCompile(`^\s*#\d+ 0x[0-9a-f]+ in .*`)
+
+// Replays each crashing input found so far through the executable and
+// captures the resulting sanitizer report.  The report is also saved next to
+// the input with a .txt suffix.
+func (t *TargetBuilder) FuzzCrashes(exePath string) ([]*FuzzCrash, error) {
+	paths := []string{}
+	for _, prefix := range []string{"crash-", "leak-", "timeout-", "oom-"} {
+		matches, _ := filepath.Glob(t.fuzzCrashDir() + "/" + prefix + "*")
+		for _, m := range matches {
+			if !strings.HasSuffix(m, ".txt") {
+				paths = append(paths, m)
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	crashes := []*FuzzCrash{}
+	for _, path := range paths {
+		cmd := exec.Command(exePath, path)
+		cmd.Env = append(os.Environ(), fuzzEnv()...)
+		out, _ := cmd.CombinedOutput()
+
+		crash := &FuzzCrash{Input: path, Report: string(out)}
+		for _, line := range strings.Split(crash.Report, "\n") {
+			if fuzzFrameRe.MatchString(line) {
+				crash.Frames = append(crash.Frames, strings.TrimSpace(line))
+			}
+		}
+
+		if err := ioutil.WriteFile(path+".txt", out, 0644); err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+
+		crashes = append(crashes, crash)
+	}
+
+	return crashes, nil
+}
+
+// Prints a summary of each crash: its input file and the top frames of its
+// stack trace.
+func PrintFuzzCrashes(crashes []*FuzzCrash, maxFrames int) {
+	buf := &bytes.Buffer{}
+	for _, c := range crashes {
+		fmt.Fprintf(buf, "Crash: %s\n", c.Input)
+		frames := c.Frames
+		if maxFrames > 0 && len(frames) > maxFrames {
+			frames = frames[:maxFrames]
+		}
+		for _, f := range frames {
+			fmt.Fprintf(buf, "    %s\n", f)
+		}
+		fmt.Fprintf(buf, "    (full report: %s.txt)\n", c.Input)
+	}
+
+	util.StatusMessage(util.VERBOSITY_QUIET, "%s", buf.String())
+}
@@ -0,0 +1,267 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+// Kernel symbols the generated GDB commands use.  Each command is only
+// defined if the executable contains all of the symbols it needs.
+const GDB_SYM_TASK_LIST = "g_os_task_list"
+const GDB_SYM_RUN_LIST = "g_os_run_list"
+const GDB_SYM_SLEEP_LIST = "g_os_sleep_list"
+const GDB_SYM_TIME = "g_os_time"
+const GDB_SYM_MSYS_POOLS = "g_msys_pool_list"
+const GDB_SYM_MEMPOOLS = "g_os_mempool_list"
+
+// A GDB user-defined command.
+type gdbCommand struct {
+	name string
+	help string
+	syms []string
+	body string
+}
+
+// The kernel's lists are queue.h heads, whose first member points at the
+// first element.  Some of them are anonymous structs, so the generated
+// commands dereference the heads' addresses rather than name their types.
+var gdbCommands = []gdbCommand{
+	{
+		name: "mynewt_tasks",
+		help: "List all tasks; the running task is marked with a '*'.",
+		syms: []string{GDB_SYM_TASK_LIST, COREDUMP_CUR_TASK_SYM},
+		body: `
+    set $cur = *(struct os_task **) $mynewt_current_task
+    set $t = *(struct os_task **) $mynewt_task_list
+    printf "   %-16s %4s %4s %5s %10s %10s %8s\n", "task", "id", "prio", "state", "stack", "stacksz", "csw"
+    while $t
+        if $t == $cur
+            printf " * "
+        else
+            printf "   "
+        end
+        printf "%-16s %4d %4d %5d 0x%08x %10d %8d\n", $t->t_name, $t->t_taskid, $t->t_prio, $t->t_state, $t->t_stackbottom, $t->t_stacksize, $t->t_ctx_sw_cnt
+        set $t = $t->t_os_task_list.stqe_next
+    end`,
+	},
+	{
+		name: "mynewt_mbufs",
+		help: "Dump the usage of the system mbuf pools.",
+		syms: []string{GDB_SYM_MSYS_POOLS},
+		body: `
+    set $p = *(struct os_mbuf_pool **) $mynewt_msys_pools
+    printf "%-16s %8s %8s %8s %8s\n", "pool", "blksz", "blocks", "free", "min"
+    while $p
+        set $mp = $p->omp_pool
+        printf "%-16s %8d %8d %8d %8d\n", $mp->name, $mp->mp_block_size, $mp->mp_num_blocks, $mp->mp_num_free, $mp->mp_min_free
+        set $p = $p->omp_next.stqe_next
+    end`,
+	},
+	{
+		name: "mynewt_mempools",
+		help: "Dump the usage of all memory pools.",
+		syms: []string{GDB_SYM_MEMPOOLS},
+		body: `
+    set $mp = *(struct os_mempool **) $mynewt_mempools
+    printf "%-16s %8s %8s %8s %8s\n", "pool", "blksz", "blocks", "free", "min"
+    while $mp
+        printf "%-16s %8d %8d %8d %8d\n", $mp->name, $mp->mp_block_size, $mp->mp_num_blocks, $mp->mp_num_free, $mp->mp_min_free
+        set $mp = $mp->mp_list.stqe_next
+    end`,
+	},
+	{
+		name: "mynewt_sched",
+		help: "Walk the scheduler's run and sleep lists.",
+		syms: []string{GDB_SYM_RUN_LIST, GDB_SYM_SLEEP_LIST, GDB_SYM_TIME,
+			COREDUMP_CUR_TASK_SYM},
+		body: `
+    set $cur = *(struct os_task **) $mynewt_current_task
+    printf "time: %u ticks\n", *(unsigned int *) $mynewt_time
+    if $cur
+        printf "running: %s (prio %d)\n", $cur->t_name, $cur->t_prio
+    end
+    printf "run list:\n"
+    set $t = *(struct os_task **) $mynewt_run_list
+    while $t
+        printf "    %-16s prio %d\n", $t->t_name, $t->t_prio
+        set $t = $t->t_os_list.tqe_next
+    end
+    printf "sleep list:\n"
+    set $t = *(struct os_task **) $mynewt_sleep_list
+    while $t
+        if $t->t_flags & 0x1
+            printf "    %-16s prio %d, forever\n", $t->t_name, $t->t_prio
+        else
+            printf "    %-16s prio %d, wakeup %u\n", $t->t_name, $t->t_prio, $t->t_next_wakeup
+        end
+        set $t = $t->t_os_list.tqe_next
+    end`,
+	},
+}
+
+// The convenience variable through which the generated commands refer to
+// each symbol's address.
+var gdbSymVars = map[string]string{
+	GDB_SYM_TASK_LIST:     "$mynewt_task_list",
+	GDB_SYM_RUN_LIST:      "$mynewt_run_list",
+	GDB_SYM_SLEEP_LIST:    "$mynewt_sleep_list",
+	GDB_SYM_TIME:          "$mynewt_time",
+	GDB_SYM_MSYS_POOLS:    "$mynewt_msys_pools",
+	GDB_SYM_MEMPOOLS:      "$mynewt_mempools",
+	COREDUMP_CUR_TASK_SYM: "$mynewt_current_task",
+}
+
+// Returns the path of the GDB script generated for the builder's executable.
+func (b *Builder) AppGdbInitPath() string {
+	return b.AppBinBasePath() + ".gdbinit"
+}
+
+// Returns the addresses of the kernel symbols in the specified executable.
+func readGdbSyms(elfPath string) (map[string]uint64, error) {
+	f, err := elf.Open(elfPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := map[string]uint64{}
+	for _, s := range syms {
+		if _, ok := gdbSymVars[s.Name]; ok {
+			addrs[s.Name] = s.Value
+		}
+	}
+
+	return addrs, nil
+}
+
+// Generates the contents of a GDB script defining the commands supported by
+// an executable with the specified symbol addresses.
+func gdbInitScript(elfPath string, addrs map[string]uint64) []byte {
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "# Generated by %s for %s\n",
+		newtutil.NewtVersionStr, filepath.ToSlash(elfPath))
+	fmt.Fprintf(buf, "# Symbol addresses are those of this build; "+
+		"rebuild to regenerate.\n")
+
+	names := make([]string, 0, len(addrs))
+	for name, _ := range addrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(names) > 0 {
+		fmt.Fprintf(buf, "\n")
+	}
+	for _, name := range names {
+		fmt.Fprintf(buf, "set %s = 0x%x\n", gdbSymVars[name], addrs[name])
+	}
+
+	for _, cmd := range gdbCommands {
+		supported := true
+		for _, sym := range cmd.syms {
+			if _, ok := addrs[sym]; !ok {
+				supported = false
+			}
+		}
+		if !supported {
+			continue
+		}
+
+		fmt.Fprintf(buf, "\ndefine %s%s\nend\n", cmd.name, cmd.body)
+		fmt.Fprintf(buf, "document %s\n%s\nend\n", cmd.name, cmd.help)
+	}
+
+	return buf.Bytes()
+}
+
+// Writes the GDB script for the builder's freshly linked executable.  The
+// script is only rewritten if the symbol addresses changed.  Executables
+// that aren't ELF files (e.g., sim builds on macOS) don't get a script.
+func (b *Builder) writeGdbInit() error {
+	elfPath := b.AppElfPath()
+	addrs, err := readGdbSyms(elfPath)
+	if err != nil {
+		log.Debugf("Not generating GDB script for %s: %s", elfPath,
+			err.Error())
+		return nil
+	}
+
+	path := b.AppGdbInitPath()
+	contents := gdbInitScript(elfPath, addrs)
+
+	changed, err := util.FileContentsChanged(path, contents)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "Generating GDB script %s\n",
+		path)
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Writes the GDB scripts of the target's executables; see writeGdbInit().
+func (t *TargetBuilder) writeGdbInits() error {
+	for _, b := range []*Builder{t.AppBuilder, t.LoaderBuilder} {
+		if b == nil || b.appPkg == nil {
+			continue
+		}
+		if err := b.writeGdbInit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the gdb arguments that load the GDB script generated for the
+// specified executable, or nothing if there is none.
+func gdbInitArgs(elfPath string) []string {
+	path := strings.TrimSuffix(elfPath, ".elf") + ".gdbinit"
+	if util.NodeNotExist(path) {
+		return nil
+	}
+
+	return []string{"-x", path}
+}
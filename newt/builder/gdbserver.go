@@ -0,0 +1,252 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+// How long to wait for a GDB server to start accepting connections.
+const GDB_SERVER_TIMEOUT = 10 * time.Second
+
+// Options for attaching a debugger to a running target.
+type AttachOptions struct {
+	// Port of the GDB server; 0 means the probe's port.
+	GdbPort int
+
+	// Reset and halt the target after attaching.
+	Reset bool
+
+	// Additional gdb commands, run after connecting.
+	Commands []string
+
+	// Only run the GDB server, in the foreground.
+	NoGDB bool
+}
+
+// A GDB server process started by newt.  A nil *gdbServer stands for a
+// server that was already running.
+type gdbServer struct {
+	cmd     *exec.Cmd
+	logFile *os.File
+	done    chan struct{}
+}
+
+func gdbServerListening(port int) bool {
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:"+strconv.Itoa(port),
+		time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Polls until the server accepts connections on its port, the server process
+// exits, or the timeout expires.
+func (gs *gdbServer) waitListening(port int) error {
+	deadline := time.Now().Add(GDB_SERVER_TIMEOUT)
+
+	for !gdbServerListening(port) {
+		select {
+		case <-gs.done:
+			return util.FmtNewtError("GDB server exited unexpectedly; "+
+				"see %s", gs.logFile.Name())
+		case <-time.After(200 * time.Millisecond):
+		}
+
+		if time.Now().After(deadline) {
+			return util.FmtNewtError("GDB server did not start listening "+
+				"on port %d within %s; see %s", port, GDB_SERVER_TIMEOUT,
+				gs.logFile.Name())
+		}
+	}
+
+	return nil
+}
+
+// Stops the server and waits for it to exit.
+func (gs *gdbServer) stop() {
+	if gs == nil {
+		return
+	}
+
+	util.KillProcessTree(gs.cmd.Process)
+	<-gs.done
+	gs.logFile.Close()
+}
+
+// Starts the probe's GDB server in the background, unless a server is already
+// listening on the port, in which case that one is reused.  The server's
+// output is written to logPath.
+func startGdbServer(probe *pkg.BspProbe, port int,
+	logPath string) (*gdbServer, error) {
+
+	if gdbServerListening(port) {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Reusing GDB server already listening on port %d\n", port)
+		return nil, nil
+	}
+
+	serverCmd := probeGdbServerCmd(probe, port)
+	if err := lookPathCmd(probe.Name, serverCmd); err != nil {
+		return nil, err
+	}
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "%s\n",
+		strings.Join(serverCmd, " "))
+
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	gs := &gdbServer{
+		cmd:     exec.Command(serverCmd[0], serverCmd[1:]...),
+		logFile: logFile,
+		done:    make(chan struct{}),
+	}
+	gs.cmd.Stdout = logFile
+	gs.cmd.Stderr = logFile
+	gs.cmd.SysProcAttr = probeSysProcAttr()
+	if err := gs.cmd.Start(); err != nil {
+		logFile.Close()
+		return nil, util.FmtNewtError("Failed to start %s GDB server: %s",
+			probe.Name, err.Error())
+	}
+	go func() {
+		gs.cmd.Wait()
+		close(gs.done)
+	}()
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Started %s GDB server on port %d; log: %s\n",
+		probe.Name, port, logPath)
+	if err := gs.waitListening(port); err != nil {
+		gs.stop()
+		return nil, err
+	}
+
+	return gs, nil
+}
+
+// Runs a debugging session: starts (or reuses) the probe's GDB server and
+// runs the toolchain's gdb against the specified executable, then stops the
+// server once gdb exits.  gdbCmds are run after connecting.  If noGDB is set,
+// only the server is run, in the foreground.
+func (t *TargetBuilder) gdbSession(probe *pkg.BspProbe, elfPath string,
+	port int, gdbCmds []string, noGDB bool) error {
+
+	if util.NodeNotExist(elfPath) {
+		return util.FmtNewtError("No executable to debug: %s", elfPath)
+	}
+
+	if noGDB {
+		if gdbServerListening(port) {
+			return util.FmtNewtError("A GDB server is already listening "+
+				"on port %d", port)
+		}
+
+		serverCmd := probeGdbServerCmd(probe, port)
+		if err := lookPathCmd(probe.Name, serverCmd); err != nil {
+			return err
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"GDB server listening on port %d; attach with: "+
+				"gdb %s -ex \"target remote :%d\"\n",
+			port, elfPath, port)
+		return util.ShellInteractiveCommand(serverCmd, nil)
+	}
+
+	c, err := t.NewCompiler(t.AppBuilder.BinDir())
+	if err != nil {
+		return err
+	}
+	gdbCmd := []string{c.GdbPath(), elfPath}
+	gdbCmd = append(gdbCmd, gdbInitArgs(elfPath)...)
+	gdbCmd = append(gdbCmd, "-ex", "target remote :"+strconv.Itoa(port))
+	for _, cmd := range gdbCmds {
+		gdbCmd = append(gdbCmd, "-ex", cmd)
+	}
+	// A containerized debugger is looked up inside the container.
+	if newtutil.NewtContainer == nil {
+		if err := lookPathCmd("debugger", gdbCmd); err != nil {
+			return err
+		}
+	}
+
+	logPath := filepath.Join(filepath.Dir(elfPath),
+		probe.Name+"-gdbserver.log")
+	gs, err := startGdbServer(probe, port, logPath)
+	if err != nil {
+		return err
+	}
+	defer gs.stop()
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "%s\n",
+		strings.Join(gdbCmd, " "))
+	gdbCmd, env := newtutil.InteractiveToolchainCmd(gdbCmd, t.target.Env())
+	return util.ShellInteractiveCommand(gdbCmd, env)
+}
+
+// Attaches the toolchain's debugger to the target's app without loading or
+// (by default) resetting it.  The GDB server comes from the selected probe
+// backend; see SetProbe().
+func (t *TargetBuilder) Attach(opts AttachOptions) error {
+	if err := t.PrepBuild(); err != nil {
+		return err
+	}
+
+	probe, err := t.selectedProbe()
+	if err != nil {
+		return err
+	}
+	if probe == nil {
+		supported := "none"
+		if names := ProbeNames(t.bspPkg); len(names) > 0 {
+			supported = strings.Join(names, ", ")
+		}
+		return util.FmtNewtError("Attaching requires a probe backend; "+
+			"select one with --jtag or the BSP's bsp.probe_default "+
+			"(supported by BSP %s: %s)", t.bspPkg.FullName(), supported)
+	}
+
+	port := opts.GdbPort
+	if port == 0 {
+		port = probeGdbPort(probe)
+	}
+
+	gdbCmds := []string{}
+	if opts.Reset {
+		gdbCmds = append(gdbCmds, probeGdbResetCmd(probe))
+	}
+	gdbCmds = append(gdbCmds, opts.Commands...)
+
+	return t.gdbSession(probe, t.AppBuilder.AppElfPath(), port, gdbCmds,
+		opts.NoGDB)
+}
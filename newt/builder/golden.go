@@ -0,0 +1,286 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+const GOLDEN_CHECK_HASH = "hash"
+const GOLDEN_CHECK_SECTIONS = "sections"
+const GOLDEN_CHECK_SYSCFG = "syscfg"
+
+var GoldenChecks = []string{
+	GOLDEN_CHECK_HASH,
+	GOLDEN_CHECK_SECTIONS,
+	GOLDEN_CHECK_SYSCFG,
+}
+
+// The properties of one image (app or loader) recorded in a golden manifest.
+type GoldenImage struct {
+	// SHA256 of the image's flat binary (.elf.bin).
+	Hash string `json:"hash"`
+
+	// Size of each loadable section of the elf file.
+	Sections map[string]int `json:"sections"`
+}
+
+// A snapshot of a build against which later builds of the same target are
+// compared to detect unexpected changes.
+type GoldenManifest struct {
+	Target string                  `json:"target"`
+	Images map[string]*GoldenImage `json:"images"`
+	Syscfg map[string]string       `json:"syscfg"`
+}
+
+// Parses the sysv-format output of the "size" utility:
+//
+//	app.elf  :
+//	section   size      addr
+//	.text     12345     134217728
+//
+// Sections at address 0 (debug info, comments, etc.) are not part of the
+// image and are skipped.
+func parseElfSections(output string) map[string]int {
+	sections := map[string]int{}
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || !strings.HasPrefix(fields[0], ".") {
+			continue
+		}
+
+		size, err := util.AtoiNoOct(fields[1])
+		if err != nil {
+			continue
+		}
+		addr, err := util.AtoiNoOct(fields[2])
+		if err != nil || addr == 0 {
+			continue
+		}
+
+		sections[fields[0]] = size
+	}
+
+	return sections
+}
+
+func (b *Builder) goldenImage() (*GoldenImage, error) {
+	data, err := ioutil.ReadFile(b.AppBinPath())
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	c, err := b.newCompiler(b.appPkg, b.FileBinDir(b.AppElfPath()))
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := c.PrintSectionSizes(b.AppElfPath())
+	if err != nil {
+		return nil, err
+	}
+
+	return &GoldenImage{
+		Hash:     fmt.Sprintf("%x", sha256.Sum256(data)),
+		Sections: parseElfSections(output),
+	}, nil
+}
+
+// Describes the most recent build of the target.  The target must have been
+// built already.
+func (t *TargetBuilder) GoldenManifest() (*GoldenManifest, error) {
+	gm := &GoldenManifest{
+		Target: t.target.FullName(),
+		Images: map[string]*GoldenImage{},
+		Syscfg: map[string]string{},
+	}
+
+	for _, b := range []*Builder{t.LoaderBuilder, t.AppBuilder} {
+		if b == nil {
+			continue
+		}
+
+		img, err := b.goldenImage()
+		if err != nil {
+			return nil, err
+		}
+		gm.Images[b.buildName] = img
+	}
+
+	for name, entry := range t.res.Cfg.Settings {
+		gm.Syscfg[name] = entry.Value
+	}
+
+	return gm, nil
+}
+
+func ReadGoldenManifest(path string) (*GoldenManifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	gm := &GoldenManifest{}
+	if err := json.Unmarshal(data, gm); err != nil {
+		return nil, util.FmtNewtError(
+			"Failure decoding golden manifest %s: %s", path, err.Error())
+	}
+
+	return gm, nil
+}
+
+func (gm *GoldenManifest) Write(path string) error {
+	data, err := json.MarshalIndent(gm, "", "    ")
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k, _ := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func diffSections(img string, golden map[string]int,
+	cur map[string]int) []string {
+
+	names := map[string]bool{}
+	for name, _ := range golden {
+		names[name] = true
+	}
+	for name, _ := range cur {
+		names[name] = true
+	}
+
+	diffs := []string{}
+	for _, name := range sortedKeys(names) {
+		g, inGolden := golden[name]
+		c, inCur := cur[name]
+		switch {
+		case !inCur:
+			diffs = append(diffs, fmt.Sprintf("%s: section %s removed "+
+				"(was %d bytes)", img, name, g))
+		case !inGolden:
+			diffs = append(diffs, fmt.Sprintf("%s: section %s added "+
+				"(%d bytes)", img, name, c))
+		case g != c:
+			diffs = append(diffs, fmt.Sprintf("%s: section %s changed "+
+				"size: %d -> %d (%+d)", img, name, g, c, c-g))
+		}
+	}
+
+	return diffs
+}
+
+func diffSyscfg(golden map[string]string, cur map[string]string) []string {
+	names := map[string]bool{}
+	for name, _ := range golden {
+		names[name] = true
+	}
+	for name, _ := range cur {
+		names[name] = true
+	}
+
+	diffs := []string{}
+	for _, name := range sortedKeys(names) {
+		g, inGolden := golden[name]
+		c, inCur := cur[name]
+		switch {
+		case !inCur:
+			diffs = append(diffs, fmt.Sprintf("syscfg: %s removed "+
+				"(was \"%s\")", name, g))
+		case !inGolden:
+			diffs = append(diffs, fmt.Sprintf("syscfg: %s added (\"%s\")",
+				name, c))
+		case g != c:
+			diffs = append(diffs, fmt.Sprintf("syscfg: %s changed: "+
+				"\"%s\" -> \"%s\"", name, g, c))
+		}
+	}
+
+	return diffs
+}
+
+// Compares a build against a golden manifest and describes each difference.
+// Only the properties named in checks (GOLDEN_CHECK_*) are compared.
+func (gm *GoldenManifest) Diff(cur *GoldenManifest,
+	checks []string) []string {
+
+	enabled := map[string]bool{}
+	for _, c := range checks {
+		enabled[c] = true
+	}
+
+	diffs := []string{}
+
+	imgs := map[string]bool{}
+	for name, _ := range gm.Images {
+		imgs[name] = true
+	}
+	for name, _ := range cur.Images {
+		imgs[name] = true
+	}
+
+	for _, name := range sortedKeys(imgs) {
+		g := gm.Images[name]
+		c := cur.Images[name]
+		switch {
+		case c == nil:
+			diffs = append(diffs, fmt.Sprintf("%s: image missing", name))
+			continue
+		case g == nil:
+			diffs = append(diffs, fmt.Sprintf("%s: unexpected image", name))
+			continue
+		}
+
+		if enabled[GOLDEN_CHECK_HASH] && g.Hash != c.Hash {
+			diffs = append(diffs, fmt.Sprintf("%s: hash changed: %s -> %s",
+				name, g.Hash, c.Hash))
+		}
+		if enabled[GOLDEN_CHECK_SECTIONS] {
+			diffs = append(diffs, diffSections(name, g.Sections,
+				c.Sections)...)
+		}
+	}
+
+	if enabled[GOLDEN_CHECK_SYSCFG] {
+		diffs = append(diffs, diffSyscfg(gm.Syscfg, cur.Syscfg)...)
+	}
+
+	return diffs
+}
@@ -0,0 +1,369 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
+)
+
+const HERMETIC_TRACE_DIR = "hermetic"
+
+// Kinds of undeclared build inputs.
+const (
+	// A project file outside the packages in the build.
+	HERMETIC_KIND_PROJECT = "project"
+
+	// A file outside the project, the toolchain and the system directories.
+	HERMETIC_KIND_EXTERNAL = "external"
+)
+
+// Directories the toolchain may always read from.
+var hermeticSystemDirs = []string{
+	"/bin",
+	"/dev",
+	"/etc",
+	"/lib",
+	"/lib32",
+	"/lib64",
+	"/proc",
+	"/sbin",
+	"/sys",
+	"/tmp",
+	"/usr",
+}
+
+// A file the toolchain read that is not among the build's declared inputs.
+type HermeticViolation struct {
+	Path  string   `json:"path"`
+	Kind  string   `json:"kind"`
+	Tools []string `json:"tools"`
+}
+
+type HermeticReport struct {
+	Target     string              `json:"target"`
+	Commands   int                 `json:"commands"`
+	Files      int                 `json:"files"`
+	Violations []HermeticViolation `json:"violations"`
+}
+
+var hermeticCallRe = regexp.MustCompile(
+	`^(\d+)\s+(open|openat|execve)\((?:AT_FDCWD, |-?\d+, )?` +
+		`"((?:[^"\\]|\\.)*)"(.*)$`)
+var hermeticResumedRe = regexp.MustCompile(
+	`^(\d+)\s+<\.\.\. (open|openat|execve) resumed>(.*)$`)
+var hermeticResultRe = regexp.MustCompile(`\)\s+= (-?\d+)`)
+
+// Indicates whether a traced call succeeded and, for opens, whether it read
+// the file.  Writes are outputs, and directory opens are only searches.
+func hermeticIsRead(call string, rest string) bool {
+	m := hermeticResultRe.FindStringSubmatch(rest)
+	if m == nil {
+		return false
+	}
+	if ret, _ := strconv.Atoi(m[1]); ret < 0 {
+		return false
+	}
+	if call == "execve" {
+		return true
+	}
+	return !strings.Contains(rest, "O_WRONLY") &&
+		!strings.Contains(rest, "O_DIRECTORY")
+}
+
+// Reads the files successfully read or executed according to an strace log.
+// Relative paths are resolved against newt's working directory, which the
+// toolchain inherits.
+func readHermeticTrace(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	defer f.Close()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	files := []string{}
+	add := func(file string) {
+		if uq, err := strconv.Unquote(`"` + file + `"`); err == nil {
+			file = uq
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(wd, file)
+		}
+		files = append(files, filepath.Clean(file))
+	}
+
+	// Calls interrupted by another process's output are completed by a
+	// later "resumed" line.
+	type pendingCall struct {
+		call string
+		file string
+		rest string
+	}
+	pending := map[string]pendingCall{}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := hermeticCallRe.FindStringSubmatch(line); m != nil {
+			if strings.HasSuffix(m[4], "<unfinished ...>") {
+				pending[m[1]] = pendingCall{m[2], m[3], m[4]}
+			} else if hermeticIsRead(m[2], m[4]) {
+				add(m[3])
+			}
+		} else if m := hermeticResumedRe.FindStringSubmatch(line); m != nil {
+			p, ok := pending[m[1]]
+			if !ok || p.call != m[2] {
+				continue
+			}
+			delete(pending, m[1])
+			if hermeticIsRead(p.call, p.rest+m[3]) {
+				add(p.file)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	return files, nil
+}
+
+// Returns the installation directory of a toolchain executable: the parent
+// of the directory containing it (e.g., /opt/gcc-arm for
+// /opt/gcc-arm/bin/arm-none-eabi-gcc).  Symlinks are followed, and both the
+// linked and real directories are returned.
+func hermeticToolDirs(tool string) []string {
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return nil
+	}
+
+	paths := []string{path}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		paths = append(paths, real)
+	}
+
+	dirs := []string{}
+	for _, p := range paths {
+		if abs, err := filepath.Abs(p); err == nil {
+			dir := filepath.Dir(filepath.Dir(abs))
+			if dir != filepath.Dir(dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// Returns the directories the build may read from: the packages in the
+// build, the compiler package, the target's bin directory, the toolchain's
+// installation directories, the system directories and the supplied extra
+// directories.
+func (t *TargetBuilder) hermeticAllowedDirs(extra []string) []string {
+	projDir := project.GetProject().Path()
+	absPath := func(path string) string {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(projDir, path)
+		}
+		return filepath.Clean(path)
+	}
+
+	dirs := []string{
+		absPath(t.compilerPkg.BasePath()),
+		absPath(TargetBinDir(t.target.Name())),
+	}
+	for _, b := range t.builders() {
+		for _, bpkg := range b.PkgMap {
+			dirs = append(dirs, absPath(bpkg.rpkg.Lpkg.BasePath()))
+		}
+	}
+
+	if c, err := t.NewCompiler(t.AppBuilder.BinDir()); err == nil {
+		cc, cpp, as := c.ToolPaths()
+		for _, tool := range []string{cc, cpp, as} {
+			if tool != "" {
+				dirs = append(dirs, hermeticToolDirs(tool)...)
+			}
+		}
+	}
+
+	dirs = append(dirs, hermeticSystemDirs...)
+	for _, dir := range extra {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dirs = append(dirs, abs)
+		}
+	}
+
+	return util.UniqueStrings(dirs)
+}
+
+func hermeticInDir(path string, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+type violationSorter struct {
+	violations []HermeticViolation
+}
+
+func (s violationSorter) Len() int {
+	return len(s.violations)
+}
+func (s violationSorter) Swap(i, j int) {
+	s.violations[i], s.violations[j] = s.violations[j], s.violations[i]
+}
+func (s violationSorter) Less(i, j int) bool {
+	return s.violations[i].Path < s.violations[j].Path
+}
+
+// Rebuilds the target from scratch with each toolchain command traced by
+// strace, and reports the files the toolchain read that are outside the
+// build's declared inputs: the packages in the build, the target's bin
+// directory, the toolchain's installation directory and the system
+// directories.  Directories in allow are also accepted.  Such files are not
+// tracked by incremental builds, so a change to them goes unnoticed.
+//
+// Only supported on Linux, without a toolchain container.
+func (t *TargetBuilder) CheckHermetic(allow []string) (*HermeticReport,
+	error) {
+
+	if runtime.GOOS != "linux" {
+		return nil, util.NewNewtError(
+			"Hermeticity checks are only supported on Linux")
+	}
+	if newtutil.NewtContainer != nil {
+		return nil, util.NewNewtError(
+			"Hermeticity checks are not supported with a toolchain " +
+				"container")
+	}
+	strace, err := exec.LookPath("strace")
+	if err != nil {
+		return nil, util.NewNewtError(
+			"Hermeticity checks require strace; it was not found in "+
+				"PATH").WithClass(util.ERR_CLASS_TOOL_MISSING)
+	}
+
+	// Every command must run to be traced, so discard the previous build.
+	targetName := t.target.Name()
+	for _, buildName := range []string{BUILD_NAME_APP, BUILD_NAME_LOADER} {
+		if err := os.RemoveAll(BinDir(targetName, buildName)); err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+	}
+	traceDir := filepath.Join(TargetBinDir(targetName), HERMETIC_TRACE_DIR)
+	if err := os.RemoveAll(traceDir); err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	if err := os.MkdirAll(traceDir, 0755); err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	// Trace file -> name of the tool it traces.
+	traces := map[string]string{}
+	var mtx sync.Mutex
+
+	newtutil.ToolchainWrapper = func(cmd []string) []string {
+		mtx.Lock()
+		path := filepath.Join(traceDir,
+			fmt.Sprintf("%d.trace", len(traces)))
+		traces[path] = filepath.Base(cmd[0])
+		mtx.Unlock()
+
+		wrapped := []string{strace, "-f", "-qq",
+			"-e", "trace=open,openat,execve", "-o", path, "--"}
+		return append(wrapped, cmd...)
+	}
+	defer func() { newtutil.ToolchainWrapper = nil }()
+
+	if err := t.Build(); err != nil {
+		return nil, err
+	}
+
+	dirs := t.hermeticAllowedDirs(allow)
+	projDir := filepath.Clean(project.GetProject().Path())
+
+	report := &HermeticReport{
+		Target:   t.target.FullName(),
+		Commands: len(traces),
+	}
+
+	files := map[string]struct{}{}
+	violations := map[string]*HermeticViolation{}
+	for path, tool := range traces {
+		read, err := readHermeticTrace(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range read {
+			files[file] = struct{}{}
+
+			allowed := false
+			for _, dir := range dirs {
+				if hermeticInDir(file, dir) {
+					allowed = true
+					break
+				}
+			}
+			if allowed {
+				continue
+			}
+
+			v := violations[file]
+			if v == nil {
+				kind := HERMETIC_KIND_EXTERNAL
+				if hermeticInDir(file, projDir) {
+					kind = HERMETIC_KIND_PROJECT
+				}
+				v = &HermeticViolation{Path: file, Kind: kind}
+				violations[file] = v
+			}
+			v.Tools = append(v.Tools, tool)
+		}
+	}
+
+	report.Files = len(files)
+	for _, v := range violations {
+		v.Tools = util.UniqueStrings(v.Tools)
+		sort.Strings(v.Tools)
+		report.Violations = append(report.Violations, *v)
+	}
+	sort.Sort(violationSorter{report.Violations})
+
+	return report, nil
+}
@@ -0,0 +1,386 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/image"
+	"mynewt.apache.org/newt/util"
+)
+
+const HISTORY_ENTRY_FILENAME = "build.json"
+const HISTORY_MANIFEST_FILENAME = "manifest.json"
+const HISTORY_SYSCFG_FILENAME = "syscfg.json"
+
+// A build recorded in a target's history.  Each build is kept in its own
+// directory under HistoryDir(), along with copies of its manifest, linker
+// maps and syscfg.
+type HistoryEntry struct {
+	Id   int       `json:"id"`
+	Time time.Time `json:"time"`
+
+	// Total size of each memory region, per image (app, loader).
+	Totals map[string]map[string]uint32 `json:"totals,omitempty"`
+
+	// Commit of each repo, as recorded in the manifest.
+	Repos []image.ImageManifestRepo `json:"repos,omitempty"`
+
+	Dir string `json:"-"`
+}
+
+type HistoryDiff struct {
+	Old    int             `json:"old"`
+	New    int             `json:"new"`
+	Sizes  []ImageSizeDiff `json:"sizes"`
+	Syscfg []string        `json:"syscfg"`
+}
+
+func historyEntryDir(targetName string, id int) string {
+	return filepath.Join(HistoryDir(targetName), strconv.Itoa(id))
+}
+
+func writeJsonFile(path string, itf interface{}) error {
+	data, err := json.MarshalIndent(itf, "", "    ")
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+func readJsonFile(path string, itf interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	if err := json.Unmarshal(data, itf); err != nil {
+		return util.FmtNewtError("Failure decoding %s: %s", path,
+			err.Error())
+	}
+
+	return nil
+}
+
+type historyEntrySorter struct {
+	entries []*HistoryEntry
+}
+
+func (s historyEntrySorter) Len() int {
+	return len(s.entries)
+}
+func (s historyEntrySorter) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+}
+func (s historyEntrySorter) Less(i, j int) bool {
+	return s.entries[i].Id < s.entries[j].Id
+}
+
+// Reads the target's build history, oldest build first.
+func ReadHistory(targetName string) ([]*HistoryEntry, error) {
+	dir := HistoryDir(targetName)
+	if util.NodeNotExist(dir) {
+		return nil, nil
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	entries := []*HistoryEntry{}
+	for _, info := range infos {
+		id, err := strconv.Atoi(info.Name())
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		entry := &HistoryEntry{}
+		path := filepath.Join(dir, info.Name(), HISTORY_ENTRY_FILENAME)
+		if err := readJsonFile(path, entry); err != nil {
+			log.Debugf("Skipping history entry %d: %s", id, err.Error())
+			continue
+		}
+		entry.Id = id
+		entry.Dir = filepath.Join(dir, info.Name())
+
+		entries = append(entries, entry)
+	}
+
+	sort.Sort(historyEntrySorter{entries})
+
+	return entries, nil
+}
+
+// Looks up a build in the target's history.  A negative id counts back from
+// the most recent build (-1 is the latest).
+func FindHistoryEntry(targetName string, id int) (*HistoryEntry, error) {
+	entries, err := ReadHistory(targetName)
+	if err != nil {
+		return nil, err
+	}
+
+	if id < 0 {
+		if -id > len(entries) {
+			return nil, util.FmtNewtError(
+				"Target %s has only %d builds in its history", targetName,
+				len(entries))
+		}
+		return entries[len(entries)+id], nil
+	}
+
+	for _, e := range entries {
+		if e.Id == id {
+			return e, nil
+		}
+	}
+
+	return nil, util.FmtNewtError("Build %d not in %s's history", id,
+		targetName)
+}
+
+// Copies the artifacts of the build that just completed into a new history
+// entry and discards entries beyond the target's history depth.  The history
+// is a convenience, so failures are only reported as warnings.
+func (t *TargetBuilder) recordHistory() {
+	depth, err := t.target.HistoryDepth()
+	if err == nil && depth > 0 {
+		err = t.addHistoryEntry(depth)
+	}
+
+	if err != nil {
+		util.StatusMessage(util.VERBOSITY_QUIET,
+			"* Warning: failed to record build history: %s\n",
+			err.Error())
+	}
+}
+
+func (t *TargetBuilder) addHistoryEntry(depth int) error {
+	targetName := t.target.Name()
+
+	entries, err := ReadHistory(targetName)
+	if err != nil {
+		return err
+	}
+
+	entry := &HistoryEntry{
+		Id:     1,
+		Time:   time.Now(),
+		Totals: map[string]map[string]uint32{},
+	}
+	if len(entries) > 0 {
+		entry.Id = entries[len(entries)-1].Id + 1
+	}
+	entry.Dir = historyEntryDir(targetName, entry.Id)
+
+	if err := os.MkdirAll(entry.Dir, 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	manifestPath := t.AppBuilder.ManifestPath()
+	if manifest, err := readManifest(manifestPath); err == nil {
+		entry.Repos = manifest.Repos
+	}
+	if err := util.CopyFile(manifestPath,
+		filepath.Join(entry.Dir, HISTORY_MANIFEST_FILENAME)); err != nil {
+
+		return err
+	}
+
+	for _, b := range t.builders() {
+		mapPath := b.AppElfPath() + ".map"
+		if util.NodeExist(mapPath) {
+			dst := filepath.Join(entry.Dir, b.buildName+".elf.map")
+			if err := util.CopyFile(mapPath, dst); err != nil {
+				return err
+			}
+		}
+
+		if t.bspPkg.Arch == "sim" {
+			continue
+		}
+		summary, err := b.SizeSummary(SizeOptions{})
+		if err != nil {
+			log.Debugf("Failed to calculate %s size: %s", b.buildName,
+				err.Error())
+			continue
+		}
+		entry.Totals[b.buildName] = summary.Totals
+	}
+
+	settings := map[string]string{}
+	for name, e := range t.res.Cfg.Settings {
+		settings[name] = e.Value
+	}
+	err = writeJsonFile(filepath.Join(entry.Dir, HISTORY_SYSCFG_FILENAME),
+		settings)
+	if err != nil {
+		return err
+	}
+
+	err = writeJsonFile(filepath.Join(entry.Dir, HISTORY_ENTRY_FILENAME),
+		entry)
+	if err != nil {
+		return err
+	}
+
+	// Discard the oldest builds.
+	entries = append(entries, entry)
+	for len(entries) > depth {
+		if err := os.RemoveAll(entries[0].Dir); err != nil {
+			return util.ChildNewtError(err)
+		}
+		entries = entries[1:]
+	}
+
+	return nil
+}
+
+func (e *HistoryEntry) manifest() (*image.ImageManifest, error) {
+	return readManifest(filepath.Join(e.Dir, HISTORY_MANIFEST_FILENAME))
+}
+
+func (e *HistoryEntry) Syscfg() (map[string]string, error) {
+	settings := map[string]string{}
+	err := readJsonFile(filepath.Join(e.Dir, HISTORY_SYSCFG_FILENAME),
+		&settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// Reads an image's sizes from a recorded build, preferring the manifest's
+// size information and falling back to the copy of the linker map.
+func (e *HistoryEntry) imageSizes(buildName string) (*imageSizes, error) {
+	manifest, err := e.manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := manifest.PkgSizes
+	if buildName == BUILD_NAME_LOADER {
+		pkgs = manifest.LoaderPkgSizes
+	}
+	if len(pkgs) > 0 {
+		return manifestImageSizes(pkgs), nil
+	}
+
+	mapPath := filepath.Join(e.Dir, buildName+".elf.map")
+	if util.NodeNotExist(mapPath) {
+		return nil, nil
+	}
+
+	libs, err := ParseMapFileSizes(mapPath)
+	if err != nil {
+		return nil, err
+	}
+
+	is := newImageSizes()
+	is.addLibs(libs, util.FilenameFromPath)
+
+	return is, nil
+}
+
+func sizeRegions(sizes ...*imageSizes) []string {
+	regions := map[string]bool{}
+	for _, is := range sizes {
+		for _, pkgSizes := range is.pkgs {
+			for r, _ := range pkgSizes {
+				regions[r] = true
+			}
+		}
+	}
+
+	return sortedKeys(regions)
+}
+
+// Compares the sizes and syscfg of two builds in the target's history.  At
+// most maxSyms changed symbols are reported per image; a negative value means
+// no limit.
+func DiffHistory(targetName string, oldId int, newId int,
+	maxSyms int) (*HistoryDiff, error) {
+
+	old, err := FindHistoryEntry(targetName, oldId)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := FindHistoryEntry(targetName, newId)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &HistoryDiff{
+		Old:    old.Id,
+		New:    cur.Id,
+		Sizes:  []ImageSizeDiff{},
+		Syscfg: []string{},
+	}
+
+	for _, name := range []string{BUILD_NAME_LOADER, BUILD_NAME_APP} {
+		o, err := old.imageSizes(name)
+		if err != nil {
+			return nil, err
+		}
+		c, err := cur.imageSizes(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if o == nil && c == nil {
+			continue
+		}
+		if o == nil {
+			o = newImageSizes()
+		}
+		if c == nil {
+			c = newImageSizes()
+		}
+
+		diff.Sizes = append(diff.Sizes, diffImageSizes(name,
+			sizeRegions(o, c), o, c, maxSyms))
+	}
+
+	oldCfg, err := old.Syscfg()
+	if err != nil {
+		return nil, err
+	}
+	curCfg, err := cur.Syscfg()
+	if err != nil {
+		return nil, err
+	}
+	diff.Syscfg = diffSyscfg(oldCfg, curCfg)
+
+	return diff, nil
+}
@@ -0,0 +1,116 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/image"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
+)
+
+// Build hooks that a target can define in its target.yml.  Each hook is
+// configured with a "target.hooks.<name>" setting containing a shell command.
+const (
+	HOOK_PRE_BUILD         = "pre_build"
+	HOOK_POST_LINK         = "post_link"
+	HOOK_POST_CREATE_IMAGE = "post_create_image"
+)
+
+// Returns the environment variables describing the target that are passed to
+// every hook.
+func (t *TargetBuilder) hookEnv() []string {
+	env := []string{
+		"NEWT_TARGET=" + t.target.Name(),
+		"NEWT_APP=" + t.target.AppName,
+		"NEWT_BSP=" + t.target.BspName,
+		"NEWT_BUILD_PROFILE=" + t.target.BuildProfile,
+		"NEWT_PROJECT_DIR=" + project.GetProject().Path(),
+		"NEWT_BIN_DIR=" + TargetBinDir(t.target.Name()),
+	}
+
+	if t.AppBuilder != nil && t.AppBuilder.appPkg != nil {
+		env = append(env, "NEWT_ELF="+t.AppBuilder.AppElfPath())
+	}
+	if t.LoaderBuilder != nil && t.LoaderBuilder.appPkg != nil {
+		env = append(env, "NEWT_LOADER_ELF="+t.LoaderBuilder.AppElfPath())
+	}
+
+	return env
+}
+
+// Returns the environment variables describing a generated image.  The prefix
+// distinguishes the app image from the loader image.
+func imageHookEnv(prefix string, img *image.Image) []string {
+	if img == nil {
+		return nil
+	}
+
+	return []string{
+		prefix + "=" + img.TargetImg,
+		prefix + "_HASH=" + hex.EncodeToString(img.Hash),
+		prefix + "_VERSION=" + fmt.Sprintf("%d.%d.%d.%d", img.Version.Major,
+			img.Version.Minor, img.Version.Rev, img.Version.BuildNum),
+	}
+}
+
+// Runs the target's command for the specified hook, if it defines one.  The
+// command is executed by the shell from the project directory, with
+// information about the build in its environment.  A failing hook fails the
+// build.
+func (t *TargetBuilder) runHook(hook string, extraEnv []string) error {
+	cmdStr := strings.TrimSpace(t.target.Hook(hook))
+	if cmdStr == "" {
+		return nil
+	}
+
+	var cmd []string
+	if runtime.GOOS == "windows" {
+		cmd = []string{"cmd", "/C", cmdStr}
+	} else {
+		cmd = []string{"sh", "-c", cmdStr}
+	}
+
+	env := append(t.target.Env(), t.hookEnv()...)
+	env = append(env, "NEWT_HOOK="+hook)
+	env = append(env, extraEnv...)
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Running %s hook: %s\n",
+		hook, cmdStr)
+
+	if err := os.Chdir(project.GetProject().Path()); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	out, err := util.ShellCommand(cmd, env)
+	if err != nil {
+		return util.FmtNewtError("%s hook of target %s failed: %s", hook,
+			t.target.Name(), strings.TrimSpace(err.(*util.NewtError).Text))
+	}
+	if len(out) > 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s", out)
+	}
+
+	return nil
+}
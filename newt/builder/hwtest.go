@@ -0,0 +1,205 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+// Matches the line a test app prints once all of its tests have run, unless
+// a different pattern is specified.
+const HW_TEST_DONE_PATTERN = `(?i)\btests? (done|complete|finished)\b`
+
+// Creates a target builder that links the specified test package into the
+// target's app to produce an image for real hardware.  Unlike the simulator
+// tests created by NewTargetTester(), the test package does not provide
+// main(); the target's app is responsible for running the tests and printing
+// their results to the console.
+func NewTargetHwTester(target *target.Target,
+	testPkg *pkg.LocalPackage) (*TargetBuilder, error) {
+
+	if err := target.Validate(true); err != nil {
+		return nil, err
+	}
+
+	t, err := NewTargetTester(target, testPkg)
+	if err != nil {
+		return nil, err
+	}
+	t.hwTest = true
+
+	return t, nil
+}
+
+// Source of the console output of a device under test.
+type TestConsole struct {
+	// Serial device to read from (e.g., /dev/ttyACM0) and its baud rate.
+	Device string
+	Baud   int
+
+	// Shell command whose stdout carries the console output (e.g., an RTT
+	// client).  Used instead of Device if set.
+	Cmd string
+}
+
+// Opens the console.  The returned function closes it.
+func (tc *TestConsole) open() (io.Reader, func(), error) {
+	if tc.Cmd != "" {
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", tc.Cmd)
+		} else {
+			cmd = exec.Command("sh", "-c", tc.Cmd)
+		}
+
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, util.ChildNewtError(err)
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, nil, util.FmtNewtError(
+				"Failed to start console command \"%s\": %s", tc.Cmd,
+				err.Error())
+		}
+
+		return stdout, func() {
+			util.KillProcessTree(cmd.Process)
+			cmd.Wait()
+		}, nil
+	}
+
+	if tc.Device == "" {
+		return nil, nil, util.NewNewtError("No test console specified")
+	}
+
+	if runtime.GOOS != "windows" {
+		devFlag := "-F"
+		if runtime.GOOS == "darwin" {
+			devFlag = "-f"
+		}
+		if _, err := util.ShellCommand([]string{"stty", devFlag, tc.Device,
+			strconv.Itoa(tc.Baud), "raw", "-echo"}, nil); err != nil {
+
+			return nil, nil, err
+		}
+	}
+
+	f, err := os.Open(tc.Device)
+	if err != nil {
+		return nil, nil, util.ChildNewtError(err)
+	}
+
+	return f, func() { f.Close() }, nil
+}
+
+// Loads the test image onto the device and collects the test results from
+// the console.  The console is opened before the image is loaded so that no
+// output is missed.  The run ends when a line matching donePattern is read or
+// when the timeout expires.
+func (t *TargetBuilder) RunHwTest(console *TestConsole, donePattern string,
+	timeout time.Duration) TestExeResult {
+
+	res := TestExeResult{Attempts: 1}
+	start := time.Now()
+	defer func() { res.Duration = time.Since(start) }()
+
+	doneRe, err := regexp.Compile(donePattern)
+	if err != nil {
+		res.Err = util.FmtNewtError("Invalid done pattern \"%s\": %s",
+			donePattern, err.Error())
+		return res
+	}
+
+	r, closeFn, err := console.open()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer closeFn()
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	if err := t.Load(""); err != nil {
+		res.Err = err
+		return res
+	}
+	log.Debugf("Test image loaded; reading console")
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		timer = time.After(timeout)
+	}
+
+	done := false
+	for !done {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				res.Err = util.NewNewtError(
+					"Console closed before the tests completed")
+				done = true
+				break
+			}
+
+			res.Output = append(res.Output, line...)
+			res.Output = append(res.Output, '\n')
+			if doneRe.MatchString(line) {
+				done = true
+			}
+
+		case <-timer:
+			res.TimedOut = true
+			res.Err = util.FmtNewtError(
+				"Tests did not complete within %s", timeout)
+			done = true
+		}
+	}
+
+	res.Cases = ParseTestCases(res.Output)
+	if res.Err == nil {
+		for _, c := range res.Cases {
+			if !c.Passed {
+				res.Err = util.NewNewtError("Test failure: " + c.Name)
+				break
+			}
+		}
+	}
+
+	return res
+}
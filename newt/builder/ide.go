@@ -0,0 +1,75 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/project"
+)
+
+// Everything an IDE's debug launch configuration needs to know about a
+// target.
+type IdeDebugInfo struct {
+	Target     string
+	ProjectDir string
+
+	ElfPath string
+	GdbPath string
+
+	// CMSIS-SVD file from the BSP (bsp.svd); empty if none.
+	SvdPath string
+
+	// Probe backend that provides the GDB server; nil if the BSP's scripts
+	// are used, in which case a server is expected on GdbPort.
+	Probe   *pkg.BspProbe
+	GdbPort int
+}
+
+// Collects the information needed to generate IDE debug configurations for
+// the target's app.  The probe backend is the one selected with SetProbe().
+func (t *TargetBuilder) IdeDebugInfo() (*IdeDebugInfo, error) {
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	probe, err := t.selectedProbe()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := t.NewCompiler(t.AppBuilder.BinDir())
+	if err != nil {
+		return nil, err
+	}
+
+	info := &IdeDebugInfo{
+		Target:     t.target.Name(),
+		ProjectDir: project.GetProject().Path(),
+		ElfPath:    t.AppBuilder.AppElfPath(),
+		GdbPath:    c.GdbPath(),
+		SvdPath:    t.bspPkg.SvdFile,
+		Probe:      probe,
+		GdbPort:    PYOCD_DEFAULT_GDB_PORT,
+	}
+	if probe != nil {
+		info.GdbPort = probeGdbPort(probe)
+	}
+
+	return info, nil
+}
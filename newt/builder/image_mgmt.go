@@ -0,0 +1,405 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/smp"
+	"mynewt.apache.org/newt/util"
+)
+
+// Connection string prefixes for image management.
+const SMP_CONN_SERIAL = "serial:"
+const SMP_CONN_UDP = "udp:"
+
+// How to reach a device's SMP (mcumgr) server.
+type SmpConnOptions struct {
+	// serial:<device>, udp:<host>:<port> or the name of a connection
+	// profile.  A bare device path means serial.  Empty selects the
+	// target's connection profile.
+	Conn string
+
+	// Serial baud rate; 0 means the BSP's or mcumgr's default.
+	Baud int
+
+	// Largest SMP packet the device accepts; 0 means the transport's
+	// default.
+	Mtu int
+
+	// Time to wait for each response; 0 means the default.
+	Timeout time.Duration
+}
+
+type ImageUploadOptions struct {
+	SmpConnOptions
+
+	// Mark the image pending, so the bootloader tests it on the next
+	// reset.
+	Test bool
+
+	// Mark the image permanent instead.
+	Confirm bool
+
+	// Reset the device afterwards.
+	Reset bool
+
+	// Upload even if the device already has the image.
+	Force bool
+}
+
+// Selects uploading the image over SMP to the running device's image manager
+// for load operations.  nil selects the other load methods.
+func (t *TargetBuilder) SetMgmtLoad(opts *ImageUploadOptions) {
+	t.mgmtLoad = opts
+}
+
+func (t *TargetBuilder) smpConnect(opts SmpConnOptions) (*smp.Client,
+	error) {
+
+	var tr smp.Transport
+
+	var cp *newtutil.ConnProfile
+	var err error
+	if opts.Conn == "" {
+		cp, err = t.ConnProfile()
+	} else {
+		cp, err = namedConnProfile(opts.Conn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cp != nil {
+		opts.Conn = cp.SmpConn()
+		if opts.Baud == 0 {
+			opts.Baud = cp.Baud
+		}
+	}
+
+	switch {
+	case opts.Conn == "":
+		return nil, util.NewNewtError("Image management requires a " +
+			"connection (--conn serial:<device> or udp:<host>:<port>, " +
+			"or a target connection profile)")
+
+	case strings.HasPrefix(opts.Conn, SMP_CONN_UDP):
+		ut, err := smp.NewUdpTransport(
+			strings.TrimPrefix(opts.Conn, SMP_CONN_UDP), opts.Mtu)
+		if err != nil {
+			return nil, err
+		}
+		tr = ut
+
+	case strings.HasPrefix(opts.Conn, SERIAL_PORT_BLE_PREFIX):
+		return nil, util.FmtNewtError("BLE connections are not supported "+
+			"by newt's image management; use mcumgr or --method serial "+
+			"with a %s<name> port", SERIAL_PORT_BLE_PREFIX)
+
+	default:
+		port := strings.TrimPrefix(opts.Conn, SMP_CONN_SERIAL)
+
+		_, baud := serialLoadDefaults(SERIAL_PROTO_MCUMGR)
+		if sl := t.bspPkg.SerialLoad; sl != nil &&
+			sl.Protocol == SERIAL_PROTO_MCUMGR && sl.Baud != 0 {
+
+			baud = sl.Baud
+		}
+		if opts.Baud != 0 {
+			baud = opts.Baud
+		}
+
+		f, err := openConsoleUart(port, baud)
+		if err != nil {
+			return nil, err
+		}
+		tr = smp.NewSerialTransport(f, opts.Mtu)
+	}
+
+	c := smp.NewClient(tr)
+	if opts.Timeout != 0 {
+		c.Timeout = opts.Timeout
+	}
+
+	return c, nil
+}
+
+// Reads the state of the device's image slots.
+func (t *TargetBuilder) ImageState(opts SmpConnOptions) ([]smp.ImageSlot,
+	error) {
+
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	c, err := t.smpConnect(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	return c.ImageState()
+}
+
+// Makes an image on the device permanent; an empty hash confirms the running
+// image.
+func (t *TargetBuilder) ImageConfirm(opts SmpConnOptions,
+	hash string) ([]smp.ImageSlot, error) {
+
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	c, err := t.smpConnect(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	return c.ImageConfirm(hash)
+}
+
+// Marks an image on the device for test: the bootloader runs it once on the
+// next reset, then reverts to the current image unless the new one gets
+// confirmed.  An empty hash selects the target's most recently created image.
+func (t *TargetBuilder) ImageTest(opts SmpConnOptions, hash string,
+	reset bool) ([]smp.ImageSlot, error) {
+
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	if hash == "" {
+		var err error
+		hash, err = manifestImageHash(t.AppBuilder.ManifestPath())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := t.smpConnect(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	slots, err := c.ImageState()
+	if err != nil {
+		return nil, err
+	}
+	slot := smp.FindImageSlot(slots, hash)
+	switch {
+	case slot == nil:
+		return nil, util.FmtNewtError("The device doesn't have image %s; "+
+			"upload it with \"newt image upload\"", hash)
+	case slot.Active:
+		return nil, util.FmtNewtError("Image %s is already running",
+			slot.Version)
+	}
+
+	if slots, err = c.ImageTest(hash); err != nil {
+		return nil, err
+	}
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Image %s marked for test\n", slot.Version)
+
+	return slots, smpReset(c, reset)
+}
+
+// Returns the device to its previous image.  If the running image is still
+// under test, resetting the device is enough: the bootloader reverts it.
+// Otherwise, the image in the other slot is made permanent.
+func (t *TargetBuilder) ImageRevert(opts SmpConnOptions,
+	reset bool) ([]smp.ImageSlot, error) {
+
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	c, err := t.smpConnect(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	slots, err := c.ImageState()
+	if err != nil {
+		return nil, err
+	}
+
+	// The previous image is in the running image's other slot.
+	var active *smp.ImageSlot
+	for i, _ := range slots {
+		if slots[i].Active {
+			active = &slots[i]
+		}
+	}
+	var other *smp.ImageSlot
+	for i, _ := range slots {
+		s := &slots[i]
+		if !s.Active && s.Bootable &&
+			(active == nil || s.Image == active.Image) {
+
+			other = s
+		}
+	}
+
+	switch {
+	case active != nil && !active.Confirmed:
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Image %s is under test; the bootloader reverts it on reset\n",
+			active.Version)
+		if !reset {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"* Warning: the device was not reset; the revert takes "+
+					"effect on its next reset\n")
+		}
+
+	case other != nil:
+		if slots, err = c.ImageConfirm(other.Hash); err != nil {
+			return nil, err
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Reverting to image %s in slot %d\n", other.Version, other.Slot)
+
+	default:
+		return nil, util.NewNewtError("The device has no previous image " +
+			"to revert to")
+	}
+
+	return slots, smpReset(c, reset)
+}
+
+// Resets the device if requested.  The device may reset before its response
+// arrives, so a missing response is not an error.
+func smpReset(c *smp.Client, reset bool) error {
+	if !reset {
+		return nil
+	}
+
+	if err := c.Reset(); err != nil {
+		util.StatusMessage(util.VERBOSITY_VERBOSE,
+			"Reset: %s\n", err.Error())
+	}
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Device reset\n")
+
+	return nil
+}
+
+// Uploads the target's most recently created image to the device's
+// secondary slot, then marks it for test or confirms it and resets the
+// device, as requested.
+func (t *TargetBuilder) ImageUpload(opts ImageUploadOptions) error {
+	if err := t.PrepBuild(); err != nil {
+		return err
+	}
+
+	imgPath := t.AppBuilder.AppImgPath()
+	data, err := ioutil.ReadFile(imgPath)
+	if err != nil {
+		return util.FmtNewtError("Can't read image %s: %s; run "+
+			"create-image first", imgPath, err.Error())
+	}
+	hash, err := manifestImageHash(t.AppBuilder.ManifestPath())
+	if err != nil {
+		return err
+	}
+
+	c, err := t.smpConnect(opts.SmpConnOptions)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	slots, err := c.ImageState()
+	if err != nil {
+		return err
+	}
+
+	slot := smp.FindImageSlot(slots, hash)
+	switch {
+	case slot != nil && slot.Active && !opts.Force:
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Device is already running image %s (%s)\n", slot.Version,
+			hash)
+		return nil
+
+	case slot != nil && !opts.Force:
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Image %s already in slot %d; not uploading\n", slot.Version,
+			slot.Slot)
+
+	default:
+		for _, s := range slots {
+			if s.Slot != 0 && s.Pending {
+				util.StatusMessage(util.VERBOSITY_DEFAULT,
+					"* Warning: replacing pending image %s in slot %d\n",
+					s.Version, s.Slot)
+			}
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Uploading %s (%d bytes) over %s\n", imgPath, len(data),
+			opts.Conn)
+
+		lastPct := -1
+		err := c.ImageUpload(data, func(off int, total int) {
+			pct := off * 100 / total
+			if pct/10 != lastPct/10 {
+				util.StatusMessage(util.VERBOSITY_DEFAULT, "    %3d%%\n", pct)
+				lastPct = pct
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		slots, err = c.ImageState()
+		if err != nil {
+			return err
+		}
+		slot = smp.FindImageSlot(slots, hash)
+		if slot == nil {
+			return util.FmtNewtError("Upload finished but the device "+
+				"doesn't report image %s", hash)
+		}
+	}
+
+	switch {
+	case opts.Confirm:
+		if _, err := c.ImageConfirm(hash); err != nil {
+			return err
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Image %s confirmed\n", slot.Version)
+
+	case opts.Test:
+		if _, err := c.ImageTest(hash); err != nil {
+			return err
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Image %s marked for test; confirm it after reset with "+
+				"\"newt image confirm\"\n", slot.Version)
+	}
+
+	return smpReset(c, opts.Reset)
+}
@@ -0,0 +1,182 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/flash"
+	"mynewt.apache.org/newt/newt/image"
+	"mynewt.apache.org/newt/util"
+)
+
+// Image layout settings.  Each is read from the target
+// ("target.image_<name>") or, failing that, from the BSP
+// ("bsp.image_<name>").
+const (
+	IMAGE_SETTING_HEADER_SIZE = "header_size"
+	IMAGE_SETTING_ALIGN       = "align"
+	IMAGE_SETTING_PAD         = "pad"
+	IMAGE_SETTING_SEC_COUNTER = "security_counter"
+)
+
+// Pads an image to the size of the slot it is written to.
+const IMAGE_PAD_SLOT = "slot"
+
+// Derives the security counter from the image version.
+const IMAGE_SEC_COUNTER_AUTO = "auto"
+
+// Describes how a target's images are laid out, as required by its
+// bootloader configuration and flash.
+type ImageLayout struct {
+	HeaderSize uint
+	Align      uint
+
+	// If PadSlot is set, images are padded to the size of their slot;
+	// otherwise, to PadSize bytes if it is non-zero.
+	PadSlot bool
+	PadSize uint
+
+	// "", "auto", or a number.
+	SecCounter string
+}
+
+func (t *TargetBuilder) imageSetting(name string) (string, string) {
+	if val := t.target.ImageSetting(name); val != "" {
+		return val, "target.image_" + name
+	}
+
+	if t.bspPkg != nil {
+		key := "bsp.image_" + name
+		if val := t.bspPkg.BspV.GetString(key); val != "" {
+			return val, key
+		}
+	}
+
+	return "", ""
+}
+
+func (t *TargetBuilder) imageSizeSetting(name string) (uint, error) {
+	val, src := t.imageSetting(name)
+	if val == "" {
+		return 0, nil
+	}
+
+	multiplier := 1
+	str := strings.ToLower(val)
+	if strings.HasSuffix(str, "kb") {
+		multiplier = 1024
+		str = strings.TrimSuffix(str, "kb")
+	}
+
+	n, err := util.AtoiNoOct(str)
+	if err != nil || n < 0 {
+		return 0, util.FmtNewtError("Invalid %s: \"%s\"", src, val)
+	}
+
+	return uint(n * multiplier), nil
+}
+
+// Reads the target's image layout settings.
+func (t *TargetBuilder) ImageLayout() (ImageLayout, error) {
+	layout := ImageLayout{}
+	var err error
+
+	layout.HeaderSize, err = t.imageSizeSetting(IMAGE_SETTING_HEADER_SIZE)
+	if err != nil {
+		return layout, err
+	}
+	if layout.HeaderSize != 0 && layout.HeaderSize < image.IMAGE_HEADER_SIZE {
+		_, src := t.imageSetting(IMAGE_SETTING_HEADER_SIZE)
+		return layout, util.FmtNewtError("%s must be at least %d bytes",
+			src, image.IMAGE_HEADER_SIZE)
+	}
+
+	layout.Align, err = t.imageSizeSetting(IMAGE_SETTING_ALIGN)
+	if err != nil {
+		return layout, err
+	}
+	if layout.Align&(layout.Align-1) != 0 {
+		_, src := t.imageSetting(IMAGE_SETTING_ALIGN)
+		return layout, util.FmtNewtError("%s must be a power of two", src)
+	}
+
+	if val, _ := t.imageSetting(IMAGE_SETTING_PAD); val == IMAGE_PAD_SLOT {
+		layout.PadSlot = true
+	} else {
+		layout.PadSize, err = t.imageSizeSetting(IMAGE_SETTING_PAD)
+		if err != nil {
+			return layout, err
+		}
+	}
+
+	val, src := t.imageSetting(IMAGE_SETTING_SEC_COUNTER)
+	if val != "" && val != IMAGE_SEC_COUNTER_AUTO {
+		if _, err := strconv.ParseUint(val, 0, 32); err != nil {
+			return layout, util.FmtNewtError(
+				"Invalid %s: \"%s\"; must be %s or a 32-bit number",
+				src, val, IMAGE_SEC_COUNTER_AUTO)
+		}
+	}
+	layout.SecCounter = val
+
+	return layout, nil
+}
+
+// Applies the layout to an image that is to be written to the specified flash
+// area.  The image's version must already be set.
+func (t *TargetBuilder) applyImageLayout(layout ImageLayout,
+	img *image.Image, areaName string) error {
+
+	img.HeaderSize = layout.HeaderSize
+	img.Align = layout.Align
+	img.PadSize = layout.PadSize
+
+	if layout.PadSlot {
+		area, ok := t.bspPkg.FlashMap.Areas[areaName]
+		if !ok {
+			return util.FmtNewtError("Can't pad image to its slot; BSP "+
+				"has no %s flash area", areaName)
+		}
+		img.PadSize = uint(area.Size)
+	}
+
+	switch layout.SecCounter {
+	case "":
+	case IMAGE_SEC_COUNTER_AUTO:
+		img.UseSecCounter = true
+		img.SecCounter = img.Version.SecurityCounter()
+	default:
+		n, _ := strconv.ParseUint(layout.SecCounter, 0, 32)
+		img.UseSecCounter = true
+		img.SecCounter = uint32(n)
+	}
+
+	return nil
+}
+
+// Returns the flash area that a target's app image is written to.
+func (t *TargetBuilder) appImageArea() string {
+	if t.LoaderBuilder != nil {
+		return flash.FLASH_AREA_NAME_IMAGE_1
+	}
+	return flash.FLASH_AREA_NAME_IMAGE_0
+}
@@ -0,0 +1,293 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+var includeLineRe = regexp.MustCompile(`^\s*#\s*include\s*[<"]([^>"]+)[>"]`)
+
+// An #include directive in one of a package's files.
+type includeLine struct {
+	line   int
+	header string
+}
+
+// Instructs the target builder to check that packages only include headers
+// from packages they depend on directly.
+func (t *TargetBuilder) EnableStrictIncludes() {
+	t.strictIncludes = true
+}
+
+// Instructs the target builder to only pass each package the include
+// directories of the packages it depends on; see globalCompilerInfo().
+func (t *TargetBuilder) EnableIncludePruning() {
+	t.pruneIncludes = true
+}
+
+// Returns the part of the target's or app's compiler info that applies to
+// every package.  The include paths of these packages cover nearly every
+// package in the build; when pruning, they are left out, and each package
+// only gets its own and its dependencies' include directories, along with
+// the BSP's and the generated headers.
+func (b *Builder) globalCompilerInfo(
+	ci *toolchain.CompilerInfo) *toolchain.CompilerInfo {
+
+	if !b.targetBuilder.pruneIncludes {
+		return ci
+	}
+
+	pruned := *ci
+	pruned.Includes = nil
+	return &pruned
+}
+
+// Reports the average number of include directories passed to each package
+// with and without pruning.
+func (b *Builder) reportIncludePruning() error {
+	if !b.targetBuilder.pruneIncludes || len(b.PkgMap) == 0 {
+		return nil
+	}
+
+	full := []string{}
+	for _, bpkg := range []*BuildPackage{b.targetPkg, b.appPkg} {
+		if bpkg != nil {
+			ci, err := bpkg.CompilerInfo(b)
+			if err != nil {
+				return err
+			}
+			full = append(full, ci.Includes...)
+		}
+	}
+
+	total := 0
+	totalPruned := 0
+	for _, bpkg := range b.PkgMap {
+		ci, err := bpkg.CompilerInfo(b)
+		if err != nil {
+			return err
+		}
+		own := append(append([]string{}, b.compilerInfo.Includes...),
+			ci.Includes...)
+		totalPruned += len(util.UniqueStrings(own))
+		total += len(util.UniqueStrings(append(own, full...)))
+	}
+
+	n := float64(len(b.PkgMap))
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Include path pruning (%s): %.1f include dirs per package "+
+			"(%.1f without pruning)\n", b.buildName,
+		float64(totalPruned)/n, float64(total)/n)
+
+	return nil
+}
+
+// Sorts packages by the length of their base paths, longest first.
+type ownerSorter struct {
+	owners []*BuildPackage
+	bases  map[*BuildPackage]string
+}
+
+func (s ownerSorter) Len() int {
+	return len(s.owners)
+}
+func (s ownerSorter) Swap(i, j int) {
+	s.owners[i], s.owners[j] = s.owners[j], s.owners[i]
+}
+func (s ownerSorter) Less(i, j int) bool {
+	return len(s.bases[s.owners[i]]) > len(s.bases[s.owners[j]])
+}
+
+// Reads the #include directives in the specified file.  Results are cached
+// in the supplied map.
+func readIncludeLines(path string,
+	cache map[string][]includeLine) []includeLine {
+
+	if incs, ok := cache[path]; ok {
+		return incs
+	}
+
+	incs := []includeLine{}
+	lines, err := util.ReadLines(path)
+	if err == nil {
+		for i, line := range lines {
+			if m := includeLineRe.FindStringSubmatch(line); m != nil {
+				incs = append(incs, includeLine{i + 1, m[1]})
+			}
+		}
+	}
+
+	cache[path] = incs
+	return incs
+}
+
+// Returns the package containing the specified file: the one with the
+// longest base path that is a prefix of the file's path.
+func includeOwner(path string, bpkgs []*BuildPackage,
+	bases map[*BuildPackage]string) *BuildPackage {
+
+	for _, bpkg := range bpkgs {
+		base := bases[bpkg]
+		if strings.HasPrefix(path, base+string(filepath.Separator)) {
+			return bpkg
+		}
+	}
+	return nil
+}
+
+// Checks the headers each package's object files were compiled against
+// (according to the .d files the compiler wrote).  A package may only
+// #include headers from itself and its direct dependencies, including
+// packages that supply the APIs it requires.  Headers from the BSP and
+// target, and headers outside any package (toolchain headers, generated
+// code), are always allowed.  A header that a package only uses indirectly,
+// through a dependency's header, is not a violation.
+//
+// Include paths are not restricted; a violation is reported with the
+// offending #include line instead of as a missing header.
+func (b *Builder) checkIncludes(bpkgs []*BuildPackage) error {
+	projDir := project.GetProject().Path()
+	absPath := func(path string) string {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(projDir, path)
+		}
+		return filepath.Clean(path)
+	}
+
+	// Longest base paths first, so nested packages are matched before the
+	// packages containing them.
+	owners := make([]*BuildPackage, 0, len(b.PkgMap))
+	bases := map[*BuildPackage]string{}
+	for _, bpkg := range b.PkgMap {
+		owners = append(owners, bpkg)
+		bases[bpkg] = absPath(bpkg.rpkg.Lpkg.BasePath())
+	}
+	sort.Sort(ownerSorter{owners, bases})
+
+	exempt := func(bpkg *BuildPackage) bool {
+		switch bpkg.rpkg.Lpkg.Type() {
+		case pkg.PACKAGE_TYPE_BSP, pkg.PACKAGE_TYPE_TARGET,
+			pkg.PACKAGE_TYPE_GENERATED:
+			return true
+		default:
+			return bpkg == b.bspPkg
+		}
+	}
+
+	cache := map[string][]includeLine{}
+	diags := []toolchain.Diagnostic{}
+	for _, bpkg := range bpkgs {
+		if exempt(bpkg) || isPrebuilt(bpkg) {
+			continue
+		}
+
+		allowed := map[*BuildPackage]bool{bpkg: true}
+		for _, dep := range bpkg.rpkg.Deps {
+			if dbpkg := b.PkgMap[dep.Rpkg]; dbpkg != nil {
+				allowed[dbpkg] = true
+			}
+		}
+
+		depFiles := []string{}
+		filepath.Walk(b.PkgBinDir(bpkg),
+			func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() &&
+					strings.HasSuffix(path, ".d") {
+
+					depFiles = append(depFiles, path)
+				}
+				return nil
+			})
+		sort.Strings(depFiles)
+
+		seen := map[string]bool{}
+		for _, depFile := range depFiles {
+			deps, err := toolchain.ParseDepsFile(depFile)
+			if err != nil {
+				return err
+			}
+
+			own := []string{}
+			foreign := map[string]*BuildPackage{}
+			for _, dep := range deps {
+				path := absPath(dep)
+				owner := includeOwner(path, owners, bases)
+				if owner == bpkg {
+					own = append(own, path)
+				} else if owner != nil && !allowed[owner] && !exempt(owner) {
+					foreign[filepath.ToSlash(path)] = owner
+				}
+			}
+			if len(foreign) == 0 {
+				continue
+			}
+
+			for _, file := range own {
+				for _, inc := range readIncludeLines(file, cache) {
+					for hdr, owner := range foreign {
+						if !strings.HasSuffix(hdr, "/"+inc.header) {
+							continue
+						}
+
+						relFile := file
+						if rel, err := filepath.Rel(projDir, file); err == nil {
+							relFile = rel
+						}
+						key := fmt.Sprintf("%s:%d", relFile, inc.line)
+						if seen[key] {
+							continue
+						}
+						seen[key] = true
+
+						diags = append(diags, toolchain.Diagnostic{
+							Package:  bpkg.rpkg.Lpkg.FullName(),
+							File:     relFile,
+							Line:     inc.line,
+							Severity: toolchain.DIAG_SEVERITY_ERROR,
+							Message: fmt.Sprintf("#include <%s> is "+
+								"provided by %s, which is not a dependency "+
+								"of %s; add it to pkg.deps",
+								inc.header, owner.rpkg.Lpkg.FullName(),
+								bpkg.rpkg.Lpkg.FullName()),
+						})
+					}
+				}
+			}
+		}
+	}
+
+	if len(diags) == 0 {
+		return nil
+	}
+
+	toolchain.AddDiagnostics(diags)
+	return util.FmtNewtError("%d #include(s) of headers from undeclared "+
+		"dependencies", len(diags))
+}
@@ -0,0 +1,68 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"mynewt.apache.org/newt/util"
+)
+
+// Instructs the target builder to speed up relinks with whatever the
+// toolchain supports: thin package archives, and the linker's incremental
+// mode.  Unchanged package archives are reused regardless.
+func (t *TargetBuilder) EnableIncremental() {
+	t.incremental = true
+}
+
+// Instructs the target builder to take object files that another target
+// compiled with identical flags and headers from the project's object store,
+// and to add the ones it compiles itself.
+func (t *TargetBuilder) EnableSharedObjects() {
+	t.sharedObjects = true
+}
+
+// Reports the incremental features that an incremental build of the target
+// will go without.
+func (t *TargetBuilder) warnIncremental() error {
+	c, err := t.NewCompiler("")
+	if err != nil {
+		return err
+	}
+
+	thin, link := c.IncrementalSupport()
+	if !thin && !link {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"* Warning: compiler %s supports neither thin archives nor "+
+				"incremental linking; doing a normal build\n",
+			t.compilerPkg.FullName())
+		return nil
+	}
+
+	if thin && t.LoaderBuilder != nil {
+		util.StatusMessage(util.VERBOSITY_VERBOSE,
+			"Not using thin archives; split images modify their "+
+				"archives\n")
+	}
+	if !link {
+		util.StatusMessage(util.VERBOSITY_VERBOSE,
+			"Compiler %s has no incremental linker mode\n",
+			t.compilerPkg.FullName())
+	}
+
+	return nil
+}
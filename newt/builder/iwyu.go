@@ -0,0 +1,547 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+// An #include that nothing in the including file uses.
+type IwyuUnused struct {
+	Line   int    `json:"line"`
+	Header string `json:"header"`
+}
+
+// A header that should be included directly, because the file uses what it
+// declares but only gets it through other headers.
+type IwyuMissing struct {
+	Header  string   `json:"header"`
+	Package string   `json:"package"`
+	Symbols []string `json:"symbols"`
+}
+
+type IwyuFile struct {
+	File    string        `json:"file"`
+	Unused  []IwyuUnused  `json:"unused"`
+	Missing []IwyuMissing `json:"missing"`
+}
+
+type IwyuReport struct {
+	Package string     `json:"package"`
+	Files   []IwyuFile `json:"files"`
+}
+
+var iwyuCommentRe = regexp.MustCompile(`(?s)/\*.*?\*/|//[^\n]*`)
+var iwyuLiteralRe = regexp.MustCompile(`"(\\.|[^"\\\n])*"|'(\\.|[^'\\\n])*'`)
+var iwyuIdentRe = regexp.MustCompile(`[A-Za-z_]\w*`)
+var iwyuDefineRe = regexp.MustCompile(`^\s*#\s*define\s+([A-Za-z_]\w*)`)
+var iwyuTagRe = regexp.MustCompile(`\b(?:struct|union|enum)\s+([A-Za-z_]\w*)\s*\{`)
+var iwyuEnumRe = regexp.MustCompile(`\benum\b[^{;]*\{([^}]*)\}`)
+var iwyuFuncDefRe = regexp.MustCompile(`([A-Za-z_]\w*)\s*\([^()]*\)\s*\{\}`)
+var iwyuFuncPtrRe = regexp.MustCompile(`\(\s*\*\s*([A-Za-z_]\w*)\s*\)`)
+var iwyuCallRe = regexp.MustCompile(`([A-Za-z_]\w*)\s*\(`)
+
+// Identifiers that are followed by parentheses without being functions.
+var iwyuNonFuncs = map[string]bool{
+	"__attribute__": true, "__asm__": true, "asm": true, "defined": true,
+	"sizeof": true, "typeof": true, "__typeof__": true, "if": true,
+	"while": true, "for": true, "switch": true, "return": true,
+	"_Static_assert": true, "static_assert": true, "alignof": true,
+	"_Alignof": true, "__declspec": true,
+}
+
+// Removes comments and string and character literals, and joins
+// preprocessor continuation lines.
+func iwyuStrip(text string) string {
+	text = iwyuCommentRe.ReplaceAllStringFunc(text, func(s string) string {
+		// Keep line numbers intact.
+		return strings.Repeat("\n", strings.Count(s, "\n"))
+	})
+	text = iwyuLiteralRe.ReplaceAllString(text, `""`)
+	return strings.Replace(text, "\\\n", " ", -1)
+}
+
+// Replaces the contents of each top-level brace pair with nothing, leaving
+// "{}".
+func iwyuTopLevel(code string) string {
+	var b bytes.Buffer
+	depth := 0
+	for _, c := range code {
+		switch c {
+		case '{':
+			if depth == 0 {
+				b.WriteRune(c)
+			}
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+				if depth == 0 {
+					b.WriteRune(c)
+				}
+			}
+		default:
+			if depth == 0 {
+				b.WriteRune(c)
+			}
+		}
+	}
+
+	return b.String()
+}
+
+func lastIdent(s string) string {
+	idents := iwyuIdentRe.FindAllString(s, -1)
+	if len(idents) == 0 {
+		return ""
+	}
+	return idents[len(idents)-1]
+}
+
+// Returns the identifiers a header declares: macros, struct, union and enum
+// tags, enumerators, typedefs, functions and extern variables.  This is a
+// heuristic scan of the header's text; conditional compilation is ignored.
+func iwyuHeaderDecls(text string) map[string]bool {
+	decls := map[string]bool{}
+
+	code := []string{}
+	for _, line := range strings.Split(iwyuStrip(text), "\n") {
+		if m := iwyuDefineRe.FindStringSubmatch(line); m != nil {
+			decls[m[1]] = true
+		}
+		if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			code = append(code, line)
+		}
+	}
+	body := strings.Join(code, "\n")
+
+	for _, m := range iwyuTagRe.FindAllStringSubmatch(body, -1) {
+		decls[m[1]] = true
+	}
+	for _, m := range iwyuEnumRe.FindAllStringSubmatch(body, -1) {
+		for _, entry := range strings.Split(m[1], ",") {
+			if id := iwyuIdentRe.FindString(entry); id != "" {
+				decls[id] = true
+			}
+		}
+	}
+
+	top := iwyuTopLevel(body)
+	for _, m := range iwyuFuncDefRe.FindAllStringSubmatch(top, -1) {
+		if !iwyuNonFuncs[m[1]] {
+			decls[m[1]] = true
+		}
+	}
+	top = iwyuFuncDefRe.ReplaceAllString(top, ";")
+
+	for _, stmt := range strings.Split(top, ";") {
+		stmt = strings.TrimSpace(stmt)
+		switch {
+		case strings.HasPrefix(stmt, "typedef"):
+			if m := iwyuFuncPtrRe.FindStringSubmatch(stmt); m != nil {
+				decls[m[1]] = true
+			} else if i := strings.Index(stmt, "["); i >= 0 {
+				decls[lastIdent(stmt[:i])] = true
+			} else {
+				decls[lastIdent(stmt)] = true
+			}
+
+		case strings.Contains(stmt, "("):
+			for _, m := range iwyuCallRe.FindAllStringSubmatch(stmt, -1) {
+				if !iwyuNonFuncs[m[1]] {
+					decls[m[1]] = true
+					break
+				}
+			}
+
+		case strings.HasPrefix(stmt, "extern"):
+			if i := strings.IndexAny(stmt, "[="); i >= 0 {
+				stmt = stmt[:i]
+			}
+			decls[lastIdent(stmt)] = true
+		}
+	}
+
+	delete(decls, "")
+	return decls
+}
+
+// Returns the identifiers a source file refers to, ignoring comments,
+// literals and #include directives.
+func iwyuSourceIdents(text string) map[string]bool {
+	idents := map[string]bool{}
+	for _, line := range strings.Split(iwyuStrip(text), "\n") {
+		if includeLineRe.MatchString(line) {
+			continue
+		}
+		for _, id := range iwyuIdentRe.FindAllString(line, -1) {
+			idents[id] = true
+		}
+	}
+
+	return idents
+}
+
+func iwyuIsSource(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".c", ".cc", ".cpp", ".cxx":
+		return true
+	default:
+		return false
+	}
+}
+
+// Analyzes the #includes of one source file against the headers it was
+// compiled with.
+type iwyuAnalyzer struct {
+	projDir string
+	owners  []*BuildPackage
+	bases   map[*BuildPackage]string
+	lines   map[string][]includeLine
+	decls   map[string]map[string]bool
+}
+
+func (ia *iwyuAnalyzer) absPath(path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(ia.projDir, path)
+	}
+	return filepath.Clean(path)
+}
+
+func (ia *iwyuAnalyzer) relPath(path string) string {
+	if rel, err := filepath.Rel(ia.projDir, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(path)
+}
+
+func (ia *iwyuAnalyzer) headerDecls(path string) map[string]bool {
+	if decls, ok := ia.decls[path]; ok {
+		return decls
+	}
+
+	decls := map[string]bool{}
+	if data, err := util.ReadTextFile(path); err == nil {
+		decls = iwyuHeaderDecls(string(data))
+	}
+	ia.decls[path] = decls
+	return decls
+}
+
+// Finds the header an #include refers to among the file's dependencies:
+// the one next to the including file, else any whose path ends with the
+// included name.
+func resolveInclude(from string, name string, deps []string,
+	depSet map[string]bool) string {
+
+	if local := filepath.Join(filepath.Dir(from), name); depSet[local] {
+		return local
+	}
+
+	suffix := string(filepath.Separator) + filepath.FromSlash(name)
+	for _, dep := range deps {
+		if strings.HasSuffix(dep, suffix) {
+			return dep
+		}
+	}
+
+	return ""
+}
+
+// Returns how a file in a package would #include the specified header: its
+// path relative to the owning package's include directory, if it is in one.
+func (ia *iwyuAnalyzer) includeName(path string,
+	owner *BuildPackage) string {
+
+	if owner != nil {
+		incDir := ia.bases[owner] + string(filepath.Separator) + "include" +
+			string(filepath.Separator)
+		if strings.HasPrefix(path, incDir) {
+			return filepath.ToSlash(strings.TrimPrefix(path, incDir))
+		}
+	}
+
+	return ia.relPath(path)
+}
+
+func (ia *iwyuAnalyzer) analyzeFile(depFile string) (*IwyuFile, error) {
+	rawDeps, err := toolchain.ParseDepsFile(depFile)
+	if err != nil {
+		return nil, err
+	}
+
+	src := ""
+	deps := []string{}
+	depSet := map[string]bool{}
+	for _, dep := range rawDeps {
+		path := ia.absPath(dep)
+		if src == "" && iwyuIsSource(path) {
+			src = path
+			continue
+		}
+		if !depSet[path] {
+			depSet[path] = true
+			deps = append(deps, path)
+		}
+	}
+	if src == "" {
+		return nil, nil
+	}
+
+	data, err := util.ReadTextFile(src)
+	if err != nil {
+		return nil, err
+	}
+	used := iwyuSourceIdents(string(data))
+
+	// Include graph of the headers the file was compiled with.
+	edges := map[string][]string{}
+	for _, hdr := range deps {
+		for _, inc := range readIncludeLines(hdr, ia.lines) {
+			if path := resolveInclude(hdr, inc.header, deps,
+				depSet); path != "" {
+
+				edges[hdr] = append(edges[hdr], path)
+			}
+		}
+	}
+
+	usesAny := func(path string) bool {
+		for sym, _ := range ia.headerDecls(path) {
+			if used[sym] {
+				return true
+			}
+		}
+		return false
+	}
+
+	f := &IwyuFile{
+		File:    ia.relPath(src),
+		Unused:  []IwyuUnused{},
+		Missing: []IwyuMissing{},
+	}
+
+	direct := map[string]bool{}
+	srcBase := strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))
+	for _, inc := range readIncludeLines(src, ia.lines) {
+		path := resolveInclude(src, inc.header, deps, depSet)
+		if path == "" {
+			// Excluded by conditional compilation.
+			continue
+		}
+		direct[path] = true
+
+		// A file's own header is never reported.
+		hdrBase := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if hdrBase == srcBase {
+			continue
+		}
+
+		// The include is used if the file uses anything declared by the
+		// header or by the headers it brings in.
+		seen := map[string]bool{}
+		queue := []string{path}
+		isUsed := false
+		for len(queue) > 0 && !isUsed {
+			hdr := queue[0]
+			queue = queue[1:]
+			if seen[hdr] {
+				continue
+			}
+			seen[hdr] = true
+			isUsed = usesAny(hdr)
+			queue = append(queue, edges[hdr]...)
+		}
+
+		if !isUsed {
+			f.Unused = append(f.Unused, IwyuUnused{
+				Line:   inc.line,
+				Header: inc.header,
+			})
+		}
+	}
+
+	// Symbols the file uses that none of its direct includes declare.
+	covered := map[string]bool{}
+	for path, _ := range direct {
+		for sym, _ := range ia.headerDecls(path) {
+			covered[sym] = true
+		}
+	}
+
+	// Candidate headers: those in packages of this build, excluding
+	// toolchain and generated headers.
+	candidates := map[string]map[string]bool{}
+	for _, hdr := range deps {
+		if direct[hdr] || includeOwner(hdr, ia.owners, ia.bases) == nil {
+			continue
+		}
+		for sym, _ := range ia.headerDecls(hdr) {
+			if used[sym] && !covered[sym] {
+				if candidates[hdr] == nil {
+					candidates[hdr] = map[string]bool{}
+				}
+				candidates[hdr][sym] = true
+			}
+		}
+	}
+
+	// Suggest the headers covering the most symbols first.
+	for len(candidates) > 0 {
+		best := ""
+		for hdr, syms := range candidates {
+			if best == "" || len(syms) > len(candidates[best]) ||
+				(len(syms) == len(candidates[best]) && hdr < best) {
+
+				best = hdr
+			}
+		}
+
+		syms := []string{}
+		for sym, _ := range candidates[best] {
+			syms = append(syms, sym)
+			covered[sym] = true
+		}
+		sort.Strings(syms)
+		delete(candidates, best)
+
+		owner := includeOwner(best, ia.owners, ia.bases)
+		f.Missing = append(f.Missing, IwyuMissing{
+			Header:  ia.includeName(best, owner),
+			Package: owner.rpkg.Lpkg.FullName(),
+			Symbols: syms,
+		})
+
+		for hdr, hsyms := range candidates {
+			for sym, _ := range hsyms {
+				if covered[sym] {
+					delete(hsyms, sym)
+				}
+			}
+			if len(hsyms) == 0 {
+				delete(candidates, hdr)
+			}
+		}
+	}
+
+	return f, nil
+}
+
+type iwyuFileSorter struct {
+	files []IwyuFile
+}
+
+func (s iwyuFileSorter) Len() int {
+	return len(s.files)
+}
+func (s iwyuFileSorter) Swap(i, j int) {
+	s.files[i], s.files[j] = s.files[j], s.files[i]
+}
+func (s iwyuFileSorter) Less(i, j int) bool {
+	return s.files[i].File < s.files[j].File
+}
+
+// Compiles the target's packages and reports, for each C or C++ source file
+// of the specified package, the #includes it doesn't use and the headers it
+// uses without including them directly.  Headers are matched with the
+// symbols they declare by a scan of their text, so the results are
+// suggestions.
+func (t *TargetBuilder) Iwyu(lpkg *pkg.LocalPackage) (*IwyuReport, error) {
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	project.ResetDeps(t.AppList)
+	if err := t.bspPkg.Reload(t.AppBuilder.cfg.Features()); err != nil {
+		return nil, err
+	}
+
+	var b *Builder
+	var bpkg *BuildPackage
+	for _, cand := range t.builders() {
+		for rpkg, bp := range cand.PkgMap {
+			if rpkg.Lpkg == lpkg {
+				b = cand
+				bpkg = bp
+			}
+		}
+		if b != nil {
+			break
+		}
+	}
+	if b == nil {
+		return nil, util.FmtNewtError("Package %s is not part of target %s",
+			lpkg.FullName(), t.target.FullName())
+	}
+
+	if err := b.Build(); err != nil {
+		return nil, err
+	}
+
+	ia := &iwyuAnalyzer{
+		projDir: project.GetProject().Path(),
+		bases:   map[*BuildPackage]string{},
+		lines:   map[string][]includeLine{},
+		decls:   map[string]map[string]bool{},
+	}
+	for _, bp := range b.PkgMap {
+		ia.owners = append(ia.owners, bp)
+		ia.bases[bp] = ia.absPath(bp.rpkg.Lpkg.BasePath())
+	}
+	sort.Sort(ownerSorter{ia.owners, ia.bases})
+
+	depFiles := []string{}
+	filepath.Walk(b.PkgBinDir(bpkg),
+		func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && strings.HasSuffix(path, ".d") {
+				depFiles = append(depFiles, path)
+			}
+			return nil
+		})
+	sort.Strings(depFiles)
+
+	report := &IwyuReport{
+		Package: lpkg.FullName(),
+		Files:   []IwyuFile{},
+	}
+	for _, depFile := range depFiles {
+		f, err := ia.analyzeFile(depFile)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			report.Files = append(report.Files, *f)
+		}
+	}
+
+	sort.Sort(iwyuFileSorter{report.Files})
+
+	return report, nil
+}
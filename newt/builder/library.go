@@ -205,6 +205,43 @@ func (b *Builder) ParseObjectLibraryFile(bp *BuildPackage,
 	return nil, sm
 }
 
+// Returns the names of the symbols that the specified package's archive
+// references but does not define.
+func (b *Builder) ParseUndefinedSymbols(bp *BuildPackage) (
+	map[string]bool, error) {
+
+	c, err := b.targetBuilder.NewCompiler(b.AppElfPath())
+	if err != nil {
+		return nil, err
+	}
+
+	err, out := c.ParseLibrary(b.ArchivePath(bp))
+	if err != nil {
+		return nil, err
+	}
+
+	err, r := getParseRexeg()
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	buffer := bytes.NewBuffer(out)
+	for {
+		line, err := buffer.ReadString('\n')
+		if err != nil {
+			break
+		}
+
+		err, si := parseObjectLine(line, r)
+		if err == nil && si != nil && si.IsSection("*UND*") {
+			names[si.Name] = true
+		}
+	}
+
+	return names, nil
+}
+
 func (b *Builder) CopySymbols(sm *symbol.SymbolMap) error {
 	c, err := b.targetBuilder.NewCompiler(b.AppElfPath())
 
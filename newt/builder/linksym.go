@@ -0,0 +1,150 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Symbol names accepted by pkg.link_wrap and pkg.link_aliases.
+var linkSymbolRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Linker symbol redirections requested by the packages in a build.  A
+// package wraps symbols with "pkg.link_wrap" (a list of symbol names; calls
+// to each symbol are redirected to __wrap_<symbol>) and defines aliases with
+// "pkg.link_aliases" (a list of "<alias>=<symbol>" entries).  Both may be
+// conditioned on syscfg settings like any other package list.
+type linkSymbols struct {
+	// symbol name => packages wrapping it.
+	wraps map[string][]string
+
+	// alias name => target symbol.
+	aliases map[string]string
+
+	// alias name => package defining it.
+	aliasPkgs map[string]string
+}
+
+func (ls *linkSymbols) addWrap(pkgName string, sym string) error {
+	if !linkSymbolRe.MatchString(sym) {
+		return util.FmtNewtError(
+			"Package %s: invalid symbol in pkg.link_wrap: \"%s\"",
+			pkgName, sym)
+	}
+
+	ls.wraps[sym] = append(ls.wraps[sym], pkgName)
+	return nil
+}
+
+func (ls *linkSymbols) addAlias(pkgName string, entry string) error {
+	parts := strings.SplitN(entry, "=", 2)
+	if len(parts) != 2 {
+		return util.FmtNewtError(
+			"Package %s: pkg.link_aliases entry \"%s\" is not of the form "+
+				"<alias>=<symbol>", pkgName, entry)
+	}
+
+	alias := strings.TrimSpace(parts[0])
+	sym := strings.TrimSpace(parts[1])
+	for _, s := range []string{alias, sym} {
+		if !linkSymbolRe.MatchString(s) {
+			return util.FmtNewtError(
+				"Package %s: invalid symbol in pkg.link_aliases: \"%s\"",
+				pkgName, s)
+		}
+	}
+
+	if prev, ok := ls.aliases[alias]; ok && prev != sym {
+		return util.FmtNewtError(
+			"Conflicting linker aliases for %s: %s=%s (%s), %s=%s (%s)",
+			alias, alias, prev, ls.aliasPkgs[alias], alias, sym, pkgName)
+	}
+
+	ls.aliases[alias] = sym
+	ls.aliasPkgs[alias] = pkgName
+	return nil
+}
+
+// Collects the symbol redirections of the specified packages.
+func (b *Builder) linkSymbols(bpkgs []*BuildPackage) (*linkSymbols, error) {
+	ls := &linkSymbols{
+		wraps:     map[string][]string{},
+		aliases:   map[string]string{},
+		aliasPkgs: map[string]string{},
+	}
+
+	for _, bpkg := range bpkgs {
+		name := bpkg.rpkg.Lpkg.FullName()
+
+		for _, sym := range bpkg.pkgFlags(b, "pkg.link_wrap") {
+			if err := ls.addWrap(name, sym); err != nil {
+				return nil, err
+			}
+		}
+		for _, entry := range bpkg.pkgFlags(b, "pkg.link_aliases") {
+			if err := ls.addAlias(name, entry); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for alias, _ := range ls.aliases {
+		if pkgs := ls.wraps[alias]; len(pkgs) > 0 {
+			return nil, util.FmtNewtError(
+				"Symbol %s is both wrapped (%s) and an alias (%s)",
+				alias, strings.Join(pkgs, ", "), ls.aliasPkgs[alias])
+		}
+	}
+
+	return ls, nil
+}
+
+// Returns the linker flags that implement the redirections, in a stable
+// order so that they don't cause spurious relinks.  The comma-separated form
+// keeps each flag's base (the part before '=') distinct, so that flag
+// conflict resolution doesn't discard all but one of them.
+func (ls *linkSymbols) lflags() []string {
+	flags := []string{}
+
+	wraps := make([]string, 0, len(ls.wraps))
+	for sym, _ := range ls.wraps {
+		wraps = append(wraps, sym)
+	}
+	sort.Strings(wraps)
+	for _, sym := range wraps {
+		flags = append(flags, "-Wl,--wrap,"+sym)
+	}
+
+	aliases := make([]string, 0, len(ls.aliases))
+	for alias, _ := range ls.aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		flags = append(flags,
+			"-Wl,--defsym,"+alias+"="+ls.aliases[alias])
+	}
+
+	return flags
+}
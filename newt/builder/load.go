@@ -22,10 +22,12 @@ package builder
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 
+	"mynewt.apache.org/newt/newt/newtutil"
 	"mynewt.apache.org/newt/newt/pkg"
 	"mynewt.apache.org/newt/newt/project"
 	"mynewt.apache.org/newt/util"
@@ -39,6 +41,19 @@ func (t *TargetBuilder) Load(extraJtagCmd string) error {
 		return err
 	}
 
+	if t.programAreas {
+		if t.mgmtLoad != nil {
+			return programAreasLoadMethodErr(LOAD_METHOD_MGMT)
+		}
+		if t.serialLoad != nil {
+			return programAreasLoadMethodErr(LOAD_METHOD_SERIAL)
+		}
+	}
+
+	if t.mgmtLoad != nil {
+		return t.ImageUpload(*t.mgmtLoad)
+	}
+
 	if t.LoaderBuilder != nil {
 		err = t.AppBuilder.Load(1, extraJtagCmd)
 		if err == nil {
@@ -47,8 +62,15 @@ func (t *TargetBuilder) Load(extraJtagCmd string) error {
 	} else {
 		err = t.AppBuilder.Load(0, extraJtagCmd)
 	}
+	if err != nil {
+		return err
+	}
 
-	return err
+	if t.programAreas {
+		return t.loadProgramAreas()
+	}
+
+	return nil
 }
 
 func Load(binBaseName string, bspPkg *pkg.BspPackage,
@@ -90,6 +112,7 @@ func Load(binBaseName string, bspPkg *pkg.BspPackage,
 	for _, v := range env {
 		util.StatusMessage(util.VERBOSITY_VERBOSE, "* %s\n", v)
 	}
+	cmd, env = newtutil.ToolchainCmd(cmd, env)
 	if _, err := util.ShellCommand(cmd, env); err != nil {
 		return err
 	}
@@ -109,13 +132,30 @@ func (b *Builder) Load(imageSlot int, extraJtagCmd string) error {
 		return err
 	}
 
-	envSettings := map[string]string{
-		"IMAGE_SLOT": strconv.Itoa(imageSlot),
-		"FEATURES":   b.FeatureString(),
+	// The target's variables come first, so that the settings below
+	// override them.
+	envSettings := map[string]string{}
+	for _, kv := range b.targetBuilder.target.Env() {
+		nv := strings.SplitN(kv, "=", 2)
+		envSettings[nv[0]] = nv[1]
 	}
+	envSettings["IMAGE_SLOT"] = strconv.Itoa(imageSlot)
+	envSettings["FEATURES"] = b.FeatureString()
 	if extraJtagCmd != "" {
 		envSettings["EXTRA_JTAG_CMD"] = extraJtagCmd
 	}
+	if serial := b.targetBuilder.probeSerial; serial != "" {
+		envSettings["PROBE_SERIAL"] = serial
+	}
+	if b.targetBuilder.programAreas && b == b.targetBuilder.AppBuilder {
+		path, err := b.targetBuilder.programDescPath()
+		if err != nil {
+			return err
+		}
+		if path != "" {
+			envSettings["PROGRAM_DESC"] = path
+		}
+	}
 	features := b.cfg.Features()
 
 	var flashTargetArea string
@@ -143,6 +183,26 @@ func (b *Builder) Load(imageSlot int, extraJtagCmd string) error {
 	}
 	envSettings["FLASH_OFFSET"] = "0x" + strconv.FormatInt(int64(tgtArea.Offset), 16)
 
+	// Bootloaders are flashed as raw binaries; apps as images with a
+	// header, like the BSP scripts do.
+	bootLoader := envSettings["BOOT_LOADER"] != ""
+	binPath := b.AppImgPath()
+	if bootLoader {
+		binPath = b.AppBinBasePath() + ".elf.bin"
+	}
+
+	if opts := b.targetBuilder.serialLoad; opts != nil {
+		return b.serialLoad(opts, binPath, tgtArea.Offset, bootLoader)
+	}
+
+	probe, err := b.targetBuilder.selectedProbe()
+	if err != nil {
+		return err
+	}
+	if probe != nil {
+		return probeLoad(probe, binPath, tgtArea.Offset, extraJtagCmd)
+	}
+
 	if err := Load(b.AppBinBasePath(), b.targetBuilder.bspPkg,
 		envSettings); err != nil {
 
@@ -173,17 +233,26 @@ func (b *Builder) debugBin(binPath string, extraJtagCmd string, reset bool,
 		return err
 	}
 
+	probe, err := b.targetBuilder.selectedProbe()
+	if err != nil {
+		return err
+	}
+	if probe != nil {
+		return b.probeDebug(probe, binPath+".elf", extraJtagCmd, reset,
+			noGDB)
+	}
+
 	bspPath := b.bspPkg.rpkg.Lpkg.BasePath()
 	binBaseName := binPath
 	featureString := b.FeatureString()
 
 	coreRepo := project.GetProject().FindRepo("apache-mynewt-core")
-	envSettings := []string{
+	envSettings := append(b.targetBuilder.target.Env(),
 		fmt.Sprintf("CORE_PATH=%s", coreRepo.Path()),
 		fmt.Sprintf("BSP_PATH=%s", bspPath),
 		fmt.Sprintf("BIN_BASENAME=%s", binBaseName),
 		fmt.Sprintf("FEATURES=%s", featureString),
-	}
+	)
 	if extraJtagCmd != "" {
 		envSettings = append(envSettings,
 			fmt.Sprintf("EXTRA_JTAG_CMD=%s", extraJtagCmd))
@@ -194,6 +263,14 @@ func (b *Builder) debugBin(binPath string, extraJtagCmd string, reset bool,
 	if noGDB == true {
 		envSettings = append(envSettings, fmt.Sprintf("NO_GDB=1"))
 	}
+	if serial := b.targetBuilder.probeSerial; serial != "" {
+		envSettings = append(envSettings, "PROBE_SERIAL="+serial)
+	}
+	if args := gdbInitArgs(binPath + ".elf"); args != nil {
+		// The BSP debug scripts pass this on to gdb.
+		envSettings = append(envSettings,
+			"EXTRA_GDB_CMDS=source "+filepath.ToSlash(args[1]))
+	}
 
 	os.Chdir(project.GetProject().Path())
 
@@ -204,6 +281,8 @@ func (b *Builder) debugBin(binPath string, extraJtagCmd string, reset bool,
 	}
 
 	fmt.Printf("%s\n", cmdLine)
+	cmdLine, envSettings = newtutil.InteractiveToolchainCmd(cmdLine,
+		envSettings)
 	return util.ShellInteractiveCommand(cmdLine, envSettings)
 }
 
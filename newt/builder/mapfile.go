@@ -0,0 +1,516 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// An input section listed in a linker map.
+type MapSection struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	Size uint64 `json:"size"`
+
+	// Global symbols the linker listed within the section.
+	Symbols []string `json:"symbols,omitempty"`
+}
+
+// The reason the linker pulled an archive member into the link: a reference
+// to Symbol from File.
+type MapPullReason struct {
+	File   string
+	Symbol string
+}
+
+// The cross reference information in a GNU ld map file.  The map must have
+// been produced with --cref for CrossRefs to be populated.
+type MapFile struct {
+	// Archive members included in the link, indexed by "<archive>(<obj>)".
+	Members map[string]MapPullReason
+
+	// Input sections removed by --gc-sections, and those kept.
+	Discarded []*MapSection
+	Kept      []*MapSection
+
+	// Files referencing each symbol; the first is the one defining it.
+	CrossRefs map[string][]string
+
+	// Values of the symbols assigned by the linker script, e.g.,
+	// "__HeapBase = .".
+	Assignments map[string]uint64
+}
+
+const (
+	mapStateStart = iota
+	mapStateMembers
+	mapStateDiscarded
+	mapStateMemoryMap
+	mapStateCref
+)
+
+// Splits a map file reference of the form "<archive>(<object>)" into its
+// archive and object.  A plain object file has no archive.
+func SplitMapFile(file string) (string, string) {
+	if idx := strings.LastIndex(file, "("); idx >= 0 &&
+		strings.HasSuffix(file, ")") {
+
+		return file[:idx], file[idx+1 : len(file)-1]
+	}
+
+	return "", file
+}
+
+// Parses an input section line (or the continuation of one whose name was on
+// the previous line): "[<name>] <addr> <size> <file>".
+func parseMapSectionLine(name string, fields []string) *MapSection {
+	if len(fields) != 3 {
+		return nil
+	}
+
+	if _, err := strconv.ParseUint(fields[0], 0, 64); err != nil {
+		return nil
+	}
+	size, err := strconv.ParseUint(fields[1], 0, 64)
+	if err != nil {
+		return nil
+	}
+
+	return &MapSection{
+		Name: name,
+		File: fields[2],
+		Size: size,
+	}
+}
+
+func ParseMapFile(path string) (*MapFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, util.NewNewtError("Mapfile failed: " + err.Error())
+	}
+	defer file.Close()
+
+	mf := &MapFile{
+		Members:     map[string]MapPullReason{},
+		CrossRefs:   map[string][]string{},
+		Assignments: map[string]uint64{},
+	}
+
+	state := mapStateStart
+
+	// Name of an input section or archive member whose details continue on
+	// the next line.
+	pending := ""
+
+	// Most recent kept input section; subsequent "<addr> <symbol>" lines
+	// name the symbols it contains.
+	var cur *MapSection
+
+	// Symbol of the cross reference entry being read.
+	crefSym := ""
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+
+		switch {
+		case strings.HasPrefix(line, "Archive member included"):
+			state = mapStateMembers
+			continue
+		case strings.HasPrefix(line, "Discarded input sections"):
+			state = mapStateDiscarded
+			continue
+		case strings.HasPrefix(line, "Memory Configuration"),
+			strings.HasPrefix(line, "Allocating common symbols"),
+			strings.HasPrefix(line, "Merging program properties"):
+
+			state = mapStateStart
+			continue
+		case strings.HasPrefix(line, "Linker script and memory map"):
+			state = mapStateMemoryMap
+			continue
+		case strings.HasPrefix(line, "Cross Reference Table"):
+			state = mapStateCref
+			continue
+		}
+
+		if len(fields) == 0 {
+			continue
+		}
+		indented := line[0] == ' ' || line[0] == '\t'
+
+		switch state {
+		case mapStateMembers:
+			// "<member> <file> (<symbol>)", with the referencing file
+			// and symbol on the next line if the member name is long.
+			if !indented {
+				pending = fields[0]
+				fields = fields[1:]
+			}
+			if len(fields) == 2 && pending != "" {
+				mf.Members[pending] = MapPullReason{
+					File:   fields[0],
+					Symbol: strings.Trim(fields[1], "()"),
+				}
+				pending = ""
+			}
+
+		case mapStateDiscarded:
+			if len(fields) == 1 {
+				pending = fields[0]
+			} else if sec := parseMapSectionLine(pending, fields); sec != nil {
+				mf.Discarded = append(mf.Discarded, sec)
+				pending = ""
+			} else if sec := parseMapSectionLine(fields[0],
+				fields[1:]); sec != nil {
+
+				mf.Discarded = append(mf.Discarded, sec)
+			}
+
+		case mapStateMemoryMap:
+			if strings.HasPrefix(line, "OUTPUT(") {
+				cur = nil
+				continue
+			}
+			if !indented {
+				// Output section.
+				cur = nil
+				pending = ""
+				continue
+			}
+
+			if len(fields) >= 3 && fields[2] == "=" {
+				addr, err := strconv.ParseUint(fields[0], 0, 64)
+				if err == nil {
+					mf.Assignments[fields[1]] = addr
+				}
+				continue
+			}
+
+			switch {
+			case len(fields) == 1 && strings.HasPrefix(fields[0], "."):
+				pending = fields[0]
+			case pending != "" && parseMapSectionLine(pending,
+				fields) != nil:
+
+				cur = parseMapSectionLine(pending, fields)
+				mf.Kept = append(mf.Kept, cur)
+				pending = ""
+			case len(fields) == 4 && (strings.HasPrefix(fields[0], ".") ||
+				fields[0] == "COMMON"):
+
+				cur = parseMapSectionLine(fields[0], fields[1:])
+				if cur != nil {
+					mf.Kept = append(mf.Kept, cur)
+				}
+				pending = ""
+			case len(fields) == 2 && cur != nil:
+				if _, err := strconv.ParseUint(fields[0], 0, 64); err == nil {
+					cur.Symbols = append(cur.Symbols, fields[1])
+				}
+			}
+
+		case mapStateCref:
+			if !indented {
+				if fields[0] == "Symbol" && len(fields) == 2 &&
+					fields[1] == "File" {
+
+					continue
+				}
+				crefSym = fields[0]
+				fields = fields[1:]
+			}
+			if crefSym != "" && len(fields) == 1 {
+				mf.CrossRefs[crefSym] = append(mf.CrossRefs[crefSym],
+					fields[0])
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	return mf, nil
+}
+
+// Returns the kept and discarded input sections defining the specified
+// symbol.  A section defines a symbol if the map lists the symbol within it,
+// or if it is named after the symbol (-ffunction-sections / -fdata-sections),
+// e.g., ".text.<symbol>".
+func (mf *MapFile) SymbolSections(sym string) ([]*MapSection,
+	[]*MapSection) {
+
+	matches := func(sec *MapSection) bool {
+		if strings.HasSuffix(sec.Name, "."+sym) {
+			return true
+		}
+		for _, s := range sec.Symbols {
+			if s == sym {
+				return true
+			}
+		}
+		return false
+	}
+
+	kept := []*MapSection{}
+	for _, sec := range mf.Kept {
+		if matches(sec) {
+			kept = append(kept, sec)
+		}
+	}
+
+	discarded := []*MapSection{}
+	for _, sec := range mf.Discarded {
+		if matches(sec) {
+			discarded = append(discarded, sec)
+		}
+	}
+
+	return kept, discarded
+}
+
+// A location in the linked image, identified by package and object file.
+type SymbolLocation struct {
+	Package string `json:"package"`
+	Object  string `json:"object"`
+}
+
+type SymbolSection struct {
+	SymbolLocation
+	Section string `json:"section"`
+	Size    uint64 `json:"size"`
+}
+
+// Why a symbol is (or is not) part of an image.
+type SymbolRetention struct {
+	Symbol string `json:"symbol"`
+	Image  string `json:"image"`
+
+	// Input sections defining the symbol that were kept in the image and
+	// that --gc-sections discarded.
+	Kept      []SymbolSection `json:"kept"`
+	Discarded []SymbolSection `json:"discarded"`
+
+	// Files referencing the symbol, per the linker's cross reference table.
+	ReferencedBy []SymbolLocation `json:"referenced_by"`
+
+	// The reference that caused the linker to pull the defining object out
+	// of its archive, if any.
+	PulledInBy *SymbolLocation `json:"pulled_in_by,omitempty"`
+	PullSymbol string          `json:"pull_symbol,omitempty"`
+}
+
+func (b *Builder) mapLocation(file string) SymbolLocation {
+	ar, obj := SplitMapFile(file)
+	if ar == "" {
+		return SymbolLocation{Object: util.FilenameFromPath(obj)}
+	}
+
+	return SymbolLocation{
+		Package: b.FindPkgNameByArName(ar),
+		Object:  obj,
+	}
+}
+
+func (b *Builder) mapSections(secs []*MapSection) []SymbolSection {
+	result := []SymbolSection{}
+	for _, sec := range secs {
+		result = append(result, SymbolSection{
+			SymbolLocation: b.mapLocation(sec.File),
+			Section:        sec.Name,
+			Size:           sec.Size,
+		})
+	}
+
+	return result
+}
+
+func (b *Builder) parseMapFile() (*MapFile, error) {
+	if err := b.checkSizeable(); err != nil {
+		return nil, err
+	}
+
+	return ParseMapFile(b.AppElfPath() + ".map")
+}
+
+// Explains why the specified symbol is part of the image, or was left out.
+// Returns nil if the linker map doesn't mention the symbol.
+func (b *Builder) WhySymbol(sym string) (*SymbolRetention, error) {
+	mf, err := b.parseMapFile()
+	if err != nil {
+		return nil, err
+	}
+
+	kept, discarded := mf.SymbolSections(sym)
+	refs := mf.CrossRefs[sym]
+	if len(kept) == 0 && len(discarded) == 0 && len(refs) == 0 {
+		return nil, nil
+	}
+
+	sr := &SymbolRetention{
+		Symbol:       sym,
+		Image:        b.buildName,
+		Kept:         b.mapSections(kept),
+		Discarded:    b.mapSections(discarded),
+		ReferencedBy: []SymbolLocation{},
+	}
+
+	// The first file in a cross reference entry is the definition.
+	defFile := ""
+	if len(refs) > 0 {
+		defFile = refs[0]
+		for _, f := range refs[1:] {
+			sr.ReferencedBy = append(sr.ReferencedBy, b.mapLocation(f))
+		}
+	} else if len(kept) > 0 {
+		defFile = kept[0].File
+	}
+
+	if reason, ok := mf.Members[defFile]; ok {
+		loc := b.mapLocation(reason.File)
+		sr.PulledInBy = &loc
+		sr.PullSymbol = reason.Symbol
+	}
+
+	return sr, nil
+}
+
+// Explains why the specified symbol is part of each of the target's images.
+// Images whose linker map doesn't mention the symbol are omitted.
+func (t *TargetBuilder) WhySymbol(sym string) ([]*SymbolRetention, error) {
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	result := []*SymbolRetention{}
+	for _, b := range t.builders() {
+		sr, err := b.WhySymbol(sym)
+		if err != nil {
+			return nil, err
+		}
+		if sr != nil {
+			result = append(result, sr)
+		}
+	}
+
+	return result, nil
+}
+
+// Input section totals of a single package.
+type PkgGcSummary struct {
+	Name           string        `json:"name"`
+	KeptSize       uint64        `json:"kept_size"`
+	DiscardedSize  uint64        `json:"discarded_size"`
+	KeptCount      int           `json:"kept_count"`
+	DiscardedCount int           `json:"discarded_count"`
+	Discarded      []*MapSection `json:"discarded"`
+}
+
+type pkgGcSorter struct {
+	pkgs []*PkgGcSummary
+}
+
+func (s pkgGcSorter) Len() int {
+	return len(s.pkgs)
+}
+func (s pkgGcSorter) Swap(i, j int) {
+	s.pkgs[i], s.pkgs[j] = s.pkgs[j], s.pkgs[i]
+}
+func (s pkgGcSorter) Less(i, j int) bool {
+	if s.pkgs[i].DiscardedSize != s.pkgs[j].DiscardedSize {
+		return s.pkgs[i].DiscardedSize > s.pkgs[j].DiscardedSize
+	}
+	return s.pkgs[i].Name < s.pkgs[j].Name
+}
+
+// Summarizes, per package, the input sections the linker kept and those it
+// discarded with --gc-sections.
+func (b *Builder) GcSummary() ([]*PkgGcSummary, error) {
+	mf, err := b.parseMapFile()
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := map[string]*PkgGcSummary{}
+	get := func(file string) *PkgGcSummary {
+		loc := b.mapLocation(file)
+		name := loc.Package
+		if name == "" {
+			name = loc.Object
+		}
+
+		p := pkgs[name]
+		if p == nil {
+			p = &PkgGcSummary{Name: name, Discarded: []*MapSection{}}
+			pkgs[name] = p
+		}
+		return p
+	}
+
+	for _, sec := range mf.Kept {
+		p := get(sec.File)
+		p.KeptSize += sec.Size
+		p.KeptCount++
+	}
+	for _, sec := range mf.Discarded {
+		if sec.Size == 0 {
+			continue
+		}
+		p := get(sec.File)
+		p.DiscardedSize += sec.Size
+		p.DiscardedCount++
+		p.Discarded = append(p.Discarded, sec)
+	}
+
+	result := make([]*PkgGcSummary, 0, len(pkgs))
+	for _, p := range pkgs {
+		result = append(result, p)
+	}
+	sort.Sort(pkgGcSorter{result})
+
+	return result, nil
+}
+
+// Summarizes the kept and discarded input sections of each of the target's
+// images, indexed by image name.
+func (t *TargetBuilder) GcSummaries() (map[string][]*PkgGcSummary, error) {
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	result := map[string][]*PkgGcSummary{}
+	for _, b := range t.builders() {
+		s, err := b.GcSummary()
+		if err != nil {
+			return nil, err
+		}
+		result[b.buildName] = s
+	}
+
+	return result, nil
+}
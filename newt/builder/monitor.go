@@ -0,0 +1,396 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"debug/elf"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mynewt.apache.org/newt/newt/syscfg"
+	"mynewt.apache.org/newt/util"
+)
+
+// Linker script symbols delimiting the heap.
+const HEAP_BASE_SYMBOL = "__HeapBase"
+const HEAP_LIMIT_SYMBOL = "__HeapLimit"
+
+type MonitorOptions struct {
+	// Serial device, or ble:<peer-name>, of the running device.
+	Port string
+
+	// Baud rate; 0 means the BSP's or mcumgr's default.
+	Baud int
+
+	// Statistics groups (mcumgr stat) to show along with the tasks and
+	// memory pools.
+	Stats []string
+}
+
+type MonitorTask struct {
+	Name    string `json:"name"`
+	Prio    int    `json:"prio"`
+	Id      int    `json:"id"`
+	Runtime uint64 `json:"runtime"`
+	Csw     uint64 `json:"csw"`
+
+	// In os_stack_t units, as in the task's definition.
+	StackSize int `json:"stack_size"`
+	StackUsed int `json:"stack_used"`
+
+	// The syscfg settings the task was matched with, and the package
+	// defining them; empty if none matched.
+	PrioSetting  string `json:"prio_setting,omitempty"`
+	StackSetting string `json:"stack_setting,omitempty"`
+	Package      string `json:"package,omitempty"`
+}
+
+type MonitorPool struct {
+	Name      string `json:"name"`
+	BlockSize int    `json:"block_size"`
+	Count     int    `json:"count"`
+	Free      int    `json:"free"`
+	MinFree   int    `json:"min_free"`
+}
+
+type MonitorStat struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+type MonitorStatGroup struct {
+	Name   string        `json:"name"`
+	Values []MonitorStat `json:"values"`
+}
+
+// The heap's bounds, from the app's executable; the device doesn't report
+// heap usage.
+type MonitorHeap struct {
+	Base  uint32 `json:"base"`
+	Limit uint32 `json:"limit"`
+}
+
+type MonitorSnapshot struct {
+	Time  time.Time          `json:"time"`
+	Tasks []MonitorTask      `json:"tasks"`
+	Pools []MonitorPool      `json:"pools"`
+	Heap  *MonitorHeap       `json:"heap,omitempty"`
+	Stats []MonitorStatGroup `json:"stats,omitempty"`
+}
+
+// Polls a running device for task, memory pool and statistics information.
+type Monitor struct {
+	t    *TargetBuilder
+	opts MonitorOptions
+	heap *MonitorHeap
+
+	// Task priority settings (e.g., BLE_LL_PRIO), by value.
+	prioSettings map[int]*syscfg.CfgEntry
+}
+
+// Returns the heap bounds from the app's executable, or nil if it hasn't
+// been built or doesn't define them.
+func readMonitorHeap(elfPath string) *MonitorHeap {
+	f, err := elf.Open(elfPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	syms, err := f.Symbols()
+	if err != nil {
+		return nil
+	}
+
+	heap := &MonitorHeap{}
+	found := 0
+	for _, s := range syms {
+		switch s.Name {
+		case HEAP_BASE_SYMBOL:
+			heap.Base = uint32(s.Value)
+			found++
+		case HEAP_LIMIT_SYMBOL:
+			heap.Limit = uint32(s.Value)
+			found++
+		}
+	}
+	if found != 2 || heap.Limit < heap.Base {
+		return nil
+	}
+
+	return heap
+}
+
+func (t *TargetBuilder) NewMonitor(opts MonitorOptions) (*Monitor, error) {
+	if opts.Port == "" {
+		return nil, util.NewNewtError("Monitoring requires a port " +
+			"(--port)")
+	}
+
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	m := &Monitor{
+		t:            t,
+		opts:         opts,
+		heap:         readMonitorHeap(t.AppBuilder.AppElfPath()),
+		prioSettings: map[int]*syscfg.CfgEntry{},
+	}
+
+	for name, entry := range t.res.Cfg.Settings {
+		if !strings.HasSuffix(name, "_PRIO") {
+			continue
+		}
+		prio, err := util.AtoiNoOct(entry.Value)
+		if err != nil {
+			continue
+		}
+		e := entry
+		m.prioSettings[prio] = &e
+	}
+
+	cmd := t.mcumgrCmd(SerialLoadOptions{Port: opts.Port, Baud: opts.Baud})
+	if err := lookPathCmd(SERIAL_PROTO_MCUMGR, cmd); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Monitor) run(args ...string) ([]string, error) {
+	cmd := m.t.mcumgrCmd(SerialLoadOptions{
+		Port: m.opts.Port,
+		Baud: m.opts.Baud,
+	}, args...)
+
+	out, err := util.ShellCommand(cmd, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Split(string(out), "\n"), nil
+}
+
+// Finds the syscfg settings of a task: by name (task "ble_ll" and
+// BLE_LL_PRIO), or else by priority, which is unique to each task.
+func (m *Monitor) correlateTask(task *MonitorTask) {
+	cfg := m.t.res.Cfg
+
+	var prioEntry *syscfg.CfgEntry
+	upper := strings.ToUpper(task.Name)
+	for _, name := range []string{upper + "_PRIO", upper + "_TASK_PRIO"} {
+		if entry, ok := cfg.Settings[name]; ok {
+			prioEntry = &entry
+			break
+		}
+	}
+	if prioEntry == nil {
+		prioEntry = m.prioSettings[task.Prio]
+	}
+	if prioEntry == nil {
+		return
+	}
+
+	task.PrioSetting = prioEntry.Name
+	if prioEntry.PackageDef != nil {
+		task.Package = prioEntry.PackageDef.FullName()
+	}
+
+	prefix := strings.TrimSuffix(prioEntry.Name, "_PRIO")
+	prefix = strings.TrimSuffix(prefix, "_TASK")
+	for _, name := range []string{
+		prefix + "_STACK_SIZE",
+		prefix + "_TASK_STACK_SIZE",
+	} {
+		if _, ok := cfg.Settings[name]; ok {
+			task.StackSetting = name
+			break
+		}
+	}
+}
+
+func parseUints(fields []string) ([]uint64, bool) {
+	vals := make([]uint64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		vals[i] = v
+	}
+	return vals, true
+}
+
+type monitorTaskSorter struct {
+	tasks []MonitorTask
+}
+
+func (s monitorTaskSorter) Len() int {
+	return len(s.tasks)
+}
+func (s monitorTaskSorter) Swap(i, j int) {
+	s.tasks[i], s.tasks[j] = s.tasks[j], s.tasks[i]
+}
+func (s monitorTaskSorter) Less(i, j int) bool {
+	return s.tasks[i].Prio < s.tasks[j].Prio
+}
+
+// Parses the output of "mcumgr taskstat", whose columns are task, pri, tid,
+// runtime, csw, stksz, stkuse, last_checkin and next_checkin.
+func (m *Monitor) tasks() ([]MonitorTask, error) {
+	lines, err := m.run("taskstat")
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := []MonitorTask{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 7 {
+			continue
+		}
+		vals, ok := parseUints(fields[1:7])
+		if !ok {
+			continue
+		}
+
+		task := MonitorTask{
+			Name:      fields[0],
+			Prio:      int(vals[0]),
+			Id:        int(vals[1]),
+			Runtime:   vals[2],
+			Csw:       vals[3],
+			StackSize: int(vals[4]),
+			StackUsed: int(vals[5]),
+		}
+		m.correlateTask(&task)
+		tasks = append(tasks, task)
+	}
+
+	sort.Sort(monitorTaskSorter{tasks})
+	return tasks, nil
+}
+
+type monitorPoolSorter struct {
+	pools []MonitorPool
+}
+
+func (s monitorPoolSorter) Len() int {
+	return len(s.pools)
+}
+func (s monitorPoolSorter) Swap(i, j int) {
+	s.pools[i], s.pools[j] = s.pools[j], s.pools[i]
+}
+func (s monitorPoolSorter) Less(i, j int) bool {
+	return s.pools[i].Name < s.pools[j].Name
+}
+
+// Parses the output of "mcumgr mpstat", whose columns are name, blksz, cnt,
+// free and min.
+func (m *Monitor) pools() ([]MonitorPool, error) {
+	lines, err := m.run("mpstat")
+	if err != nil {
+		return nil, err
+	}
+
+	pools := []MonitorPool{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 5 {
+			continue
+		}
+		vals, ok := parseUints(fields[1:])
+		if !ok {
+			continue
+		}
+
+		pools = append(pools, MonitorPool{
+			Name:      fields[0],
+			BlockSize: int(vals[0]),
+			Count:     int(vals[1]),
+			Free:      int(vals[2]),
+			MinFree:   int(vals[3]),
+		})
+	}
+
+	sort.Sort(monitorPoolSorter{pools})
+	return pools, nil
+}
+
+// Parses the output of "mcumgr stat <group>": one "<value> <name>" line per
+// statistic.
+func (m *Monitor) statGroup(name string) (MonitorStatGroup, error) {
+	group := MonitorStatGroup{Name: name}
+
+	lines, err := m.run("stat", name)
+	if err != nil {
+		return group, err
+	}
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		group.Values = append(group.Values,
+			MonitorStat{Name: fields[1], Value: v})
+	}
+
+	return group, nil
+}
+
+// Reads the device's current task, memory pool and statistics information.
+func (m *Monitor) Snapshot() (*MonitorSnapshot, error) {
+	snap := &MonitorSnapshot{
+		Time: time.Now(),
+		Heap: m.heap,
+	}
+
+	var err error
+	if snap.Tasks, err = m.tasks(); err != nil {
+		return nil, err
+	}
+	if snap.Pools, err = m.pools(); err != nil {
+		return nil, err
+	}
+
+	for _, name := range m.opts.Stats {
+		group, err := m.statGroup(name)
+		if err != nil {
+			return nil, err
+		}
+		snap.Stats = append(snap.Stats, group)
+	}
+
+	return snap, nil
+}
+
+// Returns the value of a syscfg setting the monitor matched a task with.
+func (m *Monitor) SettingValue(name string) string {
+	val, _ := m.t.settingValue(name)
+	return val
+}
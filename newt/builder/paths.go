@@ -20,8 +20,11 @@
 package builder
 
 import (
+	"io/ioutil"
 	"path/filepath"
+	"strings"
 
+	"mynewt.apache.org/newt/newt/flash"
 	"mynewt.apache.org/newt/newt/interfaces"
 	"mynewt.apache.org/newt/newt/pkg"
 	"mynewt.apache.org/newt/newt/project"
@@ -32,43 +35,74 @@ const BUILD_NAME_APP = "app"
 const BUILD_NAME_LOADER = "loader"
 
 func BinRoot() string {
-	return project.GetProject().Path() + "/bin"
+	return filepath.Join(project.GetProject().Path(), "bin")
 }
 
 func TargetBinDir(targetName string) string {
-	return BinRoot() + "/" + targetName
+	return filepath.Join(BinRoot(), targetName)
+}
+
+// Directory of the object files shared by all of the project's targets; see
+// toolchain.Compiler.EnableObjStore().
+func ObjStoreDir() string {
+	return filepath.Join(BinRoot(), ".objstore")
+}
+
+func HistoryDir(targetName string) string {
+	return filepath.Join(BinRoot(), targetName, "history")
 }
 
 func GeneratedBaseDir(targetName string) string {
-	return BinRoot() + "/" + targetName + "/generated"
+	return filepath.Join(BinRoot(), targetName, "generated")
 }
 
 func GeneratedSrcDir(targetName string) string {
-	return GeneratedBaseDir(targetName) + "/src"
+	return filepath.Join(GeneratedBaseDir(targetName), "src")
 }
 
 func GeneratedIncludeDir(targetName string) string {
-	return GeneratedBaseDir(targetName) + "/include"
+	return filepath.Join(GeneratedBaseDir(targetName), "include")
+}
+
+// Directory holding the GATT service source generated for one package.
+func GeneratedGattSrcDir(targetName string, gattName string) string {
+	return filepath.Join(GeneratedBaseDir(targetName), "gatt", gattName)
+}
+
+func GeneratedLinkDir(targetName string) string {
+	return filepath.Join(GeneratedBaseDir(targetName), "link")
+}
+
+func GeneratedLinkerScriptPath(targetName string) string {
+	return filepath.Join(GeneratedLinkDir(targetName),
+		flash.LINKER_SCRIPT_FILENAME)
+}
+
+// Descriptors of the flash areas that are programmed individually; see
+// flash.FlashMap.ProgramDescs().
+func GeneratedProgramDescPath(targetName string) string {
+	return filepath.Join(GeneratedBaseDir(targetName), "flash",
+		flash.PROGRAM_DESC_FILENAME)
 }
 
 func GeneratedBinDir(targetName string) string {
-	return GeneratedBaseDir(targetName) + "/bin"
+	return filepath.Join(GeneratedBaseDir(targetName), "bin")
 }
 
 func SysinitArchivePath(targetName string) string {
-	return GeneratedBinDir(targetName) + "/sysinit.a"
+	return filepath.Join(GeneratedBinDir(targetName), "sysinit.a")
 }
 
 func PkgSyscfgPath(pkgPath string) string {
-	return pkgPath + "/" + pkg.SYSCFG_YAML_FILENAME
+	return filepath.Join(pkgPath, pkg.SYSCFG_YAML_FILENAME)
 }
 
 func BinDir(targetName string, buildName string) string {
-	return BinRoot() + "/" + targetName + "/" + buildName
+	return filepath.Join(BinRoot(), targetName, buildName)
 }
 
 func FileBinDir(targetName string, buildName string, pkgName string) string {
-	return BinDir(targetName, buildName) + "/" + pkgName
+	return filepath.Join(BinDir(targetName, buildName), pkgName)
 }
 
 func PkgBinDir(targetName string, buildName string, pkgName string,
@@ -86,12 +120,13 @@ func ArchivePath(targetName string, buildName string, pkgName string,
 	pkgType interfaces.PackageType) string {
 
 	filename := util.FilenameFromPath(pkgName) + ".a"
-	return PkgBinDir(targetName, buildName, pkgName, pkgType) + "/" + filename
+	return filepath.Join(PkgBinDir(targetName, buildName, pkgName, pkgType),
+		filename)
 }
 
 func AppElfPath(targetName string, buildName string, appName string) string {
-	return FileBinDir(targetName, buildName, appName) + "/" +
-		filepath.Base(appName) + ".elf"
+	return filepath.Join(FileBinDir(targetName, buildName, appName),
+		filepath.Base(appName)+".elf")
 }
 
 func AppBinPath(targetName string, buildName string, appName string) string {
@@ -101,25 +136,56 @@ func AppBinPath(targetName string, buildName string, appName string) string {
 func TestExePath(targetName string, buildName string, pkgName string,
 	pkgType interfaces.PackageType) string {
 
-	return PkgBinDir(targetName, buildName, pkgName, pkgType) + "/" +
-		TestTargetName(pkgName) + ".elf"
+	return filepath.Join(PkgBinDir(targetName, buildName, pkgName, pkgType),
+		TestTargetName(pkgName)+".elf")
 }
 
 func ManifestPath(targetName string, buildName string, pkgName string) string {
-	return FileBinDir(targetName, buildName, pkgName) + "/manifest.json"
+	return filepath.Join(FileBinDir(targetName, buildName, pkgName),
+		"manifest.json")
 }
 
 func AppImgPath(targetName string, buildName string, appName string) string {
-	return FileBinDir(targetName, buildName, appName) + "/" +
-		filepath.Base(appName) + ".img"
+	return filepath.Join(FileBinDir(targetName, buildName, appName),
+		filepath.Base(appName)+".img")
+}
+
+// Returns the paths of the link outputs (ELF files, images, hex files,
+// manifest, etc.) currently present in the specified build of a target.  The
+// app package's archive and object files are not included.
+func LinkOutputPaths(targetName string, buildName string,
+	appName string) ([]string, error) {
+
+	dir := FileBinDir(targetName, buildName, appName)
+	if util.NodeNotExist(dir) {
+		return nil, nil
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	archive := util.FilenameFromPath(appName) + ".a"
+
+	paths := []string{}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, archive) {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, name))
+	}
+
+	return paths, nil
 }
 
 func MfgBinDir(mfgPkgName string) string {
-	return BinRoot() + "/" + mfgPkgName
+	return filepath.Join(BinRoot(), mfgPkgName)
 }
 
 func MfgBootDir(mfgPkgName string) string {
-	return MfgBinDir(mfgPkgName) + "/bootloader"
+	return filepath.Join(MfgBinDir(mfgPkgName), "bootloader")
 }
 
 func (b *Builder) BinDir() string {
@@ -142,8 +208,8 @@ func (b *Builder) ArchivePath(bpkg *BuildPackage) string {
 }
 
 func (b *Builder) AppTentativeElfPath() string {
-	return b.PkgBinDir(b.appPkg) + "/" + filepath.Base(b.appPkg.rpkg.Lpkg.Name()) +
-		"_tmp.elf"
+	return filepath.Join(b.PkgBinDir(b.appPkg),
+		filepath.Base(b.appPkg.rpkg.Lpkg.Name())+"_tmp.elf")
 }
 
 func (b *Builder) AppElfPath() string {
@@ -152,18 +218,18 @@ func (b *Builder) AppElfPath() string {
 }
 
 func (b *Builder) AppLinkerElfPath() string {
-	return b.PkgBinDir(b.appPkg) + "/" + filepath.Base(b.appPkg.rpkg.Lpkg.Name()) +
-		"linker.elf"
+	return filepath.Join(b.PkgBinDir(b.appPkg),
+		filepath.Base(b.appPkg.rpkg.Lpkg.Name())+"linker.elf")
 }
 
 func (b *Builder) AppImgPath() string {
-	return b.PkgBinDir(b.appPkg) + "/" + filepath.Base(b.appPkg.rpkg.Lpkg.Name()) +
-		".img"
+	return filepath.Join(b.PkgBinDir(b.appPkg),
+		filepath.Base(b.appPkg.rpkg.Lpkg.Name())+".img")
 }
 
 func (b *Builder) AppHexPath() string {
-	return b.PkgBinDir(b.appPkg) + "/" + filepath.Base(b.appPkg.rpkg.Lpkg.Name()) +
-		".hex"
+	return filepath.Join(b.PkgBinDir(b.appPkg),
+		filepath.Base(b.appPkg.rpkg.Lpkg.Name())+".hex")
 }
 
 func (b *Builder) AppBinPath() string {
@@ -185,6 +251,6 @@ func (b *Builder) ManifestPath() string {
 }
 
 func (b *Builder) AppBinBasePath() string {
-	return b.PkgBinDir(b.appPkg) + "/" +
-		filepath.Base(b.appPkg.rpkg.Lpkg.Name())
+	return filepath.Join(b.PkgBinDir(b.appPkg),
+		filepath.Base(b.appPkg.rpkg.Lpkg.Name()))
 }
@@ -0,0 +1,222 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/svd"
+	"mynewt.apache.org/newt/util"
+)
+
+type FieldValue struct {
+	Name  string `json:"name"`
+	Msb   int    `json:"msb"`
+	Lsb   int    `json:"lsb"`
+	Value uint64 `json:"value"`
+
+	// Name of the matching enumerated value, if any.
+	Enum string `json:"enum,omitempty"`
+
+	Description string `json:"description,omitempty"`
+}
+
+type RegisterValue struct {
+	Name        string       `json:"name"`
+	Address     uint32       `json:"address"`
+	Size        int          `json:"size"`
+	Value       uint64       `json:"value"`
+	Fields      []FieldValue `json:"fields,omitempty"`
+	Description string       `json:"description,omitempty"`
+
+	// Why the register was not read; empty if it was.
+	Skipped string `json:"skipped,omitempty"`
+}
+
+type PeripheralValues struct {
+	Name        string          `json:"name"`
+	BaseAddress uint32          `json:"base_address"`
+	Description string          `json:"description,omitempty"`
+	Registers   []RegisterValue `json:"registers"`
+}
+
+// Loads the SVD file describing the target's MCU.  svdPath overrides the
+// BSP's bsp.svd setting if it is not empty.
+func (t *TargetBuilder) SvdDevice(svdPath string) (*svd.Device, error) {
+	if svdPath == "" {
+		svdPath = t.bspPkg.SvdFile
+	}
+	if svdPath == "" {
+		return nil, util.FmtNewtError("BSP %s does not specify an SVD "+
+			"file (bsp.svd); use --svd to specify one",
+			t.bspPkg.FullName())
+	}
+
+	return svd.Load(svdPath)
+}
+
+// Groups registers into runs of adjacent registers so that each run can be
+// read in one access.  Reserved gaps are never read; on some MCUs doing so
+// causes a bus fault.
+func registerRuns(base uint32, regs []*svd.Register) ([]memRange,
+	[][]*svd.Register) {
+
+	ranges := []memRange{}
+	runs := [][]*svd.Register{}
+
+	for _, r := range regs {
+		addr := base + r.Offset
+		size := (r.Size + 7) / 8
+
+		last := len(ranges) - 1
+		if last >= 0 &&
+			ranges[last].addr+uint32(ranges[last].size) == addr {
+
+			ranges[last].size += size
+			runs[last] = append(runs[last], r)
+		} else {
+			ranges = append(ranges, memRange{addr, size})
+			runs = append(runs, []*svd.Register{r})
+		}
+	}
+
+	return ranges, runs
+}
+
+func registerValue(base uint32, r *svd.Register, val uint64) RegisterValue {
+	rv := RegisterValue{
+		Name:        r.Name,
+		Address:     base + r.Offset,
+		Size:        r.Size,
+		Value:       val,
+		Description: r.Description,
+	}
+
+	for _, f := range r.Fields {
+		fv := FieldValue{
+			Name:        f.Name,
+			Msb:         f.Lsb + f.Width - 1,
+			Lsb:         f.Lsb,
+			Value:       f.Extract(val),
+			Description: f.Description,
+		}
+		if e := f.Enum(fv.Value); e != nil {
+			fv.Enum = e.Name
+		}
+		rv.Fields = append(rv.Fields, fv)
+	}
+
+	return rv
+}
+
+// Reads the registers of a peripheral described by the target's SVD file
+// over the debug connection.  If regNames is not empty, only the named
+// registers are read.  Registers that are write-only or that have read side
+// effects (e.g., clearing a flag) are reported but not read.
+func (t *TargetBuilder) ReadPeripheral(dev *svd.Device, name string,
+	regNames []string) (*PeripheralValues, error) {
+
+	periph := dev.Peripheral(name)
+	if periph == nil {
+		return nil, util.FmtNewtError("SVD file for %s has no peripheral "+
+			"\"%s\"", dev.Name, name)
+	}
+
+	probe, err := t.selectedProbe()
+	if err != nil {
+		return nil, err
+	}
+	if probe == nil {
+		return nil, util.NewNewtError("Reading peripheral registers " +
+			"requires a probe backend (--jtag or the BSP's " +
+			"bsp.probe_default)")
+	}
+
+	regs := []*svd.Register{}
+	for i, _ := range periph.Registers {
+		r := &periph.Registers[i]
+		if len(regNames) == 0 {
+			regs = append(regs, r)
+			continue
+		}
+		for _, rn := range regNames {
+			if strings.EqualFold(r.Name, rn) {
+				regs = append(regs, r)
+				break
+			}
+		}
+	}
+	if len(regs) == 0 {
+		return nil, util.FmtNewtError("Peripheral %s has no registers "+
+			"named %s", periph.Name, strings.Join(regNames, ", "))
+	}
+
+	readable := []*svd.Register{}
+	for _, r := range regs {
+		if r.Readable() {
+			readable = append(readable, r)
+		}
+	}
+
+	values := map[*svd.Register]uint64{}
+	if len(readable) > 0 {
+		ranges, runs := registerRuns(periph.BaseAddress, readable)
+		data, err := probeReadMem(probe, ranges)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, run := range runs {
+			off := 0
+			for _, r := range run {
+				size := (r.Size + 7) / 8
+				if off+size > len(data[i]) {
+					return nil, util.FmtNewtError("Short read at 0x%x",
+						ranges[i].addr)
+				}
+
+				buf := make([]byte, 8)
+				copy(buf, data[i][off:off+size])
+				values[r] = binary.LittleEndian.Uint64(buf)
+				off += size
+			}
+		}
+	}
+
+	pv := &PeripheralValues{
+		Name:        periph.Name,
+		BaseAddress: periph.BaseAddress,
+		Description: periph.Description,
+	}
+	for _, r := range regs {
+		rv := registerValue(periph.BaseAddress, r, values[r])
+		if !r.Readable() {
+			rv.Fields = nil
+			if r.ReadAction != "" {
+				rv.Skipped = "read side effect: " + r.ReadAction
+			} else {
+				rv.Skipped = r.Access
+			}
+		}
+		pv.Registers = append(pv.Registers, rv)
+	}
+
+	return pv, nil
+}
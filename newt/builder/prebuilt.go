@@ -0,0 +1,163 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+// A prebuilt package ships a static library in place of source code.  Its
+// pkg.yml specifies:
+//     pkg.prebuilt.archive:   Path of the .a file, relative to the package.
+//     pkg.prebuilt.compilers: Compiler packages the archive is known to work
+//                             with (optional; any compiler if unspecified).
+//     pkg.prebuilt.abi_flags: Compiler options the archive was built with
+//                             (e.g., -mcpu, -mfloat-abi, -mfpu).  The target
+//                             must use a matching value for each.
+// Public headers are found in the package's include directory as usual.
+
+func isPrebuilt(bpkg *BuildPackage) bool {
+	return bpkg.rpkg.Lpkg.Type() == pkg.PACKAGE_TYPE_PREBUILT
+}
+
+func (bpkg *BuildPackage) prebuiltArchive(b *Builder) (string, error) {
+	lpkg := bpkg.rpkg.Lpkg
+	features := b.cfg.FeaturesForLpkg(lpkg)
+
+	archive := newtutil.GetStringFeatures(lpkg.PkgV, features,
+		"pkg.prebuilt.archive")
+	if archive == "" {
+		return "", util.FmtNewtError(
+			"Prebuilt package %s does not specify pkg.prebuilt.archive",
+			lpkg.FullName())
+	}
+
+	if !filepath.IsAbs(archive) {
+		archive = filepath.Join(lpkg.BasePath(), archive)
+	}
+	if util.NodeNotExist(archive) {
+		return "", util.FmtNewtError(
+			"Prebuilt package %s: archive does not exist: %s",
+			lpkg.FullName(), archive)
+	}
+
+	return archive, nil
+}
+
+// Produces the single job for a prebuilt package: copying its archive into
+// the package's bin directory so that it gets linked.  The package's sources,
+// if any, are not compiled.
+func (b *Builder) collectPrebuiltEntries(c *toolchain.Compiler,
+	bpkg *BuildPackage) ([]toolchain.CompilerJob, error) {
+
+	archive, err := bpkg.prebuiltArchive(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return []toolchain.CompilerJob{{
+		Filename:     archive,
+		Compiler:     c,
+		CompilerType: toolchain.COMPILER_TYPE_ARCHIVE,
+	}}, nil
+}
+
+// Describes each way in which the target's compiler and flags are
+// incompatible with the declarations of the specified prebuilt package.
+func (b *Builder) prebuiltIncompatibilities(bpkg *BuildPackage,
+	cflags []string) []string {
+
+	lpkg := bpkg.rpkg.Lpkg
+	features := b.cfg.FeaturesForLpkg(lpkg)
+	problems := []string{}
+
+	compilers := newtutil.GetStringSliceFeatures(lpkg.PkgV, features,
+		"pkg.prebuilt.compilers")
+	if len(compilers) > 0 && b.compilerPkg != nil {
+		cpkg := b.compilerPkg.rpkg.Lpkg
+		found := false
+		for _, name := range compilers {
+			if name == cpkg.FullName() || name == cpkg.Name() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf(
+				"built with %s; target uses %s",
+				strings.Join(compilers, " or "), cpkg.FullName()))
+		}
+	}
+
+	abiFlags := newtutil.GetStringSliceFeatures(lpkg.PkgV, features,
+		"pkg.prebuilt.abi_flags")
+	problems = append(problems,
+		toolchain.IncompatibleFlags(abiFlags, cflags)...)
+
+	return problems
+}
+
+// Verifies that every prebuilt package in the build is compatible with the
+// target's compiler and flags.
+func (b *Builder) verifyPrebuilts() error {
+	prebuilts := []*BuildPackage{}
+	for _, bpkg := range b.sortedBuildPackages() {
+		if isPrebuilt(bpkg) {
+			prebuilts = append(prebuilts, bpkg)
+		}
+	}
+	if len(prebuilts) == 0 {
+		return nil
+	}
+
+	c, err := b.newCompiler(nil, b.BinDir())
+	if err != nil {
+		return err
+	}
+	cflags := c.Cflags()
+
+	buffer := ""
+	for _, bpkg := range prebuilts {
+		if _, err := bpkg.prebuiltArchive(b); err != nil {
+			return err
+		}
+
+		problems := b.prebuiltIncompatibilities(bpkg, cflags)
+		if len(problems) > 0 {
+			buffer += fmt.Sprintf("    %s:\n", bpkg.rpkg.Lpkg.FullName())
+			for _, p := range problems {
+				buffer += fmt.Sprintf("        %s\n", p)
+			}
+		}
+	}
+
+	if buffer != "" {
+		return util.NewNewtError(
+			"Prebuilt packages incompatible with target:\n" + buffer)
+	}
+
+	return nil
+}
@@ -0,0 +1,357 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+// Selects the BSP's download and debug scripts, overriding a default probe
+// backend in bsp.yml.
+const PROBE_SCRIPT = "script"
+
+const PROBE_PYOCD = "pyocd"
+const PROBE_PROBE_RS = "probe-rs"
+
+const PYOCD_DEFAULT_BINARY = "pyocd"
+const PYOCD_DEFAULT_GDB_PORT = 3333
+const PROBE_RS_DEFAULT_BINARY = "probe-rs"
+const PROBE_RS_DEFAULT_GDB_PORT = 1337
+
+// Selects the debug probe backend used by load and debug operations.  An
+// empty name selects the BSP's default (bsp.probe_default), or its scripts if
+// it has no default.
+func (t *TargetBuilder) SetProbe(name string) {
+	t.probe = name
+}
+
+// Selects the debug probe to use by serial number, for hosts with several
+// boards attached.  Probe backends pass it to the backend; the BSP's scripts
+// receive it in the PROBE_SERIAL environment variable.
+func (t *TargetBuilder) SetProbeSerial(serial string) {
+	t.probeSerial = serial
+}
+
+// Returns the probe configuration load and debug operations should use, or
+// nil if the BSP's scripts should be used.
+func (t *TargetBuilder) selectedProbe() (*pkg.BspProbe, error) {
+	name := t.probe
+	if name == "" {
+		name = t.bspPkg.DefaultProbe
+	}
+
+	switch name {
+	case "", PROBE_SCRIPT:
+		return nil, nil
+	case PROBE_PYOCD, PROBE_PROBE_RS:
+	default:
+		return nil, util.FmtNewtError("Unknown probe backend \"%s\"; "+
+			"must be %s, %s, or %s", name, PROBE_PYOCD, PROBE_PROBE_RS,
+			PROBE_SCRIPT)
+	}
+
+	probe := t.bspPkg.Probes[name]
+	if probe == nil {
+		supported := "none"
+		if names := ProbeNames(t.bspPkg); len(names) > 0 {
+			supported = strings.Join(names, ", ")
+		}
+		return nil, util.FmtNewtError("BSP %s does not describe probe "+
+			"backend \"%s\" (bsp.probe.%s); supported backends: %s",
+			t.bspPkg.FullName(), name, name, supported)
+	}
+
+	selected := *probe
+	selected.Serial = t.probeSerial
+
+	return &selected, nil
+}
+
+// Returns the names of the probe backends the BSP describes.
+func ProbeNames(bsp *pkg.BspPackage) []string {
+	names := make([]string, 0, len(bsp.Probes))
+	for name, _ := range bsp.Probes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func probeBinary(probe *pkg.BspProbe) string {
+	if probe.Binary != "" {
+		return probe.Binary
+	}
+	if probe.Name == PROBE_PROBE_RS {
+		return PROBE_RS_DEFAULT_BINARY
+	}
+	return PYOCD_DEFAULT_BINARY
+}
+
+func probeGdbPort(probe *pkg.BspProbe) int {
+	if probe.GdbPort != 0 {
+		return probe.GdbPort
+	}
+	if probe.Name == PROBE_PROBE_RS {
+		return PROBE_RS_DEFAULT_GDB_PORT
+	}
+	return PYOCD_DEFAULT_GDB_PORT
+}
+
+// Returns the arguments that select the probe, target and clock frequency.
+// pyocd takes the frequency in Hz; probe-rs in kHz.  probe-rs expects the
+// probe as <VID>:<PID>[:<serial>].
+func probeTargetArgs(probe *pkg.BspProbe) []string {
+	if probe.Name == PROBE_PROBE_RS {
+		args := []string{"--chip", probe.Target}
+		if probe.Serial != "" {
+			args = append(args, "--probe", probe.Serial)
+		}
+		if probe.Frequency > 0 {
+			args = append(args, "--speed",
+				strconv.Itoa((probe.Frequency+999)/1000))
+		}
+		return args
+	}
+
+	args := []string{"-t", probe.Target}
+	if probe.Serial != "" {
+		args = append(args, "-u", probe.Serial)
+	}
+	if probe.Frequency > 0 {
+		args = append(args, "-f", strconv.Itoa(probe.Frequency))
+	}
+	return args
+}
+
+func probeFlashCmd(probe *pkg.BspProbe, binPath string, offset int) []string {
+	addr := "0x" + strconv.FormatInt(int64(offset), 16)
+
+	var cmd []string
+	if probe.Name == PROBE_PROBE_RS {
+		cmd = []string{probeBinary(probe), "download"}
+		cmd = append(cmd, probeTargetArgs(probe)...)
+		cmd = append(cmd, "--binary-format", "bin", "--base-address", addr,
+			binPath)
+	} else {
+		cmd = []string{probeBinary(probe), "flash"}
+		cmd = append(cmd, probeTargetArgs(probe)...)
+		cmd = append(cmd, "--format", "bin", "--base-address", addr,
+			binPath)
+	}
+
+	return append(cmd, probe.Args...)
+}
+
+func probeResetCmd(probe *pkg.BspProbe) []string {
+	cmd := []string{probeBinary(probe), "reset"}
+	cmd = append(cmd, probeTargetArgs(probe)...)
+
+	return append(cmd, probe.Args...)
+}
+
+func probeGdbServerCmd(probe *pkg.BspProbe, port int) []string {
+	var cmd []string
+	if probe.Name == PROBE_PROBE_RS {
+		cmd = []string{probeBinary(probe), "gdb"}
+		cmd = append(cmd, probeTargetArgs(probe)...)
+		cmd = append(cmd, "--gdb-connection-string",
+			"127.0.0.1:"+strconv.Itoa(port))
+	} else {
+		cmd = []string{probeBinary(probe), "gdbserver"}
+		cmd = append(cmd, probeTargetArgs(probe)...)
+		cmd = append(cmd, "--port", strconv.Itoa(port))
+	}
+
+	return append(cmd, probe.Args...)
+}
+
+// The GDB monitor command that resets and halts the target.
+func probeGdbResetCmd(probe *pkg.BspProbe) string {
+	if probe.Name == PROBE_PROBE_RS {
+		return "monitor reset"
+	}
+	return "monitor reset halt"
+}
+
+func lookPathCmd(what string, cmd []string) error {
+	binPath, err := exec.LookPath(cmd[0])
+	if err != nil {
+		return util.FmtNewtError("Can't find %s executable \"%s\": %s",
+			what, cmd[0], err.Error()).WithClass(util.ERR_CLASS_TOOL_MISSING)
+	}
+	cmd[0] = binPath
+
+	return nil
+}
+
+func warnExtraJtagCmd(probe *pkg.BspProbe, extraJtagCmd string) {
+	if extraJtagCmd != "" {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"* Warning: extra JTAG commands are not supported by %s; "+
+				"ignoring\n", probe.Name)
+	}
+}
+
+func runProbeCmd(probe *pkg.BspProbe, cmd []string) error {
+	if err := lookPathCmd(probe.Name, cmd); err != nil {
+		return err
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "%s\n",
+		strings.Join(cmd, " "))
+	if _, err := util.ShellCommand(cmd, nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Writes a binary to flash at the specified offset and resets the target.
+type memRange struct {
+	addr uint32
+	size int
+}
+
+// Reads ranges of device memory through a probe backend.  pyOCD reads all
+// ranges in a single session; probe-rs needs one invocation per range.
+func probeReadMem(probe *pkg.BspProbe, ranges []memRange) ([][]byte, error) {
+	if probe.Name == PROBE_PROBE_RS {
+		return probeRsReadMem(probe, ranges)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "newt-mem")
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cmd := []string{probeBinary(probe), "cmd"}
+	cmd = append(cmd, probeTargetArgs(probe)...)
+	for i, r := range ranges {
+		cmd = append(cmd, "-c", fmt.Sprintf("savemem 0x%x %d %s", r.addr,
+			r.size, filepath.Join(tmpDir, strconv.Itoa(i))))
+	}
+	cmd = append(cmd, probe.Args...)
+
+	if err := runProbeCmd(probe, cmd); err != nil {
+		return nil, err
+	}
+
+	data := make([][]byte, len(ranges))
+	for i, _ := range ranges {
+		data[i], err = ioutil.ReadFile(filepath.Join(tmpDir, strconv.Itoa(i)))
+		if err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+	}
+
+	return data, nil
+}
+
+func probeRsReadMem(probe *pkg.BspProbe, ranges []memRange) ([][]byte,
+	error) {
+
+	data := make([][]byte, len(ranges))
+	for i, r := range ranges {
+		cmd := []string{probeBinary(probe), "read"}
+		cmd = append(cmd, probeTargetArgs(probe)...)
+		cmd = append(cmd, "b32", fmt.Sprintf("0x%x", r.addr),
+			strconv.Itoa((r.size+3)/4))
+		cmd = append(cmd, probe.Args...)
+
+		if err := lookPathCmd(probe.Name, cmd); err != nil {
+			return nil, err
+		}
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "%s\n",
+			strings.Join(cmd, " "))
+		out, err := util.ShellCommand(cmd, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// probe-rs prints the words in hex.
+		buf := new(bytes.Buffer)
+		for _, field := range strings.Fields(string(out)) {
+			word, err := strconv.ParseUint(
+				strings.TrimPrefix(field, "0x"), 16, 32)
+			if err != nil {
+				return nil, util.FmtNewtError(
+					"Unexpected probe-rs output: %s", field)
+			}
+			binary.Write(buf, binary.LittleEndian, uint32(word))
+		}
+		if buf.Len() < r.size {
+			return nil, util.FmtNewtError("probe-rs returned %d bytes; "+
+				"expected %d", buf.Len(), r.size)
+		}
+		data[i] = buf.Bytes()[:r.size]
+	}
+
+	return data, nil
+}
+
+func probeLoad(probe *pkg.BspProbe, binPath string, offset int,
+	extraJtagCmd string) error {
+
+	if util.NodeNotExist(binPath) {
+		return util.FmtNewtError("No image to load: %s", binPath)
+	}
+	warnExtraJtagCmd(probe, extraJtagCmd)
+
+	if err := runProbeCmd(probe, probeFlashCmd(probe, binPath,
+		offset)); err != nil {
+
+		return err
+	}
+	if err := runProbeCmd(probe, probeResetCmd(probe)); err != nil {
+		return err
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "Successfully loaded image.\n")
+	return nil
+}
+
+// Starts the probe's GDB server and attaches the toolchain's debugger to it.
+// If noGDB is set, only the server is run, in the foreground.
+func (b *Builder) probeDebug(probe *pkg.BspProbe, elfPath string,
+	extraJtagCmd string, reset bool, noGDB bool) error {
+
+	warnExtraJtagCmd(probe, extraJtagCmd)
+
+	gdbCmds := []string{}
+	if reset {
+		gdbCmds = append(gdbCmds, probeGdbResetCmd(probe))
+	}
+
+	return b.targetBuilder.gdbSession(probe, elfPath, probeGdbPort(probe),
+		gdbCmds, noGDB)
+}
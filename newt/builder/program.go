@@ -0,0 +1,201 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/flash"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+// Also programs the BSP's EEPROM and OTP areas when loading; see
+// flash.FlashMap.ProgramDescs().
+func (t *TargetBuilder) SetProgramAreas(program bool) {
+	t.programAreas = program
+}
+
+func programDataIsHex(desc flash.ProgramDesc) bool {
+	return strings.ToLower(filepath.Ext(desc.Data)) == ".hex"
+}
+
+// Returns the command that writes an area's data.  Hex files carry their own
+// addresses; binaries are written at the start of the area.  Write-once areas
+// get the BSP's bsp.probe.<name>.otp_args in addition to the usual arguments.
+func probeProgramCmd(probe *pkg.BspProbe, desc flash.ProgramDesc) []string {
+	format := "bin"
+	if programDataIsHex(desc) {
+		format = "hex"
+	}
+
+	var cmd []string
+	if probe.Name == PROBE_PROBE_RS {
+		cmd = []string{probeBinary(probe), "download"}
+		cmd = append(cmd, probeTargetArgs(probe)...)
+		cmd = append(cmd, "--binary-format", format)
+	} else {
+		cmd = []string{probeBinary(probe), "flash"}
+		cmd = append(cmd, probeTargetArgs(probe)...)
+		cmd = append(cmd, "--format", format)
+	}
+	if format == "bin" {
+		cmd = append(cmd, "--base-address",
+			"0x"+strconv.FormatInt(int64(desc.Address), 16))
+	}
+	cmd = append(cmd, desc.Data)
+
+	cmd = append(cmd, probe.Args...)
+	if desc.WriteOnce {
+		cmd = append(cmd, probe.OtpArgs...)
+	}
+
+	return cmd
+}
+
+// Determines whether a write-once area needs to be written.  The area must
+// either be blank or already hold the data; anything else can't be fixed
+// without replacing the part.
+func probeOtpNeedsWrite(probe *pkg.BspProbe, desc flash.ProgramDesc,
+	data []byte) (bool, error) {
+
+	mem, err := probeReadMem(probe, []memRange{{
+		addr: uint32(desc.Address),
+		size: len(data),
+	}})
+	if err != nil {
+		return false, err
+	}
+
+	if bytes.Equal(mem[0], data) {
+		return false, nil
+	}
+
+	for _, b := range mem[0] {
+		if b != 0xff {
+			return false, util.FmtNewtError(
+				"%s area %s (0x%x) is already programmed with different "+
+					"data; refusing to write it", desc.Type, desc.Area,
+				desc.Address)
+		}
+	}
+
+	return true, nil
+}
+
+func probeProgramArea(probe *pkg.BspProbe, desc flash.ProgramDesc) error {
+	if !programDataIsHex(desc) {
+		data, err := ioutil.ReadFile(desc.Data)
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+		if len(data) > desc.Size {
+			return util.FmtNewtError(
+				"Data for %s area %s is too large: %s (%d bytes); area "+
+					"size=%d", desc.Type, desc.Area, desc.Data, len(data),
+				desc.Size)
+		}
+
+		if desc.WriteOnce {
+			write, err := probeOtpNeedsWrite(probe, desc, data)
+			if err != nil {
+				return err
+			}
+			if !write {
+				util.StatusMessage(util.VERBOSITY_DEFAULT,
+					"%s area %s already programmed\n", desc.Type, desc.Area)
+				return nil
+			}
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Programming %s area %s (0x%x) from %s\n", desc.Type, desc.Area,
+		desc.Address, desc.Data)
+
+	return runProbeCmd(probe, probeProgramCmd(probe, desc))
+}
+
+// Programs the EEPROM and OTP areas that the BSP provides data for.  With the
+// BSP's scripts, the download script receives the descriptors instead (see
+// Builder.Load()).
+func (t *TargetBuilder) loadProgramAreas() error {
+	probe, err := t.selectedProbe()
+	if err != nil {
+		return err
+	}
+	if probe == nil {
+		return nil
+	}
+
+	descs := t.bspPkg.FlashMap.ProgramDescs()
+	if len(descs) == 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"BSP %s has no EEPROM or OTP areas to program\n",
+			t.bspPkg.FullName())
+		return nil
+	}
+
+	for _, desc := range descs {
+		if desc.Data == "" {
+			util.StatusMessage(util.VERBOSITY_VERBOSE,
+				"No data for %s area %s; skipping\n", desc.Type, desc.Area)
+			continue
+		}
+		if util.NodeNotExist(desc.Data) {
+			return util.FmtNewtError("Data for %s area %s not found: %s",
+				desc.Type, desc.Area, desc.Data)
+		}
+
+		if err := probeProgramArea(probe, desc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Writes the target's programming descriptors and returns the absolute path
+// of the file, or "" if the BSP has no areas to program.
+func (t *TargetBuilder) programDescPath() (string, error) {
+	path := GeneratedProgramDescPath(t.target.Name())
+	if err := t.bspPkg.FlashMap.EnsureProgramDescsWritten(path); err != nil {
+		return "", err
+	}
+	if util.NodeNotExist(path) {
+		return "", nil
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	return abs, nil
+}
+
+func programAreasLoadMethodErr(method string) error {
+	return util.FmtNewtError(
+		"--program-areas cannot be used with --method %s", method)
+}
@@ -0,0 +1,292 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Linker script symbols delimiting the heap and the interrupt stack.
+const (
+	RAM_SYM_HEAP_BASE   = "__HeapBase"
+	RAM_SYM_HEAP_LIMIT  = "__HeapLimit"
+	RAM_SYM_STACK_LIMIT = "__StackLimit"
+	RAM_SYM_STACK_TOP   = "__StackTop"
+)
+
+// Suffix of the syscfg settings specifying task stack sizes, in os_stack_t
+// units.
+const RAM_STACK_SETTING_SUFFIX = "_STACK_SIZE"
+
+// Size of os_stack_t on the 32-bit architectures Mynewt targets.
+const RAM_STACK_WORD_SIZE = 4
+
+// A portion of RAM set aside for a specific runtime purpose.
+type RamReservation struct {
+	Name   string `json:"name"`
+	Size   uint64 `json:"size"`
+	Source string `json:"source"`
+}
+
+// How an image's RAM is used, including the runtime reservations carved out
+// of its static data.
+type RamBudget struct {
+	Image  string `json:"image"`
+	Region string `json:"region"`
+	Origin uint64 `json:"origin"`
+	Length uint64 `json:"length"`
+
+	// Output sections placed in RAM (.data, .bss, ...).
+	Sections []SectionSizeSummary `json:"sections"`
+	Static   uint64               `json:"static"`
+
+	// Reservations contained in the static data.
+	TaskStacks []RamReservation `json:"task_stacks"`
+	Msys       []RamReservation `json:"msys"`
+	Pools      []RamPool        `json:"pools"`
+
+	// Static data not accounted for by the reservations above.
+	OtherStatic uint64 `json:"other_static"`
+
+	InterruptStack uint64 `json:"interrupt_stack"`
+	Heap           uint64 `json:"heap"`
+
+	// RAM not used by any of the above; negative if the figures overlap.
+	Unused int64 `json:"unused"`
+}
+
+func sumReservations(rs []RamReservation) uint64 {
+	total := uint64(0)
+	for _, r := range rs {
+		total += r.Size
+	}
+	return total
+}
+
+type reservationSorter struct {
+	reservations []RamReservation
+}
+
+func (s reservationSorter) Len() int {
+	return len(s.reservations)
+}
+func (s reservationSorter) Swap(i, j int) {
+	s.reservations[i], s.reservations[j] = s.reservations[j], s.reservations[i]
+}
+func (s reservationSorter) Less(i, j int) bool {
+	return s.reservations[i].Name < s.reservations[j].Name
+}
+
+// Returns the task stacks configured by syscfg *_STACK_SIZE settings.
+func (b *Builder) taskStackReservations(wordSize int) []RamReservation {
+	stacks := []RamReservation{}
+	for name, entry := range b.targetBuilder.res.Cfg.Settings {
+		if !strings.HasSuffix(name, RAM_STACK_SETTING_SUFFIX) {
+			continue
+		}
+
+		words, err := util.AtoiNoOct(entry.Value)
+		if err != nil || words <= 0 {
+			continue
+		}
+
+		stacks = append(stacks, RamReservation{
+			Name:   strings.TrimSuffix(name, RAM_STACK_SETTING_SUFFIX),
+			Size:   uint64(words * wordSize),
+			Source: "syscfg " + name,
+		})
+	}
+
+	sort.Sort(reservationSorter{stacks})
+
+	return stacks
+}
+
+// Returns the msys mbuf pools.  The size of a pool is taken from its data
+// symbol in the linker map if present; otherwise it is estimated from the
+// MSYS_<n>_BLOCK_COUNT and MSYS_<n>_BLOCK_SIZE settings.
+func (b *Builder) msysReservations(
+	symSizes map[string]uint64) []RamReservation {
+
+	settings := b.targetBuilder.res.Cfg.Settings
+
+	pools := []RamReservation{}
+	for n := 1; ; n++ {
+		countName := fmt.Sprintf("MSYS_%d_BLOCK_COUNT", n)
+		sizeName := fmt.Sprintf("MSYS_%d_BLOCK_SIZE", n)
+
+		countEntry, ok1 := settings[countName]
+		sizeEntry, ok2 := settings[sizeName]
+		if !ok1 || !ok2 {
+			break
+		}
+
+		count, err1 := util.AtoiNoOct(countEntry.Value)
+		size, err2 := util.AtoiNoOct(sizeEntry.Value)
+		if err1 != nil || err2 != nil || count <= 0 {
+			continue
+		}
+
+		name := fmt.Sprintf("msys_%d", n)
+		sym := fmt.Sprintf("os_msys_init_%d_data", n)
+		if sz, ok := symSizes[sym]; ok {
+			pools = append(pools, RamReservation{
+				Name:   name,
+				Size:   sz,
+				Source: "symbol " + sym,
+			})
+		} else {
+			pools = append(pools, RamReservation{
+				Name:   name,
+				Size:   uint64(count * ((size + 3) &^ 3)),
+				Source: fmt.Sprintf("syscfg %s x %s", countName, sizeName),
+			})
+		}
+	}
+
+	return pools
+}
+
+type sectionNameSorter struct {
+	sections []SectionSizeSummary
+}
+
+func (s sectionNameSorter) Len() int {
+	return len(s.sections)
+}
+func (s sectionNameSorter) Swap(i, j int) {
+	s.sections[i], s.sections[j] = s.sections[j], s.sections[i]
+}
+func (s sectionNameSorter) Less(i, j int) bool {
+	return s.sections[i].Name < s.sections[j].Name
+}
+
+// Determines how the image's RAM is used.  wordSize is the size of
+// os_stack_t, used to convert task stack settings to bytes.
+func (b *Builder) RamBudget(wordSize int) (*RamBudget, error) {
+	if err := b.checkSizeable(); err != nil {
+		return nil, err
+	}
+
+	mapPath := b.AppElfPath() + ".map"
+	libs, err := ParseMapFileSizes(mapPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var ram *MemSection
+	for _, sec := range globalMemSections {
+		if strings.EqualFold(sec.Name, "ram") {
+			ram = sec
+		}
+	}
+	if ram == nil {
+		return nil, util.FmtNewtError("No RAM region in %s", mapPath)
+	}
+
+	rb := &RamBudget{
+		Image:    b.buildName,
+		Region:   ram.Name,
+		Origin:   ram.Offset,
+		Length:   ram.EndOff - ram.Offset,
+		Sections: []SectionSizeSummary{},
+	}
+
+	secSizes := map[string]uint64{}
+	symSizes := map[string]uint64{}
+	for _, es := range libs {
+		for _, sym := range es.Syms {
+			for sec, sz := range sym.SecSizes {
+				if globalSecRegions[sec] == ram.Name {
+					secSizes[sec] += uint64(sz)
+					symSizes[sym.Name] += uint64(sz)
+				}
+			}
+		}
+	}
+
+	// .stack_dummy only reserves room for the interrupt stack, which is
+	// accounted for separately.
+	delete(secSizes, ".stack_dummy")
+
+	for name, sz := range secSizes {
+		rb.Sections = append(rb.Sections, SectionSizeSummary{
+			Name:   name,
+			Region: ram.Name,
+			Size:   uint32(sz),
+		})
+		rb.Static += sz
+	}
+	sort.Sort(sectionNameSorter{rb.Sections})
+
+	rb.TaskStacks = b.taskStackReservations(wordSize)
+	rb.Msys = b.msysReservations(symSizes)
+	rb.Pools, err = b.ramPools(symSizes)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := sumReservations(rb.TaskStacks) + sumReservations(rb.Msys)
+	for _, p := range rb.Pools {
+		reserved += p.Size
+	}
+	if reserved < rb.Static {
+		rb.OtherStatic = rb.Static - reserved
+	}
+
+	mf, err := ParseMapFile(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	syms := mf.Assignments
+	if syms[RAM_SYM_HEAP_LIMIT] > syms[RAM_SYM_HEAP_BASE] {
+		rb.Heap = syms[RAM_SYM_HEAP_LIMIT] - syms[RAM_SYM_HEAP_BASE]
+	}
+	if syms[RAM_SYM_STACK_TOP] > syms[RAM_SYM_STACK_LIMIT] {
+		rb.InterruptStack = syms[RAM_SYM_STACK_TOP] -
+			syms[RAM_SYM_STACK_LIMIT]
+	}
+
+	rb.Unused = int64(rb.Length) - int64(rb.Static) -
+		int64(rb.InterruptStack) - int64(rb.Heap)
+
+	return rb, nil
+}
+
+// Determines how each of the target's images uses its RAM.
+func (t *TargetBuilder) RamBudgets(wordSize int) ([]*RamBudget, error) {
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	budgets := []*RamBudget{}
+	for _, b := range t.builders() {
+		rb, err := b.RamBudget(wordSize)
+		if err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, rb)
+	}
+
+	return budgets, nil
+}
@@ -0,0 +1,178 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// A statically allocated pool whose size is set by syscfg settings.
+type ramPoolDef struct {
+	// Data symbol holding the pool.
+	Symbol string
+
+	// Settings whose product is the number of elements in the pool.
+	Settings []string
+}
+
+// Pools of the core packages.  Packages can declare their own with
+// "pkg.ram_pools", a list of "<symbol>=<setting>[*<setting>...]" entries.
+var builtinRamPools = []ramPoolDef{
+	{"ble_hs_conn_elem_mem", []string{"BLE_MAX_CONNECTIONS"}},
+	{"ble_gatts_clt_cfg_mem", []string{"BLE_MAX_CONNECTIONS"}},
+	{"ble_gattc_proc_mem", []string{"BLE_GATT_MAX_PROCS"}},
+	{"ble_l2cap_chan_mem", nil},
+	{"ble_l2cap_sig_proc_mem", []string{"BLE_L2CAP_SIG_MAX_PROCS"}},
+	{"ble_sm_proc_mem", []string{"BLE_SM_MAX_PROCS"}},
+	{"ble_store_config_our_secs", []string{"BLE_STORE_MAX_BONDS"}},
+	{"ble_store_config_peer_secs", []string{"BLE_STORE_MAX_BONDS"}},
+	{"ble_store_config_cccds", []string{"BLE_STORE_MAX_CCCDS"}},
+	{"g_ble_ll_conn_sm", []string{"BLE_MAX_CONNECTIONS"}},
+}
+
+// The RAM taken by a syscfg-configured pool in a linked image.
+type RamPool struct {
+	RamReservation
+
+	// The settings sizing the pool, as "<name>=<value>".
+	Settings []string `json:"settings"`
+
+	// Number of elements, if the settings determine it; 0 otherwise.
+	Count int `json:"count,omitempty"`
+
+	// Size of one element, if the count is known.
+	ElemSize uint64 `json:"elem_size,omitempty"`
+}
+
+func parseRamPoolDef(pkgName string, entry string) (ramPoolDef, error) {
+	parts := strings.SplitN(entry, "=", 2)
+	sym := strings.TrimSpace(parts[0])
+	if sym == "" || !linkSymbolRe.MatchString(sym) {
+		return ramPoolDef{}, util.FmtNewtError(
+			"Package %s: invalid pkg.ram_pools entry \"%s\"; expected "+
+				"<symbol>=<setting>[*<setting>...]", pkgName, entry)
+	}
+
+	def := ramPoolDef{Symbol: sym}
+	if len(parts) == 2 {
+		for _, s := range strings.Split(parts[1], "*") {
+			if s = strings.TrimSpace(s); s != "" {
+				def.Settings = append(def.Settings, s)
+			}
+		}
+	}
+
+	return def, nil
+}
+
+// Returns the pool definitions applicable to the build: the built-in ones
+// and those declared by the build's packages.
+func (b *Builder) ramPoolDefs() ([]ramPoolDef, error) {
+	defs := append([]ramPoolDef{}, builtinRamPools...)
+
+	for _, bpkg := range b.sortedBuildPackages() {
+		name := bpkg.rpkg.Lpkg.FullName()
+		for _, entry := range bpkg.pkgFlags(b, "pkg.ram_pools") {
+			def, err := parseRamPoolDef(name, entry)
+			if err != nil {
+				return nil, err
+			}
+			defs = append(defs, def)
+		}
+	}
+
+	return defs, nil
+}
+
+type ramPoolSorter struct {
+	pools []RamPool
+}
+
+func (s ramPoolSorter) Len() int {
+	return len(s.pools)
+}
+func (s ramPoolSorter) Swap(i, j int) {
+	s.pools[i], s.pools[j] = s.pools[j], s.pools[i]
+}
+func (s ramPoolSorter) Less(i, j int) bool {
+	return s.pools[i].Name < s.pools[j].Name
+}
+
+// Returns the syscfg-configured pools present in the image.  Sizes come from
+// the pools' symbols in the linker map; the settings only explain them.
+func (b *Builder) ramPools(symSizes map[string]uint64) ([]RamPool, error) {
+	defs, err := b.ramPoolDefs()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := b.targetBuilder.res.Cfg.Settings
+
+	seen := map[string]bool{}
+	pools := []RamPool{}
+	for _, def := range defs {
+		size, ok := symSizes[def.Symbol]
+		if !ok || seen[def.Symbol] {
+			continue
+		}
+		seen[def.Symbol] = true
+
+		pool := RamPool{
+			RamReservation: RamReservation{
+				Name:   def.Symbol,
+				Size:   size,
+				Source: "symbol " + def.Symbol,
+			},
+			Settings: []string{},
+		}
+
+		count := 1
+		for _, s := range def.Settings {
+			entry, ok := settings[s]
+			if !ok {
+				count = 0
+				continue
+			}
+			pool.Settings = append(pool.Settings,
+				fmt.Sprintf("%s=%s", s, entry.Value))
+
+			n, err := util.AtoiNoOct(entry.Value)
+			if err != nil || n <= 0 {
+				count = 0
+			}
+			count *= n
+		}
+
+		if len(def.Settings) > 0 && count > 0 {
+			pool.Count = count
+			pool.ElemSize = size / uint64(count)
+		}
+
+		pools = append(pools, pool)
+	}
+
+	sort.Sort(ramPoolSorter{pools})
+
+	return pools, nil
+}
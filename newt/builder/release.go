@@ -0,0 +1,403 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mynewt.apache.org/newt/newt/audit"
+	"mynewt.apache.org/newt/newt/image"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+const RELEASE_SUMS_FILENAME = "SHA256SUMS"
+const RELEASE_SBOM_FILENAME = "sbom.spdx.json"
+
+// A file stored in a release archive.
+type releaseFile struct {
+	// Path within the archive, relative to its top-level directory.
+	name string
+
+	// File to copy; ignored if data is set.
+	src  string
+	data []byte
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string `json:"SPDXID"`
+	Name             string `json:"name"`
+	VersionInfo      string `json:"versionInfo,omitempty"`
+	DownloadLocation string `json:"downloadLocation"`
+	FilesAnalyzed    bool   `json:"filesAnalyzed"`
+	LicenseConcluded string `json:"licenseConcluded"`
+	LicenseDeclared  string `json:"licenseDeclared"`
+	CopyrightText    string `json:"copyrightText"`
+}
+
+type spdxRelationship struct {
+	Element string `json:"spdxElementId"`
+	Type    string `json:"relationshipType"`
+	Related string `json:"relatedSpdxElement"`
+}
+
+type spdxDocument struct {
+	SpdxVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+// Returns the archive entries for one of the target's images: the signed
+// image, hex file, ELF, linker map and the ELF's debug information.  The
+// image must be the one the manifest describes (hash is the manifest's
+// record of it); otherwise the manifest is stale.  Also returns the image's
+// signature type, empty if it is unsigned.
+func (b *Builder) releaseFiles(c *toolchain.Compiler, dir string,
+	hash string, tmpDir string) ([]releaseFile, string, error) {
+
+	imgPath := b.AppImgPath()
+	data, err := ioutil.ReadFile(imgPath)
+	if err != nil {
+		return nil, "", util.FmtNewtError("Cannot read image %s; run "+
+			"\"newt create-image\" first: %s", imgPath, err.Error())
+	}
+	img, err := image.ParseImage(data)
+	if err != nil {
+		return nil, "", util.FmtNewtError("Invalid image %s: %s", imgPath,
+			err.Error())
+	}
+	if fmt.Sprintf("%x", img.Hash()) != hash {
+		return nil, "", util.FmtNewtError("Image %s does not match the "+
+			"build manifest; rerun \"newt create-image\"", imgPath)
+	}
+
+	elfPath := b.AppElfPath()
+	if util.NodeNotExist(elfPath) {
+		return nil, "", util.FmtNewtError("Missing ELF file %s", elfPath)
+	}
+
+	files := []releaseFile{
+		{name: dir + "/" + filepath.Base(imgPath), src: imgPath},
+		{name: dir + "/" + filepath.Base(elfPath), src: elfPath},
+	}
+
+	// The hex file is only generated when the BSP's flash map places the
+	// image; the map file depends on the linker.
+	for _, path := range []string{b.AppHexPath(), elfPath + ".map"} {
+		if util.NodeExist(path) {
+			files = append(files,
+				releaseFile{name: dir + "/" + filepath.Base(path), src: path})
+		}
+	}
+
+	dbgName := filepath.Base(elfPath) + ".debug"
+	dbgPath := filepath.Join(tmpDir, dir, dbgName)
+	if err := os.MkdirAll(filepath.Dir(dbgPath), 0755); err != nil {
+		return nil, "", util.ChildNewtError(err)
+	}
+	cmd, env := newtutil.ToolchainCmd(c.KeepDebugCmd(elfPath, dbgPath),
+		b.targetBuilder.target.Env())
+	if _, err := util.ShellCommand(cmd, env); err != nil {
+		return nil, "", err
+	}
+	files = append(files,
+		releaseFile{name: dir + "/" + dbgName, src: dbgPath})
+
+	return files, img.SigType(), nil
+}
+
+// Generates an SPDX software bill of materials listing the packages linked
+// into the target's images, with their repo commit and license.
+func (t *TargetBuilder) releaseSbom(
+	manifest *image.ImageManifest) ([]byte, error) {
+
+	lpkgMap := map[string]*pkg.LocalPackage{}
+	for _, b := range []*Builder{t.AppBuilder, t.LoaderBuilder} {
+		if b == nil {
+			continue
+		}
+		for rpkg, _ := range b.PkgMap {
+			lpkg := rpkg.Lpkg
+			if lpkg.Type() != pkg.PACKAGE_TYPE_TARGET {
+				lpkgMap[lpkg.FullName()] = lpkg
+			}
+		}
+	}
+	lpkgs := make([]*pkg.LocalPackage, 0, len(lpkgMap))
+	for _, lpkg := range lpkgMap {
+		lpkgs = append(lpkgs, lpkg)
+	}
+	lpkgs = pkg.SortLclPkgs(lpkgs)
+
+	repos := map[string]image.ImageManifestRepo{}
+	for _, r := range manifest.Repos {
+		repos[r.Name] = r
+	}
+
+	lics := map[string]string{}
+	report := audit.AuditLicenses(lpkgs, nil, project.GetProject().Path())
+	for _, pl := range report.Packages {
+		lics[pl.Package] = pl.License
+	}
+
+	name := fmt.Sprintf("%s-%s", t.target.FullName(), manifest.Version)
+	doc := spdxDocument{
+		SpdxVersion: "SPDX-2.3",
+		DataLicense: "CC0-1.0",
+		SPDXID:      "SPDXRef-DOCUMENT",
+		Name:        name,
+		DocumentNamespace: fmt.Sprintf("https://spdx.org/spdxdocs/%s-%s",
+			filepath.Base(t.target.Name()), manifest.BuildID),
+		CreationInfo: spdxCreationInfo{
+			Created:  time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+			Creators: []string{"Tool: newt-" + newtutil.NewtVersion.String()},
+		},
+		Packages: []spdxPackage{{
+			SPDXID:           "SPDXRef-Image",
+			Name:             t.target.FullName(),
+			VersionInfo:      manifest.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+		}},
+		Relationships: []spdxRelationship{{
+			Element: "SPDXRef-DOCUMENT",
+			Type:    "DESCRIBES",
+			Related: "SPDXRef-Image",
+		}},
+	}
+
+	for i, lpkg := range lpkgs {
+		sp := spdxPackage{
+			SPDXID:           fmt.Sprintf("SPDXRef-Package-%d", i+1),
+			Name:             lpkg.FullName(),
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+		}
+		if r, ok := repos[lpkg.Repo().Name()]; ok {
+			if r.Commit != "UNKNOWN" {
+				sp.VersionInfo = r.Commit
+			}
+			if r.URL != "" && sp.VersionInfo != "" {
+				sp.DownloadLocation = fmt.Sprintf("git+%s@%s", r.URL,
+					r.Commit)
+			}
+		}
+		if lic := lics[lpkg.FullName()]; lic != "" {
+			sp.LicenseDeclared = lic
+		}
+
+		doc.Packages = append(doc.Packages, sp)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			Element: "SPDXRef-Image",
+			Type:    "CONTAINS",
+			Related: sp.SPDXID,
+		})
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	return append(data, '\n'), nil
+}
+
+// Writes the specified files to a zip archive under a single top-level
+// directory, followed by a SHA256SUMS file in sha256sum(1) format.
+func writeRelease(outFile string, top string, files []releaseFile) error {
+	f, err := os.Create(outFile)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	now := time.Now()
+	create := func(name string) (io.Writer, error) {
+		return zw.CreateHeader(&zip.FileHeader{
+			Name:     top + "/" + name,
+			Method:   zip.Deflate,
+			Modified: now,
+		})
+	}
+
+	sums := ""
+	for _, rf := range files {
+		data := rf.data
+		if data == nil {
+			data, err = ioutil.ReadFile(rf.src)
+			if err != nil {
+				return util.ChildNewtError(err)
+			}
+		}
+
+		w, err := create(rf.name)
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return util.ChildNewtError(err)
+		}
+		sums += fmt.Sprintf("%x  %s\n", sha256.Sum256(data), rf.name)
+	}
+
+	w, err := create(RELEASE_SUMS_FILENAME)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	if _, err := w.Write([]byte(sums)); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return util.ChildNewtError(err)
+	}
+	return nil
+}
+
+// Bundles the target's most recently created images into a release archive:
+//
+//	<target>-<version>/
+//	    app/<app>.img, .hex, .elf, .elf.map, .elf.debug
+//	    loader/...          (split images only)
+//	    manifest.json
+//	    sbom.spdx.json
+//	    SHA256SUMS
+//
+// Nothing is built; the images must have been created with create-image.
+// Unsigned images are rejected unless allowUnsigned is set.  If outFile is
+// empty, the archive is named by the artifact name template, or
+// <target>-release.zip.
+func (t *TargetBuilder) Package(outFile string, allowUnsigned bool) error {
+	if err := t.PrepBuild(); err != nil {
+		return err
+	}
+	if t.appPkg == nil {
+		return util.FmtNewtError("Target %s has no app",
+			t.target.FullName())
+	}
+
+	manifestPath := t.AppBuilder.ManifestPath()
+	if util.NodeNotExist(manifestPath) {
+		return util.FmtNewtError("No build manifest for target %s; run "+
+			"\"newt create-image\" first", t.target.FullName())
+	}
+	manifest, err := readManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	if manifest.ImageHash == "" {
+		return util.FmtNewtError("Target %s has been built but has no "+
+			"image; run \"newt create-image\" first", t.target.FullName())
+	}
+
+	c, err := t.NewCompiler("")
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := ioutil.TempDir("", "newt-package")
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files, sigType, err := t.AppBuilder.releaseFiles(c, "app",
+		manifest.ImageHash, tmpDir)
+	if err != nil {
+		return err
+	}
+	unsigned := []string{}
+	if sigType == "" {
+		unsigned = append(unsigned, t.AppBuilder.AppImgPath())
+	}
+
+	if t.LoaderBuilder != nil {
+		lfiles, lsigType, err := t.LoaderBuilder.releaseFiles(c, "loader",
+			manifest.LoaderHash, tmpDir)
+		if err != nil {
+			return err
+		}
+		files = append(files, lfiles...)
+		if lsigType == "" {
+			unsigned = append(unsigned, t.LoaderBuilder.AppImgPath())
+		}
+	}
+
+	if len(unsigned) > 0 && !allowUnsigned {
+		return util.FmtNewtError("Unsigned image: %s; sign it with "+
+			"\"newt create-image <target> <version> <key>\"",
+			strings.Join(unsigned, ", "))
+	}
+
+	if outFile == "" {
+		outFile, err = t.ArtifactName(manifest.Version, ".zip")
+		if err != nil {
+			return err
+		}
+		if outFile == "" {
+			outFile = filepath.Base(t.target.Name()) + "-release.zip"
+		}
+	}
+
+	sbom, err := t.releaseSbom(manifest)
+	if err != nil {
+		return err
+	}
+	files = append(files,
+		releaseFile{name: "manifest.json", src: manifestPath},
+		releaseFile{name: RELEASE_SBOM_FILENAME, data: sbom})
+
+	top := fmt.Sprintf("%s-%s", filepath.Base(t.target.Name()),
+		manifest.Version)
+	if err := writeRelease(outFile, top, files); err != nil {
+		os.Remove(outFile)
+		return err
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Packaged %d files for target %s into %s\n", len(files)+1,
+		t.target.FullName(), outFile)
+	return nil
+}
@@ -0,0 +1,355 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mynewt.apache.org/newt/newt/symbol"
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newt/yaml"
+)
+
+// A symbol the loader's ROM elf exposes to split apps.
+type RomSymbol struct {
+	Name    string `json:"name"`
+	Section string `json:"section"`
+	Addr    int    `json:"addr"`
+	Size    int    `json:"size"`
+}
+
+// A frozen ROM symbol list, stored in the target's package.  Apps linked
+// against any loader built from a compatible ROM can be loaded alongside it.
+type RomSymbols struct {
+	Version string
+	Loader  string
+	Frozen  string
+	Symbols []RomSymbol
+}
+
+// A frozen symbol that is missing or different in the current ROM.
+type RomSymbolChange struct {
+	Old RomSymbol  `json:"old"`
+	New *RomSymbol `json:"new,omitempty"`
+}
+
+// Compatibility of the current ROM elf with a frozen symbol list.  The ROM
+// is compatible if every frozen symbol still exists with the same section,
+// address and size.  New symbols are allowed.
+type RomCompatReport struct {
+	Target  string            `json:"target"`
+	Version string            `json:"version"`
+	Frozen  int               `json:"frozen"`
+	Removed []RomSymbolChange `json:"removed"`
+	Moved   []RomSymbolChange `json:"moved"`
+	Resized []RomSymbolChange `json:"resized"`
+	Added   []RomSymbol       `json:"added"`
+}
+
+func (r *RomCompatReport) Compatible() bool {
+	return len(r.Removed) == 0 && len(r.Moved) == 0 && len(r.Resized) == 0
+}
+
+func (s RomSymbol) String() string {
+	return fmt.Sprintf("%s 0x%08x %d", s.Section, s.Addr, s.Size)
+}
+
+// Returns a human-readable description of the report.
+func (r *RomCompatReport) Text() string {
+	buf := bytes.Buffer{}
+
+	fmt.Fprintf(&buf, "ROM compatibility of %s with frozen version %s "+
+		"(%d symbols): ", r.Target, r.Version, r.Frozen)
+	if r.Compatible() {
+		fmt.Fprintf(&buf, "compatible\n")
+	} else {
+		fmt.Fprintf(&buf, "INCOMPATIBLE\n")
+	}
+
+	for _, c := range r.Removed {
+		fmt.Fprintf(&buf, "    removed: %s (was %s)\n", c.Old.Name, c.Old)
+	}
+	for _, c := range r.Moved {
+		fmt.Fprintf(&buf, "    moved:   %s (%s -> %s)\n", c.Old.Name,
+			c.Old, c.New)
+	}
+	for _, c := range r.Resized {
+		fmt.Fprintf(&buf, "    resized: %s (%d -> %d bytes)\n", c.Old.Name,
+			c.Old.Size, c.New.Size)
+	}
+	if len(r.Added) > 0 {
+		fmt.Fprintf(&buf, "    %d new symbols\n", len(r.Added))
+	}
+
+	return buf.String()
+}
+
+// Reads the symbols of the loader's ROM elf, sorted by name.
+func (b *Builder) romSymbols() ([]RomSymbol, error) {
+	err, sm := b.ParseObjectElf(b.AppLinkerElfPath())
+	if err != nil {
+		return nil, err
+	}
+
+	return romSymbolList(sm), nil
+}
+
+type romSymbolSorter struct {
+	syms []RomSymbol
+}
+
+func (s romSymbolSorter) Len() int {
+	return len(s.syms)
+}
+func (s romSymbolSorter) Swap(i, j int) {
+	s.syms[i], s.syms[j] = s.syms[j], s.syms[i]
+}
+func (s romSymbolSorter) Less(i, j int) bool {
+	return s.syms[i].Name < s.syms[j].Name
+}
+
+func romSymbolList(sm *symbol.SymbolMap) []RomSymbol {
+	syms := make([]RomSymbol, 0, len(*sm))
+	for name, si := range *sm {
+		syms = append(syms, RomSymbol{
+			Name:    name,
+			Section: si.Section,
+			Addr:    si.Loc,
+			Size:    si.Size,
+		})
+	}
+	sort.Sort(romSymbolSorter{syms})
+
+	return syms
+}
+
+// Parses a symbol entry of a frozen list: "<name> <section> <addr> <size>".
+// Names are case sensitive, so symbols are stored as strings rather than
+// mapping keys.
+func parseRomSymbol(entry string) (RomSymbol, error) {
+	fields := strings.Fields(entry)
+	if len(fields) == 4 {
+		addr, err1 := strconv.ParseInt(fields[2], 0, 64)
+		size, err2 := strconv.Atoi(fields[3])
+		if err1 == nil && err2 == nil {
+			return RomSymbol{
+				Name:    fields[0],
+				Section: fields[1],
+				Addr:    int(addr),
+				Size:    size,
+			}, nil
+		}
+	}
+
+	return RomSymbol{}, util.FmtNewtError(
+		"Invalid ROM symbol entry \"%s\"; must be "+
+			"<name> <section> <addr> <size>", entry)
+}
+
+func ReadRomSymbols(path string) (*RomSymbols, error) {
+	if util.NodeNotExist(path) {
+		return nil, util.FmtNewtError(
+			"Frozen ROM symbol list %s does not exist", path)
+	}
+
+	dir := filepath.Dir(path)
+	name := strings.TrimSuffix(filepath.Base(path), ".yml")
+	v, err := util.ReadConfig(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &RomSymbols{
+		Version: v.GetString("rom.version"),
+		Loader:  v.GetString("rom.loader"),
+		Frozen:  v.GetString("rom.frozen"),
+	}
+	for _, entry := range v.GetStringSlice("rom.symbols") {
+		sym, err := parseRomSymbol(entry)
+		if err != nil {
+			return nil, util.FmtNewtError("%s: %s", path, err.Error())
+		}
+		rs.Symbols = append(rs.Symbols, sym)
+	}
+
+	return rs, nil
+}
+
+func (rs *RomSymbols) write(path string) error {
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "### Frozen ROM symbols; generated by "+
+		"\"newt split-freeze\".\n")
+	fmt.Fprintf(&buf, "### Each symbol: <name> <section> <addr> <size>\n")
+	fmt.Fprintf(&buf, "rom.version: %s\n", yaml.EscapeString(rs.Version))
+	fmt.Fprintf(&buf, "rom.loader: %s\n", yaml.EscapeString(rs.Loader))
+	fmt.Fprintf(&buf, "rom.frozen: %s\n", yaml.EscapeString(rs.Frozen))
+	fmt.Fprintf(&buf, "rom.symbols:\n")
+	for _, s := range rs.Symbols {
+		fmt.Fprintf(&buf, "    - \"%s %s 0x%08x %d\"\n", s.Name, s.Section,
+			s.Addr, s.Size)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+func (t *TargetBuilder) requireSplit() error {
+	if t.LoaderBuilder == nil {
+		return util.FmtNewtError(
+			"Target %s is not a split image target (no target.loader)",
+			t.target.FullName())
+	}
+	return nil
+}
+
+// Records the symbols of the loader's ROM elf as the specified version of
+// the target's frozen ROM.  The target must have been built.  An existing
+// version is only replaced if overwrite is set, since apps may have been
+// built against it.  Returns the path written.
+func (t *TargetBuilder) FreezeRomSymbols(version string,
+	overwrite bool) (string, error) {
+
+	if err := t.requireSplit(); err != nil {
+		return "", err
+	}
+
+	path := t.target.RomSymbolsPath(version)
+	if util.NodeExist(path) && !overwrite {
+		return "", util.FmtNewtError(
+			"ROM version %s is already frozen (%s)", version, path)
+	}
+
+	syms, err := t.LoaderBuilder.romSymbols()
+	if err != nil {
+		return "", err
+	}
+
+	rs := &RomSymbols{
+		Version: version,
+		Loader:  t.LoaderBuilder.appPkg.rpkg.Lpkg.FullName(),
+		Frozen:  time.Now().Format(time.RFC3339),
+		Symbols: syms,
+	}
+	if err := rs.write(path); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// Compares the loader's ROM elf with the specified frozen version.  The
+// target must have been built.
+func (t *TargetBuilder) CheckRomSymbols(
+	version string) (*RomCompatReport, error) {
+
+	if err := t.requireSplit(); err != nil {
+		return nil, err
+	}
+
+	frozen, err := ReadRomSymbols(t.target.RomSymbolsPath(version))
+	if err != nil {
+		return nil, err
+	}
+
+	cur, err := t.LoaderBuilder.romSymbols()
+	if err != nil {
+		return nil, err
+	}
+
+	r := compareRomSymbols(frozen.Symbols, cur)
+	r.Target = t.target.FullName()
+	r.Version = version
+
+	return r, nil
+}
+
+func compareRomSymbols(frozen []RomSymbol, cur []RomSymbol) *RomCompatReport {
+	curMap := make(map[string]RomSymbol, len(cur))
+	for _, s := range cur {
+		curMap[s.Name] = s
+	}
+
+	r := &RomCompatReport{
+		Frozen: len(frozen),
+	}
+
+	frozenNames := map[string]bool{}
+	for _, old := range frozen {
+		frozenNames[old.Name] = true
+
+		s, ok := curMap[old.Name]
+		switch {
+		case !ok:
+			r.Removed = append(r.Removed, RomSymbolChange{Old: old})
+		case s.Addr != old.Addr || s.Section != old.Section:
+			r.Moved = append(r.Moved, RomSymbolChange{old, &s})
+		case s.Size != old.Size:
+			r.Resized = append(r.Resized, RomSymbolChange{old, &s})
+		}
+	}
+	for _, s := range cur {
+		if !frozenNames[s.Name] {
+			r.Added = append(r.Added, s)
+		}
+	}
+
+	return r
+}
+
+// Checks the loader's ROM elf against the frozen version the target
+// specifies (target.rom_version), if any.  An incompatibility is an error
+// unless RomCheckWarnOnly is set.
+func (t *TargetBuilder) checkRomVersion() error {
+	version := t.target.RomVersion()
+	if version == "" {
+		return nil
+	}
+
+	r, err := t.CheckRomSymbols(version)
+	if err != nil {
+		return err
+	}
+
+	if r.Compatible() {
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "%s", r.Text())
+		return nil
+	}
+
+	if t.RomCheckWarnOnly {
+		util.StatusMessage(util.VERBOSITY_QUIET, "* Warning: %s", r.Text())
+		return nil
+	}
+
+	return util.NewNewtError(strings.TrimSuffix(r.Text(), "\n"))
+}
@@ -0,0 +1,229 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// How the console output of a running image is watched; see LoadAndWatch()
+// and Emulate().
+type RunWatch struct {
+	// Stop at the first line that matches.  If the pattern has a capture
+	// group, the first group is the exit code the image reports (e.g.,
+	// "TEST EXIT: (\d+)").
+	Until string
+
+	// Stop after this long; zero waits until the console closes.
+	Timeout time.Duration
+}
+
+type RunResult struct {
+	// A line matched RunWatch.Until.
+	Matched bool
+
+	// RunWatch.Timeout expired.
+	TimedOut bool
+
+	// The console closed (e.g., the emulator exited).
+	Closed bool
+
+	// The exit code the image reported, through RunWatch.Until or
+	// semihosting; -1 if it didn't report one.
+	ExitCode int
+}
+
+func compileRunUntil(until string) (*regexp.Regexp, error) {
+	if until == "" {
+		return nil, nil
+	}
+
+	re, err := regexp.Compile(until)
+	if err != nil {
+		return nil, util.FmtNewtError("Invalid pattern \"%s\": %s", until,
+			err.Error())
+	}
+	return re, nil
+}
+
+// Copies console output from r to cw line by line until a line matches re,
+// the timeout expires, or r is exhausted.
+func watchConsole(r io.Reader, cw *consoleWriter, re *regexp.Regexp,
+	timeout time.Duration) (*RunResult, error) {
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		timer = time.After(timeout)
+	}
+
+	res := &RunResult{ExitCode: -1}
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				res.Closed = true
+				return res, nil
+			}
+
+			cw.emit(line + "\n")
+			if re == nil {
+				continue
+			}
+			m := re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+
+			res.Matched = true
+			if len(m) > 1 {
+				code, err := strconv.Atoi(strings.TrimSpace(m[1]))
+				if err != nil {
+					return nil, util.FmtNewtError("Invalid exit code "+
+						"\"%s\" in console line: %s", m[1], line)
+				}
+				res.ExitCode = code
+			}
+			return res, nil
+
+		case <-timer:
+			res.TimedOut = true
+			return res, nil
+		}
+	}
+}
+
+// Converts a watch that ended without the expected line into an error.
+func runWatchError(watch RunWatch, res *RunResult) error {
+	if watch.Until == "" || res.Matched {
+		return nil
+	}
+	if res.TimedOut {
+		return util.FmtNewtError("No console line matched \"%s\" within %s",
+			watch.Until, watch.Timeout)
+	}
+	return util.FmtNewtError("Console closed before a line matched \"%s\"",
+		watch.Until)
+}
+
+// Loads the target's images onto the device, which resets it, and copies
+// the device's console to stdout as specified by watch.  A UART console is
+// opened before loading so that no output is missed; RTT is attached once
+// the probe is free, and earlier output waits in the device's RTT buffer.
+func (t *TargetBuilder) LoadAndWatch(extraJtagCmd string,
+	console ConsoleOptions, watch RunWatch) (*RunResult, error) {
+
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	re, err := compileRunUntil(watch.Until)
+	if err != nil {
+		return nil, err
+	}
+
+	cw, closeLog, err := t.newConsoleWriter(console)
+	if err != nil {
+		return nil, err
+	}
+	defer closeLog()
+
+	var r io.Reader
+	switch source := t.consoleSource(console); source {
+	case CONSOLE_SOURCE_UART:
+		port, err := t.consolePort(console.Port)
+		if err != nil {
+			return nil, err
+		}
+		baud := t.consoleBaud(console)
+		f, err := openConsoleUart(port, baud)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		if err := t.Load(extraJtagCmd); err != nil {
+			return nil, err
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Console on %s at %d baud\n", port, baud)
+		r = f
+
+	case CONSOLE_SOURCE_RTT:
+		if err := t.Load(extraJtagCmd); err != nil {
+			return nil, err
+		}
+
+		cmdStrs, err := t.rttCmd()
+		if err != nil {
+			return nil, err
+		}
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "%s\n",
+			strings.Join(cmdStrs, " "))
+
+		cmd := exec.Command(cmdStrs[0], cmdStrs[1:]...)
+		cmd.Stderr = os.Stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+		defer func() {
+			util.KillProcessTree(cmd.Process)
+			cmd.Wait()
+		}()
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"RTT console via %s\n", cmdStrs[0])
+		r = stdout
+
+	default:
+		return nil, util.FmtNewtError("Invalid console source \"%s\"; "+
+			"must be %s or %s", source, CONSOLE_SOURCE_UART,
+			CONSOLE_SOURCE_RTT)
+	}
+
+	res, err := watchConsole(r, cw, re, watch.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	cw.flush()
+
+	return res, runWatchError(watch, res)
+}
@@ -0,0 +1,131 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"os"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+const SANITIZER_ADDRESS = "address"
+const SANITIZER_UNDEFINED = "undefined"
+const SANITIZER_THREAD = "thread"
+
+// Only simulator builds run on the host, so only they can be sanitized.
+const SANITIZER_ARCH = "sim"
+
+// Runtime options applied to each sanitizer unless the user's environment
+// already specifies them.  Errors are made fatal so that a sanitizer report
+// always fails the test.
+var sanitizerEnvDefaults = map[string][2]string{
+	SANITIZER_ADDRESS: {"ASAN_OPTIONS",
+		"halt_on_error=1:detect_stack_use_after_return=0"},
+	SANITIZER_UNDEFINED: {"UBSAN_OPTIONS",
+		"halt_on_error=1:print_stacktrace=1"},
+	SANITIZER_THREAD: {"TSAN_OPTIONS", "halt_on_error=1"},
+}
+
+func containsString(slice []string, s string) bool {
+	for _, e := range slice {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func validateSanitizers(names []string, arch string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	if arch != SANITIZER_ARCH {
+		return util.FmtNewtError(
+			"Sanitizers are only supported by %s BSPs (bsp arch=%s)",
+			SANITIZER_ARCH, arch)
+	}
+
+	set := map[string]bool{}
+	for _, name := range names {
+		if _, ok := sanitizerEnvDefaults[name]; !ok {
+			return util.FmtNewtError("Unknown sanitizer: \"%s\"; must be "+
+				"one of: %s, %s, %s", name, SANITIZER_ADDRESS,
+				SANITIZER_UNDEFINED, SANITIZER_THREAD)
+		}
+		set[name] = true
+	}
+
+	if set[SANITIZER_THREAD] && set[SANITIZER_ADDRESS] {
+		return util.FmtNewtError("The %s and %s sanitizers cannot be "+
+			"combined", SANITIZER_ADDRESS, SANITIZER_THREAD)
+	}
+
+	return nil
+}
+
+// Adds the specified sanitizers to the ones configured by the target.
+func (t *TargetBuilder) EnableSanitizers(names []string) error {
+	all := t.sanitizers
+	for _, name := range names {
+		if !containsString(all, name) {
+			all = append(all, name)
+		}
+	}
+
+	if err := validateSanitizers(all, t.bspPkg.Arch); err != nil {
+		return err
+	}
+
+	t.sanitizers = all
+	return nil
+}
+
+func (t *TargetBuilder) Sanitizers() []string {
+	return t.sanitizers
+}
+
+func sanitizerCompilerInfo(names []string) *toolchain.CompilerInfo {
+	flag := "-fsanitize=" + strings.Join(names, ",")
+
+	ci := toolchain.NewCompilerInfo()
+	ci.Cflags = []string{flag, "-fno-omit-frame-pointer"}
+	if containsString(names, SANITIZER_UNDEFINED) {
+		ci.Cflags = append(ci.Cflags, "-fno-sanitize-recover=undefined")
+	}
+	ci.Lflags = []string{flag}
+
+	return ci
+}
+
+// Returns the environment variables that configure the runtime of the
+// specified sanitizers.
+func SanitizerEnv(names []string) []string {
+	env := []string{}
+	for _, name := range names {
+		kv := sanitizerEnvDefaults[name]
+		if os.Getenv(kv[0]) == "" {
+			env = append(env, kv[0]+"="+kv[1])
+		}
+	}
+
+	return env
+}
@@ -0,0 +1,366 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/flash"
+	"mynewt.apache.org/newt/newt/image"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+const (
+	SECURE_SEV_ERROR   = "error"
+	SECURE_SEV_WARNING = "warning"
+)
+
+// A problem found by SecureCheck().  Check names the part of the boot chain
+// the problem concerns (e.g., "signature", "flash-map").
+type SecureFinding struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Message  string `json:"message"`
+}
+
+type SecureReport struct {
+	Target     string          `json:"target"`
+	BootTarget string          `json:"boot_target"`
+	Findings   []SecureFinding `json:"findings"`
+}
+
+// Bootloader settings that enable signature verification, and the signature
+// algorithm each one accepts.  RSA key length is read from
+// BOOTUTIL_SIGN_RSA_LEN.
+var bootSigSettings = []struct {
+	setting string
+	alg     string
+}{
+	{"BOOTUTIL_SIGN_RSA", "RSA"},
+	{"BOOTUTIL_SIGN_EC", "ECDSA-P224"},
+	{"BOOTUTIL_SIGN_EC256", "ECDSA-P256"},
+	{"BOOTUTIL_SIGN_ED25519", "ED25519"},
+}
+
+// Image slot areas that the bootloader and the app must agree on.
+var bootFlashAreas = []string{
+	flash.FLASH_AREA_NAME_BOOTLOADER,
+	flash.FLASH_AREA_NAME_IMAGE_0,
+	flash.FLASH_AREA_NAME_IMAGE_1,
+	flash.FLASH_AREA_NAME_IMAGE_SCRATCH,
+}
+
+func (r *SecureReport) add(sev string, check string, format string,
+	args ...interface{}) {
+
+	r.Findings = append(r.Findings, SecureFinding{
+		Severity: sev,
+		Check:    check,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *SecureReport) NumErrors() int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.Severity == SECURE_SEV_ERROR {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *SecureReport) Text() string {
+	lines := []string{}
+	for _, f := range r.Findings {
+		sev := "Error"
+		if f.Severity == SECURE_SEV_WARNING {
+			sev = "Warning"
+		}
+		lines = append(lines,
+			fmt.Sprintf("* %s: [%s] %s", sev, f.Check, f.Message))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Looks for the bootloader that boots the specified app target: the only
+// other target with the same BSP whose app is a boot package (e.g.,
+// "@mcuboot/boot/mynewt" or "@apache-mynewt-core/apps/boot").
+func FindBootTarget(app *target.Target) (*target.Target, error) {
+	names := []string{}
+	for name, t := range target.GetTargets() {
+		if t == app || t.BspName != app.BspName ||
+			strings.HasSuffix(name, "/unittest") {
+
+			continue
+		}
+
+		if strings.Contains(filepath.Base(t.AppName), "boot") ||
+			strings.Contains(t.AppName, "/boot/") {
+
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	switch len(names) {
+	case 0:
+		return nil, util.FmtNewtError(
+			"No bootloader target uses BSP %s; specify one with --boot",
+			app.BspName)
+	case 1:
+		return target.GetTargets()[names[0]], nil
+	default:
+		return nil, util.FmtNewtError(
+			"Several bootloader targets use BSP %s (%s); specify one "+
+				"with --boot", app.BspName, strings.Join(names, ", "))
+	}
+}
+
+func (t *TargetBuilder) settingDefined(name string) bool {
+	_, ok := t.res.Cfg.Settings[name]
+	return ok
+}
+
+// Returns the signature algorithms the bootloader accepts.
+func (t *TargetBuilder) bootSigAlgs() []string {
+	algs := []string{}
+	for _, s := range bootSigSettings {
+		if !t.settingEnabled(s.setting) {
+			continue
+		}
+
+		alg := s.alg
+		if alg == "RSA" {
+			bits := "2048"
+			if entry, ok := t.res.Cfg.Settings["BOOTUTIL_SIGN_RSA_LEN"]; ok &&
+				entry.Value != "" {
+
+				bits = entry.Value
+			}
+			alg += "-" + bits
+		}
+		algs = append(algs, alg)
+	}
+
+	return algs
+}
+
+func (r *SecureReport) checkSignature(boot *TargetBuilder,
+	img *image.Image) {
+
+	algs := boot.bootSigAlgs()
+	imgAlg := img.SigAlgName()
+
+	switch {
+	case len(algs) == 0 && imgAlg != "":
+		r.add(SECURE_SEV_WARNING, "signature",
+			"image is signed (%s), but the bootloader does not verify "+
+				"signatures", imgAlg)
+
+	case len(algs) > 1:
+		r.add(SECURE_SEV_ERROR, "signature",
+			"bootloader enables several signature types (%s); only one "+
+				"may be enabled", strings.Join(algs, ", "))
+
+	case len(algs) == 1 && imgAlg == "":
+		r.add(SECURE_SEV_ERROR, "signature",
+			"image is unsigned, but the bootloader requires %s signatures",
+			algs[0])
+
+	case len(algs) == 1 && imgAlg != algs[0]:
+		r.add(SECURE_SEV_ERROR, "signature",
+			"image is signed with %s, but the bootloader only verifies %s "+
+				"signatures", imgAlg, algs[0])
+	}
+
+	if len(algs) > 0 {
+		for _, name := range []string{
+			"BOOTUTIL_VALIDATE_SLOT0", "BOOTUTIL_VALIDATE_PRIMARY_SLOT"} {
+
+			if boot.settingDefined(name) && !boot.settingEnabled(name) {
+				r.add(SECURE_SEV_WARNING, "signature",
+					"%s is disabled; the bootloader does not verify the "+
+						"primary slot before booting it", name)
+			}
+		}
+	}
+
+	for _, name := range []string{
+		"BOOTUTIL_ENCRYPT_RSA", "BOOTUTIL_ENCRYPT_KW",
+		"BOOTUTIL_ENCRYPT_EC256"} {
+
+		if boot.settingEnabled(name) {
+			r.add(SECURE_SEV_ERROR, "encryption",
+				"bootloader expects encrypted images (%s), which newt does "+
+					"not produce", name)
+		}
+	}
+}
+
+func (r *SecureReport) checkFlashMap(app *TargetBuilder,
+	boot *TargetBuilder) {
+
+	appMap := app.bspPkg.FlashMap
+	bootMap := boot.bspPkg.FlashMap
+
+	for _, name := range bootFlashAreas {
+		appArea, appOk := appMap.Areas[name]
+		bootArea, bootOk := bootMap.Areas[name]
+
+		switch {
+		case !appOk && !bootOk:
+		case appOk != bootOk:
+			which := "app"
+			if appOk {
+				which = "bootloader"
+			}
+			r.add(SECURE_SEV_ERROR, "flash-map",
+				"%s is not defined in the %s target's flash map", name, which)
+		case appArea.Device != bootArea.Device ||
+			appArea.Offset != bootArea.Offset ||
+			appArea.Size != bootArea.Size:
+
+			r.add(SECURE_SEV_ERROR, "flash-map",
+				"%s differs: app has device %d offset 0x%x size %d; "+
+					"bootloader has device %d offset 0x%x size %d",
+				name, appArea.Device, appArea.Offset, appArea.Size,
+				bootArea.Device, bootArea.Offset, bootArea.Size)
+		}
+	}
+
+	if !boot.settingEnabled("BOOT_LOADER") {
+		r.add(SECURE_SEV_WARNING, "bootloader",
+			"boot target does not enable BOOT_LOADER")
+	}
+	if app.settingEnabled("BOOT_LOADER") {
+		r.add(SECURE_SEV_ERROR, "bootloader",
+			"app target enables BOOT_LOADER")
+	}
+}
+
+func (r *SecureReport) checkSlots(app *TargetBuilder, boot *TargetBuilder,
+	img *image.Image) {
+
+	areas := boot.bspPkg.FlashMap.Areas
+	slot0, ok0 := areas[flash.FLASH_AREA_NAME_IMAGE_0]
+	slot1, ok1 := areas[flash.FLASH_AREA_NAME_IMAGE_1]
+	if !ok0 || !ok1 {
+		r.add(SECURE_SEV_ERROR, "slots",
+			"flash map must define %s and %s", flash.FLASH_AREA_NAME_IMAGE_0,
+			flash.FLASH_AREA_NAME_IMAGE_1)
+		return
+	}
+
+	overwrite := boot.settingEnabled("BOOTUTIL_OVERWRITE_ONLY")
+	move := boot.settingEnabled("BOOTUTIL_SWAP_USING_MOVE")
+	if !overwrite {
+		if slot0.Size != slot1.Size {
+			r.add(SECURE_SEV_ERROR, "slots",
+				"swap upgrades require equal image slots; %s is %d bytes, "+
+					"%s is %d bytes", slot0.Name, slot0.Size, slot1.Name,
+				slot1.Size)
+		}
+
+		_, ok := areas[flash.FLASH_AREA_NAME_IMAGE_SCRATCH]
+		if !ok && !move {
+			r.add(SECURE_SEV_ERROR, "slots",
+				"swap upgrades require a %s area (or "+
+					"BOOTUTIL_SWAP_USING_MOVE)",
+				flash.FLASH_AREA_NAME_IMAGE_SCRATCH)
+		}
+	}
+
+	// The image must leave room for the boot trailer in whichever slot it
+	// occupies.
+	binPath := app.AppBuilder.AppBinPath()
+	info, err := os.Stat(binPath)
+	if err != nil {
+		r.add(SECURE_SEV_WARNING, "image-size",
+			"cannot determine image size: %s", err.Error())
+		return
+	}
+
+	imgSz := int(info.Size()) + img.Overhead()
+	trailerSz := boot.bootTrailerSize()
+	for _, slot := range []flash.FlashArea{slot0, slot1} {
+		if max := slot.Size - trailerSz; imgSz > max {
+			r.add(SECURE_SEV_ERROR, "image-size",
+				"padded image (%d bytes) does not fit in %s; %d bytes "+
+					"available after the %d-byte boot trailer",
+				imgSz, slot.Name, max, trailerSz)
+		}
+	}
+}
+
+// Cross-checks an app target against the bootloader target that boots it:
+// the signature algorithm of the image signed with the specified key ("" for
+// an unsigned image), the image slot layout and swap mode, and the size of
+// the image.  The app is built so that its size is known.
+func SecureCheck(app *TargetBuilder, boot *TargetBuilder,
+	keyFile string) (*SecureReport, error) {
+
+	if app.target.LoaderName != "" {
+		return nil, util.NewNewtError(
+			"Secure boot checks are not supported for split images")
+	}
+
+	layout, err := app.ImageLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	// Padding doesn't count against the slot; the boot trailer occupies the
+	// end of a padded image.
+	img := &image.Image{
+		HeaderSize:    layout.HeaderSize,
+		UseSecCounter: layout.SecCounter != "",
+	}
+	if keyFile != "" {
+		if err := img.SetSigningKey(keyFile, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := boot.Resolve(); err != nil {
+		return nil, err
+	}
+	if err := app.Build(); err != nil {
+		return nil, err
+	}
+
+	r := &SecureReport{
+		Target:     app.target.FullName(),
+		BootTarget: boot.target.FullName(),
+		Findings:   []SecureFinding{},
+	}
+
+	r.checkSignature(boot, img)
+	r.checkFlashMap(app, boot)
+	r.checkSlots(app, boot, img)
+
+	return r, nil
+}
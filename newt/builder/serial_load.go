@@ -0,0 +1,252 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/image"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+const LOAD_METHOD_JTAG = "jtag"
+const LOAD_METHOD_SERIAL = "serial"
+const LOAD_METHOD_MGMT = "mgmt"
+
+const SERIAL_PROTO_MCUMGR = "mcumgr"
+const SERIAL_PROTO_NRF_DFU = "nrf-dfu"
+const SERIAL_PROTO_STM32 = "stm32"
+
+// A port with this prefix names a BLE peer rather than a serial device
+// (mcumgr only).
+const SERIAL_PORT_BLE_PREFIX = "ble:"
+
+// Where and how to program a device through its serial bootloader.
+type SerialLoadOptions struct {
+	// Serial device (e.g., /dev/ttyUSB0), or ble:<peer-name> for mcumgr.
+	Port string
+
+	// Baud rate; 0 means the BSP's or protocol's default.
+	Baud int
+}
+
+// Selects programming through the BSP's serial bootloader (bsp.serial_load)
+// for load operations.  nil selects the debug probe or the BSP's scripts.
+func (t *TargetBuilder) SetSerialLoad(opts *SerialLoadOptions) {
+	t.serialLoad = opts
+}
+
+func serialLoadDefaults(proto string) (string, int) {
+	switch proto {
+	case SERIAL_PROTO_MCUMGR:
+		return "mcumgr", 115200
+	case SERIAL_PROTO_NRF_DFU:
+		return "nrfutil", 115200
+	case SERIAL_PROTO_STM32:
+		return "stm32flash", 57600
+	default:
+		return "", 0
+	}
+}
+
+// Returns the mcumgr arguments that connect to the specified serial device,
+// or BLE peer if the port has the ble: prefix.
+func mcumgrConnArgs(port string, baud int) []string {
+	if strings.HasPrefix(port, SERIAL_PORT_BLE_PREFIX) {
+		return []string{"--conntype", "ble", "--connstring",
+			"peer_name=" + strings.TrimPrefix(port, SERIAL_PORT_BLE_PREFIX)}
+	}
+
+	return []string{"--conntype", "serial", "--connstring",
+		"dev=" + port + ",baud=" + strconv.Itoa(baud)}
+}
+
+// Returns an mcumgr command line that runs the specified mcumgr command on
+// the device at opts.Port.  The mcumgr binary, baud rate and extra arguments
+// come from the BSP's bsp.serial_load settings when it uses mcumgr.
+func (t *TargetBuilder) mcumgrCmd(opts SerialLoadOptions,
+	args ...string) []string {
+
+	mcumgr, baud := serialLoadDefaults(SERIAL_PROTO_MCUMGR)
+	extra := []string{}
+	if sl := t.bspPkg.SerialLoad; sl != nil &&
+		sl.Protocol == SERIAL_PROTO_MCUMGR {
+
+		if sl.Binary != "" {
+			mcumgr = sl.Binary
+		}
+		if sl.Baud != 0 {
+			baud = sl.Baud
+		}
+		extra = sl.Args
+	}
+	if opts.Baud != 0 {
+		baud = opts.Baud
+	}
+
+	cmd := append([]string{mcumgr}, mcumgrConnArgs(opts.Port, baud)...)
+	cmd = append(cmd, extra...)
+	return append(cmd, args...)
+}
+
+// Reads the image hash from the manifest written alongside an image.
+func manifestImageHash(manifestPath string) (string, error) {
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return "", util.FmtNewtError("Can't read image manifest: %s; "+
+			"run create-image first", err.Error())
+	}
+
+	manifest := image.ImageManifest{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", util.FmtNewtError("Failure decoding manifest %s: %s",
+			manifestPath, err.Error())
+	}
+	if manifest.ImageHash == "" {
+		return "", util.FmtNewtError("Manifest %s contains no image hash",
+			manifestPath)
+	}
+
+	return manifest.ImageHash, nil
+}
+
+// Returns the commands that program the specified file through the BSP's
+// serial bootloader.  offset is the flash address the file belongs at; it is
+// used by protocols that write raw flash.
+func (b *Builder) serialLoadCmds(sl *pkg.BspSerialLoad,
+	opts *SerialLoadOptions, binPath string, offset int,
+	bootLoader bool) ([][]string, error) {
+
+	binary, baud := serialLoadDefaults(sl.Protocol)
+	if binary == "" {
+		return nil, util.FmtNewtError("Unknown serial load protocol "+
+			"\"%s\"; must be %s, %s, or %s", sl.Protocol,
+			SERIAL_PROTO_MCUMGR, SERIAL_PROTO_NRF_DFU, SERIAL_PROTO_STM32)
+	}
+	if sl.Binary != "" {
+		binary = sl.Binary
+	}
+	if sl.Baud != 0 {
+		baud = sl.Baud
+	}
+	if opts.Baud != 0 {
+		baud = opts.Baud
+	}
+
+	isBle := strings.HasPrefix(opts.Port, SERIAL_PORT_BLE_PREFIX)
+	if isBle && sl.Protocol != SERIAL_PROTO_MCUMGR {
+		return nil, util.FmtNewtError("Serial load protocol %s does not "+
+			"support BLE", sl.Protocol)
+	}
+
+	addr := "0x" + strconv.FormatInt(int64(offset), 16)
+
+	switch sl.Protocol {
+	case SERIAL_PROTO_MCUMGR:
+		// The running image receives the upload into its secondary slot;
+		// marking it pending makes the bootloader swap it in on reset.
+		if bootLoader {
+			return nil, util.NewNewtError(
+				"The bootloader cannot be loaded over mcumgr")
+		}
+		hash, err := manifestImageHash(b.ManifestPath())
+		if err != nil {
+			return nil, err
+		}
+
+		conn := append(mcumgrConnArgs(opts.Port, baud), sl.Args...)
+
+		mcumgr := func(args ...string) []string {
+			cmd := append([]string{binary}, conn...)
+			return append(cmd, args...)
+		}
+		return [][]string{
+			mcumgr("image", "upload", binPath),
+			mcumgr("image", "test", hash),
+			mcumgr("reset"),
+		}, nil
+
+	case SERIAL_PROTO_NRF_DFU:
+		hwVersion := sl.HwVersion
+		if hwVersion == "" {
+			hwVersion = "52"
+		}
+		sdReq := sl.SdReq
+		if sdReq == "" {
+			sdReq = "0x00"
+		}
+
+		zipPath := strings.TrimSuffix(binPath, filepath.Ext(binPath)) +
+			"-dfu.zip"
+		gen := []string{binary, "pkg", "generate",
+			"--hw-version", hwVersion, "--sd-req", sdReq,
+			"--application-version", "0", "--application", binPath,
+			zipPath}
+		dfu := []string{binary, "dfu", "serial", "-pkg", zipPath,
+			"-p", opts.Port, "-b", strconv.Itoa(baud)}
+		return [][]string{gen, append(dfu, sl.Args...)}, nil
+
+	default:
+		cmd := []string{binary, "-b", strconv.Itoa(baud), "-w", binPath,
+			"-v", "-S", addr, "-R"}
+		cmd = append(cmd, sl.Args...)
+		return [][]string{append(cmd, opts.Port)}, nil
+	}
+}
+
+// Programs the specified file through the BSP's serial bootloader.
+func (b *Builder) serialLoad(opts *SerialLoadOptions, binPath string,
+	offset int, bootLoader bool) error {
+
+	sl := b.targetBuilder.bspPkg.SerialLoad
+	if sl == nil {
+		return util.FmtNewtError("BSP %s does not support serial loading "+
+			"(bsp.serial_load)", b.targetBuilder.bspPkg.FullName())
+	}
+	if opts.Port == "" {
+		return util.NewNewtError("Serial loading requires a port")
+	}
+	if util.NodeNotExist(binPath) {
+		return util.FmtNewtError("No image to load: %s", binPath)
+	}
+
+	cmds, err := b.serialLoadCmds(sl, opts, binPath, offset, bootLoader)
+	if err != nil {
+		return err
+	}
+
+	for _, cmd := range cmds {
+		if err := lookPathCmd(sl.Protocol, cmd); err != nil {
+			return err
+		}
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "%s\n",
+			strings.Join(cmd, " "))
+		if _, err := util.ShellCommand(cmd, nil); err != nil {
+			return err
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "Successfully loaded image.\n")
+	return nil
+}
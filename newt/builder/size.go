@@ -44,6 +44,12 @@ type MemSectionArray []*MemSection
 
 var globalMemSections map[string]*MemSection
 
+/*
+ * Memory region each output section was placed in, indexed by output section
+ * name.
+ */
+var globalSecRegions map[string]string
+
 func (array MemSectionArray) Len() int {
 	return len(array)
 }
@@ -77,9 +83,10 @@ func (m *MemSection) PartOf(addr uint64) bool {
  * Info about specific symbol size
  */
 type SymbolData struct {
-	Name    string
-	ObjName string            /* Which object file it came from */
-	Sizes   map[string]uint32 /* Sizes indexed by mem section name */
+	Name     string
+	ObjName  string            /* Which object file it came from */
+	Sizes    map[string]uint32 /* Sizes indexed by mem section name */
+	SecSizes map[string]uint32 /* Sizes indexed by output section name */
 }
 
 type SymbolDataArray []*SymbolData
@@ -88,9 +95,10 @@ type SymbolDataArray []*SymbolData
  * We accumulate the size of libraries to elements in this.
  */
 type PkgSize struct {
-	Name  string
-	Sizes map[string]uint32      /* Sizes indexed by mem section name */
-	Syms  map[string]*SymbolData /* Symbols indexed by symbol name */
+	Name     string
+	Sizes    map[string]uint32      /* Sizes indexed by mem section name */
+	SecSizes map[string]uint32      /* Sizes indexed by output section name */
+	Syms     map[string]*SymbolData /* Symbols indexed by symbol name */
 }
 
 type PkgSizeArray []*PkgSize
@@ -128,6 +136,7 @@ func MakeSymbolData(name string, objName string) *SymbolData {
 	for _, sec := range globalMemSections {
 		sym.Sizes[sec.Name] = 0
 	}
+	sym.SecSizes = make(map[string]uint32)
 	return sym
 }
 
@@ -139,11 +148,14 @@ func MakePkgSize(name string) *PkgSize {
 	for _, sec := range globalMemSections {
 		pkgSize.Sizes[sec.Name] = 0
 	}
+	pkgSize.SecSizes = make(map[string]uint32)
 	pkgSize.Syms = make(map[string]*SymbolData)
 	return pkgSize
 }
 
-func (ps *PkgSize) addSymSize(symName string, objName string, size uint32, addr uint64) {
+func (ps *PkgSize) addSymSize(symName string, objName string, size uint32,
+	addr uint64, outSection string) {
+
 	for _, section := range globalMemSections {
 		if section.PartOf(addr) {
 			name := section.Name
@@ -156,6 +168,9 @@ func (ps *PkgSize) addSymSize(symName string, objName string, size uint32, addr
 				}
 				ps.Sizes[name] += size32
 				sym.Sizes[name] += size32
+				ps.SecSizes[outSection] += size32
+				sym.SecSizes[outSection] += size32
+				globalSecRegions[outSection] = name
 			}
 			break
 		}
@@ -174,8 +189,10 @@ func ParseMapFileSizes(fileName string) (map[string]*PkgSize, error) {
 	}
 
 	var symName string = ""
+	var outSection string = ""
 
 	globalMemSections = make(map[string]*MemSection)
+	globalSecRegions = make(map[string]string)
 	pkgSizes := make(map[string]*PkgSize)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
@@ -224,6 +241,17 @@ func ParseMapFileSizes(fileName string) (map[string]*PkgSize, error) {
 			}
 
 			array := strings.Fields(scanner.Text())
+
+			/*
+			 * Output section names start in the first column, e.g.
+			 * .text           0x0000000008000000     0xb7d4
+			 */
+			line := scanner.Text()
+			if len(array) > 0 && len(line) > 0 && line[0] == '.' {
+				outSection = array[0]
+				continue
+			}
+
 			switch len(array) {
 			case 1:
 				/*
@@ -328,7 +356,8 @@ func ParseMapFileSizes(fileName string) (map[string]*PkgSize, error) {
 				pkgSize = MakePkgSize(srcLib)
 				pkgSizes[srcLib] = pkgSize
 			}
-			pkgSize.addSymSize(symName, objName, uint32(size), addr)
+			pkgSize.addSymSize(symName, objName, uint32(size), addr,
+				outSection)
 			symName = ".unknown"
 		default:
 		}
@@ -342,65 +371,269 @@ func ParseMapFileSizes(fileName string) (map[string]*PkgSize, error) {
 	return pkgSizes, nil
 }
 
+func (t *TargetBuilder) builders() []*Builder {
+	builders := []*Builder{t.AppBuilder}
+	if t.LoaderBuilder != nil {
+		builders = append(builders, t.LoaderBuilder)
+	}
+
+	return builders
+}
+
 /*
- * Return a printable string containing size data for the libraries
+ * Options controlling the size breakdown of an image.
  */
-func PrintSizes(libs map[string]*PkgSize) error {
-	/*
-	 * Order sections by offset, and display lib sizes in that order.
-	 */
-	memSections := make(MemSectionArray, len(globalMemSections))
-	var i int = 0
-	for _, sec := range globalMemSections {
-		memSections[i] = sec
-		i++
+type SizeOptions struct {
+	// Only count symbols placed in these output sections (e.g., ".bss");
+	// empty means all sections.
+	Sections []string
+
+	// Number of largest symbols to report; 0 means none.
+	Symbols int
+}
+
+/*
+ * Machine-readable size data for a single image.
+ */
+type PkgSizeSummary struct {
+	Name         string            `json:"name"`
+	Sizes        map[string]uint32 `json:"sizes"`
+	SectionSizes map[string]uint32 `json:"section_sizes"`
+}
+
+type SymbolSizeSummary struct {
+	Name    string `json:"name"`
+	Package string `json:"package"`
+	Object  string `json:"object"`
+	Section string `json:"section"`
+	Region  string `json:"region"`
+	Size    uint32 `json:"size"`
+}
+
+type SectionSizeSummary struct {
+	Name   string `json:"name"`
+	Region string `json:"region"`
+	Size   uint32 `json:"size"`
+}
+
+type ImageSizeSummary struct {
+	Name string `json:"name"`
+
+	// Memory regions (e.g., FLASH, RAM) in address order.
+	Sections []string `json:"sections"`
+
+	// Output sections, largest first.
+	OutputSections []SectionSizeSummary `json:"output_sections"`
+
+	// Total size of each memory region.
+	Totals map[string]uint32 `json:"totals"`
+
+	// Packages, largest first.
+	Packages []PkgSizeSummary `json:"packages"`
+
+	// The largest symbols, if requested.
+	Symbols []SymbolSizeSummary `json:"symbols,omitempty"`
+}
+
+func (t *TargetBuilder) SizeSummaries(
+	opts SizeOptions) ([]ImageSizeSummary, error) {
+
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
 	}
-	sort.Sort(memSections)
 
-	/*
-	 * Order libraries by name, and display them in that order.
-	 */
-	pkgSizes := make(PkgSizeArray, len(libs))
-	i = 0
-	for _, es := range libs {
-		pkgSizes[i] = es
-		i++
+	summaries := []ImageSizeSummary{}
+	for _, b := range t.builders() {
+		summary, err := b.SizeSummary(opts)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
 	}
-	sort.Sort(pkgSizes)
 
-	for _, sec := range memSections {
-		fmt.Printf("%7s ", sec.Name)
+	return summaries, nil
+}
+
+func sumSizes(sizes map[string]uint32) uint32 {
+	total := uint32(0)
+	for _, sz := range sizes {
+		total += sz
 	}
-	fmt.Printf("\n")
-	for _, es := range pkgSizes {
-		for i := 0; i < len(memSections); i++ {
-			fmt.Printf("%7d ", es.Sizes[memSections[i].Name])
-		}
-		fmt.Printf("%s\n", filepath.Base(es.Name))
+	return total
+}
+
+type pkgSizeSorter struct {
+	pkgs []PkgSizeSummary
+}
+
+func (s pkgSizeSorter) Len() int {
+	return len(s.pkgs)
+}
+func (s pkgSizeSorter) Swap(i, j int) {
+	s.pkgs[i], s.pkgs[j] = s.pkgs[j], s.pkgs[i]
+}
+func (s pkgSizeSorter) Less(i, j int) bool {
+	a := sumSizes(s.pkgs[i].Sizes)
+	b := sumSizes(s.pkgs[j].Sizes)
+	if a != b {
+		return a > b
 	}
+	return s.pkgs[i].Name < s.pkgs[j].Name
+}
 
-	return nil
+type sectionSizeSorter struct {
+	sections []SectionSizeSummary
 }
 
-func (t *TargetBuilder) Size() error {
+func (s sectionSizeSorter) Len() int {
+	return len(s.sections)
+}
+func (s sectionSizeSorter) Swap(i, j int) {
+	s.sections[i], s.sections[j] = s.sections[j], s.sections[i]
+}
+func (s sectionSizeSorter) Less(i, j int) bool {
+	a, b := s.sections[i], s.sections[j]
+	if a.Size != b.Size {
+		return a.Size > b.Size
+	}
+	return a.Name < b.Name
+}
 
-	err := t.PrepBuild()
+type symbolSizeSorter struct {
+	syms []SymbolSizeSummary
+}
 
+func (s symbolSizeSorter) Len() int {
+	return len(s.syms)
+}
+func (s symbolSizeSorter) Swap(i, j int) {
+	s.syms[i], s.syms[j] = s.syms[j], s.syms[i]
+}
+func (s symbolSizeSorter) Less(i, j int) bool {
+	a, b := s.syms[i], s.syms[j]
+	if a.Size != b.Size {
+		return a.Size > b.Size
+	}
+	return a.Name < b.Name
+}
+
+/*
+ * Attributes the image's flash and RAM usage to packages, via the archive
+ * each linked object came from, and to output sections.
+ */
+func (b *Builder) SizeSummary(opts SizeOptions) (ImageSizeSummary, error) {
+	summary := ImageSizeSummary{Name: b.buildName}
+
+	if err := b.checkSizeable(); err != nil {
+		return summary, err
+	}
+
+	libs, err := ParseMapFileSizes(b.AppElfPath() + ".map")
 	if err != nil {
-		return err
+		return summary, err
 	}
 
-	fmt.Printf("Size of Application Image: %s\n", t.AppBuilder.buildName)
-	err = t.AppBuilder.Size()
+	memSections := make(MemSectionArray, 0, len(globalMemSections))
+	for _, sec := range globalMemSections {
+		memSections = append(memSections, sec)
+	}
+	sort.Sort(memSections)
 
-	if err == nil {
-		if t.LoaderBuilder != nil {
-			fmt.Printf("Size of Loader Image: %s\n", t.LoaderBuilder.buildName)
-			err = t.LoaderBuilder.Size()
+	summary.Sections = make([]string, len(memSections))
+	for i, sec := range memSections {
+		summary.Sections[i] = sec.Name
+	}
+
+	included := func(section string) bool {
+		if len(opts.Sections) == 0 {
+			return true
 		}
+		for _, s := range opts.Sections {
+			if s == section {
+				return true
+			}
+		}
+		return false
 	}
 
-	return err
+	summary.Totals = map[string]uint32{}
+	for _, name := range summary.Sections {
+		summary.Totals[name] = 0
+	}
+
+	secSizes := map[string]uint32{}
+	for _, es := range libs {
+		pkgName := b.FindPkgNameByArName(es.Name)
+
+		ps := PkgSizeSummary{
+			Name:         pkgName,
+			Sizes:        map[string]uint32{},
+			SectionSizes: map[string]uint32{},
+		}
+		for _, name := range summary.Sections {
+			ps.Sizes[name] = 0
+		}
+
+		for _, sym := range es.Syms {
+			for sec, sz := range sym.SecSizes {
+				if !included(sec) || sz == 0 {
+					continue
+				}
+
+				region := globalSecRegions[sec]
+				ps.Sizes[region] += sz
+				ps.SectionSizes[sec] += sz
+				summary.Totals[region] += sz
+				secSizes[sec] += sz
+
+				if opts.Symbols > 0 {
+					summary.Symbols = append(summary.Symbols,
+						SymbolSizeSummary{
+							Name:    sym.Name,
+							Package: pkgName,
+							Object:  sym.ObjName,
+							Section: sec,
+							Region:  region,
+							Size:    sz,
+						})
+				}
+			}
+		}
+
+		if len(ps.SectionSizes) > 0 {
+			summary.Packages = append(summary.Packages, ps)
+		}
+	}
+
+	sort.Sort(pkgSizeSorter{summary.Packages})
+
+	for sec, sz := range secSizes {
+		summary.OutputSections = append(summary.OutputSections,
+			SectionSizeSummary{
+				Name:   sec,
+				Region: globalSecRegions[sec],
+				Size:   sz,
+			})
+	}
+	sort.Sort(sectionSizeSorter{summary.OutputSections})
+
+	sort.Sort(symbolSizeSorter{summary.Symbols})
+	if len(summary.Symbols) > opts.Symbols {
+		summary.Symbols = summary.Symbols[:opts.Symbols]
+	}
+
+	return summary, nil
+}
+
+func (b *Builder) checkSizeable() error {
+	if b.appPkg == nil {
+		return util.NewNewtError("app package not specified for this target")
+	}
+	if b.targetBuilder.bspPkg.Arch == "sim" {
+		return util.NewNewtError("'newt size' not supported for sim targets")
+	}
+
+	return nil
 }
 
 func (b *Builder) FindPkgNameByArName(arName string) string {
@@ -413,12 +646,8 @@ func (b *Builder) FindPkgNameByArName(arName string) string {
 }
 
 func (b *Builder) PkgSizes() (*image.ImageManifestSizeCollector, error) {
-	if b.appPkg == nil {
-		return nil, util.NewNewtError("app package not specified for this target")
-	}
-
-	if b.targetBuilder.bspPkg.Arch == "sim" {
-		return nil, util.NewNewtError("'newt size' not supported for sim targets")
+	if err := b.checkSizeable(); err != nil {
+		return nil, err
 	}
 	mapFile := b.AppElfPath() + ".map"
 
@@ -464,45 +693,6 @@ func (b *Builder) PkgSizes() (*image.ImageManifestSizeCollector, error) {
 	return c, nil
 }
 
-func (b *Builder) Size() error {
-	if b.appPkg == nil {
-		return util.NewNewtError("app package not specified for this target")
-	}
-
-	err := b.targetBuilder.PrepBuild()
-	if err != nil {
-		return err
-	}
-	if b.targetBuilder.bspPkg.Arch == "sim" {
-		fmt.Println("'newt size' not supported for sim targets.")
-		return nil
-	}
-	mapFile := b.AppElfPath() + ".map"
-
-	pkgSizes, err := ParseMapFileSizes(mapFile)
-	if err != nil {
-		return err
-	}
-	err = PrintSizes(pkgSizes)
-	if err != nil {
-		return err
-	}
-
-	c, err := b.newCompiler(b.appPkg, b.FileBinDir(b.AppElfPath()))
-	if err != nil {
-		return err
-	}
-
-	fmt.Printf("\nobjsize\n")
-	output, err := c.PrintSize(b.AppElfPath())
-	if err != nil {
-		return err
-	}
-	fmt.Printf("%s", output)
-
-	return nil
-}
-
 func (t *TargetBuilder) SizeReport(ram, flash bool) error {
 
 	err := t.PrepBuild()
@@ -0,0 +1,398 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/image"
+	"mynewt.apache.org/newt/util"
+)
+
+type sizeSymKey struct {
+	pkg    string
+	name   string
+	region string
+}
+
+// Sizes of a single image, indexed by package and memory region.
+type imageSizes struct {
+	pkgs map[string]map[string]uint32
+	syms map[sizeSymKey]uint32
+}
+
+func newImageSizes() *imageSizes {
+	return &imageSizes{
+		pkgs: map[string]map[string]uint32{},
+		syms: map[sizeSymKey]uint32{},
+	}
+}
+
+func (is *imageSizes) add(pkg string, sym string, region string,
+	size uint32) {
+
+	if is.pkgs[pkg] == nil {
+		is.pkgs[pkg] = map[string]uint32{}
+	}
+	is.pkgs[pkg][region] += size
+	is.syms[sizeSymKey{pkg, sym, region}] += size
+}
+
+func (is *imageSizes) addLibs(libs map[string]*PkgSize,
+	pkgName func(arName string) string) {
+
+	for _, es := range libs {
+		name := pkgName(es.Name)
+		for _, sym := range es.Syms {
+			for region, sz := range sym.Sizes {
+				if sz != 0 {
+					is.add(name, sym.Name, region, sz)
+				}
+			}
+		}
+	}
+}
+
+func manifestImageSizes(pkgs []*image.ImageManifestSizePkg) *imageSizes {
+	is := newImageSizes()
+	for _, p := range pkgs {
+		for _, f := range p.Files {
+			for _, sym := range f.Syms {
+				for _, area := range sym.Areas {
+					is.add(p.Name, sym.Name, area.Name, area.Size)
+				}
+			}
+		}
+	}
+
+	return is
+}
+
+// Finds the package an archive from another build of the target belongs to,
+// by matching the archive's path against the paths of this build's archives.
+func (b *Builder) findPkgNameByForeignArName(arName string) string {
+	best := ""
+	bestLen := 0
+	for rpkg, bpkg := range b.PkgMap {
+		suffix := "/" + rpkg.Lpkg.Name() + "/" +
+			util.FilenameFromPath(b.ArchivePath(bpkg))
+		if strings.HasSuffix(arName, suffix) && len(suffix) > bestLen {
+			best = rpkg.Lpkg.FullName()
+			bestLen = len(suffix)
+		}
+	}
+
+	if best == "" {
+		return util.FilenameFromPath(arName)
+	}
+	return best
+}
+
+func (b *Builder) imageSizes() (*imageSizes, []string, error) {
+	if err := b.checkSizeable(); err != nil {
+		return nil, nil, err
+	}
+
+	libs, err := ParseMapFileSizes(b.AppElfPath() + ".map")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	memSections := make(MemSectionArray, 0, len(globalMemSections))
+	for _, sec := range globalMemSections {
+		memSections = append(memSections, sec)
+	}
+	sort.Sort(memSections)
+
+	regions := make([]string, len(memSections))
+	for i, sec := range memSections {
+		regions[i] = sec.Name
+	}
+
+	is := newImageSizes()
+	is.addLibs(libs, b.FindPkgNameByArName)
+
+	return is, regions, nil
+}
+
+// Reads the sizes of another build of this image from its elf file; the
+// linker map is expected next to it.
+func (b *Builder) elfImageSizes(elfPath string) (*imageSizes, error) {
+	mapPath := elfPath + ".map"
+	if util.NodeNotExist(mapPath) {
+		return nil, util.FmtNewtError(
+			"No linker map for %s; expected %s", elfPath, mapPath)
+	}
+
+	libs, err := ParseMapFileSizes(mapPath)
+	if err != nil {
+		return nil, err
+	}
+
+	is := newImageSizes()
+	is.addLibs(libs, b.findPkgNameByForeignArName)
+
+	return is, nil
+}
+
+type PkgSizeDelta struct {
+	Name  string           `json:"name"`
+	Old   map[string]int64 `json:"old"`
+	New   map[string]int64 `json:"new"`
+	Delta map[string]int64 `json:"delta"`
+}
+
+type SymbolSizeDelta struct {
+	Name    string `json:"name"`
+	Package string `json:"package"`
+	Region  string `json:"region"`
+	Old     int64  `json:"old"`
+	New     int64  `json:"new"`
+	Delta   int64  `json:"delta"`
+}
+
+type ImageSizeDiff struct {
+	Name    string           `json:"name"`
+	Regions []string         `json:"regions"`
+	Totals  map[string]int64 `json:"totals"`
+
+	// Packages whose size changed, largest change first.
+	Packages []PkgSizeDelta `json:"packages"`
+
+	// Symbols whose size changed, largest change first.
+	Symbols []SymbolSizeDelta `json:"symbols"`
+}
+
+func absDelta(d int64) int64 {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// Returns the total absolute change of a package's sizes.
+func pkgSizeChange(pd PkgSizeDelta) int64 {
+	total := int64(0)
+	for _, d := range pd.Delta {
+		total += absDelta(d)
+	}
+	return total
+}
+
+type pkgSizeDeltaSorter struct {
+	deltas []PkgSizeDelta
+}
+
+func (s pkgSizeDeltaSorter) Len() int {
+	return len(s.deltas)
+}
+func (s pkgSizeDeltaSorter) Swap(i, j int) {
+	s.deltas[i], s.deltas[j] = s.deltas[j], s.deltas[i]
+}
+func (s pkgSizeDeltaSorter) Less(i, j int) bool {
+	a, b := s.deltas[i], s.deltas[j]
+	if pkgSizeChange(a) != pkgSizeChange(b) {
+		return pkgSizeChange(a) > pkgSizeChange(b)
+	}
+	return a.Name < b.Name
+}
+
+type symbolSizeDeltaSorter struct {
+	deltas []SymbolSizeDelta
+}
+
+func (s symbolSizeDeltaSorter) Len() int {
+	return len(s.deltas)
+}
+func (s symbolSizeDeltaSorter) Swap(i, j int) {
+	s.deltas[i], s.deltas[j] = s.deltas[j], s.deltas[i]
+}
+func (s symbolSizeDeltaSorter) Less(i, j int) bool {
+	a, b := s.deltas[i], s.deltas[j]
+	if absDelta(a.Delta) != absDelta(b.Delta) {
+		return absDelta(a.Delta) > absDelta(b.Delta)
+	}
+	if a.Package != b.Package {
+		return a.Package < b.Package
+	}
+	return a.Name < b.Name
+}
+
+func diffImageSizes(name string, regions []string, old *imageSizes,
+	cur *imageSizes, maxSyms int) ImageSizeDiff {
+
+	// Include regions only known to the old build.
+	known := map[string]bool{}
+	for _, r := range regions {
+		known[r] = true
+	}
+	for _, sizes := range old.pkgs {
+		for r, _ := range sizes {
+			if !known[r] {
+				known[r] = true
+				regions = append(regions, r)
+			}
+		}
+	}
+
+	diff := ImageSizeDiff{
+		Name:     name,
+		Regions:  regions,
+		Totals:   map[string]int64{},
+		Packages: []PkgSizeDelta{},
+		Symbols:  []SymbolSizeDelta{},
+	}
+	for _, r := range regions {
+		diff.Totals[r] = 0
+	}
+
+	pkgNames := map[string]bool{}
+	for p, _ := range old.pkgs {
+		pkgNames[p] = true
+	}
+	for p, _ := range cur.pkgs {
+		pkgNames[p] = true
+	}
+
+	for p, _ := range pkgNames {
+		pd := PkgSizeDelta{
+			Name:  p,
+			Old:   map[string]int64{},
+			New:   map[string]int64{},
+			Delta: map[string]int64{},
+		}
+
+		changed := false
+		for _, r := range regions {
+			o := int64(old.pkgs[p][r])
+			n := int64(cur.pkgs[p][r])
+			pd.Old[r] = o
+			pd.New[r] = n
+			pd.Delta[r] = n - o
+			diff.Totals[r] += n - o
+			if n != o {
+				changed = true
+			}
+		}
+
+		if changed {
+			diff.Packages = append(diff.Packages, pd)
+		}
+	}
+
+	sort.Sort(pkgSizeDeltaSorter{diff.Packages})
+
+	syms := map[sizeSymKey]bool{}
+	for k, _ := range old.syms {
+		syms[k] = true
+	}
+	for k, _ := range cur.syms {
+		syms[k] = true
+	}
+
+	for k, _ := range syms {
+		o := int64(old.syms[k])
+		n := int64(cur.syms[k])
+		if o != n {
+			diff.Symbols = append(diff.Symbols, SymbolSizeDelta{
+				Name:    k.name,
+				Package: k.pkg,
+				Region:  k.region,
+				Old:     o,
+				New:     n,
+				Delta:   n - o,
+			})
+		}
+	}
+
+	sort.Sort(symbolSizeDeltaSorter{diff.Symbols})
+	if maxSyms >= 0 && len(diff.Symbols) > maxSyms {
+		diff.Symbols = diff.Symbols[:maxSyms]
+	}
+
+	return diff
+}
+
+// Compares the target's current build against an older build of it, given
+// either as the path of its app elf file (with the linker map next to it) or
+// of its image manifest.  A manifest also covers the loader image, if any.
+// At most maxSyms changed symbols are reported per image; a negative value
+// means no limit.
+func (t *TargetBuilder) SizeDiff(against string,
+	maxSyms int) ([]ImageSizeDiff, error) {
+
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	olds := map[string]*imageSizes{}
+	if strings.HasSuffix(against, ".json") {
+		data, err := ioutil.ReadFile(against)
+		if err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+
+		manifest := image.ImageManifest{}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, util.FmtNewtError(
+				"Failure decoding manifest %s: %s", against, err.Error())
+		}
+		if len(manifest.PkgSizes) == 0 {
+			return nil, util.FmtNewtError(
+				"Manifest %s contains no size information", against)
+		}
+
+		olds[BUILD_NAME_APP] = manifestImageSizes(manifest.PkgSizes)
+		if len(manifest.LoaderPkgSizes) > 0 {
+			olds[BUILD_NAME_LOADER] =
+				manifestImageSizes(manifest.LoaderPkgSizes)
+		}
+	} else {
+		if err := t.AppBuilder.checkSizeable(); err != nil {
+			return nil, err
+		}
+
+		is, err := t.AppBuilder.elfImageSizes(against)
+		if err != nil {
+			return nil, err
+		}
+		olds[BUILD_NAME_APP] = is
+	}
+
+	diffs := []ImageSizeDiff{}
+	for _, b := range t.builders() {
+		old := olds[b.buildName]
+		if old == nil {
+			continue
+		}
+
+		cur, regions, err := b.imageSizes()
+		if err != nil {
+			return nil, err
+		}
+
+		diffs = append(diffs, diffImageSizes(b.buildName, regions, old, cur,
+			maxSyms))
+	}
+
+	return diffs, nil
+}
@@ -0,0 +1,558 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newt/yaml"
+)
+
+// Default location of the size store, relative to the project directory.
+const SIZE_STORE_FILENAME = "sizes.json"
+
+const SIZE_BASELINE_DFLT = "master"
+
+const sizeStoreHttpTimeout = 30 * time.Second
+
+// The per-package sizes of one build of a target, as kept in a size store.
+type SizeRecord struct {
+	Target string    `json:"target"`
+	Branch string    `json:"branch"`
+	Commit string    `json:"commit"`
+	Time   time.Time `json:"time"`
+
+	// Total size of each package, per image (app, loader).
+	Images map[string]map[string]uint32 `json:"images"`
+}
+
+// Keeps a history of size records.  A store is either a local file
+// containing one JSON record per line, or an HTTP service.
+type SizeStore interface {
+	Append(rec *SizeRecord) error
+
+	// Returns the most recent record of the target on the specified branch,
+	// or nil if there is none.
+	Latest(target string, branch string) (*SizeRecord, error)
+
+	String() string
+}
+
+type fileSizeStore struct {
+	path string
+}
+
+// An HTTP size store accepts records POSTed to its URL, and answers
+// "GET <url>?target=<target>&branch=<branch>" with the most recent matching
+// record (404 if there is none).
+type httpSizeStore struct {
+	url string
+}
+
+// Opens the size store at the specified location: a file path or an http(s)
+// URL.  If loc is empty, the size_store setting is used, and failing that,
+// .newt/sizes.json in the project directory.
+func OpenSizeStore(loc string) SizeStore {
+	if loc == "" {
+		loc = newtutil.NewtSettings.String("size_store")
+	}
+	if loc == "" {
+		loc = filepath.Join(project.GetProject().Path(), newtutil.NEWTRC_DIR,
+			SIZE_STORE_FILENAME)
+	}
+
+	if strings.HasPrefix(loc, "http://") || strings.HasPrefix(loc, "https://") {
+		return &httpSizeStore{url: loc}
+	}
+	return &fileSizeStore{path: loc}
+}
+
+func (s *fileSizeStore) String() string {
+	return s.path
+}
+
+func (s *fileSizeStore) Append(rec *SizeRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+func (s *fileSizeStore) Latest(target string,
+	branch string) (*SizeRecord, error) {
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, util.ChildNewtError(err)
+	}
+	defer f.Close()
+
+	var latest *SizeRecord
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		rec := &SizeRecord{}
+		if err := json.Unmarshal(line, rec); err != nil {
+			return nil, util.FmtNewtError("Failure decoding %s:%d: %s",
+				s.path, lineNum, err.Error())
+		}
+
+		if rec.Target == target && rec.Branch == branch {
+			latest = rec
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	return latest, nil
+}
+
+func (s *httpSizeStore) String() string {
+	return s.url
+}
+
+func (s *httpSizeStore) Append(rec *SizeRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	client := &http.Client{Timeout: sizeStoreHttpTimeout}
+	rsp, err := client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return util.FmtNewtError("Failed to record sizes at %s: %s", s.url,
+			err.Error())
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return util.FmtNewtError("Failed to record sizes at %s: %s", s.url,
+			rsp.Status)
+	}
+
+	return nil
+}
+
+func (s *httpSizeStore) Latest(target string,
+	branch string) (*SizeRecord, error) {
+
+	q := url.Values{}
+	q.Set("target", target)
+	q.Set("branch", branch)
+
+	sep := "?"
+	if strings.Contains(s.url, "?") {
+		sep = "&"
+	}
+
+	client := &http.Client{Timeout: sizeStoreHttpTimeout}
+	rsp, err := client.Get(s.url + sep + q.Encode())
+	if err != nil {
+		return nil, util.FmtNewtError("Failed to query sizes at %s: %s",
+			s.url, err.Error())
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return nil, util.FmtNewtError("Failed to query sizes at %s: %s",
+			s.url, rsp.Status)
+	}
+
+	rec := &SizeRecord{}
+	if err := json.NewDecoder(rsp.Body).Decode(rec); err != nil {
+		return nil, util.FmtNewtError("Failure decoding response from %s: %s",
+			s.url, err.Error())
+	}
+
+	return rec, nil
+}
+
+// Returns the branch checked out in the project directory, or "" if it can't
+// be determined (e.g., a detached HEAD).
+func projectGitBranch() string {
+	out, err := util.ShellCommand([]string{"git", "-C",
+		project.GetProject().Path(), "rev-parse", "--abbrev-ref", "HEAD"}, nil)
+	if err != nil {
+		return ""
+	}
+
+	branch := strings.TrimSpace(string(out))
+	if branch == "HEAD" {
+		return ""
+	}
+	return branch
+}
+
+// Calculates the total size of each package in the target's most recent
+// build, per image.
+func (t *TargetBuilder) pkgSizeTotals() (map[string]map[string]uint32,
+	error) {
+
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	images := map[string]map[string]uint32{}
+	for _, b := range t.builders() {
+		is, _, err := b.imageSizes()
+		if err != nil {
+			return nil, err
+		}
+
+		totals := map[string]uint32{}
+		for pkg, regions := range is.pkgs {
+			totals[pkg] = sumSizes(regions)
+		}
+		images[b.buildName] = totals
+	}
+
+	return images, nil
+}
+
+// Collects the per-package sizes of the target's most recent build.  If
+// branch is empty, the branch checked out in the project is used.
+func (t *TargetBuilder) SizeRecord(branch string) (*SizeRecord, error) {
+	if branch == "" {
+		branch = projectGitBranch()
+		if branch == "" {
+			return nil, util.NewNewtError("Can't determine the project's " +
+				"branch; specify it with --branch")
+		}
+	}
+
+	images, err := t.pkgSizeTotals()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SizeRecord{
+		Target: t.target.FullName(),
+		Branch: branch,
+		Commit: projectGitSha(),
+		Time:   time.Now().UTC(),
+		Images: images,
+	}, nil
+}
+
+// A package's allowed growth: either a number of bytes or a percentage of
+// its baseline size.
+type SizeGrowthBudget struct {
+	Text    string
+	Bytes   int
+	Percent int
+}
+
+// Growth budgets read from a budget file:
+//
+//	baseline: master
+//	default: 2%
+//	packages:
+//	    "@apache-mynewt-core/kernel/os": 512
+//	    "@apache-mynewt-nimble/*": 5%
+//
+// Package names may contain glob patterns; if several match, the longest
+// pattern applies.  Packages without a budget of their own use the default.
+type SizeBudgets struct {
+	Baseline string
+	Default  *SizeGrowthBudget
+	Pkgs     map[string]*SizeGrowthBudget
+}
+
+func parseGrowthBudget(name string, val interface{}) (*SizeGrowthBudget,
+	error) {
+
+	str := strings.TrimSpace(cast.ToString(val))
+
+	var err error
+	gb := &SizeGrowthBudget{Text: str}
+	if strings.HasSuffix(str, "%") {
+		gb.Percent, err = parseBudgetPercent(name, str)
+		if err != nil {
+			return nil, err
+		}
+		gb.Bytes = -1
+	} else {
+		gb.Bytes, err = parseBudgetSize(name, str)
+		if err != nil {
+			return nil, err
+		}
+		gb.Percent = -1
+	}
+
+	return gb, nil
+}
+
+// Reads a size growth budget file.
+func ReadSizeBudgets(filename string) (*SizeBudgets, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, util.FmtNewtError("Error parsing %s: %s", filename,
+			err.Error())
+	}
+
+	sb := &SizeBudgets{
+		Baseline: cast.ToString(m["baseline"]),
+		Pkgs:     map[string]*SizeGrowthBudget{},
+	}
+
+	if val, ok := m["default"]; ok {
+		sb.Default, err = parseGrowthBudget("default", val)
+		if err != nil {
+			return nil, util.FmtNewtError("%s: %s", filename, err.Error())
+		}
+	}
+
+	for name, val := range cast.ToStringMap(m["packages"]) {
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, util.FmtNewtError("%s: invalid package pattern "+
+				"\"%s\"", filename, name)
+		}
+
+		sb.Pkgs[name], err = parseGrowthBudget(name, val)
+		if err != nil {
+			return nil, util.FmtNewtError("%s: %s", filename, err.Error())
+		}
+	}
+
+	return sb, nil
+}
+
+type patternSorter struct {
+	patterns []string
+}
+
+func (s patternSorter) Len() int {
+	return len(s.patterns)
+}
+func (s patternSorter) Swap(i, j int) {
+	s.patterns[i], s.patterns[j] = s.patterns[j], s.patterns[i]
+}
+func (s patternSorter) Less(i, j int) bool {
+	if len(s.patterns[i]) != len(s.patterns[j]) {
+		return len(s.patterns[i]) > len(s.patterns[j])
+	}
+	return s.patterns[i] < s.patterns[j]
+}
+
+// Finds the growth budget that applies to a package; nil if there is none.
+func (sb *SizeBudgets) pkgBudget(pkgName string) *SizeGrowthBudget {
+	if gb := sb.Pkgs[pkgName]; gb != nil {
+		return gb
+	}
+
+	patterns := make([]string, 0, len(sb.Pkgs))
+	for pattern, _ := range sb.Pkgs {
+		patterns = append(patterns, pattern)
+	}
+	sort.Sort(patternSorter{patterns})
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, pkgName); ok {
+			return sb.Pkgs[pattern]
+		}
+	}
+
+	return sb.Default
+}
+
+// The growth of one package relative to the baseline.
+type SizeGrowth struct {
+	Image    string `json:"image"`
+	Package  string `json:"package"`
+	Baseline uint32 `json:"baseline"`
+	Current  uint32 `json:"current"`
+	Growth   int64  `json:"growth"`
+	Budget   string `json:"budget,omitempty"`
+	Exceeded bool   `json:"exceeded"`
+}
+
+type SizeCheckReport struct {
+	Target         string       `json:"target"`
+	Baseline       string       `json:"baseline"`
+	BaselineCommit string       `json:"baseline_commit"`
+	Packages       []SizeGrowth `json:"packages"`
+}
+
+func (r *SizeCheckReport) Exceeded() []SizeGrowth {
+	exceeded := []SizeGrowth{}
+	for _, g := range r.Packages {
+		if g.Exceeded {
+			exceeded = append(exceeded, g)
+		}
+	}
+
+	return exceeded
+}
+
+type sizeGrowthSorter struct {
+	growths []SizeGrowth
+}
+
+func (s sizeGrowthSorter) Len() int {
+	return len(s.growths)
+}
+func (s sizeGrowthSorter) Swap(i, j int) {
+	s.growths[i], s.growths[j] = s.growths[j], s.growths[i]
+}
+func (s sizeGrowthSorter) Less(i, j int) bool {
+	a := s.growths[i]
+	b := s.growths[j]
+	if a.Image != b.Image {
+		return a.Image < b.Image
+	}
+	if a.Growth != b.Growth {
+		return a.Growth > b.Growth
+	}
+	return a.Package < b.Package
+}
+
+// Compares the per-package sizes of the target's most recent build against
+// the latest record of the baseline branch, and flags the packages that grew
+// by more than their budget.  Packages that are new since the baseline are
+// only held to byte budgets.  If baseline is empty, the budget file's
+// baseline is used, and failing that, "master".
+func (t *TargetBuilder) SizeCheck(store SizeStore, budgets *SizeBudgets,
+	baseline string) (*SizeCheckReport, error) {
+
+	if baseline == "" {
+		baseline = budgets.Baseline
+	}
+	if baseline == "" {
+		baseline = SIZE_BASELINE_DFLT
+	}
+
+	images, err := t.pkgSizeTotals()
+	if err != nil {
+		return nil, err
+	}
+
+	targetName := t.target.FullName()
+	base, err := store.Latest(targetName, baseline)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		return nil, util.FmtNewtError("No sizes of %s recorded for branch "+
+			"\"%s\" in %s; run \"newt size record\" on that branch first",
+			targetName, baseline, store.String())
+	}
+
+	report := &SizeCheckReport{
+		Target:         targetName,
+		Baseline:       baseline,
+		BaselineCommit: base.Commit,
+		Packages:       []SizeGrowth{},
+	}
+
+	for imgName, pkgs := range images {
+		basePkgs := base.Images[imgName]
+
+		for pkg, sz := range pkgs {
+			baseSz, existed := basePkgs[pkg]
+			if sz <= baseSz {
+				continue
+			}
+
+			g := SizeGrowth{
+				Image:    imgName,
+				Package:  pkg,
+				Baseline: baseSz,
+				Current:  sz,
+				Growth:   int64(sz) - int64(baseSz),
+			}
+
+			if gb := budgets.pkgBudget(pkg); gb != nil {
+				g.Budget = gb.Text
+				if gb.Bytes >= 0 {
+					g.Exceeded = g.Growth > int64(gb.Bytes)
+				} else if existed {
+					g.Exceeded = g.Growth*100 > int64(baseSz)*int64(gb.Percent)
+				}
+			}
+
+			report.Packages = append(report.Packages, g)
+		}
+	}
+
+	sort.Sort(sizeGrowthSorter{report.Packages})
+
+	return report, nil
+}
+
+func (g SizeGrowth) String() string {
+	pct := ""
+	if g.Baseline != 0 {
+		pct = fmt.Sprintf(" (%+.1f%%)",
+			float64(g.Growth)*100/float64(g.Baseline))
+	}
+	return fmt.Sprintf("%s: %s grew by %d bytes%s; %d -> %d", g.Image,
+		g.Package, g.Growth, pct, g.Baseline, g.Current)
+}
@@ -0,0 +1,303 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"mynewt.apache.org/newt/newt/symbol"
+	"mynewt.apache.org/newt/util"
+)
+
+const SPLIT_STATE_FILENAME = "split.json"
+
+// Records the loader / app pairing produced by the most recent split build
+// of a target.  The symbol list is cached so that subsequent builds with
+// unchanged inputs do not need to recalculate it.
+type SplitState struct {
+	Loader    string `json:"loader"`
+	App       string `json:"app"`
+	Generated string `json:"generated"`
+
+	// Fingerprint of the archives and tentative executables the symbol list
+	// was calculated from.
+	InputHash string `json:"input_hash"`
+
+	// Packages linked into the loader and shared with the app.
+	CommonPkgs []string `json:"common_packages"`
+
+	// Loader symbols the app links against.
+	Symbols []symbol.SymbolInfo `json:"symbols"`
+
+	// The target's loader_keep setting, and the app symbols from shared
+	// packages that the loader was linked with (-Wl,--undefined).
+	KeepMode    string              `json:"keep_mode,omitempty"`
+	KeepSymbols []symbol.SymbolInfo `json:"keep_symbols,omitempty"`
+
+	// Fingerprint of the symbol table of the loader's ROM elf, i.e., the ABI
+	// the loader presents to the app.
+	LoaderAbi string `json:"loader_abi"`
+
+	// The loader ABI the app was most recently linked against.
+	AppAbi string `json:"app_abi,omitempty"`
+}
+
+func SplitStatePath(targetName string) string {
+	return filepath.Join(TargetBinDir(targetName), SPLIT_STATE_FILENAME)
+}
+
+// Reads the split state recorded for the specified target.  Returns nil if
+// the target has never been built as a split image.
+func ReadSplitState(targetName string) (*SplitState, error) {
+	path := SplitStatePath(targetName)
+	if util.NodeNotExist(path) {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	ss := &SplitState{}
+	if err := json.Unmarshal(data, ss); err != nil {
+		return nil, util.FmtNewtError(
+			"Failure decoding split state %s: %s", path, err.Error())
+	}
+
+	return ss, nil
+}
+
+func (ss *SplitState) write(targetName string) error {
+	data, err := json.MarshalIndent(ss, "", "    ")
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	path := SplitStatePath(targetName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Returns the symbols in the specified map, sorted by name.
+func sortedSymbols(sm *symbol.SymbolMap) []symbol.SymbolInfo {
+	names := make([]string, 0, len(*sm))
+	for name, _ := range *sm {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	syms := make([]symbol.SymbolInfo, 0, len(names))
+	for _, name := range names {
+		syms = append(syms, (*sm)[name])
+	}
+
+	return syms
+}
+
+func (ss *SplitState) symbolMap() *symbol.SymbolMap {
+	sm := symbol.NewSymbolMap()
+	for _, si := range ss.Symbols {
+		sm.Add(si)
+	}
+
+	return sm
+}
+
+func (ss *SplitState) commonPkgMap() map[string]bool {
+	m := map[string]bool{}
+	for _, name := range ss.CommonPkgs {
+		m[name] = true
+	}
+
+	return m
+}
+
+// Indicates whether the app was linked against the loader's current ABI.
+func (ss *SplitState) InSync() bool {
+	return ss.AppAbi != "" && ss.AppAbi == ss.LoaderAbi
+}
+
+// Calculates a fingerprint of the files the split symbol list is derived
+// from: every package archive in both images, and both tentative
+// executables.
+func (t *TargetBuilder) splitInputHash() (string, error) {
+	paths := []string{
+		t.AppBuilder.AppTentativeElfPath(),
+		t.LoaderBuilder.AppTentativeElfPath(),
+	}
+	for _, b := range []*Builder{t.AppBuilder, t.LoaderBuilder} {
+		for _, bpkg := range b.PkgMap {
+			archives, _ := filepath.Glob(
+				filepath.Join(b.PkgBinDir(bpkg), "*.a"))
+			paths = append(paths, archives...)
+		}
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", util.ChildNewtError(err)
+		}
+		fmt.Fprintf(h, "%s %x\n", path, sha256.Sum256(data))
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Calculates a fingerprint of the symbols the loader's ROM elf exposes to
+// the app.  If any of their addresses or sizes change, the app must be
+// relinked.
+func (b *Builder) loaderAbiHash() (string, error) {
+	err, sm := b.ParseObjectElf(b.AppLinkerElfPath())
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0, len(*sm))
+	for name, _ := range *sm {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		si := (*sm)[name]
+		fmt.Fprintf(h, "%s %s %x %d\n", name, si.Section, si.Loc, si.Size)
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Determines the set of packages and symbols shared by the loader and app.
+// The result is cached in the target's split state; it is only recalculated
+// (and the loader relinked) if any of the inputs changed since the previous
+// build.
+func (t *TargetBuilder) splitSymbols(prev *SplitState, inputHash string) (
+	map[string]bool, *symbol.SymbolMap, error) {
+
+	keepMode, err := t.target.LoaderKeep()
+	if err != nil {
+		return nil, nil, err
+	}
+	t.loaderKeep = keepMode
+
+	if prev != nil &&
+		prev.InputHash == inputHash &&
+		prev.KeepMode == keepMode &&
+		prev.Loader == t.LoaderBuilder.appPkg.rpkg.Lpkg.FullName() &&
+		prev.App == t.AppBuilder.appPkg.rpkg.Lpkg.FullName() &&
+		util.NodeExist(t.LoaderBuilder.AppElfPath()) {
+
+		util.StatusMessage(util.VERBOSITY_VERBOSE,
+			"Loader symbol list is up to date; using cached list "+
+				"(%d symbols)\n", len(prev.Symbols))
+		return prev.commonPkgMap(), prev.symbolMap(), nil
+	}
+
+	err, commonPkgs, commonSyms := t.RelinkLoader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return commonPkgs, commonSyms, nil
+}
+
+// Describes a split build with the specified shared packages and symbols.
+// The symbols are copied; the caller may modify the map afterwards.
+func (t *TargetBuilder) newSplitState(prev *SplitState, inputHash string,
+	commonPkgs map[string]bool, commonSyms *symbol.SymbolMap) *SplitState {
+
+	ss := &SplitState{
+		Loader:     t.LoaderBuilder.appPkg.rpkg.Lpkg.FullName(),
+		App:        t.AppBuilder.appPkg.rpkg.Lpkg.FullName(),
+		Generated:  time.Now().Format(time.RFC3339),
+		InputHash:  inputHash,
+		CommonPkgs: sortedKeys(commonPkgs),
+		KeepMode:   t.loaderKeep,
+	}
+
+	ss.Symbols = sortedSymbols(commonSyms)
+
+	if t.loaderKeepSyms != nil {
+		ss.KeepSymbols = sortedSymbols(t.loaderKeepSyms)
+	} else if prev != nil {
+		// The loader wasn't relinked; its keep list is unchanged.
+		ss.KeepSymbols = prev.KeepSymbols
+	}
+
+	if prev != nil {
+		if prev.InputHash == inputHash {
+			ss.Generated = prev.Generated
+		}
+		ss.AppAbi = prev.AppAbi
+	}
+
+	return ss
+}
+
+// Records the state of a split build after the loader's ROM elf has been
+// generated.  If the loader ABI differs from the one the app was linked
+// against, the app executable is removed to force a relink.
+func (t *TargetBuilder) recordSplitState(ss *SplitState) error {
+	abi, err := t.LoaderBuilder.loaderAbiHash()
+	if err != nil {
+		return err
+	}
+	ss.LoaderAbi = abi
+
+	if ss.AppAbi != ss.LoaderAbi {
+		appElf := t.AppBuilder.AppElfPath()
+		if util.NodeExist(appElf) {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"Loader ABI changed; relinking app\n")
+			if err := os.Remove(appElf); err != nil {
+				return util.ChildNewtError(err)
+			}
+		}
+	}
+
+	t.splitState = ss
+	return ss.write(t.target.Name())
+}
+
+// Records that the app has been linked against the loader's current ABI.
+func (t *TargetBuilder) recordSplitLink() error {
+	if t.splitState == nil {
+		return nil
+	}
+
+	t.splitState.AppAbi = t.splitState.LoaderAbi
+	return t.splitState.write(t.target.Name())
+}
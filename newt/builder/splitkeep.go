@@ -0,0 +1,172 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+
+	"mynewt.apache.org/newt/newt/symbol"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+// An app symbol from a shared package that the loader was linked with.
+type SplitKeepSymbol struct {
+	Name    string `json:"name"`
+	Package string `json:"package"`
+	Section string `json:"section"`
+	Size    int    `json:"size"`
+
+	// Whether one of the app's own packages references the symbol.
+	Referenced bool `json:"referenced"`
+}
+
+// Usefulness of a split image's loader keep list.  Unreferenced symbols are
+// only kept because the tentative app executable contained them; their
+// total size is an upper bound on what minimizing the list saves, since
+// another kept symbol may still pull them in.
+type SplitKeepReport struct {
+	Target           string            `json:"target"`
+	KeepMode         string            `json:"keep_mode"`
+	Symbols          []SplitKeepSymbol `json:"symbols"`
+	Referenced       int               `json:"referenced"`
+	Unreferenced     int               `json:"unreferenced"`
+	UnreferencedSize int               `json:"unreferenced_size"`
+}
+
+// Returns the names of the symbols that the builder's packages reference
+// without defining, ignoring the specified (shared) packages.  Packages
+// without an archive are skipped.
+func (b *Builder) referencedSymbols(shared map[string]bool) (
+	map[string]bool, error) {
+
+	refs := map[string]bool{}
+	for _, bpkg := range b.PkgMap {
+		if shared[bpkg.rpkg.Lpkg.Name()] ||
+			util.NodeNotExist(b.ArchivePath(bpkg)) {
+
+			continue
+		}
+
+		names, err := b.ParseUndefinedSymbols(bpkg)
+		if err != nil {
+			return nil, err
+		}
+		for name, _ := range names {
+			refs[name] = true
+		}
+	}
+
+	return refs, nil
+}
+
+// Returns the symbols of a keep list that are referenced.
+func filterKeepSymbols(keep *symbol.SymbolMap,
+	refs map[string]bool) *symbol.SymbolMap {
+
+	filtered := symbol.NewSymbolMap()
+	for name, si := range *keep {
+		if refs[name] {
+			filtered.Add(si)
+		}
+	}
+
+	return filtered
+}
+
+// Reports which symbols of the loader's keep list the app still references.
+// The target must have been built.
+func (t *TargetBuilder) SplitKeepReport() (*SplitKeepReport, error) {
+	if t.LoaderBuilder == nil {
+		return nil, util.FmtNewtError(
+			"Target %s is not a split image target", t.target.FullName())
+	}
+
+	ss, err := ReadSplitState(t.target.Name())
+	if err != nil {
+		return nil, err
+	}
+	if ss == nil {
+		return nil, util.FmtNewtError(
+			"No split build recorded for target %s", t.target.FullName())
+	}
+
+	// The shared packages have been removed from the app builder; the
+	// remaining ones are the app's own.
+	refs, err := t.AppBuilder.referencedSymbols(ss.commonPkgMap())
+	if err != nil {
+		return nil, err
+	}
+
+	r := &SplitKeepReport{
+		Target:   t.target.FullName(),
+		KeepMode: ss.KeepMode,
+		Symbols:  []SplitKeepSymbol{},
+	}
+	if r.KeepMode == "" {
+		r.KeepMode = target.TARGET_LOADER_KEEP_ALL
+	}
+
+	for _, si := range ss.KeepSymbols {
+		ks := SplitKeepSymbol{
+			Name:       si.Name,
+			Package:    si.Bpkg,
+			Section:    si.Section,
+			Size:       si.Size,
+			Referenced: refs[si.Name],
+		}
+		if ks.Referenced {
+			r.Referenced++
+		} else {
+			r.Unreferenced++
+			r.UnreferencedSize += ks.Size
+		}
+		r.Symbols = append(r.Symbols, ks)
+	}
+
+	return r, nil
+}
+
+// Returns a human-readable description of the report.  Unless verbose is
+// set, only unreferenced symbols are listed.
+func (r *SplitKeepReport) Text(verbose bool) string {
+	buf := bytes.Buffer{}
+
+	fmt.Fprintf(&buf, "Loader keep list of %s (%s): %d symbols, "+
+		"%d referenced by the app, %d unreferenced (up to %d bytes)\n",
+		r.Target, r.KeepMode, len(r.Symbols), r.Referenced, r.Unreferenced,
+		r.UnreferencedSize)
+
+	for _, s := range r.Symbols {
+		if s.Referenced && !verbose {
+			continue
+		}
+
+		status := "unreferenced"
+		if s.Referenced {
+			status = "referenced"
+		}
+		fmt.Fprintf(&buf, "    %-12s %6d %-8s %s (%s)\n", status, s.Size,
+			s.Section, s.Name, s.Package)
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,368 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+// Stack size setting of the main task in mynewt core; used when the target
+// does not specify any stack sizes.
+const STACK_DEFAULT_ENTRY = "main"
+const STACK_DEFAULT_SETTING = "OS_MAIN_STACK_SIZE"
+
+// Worst-case stack estimate for a single entry point (e.g., a task
+// function).
+type StackUsage struct {
+	Entry string `json:"entry"`
+
+	// The syscfg setting the configured size was read from, if any.
+	Setting string `json:"setting,omitempty"`
+
+	// Configured stack size in bytes; 0 if unknown.
+	Configured int `json:"configured_bytes,omitempty"`
+
+	Estimated int `json:"estimated_bytes"`
+
+	// The call chain that produces the estimate, starting at the entry.
+	Path []string `json:"deepest_path"`
+
+	// The estimate is a lower bound if the call graph contains recursion,
+	// frames of dynamic size, indirect calls, or functions without stack
+	// usage information.
+	Recursive bool     `json:"recursive"`
+	Dynamic   bool     `json:"dynamic"`
+	Indirect  bool     `json:"indirect_calls"`
+	Unknown   []string `json:"unknown_functions,omitempty"`
+
+	// Whether the estimate plus the safety margin exceeds the configured
+	// size.
+	Insufficient bool `json:"insufficient"`
+}
+
+type stackFrame struct {
+	size    int
+	dynamic bool
+}
+
+// Instructs the target builder to have the compiler emit per-function stack
+// usage (.su) files.
+func (t *TargetBuilder) EnableStackUsage() {
+	t.stackUsage = true
+}
+
+func stackUsageCompilerInfo() *toolchain.CompilerInfo {
+	ci := toolchain.NewCompilerInfo()
+	ci.Cflags = []string{"-fstack-usage"}
+	return ci
+}
+
+// Parses every .su file in the specified directory tree.  Each line has the
+// form "<file>:<line>:<col>:<function>\t<bytes>\t<qualifiers>".
+func readStackFrames(dir string) (map[string]stackFrame, error) {
+	frames := map[string]stackFrame{}
+
+	err := filepath.Walk(dir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() ||
+				!strings.HasSuffix(path, ".su") {
+
+				return nil
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return util.ChildNewtError(err)
+			}
+
+			for _, line := range strings.Split(string(data), "\n") {
+				fields := strings.Split(line, "\t")
+				if len(fields) < 3 {
+					continue
+				}
+
+				loc := fields[0]
+				name := loc[strings.LastIndex(loc, ":")+1:]
+				size, err := util.AtoiNoOct(fields[1])
+				if err != nil {
+					continue
+				}
+
+				// Static functions in different files may share a name;
+				// keep the larger frame.
+				f := stackFrame{
+					size:    size,
+					dynamic: strings.Contains(fields[2], "dynamic"),
+				}
+				if old, ok := frames[name]; !ok || f.size > old.size {
+					frames[name] = f
+				}
+			}
+
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return frames, nil
+}
+
+var disasmFuncRe = regexp.MustCompile(`^[0-9a-f]+ <([^>]+)>:$`)
+var disasmCallRe = regexp.MustCompile(
+	`\s(bl|blx|call|callq|jal|b|b\.w|b\.n|jmp|jmpq)\s+(?:0x)?[0-9a-f]+\s+` +
+		`<([^>+]+)(\+0x[0-9a-f]+)?>`)
+var disasmIndirectRe = regexp.MustCompile(
+	`\s(blx\s+r\d+|bx\s+r[0-9]\b|callq?\s+\*|jalr\s)`)
+
+// Builds a call graph from the disassembly of an executable.  Returns each
+// function's callees and the set of functions that make indirect calls.
+func readCallGraph(disasm []byte) (map[string][]string, map[string]bool) {
+	calls := map[string][]string{}
+	indirect := map[string]bool{}
+
+	cur := ""
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(disasm))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if m := disasmFuncRe.FindStringSubmatch(line); m != nil {
+			cur = m[1]
+			seen = map[string]bool{}
+			continue
+		}
+		if cur == "" {
+			continue
+		}
+
+		if m := disasmCallRe.FindStringSubmatch(line); m != nil {
+			mnemonic, callee, offset := m[1], m[2], m[3]
+
+			// A branch is only a (tail) call if it targets the start of
+			// another function.
+			isCall := mnemonic == "bl" || mnemonic == "blx" ||
+				strings.HasPrefix(mnemonic, "call") || mnemonic == "jal"
+			if !isCall && (offset != "" || callee == cur) {
+				continue
+			}
+
+			if !seen[callee] {
+				seen[callee] = true
+				calls[cur] = append(calls[cur], callee)
+			}
+			continue
+		}
+
+		if disasmIndirectRe.MatchString(line) {
+			indirect[cur] = true
+		}
+	}
+
+	return calls, indirect
+}
+
+type stackAnalyzer struct {
+	frames   map[string]stackFrame
+	calls    map[string][]string
+	indirect map[string]bool
+
+	// Memoized worst-case depth and path of each fully analyzed function.
+	depth map[string]int
+	path  map[string][]string
+
+	onStack map[string]bool
+	result  *StackUsage
+	unknown map[string]bool
+}
+
+func (sa *stackAnalyzer) visit(fn string) {
+	if _, ok := sa.depth[fn]; ok {
+		return
+	}
+
+	frame, ok := sa.frames[fn]
+	if !ok {
+		sa.unknown[fn] = true
+	}
+	if frame.dynamic {
+		sa.result.Dynamic = true
+	}
+	if sa.indirect[fn] {
+		sa.result.Indirect = true
+	}
+
+	sa.onStack[fn] = true
+
+	best := 0
+	var bestPath []string
+	for _, callee := range sa.calls[fn] {
+		if sa.onStack[callee] {
+			sa.result.Recursive = true
+			continue
+		}
+
+		sa.visit(callee)
+		if sa.depth[callee] > best || bestPath == nil {
+			best = sa.depth[callee]
+			bestPath = sa.path[callee]
+		}
+	}
+
+	delete(sa.onStack, fn)
+
+	sa.depth[fn] = frame.size + best
+	sa.path[fn] = append([]string{fn}, bestPath...)
+}
+
+// Resolves a configured stack size: either a syscfg setting holding the size
+// in os_stack_t units, or a size in bytes.
+func (t *TargetBuilder) stackSize(val string, wordSize int) (int, string,
+	error) {
+
+	if val == "" {
+		return 0, "", nil
+	}
+
+	if size, err := parseBudgetSize("stack", val); err == nil {
+		return size, "", nil
+	}
+
+	entry, ok := t.res.Cfg.Settings[val]
+	if !ok {
+		return 0, "", util.FmtNewtError(
+			"Stack size \"%s\" is neither a size nor a syscfg setting", val)
+	}
+
+	words, err := util.AtoiNoOct(entry.Value)
+	if err != nil {
+		return 0, "", util.FmtNewtError(
+			"Syscfg setting %s has non-numeric value: %s", val, entry.Value)
+	}
+
+	return words * wordSize, val, nil
+}
+
+// Estimates the worst-case stack depth of each entry point of a built
+// target.  Entry points and their configured stack sizes come from the
+// target's target.stack.<function> settings, or default to the main task;
+// extraEntries adds functions with no configured size.  An entry is flagged
+// as insufficient if its estimate grown by marginPct percent exceeds the
+// configured size.
+func (t *TargetBuilder) StackUsage(extraEntries []string, wordSize int,
+	marginPct int) ([]*StackUsage, error) {
+
+	b := t.AppBuilder
+	elfPath := b.AppElfPath()
+	if util.NodeNotExist(elfPath) {
+		return nil, util.FmtNewtError("No app executable: %s", elfPath)
+	}
+
+	frames, err := readStackFrames(b.BinDir())
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, util.NewNewtError("No stack usage information found; " +
+			"the compiler may not support -fstack-usage")
+	}
+
+	c, err := t.NewCompiler(b.BinDir())
+	if err != nil {
+		return nil, err
+	}
+	cmd, env := newtutil.ToolchainCmd(c.DisassembleCmd(elfPath),
+		t.target.Env())
+	disasm, err := util.ShellCommandLimitDbgOutput(cmd, env, 0)
+	if err != nil {
+		return nil, err
+	}
+	calls, indirect := readCallGraph(disasm)
+
+	entries := t.target.StackSizes()
+	if len(entries) == 0 {
+		if _, ok := t.res.Cfg.Settings[STACK_DEFAULT_SETTING]; ok {
+			entries[STACK_DEFAULT_ENTRY] = STACK_DEFAULT_SETTING
+		}
+	}
+	for _, e := range extraEntries {
+		if _, ok := entries[e]; !ok {
+			entries[e] = ""
+		}
+	}
+
+	names := make([]string, 0, len(entries))
+	for name, _ := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := []*StackUsage{}
+	for _, name := range names {
+		su := &StackUsage{Entry: name}
+		su.Configured, su.Setting, err = t.stackSize(entries[name], wordSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := frames[name]; !ok && calls[name] == nil {
+			return nil, util.FmtNewtError(
+				"Stack entry point \"%s\" not found in %s", name, elfPath)
+		}
+
+		sa := &stackAnalyzer{
+			frames:   frames,
+			calls:    calls,
+			indirect: indirect,
+			depth:    map[string]int{},
+			path:     map[string][]string{},
+			onStack:  map[string]bool{},
+			result:   su,
+			unknown:  map[string]bool{},
+		}
+		sa.visit(name)
+
+		su.Estimated = sa.depth[name]
+		su.Path = sa.path[name]
+		for fn, _ := range sa.unknown {
+			su.Unknown = append(su.Unknown, fn)
+		}
+		sort.Strings(su.Unknown)
+
+		if su.Configured > 0 {
+			su.Insufficient =
+				su.Estimated*(100+marginPct) > su.Configured*100
+		}
+
+		results = append(results, su)
+	}
+
+	return results, nil
+}
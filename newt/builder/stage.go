@@ -0,0 +1,95 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package builder
+
+import (
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// The stages of a build, in the order they run.  A build can be stopped
+// after any of them; see SetUntilStage().
+const (
+	// Generated headers and sources (syscfg, sysinit, flash map, etc.).
+	BUILD_STAGE_GENERATE = "generate"
+
+	// Object files.
+	BUILD_STAGE_COMPILE = "compile"
+
+	// Package archives.
+	BUILD_STAGE_ARCHIVE = "archive"
+
+	// Linked ELF files.
+	BUILD_STAGE_LINK = "link"
+
+	// The complete build: post-link hooks, budget checks and the manifest.
+	BUILD_STAGE_IMAGE = "image"
+)
+
+var BuildStages = []string{
+	BUILD_STAGE_GENERATE,
+	BUILD_STAGE_COMPILE,
+	BUILD_STAGE_ARCHIVE,
+	BUILD_STAGE_LINK,
+	BUILD_STAGE_IMAGE,
+}
+
+func buildStageIdx(stage string) int {
+	for i, s := range BuildStages {
+		if s == stage {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Instructs the target builder to stop the build after the specified stage.
+// An empty string runs the complete build.
+func (t *TargetBuilder) SetUntilStage(stage string) error {
+	if stage != "" && buildStageIdx(stage) < 0 {
+		return util.FmtNewtError("Invalid build stage \"%s\"; must be one "+
+			"of: %s", stage, strings.Join(BuildStages, ", "))
+	}
+
+	t.untilStage = stage
+	return nil
+}
+
+// Returns the stage the build stops after.
+func (t *TargetBuilder) UntilStage() string {
+	if t.untilStage == "" {
+		return BUILD_STAGE_IMAGE
+	}
+
+	return t.untilStage
+}
+
+// Indicates whether the build stops after the specified stage, i.e., whether
+// none of the subsequent stages run.
+func (t *TargetBuilder) stopsAfter(stage string) bool {
+	return buildStageIdx(t.UntilStage()) <= buildStageIdx(stage)
+}
+
+// Indicates whether the build stops before reaching the final stage.
+func (t *TargetBuilder) StopsEarly() bool {
+	return t.UntilStage() != BUILD_STAGE_IMAGE
+}
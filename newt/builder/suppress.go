@@ -0,0 +1,208 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
+)
+
+// Name of the file, in a package's base directory, listing the analyzer
+// findings the package deliberately deviates from.
+const SUPPRESS_FILENAME = "analyze.suppress"
+
+// An entry of a package suppression file.  Each non-blank line has the form
+// "<rule> [<file>[:<line>]] # <justification>"; the rule and file may be glob
+// patterns, and the file is relative to the package directory.  Every entry
+// must carry a justification.
+type Suppression struct {
+	Package       string `json:"package"`
+	Source        string `json:"source"`
+	Rule          string `json:"rule"`
+	File          string `json:"file,omitempty"`
+	Line          int    `json:"line,omitempty"`
+	Justification string `json:"justification"`
+
+	pkgDir string
+	used   bool
+}
+
+func parseSuppression(pkgName string, pkgDir string, source string,
+	line string) (*Suppression, error) {
+
+	justification := ""
+	if idx := strings.Index(line, "#"); idx >= 0 {
+		justification = strings.TrimSpace(line[idx+1:])
+		line = line[:idx]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if len(fields) > 2 {
+		return nil, util.FmtNewtError(
+			"%s: invalid suppression; expected <rule> [<file>[:<line>]]",
+			source)
+	}
+	if justification == "" {
+		return nil, util.FmtNewtError(
+			"%s: suppression of %s has no justification", source, fields[0])
+	}
+
+	sup := &Suppression{
+		Package:       pkgName,
+		Source:        source,
+		Rule:          fields[0],
+		Justification: justification,
+		pkgDir:        pkgDir,
+	}
+
+	if len(fields) > 1 {
+		sup.File = fields[1]
+		if idx := strings.LastIndex(sup.File, ":"); idx >= 0 {
+			n, err := util.AtoiNoOct(sup.File[idx+1:])
+			if err != nil || n <= 0 {
+				return nil, util.FmtNewtError(
+					"%s: invalid line number in \"%s\"", source, sup.File)
+			}
+			sup.Line = n
+			sup.File = sup.File[:idx]
+		}
+	}
+
+	if _, err := path.Match(sup.Rule, ""); err != nil {
+		return nil, util.FmtNewtError("%s: invalid rule pattern \"%s\"",
+			source, sup.Rule)
+	}
+	if _, err := filepath.Match(sup.File, ""); err != nil {
+		return nil, util.FmtNewtError("%s: invalid file pattern \"%s\"",
+			source, sup.File)
+	}
+
+	return sup, nil
+}
+
+// Reads the suppression file of each package with a compile command.
+func readSuppressions(cmds []CompileCommand) ([]*Suppression, error) {
+	sups := []*Suppression{}
+
+	seen := map[string]bool{}
+	for _, cc := range cmds {
+		if seen[cc.Package] {
+			continue
+		}
+		seen[cc.Package] = true
+
+		supPath := filepath.Join(cc.PkgDir, SUPPRESS_FILENAME)
+		if util.NodeNotExist(supPath) {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(supPath)
+		if err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+
+		relPath := supPath
+		if rel, err := filepath.Rel(project.GetProject().Path(),
+			supPath); err == nil {
+
+			relPath = rel
+		}
+
+		for i, line := range strings.Split(string(data), "\n") {
+			source := fmt.Sprintf("%s:%d", relPath, i+1)
+			sup, err := parseSuppression(cc.Package, cc.PkgDir, source, line)
+			if err != nil {
+				return nil, err
+			}
+			if sup != nil {
+				sups = append(sups, sup)
+			}
+		}
+	}
+
+	return sups, nil
+}
+
+func (sup *Suppression) matches(f *Finding) bool {
+	if f.Package != sup.Package {
+		return false
+	}
+
+	ruleMatch := false
+	for _, rule := range strings.Split(f.Rule, ",") {
+		if ok, _ := path.Match(sup.Rule, rule); ok {
+			ruleMatch = true
+			break
+		}
+	}
+	if !ruleMatch {
+		return false
+	}
+
+	if sup.File != "" {
+		file := f.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(project.GetProject().Path(), file)
+		}
+		rel, err := filepath.Rel(sup.pkgDir, file)
+		if err != nil {
+			return false
+		}
+		if ok, _ := filepath.Match(sup.File, rel); !ok {
+			return false
+		}
+	}
+
+	return sup.Line == 0 || sup.Line == f.Line
+}
+
+// Marks each finding matched by a suppression entry and returns the entries
+// that matched nothing.
+func applySuppressions(findings []Finding,
+	sups []*Suppression) []*Suppression {
+
+	for i, _ := range findings {
+		f := &findings[i]
+		for _, sup := range sups {
+			if sup.matches(f) {
+				f.Suppression = sup
+				sup.used = true
+				break
+			}
+		}
+	}
+
+	unused := []*Suppression{}
+	for _, sup := range sups {
+		if !sup.used {
+			unused = append(unused, sup)
+		}
+	}
+
+	return unused
+}
@@ -27,11 +27,13 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 
 	"mynewt.apache.org/newt/newt/flash"
+	"mynewt.apache.org/newt/newt/gatt"
 	"mynewt.apache.org/newt/newt/image"
 	"mynewt.apache.org/newt/newt/interfaces"
 	"mynewt.apache.org/newt/newt/newtutil"
@@ -46,6 +48,11 @@ import (
 	"mynewt.apache.org/newt/util"
 )
 
+// Serializes PrepBuild() across target builders, which may be used
+// concurrently (e.g., when loading several devices at once); builders of the
+// same target share their generated files.
+var prepBuildMutex sync.Mutex
+
 type TargetBuilder struct {
 	target      *target.Target
 	bspPkg      *pkg.BspPackage
@@ -62,7 +69,77 @@ type TargetBuilder struct {
 
 	injectedSettings map[string]string
 
+	// Report memory budget violations as warnings rather than errors.
+	BudgetWarnOnly bool
+
+	// Report an incompatibility with the frozen ROM symbols as a warning
+	// rather than an error; see checkRomVersion().
+	RomCheckWarnOnly bool
+
+	// Instrument compiled code for gcov; see EnableCoverage().
+	coverage bool
+
+	// Sanitizers to build with; see EnableSanitizers().
+	sanitizers []string
+
+	// Whether the test package is built for real hardware; see
+	// NewTargetHwTester().
+	hwTest bool
+
+	// Set when building a fuzz harness; see NewTargetFuzzer().
+	fuzz *FuzzConfig
+
+	// Emit per-function stack usage; see EnableStackUsage().
+	stackUsage bool
+
+	// Use thin archives and incremental linking; see EnableIncremental().
+	incremental bool
+
+	// Share object files with other targets; see EnableSharedObjects().
+	sharedObjects bool
+
+	// Compilation cache hits and misses of the current build.
+	cacheStats *toolchain.CacheStats
+
+	// Per-file compile times of the current build.
+	compileTimes *toolchain.CompileTimes
+
+	// Reject includes from undeclared dependencies; see
+	// EnableStrictIncludes().
+	strictIncludes bool
+
+	// Only pass packages the include paths of their dependencies; see
+	// EnableIncludePruning().
+	pruneIncludes bool
+
+	// The target's loader_keep setting and the symbols the loader was most
+	// recently relinked with; see RelinkLoader().
+	loaderKeep     string
+	loaderKeepSyms *symbol.SymbolMap
+
+	// Stage after which the build stops; see SetUntilStage().
+	untilStage string
+
+	// Debug probe backend for load and debug; see SetProbe().
+	probe string
+
+	// Serial number of the debug probe to use; see SetProbeSerial().
+	probeSerial string
+
+	// Load through the BSP's serial bootloader; see SetSerialLoad().
+	serialLoad *SerialLoadOptions
+
+	// Load by uploading the image to the running device's image manager;
+	// see SetMgmtLoad().
+	mgmtLoad *ImageUploadOptions
+
+	// Also program EEPROM and OTP areas when loading; see SetProgramAreas().
+	programAreas bool
+
 	res *resolve.Resolution
+
+	// Loader / app pairing of a split build; see recordSplitState().
+	splitState *SplitState
 }
 
 func NewTargetTester(target *target.Target,
@@ -93,6 +170,10 @@ func NewTargetTester(target *target.Target,
 		injectedSettings: map[string]string{},
 	}
 
+	if err := t.EnableSanitizers(target.Sanitizers()); err != nil {
+		return nil, err
+	}
+
 	return t, nil
 }
 
@@ -100,15 +181,64 @@ func NewTargetBuilder(target *target.Target) (*TargetBuilder, error) {
 	return NewTargetTester(target, nil)
 }
 
+// Returns the flags a project-defined build profile adds to the compiler
+// package's.
+func buildProfileCompilerInfo(
+	p *project.BuildProfile) *toolchain.CompilerInfo {
+
+	ci := toolchain.NewCompilerInfo()
+	ci.Cflags = p.AllCflags()
+	ci.Lflags = p.Lflags
+	ci.Aflags = p.Aflags
+	return ci
+}
+
 func (t *TargetBuilder) NewCompiler(dstDir string) (
 	*toolchain.Compiler, error) {
 
+	proj := project.GetProject()
 	c, err := toolchain.NewCompiler(
 		t.compilerPkg.BasePath(),
 		dstDir,
-		t.target.BuildProfile)
+		proj.CompilerBuildProfile(t.target.BuildProfile))
+	if err != nil {
+		return nil, err
+	}
+
+	// The profile's flags are part of each command, so changing them
+	// triggers a rebuild.
+	if p := proj.BuildProfile(t.target.BuildProfile); p != nil {
+		c.AddInfo(buildProfileCompilerInfo(p))
+	}
+
+	if t.coverage {
+		c.AddInfo(coverageCompilerInfo())
+	}
+	if len(t.sanitizers) > 0 {
+		c.AddInfo(sanitizerCompilerInfo(t.sanitizers))
+	}
+	if t.stackUsage {
+		c.AddInfo(stackUsageCompilerInfo())
+	}
+	if t.incremental {
+		// The archives of a split build are rewritten with objcopy.
+		c.EnableIncremental(t.LoaderBuilder == nil)
+	}
+	if t.sharedObjects {
+		c.EnableObjStore(ObjStoreDir(), TargetBinDir(t.target.Name()))
+	}
+	if launcher := compilerLauncher(); len(launcher) > 0 {
+		c.SetLauncher(launcher)
+	}
+	c.SetCacheStats(t.cacheStats)
+	c.SetEnv(t.target.Env())
+	c.SetCompileTimes(t.compileTimes)
+	if t.fuzz != nil {
+		c.SetCcPath(t.fuzz.Cc)
+		c.AddInfo(t.fuzzCompilerInfo())
+	}
 
-	return c, err
+	return c, nil
 }
 
 func (t *TargetBuilder) ensureResolved() error {
@@ -151,25 +281,63 @@ func (t *TargetBuilder) ensureResolved() error {
 		appSeeds = append(appSeeds, t.appPkg)
 	}
 
-	if t.testPkg != nil {
+	if t.fuzz != nil {
+		// FUZZ: lets packages know that they are being built for fuzzing.
+		t.injectedSettings["FUZZ"] = "1"
+
+		appSeeds = append(appSeeds, t.testPkg)
+	} else if t.testPkg != nil {
 		// A few features are automatically supported when the test command is
 		// used:
 		//     * TEST:      lets packages know that this is a test app
 		//     * SELFTEST:  indicates that the "newt test" command is used;
 		//                  causes a package to define a main() function.
+		//                  Not set for hardware tests, where the target's
+		//                  app provides main().
 		t.injectedSettings["TEST"] = "1"
-		t.injectedSettings["SELFTEST"] = "1"
+		if !t.hwTest {
+			t.injectedSettings["SELFTEST"] = "1"
+		}
 
 		appSeeds = append(appSeeds, t.testPkg)
 	}
 
+	start := time.Now()
+
 	var err error
 	t.res, err = resolve.ResolveFull(
-		loaderSeeds, appSeeds, t.injectedSettings, t.bspPkg.FlashMap)
+		loaderSeeds, appSeeds, t.injectedSettings, t.bspPkg.FlashMap,
+		t.target.ApiPreferences())
 	if err != nil {
+		return util.ClassifyError(err, util.ERR_CLASS_RESOLVE)
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE,
+		"Resolved %d packages in %s\n", len(t.res.MasterSet.Rpkgs),
+		time.Since(start))
+
+	return t.reportDeprecations()
+}
+
+// Warns about deprecated packages the target still uses.  With
+// --strict-deprecated, their use is an error instead.
+func (t *TargetBuilder) reportDeprecations() error {
+	text := strings.TrimSpace(t.res.DeprecationText())
+	if text == "" {
+		return nil
+	}
+
+	lines := strings.Split(text, "\n")
+	if newtutil.NewtStrictDeprecated {
+		err := util.FmtNewtError("Target %s uses deprecated packages:\n"+
+			"    %s", t.target.FullName(), strings.Join(lines, "\n    "))
+		t.res = nil
 		return err
 	}
 
+	for _, line := range lines {
+		util.ErrorMessage(util.VERBOSITY_QUIET, "* Warning: %s\n", line)
+	}
 	return nil
 }
 
@@ -187,7 +355,7 @@ func (t *TargetBuilder) validateAndWriteCfg() error {
 	}
 
 	if errText := t.res.ErrorText(); errText != "" {
-		return util.NewNewtError(errText)
+		return util.NewNewtError(errText).WithClass(util.ERR_CLASS_RESOLVE)
 	}
 
 	warningText := strings.TrimSpace(t.res.WarningText())
@@ -197,6 +365,11 @@ func (t *TargetBuilder) validateAndWriteCfg() error {
 		}
 	}
 
+	// These would otherwise only show up as runtime asserts.
+	if bleText := t.res.Cfg.BleWarningText(); bleText != "" {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "WARNING: %s", bleText)
+	}
+
 	if err := syscfg.EnsureWritten(t.res.Cfg,
 		GeneratedIncludeDir(t.target.Name())); err != nil {
 
@@ -215,22 +388,107 @@ func (t *TargetBuilder) generateSysinit() error {
 
 	if t.res.LoaderSet != nil {
 		lpkgs := resolve.RpkgSliceToLpkgSlice(t.res.LoaderSet.Rpkgs)
-		sysinit.EnsureWritten(lpkgs, srcDir,
-			pkg.ShortName(t.target.Package()), true)
+		if err := sysinit.EnsureWritten(lpkgs, srcDir,
+			pkg.ShortName(t.target.Package()), true); err != nil {
+
+			return err
+		}
 	}
 
 	lpkgs := resolve.RpkgSliceToLpkgSlice(t.res.AppSet.Rpkgs)
-	sysinit.EnsureWritten(lpkgs, srcDir,
-		pkg.ShortName(t.target.Package()), false)
+	if err := sysinit.EnsureWritten(lpkgs, srcDir,
+		pkg.ShortName(t.target.Package()), false); err != nil {
+
+		return err
+	}
 
 	return nil
 }
 
 func (t *TargetBuilder) generateFlashMap() error {
-	return t.bspPkg.FlashMap.EnsureWritten(
+	if err := t.bspPkg.FlashMap.EnsureWritten(
 		GeneratedSrcDir(t.target.Name()),
 		GeneratedIncludeDir(t.target.Name()),
-		pkg.ShortName(t.target.Package()))
+		pkg.ShortName(t.target.Package())); err != nil {
+
+		return err
+	}
+
+	if err := t.bspPkg.FlashMap.EnsureProgramDescsWritten(
+		GeneratedProgramDescPath(t.target.Name())); err != nil {
+
+		return err
+	}
+
+	if len(t.bspPkg.MemoryRegions) > 0 {
+		if err := t.bspPkg.FlashMap.EnsureLinkerScriptWritten(
+			GeneratedLinkerScriptPath(t.target.Name()),
+			t.bspPkg.MemoryRegions); err != nil {
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the paths of all generated linker script fragments.  These are not
+// passed to the linker directly; the BSP's linker scripts include them.
+func (t *TargetBuilder) generatedLinkerScripts() []string {
+	if len(t.bspPkg.MemoryRegions) == 0 {
+		return nil
+	}
+
+	return []string{GeneratedLinkerScriptPath(t.target.Name())}
+}
+
+// Generates the GATT service tables declared by the builder's packages.
+// Each package's generated source is compiled as part of the package, with
+// its include paths.
+func (t *TargetBuilder) generateGatt(b *Builder) error {
+	tracker := toolchain.NewDepTracker(nil)
+	owners := map[string]*BuildPackage{}
+
+	for _, bpkg := range b.sortedBuildPackages() {
+		lpkg := bpkg.rpkg.Lpkg
+		g, err := gatt.Read(lpkg)
+		if err != nil {
+			return err
+		}
+		if g == nil {
+			continue
+		}
+
+		if other := owners[g.Name]; other != nil {
+			return util.FmtNewtError("Packages %s and %s both declare "+
+				"GATT services named \"%s\"; set %s.name in one of them",
+				other.rpkg.Lpkg.FullName(), lpkg.FullName(), g.Name,
+				gatt.GATT_YAML_KEY)
+		}
+		owners[g.Name] = bpkg
+
+		srcDir := GeneratedGattSrcDir(t.target.Name(), g.Name)
+		inclDir := GeneratedIncludeDir(t.target.Name())
+
+		genReqd, err := tracker.GenRequired(
+			[]string{
+				filepath.Join(srcDir, g.SrcName()),
+				filepath.Join(inclDir, g.HeaderName()),
+			},
+			[]string{filepath.Join(lpkg.BasePath(), pkg.PACKAGE_FILE_NAME)})
+		if err != nil {
+			return err
+		}
+		if genReqd {
+			if err := g.EnsureWritten(srcDir, inclDir); err != nil {
+				return err
+			}
+		}
+
+		bpkg.extraSrcDirs = append(bpkg.extraSrcDirs, srcDir)
+	}
+
+	return nil
 }
 
 func (t *TargetBuilder) generateCode() error {
@@ -242,10 +500,22 @@ func (t *TargetBuilder) generateCode() error {
 		return err
 	}
 
+	if t.LoaderBuilder != nil {
+		if err := t.generateGatt(t.LoaderBuilder); err != nil {
+			return err
+		}
+	}
+	if err := t.generateGatt(t.AppBuilder); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (t *TargetBuilder) PrepBuild() error {
+	prepBuildMutex.Lock()
+	defer prepBuildMutex.Unlock()
+
 	if err := t.ensureResolved(); err != nil {
 		return err
 	}
@@ -325,8 +595,18 @@ func (t *TargetBuilder) buildLoader() error {
 		return err
 	}
 
-	/* re-link the loader with app dependencies */
-	err, commonPkgs, commonSyms := t.RelinkLoader()
+	prevSplit, err := ReadSplitState(t.target.Name())
+	if err != nil {
+		return err
+	}
+	inputHash, err := t.splitInputHash()
+	if err != nil {
+		return err
+	}
+
+	/* re-link the loader with app dependencies, unless the symbol list
+	 * from the previous build is still valid */
+	commonPkgs, commonSyms, err := t.splitSymbols(prevSplit, inputHash)
 	if err != nil {
 		return err
 	}
@@ -335,6 +615,8 @@ func (t *TargetBuilder) buildLoader() error {
 	delete(commonPkgs, t.bspPkg.Name())
 	t.AppBuilder.RemovePackages(commonPkgs)
 
+	split := t.newSplitState(prevSplit, inputHash, commonPkgs, commonSyms)
+
 	/* create the special elf to link the app against */
 	/* its just the elf with a set of symbols removed and renamed */
 	err = t.LoaderBuilder.buildRomElf(commonSyms)
@@ -342,6 +624,14 @@ func (t *TargetBuilder) buildLoader() error {
 		return err
 	}
 
+	if err := t.checkRomVersion(); err != nil {
+		return err
+	}
+
+	if err := t.recordSplitState(split); err != nil {
+		return err
+	}
+
 	/* set up the linker elf and linker script for the app */
 	t.AppBuilder.linkElf = t.LoaderBuilder.AppLinkerElfPath()
 
@@ -349,10 +639,44 @@ func (t *TargetBuilder) buildLoader() error {
 
 }
 
-func (t *TargetBuilder) Build() error {
+func (t *TargetBuilder) Build() (err error) {
+	start := time.Now()
+	newtutil.EmitEvent(newtutil.EVENT_BUILD_START, map[string]interface{}{
+		"target": t.target.Name(),
+	})
+	t.startCacheStats()
+	t.startCompileTimes()
+	defer func() {
+		t.reportCacheStats()
+		t.recordCompileTimes()
+
+		fields := map[string]interface{}{
+			"target":       t.target.Name(),
+			"success":      err == nil,
+			"duration_sec": time.Since(start).Seconds(),
+		}
+		if err != nil {
+			fields["error"] = err.Error()
+		}
+		newtutil.EmitEvent(newtutil.EVENT_BUILD_END, fields)
+	}()
+
+	if err := t.runHook(HOOK_PRE_BUILD, nil); err != nil {
+		return err
+	}
+
 	if err := t.PrepBuild(); err != nil {
 		return err
 	}
+	if t.stopsAfter(BUILD_STAGE_GENERATE) {
+		return nil
+	}
+
+	if t.incremental {
+		if err := t.warnIncremental(); err != nil {
+			return err
+		}
+	}
 
 	/* Build the Apps */
 	project.ResetDeps(t.AppList)
@@ -365,6 +689,25 @@ func (t *TargetBuilder) Build() error {
 		return err
 	}
 
+	if t.stopsAfter(BUILD_STAGE_ARCHIVE) {
+		/* The loader of a split image is normally built after the app's
+		 * tentative link; build it without linking anything. */
+		if t.LoaderBuilder != nil {
+			project.ResetDeps(t.LoaderList)
+
+			if err := t.bspPkg.Reload(
+				t.LoaderBuilder.cfg.Features()); err != nil {
+
+				return err
+			}
+			if err := t.LoaderBuilder.Build(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
 	var linkerScripts []string
 	if t.LoaderBuilder == nil {
 		linkerScripts = t.bspPkg.LinkerScripts
@@ -379,15 +722,59 @@ func (t *TargetBuilder) Build() error {
 	if err := t.AppBuilder.Link(linkerScripts); err != nil {
 		return err
 	}
+	if err := t.recordSplitLink(); err != nil {
+		return err
+	}
+	if err := t.writeGdbInits(); err != nil {
+		return err
+	}
+	if t.stopsAfter(BUILD_STAGE_LINK) {
+		return nil
+	}
+
+	if err := t.checkBudgets(); err != nil {
+		return err
+	}
+
+	if err := t.runHook(HOOK_POST_LINK, nil); err != nil {
+		return err
+	}
 
 	/* Create manifest. */
 	if err := t.createManifest(); err != nil {
 		return err
 	}
 
+	t.emitSizeEvents()
+	t.recordHistory()
+
 	return nil
 }
 
+// Reports the size of each linked image to the event log.  Sizes are not
+// available for all targets (e.g., sim), so failures are only logged.
+func (t *TargetBuilder) emitSizeEvents() {
+	if !newtutil.EventLogEnabled() || t.bspPkg.Arch == "sim" {
+		return
+	}
+
+	for _, b := range t.builders() {
+		summary, err := b.SizeSummary(SizeOptions{})
+		if err != nil {
+			log.Debugf("Failed to calculate %s size: %s", b.buildName,
+				err.Error())
+			continue
+		}
+
+		newtutil.EmitEvent(newtutil.EVENT_SIZE, map[string]interface{}{
+			"target":   t.target.Name(),
+			"build":    summary.Name,
+			"sections": summary.Sections,
+			"packages": summary.Packages,
+		})
+	}
+}
+
 /*
  * This function re-links the loader adding symbols from libraries
  * shared with the app. Returns a list of the common packages shared
@@ -495,12 +882,23 @@ func (t *TargetBuilder) RelinkLoader() (error, map[string]bool,
 			if _, ok := commonPkgs[libsym.Bpkg]; ok {
 				/* if its not in the loader elf, add it as undefined */
 				if _, ok := (*loaderElfSym)[name]; !ok {
+					elfsym.Bpkg = libsym.Bpkg
 					preserveElf.Add(elfsym)
 				}
 			}
 		}
 	}
 
+	/* optionally only keep what the app's own packages reference */
+	if t.loaderKeep == target.TARGET_LOADER_KEEP_REFERENCED {
+		refs, err := t.AppBuilder.referencedSymbols(commonPkgs)
+		if err != nil {
+			return err, nil, nil
+		}
+		preserveElf = filterKeepSymbols(preserveElf, refs)
+	}
+	t.loaderKeepSyms = preserveElf
+
 	/* re-link loader */
 	project.ResetDeps(t.LoaderList)
 
@@ -515,6 +913,31 @@ func (t *TargetBuilder) RelinkLoader() (error, map[string]bool,
 	return err, commonPkgs, smMatch
 }
 
+// Returns the command that compiles the specified source file for this
+// target.  The file must belong to a package in the app or loader image.
+func (t *TargetBuilder) CompileCmd(srcPath string) ([]string, error) {
+	if err := t.PrepBuild(); err != nil {
+		return nil, err
+	}
+
+	for _, b := range []*Builder{t.AppBuilder, t.LoaderBuilder} {
+		if b == nil {
+			continue
+		}
+
+		cmd, err := b.CompileCmd(srcPath)
+		if err != nil {
+			return nil, err
+		}
+		if cmd != nil {
+			return cmd, nil
+		}
+	}
+
+	return nil, util.FmtNewtError("File %s is not part of target %s",
+		srcPath, t.target.FullName())
+}
+
 func (t *TargetBuilder) GetTarget() *target.Target {
 	return t.target
 }
@@ -563,7 +986,7 @@ func (t *TargetBuilder) createManifest() error {
 
 	manifest.Repos = rm.AllRepos()
 
-	vars := t.GetTarget().Vars
+	vars := t.GetTarget().EffectiveVars()
 	keys := make([]string, 0, len(vars))
 	for k := range vars {
 		keys = append(keys, k)
@@ -572,7 +995,22 @@ func (t *TargetBuilder) createManifest() error {
 	for _, k := range keys {
 		manifest.TgtVars = append(manifest.TgtVars, k+"="+vars[k])
 	}
-	syscfgKV := t.GetTarget().Package().SyscfgV.GetStringMapString("syscfg.vals")
+	manifest.Env = t.GetTarget().Env()
+
+	if p := project.GetProject().BuildProfile(
+		t.GetTarget().BuildProfile); p != nil {
+
+		manifest.BuildProfile = &image.ImageManifestProfile{
+			Name:    p.Name,
+			Base:    p.Base,
+			Cflags:  p.Cflags,
+			Lflags:  p.Lflags,
+			Aflags:  p.Aflags,
+			Defines: p.Defines,
+		}
+	}
+
+	syscfgKV := t.GetTarget().SyscfgVals()
 	if len(syscfgKV) > 0 {
 		tgtSyscfg := fmt.Sprintf("target.syscfg=%s",
 			syscfg.KeyValueToStr(syscfgKV))
@@ -768,6 +1206,11 @@ func (t *TargetBuilder) verifyImgSizes(li *image.Image, ai *image.Image) error {
 func (t *TargetBuilder) CreateImages(version string,
 	keystr string, keyId uint8) (*image.Image, *image.Image, error) {
 
+	// Reject bad image settings before spending time on the build.
+	if _, err := t.ImageLayout(); err != nil {
+		return nil, nil, util.ClassifyError(err, util.ERR_CLASS_IMAGE)
+	}
+
 	if err := t.Build(); err != nil {
 		return nil, nil, err
 	}
@@ -783,41 +1226,39 @@ func (t *TargetBuilder) CreateImages(version string,
 
 	if t.LoaderBuilder != nil {
 		loaderImg, err = t.LoaderBuilder.CreateImage(version, keystr, keyId,
-			nil)
+			nil, flash.FLASH_AREA_NAME_IMAGE_0)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, util.ClassifyError(err, util.ERR_CLASS_IMAGE)
 		}
 		tgtArea := t.bspPkg.FlashMap.Areas[flash.FLASH_AREA_NAME_IMAGE_0]
-		log.Debugf("Convert %s -> %s at offset 0x%x",
+		tgtAddr := t.bspPkg.FlashMap.AreaAddress(tgtArea)
+		log.Debugf("Convert %s -> %s at address 0x%x",
 			t.LoaderBuilder.AppImgPath(),
 			t.LoaderBuilder.AppHexPath(),
-			tgtArea.Offset)
+			tgtAddr)
 		err = c.ConvertBinToHex(t.LoaderBuilder.AppImgPath(),
-				t.LoaderBuilder.AppHexPath(), tgtArea.Offset)
+				t.LoaderBuilder.AppHexPath(), tgtAddr)
 		if err != nil {
 			log.Errorf("Can't convert to hexfile %s\n", err.Error())
 		}
 	}
 
-	appImg, err = t.AppBuilder.CreateImage(version, keystr, keyId, loaderImg)
+	flashTargetArea := t.appImageArea()
+	appImg, err = t.AppBuilder.CreateImage(version, keystr, keyId, loaderImg,
+		flashTargetArea)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, util.ClassifyError(err, util.ERR_CLASS_IMAGE)
 	}
 
-	flashTargetArea := ""
-	if t.LoaderBuilder == nil {
-		flashTargetArea = flash.FLASH_AREA_NAME_IMAGE_0
-	} else  {
-		flashTargetArea = flash.FLASH_AREA_NAME_IMAGE_1
-	}
 	tgtArea := t.bspPkg.FlashMap.Areas[flashTargetArea]
 	if tgtArea.Name != "" {
-		log.Debugf("Convert %s -> %s at offset 0x%x",
+		tgtAddr := t.bspPkg.FlashMap.AreaAddress(tgtArea)
+		log.Debugf("Convert %s -> %s at address 0x%x",
 			t.AppBuilder.AppImgPath(),
 			t.AppBuilder.AppHexPath(),
-			tgtArea.Offset)
+			tgtAddr)
 		err = c.ConvertBinToHex(t.AppBuilder.AppImgPath(),
-				t.AppBuilder.AppHexPath(), tgtArea.Offset)
+				t.AppBuilder.AppHexPath(), tgtAddr)
 		if err != nil {
 			log.Errorf("Can't convert to hexfile %s\n", err.Error())
 		}
@@ -828,6 +1269,16 @@ func (t *TargetBuilder) CreateImages(version string,
 	}
 
 	if err := t.verifyImgSizes(loaderImg, appImg); err != nil {
+		return nil, nil, util.ClassifyError(err, util.ERR_CLASS_IMAGE)
+	}
+
+	if err := t.nameArtifacts(appImg.Version.String()); err != nil {
+		return nil, nil, err
+	}
+
+	imgEnv := append(imageHookEnv("NEWT_IMAGE", appImg),
+		imageHookEnv("NEWT_LOADER_IMAGE", loaderImg)...)
+	if err := t.runHook(HOOK_POST_CREATE_IMAGE, imgEnv); err != nil {
 		return nil, nil, err
 	}
 
@@ -849,3 +1300,28 @@ func (t *TargetBuilder) CreateRevdepGraph() (DepGraph, error) {
 
 	return revdepGraph(t.res.MasterSet)
 }
+
+// Returns the value of a syscfg setting, or false if it is undefined or
+// empty.  Requires a prior call to PrepBuild().
+func (t *TargetBuilder) settingValue(name string) (string, bool) {
+	entry, ok := t.res.Cfg.Settings[name]
+	if !ok || entry.Value == "" {
+		return "", false
+	}
+	return entry.Value, true
+}
+
+// Returns the value of an integer syscfg setting, or false if it is
+// undefined or not an integer.
+func (t *TargetBuilder) intSetting(name string) (int, bool) {
+	val, ok := t.settingValue(name)
+	if !ok {
+		return 0, false
+	}
+
+	n, err := util.AtoiNoOct(val)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,227 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Outcome of a single test case, as reported by the test binary.
+type TestCaseResult struct {
+	Name    string
+	Passed  bool
+	Message string
+}
+
+// Outcome of running a unit test executable.
+type TestExeResult struct {
+	// Number of times the executable was run.
+	Attempts int
+
+	// Whether the last attempt was killed for exceeding the timeout.
+	TimedOut bool
+
+	Duration time.Duration
+
+	// Combined stdout and stderr of the last attempt.
+	Output []byte
+
+	// Individual test cases reported by the last attempt.
+	Cases []TestCaseResult
+
+	// Nil if the last attempt passed.
+	Err error
+}
+
+// Matches the per-case status lines printed by testutil, e.g.,
+// "[pass] os_mutex_test_suite/os_mutex_test_basic" or
+// "[FAIL] os_sem_test_suite/os_sem_test_case {test_sem.c:55} ...".
+var testCaseRe = regexp.MustCompile(`^\[(pass|FAIL)\] (\S+)\s*(.*)$`)
+
+// Extracts the individual test case results from a test binary's output.
+func ParseTestCases(output []byte) []TestCaseResult {
+	cases := []TestCaseResult{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		m := testCaseRe.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if m == nil {
+			continue
+		}
+
+		cases = append(cases, TestCaseResult{
+			Name:    m[2],
+			Passed:  m[1] == "pass",
+			Message: m[3],
+		})
+	}
+
+	return cases
+}
+
+// Environment variable through which the --filter expression of `newt test`
+// is passed to test executables, so that a test framework supporting it can
+// skip the other cases.
+const TEST_FILTER_ENV = "NEWT_TEST_FILTER"
+
+// Restricts a result to the test cases whose names match the specified
+// expression.  A run that failed only because of cases outside the filter is
+// considered successful; timeouts and failures not attributable to any case
+// (e.g., a crash) are kept.
+func (res *TestExeResult) Filter(re *regexp.Regexp) {
+	if re == nil || len(res.Cases) == 0 {
+		return
+	}
+
+	caseFailed := false
+	matchFailed := false
+	cases := []TestCaseResult{}
+	for _, c := range res.Cases {
+		if !c.Passed {
+			caseFailed = true
+		}
+		if re.MatchString(c.Name) {
+			cases = append(cases, c)
+			if !c.Passed {
+				matchFailed = true
+			}
+		}
+	}
+	res.Cases = cases
+
+	if res.Err != nil && !res.TimedOut && caseFailed && !matchFailed {
+		res.Err = nil
+	}
+}
+
+// Runs a unit test executable once from within its own directory, adding env
+// to newt's environment.  If timeout is nonzero, the process is killed once it
+// has run for that long.
+func runTestExeOnce(exePath string, env []string,
+	timeout time.Duration) TestExeResult {
+
+	cmdStr := strings.TrimSpace(strings.Join(env, " ") + " " + exePath)
+	log.Debugf("%s", cmdStr)
+	if util.PrintShellCmds {
+		util.StatusMessage(util.VERBOSITY_SILENT, "%s\n", cmdStr)
+	}
+
+	cmd := exec.Command(exePath)
+	cmd.Dir = filepath.Dir(exePath)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if timeout > 0 {
+		// Run the test in its own process group so that a leftover child
+		// still holding the output pipe gets killed along with it;
+		// otherwise, the wait for output would keep a timed out test alive.
+		cmd.SysProcAttr = probeSysProcAttr()
+	}
+
+	start := time.Now()
+	err := cmd.Start()
+
+	var timedOut int32
+	if err == nil {
+		if timeout > 0 {
+			timer := time.AfterFunc(timeout, func() {
+				atomic.StoreInt32(&timedOut, 1)
+				util.KillProcessTree(cmd.Process)
+			})
+			defer timer.Stop()
+		}
+
+		err = cmd.Wait()
+	}
+
+	o := out.Bytes()
+	res := TestExeResult{
+		Duration: time.Since(start),
+		Output:   o,
+		Cases:    ParseTestCases(o),
+	}
+
+	if atomic.LoadInt32(&timedOut) != 0 {
+		res.TimedOut = true
+		res.Err = util.FmtNewtError("Test timed out after %s", timeout)
+	} else if err != nil {
+		res.Err = util.NewNewtError(err.Error())
+	}
+
+	return res
+}
+
+// Runs a unit test executable, rerunning it up to `retries` additional times
+// if it fails.  If filter is non-nil, only the test cases matching it are
+// considered.  The result of the last attempt is returned.
+func RunTestExe(exePath string, env []string, timeout time.Duration,
+	retries int, filter *regexp.Regexp) TestExeResult {
+
+	if filter != nil {
+		env = append(append([]string{}, env...),
+			TEST_FILTER_ENV+"="+filter.String())
+	}
+
+	var res TestExeResult
+	for attempt := 1; attempt <= retries+1; attempt++ {
+		res = runTestExeOnce(exePath, env, timeout)
+		res.Filter(filter)
+		res.Attempts = attempt
+		if res.Err == nil {
+			break
+		}
+
+		log.Debugf("Test %s failed (attempt %d): %s", exePath, attempt,
+			res.Err.Error())
+	}
+
+	return res
+}
+
+// Returns the path of the test executable produced by SelfTestCreateExe.
+func (t *TargetBuilder) SelfTestExePath() (string, error) {
+	testRpkg, err := t.getTestRpkg()
+	if err != nil {
+		return "", err
+	}
+
+	testBpkg, err := t.AppBuilder.getTestBpkg(testRpkg)
+	if err != nil {
+		return "", err
+	}
+
+	return t.AppBuilder.TestExePath(testBpkg), nil
+}
@@ -0,0 +1,147 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+func addrFrameString(f builder.AddrFrame) string {
+	if f.File == "" {
+		return f.Func
+	}
+	return fmt.Sprintf("%s at %s:%d", f.Func, f.File, f.Line)
+}
+
+func printAddrLocations(locs []*builder.AddrLocation) {
+	width := 0
+	for _, loc := range locs {
+		if len(loc.Label) > width {
+			width = len(loc.Label)
+		}
+	}
+
+	for _, loc := range locs {
+		prefix := fmt.Sprintf("0x%08x", loc.Addr)
+		if width > 0 {
+			prefix = fmt.Sprintf("%-*s %s", width, loc.Label, prefix)
+		}
+
+		if len(loc.Frames) == 0 {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "%s  ??\n", prefix)
+			continue
+		}
+
+		suffix := ""
+		if loc.Image == builder.BUILD_NAME_LOADER {
+			suffix = " [loader]"
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s  %s%s\n", prefix,
+			addrFrameString(loc.Frames[0]), suffix)
+		for _, f := range loc.Frames[1:] {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "%*s  inlined into "+
+				"%s\n", len(prefix), "", addrFrameString(f))
+		}
+	}
+}
+
+func addr2lineRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	TryGetProject()
+
+	b, err := TargetBuilderForTargetOrUnittest(args[0])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	text := strings.Join(args[1:], " ")
+	fromStdin := len(args) == 1 || (len(args) == 2 && args[1] == "-")
+	if fromStdin {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			NewtUsage(nil, util.ChildNewtError(err))
+		}
+		text = string(data)
+	}
+
+	addrs := builder.ParseCrashAddrs(text)
+	if len(addrs) == 0 {
+		NewtUsage(cmd, util.NewNewtError("No addresses specified"))
+	}
+
+	locs, err := b.Addr2Line(addrs)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	// Pasted text contains plenty of numbers that aren't code addresses.
+	if fromStdin {
+		inCode := []*builder.AddrLocation{}
+		for _, loc := range locs {
+			if loc.Image != "" {
+				inCode = append(inCode, loc)
+			}
+		}
+		locs = inCode
+	}
+
+	if newtutil.NewtJson {
+		printJson(locs)
+	} else {
+		printAddrLocations(locs)
+	}
+}
+
+func AddAddr2LineCommands(cmd *cobra.Command) {
+	a2lHelpText := "Map code addresses to function names and source lines " +
+		"using the executables\nof <target-name>.  Addresses are given as " +
+		"arguments, or read from stdin if none\nare given (or \"-\"), so " +
+		"a fault dump can be pasted as printed on the console.\n" +
+		"Values read from stdin, and labeled values (e.g., " +
+		"\"pc:0x00008ca0\"), are only\nreported if they point into " +
+		"code.  With a split image, addresses in the loader\nare looked " +
+		"up in the loader's executable."
+	a2lHelpEx := "  newt addr2line my_target 0x8ca0 0x8c8d\n"
+	a2lHelpEx += "  newt addr2line my_target < fault.txt\n"
+
+	a2lCmd := &cobra.Command{
+		Use:     "addr2line <target-name> [<address>...]",
+		Short:   "Map crash addresses to source lines",
+		Long:    a2lHelpText,
+		Example: a2lHelpEx,
+		Run:     addr2lineRunCmd,
+	}
+
+	cmd.AddCommand(a2lCmd)
+	AddTabCompleteFn(a2lCmd, func() []string {
+		return append(targetList(), unittestList()...)
+	})
+}
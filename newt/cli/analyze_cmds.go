@@ -0,0 +1,403 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+var analyzeOpts builder.AnalyzeOptions
+var analyzeSarifPath string
+var analyzeByPkg bool
+var analyzeShowSuppressed bool
+
+// Subset of the SARIF 2.1.0 format understood by code review tools.
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			Uri string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region sarifRegion `json:"region"`
+	} `json:"physicalLocation"`
+}
+
+type sarifSuppression struct {
+	Kind          string `json:"kind"`
+	Justification string `json:"justification"`
+}
+
+type sarifResult struct {
+	RuleId       string             `json:"ruleId,omitempty"`
+	Level        string             `json:"level"`
+	Message      sarifMessage       `json:"message"`
+	Locations    []sarifLocation    `json:"locations"`
+	Suppressions []sarifSuppression `json:"suppressions,omitempty"`
+}
+
+type sarifRule struct {
+	Id string `json:"id"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name  string      `json:"name"`
+			Rules []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+func writeSarif(path string, tool string, findings []builder.Finding) error {
+	projPath := TryGetProject().Path()
+
+	run := sarifRun{Results: []sarifResult{}}
+	run.Tool.Driver.Name = tool
+	run.Tool.Driver.Rules = []sarifRule{}
+
+	rules := map[string]bool{}
+	for _, f := range findings {
+		if f.Rule != "" && !rules[f.Rule] {
+			rules[f.Rule] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules,
+				sarifRule{Id: f.Rule})
+		}
+
+		// SARIF consumers expect paths relative to the repository root.
+		uri := f.File
+		if rel, err := filepath.Rel(projPath, f.File); err == nil &&
+			filepath.IsAbs(f.File) {
+
+			uri = rel
+		}
+
+		loc := sarifLocation{}
+		loc.PhysicalLocation.ArtifactLocation.Uri = filepath.ToSlash(uri)
+		loc.PhysicalLocation.Region = sarifRegion{
+			StartLine:   f.Line,
+			StartColumn: f.Column,
+		}
+
+		result := sarifResult{
+			RuleId:    f.Rule,
+			Level:     f.Severity,
+			Message:   sarifMessage{Text: f.Message},
+			Locations: []sarifLocation{loc},
+		}
+		if f.Suppression != nil {
+			result.Suppressions = []sarifSuppression{{
+				Kind:          "external",
+				Justification: f.Suppression.Justification,
+			}}
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+
+	data, err := json.MarshalIndent(log, "", "    ")
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+func printFindings(findings []builder.Finding) {
+	severityColors := map[string]string{
+		toolchain.DIAG_SEVERITY_ERROR:   ANSI_RED,
+		toolchain.DIAG_SEVERITY_WARNING: ANSI_YELLOW,
+	}
+
+	for _, f := range findings {
+		if f.Suppression != nil && !analyzeShowSuppressed {
+			continue
+		}
+
+		loc := fmt.Sprintf("%s:%d", f.File, f.Line)
+		if f.Column != 0 {
+			loc += fmt.Sprintf(":%d", f.Column)
+		}
+
+		severity := f.Severity + ":"
+		if color, ok := severityColors[f.Severity]; ok {
+			severity = colorText(color, severity)
+		}
+
+		rule := ""
+		if f.Rule != "" {
+			rule = " [" + f.Rule + "]"
+		}
+		if f.Suppression != nil {
+			rule += " (suppressed by " + f.Suppression.Source + ")"
+		}
+
+		util.StatusMessage(util.VERBOSITY_QUIET, "%s: %s %s%s\n", loc,
+			severity, f.Message, rule)
+	}
+}
+
+type analyzePkgSummary struct {
+	Package    string
+	Errors     int
+	Warnings   int
+	Notes      int
+	Suppressed int
+}
+
+type analyzePkgSorter struct {
+	summaries []*analyzePkgSummary
+}
+
+func (s analyzePkgSorter) Len() int {
+	return len(s.summaries)
+}
+func (s analyzePkgSorter) Swap(i, j int) {
+	s.summaries[i], s.summaries[j] = s.summaries[j], s.summaries[i]
+}
+func (s analyzePkgSorter) Less(i, j int) bool {
+	return s.summaries[i].Package < s.summaries[j].Package
+}
+
+func summarizeFindings(findings []builder.Finding) []*analyzePkgSummary {
+	summaries := map[string]*analyzePkgSummary{}
+	for _, f := range findings {
+		s := summaries[f.Package]
+		if s == nil {
+			s = &analyzePkgSummary{Package: f.Package}
+			summaries[f.Package] = s
+		}
+
+		switch {
+		case f.Suppression != nil:
+			s.Suppressed++
+		case f.Severity == toolchain.DIAG_SEVERITY_ERROR:
+			s.Errors++
+		case f.Severity == toolchain.DIAG_SEVERITY_WARNING:
+			s.Warnings++
+		default:
+			s.Notes++
+		}
+	}
+
+	result := make([]*analyzePkgSummary, 0, len(summaries))
+	for _, s := range summaries {
+		result = append(result, s)
+	}
+	sort.Sort(analyzePkgSorter{result})
+
+	return result
+}
+
+func printPkgSummaries(summaries []*analyzePkgSummary) {
+	width := len("Package")
+	for _, s := range summaries {
+		if len(s.Package) > width {
+			width = len(s.Package)
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "%-*s %8s %8s %8s %10s\n",
+		width, "Package", "Errors", "Warnings", "Notes", "Suppressed")
+	for _, s := range summaries {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%-*s %8d %8d %8d %10d\n",
+			width, s.Package, s.Errors, s.Warnings, s.Notes, s.Suppressed)
+	}
+}
+
+// Resolves the analyzer named by --tool, which may be a checker declared in
+// project.yml.
+func resolveAnalyzer(proj *project.Project) {
+	if analyzeOpts.Tool == builder.ANALYZER_CLANG_TIDY ||
+		analyzeOpts.Tool == builder.ANALYZER_CPPCHECK {
+
+		return
+	}
+
+	if tmpl, ok := proj.Checkers()[analyzeOpts.Tool]; ok {
+		if strings.TrimSpace(tmpl) == "" {
+			NewtUsage(nil, util.FmtNewtError(
+				"Checker \"%s\" has an empty command", analyzeOpts.Tool))
+		}
+		analyzeOpts.Cmd = tmpl
+	}
+}
+
+func analyzeRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	proj := TryGetProject()
+	resolveAnalyzer(proj)
+
+	b, err := TargetBuilderForTargetOrUnittest(args[0])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	cmds, err := b.CompileCommands()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	res, err := builder.Analyze(cmds, analyzeOpts)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+	findings := res.Findings
+
+	if analyzeSarifPath != "" {
+		if err := writeSarif(analyzeSarifPath, analyzeOpts.Tool,
+			findings); err != nil {
+
+			NewtUsage(nil, err)
+		}
+	}
+
+	summaries := summarizeFindings(findings)
+	if newtutil.NewtJson {
+		if analyzeByPkg {
+			printJson(summaries)
+		} else {
+			printJson(findings)
+		}
+	} else {
+		printFindings(findings)
+		if analyzeByPkg {
+			printPkgSummaries(summaries)
+		}
+	}
+
+	for _, sup := range res.Unused {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"* Warning: %s: suppression of %s matches no findings\n",
+			sup.Source, sup.Rule)
+	}
+
+	errors := 0
+	warnings := 0
+	suppressed := 0
+	for _, s := range summaries {
+		errors += s.Errors
+		warnings += s.Warnings
+		suppressed += s.Suppressed
+	}
+
+	if !newtutil.NewtJson {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"%d error(s), %d warning(s), %d suppressed\n", errors, warnings,
+			suppressed)
+	}
+	if errors > 0 {
+		NewtUsage(nil, util.FmtNewtError("%s reported %d error(s)",
+			analyzeOpts.Tool, errors))
+	}
+}
+
+func AddAnalyzeCommands(cmd *cobra.Command) {
+	analyzeHelpText := "Run a static analyzer over each C and C++ source " +
+		"file of a target, using the same flags the file is compiled " +
+		"with.\n\nBy default, only packages in the project's own repo are " +
+		"analyzed and findings located in installed repos are dropped; " +
+		"use --all-pkgs to include them.  The command fails if the " +
+		"analyzer reports any unsuppressed errors.\n\n" +
+		"Besides clang-tidy and cppcheck, --tool accepts any checker " +
+		"declared in project.yml under project.checkers as " +
+		"<name>: <command>.  The command is run once per source file; " +
+		"{file} and {pkg} are replaced with the file and its package, and " +
+		"a word containing {includes}, {defines} or {flags} is repeated " +
+		"for each include directory, macro definition or compiler flag " +
+		"(e.g., -I{includes} -D{defines}).  Checkers must report " +
+		"problems as <file>:<line>[:<col>]: <severity>: <message> " +
+		"[<rule>].\n\n" +
+		"A package may deviate from rules by listing them in an " +
+		builder.SUPPRESS_FILENAME + " file in its directory, one " +
+		"\"<rule> [<file>[:<line>]] # <justification>\" per line; rules " +
+		"and files may be glob patterns.  Every entry must have a " +
+		"justification, and entries matching no findings are reported."
+	analyzeHelpEx := "  newt analyze my_target\n"
+	analyzeHelpEx += "  newt analyze my_target --tool cppcheck " +
+		"--sarif analysis.sarif\n"
+	analyzeHelpEx += "  newt analyze my_target --tool misra --by-pkg\n"
+
+	analyzeCmd := &cobra.Command{
+		Use:     "analyze <target-name>",
+		Short:   "Run a static analyzer over a target's sources",
+		Long:    analyzeHelpText,
+		Example: analyzeHelpEx,
+		Run:     analyzeRunCmd,
+	}
+
+	analyzeCmd.Flags().StringVarP(&analyzeOpts.Tool, "tool", "",
+		builder.ANALYZER_CLANG_TIDY, "Analyzer to run: clang-tidy, "+
+			"cppcheck or a checker from project.checkers")
+	analyzeCmd.Flags().StringVarP(&analyzeOpts.Path, "tool-path", "", "",
+		"Path of the analyzer executable")
+	analyzeCmd.Flags().StringSliceVarP(&analyzeOpts.Args, "args", "", nil,
+		"Additional arguments passed to the analyzer")
+	analyzeCmd.Flags().BoolVarP(&analyzeOpts.AllPkgs, "all-pkgs", "", false,
+		"Analyze packages from all repos, not just the project's own")
+	analyzeCmd.Flags().StringVarP(&analyzeSarifPath, "sarif", "", "",
+		"Write the findings to the specified file in SARIF format")
+	analyzeCmd.Flags().BoolVarP(&analyzeByPkg, "by-pkg", "", false,
+		"Summarize the findings per package")
+	analyzeCmd.Flags().BoolVarP(&analyzeShowSuppressed, "show-suppressed",
+		"", false, "Also list suppressed findings")
+
+	cmd.AddCommand(analyzeCmd)
+	AddTabCompleteFn(analyzeCmd, func() []string {
+		return append(targetList(), unittestList()...)
+	})
+}
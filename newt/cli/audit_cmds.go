@@ -0,0 +1,252 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/audit"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+func printLicenseReport(report *audit.LicenseReport) {
+	if report.Policy != nil {
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "Policy: %s\n",
+			report.Policy.Path)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Repositories:\n")
+	for _, rl := range report.Repos {
+		lic := rl.License
+		if lic == "" {
+			lic = "unknown"
+		}
+		file := rl.File
+		if file == "" {
+			file = "no license file"
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "    %s: %s (%s)\n",
+			rl.Repo, lic, file)
+	}
+
+	width := len("Package")
+	for _, pl := range report.Packages {
+		if len(pl.Package) > width {
+			width = len(pl.Package)
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "\n%-*s %-20s %-13s %s\n",
+		width, "Package", "License", "Source", "Status")
+	for _, pl := range report.Packages {
+		lic := pl.License
+		if lic == "" {
+			lic = "-"
+		}
+		src := pl.Source
+		if src == "" {
+			src = "-"
+		}
+
+		status := pl.Status
+		if status == audit.LICENSE_STATUS_OK {
+			status = colorText(ANSI_GREEN, status)
+		} else if status != audit.LICENSE_STATUS_EXEMPT {
+			status = colorText(ANSI_RED, status)
+		}
+		if pl.Reason != "" {
+			status += " (" + pl.Reason + ")"
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%-*s %-20s %-13s %s\n",
+			width, pl.Package, lic, src, status)
+		if pl.File != "" {
+			util.StatusMessage(util.VERBOSITY_VERBOSE, "    file: %s\n",
+				pl.File)
+		}
+	}
+}
+
+func auditLicensesRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	proj := TryGetProject()
+
+	b, err := TargetBuilderForTargetOrUnittest(args[0])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	res, err := b.Resolve()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	policy, err := audit.ReadLicensePolicy(proj.Path())
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	// Targets only hold configuration; they are not part of the image.
+	lpkgs := []*pkg.LocalPackage{}
+	for _, rpkg := range res.MasterSet.Rpkgs {
+		if rpkg.Lpkg.Type() != pkg.PACKAGE_TYPE_TARGET {
+			lpkgs = append(lpkgs, rpkg.Lpkg)
+		}
+	}
+
+	report := audit.AuditLicenses(lpkgs, policy, proj.Path())
+	if newtutil.NewtJson {
+		printJson(report)
+	} else {
+		printLicenseReport(report)
+	}
+
+	if report.Problems > 0 {
+		NewtUsage(nil, util.FmtNewtError(
+			"%d of %d packages failed the license audit",
+			report.Problems, len(report.Packages)))
+	}
+}
+
+func printEncodingReport(report *audit.EncodingReport) {
+	pkgName := ""
+	for _, ep := range report.Problems {
+		if ep.Package != pkgName {
+			pkgName = ep.Package
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "%s:\n", pkgName)
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "    %s: %s\n", ep.File,
+			ep.String())
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"%d of %d files have encoding problems\n", len(report.Problems),
+		report.Files)
+}
+
+func auditEncodingRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	proj := TryGetProject()
+
+	b, err := TargetBuilderForTargetOrUnittest(args[0])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	res, err := b.Resolve()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	lpkgs := make([]*pkg.LocalPackage, len(res.MasterSet.Rpkgs))
+	for i, rpkg := range res.MasterSet.Rpkgs {
+		lpkgs[i] = rpkg.Lpkg
+	}
+
+	report := audit.AuditEncoding(lpkgs, proj.Path())
+	if newtutil.NewtJson {
+		printJson(report)
+	} else {
+		printEncodingReport(report)
+	}
+
+	if len(report.Problems) > 0 {
+		NewtUsage(nil, util.FmtNewtError(
+			"%d files have a byte order mark or non-UTF-8 text",
+			len(report.Problems)))
+	}
+}
+
+func AddAuditCommands(cmd *cobra.Command) {
+	auditCmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Check the packages a target uses against project policy",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Usage()
+		},
+	}
+
+	cmd.AddCommand(auditCmd)
+
+	licensesHelpText := "Report the license of every package the target " +
+		"uses.  A package's license is the SPDX expression in its " +
+		"pkg.license field; otherwise, it is identified from a LICENSE " +
+		"or COPYING file in the package directory, or failing that, in " +
+		"the root of the package's repository.\n\n" +
+		"If the project contains a " + audit.LICENSE_POLICY_FILENAME +
+		" file, each license is checked against it:\n\n" +
+		"    license.allowed:\n" +
+		"        - Apache-2.0\n" +
+		"        - BSD-3-Clause\n" +
+		"    license.denied:\n" +
+		"        - GPL-3.0-only\n" +
+		"    license.require_file: true\n" +
+		"    license.exceptions:\n" +
+		"        \"@vendor/hw/drivers/blob\": \"Vendor agreement\"\n\n" +
+		"The command fails if any package has no recognizable license, " +
+		"a denied or unlisted license, or (with license.require_file) no " +
+		"license file.  Packages listed under license.exceptions are " +
+		"not checked."
+	licensesHelpEx := "  newt audit licenses my_target\n"
+	licensesHelpEx += "  newt audit licenses my_target --json\n"
+
+	licensesCmd := &cobra.Command{
+		Use:     "licenses <target-name>",
+		Short:   "Audit the licenses of a target's packages",
+		Long:    licensesHelpText,
+		Example: licensesHelpEx,
+		Run:     auditLicensesRunCmd,
+	}
+
+	auditCmd.AddCommand(licensesCmd)
+	AddTabCompleteFn(licensesCmd, func() []string {
+		return append(targetList(), unittestList()...)
+	})
+
+	encodingHelpText := "Report the source, header, linker script and " +
+		"YAML files of the target's packages that start with a UTF-8 " +
+		"byte order mark or contain text that isn't valid UTF-8 (usually " +
+		"Windows-1252 characters from vendor SDKs).\n\n" +
+		"newt reads such files as UTF-8 or Windows-1252, but other " +
+		"line-based tools may not.  The command fails if any file has " +
+		"a problem."
+	encodingHelpEx := "  newt audit encoding my_target\n"
+	encodingHelpEx += "  newt audit encoding my_target --json\n"
+
+	encodingCmd := &cobra.Command{
+		Use:     "encoding <target-name>",
+		Short:   "Find files of a target's packages that aren't plain UTF-8",
+		Long:    encodingHelpText,
+		Example: encodingHelpEx,
+		Run:     auditEncodingRunCmd,
+	}
+
+	auditCmd.AddCommand(encodingCmd)
+	AddTabCompleteFn(encodingCmd, func() []string {
+		return append(targetList(), unittestList()...)
+	})
+}
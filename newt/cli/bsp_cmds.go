@@ -0,0 +1,209 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/flash"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+type bspInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Mcu         string   `json:"mcu,omitempty"`
+	Arch        string   `json:"arch,omitempty"`
+	Compiler    string   `json:"compiler,omitempty"`
+	FlashSize   int      `json:"flash_size"`
+	RamSize     int      `json:"ram_size"`
+	Features    []string `json:"features"`
+	Emulators   []string `json:"emulators,omitempty"`
+	Probes      []string `json:"probes,omitempty"`
+	SerialLoad  string   `json:"serial_load,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// Returns the MCU package a BSP depends on, or "" if none of its
+// dependencies is under an mcu directory.
+func bspMcu(lpkg *pkg.LocalPackage) string {
+	deps := newtutil.GetStringSliceFeatures(lpkg.PkgV, nil, "pkg.deps")
+	for _, depStr := range deps {
+		dep, err := pkg.NewDependency(lpkg.Repo(), depStr)
+		if err != nil {
+			continue
+		}
+		if strings.Contains("/"+dep.Name+"/", "/mcu/") {
+			return dep.String()
+		}
+	}
+
+	return ""
+}
+
+// Returns the total size of a BSP's memory regions whose names contain the
+// specified string (e.g., "RAM" matches RAM and RAM2).
+func bspRegionSize(regions []flash.MemoryRegion, kind string) int {
+	size := 0
+	for _, r := range regions {
+		if strings.Contains(strings.ToUpper(r.Name), kind) {
+			size += r.Length
+		}
+	}
+
+	return size
+}
+
+func newBspInfo(lpkg *pkg.LocalPackage) bspInfo {
+	info := bspInfo{
+		Name:        lpkg.FullName(),
+		Description: lpkg.Desc().Description,
+		Mcu:         bspMcu(lpkg),
+		Features:    []string{},
+	}
+
+	bsp, err := pkg.NewBspPackage(lpkg)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+
+	info.Arch = bsp.Arch
+	info.Compiler = bsp.CompilerName
+	if bsp.Features != nil {
+		info.Features = bsp.Features
+	}
+
+	info.FlashSize = bspRegionSize(bsp.MemoryRegions, "FLASH")
+	if info.FlashSize == 0 {
+		// Fall back to the internal flash device's size.
+		info.FlashSize = bsp.FlashMap.Devices[0].Size
+	}
+	info.RamSize = bspRegionSize(bsp.MemoryRegions, "RAM")
+
+	for name, _ := range bsp.Emulators {
+		info.Emulators = append(info.Emulators, name)
+	}
+	sort.Strings(info.Emulators)
+
+	for name, _ := range bsp.Probes {
+		info.Probes = append(info.Probes, name)
+	}
+	sort.Strings(info.Probes)
+
+	if bsp.SerialLoad != nil {
+		info.SerialLoad = bsp.SerialLoad.Protocol
+	}
+
+	return info
+}
+
+func sizeKb(size int) string {
+	if size == 0 {
+		return "?"
+	}
+	return fmt.Sprintf("%dK", size/1024)
+}
+
+func bspListRunCmd(cmd *cobra.Command, args []string) {
+	proj := TryGetProject()
+
+	lpkgs := []*pkg.LocalPackage{}
+	for _, pack := range proj.PackagesOfType(pkg.PACKAGE_TYPE_BSP) {
+		lpkgs = append(lpkgs, pack.(*pkg.LocalPackage))
+	}
+	lpkgs = pkg.SortLclPkgs(lpkgs)
+
+	infos := make([]bspInfo, len(lpkgs))
+	for i, lpkg := range lpkgs {
+		infos[i] = newBspInfo(lpkg)
+	}
+
+	if newtutil.NewtJson {
+		printJson(infos)
+		return
+	}
+
+	width := len("BSP")
+	for _, info := range infos {
+		if len(info.Name) > width {
+			width = len(info.Name)
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "%-*s  %-12s %7s %7s  %s\n",
+		width, "BSP", "Arch", "Flash", "RAM", "MCU")
+	for _, info := range infos {
+		if info.Error != "" {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "%-*s  (error: %s)\n",
+				width, info.Name, info.Error)
+			continue
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"%-*s  %-12s %7s %7s  %s\n", width, info.Name, info.Arch,
+			sizeKb(info.FlashSize), sizeKb(info.RamSize), info.Mcu)
+		if len(info.Features) > 0 {
+			util.StatusMessage(util.VERBOSITY_VERBOSE, "%*s  features: %s\n",
+				width, "", strings.Join(info.Features, ", "))
+		}
+	}
+}
+
+func AddBspCommands(cmd *cobra.Command) {
+	bspHelpText := "Commands for querying the BSPs available to the " +
+		"project."
+
+	bspCmd := &cobra.Command{
+		Use:   "bsp",
+		Short: "Query BSPs",
+		Long:  bspHelpText,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Usage()
+		},
+	}
+	cmd.AddCommand(bspCmd)
+
+	listHelpText := "List the BSPs in all of the project's repositories " +
+		"with their MCU, architecture, flash and RAM sizes and supported " +
+		"features.\n\n" +
+		"Flash and RAM sizes come from the BSP's bsp.memory_regions (or, " +
+		"for flash, the size of its internal flash device); features are " +
+		"listed by bsp.features in bsp.yml.  With --json, the full " +
+		"details of each BSP, including its emulators, debug probes and " +
+		"serial bootloader protocol, are printed."
+	listHelpEx := "  newt bsp list\n"
+	listHelpEx += "  newt bsp list --json"
+
+	listCmd := &cobra.Command{
+		Use:     "list",
+		Short:   "List BSPs and their capabilities",
+		Long:    listHelpText,
+		Example: listHelpEx,
+		Run:     bspListRunCmd,
+	}
+	bspCmd.AddCommand(listCmd)
+}
@@ -20,16 +20,24 @@
 package cli
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/spf13/cobra"
 	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/downloader"
+	"mynewt.apache.org/newt/newt/newtutil"
 	"mynewt.apache.org/newt/newt/pkg"
 	"mynewt.apache.org/newt/newt/project"
 	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/newt/toolchain"
 	"mynewt.apache.org/newt/util"
 )
 
@@ -101,8 +109,197 @@ func pkgToUnitTests(pack *pkg.LocalPackage) []*pkg.LocalPackage {
 	return result
 }
 
+// Returns the unit test packages matching the specified glob pattern.  A
+// unit test matches if the pattern matches either its own name or the name of
+// the package it tests (e.g., "kernel/*" matches "kernel/os/test").
+func unitTestGlob(pattern string) []*pkg.LocalPackage {
+	result := []*pkg.LocalPackage{}
+	for _, p := range project.GetProject().PackagesOfType(
+		pkg.PACKAGE_TYPE_UNITTEST) {
+
+		lpkg := p.(*pkg.LocalPackage)
+		for _, n := range []string{lpkg.Name(), filepath.Dir(lpkg.Name())} {
+			if ok, _ := filepath.Match(pattern, n); ok {
+				result = append(result, lpkg)
+				break
+			}
+		}
+	}
+
+	return pkg.SortLclPkgs(result)
+}
+
 var extraJtagCmd string
+var jtagBackend string
+var attachOpts builder.AttachOptions
+var loadMethod string
+var probeSerial string
+var loadProgramAreas bool
+var serialLoadOpts builder.SerialLoadOptions
 var noGDB_flag bool
+var noStrict bool
+var buildLogFormat string
+var selectApis bool
+var buildIncremental bool
+var buildStrictIncludes bool
+var buildPruneIncludes bool
+var buildShareObjects bool
+var buildUntil string
+var buildCheckHermetic bool
+var buildHermeticAllow []string
+
+var cleanPkgs []string
+var cleanGenerated bool
+var cleanLink bool
+var cleanCache bool
+
+// Prints the compiler diagnostics collected during the build, grouped by
+// package, followed by a summary.  In JSON mode, each diagnostic is printed as
+// a single-line JSON object.  Returns the number of errors.
+func printDiagnostics() int {
+	diags := toolchain.Diagnostics()
+
+	if buildLogFormat == "json" {
+		for _, d := range diags {
+			data, err := json.Marshal(d)
+			if err != nil {
+				NewtUsage(nil, util.ChildNewtError(err))
+			}
+			fmt.Printf("%s\n", data)
+		}
+	}
+
+	type pkgCounts struct {
+		errors   int
+		warnings int
+	}
+
+	pkgNames := []string{}
+	byPkg := map[string][]toolchain.Diagnostic{}
+	counts := map[string]*pkgCounts{}
+	total := pkgCounts{}
+
+	for _, d := range diags {
+		if byPkg[d.Package] == nil {
+			pkgNames = append(pkgNames, d.Package)
+			counts[d.Package] = &pkgCounts{}
+		}
+		byPkg[d.Package] = append(byPkg[d.Package], d)
+
+		switch d.Severity {
+		case toolchain.DIAG_SEVERITY_ERROR:
+			counts[d.Package].errors++
+			total.errors++
+		case toolchain.DIAG_SEVERITY_WARNING:
+			counts[d.Package].warnings++
+			total.warnings++
+		}
+	}
+	sort.Strings(pkgNames)
+
+	if len(diags) == 0 || buildLogFormat == "json" {
+		return total.errors
+	}
+
+	severityColors := map[string]string{
+		toolchain.DIAG_SEVERITY_ERROR:   ANSI_RED,
+		toolchain.DIAG_SEVERITY_WARNING: ANSI_YELLOW,
+	}
+
+	util.StatusMessage(util.VERBOSITY_QUIET, "\nCompiler diagnostics:\n")
+	for _, pkgName := range pkgNames {
+		util.StatusMessage(util.VERBOSITY_QUIET, "* PACKAGE: %s\n", pkgName)
+		for _, d := range byPkg[pkgName] {
+			loc := d.File
+			if d.Line != 0 {
+				loc += fmt.Sprintf(":%d", d.Line)
+			}
+			if d.Column != 0 {
+				loc += fmt.Sprintf(":%d", d.Column)
+			}
+
+			severity := d.Severity + ":"
+			if color, ok := severityColors[d.Severity]; ok {
+				severity = colorText(color, severity)
+			}
+
+			util.StatusMessage(util.VERBOSITY_QUIET, "    %s: %s %s\n", loc,
+				severity, d.Message)
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_QUIET, "\n%d error(s), %d warning(s)\n",
+		total.errors, total.warnings)
+	for _, pkgName := range pkgNames {
+		c := counts[pkgName]
+		if c.errors > 0 || c.warnings > 0 {
+			util.StatusMessage(util.VERBOSITY_QUIET,
+				"    %s: %d error(s), %d warning(s)\n", pkgName, c.errors,
+				c.warnings)
+		}
+	}
+
+	return total.errors
+}
+
+// Prompts the user to choose a supplier for each of the target's conflicting
+// APIs.  The choices are applied to the target and, if the user agrees, saved
+// to its target.yml.  Returns a builder for the updated target.
+func selectApiSuppliers(b *builder.TargetBuilder) (
+	*builder.TargetBuilder, error) {
+
+	res, err := b.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	if len(res.ApiConflicts) == 0 {
+		return b, nil
+	}
+
+	apis := make([]string, 0, len(res.ApiConflicts))
+	for api, _ := range res.ApiConflicts {
+		apis = append(apis, api)
+	}
+	sort.Strings(apis)
+
+	t := b.GetTarget()
+	scanner := bufio.NewScanner(os.Stdin)
+	for _, api := range apis {
+		cands := res.ApiConflicts[api]
+
+		fmt.Printf("API %s is supplied by several packages:\n", api)
+		for i, rpkg := range cands {
+			names := []string{}
+			for _, p := range res.DepPath(rpkg) {
+				names = append(names, p.Lpkg.FullName())
+			}
+			fmt.Printf("    %d) %s (%s)\n", i+1, rpkg.Lpkg.FullName(),
+				strings.Join(names, " --> "))
+		}
+
+		choice := 0
+		for choice < 1 || choice > len(cands) {
+			fmt.Printf("Select a supplier [1-%d]: ", len(cands))
+			if !scanner.Scan() {
+				return nil, util.FmtNewtError(
+					"No supplier selected for API %s", api)
+			}
+			choice, _ = util.AtoiNoOct(strings.TrimSpace(scanner.Text()))
+		}
+
+		t.Vars[target.TARGET_API_PREF_PREFIX+api] =
+			cands[choice-1].Lpkg.FullName()
+	}
+
+	fmt.Printf("Save API preferences to %s? (y/N) ", t.FullName())
+	if scanner.Scan() && strings.ToLower(scanner.Text()) == "y" {
+		if err := t.Save(); err != nil {
+			return nil, err
+		}
+	}
+
+	return builder.NewTargetBuilder(t)
+}
 
 func buildRunCmd(cmd *cobra.Command, args []string, printShellCmds bool) {
 	if len(args) < 1 {
@@ -111,7 +308,23 @@ func buildRunCmd(cmd *cobra.Command, args []string, printShellCmds bool) {
 
 	util.PrintShellCmds = printShellCmds
 
-	TryGetProject()
+	if buildLogFormat != "text" && buildLogFormat != "json" {
+		NewtUsage(cmd, util.FmtNewtError("Invalid log format: %s",
+			buildLogFormat))
+	}
+
+	proj := TryGetProject()
+
+	// Builds are only reproducible if the repos match the lock file.
+	for _, m := range proj.LockMismatches() {
+		util.StatusMessage(util.VERBOSITY_QUIET,
+			"* Warning: %s; run \"newt sync\" to restore it\n", m)
+	}
+
+	// Refuse to build from modified or tampered repos.
+	if err := proj.CheckIntegrity(); err != nil {
+		NewtUsage(nil, err)
+	}
 
 	// Verify and resolve each specified package.
 	targets, all, err := ResolveTargetsOrAll(args...)
@@ -130,41 +343,113 @@ func buildRunCmd(cmd *cobra.Command, args []string, printShellCmds bool) {
 		}
 	}
 
-	for i, _ := range targets {
-		// Reset the global state for the next build.
-		// XXX: It is not good that this is necessary.  This is certainly going
-		// to bite us...
-		if i > 0 {
-			if err := ResetGlobalState(); err != nil {
-				NewtUsage(nil, err)
-			}
-		}
-
-		// Look up the target by name.  This has to be done a second time here
-		// now that the project has been reset.
-		t := ResolveTarget(targets[i].FullName())
-		if t == nil {
-			NewtUsage(nil, util.NewNewtError("Failed to resolve target: "+
-				targets[i].Name()))
-		}
-
+	results, err := runBulkCmd(cmd, targets, func(t *target.Target) error {
 		util.StatusMessage(util.VERBOSITY_DEFAULT, "Building target %s\n",
 			t.FullName())
 
+		// Only report the diagnostics of the target being built.
+		defer toolchain.ResetDiagnostics()
+
 		b, err := builder.NewTargetBuilder(t)
 		if err != nil {
-			NewtUsage(nil, err)
+			return err
+		}
+		if selectApis {
+			if b, err = selectApiSuppliers(b); err != nil {
+				return err
+			}
+		}
+		b.BudgetWarnOnly = noStrict
+		if buildIncremental {
+			b.EnableIncremental()
+		}
+		if buildStrictIncludes || proj.StrictIncludes() {
+			b.EnableStrictIncludes()
+		}
+		if buildPruneIncludes || proj.PruneIncludes() {
+			b.EnableIncludePruning()
+		}
+		if buildShareObjects || proj.SharedObjects() {
+			b.EnableSharedObjects()
+		}
+		if err := b.SetUntilStage(buildUntil); err != nil {
+			return err
 		}
 
-		if err := b.Build(); err != nil {
-			NewtUsage(nil, err)
+		var report *builder.HermeticReport
+		if buildCheckHermetic {
+			report, err = b.CheckHermetic(buildHermeticAllow)
+		} else {
+			err = b.Build()
+		}
+		if err != nil {
+			if printDiagnostics() > 0 {
+				err = util.FmtNewtError("Failed to build target %s",
+					t.FullName()).WithClass(util.ErrClass(err))
+			}
+			return err
+		}
+
+		if b.StopsEarly() {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"Target built through the %s stage: %s\n",
+				b.UntilStage(), t.Name())
+			printDiagnostics()
+			return nil
 		}
 
 		util.StatusMessage(util.VERBOSITY_DEFAULT,
 			"Target successfully built: %s\n", t.Name())
+		printDiagnostics()
+
+		if report != nil {
+			if err := printHermeticReport(report); err != nil {
+				return err
+			}
+		}
+
+		if err := b.BuildCompanions(); err != nil {
+			return err
+		}
+
+		return nil
+	})
+
+	if newtutil.NewtJson && len(targets) > 1 {
+		printJson(results)
+	}
+
+	if err != nil {
+		NewtUsage(nil, err)
 	}
 }
 
+// Prints the undeclared inputs found by a hermeticity check; returns an
+// error if there are any.
+func printHermeticReport(report *builder.HermeticReport) error {
+	if newtutil.NewtJson {
+		printJson(report)
+	} else {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Traced %d toolchain commands reading %d files\n",
+			report.Commands, report.Files)
+		for _, v := range report.Violations {
+			util.StatusMessage(util.VERBOSITY_QUIET,
+				"* Undeclared %s input: %s (read by %s)\n", v.Kind, v.Path,
+				strings.Join(v.Tools, ", "))
+		}
+	}
+
+	if len(report.Violations) > 0 {
+		return util.FmtNewtError("Build of target %s is not hermetic: "+
+			"%d undeclared inputs", report.Target, len(report.Violations))
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Build of target %s is hermetic\n", report.Target)
+	return nil
+}
+
 func cleanDir(path string) {
 	util.StatusMessage(util.VERBOSITY_VERBOSE,
 		"Cleaning directory %s\n", path)
@@ -175,12 +460,73 @@ func cleanDir(path string) {
 	}
 }
 
+// Removes the specified files, reporting each at verbose verbosity.
+func cleanFiles(paths []string) {
+	for _, path := range paths {
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "Removing %s\n", path)
+
+		if err := os.Remove(path); err != nil {
+			NewtUsage(nil, util.ChildNewtError(err))
+		}
+	}
+}
+
+// Removes the artifacts of the specified target that fall within the
+// requested scopes.
+func cleanTarget(t *target.Target, pkgNames []string) {
+	binDir := builder.TargetBinDir(t.Name())
+	if util.NodeNotExist(binDir) {
+		return
+	}
+
+	if cleanGenerated {
+		cleanDir(builder.GeneratedBaseDir(t.Name()))
+	}
+
+	if cleanLink {
+		builds := map[string]string{
+			builder.BUILD_NAME_APP:    t.AppName,
+			builder.BUILD_NAME_LOADER: t.LoaderName,
+		}
+		for buildName, appName := range builds {
+			if appName == "" {
+				continue
+			}
+			paths, err := builder.LinkOutputPaths(t.Name(), buildName, appName)
+			if err != nil {
+				NewtUsage(nil, err)
+			}
+			cleanFiles(paths)
+		}
+	}
+
+	if len(pkgNames) > 0 {
+		infos, err := ioutil.ReadDir(binDir)
+		if err != nil {
+			NewtUsage(nil, util.ChildNewtError(err))
+		}
+
+		for _, info := range infos {
+			if !info.IsDir() || info.Name() == "generated" {
+				continue
+			}
+			for _, pkgName := range pkgNames {
+				dir := builder.FileBinDir(t.Name(), info.Name(), pkgName)
+				if util.NodeExist(dir) {
+					cleanDir(dir)
+				}
+			}
+		}
+	}
+}
+
 func cleanRunCmd(cmd *cobra.Command, args []string) {
-	if len(args) < 1 {
+	scoped := cleanGenerated || cleanLink || len(cleanPkgs) > 0
+	if len(args) < 1 && !cleanCache {
 		NewtUsage(cmd, util.NewNewtError("Must specify target"))
 	}
 
-	TryGetProject()
+	proj := TryGetProject()
 
 	cleanAll := false
 	targets := []*target.Target{}
@@ -196,10 +542,43 @@ func cleanRunCmd(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	// Package artifacts are stored under the package's name as used by the
+	// builder, so resolve each one to its canonical form.
+	pkgNames := []string{}
+	for _, name := range cleanPkgs {
+		lpkg, err := proj.ResolvePackage(proj.LocalRepo(), name)
+		if err != nil {
+			NewtUsage(cmd, err)
+		}
+		pkgNames = append(pkgNames, lpkg.Name())
+	}
+
+	if cleanCache {
+		dir := downloader.CacheDir()
+		if dir == "" {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"No download cache configured\n")
+		} else {
+			cleanDir(dir)
+		}
+	}
+
 	if cleanAll {
-		cleanDir(builder.BinRoot())
-	} else {
-		for _, t := range targets {
+		if !scoped {
+			cleanDir(builder.BinRoot())
+			return
+		}
+
+		targets = []*target.Target{}
+		for _, t := range target.GetTargets() {
+			targets = append(targets, t)
+		}
+	}
+
+	for _, t := range targets {
+		if scoped {
+			cleanTarget(t, pkgNames)
+		} else {
 			cleanDir(builder.TargetBinDir(t.Name()))
 		}
 	}
@@ -215,6 +594,70 @@ func pkgnames(pkgs []*pkg.LocalPackage) string {
 	return s
 }
 
+// Builds the simulator test executable of each package, for the specified
+// BSP or, if bspName is empty, the unit test target's.  Building relies on
+// global state, so this has to happen one package at a time; the executables
+// are then free to run concurrently.
+func buildUnitTests(packs []*pkg.LocalPackage,
+	bspName string) []*unitTestResult {
+
+	results := make([]*unitTestResult, len(packs))
+	for i, pack := range packs {
+		results[i] = &unitTestResult{Pkg: pack, Bsp: bspName}
+
+		// Reset the global state for the next test.
+		if err := ResetGlobalState(); err != nil {
+			NewtUsage(nil, err)
+		}
+
+		var t *target.Target
+		var err error
+		if bspName == "" {
+			t, err = ResolveUnittest(pack.Name())
+		} else {
+			t, err = resolveBspUnittest(bspName, pack.Name())
+		}
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+
+		b, err := builder.NewTargetTester(t, pack)
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+
+		if err := b.EnableSanitizers(testSanitizers()); err != nil {
+			NewtUsage(nil, err)
+		}
+		results[i].Env = builder.SanitizerEnv(b.Sanitizers())
+
+		if testCoverage {
+			b.EnableCoverage()
+			results[i].BinDir = builder.TargetBinDir(t.Name())
+			results[i].SrcDir = filepath.Dir(pack.BasePath())
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "Building test package %s\n",
+			results[i].Name())
+
+		if err := b.SelfTestCreateExe(); err != nil {
+			newtError := err.(*util.NewtError)
+			util.StatusMessage(util.VERBOSITY_QUIET, "%s\n", newtError.Text)
+			results[i].BuildErr = err
+			continue
+		}
+
+		results[i].ExePath, err = b.SelfTestExePath()
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+		results[i].LogPath = filepath.Join(filepath.Dir(results[i].ExePath),
+			TEST_LOG_FILENAME)
+	}
+
+	return results
+}
+
 func testRunCmd(cmd *cobra.Command, args []string, exclude string) {
 	if len(args) < 1 {
 		NewtUsage(cmd, nil)
@@ -228,6 +671,14 @@ func testRunCmd(cmd *cobra.Command, args []string, exclude string) {
 	for _, pkgName := range args {
 		if pkgName == "all" {
 			testAll = true
+		} else if isGlob(pkgName) {
+			matches := unitTestGlob(pkgName)
+			if len(matches) == 0 {
+				NewtUsage(nil, util.FmtNewtError("No unit tests match "+
+					"pattern: %s", pkgName))
+			}
+
+			packs = append(packs, matches...)
 		} else {
 			pack, err := proj.ResolvePackage(proj.LocalRepo(), pkgName)
 			if err != nil {
@@ -274,43 +725,104 @@ func testRunCmd(cmd *cobra.Command, args []string, exclude string) {
 		NewtUsage(nil, util.NewNewtError("No testable packages found"))
 	}
 
-	passedPkgs := []*pkg.LocalPackage{}
-	failedPkgs := []*pkg.LocalPackage{}
-	for _, pack := range packs {
-		// Reset the global state for the next test.
-		if err := ResetGlobalState(); err != nil {
-			NewtUsage(nil, err)
+	if testFilter != "" {
+		re, err := regexp.Compile(testFilter)
+		if err != nil {
+			NewtUsage(cmd, util.FmtNewtError("Invalid filter \"%s\": %s",
+				testFilter, err.Error()))
 		}
+		testFilterRe = re
+	}
 
-		t, err := ResolveUnittest(pack.Name())
+	if testShard != "" {
+		idx, count, err := parseTestShard(testShard)
 		if err != nil {
-			NewtUsage(nil, err)
+			NewtUsage(cmd, err)
 		}
 
-		b, err := builder.NewTargetTester(t, pack)
+		packs = shardUnitTests(packs, idx, count)
+		if len(packs) == 0 {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"No tests in shard %s\n", testShard)
+			return
+		}
+	}
+
+	if testCoverageMin > 0 {
+		testCoverage = true
+	}
+
+	var results []*unitTestResult
+	if testHwTarget != "" {
+		if len(testBsps) > 0 {
+			NewtUsage(cmd, util.NewNewtError(
+				"--bsp cannot be used with --target"))
+		}
+		results = runHwUnitTests(packs)
+	} else if len(testBsps) > 0 {
+		bsps, err := resolveTestBsps()
 		if err != nil {
+			NewtUsage(cmd, err)
+		}
+		for _, bsp := range bsps {
+			results = append(results, buildUnitTests(packs, bsp)...)
+		}
+		runUnitTests(results)
+	} else {
+		results = buildUnitTests(packs, "")
+		runUnitTests(results)
+	}
+
+	if testJunitPath != "" {
+		if err := writeJunitReport(testJunitPath, results); err != nil {
+			NewtUsage(nil, err)
+		}
+	}
+	if testTapPath != "" {
+		if err := writeTapReport(testTapPath, results); err != nil {
 			NewtUsage(nil, err)
 		}
+	}
 
-		util.StatusMessage(util.VERBOSITY_DEFAULT, "Testing package %s\n",
-			pack.FullName())
+	belowMin := []*pkg.LocalPackage{}
+	if testCoverage {
+		belowMin = printCoverageSummary(results)
+	}
 
-		err = b.SelfTestExecute()
-		if err == nil {
-			passedPkgs = append(passedPkgs, pack)
+	if len(testBsps) > 0 {
+		printBspSummary(results)
+	}
+
+	passed := []string{}
+	failed := []string{}
+	flaky := []string{}
+	for _, r := range results {
+		if r.Passed() {
+			passed = append(passed, r.SummaryName())
+			if r.Flaky() {
+				flaky = append(flaky, r.SummaryName())
+			}
 		} else {
-			newtError := err.(*util.NewtError)
-			util.StatusMessage(util.VERBOSITY_QUIET, newtError.Text)
-			failedPkgs = append(failedPkgs, pack)
+			failed = append(failed, r.SummaryName())
 		}
 	}
 
-	passStr := fmt.Sprintf("Passed tests: [%s]", PackageNameList(passedPkgs))
-	failStr := fmt.Sprintf("Failed tests: [%s]", PackageNameList(failedPkgs))
+	passStr := fmt.Sprintf("Passed tests: [%s]", strings.Join(passed, " "))
+	failStr := fmt.Sprintf("Failed tests: [%s]", strings.Join(failed, " "))
+
+	if len(flaky) > 0 {
+		util.StatusMessage(util.VERBOSITY_QUIET,
+			"* Warning: flaky tests (passed after retry): [%s]\n",
+			strings.Join(flaky, " "))
+	}
 
-	if len(failedPkgs) > 0 {
+	if len(failed) > 0 {
 		NewtUsage(nil, util.FmtNewtError("Test failure(s):\n%s\n%s", passStr,
 			failStr))
+	} else if len(belowMin) > 0 {
+		NewtUsage(nil, util.FmtNewtError(
+			"Coverage below %.1f%%: [%s]", testCoverageMin,
+			PackageNameList(belowMin)))
 	} else {
 		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s\n", passStr)
 		util.StatusMessage(util.VERBOSITY_DEFAULT, "All tests passed\n")
@@ -333,6 +845,10 @@ func loadRunCmd(cmd *cobra.Command, args []string) {
 	if err != nil {
 		NewtUsage(nil, err)
 	}
+	if err := applyLoadMethod(b); err != nil {
+		NewtUsage(cmd, err)
+	}
+	b.SetProgramAreas(loadProgramAreas)
 
 	if err := b.Load(extraJtagCmd); err != nil {
 		NewtUsage(cmd, err)
@@ -355,13 +871,86 @@ func debugRunCmd(cmd *cobra.Command, args []string) {
 	if err != nil {
 		NewtUsage(nil, err)
 	}
+	b.SetProbe(jtagBackend)
+	b.SetProbeSerial(probeSerial)
 
 	if err := b.Debug(extraJtagCmd, false, noGDB_flag); err != nil {
 		NewtUsage(cmd, err)
 	}
 }
 
-func sizeRunCmd(cmd *cobra.Command, args []string, ram bool, flash bool) {
+// Configures the target builder for the load method selected on the command
+// line.
+func applyLoadMethod(b *builder.TargetBuilder) error {
+	b.SetProbe(jtagBackend)
+	b.SetProbeSerial(probeSerial)
+
+	switch loadMethod {
+	case "", builder.LOAD_METHOD_JTAG:
+		if serialLoadOpts.Port != "" {
+			return util.NewNewtError("--port requires --method serial or mgmt")
+		}
+	case builder.LOAD_METHOD_SERIAL:
+		if jtagBackend != "" {
+			return util.NewNewtError(
+				"--jtag cannot be used with --method serial")
+		}
+		if serialLoadOpts.Port == "" {
+			cp, err := b.ConnProfile()
+			if err != nil {
+				return err
+			}
+			if cp == nil || cp.SerialPort() == "" {
+				return util.NewNewtError("--method serial requires --port " +
+					"or a serial or BLE target connection profile")
+			}
+			serialLoadOpts.Port = cp.SerialPort()
+			if serialLoadOpts.Baud == 0 {
+				serialLoadOpts.Baud = cp.Baud
+			}
+		}
+		b.SetSerialLoad(&serialLoadOpts)
+	case builder.LOAD_METHOD_MGMT:
+		if jtagBackend != "" {
+			return util.NewNewtError(
+				"--jtag cannot be used with --method mgmt")
+		}
+		opts := &builder.ImageUploadOptions{
+			Test:  true,
+			Reset: true,
+		}
+		opts.Conn = serialLoadOpts.Port
+		opts.Baud = serialLoadOpts.Baud
+		b.SetMgmtLoad(opts)
+	default:
+		return util.FmtNewtError("Invalid load method \"%s\"; must be "+
+			"%s, %s or %s", loadMethod, builder.LOAD_METHOD_JTAG,
+			builder.LOAD_METHOD_SERIAL, builder.LOAD_METHOD_MGMT)
+	}
+
+	return nil
+}
+
+func addLoadMethodFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVarP(&loadMethod, "method", "", "",
+		"How to load the image: jtag (default), serial, or mgmt (upload "+
+			"to the running image over SMP)")
+	cmd.PersistentFlags().StringVarP(&serialLoadOpts.Port, "port", "", "",
+		"Serial port (or ble:<name>) to load through with --method "+
+			"serial, or connection for --method mgmt (default: the "+
+			"target's connection profile)")
+	cmd.PersistentFlags().IntVarP(&serialLoadOpts.Baud, "baud", "", 0,
+		"Baud rate for --method serial or mgmt (default: the "+
+			"connection profile's or BSP's)")
+	addProbeSerialFlag(cmd)
+}
+
+func addProbeSerialFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVarP(&probeSerial, "serial", "", "",
+		"Serial number of the debug probe to use")
+}
+
+func attachRunCmd(cmd *cobra.Command, args []string) {
 	if len(args) < 1 {
 		NewtUsage(cmd, util.NewNewtError("Must specify target"))
 	}
@@ -377,25 +966,170 @@ func sizeRunCmd(cmd *cobra.Command, args []string, ram bool, flash bool) {
 	if err != nil {
 		NewtUsage(nil, err)
 	}
+	b.SetProbe(jtagBackend)
+	b.SetProbeSerial(probeSerial)
 
-	if ram || flash {
-		if err := b.SizeReport(ram, flash); err != nil {
-			NewtUsage(cmd, err)
-		}
-		return
+	if err := b.Attach(attachOpts); err != nil {
+		NewtUsage(cmd, err)
+	}
+}
+
+func sizeRunCmd(cmd *cobra.Command, args []string, ram bool, flash bool) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
 	}
 
-	if err := b.Size(); err != nil {
+	TryGetProject()
+
+	targets, err := ResolveTargets(args...)
+	if err != nil {
 		NewtUsage(cmd, err)
 	}
+
+	if sizeRamBudget {
+		sizeRamBudgetRunCmd(cmd, targets)
+		return
+	}
+
+	if sizeWhy != "" || sizeDiscarded {
+		sizeXrefRunCmd(cmd, targets)
+		return
+	}
+
+	if newtutil.NewtJson && sizeCsv {
+		NewtUsage(cmd, util.NewNewtError(
+			"--csv and --json are mutually exclusive"))
+	}
+	if sizeOpts.Symbols < 0 {
+		NewtUsage(cmd, util.NewNewtError("--symbols must not be negative"))
+	}
+
+	// In JSON and CSV modes, the summaries of all targets are collected into
+	// a single document.
+	summaries := map[string][]builder.ImageSizeSummary{}
+	targetNames := []string{}
+
+	_, err = runBulkCmd(cmd, targets, func(t *target.Target) error {
+		b, err := builder.NewTargetBuilder(t)
+		if err != nil {
+			return err
+		}
+
+		if ram || flash {
+			if len(targets) > 1 {
+				util.StatusMessage(util.VERBOSITY_DEFAULT, "Target %s\n",
+					t.FullName())
+			}
+			return b.SizeReport(ram, flash)
+		}
+
+		imgs, err := b.SizeSummaries(sizeOpts)
+		if err != nil {
+			return err
+		}
+
+		if newtutil.NewtJson || sizeCsv {
+			summaries[t.FullName()] = imgs
+			targetNames = append(targetNames, t.FullName())
+			return nil
+		}
+
+		if len(targets) > 1 {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "Target %s\n",
+				t.FullName())
+		}
+		for _, img := range imgs {
+			printSizeSummary(img)
+		}
+
+		return nil
+	})
+
+	// A partial document would be mistaken for a complete one; the error is
+	// reported instead (as JSON with --json-errors).
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		if len(targets) == 1 {
+			printJson(summaries[targets[0].FullName()])
+		} else {
+			printJson(summaries)
+		}
+	} else if sizeCsv {
+		sort.Strings(targetNames)
+		if err := writeSizeCsv(summaries, targetNames); err != nil {
+			NewtUsage(nil, err)
+		}
+	}
 }
 
 func AddBuildCommands(cmd *cobra.Command) {
 	var printShellCmds bool
 
+	buildHelpText := "Build one or more targets.  Target names may be glob " +
+		"patterns (e.g., \"nrf52-*\"); quote them to prevent shell " +
+		"expansion.  Targets may also be selected by their location with " +
+		"a label: \"//<dir>:<name>\" is the target <name> in directory " +
+		"<dir> or <dir>/targets, \"//<dir>:all\" is every target there, " +
+		"\"//<dir>/...\" is every target below <dir>, and \":<name>\" " +
+		"is relative to the current directory; prefix \"@<repo>\" to " +
+		"select targets in another repo.  When several targets are " +
+		"built, a failure does not " +
+		"stop the remaining builds, and a per-target summary is printed " +
+		"at the end.\n\n" +
+		"Packages whose objects are unchanged are not rearchived.  With " +
+		"--incremental, package archives are thin (they reference the " +
+		"object files) if the compiler package sets " +
+		"compiler.archive.thin, and the link uses " +
+		"compiler.ld.incremental_flags (e.g., -Wl,--incremental for " +
+		"gold) if it sets them.\n\n" +
+		"With --strict-includes, or if project.yml sets " +
+		"project.strict_includes, a package may only include headers " +
+		"from itself, its direct dependencies, the BSP and the target.  " +
+		"Each violating #include line is reported.\n\n" +
+		"By default, every package is compiled with the include paths of " +
+		"all the app's and target's dependencies.  With " +
+		"--prune-includes, or if project.yml sets " +
+		"project.prune_includes, each package only gets the include " +
+		"paths of its own dependencies, the BSP and the generated " +
+		"headers, which shortens the compile commands.  The average " +
+		"number of include paths per package is reported, along with " +
+		"the time it took to compile the files that were last " +
+		"compiled without pruning, compared with that build.  Since " +
+		"pruning changes the compile commands, building without and then " +
+		"with the flag recompiles, and so compares, every file.  " +
+		"Compile times are not recorded when " +
+		"compiler_launcher is set.  A package " +
+		"that includes headers from packages it does not depend on " +
+		"fails to compile.\n\n" +
+		"With --share-objects, or if project.yml sets " +
+		"project.shared_objects, targets share the object files they " +
+		"compile with identical flags through a store in bin/.objstore.  " +
+		"A stored object is only reused if the headers it was compiled " +
+		"against are unchanged, so targets with different syscfg " +
+		"settings still compile the packages that depend on them.  " +
+		"Generated sources are always compiled per target.\n\n" +
+		"With --check-hermetic (Linux only; requires strace), the " +
+		"target is rebuilt from scratch with each toolchain command " +
+		"traced, and any file the toolchain reads outside the build's " +
+		"packages, the target's bin directory, the toolchain's " +
+		"installation directory and the system directories is reported.  " +
+		"Such inputs are not tracked by incremental builds.  Additional " +
+		"directories can be accepted with --hermetic-allow.\n\n" +
+		"--until stops the build after the named stage: generate (the " +
+		"syscfg, sysinit and flash map sources), compile (object " +
+		"files), archive (package archives), link (ELF files) or image " +
+		"(the complete build, including post-link hooks, budget checks " +
+		"and the manifest).  For example, \"--until generate\" only " +
+		"produces the generated headers an IDE needs, and \"--until " +
+		"compile\" checks that the sources compile without linking."
+
 	buildCmd := &cobra.Command{
 		Use:   "build <target-name> [target-names...]",
 		Short: "Build one or more targets",
+		Long:  buildHelpText,
 		Run: func(cmd *cobra.Command, args []string) {
 			buildRunCmd(cmd, args, printShellCmds)
 		},
@@ -403,17 +1137,59 @@ func AddBuildCommands(cmd *cobra.Command) {
 
 	buildCmd.Flags().BoolVarP(&printShellCmds, "printCmds", "p", false,
 		"Print executed build commands")
+	buildCmd.Flags().BoolVarP(&noStrict, "no-strict", "", false,
+		"Warn rather than fail when memory budgets are exceeded")
+	buildCmd.Flags().StringVarP(&buildLogFormat, "log-format", "", "text",
+		"Format of the compiler diagnostics summary: text or json")
+	buildCmd.Flags().BoolVarP(&selectApis, "select-apis", "", false,
+		"Prompt for a supplier of each API provided by several packages")
+	buildCmd.Flags().BoolVarP(&buildIncremental, "incremental", "", false,
+		"Use thin archives and incremental linking if the toolchain "+
+			"supports them")
+	buildCmd.Flags().BoolVarP(&buildStrictIncludes, "strict-includes", "",
+		false, "Fail if a package includes headers from a package it "+
+			"does not depend on")
+	buildCmd.Flags().BoolVarP(&buildPruneIncludes, "prune-includes", "",
+		false, "Only pass each package the include paths of its "+
+			"dependencies")
+	buildCmd.Flags().BoolVarP(&buildShareObjects, "share-objects", "",
+		false, "Reuse object files that other targets compiled with "+
+			"identical flags")
+	buildCmd.Flags().StringVarP(&buildUntil, "until", "", "",
+		"Stop the build after the specified stage: "+
+			strings.Join(builder.BuildStages, ", "))
+	buildCmd.Flags().BoolVarP(&buildCheckHermetic, "check-hermetic", "",
+		false, "Rebuild with the toolchain traced and fail if it reads "+
+			"undeclared inputs")
+	buildCmd.Flags().StringSliceVarP(&buildHermeticAllow, "hermetic-allow",
+		"", nil, "With --check-hermetic, directories the toolchain may "+
+			"also read from")
+	addBulkFlags(buildCmd)
 
 	cmd.AddCommand(buildCmd)
 	AddTabCompleteFn(buildCmd, func() []string {
 		return append(targetList(), "all")
 	})
 
+	cleanHelpText := "Delete build artifacts for one or more targets.  By " +
+		"default, all of a target's artifacts are removed.  The scope " +
+		"flags restrict the deletion to a subset of them; several scopes " +
+		"may be combined."
+
 	cleanCmd := &cobra.Command{
 		Use:   "clean <target-name> [target-names...] | all",
 		Short: "Delete build artifacts for one or more targets",
+		Long:  cleanHelpText,
 		Run:   cleanRunCmd,
 	}
+	cleanCmd.Flags().StringSliceVarP(&cleanPkgs, "pkg", "", nil,
+		"Only delete the artifacts of the specified package(s)")
+	cleanCmd.Flags().BoolVarP(&cleanGenerated, "generated", "", false,
+		"Only delete generated code (syscfg, sysinit, linker scripts)")
+	cleanCmd.Flags().BoolVarP(&cleanLink, "link", "", false,
+		"Only delete link outputs (ELF, image, hex, manifest)")
+	cleanCmd.Flags().BoolVarP(&cleanCache, "cache", "", false,
+		"Delete the repository download cache")
 
 	cmd.AddCommand(cleanCmd)
 	AddTabCompleteFn(cleanCmd, func() []string {
@@ -421,35 +1197,139 @@ func AddBuildCommands(cmd *cobra.Command) {
 	})
 
 	var exclude string
+	testHelpText := "Executes unit tests for one or more packages.  By " +
+		"default, the tests are built for and run in the simulator.\n\n" +
+		"With --target <hw-target>, each package's tests are linked into " +
+		"the target's app, loaded onto the device, and their results are " +
+		"read from the device console (--console or --console-cmd).  The " +
+		"app must run the tests and print a line matching --done-pattern " +
+		"when finished.\n\n" +
+		"--shard i/n splits the selected packages into n groups and runs " +
+		"only the i-th, so a suite can be spread over several CI " +
+		"machines.  --filter restricts the results to test cases whose " +
+		"names (<suite>/<case>) match a regular expression; the " +
+		"expression is also passed to the test executable in the " +
+		builder.TEST_FILTER_ENV + " environment variable.\n\n" +
+		"--bsp runs the tests once for each of the specified BSPs " +
+		"instead of the unit test target's, e.g., simulator BSPs with " +
+		"different pointer sizes or endianness.  A BSP may be given by " +
+		"the last element of its package name.  Results are reported " +
+		"per BSP."
+
 	testCmd := &cobra.Command{
 		Use:   "test <package-name> [package-names...] | all",
 		Short: "Executes unit tests for one or more packages",
+		Long:  testHelpText,
 		Run: func(cmd *cobra.Command, args []string) {
 			testRunCmd(cmd, args, exclude)
 		},
 	}
 	testCmd.Flags().StringVarP(&exclude, "exclude", "e", "", "Comma separated list of packages to exclude")
+	testCmd.Flags().IntVarP(&testParallel, "parallel", "", 1,
+		"Number of test executables to run concurrently")
+	testCmd.Flags().DurationVarP(&testTimeout, "timeout", "", 0,
+		"Kill a test executable that runs longer than this (e.g., 30s)")
+	testCmd.Flags().IntVarP(&testRetries, "retries", "", 0,
+		"Number of times to rerun a failing test before reporting it")
+	testCmd.Flags().StringVarP(&testFilter, "filter", "", "",
+		"Only consider test cases whose names match this regular expression")
+	testCmd.Flags().StringVarP(&testShard, "shard", "", "",
+		"Run only the i-th of n groups of test packages (i/n)")
+	testCmd.Flags().StringVarP(&testJunitPath, "junit", "", "",
+		"Write a JUnit XML report to the specified file")
+	testCmd.Flags().StringVarP(&testTapPath, "tap", "", "",
+		"Write a TAP report to the specified file")
+	testCmd.Flags().BoolVarP(&testCoverage, "coverage", "", false,
+		"Instrument tests with gcov and write lcov and HTML coverage "+
+			"reports")
+	testCmd.Flags().StringSliceVarP(&testBsps, "bsp", "", nil,
+		"Run the tests against each of these BSPs (comma separated)")
+	testCmd.Flags().StringVarP(&testHwTarget, "target", "", "",
+		"Run the tests on the hardware target's device instead of the "+
+			"simulator")
+	testCmd.Flags().StringVarP(&testConsole.Device, "console", "", "",
+		"Serial device the test results are read from (with --target)")
+	testCmd.Flags().IntVarP(&testConsole.Baud, "baud", "", 115200,
+		"Baud rate of the --console serial device")
+	testCmd.Flags().StringVarP(&testConsole.Cmd, "console-cmd", "", "",
+		"Shell command whose output carries the device console, e.g., "+
+			"an RTT client (with --target)")
+	testCmd.Flags().StringVarP(&testDonePattern, "done-pattern", "",
+		builder.HW_TEST_DONE_PATTERN,
+		"Regular expression matching the console line printed once "+
+			"all tests have run (with --target)")
+	testCmd.Flags().BoolVarP(&testAsan, "asan", "", false,
+		"Build and run tests with AddressSanitizer (sim BSPs only)")
+	testCmd.Flags().BoolVarP(&testUbsan, "ubsan", "", false,
+		"Build and run tests with UndefinedBehaviorSanitizer (sim BSPs only)")
+	testCmd.Flags().BoolVarP(&testTsan, "tsan", "", false,
+		"Build and run tests with ThreadSanitizer (sim BSPs only)")
+	testCmd.Flags().Float64VarP(&testCoverageMin, "coverage-min", "", 0,
+		"Fail if the line coverage of any tested package is below this "+
+			"percentage; implies --coverage")
 	cmd.AddCommand(testCmd)
 	AddTabCompleteFn(testCmd, func() []string {
 		return append(testablePkgList(), "all", "allexcept")
 	})
 
-	loadHelpText := "Load application image on to the board for <target-name>"
+	loadHelpText := "Load application image on to the board for " +
+		"<target-name>.\n\n" +
+		"By default, the BSP's download script is used.  With --jtag " +
+		"pyocd|probe-rs,\nnewt programs the board itself using the " +
+		"backend described by the BSP's\nbsp.probe settings.  A BSP can " +
+		"select a backend by default with\nbsp.probe_default; --jtag " +
+		"script restores the script.\n\n" +
+		"With --method serial, newt programs the board through its " +
+		"bootloader instead,\nusing the protocol in the BSP's " +
+		"bsp.serial_load settings (mcumgr, nrf-dfu,\nor stm32).  " +
+		"For mcumgr, --port ble:<name> connects to a BLE peer.\n\n" +
+		"When several boards are attached, --serial selects the debug " +
+		"probe by serial\nnumber; the BSP's scripts receive it in " +
+		"PROBE_SERIAL.\n\n" +
+		"--program-areas also programs the BSP's EEPROM and OTP flash " +
+		"areas from the\ndata files bsp.yml provides for them.  " +
+		"Write-once areas are read back first;\nones that already hold " +
+		"the data are skipped, and ones holding anything else\nare " +
+		"refused.  The BSP's download script receives the area " +
+		"descriptors in\nPROGRAM_DESC."
+	loadHelpEx := "  newt load my_target\n"
+	loadHelpEx += "  newt load my_target --jtag pyocd\n"
+	loadHelpEx += "  newt load my_target --method serial --port " +
+		"/dev/ttyUSB0\n"
+	loadHelpEx += "  newt load my_target --jtag pyocd --serial " +
+		"0240000034544e45\n"
 
 	loadCmd := &cobra.Command{
-		Use:   "load <target-name>",
-		Short: "Load built target to board",
-		Long:  loadHelpText,
-		Run:   loadRunCmd,
+		Use:     "load <target-name>",
+		Short:   "Load built target to board",
+		Long:    loadHelpText,
+		Example: loadHelpEx,
+		Run:     loadRunCmd,
 	}
 
 	cmd.AddCommand(loadCmd)
 	AddTabCompleteFn(loadCmd, targetList)
 
+	addLoadFleetCommand(cmd)
+
 	loadCmd.PersistentFlags().StringVarP(&extraJtagCmd, "extrajtagcmd", "", "",
 		"Extra commands to send to JTAG software")
-
-	debugHelpText := "Open a debugger session for <target-name>"
+	loadCmd.PersistentFlags().StringVarP(&jtagBackend, "jtag", "", "",
+		"Debug probe backend to load with (pyocd, probe-rs, or script)")
+	addLoadMethodFlags(loadCmd)
+	loadCmd.PersistentFlags().BoolVarP(&loadProgramAreas, "program-areas",
+		"", false, "Also program the BSP's EEPROM and OTP areas")
+
+	debugHelpText := "Open a debugger session for <target-name>.\n\n" +
+		"With --jtag pyocd|probe-rs, newt starts the backend's GDB server " +
+		"and attaches\nthe toolchain's gdb to it instead of running the " +
+		"BSP's debug script.\n\n" +
+		"Each build generates a GDB script next to the app's executable " +
+		"(<app>.gdbinit), which\nthe debugger loads.  It defines " +
+		"RTOS-aware commands using the kernel's\naddresses in that " +
+		"build: mynewt_tasks, mynewt_mbufs, mynewt_mempools and\n" +
+		"mynewt_sched.  BSP debug scripts receive it in " +
+		"EXTRA_GDB_CMDS."
 
 	debugCmd := &cobra.Command{
 		Use:   "debug <target-name>",
@@ -460,28 +1340,107 @@ func AddBuildCommands(cmd *cobra.Command) {
 
 	debugCmd.PersistentFlags().StringVarP(&extraJtagCmd, "extrajtagcmd", "",
 		"", "Extra commands to send to JTAG software")
+	debugCmd.PersistentFlags().StringVarP(&jtagBackend, "jtag", "", "",
+		"Debug probe backend to debug with (pyocd, probe-rs, or script)")
 	debugCmd.PersistentFlags().BoolVarP(&noGDB_flag, "noGDB", "n", false,
 		"Do not start GDB from command line")
+	addProbeSerialFlag(debugCmd)
 
 	cmd.AddCommand(debugCmd)
 	AddTabCompleteFn(debugCmd, targetList)
 
+	attachHelpText := "Attach a debugger to the running app of " +
+		"<target-name> without loading or\nresetting it.  newt starts the " +
+		"GDB server of the probe backend selected with\n--jtag (or the " +
+		"BSP's bsp.probe_default), or reuses a server already\n" +
+		"listening on the port, and stops the server it started when gdb " +
+		"exits."
+	attachHelpEx := "  newt attach my_target\n"
+	attachHelpEx += "  newt attach my_target --jtag probe-rs --reset\n"
+	attachHelpEx += "  newt attach my_target --ex \"break main\" " +
+		"--ex continue\n"
+
+	attachCmd := &cobra.Command{
+		Use:     "attach <target-name>",
+		Short:   "Attach debugger to running target",
+		Long:    attachHelpText,
+		Example: attachHelpEx,
+		Run:     attachRunCmd,
+	}
+
+	attachCmd.PersistentFlags().StringVarP(&jtagBackend, "jtag", "", "",
+		"Debug probe backend to attach with (pyocd or probe-rs)")
+	attachCmd.PersistentFlags().IntVarP(&attachOpts.GdbPort, "gdb-port",
+		"", 0, "Port of the GDB server (default: the backend's port)")
+	attachCmd.PersistentFlags().BoolVarP(&attachOpts.Reset, "reset", "",
+		false, "Reset and halt the target after attaching")
+	attachCmd.PersistentFlags().StringArrayVarP(&attachOpts.Commands,
+		"ex", "", nil, "gdb command to run after attaching; may be "+
+			"repeated")
+	attachCmd.PersistentFlags().BoolVarP(&attachOpts.NoGDB, "noGDB", "n",
+		false, "Only run the GDB server")
+	addProbeSerialFlag(attachCmd)
+
+	cmd.AddCommand(attachCmd)
+	AddTabCompleteFn(attachCmd, targetList)
+
 	sizeHelpText := "Calculate the size of target components specified by " +
-		"<target-name>."
+		"<target-name>.\n\n" +
+		"Flash and RAM usage is attributed to packages by the archive " +
+		"each linked object comes from, and broken down by output " +
+		"section.  --section restricts the report to symbols placed in " +
+		"the given output sections (e.g., --section .bss,.data), and " +
+		"--symbols N lists the N largest symbols.  The report can be " +
+		"exported with --json or --csv for tracking over time.\n\n" +
+		"--why <symbol> uses the linker map's cross reference table to " +
+		"show where a symbol is defined, whether its section was kept " +
+		"or discarded by --gc-sections, which files reference it, and " +
+		"which reference pulled its object out of its archive.  " +
+		"--discarded summarizes the kept and discarded input sections " +
+		"of each package; add -v to list the discarded sections.\n\n" +
+		"--ram-budget accounts for all of RAM: the static data, with the " +
+		"task stacks (from *_STACK_SIZE settings), msys mbuf pools and " +
+		"other syscfg-sized pools (e.g., BLE connections, with the " +
+		"settings sizing them and the cost of one element) it " +
+		"contains, the interrupt stack and the heap (from the linker " +
+		"script's __StackLimit/__StackTop and __HeapBase/__HeapLimit " +
+		"symbols), and what remains unused."
+	sizeHelpEx := "  newt size my_target\n"
+	sizeHelpEx += "  newt size my_target --symbols 20 --section .text\n"
+	sizeHelpEx += "  newt size my_target --csv > sizes.csv\n"
+	sizeHelpEx += "  newt size my_target --why os_mempool_init\n"
 
 	var ram, flash bool
 	sizeCmd := &cobra.Command{
-		Use:   "size <target-name>",
-		Short: "Size of target components",
-		Long:  sizeHelpText,
+		Use:     "size <target-name> [target-names...]",
+		Short:   "Size of target components",
+		Long:    sizeHelpText,
+		Example: sizeHelpEx,
 		Run: func(cmd *cobra.Command, args []string) {
 			sizeRunCmd(cmd, args, ram, flash)
 		},
 	}
 
+	addBulkFlags(sizeCmd)
 	sizeCmd.Flags().BoolVarP(&ram, "ram", "R", false, "Print RAM statistics")
 	sizeCmd.Flags().BoolVarP(&flash, "flash", "F", false,
 		"Print FLASH statistics")
+	sizeCmd.Flags().IntVarP(&sizeOpts.Symbols, "symbols", "", 0,
+		"List the specified number of largest symbols")
+	sizeCmd.Flags().StringSliceVarP(&sizeOpts.Sections, "section", "", nil,
+		"Only count symbols in these output sections")
+	sizeCmd.Flags().BoolVarP(&sizeCsv, "csv", "", false,
+		"Print the report in CSV format")
+	sizeCmd.Flags().StringVarP(&sizeWhy, "why", "", "",
+		"Explain why the specified symbol is part of the image")
+	sizeCmd.Flags().BoolVarP(&sizeDiscarded, "discarded", "", false,
+		"List the input sections kept and discarded by --gc-sections")
+	sizeCmd.Flags().BoolVarP(&sizeRamBudget, "ram-budget", "", false,
+		"Break RAM usage down into task stacks, msys and syscfg-sized "+
+			"pools, heap and other static data")
+
+	addSizeDiffCommand(sizeCmd)
+	addSizeTrendCommands(sizeCmd)
 
 	cmd.AddCommand(sizeCmd)
 	AddTabCompleteFn(sizeCmd, targetList)
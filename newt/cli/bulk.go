@@ -0,0 +1,294 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+// Outcome of a single target's operation during a bulk run.
+type bulkResult struct {
+	Target   string  `json:"target"`
+	Passed   bool    `json:"passed"`
+	Error    string  `json:"error,omitempty"`
+	Class    string  `json:"error_class,omitempty"`
+	Duration float64 `json:"duration_sec"`
+}
+
+// Number of targets to process concurrently; 1 processes them in-process,
+// one after another.
+var bulkParallel int
+
+func addBulkFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&bulkParallel, "parallel", "", 1,
+		"Number of targets to process concurrently; each runs in a "+
+			"separate newt process")
+}
+
+// Runs the specified operation on each target in turn.  A failure does not
+// stop the remaining targets from being processed.  Global state is reset
+// between targets, so each target is re-resolved before the operation runs.
+func runBulk(targets []*target.Target,
+	op func(t *target.Target) error) []bulkResult {
+
+	results := make([]bulkResult, len(targets))
+	for i, t := range targets {
+		results[i].Target = t.FullName()
+		start := time.Now()
+
+		err := func() error {
+			if i > 0 {
+				if err := ResetGlobalState(); err != nil {
+					return err
+				}
+			}
+
+			// Look up the target by name.  This has to be done a second time
+			// here now that the project has been reset.
+			rt := ResolveTarget(t.FullName())
+			if rt == nil {
+				return util.FmtNewtError("Failed to resolve target: %s",
+					t.Name())
+			}
+
+			return op(rt)
+		}()
+
+		results[i].Duration = time.Since(start).Seconds()
+		if err != nil {
+			results[i].Error = bulkErrorText(err)
+			results[i].Class = util.ErrClass(err)
+			if len(targets) > 1 {
+				util.ErrorMessage(util.VERBOSITY_QUIET, "%s: %s\n",
+					colorText(ANSI_RED, "Error"), results[i].Error)
+			}
+		} else {
+			results[i].Passed = true
+		}
+	}
+
+	return results
+}
+
+func bulkErrorText(err error) string {
+	if nerr, ok := err.(*util.NewtError); ok {
+		return strings.TrimSpace(nerr.Text)
+	}
+	return err.Error()
+}
+
+// Reconstructs the flags the user specified for the current command so that
+// they can be passed to a child newt process.  Flags that only make sense for
+// the parent are omitted.
+func bulkChildFlags(cmd *cobra.Command) []string {
+	args := []string{}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		switch f.Name {
+		case "parallel", "outfile":
+			return
+		}
+
+		for _, val := range bulkFlagValues(cmd.Flags(), f) {
+			args = append(args, "--"+f.Name+"="+val)
+		}
+	})
+
+	return args
+}
+
+// Returns the command line values that reproduce a flag's setting.  A slice
+// flag's String() renders as "[a,b]", which doesn't parse back, so each
+// element is passed as a separate occurrence of the flag instead.
+func bulkFlagValues(flags *pflag.FlagSet, f *pflag.Flag) []string {
+	switch f.Value.Type() {
+	case "stringSlice":
+		elems, _ := flags.GetStringSlice(f.Name)
+		vals := make([]string, len(elems))
+		for i, elem := range elems {
+			// Each occurrence is itself parsed as CSV.
+			vals[i] = bulkCsvField(elem)
+		}
+		return vals
+
+	case "stringArray":
+		vals, _ := flags.GetStringArray(f.Name)
+		return vals
+
+	case "intSlice":
+		elems, _ := flags.GetIntSlice(f.Name)
+		vals := make([]string, len(elems))
+		for i, elem := range elems {
+			vals[i] = strconv.Itoa(elem)
+		}
+		return vals
+
+	default:
+		return []string{f.Value.String()}
+	}
+}
+
+func bulkCsvField(s string) string {
+	if !strings.ContainsAny(s, ",\"\r\n") {
+		return s
+	}
+
+	return "\"" + strings.Replace(s, "\"", "\"\"", -1) + "\""
+}
+
+// Runs "newt <subcmd> <target>" for each target in a separate process, with
+// up to bulkParallel processes at a time.  Each child's output is buffered and
+// printed as a block once the child terminates, so that the output of
+// different targets is not interleaved.
+func runBulkParallel(cmd *cobra.Command, targets []*target.Target) []bulkResult {
+	exe, err := util.Executable()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	flags := bulkChildFlags(cmd)
+	results := make([]bulkResult, len(targets))
+
+	var outMtx sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, bulkParallel)
+
+	for i, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, t *target.Target) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			args := append([]string{cmd.Name()}, flags...)
+			args = append(args, t.FullName())
+
+			var out bytes.Buffer
+			child := exec.Command(exe, args...)
+			child.Stdout = &out
+			child.Stderr = &out
+
+			start := time.Now()
+			err := child.Run()
+
+			results[i].Target = t.FullName()
+			results[i].Duration = time.Since(start).Seconds()
+			if err != nil {
+				results[i].Error = err.Error()
+				results[i].Class = util.ERR_CLASS_GENERAL
+				if exitErr, ok := err.(*exec.ExitError); ok {
+					ws, ok := exitErr.Sys().(syscall.WaitStatus)
+					if ok {
+						results[i].Class = util.ErrClassFromExitCode(
+							ws.ExitStatus())
+					}
+				}
+			} else {
+				results[i].Passed = true
+			}
+
+			outMtx.Lock()
+			defer outMtx.Unlock()
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "==> %s <==\n%s",
+				t.FullName(), out.String())
+		}(i, t)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Runs the specified operation on each target, in-process or in parallel
+// child processes depending on the --parallel setting.  JSON output cannot be
+// combined from several processes, so targets are always processed in-process
+// in JSON mode.  In text mode, a pass/fail summary is printed if more than one
+// target was processed.  Returns an error if any target failed.
+func runBulkCmd(cmd *cobra.Command, targets []*target.Target,
+	op func(t *target.Target) error) ([]bulkResult, error) {
+
+	var results []bulkResult
+	if bulkParallel > 1 && len(targets) > 1 && !newtutil.NewtJson {
+		results = runBulkParallel(cmd, targets)
+	} else {
+		results = runBulk(targets, op)
+	}
+
+	if len(targets) == 1 && !results[0].Passed {
+		return results, util.NewNewtError(results[0].Error).WithClass(
+			results[0].Class)
+	}
+
+	if len(targets) > 1 && !newtutil.NewtJson {
+		printBulkMatrix(results)
+	}
+
+	// The failures only share a class if every target failed the same way.
+	failed := 0
+	class := ""
+	for _, r := range results {
+		if !r.Passed {
+			failed++
+			if class == "" {
+				class = r.Class
+			} else if class != r.Class {
+				class = util.ERR_CLASS_GENERAL
+			}
+		}
+	}
+	if failed > 0 {
+		return results, util.FmtNewtError("%s failed for %d of %d targets",
+			cmd.Name(), failed, len(results)).WithClass(class)
+	}
+
+	return results, nil
+}
+
+func printBulkMatrix(results []bulkResult) {
+	width := len("Target")
+	for _, r := range results {
+		if len(r.Target) > width {
+			width = len(r.Target)
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_QUIET, "\n%-*s  %-6s  %s\n",
+		width, "Target", "Result", "Time")
+	for _, r := range results {
+		status := fmt.Sprintf("%-6s", "pass")
+		if !r.Passed {
+			status = colorText(ANSI_RED, fmt.Sprintf("%-6s", "FAIL"))
+		}
+		util.StatusMessage(util.VERBOSITY_QUIET, "%-*s  %s  %.1fs\n",
+			width, r.Target, status, r.Duration)
+	}
+}
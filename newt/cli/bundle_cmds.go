@@ -0,0 +1,161 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/interfaces"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
+)
+
+var bundleOut string
+var bundleToolchains bool
+
+func printBundleManifest(m *project.BundleManifest) {
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Project: %s\n", m.Project)
+	for _, r := range m.Repos {
+		vers := ""
+		if r.Version != "" {
+			vers = " " + r.Version
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "    repo %s%s (%s)\n",
+			r.Name, vers, r.Commit)
+	}
+	for _, tc := range m.Toolchains {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"    toolchain %s %s (%s)\n", tc.Name, tc.Version, tc.Platform)
+	}
+}
+
+func bundleExportRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) > 0 {
+		NewtUsage(cmd, nil)
+	}
+
+	proj := TryGetProject()
+	interfaces.SetProject(proj)
+
+	out := bundleOut
+	if out == "" {
+		out = proj.Name() + "-bundle.tar.gz"
+	}
+
+	m, err := proj.ExportBundle(out, bundleToolchains)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(m)
+		return
+	}
+
+	printBundleManifest(m)
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Wrote bundle %s\n", out)
+}
+
+func bundleImportRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		NewtUsage(cmd, util.NewNewtError(
+			"Must specify a bundle and, optionally, a destination"))
+	}
+
+	dst := ""
+	if len(args) > 1 {
+		dst = args[1]
+	} else {
+		m, err := project.ReadBundleManifest(args[0])
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+		dst = m.Project
+	}
+
+	m, err := project.ImportBundle(args[0], dst)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(m)
+		return
+	}
+
+	printBundleManifest(m)
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Imported project to %s; build with --offline to ensure no "+
+			"network access is attempted\n", dst)
+}
+
+func AddBundleCommands(cmd *cobra.Command) {
+	bundleCmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Move a project to a machine without network access",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Usage()
+		},
+	}
+
+	cmd.AddCommand(bundleCmd)
+
+	exportHelpText := "Write a single archive containing the project, " +
+		"excluding its bin directory, and every installed repo at the " +
+		"commit recorded in " + project.PROJECT_LOCK_FILE + ".  The repos " +
+		"must match the lock file and pass the integrity check.\n\n" +
+		"With --toolchains, the installed versions of the toolchains " +
+		"pinned in project.yml are included too.  Toolchains only work " +
+		"on machines of the platform they were installed on."
+	exportHelpEx := "  newt bundle export\n"
+	exportHelpEx += "  newt bundle export --toolchains --out my_proj.tar.gz\n"
+
+	exportCmd := &cobra.Command{
+		Use:     "export",
+		Short:   "Bundle the project and its repos into an archive",
+		Long:    exportHelpText,
+		Example: exportHelpEx,
+		Run:     bundleExportRunCmd,
+	}
+	exportCmd.Flags().StringVarP(&bundleOut, "out", "", "",
+		"Archive to write (default: <project>-bundle.tar.gz)")
+	exportCmd.Flags().BoolVarP(&bundleToolchains, "toolchains", "", false,
+		"Include the project's pinned toolchains")
+	bundleCmd.AddCommand(exportCmd)
+
+	importHelpText := "Extract a bundle written by \"newt bundle export\" " +
+		"into a buildable project.  The destination directory, which " +
+		"defaults to the project's name, must not exist or be empty.  " +
+		"Bundled toolchains are installed to the toolchains directory " +
+		"unless they are already installed or were made for another " +
+		"platform."
+	importHelpEx := "  newt bundle import my_proj-bundle.tar.gz\n"
+	importHelpEx += "  newt bundle import my_proj-bundle.tar.gz /opt/src/my_proj\n"
+
+	importCmd := &cobra.Command{
+		Use:     "import <bundle> [<dir>]",
+		Short:   "Reconstruct a project from a bundle",
+		Long:    importHelpText,
+		Example: importHelpEx,
+		Run:     bundleImportRunCmd,
+	}
+	bundleCmd.AddCommand(importCmd)
+}
@@ -0,0 +1,135 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+func compareValue(present bool, val string) string {
+	switch {
+	case !present:
+		return "(unset)"
+	case val == "":
+		return "''"
+	default:
+		return val
+	}
+}
+
+func printTargetComparison(tc *builder.TargetComparison) {
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "A: %s\nB: %s\n",
+		tc.TargetA, tc.TargetB)
+
+	if tc.Identical() {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"\nThe targets do not differ in any compared respect\n")
+		return
+	}
+
+	for _, c := range tc.Categories {
+		if len(c.Entries) == 0 {
+			util.StatusMessage(util.VERBOSITY_VERBOSE, "\n%s: no change\n",
+				c.Name)
+			continue
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "\n%s (%d):\n", c.Name,
+			len(c.Entries))
+		for _, e := range c.Entries {
+			if c.Set {
+				if e.InA {
+					util.StatusMessage(util.VERBOSITY_DEFAULT,
+						"  %s %s\n", colorText(ANSI_RED, "A only:"), e.Name)
+				} else {
+					util.StatusMessage(util.VERBOSITY_DEFAULT,
+						"  %s %s\n", colorText(ANSI_GREEN, "B only:"), e.Name)
+				}
+			} else {
+				util.StatusMessage(util.VERBOSITY_DEFAULT,
+					"  %s: %s -> %s\n", e.Name, compareValue(e.InA, e.A),
+					compareValue(e.InB, e.B))
+			}
+		}
+	}
+}
+
+func compareTargetsRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		NewtUsage(cmd, util.NewNewtError("Must specify two targets"))
+	}
+
+	TryGetProject()
+
+	builders := make([]*builder.TargetBuilder, len(args))
+	for i, arg := range args {
+		t := ResolveTarget(arg)
+		if t == nil {
+			NewtUsage(cmd, util.NewNewtError("Invalid target name: "+arg))
+		}
+
+		b, err := builder.NewTargetBuilder(t)
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+		builders[i] = b
+	}
+
+	tc, err := builder.CompareTargets(builders[0], builders[1])
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(tc)
+		return
+	}
+
+	printTargetComparison(tc)
+}
+
+func AddCompareCommands(cmd *cobra.Command) {
+	compareHelpText := "Compare the effective configurations of two " +
+		"targets and report the differences by category: target " +
+		"variables (including inherited ones), the resolved package " +
+		"sets, syscfg values, the compiler and linker flags of the " +
+		"app, and the BSP's flash areas.  Values are listed as A -> B; " +
+		"(unset) marks a variable or setting the target lacks.  With -v, " +
+		"categories without differences are listed too.\n\n" +
+		"Both targets' builds are prepared, i.e., their generated " +
+		"headers are written, but nothing is compiled."
+	compareHelpEx := "  newt compare-targets my_blinky my_blinky_dbg\n"
+	compareHelpEx += "  newt compare-targets --json sensor_a sensor_b\n"
+
+	compareCmd := &cobra.Command{
+		Use:     "compare-targets <target-a> <target-b>",
+		Short:   "Report how two targets' configurations differ",
+		Long:    compareHelpText,
+		Example: compareHelpEx,
+		Run:     compareTargetsRunCmd,
+	}
+
+	cmd.AddCommand(compareCmd)
+	AddTabCompleteFn(compareCmd, targetList)
+}
@@ -26,8 +26,12 @@ import (
 	"sort"
 	"strings"
 
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/interfaces"
+	"mynewt.apache.org/newt/newt/newtutil"
 	"mynewt.apache.org/newt/newt/pkg"
 	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -35,7 +39,18 @@ import (
 
 type TabCompleteFn func() []string
 
+// Generates completions for a positional argument other than the first.  The
+// function is passed the partial word being completed and returns full-word
+// candidates.
+type ArgCompleteFn func(word string) []string
+
 var tabCompleteEntries = map[*cobra.Command]TabCompleteFn{}
+var argCompleteEntries = map[*cobra.Command]ArgCompleteFn{}
+
+// Characters that bash treats as word separators in addition to whitespace.
+// Candidates are trimmed up to the last of these so that bash substitutes
+// only the portion it considers the current word.
+const compWordBreaks = "=:"
 
 func AddTabCompleteFn(cmd *cobra.Command, cb TabCompleteFn) {
 	if cmd.ValidArgs != nil || tabCompleteEntries[cmd] != nil {
@@ -46,6 +61,15 @@ func AddTabCompleteFn(cmd *cobra.Command, cb TabCompleteFn) {
 	tabCompleteEntries[cmd] = cb
 }
 
+func AddArgCompleteFn(cmd *cobra.Command, cb ArgCompleteFn) {
+	if argCompleteEntries[cmd] != nil {
+		panic("argument completion generated twice for command " +
+			cmd.Name())
+	}
+
+	argCompleteEntries[cmd] = cb
+}
+
 func GenerateTabCompleteValues() {
 	for cmd, cb := range tabCompleteEntries {
 		cmd.ValidArgs = cb()
@@ -118,6 +142,106 @@ func mfgList() []string {
 	return targetNames
 }
 
+func pkgTypeList(pkgType interfaces.PackageType) []string {
+	return pkgNameList(func(pack *pkg.LocalPackage) bool {
+		return pack.Type() == pkgType
+	})
+}
+
+/* @return                      A sorted slice of all syscfg setting names. */
+func syscfgSettingList() []string {
+	proj, err := project.TryGetProject()
+	if err != nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, pack := range proj.PackagesOfType(-1) {
+		lpkg := pack.(*pkg.LocalPackage)
+		defs := newtutil.GetStringMapFeatures(lpkg.SyscfgV, nil,
+			"syscfg.defs")
+		for name, _ := range defs {
+			seen[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name, _ := range seen {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Completes the <var-name>=<value> arguments accepted by "target set" and
+// "target amend".  Package-valued variables complete to package names of the
+// appropriate type; syscfg completes setting names.
+func targetVarCompleter(varNames []string) ArgCompleteFn {
+	return func(word string) []string {
+		eq := strings.Index(word, "=")
+		if eq == -1 {
+			names := make([]string, len(varNames))
+			for i, name := range varNames {
+				names[i] = name + "="
+			}
+			return names
+		}
+
+		key := word[:eq]
+		var vals []string
+		switch key {
+		case "app", "loader":
+			vals = pkgTypeList(pkg.PACKAGE_TYPE_APP)
+		case "bsp":
+			vals = pkgTypeList(pkg.PACKAGE_TYPE_BSP)
+		case "inherits":
+			vals = targetList()
+		case "sanitizers":
+			vals = []string{builder.SANITIZER_ADDRESS,
+				builder.SANITIZER_UNDEFINED, builder.SANITIZER_THREAD}
+		case "syscfg":
+			// Only complete setting names; the value following a setting's
+			// '=' is free-form.
+			settings := word[eq+1:]
+			colon := strings.LastIndex(settings, ":")
+			if strings.Contains(settings[colon+1:], "=") {
+				return nil
+			}
+
+			prefix := word[:eq+1] + settings[:colon+1]
+			names := syscfgSettingList()
+			for i, name := range names {
+				names[i] = prefix + name
+			}
+			return names
+		default:
+			return nil
+		}
+
+		for i, val := range vals {
+			vals[i] = key + "=" + val
+		}
+		return vals
+	}
+}
+
+// Prints the candidates that begin with the specified word.  Unless the shell
+// requested full words, each candidate is trimmed to the part following the
+// word's last bash word-break character.
+func printCompletions(word string, candidates []string) {
+	trim := 0
+	if os.Getenv("NEWT_COMP_FULL_WORD") == "" {
+		trim = strings.LastIndexAny(word, compWordBreaks) + 1
+	}
+
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			fmt.Printf("%s\n", c[trim:])
+		}
+	}
+}
+
 func completeRunCmd(cmd *cobra.Command, args []string) {
 	cmd_line := os.Getenv("COMP_LINE")
 
@@ -158,7 +282,7 @@ func completeRunCmd(cmd *cobra.Command, args []string) {
 	/* skip flags for now. This just removes them */
 	/* skip over complete flags. So the current bash autocomplete will
 	 * not complete flags */
-	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+	found_cmd.Flags().VisitAll(func(flag *pflag.Flag) {
 		flg := fmt.Sprintf("--%s", flag.Name)
 		if flag.Value.Type() == "bool" {
 			/* skip the flag */
@@ -170,6 +294,10 @@ func completeRunCmd(cmd *cobra.Command, args []string) {
 			extra_str = r.ReplaceAllString(extra_str, "")
 		}
 
+		if len(flag.Shorthand) == 0 {
+			return
+		}
+
 		sflg := fmt.Sprintf("-%s", flag.Shorthand)
 		if flag.Value.Type() == "bool" {
 			/* skip the flag */
@@ -190,17 +318,21 @@ func completeRunCmd(cmd *cobra.Command, args []string) {
 
 	extra_str = strings.TrimLeft(extra_str, " ")
 
+	/* only the last word is being completed */
+	words := strings.Split(extra_str, " ")
+	cur_word := words[len(words)-1]
+
 	/* give flag hints if the person asks for them */
-	showShort := strings.HasPrefix(extra_str, "-") &&
-		!strings.HasPrefix(extra_str, "--")
+	showShort := strings.HasPrefix(cur_word, "-") &&
+		!strings.HasPrefix(cur_word, "--")
 
-	showLong := strings.HasPrefix(extra_str, "--") ||
-		extra_str == "-"
+	showLong := strings.HasPrefix(cur_word, "--") ||
+		cur_word == "-"
 
 	if showLong {
 		r := regexp.MustCompile("^--[^\\W]+")
-		partial_flag := r.FindString(extra_str)
-		cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		partial_flag := r.FindString(cur_word)
+		found_cmd.Flags().VisitAll(func(flag *pflag.Flag) {
 			flg := fmt.Sprintf("--%s", flag.Name)
 			if strings.HasPrefix(flg, partial_flag) {
 				fmt.Println(flg)
@@ -210,8 +342,8 @@ func completeRunCmd(cmd *cobra.Command, args []string) {
 
 	if showShort {
 		r := regexp.MustCompile("^-[^\\W]+")
-		partial_flag := r.FindString(extra_str)
-		cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		partial_flag := r.FindString(cur_word)
+		found_cmd.Flags().VisitAll(func(flag *pflag.Flag) {
 			if len(flag.Shorthand) > 0 {
 				flg := fmt.Sprintf("-%s", flag.Shorthand)
 				if strings.HasPrefix(flg, partial_flag) {
@@ -221,21 +353,71 @@ func completeRunCmd(cmd *cobra.Command, args []string) {
 		})
 	}
 
-	/* dump out valid arguments */
-	for _, c := range found_cmd.ValidArgs {
-		if strings.HasPrefix(c, extra_str) {
-			fmt.Printf("%s\n", c)
-		}
+	if showShort || showLong {
+		return
+	}
+
+	/* commands with a custom completer handle arguments after the first */
+	argCb := argCompleteEntries[found_cmd]
+	if argCb != nil && len(words) > 1 {
+		printCompletions(cur_word, argCb(cur_word))
+		return
 	}
 
+	/* dump out valid arguments */
+	printCompletions(cur_word, found_cmd.ValidArgs)
+
 	/* dump out possible sub commands */
-	for _, child_cmd := range found_cmd.Commands() {
-		if strings.HasPrefix(child_cmd.Name(), extra_str) {
-			fmt.Printf("%s\n", child_cmd.Name())
+	if len(words) == 1 {
+		for _, child_cmd := range found_cmd.Commands() {
+			if !child_cmd.Hidden &&
+				strings.HasPrefix(child_cmd.Name(), cur_word) {
+
+				fmt.Printf("%s\n", child_cmd.Name())
+			}
 		}
 	}
 }
 
+const bashCompletionScript = `# bash completion for newt
+complete -o default -C 'newt complete' newt
+`
+
+const zshCompletionScript = `# zsh completion for newt
+autoload -U +X bashcompinit && bashcompinit
+complete -o default -C 'newt complete' newt
+`
+
+const fishCompletionScript = `# fish completion for newt
+function __newt_complete
+    set -lx COMP_LINE (commandline -cp)
+    set -lx NEWT_COMP_FULL_WORD 1
+    newt complete 2>/dev/null
+end
+
+complete -c newt -f -a '(__newt_complete)'
+`
+
+func completionRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify a shell"))
+	}
+
+	var script string
+	switch args[0] {
+	case "bash":
+		script = bashCompletionScript
+	case "zsh":
+		script = zshCompletionScript
+	case "fish":
+		script = fishCompletionScript
+	default:
+		NewtUsage(cmd, util.FmtNewtError("Unsupported shell: %s", args[0]))
+	}
+
+	fmt.Print(script)
+}
+
 func AddCompleteCommands(cmd *cobra.Command) {
 
 	completeCmd := &cobra.Command{
@@ -249,4 +431,23 @@ func AddCompleteCommands(cmd *cobra.Command) {
 	/* silence errors on the complete command because we have partial flags */
 	completeCmd.SilenceErrors = true
 	cmd.AddCommand(completeCmd)
+
+	completionHelpText := "Print a completion script for the specified " +
+		"shell (bash, zsh, or fish).  The script completes commands, " +
+		"flags, target names, package names, and syscfg settings by " +
+		"querying the current project."
+	completionHelpEx := "  source <(newt completion bash)\n"
+	completionHelpEx += "  newt completion fish > " +
+		"~/.config/fish/completions/newt.fish"
+
+	completionCmd := &cobra.Command{
+		Use:       "completion <shell>",
+		Short:     "Generate a shell completion script",
+		Long:      completionHelpText,
+		Example:   completionHelpEx,
+		Run:       completionRunCmd,
+		ValidArgs: []string{"bash", "fish", "zsh"},
+	}
+
+	cmd.AddCommand(completionCmd)
 }
@@ -0,0 +1,94 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/util"
+)
+
+var confImageOpts builder.ConfImageOptions
+
+func confImageRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		NewtUsage(cmd, util.NewNewtError(
+			"Must specify target and settings file"))
+	}
+
+	TryGetProject()
+
+	t := ResolveTarget(args[0])
+	if t == nil {
+		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
+	}
+
+	b, err := builder.NewTargetBuilder(t)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	out, err := b.ConfImage(args[1], confImageOpts)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Config image: %s\n", out)
+}
+
+func AddConfImageCommands(cmd *cobra.Command) {
+	confImageHelpText := "Build an image of the target's config FCB " +
+		"(sys/config with CONFIG_FCB) holding the settings in a YAML " +
+		"file, so that devices boot with factory settings already " +
+		"stored.\n\n" +
+		"Each key is a config name and each value is stored as the string " +
+		"sys/config would save; nested mappings form slash-separated " +
+		"names and booleans are stored as 1 or 0.  The area defaults to " +
+		"CONFIG_FCB_FLASH_AREA and the write alignment to " +
+		"MCU_FLASH_MIN_WRITE_SIZE; --sector-size must match the flash " +
+		"sectors the area is divided into.\n\n" +
+		"The image can be flashed at the area's offset or included in an " +
+		"mfg image with a raw entry naming the file and the area."
+	confImageHelpEx := "  newt config-image my_target factory.yml\n"
+	confImageHelpEx += "  newt config-image my_target factory.yml " +
+		"--sector-size 16384 --out config.bin\n"
+
+	confImageCmd := &cobra.Command{
+		Use:     "config-image <target-name> <settings.yml>",
+		Short:   "Build a pre-populated config FCB image",
+		Long:    confImageHelpText,
+		Example: confImageHelpEx,
+		Run:     confImageRunCmd,
+	}
+
+	confImageCmd.Flags().StringVarP(&confImageOpts.Area, "area", "", "",
+		"Flash area holding the config FCB")
+	confImageCmd.Flags().IntVarP(&confImageOpts.SectorSize, "sector-size",
+		"", 0, "FCB sector size, in bytes (default 4096)")
+	confImageCmd.Flags().IntVarP(&confImageOpts.Align, "align", "", 0,
+		"Flash write alignment, in bytes")
+	confImageCmd.Flags().StringVarP(&confImageOpts.Out, "out", "", "",
+		"Image file to write (default: "+
+			"bin/targets/<target>/config-<area>.bin)")
+
+	cmd.AddCommand(confImageCmd)
+	AddTabCompleteFn(confImageCmd, targetList)
+}
@@ -0,0 +1,184 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+func connDesc(cp *newtutil.ConnProfile) string {
+	switch cp.Type {
+	case newtutil.CONN_TYPE_SERIAL:
+		if cp.Baud != 0 {
+			return fmt.Sprintf("%s at %d baud", cp.Port, cp.Baud)
+		}
+		return cp.Port
+	case newtutil.CONN_TYPE_UDP:
+		return cp.Addr
+	default:
+		return cp.Peer
+	}
+}
+
+func connListCmd(cmd *cobra.Command, args []string) {
+	projDir := TryGetProject().Path()
+
+	profiles, err := newtutil.ReadConnProfiles(projDir)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+	names := newtutil.SortedConnProfileNames(profiles)
+
+	if newtutil.NewtJson {
+		list := make([]*newtutil.ConnProfile, len(names))
+		for i, name := range names {
+			list[i] = profiles[name]
+		}
+		printJson(list)
+		return
+	}
+
+	for _, name := range names {
+		cp := profiles[name]
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s: %s %s\n", name,
+			cp.Type, connDesc(cp))
+	}
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "Connections file: %s\n",
+		newtutil.ConnProfilesPath(projDir))
+}
+
+func connAddCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		NewtUsage(cmd, util.NewNewtError("Must specify a connection name "+
+			"and at least one attribute"))
+	}
+
+	projDir := TryGetProject().Path()
+
+	profiles, err := newtutil.ReadConnProfiles(projDir)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	// Adding an existing profile updates the specified attributes.
+	name := args[0]
+	cp := profiles[name]
+	if cp == nil {
+		cp = &newtutil.ConnProfile{Name: name}
+	}
+
+	for _, arg := range args[1:] {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			NewtUsage(cmd, util.FmtNewtError(
+				"Invalid attribute \"%s\"; expected <name>=<value>", arg))
+		}
+		if err := cp.SetAttr(parts[0], parts[1]); err != nil {
+			NewtUsage(cmd, err)
+		}
+	}
+	if err := cp.Validate(); err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	profiles[name] = cp
+	if err := newtutil.WriteConnProfiles(projDir, profiles); err != nil {
+		NewtUsage(nil, err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Connection %s: %s %s\n",
+		name, cp.Type, connDesc(cp))
+}
+
+func connDeleteCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify a connection name"))
+	}
+
+	projDir := TryGetProject().Path()
+
+	profiles, err := newtutil.ReadConnProfiles(projDir)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+	if profiles[args[0]] == nil {
+		NewtUsage(nil, util.FmtNewtError("Unknown connection: %s", args[0]))
+	}
+
+	delete(profiles, args[0])
+	if err := newtutil.WriteConnProfiles(projDir, profiles); err != nil {
+		NewtUsage(nil, err)
+	}
+}
+
+func AddConnCommands(cmd *cobra.Command) {
+	connHelpText := "Manage the project's connection profiles.  A profile " +
+		"describes how to reach a device: its transport (serial, udp or " +
+		"ble) and serial port, UDP address or BLE peer.  Profiles are " +
+		"stored per developer in .newt/connections.yml in the project " +
+		"directory.\n\n" +
+		"A target uses a profile when its target.connection setting names " +
+		"it (newt target set <target> connection=<name>).  \"newt load " +
+		"--method serial|mgmt\", \"newt image upload\" and \"newt console\" " +
+		"then use the profile's connection unless one is specified on the " +
+		"command line.\n\n" +
+		"Attributes: " + strings.Join(newtutil.ConnProfileAttrs, ", ")
+	connHelpEx := "  newt conn add board1 type=serial port=/dev/ttyACM0 " +
+		"baud=115200\n"
+	connHelpEx += "  newt conn add sim type=udp addr=127.0.0.1:1337\n"
+	connHelpEx += "  newt target set my_target connection=board1\n"
+	connHelpEx += "  newt load my_target --method mgmt"
+
+	connCmd := &cobra.Command{
+		Use:     "conn",
+		Short:   "Manage device connection profiles",
+		Long:    connHelpText,
+		Example: connHelpEx,
+		Run:     connListCmd,
+	}
+	cmd.AddCommand(connCmd)
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the project's connection profiles",
+		Run:   connListCmd,
+	}
+	connCmd.AddCommand(listCmd)
+
+	addCmd := &cobra.Command{
+		Use:   "add <name> <attr>=<value> [<attr>=<value>...]",
+		Short: "Add or update a connection profile",
+		Run:   connAddCmd,
+	}
+	connCmd.AddCommand(addCmd)
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete <name>",
+		Short: "Delete a connection profile",
+		Run:   connDeleteCmd,
+	}
+	connCmd.AddCommand(deleteCmd)
+}
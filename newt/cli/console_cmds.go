@@ -0,0 +1,113 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/util"
+)
+
+var consoleOpts builder.ConsoleOptions
+var consoleRtt bool
+var consoleUart bool
+
+func consoleRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	TryGetProject()
+
+	t := ResolveTarget(args[0])
+	if t == nil {
+		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
+	}
+
+	if consoleRtt && consoleUart {
+		NewtUsage(cmd, util.NewNewtError(
+			"--rtt and --uart are mutually exclusive"))
+	}
+	if consoleRtt {
+		consoleOpts.Source = builder.CONSOLE_SOURCE_RTT
+	} else if consoleUart {
+		consoleOpts.Source = builder.CONSOLE_SOURCE_UART
+	}
+
+	b, err := builder.NewTargetBuilder(t)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+	b.SetProbe(jtagBackend)
+
+	if err := b.Console(consoleOpts); err != nil {
+		NewtUsage(nil, err)
+	}
+}
+
+func AddConsoleCommands(cmd *cobra.Command) {
+	consoleHelpText := "Open the console of <target-name>.\n\n" +
+		"By default, the console is read from the UART.  The serial " +
+		"device is taken from\n--port, the BSP's bsp.console.port (which " +
+		"may be a glob pattern), or the only\nUSB serial device attached; " +
+		"the baud rate from --baud, bsp.console.baud, or\nthe target's " +
+		"CONSOLE_UART_BAUD setting.  Targets whose console is RTT-only, " +
+		"or\n--rtt, read SEGGER RTT through the probe backend selected " +
+		"with --jtag or the\nBSP's bsp.probe_default.\n\n" +
+		"With --log-modules, numeric log module IDs (mod=N) are replaced " +
+		"with the\nmodule's name, from mynewt's predefined modules and the " +
+		"target's *_LOG_MOD\nsettings."
+	consoleHelpEx := "  newt console my_target\n"
+	consoleHelpEx += "  newt console my_target --port /dev/ttyACM0 " +
+		"--timestamps --console-log console.log\n"
+	consoleHelpEx += "  newt console my_target --rtt --jtag pyocd " +
+		"--log-modules\n"
+
+	consoleCmd := &cobra.Command{
+		Use:     "console <target-name>",
+		Short:   "Open target's console",
+		Long:    consoleHelpText,
+		Example: consoleHelpEx,
+		Run:     consoleRunCmd,
+	}
+
+	consoleCmd.Flags().BoolVarP(&consoleUart, "uart", "", false,
+		"Read the console from the UART")
+	consoleCmd.Flags().BoolVarP(&consoleRtt, "rtt", "", false,
+		"Read the console over SEGGER RTT through the debug probe")
+	consoleCmd.Flags().StringVarP(&consoleOpts.Port, "port", "", "",
+		"Serial device of the UART console (default: the target's "+
+			"connection profile's, or detected)")
+	consoleCmd.Flags().IntVarP(&consoleOpts.Baud, "baud", "", 0,
+		"Baud rate of the UART console")
+	consoleCmd.Flags().StringVarP(&jtagBackend, "jtag", "", "",
+		"Debug probe backend for RTT (pyocd or probe-rs)")
+	consoleCmd.Flags().BoolVarP(&consoleOpts.Timestamps, "timestamps", "t",
+		false, "Prefix each line with the time it was received")
+	consoleCmd.Flags().BoolVarP(&consoleOpts.StripAnsi, "strip-ansi", "",
+		false, "Remove ANSI escape sequences from the output")
+	consoleCmd.Flags().StringVarP(&consoleOpts.LogFile, "console-log", "",
+		"", "Also write the console output to the specified file")
+	consoleCmd.Flags().BoolVarP(&consoleOpts.LogModules, "log-modules", "",
+		false, "Show log module names instead of IDs")
+
+	cmd.AddCommand(consoleCmd)
+	AddTabCompleteFn(consoleCmd, targetList)
+}
@@ -0,0 +1,162 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+var coredumpOutput string
+
+func printCoredumpSummary(s *builder.CoredumpSummary) {
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Core dump: %s\n", s.RawPath)
+	if s.ImageHash != "" {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "Image hash: %s\n",
+			s.ImageHash)
+	}
+	if s.BuildMismatch {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"* Warning: the dump was produced by a different image than "+
+				"the current build; symbols may be wrong\n")
+	}
+
+	if s.Exception != "" {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "Context: %s\n",
+			colorText(ANSI_RED, s.Exception))
+	}
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "PC: 0x%08x  %s\n",
+		s.Pc, s.PcSymbol)
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "LR: 0x%08x  %s\n",
+		s.Lr, s.LrSymbol)
+	if s.Task != nil {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Task: %s (id %d, prio %d)\n", s.Task.Name, s.Task.Id,
+			s.Task.Prio)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Registers:\n")
+	line := []string{}
+	for i, r := range s.Regs {
+		line = append(line, fmt.Sprintf("%-4s 0x%08x", r.Name, r.Value))
+		if len(line) == 4 || i == len(s.Regs)-1 {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "    %s\n",
+				strings.Join(line, "  "))
+			line = nil
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"ELF core written to %s; debug with: gdb %s %s\n",
+		s.CorePath, s.ElfPath, s.CorePath)
+}
+
+func decodeAndPrintCoredump(b *builder.TargetBuilder, rawPath string) {
+	summary, err := b.DecodeCoredump(rawPath, rawPath+".elf")
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(summary)
+	} else {
+		printCoredumpSummary(summary)
+	}
+}
+
+func coredumpFetchRunCmd(cmd *cobra.Command, args []string) {
+	b := targetBuilderArg(cmd, args)
+	if err := applyLoadMethod(b); err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	rawPath, err := b.FetchCoredump(coredumpOutput)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	decodeAndPrintCoredump(b, rawPath)
+}
+
+func coredumpDecodeRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		NewtUsage(cmd, util.NewNewtError(
+			"Must specify target and core dump file"))
+	}
+
+	b := targetBuilderArg(cmd, args)
+	decodeAndPrintCoredump(b, args[1])
+}
+
+func AddCoredumpCommands(cmd *cobra.Command) {
+	coredumpCmd := &cobra.Command{
+		Use:   "coredump",
+		Short: "Retrieve and decode core dumps",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	cmd.AddCommand(coredumpCmd)
+
+	fetchHelpText := "Download the core dump stored on the device of " +
+		"<target-name>, convert it\nto an ELF core file that gdb can load " +
+		"together with the target's executable,\nand print a summary of " +
+		"the fault: registers, faulting PC, and running task.\n\n" +
+		"The dump is read from the coredump flash area through the probe " +
+		"backend\nselected with --jtag (or the BSP's bsp.probe_default), " +
+		"or with mcumgr when\n--method serial is given."
+	fetchHelpEx := "  newt coredump fetch my_target --jtag pyocd\n"
+	fetchHelpEx += "  newt coredump fetch my_target --method serial " +
+		"--port /dev/ttyACM0\n"
+
+	fetchCmd := &cobra.Command{
+		Use:     "fetch <target-name>",
+		Short:   "Download and decode a target's core dump",
+		Long:    fetchHelpText,
+		Example: fetchHelpEx,
+		Run:     coredumpFetchRunCmd,
+	}
+	fetchCmd.Flags().StringVarP(&jtagBackend, "jtag", "", "",
+		"Debug probe backend to read the dump with (pyocd or probe-rs)")
+	addLoadMethodFlags(fetchCmd)
+	fetchCmd.Flags().StringVarP(&coredumpOutput, "output", "", "",
+		"Where to write the raw dump (default: next to the executable)")
+
+	coredumpCmd.AddCommand(fetchCmd)
+	AddTabCompleteFn(fetchCmd, targetList)
+
+	decodeHelpText := "Decode a core dump previously downloaded from " +
+		"<target-name>'s device,\nas newt coredump fetch does."
+
+	decodeCmd := &cobra.Command{
+		Use:   "decode <target-name> <dump-file>",
+		Short: "Decode a downloaded core dump",
+		Long:  decodeHelpText,
+		Run:   coredumpDecodeRunCmd,
+	}
+
+	coredumpCmd.AddCommand(decodeCmd)
+	AddTabCompleteFn(decodeCmd, targetList)
+}
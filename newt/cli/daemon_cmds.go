@@ -0,0 +1,65 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/daemon"
+)
+
+var daemonListenAddr string
+
+func daemonRunCmd(cmd *cobra.Command, args []string) {
+	TryGetProject()
+
+	if err := daemon.NewDaemon().Serve(daemonListenAddr); err != nil {
+		NewtUsage(nil, err)
+	}
+}
+
+func AddDaemonCommands(cmd *cobra.Command) {
+	daemonHelpText := "Run a JSON-RPC 2.0 server for IDE integration.  The " +
+		"project is parsed once and kept in memory.  Each request and " +
+		"response is a single line of JSON exchanged over TCP.\n\n" +
+		"Methods:\n" +
+		"    project.info        Project name, path, and repositories\n" +
+		"    project.reload      Re-read the project from disk\n" +
+		"    targets.list        Names of all targets\n" +
+		"    syscfg.query        Settings for {target, [settings]}\n" +
+		"    build.compileFlags  Compiler command for {target, file}\n" +
+		"    build.run           Build {target}"
+	daemonHelpEx := "  newt daemon --listen 127.0.0.1:7340\n"
+	daemonHelpEx += "  echo '{\"jsonrpc\":\"2.0\",\"id\":1," +
+		"\"method\":\"targets.list\"}' | nc 127.0.0.1 7340"
+
+	daemonCmd := &cobra.Command{
+		Use:     "daemon",
+		Short:   "Serve project queries over JSON-RPC",
+		Long:    daemonHelpText,
+		Example: daemonHelpEx,
+		Run:     daemonRunCmd,
+	}
+
+	daemonCmd.PersistentFlags().StringVarP(&daemonListenAddr, "listen", "",
+		"127.0.0.1:7340", "Address to listen on")
+
+	cmd.AddCommand(daemonCmd)
+}
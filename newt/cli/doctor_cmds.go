@@ -0,0 +1,440 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/newt/toolchain"
+	"mynewt.apache.org/newt/util"
+)
+
+// Debugger tools, keyed by a name fragment that identifies them in a BSP's
+// download or debug script (either directly or via a sourced helper script).
+var doctorDebugTools = []struct {
+	pattern string
+	tools   []string
+}{
+	{"jlink", []string{"JLinkExe", "JLinkGDBServer"}},
+	{"openocd", []string{"openocd"}},
+	{"stlink", []string{"openocd"}},
+	{"pyocd", []string{"pyocd"}},
+	{"nrfjprog", []string{"nrfjprog"}},
+	{"dfu-util", []string{"dfu-util"}},
+}
+
+func appendUnique(slice []string, s string) []string {
+	for _, elem := range slice {
+		if elem == s {
+			return slice
+		}
+	}
+
+	return append(slice, s)
+}
+
+type doctor struct {
+	warnings int
+	failures int
+}
+
+func (d *doctor) section(name string) {
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "\n%s:\n", name)
+}
+
+func (d *doctor) ok(format string, args ...interface{}) {
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "  [ok]   "+format+"\n",
+		args...)
+}
+
+func (d *doctor) report(status string, problem string, fix string) {
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "  [%s] %s\n", status,
+		problem)
+	if fix != "" {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "         fix: %s\n",
+			fix)
+	}
+}
+
+func (d *doctor) warn(problem string, fix string) {
+	d.warnings++
+	d.report("warn", problem, fix)
+}
+
+func (d *doctor) fail(problem string, fix string) {
+	d.failures++
+	d.report("FAIL", problem, fix)
+}
+
+// Returns the first line of a tool's version output, or "" if the tool
+// doesn't report one.
+func toolVersion(path string) string {
+	out, err := exec.Command(path, "--version").CombinedOutput()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+}
+
+func (d *doctor) checkTool(name string, fix string, version bool) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		d.fail(name+" not found in PATH", fix)
+		return
+	}
+
+	vers := ""
+	if version {
+		vers = toolVersion(path)
+	}
+	if vers != "" {
+		d.ok("%s (%s)", path, vers)
+	} else {
+		d.ok("%s", path)
+	}
+}
+
+func (d *doctor) checkGit() {
+	d.section("Git")
+	d.checkTool("git", "install git; newt uses it to download repositories",
+		true)
+}
+
+// Warns if another newt executable earlier in PATH shadows this one.
+func (d *doctor) checkPath() {
+	d.section("PATH")
+
+	self, err := util.Executable()
+	if err != nil {
+		return
+	}
+	self, _ = filepath.EvalSymlinks(self)
+
+	found := []string{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		path, err := exec.LookPath(filepath.Join(dir, "newt"))
+		if err != nil {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+		found = appendUnique(found, path)
+	}
+
+	switch {
+	case len(found) == 0:
+		d.warn("newt ("+self+") is not in PATH",
+			"add "+filepath.Dir(self)+" to PATH")
+	case found[0] != self:
+		d.warn("running "+self+", but \"newt\" in PATH resolves to "+
+			found[0], "remove or reorder the other newt installation")
+	default:
+		d.ok("%s", self)
+	}
+	for i, path := range found {
+		if i > 0 && path != self {
+			d.warn("additional newt executable in PATH: "+path,
+				"remove it to avoid running an unexpected version")
+		}
+	}
+}
+
+func (d *doctor) checkRepos(proj *project.Project) {
+	d.section("Repositories")
+
+	names := []string{}
+	for name, _ := range proj.Repos() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	remote := 0
+	for _, name := range names {
+		r := proj.Repos()[name]
+		if r.IsLocal() {
+			continue
+		}
+		remote++
+		if util.NodeNotExist(r.Path()) {
+			d.fail("repository "+name+" is not installed",
+				"run \"newt install\"")
+		} else {
+			d.ok("%s installed at %s", name, r.Path())
+		}
+	}
+	if remote == 0 {
+		d.ok("no external repositories")
+	}
+
+	for _, w := range proj.Warnings() {
+		d.warn(w, "")
+	}
+	for _, m := range proj.LockMismatches() {
+		d.warn(m, "run \"newt upgrade\" or update project.lock")
+	}
+	for _, p := range proj.IntegrityProblems() {
+		d.warn(p, "run \"newt upgrade\", or pass --allow-dirty-repos "+
+			"if the change is intentional")
+	}
+}
+
+func (d *doctor) checkBin(proj *project.Project) {
+	d.section("Build directories")
+
+	binDir := builder.BinRoot() + "/" + TARGET_DEFAULT_DIR
+	infos, err := ioutil.ReadDir(binDir)
+	if err != nil {
+		d.ok("no build output")
+		return
+	}
+
+	stale := 0
+	for _, info := range infos {
+		name := TARGET_DEFAULT_DIR + "/" + info.Name()
+		if !info.IsDir() || info.Name() == "unittest" {
+			continue
+		}
+		if target.GetTargets()[name] == nil {
+			stale++
+			d.warn("stale build directory for deleted target "+name,
+				"rm -rf "+binDir+"/"+info.Name())
+		}
+	}
+
+	if stale == 0 {
+		d.ok("%s", binDir)
+	}
+}
+
+// Determines which debugger tools a BSP's scripts rely on.
+func bspDebugTools(bsp *pkg.BspPackage) []string {
+	tools := []string{}
+	shRe := regexp.MustCompile(`[^\s"']+\.sh`)
+
+	for _, script := range []string{bsp.DownloadScript, bsp.DebugScript} {
+		if script == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(script)
+		if err != nil {
+			continue
+		}
+
+		// Look at the script itself and the names of any helper scripts it
+		// sources.
+		text := strings.ToLower(string(data))
+		for _, sh := range shRe.FindAllString(text, -1) {
+			text += " " + filepath.Base(sh)
+		}
+
+		for _, dt := range doctorDebugTools {
+			if strings.Contains(text, dt.pattern) {
+				for _, tool := range dt.tools {
+					tools = appendUnique(tools, tool)
+				}
+			}
+		}
+	}
+
+	return tools
+}
+
+// Returns the executables a compiler package refers to.
+func compilerTools(proj *project.Project, bsp *pkg.BspPackage,
+	buildProfile string) ([]string, error) {
+
+	compilerPkg, err := proj.ResolvePackage(bsp.Repo(), bsp.CompilerName)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := util.ReadConfig(compilerPkg.BasePath(),
+		strings.TrimSuffix(toolchain.COMPILER_FILENAME, ".yml"))
+	if err != nil {
+		return nil, err
+	}
+
+	features := map[string]bool{
+		proj.CompilerBuildProfile(buildProfile): true,
+		strings.ToUpper(runtime.GOOS):           true,
+	}
+
+	tools := []string{}
+	for _, key := range []string{"cc", "cpp", "as", "archive", "objdump",
+		"objsize", "objcopy"} {
+
+		tool := newtutil.GetStringFeatures(v, features, "compiler.path."+key)
+		if tool != "" {
+			tools = appendUnique(tools, tool)
+		}
+	}
+
+	return tools, nil
+}
+
+func (d *doctor) checkTargets(proj *project.Project) {
+	targetNames := []string{}
+	for name, t := range target.GetTargets() {
+		if !strings.HasSuffix(t.Name(), "/unittest") {
+			targetNames = append(targetNames, name)
+		}
+	}
+	sort.Strings(targetNames)
+
+	d.section("Targets")
+	if len(targetNames) == 0 {
+		d.ok("no targets defined")
+	}
+
+	compilers := map[string][]string{}
+	debuggers := map[string][]string{}
+
+	for _, name := range targetNames {
+		t := target.GetTargets()[name]
+		if err := t.Validate(false); err != nil {
+			d.fail(name+": "+err.Error(), "run \"newt target set "+name+
+				" ...\"")
+			continue
+		}
+
+		bsp, err := pkg.NewBspPackage(t.Bsp())
+		if err != nil {
+			d.fail(name+": "+err.Error(), "")
+			continue
+		}
+
+		tools, err := compilerTools(proj, bsp, t.BuildProfile)
+		if err != nil {
+			d.fail(name+": compiler "+bsp.CompilerName+": "+err.Error(), "")
+			continue
+		}
+		for _, tool := range tools {
+			compilers[tool] = append(compilers[tool], name)
+		}
+		for _, tool := range bspDebugTools(bsp) {
+			debuggers[tool] = append(debuggers[tool], name)
+		}
+
+		d.ok("%s (bsp %s, compiler %s)", name, bsp.FullName(),
+			bsp.CompilerName)
+	}
+
+	checkUsedTools := func(title string, tools map[string][]string,
+		fail bool) {
+
+		d.section(title)
+
+		names := make([]string, 0, len(tools))
+		for tool, _ := range tools {
+			names = append(names, tool)
+		}
+		sort.Strings(names)
+
+		if len(names) == 0 {
+			d.ok("none required")
+		}
+		for _, tool := range names {
+			path, err := exec.LookPath(tool)
+			if err == nil {
+				if fail {
+					if vers := toolVersion(path); vers != "" {
+						d.ok("%s (%s)", path, vers)
+						continue
+					}
+				}
+				d.ok("%s", path)
+				continue
+			}
+
+			problem := tool + " not found in PATH (used by " +
+				strings.Join(tools[tool], ", ") + ")"
+			fix := "install " + tool + " or add its directory to PATH"
+			if fail {
+				d.fail(problem, fix)
+			} else {
+				d.warn(problem, fix)
+			}
+		}
+	}
+
+	checkUsedTools("Toolchain", compilers, true)
+	checkUsedTools("Debugger tools", debuggers, false)
+}
+
+func doctorRunCmd(cmd *cobra.Command, args []string) {
+	d := &doctor{}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "%s (%s/%s)\n",
+		newtutil.NewtVersionStr, runtime.GOOS, runtime.GOARCH)
+
+	d.checkPath()
+	d.checkGit()
+
+	proj, err := project.TryGetProject()
+	if err != nil {
+		d.section("Project")
+		d.fail(err.Error(), "run newt from within a project directory")
+	} else {
+		d.checkRepos(proj)
+		d.checkTargets(proj)
+		d.checkBin(proj)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "\n%d problem(s), "+
+		"%d warning(s)\n", d.failures, d.warnings)
+
+	if d.failures > 0 {
+		os.Exit(1)
+	}
+}
+
+func AddDoctorCommands(cmd *cobra.Command) {
+	doctorHelpText := "Check the environment for common problems: " +
+		"missing or shadowed tools, toolchains and debuggers required " +
+		"by the project's targets, repository state, stale build " +
+		"directories, and target misconfigurations.  Each problem is " +
+		"reported with a suggested fix."
+
+	doctorCmd := &cobra.Command{
+		Use:     "doctor",
+		Short:   "Diagnose problems with the newt environment",
+		Long:    doctorHelpText,
+		Example: "  newt doctor",
+		Run:     doctorRunCmd,
+	}
+
+	cmd.AddCommand(doctorCmd)
+}
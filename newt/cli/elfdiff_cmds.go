@@ -0,0 +1,140 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+var elfDiffOpts builder.ElfDiffOptions
+
+func elfSymbolDesc(sym builder.ElfSymbolDelta) string {
+	kind := "object"
+	if sym.Func {
+		kind = "func"
+	}
+
+	desc := fmt.Sprintf("%s (%s, %s)", sym.Name, kind, sym.Section)
+	switch {
+	case sym.Status == builder.ELF_DIFF_MOVED:
+		desc += fmt.Sprintf(" %s -> %s", sym.OldPackage, sym.NewPackage)
+	case sym.NewPackage != "":
+		desc += " " + sym.NewPackage
+	case sym.OldPackage != "":
+		desc += " " + sym.OldPackage
+	}
+
+	return desc
+}
+
+func printElfDiff(d *builder.ElfDiff) {
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "%s -> %s: %d -> %d bytes "+
+		"(%+d)\n", d.Old, d.New, d.OldSize, d.NewSize, d.NewSize-d.OldSize)
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Functions: %d added, %d "+
+		"removed\n", d.FuncsAdded, d.FuncsRemoved)
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "\nSections:\n")
+	if len(d.Sections) == 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "  No change\n")
+	}
+	for _, s := range d.Sections {
+		switch s.Status {
+		case builder.ELF_DIFF_ADDED:
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"  %-20s added   0x%08x %8d\n", s.Name, s.NewAddr, s.NewSize)
+		case builder.ELF_DIFF_REMOVED:
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"  %-20s removed 0x%08x %8d\n", s.Name, s.OldAddr, s.OldSize)
+		default:
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"  %-20s 0x%08x -> 0x%08x %8d -> %8d (%+d)\n", s.Name,
+				s.OldAddr, s.NewAddr, s.OldSize, s.NewSize, s.Delta)
+		}
+	}
+
+	if len(d.Packages) > 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "\nPackages:\n")
+		for _, p := range d.Packages {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "  %+9d %s\n",
+				p.Delta, p.Name)
+		}
+	}
+
+	if len(d.Symbols) > 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "\nSymbols:\n")
+		for _, sym := range d.Symbols {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "  %+9d %-8s %s\n",
+				sym.Delta, sym.Status, elfSymbolDesc(sym))
+		}
+	}
+}
+
+func elfDiffRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		NewtUsage(cmd, util.NewNewtError("Must specify two elf files"))
+	}
+
+	diff, err := builder.DiffElfs(args[0], args[1], elfDiffOpts)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(diff)
+		return
+	}
+
+	printElfDiff(diff)
+}
+
+func AddElfDiffCommands(cmd *cobra.Command) {
+	elfDiffHelpText := "Compare two elf files: the layout of their " +
+		"allocated sections, and the functions and objects that were " +
+		"added, removed or resized.\n\n" +
+		"With --packages, symbols are attributed to packages using the " +
+		"linker map next to each file (<file>.map, as written by newt " +
+		"build); the change in each package's size is listed, and " +
+		"symbols that moved from one package to another are reported.  " +
+		"This is useful for reviewing the effect of a dependency upgrade."
+	elfDiffHelpEx := "  newt elf-diff old/blinky.elf " +
+		"bin/targets/blinky/app/apps/blinky/blinky.elf\n"
+	elfDiffHelpEx += "  newt elf-diff --packages --symbols -1 a.elf b.elf\n"
+
+	elfDiffCmd := &cobra.Command{
+		Use:     "elf-diff <old.elf> <new.elf>",
+		Short:   "Compare the symbols and sections of two elf files",
+		Long:    elfDiffHelpText,
+		Example: elfDiffHelpEx,
+		Run:     elfDiffRunCmd,
+	}
+
+	elfDiffCmd.Flags().BoolVarP(&elfDiffOpts.Packages, "packages", "p",
+		false, "Attribute symbols to packages using the linker maps")
+	elfDiffCmd.Flags().IntVarP(&elfDiffOpts.MaxSyms, "symbols", "", 20,
+		"Number of changed symbols to list; -1 lists all")
+
+	cmd.AddCommand(elfDiffCmd)
+}
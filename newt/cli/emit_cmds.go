@@ -0,0 +1,89 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+var emitHeadersOut string
+
+func emitHeadersRunCmd(cmd *cobra.Command, args []string) {
+	b := targetBuilderArg(cmd, args)
+
+	outDir := emitHeadersOut
+	if outDir == "" {
+		outDir = b.EmitHeadersDir()
+	}
+
+	cfg, err := b.EmitHeaders(outDir)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(cfg)
+		return
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Emitted %d generated files of target %s to %s\n", len(cfg.Files),
+		cfg.Target, outDir)
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Build configuration: %s\n",
+		filepath.Join(outDir, builder.EMIT_CONFIG_FILENAME))
+}
+
+func AddEmitCommands(cmd *cobra.Command) {
+	emitHelpText := "Resolve <target-name> and write its generated " +
+		"configuration, without compiling anything: syscfg.h, the " +
+		"sysinit and flash map sources, GATT tables and linker scripts.  " +
+		"This lets other build systems compile Mynewt packages with the " +
+		"configuration newt computes.\n\n" +
+		"The files are written to the directory given with --out " +
+		"(default: bin/targets/<target>/headers), along with " +
+		builder.EMIT_CONFIG_FILENAME + ", which lists the generated " +
+		"files, the include paths of all packages, the defines they all " +
+		"share, and the include paths and additional defines of each " +
+		"package.  Its paths are absolute and refer to the copies in the " +
+		"output directory.  An existing output directory is only " +
+		"replaced if newt created it."
+	emitHelpEx := "  newt emit-headers my_target\n"
+	emitHelpEx += "  newt emit-headers my_target --out ../ext/mynewt-cfg\n"
+
+	emitCmd := &cobra.Command{
+		Use:     "emit-headers <target-name>",
+		Short:   "Write a target's generated configuration for other build systems",
+		Long:    emitHelpText,
+		Example: emitHelpEx,
+		Run:     emitHeadersRunCmd,
+	}
+
+	emitCmd.Flags().StringVarP(&emitHeadersOut, "out", "", "",
+		"Directory to write the generated files to")
+
+	cmd.AddCommand(emitCmd)
+	AddTabCompleteFn(emitCmd, targetList)
+}
@@ -0,0 +1,256 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+var fleetParallel int
+
+// A device listed in a fleet file.
+type fleetDevice struct {
+	Name         string
+	Serial       string
+	Target       string
+	Jtag         string
+	ExtraJtagCmd string
+}
+
+type fleetResult struct {
+	Name    string  `json:"name"`
+	Serial  string  `json:"serial"`
+	Target  string  `json:"target"`
+	Passed  bool    `json:"passed"`
+	Error   string  `json:"error,omitempty"`
+	Seconds float64 `json:"seconds"`
+}
+
+// Reads a fleet file.  fleet.target, fleet.jtag and fleet.extrajtagcmd set
+// defaults for the devices listed in fleet.devices, each of which must have
+// a probe serial number.
+func readFleet(path string) ([]*fleetDevice, error) {
+	if filepath.Ext(path) != ".yml" {
+		return nil, util.FmtNewtError(
+			"Fleet file must have a .yml extension: %s", path)
+	}
+	v, err := util.ReadConfig(filepath.Dir(path),
+		strings.TrimSuffix(filepath.Base(path), ".yml"))
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := fleetDevice{
+		Target:       v.GetString("fleet.target"),
+		Jtag:         v.GetString("fleet.jtag"),
+		ExtraJtagCmd: v.GetString("fleet.extrajtagcmd"),
+	}
+
+	devices := []*fleetDevice{}
+	serials := map[string]bool{}
+	for i, itf := range cast.ToSlice(v.Get("fleet.devices")) {
+		fields := cast.ToStringMapString(itf)
+		get := func(key string, dflt string) string {
+			if val, ok := fields[key]; ok && val != "" {
+				return val
+			}
+			return dflt
+		}
+
+		dev := &fleetDevice{
+			Serial:       get("serial", ""),
+			Target:       get("target", defaults.Target),
+			Jtag:         get("jtag", defaults.Jtag),
+			ExtraJtagCmd: get("extrajtagcmd", defaults.ExtraJtagCmd),
+		}
+		dev.Name = get("name", dev.Serial)
+
+		if dev.Serial == "" {
+			return nil, util.FmtNewtError("%s: device %d has no serial "+
+				"number", path, i+1)
+		}
+		if serials[dev.Serial] {
+			return nil, util.FmtNewtError("%s: serial number %s is listed "+
+				"more than once", path, dev.Serial)
+		}
+		serials[dev.Serial] = true
+
+		if dev.Target == "" {
+			return nil, util.FmtNewtError("%s: device %s has no target",
+				path, dev.Name)
+		}
+
+		devices = append(devices, dev)
+	}
+
+	if len(devices) == 0 {
+		return nil, util.FmtNewtError("%s lists no devices (fleet.devices)",
+			path)
+	}
+
+	return devices, nil
+}
+
+func printFleetResult(r *fleetResult) {
+	if r.Passed {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s %s (%s) %.1fs\n",
+			colorText(ANSI_GREEN, "PASS"), r.Name, r.Target, r.Seconds)
+	} else {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s %s (%s) %.1fs: %s\n",
+			colorText(ANSI_RED, "FAIL"), r.Name, r.Target, r.Seconds,
+			r.Error)
+	}
+}
+
+func loadFleetRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify fleet file"))
+	}
+
+	TryGetProject()
+
+	devices, err := readFleet(args[0])
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	// Resolve every target before loading anything, so that a typo doesn't
+	// leave the fleet half flashed.
+	builders := make([]*builder.TargetBuilder, len(devices))
+	for i, dev := range devices {
+		t := ResolveTarget(dev.Target)
+		if t == nil {
+			NewtUsage(nil, util.FmtNewtError(
+				"Device %s: invalid target name: %s", dev.Name, dev.Target))
+		}
+
+		b, err := builder.NewTargetBuilder(t)
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+		b.SetProbe(dev.Jtag)
+		b.SetProbeSerial(dev.Serial)
+		builders[i] = b
+	}
+
+	parallel := fleetParallel
+	if parallel <= 0 || parallel > len(devices) {
+		parallel = len(devices)
+	}
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Loading %d devices, %d at a time\n", len(devices), parallel)
+
+	results := make([]*fleetResult, len(devices))
+	sem := make(chan struct{}, parallel)
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+
+	for i, dev := range devices {
+		wg.Add(1)
+		go func(i int, dev *fleetDevice) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			start := time.Now()
+			err := builders[i].Load(dev.ExtraJtagCmd)
+
+			r := &fleetResult{
+				Name:    dev.Name,
+				Serial:  dev.Serial,
+				Target:  dev.Target,
+				Passed:  err == nil,
+				Seconds: time.Since(start).Seconds(),
+			}
+			if err != nil {
+				r.Error = err.Error()
+			}
+			results[i] = r
+
+			mtx.Lock()
+			if !newtutil.NewtJson {
+				printFleetResult(r)
+			}
+			mtx.Unlock()
+		}(i, dev)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, r := range results {
+		if !r.Passed {
+			failed++
+		}
+	}
+
+	if newtutil.NewtJson {
+		printJson(results)
+	} else {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"%d of %d devices loaded\n", len(results)-failed, len(results))
+	}
+
+	if failed > 0 {
+		NewtUsage(nil, util.FmtNewtError("%d devices failed to load",
+			failed))
+	}
+}
+
+func addLoadFleetCommand(cmd *cobra.Command) {
+	fleetHelpText := "Load several devices in parallel, as described by a " +
+		"fleet file:\n\n" +
+		"    fleet.target: my_target\n" +
+		"    fleet.jtag: pyocd\n" +
+		"    fleet.devices:\n" +
+		"        - name: rack-1\n" +
+		"          serial: 0240000034544e45\n" +
+		"        - serial: 0240000034544e46\n" +
+		"          target: other_target\n" +
+		"          jtag: probe-rs\n\n" +
+		"Each device is identified by its debug probe's serial number; " +
+		"target, jtag and\nextrajtagcmd may be set per device, overriding " +
+		"the fleet-wide defaults.  The\ntargets must already be built.  " +
+		"The result of each device is printed as it\nfinishes; the " +
+		"command fails if any device failed."
+	fleetHelpEx := "  newt load-fleet rack.yml\n"
+	fleetHelpEx += "  newt load-fleet rack.yml --parallel 4\n"
+
+	fleetCmd := &cobra.Command{
+		Use:     "load-fleet <fleet-file>",
+		Short:   "Load targets to several devices in parallel",
+		Long:    fleetHelpText,
+		Example: fleetHelpEx,
+		Run:     loadFleetRunCmd,
+	}
+
+	fleetCmd.Flags().IntVarP(&fleetParallel, "parallel", "", 0,
+		"Maximum number of devices to load at once (default: all)")
+
+	cmd.AddCommand(fleetCmd)
+}
@@ -0,0 +1,101 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/util"
+)
+
+var fsImageOpts builder.FsImageOptions
+
+func fsImageRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+	if fsImageOpts.Dir == "" {
+		NewtUsage(cmd, util.NewNewtError("Must specify --dir"))
+	}
+
+	TryGetProject()
+
+	t := ResolveTarget(args[0])
+	if t == nil {
+		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
+	}
+
+	b, err := builder.NewTargetBuilder(t)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	out, err := b.FsImage(fsImageOpts)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "File system image: %s\n",
+		out)
+}
+
+func AddFsImageCommands(cmd *cobra.Command) {
+	fsImageHelpText := "Build a littlefs or NFFS image holding the " +
+		"contents of a host directory, sized to one of the target BSP's " +
+		"flash areas.\n\n" +
+		"The file system type and area default to the target's " +
+		"LITTLEFS_FLASH_AREA or NFFS_FLASH_AREA setting; littlefs images " +
+		"also take their block, program and cache sizes from the " +
+		"LITTLEFS_* settings.  For NFFS, --block-size is the size of each " +
+		"NFFS area and should match the flash sector size.\n\n" +
+		"The image can be flashed on its own at the area's offset, or " +
+		"included in an mfg image with a raw entry naming the file and " +
+		"the area."
+	fsImageHelpEx := "  newt fs-image my_target --area FLASH_AREA_NFFS " +
+		"--dir assets/\n"
+	fsImageHelpEx += "  newt fs-image my_target --type littlefs " +
+		"--block-size 4096 --dir assets/ --out fs.bin\n"
+
+	fsImageCmd := &cobra.Command{
+		Use:     "fs-image <target-name>",
+		Short:   "Build a file system image for a flash area",
+		Long:    fsImageHelpText,
+		Example: fsImageHelpEx,
+		Run:     fsImageRunCmd,
+	}
+
+	fsImageCmd.Flags().StringVarP(&fsImageOpts.Area, "area", "", "",
+		"Flash area to build the image for")
+	fsImageCmd.Flags().StringVarP(&fsImageOpts.Dir, "dir", "", "",
+		"Directory holding the image's contents")
+	fsImageCmd.Flags().StringVarP(&fsImageOpts.Type, "type", "", "",
+		"File system type (littlefs or nffs)")
+	fsImageCmd.Flags().IntVarP(&fsImageOpts.BlockSize, "block-size", "",
+		0, "littlefs block size or NFFS area size, in bytes")
+	fsImageCmd.Flags().IntVarP(&fsImageOpts.ProgSize, "prog-size", "", 0,
+		"littlefs program size, in bytes")
+	fsImageCmd.Flags().StringVarP(&fsImageOpts.Out, "out", "", "",
+		"Image file to write (default: "+
+			"bin/targets/<target>/fs-<area>.bin)")
+
+	cmd.AddCommand(fsImageCmd)
+	AddTabCompleteFn(fsImageCmd, targetList)
+}
@@ -0,0 +1,166 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+var fuzzEngine string
+var fuzzCc string
+var fuzzDuration time.Duration
+var fuzzBuildOnly bool
+var fuzzArgs []string
+
+// Number of stack frames shown per crash in the summary.
+const FUZZ_SUMMARY_FRAMES = 8
+
+func fuzzPkgList() []string {
+	return pkgNameList(func(pack *pkg.LocalPackage) bool {
+		return pack.PkgV.IsSet("pkg.fuzz")
+	})
+}
+
+func fuzzRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, nil)
+	}
+
+	proj := TryGetProject()
+
+	lpkg, err := proj.ResolvePackage(proj.LocalRepo(), args[0])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	if len(args) < 2 {
+		harnesses, err := builder.FuzzHarnesses(lpkg)
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Fuzz harnesses in %s:\n", lpkg.FullName())
+		for _, h := range harnesses {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "    %s (%s)\n",
+				h.Name, h.Dir)
+		}
+		return
+	}
+
+	harness, err := builder.FindFuzzHarness(lpkg, args[1])
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	// Fuzz builds use the unit test target, which is set up for the native
+	// BSP, under a name unique to the harness.
+	t, err := ResolveUnittest(fmt.Sprintf("%s/fuzz/%s", lpkg.Name(),
+		harness.Name))
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	b, err := builder.NewTargetFuzzer(t, lpkg, builder.FuzzConfig{
+		Harness: harness,
+		Engine:  fuzzEngine,
+		Cc:      fuzzCc,
+	})
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Building fuzz harness %s of %s\n", harness.Name, lpkg.FullName())
+	exePath, err := b.FuzzCreateExe()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Fuzzer: %s\n", exePath)
+
+	if fuzzBuildOnly {
+		return
+	}
+
+	if err := b.RunFuzzer(exePath, fuzzDuration, fuzzArgs); err != nil {
+		NewtUsage(nil, err)
+	}
+
+	crashes, err := b.FuzzCrashes(exePath)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Corpus: %s/corpus\n",
+		b.FuzzDir())
+	if len(crashes) > 0 {
+		builder.PrintFuzzCrashes(crashes, FUZZ_SUMMARY_FRAMES)
+		NewtUsage(nil, util.FmtNewtError("Fuzzing found %d crash(es)",
+			len(crashes)))
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "No crashes found\n")
+}
+
+func AddFuzzCommands(cmd *cobra.Command) {
+	fuzzHelpText := "Build a package's fuzz harness with fuzzing " +
+		"instrumentation for the native BSP and run it.\n\n" +
+		"Harnesses are declared in the package's pkg.yml as a map from " +
+		"harness name to a directory containing the sources that define " +
+		"LLVMFuzzerTestOneInput().  An optional \"corpus\" subdirectory " +
+		"of the harness holds seed inputs.  The harness is built using " +
+		"the unit test target.\n\n" +
+		"The working corpus and crashing inputs are kept in the " +
+		"harness's bin directory.  After the fuzzer exits, every " +
+		"crashing input is replayed and its stack trace reported.  " +
+		"Without a harness name, lists the package's harnesses."
+	fuzzHelpEx := "  newt fuzz net/oic parse_coap\n"
+	fuzzHelpEx += "  newt fuzz net/oic parse_coap --engine afl --duration 10m\n"
+
+	fuzzCmd := &cobra.Command{
+		Use:     "fuzz <package> [<harness>]",
+		Short:   "Build and run a package's fuzz harness",
+		Long:    fuzzHelpText,
+		Example: fuzzHelpEx,
+		Run:     fuzzRunCmd,
+	}
+
+	fuzzCmd.Flags().StringVarP(&fuzzEngine, "engine", "",
+		builder.FUZZ_ENGINE_LIBFUZZER,
+		"Fuzzing engine: libfuzzer or afl")
+	fuzzCmd.Flags().StringVarP(&fuzzCc, "cc", "", "",
+		"C compiler to build with (default: clang for libfuzzer, "+
+			"afl-clang-fast for afl)")
+	fuzzCmd.Flags().DurationVarP(&fuzzDuration, "duration", "", 0,
+		"Stop fuzzing after this long (default: run until interrupted)")
+	fuzzCmd.Flags().BoolVarP(&fuzzBuildOnly, "build-only", "", false,
+		"Build the fuzzer without running it")
+	fuzzCmd.Flags().StringSliceVarP(&fuzzArgs, "args", "", nil,
+		"Additional arguments passed to the fuzzing engine")
+
+	cmd.AddCommand(fuzzCmd)
+	AddTabCompleteFn(fuzzCmd, fuzzPkgList)
+}
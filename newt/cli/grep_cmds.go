@@ -0,0 +1,115 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"path/filepath"
+	"regexp"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+var grepSyscfg bool
+var grepPkgField string
+
+func grepRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify a pattern"))
+	}
+	if grepSyscfg == (grepPkgField != "") {
+		NewtUsage(cmd, util.NewNewtError(
+			"Must specify exactly one of --syscfg and --pkg-field"))
+	}
+
+	re, err := regexp.Compile(args[0])
+	if err != nil {
+		NewtUsage(cmd, util.FmtNewtError("Invalid pattern \"%s\": %s",
+			args[0], err.Error()))
+	}
+
+	proj := TryGetProject()
+
+	lpkgs := []*pkg.LocalPackage{}
+	for _, list := range proj.PackageList() {
+		for _, p := range *list {
+			lpkgs = append(lpkgs, p.(*pkg.LocalPackage))
+		}
+	}
+
+	var matches []pkg.YamlMatch
+	if grepSyscfg {
+		matches = pkg.SearchSyscfg(lpkgs, re)
+	} else {
+		matches = pkg.SearchPkgField(lpkgs, grepPkgField, re)
+	}
+
+	if newtutil.NewtJson {
+		printJson(matches)
+		return
+	}
+
+	for _, m := range matches {
+		path := m.File
+		if rel, err := filepath.Rel(proj.Path(), path); err == nil {
+			path = rel
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s:%d: %s = %s\n",
+			path, m.Line, m.Key, m.Value)
+	}
+
+	if len(matches) == 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "No matches\n")
+	}
+}
+
+func AddGrepCommands(cmd *cobra.Command) {
+	grepHelpText := "Search the YAML metadata of every package in the " +
+		"project's repos, rather than their source code.  The pattern is " +
+		"a regular expression.\n\n" +
+		"With --syscfg, the names of syscfg settings that packages define " +
+		"(syscfg.defs) or override (syscfg.vals) are searched.  With " +
+		"--pkg-field, the values of the specified pkg.yml field are " +
+		"searched; \"cflags\" is short for \"pkg.cflags\".  Each match is " +
+		"printed as file:line: key = value."
+	grepHelpEx := "  newt grep --syscfg BLE_\n"
+	grepHelpEx += "  newt grep --syscfg '^OS_MAIN_STACK_SIZE$'\n"
+	grepHelpEx += "  newt grep --pkg-field cflags NDEBUG\n"
+	grepHelpEx += "  newt grep --pkg-field deps --json nimble\n"
+
+	grepCmd := &cobra.Command{
+		Use:     "grep {--syscfg | --pkg-field <field>} <pattern>",
+		Short:   "Search package metadata for settings and field values",
+		Long:    grepHelpText,
+		Example: grepHelpEx,
+		Run:     grepRunCmd,
+	}
+
+	grepCmd.Flags().BoolVarP(&grepSyscfg, "syscfg", "", false,
+		"Search syscfg setting names")
+	grepCmd.Flags().StringVarP(&grepPkgField, "pkg-field", "", "",
+		"Search the values of the specified pkg.yml field")
+
+	cmd.AddCommand(grepCmd)
+}
@@ -0,0 +1,177 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+var historySymbols int
+
+func historyBuildId(arg string) int {
+	id, err := util.AtoiNoOct(arg)
+	if err != nil {
+		NewtUsage(nil, util.FmtNewtError("Invalid build id: %s", arg))
+	}
+
+	return id
+}
+
+func printHistoryEntry(e *builder.HistoryEntry) {
+	sizes := []string{}
+
+	images := make([]string, 0, len(e.Totals))
+	for name, _ := range e.Totals {
+		images = append(images, name)
+	}
+	sort.Strings(images)
+
+	for _, name := range images {
+		regions := make([]string, 0, len(e.Totals[name]))
+		for region, _ := range e.Totals[name] {
+			regions = append(regions, region)
+		}
+		sort.Strings(regions)
+
+		s := name + ":"
+		for _, region := range regions {
+			s += fmt.Sprintf(" %s=%d", region, e.Totals[name][region])
+		}
+		sizes = append(sizes, s)
+	}
+
+	dirty := ""
+	for _, r := range e.Repos {
+		if r.Dirty {
+			dirty = " (dirty)"
+			break
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "%4d  %s  %s%s\n", e.Id,
+		e.Time.Format("2006-01-02 15:04:05"), strings.Join(sizes, "  "),
+		dirty)
+}
+
+func historyRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	TryGetProject()
+
+	t := ResolveTarget(args[0])
+	if t == nil {
+		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
+	}
+
+	if len(args) == 1 {
+		entries, err := builder.ReadHistory(t.Name())
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+
+		if newtutil.NewtJson {
+			printJson(entries)
+			return
+		}
+
+		if len(entries) == 0 {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"No builds recorded for %s\n", t.FullName())
+			return
+		}
+		for _, e := range entries {
+			printHistoryEntry(e)
+		}
+		return
+	}
+
+	oldId := historyBuildId(args[1])
+	newId := -1
+	if len(args) > 2 {
+		newId = historyBuildId(args[2])
+	}
+
+	diff, err := builder.DiffHistory(t.Name(), oldId, newId, historySymbols)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(diff)
+		return
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Build %d -> build %d\n\n",
+		diff.Old, diff.New)
+	for _, d := range diff.Sizes {
+		printSizeDiff(d)
+	}
+
+	if len(diff.Syscfg) == 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "Syscfg: no change\n")
+	} else {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "Syscfg:\n")
+		for _, line := range diff.Syscfg {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "  %s\n",
+				strings.TrimPrefix(line, "syscfg: "))
+		}
+	}
+}
+
+func AddHistoryCommands(cmd *cobra.Command) {
+	historyHelpText := "List the builds of a target recorded in its " +
+		"history, or compare two of them.\n\n" +
+		"Each build's manifest, linker maps, sizes and syscfg are kept " +
+		"under bin/<target>/history.  The number of builds kept is set " +
+		"by \"target.history\" in target.yml (default " +
+		fmt.Sprintf("%d", target.DEFAULT_HISTORY_DEPTH) + "; 0 disables " +
+		"the history).\n\n" +
+		"With one build id, that build is compared against the latest " +
+		"one; with two, the first is compared against the second.  " +
+		"Negative ids count back from the latest build (-1 is the latest)."
+	historyHelpEx := "  newt history my_target\n"
+	historyHelpEx += "  newt history my_target 12\n"
+	historyHelpEx += "  newt history my_target -- -2 -1\n"
+
+	historyCmd := &cobra.Command{
+		Use:     "history <target-name> [old-build [new-build]]",
+		Short:   "List or compare a target's recent builds",
+		Long:    historyHelpText,
+		Example: historyHelpEx,
+		Run:     historyRunCmd,
+	}
+
+	historyCmd.Flags().IntVarP(&historySymbols, "symbols", "", 10,
+		"Number of changed symbols to list; -1 lists all")
+
+	cmd.AddCommand(historyCmd)
+	AddTabCompleteFn(historyCmd, targetList)
+}
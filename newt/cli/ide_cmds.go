@@ -0,0 +1,396 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
+)
+
+const IDE_FORMAT_VSCODE = "vscode"
+const IDE_FORMAT_ECLIPSE = "eclipse"
+
+var ideFormat string
+var ideOutput string
+
+// Makes a path relative to the project directory, expressed with the
+// variable the IDE substitutes for it.
+func ideProjectPath(info *builder.IdeDebugInfo, path string,
+	projVar string) string {
+
+	rel, err := filepath.Rel(info.ProjectDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return projVar + "/" + filepath.ToSlash(rel)
+}
+
+// Merges entries into a VS Code JSON file's list (e.g., "configurations"),
+// replacing entries that have the same key (e.g., "name") and keeping all
+// others.
+func mergeVscodeFile(path string, version string, listKey string,
+	idKey string, entries []map[string]interface{}) error {
+
+	doc := map[string]interface{}{
+		"version": version,
+	}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return util.FmtNewtError("Can't update %s: %s; remove the "+
+				"file or its comments and retry", path, err.Error())
+		}
+	}
+
+	replaced := map[string]bool{}
+	for _, e := range entries {
+		replaced[fmt.Sprintf("%v", e[idKey])] = true
+	}
+
+	list := []interface{}{}
+	if old, ok := doc[listKey].([]interface{}); ok {
+		for _, itf := range old {
+			if m, ok := itf.(map[string]interface{}); ok &&
+				replaced[fmt.Sprintf("%v", m[idKey])] {
+
+				continue
+			}
+			list = append(list, itf)
+		}
+	}
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	doc[listKey] = list
+
+	data, err := json.MarshalIndent(doc, "", "    ")
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Wrote %s\n", path)
+	return nil
+}
+
+func exportVscode(info *builder.IdeDebugInfo, dir string) error {
+	name := filepath.Base(info.Target)
+	buildTask := "newt build " + name
+	loadTask := "newt load " + name
+
+	loadArgs := []string{"load", info.Target}
+	if info.Probe != nil {
+		loadArgs = append(loadArgs, "--jtag", info.Probe.Name)
+	}
+
+	tasks := []map[string]interface{}{
+		{
+			"label":          buildTask,
+			"type":           "shell",
+			"command":        "newt",
+			"args":           []string{"build", info.Target},
+			"options":        map[string]string{"cwd": "${workspaceFolder}"},
+			"group":          "build",
+			"problemMatcher": []string{"$gcc"},
+		},
+		{
+			"label":          loadTask,
+			"type":           "shell",
+			"command":        "newt",
+			"args":           loadArgs,
+			"options":        map[string]string{"cwd": "${workspaceFolder}"},
+			"dependsOn":      buildTask,
+			"problemMatcher": []string{},
+		},
+	}
+
+	elf := ideProjectPath(info, info.ElfPath, "${workspaceFolder}")
+	svd := ""
+	if info.SvdPath != "" {
+		svd = ideProjectPath(info, info.SvdPath, "${workspaceFolder}")
+	}
+
+	// newt load programs the image (with its header) before the session
+	// starts, so the debugger must not load the ELF itself.
+	launch := map[string]interface{}{
+		"name":          "Debug " + name,
+		"request":       "launch",
+		"cwd":           "${workspaceFolder}",
+		"preLaunchTask": loadTask,
+	}
+	switch {
+	case info.Probe != nil && info.Probe.Name == builder.PROBE_PROBE_RS:
+		core := map[string]interface{}{"programBinary": elf}
+		if svd != "" {
+			core["svdFile"] = svd
+		}
+		launch["type"] = "probe-rs-debug"
+		launch["chip"] = info.Probe.Target
+		launch["flashingConfig"] = map[string]bool{"flashingEnabled": false}
+		launch["coreConfigs"] = []interface{}{core}
+
+	case info.Probe != nil:
+		launch["type"] = "cortex-debug"
+		launch["servertype"] = "pyocd"
+		launch["targetId"] = info.Probe.Target
+		launch["executable"] = elf
+		launch["gdbPath"] = info.GdbPath
+		launch["overrideLaunchCommands"] = []string{"monitor reset halt"}
+		launch["runToEntryPoint"] = "main"
+
+	default:
+		// The BSP's debug script is the only way to start its GDB server;
+		// run it without gdb and attach to it.
+		launch["type"] = "cortex-debug"
+		launch["servertype"] = "external"
+		launch["gdbTarget"] = fmt.Sprintf("localhost:%d", info.GdbPort)
+		launch["executable"] = elf
+		launch["gdbPath"] = info.GdbPath
+		launch["overrideLaunchCommands"] = []string{"monitor reset halt"}
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"* Warning: no probe backend selected; start the GDB server "+
+				"with \"newt debug %s -n\" before debugging\n", name)
+	}
+	if svd != "" && launch["type"] == "cortex-debug" {
+		launch["svdFile"] = svd
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := mergeVscodeFile(filepath.Join(dir, "tasks.json"), "2.0.0",
+		"tasks", "label", tasks); err != nil {
+
+		return err
+	}
+	return mergeVscodeFile(filepath.Join(dir, "launch.json"), "0.2.0",
+		"configurations", "name", []map[string]interface{}{launch})
+}
+
+type eclipseAttr struct {
+	XMLName xml.Name
+	Key     string `xml:"key,attr"`
+	Value   string `xml:"value,attr"`
+}
+
+type eclipseLaunch struct {
+	XMLName xml.Name      `xml:"launchConfiguration"`
+	Type    string        `xml:"type,attr"`
+	Attrs   []eclipseAttr `xml:",any"`
+}
+
+func eclipseStr(key string, val string) eclipseAttr {
+	return eclipseAttr{xml.Name{Local: "stringAttribute"}, key, val}
+}
+
+func eclipseBool(key string, val bool) eclipseAttr {
+	return eclipseAttr{xml.Name{Local: "booleanAttribute"}, key,
+		fmt.Sprintf("%t", val)}
+}
+
+func eclipseInt(key string, val int) eclipseAttr {
+	return eclipseAttr{xml.Name{Local: "intAttribute"}, key,
+		fmt.Sprintf("%d", val)}
+}
+
+func writeEclipseLaunch(path string, l *eclipseLaunch) error {
+	data, err := xml.MarshalIndent(l, "", "    ")
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	data = append([]byte(`<?xml version="1.0" encoding="UTF-8" `+
+		`standalone="no"?>`+"\n"), data...)
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Wrote %s\n", path)
+	return nil
+}
+
+// Returns an External Tools launch configuration that runs newt.
+func eclipseNewtTool(info *builder.IdeDebugInfo,
+	args ...string) *eclipseLaunch {
+
+	const prefix = "org.eclipse.ui.externaltools."
+	return &eclipseLaunch{
+		Type: prefix + "ProgramLaunchConfigurationType",
+		Attrs: []eclipseAttr{
+			eclipseStr(prefix+"ATTR_LOCATION", "${system_path:newt}"),
+			eclipseStr(prefix+"ATTR_TOOL_ARGUMENTS",
+				strings.Join(args, " ")),
+			eclipseStr(prefix+"ATTR_WORKING_DIRECTORY", info.ProjectDir),
+		},
+	}
+}
+
+func exportEclipse(info *builder.IdeDebugInfo, dir string) error {
+	name := filepath.Base(info.Target)
+
+	loadArgs := []string{"load", info.Target}
+	if info.Probe != nil {
+		loadArgs = append(loadArgs, "--jtag", info.Probe.Name)
+	}
+
+	const jtag = "org.eclipse.cdt.debug.gdbjtag.core."
+	const cdt = "org.eclipse.cdt.launch."
+	debug := &eclipseLaunch{
+		Type: "org.eclipse.cdt.debug.gdbjtag.launchConfigurationType",
+		Attrs: []eclipseAttr{
+			eclipseStr("org.eclipse.cdt.dsf.gdb.DEBUG_NAME", info.GdbPath),
+			eclipseStr(jtag+"jtagDevice", "Generic TCP/IP"),
+			eclipseStr(jtag+"ipAddress", "localhost"),
+			eclipseInt(jtag+"portNumber", info.GdbPort),
+			eclipseBool(jtag+"doReset", false),
+			eclipseBool(jtag+"loadImage", false),
+			eclipseBool(jtag+"loadSymbols", true),
+			eclipseBool(jtag+"useProjBinaryForSymbols", true),
+			eclipseStr(jtag+"initCommands", "monitor reset halt"),
+			eclipseBool(jtag+"setStopAt", true),
+			eclipseStr(jtag+"stopAt", "main"),
+			eclipseStr(cdt+"PROGRAM_NAME", info.ElfPath),
+			eclipseStr(cdt+"PROJECT_ATTR",
+				project.GetProject().Name()),
+			eclipseInt(cdt+"ATTR_BUILD_BEFORE_LAUNCH_ATTR", 0),
+		},
+	}
+	if info.SvdPath != "" {
+		debug.Attrs = append(debug.Attrs,
+			eclipseStr("ilg.gnumcueclipse.debug.SVD_PATH", info.SvdPath))
+	}
+
+	launches := []struct {
+		suffix string
+		l      *eclipseLaunch
+	}{
+		{"build", eclipseNewtTool(info, "build", info.Target)},
+		{"load", eclipseNewtTool(info, loadArgs...)},
+		{"gdbserver", eclipseNewtTool(info, "debug", info.Target, "-n")},
+		{"debug", debug},
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+	for _, entry := range launches {
+		path := filepath.Join(dir, fmt.Sprintf("%s %s.launch", name,
+			entry.suffix))
+		if err := writeEclipseLaunch(path, entry.l); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func exportIdeRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	TryGetProject()
+
+	t := ResolveTarget(args[0])
+	if t == nil {
+		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
+	}
+
+	b, err := builder.NewTargetBuilder(t)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+	b.SetProbe(jtagBackend)
+
+	info, err := b.IdeDebugInfo()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	switch ideFormat {
+	case IDE_FORMAT_VSCODE:
+		dir := ideOutput
+		if dir == "" {
+			dir = filepath.Join(info.ProjectDir, ".vscode")
+		}
+		err = exportVscode(info, dir)
+
+	case IDE_FORMAT_ECLIPSE:
+		dir := ideOutput
+		if dir == "" {
+			dir = info.ProjectDir
+		}
+		err = exportEclipse(info, dir)
+
+	default:
+		NewtUsage(cmd, util.FmtNewtError("Invalid format \"%s\"; must be "+
+			"%s or %s", ideFormat, IDE_FORMAT_VSCODE, IDE_FORMAT_ECLIPSE))
+	}
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+}
+
+func AddIdeCommands(cmd *cobra.Command) {
+	ideHelpText := "Generate IDE debug configurations for <target-name>, " +
+		"wired to the target's\nexecutable, the toolchain's gdb, the " +
+		"BSP's SVD file (bsp.svd), and the GDB\nserver of the probe " +
+		"backend selected with --jtag or the BSP's bsp.probe_default.\n\n" +
+		"vscode: adds \"newt build\" and \"newt load\" tasks to " +
+		".vscode/tasks.json, and a\nlaunch configuration (cortex-debug " +
+		"for pyocd or the BSP's scripts, probe-rs-debug\nfor probe-rs) " +
+		"that loads the target first to .vscode/launch.json.  Entries " +
+		"of\nthe same name are replaced; others are kept.\n\n" +
+		"eclipse: writes External Tools launch configurations for newt " +
+		"build, load and\nthe GDB server, and a GDB Hardware Debugging " +
+		"configuration, to the project\ndirectory."
+	ideHelpEx := "  newt export-ide my_target --format vscode\n"
+	ideHelpEx += "  newt export-ide my_target --format eclipse --jtag pyocd\n"
+
+	ideCmd := &cobra.Command{
+		Use:     "export-ide <target-name>",
+		Short:   "Generate IDE debug configurations",
+		Long:    ideHelpText,
+		Example: ideHelpEx,
+		Run:     exportIdeRunCmd,
+	}
+
+	ideCmd.Flags().StringVarP(&ideFormat, "format", "", IDE_FORMAT_VSCODE,
+		"IDE to generate configurations for (vscode or eclipse)")
+	ideCmd.Flags().StringVarP(&ideOutput, "output", "", "",
+		"Directory to write to (default: .vscode, or the project "+
+			"directory for eclipse)")
+	ideCmd.Flags().StringVarP(&jtagBackend, "jtag", "", "",
+		"Probe backend providing the GDB server (pyocd or probe-rs)")
+
+	cmd.AddCommand(ideCmd)
+	AddTabCompleteFn(ideCmd, targetList)
+}
@@ -20,12 +20,14 @@
 package cli
 
 import (
+	"fmt"
 	"strconv"
 
 	"github.com/spf13/cobra"
 	"mynewt.apache.org/newt/newt/builder"
 	"mynewt.apache.org/newt/newt/image"
 	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/smp"
 	"mynewt.apache.org/newt/util"
 )
 
@@ -68,6 +70,10 @@ func createImageRunCmd(cmd *cobra.Command, args []string) {
 		NewtUsage(nil, err)
 		return
 	}
+
+	if err := b.CreateCompanionImages(version, keystr, keyId); err != nil {
+		NewtUsage(nil, err)
+	}
 }
 
 func resignImageRunCmd(cmd *cobra.Command, args []string) {
@@ -108,10 +114,222 @@ func resignImageRunCmd(cmd *cobra.Command, args []string) {
 	}
 }
 
+var imageUploadOpts builder.ImageUploadOptions
+var imageNoTest bool
+var imageNoReset bool
+
+func printImageSlots(slots []smp.ImageSlot) {
+	if newtutil.NewtJson {
+		printJson(slots)
+		return
+	}
+
+	for _, s := range slots {
+		flags := ""
+		if s.Active {
+			flags += " active"
+		}
+		if s.Confirmed {
+			flags += " confirmed"
+		}
+		if s.Pending {
+			flags += " pending"
+		}
+		if s.Permanent {
+			flags += " permanent"
+		}
+		fmt.Printf("slot %d: %-12s %s%s\n", s.Slot, s.Version, s.Hash, flags)
+	}
+}
+
+func imageUploadRunCmd(cmd *cobra.Command, args []string) {
+	b := targetBuilderArg(cmd, args)
+
+	imageUploadOpts.Test = !imageNoTest
+	imageUploadOpts.Reset = !imageNoReset
+	if err := b.ImageUpload(imageUploadOpts); err != nil {
+		NewtUsage(nil, err)
+	}
+}
+
+func imageStateRunCmd(cmd *cobra.Command, args []string) {
+	b := targetBuilderArg(cmd, args)
+
+	slots, err := b.ImageState(imageUploadOpts.SmpConnOptions)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+	printImageSlots(slots)
+}
+
+func imageConfirmRunCmd(cmd *cobra.Command, args []string) {
+	b := targetBuilderArg(cmd, args)
+
+	hash := ""
+	if len(args) > 1 {
+		hash = args[1]
+	}
+
+	slots, err := b.ImageConfirm(imageUploadOpts.SmpConnOptions, hash)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+	printImageSlots(slots)
+}
+
+func imageTestRunCmd(cmd *cobra.Command, args []string) {
+	b := targetBuilderArg(cmd, args)
+
+	hash := ""
+	if len(args) > 1 {
+		hash = args[1]
+	}
+
+	slots, err := b.ImageTest(imageUploadOpts.SmpConnOptions, hash,
+		!imageNoReset)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+	printImageSlots(slots)
+}
+
+func imageRevertRunCmd(cmd *cobra.Command, args []string) {
+	b := targetBuilderArg(cmd, args)
+
+	slots, err := b.ImageRevert(imageUploadOpts.SmpConnOptions, !imageNoReset)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+	printImageSlots(slots)
+}
+
+func addImageMgmtCommands(cmd *cobra.Command) {
+	imageHelpText := "Manage the images on a running device over its " +
+		"SMP (mcumgr) server, without newtmgr or mcumgr.\n\n" +
+		"<conn> is serial:<device>, udp:<host>:<port> or the name of a " +
+		"connection profile\n(see \"newt conn\").\n\n" +
+		"A typical over-the-air update cycle: \"upload\" transfers the " +
+		"image, marks it for\ntest and resets the device; the " +
+		"bootloader runs it once.  \"confirm\" then keeps\nit, while " +
+		"\"revert\" (or a reset) returns to the previous image."
+
+	imageCmd := &cobra.Command{
+		Use:   "image",
+		Short: "Manage images on a device",
+		Long:  imageHelpText,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+
+	pf := imageCmd.PersistentFlags()
+	pf.StringVarP(&imageUploadOpts.Conn, "conn", "", "",
+		"Connection to the device (serial:<device>, udp:<host>:<port> "+
+			"or a connection profile; default: the target's connection "+
+			"profile)")
+	pf.IntVarP(&imageUploadOpts.Baud, "baud", "", 0,
+		"Serial baud rate (default: the BSP's)")
+	pf.IntVarP(&imageUploadOpts.Mtu, "mtu", "", 0,
+		"Largest SMP packet the device accepts")
+	pf.DurationVarP(&imageUploadOpts.Timeout, "timeout", "", 0,
+		"Time to wait for each response (default "+
+			smp.SMP_DEFAULT_TIMEOUT.String()+")")
+
+	cmd.AddCommand(imageCmd)
+
+	uploadHelpText := "Upload <target-name>'s image (created with " +
+		"create-image) to the device's secondary slot, mark it for test " +
+		"and reset the device.  The bootloader then runs the new image " +
+		"once; confirm it with \"newt image confirm\" to keep it."
+	uploadHelpEx := "  newt image upload my_target --conn serial:/dev/ttyACM0\n"
+	uploadHelpEx += "  newt image upload my_target --conn udp:[fe80::1%eth0]:1337 " +
+		"--confirm\n"
+
+	uploadCmd := &cobra.Command{
+		Use:     "upload <target-name>",
+		Short:   "Upload an image to a device",
+		Long:    uploadHelpText,
+		Example: uploadHelpEx,
+		Run:     imageUploadRunCmd,
+	}
+	uploadCmd.Flags().BoolVarP(&imageUploadOpts.Confirm, "confirm", "", false,
+		"Make the image permanent instead of marking it for test")
+	uploadCmd.Flags().BoolVarP(&imageNoTest, "no-test", "", false,
+		"Only upload; leave the image's state alone")
+	uploadCmd.Flags().BoolVarP(&imageUploadOpts.Force, "force", "f", false,
+		"Upload even if the device already has the image")
+
+	imageCmd.AddCommand(uploadCmd)
+	AddTabCompleteFn(uploadCmd, targetList)
+
+	stateCmd := &cobra.Command{
+		Use:   "state <target-name>",
+		Short: "Display the state of a device's image slots",
+		Run:   imageStateRunCmd,
+	}
+
+	imageCmd.AddCommand(stateCmd)
+	AddTabCompleteFn(stateCmd, targetList)
+
+	confirmCmd := &cobra.Command{
+		Use:   "confirm <target-name> [hash]",
+		Short: "Make an image permanent (default: the running image)",
+		Run:   imageConfirmRunCmd,
+	}
+
+	imageCmd.AddCommand(confirmCmd)
+	AddTabCompleteFn(confirmCmd, targetList)
+
+	testHelpText := "Mark an image on the device for test (default: " +
+		"<target-name>'s image, as created\nwith create-image) and reset " +
+		"the device.  The bootloader runs the image once,\nthen reverts " +
+		"to the current image unless it gets confirmed."
+
+	testCmd := &cobra.Command{
+		Use:   "test <target-name> [hash]",
+		Short: "Mark an image for test and reset the device",
+		Long:  testHelpText,
+		Run:   imageTestRunCmd,
+	}
+
+	imageCmd.AddCommand(testCmd)
+	AddTabCompleteFn(testCmd, targetList)
+
+	revertHelpText := "Return the device to its previous image.  If the " +
+		"running image is under test,\nthe device is reset so that the " +
+		"bootloader reverts it; otherwise, the image in\nthe other slot " +
+		"is made permanent and the device is reset."
+
+	revertCmd := &cobra.Command{
+		Use:   "revert <target-name>",
+		Short: "Return the device to its previous image",
+		Long:  revertHelpText,
+		Run:   imageRevertRunCmd,
+	}
+
+	imageCmd.AddCommand(revertCmd)
+	AddTabCompleteFn(revertCmd, targetList)
+
+	for _, c := range []*cobra.Command{uploadCmd, testCmd, revertCmd} {
+		c.Flags().BoolVarP(&imageNoReset, "no-reset", "", false,
+			"Don't reset the device afterwards")
+	}
+}
+
 func AddImageCommands(cmd *cobra.Command) {
 	createImageHelpText := "Create an image by adding an image header to the " +
 		"binary file created for <target-name>. Version number in the header is set " +
-		"to be <version>.\n\nTo sign the image give private key as <signing-key> and an optional key-id."
+		"to be <version>.\n\nTo sign the image give private key as <signing-key> and an optional key-id." +
+		"\n\nIf the target's artifact_name or project.yml's " +
+		"project.artifact_name specifies a name template (e.g., " +
+		"\"{app}-{target}-{version}-{gitsha}.img\"), the image and hex " +
+		"files are also copied to bin/<target>/" + builder.ARTIFACTS_DIR +
+		" under the names it gives them.  Placeholders: {app}, {target}, " +
+		"{bsp}, {version}, {gitsha}, {build_profile}, {date} and {ext}." +
+		"\n\nThe image layout is controlled by the target settings " +
+		"image_header_size, image_align (flash write alignment), image_pad " +
+		"(\"slot\" or a size) and image_security_counter (\"auto\" or a " +
+		"number); a BSP may specify defaults as bsp.image_<setting>."
 	createImageHelpEx := "  newt create-image my_target1 1.2.0\n"
 	createImageHelpEx += "  newt create-image my_target1 1.2.0.3\n"
 	createImageHelpEx += "  newt create-image my_target1 1.2.0.3 private.pem\n"
@@ -156,4 +374,6 @@ func AddImageCommands(cmd *cobra.Command) {
 		"Ignore flash overflow errors during image creation")
 
 	cmd.AddCommand(resignImageCmd)
+
+	addImageMgmtCommands(cmd)
 }
@@ -0,0 +1,376 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/downloader"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+type reportRepo struct {
+	Name         string `json:"name"`
+	Path         string `json:"path"`
+	Kind         string `json:"kind"`
+	Version      string `json:"version,omitempty"`
+	Commit       string `json:"commit,omitempty"`
+	LockedCommit string `json:"locked_commit,omitempty"`
+	Dirty        bool   `json:"dirty"`
+	Error        string `json:"error,omitempty"`
+}
+
+type reportTarget struct {
+	Name         string `json:"name"`
+	App          string `json:"app,omitempty"`
+	Bsp          string `json:"bsp,omitempty"`
+	Loader       string `json:"loader,omitempty"`
+	BuildProfile string `json:"build_profile"`
+	BinBytes     int64  `json:"bin_bytes"`
+}
+
+type reportDir struct {
+	Path    string `json:"path"`
+	Bytes   int64  `json:"bytes"`
+	Entries int    `json:"entries"`
+}
+
+type reportTool struct {
+	Name string `json:"name"`
+	Path string `json:"path,omitempty"`
+}
+
+type reportToolchain struct {
+	Compiler string       `json:"compiler"`
+	Targets  []string     `json:"targets"`
+	Tools    []reportTool `json:"tools"`
+	Error    string       `json:"error,omitempty"`
+}
+
+type projectReport struct {
+	Name          string            `json:"name"`
+	Path          string            `json:"path"`
+	Workspace     string            `json:"workspace,omitempty"`
+	NewtVersion   string            `json:"newt_version"`
+	Repositories  []reportRepo      `json:"repositories"`
+	Targets       []reportTarget    `json:"targets"`
+	Bin           reportDir         `json:"bin"`
+	ParseCache    *reportDir        `json:"parse_cache,omitempty"`
+	DownloadCache *reportDir        `json:"download_cache,omitempty"`
+	Toolchains    []reportToolchain `json:"toolchains"`
+}
+
+// Returns the total size of the regular files under the specified path, and
+// the number of direct children of the path.  Missing paths have a size of 0.
+func diskUsage(path string) (int64, int) {
+	var size int64
+	filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	entries := 0
+	if f, err := os.Open(path); err == nil {
+		names, _ := f.Readdirnames(-1)
+		entries = len(names)
+		f.Close()
+	}
+
+	return size, entries
+}
+
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+
+	if i == 0 {
+		return fmt.Sprintf("%d %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", f, units[i])
+}
+
+func reportRepos(proj *project.Project) []reportRepo {
+	ps, err := project.LoadProjectState()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	names := []string{}
+	for name, _ := range proj.Repos() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	repos := []reportRepo{}
+	for _, name := range names {
+		r := proj.Repos()[name]
+		rr := reportRepo{
+			Name:         name,
+			Path:         r.Path(),
+			LockedCommit: proj.LockedCommit(name),
+		}
+
+		switch {
+		case r.IsLocal():
+			rr.Kind = "local"
+		case r.IsVendored():
+			rr.Kind = "vendored"
+		case r.IsOverridden():
+			rr.Kind = "override"
+		default:
+			rr.Kind = "remote"
+		}
+
+		if vers := ps.GetInstalledVersion(name); vers != nil {
+			rr.Version = vers.String()
+		}
+
+		if !r.IsLocal() {
+			if util.NodeNotExist(r.Path()) {
+				rr.Error = "not installed"
+			} else {
+				rr.Commit, err = r.HeadCommit()
+				if err != nil {
+					rr.Error = err.Error()
+				} else {
+					rr.Dirty, _ = r.HasLocalChanges()
+				}
+			}
+		}
+
+		repos = append(repos, rr)
+	}
+
+	return repos
+}
+
+// Collects the targets in the project along with the toolchains they use.
+// Toolchains are grouped by compiler package.
+func reportTargets(proj *project.Project) ([]reportTarget, []reportToolchain) {
+	names := []string{}
+	for name, t := range target.GetTargets() {
+		if !strings.HasSuffix(t.Name(), "/unittest") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	targets := []reportTarget{}
+	toolchainMap := map[string]*reportToolchain{}
+	compilerNames := []string{}
+
+	for _, name := range names {
+		t := target.GetTargets()[name]
+		binBytes, _ := diskUsage(builder.TargetBinDir(t.Name()))
+		targets = append(targets, reportTarget{
+			Name:         name,
+			App:          t.AppName,
+			Bsp:          t.BspName,
+			Loader:       t.LoaderName,
+			BuildProfile: t.BuildProfile,
+			BinBytes:     binBytes,
+		})
+
+		bspPkg := t.Bsp()
+		if bspPkg == nil {
+			continue
+		}
+		bsp, err := pkg.NewBspPackage(bspPkg)
+		if err != nil || bsp.CompilerName == "" {
+			continue
+		}
+
+		tc := toolchainMap[bsp.CompilerName]
+		if tc == nil {
+			tc = &reportToolchain{Compiler: bsp.CompilerName}
+			toolchainMap[bsp.CompilerName] = tc
+			compilerNames = append(compilerNames, bsp.CompilerName)
+
+			tools, err := compilerTools(proj, bsp, t.BuildProfile)
+			if err != nil {
+				tc.Error = err.Error()
+			}
+			for _, tool := range tools {
+				rt := reportTool{Name: tool}
+				rt.Path, _ = exec.LookPath(tool)
+				tc.Tools = append(tc.Tools, rt)
+			}
+		}
+		tc.Targets = append(tc.Targets, name)
+	}
+
+	sort.Strings(compilerNames)
+	toolchains := []reportToolchain{}
+	for _, name := range compilerNames {
+		toolchains = append(toolchains, *toolchainMap[name])
+	}
+
+	return targets, toolchains
+}
+
+func buildProjectReport(proj *project.Project) projectReport {
+	report := projectReport{
+		Name:        proj.Name(),
+		Path:        proj.Path(),
+		Workspace:   proj.Workspace(),
+		NewtVersion: newtutil.NewtVersion.String(),
+	}
+
+	report.Repositories = reportRepos(proj)
+	report.Targets, report.Toolchains = reportTargets(proj)
+
+	report.Bin.Path = builder.BinRoot()
+	report.Bin.Bytes, report.Bin.Entries = diskUsage(report.Bin.Path)
+
+	parseCache := proj.Path() + "/" + project.PARSE_CACHE_PATH
+	if info, err := os.Stat(parseCache); err == nil {
+		report.ParseCache = &reportDir{
+			Path:    parseCache,
+			Bytes:   info.Size(),
+			Entries: 1,
+		}
+	}
+
+	if dir := downloader.CacheDir(); dir != "" {
+		rd := &reportDir{Path: dir}
+		rd.Bytes, rd.Entries = diskUsage(dir)
+		report.DownloadCache = rd
+	}
+
+	return report
+}
+
+func printProjectReport(report projectReport) {
+	msg := func(format string, args ...interface{}) {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, format, args...)
+	}
+
+	msg("Project: %s (%s)\n", report.Name, report.Path)
+	if report.Workspace != "" {
+		msg("Workspace: %s (repos in %s)\n", report.Workspace,
+			repo.ReposDir())
+	}
+	msg("Newt version: %s\n", report.NewtVersion)
+
+	msg("\nRepositories:\n")
+	for _, r := range report.Repositories {
+		details := []string{r.Kind}
+		if r.Version != "" {
+			details = append(details, "version "+r.Version)
+		}
+		if r.Commit != "" {
+			details = append(details, "commit "+r.Commit)
+		}
+		if r.LockedCommit != "" && r.Commit != "" &&
+			r.LockedCommit != r.Commit {
+
+			details = append(details, colorText(ANSI_YELLOW,
+				"locked at "+r.LockedCommit))
+		}
+		if r.Dirty {
+			details = append(details, colorText(ANSI_YELLOW,
+				"local changes"))
+		}
+		if r.Error != "" {
+			details = append(details, colorText(ANSI_RED, r.Error))
+		}
+		msg("    * @%s: %s\n", r.Name, strings.Join(details, ", "))
+	}
+
+	msg("\nTargets:\n")
+	if len(report.Targets) == 0 {
+		msg("    (none)\n")
+	}
+	for _, t := range report.Targets {
+		details := []string{}
+		if t.App != "" {
+			details = append(details, "app="+t.App)
+		}
+		if t.Loader != "" {
+			details = append(details, "loader="+t.Loader)
+		}
+		details = append(details, "bsp="+t.Bsp,
+			"build_profile="+t.BuildProfile)
+		if t.BinBytes > 0 {
+			details = append(details, "bin="+formatBytes(t.BinBytes))
+		}
+		msg("    * %s: %s\n", t.Name, strings.Join(details, " "))
+	}
+
+	msg("\nDisk usage:\n")
+	msg("    * %s: %s\n", report.Bin.Path, formatBytes(report.Bin.Bytes))
+	if report.ParseCache != nil {
+		msg("    * parse cache (%s): %s\n", report.ParseCache.Path,
+			formatBytes(report.ParseCache.Bytes))
+	}
+	if report.DownloadCache != nil {
+		msg("    * download cache (%s): %s in %d repos\n",
+			report.DownloadCache.Path,
+			formatBytes(report.DownloadCache.Bytes),
+			report.DownloadCache.Entries)
+	}
+
+	msg("\nToolchains:\n")
+	if len(report.Toolchains) == 0 {
+		msg("    (none)\n")
+	}
+	for _, tc := range report.Toolchains {
+		msg("    * %s (used by %s)\n", tc.Compiler,
+			strings.Join(tc.Targets, ", "))
+		if tc.Error != "" {
+			msg("        %s\n", colorText(ANSI_RED, tc.Error))
+		}
+		for _, tool := range tc.Tools {
+			path := tool.Path
+			if path == "" {
+				path = colorText(ANSI_RED, "not found")
+			}
+			msg("        %s: %s\n", tool.Name, path)
+		}
+	}
+}
+
+func infoDeepRunCmd() {
+	proj := TryGetProject()
+	report := buildProjectReport(proj)
+
+	if newtutil.NewtJson {
+		printJson(report)
+	} else {
+		printProjectReport(report)
+	}
+}
@@ -0,0 +1,135 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+var iwyuTarget string
+
+func printIwyuReport(r *builder.IwyuReport) {
+	clean := 0
+	for _, f := range r.Files {
+		if len(f.Unused) == 0 && len(f.Missing) == 0 {
+			clean++
+			continue
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s:\n", f.File)
+		for _, u := range f.Unused {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"    %d: remove #include <%s> (unused)\n", u.Line, u.Header)
+		}
+		for _, m := range f.Missing {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"    add #include <%s> (%s) for %s\n", m.Header, m.Package,
+				strings.Join(m.Symbols, ", "))
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "%d of %d file(s) of %s "+
+		"include what they use\n", clean, len(r.Files), r.Package)
+}
+
+func iwyuRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify a package"))
+	}
+
+	proj := TryGetProject()
+
+	lpkg, err := proj.ResolvePackage(proj.LocalRepo(), args[0])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	var b *builder.TargetBuilder
+	if iwyuTarget != "" {
+		t := ResolveTarget(iwyuTarget)
+		if t == nil {
+			NewtUsage(cmd, util.NewNewtError("Invalid target name: "+
+				iwyuTarget))
+		}
+		b, err = builder.NewTargetBuilder(t)
+	} else {
+		// Like unit tests, the package is built with its own copy of the
+		// unit test target.
+		t, rerr := ResolveUnittest(lpkg.Name())
+		if rerr != nil {
+			NewtUsage(nil, rerr)
+		}
+		b, err = builder.NewTargetTester(t, lpkg)
+	}
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	report, err := b.Iwyu(lpkg)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(report)
+		return
+	}
+
+	printIwyuReport(report)
+}
+
+func AddIwyuCommands(cmd *cobra.Command) {
+	iwyuHelpText := "Suggest #include changes for a package's C and C++ " +
+		"source files: headers that are included but not used, and " +
+		"headers that a file uses without including them directly (it " +
+		"only gets them through other headers).\n\n" +
+		"The package is compiled, with the unit test target unless " +
+		"--target is specified, and each file's headers are taken from " +
+		"the dependency (.d) files the compiler writes.  The symbols a " +
+		"header declares (macros, types, tags, enumerators, functions " +
+		"and extern variables) are found by scanning its text, so the " +
+		"results are suggestions to review rather than exact.  An " +
+		"#include is considered used if the file uses anything declared " +
+		"by the header or by the headers it includes; a file's own " +
+		"header is never reported."
+	iwyuHelpEx := "  newt iwyu net/oic\n"
+	iwyuHelpEx += "  newt iwyu hw/drivers/sensors/bme280 --target " +
+		"my_sensor_app"
+
+	iwyuCmd := &cobra.Command{
+		Use:     "iwyu <package>",
+		Short:   "Suggest #include changes for a package's sources",
+		Long:    iwyuHelpText,
+		Example: iwyuHelpEx,
+		Run:     iwyuRunCmd,
+	}
+
+	iwyuCmd.Flags().StringVarP(&iwyuTarget, "target", "t", "",
+		"Build the package as part of this target instead of the unit "+
+			"test target")
+
+	cmd.AddCommand(iwyuCmd)
+}
@@ -20,14 +20,23 @@
 package cli
 
 import (
+	"crypto"
+	"os"
+	"path/filepath"
+
 	"github.com/spf13/cobra"
 
 	"mynewt.apache.org/newt/newt/image"
 	"mynewt.apache.org/newt/newt/mfg"
+	"mynewt.apache.org/newt/newt/newtutil"
 	"mynewt.apache.org/newt/newt/pkg"
 	"mynewt.apache.org/newt/util"
 )
 
+var mfgInspectKey string
+var mfgInspectExtract []string
+var mfgInspectOutput string
+
 func ResolveMfgPkg(pkgName string) (*pkg.LocalPackage, error) {
 	proj := TryGetProject()
 
@@ -160,6 +169,120 @@ func mfgDeployRunCmd(cmd *cobra.Command, args []string) {
 	mfgLoad(mi)
 }
 
+func validStr(valid bool, err string) string {
+	if valid {
+		return colorText(ANSI_GREEN, "valid")
+	}
+	return colorText(ANSI_RED, err)
+}
+
+func printMfgInspection(ins *mfg.MfgInspection) {
+	for _, s := range ins.Sections {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Section %d: %s (offset 0x%x)\n", s.Device, s.Path, s.Offset)
+	}
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Meta region: offset 0x%x, version %d\n", ins.MetaOffset,
+		ins.MetaVersion)
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Mfg hash: %s (%s)\n",
+		ins.MfgHash, validStr(ins.HashValid, ins.HashError))
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Flash areas:\n")
+	for _, a := range ins.Areas {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"    %-26s id=%-3d device=%d offset=0x%08x size=%d\n",
+			a.Name, a.Id, a.Device, a.Offset, a.Size)
+
+		if a.ImageError != "" {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"        image: %s\n", colorText(ANSI_RED, a.ImageError))
+		}
+		if img := a.Image; img != nil {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"        image: version %s, %d bytes, flags 0x%x\n",
+				img.Version, img.Size, img.Flags)
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"        hash: %s (%s)\n", img.Hash,
+				validStr(img.HashValid, img.HashError))
+			if img.SigType != "" {
+				util.StatusMessage(util.VERBOSITY_DEFAULT,
+					"        signature: %s, key id %d (%s)\n", img.SigType,
+					img.KeyId, validStr(img.SigStatus == "valid",
+						img.SigStatus))
+			}
+		}
+	}
+
+	for _, tlv := range ins.OtherTlvs {
+		name := ""
+		if tlv.Name != "" {
+			name = " (" + tlv.Name + ")"
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Meta TLV type %d%s: %s\n", tlv.Type, name, tlv.Data)
+	}
+}
+
+func mfgInspectRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError(
+			"Must specify mfg package name or mfg image file"))
+	}
+
+	// The argument is either an image file or the name of an mfg package,
+	// whose last created image is inspected.  A package also provides the
+	// names of the flash areas.
+	path := args[0]
+	var names map[int]string
+	if util.NodeNotExist(path) {
+		lpkg, err := ResolveMfgPkg(path)
+		if err != nil {
+			NewtUsage(cmd, err)
+		}
+
+		mi, err := mfg.Load(lpkg)
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+		path = mfg.MfgSectionBinPath(lpkg.Name(), 0)
+		names = mi.AreaNames()
+	}
+
+	var key crypto.PublicKey
+	if mfgInspectKey != "" {
+		var err error
+		key, err = image.ReadVerifyKey(mfgInspectKey)
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+	}
+
+	ins, err := mfg.Inspect(path, names, key)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(ins)
+	} else {
+		printMfgInspection(ins)
+	}
+
+	if len(mfgInspectExtract) > 0 {
+		if err := os.MkdirAll(mfgInspectOutput, 0755); err != nil {
+			NewtUsage(nil, util.ChildNewtError(err))
+		}
+	}
+	for _, area := range mfgInspectExtract {
+		dst := filepath.Join(mfgInspectOutput, area+".bin")
+		if err := ins.ExtractArea(area, dst); err != nil {
+			NewtUsage(nil, err)
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "Extracted %s to %s\n",
+			area, dst)
+	}
+}
+
 func AddMfgCommands(cmd *cobra.Command) {
 	mfgHelpText := ""
 	mfgHelpEx := ""
@@ -198,4 +321,33 @@ func AddMfgCommands(cmd *cobra.Command) {
 	}
 	mfgCmd.AddCommand(mfgDeployCmd)
 	AddTabCompleteFn(mfgDeployCmd, mfgList)
+
+	mfgInspectHelpText := "Parse a manufacturing image and list the flash " +
+		"areas its meta region\ndescribes, with the version and hash of " +
+		"each image they contain.  Verifies\nthe mfg hash, the image " +
+		"hashes and, given --key, the image signatures.\n\n" +
+		"The argument is a section 0 file, as created by newt mfg create, " +
+		"or the name\nof an mfg package, whose last created image is " +
+		"inspected.  The image's other\nsections are read from the same " +
+		"directory."
+	mfgInspectHelpEx := "  newt mfg inspect my_mfg\n"
+	mfgInspectHelpEx += "  newt mfg inspect factory-s0.bin --key " +
+		"pub.pem --extract FLASH_AREA_IMAGE_0\n"
+
+	mfgInspectCmd := &cobra.Command{
+		Use:     "inspect <mfg-package-name | mfg-image-file>",
+		Short:   "Verify a manufacturing image and extract flash areas",
+		Long:    mfgInspectHelpText,
+		Example: mfgInspectHelpEx,
+		Run:     mfgInspectRunCmd,
+	}
+	mfgInspectCmd.Flags().StringVarP(&mfgInspectKey, "key", "", "",
+		"Public (or private) key to verify image signatures with")
+	mfgInspectCmd.Flags().StringArrayVarP(&mfgInspectExtract, "extract", "",
+		nil, "Write the contents of a flash area (name or ID) to "+
+			"<area>.bin; may be repeated")
+	mfgInspectCmd.Flags().StringVarP(&mfgInspectOutput, "output", "", ".",
+		"Directory to extract flash areas to")
+	mfgCmd.AddCommand(mfgInspectCmd)
+	AddTabCompleteFn(mfgInspectCmd, mfgList)
 }
@@ -0,0 +1,186 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+)
+
+// Stack and pool usage at or above this percentage is highlighted.
+const MONITOR_HIGH_USAGE_PCT = 80
+
+var monitorOpts builder.MonitorOptions
+var monitorInterval time.Duration
+var monitorOnce bool
+
+func monitorPct(used int, total int) int {
+	if total == 0 {
+		return 0
+	}
+	return used * 100 / total
+}
+
+func stdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func renderMonitorSnapshot(m *builder.Monitor, targetName string,
+	snap *builder.MonitorSnapshot) []byte {
+
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "%s on %s  %s\n\n", targetName, monitorOpts.Port,
+		snap.Time.Format("15:04:05"))
+
+	fmt.Fprintf(buf, "Tasks:\n")
+	fmt.Fprintf(buf, "    %-12s %4s %15s %10s %10s  %s\n", "task", "pri",
+		"stack used", "csw", "runtime", "syscfg")
+	for _, task := range snap.Tasks {
+		pct := monitorPct(task.StackUsed, task.StackSize)
+		stack := fmt.Sprintf("%5d/%-5d %3d%%", task.StackUsed,
+			task.StackSize, pct)
+		if pct >= MONITOR_HIGH_USAGE_PCT {
+			stack = colorText(ANSI_RED, stack)
+		}
+
+		cfg := ""
+		if task.StackSetting != "" {
+			cfg = fmt.Sprintf("%s=%s", task.StackSetting,
+				m.SettingValue(task.StackSetting))
+		} else if task.PrioSetting != "" {
+			cfg = task.PrioSetting
+		}
+		if task.Package != "" {
+			cfg += " (" + task.Package + ")"
+		}
+
+		fmt.Fprintf(buf, "    %-12s %4d %s %10d %10d  %s\n", task.Name,
+			task.Prio, stack, task.Csw, task.Runtime, cfg)
+	}
+
+	fmt.Fprintf(buf, "\nMemory pools:\n")
+	fmt.Fprintf(buf, "    %-16s %6s %15s %9s\n", "pool", "blksz", "used",
+		"min free")
+	for _, pool := range snap.Pools {
+		used := pool.Count - pool.Free
+		pct := monitorPct(used, pool.Count)
+		usage := fmt.Sprintf("%5d/%-5d %3d%%", used, pool.Count, pct)
+		if pct >= MONITOR_HIGH_USAGE_PCT {
+			usage = colorText(ANSI_RED, usage)
+		}
+		minFree := fmt.Sprintf("%9d", pool.MinFree)
+		if pool.MinFree == 0 {
+			minFree = colorText(ANSI_RED, minFree)
+		}
+
+		fmt.Fprintf(buf, "    %-16s %6d %s %s\n", pool.Name,
+			pool.BlockSize, usage, minFree)
+	}
+
+	if snap.Heap != nil {
+		fmt.Fprintf(buf, "\nHeap: 0x%08x-0x%08x (%d bytes)\n",
+			snap.Heap.Base, snap.Heap.Limit, snap.Heap.Limit-snap.Heap.Base)
+	}
+
+	for _, group := range snap.Stats {
+		fmt.Fprintf(buf, "\nStatistics (%s):\n", group.Name)
+		for _, s := range group.Values {
+			fmt.Fprintf(buf, "    %-32s %10d\n", s.Name, s.Value)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func monitorRunCmd(cmd *cobra.Command, args []string) {
+	b := targetBuilderArg(cmd, args)
+
+	m, err := b.NewMonitor(monitorOpts)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	for {
+		snap, err := m.Snapshot()
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+
+		if newtutil.NewtJson {
+			printJson(snap)
+		} else {
+			out := renderMonitorSnapshot(m, args[0], snap)
+			if !monitorOnce && stdoutIsTerminal() {
+				// Redraw in place.
+				fmt.Printf("\x1b[H\x1b[2J")
+			}
+			os.Stdout.Write(out)
+		}
+
+		if monitorOnce {
+			return
+		}
+		time.Sleep(monitorInterval)
+	}
+}
+
+func AddMonitorCommands(cmd *cobra.Command) {
+	monitorHelpText := "Connect to a running device over serial or BLE " +
+		"and display its tasks' stack usage, memory pool usage and heap " +
+		"in a view that refreshes until interrupted.\n\n" +
+		"Information is read with mcumgr (the device needs the os mgmt " +
+		"group).  Tasks are matched with the syscfg settings that size " +
+		"them (e.g., BLE_LL_PRIO and BLE_LL_STACK_SIZE) and the packages " +
+		"defining them; the heap's bounds come from the built executable."
+	monitorHelpEx := "  newt monitor my_target --port /dev/ttyACM0\n"
+	monitorHelpEx += "  newt monitor my_target --port ble:my_device " +
+		"--stat ble_ll --interval 5s\n"
+
+	monitorCmd := &cobra.Command{
+		Use:     "monitor <target-name>",
+		Short:   "Display live task, memory pool and heap statistics",
+		Long:    monitorHelpText,
+		Example: monitorHelpEx,
+		Run:     monitorRunCmd,
+	}
+
+	monitorCmd.Flags().StringVarP(&monitorOpts.Port, "port", "", "",
+		"Serial port (or ble:<name>) of the device")
+	monitorCmd.Flags().IntVarP(&monitorOpts.Baud, "baud", "", 0,
+		"Baud rate (default: the BSP's)")
+	monitorCmd.Flags().StringSliceVarP(&monitorOpts.Stats, "stat", "", nil,
+		"Statistics group to display as well; may be repeated")
+	monitorCmd.Flags().DurationVarP(&monitorInterval, "interval", "",
+		2*time.Second, "Time between refreshes")
+	monitorCmd.Flags().BoolVarP(&monitorOnce, "once", "", false,
+		"Display one snapshot and exit")
+
+	cmd.AddCommand(monitorCmd)
+	AddTabCompleteFn(monitorCmd, targetList)
+}
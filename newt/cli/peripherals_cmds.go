@@ -0,0 +1,140 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+var peripheralsSvd string
+
+func printPeripheralValues(pv *builder.PeripheralValues) {
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "%s @ 0x%08x  %s\n",
+		pv.Name, pv.BaseAddress, pv.Description)
+
+	nameWidth := 0
+	for _, r := range pv.Registers {
+		if len(r.Name) > nameWidth {
+			nameWidth = len(r.Name)
+		}
+	}
+
+	for _, r := range pv.Registers {
+		if r.Skipped != "" {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"  %-*s  0x%08x  (not read: %s)\n", nameWidth, r.Name,
+				r.Address, r.Skipped)
+			continue
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "  %-*s  0x%08x  "+
+			"0x%0*x\n", nameWidth, r.Name, r.Address, (r.Size+3)/4, r.Value)
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "  %-*s  %s\n",
+			nameWidth, "", r.Description)
+
+		for _, f := range r.Fields {
+			bits := fmt.Sprintf("[%d:%d]", f.Msb, f.Lsb)
+			if f.Msb == f.Lsb {
+				bits = fmt.Sprintf("[%d]", f.Lsb)
+			}
+
+			val := fmt.Sprintf("0x%x", f.Value)
+			if f.Enum != "" {
+				val += " " + colorText(ANSI_GREEN, f.Enum)
+			}
+
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"      %-24s %s\n", f.Name+bits, val)
+		}
+	}
+}
+
+func peripheralsRunCmd(cmd *cobra.Command, args []string) {
+	b := targetBuilderArg(cmd, args)
+	b.SetProbe(jtagBackend)
+	b.SetProbeSerial(probeSerial)
+
+	dev, err := b.SvdDevice(peripheralsSvd)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if len(args) < 2 {
+		if newtutil.NewtJson {
+			printJson(dev.Peripherals)
+			return
+		}
+		for _, p := range dev.Peripherals {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "%-16s 0x%08x  %s\n",
+				p.Name, p.BaseAddress, p.Description)
+		}
+		return
+	}
+
+	pv, err := b.ReadPeripheral(dev, args[1], args[2:])
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(pv)
+	} else {
+		printPeripheralValues(pv)
+	}
+}
+
+func AddPeripheralsCommands(cmd *cobra.Command) {
+	periphHelpText := "Read the registers of <peripheral> on the device " +
+		"of <target-name> over the\ndebug connection and print their " +
+		"bitfields, as described by the BSP's\nCMSIS-SVD file " +
+		"(bsp.svd).  If registers are given, only those are read.\n" +
+		"Without a peripheral, list the peripherals the SVD file " +
+		"describes.\n\n" +
+		"Write-only registers and registers whose reads have side " +
+		"effects (e.g.,\nclearing a status flag) are not read.  The " +
+		"registers are read through the\nprobe backend selected with " +
+		"--jtag or the BSP's bsp.probe_default."
+	periphHelpEx := "  newt peripherals my_target\n"
+	periphHelpEx += "  newt peripherals my_target UARTE0\n"
+	periphHelpEx += "  newt peripherals my_target GPIO OUT DIR --jtag pyocd\n"
+
+	periphCmd := &cobra.Command{
+		Use:     "peripherals <target-name> [<peripheral> [<register>...]]",
+		Short:   "Read and decode peripheral registers of a device",
+		Long:    periphHelpText,
+		Example: periphHelpEx,
+		Run:     peripheralsRunCmd,
+	}
+
+	periphCmd.Flags().StringVarP(&jtagBackend, "jtag", "", "",
+		"Debug probe backend to read the registers with (pyocd or "+
+			"probe-rs)")
+	periphCmd.Flags().StringVarP(&peripheralsSvd, "svd", "", "",
+		"SVD file to use instead of the BSP's")
+	addProbeSerialFlag(periphCmd)
+
+	cmd.AddCommand(periphCmd)
+	AddTabCompleteFn(periphCmd, targetList)
+}
@@ -20,21 +20,27 @@
 package cli
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/spf13/cobra"
+	"mynewt.apache.org/newt/newt/builder"
 	"mynewt.apache.org/newt/newt/interfaces"
 	"mynewt.apache.org/newt/newt/newtutil"
 	"mynewt.apache.org/newt/newt/pkg"
 	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/resolve"
 	"mynewt.apache.org/newt/util"
 )
 
 var NewTypeStr = "pkg"
+var pkgNewTemplate string
+var pkgNewVars []string
 
 func pkgNewCmd(cmd *cobra.Command, args []string) {
 
@@ -46,11 +52,34 @@ func pkgNewCmd(cmd *cobra.Command, args []string) {
 		NewtUsage(cmd, util.NewNewtError("Exactly one argument required"))
 	}
 
-	NewTypeStr = strings.ToUpper(NewTypeStr)
-
 	pw := project.NewPackageWriter()
-	if err := pw.ConfigurePackage(NewTypeStr, args[0]); err != nil {
-		NewtUsage(cmd, err)
+	if pkgNewTemplate != "" {
+		vars := map[string]string{}
+		for _, kv := range pkgNewVars {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				NewtUsage(cmd, util.FmtNewtError(
+					"Invalid template variable: \"%s\"; must have the "+
+						"form <name>=<value>", kv))
+			}
+			vars[parts[0]] = parts[1]
+		}
+
+		if err := pw.ConfigureTemplate(pkgNewTemplate, args[0],
+			vars); err != nil {
+
+			NewtUsage(cmd, err)
+		}
+	} else {
+		if len(pkgNewVars) > 0 {
+			NewtUsage(cmd, util.NewNewtError(
+				"--var requires --template"))
+		}
+
+		NewTypeStr = strings.ToUpper(NewTypeStr)
+		if err := pw.ConfigurePackage(NewTypeStr, args[0]); err != nil {
+			NewtUsage(cmd, err)
+		}
 	}
 	if err := pw.WritePackage(); err != nil {
 		NewtUsage(cmd, err)
@@ -116,17 +145,15 @@ func pkgCloneOrMoveCmd(cmd *cobra.Command, args []string, dirOpFn dirOperation,
 		NewtUsage(cmd, err)
 	}
 
-	dstPath := proj.Path() + "/"
 	repo := proj.LocalRepo()
 	if repoName != "" {
-		dstPath += "repos/" + repoName + "/"
 		repo = proj.FindRepo(repoName)
 		if repo == nil {
 			NewtUsage(cmd, util.NewNewtError("Destination repo "+
 				repoName+" does not exist"))
 		}
 	}
-	dstPath += pkgName + "/"
+	dstPath := repo.Path() + "/" + pkgName + "/"
 
 	if util.NodeExist(dstPath) {
 		NewtUsage(cmd, util.NewNewtError("Cannot overwrite existing package, "+
@@ -211,6 +238,356 @@ func pkgRemoveCmd(cmd *cobra.Command, args []string) {
 	}
 }
 
+var publishPush bool
+var publishRemote string
+var publishDryRun bool
+
+func pkgPublishCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify a release version"))
+	}
+
+	proj := TryGetProject()
+
+	opts := project.PublishOpts{
+		DryRun: publishDryRun,
+	}
+	if publishPush {
+		opts.Remote = publishRemote
+	}
+
+	if err := proj.Publish(args[0], opts); err != nil {
+		NewtUsage(nil, err)
+	}
+}
+
+type pkgListEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Repo string `json:"repo"`
+	Path string `json:"path"`
+}
+
+type pkgListEntrySorter struct {
+	entries []pkgListEntry
+}
+
+func (s pkgListEntrySorter) Len() int {
+	return len(s.entries)
+}
+func (s pkgListEntrySorter) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+}
+func (s pkgListEntrySorter) Less(i, j int) bool {
+	return s.entries[i].Name < s.entries[j].Name
+}
+
+func pkgListCmd(cmd *cobra.Command, args []string) {
+	proj := TryGetProject()
+
+	repoNames := map[string]bool{}
+	for _, arg := range args {
+		repoNames[arg] = true
+	}
+
+	entries := []pkgListEntry{}
+	for repoName, list := range proj.PackageList() {
+		if len(repoNames) > 0 && !repoNames[repoName] {
+			continue
+		}
+		for _, p := range *list {
+			lpkg := p.(*pkg.LocalPackage)
+			entries = append(entries, pkgListEntry{
+				Name: lpkg.FullName(),
+				Type: pkg.PackageTypeNames[lpkg.Type()],
+				Repo: repoName,
+				Path: lpkg.BasePath(),
+			})
+		}
+	}
+	sort.Sort(pkgListEntrySorter{entries})
+
+	if newtutil.NewtJson {
+		printJson(entries)
+		return
+	}
+
+	for _, e := range entries {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%-12s %s\n", e.Type,
+			e.Name)
+	}
+}
+
+func pkgGraphCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target name"))
+	}
+
+	TryGetProject()
+
+	b, err := TargetBuilderForTargetOrUnittest(args[0])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	res, err := b.Resolve()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	dg, err := b.CreateDepGraph()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	name := b.GetTarget().FullName()
+	if newtutil.NewtJson {
+		printJson(builder.DepGraphToJson(dg, name, res.Seeds))
+	} else {
+		fmt.Print(builder.DepGraphDot(dg, name, res.Seeds))
+	}
+}
+
+var depsExplain bool
+
+type pkgDepsEntry struct {
+	Name     string   `json:"name"`
+	Api      string   `json:"api,omitempty"`
+	Settings []string `json:"settings,omitempty"`
+}
+
+func pkgDepsCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		NewtUsage(cmd, util.NewNewtError(
+			"Must specify target name and package name"))
+	}
+
+	TryGetProject()
+
+	b, err := TargetBuilderForTargetOrUnittest(args[0])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	lpkgs, err := ResolvePackages(args[1:])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+	lpkg := lpkgs[0]
+
+	res, err := b.Resolve()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	rpkg := res.LpkgRpkgMap[lpkg]
+	if rpkg == nil {
+		util.StatusMessage(util.VERBOSITY_QUIET,
+			"* Warning: package \"%s\" is not included in target \"%s\"\n",
+			lpkg.FullName(), b.GetTarget().FullName())
+	}
+
+	if depsExplain {
+		decls := res.DeclaredDeps(lpkg)
+		if newtutil.NewtJson {
+			printJson(decls)
+			return
+		}
+
+		printDeclaredDeps(lpkg, decls)
+		return
+	}
+
+	deps := []*resolve.ResolveDep{}
+	if rpkg != nil {
+		for _, dep := range rpkg.Deps {
+			deps = append(deps, dep)
+		}
+		deps = resolve.SortResolveDeps(deps)
+	}
+
+	if newtutil.NewtJson {
+		entries := []pkgDepsEntry{}
+		for _, dep := range deps {
+			entries = append(entries, pkgDepsEntry{
+				Name:     dep.Rpkg.Lpkg.FullName(),
+				Api:      dep.Api,
+				Settings: dep.Settings,
+			})
+		}
+		printJson(entries)
+		return
+	}
+
+	for _, dep := range deps {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s\n", strings.TrimSpace(
+			dep.Rpkg.Lpkg.FullName()+" "+builder.DepReason(dep)))
+	}
+}
+
+func printDeclaredDeps(lpkg *pkg.LocalPackage,
+	decls []resolve.DeclaredDep) {
+
+	if len(decls) == 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Package %s declares no dependencies\n", lpkg.FullName())
+		return
+	}
+
+	nameWidth := 0
+	for _, d := range decls {
+		if len(d.Name) > nameWidth {
+			nameWidth = len(d.Name)
+		}
+	}
+
+	for _, d := range decls {
+		cond := "(unconditional)"
+		if d.Setting != "" {
+			if d.Defined {
+				cond = fmt.Sprintf("%s=%s", d.Setting, d.Value)
+			} else {
+				cond = fmt.Sprintf("%s (undefined)", d.Setting)
+			}
+			if d.Overwrite {
+				cond += " OVERWRITE"
+			}
+		}
+
+		status := colorText(ANSI_RED, "inactive")
+		if d.Active {
+			status = colorText(ANSI_GREEN, "active")
+		}
+
+		kind := "dep"
+		if d.Kind == "req_api" {
+			kind = "api"
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%-4s %-*s  %-8s  %s\n",
+			kind, nameWidth, d.Name, status, cond)
+	}
+}
+
+var whyMaxChains int
+
+type pkgWhyJson struct {
+	Target    string                   `json:"target"`
+	Package   string                   `json:"package"`
+	Included  bool                     `json:"included"`
+	Seed      bool                     `json:"seed"`
+	Chains    [][]builder.DepGraphEdge `json:"chains"`
+	Truncated bool                     `json:"truncated"`
+}
+
+func pkgWhyCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		NewtUsage(cmd, util.NewNewtError(
+			"Must specify target name and package name"))
+	}
+
+	TryGetProject()
+
+	b, err := TargetBuilderForTargetOrUnittest(args[0])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	lpkgs, err := ResolvePackages(args[1:])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	res, err := b.Resolve()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	dg, err := b.CreateDepGraph()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	wj := pkgWhyJson{
+		Target:  b.GetTarget().FullName(),
+		Package: lpkgs[0].FullName(),
+		Chains:  [][]builder.DepGraphEdge{},
+	}
+
+	rpkg := res.LpkgRpkgMap[lpkgs[0]]
+	var chains []builder.DepChain
+	if rpkg != nil {
+		wj.Included = true
+		for _, seed := range res.Seeds {
+			if seed == rpkg {
+				wj.Seed = true
+			}
+		}
+
+		chains, wj.Truncated = builder.DepChains(dg, res.Seeds, rpkg,
+			whyMaxChains)
+		for _, c := range chains {
+			edges := []builder.DepGraphEdge{}
+			parent := c.Seed
+			for _, dep := range c.Deps {
+				edges = append(edges, builder.DepGraphEdge{
+					From:     parent.Lpkg.FullName(),
+					To:       dep.Rpkg.Lpkg.FullName(),
+					Api:      dep.Api,
+					Settings: dep.Settings,
+				})
+				parent = dep.Rpkg
+			}
+			if len(edges) > 0 {
+				wj.Chains = append(wj.Chains, edges)
+			}
+		}
+	}
+
+	if newtutil.NewtJson {
+		printJson(wj)
+		return
+	}
+
+	if !wj.Included {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Package \"%s\" is not included in target \"%s\"\n",
+			wj.Package, wj.Target)
+		return
+	}
+
+	if wj.Seed {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Package \"%s\" is a seed package of target \"%s\"\n",
+			wj.Package, wj.Target)
+	}
+
+	if len(wj.Chains) > 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Dependency chains including \"%s\":\n", wj.Package)
+	}
+	for _, c := range chains {
+		if len(c.Deps) == 0 {
+			continue
+		}
+
+		line := c.Seed.Lpkg.FullName()
+		for _, dep := range c.Deps {
+			line += " --> " + dep.Rpkg.Lpkg.FullName()
+			if reason := builder.DepReason(dep); reason != "" {
+				line += " " + reason
+			}
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "    * %s\n", line)
+	}
+
+	if wj.Truncated {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"* Warning: only the first %d chains are shown; "+
+				"use --max-chains to see more\n", whyMaxChains)
+	}
+}
+
 func AddPackageCommands(cmd *cobra.Command) {
 	/* Add the base package command, on top of which other commands are
 	 * keyed
@@ -231,8 +608,22 @@ func AddPackageCommands(cmd *cobra.Command) {
 	cmd.AddCommand(pkgCmd)
 
 	/* Package new command, create a new package */
-	newCmdHelpText := ""
-	newCmdHelpEx := ""
+	newCmdHelpText := "Create a new package.  By default, the package " +
+		"is downloaded from the template repository for its --type.\n\n" +
+		"Alternatively, --template selects one of the package templates " +
+		"built into newt; --var sets the template's parameters:\n"
+	for _, name := range project.PkgTemplateNames() {
+		newCmdHelpText += fmt.Sprintf("    %-9s %s\n", name,
+			project.PkgTemplateDesc(name))
+	}
+	newCmdHelpText += "\nThe driver template accepts bus=i2c|spi|uart, " +
+		"the sensor template bus=i2c|spi (default i2c).  The unittest " +
+		"template accepts parent=<package-under-test>; it defaults to " +
+		"the new package's parent directory."
+	newCmdHelpEx := "  newt pkg new libs/mylib\n"
+	newCmdHelpEx += "  newt pkg new hw/drivers/mydev --template driver " +
+		"--var bus=spi\n"
+	newCmdHelpEx += "  newt pkg new hw/drivers/mydev/test --template unittest\n"
 
 	newCmd := &cobra.Command{
 		Use:     "new <package-name>",
@@ -244,6 +635,11 @@ func AddPackageCommands(cmd *cobra.Command) {
 
 	newCmd.PersistentFlags().StringVarP(&NewTypeStr, "type", "t",
 		"lib", "Type of package to create: app, bsp, lib, sdk, unittest.")
+	newCmd.PersistentFlags().StringVarP(&pkgNewTemplate, "template", "", "",
+		"Built-in package template: "+
+			strings.Join(project.PkgTemplateNames(), ", "))
+	newCmd.PersistentFlags().StringArrayVarP(&pkgNewVars, "var", "", nil,
+		"Set a template variable (<name>=<value>); may be repeated")
 
 	pkgCmd.AddCommand(newCmd)
 
@@ -285,4 +681,114 @@ func AddPackageCommands(cmd *cobra.Command) {
 	}
 
 	pkgCmd.AddCommand(removeCmd)
+
+	listCmdHelpText := "List the packages in the project.  If one or more " +
+		"repository names are specified, only packages in those " +
+		"repositories are listed."
+	listCmdHelpEx := "  newt pkg list\n"
+	listCmdHelpEx += "  newt pkg list apache-mynewt-core --json"
+
+	listCmd := &cobra.Command{
+		Use:     "list [repo-names...]",
+		Short:   "List packages",
+		Long:    listCmdHelpText,
+		Example: listCmdHelpEx,
+		Run:     pkgListCmd,
+	}
+
+	pkgCmd.AddCommand(listCmd)
+
+	publishCmdHelpText := "Publish a release of the current repository.  " +
+		"Checks that repository.yml maps <version> to a tag and that its " +
+		"version map is consistent, and that every package parses.  Then " +
+		"tags the current commit and, with --push, pushes the tag."
+	publishCmdHelpEx := "  newt pkg publish 1.2.0 --dry-run\n"
+	publishCmdHelpEx += "  newt pkg publish 1.2.0 --push"
+
+	publishCmd := &cobra.Command{
+		Use:     "publish <version>",
+		Short:   "Validate and tag a repository release",
+		Long:    publishCmdHelpText,
+		Example: publishCmdHelpEx,
+		Run:     pkgPublishCmd,
+	}
+
+	publishCmd.PersistentFlags().BoolVarP(&publishPush, "push", "", false,
+		"Push the release tag")
+	publishCmd.PersistentFlags().StringVarP(&publishRemote, "remote", "",
+		"origin", "Remote to push the release tag to")
+	publishCmd.PersistentFlags().BoolVarP(&publishDryRun, "dry-run", "n",
+		false, "Only validate the repository; don't create a tag")
+
+	pkgCmd.AddCommand(publishCmd)
+
+	depsCmdHelpText := "List a package's dependencies in the context of " +
+		"a target.  By default, the dependencies the package has after " +
+		"resolution are listed.  With --explain, every dependency and " +
+		"API requirement declared in the package's pkg.yml is listed " +
+		"instead, along with the syscfg setting guarding it (e.g., " +
+		"pkg.deps.BLE_HOST), the setting's value in the target, and " +
+		"whether the entry is active."
+	depsCmdHelpEx := "  newt pkg deps my_target net/nimble/host\n"
+	depsCmdHelpEx += "  newt pkg deps my_target net/nimble/host --explain"
+
+	depsCmd := &cobra.Command{
+		Use:     "deps <target> <package>",
+		Short:   "List a package's dependencies in a target",
+		Long:    depsCmdHelpText,
+		Example: depsCmdHelpEx,
+		Run:     pkgDepsCmd,
+	}
+
+	depsCmd.PersistentFlags().BoolVarP(&depsExplain, "explain", "", false,
+		"List all declared dependencies with their syscfg conditions")
+
+	pkgCmd.AddCommand(depsCmd)
+	AddTabCompleteFn(depsCmd, func() []string {
+		return append(targetList(), unittestList()...)
+	})
+
+	graphCmdHelpText := "Print the resolved dependency graph of a target " +
+		"in Graphviz DOT format, or as JSON if --json is specified.  " +
+		"Seed packages are drawn as boxes, API dependencies are " +
+		"labelled with the API, and dependencies enabled by syscfg " +
+		"settings are dashed and labelled with those settings."
+	graphCmdHelpEx := "  newt pkg graph my_target | dot -Tsvg > deps.svg\n"
+	graphCmdHelpEx += "  newt pkg graph my_target --json"
+
+	graphCmd := &cobra.Command{
+		Use:     "graph <target>",
+		Short:   "Print a target's dependency graph as DOT or JSON",
+		Long:    graphCmdHelpText,
+		Example: graphCmdHelpEx,
+		Run:     pkgGraphCmd,
+	}
+
+	pkgCmd.AddCommand(graphCmd)
+	AddTabCompleteFn(graphCmd, func() []string {
+		return append(targetList(), unittestList()...)
+	})
+
+	whyCmdHelpText := "Explain why a package is included in a target.  " +
+		"Prints every dependency chain leading from one of the target's " +
+		"seed packages (target, app, BSP, compiler, etc.) to the package, " +
+		"including the API each provider was selected for and the syscfg " +
+		"settings that enabled conditional dependencies."
+	whyCmdHelpEx := "  newt pkg why my_target sys/log/full"
+
+	whyCmd := &cobra.Command{
+		Use:     "why <target> <package>",
+		Short:   "Show why a package is included in a target",
+		Long:    whyCmdHelpText,
+		Example: whyCmdHelpEx,
+		Run:     pkgWhyCmd,
+	}
+
+	whyCmd.PersistentFlags().IntVarP(&whyMaxChains, "max-chains", "", 100,
+		"Maximum number of dependency chains to print; 0 for no limit")
+
+	pkgCmd.AddCommand(whyCmd)
+	AddTabCompleteFn(whyCmd, func() []string {
+		return append(targetList(), unittestList()...)
+	})
 }
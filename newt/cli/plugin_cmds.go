@@ -0,0 +1,209 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+// Executables on the PATH named with this prefix are made available as newt
+// subcommands (e.g., "newt-provision" becomes "newt provision").
+const PLUGIN_PREFIX = "newt-"
+
+// Project context passed to external commands via the NEWT_CONTEXT
+// environment variable.
+type pluginContext struct {
+	NewtVersion string            `json:"newt_version"`
+	NewtPath    string            `json:"newt_path"`
+	ProjectDir  string            `json:"project_dir,omitempty"`
+	ProjectName string            `json:"project_name,omitempty"`
+	Repos       map[string]string `json:"repos,omitempty"`
+	Targets     []string          `json:"targets,omitempty"`
+	Verbosity   int               `json:"verbosity"`
+}
+
+// Finds executables on the PATH that implement newt subcommands.  If several
+// directories contain the same command, the first one wins, as with the
+// shell.
+func findPathPlugins() map[string]string {
+	plugins := map[string]string{}
+
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, info := range infos {
+			name := info.Name()
+			if !strings.HasPrefix(name, PLUGIN_PREFIX) ||
+				info.IsDir() || info.Mode()&0111 == 0 {
+
+				continue
+			}
+
+			cmdName := strings.TrimPrefix(name, PLUGIN_PREFIX)
+			cmdName = strings.TrimSuffix(cmdName, filepath.Ext(cmdName))
+			if cmdName == "" {
+				continue
+			}
+			if _, ok := plugins[cmdName]; !ok {
+				plugins[cmdName] = filepath.Join(dir, name)
+			}
+		}
+	}
+
+	return plugins
+}
+
+// Describes the environment newt is running in.  Project details are only
+// included if newt is run from within a project.
+func buildPluginContext() pluginContext {
+	ctx := pluginContext{
+		NewtVersion: newtutil.NewtVersion.String(),
+		Verbosity:   util.Verbosity,
+	}
+
+	if exe, err := util.Executable(); err == nil {
+		ctx.NewtPath = exe
+	}
+
+	proj, err := project.TryGetProject()
+	if err != nil {
+		log.Debugf("External command runs without project context: %s",
+			err.Error())
+		return ctx
+	}
+
+	ctx.ProjectDir = proj.Path()
+	ctx.ProjectName = proj.Name()
+
+	ctx.Repos = map[string]string{}
+	for name, r := range proj.Repos() {
+		ctx.Repos[name] = r.Path()
+	}
+
+	for name, _ := range target.GetTargets() {
+		if !strings.HasSuffix(name, "/unittest") {
+			ctx.Targets = append(ctx.Targets, name)
+		}
+	}
+	sort.Strings(ctx.Targets)
+
+	return ctx
+}
+
+// Executes an external command, passing it the remaining command line
+// arguments and the project context.  Newt exits with the command's exit
+// status.
+func runPlugin(path string, args []string) {
+	ctx := buildPluginContext()
+	ctxJson, err := json.Marshal(ctx)
+	if err != nil {
+		NewtUsage(nil, util.ChildNewtError(err))
+	}
+
+	env := append(os.Environ(),
+		"NEWT_CONTEXT="+string(ctxJson),
+		"NEWT_PATH="+ctx.NewtPath,
+		"NEWT_VERSION="+ctx.NewtVersion)
+	if ctx.ProjectDir != "" {
+		env = append(env, "NEWT_PROJECT_DIR="+ctx.ProjectDir)
+	}
+
+	c := exec.Command(path, args...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	c.Env = env
+
+	log.Debugf("Running external command: %s %s", path,
+		strings.Join(args, " "))
+
+	if err := c.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				os.Exit(ws.ExitStatus())
+			}
+		}
+		NewtUsage(nil, util.FmtNewtError("Failed to run %s: %s", path,
+			err.Error()))
+	}
+}
+
+// Registers a newt subcommand for each external command declared in the
+// project's project.yml or found on the PATH.  Project commands take
+// precedence over PATH commands of the same name; built-in commands cannot be
+// overridden.
+func AddPluginCommands(cmd *cobra.Command, projDir string) {
+	plugins := findPathPlugins()
+
+	if projDir != "" {
+		projCmds, err := project.ReadProjectCommands(projDir)
+		if err != nil {
+			log.Debugf("Failed to read project commands: %s", err.Error())
+		}
+		for name, path := range projCmds {
+			plugins[name] = path
+		}
+	}
+
+	names := make([]string, 0, len(plugins))
+	for name, _ := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := plugins[name]
+
+		if existing, _, err := cmd.Find([]string{name}); err == nil &&
+			existing != cmd {
+
+			log.Debugf("Ignoring external command %s (%s); conflicts with "+
+				"a built-in command", name, path)
+			continue
+		}
+
+		pluginCmd := &cobra.Command{
+			Use:                name,
+			Short:              "External command (" + path + ")",
+			DisableFlagParsing: true,
+			Run: func(cmd *cobra.Command, args []string) {
+				runPlugin(path, args)
+			},
+		}
+		cmd.AddCommand(pluginCmd)
+	}
+}
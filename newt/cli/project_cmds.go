@@ -26,13 +26,59 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
+	"mynewt.apache.org/newt/newt/builder"
 	"mynewt.apache.org/newt/newt/downloader"
 	"mynewt.apache.org/newt/newt/interfaces"
 	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
 	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/newt/target"
 	"mynewt.apache.org/newt/util"
 )
 
+var newTemplate string
+var newVars []string
+var infoDeep bool
+
+// Downloads a project template specified as [<host>/]<user>/<repo>[@<ref>].
+// A path to an existing directory is used as is.  Returns the template
+// directory and whether it is a temporary copy.
+func fetchProjectTemplate(spec string) (string, bool, error) {
+	if util.NodeExist(spec) {
+		return spec, false, nil
+	}
+
+	ref := "master"
+	if idx := strings.LastIndex(spec, "@"); idx != -1 {
+		ref = spec[idx+1:]
+		spec = spec[:idx]
+	}
+
+	parts := strings.Split(spec, "/")
+	dl := downloader.NewGithubDownloader()
+	switch len(parts) {
+	case 2:
+		dl.User, dl.Repo = parts[0], parts[1]
+	case 3:
+		dl.Server, dl.User, dl.Repo = parts[0], parts[1], parts[2]
+	default:
+		return "", false, util.FmtNewtError(
+			"Invalid template: %s; must be a directory or "+
+				"[<host>/]<user>/<repo>[@<ref>]", spec)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Downloading "+
+		"project template from %s...\n", spec)
+
+	dir, err := dl.DownloadRepo(ref)
+	if err != nil {
+		return "", false, err
+	}
+
+	return dir, true, nil
+}
+
 func newRunCmd(cmd *cobra.Command, args []string) {
 	if len(args) < 1 {
 		NewtUsage(cmd, util.NewNewtError("Must specify "+
@@ -46,26 +92,48 @@ func newRunCmd(cmd *cobra.Command, args []string) {
 			"directory already exists"))
 	}
 
-	util.StatusMessage(util.VERBOSITY_DEFAULT, "Downloading "+
-		"project skeleton from apache/mynewt-blinky...\n")
-	dl := downloader.NewGithubDownloader()
-	dl.User = "apache"
-	dl.Repo = "mynewt-blinky"
+	vars := map[string]string{}
+	for _, kv := range newVars {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			NewtUsage(cmd, util.FmtNewtError(
+				"Invalid template variable: \"%s\"; must have the form "+
+					"<name>=<value>", kv))
+		}
+		vars[parts[0]] = parts[1]
+	}
 
-	dir, err := dl.DownloadRepo(newtutil.NewtBlinkyTag)
-	if err != nil {
-		NewtUsage(cmd, err)
+	var dir string
+	if newTemplate != "" {
+		var isTmp bool
+		var err error
+
+		dir, isTmp, err = fetchProjectTemplate(newTemplate)
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+		if isTmp {
+			defer os.RemoveAll(dir)
+		}
+	} else {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "Downloading "+
+			"project skeleton from apache/mynewt-blinky...\n")
+		dl := downloader.NewGithubDownloader()
+		dl.User = "apache"
+		dl.Repo = "mynewt-blinky"
+
+		var err error
+		dir, err = dl.DownloadRepo(newtutil.NewtBlinkyTag)
+		if err != nil {
+			NewtUsage(cmd, err)
+		}
 	}
 
 	util.StatusMessage(util.VERBOSITY_DEFAULT, "Installing "+
 		"skeleton in %s...\n", newDir)
 
-	if err := util.CopyDir(dir, newDir); err != nil {
-		NewtUsage(cmd, err)
-	}
-
-	if err := os.RemoveAll(newDir + "/" + "/.git/"); err != nil {
-		NewtUsage(cmd, err)
+	if err := project.CreateFromTemplate(dir, newDir, vars); err != nil {
+		NewtUsage(nil, err)
 	}
 
 	util.StatusMessage(util.VERBOSITY_DEFAULT,
@@ -90,12 +158,80 @@ func upgradeRunCmd(cmd *cobra.Command, args []string) {
 	}
 }
 
+// Returns the names of the packages in the specified repo, sorted.
+func infoPkgNames(proj *project.Project, repoName string) []string {
+	packNames := []string{}
+	for _, pack := range *proj.PackageList()[repoName] {
+		// Don't display the special unittest target; this is used
+		// internally by newt, so the user doesn't need to know about
+		// it.
+		// XXX: This is a hack; come up with a better solution for
+		// unit testing.
+		if !strings.HasSuffix(pack.Name(), "/unittest") {
+			packNames = append(packNames, pack.Name())
+		}
+	}
+
+	sort.Strings(packNames)
+	return packNames
+}
+
+func infoJson(proj *project.Project, repoNames []string, reqRepoName string) {
+	type repoInfo struct {
+		Name     string   `json:"name"`
+		Path     string   `json:"path"`
+		Version  string   `json:"version,omitempty"`
+		Packages []string `json:"packages,omitempty"`
+	}
+
+	info := struct {
+		Name         string     `json:"name"`
+		Path         string     `json:"path"`
+		Workspace    string     `json:"workspace,omitempty"`
+		Repositories []repoInfo `json:"repositories"`
+	}{
+		Name:      proj.Name(),
+		Path:      proj.Path(),
+		Workspace: proj.Workspace(),
+	}
+
+	if reqRepoName == "" {
+		reqRepoName = "local"
+	}
+
+	ps, err := project.LoadProjectState()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	for _, repoName := range repoNames {
+		ri := repoInfo{Name: repoName}
+		if r := proj.FindRepo(repoName); r != nil {
+			ri.Path = r.Path()
+		}
+		if vers := ps.GetInstalledVersion(repoName); vers != nil {
+			ri.Version = vers.String()
+		}
+		if reqRepoName == "all" || reqRepoName == repoName {
+			ri.Packages = infoPkgNames(proj, repoName)
+		}
+		info.Repositories = append(info.Repositories, ri)
+	}
+
+	printJson(info)
+}
+
 func infoRunCmd(cmd *cobra.Command, args []string) {
 	reqRepoName := ""
 	if len(args) >= 1 {
 		reqRepoName = strings.TrimPrefix(args[0], "@")
 	}
 
+	if infoDeep {
+		infoDeepRunCmd()
+		return
+	}
+
 	proj := TryGetProject()
 
 	repoNames := []string{}
@@ -104,7 +240,18 @@ func infoRunCmd(cmd *cobra.Command, args []string) {
 	}
 	sort.Strings(repoNames)
 
+	if newtutil.NewtJson {
+		infoJson(proj, repoNames, reqRepoName)
+		return
+	}
+
 	if reqRepoName == "" {
+		if proj.Workspace() != "" {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"Workspace: %s (repos in %s)\n\n", proj.Workspace(),
+				repo.ReposDir())
+		}
+
 		util.StatusMessage(util.VERBOSITY_DEFAULT, "Repositories in %s:\n",
 			proj.Name())
 
@@ -120,19 +267,7 @@ func infoRunCmd(cmd *cobra.Command, args []string) {
 	firstRepo := true
 	for _, repoName := range repoNames {
 		if reqRepoName == "all" || reqRepoName == repoName {
-			packNames := []string{}
-			for _, pack := range *proj.PackageList()[repoName] {
-				// Don't display the special unittest target; this is used
-				// internally by newt, so the user doesn't need to know about
-				// it.
-				// XXX: This is a hack; come up with a better solution for
-				// unit testing.
-				if !strings.HasSuffix(pack.Name(), "/unittest") {
-					packNames = append(packNames, pack.Name())
-				}
-			}
-
-			sort.Strings(packNames)
+			packNames := infoPkgNames(proj, repoName)
 			if !firstRepo {
 				util.StatusMessage(util.VERBOSITY_DEFAULT, "\n")
 			} else {
@@ -161,7 +296,8 @@ func syncRunCmd(cmd *cobra.Command, args []string) {
 	for _, repo := range repos {
 		var exists bool
 		var updated bool
-		if repo.IsLocal() {
+		if repo.IsLocal() || repo.IsVendored() ||
+			repo.IsOverridden() {
 			continue
 		}
 		vers := ps.GetInstalledVersion(repo.Name())
@@ -173,6 +309,10 @@ func syncRunCmd(cmd *cobra.Command, args []string) {
 		exists, updated, err = repo.Sync(vers, newtutil.NewtForce)
 		if exists && !updated {
 			failedRepos = append(failedRepos, repo.Name())
+		} else if updated {
+			if err := proj.ApplyLock(repo); err != nil {
+				NewtUsage(nil, err)
+			}
 		}
 	}
 	if len(failedRepos) > 0 {
@@ -186,6 +326,99 @@ func syncRunCmd(cmd *cobra.Command, args []string) {
 	}
 }
 
+var vendorUsedOnly bool
+
+func outdatedRunCmd(cmd *cobra.Command, args []string) {
+	proj := TryGetProject()
+	rfs := proj.Outdated()
+
+	if newtutil.NewtJson {
+		printJson(rfs)
+		return
+	}
+
+	orNone := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "%-24s %-16s %-16s %-16s\n",
+		"Repository", "Installed", "Compatible", "Newest")
+	for _, rf := range rfs {
+		if rf.ErrorMessage != "" {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "%-24s error: %s\n",
+				rf.Name, rf.ErrorMessage)
+			continue
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"%-24s %-16s %-16s %-16s\n", rf.Name, orNone(rf.Installed),
+			orNone(rf.Compatible), orNone(rf.Newest))
+	}
+}
+
+// Collects the packages used by every target in the project, grouped by repo
+// name.
+func vendorUsedPkgs() (map[string][]*pkg.LocalPackage, error) {
+	usedPkgs := map[string][]*pkg.LocalPackage{}
+	seen := map[*pkg.LocalPackage]struct{}{}
+
+	names := []string{}
+	for name, _ := range target.GetTargets() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t := target.GetTargets()[name]
+		if t.AppName == "" {
+			continue
+		}
+
+		b, err := builder.NewTargetBuilder(t)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := b.Resolve()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rpkg := range res.MasterSet.Rpkgs {
+			lpkg := rpkg.Lpkg
+			if _, ok := seen[lpkg]; ok {
+				continue
+			}
+			seen[lpkg] = struct{}{}
+
+			rname := lpkg.Repo().Name()
+			usedPkgs[rname] = append(usedPkgs[rname], lpkg)
+		}
+	}
+
+	return usedPkgs, nil
+}
+
+func vendorRunCmd(cmd *cobra.Command, args []string) {
+	proj := TryGetProject()
+
+	var usedPkgs map[string][]*pkg.LocalPackage
+	if vendorUsedOnly {
+		var err error
+		usedPkgs, err = vendorUsedPkgs()
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+	}
+
+	if err := proj.Vendor(usedPkgs); err != nil {
+		NewtUsage(nil, err)
+	}
+}
+
 func AddProjectCommands(cmd *cobra.Command) {
 	installHelpText := ""
 	installHelpEx := ""
@@ -200,6 +433,9 @@ func AddProjectCommands(cmd *cobra.Command) {
 		"force", "f", false,
 		"Force install of the repositories in project, regardless of what "+
 			"exists in repos directory")
+	installCmd.PersistentFlags().BoolVarP(&newtutil.NewtIgnoreLock,
+		"ignore-lock", "", false,
+		"Ignore the repository commits recorded in project.lock")
 
 	cmd.AddCommand(installCmd)
 
@@ -230,22 +466,39 @@ func AddProjectCommands(cmd *cobra.Command) {
 	syncCmd.PersistentFlags().BoolVarP(&newtutil.NewtForce,
 		"force", "f", false,
 		"Force overwrite of existing remote repositories.")
+	syncCmd.PersistentFlags().BoolVarP(&newtutil.NewtIgnoreLock,
+		"ignore-lock", "", false,
+		"Ignore the repository commits recorded in project.lock")
 	cmd.AddCommand(syncCmd)
 
-	newHelpText := ""
-	newHelpEx := ""
+	newHelpText := "Create a new project in <project-dir>.  By default, the " +
+		"project is based on apache/mynewt-blinky.  A template's " +
+		"template.yml file declares the variables that --var sets; files " +
+		"named *.tmpl and path names are rendered with Go's text/template."
+	newHelpEx := "  newt new myproj\n"
+	newHelpEx += "  newt new-project myproj --template acme/newt-template " +
+		"--var bsp=nrf52840dk --var ble=true\n"
 	newCmd := &cobra.Command{
 		Use:     "new <project-dir>",
+		Aliases: []string{"new-project"},
 		Short:   "Create a new project",
 		Long:    newHelpText,
 		Example: newHelpEx,
 		Run:     newRunCmd,
 	}
+	newCmd.PersistentFlags().StringVarP(&newTemplate, "template", "", "",
+		"Project template: a directory or [<host>/]<user>/<repo>[@<ref>]")
+	newCmd.PersistentFlags().StringArrayVarP(&newVars, "var", "", nil,
+		"Set a template variable (<name>=<value>); may be repeated")
 
 	cmd.AddCommand(newCmd)
 
-	infoHelpText := "Show information about the current project."
+	infoHelpText := "Show information about the current project.  With " +
+		"--deep, show a project health report instead: repository " +
+		"commits and local changes, targets, disk usage of build " +
+		"artifacts and caches, and the toolchains the targets require."
 	infoHelpEx := "  newt info\n"
+	infoHelpEx += "  newt info --deep --json\n"
 
 	infoCmd := &cobra.Command{
 		Use:     "info",
@@ -254,6 +507,42 @@ func AddProjectCommands(cmd *cobra.Command) {
 		Example: infoHelpEx,
 		Run:     infoRunCmd,
 	}
+	infoCmd.Flags().BoolVarP(&infoDeep, "deep", "", false,
+		"Show a full project health report")
 
 	cmd.AddCommand(infoCmd)
+
+	vendorHelpText := "Copy the project's external repositories into the " +
+		"vendor directory and update project.yml to refer to the copies.  " +
+		"The origin of each copy is recorded in vendor/vendor.yml."
+	vendorHelpEx := "  newt vendor\n"
+	vendorHelpEx += "  newt vendor --used-only\n"
+
+	vendorCmd := &cobra.Command{
+		Use:     "vendor",
+		Short:   "Copy external repositories into the project",
+		Long:    vendorHelpText,
+		Example: vendorHelpEx,
+		Run:     vendorRunCmd,
+	}
+	vendorCmd.PersistentFlags().BoolVarP(&vendorUsedOnly,
+		"used-only", "", false,
+		"Only copy the packages used by the project's targets")
+
+	cmd.AddCommand(vendorCmd)
+
+	outdatedHelpText := "List the installed version of each repository " +
+		"alongside the newest version allowed by project.yml and the " +
+		"newest version available."
+	outdatedHelpEx := "  newt outdated\n"
+	outdatedHelpEx += "  newt outdated --json\n"
+
+	outdatedCmd := &cobra.Command{
+		Use:     "outdated",
+		Short:   "Check repositories for newer versions",
+		Long:    outdatedHelpText,
+		Example: outdatedHelpEx,
+		Run:     outdatedRunCmd,
+	}
+	cmd.AddCommand(outdatedCmd)
 }
@@ -0,0 +1,81 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/util"
+)
+
+var releaseOut string
+var releaseAllowUnsigned bool
+
+func releasePackageRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	TryGetProject()
+
+	t := ResolveTarget(args[0])
+	if t == nil {
+		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
+	}
+
+	b, err := builder.NewTargetBuilder(t)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if err := b.Package(releaseOut, releaseAllowUnsigned); err != nil {
+		NewtUsage(nil, err)
+	}
+}
+
+func AddReleaseCommands(cmd *cobra.Command) {
+	packageHelpText := "Bundle the images most recently created for a " +
+		"target into a zip archive for release: the signed image, hex " +
+		"file, ELF, linker map, debug symbols, build manifest and an SPDX " +
+		"bill of materials, under a <target>-<version> directory with a " +
+		builder.RELEASE_SUMS_FILENAME + " file.\n\n" +
+		"Nothing is built; run \"newt create-image\" first.  Unsigned " +
+		"images are rejected unless --allow-unsigned is given."
+	packageHelpEx := "  newt create-image my_app 1.2.0 key-ec256.pem\n"
+	packageHelpEx += "  newt package my_app --out my_app-1.2.0.zip\n"
+
+	packageCmd := &cobra.Command{
+		Use:     "package <target-name>",
+		Short:   "Bundle a target's build artifacts into a release archive",
+		Long:    packageHelpText,
+		Example: packageHelpEx,
+		Run:     releasePackageRunCmd,
+	}
+
+	packageCmd.Flags().StringVarP(&releaseOut, "out", "", "",
+		"Archive to write (default: named by the artifact name "+
+			"template, or <target>-release.zip)")
+	packageCmd.Flags().BoolVarP(&releaseAllowUnsigned, "allow-unsigned",
+		"", false, "Package images that are not signed")
+
+	cmd.AddCommand(packageCmd)
+	AddTabCompleteFn(packageCmd, targetList)
+}
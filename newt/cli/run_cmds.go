@@ -21,12 +21,39 @@ package cli
 
 import (
 	"fmt"
+	"os"
+	"time"
+
 	"github.com/spf13/cobra"
 
+	"mynewt.apache.org/newt/newt/builder"
 	"mynewt.apache.org/newt/newt/newtutil"
 	"mynewt.apache.org/newt/util"
 )
 
+// Emulator to boot the image in instead of loading it onto a device.
+var runEmulator string
+var runGdbPort int
+var runEmulatorTimeout time.Duration
+
+// Attach the console after loading, instead of the debugger.
+var runConsole bool
+var runWatch builder.RunWatch
+var runSemihosting bool
+
+// Exits newt with the exit code the image reported, if any.
+func exitWithImageCode(res *builder.RunResult) {
+	if res == nil || res.ExitCode < 0 {
+		return
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Image exited with code %d\n",
+		res.ExitCode)
+	if res.ExitCode != 0 {
+		os.Exit(res.ExitCode)
+	}
+}
+
 func runRunCmd(cmd *cobra.Command, args []string) {
 	if len(args) < 1 {
 		NewtUsage(cmd, util.NewNewtError("Must specify target"))
@@ -38,9 +65,48 @@ func runRunCmd(cmd *cobra.Command, args []string) {
 	if err != nil {
 		NewtUsage(cmd, err)
 	}
+	if err := applyLoadMethod(b); err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	if runWatch.Until != "" && runEmulator == "" {
+		runConsole = true
+	}
+	if runSemihosting && runEmulator == "" {
+		NewtUsage(cmd, util.NewNewtError(
+			"--semihosting requires --emulator"))
+	}
+	if consoleRtt {
+		consoleOpts.Source = builder.CONSOLE_SOURCE_RTT
+	}
 
 	testPkg := b.GetTestPkg()
-	if testPkg != nil {
+	if testPkg != nil && (runEmulator != "" || runConsole) {
+		NewtUsage(nil, util.NewNewtError(
+			"--emulator and --console cannot be used with unit tests"))
+	}
+
+	if runEmulator != "" {
+		if err := b.Build(); err != nil {
+			NewtUsage(nil, err)
+		}
+		if len(args) > 1 {
+			if _, _, err := b.CreateImages(args[1], "", 0); err != nil {
+				NewtUsage(nil, err)
+			}
+		}
+
+		watch := runWatch
+		if watch.Timeout == 0 {
+			watch.Timeout = runEmulatorTimeout
+		}
+		res, err := b.Emulate(runEmulator, runGdbPort, watch,
+			runSemihosting)
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+		exitWithImageCode(res)
+	} else if testPkg != nil {
 		b.InjectSetting("TESTUTIL_SYSTEM_ASSERT", "1")
 		if err := b.SelfTestCreateExe(); err != nil {
 			NewtUsage(nil, err)
@@ -64,10 +130,13 @@ func runRunCmd(cmd *cobra.Command, args []string) {
 			}
 			features := res.Cfg.Features()
 
+			// An unattended run uses the default without asking.
 			if !features["BOOT_LOADER"] && !features["BSP_SIMULATED"] {
 				version = "0"
-				fmt.Println("Enter image version(default 0):")
-				fmt.Scanf("%s\n", &version)
+				if !runConsole {
+					fmt.Println("Enter image version(default 0):")
+					fmt.Scanf("%s\n", &version)
+				}
 			}
 		}
 		if err := b.Build(); err != nil {
@@ -82,6 +151,15 @@ func runRunCmd(cmd *cobra.Command, args []string) {
 
 		}
 
+		if runConsole {
+			res, err := b.LoadAndWatch(extraJtagCmd, consoleOpts, runWatch)
+			if err != nil {
+				NewtUsage(nil, err)
+			}
+			exitWithImageCode(res)
+			return
+		}
+
 		if err := b.Load(extraJtagCmd); err != nil {
 			NewtUsage(nil, err)
 		}
@@ -99,7 +177,24 @@ func AddRunCommands(cmd *cobra.Command) {
 		" - load <target>\n" +
 		" - debug <target>\n\n" +
 		"Note if version number is omitted, create-image step is skipped\n"
+	runHelpText += "\nWith --emulator qemu|renode, the load and debug steps " +
+		"are replaced by\nbooting the image in the emulator described by " +
+		"the BSP's\nbsp.emulator settings.\n"
+	runHelpText += "\nWith --console, the debug step is replaced by " +
+		"attaching the target's console\n(see \"newt console\"); loading " +
+		"resets the device.  The run can end when a\nconsole line matches " +
+		"--until, or after --timeout; if neither line nor timeout\nis " +
+		"reached, newt fails.  If the --until pattern has a capture group, " +
+		"it holds\nthe image's exit code, and newt exits with that code.  " +
+		"In an emulator, --semihosting\n(qemu only) lets the image exit " +
+		"the emulator with its own code.  Unattended\nruns don't prompt " +
+		"for a version; the image is created with version 0.\n"
 	runHelpEx := "  newt run <target-name> [<version>]\n"
+	runHelpEx += "  newt run <target-name> --emulator qemu --gdb-port 1234\n"
+	runHelpEx += "  newt run <target-name> --console --until " +
+		"'TEST EXIT: (\\d+)' --timeout 2m\n"
+	runHelpEx += "  newt run <target-name> --emulator qemu --semihosting " +
+		"--timeout 30s\n"
 
 	runCmd := &cobra.Command{
 		Use:     "run",
@@ -111,11 +206,41 @@ func AddRunCommands(cmd *cobra.Command) {
 
 	runCmd.PersistentFlags().StringVarP(&extraJtagCmd, "extrajtagcmd", "", "",
 		"Extra commands to send to JTAG software")
+	runCmd.PersistentFlags().StringVarP(&jtagBackend, "jtag", "", "",
+		"Debug probe backend to load and debug with (pyocd, probe-rs, "+
+			"or script)")
+	addLoadMethodFlags(runCmd)
 	runCmd.PersistentFlags().BoolVarP(&noGDB_flag, "noGDB", "n", false,
 		"Do not start GDB from command line")
 	runCmd.PersistentFlags().BoolVarP(&newtutil.NewtForce,
 		"force", "f", false,
 		"Ignore flash overflow errors during image creation")
+	runCmd.PersistentFlags().StringVarP(&runEmulator, "emulator", "", "",
+		"Boot the image in an emulator (qemu or renode) instead of on a "+
+			"device")
+	runCmd.PersistentFlags().IntVarP(&runGdbPort, "gdb-port", "", 0,
+		"With --emulator, start halted with a GDB server on this port")
+	runCmd.PersistentFlags().DurationVarP(&runEmulatorTimeout,
+		"emulator-timeout", "", 0,
+		"With --emulator, stop the emulator after this long (e.g., 30s)")
+	runCmd.PersistentFlags().BoolVarP(&runConsole, "console", "", false,
+		"Attach the console after loading instead of the debugger")
+	runCmd.PersistentFlags().StringVarP(&runWatch.Until, "until", "", "",
+		"Stop once a console line matches this pattern; a capture group "+
+			"holds the exit code")
+	runCmd.PersistentFlags().DurationVarP(&runWatch.Timeout, "timeout", "",
+		0, "Stop watching the console after this long (e.g., 2m)")
+	runCmd.PersistentFlags().BoolVarP(&runSemihosting, "semihosting", "",
+		false, "With --emulator qemu, take the exit code from the image's "+
+			"semihosting exit call")
+	runCmd.PersistentFlags().BoolVarP(&consoleRtt, "rtt", "", false,
+		"With --console, read the console over RTT")
+	runCmd.PersistentFlags().StringVarP(&consoleOpts.Port, "console-port",
+		"", "", "With --console, serial device of the UART console")
+	runCmd.PersistentFlags().IntVarP(&consoleOpts.Baud, "console-baud", "",
+		0, "With --console, baud rate of the UART console")
+	runCmd.PersistentFlags().StringVarP(&consoleOpts.LogFile, "console-log",
+		"", "", "With --console, also write the console output to a file")
 
 	cmd.AddCommand(runCmd)
 	AddTabCompleteFn(runCmd, func() []string {
@@ -0,0 +1,124 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+var secureBoot string
+var secureKey string
+
+func secureCheckRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	TryGetProject()
+
+	appTarget := ResolveTarget(args[0])
+	if appTarget == nil {
+		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
+	}
+
+	var bootTarget *target.Target
+	if secureBoot != "" {
+		bootTarget = ResolveTarget(secureBoot)
+		if bootTarget == nil {
+			NewtUsage(cmd, util.NewNewtError("Invalid target name: "+
+				secureBoot))
+		}
+	} else {
+		var err error
+		bootTarget, err = builder.FindBootTarget(appTarget)
+		if err != nil {
+			NewtUsage(cmd, err)
+		}
+	}
+
+	appBuilder, err := builder.NewTargetBuilder(appTarget)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+	bootBuilder, err := builder.NewTargetBuilder(bootTarget)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	report, err := builder.SecureCheck(appBuilder, bootBuilder, secureKey)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(report)
+	} else if len(report.Findings) > 0 {
+		util.StatusMessage(util.VERBOSITY_QUIET, "%s\n", report.Text())
+	}
+
+	if n := report.NumErrors(); n > 0 {
+		NewtUsage(nil, util.FmtNewtError(
+			"Secure boot check of %s against %s failed (%d error(s))",
+			report.Target, report.BootTarget, n))
+	}
+
+	if !newtutil.NewtJson {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"%s is consistent with bootloader %s\n", report.Target,
+			report.BootTarget)
+	}
+}
+
+func AddSecureCommands(cmd *cobra.Command) {
+	secureHelpText := "Check that an app target can be booted by its " +
+		"bootloader before anything is flashed.  The bootloader's syscfg " +
+		"(signature type, swap mode, encryption) is compared with the " +
+		"image that \"newt create-image\" would produce with the given " +
+		"signing key, and the flash maps of both targets are compared.  " +
+		"The app is built so that the size of the padded image can be " +
+		"checked against the image slots.\n\n" +
+		"The bootloader target is the other target with the same BSP " +
+		"whose app is a boot package, unless --boot names one."
+	secureHelpEx := "  newt secure-check my_app --key key-ec256.pem\n"
+	secureHelpEx += "  newt secure-check my_app --boot my_boot " +
+		"--key key-rsa2048.pem\n"
+
+	secureCmd := &cobra.Command{
+		Use:     "secure-check <target-name>",
+		Short:   "Validate an app target against its bootloader",
+		Long:    secureHelpText,
+		Example: secureHelpEx,
+		Run:     secureCheckRunCmd,
+	}
+
+	secureCmd.Flags().StringVarP(&secureBoot, "boot", "", "",
+		"Bootloader target")
+	secureCmd.Flags().StringVarP(&secureKey, "key", "", "",
+		"Signing key the image will be signed with; the image is "+
+			"unsigned if omitted")
+
+	cmd.AddCommand(secureCmd)
+	AddTabCompleteFn(secureCmd, targetList)
+}
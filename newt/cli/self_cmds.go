@@ -0,0 +1,352 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
+)
+
+var selfUpdateForce bool
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	// The null device is a character device too.
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return false
+	}
+
+	return true
+}
+
+// File in the project's .newt directory recording that the user declined to
+// install the pinned newt version, so that they aren't asked again in the
+// same shell session.  Holds the declined version and the process ID of the
+// shell.
+const NEWT_PIN_DECLINED_FILENAME = "newt-pin-declined"
+
+// Commands that always run in the invoking newt.  "newt self" commands
+// install or replace the pinned newt; the others only describe this newt.
+var unpinnedCmds = map[string]bool{
+	"self":    true,
+	"version": true,
+	"help":    true,
+}
+
+// Returns the name of the command the specified arguments invoke, i.e., the
+// first argument that is neither a flag of the root command nor a flag's
+// value.  "help" is returned if help is requested with a flag.
+func invokedCmdName(root *cobra.Command, args []string) string {
+	flags := root.PersistentFlags()
+
+	// Indicates whether the specified flag takes a value from the next
+	// argument.
+	takesArg := func(f *pflag.Flag) bool {
+		return f != nil && f.NoOptDefVal == "" && f.Value.Type() != "bool"
+	}
+	shorthand := func(c string) *pflag.Flag {
+		var found *pflag.Flag
+		flags.VisitAll(func(f *pflag.Flag) {
+			if f.Shorthand == c {
+				found = f
+			}
+		})
+		return found
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+			return ""
+
+		case arg == "--help" || arg == "-h":
+			return "help"
+
+		case strings.HasPrefix(arg, "--"):
+			if !strings.Contains(arg, "=") &&
+				takesArg(flags.Lookup(arg[2:])) {
+
+				i++
+			}
+
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			// Only the last of a group of shorthands (-vj 4) can take the
+			// next argument.
+			if len(arg) == 2 && takesArg(shorthand(arg[1:])) {
+				i++
+			} else if len(arg) > 2 && !strings.Contains(arg, "=") {
+				for j := 1; j < len(arg); j++ {
+					f := shorthand(arg[j : j+1])
+					if takesArg(f) {
+						// The rest of the group is the flag's value.
+						if j == len(arg)-1 {
+							i++
+						}
+						break
+					}
+				}
+			}
+
+		default:
+			return arg
+		}
+	}
+
+	return ""
+}
+
+// Indicates whether the specified arguments enable offline mode.  The
+// arguments are scanned before the command line is parsed, so this only looks
+// for the root command's --offline flag ahead of any "--".
+func offlineRequested(args []string) bool {
+	for _, arg := range args {
+		switch {
+		case arg == "--":
+			return false
+
+		case arg == "--offline":
+			return true
+
+		case strings.HasPrefix(arg, "--offline="):
+			b, _ := strconv.ParseBool(strings.TrimPrefix(arg, "--offline="))
+			return b
+		}
+	}
+
+	return false
+}
+
+// Indicates whether the user declined to install the specified newt version
+// earlier in this shell session.
+func newtPinDeclined(projDir string, version string) bool {
+	path := filepath.Join(projDir, newtutil.NEWTRC_DIR,
+		NEWT_PIN_DECLINED_FILENAME)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	return strings.TrimSpace(string(data)) ==
+		fmt.Sprintf("%s %d", version, os.Getppid())
+}
+
+func recordNewtPinDeclined(projDir string, version string) {
+	// Without a parent process ID, there is no way to tell sessions apart.
+	if os.Getppid() <= 1 {
+		return
+	}
+
+	path := filepath.Join(projDir, newtutil.NEWTRC_DIR,
+		NEWT_PIN_DECLINED_FILENAME)
+	data := fmt.Sprintf("%s %d\n", version, os.Getppid())
+	if err := util.WriteFile(path, []byte(data), 0644); err != nil {
+		log.Debugf("Failed to record declined newt version: %s",
+			err.Error())
+	}
+}
+
+// Runs the newt version the project pins in place of this one, if they
+// differ.  The pinned newt is run with the same arguments, and this process
+// exits with its status.  If the pinned version isn't installed, the user is
+// offered to install it, once per shell session, unless newt is in offline
+// mode; otherwise, this newt carries on after a warning.  The "self", "version" and "help" commands
+// always run in the invoking newt, so that "newt self" can install or
+// replace the pinned one.
+func RunPinnedNewt(projDir string, root *cobra.Command) {
+	if projDir == "" || os.Getenv(project.NEWT_PINNED_ENV) != "" {
+		return
+	}
+	if unpinnedCmds[invokedCmdName(root, os.Args[1:])] {
+		return
+	}
+
+	pin, err := project.ReadNewtPin(projDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "* Warning: %s\n", err.Error())
+		return
+	}
+	if pin == nil || pin.Matches() {
+		return
+	}
+
+	path := project.NewtPinPath(projDir, pin.Version)
+	if util.NodeNotExist(path) {
+		msg := fmt.Sprintf("this project requires newt %s; this is newt %s",
+			pin.Version, newtutil.NewtVersion.String())
+
+		if _, ok := pin.HostArchive(); !ok {
+			fmt.Fprintf(os.Stderr, "* Warning: %s\n", msg)
+			return
+		}
+		if pin.Offline || offlineRequested(os.Args[1:]) {
+			fmt.Fprintf(os.Stderr, "* Warning: %s; not installing it in "+
+				"offline mode\n", msg)
+			return
+		}
+		if !stdinIsTerminal() || newtPinDeclined(projDir, pin.Version) {
+			fmt.Fprintf(os.Stderr, "* Warning: %s; run \"newt self "+
+				"update\" to install it\n", msg)
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "%s.\nInstall newt %s to %s? (y/N): ", msg,
+			pin.Version, filepath.Dir(path))
+		if !PromptYesNo(false) {
+			recordNewtPinDeclined(projDir, pin.Version)
+			return
+		}
+		if _, err := project.InstallNewtPin(projDir, pin); err != nil {
+			NewtUsage(nil, err)
+		}
+	}
+
+	log.Debugf("Running pinned newt %s", path)
+
+	c := exec.Command(path, os.Args[1:]...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	c.Env = append(os.Environ(), project.NEWT_PINNED_ENV+"="+pin.Version)
+
+	if err := c.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				os.Exit(ws.ExitStatus())
+			}
+		}
+		NewtUsage(nil, util.FmtNewtError("Failed to run pinned newt %s: %s",
+			path, err.Error()))
+	}
+	os.Exit(0)
+}
+
+func selfUpdateRunCmd(cmd *cobra.Command, args []string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		NewtUsage(nil, util.ChildNewtError(err))
+	}
+	projDir, err := project.FindProjectDir(filepath.ToSlash(wd))
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	pin, err := project.ReadNewtPin(projDir)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+	if pin == nil {
+		NewtUsage(nil, util.NewNewtError(
+			"project.yml does not pin a newt version (project.newt_version)"))
+	}
+
+	if pin.Matches() {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"This newt is the version the project requires (%s)\n",
+			pin.Version)
+		return
+	}
+
+	// The project isn't loaded, so its offline setting has to be applied
+	// here.
+	if pin.Offline {
+		newtutil.NewtOffline = true
+	}
+
+	path := project.NewtPinPath(projDir, pin.Version)
+	if util.NodeExist(path) && !selfUpdateForce {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"newt %s is already installed in %s\n", pin.Version, path)
+		return
+	}
+
+	path, err = project.InstallNewtPin(projDir, pin)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Installed newt %s in %s\n", pin.Version, path)
+}
+
+func AddSelfCommands(cmd *cobra.Command) {
+	selfCmd := &cobra.Command{
+		Use:   "self",
+		Short: "Manage the newt version the project uses",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Usage()
+		},
+	}
+
+	cmd.AddCommand(selfCmd)
+
+	updateHelpText := "Download the newt version the project requires, " +
+		"verify its SHA-256 checksum and install it into the project's " +
+		".newt/bin directory.  From then on, any newt run in the project " +
+		"hands the command over to the installed version, so everyone on " +
+		"the team builds with the same newt.\n\n" +
+		"The version is pinned in project.yml:\n\n" +
+		"    project.newt_version:\n" +
+		"        version: \"1.12.0\"\n" +
+		"        archives:\n" +
+		"            linux-amd64:\n" +
+		"                url: \"https://example.com/newt_1.12.0_linux_amd64.tgz\"\n" +
+		"                sha256: \"<hex digest>\"\n\n" +
+		"A bare version (project.newt_version: \"1.12.0\") only makes newt " +
+		"warn when it is a different version.  Set " +
+		project.NEWT_PINNED_ENV + "=1 in the environment to run a newt " +
+		"other than the pinned one."
+	updateHelpEx := "  newt self update\n"
+
+	updateCmd := &cobra.Command{
+		Use:     "update",
+		Short:   "Install the newt version the project requires",
+		Long:    updateHelpText,
+		Example: updateHelpEx,
+		Run:     selfUpdateRunCmd,
+	}
+
+	updateCmd.Flags().BoolVarP(&selfUpdateForce, "force", "f", false,
+		"Reinstall the pinned version even if it is already installed")
+
+	selfCmd.AddCommand(updateCmd)
+}
@@ -0,0 +1,199 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+var settingsGlobal bool
+
+// Returns the scope that "set" and "unset" operate on: the project, unless
+// --global is specified or newt is run outside a project.
+func settingsScope() string {
+	settings := newtutil.NewtSettings
+	if !settingsGlobal &&
+		settings.Path(newtutil.SETTINGS_SCOPE_PROJECT) != "" {
+
+		return newtutil.SETTINGS_SCOPE_PROJECT
+	}
+
+	return newtutil.SETTINGS_SCOPE_GLOBAL
+}
+
+func settingsListCmd(cmd *cobra.Command, args []string) {
+	settings := newtutil.NewtSettings
+
+	type settingInfo struct {
+		Name        string `json:"name"`
+		Value       string `json:"value"`
+		Scope       string `json:"scope,omitempty"`
+		Description string `json:"description"`
+	}
+
+	infos := []settingInfo{}
+	for _, def := range newtutil.SettingDefs {
+		val, scope := settings.Get(def.Name)
+		infos = append(infos, settingInfo{
+			Name:        def.Name,
+			Value:       val,
+			Scope:       scope,
+			Description: def.Description,
+		})
+	}
+
+	if newtutil.NewtJson {
+		printJson(infos)
+		return
+	}
+
+	for _, info := range infos {
+		if info.Scope == "" {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "%s (unset)\n",
+				info.Name)
+		} else {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "%s=%s (%s)\n",
+				info.Name, info.Value, info.Scope)
+		}
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "    %s\n",
+			info.Description)
+	}
+
+	for _, scope := range []string{newtutil.SETTINGS_SCOPE_GLOBAL,
+		newtutil.SETTINGS_SCOPE_PROJECT} {
+
+		if path := settings.Path(scope); path != "" {
+			util.StatusMessage(util.VERBOSITY_VERBOSE,
+				"%s settings file: %s\n", scope, path)
+		}
+	}
+}
+
+func settingsGetCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify a setting name"))
+	}
+
+	if newtutil.FindSettingDef(args[0]) == nil {
+		NewtUsage(cmd, util.FmtNewtError("Unknown setting: %s", args[0]))
+	}
+
+	fmt.Printf("%s\n", newtutil.NewtSettings.String(args[0]))
+}
+
+func settingsSetCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		NewtUsage(cmd, util.NewNewtError("Must specify a setting name "+
+			"and value"))
+	}
+
+	scope := settingsScope()
+	if err := newtutil.NewtSettings.Set(scope, args[0], args[1]); err != nil {
+		NewtUsage(nil, err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "Wrote %s\n",
+		newtutil.NewtSettings.Path(scope))
+}
+
+func settingsUnsetCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify a setting name"))
+	}
+
+	scope := settingsScope()
+	if err := newtutil.NewtSettings.Unset(scope, args[0]); err != nil {
+		NewtUsage(nil, err)
+	}
+}
+
+func settingNames() []string {
+	names := make([]string, len(newtutil.SettingDefs))
+	for i, def := range newtutil.SettingDefs {
+		names[i] = def.Name
+	}
+
+	return names
+}
+
+func AddSettingsCommands(cmd *cobra.Command) {
+	settingsHelpText := "View and edit newt user settings.  Settings are " +
+		"read from ~/.newt/config.yml and from .newt/config.yml in the " +
+		"project directory; project settings override global ones, and " +
+		"command line flags override both.\n\nSettings:\n"
+	for _, def := range newtutil.SettingDefs {
+		settingsHelpText += fmt.Sprintf("    %-16s%s\n", def.Name,
+			def.Description)
+	}
+	settingsHelpEx := "  newt settings\n"
+	settingsHelpEx += "  newt settings set jobs 4\n"
+	settingsHelpEx += "  newt settings set --global toolchain_path " +
+		"/opt/gcc-arm-none-eabi/bin"
+
+	settingsCmd := &cobra.Command{
+		Use:     "settings",
+		Short:   "View and edit newt user settings",
+		Long:    settingsHelpText,
+		Example: settingsHelpEx,
+		Run:     settingsListCmd,
+	}
+
+	cmd.AddCommand(settingsCmd)
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List all settings and their values",
+		Run:   settingsListCmd,
+	}
+	settingsCmd.AddCommand(listCmd)
+
+	getCmd := &cobra.Command{
+		Use:       "get <name>",
+		Short:     "Print the value of a setting",
+		Run:       settingsGetCmd,
+		ValidArgs: settingNames(),
+	}
+	settingsCmd.AddCommand(getCmd)
+
+	setCmd := &cobra.Command{
+		Use:       "set <name> <value>",
+		Short:     "Change a setting",
+		Run:       settingsSetCmd,
+		ValidArgs: settingNames(),
+	}
+	setCmd.Flags().BoolVarP(&settingsGlobal, "global", "g", false,
+		"Change the global setting rather than the project's")
+	settingsCmd.AddCommand(setCmd)
+
+	unsetCmd := &cobra.Command{
+		Use:       "unset <name>",
+		Short:     "Remove a setting",
+		Run:       settingsUnsetCmd,
+		ValidArgs: settingNames(),
+	}
+	unsetCmd.Flags().BoolVarP(&settingsGlobal, "global", "g", false,
+		"Remove the global setting rather than the project's")
+	settingsCmd.AddCommand(unsetCmd)
+}
@@ -0,0 +1,684 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+var sizeOpts builder.SizeOptions
+var sizeCsv bool
+
+func printSizeSummary(s builder.ImageSizeSummary) {
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Size of %s image:\n", s.Name)
+
+	width := len("Package")
+	for _, p := range s.Packages {
+		if len(p.Name) > width {
+			width = len(p.Name)
+		}
+	}
+
+	line := fmt.Sprintf("  %-*s", width, "Package")
+	for _, region := range s.Sections {
+		line += fmt.Sprintf(" %9s", region)
+	}
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "%s\n", line)
+
+	for _, p := range s.Packages {
+		line := fmt.Sprintf("  %-*s", width, p.Name)
+		for _, region := range s.Sections {
+			line += fmt.Sprintf(" %9d", p.Sizes[region])
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s\n", line)
+	}
+
+	line = fmt.Sprintf("  %-*s", width, "Total")
+	for _, region := range s.Sections {
+		line += fmt.Sprintf(" %9d", s.Totals[region])
+	}
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "%s\n\n", line)
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "  %-20s %-9s %9s\n",
+		"Section", "Region", "Size")
+	for _, sec := range s.OutputSections {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "  %-20s %-9s %9d\n",
+			sec.Name, sec.Region, sec.Size)
+	}
+
+	if len(s.Symbols) > 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"\n  Largest symbols:\n")
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"  %9s %-12s %-32s %s\n", "Size", "Section", "Symbol", "Package")
+		for _, sym := range s.Symbols {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"  %9d %-12s %-32s %s (%s)\n", sym.Size, sym.Section,
+				sym.Name, sym.Package, sym.Object)
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "\n")
+}
+
+// Writes the size summaries of a set of targets to stdout as CSV, one row per
+// package and memory region, output section, and reported symbol.
+func writeSizeCsv(summaries map[string][]builder.ImageSizeSummary,
+	targetNames []string) error {
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"target", "image", "kind", "name", "package",
+		"section", "region", "size"})
+
+	for _, tname := range targetNames {
+		for _, s := range summaries[tname] {
+			for _, p := range s.Packages {
+				for _, region := range s.Sections {
+					w.Write([]string{tname, s.Name, "package", p.Name, p.Name,
+						"", region, fmt.Sprintf("%d", p.Sizes[region])})
+				}
+			}
+			for _, sec := range s.OutputSections {
+				w.Write([]string{tname, s.Name, "section", sec.Name, "",
+					sec.Name, sec.Region, fmt.Sprintf("%d", sec.Size)})
+			}
+			for _, sym := range s.Symbols {
+				w.Write([]string{tname, s.Name, "symbol", sym.Name,
+					sym.Package, sym.Section, sym.Region,
+					fmt.Sprintf("%d", sym.Size)})
+			}
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+var sizeDiffAgainst string
+var sizeDiffSymbols int
+var sizeDiffMarkdown bool
+
+func formatSizeDelta(d int64) string {
+	if d < 0 {
+		return "-" + formatBytes(-d)
+	}
+	return "+" + formatBytes(d)
+}
+
+func printSizeDiff(d builder.ImageSizeDiff) {
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Size change of %s image:\n",
+		d.Name)
+
+	if len(d.Packages) == 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "  No change\n\n")
+		return
+	}
+
+	width := len("Package")
+	for _, p := range d.Packages {
+		if len(p.Name) > width {
+			width = len(p.Name)
+		}
+	}
+
+	line := fmt.Sprintf("  %-*s", width, "Package")
+	for _, region := range d.Regions {
+		line += fmt.Sprintf(" %9s", region)
+	}
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "%s\n", line)
+
+	for _, p := range d.Packages {
+		line := fmt.Sprintf("  %-*s", width, p.Name)
+		for _, region := range d.Regions {
+			line += fmt.Sprintf(" %+9d", p.Delta[region])
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s\n", line)
+	}
+
+	line = fmt.Sprintf("  %-*s", width, "Total")
+	for _, region := range d.Regions {
+		line += fmt.Sprintf(" %+9d", d.Totals[region])
+	}
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "%s\n", line)
+
+	if len(d.Symbols) > 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "\n  Symbols:\n")
+		for _, sym := range d.Symbols {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"  %+9d %-9s %s (%s)\n", sym.Delta, sym.Region, sym.Name,
+				sym.Package)
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "\n")
+}
+
+// Prints a size diff as markdown, suitable for a pull request comment.
+func printSizeDiffMarkdown(d builder.ImageSizeDiff) {
+	fmt.Printf("### Size change of %s image\n\n", d.Name)
+
+	if len(d.Packages) == 0 {
+		fmt.Printf("No change.\n\n")
+		return
+	}
+
+	for _, region := range d.Regions {
+		if d.Totals[region] != 0 {
+			fmt.Printf("- %s: %s\n", region,
+				formatSizeDelta(d.Totals[region]))
+		}
+	}
+	fmt.Printf("\n")
+
+	fmt.Printf("| Package |")
+	for _, region := range d.Regions {
+		fmt.Printf(" %s |", region)
+	}
+	fmt.Printf("\n|---|")
+	for range d.Regions {
+		fmt.Printf("---:|")
+	}
+	fmt.Printf("\n")
+	for _, p := range d.Packages {
+		fmt.Printf("| %s |", p.Name)
+		for _, region := range d.Regions {
+			fmt.Printf(" %+d |", p.Delta[region])
+		}
+		fmt.Printf("\n")
+	}
+	fmt.Printf("\n")
+
+	if len(d.Symbols) > 0 {
+		fmt.Printf("| Symbol | Package | Region | Change |\n")
+		fmt.Printf("|---|---|---|---:|\n")
+		for _, sym := range d.Symbols {
+			fmt.Printf("| `%s` | %s | %s | %+d |\n", sym.Name, sym.Package,
+				sym.Region, sym.Delta)
+		}
+		fmt.Printf("\n")
+	}
+}
+
+func sizeDiffRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+	if sizeDiffAgainst == "" {
+		NewtUsage(cmd, util.NewNewtError("Must specify the build to "+
+			"compare against with --against"))
+	}
+
+	TryGetProject()
+
+	t := ResolveTarget(args[0])
+	if t == nil {
+		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
+	}
+
+	b, err := builder.NewTargetBuilder(t)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	diffs, err := b.SizeDiff(sizeDiffAgainst, sizeDiffSymbols)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	switch {
+	case newtutil.NewtJson:
+		printJson(diffs)
+	case sizeDiffMarkdown:
+		for _, d := range diffs {
+			printSizeDiffMarkdown(d)
+		}
+	default:
+		for _, d := range diffs {
+			printSizeDiff(d)
+		}
+	}
+}
+
+func addSizeDiffCommand(sizeCmd *cobra.Command) {
+	diffHelpText := "Compare the sizes of a target's current build against " +
+		"an older build, showing which packages and symbols grew or " +
+		"shrank.\n\n" +
+		"The older build is given with --against as either its app elf " +
+		"file, with the linker map (<elf>.map) next to it, or its image " +
+		"manifest (manifest.json); a manifest also covers the loader " +
+		"image.  Use --markdown to produce a summary for a pull request."
+	diffHelpEx := "  newt size diff my_target --against old/blinky.elf\n"
+	diffHelpEx += "  newt size diff my_target --against old/manifest.json " +
+		"--markdown\n"
+
+	diffCmd := &cobra.Command{
+		Use:     "diff <target-name>",
+		Short:   "Show size changes relative to an older build",
+		Long:    diffHelpText,
+		Example: diffHelpEx,
+		Run:     sizeDiffRunCmd,
+	}
+
+	diffCmd.Flags().StringVarP(&sizeDiffAgainst, "against", "", "",
+		"Elf file or image manifest of the build to compare against")
+	diffCmd.Flags().IntVarP(&sizeDiffSymbols, "symbols", "", 10,
+		"Number of changed symbols to list; -1 lists all")
+	diffCmd.Flags().BoolVarP(&sizeDiffMarkdown, "markdown", "", false,
+		"Print the diff as markdown")
+
+	sizeCmd.AddCommand(diffCmd)
+	AddTabCompleteFn(diffCmd, targetList)
+}
+
+var sizeWhy string
+var sizeDiscarded bool
+
+func symbolLocationText(loc builder.SymbolLocation) string {
+	if loc.Package == "" {
+		return loc.Object
+	}
+	return fmt.Sprintf("%s (%s)", loc.Package, loc.Object)
+}
+
+func printSymbolRetention(sr *builder.SymbolRetention) {
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "%s in %s image:\n",
+		sr.Symbol, sr.Image)
+
+	for _, sec := range sr.Kept {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"  kept:      %s, section %s, %d bytes\n",
+			symbolLocationText(sec.SymbolLocation), sec.Section, sec.Size)
+	}
+	for _, sec := range sr.Discarded {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"  discarded: %s, section %s, %d bytes\n",
+			symbolLocationText(sec.SymbolLocation), sec.Section, sec.Size)
+	}
+
+	if sr.PulledInBy != nil {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"  pulled in by a reference to %s from %s\n", sr.PullSymbol,
+			symbolLocationText(*sr.PulledInBy))
+	}
+
+	if len(sr.ReferencedBy) == 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "  not referenced\n")
+	} else {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "  referenced by:\n")
+		for _, loc := range sr.ReferencedBy {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "    %s\n",
+				symbolLocationText(loc))
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "\n")
+}
+
+func printGcSummary(image string, pkgs []*builder.PkgGcSummary) {
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Input sections of %s image:\n", image)
+
+	width := len("Package")
+	for _, p := range pkgs {
+		if len(p.Name) > width {
+			width = len(p.Name)
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "  %-*s %21s %21s\n", width,
+		"Package", "Kept (count/bytes)", "Discarded (count/bytes)")
+	for _, p := range pkgs {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "  %-*s %8d %12d %8d %12d\n",
+			width, p.Name, p.KeptCount, p.KeptSize, p.DiscardedCount,
+			p.DiscardedSize)
+		for _, sec := range p.Discarded {
+			_, obj := builder.SplitMapFile(sec.File)
+			util.StatusMessage(util.VERBOSITY_VERBOSE,
+				"      %-40s %8d %s\n", sec.Name, sec.Size,
+				util.FilenameFromPath(obj))
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "\n")
+}
+
+// Handles the size command's map file cross reference modes: --why and
+// --discarded.
+func sizeXrefRunCmd(cmd *cobra.Command, targets []*target.Target) {
+	if sizeWhy != "" && sizeDiscarded {
+		NewtUsage(cmd, util.NewNewtError(
+			"--why and --discarded are mutually exclusive"))
+	}
+
+	jsonResults := map[string]interface{}{}
+
+	_, err := runBulkCmd(cmd, targets, func(t *target.Target) error {
+		b, err := builder.NewTargetBuilder(t)
+		if err != nil {
+			return err
+		}
+
+		if len(targets) > 1 && !newtutil.NewtJson {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "Target %s\n",
+				t.FullName())
+		}
+
+		if sizeWhy != "" {
+			srs, err := b.WhySymbol(sizeWhy)
+			if err != nil {
+				return err
+			}
+			if newtutil.NewtJson {
+				jsonResults[t.FullName()] = srs
+				return nil
+			}
+
+			if len(srs) == 0 {
+				util.StatusMessage(util.VERBOSITY_DEFAULT,
+					"%s does not appear in the linker map\n", sizeWhy)
+			}
+			for _, sr := range srs {
+				printSymbolRetention(sr)
+			}
+			return nil
+		}
+
+		summaries, err := b.GcSummaries()
+		if err != nil {
+			return err
+		}
+		if newtutil.NewtJson {
+			jsonResults[t.FullName()] = summaries
+			return nil
+		}
+
+		for _, name := range []string{builder.BUILD_NAME_APP,
+			builder.BUILD_NAME_LOADER} {
+
+			if pkgs, ok := summaries[name]; ok {
+				printGcSummary(name, pkgs)
+			}
+		}
+		return nil
+	})
+
+	if newtutil.NewtJson {
+		if len(targets) == 1 {
+			printJson(jsonResults[targets[0].FullName()])
+		} else {
+			printJson(jsonResults)
+		}
+	}
+
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+}
+
+var sizeRamBudget bool
+
+func printRamBudget(rb *builder.RamBudget) {
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"RAM budget of %s image (%s: %d bytes at 0x%x):\n", rb.Image,
+		rb.Region, rb.Length, rb.Origin)
+
+	pct := func(n uint64) string {
+		if rb.Length == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%5.1f%%", float64(n)*100/float64(rb.Length))
+	}
+	row := func(indent int, name string, n uint64, note string) {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "  %*s%-*s %9d %s  %s\n",
+			indent, "", 28-indent, name, n, pct(n), note)
+	}
+
+	row(0, "static data", rb.Static, "")
+	for _, sec := range rb.Sections {
+		row(4, sec.Name, uint64(sec.Size), "")
+	}
+	for _, r := range rb.TaskStacks {
+		row(4, "stack "+r.Name, r.Size, r.Source)
+	}
+	for _, r := range rb.Msys {
+		row(4, r.Name, r.Size, r.Source)
+	}
+	for _, p := range rb.Pools {
+		note := strings.Join(p.Settings, ", ")
+		if p.Count > 0 {
+			note += fmt.Sprintf(" (%d x %d bytes)", p.Count, p.ElemSize)
+		}
+		row(4, p.Name, p.Size, note)
+	}
+	row(4, "other", rb.OtherStatic, "")
+	row(0, "interrupt stack", rb.InterruptStack, "")
+	row(0, "heap", rb.Heap, "")
+
+	if rb.Unused >= 0 {
+		row(0, "unused", uint64(rb.Unused), "")
+	} else {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"  * Warning: the figures above exceed RAM by %d bytes; some "+
+				"reservations overlap\n", -rb.Unused)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "\n")
+}
+
+func sizeRamBudgetRunCmd(cmd *cobra.Command, targets []*target.Target) {
+	jsonResults := map[string]interface{}{}
+
+	_, err := runBulkCmd(cmd, targets, func(t *target.Target) error {
+		b, err := builder.NewTargetBuilder(t)
+		if err != nil {
+			return err
+		}
+
+		budgets, err := b.RamBudgets(builder.RAM_STACK_WORD_SIZE)
+		if err != nil {
+			return err
+		}
+
+		if newtutil.NewtJson {
+			jsonResults[t.FullName()] = budgets
+			return nil
+		}
+
+		if len(targets) > 1 {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "Target %s\n",
+				t.FullName())
+		}
+		for _, rb := range budgets {
+			printRamBudget(rb)
+		}
+		return nil
+	})
+
+	if newtutil.NewtJson {
+		if len(targets) == 1 {
+			printJson(jsonResults[targets[0].FullName()])
+		} else {
+			printJson(jsonResults)
+		}
+	}
+
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+}
+
+var sizeStore string
+var sizeRecordBranch string
+var sizeBudgetFile string
+var sizeBaseline string
+
+func sizeRecordRunCmd(cmd *cobra.Command, args []string) {
+	b := targetBuilderArg(cmd, args)
+
+	rec, err := b.SizeRecord(sizeRecordBranch)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	store := builder.OpenSizeStore(sizeStore)
+	if err := store.Append(rec); err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(rec)
+		return
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Recorded sizes of %s (branch %s, commit %s) in %s\n",
+		rec.Target, rec.Branch, rec.Commit, store.String())
+}
+
+func sizeCheckRunCmd(cmd *cobra.Command, args []string) {
+	if sizeBudgetFile == "" {
+		NewtUsage(cmd, util.NewNewtError("Must specify a budget file with "+
+			"--budget-file"))
+	}
+
+	b := targetBuilderArg(cmd, args)
+
+	budgets, err := builder.ReadSizeBudgets(sizeBudgetFile)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	store := builder.OpenSizeStore(sizeStore)
+	report, err := b.SizeCheck(store, budgets, sizeBaseline)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	exceeded := report.Exceeded()
+
+	if newtutil.NewtJson {
+		printJson(report)
+	} else {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Comparing %s against %s (commit %s)\n", report.Target,
+			report.Baseline, report.BaselineCommit)
+		for _, g := range report.Packages {
+			if !g.Exceeded {
+				util.StatusMessage(util.VERBOSITY_VERBOSE, "    %s\n",
+					g.String())
+			}
+		}
+		for _, g := range exceeded {
+			util.StatusMessage(util.VERBOSITY_QUIET,
+				"    %s; budget is %s\n", g.String(), g.Budget)
+		}
+	}
+
+	if len(exceeded) > 0 {
+		NewtUsage(nil, util.FmtNewtError(
+			"%d package(s) exceeded their growth budget", len(exceeded)))
+	}
+
+	if !newtutil.NewtJson {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"All packages are within their growth budgets\n")
+	}
+}
+
+func addSizeTrendCommands(sizeCmd *cobra.Command) {
+	recordHelpText := "Append the per-package sizes of a target's most " +
+		"recent build to a size store, tagged with the project's branch " +
+		"and commit.  Run it on the baseline branch (e.g., in CI after " +
+		"each merge) so that \"newt size check\" has something to " +
+		"compare against.\n\n" +
+		"The store is given with --store, or the size_store setting, " +
+		"and defaults to .newt/" + builder.SIZE_STORE_FILENAME + " in " +
+		"the project.  It is either a file, to which one JSON record is " +
+		"appended per line, or an http(s) URL; records are POSTed to the " +
+		"URL, and \"GET <url>?target=<target>&branch=<branch>\" must " +
+		"return the latest matching record, or 404."
+	recordHelpEx := "  newt size record my_target\n"
+	recordHelpEx += "  newt size record my_target --branch main " +
+		"--store https://sizes.example.com/records\n"
+
+	recordCmd := &cobra.Command{
+		Use:     "record <target-name>",
+		Short:   "Record a target's package sizes in a size store",
+		Long:    recordHelpText,
+		Example: recordHelpEx,
+		Run:     sizeRecordRunCmd,
+	}
+	recordCmd.Flags().StringVarP(&sizeStore, "store", "", "",
+		"Size store file or URL")
+	recordCmd.Flags().StringVarP(&sizeRecordBranch, "branch", "", "",
+		"Branch to record the sizes under (default: the project's "+
+			"current branch)")
+
+	sizeCmd.AddCommand(recordCmd)
+	AddTabCompleteFn(recordCmd, targetList)
+
+	checkHelpText := "Compare the per-package sizes of a target's most " +
+		"recent build against the latest sizes recorded for the baseline " +
+		"branch, and fail if any package grew by more than its budget.\n\n" +
+		"The budget file lists the allowed growth of each package, in " +
+		"bytes (e.g., 512 or 1kB) or as a percentage of its baseline " +
+		"size.  Package names may be glob patterns; packages without a " +
+		"budget of their own use the default, if any.  Packages that " +
+		"are new since the baseline are only held to byte budgets.  " +
+		"The baseline branch is given with --baseline, or the file's " +
+		"baseline, and defaults to " + builder.SIZE_BASELINE_DFLT + ":\n\n" +
+		"    baseline: main\n" +
+		"    default: 2%\n" +
+		"    packages:\n" +
+		"        \"@apache-mynewt-core/kernel/os\": 512\n" +
+		"        \"@apache-mynewt-nimble/*\": 5%\n\n" +
+		"Use -v to also list the packages that grew within their budget."
+	checkHelpEx := "  newt size check my_target --budget-file budgets.yml\n"
+
+	checkCmd := &cobra.Command{
+		Use:     "check <target-name>",
+		Short:   "Check a target's package sizes against growth budgets",
+		Long:    checkHelpText,
+		Example: checkHelpEx,
+		Run:     sizeCheckRunCmd,
+	}
+	checkCmd.Flags().StringVarP(&sizeStore, "store", "", "",
+		"Size store file or URL")
+	checkCmd.Flags().StringVarP(&sizeBudgetFile, "budget-file", "", "",
+		"YAML file containing the package growth budgets")
+	checkCmd.Flags().StringVarP(&sizeBaseline, "baseline", "", "",
+		"Branch to compare against")
+
+	sizeCmd.AddCommand(checkCmd)
+	AddTabCompleteFn(checkCmd, targetList)
+}
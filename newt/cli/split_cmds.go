@@ -0,0 +1,379 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+type splitStatusJson struct {
+	Target     string   `json:"target"`
+	Loader     string   `json:"loader"`
+	App        string   `json:"app"`
+	Generated  string   `json:"generated"`
+	CommonPkgs []string `json:"common_packages"`
+	NumSymbols int      `json:"num_symbols"`
+	LoaderAbi  string   `json:"loader_abi"`
+	AppAbi     string   `json:"app_abi"`
+	InSync     bool     `json:"in_sync"`
+
+	// Differences between the recorded pairing and the target's current
+	// configuration.
+	Stale []string `json:"stale,omitempty"`
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	if hash == "" {
+		return "(none)"
+	}
+	return hash
+}
+
+func splitStatusRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	TryGetProject()
+
+	t := ResolveTarget(args[0])
+	if t == nil {
+		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
+	}
+
+	ss, err := builder.ReadSplitState(t.Name())
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+	if ss == nil {
+		NewtUsage(nil, util.FmtNewtError(
+			"No split build recorded for target %s", t.FullName()))
+	}
+
+	sj := splitStatusJson{
+		Target:     t.FullName(),
+		Loader:     ss.Loader,
+		App:        ss.App,
+		Generated:  ss.Generated,
+		CommonPkgs: ss.CommonPkgs,
+		NumSymbols: len(ss.Symbols),
+		LoaderAbi:  ss.LoaderAbi,
+		AppAbi:     ss.AppAbi,
+		InSync:     ss.InSync(),
+	}
+
+	if t.Loader() == nil {
+		sj.Stale = append(sj.Stale, "target no longer specifies a loader")
+	} else if t.Loader().FullName() != ss.Loader {
+		sj.Stale = append(sj.Stale,
+			"target now specifies loader "+t.Loader().FullName())
+	}
+	if t.App() != nil && t.App().FullName() != ss.App {
+		sj.Stale = append(sj.Stale,
+			"target now specifies app "+t.App().FullName())
+	}
+
+	if newtutil.NewtJson {
+		printJson(sj)
+		return
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Target:    %s\n", sj.Target)
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Loader:    %s\n", sj.Loader)
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "App:       %s\n", sj.App)
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Generated: %s\n",
+		sj.Generated)
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Shared:    %d packages, %d symbols\n",
+		len(sj.CommonPkgs), sj.NumSymbols)
+	for _, name := range sj.CommonPkgs {
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "    %s\n", name)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Loader ABI: %s\n", shortHash(sj.LoaderAbi))
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"App linked against: %s\n", shortHash(sj.AppAbi))
+	if sj.InSync {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "Status: %s\n",
+			colorText(ANSI_GREEN, "app and loader in sync"))
+	} else {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "Status: %s\n",
+			colorText(ANSI_RED, "app must be relinked against the loader"))
+	}
+
+	for _, s := range sj.Stale {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"* Warning: %s; rebuild the target\n", s)
+	}
+}
+
+var splitFreezeForce bool
+
+// Builds a split image target for the split-freeze and split-check
+// commands.  Incompatibilities with the frozen ROM are left for the caller
+// to report.
+func buildSplitTarget(cmd *cobra.Command,
+	name string) *builder.TargetBuilder {
+
+	t := ResolveTarget(name)
+	if t == nil {
+		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+name))
+	}
+
+	b, err := builder.NewTargetBuilder(t)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+	if b.LoaderBuilder == nil {
+		NewtUsage(nil, util.FmtNewtError(
+			"Target %s is not a split image target (no target.loader)",
+			t.FullName()))
+	}
+
+	b.RomCheckWarnOnly = true
+	if err := b.Build(); err != nil {
+		NewtUsage(nil, err)
+	}
+
+	return b
+}
+
+func splitFreezeRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target and version"))
+	}
+
+	TryGetProject()
+
+	b := buildSplitTarget(cmd, args[0])
+	version := args[1]
+
+	path, err := b.FreezeRomSymbols(version, splitFreezeForce)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	t := b.GetTarget()
+	t.Vars[target.TARGET_ROM_VERSION_VAR] = version
+	if err := t.Save(); err != nil {
+		NewtUsage(nil, err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Froze ROM symbols of target %s as version %s: %s\n",
+		t.FullName(), version, path)
+}
+
+func splitCheckRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	TryGetProject()
+
+	b := buildSplitTarget(cmd, args[0])
+
+	version := b.GetTarget().RomVersion()
+	if len(args) > 1 {
+		version = args[1]
+	}
+	if version == "" {
+		NewtUsage(nil, util.FmtNewtError(
+			"Target %s does not specify a ROM version (%s); specify one",
+			b.GetTarget().FullName(), target.TARGET_ROM_VERSION_VAR))
+	}
+
+	r, err := b.CheckRomSymbols(version)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(r)
+	} else {
+		util.StatusMessage(util.VERBOSITY_QUIET, "%s", r.Text())
+		for _, s := range r.Added {
+			util.StatusMessage(util.VERBOSITY_VERBOSE, "    added:   %s (%s)\n",
+				s.Name, s)
+		}
+	}
+
+	if !r.Compatible() {
+		NewtUsage(nil, util.FmtNewtError(
+			"ROM of target %s is not compatible with version %s",
+			b.GetTarget().FullName(), version))
+	}
+}
+
+var splitKeepMinimize bool
+
+func splitKeepRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	TryGetProject()
+
+	b := buildSplitTarget(cmd, args[0])
+	r, err := b.SplitKeepReport()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson && !splitKeepMinimize {
+		printJson(r)
+		return
+	}
+
+	verbose := util.Verbosity >= util.VERBOSITY_VERBOSE
+	if !newtutil.NewtJson {
+		util.StatusMessage(util.VERBOSITY_QUIET, "%s", r.Text(verbose))
+	}
+
+	if !splitKeepMinimize {
+		return
+	}
+
+	// Relink the loader with only the referenced symbols, and keep it that
+	// way.
+	t := b.GetTarget()
+	t.Vars[target.TARGET_LOADER_KEEP_VAR] = target.TARGET_LOADER_KEEP_REFERENCED
+	if err := t.Save(); err != nil {
+		NewtUsage(nil, err)
+	}
+
+	b = buildSplitTarget(cmd, args[0])
+	mr, err := b.SplitKeepReport()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(mr)
+		return
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Minimized the loader keep list of target %s: %d -> %d symbols; "+
+			"set %s to %s\n", t.FullName(), len(r.Symbols), len(mr.Symbols),
+		target.TARGET_LOADER_KEEP_VAR, target.TARGET_LOADER_KEEP_REFERENCED)
+}
+
+func AddSplitCommands(cmd *cobra.Command) {
+	splitStatusHelpText := "Explain the loader / app pairing recorded by " +
+		"the most recent split image build of a target: which packages " +
+		"and symbols the app shares with the loader, and whether the app " +
+		"was linked against the loader's current ABI.\n\n" +
+		"The symbol list is cached in bin/<target>/" +
+		builder.SPLIT_STATE_FILENAME + " and reused as long as the " +
+		"loader and app archives are unchanged.  If the loader's ABI " +
+		"changes, the next build relinks the app."
+	splitStatusHelpEx := "  newt split-status my_target\n"
+	splitStatusHelpEx += "  newt split-status my_target -v\n"
+
+	splitStatusCmd := &cobra.Command{
+		Use:     "split-status <target-name>",
+		Short:   "Show the loader / app pairing of a split image target",
+		Long:    splitStatusHelpText,
+		Example: splitStatusHelpEx,
+		Run:     splitStatusRunCmd,
+	}
+
+	cmd.AddCommand(splitStatusCmd)
+	AddTabCompleteFn(splitStatusCmd, targetList)
+
+	splitFreezeHelpText := "Build a split image target and freeze the " +
+		"symbols its loader's ROM elf exposes to apps: their sections, " +
+		"addresses and sizes are written to <target>/" +
+		target.TARGET_ROM_DIR + "/<version>.yml, and the target's " +
+		"rom_version is set to the version.  Commit the file; it " +
+		"records the ROM's ABI.\n\n" +
+		"Subsequent builds of the target check the new ROM elf against " +
+		"the frozen list, and fail if a frozen symbol was removed, moved " +
+		"or resized.  A frozen version is not replaced unless --force is " +
+		"specified."
+	splitFreezeHelpEx := "  newt split-freeze my_target 1.0.0\n"
+
+	splitFreezeCmd := &cobra.Command{
+		Use:     "split-freeze <target-name> <version>",
+		Short:   "Freeze the ROM symbols of a split image target",
+		Long:    splitFreezeHelpText,
+		Example: splitFreezeHelpEx,
+		Run:     splitFreezeRunCmd,
+	}
+	splitFreezeCmd.Flags().BoolVarP(&splitFreezeForce, "force", "f", false,
+		"Replace the version if it is already frozen")
+
+	cmd.AddCommand(splitFreezeCmd)
+	AddTabCompleteFn(splitFreezeCmd, targetList)
+
+	splitCheckHelpText := "Build a split image target and report the " +
+		"compatibility of its loader's ROM elf with a frozen version " +
+		"(by default, the target's rom_version): frozen symbols that " +
+		"were removed, moved or resized, and the number of new symbols " +
+		"(listed with -v).  Fails if the ROM is incompatible."
+	splitCheckHelpEx := "  newt split-check my_target\n"
+	splitCheckHelpEx += "  newt split-check my_target 1.0.0 -v\n"
+
+	splitCheckCmd := &cobra.Command{
+		Use:     "split-check <target-name> [version]",
+		Short:   "Check a split image target's ROM against frozen symbols",
+		Long:    splitCheckHelpText,
+		Example: splitCheckHelpEx,
+		Run:     splitCheckRunCmd,
+	}
+
+	cmd.AddCommand(splitCheckCmd)
+	AddTabCompleteFn(splitCheckCmd, targetList)
+
+	splitKeepHelpText := "Build a split image target and report the " +
+		"loader's keep list: the app symbols from packages shared with " +
+		"the loader that the loader is linked with, so that the app can " +
+		"use them.  Symbols that none of the app's own packages " +
+		"reference are dead weight; their sizes are listed, and their " +
+		"total is an upper bound on the space that minimizing the list " +
+		"saves in the loader.  -v also lists referenced symbols.\n\n" +
+		"--minimize sets the target's loader_keep to referenced and " +
+		"rebuilds it, so that the loader only keeps referenced symbols " +
+		"from then on.  Set loader_keep to all to restore the default."
+	splitKeepHelpEx := "  newt split-keep my_target\n"
+	splitKeepHelpEx += "  newt split-keep my_target --minimize\n"
+
+	splitKeepCmd := &cobra.Command{
+		Use:     "split-keep <target-name>",
+		Short:   "Report unreferenced symbols in a split loader's keep list",
+		Long:    splitKeepHelpText,
+		Example: splitKeepHelpEx,
+		Run:     splitKeepRunCmd,
+	}
+	splitKeepCmd.Flags().BoolVarP(&splitKeepMinimize, "minimize", "", false,
+		"Only keep referenced symbols from now on and rebuild")
+
+	cmd.AddCommand(splitKeepCmd)
+	AddTabCompleteFn(splitKeepCmd, targetList)
+}
@@ -0,0 +1,169 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+var stackEntries []string
+var stackWordSize int
+var stackMargin int
+
+func stackUsageFlags(su *builder.StackUsage) []string {
+	flags := []string{}
+	if su.Recursive {
+		flags = append(flags, "recursion")
+	}
+	if su.Dynamic {
+		flags = append(flags, "dynamic frames")
+	}
+	if su.Indirect {
+		flags = append(flags, "indirect calls")
+	}
+	if len(su.Unknown) > 0 {
+		flags = append(flags, fmt.Sprintf("%d unknown fn(s)",
+			len(su.Unknown)))
+	}
+	return flags
+}
+
+func printStackUsage(results []*builder.StackUsage) {
+	width := len("Entry")
+	for _, su := range results {
+		if len(su.Entry) > width {
+			width = len(su.Entry)
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "%-*s %10s %10s  %s\n",
+		width, "Entry", "Estimated", "Configured", "Notes")
+	for _, su := range results {
+		configured := "-"
+		if su.Configured > 0 {
+			configured = fmt.Sprintf("%d", su.Configured)
+		}
+
+		notes := stackUsageFlags(su)
+		if su.Insufficient {
+			notes = append([]string{colorText(ANSI_RED, "INSUFFICIENT")},
+				notes...)
+		}
+		if su.Setting != "" {
+			notes = append(notes, "size from "+su.Setting)
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%-*s %10d %10s  %s\n",
+			width, su.Entry, su.Estimated, configured,
+			strings.Join(notes, ", "))
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "    deepest path: %s\n",
+			strings.Join(su.Path, " -> "))
+		if len(su.Unknown) > 0 {
+			util.StatusMessage(util.VERBOSITY_VERBOSE,
+				"    no stack info: %s\n", strings.Join(su.Unknown, ", "))
+		}
+	}
+}
+
+func stackUsageRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	TryGetProject()
+
+	b, err := TargetBuilderForTargetOrUnittest(args[0])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	b.EnableStackUsage()
+	if err := b.Build(); err != nil {
+		NewtUsage(nil, err)
+	}
+
+	results, err := b.StackUsage(stackEntries, stackWordSize, stackMargin)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if newtutil.NewtJson {
+		printJson(results)
+	} else {
+		printStackUsage(results)
+	}
+
+	insufficient := []string{}
+	for _, su := range results {
+		if su.Insufficient {
+			insufficient = append(insufficient, su.Entry)
+		}
+	}
+	if len(insufficient) > 0 {
+		NewtUsage(nil, util.FmtNewtError(
+			"Stack size may be insufficient for: %s",
+			strings.Join(insufficient, ", ")))
+	}
+}
+
+func AddStackCommands(cmd *cobra.Command) {
+	stackHelpText := "Build a target with -fstack-usage and estimate the " +
+		"worst-case stack depth of its entry points by combining the " +
+		"per-function frame sizes with the call graph from the " +
+		"executable.\n\n" +
+		"Entry points and their stack sizes are configured in " +
+		"target.yml as target.stack.<function>: <size>, where <size> is " +
+		"either a syscfg setting holding the size in os_stack_t units " +
+		"(e.g., BLE_HS_STACK_SIZE) or a size in bytes.  Without any, the " +
+		"main task is checked against OS_MAIN_STACK_SIZE.\n\n" +
+		"Estimates are lower bounds when the call graph contains " +
+		"recursion, dynamically sized frames, indirect calls or " +
+		"functions without stack information (e.g., from prebuilt " +
+		"libraries); such entries are annotated.  The command fails if an " +
+		"estimate plus the margin exceeds the configured size."
+	stackHelpEx := "  newt stack-usage my_target\n"
+	stackHelpEx += "  newt stack-usage my_target --entry my_task_func -v\n"
+
+	stackCmd := &cobra.Command{
+		Use:     "stack-usage <target-name>",
+		Short:   "Estimate worst-case stack depth per task",
+		Long:    stackHelpText,
+		Example: stackHelpEx,
+		Run:     stackUsageRunCmd,
+	}
+
+	stackCmd.Flags().StringSliceVarP(&stackEntries, "entry", "", nil,
+		"Additional entry point functions to analyze")
+	stackCmd.Flags().IntVarP(&stackWordSize, "word-size", "", 4,
+		"Size of os_stack_t in bytes")
+	stackCmd.Flags().IntVarP(&stackMargin, "margin", "", 10,
+		"Safety margin, as a percentage of the estimate")
+
+	cmd.AddCommand(stackCmd)
+	AddTabCompleteFn(stackCmd, func() []string {
+		return append(targetList(), unittestList()...)
+	})
+}
@@ -0,0 +1,679 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/syscfg"
+	"mynewt.apache.org/newt/util"
+)
+
+const (
+	cfgModeBrowse = iota
+	cfgModeEdit
+	cfgModeSearch
+	cfgModeConfirmQuit
+)
+
+// Rows taken up by everything but the list: title, location, blank line,
+// separator, three lines of details, status, and key help.
+const cfgEditChromeRows = 9
+
+// Full-screen, menuconfig-style editor for a target's syscfg overrides.
+// Settings are presented grouped by defining package; edits are accumulated
+// in memory and written to the target's syscfg.yml on save.
+type cfgEditor struct {
+	b     *builder.TargetBuilder
+	lpkg  *pkg.LocalPackage
+	cfg   syscfg.Cfg
+	byPkg map[string][]string
+	pkgs  []string
+	vals  map[string]string
+	dirty bool
+
+	in  *termKeyReader
+	out *bufio.Writer
+
+	mode   int
+	input  []rune
+	status string
+
+	// The settings list currently displayed; nil while the package list is
+	// shown.
+	entries  []string
+	location string
+
+	sel    int
+	top    int
+	pkgSel int
+	pkgTop int
+}
+
+func newCfgEditor(b *builder.TargetBuilder) *cfgEditor {
+	ed := &cfgEditor{
+		b:   b,
+		in:  newTermKeyReader(os.Stdin),
+		out: bufio.NewWriter(os.Stdout),
+	}
+
+	ed.lpkg = b.GetTestPkg()
+	if ed.lpkg == nil {
+		ed.lpkg = b.GetTarget().Package()
+	}
+
+	ed.vals = map[string]string{}
+	for k, v := range ed.lpkg.SyscfgV.GetStringMapString("syscfg.vals") {
+		ed.vals[k] = v
+	}
+
+	ed.setCfg(targetBuilderConfigResolve(b).Cfg)
+	return ed
+}
+
+func (ed *cfgEditor) setCfg(cfg syscfg.Cfg) {
+	ed.cfg = cfg
+
+	ed.byPkg = map[string][]string{}
+	ed.pkgs = nil
+	for pkgName, entries := range syscfg.EntriesByPkg(cfg) {
+		names := make([]string, len(entries))
+		for i, entry := range entries {
+			names[i] = entry.Name
+		}
+		sort.Strings(names)
+
+		ed.byPkg[pkgName] = names
+		ed.pkgs = append(ed.pkgs, pkgName)
+	}
+	sort.Strings(ed.pkgs)
+}
+
+// Re-resolves the target's configuration so that displayed values reflect
+// the overrides saved so far.
+func (ed *cfgEditor) reload() error {
+	res, err := ed.b.Resolve()
+	if err != nil {
+		return err
+	}
+
+	ed.setCfg(res.Cfg)
+	return nil
+}
+
+// Returns the value a setting would have without the target's override.
+func (ed *cfgEditor) baseValue(entry syscfg.CfgEntry) (string, string) {
+	for i := len(entry.History) - 1; i >= 0; i-- {
+		point := entry.History[i]
+		if point.Source != ed.lpkg {
+			if i == 0 {
+				return point.Value, "default"
+			}
+			return point.Value, point.Name()
+		}
+	}
+
+	return entry.Value, "default"
+}
+
+func (ed *cfgEditor) value(entry syscfg.CfgEntry) string {
+	if val, ok := ed.vals[entry.Name]; ok {
+		return val
+	}
+
+	val, _ := ed.baseValue(entry)
+	return val
+}
+
+// Describes where a setting's effective value comes from.
+func (ed *cfgEditor) origin(entry syscfg.CfgEntry) string {
+	if _, ok := ed.vals[entry.Name]; ok {
+		return ed.lpkg.Name()
+	}
+
+	_, origin := ed.baseValue(entry)
+	return origin
+}
+
+// Finds all settings whose name or description contains the specified text,
+// ignoring case.
+func (ed *cfgEditor) search(text string) []string {
+	text = strings.ToLower(text)
+
+	names := []string{}
+	for _, pkgName := range ed.pkgs {
+		for _, name := range ed.byPkg[pkgName] {
+			entry := ed.cfg.Settings[name]
+			if strings.Contains(strings.ToLower(entry.Name), text) ||
+				strings.Contains(strings.ToLower(entry.Description), text) {
+
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+func (ed *cfgEditor) save() error {
+	ed.lpkg.SyscfgV.Set("syscfg.vals", ed.vals)
+	if err := ed.lpkg.SaveSyscfgVals(); err != nil {
+		return err
+	}
+	ed.dirty = false
+
+	ed.status = "Wrote " + builder.PkgSyscfgPath(ed.lpkg.BasePath())
+
+	if err := ed.reload(); err != nil {
+		return err
+	}
+	if ed.cfg.ErrorText() != "" {
+		ed.status += "; configuration has errors"
+	}
+
+	return nil
+}
+
+func (ed *cfgEditor) listLen() int {
+	if ed.entries != nil {
+		return len(ed.entries)
+	}
+
+	return len(ed.pkgs)
+}
+
+func (ed *cfgEditor) selectedEntry() (syscfg.CfgEntry, bool) {
+	if ed.entries == nil || len(ed.entries) == 0 {
+		return syscfg.CfgEntry{}, false
+	}
+
+	return ed.cfg.Settings[ed.entries[ed.sel]], true
+}
+
+func (ed *cfgEditor) openList(location string, names []string) {
+	if ed.entries == nil {
+		ed.pkgSel = ed.sel
+		ed.pkgTop = ed.top
+	}
+
+	ed.location = location
+	ed.entries = names
+	ed.sel = 0
+	ed.top = 0
+}
+
+func (ed *cfgEditor) closeList() {
+	if ed.entries == nil {
+		return
+	}
+
+	ed.entries = nil
+	ed.location = ""
+	ed.sel = ed.pkgSel
+	ed.top = ed.pkgTop
+}
+
+func (ed *cfgEditor) moveSel(delta int) {
+	ed.sel += delta
+	if ed.sel >= ed.listLen() {
+		ed.sel = ed.listLen() - 1
+	}
+	if ed.sel < 0 {
+		ed.sel = 0
+	}
+}
+
+func (ed *cfgEditor) setVal(name string, val string) {
+	if cur, ok := ed.vals[name]; !ok || cur != val {
+		ed.vals[name] = val
+		ed.dirty = true
+	}
+}
+
+func (ed *cfgEditor) pkgRow(pkgName string) string {
+	names := ed.byPkg[pkgName]
+
+	overrides := 0
+	for _, name := range names {
+		if _, ok := ed.vals[name]; ok {
+			overrides++
+		}
+	}
+
+	row := fmt.Sprintf("  %s  (%d settings", pkgName, len(names))
+	if overrides > 0 {
+		row += fmt.Sprintf(", %d overridden", overrides)
+	}
+
+	return row + ")"
+}
+
+func (ed *cfgEditor) entryRow(name string, nameWidth int) string {
+	entry := ed.cfg.Settings[name]
+
+	mark := " "
+	if _, ok := ed.vals[name]; ok {
+		mark = "*"
+	}
+
+	return fmt.Sprintf(" %s %-*s = %s  [%s]", mark, nameWidth, name,
+		ed.value(entry), ed.origin(entry))
+}
+
+// Returns the three lines describing the selected item.
+func (ed *cfgEditor) details(width int) []string {
+	lines := []string{}
+
+	if entry, ok := ed.selectedEntry(); ok {
+		desc := termWrap(entry.Description, width-2)
+		for i := 0; i < 2; i++ {
+			if i < len(desc) {
+				lines = append(lines, " "+desc[i])
+			} else {
+				lines = append(lines, "")
+			}
+		}
+
+		lines = append(lines, fmt.Sprintf(" Default: %s   Defined in: %s",
+			entry.History[0].Value, entry.History[0].Name()))
+	} else if ed.entries == nil && len(ed.pkgs) > 0 {
+		lines = append(lines, " Package "+ed.pkgs[ed.sel], "", "")
+	}
+
+	for len(lines) < 3 {
+		lines = append(lines, "")
+	}
+
+	return lines
+}
+
+func (ed *cfgEditor) keyHelp() string {
+	switch ed.mode {
+	case cfgModeEdit:
+		return " Enter:set value  Esc:cancel"
+	case cfgModeSearch:
+		return " Enter:search  Esc:cancel"
+	case cfgModeConfirmQuit:
+		return " y:save and quit  n:quit without saving  Esc:cancel"
+	}
+
+	if ed.entries != nil {
+		return " Enter:edit  Space:toggle  d:remove override  /:search  " +
+			"Esc:back  s:save  q:quit"
+	}
+
+	return " Enter:open  /:search  s:save  q:quit"
+}
+
+func (ed *cfgEditor) statusLine() string {
+	switch ed.mode {
+	case cfgModeEdit:
+		entry, _ := ed.selectedEntry()
+		return " " + entry.Name + ": " + string(ed.input) + "_"
+	case cfgModeSearch:
+		return " Search: " + string(ed.input) + "_"
+	case cfgModeConfirmQuit:
+		return " Save changes before quitting?"
+	}
+
+	return " " + ed.status
+}
+
+func (ed *cfgEditor) draw() {
+	width, height := termSize()
+	listRows := height - cfgEditChromeRows
+	if listRows < 1 {
+		listRows = 1
+	}
+
+	if ed.sel < ed.top {
+		ed.top = ed.sel
+	}
+	if ed.sel >= ed.top+listRows {
+		ed.top = ed.sel - listRows + 1
+	}
+
+	lines := []string{}
+	reverse := map[int]bool{}
+
+	title := " Syscfg: " + ed.b.GetTarget().FullName()
+	if ed.dirty {
+		title += "  (modified)"
+	}
+	reverse[len(lines)] = true
+	lines = append(lines, title)
+
+	if ed.entries != nil {
+		lines = append(lines, " Packages > "+ed.location)
+	} else {
+		lines = append(lines, " Packages")
+	}
+	lines = append(lines, "")
+
+	nameWidth := 0
+	for _, name := range ed.entries {
+		if len(name) > nameWidth {
+			nameWidth = len(name)
+		}
+	}
+	if nameWidth > width/2 {
+		nameWidth = width / 2
+	}
+
+	for i := ed.top; i < ed.top+listRows; i++ {
+		row := ""
+		if i < ed.listLen() {
+			if ed.entries != nil {
+				row = ed.entryRow(ed.entries[i], nameWidth)
+			} else {
+				row = ed.pkgRow(ed.pkgs[i])
+			}
+			reverse[len(lines)] = i == ed.sel
+		}
+		lines = append(lines, row)
+	}
+
+	lines = append(lines, strings.Repeat("-", width))
+	lines = append(lines, ed.details(width)...)
+	lines = append(lines, ed.statusLine())
+	reverse[len(lines)] = true
+	lines = append(lines, ed.keyHelp())
+
+	fmt.Fprintf(ed.out, "\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			fmt.Fprintf(ed.out, "\r\n")
+		}
+		if reverse[i] {
+			fmt.Fprintf(ed.out, "\x1b[7m%s\x1b[0m", termFit(line, width))
+		} else {
+			fmt.Fprintf(ed.out, "%s", termFit(line, width))
+		}
+	}
+	ed.out.Flush()
+}
+
+// Handles a key while a line of text is being entered.  Returns true when
+// input is complete.
+func (ed *cfgEditor) handleInputKey(key termKey) bool {
+	switch key.Code {
+	case termKeyRune:
+		ed.input = append(ed.input, key.Rune)
+	case termKeyBackspace:
+		if len(ed.input) > 0 {
+			ed.input = ed.input[:len(ed.input)-1]
+		}
+	case termKeyEnter:
+		return true
+	case termKeyEsc, termKeyCtrlC:
+		ed.mode = cfgModeBrowse
+	}
+
+	return false
+}
+
+func (ed *cfgEditor) handleBrowseKey(key termKey) (bool, error) {
+	page := termPageRows()
+
+	switch key.Code {
+	case termKeyUp:
+		ed.moveSel(-1)
+	case termKeyDown:
+		ed.moveSel(1)
+	case termKeyPgUp:
+		ed.moveSel(-page)
+	case termKeyPgDn:
+		ed.moveSel(page)
+	case termKeyHome:
+		ed.sel = 0
+	case termKeyEnd:
+		ed.moveSel(ed.listLen())
+	case termKeyLeft, termKeyEsc, termKeyBackspace:
+		ed.closeList()
+	case termKeyCtrlC:
+		return true, nil
+
+	case termKeyEnter, termKeyRight:
+		if ed.entries == nil {
+			if len(ed.pkgs) > 0 {
+				pkgName := ed.pkgs[ed.sel]
+				ed.openList(pkgName, ed.byPkg[pkgName])
+			}
+		} else if entry, ok := ed.selectedEntry(); ok &&
+			key.Code == termKeyEnter {
+
+			ed.mode = cfgModeEdit
+			ed.input = []rune(ed.value(entry))
+		}
+
+	case termKeyRune:
+		return ed.handleBrowseRune(key.Rune)
+	}
+
+	return false, nil
+}
+
+func (ed *cfgEditor) handleBrowseRune(r rune) (bool, error) {
+	switch r {
+	case 'k':
+		ed.moveSel(-1)
+	case 'j':
+		ed.moveSel(1)
+	case '/':
+		ed.mode = cfgModeSearch
+		ed.input = nil
+
+	case 's':
+		if err := ed.save(); err != nil {
+			return false, err
+		}
+
+	case 'q':
+		if !ed.dirty {
+			return true, nil
+		}
+		ed.mode = cfgModeConfirmQuit
+
+	case ' ':
+		entry, ok := ed.selectedEntry()
+		if !ok {
+			break
+		}
+		switch ed.value(entry) {
+		case "0":
+			ed.setVal(entry.Name, "1")
+		case "1":
+			ed.setVal(entry.Name, "0")
+		default:
+			ed.status = entry.Name + " is not a boolean setting"
+		}
+
+	case 'd':
+		entry, ok := ed.selectedEntry()
+		if !ok {
+			break
+		}
+		if _, ok := ed.vals[entry.Name]; ok {
+			delete(ed.vals, entry.Name)
+			ed.dirty = true
+		} else {
+			ed.status = entry.Name + " is not overridden by the target"
+		}
+	}
+
+	return false, nil
+}
+
+// Processes a single keypress.  Returns true when the editor should exit.
+func (ed *cfgEditor) handleKey(key termKey) (bool, error) {
+	switch ed.mode {
+	case cfgModeEdit:
+		if ed.handleInputKey(key) {
+			entry, _ := ed.selectedEntry()
+			ed.setVal(entry.Name, strings.TrimSpace(string(ed.input)))
+			ed.mode = cfgModeBrowse
+		}
+
+	case cfgModeSearch:
+		if ed.handleInputKey(key) {
+			ed.mode = cfgModeBrowse
+			text := strings.TrimSpace(string(ed.input))
+			if names := ed.search(text); len(names) == 0 {
+				ed.status = "No settings match \"" + text + "\""
+			} else {
+				ed.closeList()
+				ed.openList("Settings matching \""+text+"\"", names)
+			}
+		}
+
+	case cfgModeConfirmQuit:
+		switch {
+		case key.Code == termKeyRune && (key.Rune == 'y' || key.Rune == 'Y'):
+			return true, ed.save()
+		case key.Code == termKeyRune && (key.Rune == 'n' || key.Rune == 'N'):
+			return true, nil
+		case key.Code == termKeyEsc:
+			ed.mode = cfgModeBrowse
+		}
+
+	default:
+		ed.status = ""
+		return ed.handleBrowseKey(key)
+	}
+
+	return false, nil
+}
+
+func termPageRows() int {
+	_, height := termSize()
+	if height-cfgEditChromeRows < 1 {
+		return 1
+	}
+
+	return height - cfgEditChromeRows
+}
+
+func (ed *cfgEditor) run() error {
+	st, err := termMakeRaw()
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	// Switch to the alternate screen and hide the cursor.
+	fmt.Fprintf(ed.out, "\x1b[?1049h\x1b[?25l\x1b[2J")
+	defer func() {
+		fmt.Fprintf(ed.out, "\x1b[?25h\x1b[?1049l")
+		ed.out.Flush()
+		termRestore(st)
+	}()
+
+	for {
+		ed.draw()
+
+		key, err := ed.in.ReadKey()
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+
+		done, err := ed.handleKey(key)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+func configEditCmd(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		NewtUsage(cmd,
+			util.NewNewtError("Must specify exactly one target or "+
+				"unittest name"))
+	}
+
+	if !termIsInteractive() {
+		NewtUsage(nil, util.NewNewtError("newt config edit requires an "+
+			"interactive terminal; use \"newt target set\" or edit "+
+			"syscfg.yml to change settings non-interactively"))
+	}
+
+	TryGetProject()
+
+	b, err := TargetBuilderForTargetOrUnittest(args[0])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	ed := newCfgEditor(b)
+	if err := ed.run(); err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if ed.status != "" {
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s\n", ed.status)
+	}
+	if errText := ed.cfg.ErrorText(); errText != "" {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"* Warning: configuration has errors:\n%s\n", errText)
+	}
+}
+
+func AddConfigCommands(cmd *cobra.Command) {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Browse and edit system configuration",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Usage()
+		},
+	}
+
+	cmd.AddCommand(configCmd)
+
+	editCmd := &cobra.Command{
+		Use:   "edit <target>",
+		Short: "Edit a target's system configuration in a terminal UI",
+		Long: "Open a full-screen, menuconfig-style editor for a target's " +
+			"syscfg settings.  Settings are grouped by defining package " +
+			"and shown with their descriptions, current values, and the " +
+			"package each value comes from.  Overrides are written to the " +
+			"target's syscfg.yml.\n\n" +
+			"Keys:\n" +
+			"  Up/Down, PgUp/PgDn   move the selection\n" +
+			"  Enter                open a package / edit a setting\n" +
+			"  Space                toggle a boolean setting\n" +
+			"  d                    remove the target's override\n" +
+			"  /                    search setting names and descriptions\n" +
+			"  Esc, Left            return to the package list\n" +
+			"  s                    save\n" +
+			"  q                    quit",
+		Run: configEditCmd,
+	}
+
+	configCmd.AddCommand(editCmd)
+	AddTabCompleteFn(editCmd, func() []string {
+		return append(targetList(), unittestList()...)
+	})
+}
@@ -42,12 +42,19 @@ import (
 
 var targetForce bool = false
 var amendDelete bool = false
+var copyBsp string
+var copyPrune bool = false
+var configPruneRemove bool = false
+var exportBuildSystem string = builder.EXPORT_BUILD_SYSTEM_CMAKE
+var exportOutput string
 
 // target variables that can have values amended with the amend command.
 var amendVars = []string{"aflags", "cflags", "lflags", "syscfg"}
 
-var setVars = []string{"aflags", "app", "build_profile", "bsp", "cflags",
-	"lflags", "loader", "syscfg"}
+var setVars = []string{"aflags", "app", "artifact_name", "build_profile",
+	"bsp", "cflags", "companions", "connection", "image_align",
+	"image_header_size", "image_pad", "image_security_counter", "inherits",
+	"lflags", "loader", "loader_keep", "rom_version", "sanitizers", "syscfg"}
 
 func resolveExistingTargetArg(arg string) (*target.Target, error) {
 	t := ResolveTarget(arg)
@@ -194,23 +201,70 @@ func targetShowCmd(cmd *cobra.Command, args []string) {
 
 	sort.Strings(targetNames)
 
+	jsonTargets := map[string]map[string]interface{}{}
 	for _, name := range targetNames {
 		kvPairs := map[string]string{}
 
-		util.StatusMessage(util.VERBOSITY_DEFAULT, name+"\n")
+		// Annotations indicating which base target a value was inherited
+		// from; only displayed in text output.
+		origins := map[string]string{}
+
+		if !newtutil.NewtJson {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, name+"\n")
+		}
 
 		target := target.GetTargets()[name]
-		for k, v := range target.Vars {
-			kvPairs[strings.TrimPrefix(k, "target.")] = v
+		for k, v := range target.EffectiveVars() {
+			key := strings.TrimPrefix(k, "target.")
+			kvPairs[key] = v
+
+			if src := target.VarSource(k); src != target {
+				origins[key] = "inherited from " + src.Name()
+			}
 		}
 
 		// A few variables come from the base package rather than the target.
-		kvPairs["syscfg"] = syscfg.KeyValueToStr(
-			target.Package().SyscfgV.GetStringMapString("syscfg.vals"))
+		syscfgVals := target.SyscfgVals()
+		kvPairs["syscfg"] = syscfg.KeyValueToStr(syscfgVals)
+
+		inheritedCfg := map[string][]string{}
+		for k, _ := range syscfgVals {
+			if src := target.SyscfgValSource(k); src != target {
+				inheritedCfg[src.Name()] = append(inheritedCfg[src.Name()], k)
+			}
+		}
+		cfgOrigins := []string{}
+		for srcName, settings := range inheritedCfg {
+			sort.Strings(settings)
+			cfgOrigins = append(cfgOrigins, fmt.Sprintf("%s from %s",
+				strings.Join(settings, ","), srcName))
+		}
+		if len(cfgOrigins) > 0 {
+			sort.Strings(cfgOrigins)
+			origins["syscfg"] = "inherited: " + strings.Join(cfgOrigins, "; ")
+		}
 		kvPairs["cflags"] = pkgVarSliceString(target.Package(), "pkg.cflags")
 		kvPairs["lflags"] = pkgVarSliceString(target.Package(), "pkg.lflags")
 		kvPairs["aflags"] = pkgVarSliceString(target.Package(), "pkg.aflags")
 
+		if newtutil.NewtJson {
+			jsonVals := map[string]interface{}{}
+			for k, v := range kvPairs {
+				if len(v) > 0 {
+					jsonVals[k] = v
+				}
+			}
+
+			// Rather than the flattened "k=v:k=v" string, map each setting
+			// to its value.
+			if len(syscfgVals) > 0 {
+				jsonVals["syscfg"] = syscfgVals
+			}
+
+			jsonTargets[name] = jsonVals
+			continue
+		}
+
 		keys := []string{}
 		for k, _ := range kvPairs {
 			keys = append(keys, k)
@@ -218,12 +272,22 @@ func targetShowCmd(cmd *cobra.Command, args []string) {
 		sort.Strings(keys)
 		for _, k := range keys {
 			val := kvPairs[k]
-			if len(val) > 0 {
+			if len(val) == 0 {
+				continue
+			}
+			if origin := origins[k]; origin != "" {
+				util.StatusMessage(util.VERBOSITY_DEFAULT, "    %s=%s  (%s)\n",
+					k, val, origin)
+			} else {
 				util.StatusMessage(util.VERBOSITY_DEFAULT, "    %s=%s\n",
-					k, kvPairs[k])
+					k, val)
 			}
 		}
 	}
+
+	if newtutil.NewtJson {
+		printJson(jsonTargets)
+	}
 }
 
 func targetSetCmd(cmd *cobra.Command, args []string) {
@@ -247,7 +311,7 @@ func targetSetCmd(cmd *cobra.Command, args []string) {
 	for i := 1; i < len(args); i++ {
 		kv := strings.SplitN(args[i], "=", 2)
 		key := strings.TrimPrefix(kv[0], "target.")
-		supported := false
+		supported := strings.HasPrefix(key, "env.") && len(key) > 4
 		for _, v := range setVars {
 			if key == v {
 				supported = true
@@ -262,6 +326,15 @@ func targetSetCmd(cmd *cobra.Command, args []string) {
 		if !strings.HasPrefix(kv[0], "target.") {
 			kv[0] = "target." + kv[0]
 		}
+		if strings.HasPrefix(key, "env.") {
+			// Environment variable names are case-insensitive in
+			// target.yml; replace an existing setting of any case.
+			for k, _ := range t.Vars {
+				if strings.EqualFold(k, kv[0]) {
+					delete(t.Vars, k)
+				}
+			}
+		}
 
 		// Make sure it is a valid variable.
 
@@ -496,9 +569,28 @@ func targetCopyCmd(cmd *cobra.Command, args []string) {
 		NewtUsage(cmd, err)
 	}
 
+	// When porting to a different BSP, make sure it exists before creating
+	// anything.
+	var bsp *pkg.LocalPackage
+	if copyBsp != "" {
+		bsp, err = proj.ResolvePackage(proj.LocalRepo(),
+			strings.TrimSuffix(copyBsp, "/"))
+		if err != nil {
+			NewtUsage(cmd, err)
+		}
+		if bsp.Type() != pkg.PACKAGE_TYPE_BSP {
+			NewtUsage(cmd, util.FmtNewtError("Package %s is not a BSP; "+
+				"type is: %s", bsp.FullName(), pkg.PackageTypeNames[bsp.Type()]))
+		}
+	}
+
 	// Copy the source target's base package and adjust the fields which need
 	// to change.
 	dstTarget := srcTarget.Clone(proj.LocalRepo(), dstName)
+	if bsp != nil {
+		dstTarget.Vars["target.bsp"] = bsp.FullName()
+		dstTarget.BspName = bsp.FullName()
+	}
 
 	// Save the new target.
 	err = dstTarget.Save()
@@ -524,6 +616,92 @@ func targetCopyCmd(cmd *cobra.Command, args []string) {
 	util.StatusMessage(util.VERBOSITY_DEFAULT,
 		"Target successfully copied; %s --> %s\n",
 		srcTarget.FullName(), dstTarget.FullName())
+
+	if bsp != nil {
+		checkPortedSyscfg(dstTarget.FullName(), srcTarget.BspName)
+	}
+}
+
+// Resolves the configuration of a target that was just copied onto a
+// different BSP, and reports the target's syscfg overrides that refer to
+// settings which no longer exist.  With --prune, such overrides are removed
+// from the target; otherwise, a command that removes them is suggested.
+func checkPortedSyscfg(targetName string, oldBspName string) {
+	// Reload the project so that the new target is read back from disk like
+	// any other.
+	if err := ResetGlobalState(); err != nil {
+		NewtUsage(nil, err)
+	}
+
+	t := ResolveTarget(targetName)
+	if t == nil {
+		NewtUsage(nil, util.FmtNewtError("Failed to resolve target: %s",
+			targetName))
+	}
+
+	b, err := builder.NewTargetBuilder(t)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	res, err := b.Resolve()
+	if err != nil {
+		util.StatusMessage(util.VERBOSITY_QUIET,
+			"* Warning: configuration of %s does not resolve on the new "+
+				"BSP; remaining overrides were not checked:\n%s\n",
+			t.FullName(), err.Error())
+		return
+	}
+
+	stale := map[string]string{}
+	for name, points := range res.Cfg.Orphans {
+		for _, p := range points {
+			if p.Source == t.Package() {
+				stale[name] = p.Value
+			}
+		}
+	}
+
+	if len(stale) == 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"All syscfg overrides of %s are valid for BSP %s\n",
+			t.FullName(), t.BspName)
+		return
+	}
+
+	names := make([]string, 0, len(stale))
+	for name, _ := range stale {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	util.StatusMessage(util.VERBOSITY_QUIET,
+		"The following syscfg overrides were valid for %s but are not "+
+			"defined by any package when building with %s:\n",
+		oldBspName, t.BspName)
+	for _, name := range names {
+		util.StatusMessage(util.VERBOSITY_QUIET, "    %s=%s\n",
+			name, stale[name])
+	}
+
+	if !copyPrune {
+		util.StatusMessage(util.VERBOSITY_QUIET,
+			"To remove them, run:\n    newt target amend -d %s syscfg=%s\n",
+			t.FullName(), strings.Join(names, ":"))
+		return
+	}
+
+	vals := t.Package().SyscfgV.GetStringMapString("syscfg.vals")
+	for _, name := range names {
+		delete(vals, name)
+	}
+	t.Package().SyscfgV.Set("syscfg.vals", vals)
+	if err := t.Package().SaveSyscfgVals(); err != nil {
+		NewtUsage(nil, err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Removed %d stale override(s) from %s\n", len(names), t.FullName())
 }
 
 func printSetting(entry syscfg.CfgEntry) {
@@ -660,6 +838,91 @@ func targetConfigShowCmd(cmd *cobra.Command, args []string) {
 	}
 }
 
+// Reports the syscfg overrides in a target's (or unittest's) and its app's
+// syscfg.yml files that refer to settings no package defines.  With
+// --remove, the overrides are deleted from the files.
+func targetConfigPruneCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd,
+			util.NewNewtError("Must specify target or unittest name"))
+	}
+
+	for _, arg := range args {
+		b, err := TargetBuilderForTargetOrUnittest(arg)
+		if err != nil {
+			NewtUsage(cmd, err)
+		}
+
+		owners := []*pkg.LocalPackage{b.GetTarget().Package()}
+		if lpkg := b.GetTestPkg(); lpkg != nil {
+			owners = []*pkg.LocalPackage{lpkg}
+		} else if app := b.GetTarget().App(); app != nil {
+			owners = append(owners, app)
+		}
+
+		res := targetBuilderConfigResolve(b)
+
+		dead := map[*pkg.LocalPackage]map[string]string{}
+		for name, points := range res.Cfg.Orphans {
+			for _, p := range points {
+				for _, lpkg := range owners {
+					if p.Source == lpkg {
+						if dead[lpkg] == nil {
+							dead[lpkg] = map[string]string{}
+						}
+						dead[lpkg][name] = p.Value
+					}
+				}
+			}
+		}
+
+		if len(dead) == 0 {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"%s: no overrides of undefined settings\n",
+				b.GetTarget().FullName())
+			continue
+		}
+
+		for _, lpkg := range owners {
+			vals := dead[lpkg]
+			if len(vals) == 0 {
+				continue
+			}
+
+			names := make([]string, 0, len(vals))
+			for name, _ := range vals {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			path := builder.PkgSyscfgPath(lpkg.BasePath())
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"%s overrides settings that no package defines:\n", path)
+			for _, name := range names {
+				util.StatusMessage(util.VERBOSITY_DEFAULT, "    %s=%s\n",
+					name, vals[name])
+			}
+
+			if !configPruneRemove {
+				continue
+			}
+
+			n, err := syscfg.RemoveValsFromFile(path, names)
+			if err != nil {
+				NewtUsage(nil, err)
+			}
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"Removed %d override(s) from %s\n", n, path)
+		}
+
+		if !configPruneRemove {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"To remove them, run:\n    newt target config prune "+
+					"--remove %s\n", arg)
+		}
+	}
+}
+
 func targetConfigInitCmd(cmd *cobra.Command, args []string) {
 	if len(args) < 1 {
 		NewtUsage(cmd,
@@ -815,6 +1078,33 @@ func targetRevdepCmd(cmd *cobra.Command, args []string) {
 	}
 }
 
+func targetExportCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target name"))
+	}
+
+	TryGetProject()
+
+	t := ResolveTarget(args[0])
+	if t == nil {
+		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
+	}
+
+	b, err := builder.NewTargetBuilder(t)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	dir := exportOutput
+	if dir == "" {
+		dir = b.ExportDir()
+	}
+
+	if err := b.Export(exportBuildSystem, dir); err != nil {
+		NewtUsage(nil, err)
+	}
+}
+
 func AddTargetCommands(cmd *cobra.Command) {
 	targetHelpText := ""
 	targetHelpEx := ""
@@ -854,6 +1144,14 @@ func AddTargetCommands(cmd *cobra.Command) {
 	setHelpText += "specified in the command are saved in the syscfg.yml file."
 	setHelpText += "\nIf you want to change or add a new syscfg value and keep the other\n"
 	setHelpText += "syscfg values, use the newt target amend command.\n"
+	setHelpText += "\nA target that sets inherits=<base-target> uses the base target's\n"
+	setHelpText += "variables and syscfg values except for those it sets itself.\n"
+	setHelpText += "\nThe sanitizers variable is a comma-separated list of sanitizers\n"
+	setHelpText += "(address, undefined, thread) to build a sim target with.\n"
+	setHelpText += "\nenv.<NAME> sets an environment variable for the target's compiler,\n"
+	setHelpText += "linker, scripts and hooks.  Names are converted to upper case.  A\n"
+	setHelpText += "change to these variables causes a rebuild; they are recorded in the\n"
+	setHelpText += "image manifest.\n"
 	setHelpEx := "  newt target set my_target1 build_profile=optimized "
 	setHelpEx += "cflags=\"-DNDEBUG\"\n"
 	setHelpEx += "  newt target set my_target1 "
@@ -869,6 +1167,7 @@ func AddTargetCommands(cmd *cobra.Command) {
 	}
 	targetCmd.AddCommand(setCmd)
 	AddTabCompleteFn(setCmd, targetList)
+	AddArgCompleteFn(setCmd, targetVarCompleter(setVars))
 
 	amendHelpText := "Add, change, or delete values for multi-value target variables\n\n"
 	amendHelpText += "Variables that can have values amended are:\n"
@@ -896,6 +1195,7 @@ func AddTargetCommands(cmd *cobra.Command) {
 		"Delete Variable values")
 	targetCmd.AddCommand(amendCmd)
 	AddTabCompleteFn(amendCmd, targetList)
+	AddArgCompleteFn(amendCmd, targetVarCompleter(amendVars))
 
 	createHelpText := "Create a target specified by <target-name>."
 	createHelpEx := "  newt target create <target-name>\n"
@@ -928,16 +1228,26 @@ func AddTargetCommands(cmd *cobra.Command) {
 
 	targetCmd.AddCommand(delCmd)
 
-	copyHelpText := "Create a new target <dst-target> by cloning <src-target>"
-	copyHelpEx := "  newt target copy blinky_sim my_target"
+	copyHelpText := "Create a new target <dst-target> by cloning <src-target>.\n\n"
+	copyHelpText += "With --bsp, the new target uses the specified BSP instead of the\n"
+	copyHelpText += "source target's.  The new target's configuration is then resolved, and\n"
+	copyHelpText += "any syscfg overrides for settings that the new BSP does not provide are\n"
+	copyHelpText += "reported."
+	copyHelpEx := "  newt target copy blinky_sim my_target\n"
+	copyHelpEx += "  newt target copy --bsp hw/bsp/nrf52dk blinky_nrf51 blinky_nrf52"
 
 	copyCmd := &cobra.Command{
 		Use:     "copy <src-target> <dst-target>",
+		Aliases: []string{"clone"},
 		Short:   "Copy target",
 		Long:    copyHelpText,
 		Example: copyHelpEx,
 		Run:     targetCopyCmd,
 	}
+	copyCmd.Flags().StringVarP(&copyBsp, "bsp", "", "",
+		"Use the specified BSP in the new target")
+	copyCmd.Flags().BoolVarP(&copyPrune, "prune", "", false,
+		"With --bsp, remove syscfg overrides that are invalid for the new BSP")
 
 	targetCmd.AddCommand(copyCmd)
 	AddTabCompleteFn(copyCmd, targetList)
@@ -983,6 +1293,23 @@ func AddTargetCommands(cmd *cobra.Command) {
 		return append(targetList(), unittestList()...)
 	})
 
+	configPruneCmd := &cobra.Command{
+		Use:   "prune <target>",
+		Short: "Find overrides of settings that no longer exist",
+		Long: "Report the syscfg overrides in a target's and its app's " +
+			"syscfg.yml files that refer to settings not defined by any " +
+			"package the target uses (typically left behind by a repo " +
+			"upgrade).  Use --remove to delete them from the files.",
+		Run: targetConfigPruneCmd,
+	}
+	configPruneCmd.Flags().BoolVarP(&configPruneRemove, "remove", "", false,
+		"Remove the overrides")
+
+	configCmd.AddCommand(configPruneCmd)
+	AddTabCompleteFn(configPruneCmd, func() []string {
+		return append(targetList(), unittestList()...)
+	})
+
 	depHelpText := "View a target's dependency graph."
 
 	depCmd := &cobra.Command{
@@ -1010,4 +1337,31 @@ func AddTargetCommands(cmd *cobra.Command) {
 	AddTabCompleteFn(revdepCmd, func() []string {
 		return append(targetList(), unittestList()...)
 	})
+
+	exportHelpText := "Write a standalone build tree for the specified " +
+		"target's app image.  The tree contains copies of the resolved " +
+		"sources, headers, generated syscfg files, and linker scripts, " +
+		"along with a CMake or make build description that compiles them " +
+		"with the flags newt would use.  By default, the tree is written " +
+		"to bin/targets/<target>/export."
+	exportHelpEx := "  newt target export my_target1\n"
+	exportHelpEx += "  newt target export --build-system make " +
+		"--output ~/fw my_target1"
+
+	exportCmd := &cobra.Command{
+		Use:     "export <target>",
+		Short:   "Export a target as a CMake or make project",
+		Long:    exportHelpText,
+		Example: exportHelpEx,
+		Run:     targetExportCmd,
+	}
+
+	exportCmd.Flags().StringVarP(&exportBuildSystem, "build-system", "",
+		builder.EXPORT_BUILD_SYSTEM_CMAKE, "Build system to generate ("+
+			strings.Join(builder.ExportBuildSystems, "|")+")")
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "", "",
+		"Directory to write the build tree to")
+
+	targetCmd.AddCommand(exportCmd)
+	AddTabCompleteFn(exportCmd, targetList)
 }
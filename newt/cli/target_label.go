@@ -0,0 +1,214 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/interfaces"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+// Target labels select targets by their location in a repo rather than by
+// package name, which suits monorepos holding many products:
+//
+//	[@repo]//<dir>:<name>    The target <name> of the scope <dir>.
+//	[@repo]//<dir>           Short for //<dir>:<base name of dir>.
+//	[@repo]//<dir>:all       Every target of the scope <dir>.
+//	[@repo]//<dir>/...       Every target below <dir>.
+//	:<name>                  A target of the scope containing the current
+//	                             directory.
+//
+// A scope is any directory of a repo; its targets are the target packages
+// directly inside it or inside its "targets" subdirectory.  Without a repo,
+// labels refer to the local repo.
+const TARGET_LABEL_ROOT = "//"
+const TARGET_LABEL_RECURSIVE = "..."
+
+func isTargetLabel(name string) bool {
+	return strings.HasPrefix(name, ":") ||
+		strings.Contains(name, TARGET_LABEL_ROOT)
+}
+
+// A parsed target label.
+type targetLabel struct {
+	repo      interfaces.RepoInterface
+	scope     string
+	name      string
+	recursive bool
+}
+
+func parseTargetLabel(label string) (*targetLabel, error) {
+	proj := project.GetProject()
+	tl := &targetLabel{repo: proj.LocalRepo()}
+
+	if strings.HasPrefix(label, ":") {
+		// Relative to the scope containing the current directory.
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+		rel, err := filepath.Rel(proj.Path(), wd)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return nil, util.FmtNewtError("Target label \"%s\" used "+
+				"outside of the project", label)
+		}
+		tl.scope = filepath.ToSlash(rel)
+		if tl.scope == "." {
+			tl.scope = ""
+		}
+		tl.name = label[1:]
+	} else {
+		idx := strings.Index(label, TARGET_LABEL_ROOT)
+		if idx > 0 {
+			if !strings.HasPrefix(label, "@") {
+				return nil, util.FmtNewtError("Invalid target label: %s",
+					label)
+			}
+			repoName := label[1:idx]
+			r := proj.FindRepo(repoName)
+			if r == nil {
+				return nil, util.FmtNewtError("Target label \"%s\" refers "+
+					"to unknown repo \"%s\"", label, repoName)
+			}
+			tl.repo = r
+		}
+		rest := label[idx+len(TARGET_LABEL_ROOT):]
+
+		scope := rest
+		if colon := strings.LastIndex(rest, ":"); colon >= 0 {
+			scope = rest[:colon]
+			tl.name = rest[colon+1:]
+		}
+		scope = strings.Trim(scope, "/")
+
+		if scope == TARGET_LABEL_RECURSIVE ||
+			strings.HasSuffix(scope, "/"+TARGET_LABEL_RECURSIVE) {
+
+			if tl.name != "" {
+				return nil, util.FmtNewtError("Invalid target label "+
+					"\"%s\"; a recursive label can't name a target", label)
+			}
+			tl.recursive = true
+			scope = strings.TrimSuffix(
+				strings.TrimSuffix(scope, TARGET_LABEL_RECURSIVE), "/")
+		} else if tl.name == "" {
+			if scope == "" {
+				return nil, util.FmtNewtError("Invalid target label "+
+					"\"%s\"; must name a target", label)
+			}
+			tl.name = filepath.Base(scope)
+		}
+		tl.scope = scope
+	}
+
+	if !tl.recursive && (tl.name == "" || strings.Contains(tl.name, "/")) {
+		return nil, util.FmtNewtError("Invalid target label: %s", label)
+	}
+
+	return tl, nil
+}
+
+// Returns the path of the target's package relative to its repo, with
+// forward slashes.
+func targetRepoPath(t *target.Target) (interfaces.RepoInterface, string) {
+	lpkg := t.Package()
+	rel, err := filepath.Rel(lpkg.Repo().Path(), lpkg.BasePath())
+	if err != nil {
+		return lpkg.Repo(), ""
+	}
+	return lpkg.Repo(), filepath.ToSlash(rel)
+}
+
+// Indicates whether the specified repo-relative target path is in the label's
+// scope, and if so, returns the target's name within the scope.
+func (tl *targetLabel) scopeName(path string) (string, bool) {
+	dir := filepath.ToSlash(filepath.Dir(path))
+	if dir == "." {
+		dir = ""
+	}
+
+	if tl.recursive {
+		if tl.scope == "" || dir == tl.scope ||
+			strings.HasPrefix(dir, tl.scope+"/") {
+
+			return filepath.Base(path), true
+		}
+		return "", false
+	}
+
+	scopeTargets := TARGET_DEFAULT_DIR
+	if tl.scope != "" {
+		scopeTargets = tl.scope + "/" + TARGET_DEFAULT_DIR
+	}
+	if dir == tl.scope || dir == scopeTargets {
+		return filepath.Base(path), true
+	}
+
+	return "", false
+}
+
+// Returns the targets selected by the specified label, sorted by name.
+func ResolveTargetLabel(label string) ([]*target.Target, error) {
+	tl, err := parseTargetLabel(label)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for fullName, t := range target.GetTargets() {
+		repo, path := targetRepoPath(t)
+		if repo != tl.repo || path == "" {
+			continue
+		}
+
+		name, ok := tl.scopeName(path)
+		if !ok {
+			continue
+		}
+		if tl.recursive || tl.name == TARGET_KEYWORD_ALL || tl.name == name {
+			names = append(names, fullName)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, util.FmtNewtError("No targets match label: %s", label)
+	}
+
+	// A target may be both in the scope and in its "targets" directory.
+	if !tl.recursive && tl.name != TARGET_KEYWORD_ALL && len(names) > 1 {
+		sort.Strings(names)
+		return nil, util.FmtNewtError("Target label \"%s\" is ambiguous; "+
+			"matches %s", label, strings.Join(names, ", "))
+	}
+
+	sort.Strings(names)
+	targets := make([]*target.Target, len(names))
+	for i, name := range names {
+		targets[i] = target.GetTargets()[name]
+	}
+
+	return targets, nil
+}
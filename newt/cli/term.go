@@ -0,0 +1,190 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Keys recognized by termKeyReader.
+const (
+	termKeyNone = iota
+	termKeyRune
+	termKeyUp
+	termKeyDown
+	termKeyLeft
+	termKeyRight
+	termKeyPgUp
+	termKeyPgDn
+	termKeyHome
+	termKeyEnd
+	termKeyEnter
+	termKeyEsc
+	termKeyBackspace
+	termKeyCtrlC
+)
+
+type termKey struct {
+	Code int
+	Rune rune
+}
+
+var termEscSeqs = []struct {
+	seq  string
+	code int
+}{
+	{"[A", termKeyUp},
+	{"OA", termKeyUp},
+	{"[B", termKeyDown},
+	{"OB", termKeyDown},
+	{"[C", termKeyRight},
+	{"OC", termKeyRight},
+	{"[D", termKeyLeft},
+	{"OD", termKeyLeft},
+	{"[5~", termKeyPgUp},
+	{"[6~", termKeyPgDn},
+	{"[H", termKeyHome},
+	{"OH", termKeyHome},
+	{"[1~", termKeyHome},
+	{"[F", termKeyEnd},
+	{"OF", termKeyEnd},
+	{"[4~", termKeyEnd},
+}
+
+// Decodes keypresses read from a terminal in raw mode.  A single read may
+// return several keys (e.g., when a key is held down), so unconsumed input is
+// buffered.
+type termKeyReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func newTermKeyReader(r io.Reader) *termKeyReader {
+	return &termKeyReader{r: r}
+}
+
+// Decodes an escape sequence at the start of the buffer.  Terminals send an
+// escape sequence in a single write, so an ESC byte that isn't followed by a
+// sequence is the escape key itself.
+func (kr *termKeyReader) escSeq() termKey {
+	rest := string(kr.buf[1:])
+	for _, es := range termEscSeqs {
+		if strings.HasPrefix(rest, es.seq) {
+			kr.buf = kr.buf[1+len(es.seq):]
+			return termKey{Code: es.code}
+		}
+	}
+
+	if len(rest) == 0 || (rest[0] != '[' && rest[0] != 'O') {
+		kr.buf = kr.buf[1:]
+		return termKey{Code: termKeyEsc}
+	}
+
+	// Unrecognized sequence; skip through its final byte.
+	for i := 2; i < len(kr.buf); i++ {
+		if kr.buf[i] >= 0x40 && kr.buf[i] <= 0x7e {
+			kr.buf = kr.buf[i+1:]
+			return termKey{}
+		}
+	}
+	kr.buf = nil
+	return termKey{}
+}
+
+// Reads the next keypress.
+func (kr *termKeyReader) ReadKey() (termKey, error) {
+	if len(kr.buf) == 0 {
+		buf := make([]byte, 64)
+		n, err := kr.r.Read(buf)
+		if err != nil {
+			return termKey{}, err
+		}
+		kr.buf = buf[:n]
+	}
+
+	switch kr.buf[0] {
+	case 0x1b:
+		return kr.escSeq(), nil
+
+	case '\r', '\n':
+		kr.buf = kr.buf[1:]
+		return termKey{Code: termKeyEnter}, nil
+
+	case 0x7f, 0x08:
+		kr.buf = kr.buf[1:]
+		return termKey{Code: termKeyBackspace}, nil
+
+	case 0x03:
+		kr.buf = kr.buf[1:]
+		return termKey{Code: termKeyCtrlC}, nil
+	}
+
+	c, size := utf8.DecodeRune(kr.buf)
+	kr.buf = kr.buf[size:]
+	if c == utf8.RuneError || c < ' ' {
+		return termKey{}, nil
+	}
+
+	return termKey{Code: termKeyRune, Rune: c}, nil
+}
+
+// Pads or truncates a string to exactly the specified number of columns.
+func termFit(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+
+	for len(runes) < width {
+		runes = append(runes, ' ')
+	}
+
+	return string(runes)
+}
+
+// Splits text into lines of at most the specified width, breaking at spaces
+// where possible.
+func termWrap(text string, width int) []string {
+	lines := []string{}
+	runes := []rune(text)
+	for len(runes) > width {
+		brk := width
+		for i := width; i > 0; i-- {
+			if runes[i] == ' ' {
+				brk = i
+				break
+			}
+		}
+
+		lines = append(lines, string(runes[:brk]))
+		runes = runes[brk:]
+		for len(runes) > 0 && runes[0] == ' ' {
+			runes = runes[1:]
+		}
+	}
+
+	if len(runes) > 0 {
+		lines = append(lines, string(runes))
+	}
+
+	return lines
+}
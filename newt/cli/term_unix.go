@@ -0,0 +1,106 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+type termState struct {
+	termios syscall.Termios
+}
+
+func termIoctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// Indicates whether both stdin and stdout are attached to a terminal.
+func termIsInteractive() bool {
+	var termios syscall.Termios
+
+	for _, f := range []*os.File{os.Stdin, os.Stdout} {
+		if termIoctl(f.Fd(), ioctlGetTermios,
+			unsafe.Pointer(&termios)) != nil {
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// Puts the terminal into raw mode: input is delivered a byte at a time,
+// without echo or signal generation.  Returns the state to restore.
+func termMakeRaw() (*termState, error) {
+	st := &termState{}
+	fd := os.Stdin.Fd()
+	if err := termIoctl(fd, ioctlGetTermios,
+		unsafe.Pointer(&st.termios)); err != nil {
+
+		return nil, err
+	}
+
+	raw := st.termios
+	raw.Iflag &^= syscall.ICRNL | syscall.INLCR | syscall.IGNCR |
+		syscall.IXON | syscall.ISTRIP
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON |
+		syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	if err := termIoctl(fd, ioctlSetTermios, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+func termRestore(st *termState) error {
+	return termIoctl(os.Stdin.Fd(), ioctlSetTermios,
+		unsafe.Pointer(&st.termios))
+}
+
+// Returns the width and height of the terminal, in characters.
+func termSize() (int, int) {
+	var ws struct {
+		Row    uint16
+		Col    uint16
+		Xpixel uint16
+		Ypixel uint16
+	}
+
+	if err := termIoctl(os.Stdout.Fd(), syscall.TIOCGWINSZ,
+		unsafe.Pointer(&ws)); err != nil || ws.Col == 0 || ws.Row == 0 {
+
+		return 80, 24
+	}
+
+	return int(ws.Col), int(ws.Row)
+}
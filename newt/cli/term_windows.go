@@ -0,0 +1,122 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	consoleEnableProcessedInput = 0x0001
+	consoleEnableLineInput      = 0x0002
+	consoleEnableEchoInput      = 0x0004
+	consoleEnableVtInput        = 0x0200
+	consoleEnableVtProcessing   = 0x0004
+)
+
+var (
+	kernel32                       = syscall.NewLazyDLL("kernel32.dll")
+	procSetConsoleMode             = kernel32.NewProc("SetConsoleMode")
+	procGetConsoleScreenBufferInfo = kernel32.NewProc(
+		"GetConsoleScreenBufferInfo")
+)
+
+type termState struct {
+	inMode  uint32
+	outMode uint32
+}
+
+func setConsoleMode(h syscall.Handle, mode uint32) error {
+	r, _, err := procSetConsoleMode.Call(uintptr(h), uintptr(mode))
+	if r == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// Indicates whether both stdin and stdout are attached to a console.
+func termIsInteractive() bool {
+	var mode uint32
+
+	for _, f := range []*os.File{os.Stdin, os.Stdout} {
+		if syscall.GetConsoleMode(syscall.Handle(f.Fd()), &mode) != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Puts the console into raw mode and enables VT escape sequence handling
+// for both input and output.  Returns the state to restore.
+func termMakeRaw() (*termState, error) {
+	in := syscall.Handle(os.Stdin.Fd())
+	out := syscall.Handle(os.Stdout.Fd())
+
+	st := &termState{}
+	if err := syscall.GetConsoleMode(in, &st.inMode); err != nil {
+		return nil, err
+	}
+	if err := syscall.GetConsoleMode(out, &st.outMode); err != nil {
+		return nil, err
+	}
+
+	inMode := st.inMode &^ (consoleEnableProcessedInput |
+		consoleEnableLineInput | consoleEnableEchoInput)
+	if err := setConsoleMode(in, inMode|consoleEnableVtInput); err != nil {
+		return nil, err
+	}
+
+	if err := setConsoleMode(out,
+		st.outMode|consoleEnableVtProcessing); err != nil {
+
+		setConsoleMode(in, st.inMode)
+		return nil, err
+	}
+
+	return st, nil
+}
+
+func termRestore(st *termState) error {
+	setConsoleMode(syscall.Handle(os.Stdout.Fd()), st.outMode)
+	return setConsoleMode(syscall.Handle(os.Stdin.Fd()), st.inMode)
+}
+
+// Returns the width and height of the console window, in characters.
+func termSize() (int, int) {
+	var info struct {
+		SizeX, SizeY             int16
+		CursorX, CursorY         int16
+		Attributes               uint16
+		Left, Top, Right, Bottom int16
+		MaxSizeX, MaxSizeY       int16
+	}
+
+	r, _, _ := procGetConsoleScreenBufferInfo.Call(os.Stdout.Fd(),
+		uintptr(unsafe.Pointer(&info)))
+	if r == 0 {
+		return 80, 24
+	}
+
+	return int(info.Right-info.Left) + 1, int(info.Bottom-info.Top) + 1
+}
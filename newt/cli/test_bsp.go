@@ -0,0 +1,167 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+// BSPs to run the unit tests against instead of the unit test target's;
+// each test package is built and run once per BSP.
+var testBsps []string
+
+// Resolves a --bsp argument to a BSP package.  Besides a package name, the
+// last element of a BSP's name is accepted (e.g., "native" for
+// "@apache-mynewt-core/hw/bsp/native") if it is unambiguous.
+func resolveTestBsp(name string) (*pkg.LocalPackage, error) {
+	proj := TryGetProject()
+
+	if lpkg, err := proj.ResolvePackage(proj.LocalRepo(), name); err == nil {
+		if lpkg.Type() != pkg.PACKAGE_TYPE_BSP {
+			return nil, util.FmtNewtError("Package %s is not a BSP",
+				lpkg.FullName())
+		}
+		return lpkg, nil
+	}
+
+	matches := []*pkg.LocalPackage{}
+	for _, p := range proj.PackagesOfType(pkg.PACKAGE_TYPE_BSP) {
+		lpkg := p.(*pkg.LocalPackage)
+		if filepath.Base(lpkg.Name()) == name {
+			matches = append(matches, lpkg)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, util.FmtNewtError("Unknown BSP: %s", name)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, util.FmtNewtError("Ambiguous BSP \"%s\"; matches: %s",
+			name, PackageNameList(pkg.SortLclPkgs(matches)))
+	}
+}
+
+// Resolves the --bsp arguments to the full names of the BSP packages.  Names
+// are used rather than packages since the project is reloaded between
+// tests.
+func resolveTestBsps() ([]string, error) {
+	names := []string{}
+	for _, arg := range testBsps {
+		lpkg, err := resolveTestBsp(arg)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, lpkg.FullName())
+	}
+
+	return util.UniqueStrings(names), nil
+}
+
+// Returns the target used to build the specified test package for a BSP: a
+// copy of the base unit test target that uses the BSP, with a name unique to
+// the BSP and package.
+func resolveBspUnittest(bspName string, pkgName string) (*target.Target,
+	error) {
+
+	baseTarget := ResolveTarget(TARGET_TEST_NAME)
+	if baseTarget == nil {
+		return nil, util.FmtNewtError("Can't find unit test target: %s",
+			TARGET_TEST_NAME)
+	}
+
+	targetName := fmt.Sprintf("%s/%s/%s/%s",
+		TARGET_DEFAULT_DIR, TARGET_TEST_NAME,
+		builder.TestTargetName(strings.TrimPrefix(bspName, "@")),
+		builder.TestTargetName(pkgName))
+
+	t := ResolveTarget(targetName)
+	if t == nil {
+		targetName, err := ResolveNewTargetName(targetName)
+		if err != nil {
+			return nil, err
+		}
+
+		t = baseTarget.Clone(TryGetProject().LocalRepo(), targetName)
+
+		// The clone shares the base target's variables.
+		vars := make(map[string]string, len(t.Vars))
+		for k, v := range t.Vars {
+			vars[k] = v
+		}
+		vars["target.bsp"] = bspName
+		t.Vars = vars
+		t.BspName = bspName
+	}
+
+	return t, nil
+}
+
+// Prints the number of passing and failing test packages for each BSP.
+func printBspSummary(results []*unitTestResult) {
+	type bspCounts struct {
+		passed int
+		failed []string
+	}
+
+	counts := map[string]*bspCounts{}
+	for _, r := range results {
+		c := counts[r.Bsp]
+		if c == nil {
+			c = &bspCounts{}
+			counts[r.Bsp] = c
+		}
+		if r.Passed() {
+			c.passed++
+		} else {
+			c.failed = append(c.failed, r.Pkg.Name())
+		}
+	}
+
+	bsps := make([]string, 0, len(counts))
+	width := len("BSP")
+	for bsp := range counts {
+		bsps = append(bsps, bsp)
+		if len(bsp) > width {
+			width = len(bsp)
+		}
+	}
+	sort.Strings(bsps)
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "\nResults by BSP:\n")
+	for _, bsp := range bsps {
+		c := counts[bsp]
+		line := fmt.Sprintf("    %-*s %4d passed %4d failed", width, bsp,
+			c.passed, len(c.failed))
+		if len(c.failed) > 0 {
+			line += fmt.Sprintf(": [%s]", strings.Join(c.failed, " "))
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s\n", line)
+	}
+}
@@ -0,0 +1,150 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+// Hardware target to run the tests on; empty means run them in the
+// simulator.
+var testHwTarget string
+
+// Where the device's console output is read from.
+var testConsole builder.TestConsole
+
+var testDonePattern string
+
+// Used when --timeout is not specified; a device that hangs would otherwise
+// block the run forever.
+const HW_TEST_DEFAULT_TIMEOUT = 60 * time.Second
+
+// Returns the target used to build the specified test package for hardware.
+// Like the simulator's unit test targets, it is an in-memory copy of the
+// base target with a name unique to the package.
+func resolveHwUnittest(base *target.Target, pkgName string) (*target.Target,
+	error) {
+
+	targetName := fmt.Sprintf("%s/%s", base.FullName(),
+		builder.TestTargetName(pkgName))
+
+	t := ResolveTarget(targetName)
+	if t == nil {
+		targetName, err := ResolveNewTargetName(targetName)
+		if err != nil {
+			return nil, err
+		}
+
+		t = base.Clone(TryGetProject().LocalRepo(), targetName)
+	}
+
+	return t, nil
+}
+
+func validateHwTestFlags() error {
+	if testParallel > 1 {
+		return util.NewNewtError("--parallel cannot be used with --target")
+	}
+	if testCoverage {
+		return util.NewNewtError("--coverage cannot be used with --target")
+	}
+	if len(testSanitizers()) > 0 {
+		return util.NewNewtError(
+			"Sanitizers cannot be used with --target")
+	}
+	if testConsole.Device == "" && testConsole.Cmd == "" {
+		return util.NewNewtError("Testing on hardware requires --console " +
+			"or --console-cmd")
+	}
+
+	return nil
+}
+
+// Builds each package's tests into an image for the hardware target, loads
+// it onto the device and collects the results from the console.  Packages
+// are tested one at a time since they share the device.
+func runHwUnitTests(packs []*pkg.LocalPackage) []*unitTestResult {
+	if err := validateHwTestFlags(); err != nil {
+		NewtUsage(nil, err)
+	}
+
+	timeout := testTimeout
+	if timeout == 0 {
+		timeout = HW_TEST_DEFAULT_TIMEOUT
+	}
+
+	results := make([]*unitTestResult, len(packs))
+	for i, pack := range packs {
+		r := &unitTestResult{Pkg: pack}
+		results[i] = r
+
+		if err := ResetGlobalState(); err != nil {
+			NewtUsage(nil, err)
+		}
+
+		base := ResolveTarget(testHwTarget)
+		if base == nil {
+			NewtUsage(nil, util.NewNewtError("Unknown target: "+
+				testHwTarget))
+		}
+
+		t, err := resolveHwUnittest(base, pack.Name())
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+		r.LogPath = filepath.Join(builder.TargetBinDir(t.Name()),
+			TEST_LOG_FILENAME)
+
+		b, err := builder.NewTargetHwTester(t, pack)
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Building test package %s for %s\n", pack.FullName(),
+			base.FullName())
+
+		if err := b.Build(); err != nil {
+			r.BuildErr = err
+			util.StatusMessage(util.VERBOSITY_QUIET, "%s\n", err.Error())
+			continue
+		}
+
+		for attempt := 1; attempt <= testRetries+1; attempt++ {
+			r.Run = b.RunHwTest(&testConsole, testDonePattern, timeout)
+			r.Run.Filter(testFilterRe)
+			r.Run.Attempts = attempt
+			if r.Run.Err == nil {
+				break
+			}
+		}
+
+		r.saveLog()
+		reportUnitTest(r)
+	}
+
+	return results
+}
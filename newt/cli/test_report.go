@@ -0,0 +1,499 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+// Number of test executables to run concurrently.
+var testParallel int
+
+// Maximum run time of a single test executable; 0 means no limit.
+var testTimeout time.Duration
+
+// Number of times a failing test executable is rerun before it is reported
+// as a failure.
+var testRetries int
+
+// Output paths of the JUnit XML and TAP reports; empty means no report.
+var testJunitPath string
+var testTapPath string
+
+// Whether to instrument tests for gcov and produce coverage reports, and the
+// minimum line coverage percentage each tested package must reach.
+var testCoverage bool
+var testCoverageMin float64
+
+// Sanitizers to build the tests with, in addition to any the unit test
+// target specifies.
+var testAsan bool
+var testUbsan bool
+var testTsan bool
+
+// Only the test cases whose names match testFilter are considered.
+var testFilter string
+var testFilterRe *regexp.Regexp
+
+// Shard of the test packages to run, as "<index>/<count>"; empty means all.
+var testShard string
+
+// Parses a --shard value, returning the 1-based shard index and the number of
+// shards.
+func parseTestShard(val string) (int, int, error) {
+	parts := strings.Split(val, "/")
+	if len(parts) == 2 {
+		idx, err1 := strconv.Atoi(parts[0])
+		count, err2 := strconv.Atoi(parts[1])
+		if err1 == nil && err2 == nil && count >= 1 && idx >= 1 &&
+			idx <= count {
+
+			return idx, count, nil
+		}
+	}
+
+	return 0, 0, util.FmtNewtError(
+		"Invalid shard \"%s\"; must be <index>/<count> with "+
+			"1 <= index <= count", val)
+}
+
+// Returns the packages belonging to the specified shard.  Packages are
+// assigned round-robin in name order, so every machine running the same
+// command with a different index gets a disjoint, similarly sized subset.
+func shardUnitTests(packs []*pkg.LocalPackage, idx int,
+	count int) []*pkg.LocalPackage {
+
+	sorted := pkg.SortLclPkgs(packs)
+
+	shard := []*pkg.LocalPackage{}
+	for i, pack := range sorted {
+		if i%count == idx-1 {
+			shard = append(shard, pack)
+		}
+	}
+
+	return shard
+}
+
+func testSanitizers() []string {
+	names := []string{}
+	if testAsan {
+		names = append(names, builder.SANITIZER_ADDRESS)
+	}
+	if testUbsan {
+		names = append(names, builder.SANITIZER_UNDEFINED)
+	}
+	if testTsan {
+		names = append(names, builder.SANITIZER_THREAD)
+	}
+	return names
+}
+
+// Outcome of building and running a single unit test package.
+type unitTestResult struct {
+	Pkg      *pkg.LocalPackage
+	Bsp      string
+	ExePath  string
+	Env      []string
+	LogPath  string
+	BuildErr error
+	Run      builder.TestExeResult
+
+	// Build directory of the test target and source directory of the
+	// package under test; only used when collecting coverage.
+	BinDir string
+	SrcDir string
+
+	Coverage    *builder.CoverageReport
+	CoverageErr error
+}
+
+// Name used in reports: the package's full name, followed by the BSP if the
+// tests were run against several.
+func (r *unitTestResult) Name() string {
+	if r.Bsp == "" {
+		return r.Pkg.FullName()
+	}
+	return fmt.Sprintf("%s (%s)", r.Pkg.FullName(), r.Bsp)
+}
+
+// Name used in the pass / fail lists; contains no spaces.
+func (r *unitTestResult) SummaryName() string {
+	if r.Bsp == "" {
+		return r.Pkg.Name()
+	}
+	return r.Pkg.Name() + "@" + filepath.Base(r.Bsp)
+}
+
+func (r *unitTestResult) Passed() bool {
+	return r.BuildErr == nil && r.Run.Err == nil
+}
+
+func (r *unitTestResult) Flaky() bool {
+	return r.Passed() && r.Run.Attempts > 1
+}
+
+// Name of the file the test output is saved to.
+const TEST_LOG_FILENAME = "test.log"
+
+func (r *unitTestResult) saveLog() {
+	if err := ioutil.WriteFile(r.LogPath, r.Run.Output, 0644); err != nil {
+		util.StatusMessage(util.VERBOSITY_QUIET,
+			"* Warning: failed to write %s: %s\n", r.LogPath, err.Error())
+	}
+}
+
+// Runs the executables of all successfully built tests, spreading them over
+// testParallel workers.
+func runUnitTests(results []*unitTestResult) {
+	jobs := make(chan *unitTestResult, len(results))
+	for _, r := range results {
+		if r.BuildErr == nil {
+			jobs <- r
+		}
+	}
+	close(jobs)
+
+	workers := testParallel
+	if workers < 1 {
+		workers = 1
+	}
+
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				if testCoverage {
+					r.CoverageErr = builder.ResetCoverage(r.BinDir)
+				}
+
+				r.Run = builder.RunTestExe(r.ExePath, r.Env, testTimeout,
+					testRetries, testFilterRe)
+
+				if testCoverage && r.CoverageErr == nil {
+					r.CoverageErr = writeCoverage(r)
+				}
+
+				r.saveLog()
+
+				mtx.Lock()
+				reportUnitTest(r)
+				mtx.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func reportUnitTest(r *unitTestResult) {
+	name := r.Name()
+	secs := r.Run.Duration.Seconds()
+
+	switch {
+	case r.Flaky():
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"%s %s (%.2fs, passed on attempt %d)\n",
+			colorText(ANSI_YELLOW, "FLAKY"), name, secs, r.Run.Attempts)
+	case r.Passed():
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s %s (%.2fs)\n",
+			colorText(ANSI_GREEN, "PASS"), name, secs)
+	default:
+		util.StatusMessage(util.VERBOSITY_QUIET, "%s %s (%.2fs): %s\n",
+			colorText(ANSI_RED, "FAIL"), name, secs, r.Run.Err.Error())
+		util.StatusMessage(util.VERBOSITY_QUIET, "%s", r.Run.Output)
+		return
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "%s", r.Run.Output)
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	XMLName   xml.Name      `xml:"testcase"`
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr,omitempty"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+	SystemOut string          `xml:"system-out,omitempty"`
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+func junitSuite(r *unitTestResult) junitTestSuite {
+	name := r.Name()
+	suite := junitTestSuite{
+		Name:      name,
+		Time:      fmt.Sprintf("%.3f", r.Run.Duration.Seconds()),
+		SystemOut: string(r.Run.Output),
+	}
+
+	switch {
+	case r.BuildErr != nil:
+		suite.TestCases = []junitTestCase{{
+			ClassName: name,
+			Name:      "build",
+			Error: &junitFailure{
+				Message: "build failed",
+				Text:    r.BuildErr.Error(),
+			},
+		}}
+
+	case len(r.Run.Cases) == 0:
+		// The binary did not report individual cases; represent the whole
+		// package as a single case.
+		tc := junitTestCase{ClassName: name, Name: name, Time: suite.Time}
+		if r.Run.Err != nil {
+			tc.Failure = &junitFailure{
+				Message: r.Run.Err.Error(),
+				Text:    string(r.Run.Output),
+			}
+		}
+		suite.TestCases = []junitTestCase{tc}
+
+	default:
+		for _, c := range r.Run.Cases {
+			tc := junitTestCase{ClassName: name, Name: c.Name}
+			if !c.Passed {
+				tc.Failure = &junitFailure{Message: c.Message}
+			}
+			suite.TestCases = append(suite.TestCases, tc)
+		}
+
+		// A crash or timeout after the last reported case would otherwise
+		// go unnoticed.
+		if r.Run.Err != nil && r.Run.Cases[len(r.Run.Cases)-1].Passed {
+			suite.TestCases = append(suite.TestCases, junitTestCase{
+				ClassName: name,
+				Name:      name,
+				Error:     &junitFailure{Message: r.Run.Err.Error()},
+			})
+		}
+	}
+
+	suite.Tests = len(suite.TestCases)
+	for _, tc := range suite.TestCases {
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+		if tc.Error != nil {
+			suite.Errors++
+		}
+	}
+
+	return suite
+}
+
+func writeJunitReport(path string, results []*unitTestResult) error {
+	suites := junitTestSuites{}
+	for _, r := range results {
+		suites.Suites = append(suites.Suites, junitSuite(r))
+	}
+
+	b, err := xml.MarshalIndent(suites, "", "    ")
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	b = append([]byte(xml.Header), b...)
+	b = append(b, '\n')
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+func tapDiagnostic(buf *bytes.Buffer, text string) {
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		fmt.Fprintf(buf, "    %s\n", line)
+	}
+}
+
+func writeTapReport(path string, results []*unitTestResult) error {
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintf(buf, "TAP version 13\n")
+	fmt.Fprintf(buf, "1..%d\n", len(results))
+	for i, r := range results {
+		status := "ok"
+		if !r.Passed() {
+			status = "not ok"
+		}
+		fmt.Fprintf(buf, "%s %d - %s\n", status, i+1, r.Name())
+
+		for _, c := range r.Run.Cases {
+			caseStatus := "pass"
+			if !c.Passed {
+				caseStatus = "FAIL"
+			}
+			fmt.Fprintf(buf, "# [%s] %s\n", caseStatus, c.Name)
+		}
+
+		if r.Passed() && !r.Flaky() {
+			continue
+		}
+
+		fmt.Fprintf(buf, "  ---\n")
+		fmt.Fprintf(buf, "  duration_ms: %d\n",
+			r.Run.Duration.Nanoseconds()/int64(time.Millisecond))
+		fmt.Fprintf(buf, "  attempts: %d\n", r.Run.Attempts)
+		switch {
+		case r.BuildErr != nil:
+			fmt.Fprintf(buf, "  message: build failed\n")
+			fmt.Fprintf(buf, "  output: |\n")
+			tapDiagnostic(buf, r.BuildErr.Error())
+		case r.Run.Err != nil:
+			fmt.Fprintf(buf, "  message: %q\n", r.Run.Err.Error())
+			fmt.Fprintf(buf, "  timed_out: %t\n", r.Run.TimedOut)
+			if len(r.Run.Output) > 0 {
+				fmt.Fprintf(buf, "  output: |\n")
+				tapDiagnostic(buf, string(r.Run.Output))
+			}
+		default:
+			fmt.Fprintf(buf, "  message: flaky\n")
+		}
+		fmt.Fprintf(buf, "  ...\n")
+	}
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+func (r *unitTestResult) coverageDir() string {
+	return filepath.Join(filepath.Dir(r.ExePath), builder.COVERAGE_DIR_NAME)
+}
+
+// Collects the coverage data produced by a test run and writes the lcov and
+// HTML reports to the package's coverage directory.
+func writeCoverage(r *unitTestResult) error {
+	gcovPath, err := exec.LookPath("gcov")
+	if err != nil {
+		return util.NewNewtError("Coverage requires gcov in PATH").WithClass(
+			util.ERR_CLASS_TOOL_MISSING)
+	}
+
+	cr, err := builder.CollectCoverage(gcovPath, r.BinDir, r.SrcDir)
+	if err != nil {
+		return err
+	}
+	r.Coverage = cr
+
+	dir := r.coverageDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	name := r.Pkg.FullName()
+	if err := cr.WriteLcov(filepath.Join(dir,
+		builder.COVERAGE_INFO_FILENAME), name); err != nil {
+
+		return err
+	}
+	if err := cr.WriteHtml(filepath.Join(dir,
+		builder.COVERAGE_HTML_FILENAME), name); err != nil {
+
+		return err
+	}
+
+	return nil
+}
+
+// Prints a table of per-package line coverage.  Returns the packages that
+// fall short of testCoverageMin.
+func printCoverageSummary(results []*unitTestResult) []*pkg.LocalPackage {
+	below := []*pkg.LocalPackage{}
+
+	nameWidth := len("Package")
+	for _, r := range results {
+		if len(r.Name()) > nameWidth {
+			nameWidth = len(r.Name())
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "\nCoverage:\n")
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "    %-*s %15s %8s\n",
+		nameWidth, "Package", "Lines", "Percent")
+	for _, r := range results {
+		name := r.Name()
+		switch {
+		case r.BuildErr != nil:
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "    %-*s %s\n",
+				nameWidth, name, "(build failed)")
+			continue
+		case r.CoverageErr != nil:
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "    %-*s %s\n",
+				nameWidth, name, colorText(ANSI_RED, r.CoverageErr.Error()))
+			below = append(below, r.Pkg)
+			continue
+		}
+
+		cr := r.Coverage
+		pct := fmt.Sprintf("%7.1f%%", cr.Percent())
+		if cr.Percent() < testCoverageMin {
+			pct = colorText(ANSI_RED, pct)
+			below = append(below, r.Pkg)
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "    %-*s %15s %s\n",
+			nameWidth, name,
+			fmt.Sprintf("%d/%d", cr.LinesHit(), cr.LinesFound()), pct)
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "        %s\n",
+			r.coverageDir())
+	}
+
+	return below
+}
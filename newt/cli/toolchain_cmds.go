@@ -0,0 +1,241 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
+)
+
+var toolchainUrl string
+var toolchainSha256 string
+
+type toolchainStatus struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Installed bool   `json:"installed"`
+	Dir       string `json:"dir,omitempty"`
+
+	// The executable found in PATH, if any.
+	Path string `json:"path,omitempty"`
+}
+
+// Determines the toolchain and archive to install from a
+// "<name>[@<version>]" argument.
+func toolchainInstallSpec(proj *project.Project, arg string) (
+	string, string, project.ToolchainArchive, error) {
+
+	var archive project.ToolchainArchive
+
+	name := arg
+	version := ""
+	if i := strings.Index(arg, "@"); i >= 0 {
+		name = arg[:i]
+		version = arg[i+1:]
+	}
+
+	pin := proj.ToolchainPin(name)
+	if version == "" {
+		if pin == nil {
+			return "", "", archive, util.FmtNewtError(
+				"No version specified for %s, and the project does not "+
+					"pin one", name)
+		}
+		version = pin.Version
+	}
+
+	if toolchainUrl != "" {
+		if toolchainSha256 == "" {
+			return "", "", archive, util.NewNewtError(
+				"--url requires --sha256")
+		}
+		archive.Url = toolchainUrl
+		archive.Sha256 = toolchainSha256
+		return name, version, archive, nil
+	}
+
+	if pin == nil || pin.Version != version {
+		return "", "", archive, util.FmtNewtError(
+			"Project does not pin %s@%s; specify its archive with "+
+				"--url and --sha256", name, version)
+	}
+
+	archive, err := pin.HostArchive()
+	if err != nil {
+		return "", "", archive, err
+	}
+
+	return name, version, archive, nil
+}
+
+func toolchainInstallCmd(cmd *cobra.Command, args []string) {
+	proj := TryGetProject()
+
+	if len(args) == 0 {
+		for _, pin := range proj.ToolchainPins() {
+			args = append(args, pin.Name+"@"+pin.Version)
+		}
+		if len(args) == 0 {
+			NewtUsage(cmd, util.NewNewtError(
+				"Must specify a toolchain; the project does not pin any"))
+		}
+	}
+	if len(args) > 1 && toolchainUrl != "" {
+		NewtUsage(cmd, util.NewNewtError(
+			"--url can only be used when installing a single toolchain"))
+	}
+
+	for _, arg := range args {
+		name, version, archive, err := toolchainInstallSpec(proj, arg)
+		if err != nil {
+			NewtUsage(cmd, err)
+		}
+
+		dir := project.ToolchainInstallDir(name, version)
+		if util.NodeExist(dir) {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"%s %s is already installed in %s\n", name, version, dir)
+			continue
+		}
+
+		dir, err = project.InstallToolchain(name, version, archive)
+		if err != nil {
+			NewtUsage(nil, err)
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Installed %s %s in %s\n", name, version, dir)
+	}
+}
+
+func toolchainListCmd(cmd *cobra.Command, args []string) {
+	proj := TryGetProject()
+
+	statuses := []toolchainStatus{}
+	for _, pin := range proj.ToolchainPins() {
+		st := toolchainStatus{
+			Name:    pin.Name,
+			Version: pin.Version,
+		}
+
+		dir := project.ToolchainInstallDir(pin.Name, pin.Version)
+		if util.NodeExist(dir) {
+			st.Installed = true
+			st.Dir = dir
+		}
+		if path, err := exec.LookPath(pin.Name); err == nil {
+			st.Path = path
+		}
+
+		statuses = append(statuses, st)
+	}
+
+	if newtutil.NewtJson {
+		printJson(statuses)
+		return
+	}
+
+	if len(statuses) == 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Project does not pin any toolchains\n")
+		return
+	}
+
+	for _, st := range statuses {
+		state := colorText(ANSI_RED, "not installed")
+		if st.Installed {
+			state = colorText(ANSI_GREEN, "installed")
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "%s@%s: %s\n",
+			st.Name, st.Version, state)
+
+		if st.Path != "" {
+			util.StatusMessage(util.VERBOSITY_VERBOSE, "    in PATH: %s\n",
+				st.Path)
+		}
+		if st.Installed && st.Path != "" &&
+			filepath.Dir(filepath.Dir(st.Path)) != st.Dir {
+
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"    * Warning: %s in PATH is not the installed "+
+					"toolchain\n", st.Name)
+		}
+	}
+}
+
+func AddToolchainCommands(cmd *cobra.Command) {
+	toolchainCmd := &cobra.Command{
+		Use:   "toolchain",
+		Short: "Install and inspect pinned toolchains",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Usage()
+		},
+	}
+
+	cmd.AddCommand(toolchainCmd)
+
+	installHelpText := "Download a toolchain archive, verify its SHA-256 " +
+		"checksum and extract it into ~/.newt/toolchains (or " +
+		"$NEWT_TOOLCHAINS_DIR).  Installed toolchains that the project " +
+		"pins are put at the front of PATH whenever newt runs in the " +
+		"project.\n\n" +
+		"Toolchains are pinned in project.yml:\n\n" +
+		"    toolchain.pins:\n" +
+		"        arm-none-eabi-gcc:\n" +
+		"            version: \"12.2\"\n" +
+		"            archives:\n" +
+		"                linux-amd64:\n" +
+		"                    url: \"https://example.com/gcc-12.2.tar.xz\"\n" +
+		"                    sha256: \"<hex digest>\"\n\n" +
+		"Builds fail if the pinned compiler in PATH reports a different " +
+		"version.  Without arguments, every pinned toolchain is installed.  " +
+		"Toolchains the project does not pin can be installed with --url " +
+		"and --sha256."
+	installHelpEx := "  newt toolchain install\n"
+	installHelpEx += "  newt toolchain install arm-none-eabi-gcc@12.2\n"
+
+	installCmd := &cobra.Command{
+		Use:     "install [<name>[@<version>]...]",
+		Short:   "Download and install a toolchain",
+		Long:    installHelpText,
+		Example: installHelpEx,
+		Run:     toolchainInstallCmd,
+	}
+
+	installCmd.Flags().StringVarP(&toolchainUrl, "url", "", "",
+		"URL of the toolchain archive")
+	installCmd.Flags().StringVarP(&toolchainSha256, "sha256", "", "",
+		"Expected SHA-256 of the toolchain archive")
+
+	toolchainCmd.AddCommand(installCmd)
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the project's pinned toolchains",
+		Run:   toolchainListCmd,
+	}
+
+	toolchainCmd.AddCommand(listCmd)
+}
@@ -22,10 +22,12 @@ package cli
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
@@ -44,11 +46,62 @@ const TARGET_KEYWORD_ALL string = "all"
 const TARGET_DEFAULT_DIR string = "targets"
 const MFG_DEFAULT_DIR string = "mfgs"
 
+const (
+	ANSI_RED    = "31"
+	ANSI_GREEN  = "32"
+	ANSI_YELLOW = "33"
+)
+
+// Wraps text in the specified ANSI color code if color output is enabled.
+func colorText(color string, text string) string {
+	if !newtutil.NewtColor {
+		return text
+	}
+
+	return "\x1b[" + color + "m" + text + "\x1b[0m"
+}
+
+// The error report written to stderr with --json-errors.
+type jsonError struct {
+	Class    string `json:"class"`
+	ExitCode int    `json:"exit_code"`
+	Message  string `json:"message"`
+	Command  string `json:"command,omitempty"`
+}
+
+func printJsonError(cmd *cobra.Command, err error) {
+	je := jsonError{
+		Class:    util.ErrClass(err),
+		ExitCode: util.ExitCode(err),
+		Message:  strings.TrimSpace(err.Error()),
+	}
+	if cmd != nil {
+		je.Command = cmd.CommandPath()
+	}
+
+	b, jerr := json.Marshal(map[string]interface{}{"error": je})
+	if jerr != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", b)
+}
+
 func NewtUsage(cmd *cobra.Command, err error) {
+	exitCode := 1
 	if err != nil {
-		sErr := err.(*util.NewtError)
-		log.Debugf("%s", sErr.StackTrace)
-		fmt.Fprintf(os.Stderr, "Error: %s\n", sErr.Text)
+		sErr, ok := err.(*util.NewtError)
+		if !ok {
+			sErr = util.ChildNewtError(err)
+		}
+		log.Debugf("%s\n%s", sErr.Text, sErr.StackTrace)
+		if newtutil.NewtJsonErrors {
+			printJsonError(cmd, sErr)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s %s\n",
+				colorText(ANSI_RED, "Error:"), sErr.Text)
+		}
+		exitCode = util.ExitCode(sErr)
 	}
 
 	if cmd != nil {
@@ -56,7 +109,7 @@ func NewtUsage(cmd *cobra.Command, err error) {
 		fmt.Printf("%s - ", cmd.Name())
 		cmd.Help()
 	}
-	os.Exit(1)
+	os.Exit(exitCode)
 }
 
 // Display help text with a max line width of 79 characters
@@ -83,6 +136,15 @@ func ResolveTarget(name string) *target.Target {
 	// completion is used to specify the name.
 	name = strings.TrimSuffix(name, "/")
 
+	// A label must select exactly one target.
+	if isTargetLabel(name) {
+		targets, err := ResolveTargetLabel(name)
+		if err != nil || len(targets) != 1 {
+			return nil
+		}
+		return targets[0]
+	}
+
 	targetMap := target.GetTargets()
 
 	// Check for fully-qualified name.
@@ -109,8 +171,76 @@ func ResolveTarget(name string) *target.Target {
 	return nil
 }
 
+// Resolves the target named by the first argument and creates a builder
+// for it; exits with a usage error on failure.
+func targetBuilderArg(cmd *cobra.Command,
+	args []string) *builder.TargetBuilder {
+
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+
+	TryGetProject()
+
+	t := ResolveTarget(args[0])
+	if t == nil {
+		NewtUsage(cmd, util.NewNewtError("Invalid target name: "+args[0]))
+	}
+
+	b, err := builder.NewTargetBuilder(t)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	return b
+}
+
+// Indicates whether the specified name is a glob pattern rather than a
+// literal name.
+func isGlob(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// Returns all targets matching the specified glob pattern, sorted by name.  A
+// target matches if the pattern matches its full name, its name, or its base
+// name (e.g., "nrf52-*" matches "targets/nrf52-blinky").
+func ResolveTargetGlob(pattern string) ([]*target.Target, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, util.FmtNewtError("Invalid target pattern: %s", pattern)
+	}
+
+	names := []string{}
+	for fullName, t := range target.GetTargets() {
+		if strings.HasSuffix(fullName, "/unittest") {
+			continue
+		}
+
+		for _, n := range []string{fullName, t.Name(), t.ShortName()} {
+			if ok, _ := filepath.Match(pattern, n); ok {
+				names = append(names, fullName)
+				break
+			}
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, util.FmtNewtError("No targets match pattern: %s",
+			pattern)
+	}
+
+	sort.Strings(names)
+	targets := make([]*target.Target, len(names))
+	for i, name := range names {
+		targets[i] = target.GetTargets()[name]
+	}
+
+	return targets, nil
+}
+
 // Resolves a list of target names and checks for the optional "all" keyword
-// among them.  Regardless of whether "all" is specified, all target names must
+// among them.  Names containing glob characters expand to all matching
+// targets, as do target labels (e.g., "//products/..."); see
+// ResolveTargetLabel().  Regardless of whether "all" is specified, all target names must
 // be valid, or an error is reported.
 //
 // @return                      targets, all (t/f), err
@@ -121,6 +251,18 @@ func ResolveTargetsOrAll(names ...string) ([]*target.Target, bool, error) {
 	for _, name := range names {
 		if name == "all" {
 			all = true
+		} else if isTargetLabel(name) {
+			matches, err := ResolveTargetLabel(name)
+			if err != nil {
+				return nil, false, err
+			}
+			targets = append(targets, matches...)
+		} else if isGlob(name) {
+			matches, err := ResolveTargetGlob(name)
+			if err != nil {
+				return nil, false, err
+			}
+			targets = append(targets, matches...)
 		} else {
 			t := ResolveTarget(name)
 			if t == nil {
@@ -132,7 +274,17 @@ func ResolveTargetsOrAll(names ...string) ([]*target.Target, bool, error) {
 		}
 	}
 
-	return targets, all, nil
+	// Overlapping patterns may match the same target more than once.
+	seen := map[*target.Target]bool{}
+	unique := []*target.Target{}
+	for _, t := range targets {
+		if !seen[t] {
+			seen[t] = true
+			unique = append(unique, t)
+		}
+	}
+
+	return unique, all, nil
 }
 
 func ResolveTargets(names ...string) ([]*target.Target, error) {
@@ -210,7 +362,8 @@ func TryGetProject() *project.Project {
 	}
 
 	for _, w := range p.Warnings() {
-		util.ErrorMessage(util.VERBOSITY_QUIET, "* Warning: %s\n", w)
+		util.ErrorMessage(util.VERBOSITY_QUIET, "%s %s\n",
+			colorText(ANSI_YELLOW, "* Warning:"), w)
 	}
 
 	return p
@@ -351,3 +504,14 @@ func PromptYesNo(dflt bool) bool {
 
 	return dflt
 }
+
+// Prints the specified object as indented JSON to stdout.  Used by
+// informational commands when --json is specified.
+func printJson(obj interface{}) {
+	data, err := json.MarshalIndent(obj, "", "    ")
+	if err != nil {
+		NewtUsage(nil, util.ChildNewtError(err))
+	}
+
+	fmt.Printf("%s\n", data)
+}
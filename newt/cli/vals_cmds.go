@@ -24,6 +24,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"mynewt.apache.org/newt/newt/newtutil"
 	"mynewt.apache.org/newt/util"
 )
 
@@ -42,6 +43,15 @@ func valsRunCmd(cmd *cobra.Command, args []string) {
 		allVals = append(allVals, vals)
 	}
 
+	if newtutil.NewtJson {
+		valsMap := map[string][]string{}
+		for i, vals := range allVals {
+			valsMap[args[i]] = vals
+		}
+		printJson(valsMap)
+		return
+	}
+
 	for i, vals := range allVals {
 		if i != 0 {
 			util.StatusMessage(util.VERBOSITY_DEFAULT, "\n")
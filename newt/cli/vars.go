@@ -95,6 +95,10 @@ func buildProfileValues() ([]string, error) {
 		}
 	}
 
+	for _, p := range project.GetProject().BuildProfiles() {
+		profileMap[p.Name] = struct{}{}
+	}
+
 	values := make([]string, 0, len(profileMap))
 	for k, _ := range profileMap {
 		values = append(values, k)
@@ -119,6 +123,9 @@ var varsMap = map[string]func() ([]string, error){
 	"lib": func() ([]string, error) {
 		return varsFromPackageType(pkg.PACKAGE_TYPE_LIB, true)
 	},
+	"prebuilt": func() ([]string, error) {
+		return varsFromPackageType(pkg.PACKAGE_TYPE_PREBUILT, true)
+	},
 	"sdk": func() ([]string, error) {
 		return varsFromPackageType(pkg.PACKAGE_TYPE_SDK, true)
 	},
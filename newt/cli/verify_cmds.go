@@ -0,0 +1,149 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+var verifyAgainst string
+var verifyUpdate bool
+var verifyIgnore []string
+
+func verifyBuildRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		NewtUsage(cmd, util.NewNewtError("Must specify target"))
+	}
+	if verifyAgainst == "" {
+		NewtUsage(cmd, util.NewNewtError("Must specify a golden manifest "+
+			"with --against"))
+	}
+
+	ignored := map[string]bool{}
+	for _, c := range verifyIgnore {
+		ignored[c] = true
+	}
+
+	checks := []string{}
+	for _, c := range builder.GoldenChecks {
+		if ignored[c] {
+			delete(ignored, c)
+		} else {
+			checks = append(checks, c)
+		}
+	}
+	for c, _ := range ignored {
+		NewtUsage(cmd, util.FmtNewtError("Invalid --ignore value \"%s\"; "+
+			"must be one of %s", c, strings.Join(builder.GoldenChecks, ", ")))
+	}
+
+	TryGetProject()
+
+	b, err := TargetBuilderForTargetOrUnittest(args[0])
+	if err != nil {
+		NewtUsage(cmd, err)
+	}
+
+	if err := b.Build(); err != nil {
+		NewtUsage(nil, err)
+	}
+
+	cur, err := b.GoldenManifest()
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	if verifyUpdate {
+		if err := cur.Write(verifyAgainst); err != nil {
+			NewtUsage(nil, err)
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Golden manifest written to %s\n", verifyAgainst)
+		return
+	}
+
+	golden, err := builder.ReadGoldenManifest(verifyAgainst)
+	if err != nil {
+		NewtUsage(nil, err)
+	}
+
+	diffs := golden.Diff(cur, checks)
+	if newtutil.NewtJson {
+		printJson(diffs)
+	} else {
+		for _, d := range diffs {
+			util.StatusMessage(util.VERBOSITY_QUIET, "    %s\n", d)
+		}
+	}
+
+	if len(diffs) > 0 {
+		NewtUsage(nil, util.FmtNewtError(
+			"Build of %s differs from %s (%d difference(s))",
+			cur.Target, verifyAgainst, len(diffs)))
+	}
+
+	if !newtutil.NewtJson {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Build of %s matches %s\n", cur.Target, verifyAgainst)
+	}
+}
+
+func AddVerifyCommands(cmd *cobra.Command) {
+	verifyHelpText := "Build a target and compare the result against a " +
+		"golden manifest: the hash of each image's binary, the size of " +
+		"each loadable elf section, and the resolved syscfg settings.  " +
+		"The command fails if anything differs, so CI can catch " +
+		"unexpected binary drift.\n\n" +
+		"Use --update to create or refresh the golden manifest from the " +
+		"current build, and --ignore to skip comparisons that are " +
+		"expected to change (e.g., the hash when only sizes are tracked)."
+	verifyHelpEx := "  newt verify-build my_target --against golden.json " +
+		"--update\n"
+	verifyHelpEx += "  newt verify-build my_target --against golden.json\n"
+	verifyHelpEx += "  newt verify-build my_target --against golden.json " +
+		"--ignore hash\n"
+
+	verifyCmd := &cobra.Command{
+		Use:     "verify-build <target-name>",
+		Short:   "Compare a target's build against a golden manifest",
+		Long:    verifyHelpText,
+		Example: verifyHelpEx,
+		Run:     verifyBuildRunCmd,
+	}
+
+	verifyCmd.Flags().StringVarP(&verifyAgainst, "against", "", "",
+		"Golden manifest to compare the build against")
+	verifyCmd.Flags().BoolVarP(&verifyUpdate, "update", "", false,
+		"Write the golden manifest from the current build instead of "+
+			"comparing")
+	verifyCmd.Flags().StringSliceVarP(&verifyIgnore, "ignore", "", nil,
+		"Properties not to compare: "+
+			strings.Join(builder.GoldenChecks, ", "))
+
+	cmd.AddCommand(verifyCmd)
+	AddTabCompleteFn(verifyCmd, func() []string {
+		return append(targetList(), unittestList()...)
+	})
+}
@@ -0,0 +1,308 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+// Package coredump decodes the core dumps mynewt's sys/coredump package
+// stores in flash, and converts them to ELF core files that GDB can load
+// alongside the executable that produced them.
+package coredump
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"mynewt.apache.org/newt/util"
+)
+
+const COREDUMP_MAGIC = 0x690c47c3
+
+const (
+	COREDUMP_TLV_IMAGE = 1
+	COREDUMP_TLV_MEM   = 2
+	COREDUMP_TLV_REGS  = 3
+)
+
+// Registers saved by the Cortex-M fault handler, in order.
+var RegNames = []string{
+	"r0", "r1", "r2", "r3", "r4", "r5", "r6", "r7", "r8", "r9", "r10",
+	"r11", "r12", "sp", "lr", "pc", "xpsr",
+}
+
+const (
+	REG_SP   = 13
+	REG_LR   = 14
+	REG_PC   = 15
+	REG_XPSR = 16
+)
+
+type MemRegion struct {
+	Addr uint32
+	Data []byte
+}
+
+type Coredump struct {
+	// Hash of the image that was running; empty if not recorded.
+	ImageHash []byte
+
+	Regs []uint32
+	Mem  []MemRegion
+}
+
+type memRegionSorter struct {
+	regions []MemRegion
+}
+
+func (s memRegionSorter) Len() int {
+	return len(s.regions)
+}
+func (s memRegionSorter) Swap(i, j int) {
+	s.regions[i], s.regions[j] = s.regions[j], s.regions[i]
+}
+func (s memRegionSorter) Less(i, j int) bool {
+	return s.regions[i].Addr < s.regions[j].Addr
+}
+
+// Parses a raw core dump.  Trailing data beyond the size recorded in the
+// header (e.g., the rest of the flash area) is ignored.
+func Parse(data []byte) (*Coredump, error) {
+	if len(data) < 8 {
+		return nil, util.NewNewtError("Core dump too short")
+	}
+
+	magic := binary.LittleEndian.Uint32(data[0:4])
+	size := binary.LittleEndian.Uint32(data[4:8])
+	if magic != COREDUMP_MAGIC {
+		return nil, util.NewNewtError("No core dump found (bad magic)")
+	}
+	if size < 8 || int(size) > len(data) {
+		return nil, util.FmtNewtError("Core dump size %d is invalid; only "+
+			"%d bytes available", size, len(data))
+	}
+
+	cd := &Coredump{}
+	off := 8
+	for off < int(size) {
+		if off+8 > int(size) {
+			return nil, util.FmtNewtError(
+				"Truncated core dump TLV at offset %d", off)
+		}
+
+		typ := data[off]
+		tlvLen := int(binary.LittleEndian.Uint16(data[off+2 : off+4]))
+		addr := binary.LittleEndian.Uint32(data[off+4 : off+8])
+		off += 8
+
+		if off+tlvLen > int(size) {
+			return nil, util.FmtNewtError(
+				"Truncated core dump TLV at offset %d", off-8)
+		}
+		val := data[off : off+tlvLen]
+		off += tlvLen
+
+		switch typ {
+		case COREDUMP_TLV_IMAGE:
+			cd.ImageHash = val
+		case COREDUMP_TLV_MEM:
+			cd.Mem = append(cd.Mem, MemRegion{Addr: addr, Data: val})
+		case COREDUMP_TLV_REGS:
+			if tlvLen%4 != 0 {
+				return nil, util.NewNewtError(
+					"Invalid core dump register area size")
+			}
+			cd.Regs = make([]uint32, tlvLen/4)
+			for i := range cd.Regs {
+				cd.Regs[i] = binary.LittleEndian.Uint32(val[i*4:])
+			}
+		default:
+			return nil, util.FmtNewtError(
+				"Unknown core dump TLV type %d", typ)
+		}
+	}
+
+	sort.Sort(memRegionSorter{cd.Mem})
+
+	return cd, nil
+}
+
+// Returns the register with the specified index, and whether it was saved.
+func (cd *Coredump) Reg(idx int) (uint32, bool) {
+	if idx >= len(cd.Regs) {
+		return 0, false
+	}
+	return cd.Regs[idx], true
+}
+
+// Reads memory captured in the core dump.  Returns false if any of the
+// requested bytes were not captured.
+func (cd *Coredump) ReadMem(addr uint32, size int) ([]byte, bool) {
+	for _, m := range cd.Mem {
+		if addr >= m.Addr &&
+			uint64(addr)+uint64(size) <= uint64(m.Addr)+uint64(len(m.Data)) {
+
+			start := int(addr - m.Addr)
+			return m.Data[start : start+size], true
+		}
+	}
+	return nil, false
+}
+
+func (cd *Coredump) ReadUint32(addr uint32) (uint32, bool) {
+	b, ok := cd.ReadMem(addr, 4)
+	if !ok {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(b), true
+}
+
+// Reads a NUL-terminated string of at most maxLen bytes.
+func (cd *Coredump) ReadString(addr uint32, maxLen int) (string, bool) {
+	var buf bytes.Buffer
+	for i := 0; i < maxLen; i++ {
+		b, ok := cd.ReadMem(addr+uint32(i), 1)
+		if !ok {
+			return "", false
+		}
+		if b[0] == 0 {
+			return buf.String(), true
+		}
+		buf.WriteByte(b[0])
+	}
+	return buf.String(), true
+}
+
+// Builds the NT_PRSTATUS note holding the registers.  The ARM elf_prstatus
+// places its 18 general registers (r0-r15, cpsr, orig_r0) after 72 bytes of
+// process information.
+func (cd *Coredump) prstatusNote() []byte {
+	var prstatus struct {
+		Info    [18]uint32
+		Regs    [18]uint32
+		FpValid uint32
+	}
+	copy(prstatus.Regs[:], cd.Regs)
+
+	name := []byte("CORE\x00\x00\x00\x00")
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, []uint32{
+		5, uint32(binary.Size(prstatus)), uint32(elf.NT_PRSTATUS),
+	})
+	buf.Write(name)
+	binary.Write(buf, binary.LittleEndian, prstatus)
+
+	return buf.Bytes()
+}
+
+// Writes the core dump as a 32-bit ARM ELF core file: a PT_NOTE segment with
+// the registers followed by a PT_LOAD segment per captured memory region.
+func (cd *Coredump) WriteElfCore(w io.Writer) error {
+	var hdr elf.Header32
+	var phdr elf.Prog32
+
+	segs := [][]byte{cd.prstatusNote()}
+	for _, m := range cd.Mem {
+		segs = append(segs, m.Data)
+	}
+
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	hdr.Ident[elf.EI_OSABI] = byte(elf.ELFOSABI_NONE)
+	hdr.Type = uint16(elf.ET_CORE)
+	hdr.Machine = uint16(elf.EM_ARM)
+	hdr.Version = uint32(elf.EV_CURRENT)
+	hdr.Phoff = uint32(binary.Size(hdr))
+	hdr.Ehsize = uint16(binary.Size(hdr))
+	hdr.Phentsize = uint16(binary.Size(phdr))
+	hdr.Phnum = uint16(len(segs))
+	hdr.Shstrndx = uint16(elf.SHN_UNDEF)
+
+	phdrs := make([]elf.Prog32, len(segs))
+	off := uint32(binary.Size(hdr)) + uint32(len(segs)*binary.Size(phdr))
+	for i, seg := range segs {
+		p := &phdrs[i]
+		p.Off = off
+		p.Filesz = uint32(len(seg))
+		p.Align = 4
+		if i == 0 {
+			p.Type = uint32(elf.PT_NOTE)
+		} else {
+			p.Type = uint32(elf.PT_LOAD)
+			p.Vaddr = cd.Mem[i-1].Addr
+			p.Paddr = cd.Mem[i-1].Addr
+			p.Memsz = p.Filesz
+			p.Flags = uint32(elf.PF_R | elf.PF_W)
+		}
+
+		off += uint32(len(seg))
+		if pad := off % 4; pad != 0 {
+			off += 4 - pad
+		}
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, hdr); err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := binary.Write(w, binary.LittleEndian, phdrs); err != nil {
+		return util.ChildNewtError(err)
+	}
+	for _, seg := range segs {
+		if _, err := w.Write(seg); err != nil {
+			return util.ChildNewtError(err)
+		}
+		if pad := len(seg) % 4; pad != 0 {
+			w.Write(make([]byte, 4-pad))
+		}
+	}
+
+	return nil
+}
+
+// Describes the exception that was active when the registers were saved,
+// from the IPSR field of xPSR.
+func ExceptionName(xpsr uint32) string {
+	num := xpsr & 0x1ff
+	switch num {
+	case 0:
+		return "Thread mode"
+	case 2:
+		return "NMI"
+	case 3:
+		return "HardFault"
+	case 4:
+		return "MemManage"
+	case 5:
+		return "BusFault"
+	case 6:
+		return "UsageFault"
+	case 11:
+		return "SVCall"
+	case 14:
+		return "PendSV"
+	case 15:
+		return "SysTick"
+	}
+	if num >= 16 {
+		return fmt.Sprintf("IRQ %d", num-16)
+	}
+	return fmt.Sprintf("exception %d", num)
+}
@@ -0,0 +1,299 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package daemon implements a long-running JSON-RPC 2.0 server that answers
+// queries about the project.  The project is parsed once and kept in memory
+// across requests, so IDE integrations can query targets, settings, and
+// compiler flags without paying for a fresh newt process each time.
+//
+// Requests and responses are JSON objects exchanged over a TCP connection;
+// each message is terminated by a newline.
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/builder"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+const JSONRPC_VERSION = "2.0"
+
+// Standard JSON-RPC error codes, plus one for failures reported by newt.
+const (
+	ERR_PARSE            = -32700
+	ERR_INVALID_REQUEST  = -32600
+	ERR_METHOD_NOT_FOUND = -32601
+	ERR_INVALID_PARAMS   = -32602
+	ERR_NEWT             = -32000
+)
+
+type request struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type response struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type methodFn func(d *Daemon, params json.RawMessage) (interface{}, error)
+
+type Daemon struct {
+	// Newt keeps its project state in globals, so requests are handled one
+	// at a time.
+	mtx sync.Mutex
+
+	// Resolved builder for the most recently queried target.  Reused by
+	// subsequent queries for the same target.
+	tb *builder.TargetBuilder
+
+	// Set when a target has been resolved or built; global package state
+	// must be reset before a different target is resolved.
+	stale bool
+}
+
+func NewDaemon() *Daemon {
+	return &Daemon{}
+}
+
+// Discards all cached state.  The project is re-read on the next request.
+func (d *Daemon) reset() error {
+	if proj, err := project.TryGetProject(); err == nil {
+		if err := os.Chdir(proj.Path()); err != nil {
+			return util.ChildNewtError(err)
+		}
+	}
+
+	target.ResetTargets()
+	project.ResetProject()
+	d.tb = nil
+	d.stale = false
+
+	return nil
+}
+
+func (d *Daemon) findTarget(name string) (*target.Target, error) {
+	if _, err := project.TryGetProject(); err != nil {
+		return nil, err
+	}
+
+	targets := target.GetTargets()
+	if t := targets[name]; t != nil {
+		return t, nil
+	}
+	if t := targets["targets/"+name]; t != nil {
+		return t, nil
+	}
+
+	return nil, util.FmtNewtError("Could not resolve target name: %s", name)
+}
+
+// Returns a resolved builder for the named target, reusing the cached one if
+// possible.
+func (d *Daemon) targetBuilder(name string) (*builder.TargetBuilder, error) {
+	if d.tb != nil {
+		if t, err := d.findTarget(name); err == nil && t == d.tb.GetTarget() {
+			return d.tb, nil
+		}
+	}
+
+	if d.stale {
+		if err := d.reset(); err != nil {
+			return nil, err
+		}
+	}
+
+	t, err := d.findTarget(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tb, err := builder.NewTargetBuilder(t)
+	if err != nil {
+		return nil, err
+	}
+	d.stale = true
+
+	if _, err := tb.Resolve(); err != nil {
+		return nil, err
+	}
+
+	d.tb = tb
+	return tb, nil
+}
+
+// Handles a single request.  Notifications (requests without an id) are
+// processed but yield a nil response.
+func (d *Daemon) handle(req *request) *response {
+	rsp := &response{
+		Jsonrpc: JSONRPC_VERSION,
+		Id:      req.Id,
+	}
+
+	fn := methods[req.Method]
+	if fn == nil {
+		rsp.Error = &rpcError{
+			Code:    ERR_METHOD_NOT_FOUND,
+			Message: "Unknown method: " + req.Method,
+		}
+	} else {
+		result, err := d.call(fn, req.Params)
+		if err != nil {
+			code := ERR_NEWT
+			if _, ok := err.(paramsError); ok {
+				code = ERR_INVALID_PARAMS
+			}
+			rsp.Error = &rpcError{Code: code, Message: err.Error()}
+		} else {
+			rsp.Result = result
+		}
+	}
+
+	if len(req.Id) == 0 {
+		return nil
+	}
+	return rsp
+}
+
+// Invokes a method, converting a panic into an error so that a single bad
+// request does not take the daemon down.
+func (d *Daemon) call(fn methodFn,
+	params json.RawMessage) (result interface{}, err error) {
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Debugf("daemon: recovered from panic: %v", r)
+			err = util.FmtNewtError("Internal error: %v", r)
+			d.stale = true
+			d.tb = nil
+		}
+	}()
+
+	return fn(d, params)
+}
+
+func (d *Daemon) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	enc := json.NewEncoder(conn)
+
+	for {
+		req := &request{}
+		if err := dec.Decode(req); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				enc.Encode(&response{
+					Jsonrpc: JSONRPC_VERSION,
+					Id:      json.RawMessage("null"),
+					Error:   &rpcError{Code: ERR_PARSE, Message: err.Error()},
+				})
+			}
+			return
+		}
+
+		var rsp *response
+		if req.Jsonrpc != JSONRPC_VERSION || req.Method == "" {
+			rsp = &response{
+				Jsonrpc: JSONRPC_VERSION,
+				Id:      req.Id,
+				Error: &rpcError{
+					Code:    ERR_INVALID_REQUEST,
+					Message: "Invalid JSON-RPC 2.0 request",
+				},
+			}
+		} else {
+			rsp = d.handle(req)
+		}
+
+		if rsp != nil {
+			if err := enc.Encode(rsp); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Accepts connections on the specified address and serves requests until
+// the listener fails.
+func (d *Daemon) Serve(addr string) error {
+	if _, err := project.TryGetProject(); err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	defer l.Close()
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"newt daemon listening on %s\n", l.Addr().String())
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+
+		log.Debugf("daemon: connection from %s", conn.RemoteAddr())
+		go d.serveConn(conn)
+	}
+}
+
+type paramsError struct {
+	msg string
+}
+
+func (e paramsError) Error() string {
+	return e.msg
+}
+
+func decodeParams(params json.RawMessage, dst interface{}) error {
+	if len(params) == 0 {
+		return paramsError{"Missing params"}
+	}
+	if err := json.Unmarshal(params, dst); err != nil {
+		return paramsError{fmt.Sprintf("Invalid params: %s", err.Error())}
+	}
+
+	return nil
+}
@@ -0,0 +1,250 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/newt/syscfg"
+	"mynewt.apache.org/newt/newt/target"
+	"mynewt.apache.org/newt/util"
+)
+
+var methods = map[string]methodFn{
+	"project.info":       projectInfo,
+	"project.reload":     projectReload,
+	"targets.list":       targetsList,
+	"syscfg.query":       syscfgQuery,
+	"build.compileFlags": buildCompileFlags,
+	"build.run":          buildRun,
+}
+
+// Decodes the params of a method that operates on a target.  The destination
+// must have a "Target" field; targetName points to it.
+func decodeTargetParams(params json.RawMessage, dst interface{},
+	targetName *string) error {
+
+	if err := decodeParams(params, dst); err != nil {
+		return err
+	}
+	if *targetName == "" {
+		return paramsError{"Missing target"}
+	}
+
+	return nil
+}
+
+type RepoInfo struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type ProjectInfo struct {
+	Name  string     `json:"name"`
+	Path  string     `json:"path"`
+	Repos []RepoInfo `json:"repos"`
+}
+
+type repoInfoSorter struct {
+	repos []RepoInfo
+}
+
+func (s repoInfoSorter) Len() int {
+	return len(s.repos)
+}
+func (s repoInfoSorter) Swap(i, j int) {
+	s.repos[i], s.repos[j] = s.repos[j], s.repos[i]
+}
+func (s repoInfoSorter) Less(i, j int) bool {
+	return s.repos[i].Name < s.repos[j].Name
+}
+
+func projectInfo(d *Daemon, params json.RawMessage) (interface{}, error) {
+	proj, err := project.TryGetProject()
+	if err != nil {
+		return nil, err
+	}
+
+	info := ProjectInfo{
+		Name:  proj.Name(),
+		Path:  proj.Path(),
+		Repos: []RepoInfo{},
+	}
+	for name, r := range proj.Repos() {
+		info.Repos = append(info.Repos, RepoInfo{Name: name, Path: r.Path()})
+	}
+	sort.Sort(repoInfoSorter{info.Repos})
+
+	return info, nil
+}
+
+func projectReload(d *Daemon, params json.RawMessage) (interface{}, error) {
+	if err := d.reset(); err != nil {
+		return nil, err
+	}
+
+	return projectInfo(d, params)
+}
+
+func targetsList(d *Daemon, params json.RawMessage) (interface{}, error) {
+	if _, err := project.TryGetProject(); err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name, _ := range target.GetTargets() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+type SettingInfo struct {
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+	Package     string `json:"package"`
+	Origin      string `json:"origin"`
+}
+
+func syscfgQuery(d *Daemon, params json.RawMessage) (interface{}, error) {
+	p := struct {
+		Target   string   `json:"target"`
+		Settings []string `json:"settings"`
+	}{}
+	if err := decodeTargetParams(params, &p, &p.Target); err != nil {
+		return nil, err
+	}
+
+	tb, err := d.targetBuilder(p.Target)
+	if err != nil {
+		return nil, err
+	}
+	res, err := tb.Resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := map[string]SettingInfo{}
+	add := func(entry syscfg.CfgEntry) {
+		last := entry.History[len(entry.History)-1]
+		settings[entry.Name] = SettingInfo{
+			Value:       entry.Value,
+			Description: entry.Description,
+			Package:     entry.History[0].Name(),
+			Origin:      last.Name(),
+		}
+	}
+
+	if len(p.Settings) == 0 {
+		for _, entry := range res.Cfg.Settings {
+			add(entry)
+		}
+	} else {
+		for _, name := range p.Settings {
+			entry, ok := res.Cfg.Settings[name]
+			if !ok {
+				return nil, util.FmtNewtError("Unknown setting: %s", name)
+			}
+			add(entry)
+		}
+	}
+
+	return settings, nil
+}
+
+type CompileFlags struct {
+	Directory string   `json:"directory"`
+	Arguments []string `json:"arguments"`
+}
+
+func buildCompileFlags(d *Daemon, params json.RawMessage) (interface{}, error) {
+	p := struct {
+		Target string `json:"target"`
+		File   string `json:"file"`
+	}{}
+	if err := decodeTargetParams(params, &p, &p.Target); err != nil {
+		return nil, err
+	}
+	if p.File == "" {
+		return nil, paramsError{"Missing file"}
+	}
+
+	file, err := filepath.Abs(p.File)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	tb, err := d.targetBuilder(p.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := tb.CompileCmd(filepath.ToSlash(file))
+	if err != nil {
+		return nil, err
+	}
+
+	return CompileFlags{
+		Directory: project.GetProject().BasePath,
+		Arguments: cmd,
+	}, nil
+}
+
+type BuildResult struct {
+	Target string `json:"target"`
+	Elf    string `json:"elf"`
+}
+
+func buildRun(d *Daemon, params json.RawMessage) (interface{}, error) {
+	p := struct {
+		Target string `json:"target"`
+	}{}
+	if err := decodeTargetParams(params, &p, &p.Target); err != nil {
+		return nil, err
+	}
+
+	// Building modifies global package state; always start from a clean
+	// slate and discard the cached builder afterwards.
+	if d.stale {
+		if err := d.reset(); err != nil {
+			return nil, err
+		}
+	}
+
+	tb, err := d.targetBuilder(p.Target)
+	if err != nil {
+		return nil, err
+	}
+	d.tb = nil
+
+	if err := tb.Build(); err != nil {
+		return nil, err
+	}
+
+	t := tb.GetTarget()
+	return BuildResult{
+		Target: t.FullName(),
+		Elf:    t.ElfPath(),
+	}, nil
+}
@@ -0,0 +1,503 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// The pseudo-branch that an archive repo's single version maps to.
+const ARCHIVE_BRANCH = "archive"
+
+// Downloads a repo published as a tarball or ZIP file.  The archive's
+// contents are pinned by a SHA-256 checksum.
+type ArchiveDownloader struct {
+	GenericDownloader
+	Name string
+	Url  string
+
+	// Expected SHA-256 of the archive, in hex.
+	Sha256 string
+
+	// Version of the archive; used to describe archives that don't contain
+	// a repository.yml file.
+	Vers string
+
+	// Path of the verified archive, once downloaded.
+	archivePath string
+}
+
+func (ad *ArchiveDownloader) archiveExt() string {
+	url := strings.ToLower(ad.Url)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar.bz2", ".tbz2",
+		".tar.xz", ".txz", ".tar", ".zip"} {
+
+		if strings.HasSuffix(url, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+func fileSha256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", util.ChildNewtError(err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (ad *ArchiveDownloader) verify(path string) error {
+	sum, err := fileSha256(path)
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(sum, ad.Sha256) {
+		return util.FmtNewtError(
+			"Checksum mismatch for archive %s: have sha256 %s, want %s",
+			ad.Url, sum, ad.Sha256)
+	}
+
+	return nil
+}
+
+// Downloads the archive and verifies its checksum.  The archive is only
+// downloaded once per newt invocation.  If a shared cache is configured, the
+// archive is kept there, keyed by checksum.
+func (ad *ArchiveDownloader) fetchArchive() (string, error) {
+	if ad.archivePath != "" {
+		return ad.archivePath, nil
+	}
+
+	var dest string
+	if cacheDir != "" {
+		dest = fmt.Sprintf("%s/archives/%s%s", cacheDir,
+			strings.ToLower(ad.Sha256), ad.archiveExt())
+		if util.NodeExist(dest) && ad.verify(dest) == nil {
+			log.Debugf("Using cached archive %s", dest)
+			ad.archivePath = dest
+			return dest, nil
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return "", util.ChildNewtError(err)
+		}
+	} else {
+		tmpdir, err := ioutil.TempDir("", "newt-archive")
+		if err != nil {
+			return "", util.ChildNewtError(err)
+		}
+		dest = tmpdir + "/archive" + ad.archiveExt()
+	}
+
+	if err := checkOnline("download archive " + ad.Url); err != nil {
+		return "", err
+	}
+
+	url := mirrorUrl(ad.Url)
+	statusMessage(util.VERBOSITY_VERBOSE, "Downloading archive %s\n",
+		url)
+
+	// Write to a temporary name so that an interrupted download never looks
+	// like a complete one.  An interrupted download is resumed where it left
+	// off, by a retry or, if the archive is cached, by a later invocation.
+	tmpDest := dest + ".part"
+	err := retryNet("download archive "+url, func() error {
+		return downloadResumable(url, tmpDest)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := ad.verify(tmpDest); err != nil {
+		os.Remove(tmpDest)
+		return "", err
+	}
+
+	if err := os.Rename(tmpDest, dest); err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	ad.archivePath = dest
+	return dest, nil
+}
+
+// Downloads the specified URL to a file.  If the file already contains the
+// start of the download, only the remainder is requested.  Servers that don't
+// support range requests send the whole file, which then replaces the partial
+// one.
+func downloadResumable(url string, dest string) error {
+	var offset int64
+	if info, err := os.Stat(dest); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return permanentErr(util.ChildNewtError(err))
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	rsp, done, err := netDo(req)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	defer done()
+	defer rsp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch rsp.StatusCode {
+	case http.StatusPartialContent:
+		statusMessage(util.VERBOSITY_VERBOSE,
+			"Resuming download of %s at byte %d\n", url, offset)
+		flags |= os.O_APPEND
+
+	case http.StatusOK:
+		flags |= os.O_TRUNC
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is no prefix of the archive; start over.
+		os.Remove(dest)
+		return util.FmtNewtError("Failed to resume download of '%s'; "+
+			"status=%s", url, rsp.Status)
+
+	default:
+		return httpStatusErr(rsp, fmt.Sprintf(
+			"Failed to download '%s'; status=%s", url, rsp.Status))
+	}
+
+	f, err := os.OpenFile(dest, flags, 0644)
+	if err != nil {
+		return permanentErr(util.ChildNewtError(err))
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, rsp.Body); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Downloads the archive, verifies its checksum, and returns the path of the
+// local copy.
+func (ad *ArchiveDownloader) Fetch() (string, error) {
+	return ad.fetchArchive()
+}
+
+// Returns the path to write an archive entry to, or "" if the entry should
+// be skipped.  Entries that would escape the destination are rejected.
+func archiveEntryPath(dstDir string, name string) (string, error) {
+	name = filepath.ToSlash(filepath.Clean(name))
+	if name == "." || name == "/" {
+		return "", nil
+	}
+	if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return "", util.FmtNewtError("Illegal path in archive: %s", name)
+	}
+
+	return dstDir + "/" + name, nil
+}
+
+func writeArchiveFile(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY,
+		mode|0600)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+func extractTar(r io.Reader, dstDir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+
+		path, err := archiveEntryPath(dstDir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if path == "" {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return util.ChildNewtError(err)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := writeArchiveFile(path, tr,
+				os.FileMode(hdr.Mode).Perm()); err != nil {
+
+				return err
+			}
+		default:
+			// Links and special files are not supported.
+			log.Debugf("Skipping archive entry %s (type %c)", hdr.Name,
+				hdr.Typeflag)
+		}
+	}
+}
+
+func extractZip(archivePath string, dstDir string) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		path, err := archiveEntryPath(dstDir, zf.Name)
+		if err != nil {
+			return err
+		}
+		if path == "" {
+			continue
+		}
+
+		if zf.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return util.ChildNewtError(err)
+			}
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+		err = writeArchiveFile(path, rc, zf.Mode().Perm())
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Extracts the archive into a new temporary directory.  If the archive
+// contains a single top-level directory (e.g., "sdk-1.2.0/"), its contents
+// become the root of the repo.  Returns the repo root and the temporary
+// directory that the caller must remove.
+func (ad *ArchiveDownloader) extract() (string, string, error) {
+	archivePath, err := ad.fetchArchive()
+	if err != nil {
+		return "", "", err
+	}
+
+	tmpdir, err := ioutil.TempDir("", "newt-repo")
+	if err != nil {
+		return "", "", util.ChildNewtError(err)
+	}
+
+	ext := ad.archiveExt()
+	if ext == ".zip" {
+		err = extractZip(archivePath, tmpdir)
+	} else {
+		var f *os.File
+		f, err = os.Open(archivePath)
+		if err != nil {
+			os.RemoveAll(tmpdir)
+			return "", "", util.ChildNewtError(err)
+		}
+		defer f.Close()
+
+		var r io.Reader = f
+		switch ext {
+		case ".tar.gz", ".tgz":
+			gz, gzErr := gzip.NewReader(f)
+			if gzErr != nil {
+				os.RemoveAll(tmpdir)
+				return "", "", util.ChildNewtError(gzErr)
+			}
+			defer gz.Close()
+			r = gz
+		case ".tar.bz2", ".tbz2":
+			r = bzip2.NewReader(f)
+		case ".tar.xz", ".txz":
+			os.RemoveAll(tmpdir)
+			return "", "", util.FmtNewtError(
+				"Unsupported repo archive format: %s", ad.Url)
+		}
+		err = extractTar(r, tmpdir)
+	}
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		return "", "", err
+	}
+
+	infos, err := ioutil.ReadDir(tmpdir)
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		return "", "", util.ChildNewtError(err)
+	}
+	if len(infos) == 1 && infos[0].IsDir() {
+		return tmpdir + "/" + infos[0].Name(), tmpdir, nil
+	}
+
+	return tmpdir, tmpdir, nil
+}
+
+// Generates a repository description for an archive that lacks one.  The
+// archive's single version maps to the "archive" pseudo-branch.
+func (ad *ArchiveDownloader) syntheticDesc() string {
+	vers := strings.TrimLeft(ad.Vers, "<>=")
+	if vers == "" {
+		vers = "0.0.0"
+	}
+
+	return fmt.Sprintf("repo.name: %s\nrepo.versions:\n    \"%s\": \"%s\"\n",
+		ad.Name, vers, ARCHIVE_BRANCH)
+}
+
+func (ad *ArchiveDownloader) FetchFile(name string, dest string) error {
+	dir, tmpdir, err := ad.extract()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	src := dir + "/" + name
+	if util.NodeExist(src) {
+		return util.CopyFile(src, dest)
+	}
+
+	if name == "repository.yml" {
+		return ioutil.WriteFile(dest, []byte(ad.syntheticDesc()), 0644)
+	}
+
+	return util.FmtNewtError("File %s not found in archive %s", name, ad.Url)
+}
+
+func (ad *ArchiveDownloader) CurrentBranch(path string) (string, error) {
+	return ARCHIVE_BRANCH, nil
+}
+
+// An archive is immutable; its checksum pins its contents.  There is nothing
+// to update.
+func (ad *ArchiveDownloader) UpdateRepo(path string, branchName string) error {
+	return nil
+}
+
+func (ad *ArchiveDownloader) CleanupRepo(path string, branchName string) error {
+	if err := os.RemoveAll(path); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	tmpdir, err := ad.DownloadRepo(branchName)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	return util.CopyDir(tmpdir, path)
+}
+
+// Archives aren't under version control, so local changes cannot be
+// detected.
+func (ad *ArchiveDownloader) LocalDiff(path string) ([]byte, error) {
+	return nil, nil
+}
+
+// An archive's checksum serves as its commit.
+func (ad *ArchiveDownloader) HeadCommit(path string) (string, error) {
+	return strings.ToLower(ad.Sha256), nil
+}
+
+// The archive's checksum covers its entire contents.
+func (ad *ArchiveDownloader) TreeHash(path string) (string, error) {
+	return strings.ToLower(ad.Sha256), nil
+}
+
+func (ad *ArchiveDownloader) CheckoutCommit(path string, commit string) error {
+	if !strings.EqualFold(commit, ad.Sha256) {
+		return util.FmtNewtError(
+			"Archive %s has sha256 %s; cannot check out %s", ad.Url,
+			ad.Sha256, commit)
+	}
+	return nil
+}
+
+func (ad *ArchiveDownloader) DownloadRepo(commit string) (string, error) {
+	statusMessage(util.VERBOSITY_VERBOSE,
+		"Downloading repository %s from archive %s\n", ad.Name, ad.Url)
+
+	dir, _, err := ad.extract()
+	if err != nil {
+		return "", err
+	}
+
+	repoFile := dir + "/repository.yml"
+	if util.NodeNotExist(repoFile) {
+		if err := ioutil.WriteFile(repoFile, []byte(ad.syntheticDesc()),
+			0644); err != nil {
+
+			return "", util.ChildNewtError(err)
+		}
+	}
+
+	return dir, nil
+}
+
+func NewArchiveDownloader() *ArchiveDownloader {
+	return &ArchiveDownloader{}
+}
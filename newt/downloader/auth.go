@@ -0,0 +1,304 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Environment variable used to pass a password or token to the inline git
+// credential helper.  Passing secrets this way keeps them out of the command
+// line and out of the repo's .git/config.
+const GIT_CRED_PASSWORD_ENV = "NEWT_GIT_PASSWORD"
+const GIT_CRED_USERNAME_ENV = "NEWT_GIT_USERNAME"
+
+// Converts a host name to the suffix of the per-host token environment
+// variable.  E.g., "github.example.com" => "GITHUB_EXAMPLE_COM".
+func hostEnvSuffix(host string) string {
+	return strings.ToUpper(util.CIdentifier(host))
+}
+
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+
+	home, err := homeDir()
+	if err != nil {
+		return ""
+	}
+	return home + "/.netrc"
+}
+
+func homeDir() (string, error) {
+	if home := os.Getenv("HOME"); home != "" {
+		return home, nil
+	}
+	return "", util.NewNewtError("$HOME not set")
+}
+
+// Searches the user's netrc file for credentials for the specified host.
+//
+// @return                      login, password
+func netrcCredentials(host string) (string, string) {
+	path := netrcPath()
+	if path == "" {
+		return "", ""
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", ""
+	}
+
+	// The netrc format is a sequence of whitespace-separated tokens.
+	fields := strings.Fields(string(data))
+
+	login := ""
+	password := ""
+	inMachine := false
+	for i := 0; i < len(fields)-1; i++ {
+		switch fields[i] {
+		case "machine":
+			if inMachine {
+				return login, password
+			}
+			inMachine = fields[i+1] == host
+			i++
+
+		case "default":
+			if inMachine {
+				return login, password
+			}
+			inMachine = true
+
+		case "login":
+			if inMachine {
+				login = fields[i+1]
+			}
+			i++
+
+		case "password":
+			if inMachine {
+				password = fields[i+1]
+			}
+			i++
+		}
+	}
+
+	if !inMachine {
+		return "", ""
+	}
+	return login, password
+}
+
+// Asks the user's configured git credential helpers for credentials for the
+// specified host.  Interactive prompting is disabled.
+//
+// @return                      login, password
+func gitCredentialFill(host string) (string, string) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		return "", ""
+	}
+
+	cmd := exec.Command(gitPath, "credential", "fill")
+	cmd.Stdin = strings.NewReader(
+		fmt.Sprintf("protocol=https\nhost=%s\n\n", host))
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		log.Debugf("git credential fill failed for %s: %s", host, err.Error())
+		return "", ""
+	}
+
+	login := ""
+	password := ""
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "username":
+			login = kv[1]
+		case "password":
+			password = kv[1]
+		}
+	}
+
+	return login, password
+}
+
+func (gd *GithubDownloader) host() string {
+	if gd.Server != "" {
+		return gd.Server
+	}
+	return "github.com"
+}
+
+// Fills in missing credentials from the environment.  Sources are consulted
+// in the following order:
+//  1. The environment variable named by the repo's "token_env" setting.
+//  2. NEWT_TOKEN_<HOST> (e.g., NEWT_TOKEN_GITHUB_COM).
+//  3. The user's netrc file.
+//  4. git credential helpers.
+//
+// Credentials specified in project.yml or $HOME/.newt/repos.yml always take
+// precedence.
+func (gd *GithubDownloader) resolveAuth() {
+	if gd.authResolved {
+		return
+	}
+	gd.authResolved = true
+
+	if gd.UseSsh || gd.Token != "" || (gd.Login != "" && gd.Password != "") {
+		return
+	}
+
+	if gd.TokenEnv != "" {
+		if token := os.Getenv(gd.TokenEnv); token != "" {
+			log.Debugf("Using token from $%s", gd.TokenEnv)
+			gd.Token = token
+			return
+		}
+	}
+
+	hostEnv := "NEWT_TOKEN_" + hostEnvSuffix(gd.host())
+	if token := os.Getenv(hostEnv); token != "" {
+		log.Debugf("Using token from $%s", hostEnv)
+		gd.Token = token
+		return
+	}
+
+	if login, password := netrcCredentials(gd.host()); password != "" {
+		log.Debugf("Using credentials from %s", netrcPath())
+		gd.Login = login
+		gd.Password = password
+		return
+	}
+
+	if login, password := gitCredentialFill(gd.host()); password != "" {
+		log.Debugf("Using credentials from git credential helper")
+		gd.Login = login
+		gd.Password = password
+		return
+	}
+}
+
+func (gd *GithubDownloader) upstreamUrl() string {
+	if gd.UseSsh {
+		return fmt.Sprintf("git@%s:%s/%s.git", gd.host(), gd.User, gd.Repo)
+	}
+	return fmt.Sprintf("https://%s/%s/%s.git", gd.host(), gd.User, gd.Repo)
+}
+
+// Returns the URL to clone the repository from, after mirror rewrites.
+func (gd *GithubDownloader) cloneUrl() string {
+	return mirrorUrl(gd.upstreamUrl())
+}
+
+// Returns the environment needed to pass the downloader's credentials to git.
+// Git reads its configuration from GIT_CONFIG_* environment variables; these
+// install an inline credential helper that echoes the secret.
+func (gd *GithubDownloader) gitEnv() []string {
+	// Credentials belong to the upstream host; don't hand them to a mirror.
+	if isMirrored(gd.upstreamUrl()) {
+		return nil
+	}
+
+	gd.resolveAuth()
+
+	username := ""
+	password := ""
+	if gd.Token != "" {
+		username = "x-access-token"
+		password = gd.Token
+	} else if gd.Login != "" && gd.Password != "" {
+		username = gd.Login
+		password = gd.Password
+	} else {
+		return nil
+	}
+
+	helper := fmt.Sprintf("!f() { echo username=$%s; echo password=$%s; }; f",
+		GIT_CRED_USERNAME_ENV, GIT_CRED_PASSWORD_ENV)
+
+	return []string{
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=credential.helper",
+		"GIT_CONFIG_VALUE_0=" + helper,
+		GIT_CRED_USERNAME_ENV + "=" + username,
+		GIT_CRED_PASSWORD_ENV + "=" + password,
+	}
+}
+
+// Retrieves a single file from the remote repository using git rather than
+// HTTP.  This is used for SSH-authenticated repos, which have no HTTP
+// credentials.
+func (gd *GithubDownloader) fetchFileGit(name string, dest string) error {
+	tmpdir, err := ioutil.TempDir("", "newt-fetch")
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	cmd := append(gitNetArgs(),
+		"clone", "--depth", "1", "--no-checkout", "-b", gd.Branch(),
+		gd.cloneUrl(), tmpdir,
+	)
+	err = retryNet("download "+name, func() error {
+		if err := resetDir(tmpdir); err != nil {
+			return permanentErr(err)
+		}
+
+		_, err := executeGitCommandEnv(filepath.Dir(tmpdir), cmd, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	contents, err := executeGitCommandEnv(tmpdir,
+		[]string{"show", "HEAD:" + name}, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(dest, contents, 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
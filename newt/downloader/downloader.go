@@ -27,7 +27,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"unicode"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -44,6 +46,9 @@ type Downloader interface {
 	UpdateRepo(path string, branchName string) error
 	CleanupRepo(path string, branchName string) error
 	LocalDiff(path string) ([]byte, error)
+	HeadCommit(path string) (string, error)
+	TreeHash(path string) (string, error)
+	CheckoutCommit(path string, commit string) error
 }
 
 type GenericDownloader struct {
@@ -62,6 +67,21 @@ type GithubDownloader struct {
 	// Basic authentication login and password for private repositories.
 	Login    string
 	Password string
+
+	// If nonzero, the repository is cloned with a history truncated to this
+	// many commits.
+	Depth int
+
+	// If non-empty, only these paths are checked out (sparse checkout).
+	SparsePaths []string
+
+	// Name of an environment variable containing an access token.
+	TokenEnv string
+
+	// Clone over SSH, authenticating with the user's SSH agent / keys.
+	UseSsh bool
+
+	authResolved bool
 }
 
 type LocalDownloader struct {
@@ -71,7 +91,26 @@ type LocalDownloader struct {
 	Path string
 }
 
+// Returns an error if newt is in offline mode.  Called before any operation
+// that requires network access.
+func checkOnline(desc string) error {
+	if newtutil.NewtOffline {
+		return util.FmtNewtError(
+			"Cannot %s: newt is in offline mode", desc).WithClass(
+			util.ERR_CLASS_NETWORK)
+	}
+	return nil
+}
+
 func executeGitCommand(dir string, cmd []string) ([]byte, error) {
+	return executeGitCommandEnv(dir, cmd, nil)
+}
+
+// Executes a git command with additional environment variables.  The
+// environment may contain credentials, so it is never logged.
+func executeGitCommandEnv(dir string, cmd []string,
+	env []string) ([]byte, error) {
+
 	wd, err := os.Getwd()
 	if err != nil {
 		return nil, util.NewNewtError(err.Error())
@@ -80,7 +119,7 @@ func executeGitCommand(dir string, cmd []string) ([]byte, error) {
 	gitPath, err := exec.LookPath("git")
 	if err != nil {
 		return nil, util.NewNewtError(fmt.Sprintf("Can't find git binary: %s\n",
-			err.Error()))
+			err.Error())).WithClass(util.ERR_CLASS_TOOL_MISSING)
 	}
 	gitPath = filepath.ToSlash(gitPath)
 
@@ -92,9 +131,25 @@ func executeGitCommand(dir string, cmd []string) ([]byte, error) {
 
 	gitCmd := []string{gitPath}
 	gitCmd = append(gitCmd, cmd...)
-	output, err := util.ShellCommand(gitCmd, nil)
+
+	if env == nil {
+		output, err := util.ShellCommand(gitCmd, nil)
+		if err != nil {
+			return nil, err
+		}
+		return output, nil
+	}
+
+	log.Debugf("%s", strings.Join(gitCmd, " "))
+	c := exec.Command(gitCmd[0], gitCmd[1:]...)
+	c.Env = append(env, os.Environ()...)
+	output, err := c.CombinedOutput()
+	log.Debugf("o=%s", string(output))
 	if err != nil {
-		return nil, err
+		if len(output) > 0 {
+			return nil, util.NewNewtError(string(output))
+		}
+		return nil, util.NewNewtError(err.Error())
 	}
 
 	return output, nil
@@ -118,7 +173,7 @@ func branchExists(repoDir string, branchName string) bool {
 func checkout(repoDir string, commit string) error {
 	var cmd []string
 	if isTag(repoDir, commit) && !branchExists(repoDir, commit) {
-		util.StatusMessage(util.VERBOSITY_VERBOSE, "Will create new branch %s"+
+		statusMessage(util.VERBOSITY_VERBOSE, "Will create new branch %s"+
 			" from tag %s\n", commit, "tags/"+commit)
 		cmd = []string{
 			"checkout",
@@ -127,7 +182,7 @@ func checkout(repoDir string, commit string) error {
 			commit,
 		}
 	} else {
-		util.StatusMessage(util.VERBOSITY_VERBOSE, "Will checkout branch %s\n",
+		statusMessage(util.VERBOSITY_VERBOSE, "Will checkout branch %s\n",
 			commit)
 		cmd = []string{
 			"checkout",
@@ -149,10 +204,10 @@ func mergeBranches(repoDir string) {
 		}
 		_, err = executeGitCommand(repoDir, []string{"merge", "origin/" + branch})
 		if err != nil {
-			util.StatusMessage(util.VERBOSITY_VERBOSE, "Merging changes from origin/%s: %s\n",
+			statusMessage(util.VERBOSITY_VERBOSE, "Merging changes from origin/%s: %s\n",
 				branch, err)
 		} else {
-			util.StatusMessage(util.VERBOSITY_VERBOSE, "Merging changes from origin/%s\n",
+			statusMessage(util.VERBOSITY_VERBOSE, "Merging changes from origin/%s\n",
 				branch)
 		}
 		// XXX: ignore error, probably resulting from a branch not available at
@@ -160,16 +215,81 @@ func mergeBranches(repoDir string) {
 	}
 }
 
-func fetch(repoDir string) error {
-	util.StatusMessage(util.VERBOSITY_VERBOSE, "Fetching new remote branches/tags\n")
-	_, err := executeGitCommand(repoDir, []string{"fetch", "--tags"})
-	return err
+func fetch(repoDir string, env []string) error {
+	statusMessage(util.VERBOSITY_VERBOSE, "Fetching new remote branches/tags\n")
+	return retryNet("fetch "+filepath.Base(repoDir), func() error {
+		_, err := executeGitCommandEnv(repoDir,
+			append(gitNetArgs(), "fetch", "--tags"), env)
+		return err
+	})
+}
+
+func isShallow(repoDir string) bool {
+	output, err := executeGitCommand(repoDir,
+		[]string{"rev-parse", "--is-shallow-repository"})
+	return err == nil && strings.TrimSpace(string(output)) == "true"
+}
+
+func refExists(repoDir string, ref string) bool {
+	_, err := executeGitCommand(repoDir,
+		[]string{"rev-parse", "--verify", "--quiet", ref + "^{commit}"})
+	return err == nil
+}
+
+// fetchShallowRef makes the specified branch, tag, or commit available in a
+// shallow clone.  A clone made with "--single-branch" (implied by "--depth")
+// only tracks the branch it was created from, so other branches are never
+// fetched and can't be checked out by name.
+func fetchShallowRef(repoDir string, ref string, depth int,
+	env []string) error {
+
+	if depth <= 0 || !isShallow(repoDir) {
+		return nil
+	}
+
+	depthArg := "--depth=" + strconv.Itoa(depth)
+	name := filepath.Base(repoDir)
+
+	refspecs, _ := executeGitCommand(repoDir,
+		[]string{"config", "--get-all", "remote.origin.fetch"})
+	if !strings.Contains(string(refspecs), "refs/heads/*") {
+		statusMessage(util.VERBOSITY_VERBOSE,
+			"Tracking all remote branches in shallow clone\n")
+
+		if _, err := executeGitCommand(repoDir,
+			[]string{"remote", "set-branches", "origin", "*"}); err != nil {
+
+			return err
+		}
+
+		if err := retryNet("fetch "+name, func() error {
+			_, err := executeGitCommandEnv(repoDir,
+				append(gitNetArgs(), "fetch", depthArg, "origin"), env)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	if refExists(repoDir, ref) || refExists(repoDir, "origin/"+ref) {
+		return nil
+	}
+
+	// Not a branch or tag; assume a commit hash outside the fetched history.
+	statusMessage(util.VERBOSITY_VERBOSE, "Fetching %s into shallow clone\n",
+		ref)
+
+	return retryNet("fetch "+name, func() error {
+		_, err := executeGitCommandEnv(repoDir,
+			append(gitNetArgs(), "fetch", depthArg, "origin", ref), env)
+		return err
+	})
 }
 
 // stash saves current changes locally and returns if a new stash was
 // created (if there where no changes, there's no need to stash)
 func stash(repoDir string) (bool, error) {
-	util.StatusMessage(util.VERBOSITY_VERBOSE, "Stashing local changes\n")
+	statusMessage(util.VERBOSITY_VERBOSE, "Stashing local changes\n")
 	output, err := executeGitCommand(repoDir, []string{"stash"})
 	if err != nil {
 		return false, err
@@ -178,11 +298,53 @@ func stash(repoDir string) (bool, error) {
 }
 
 func stashPop(repoDir string) error {
-	util.StatusMessage(util.VERBOSITY_VERBOSE, "Un-stashing local changes\n")
+	statusMessage(util.VERBOSITY_VERBOSE, "Un-stashing local changes\n")
 	_, err := executeGitCommand(repoDir, []string{"stash", "pop"})
 	return err
 }
 
+func headCommit(repoDir string) (string, error) {
+	output, err := executeGitCommand(repoDir, []string{"rev-parse", "HEAD"})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func treeHash(repoDir string) (string, error) {
+	output, err := executeGitCommand(repoDir,
+		[]string{"rev-parse", "HEAD^{tree}"})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Restricts the working tree of the specified repo to the given paths.  The
+// repository.yml file is always included since newt requires it.
+func configureSparseCheckout(repoDir string, paths []string) error {
+	_, err := executeGitCommand(repoDir,
+		[]string{"config", "core.sparseCheckout", "true"})
+	if err != nil {
+		return err
+	}
+
+	contents := "/repository.yml\n"
+	for _, p := range paths {
+		contents += "/" + strings.TrimPrefix(p, "/") + "\n"
+	}
+
+	sparsePath := repoDir + "/.git/info/sparse-checkout"
+	if err := os.MkdirAll(filepath.Dir(sparsePath), 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := ioutil.WriteFile(sparsePath, []byte(contents), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
 func clean(repoDir string) error {
 	_, err := executeGitCommand(repoDir, []string{"clean", "-f"})
 	return err
@@ -202,6 +364,18 @@ func (gd *GenericDownloader) TempDir() (string, error) {
 }
 
 func (gd *GithubDownloader) FetchFile(name string, dest string) error {
+	if err := checkOnline(fmt.Sprintf("download %s from %s/%s",
+		name, gd.User, gd.Repo)); err != nil {
+
+		return err
+	}
+
+	if gd.UseSsh {
+		return gd.fetchFileGit(name, dest)
+	}
+
+	gd.resolveAuth()
+
 	var url string
 	if gd.Server != "" {
 		// Use the github API
@@ -211,10 +385,26 @@ func (gd *GithubDownloader) FetchFile(name string, dest string) error {
 		url = fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s/%s", gd.User, gd.Repo, gd.Branch(), name)
 	}
 
+	mirrored := isMirrored(url)
+	url = mirrorUrl(url)
+
+	return retryNet("download "+name, func() error {
+		return gd.fetchFileHttp(url, mirrored, name, dest)
+	})
+}
+
+func (gd *GithubDownloader) fetchFileHttp(url string, mirrored bool,
+	name string, dest string) error {
+
 	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return permanentErr(util.ChildNewtError(err))
+	}
 	req.Header.Add("Accept", "application/vnd.github.v3.raw")
 
-	if gd.Token != "" {
+	if mirrored {
+		log.Debugf("Not sending credentials to mirror")
+	} else if gd.Token != "" {
 		// XXX: Add command line option to include token in log.
 		log.Debugf("Using authorization token")
 		req.Header.Add("Authorization", "token "+gd.Token)
@@ -225,11 +415,11 @@ func (gd *GithubDownloader) FetchFile(name string, dest string) error {
 	}
 
 	log.Debugf("Fetching file %s (url: %s) to %s", name, url, dest)
-	client := &http.Client{}
-	rsp, err := client.Do(req)
+	rsp, done, err := netDo(req)
 	if err != nil {
 		return util.NewNewtError(err.Error())
 	}
+	defer done()
 	defer rsp.Body.Close()
 
 	if rsp.StatusCode != http.StatusOK {
@@ -242,16 +432,18 @@ func (gd *GithubDownloader) FetchFile(name string, dest string) error {
 			errMsg += "; credentials incorrect?"
 		}
 
-		return util.NewNewtError(errMsg)
+		return httpStatusErr(rsp, errMsg)
 	}
 
 	handle, err := os.Create(dest)
 	if err != nil {
-		return util.NewNewtError(err.Error())
+		return permanentErr(util.NewNewtError(err.Error()))
 	}
 	defer handle.Close()
 
-	_, err = io.Copy(handle, rsp.Body)
+	if _, err := io.Copy(handle, rsp.Body); err != nil {
+		return util.NewNewtError(err.Error())
+	}
 
 	return nil
 }
@@ -263,9 +455,21 @@ func (gd *GithubDownloader) CurrentBranch(path string) (string, error) {
 }
 
 func (gd *GithubDownloader) UpdateRepo(path string, branchName string) error {
-	err := fetch(path)
-	if err != nil {
-		return err
+	// In offline mode, only branches and tags that have already been fetched
+	// can be checked out.
+	if !newtutil.NewtOffline {
+		if err := fetch(path, gd.gitEnv()); err != nil {
+			return err
+		}
+		if err := fetchShallowRef(path, branchName, gd.Depth,
+			gd.gitEnv()); err != nil {
+
+			return err
+		}
+	} else {
+		statusMessage(util.VERBOSITY_QUIET,
+			"WARNING: Offline; not fetching from remote, so %s may be "+
+				"stale\n", branchName)
 	}
 
 	stashed, err := stash(path)
@@ -273,7 +477,9 @@ func (gd *GithubDownloader) UpdateRepo(path string, branchName string) error {
 		return err
 	}
 
-	mergeBranches(path)
+	if !newtutil.NewtOffline {
+		mergeBranches(path)
+	}
 
 	err = checkout(path, branchName)
 	if err != nil {
@@ -307,7 +513,38 @@ func (gd *GithubDownloader) LocalDiff(path string) ([]byte, error) {
 	return executeGitCommand(path, []string{"diff"})
 }
 
+func (gd *GithubDownloader) HeadCommit(path string) (string, error) {
+	return headCommit(path)
+}
+
+func (gd *GithubDownloader) TreeHash(path string) (string, error) {
+	return treeHash(path)
+}
+
+func (gd *GithubDownloader) CheckoutCommit(path string, commit string) error {
+	// The commit may not have been fetched yet.  In offline mode, it had
+	// better be present already.
+	if !newtutil.NewtOffline {
+		if err := fetch(path, gd.gitEnv()); err != nil {
+			return err
+		}
+		if err := fetchShallowRef(path, commit, gd.Depth,
+			gd.gitEnv()); err != nil {
+
+			return err
+		}
+	}
+
+	return checkout(path, commit)
+}
+
 func (gd *GithubDownloader) DownloadRepo(commit string) (string, error) {
+	if err := checkOnline(fmt.Sprintf("clone repository %s/%s",
+		gd.User, gd.Repo)); err != nil {
+
+		return "", err
+	}
+
 	// Get a temporary directory, and copy the repository into that directory.
 	tmpdir, err := ioutil.TempDir("", "newt-repo")
 	if err != nil {
@@ -316,13 +553,8 @@ func (gd *GithubDownloader) DownloadRepo(commit string) (string, error) {
 
 	// Currently only the master branch is supported.
 	branch := "master"
-	server := "github.com"
-
-	if gd.Server != "" {
-		server = gd.Server
-	}
-	url := fmt.Sprintf("https://%s/%s/%s.git", server, gd.User, gd.Repo)
-	util.StatusMessage(util.VERBOSITY_VERBOSE, "Downloading "+
+	url := gd.cloneUrl()
+	statusMessage(util.VERBOSITY_VERBOSE, "Downloading "+
 		"repository %s (branch: %s; commit: %s) at %s\n", gd.Repo, branch,
 		commit, url)
 
@@ -330,27 +562,75 @@ func (gd *GithubDownloader) DownloadRepo(commit string) (string, error) {
 	if err != nil {
 		os.RemoveAll(tmpdir)
 		return "", util.NewNewtError(fmt.Sprintf("Can't find git binary: %s\n",
-			err.Error()))
+			err.Error())).WithClass(util.ERR_CLASS_TOOL_MISSING)
 	}
 	gitPath = filepath.ToSlash(gitPath)
 
+	// A shallow clone is truncated below the tip it starts from, so clone the
+	// requested branch or tag directly rather than master.
+	if gd.Depth > 0 {
+		branch = commit
+	}
+
 	// Clone the repository.
-	cmd := []string{
-		gitPath,
+	cmd := append(gitNetArgs(),
 		"clone",
 		"-b",
 		branch,
-		url,
-		tmpdir,
+	)
+	if gd.Depth > 0 {
+		// "--depth" implies "--single-branch"; keep the other branches
+		// fetchable so that a later upgrade to a different version works.
+		cmd = append(cmd, "--depth", strconv.Itoa(gd.Depth),
+			"--no-single-branch")
+	}
+	if len(gd.SparsePaths) > 0 {
+		// Don't populate the working tree until the sparse checkout is
+		// configured.
+		cmd = append(cmd, "--no-checkout")
 	}
 
-	if util.Verbosity >= util.VERBOSITY_VERBOSE {
-		if err := util.ShellInteractiveCommand(cmd, nil); err != nil {
+	env := gd.gitEnv()
+
+	// Borrow objects from the shared cache so that only missing objects are
+	// downloaded.  The clone is dissociated so it remains usable if the cache
+	// is removed.
+	if cacheDir != "" {
+		cached, err := updateCache(url, env)
+		if err != nil {
 			os.RemoveAll(tmpdir)
 			return "", err
 		}
-	} else {
-		if _, err := util.ShellCommand(cmd, nil); err != nil {
+		cmd = append(cmd, "--reference-if-able", cached, "--dissociate")
+	}
+
+	cmd = append(cmd, url, tmpdir)
+
+	err = retryNet("clone "+gd.Repo, func() error {
+		// Remove anything a failed attempt left behind; git refuses to
+		// clone into a non-empty directory.
+		if err := resetDir(tmpdir); err != nil {
+			return permanentErr(err)
+		}
+
+		if util.Verbosity >= util.VERBOSITY_VERBOSE && env == nil {
+			return util.ShellInteractiveCommand(append([]string{gitPath},
+				cmd...), nil)
+		}
+
+		_, err := executeGitCommandEnv(filepath.Dir(tmpdir), cmd, env)
+		return err
+	})
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		return "", err
+	}
+
+	if len(gd.SparsePaths) > 0 {
+		if err := configureSparseCheckout(tmpdir,
+			gd.SparsePaths); err != nil {
+
+			os.RemoveAll(tmpdir)
 			return "", err
 		}
 	}
@@ -360,6 +640,14 @@ func (gd *GithubDownloader) DownloadRepo(commit string) (string, error) {
 		return "", err
 	}
 
+	if len(gd.SparsePaths) > 0 {
+		// Populate the working tree according to the sparse checkout rules.
+		_, err := executeGitCommand(tmpdir, []string{"read-tree", "-mu", "HEAD"})
+		if err != nil {
+			return "", err
+		}
+	}
+
 	return tmpdir, nil
 }
 
@@ -399,6 +687,18 @@ func (ld *LocalDownloader) LocalDiff(path string) ([]byte, error) {
 	return executeGitCommand(path, []string{"diff"})
 }
 
+func (ld *LocalDownloader) HeadCommit(path string) (string, error) {
+	return headCommit(path)
+}
+
+func (ld *LocalDownloader) TreeHash(path string) (string, error) {
+	return treeHash(path)
+}
+
+func (ld *LocalDownloader) CheckoutCommit(path string, commit string) error {
+	return checkout(path, commit)
+}
+
 func (ld *LocalDownloader) DownloadRepo(commit string) (string, error) {
 	// Get a temporary directory, and copy the repository into that directory.
 	tmpdir, err := ioutil.TempDir("", "newt-repo")
@@ -406,7 +706,7 @@ func (ld *LocalDownloader) DownloadRepo(commit string) (string, error) {
 		return "", err
 	}
 
-	util.StatusMessage(util.VERBOSITY_VERBOSE,
+	statusMessage(util.VERBOSITY_VERBOSE,
 		"Downloading local repository %s\n", ld.Path)
 
 	if err := util.CopyDir(ld.Path, tmpdir); err != nil {
@@ -442,6 +742,33 @@ func LoadDownloader(repoName string, repoVars map[string]string) (
 		gd.Token = repoVars["token"]
 		gd.Login = repoVars["login"]
 		gd.Password = repoVars["password"]
+		gd.TokenEnv = repoVars["token_env"]
+
+		switch repoVars["auth"] {
+		case "", "https":
+		case "ssh":
+			gd.UseSsh = true
+		default:
+			return nil, util.FmtNewtError(
+				"Invalid auth method for repository %s: %s; must be "+
+					"\"https\" or \"ssh\"", repoName, repoVars["auth"])
+		}
+
+		if repoVars["depth"] != "" {
+			depth, err := util.AtoiNoOct(repoVars["depth"])
+			if err != nil || depth < 0 {
+				return nil, util.FmtNewtError(
+					"Invalid clone depth for repository %s: %s",
+					repoName, repoVars["depth"])
+			}
+			gd.Depth = depth
+		}
+
+		// Sparse checkout paths are separated by commas or whitespace.
+		gd.SparsePaths = strings.FieldsFunc(repoVars["sparse"],
+			func(r rune) bool {
+				return r == ',' || unicode.IsSpace(r)
+			})
 
 		// Alternatively, the user can put security material in
 		// $HOME/.newt/repos.yml.
@@ -457,9 +784,47 @@ func LoadDownloader(repoName string, repoVars map[string]string) (
 			if gd.Password == "" {
 				gd.Password = privRepo["password"]
 			}
+			if gd.TokenEnv == "" {
+				gd.TokenEnv = privRepo["token_env"]
+			}
 		}
 		return gd, nil
 
+	case "archive":
+		ad := NewArchiveDownloader()
+		ad.Name = repoName
+		ad.Url = repoVars["url"]
+		ad.Sha256 = repoVars["sha256"]
+		ad.Vers = repoVars["vers"]
+
+		if ad.Url == "" {
+			return nil, util.FmtNewtError(
+				"Missing url for archive repository %s", repoName)
+		}
+		if ad.archiveExt() == "" {
+			return nil, util.FmtNewtError(
+				"Unsupported archive format for repository %s: %s; must be "+
+					".tar, .tar.gz, .tgz, .tar.bz2, .tbz2, or .zip",
+				repoName, ad.Url)
+		}
+
+		// The checksum is mandatory; an archive at a given URL can silently
+		// change.
+		if ad.Sha256 == "" {
+			return nil, util.FmtNewtError(
+				"Missing sha256 for archive repository %s", repoName)
+		}
+		return ad, nil
+
+	case "hg":
+		hd := NewHgDownloader()
+		hd.Url = repoVars["url"]
+		if hd.Url == "" {
+			return nil, util.FmtNewtError(
+				"Missing url for hg repository %s", repoName)
+		}
+		return hd, nil
+
 	case "local":
 		ld := NewLocalDownloader()
 		ld.Path = repoVars["path"]
@@ -470,3 +835,8 @@ func LoadDownloader(repoName string, repoVars map[string]string) (
 			repoVars["type"])
 	}
 }
+
+// Prints a status message according to the downloader's verbosity.
+func statusMessage(level int, message string, args ...interface{}) {
+	util.SubsysStatusMessage(util.SUBSYS_DOWNLOADER, level, message, args...)
+}
@@ -0,0 +1,225 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package downloader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+// Downloads a repo hosted in Mercurial.
+type HgDownloader struct {
+	GenericDownloader
+	Url string
+}
+
+func executeHgCommand(dir string, cmd []string) ([]byte, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, util.NewNewtError(err.Error())
+	}
+
+	hgPath, err := exec.LookPath("hg")
+	if err != nil {
+		return nil, util.FmtNewtError("Can't find hg binary: %s\n",
+			err.Error()).WithClass(util.ERR_CLASS_TOOL_MISSING)
+	}
+	hgPath = filepath.ToSlash(hgPath)
+
+	if err := os.Chdir(dir); err != nil {
+		return nil, util.NewNewtError(err.Error())
+	}
+
+	defer os.Chdir(wd)
+
+	// Ignore the user's hgrc settings that change command output.
+	hgCmd := []string{hgPath, "--noninteractive"}
+	hgCmd = append(hgCmd, cmd...)
+
+	return util.ShellCommand(hgCmd, []string{"HGPLAIN=1"})
+}
+
+// Newt asks for "master" when it wants the mainline; Mercurial calls it
+// "default".
+func hgRevision(branch string) string {
+	if branch == "master" {
+		return "default"
+	}
+	return branch
+}
+
+func (hd *HgDownloader) FetchFile(name string, dest string) error {
+	if err := checkOnline(fmt.Sprintf("download %s from %s",
+		name, hd.Url)); err != nil {
+
+		return err
+	}
+
+	tmpdir, err := ioutil.TempDir("", "newt-fetch")
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if _, err := executeHgCommand(filepath.Dir(tmpdir), []string{
+		"clone", "--noupdate", mirrorUrl(hd.Url), tmpdir,
+	}); err != nil {
+		return err
+	}
+
+	contents, err := executeHgCommand(tmpdir, []string{
+		"cat", "-r", hgRevision(hd.Branch()), name,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(dest, contents, 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Reports the tag at the working directory's parent, if any; otherwise the
+// named branch.
+func (hd *HgDownloader) CurrentBranch(path string) (string, error) {
+	output, err := executeHgCommand(path,
+		[]string{"log", "-r", ".", "--template", "{tags}"})
+	if err != nil {
+		return "", err
+	}
+	for _, tag := range strings.Fields(string(output)) {
+		if tag != "tip" {
+			return tag, nil
+		}
+	}
+
+	output, err = executeHgCommand(path, []string{"branch"})
+	if err != nil {
+		return "", err
+	}
+
+	branch := strings.TrimSpace(string(output))
+	if branch == "default" {
+		branch = "master"
+	}
+	return branch, nil
+}
+
+func (hd *HgDownloader) UpdateRepo(path string, branchName string) error {
+	if !newtutil.NewtOffline {
+		statusMessage(util.VERBOSITY_VERBOSE,
+			"Pulling new remote changesets\n")
+		if _, err := executeHgCommand(path, []string{"pull"}); err != nil {
+			return err
+		}
+	}
+
+	// Unlike git, hg carries uncommitted changes across an update.
+	_, err := executeHgCommand(path,
+		[]string{"update", "-r", hgRevision(branchName)})
+	return err
+}
+
+func (hd *HgDownloader) CleanupRepo(path string, branchName string) error {
+	_, err := executeHgCommand(path, []string{
+		"update", "--clean", "-r", hgRevision(branchName),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = executeHgCommand(path,
+		[]string{"--config", "extensions.purge=", "purge"})
+	return err
+}
+
+func (hd *HgDownloader) LocalDiff(path string) ([]byte, error) {
+	return executeHgCommand(path, []string{"diff"})
+}
+
+func (hd *HgDownloader) HeadCommit(path string) (string, error) {
+	output, err := executeHgCommand(path,
+		[]string{"log", "-r", ".", "--template", "{node}"})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Mercurial has no separate hash for a revision's contents; the changeset
+// hash already covers them.
+func (hd *HgDownloader) TreeHash(path string) (string, error) {
+	return "", nil
+}
+
+func (hd *HgDownloader) CheckoutCommit(path string, commit string) error {
+	if !newtutil.NewtOffline {
+		if _, err := executeHgCommand(path, []string{"pull"}); err != nil {
+			return err
+		}
+	}
+
+	_, err := executeHgCommand(path, []string{"update", "-r", commit})
+	return err
+}
+
+func (hd *HgDownloader) DownloadRepo(commit string) (string, error) {
+	if err := checkOnline("clone repository " + hd.Url); err != nil {
+		return "", err
+	}
+
+	tmpdir, err := ioutil.TempDir("", "newt-repo")
+	if err != nil {
+		return "", err
+	}
+
+	url := mirrorUrl(hd.Url)
+	statusMessage(util.VERBOSITY_VERBOSE, "Downloading "+
+		"repository %s (commit: %s)\n", url, commit)
+
+	err = retryNet("clone "+url, func() error {
+		if err := resetDir(tmpdir); err != nil {
+			return permanentErr(err)
+		}
+
+		_, err := executeHgCommand(filepath.Dir(tmpdir), []string{
+			"clone", "-u", hgRevision(commit), url, tmpdir,
+		})
+		return err
+	})
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		return "", err
+	}
+
+	return tmpdir, nil
+}
+
+func NewHgDownloader() *HgDownloader {
+	return &HgDownloader{}
+}
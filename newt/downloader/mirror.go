@@ -0,0 +1,165 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package downloader
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Maps URL prefixes to their replacements (e.g., "https://github.com/" ->
+// "https://git.example.com/mirror/github/").
+var urlMirrors map[string]string
+
+// Directory containing bare mirrors of downloaded repos, shared among
+// projects.  Empty if no cache is configured.
+var cacheDir string
+
+func SetMirrors(mirrors map[string]string) {
+	urlMirrors = mirrors
+}
+
+func SetCacheDir(dir string) {
+	cacheDir = dir
+}
+
+func CacheDir() string {
+	return cacheDir
+}
+
+type prefixSorter struct {
+	prefixes []string
+}
+
+func (s prefixSorter) Len() int {
+	return len(s.prefixes)
+}
+func (s prefixSorter) Swap(i, j int) {
+	s.prefixes[i], s.prefixes[j] = s.prefixes[j], s.prefixes[i]
+}
+func (s prefixSorter) Less(i, j int) bool {
+	return len(s.prefixes[i]) > len(s.prefixes[j])
+}
+
+// Applies the configured mirror rewrites to the specified URL.  If several
+// prefixes match, the longest one wins.
+func mirrorUrl(url string) string {
+	prefixes := make([]string, 0, len(urlMirrors))
+	for prefix, _ := range urlMirrors {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Sort(prefixSorter{prefixes})
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(url, prefix) {
+			mirrored := urlMirrors[prefix] + strings.TrimPrefix(url, prefix)
+			log.Debugf("Mirroring %s -> %s", url, mirrored)
+			return mirrored
+		}
+	}
+
+	return url
+}
+
+// Indicates whether the specified URL gets rewritten by a mirror.
+func isMirrored(url string) bool {
+	return mirrorUrl(url) != url
+}
+
+var cacheNameRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Returns the path of the cached bare mirror for the specified URL.
+func cachePath(url string) string {
+	// Keep the name readable, but disambiguate URLs that sanitize to the same
+	// string.
+	name := strings.Trim(cacheNameRe.ReplaceAllString(url, "_"), "_")
+	sum := sha1.Sum([]byte(url))
+
+	return fmt.Sprintf("%s/%s-%x.git", cacheDir, name, sum[:4])
+}
+
+// Brings the cached mirror of the specified URL up to date, creating it if
+// necessary.
+//
+// @return                      The path of the cached mirror.
+func updateCache(url string, env []string) (string, error) {
+	path := cachePath(url)
+
+	if util.NodeExist(path) {
+		statusMessage(util.VERBOSITY_VERBOSE,
+			"Updating cached mirror %s\n", path)
+		err := retryNet("update cached mirror of "+url, func() error {
+			_, err := executeGitCommandEnv(path, append(gitNetArgs(),
+				"fetch", "--prune", "--tags", "origin"), env)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	// Clone into a temporary directory and then move the result into place.
+	// This prevents concurrent newt processes from seeing a partial mirror.
+	tmpdir, err := ioutil.TempDir(cacheDir, ".newt-cache")
+	if err != nil {
+		return "", util.ChildNewtError(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	statusMessage(util.VERBOSITY_VERBOSE,
+		"Creating cached mirror of %s at %s\n", url, path)
+
+	err = retryNet("create cached mirror of "+url, func() error {
+		if err := os.RemoveAll(tmpdir + "/m"); err != nil {
+			return permanentErr(util.ChildNewtError(err))
+		}
+
+		_, err := executeGitCommandEnv(cacheDir, append(gitNetArgs(),
+			"clone", "--mirror", url, filepath.Base(tmpdir)+"/m"), env)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmpdir+"/m", path); err != nil {
+		// Another process may have populated the cache first.
+		if util.NodeExist(path) {
+			return path, nil
+		}
+		return "", util.ChildNewtError(err)
+	}
+
+	return path, nil
+}
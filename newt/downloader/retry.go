@@ -0,0 +1,236 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package downloader
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+const DEFAULT_NET_TIMEOUT = 60 * time.Second
+const DEFAULT_NET_RETRIES = 3
+
+// The longest delay between attempts.
+const NET_BACKOFF_MAX = 30 * time.Second
+
+// How long a network operation may go without making progress before it is
+// abandoned.  For git, this is the time spent below one KB/s.
+var netTimeout = DEFAULT_NET_TIMEOUT
+
+// The number of times a failed network operation is retried.
+var netRetries = DEFAULT_NET_RETRIES
+
+// The delay before the first retry; it doubles with each subsequent one.
+var netBackoffBase = time.Second
+
+func SetNetTimeout(timeout time.Duration) {
+	netTimeout = timeout
+}
+
+func SetNetRetries(retries int) {
+	netRetries = retries
+}
+
+// An error that retrying will not fix, e.g., an HTTP 404.
+type permanentNetError struct {
+	err error
+}
+
+func (e *permanentNetError) Error() string {
+	return e.err.Error()
+}
+
+func permanentErr(err error) error {
+	return &permanentNetError{err}
+}
+
+func netBackoff(attempt int) time.Duration {
+	d := netBackoffBase << uint(attempt)
+	if d > NET_BACKOFF_MAX || d <= 0 {
+		d = NET_BACKOFF_MAX
+	}
+	return d
+}
+
+// Runs a network operation, retrying with exponential backoff if it fails.
+// The operation indicates that an error is not worth retrying by wrapping it
+// with permanentErr().
+func retryNet(desc string, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+
+		if perm, ok := err.(*permanentNetError); ok {
+			return util.ClassifyError(perm.err, util.ERR_CLASS_NETWORK)
+		}
+		if attempt >= netRetries {
+			break
+		}
+
+		delay := netBackoff(attempt)
+		statusMessage(util.VERBOSITY_DEFAULT,
+			"Failed to %s (attempt %d of %d); retrying in %s\n",
+			desc, attempt+1, netRetries+1, delay)
+		log.Debugf("%s", err.Error())
+		time.Sleep(delay)
+	}
+
+	if netRetries == 0 {
+		return util.ClassifyError(err, util.ERR_CLASS_NETWORK)
+	}
+	return util.FmtNewtError("%s (gave up after %d attempts)",
+		err.Error(), netRetries+1).WithClass(util.ERR_CLASS_NETWORK)
+}
+
+// Empties the specified directory, creating it if necessary.
+func resetDir(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+	return nil
+}
+
+// Git configuration that makes a network operation fail rather than hang
+// when a connection stalls.  Prepend to the command of any git operation
+// that contacts a remote.
+func gitNetArgs() []string {
+	secs := int(netTimeout / time.Second)
+	if secs <= 0 {
+		return nil
+	}
+
+	return []string{
+		"-c", "http.lowSpeedLimit=1000",
+		"-c", "http.lowSpeedTime=" + strconv.Itoa(secs),
+	}
+}
+
+// Returns an HTTP client that gives up on connections that can't be
+// established or that don't respond within the network timeout.  The total
+// duration of a request is not limited; use netBody() to detect a stalled
+// transfer.
+func netHttpClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   netTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   netTimeout,
+			ResponseHeaderTimeout: netTimeout,
+		},
+	}
+}
+
+// Cancels a request if reads from its body stall for longer than the network
+// timeout.
+type idleTimeoutReader struct {
+	r     io.Reader
+	timer *time.Timer
+	mtx   sync.Mutex
+	fired bool
+}
+
+func (itr *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := itr.r.Read(p)
+
+	itr.mtx.Lock()
+	defer itr.mtx.Unlock()
+
+	if itr.fired {
+		return n, util.FmtNewtError(
+			"transfer stalled for more than %s", netTimeout)
+	}
+	itr.timer.Reset(netTimeout)
+	return n, err
+}
+
+// Issues an HTTP request with the network timeout applied to the response
+// body as well as to the connection.  The caller must close the response
+// body and call the returned function when done with the response.
+func netDo(req *http.Request) (*http.Response, func(), error) {
+	ctx, cancel := context.WithCancel(req.Context())
+
+	rsp, err := netHttpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	if netTimeout <= 0 {
+		return rsp, cancel, nil
+	}
+
+	itr := &idleTimeoutReader{r: rsp.Body}
+	itr.timer = time.AfterFunc(netTimeout, func() {
+		itr.mtx.Lock()
+		itr.fired = true
+		itr.mtx.Unlock()
+		cancel()
+	})
+	rsp.Body = struct {
+		io.Reader
+		io.Closer
+	}{itr, rsp.Body}
+
+	done := func() {
+		itr.timer.Stop()
+		cancel()
+	}
+
+	return rsp, done, nil
+}
+
+// Creates an error describing an unsuccessful HTTP response.  Client errors
+// other than timeouts and rate limiting are not worth retrying.
+func httpStatusErr(rsp *http.Response, msg string) error {
+	err := util.NewNewtError(msg)
+
+	switch {
+	case rsp.StatusCode == http.StatusRequestTimeout,
+		rsp.StatusCode == http.StatusTooManyRequests:
+		return err
+
+	case rsp.StatusCode >= 400 && rsp.StatusCode < 500:
+		return permanentErr(err)
+
+	default:
+		return err
+	}
+}
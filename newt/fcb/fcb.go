@@ -0,0 +1,163 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package fcb lays out flash circular buffers (mynewt's fs/fcb) on the host,
+// so that an FCB area can be pre-populated at manufacturing time.
+package fcb
+
+import (
+	"encoding/binary"
+
+	"mynewt.apache.org/newt/util"
+)
+
+const FCB_DISK_AREA_SIZE = 8
+
+// Largest entry an FCB can hold; lengths are stored in at most two bytes of
+// seven bits each.
+const FCB_MAX_LEN = 0x3fff
+
+// Geometry and identity of an FCB.
+type Params struct {
+	// Size of the flash area, in bytes.
+	Size int
+
+	// Size of each FCB sector; usually the flash sector size.
+	SectorSize int
+
+	// Flash write alignment.  Lengths, data and CRCs each start on an
+	// aligned offset.
+	Align int
+
+	// Must match the f_magic and f_version the runtime initializes the FCB
+	// with.
+	Magic   uint32
+	Version uint8
+
+	// Sectors that must be left empty so the runtime can rotate (the FCB's
+	// f_scratch_cnt).
+	ScratchCnt int
+}
+
+// CRC-8 with polynomial 0x07 and initial value 0xff, as computed by
+// crc8_calc() in mynewt's util/crc.
+func crc8(crc uint8, data []byte) uint8 {
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Encodes an entry length: one byte below 0x80, else two with the high bit
+// of the first set.
+func encodeLen(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+	return []byte{byte(length&0x7f) | 0x80, byte(length >> 7)}
+}
+
+func (p *Params) lenInFlash(length int) int {
+	if p.Align <= 1 {
+		return length
+	}
+	return (length + p.Align - 1) / p.Align * p.Align
+}
+
+// Builds an image of the flash area holding the specified entries, in
+// order.  Sectors are filled from the start of the area; the rest of the
+// area is left erased.  Also returns the number of sectors used.
+func Build(entries [][]byte, p Params) ([]byte, int, error) {
+	if p.Align <= 0 {
+		p.Align = 1
+	}
+	if p.SectorSize <= 0 || p.Size%p.SectorSize != 0 {
+		return nil, 0, util.FmtNewtError("Flash area size %d is not a "+
+			"multiple of the FCB sector size %d", p.Size, p.SectorSize)
+	}
+	if p.SectorSize%p.Align != 0 {
+		return nil, 0, util.FmtNewtError("FCB sector size %d is not a "+
+			"multiple of the write alignment %d", p.SectorSize, p.Align)
+	}
+
+	numSectors := p.Size / p.SectorSize
+	if numSectors > 0xffff {
+		return nil, 0, util.FmtNewtError("Too many FCB sectors (%d)",
+			numSectors)
+	}
+
+	img := make([]byte, p.Size)
+	for i := range img {
+		img[i] = 0xff
+	}
+
+	hdrSize := p.lenInFlash(FCB_DISK_AREA_SIZE)
+	sector := -1
+	off := p.SectorSize
+
+	for i, entry := range entries {
+		if len(entry) > FCB_MAX_LEN {
+			return nil, 0, util.FmtNewtError("FCB entry %d too long "+
+				"(%d > %d bytes)", i, len(entry), FCB_MAX_LEN)
+		}
+
+		lenBytes := encodeLen(len(entry))
+		size := p.lenInFlash(len(lenBytes)) + p.lenInFlash(len(entry)) +
+			p.lenInFlash(1)
+		if hdrSize+size > p.SectorSize {
+			return nil, 0, util.FmtNewtError("FCB entry %d (%d bytes) "+
+				"does not fit in a %d-byte sector", i, len(entry),
+				p.SectorSize)
+		}
+
+		if off+size > p.SectorSize {
+			sector++
+			if sector+p.ScratchCnt >= numSectors {
+				return nil, 0, util.FmtNewtError("FCB entries don't fit "+
+					"in %d sectors of %d bytes (%d kept empty)",
+					numSectors, p.SectorSize, p.ScratchCnt)
+			}
+
+			hdr := img[sector*p.SectorSize:]
+			binary.LittleEndian.PutUint32(hdr[0:], p.Magic)
+			hdr[4] = p.Version
+			hdr[5] = 0xff
+			binary.LittleEndian.PutUint16(hdr[6:], uint16(sector))
+			off = hdrSize
+		}
+
+		buf := img[sector*p.SectorSize+off:]
+		copy(buf, lenBytes)
+		dataOff := p.lenInFlash(len(lenBytes))
+		copy(buf[dataOff:], entry)
+		crcOff := dataOff + p.lenInFlash(len(entry))
+		buf[crcOff] = crc8(crc8(0xff, lenBytes), entry)
+
+		off += size
+	}
+
+	return img, sector + 1, nil
+}
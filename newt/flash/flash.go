@@ -20,7 +20,6 @@
 package flash
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -59,23 +58,64 @@ const C_VAR_COMMENT = `/**
  */
 `
 
+// Kinds of memory a flash area can reside in.  Only ordinary flash is erased
+// in bulk; EEPROM and one-time programmable (OTP) areas (e.g., UICR or eFuse
+// regions) need to be programmed individually, and OTP areas can only be
+// written once.
+const (
+	FLASH_AREA_TYPE_FLASH  = "flash"
+	FLASH_AREA_TYPE_EEPROM = "eeprom"
+	FLASH_AREA_TYPE_OTP    = "otp"
+)
+
 type FlashArea struct {
 	Name   string
 	Id     int
 	Device int
 	Offset int
 	Size   int
+
+	// One of the FLASH_AREA_TYPE_[...] constants.
+	Type string
+
+	// File (.hex or .bin) to program into the area, if any.  The BSP package
+	// resolves it relative to its directory.
+	Data string
+}
+
+// Describes a single flash device (internal flash, external SPI / QSPI flash,
+// etc.).  The base address is the location of the device in the MCU's address
+// space; it is zero for devices that are not memory mapped.
+//
+// The offsets of areas in a memory-mapped device may be given relative to the
+// device or, as BSPs traditionally do for internal flash, as absolute
+// addresses; an offset at or above the device's base address is absolute.
+type FlashDevice struct {
+	Name string
+	Id   int
+	Base int
+	Size int
 }
 
 type FlashMap struct {
 	Areas       map[string]FlashArea
+	Devices     map[int]FlashDevice
 	Overlaps    [][]FlashArea
 	IdConflicts [][]FlashArea
+
+	// Areas that extend beyond the end of their host device.
+	OutOfBounds []FlashArea
+
+	// The YAML key that defines the flash map; set by the BSP package.
+	// Generated code attributes each area and device to its entry under this
+	// key.
+	YamlSrc newtutil.YamlSource
 }
 
 func newFlashMap() FlashMap {
 	return FlashMap{
 		Areas:    map[string]FlashArea{},
+		Devices:  map[int]FlashDevice{},
 		Overlaps: [][]FlashArea{},
 	}
 }
@@ -108,6 +148,7 @@ func parseFlashArea(
 
 	area := FlashArea{
 		Name: name,
+		Type: FLASH_AREA_TYPE_FLASH,
 	}
 
 	idPresent := false
@@ -152,10 +193,25 @@ func parseFlashArea(
 		case "size":
 			area.Size, err = parseSize(v)
 			if err != nil {
-				return area, flashAreaErr(name, err.Error())
+				return area, flashAreaErr(name, "%s", err.Error())
 			}
 			sizePresent = true
 
+		case "type":
+			switch v {
+			case FLASH_AREA_TYPE_FLASH, FLASH_AREA_TYPE_EEPROM,
+				FLASH_AREA_TYPE_OTP:
+
+				area.Type = v
+			default:
+				return area, flashAreaErr(name, "invalid type: %s; must be "+
+					"one of %s, %s, %s", v, FLASH_AREA_TYPE_FLASH,
+					FLASH_AREA_TYPE_EEPROM, FLASH_AREA_TYPE_OTP)
+			}
+
+		case "data":
+			area.Data = v
+
 		default:
 			util.StatusMessage(util.VERBOSITY_QUIET,
 				"Warning: flash area \"%s\" contains unrecognized field: %s",
@@ -175,10 +231,86 @@ func parseFlashArea(
 	if !sizePresent {
 		return area, flashAreaErr(name, "required field \"size\" missing")
 	}
+	if area.Data != "" && area.BulkErasable() {
+		return area, flashAreaErr(name, "\"data\" is only supported for "+
+			"%s and %s areas", FLASH_AREA_TYPE_EEPROM, FLASH_AREA_TYPE_OTP)
+	}
 
 	return area, nil
 }
 
+// Indicates whether the area can only be written once.
+func (area FlashArea) WriteOnce() bool {
+	return area.Type == FLASH_AREA_TYPE_OTP
+}
+
+// Indicates whether the area is erased along with the rest of its device,
+// i.e., whether it may be part of an image that is written after a bulk
+// erase.
+func (area FlashArea) BulkErasable() bool {
+	return area.Type == FLASH_AREA_TYPE_FLASH
+}
+
+func flashDeviceErr(devName string, format string, args ...interface{}) error {
+	return util.NewNewtError(
+		"failure while parsing flash device \"" + devName + "\": " +
+			fmt.Sprintf(format, args...))
+}
+
+func parseFlashDevice(
+	name string, ymlFields map[string]interface{}) (FlashDevice, error) {
+
+	dev := FlashDevice{
+		Name: name,
+	}
+
+	idPresent := false
+
+	var err error
+
+	fields := cast.ToStringMapString(ymlFields)
+	for k, v := range fields {
+		switch k {
+		case "id":
+			dev.Id, err = util.AtoiNoOct(v)
+			if err != nil {
+				return dev, flashDeviceErr(name, "invalid id: %s", v)
+			}
+			idPresent = true
+
+		case "base":
+			dev.Base, err = util.AtoiNoOct(v)
+			if err != nil {
+				return dev, flashDeviceErr(name, "invalid base: %s", v)
+			}
+
+		case "size":
+			dev.Size, err = parseSize(v)
+			if err != nil {
+				return dev, flashDeviceErr(name, "%s", err.Error())
+			}
+
+		default:
+			util.StatusMessage(util.VERBOSITY_QUIET,
+				"Warning: flash device \"%s\" contains unrecognized "+
+					"field: %s", name, k)
+		}
+	}
+
+	if !idPresent {
+		return dev, flashDeviceErr(name, "required field \"id\" missing")
+	}
+
+	// Otherwise, an area offset could be read either way.
+	if dev.Base != 0 && dev.Size > dev.Base {
+		return dev, flashDeviceErr(name, "base address 0x%x is less than "+
+			"the device size (0x%x); area offsets would be ambiguous",
+			dev.Base, dev.Size)
+	}
+
+	return dev, nil
+}
+
 func (flashMap FlashMap) unSortedAreas() []FlashArea {
 	areas := make([]FlashArea, 0, len(flashMap.Areas))
 	for _, area := range flashMap.Areas {
@@ -221,19 +353,72 @@ func (flashMap FlashMap) DeviceIds() []int {
 	return devices
 }
 
-func areasDistinct(a FlashArea, b FlashArea) bool {
-	var lo FlashArea
-	var hi FlashArea
+// Retrieves the base address of the specified flash device.  Devices which
+// are not explicitly described by the flash map are assumed to have a base
+// address of 0.
+func (flashMap FlashMap) DeviceBase(deviceId int) int {
+	return flashMap.Devices[deviceId].Base
+}
 
-	if a.Offset < b.Offset {
-		lo = a
-		hi = b
-	} else {
-		lo = b
-		hi = a
+// Converts an offset in the specified flash device to an absolute address.
+// An offset at or above the device's base address already is one.
+func (flashMap FlashMap) DeviceAddress(deviceId int, offset int) int {
+	base := flashMap.DeviceBase(deviceId)
+	if base != 0 && offset >= base {
+		return offset
 	}
 
-	return lo.Device != hi.Device || lo.Offset+lo.Size <= hi.Offset
+	return base + offset
+}
+
+// Converts an offset in the specified flash device, which may be an absolute
+// address, to one relative to the start of the device.
+func (flashMap FlashMap) deviceOffset(deviceId int, offset int) int {
+	return flashMap.DeviceAddress(deviceId, offset) -
+		flashMap.DeviceBase(deviceId)
+}
+
+// Retrieves the absolute address of the start of the specified area.
+func (flashMap FlashMap) AreaAddress(area FlashArea) int {
+	return flashMap.DeviceAddress(area.Device, area.Offset)
+}
+
+// Retrieves the offset of the end of the specified area relative to the
+// start of its device.
+func (flashMap FlashMap) areaDeviceEnd(area FlashArea) int {
+	return flashMap.deviceOffset(area.Device, area.Offset) + area.Size
+}
+
+func (flashMap FlashMap) sortedDevices() []FlashDevice {
+	ids := make([]int, 0, len(flashMap.Devices))
+	for id, _ := range flashMap.Devices {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	devices := make([]FlashDevice, len(ids))
+	for i, id := range ids {
+		devices[i] = flashMap.Devices[id]
+	}
+
+	return devices
+}
+
+func (flashMap FlashMap) areasDistinct(a FlashArea, b FlashArea) bool {
+	if a.Device != b.Device {
+		return true
+	}
+
+	// Compare device-relative offsets; one area may specify an absolute
+	// address and the other not.
+	aOff := flashMap.deviceOffset(a.Device, a.Offset)
+	bOff := flashMap.deviceOffset(b.Device, b.Offset)
+
+	if aOff < bOff {
+		return aOff+a.Size <= bOff
+	} else {
+		return bOff+b.Size <= aOff
+	}
 }
 
 func (flashMap *FlashMap) detectOverlaps() {
@@ -247,7 +432,7 @@ func (flashMap *FlashMap) detectOverlaps() {
 		for j := i + 1; j < len(areas); j++ {
 			jarea := areas[j]
 
-			if !areasDistinct(iarea, jarea) {
+			if !flashMap.areasDistinct(iarea, jarea) {
 				flashMap.Overlaps = append(
 					flashMap.Overlaps, []FlashArea{iarea, jarea})
 			}
@@ -260,9 +445,30 @@ func (flashMap *FlashMap) detectOverlaps() {
 	}
 }
 
+func (flashMap *FlashMap) detectOutOfBounds() {
+	flashMap.OutOfBounds = []FlashArea{}
+
+	for _, area := range flashMap.SortedAreas() {
+		dev, ok := flashMap.Devices[area.Device]
+		if ok && dev.Size != 0 && flashMap.areaDeviceEnd(area) > dev.Size {
+			flashMap.OutOfBounds = append(flashMap.OutOfBounds, area)
+		}
+	}
+}
+
 func (flashMap FlashMap) ErrorText() string {
 	str := ""
 
+	if len(flashMap.OutOfBounds) > 0 {
+		str += "Flash areas exceed size of host device:\n"
+
+		for _, area := range flashMap.OutOfBounds {
+			dev := flashMap.Devices[area.Device]
+			str += fmt.Sprintf("    %s (device=%s end=0x%x size=0x%x)\n",
+				area.Name, dev.Name, flashMap.areaDeviceEnd(area), dev.Size)
+		}
+	}
+
 	if len(flashMap.IdConflicts) > 0 {
 		str += "Conflicting flash area IDs detected:\n"
 
@@ -286,6 +492,23 @@ func (flashMap FlashMap) ErrorText() string {
 func Read(ymlFlashMap map[string]interface{}) (FlashMap, error) {
 	flashMap := newFlashMap()
 
+	// The "devices" mapping is optional.  If it is absent, all areas are
+	// assumed to reside in devices with a base address of 0.
+	ymlDevices := cast.ToStringMap(ymlFlashMap["devices"])
+	for k, v := range ymlDevices {
+		dev, err := parseFlashDevice(k, cast.ToStringMap(v))
+		if err != nil {
+			return flashMap, err
+		}
+
+		if other, ok := flashMap.Devices[dev.Id]; ok {
+			return flashMap, flashDeviceErr(k,
+				"id %d conflicts with device \"%s\"", dev.Id, other.Name)
+		}
+
+		flashMap.Devices[dev.Id] = dev
+	}
+
 	ymlAreas := ymlFlashMap["areas"]
 	if ymlAreas == nil {
 		return flashMap, util.NewNewtError(
@@ -301,13 +524,21 @@ func Read(ymlFlashMap map[string]interface{}) (FlashMap, error) {
 		ymlArea := cast.ToStringMap(v)
 		area, err := parseFlashArea(k, ymlArea)
 		if err != nil {
-			return flashMap, flashAreaErr(k, err.Error())
+			return flashMap, flashAreaErr(k, "%s", err.Error())
+		}
+
+		if len(flashMap.Devices) > 0 {
+			if _, ok := flashMap.Devices[area.Device]; !ok {
+				return flashMap, flashAreaErr(k,
+					"references undefined flash device: %d", area.Device)
+			}
 		}
 
 		flashMap.Areas[k] = area
 	}
 
 	flashMap.detectOverlaps()
+	flashMap.detectOutOfBounds()
 
 	return flashMap, nil
 }
@@ -317,12 +548,26 @@ func (flashMap FlashMap) varDecl() string {
 		len(flashMap.Areas))
 }
 
+// Identifies the YAML entry that defines the named area or device.  Returns
+// nil if the flash map's source is unknown.
+func (flashMap FlashMap) entrySources(
+	mapping string, name string) []newtutil.YamlSource {
+
+	if flashMap.YamlSrc.Path == "" {
+		return nil
+	}
+
+	src := flashMap.YamlSrc
+	src.Key = append(append([]string{}, src.Key...), mapping, name)
+	return []newtutil.YamlSource{src}
+}
+
 func (area FlashArea) writeHeader(w io.Writer) {
 	fmt.Fprintf(w, "#define %-40s %d\n", area.Name, area.Id)
 }
 
-func (flashMap FlashMap) writeHeader(w io.Writer) {
-	fmt.Fprintf(w, newtutil.GeneratedPreamble())
+func (flashMap FlashMap) writeHeader(w *newtutil.GenWriter) {
+	fmt.Fprint(w, newtutil.GeneratedPreamble())
 
 	fmt.Fprintf(w, "#ifndef H_MYNEWT_SYSFLASH_\n")
 	fmt.Fprintf(w, "#define H_MYNEWT_SYSFLASH_\n")
@@ -333,8 +578,22 @@ func (flashMap FlashMap) writeHeader(w io.Writer) {
 	fmt.Fprintf(w, "extern %s;\n", flashMap.varDecl())
 	fmt.Fprintf(w, "\n")
 
+	devices := flashMap.sortedDevices()
+	if len(devices) > 0 {
+		for _, dev := range devices {
+			w.Begin("", flashMap.entrySources("devices", dev.Name)...)
+			fmt.Fprintf(w, "#define %-40s %d\n",
+				"SYSFLASH_DEVICE_"+strings.ToUpper(util.CIdentifier(dev.Name)),
+				dev.Id)
+			w.End()
+		}
+		fmt.Fprintf(w, "\n")
+	}
+
 	for _, area := range flashMap.SortedAreas() {
+		w.Begin("", flashMap.entrySources("areas", area.Name)...)
 		area.writeHeader(w)
+		w.End()
 	}
 
 	fmt.Fprintf(w, "\n#endif\n")
@@ -359,8 +618,8 @@ func (area FlashArea) writeSrc(w io.Writer) {
 	fmt.Fprintf(w, "    },\n")
 }
 
-func (flashMap FlashMap) writeSrc(w io.Writer) {
-	fmt.Fprintf(w, newtutil.GeneratedPreamble())
+func (flashMap FlashMap) writeSrc(w *newtutil.GenWriter) {
+	fmt.Fprint(w, newtutil.GeneratedPreamble())
 
 	fmt.Fprintf(w, "#include \"%s\"\n", HEADER_PATH)
 	fmt.Fprintf(w, "\n")
@@ -369,13 +628,22 @@ func (flashMap FlashMap) writeSrc(w io.Writer) {
 
 	for _, area := range flashMap.SortedAreas() {
 		fmt.Fprintf(w, "\n")
+		w.Begin("    ", flashMap.entrySources("areas", area.Name)...)
 		area.writeSrc(w)
+		w.End()
 	}
 
 	fmt.Fprintf(w, "};\n")
 }
 
-func (flashMap FlashMap) ensureWrittenGen(path string, contents []byte) error {
+func (flashMap FlashMap) ensureWrittenGen(path string,
+	buf *newtutil.GenWriter) error {
+
+	if err := buf.WriteSourceMap(path); err != nil {
+		return err
+	}
+
+	contents := buf.Bytes()
 	writeReqd, err := util.FileContentsChanged(path, contents)
 	if err != nil {
 		return err
@@ -401,19 +669,19 @@ func (flashMap FlashMap) ensureWrittenGen(path string, contents []byte) error {
 func (flashMap FlashMap) EnsureWritten(
 	srcDir string, includeDir string, targetName string) error {
 
-	buf := bytes.Buffer{}
-	flashMap.writeSrc(&buf)
+	buf := newtutil.NewGenWriter()
+	flashMap.writeSrc(buf)
 	if err := flashMap.ensureWrittenGen(
 		fmt.Sprintf("%s/%s-sysflash.c", srcDir, targetName),
-		buf.Bytes()); err != nil {
+		buf); err != nil {
 
 		return err
 	}
 
-	buf = bytes.Buffer{}
-	flashMap.writeHeader(&buf)
+	buf = newtutil.NewGenWriter()
+	flashMap.writeHeader(buf)
 	if err := flashMap.ensureWrittenGen(
-		includeDir+"/"+HEADER_PATH, buf.Bytes()); err != nil {
+		includeDir+"/"+HEADER_PATH, buf); err != nil {
 		return err
 	}
 
@@ -0,0 +1,178 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package flash
+
+import (
+	"testing"
+)
+
+// A flash map like an STM32 BSP's: internal flash mapped at 0x08000000, with
+// areas at absolute addresses, and a QSPI device whose areas are relative.
+func testFlashMap(areas map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"devices": map[string]interface{}{
+			"internal": map[string]interface{}{
+				"id":   "0",
+				"base": "0x08000000",
+				"size": "512kB",
+			},
+			"qspi": map[string]interface{}{
+				"id":   "1",
+				"base": "0x90000000",
+				"size": "1024kB",
+			},
+		},
+		"areas": areas,
+	}
+}
+
+func testArea(device int, offset string, size string) map[string]interface{} {
+	return map[string]interface{}{
+		"device": device,
+		"offset": offset,
+		"size":   size,
+	}
+}
+
+func testUserArea(userId int, device int, offset string,
+	size string) map[string]interface{} {
+
+	area := testArea(device, offset, size)
+	area["user_id"] = userId
+	return area
+}
+
+func TestAreaAddress(t *testing.T) {
+	fm, err := Read(testFlashMap(map[string]interface{}{
+		FLASH_AREA_NAME_BOOTLOADER: testArea(0, "0x08000000", "32kB"),
+		FLASH_AREA_NAME_IMAGE_0:    testArea(0, "0x08008000", "224kB"),
+		FLASH_AREA_NAME_IMAGE_1:    testArea(1, "0x00000000", "224kB"),
+		"FLASH_AREA_NFFS":          testUserArea(0, 1, "0x38000", "32kB"),
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	addrs := map[string]int{
+		FLASH_AREA_NAME_BOOTLOADER: 0x08000000,
+		FLASH_AREA_NAME_IMAGE_0:    0x08008000,
+		FLASH_AREA_NAME_IMAGE_1:    0x90000000,
+		"FLASH_AREA_NFFS":          0x90038000,
+	}
+	for name, addr := range addrs {
+		if got := fm.AreaAddress(fm.Areas[name]); got != addr {
+			t.Errorf("%s: address 0x%x, want 0x%x", name, got, addr)
+		}
+	}
+
+	if text := fm.ErrorText(); text != "" {
+		t.Errorf("unexpected errors:\n%s", text)
+	}
+}
+
+func TestDeviceAddress(t *testing.T) {
+	fm, err := Read(testFlashMap(map[string]interface{}{
+		FLASH_AREA_NAME_BOOTLOADER: testArea(0, "0x08000000", "32kB"),
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	tests := []struct {
+		device int
+		offset int
+		addr   int
+	}{
+		{0, 0x00000000, 0x08000000},
+		{0, 0x00004000, 0x08004000},
+		{0, 0x08004000, 0x08004000},
+		{1, 0x00004000, 0x90004000},
+		{1, 0x90004000, 0x90004000},
+
+		// Not described by the flash map; the base is 0.
+		{2, 0x00004000, 0x00004000},
+	}
+	for _, test := range tests {
+		got := fm.DeviceAddress(test.device, test.offset)
+		if got != test.addr {
+			t.Errorf("device %d offset 0x%x: address 0x%x, want 0x%x",
+				test.device, test.offset, got, test.addr)
+		}
+	}
+}
+
+func TestOutOfBounds(t *testing.T) {
+	fm, err := Read(testFlashMap(map[string]interface{}{
+		// Ends exactly at the end of the internal flash.
+		FLASH_AREA_NAME_IMAGE_0: testArea(0, "0x08040000", "256kB"),
+		// Ends 16kB past it.
+		FLASH_AREA_NAME_IMAGE_1: testArea(0, "0x08044000", "256kB"),
+		// Relative, ends 16kB past the end of the QSPI device.
+		"FLASH_AREA_NFFS": testUserArea(0, 1, "0x000fc000", "32kB"),
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	names := []string{}
+	for _, area := range fm.OutOfBounds {
+		names = append(names, area.Name)
+	}
+	if len(names) != 2 || names[0] != FLASH_AREA_NAME_IMAGE_1 ||
+		names[1] != "FLASH_AREA_NFFS" {
+
+		t.Errorf("out of bounds areas: %v", names)
+	}
+}
+
+func TestOverlapsMixedOffsets(t *testing.T) {
+	// One area specifies an absolute address, the other a relative offset;
+	// they overlap by 16kB.
+	fm, err := Read(testFlashMap(map[string]interface{}{
+		FLASH_AREA_NAME_IMAGE_0: testArea(0, "0x08008000", "32kB"),
+		FLASH_AREA_NAME_IMAGE_1: testArea(0, "0x0000c000", "32kB"),
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if len(fm.Overlaps) != 1 {
+		t.Errorf("expected 1 overlap; got %d", len(fm.Overlaps))
+	}
+}
+
+func TestAmbiguousDeviceBase(t *testing.T) {
+	_, err := Read(map[string]interface{}{
+		"devices": map[string]interface{}{
+			"internal": map[string]interface{}{
+				"id":   "0",
+				"base": "0x1000",
+				"size": "64kB",
+			},
+		},
+		"areas": map[string]interface{}{
+			FLASH_AREA_NAME_IMAGE_0: testArea(0, "0x2000", "4kB"),
+		},
+	})
+	if err == nil {
+		t.Errorf("expected an error for a base address within the " +
+			"device size")
+	}
+}
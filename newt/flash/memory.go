@@ -0,0 +1,199 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package flash
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+const LINKER_SCRIPT_FILENAME = "sysflash.ld"
+
+// A memory region that is not described by the flash map (e.g., RAM, CCM).
+type MemoryRegion struct {
+	Name   string
+	Attrs  string
+	Origin int
+	Length int
+}
+
+func memoryRegionErr(name string, format string, args ...interface{}) error {
+	return util.NewNewtError(
+		"failure while parsing memory region \"" + name + "\": " +
+			fmt.Sprintf(format, args...))
+}
+
+func parseMemoryRegion(
+	name string, ymlFields map[string]interface{}) (MemoryRegion, error) {
+
+	region := MemoryRegion{
+		Name:  name,
+		Attrs: "rwx",
+	}
+
+	originPresent := false
+	lengthPresent := false
+
+	var err error
+
+	fields := cast.ToStringMapString(ymlFields)
+	for k, v := range fields {
+		switch k {
+		case "origin":
+			region.Origin, err = util.AtoiNoOct(v)
+			if err != nil {
+				return region, memoryRegionErr(name, "invalid origin: %s", v)
+			}
+			originPresent = true
+
+		case "length":
+			region.Length, err = parseSize(v)
+			if err != nil {
+				return region, memoryRegionErr(name, "%s", err.Error())
+			}
+			lengthPresent = true
+
+		case "attributes":
+			region.Attrs = v
+
+		default:
+			util.StatusMessage(util.VERBOSITY_QUIET,
+				"Warning: memory region \"%s\" contains unrecognized "+
+					"field: %s", name, k)
+		}
+	}
+
+	if !originPresent {
+		return region, memoryRegionErr(name,
+			"required field \"origin\" missing")
+	}
+	if !lengthPresent {
+		return region, memoryRegionErr(name,
+			"required field \"length\" missing")
+	}
+
+	return region, nil
+}
+
+type memoryRegionSorter struct {
+	regions []MemoryRegion
+}
+
+func (s memoryRegionSorter) Len() int {
+	return len(s.regions)
+}
+func (s memoryRegionSorter) Swap(i, j int) {
+	s.regions[i], s.regions[j] = s.regions[j], s.regions[i]
+}
+func (s memoryRegionSorter) Less(i, j int) bool {
+	if s.regions[i].Origin != s.regions[j].Origin {
+		return s.regions[i].Origin < s.regions[j].Origin
+	}
+	return s.regions[i].Name < s.regions[j].Name
+}
+
+// Parses the memory regions specified by a BSP's "bsp.memory_regions"
+// setting.  The returned slice is sorted by origin.
+func ReadMemoryRegions(
+	ymlRegions map[string]interface{}) ([]MemoryRegion, error) {
+
+	regions := make([]MemoryRegion, 0, len(ymlRegions))
+	for k, v := range ymlRegions {
+		region, err := parseMemoryRegion(k, cast.ToStringMap(v))
+		if err != nil {
+			return nil, err
+		}
+
+		regions = append(regions, region)
+	}
+
+	sort.Sort(memoryRegionSorter{regions})
+
+	return regions, nil
+}
+
+func writeLinkerRegion(name string, attrs string, origin int, length int,
+	w io.Writer) {
+
+	fmt.Fprintf(w, "    %-32s (%s) : ORIGIN = 0x%08x, LENGTH = 0x%x\n",
+		name, attrs, origin, length)
+}
+
+// Identifies the YAML entry that defines the named memory region.  Regions
+// are read from the BSP's "bsp.memory_regions" setting, beside the flash map.
+func (flashMap FlashMap) regionSources(name string) []newtutil.YamlSource {
+	if flashMap.YamlSrc.Path == "" {
+		return nil
+	}
+
+	src := flashMap.YamlSrc
+	src.Key = []string{"bsp.memory_regions", name}
+	return []newtutil.YamlSource{src}
+}
+
+func (flashMap FlashMap) writeLinkerScript(regions []MemoryRegion,
+	w *newtutil.GenWriter) {
+
+	fmt.Fprintf(w, "%s", newtutil.GeneratedPreamble())
+
+	fmt.Fprintf(w, "MEMORY\n")
+	fmt.Fprintf(w, "{\n")
+	for _, region := range regions {
+		w.Begin("    ", flashMap.regionSources(region.Name)...)
+		writeLinkerRegion(region.Name, region.Attrs, region.Origin,
+			region.Length, w)
+		w.End()
+	}
+	for _, area := range flashMap.SortedAreas() {
+		w.Begin("    ", flashMap.entrySources("areas", area.Name)...)
+		writeLinkerRegion(area.Name, "rx", flashMap.AreaAddress(area),
+			area.Size, w)
+		w.End()
+	}
+	fmt.Fprintf(w, "}\n")
+
+	// Also expose the location of each flash area as a pair of symbols so
+	// that code and hand-written scripts do not need to hardcode them.
+	fmt.Fprintf(w, "\n")
+	for _, area := range flashMap.SortedAreas() {
+		fmt.Fprintf(w, "__%s_start__ = 0x%08x;\n", area.Name,
+			flashMap.AreaAddress(area))
+		fmt.Fprintf(w, "__%s_size__ = 0x%x;\n", area.Name, area.Size)
+	}
+}
+
+// Writes a linker script fragment containing a MEMORY command that describes
+// the specified memory regions and every flash area in the map.  A BSP's
+// linker script pulls in the generated file with "INCLUDE sysflash.ld".  The
+// file is only written if its contents have changed; this prevents
+// needless relinks.
+func (flashMap FlashMap) EnsureLinkerScriptWritten(path string,
+	regions []MemoryRegion) error {
+
+	buf := newtutil.NewGenWriter()
+	flashMap.writeLinkerScript(regions, buf)
+
+	return flashMap.ensureWrittenGen(path, buf)
+}
@@ -0,0 +1,107 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package flash
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+const PROGRAM_DESC_FILENAME = "program.json"
+
+// Describes how to program an area that is not part of a bulk-erased image
+// (an EEPROM or OTP area).
+type ProgramDesc struct {
+	Area      string `json:"area"`
+	Type      string `json:"type"`
+	Device    int    `json:"device"`
+	Address   int    `json:"address"`
+	Size      int    `json:"size"`
+	WriteOnce bool   `json:"write_once"`
+
+	// File to program into the area; empty if the BSP doesn't provide one.
+	Data string `json:"data,omitempty"`
+}
+
+// Returns a programming descriptor for each area that needs to be programmed
+// individually, in order of area ID.
+func (flashMap FlashMap) ProgramDescs() []ProgramDesc {
+	descs := []ProgramDesc{}
+	for _, area := range flashMap.SortedAreas() {
+		if area.BulkErasable() {
+			continue
+		}
+
+		descs = append(descs, ProgramDesc{
+			Area:      area.Name,
+			Type:      area.Type,
+			Device:    area.Device,
+			Address:   flashMap.AreaAddress(area),
+			Size:      area.Size,
+			WriteOnce: area.WriteOnce(),
+			Data:      area.Data,
+		})
+	}
+
+	return descs
+}
+
+// Writes the programming descriptors to the specified file, unless it already
+// has the same contents.  If there are no descriptors, the file is removed.
+func (flashMap FlashMap) EnsureProgramDescsWritten(path string) error {
+	descs := flashMap.ProgramDescs()
+	if len(descs) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return util.ChildNewtError(err)
+		}
+		return nil
+	}
+
+	contents, err := json.MarshalIndent(descs, "", "    ")
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	contents = append(contents, '\n')
+
+	writeReqd, err := util.FileContentsChanged(path, contents)
+	if err != nil {
+		return err
+	}
+	if !writeReqd {
+		log.Debugf("programming descriptors unchanged; not writing file "+
+			"(%s).", path)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
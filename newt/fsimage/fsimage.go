@@ -0,0 +1,178 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package fsimage builds flash file system images (littlefs, NFFS) from a
+// directory on the host.
+package fsimage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"mynewt.apache.org/newt/util"
+)
+
+const (
+	FS_TYPE_LITTLEFS = "littlefs"
+	FS_TYPE_NFFS     = "nffs"
+)
+
+var FsTypes = []string{FS_TYPE_LITTLEFS, FS_TYPE_NFFS}
+
+// Geometry of the flash area an image is built for.
+type Params struct {
+	// Size of the flash area, in bytes.
+	Size int
+
+	// littlefs: erase block size.  NFFS: size of each NFFS area (usually
+	// one flash sector).
+	BlockSize int
+
+	// littlefs only: program unit; commits are padded to a multiple of it.
+	ProgSize int
+
+	// littlefs only: largest file stored inline in its directory's
+	// metadata.  Must not exceed the runtime's cache size; 0 gives every
+	// non-empty file blocks of its own.
+	InlineMax int
+}
+
+// A file or directory read from the host.
+type node struct {
+	name     string
+	isDir    bool
+	data     []byte
+	children []*node
+}
+
+// Summary of an image's contents.
+type Stats struct {
+	Files int
+	Dirs  int
+
+	// Bytes of file data stored.
+	DataBytes int
+
+	// Flash consumed, in bytes, including metadata.
+	Used int
+}
+
+type nodeSorter struct {
+	nodes []*node
+}
+
+func (s nodeSorter) Len() int {
+	return len(s.nodes)
+}
+func (s nodeSorter) Swap(i, j int) {
+	s.nodes[i], s.nodes[j] = s.nodes[j], s.nodes[i]
+}
+func (s nodeSorter) Less(i, j int) bool {
+	return s.nodes[i].name < s.nodes[j].name
+}
+
+// Reads the directory tree rooted at dir.  Entries are sorted by name.
+func readTree(dir string, name string) (*node, error) {
+	n := &node{name: name, isDir: true}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+
+		// Follow symlinks; the image holds their targets.
+		if info.Mode()&os.ModeSymlink != 0 {
+			info, err = os.Stat(path)
+			if err != nil {
+				return nil, util.ChildNewtError(err)
+			}
+		}
+
+		switch {
+		case info.IsDir():
+			child, err := readTree(path, info.Name())
+			if err != nil {
+				return nil, err
+			}
+			n.children = append(n.children, child)
+
+		case info.Mode().IsRegular():
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, util.ChildNewtError(err)
+			}
+			n.children = append(n.children,
+				&node{name: info.Name(), data: data})
+
+		default:
+			return nil, util.FmtNewtError(
+				"Can't add %s to a file system image; not a regular file "+
+					"or directory", path)
+		}
+	}
+
+	sort.Sort(nodeSorter{n.children})
+
+	return n, nil
+}
+
+// Returns a buffer of the specified size in the erased (0xff) state.
+func erased(size int) []byte {
+	buf := make([]byte, size)
+	for i := range buf {
+		buf[i] = 0xff
+	}
+	return buf
+}
+
+// Builds an image of the specified file system type holding the contents of
+// dir.  The image is exactly params.Size bytes; unused space is erased
+// (0xff).
+func Build(fsType string, dir string, params Params) ([]byte, *Stats,
+	error) {
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, nil, util.ChildNewtError(err)
+	}
+	if !info.IsDir() {
+		return nil, nil, util.FmtNewtError("%s is not a directory", dir)
+	}
+
+	root, err := readTree(dir, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch fsType {
+	case FS_TYPE_LITTLEFS:
+		return buildLittlefs(root, params)
+	case FS_TYPE_NFFS:
+		return buildNffs(root, params)
+	default:
+		return nil, nil, util.FmtNewtError(
+			"Unknown file system type \"%s\"; must be %s or %s", fsType,
+			FS_TYPE_LITTLEFS, FS_TYPE_NFFS)
+	}
+}
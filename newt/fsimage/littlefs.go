@@ -0,0 +1,429 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package fsimage
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Layout of littlefs v2 (see SPEC.md in the littlefs repository).  The image
+// is equivalent to what lfs_format() followed by copying each file in would
+// produce, except that every directory is written in a single compacted
+// commit.
+
+const LFS_DISK_VERSION = 0x00020000
+const LFS_MAGIC = "littlefs"
+
+// Limits recorded in the superblock; littlefs's defaults.
+const (
+	LFS_NAME_MAX = 255
+	LFS_FILE_MAX = 2147483647
+	LFS_ATTR_MAX = 1022
+)
+
+// Tag types.
+const (
+	lfsTypeReg          = 0x001
+	lfsTypeDir          = 0x002
+	lfsTypeSuperblock   = 0x0ff
+	lfsTypeDirStruct    = 0x200
+	lfsTypeInlineStruct = 0x201
+	lfsTypeCtzStruct    = 0x202
+	lfsTypeCrc          = 0x500
+	lfsTypeSoftTail     = 0x600
+	lfsTypeHardTail     = 0x601
+)
+
+// The id of tags that don't belong to an entry (tails, CRCs).
+const lfsIdNone = 0x3ff
+
+// Bytes taken by the superblock entry in the root metadata pair: its name
+// and struct tags with their data.
+const lfsSuperblockSize = 4 + len(LFS_MAGIC) + 4 + 24
+
+type lfsEntry struct {
+	node       *node
+	nameType   int
+	structType int
+	structData []byte
+}
+
+func (e *lfsEntry) size() int {
+	size := 4 + len(e.node.name) + 4
+	if e.structType == lfsTypeInlineStruct {
+		return size + len(e.node.data)
+	}
+	return size + 8
+}
+
+// A directory, split across as many metadata pairs as its entries need.
+type lfsDir struct {
+	chunks [][]*lfsEntry
+	pairs  [][2]uint32
+}
+
+type lfsBuilder struct {
+	params     Params
+	img        []byte
+	blockCount uint32
+	next       uint32
+	dirs       []*lfsDir
+	nodeDirs   map[*node]*lfsDir
+	stats      Stats
+}
+
+// littlefs's CRC-32: the IEEE polynomial without the final inversion.
+func lfsCrc(crc uint32, data []byte) uint32 {
+	return ^crc32.Update(^crc, crc32.IEEETable, data)
+}
+
+// Number of trailing zero bits in n; n must be positive.
+func ctz(n int) int {
+	c := 0
+	for n&1 == 0 {
+		n >>= 1
+		c++
+	}
+	return c
+}
+
+func lfsPair(pair [2]uint32) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b[0:], pair[0])
+	binary.LittleEndian.PutUint32(b[4:], pair[1])
+	return b
+}
+
+func (b *lfsBuilder) block(blk uint32) []byte {
+	bs := uint32(b.params.BlockSize)
+	return b.img[blk*bs : (blk+1)*bs]
+}
+
+func (b *lfsBuilder) alloc() (uint32, error) {
+	if b.next >= b.blockCount {
+		return 0, util.FmtNewtError("Files don't fit in a %d-byte "+
+			"littlefs image (%d blocks of %d bytes)", b.params.Size,
+			b.blockCount, b.params.BlockSize)
+	}
+
+	blk := b.next
+	b.next++
+	return blk, nil
+}
+
+// The most metadata placed in one pair before the directory is continued in
+// another; littlefs splits directories at the same point when compacting.
+func (b *lfsBuilder) metaLimit() int {
+	bs := b.params.BlockSize
+	half := (bs/2 + b.params.ProgSize - 1) / b.params.ProgSize *
+		b.params.ProgSize
+	return util.Min(bs-36, half)
+}
+
+// Collects the directory tree's directories in depth-first order and splits
+// each one's entries into metadata pairs.
+func (b *lfsBuilder) addDir(n *node, isRoot bool) error {
+	dir := &lfsDir{}
+	b.dirs = append(b.dirs, dir)
+	b.nodeDirs[n] = dir
+	b.stats.Dirs++
+
+	inlineMax := util.Min(util.Min(b.params.InlineMax, 0x3fe),
+		b.params.BlockSize/8)
+
+	base := 0
+	if isRoot {
+		base = lfsSuperblockSize
+	}
+	size := base
+	chunk := []*lfsEntry{}
+	for _, child := range n.children {
+		if len(child.name) > LFS_NAME_MAX {
+			return util.FmtNewtError("File name too long for littlefs "+
+				"(max %d): %s", LFS_NAME_MAX, child.name)
+		}
+
+		e := &lfsEntry{node: child}
+		switch {
+		case child.isDir:
+			e.nameType = lfsTypeDir
+			e.structType = lfsTypeDirStruct
+		case len(child.data) == 0 || len(child.data) <= inlineMax:
+			e.nameType = lfsTypeReg
+			e.structType = lfsTypeInlineStruct
+			e.structData = child.data
+		default:
+			e.nameType = lfsTypeReg
+			e.structType = lfsTypeCtzStruct
+		}
+
+		esize := e.size()
+		if size+esize > b.params.BlockSize-36 {
+			return util.FmtNewtError("Entry %s does not fit in a "+
+				"%d-byte littlefs metadata block", child.name,
+				b.params.BlockSize)
+		}
+		if len(chunk) > 0 &&
+			(size+esize > b.metaLimit() || len(chunk) >= 0xfe) {
+
+			dir.chunks = append(dir.chunks, chunk)
+			chunk = []*lfsEntry{}
+			size = 0
+		}
+		chunk = append(chunk, e)
+		size += esize
+	}
+	dir.chunks = append(dir.chunks, chunk)
+
+	for _, child := range n.children {
+		if child.isDir {
+			if err := b.addDir(child, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Writes a file's data as a CTZ skip-list and returns the last block, which
+// is the file's head.  Block n (n > 0) starts with ctz(n)+1 pointers, the
+// i'th pointing to block n - 2^i.
+func (b *lfsBuilder) writeCtz(data []byte) (uint32, error) {
+	blocks := []uint32{}
+	for off := 0; off < len(data); {
+		blk, err := b.alloc()
+		if err != nil {
+			return 0, err
+		}
+		buf := b.block(blk)
+
+		idx := len(blocks)
+		hdr := 0
+		if idx > 0 {
+			skips := ctz(idx) + 1
+			for i := 0; i < skips; i++ {
+				binary.LittleEndian.PutUint32(buf[4*i:],
+					blocks[idx-(1<<uint(i))])
+			}
+			hdr = 4 * skips
+		}
+
+		off += copy(buf[hdr:], data[off:])
+		blocks = append(blocks, blk)
+	}
+
+	return blocks[len(blocks)-1], nil
+}
+
+// Appends tags to a metadata block, as a single commit.
+type lfsCommit struct {
+	buf  []byte
+	off  int
+	ptag uint32
+	crc  uint32
+}
+
+func newLfsCommit(buf []byte, rev uint32) *lfsCommit {
+	c := &lfsCommit{buf: buf, ptag: 0xffffffff, crc: 0xffffffff}
+	binary.LittleEndian.PutUint32(buf, rev)
+	c.crc = lfsCrc(c.crc, buf[:4])
+	c.off = 4
+	return c
+}
+
+func (c *lfsCommit) write(data []byte) {
+	copy(c.buf[c.off:], data)
+	c.crc = lfsCrc(c.crc, data)
+	c.off += len(data)
+}
+
+// Appends a tag and its data.  Each tag is stored big-endian, XORed with the
+// previous one.
+func (c *lfsCommit) tag(typ int, id int, data []byte) {
+	tag := uint32(typ)<<20 | uint32(id)<<10 | uint32(len(data))
+
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, tag^c.ptag)
+	c.write(b)
+	c.write(data)
+	c.ptag = tag
+}
+
+// Ends the commit with a CRC tag covering everything since the revision
+// count, padded to the program unit.
+func (c *lfsCommit) finish(progSize int) {
+	off := c.off + 4
+	end := (off + 4 + progSize - 1) / progSize * progSize
+	tag := uint32(lfsTypeCrc)<<20 | uint32(lfsIdNone)<<10 | uint32(end-off)
+
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, tag^c.ptag)
+	c.write(b)
+
+	binary.LittleEndian.PutUint32(b, c.crc)
+	copy(c.buf[c.off:], b)
+	c.off = end
+}
+
+// Writes one metadata pair of a directory.  The pair's second block is left
+// erased; littlefs uses it for the next compaction.
+func (b *lfsBuilder) writeMeta(pair [2]uint32, entries []*lfsEntry,
+	superblock bool, tailType int, tail [2]uint32) {
+
+	c := newLfsCommit(b.block(pair[0]), 1)
+
+	id := 0
+	if superblock {
+		sb := make([]byte, 24)
+		binary.LittleEndian.PutUint32(sb[0:], LFS_DISK_VERSION)
+		binary.LittleEndian.PutUint32(sb[4:], uint32(b.params.BlockSize))
+		binary.LittleEndian.PutUint32(sb[8:], b.blockCount)
+		binary.LittleEndian.PutUint32(sb[12:], LFS_NAME_MAX)
+		binary.LittleEndian.PutUint32(sb[16:], LFS_FILE_MAX)
+		binary.LittleEndian.PutUint32(sb[20:], LFS_ATTR_MAX)
+
+		c.tag(lfsTypeSuperblock, id, []byte(LFS_MAGIC))
+		c.tag(lfsTypeInlineStruct, id, sb)
+		id++
+	}
+
+	for _, e := range entries {
+		c.tag(e.nameType, id, []byte(e.node.name))
+		c.tag(e.structType, id, e.structData)
+		id++
+	}
+
+	if tailType != 0 {
+		c.tag(tailType, lfsIdNone, lfsPair(tail))
+	}
+
+	c.finish(b.params.ProgSize)
+}
+
+func buildLittlefs(root *node, params Params) ([]byte, *Stats, error) {
+	if params.BlockSize < 128 {
+		return nil, nil, util.FmtNewtError("Invalid littlefs block size "+
+			"%d; must be at least 128", params.BlockSize)
+	}
+	if params.ProgSize <= 0 || params.ProgSize > 512 ||
+		params.BlockSize%params.ProgSize != 0 {
+
+		return nil, nil, util.FmtNewtError("Invalid littlefs program "+
+			"size %d; must divide the block size (%d) and be at most 512",
+			params.ProgSize, params.BlockSize)
+	}
+	if params.Size%params.BlockSize != 0 {
+		return nil, nil, util.FmtNewtError("Flash area size %d is not a "+
+			"multiple of the littlefs block size %d", params.Size,
+			params.BlockSize)
+	}
+
+	b := &lfsBuilder{
+		params:     params,
+		img:        erased(params.Size),
+		blockCount: uint32(params.Size / params.BlockSize),
+		nodeDirs:   map[*node]*lfsDir{},
+	}
+	if b.blockCount < 2 {
+		return nil, nil, util.FmtNewtError("A %d-byte flash area is too "+
+			"small for littlefs; it needs at least two blocks", params.Size)
+	}
+
+	if err := b.addDir(root, true); err != nil {
+		return nil, nil, err
+	}
+
+	// The root directory's first pair doubles as the superblock at blocks
+	// {0, 1}.
+	b.next = 2
+	for i, dir := range b.dirs {
+		for j := range dir.chunks {
+			if i == 0 && j == 0 {
+				dir.pairs = append(dir.pairs, [2]uint32{0, 1})
+				continue
+			}
+
+			var pair [2]uint32
+			for k := range pair {
+				blk, err := b.alloc()
+				if err != nil {
+					return nil, nil, err
+				}
+				pair[k] = blk
+			}
+			dir.pairs = append(dir.pairs, pair)
+		}
+	}
+
+	for _, dir := range b.dirs {
+		for _, chunk := range dir.chunks {
+			for _, e := range chunk {
+				switch e.structType {
+				case lfsTypeDirStruct:
+					e.structData = lfsPair(b.nodeDirs[e.node].pairs[0])
+
+				case lfsTypeCtzStruct:
+					head, err := b.writeCtz(e.node.data)
+					if err != nil {
+						return nil, nil, err
+					}
+					e.structData = make([]byte, 8)
+					binary.LittleEndian.PutUint32(e.structData[0:], head)
+					binary.LittleEndian.PutUint32(e.structData[4:],
+						uint32(len(e.node.data)))
+				}
+
+				if !e.node.isDir {
+					b.stats.Files++
+					b.stats.DataBytes += len(e.node.data)
+				}
+			}
+		}
+	}
+
+	// Every metadata pair is linked into one list through its tail: a hard
+	// tail continues the same directory, a soft tail leads to the next one.
+	for i, dir := range b.dirs {
+		for j, chunk := range dir.chunks {
+			tailType := 0
+			var tail [2]uint32
+			if j+1 < len(dir.chunks) {
+				tailType = lfsTypeHardTail
+				tail = dir.pairs[j+1]
+			} else if i+1 < len(b.dirs) {
+				tailType = lfsTypeSoftTail
+				tail = b.dirs[i+1].pairs[0]
+			}
+
+			b.writeMeta(dir.pairs[j], chunk, i == 0 && j == 0, tailType,
+				tail)
+		}
+	}
+
+	// The root directory itself isn't counted.
+	b.stats.Dirs--
+	b.stats.Used = int(b.next) * params.BlockSize
+
+	return b.img, &b.stats, nil
+}
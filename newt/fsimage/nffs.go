@@ -0,0 +1,298 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package fsimage
+
+import (
+	"encoding/binary"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Layout of NFFS v1, as read by fs/nffs's restore code.  The flash area is
+// divided into NFFS areas of params.BlockSize bytes; the first is the
+// scratch area, the rest hold a log of inodes and data blocks.
+
+var nffsAreaMagic = []uint32{0xb98a31e2, 0x7fb0428c, 0xace08253, 0xb185fc8e}
+
+const NFFS_AREA_VER = 1
+
+const (
+	NFFS_AREA_HDR_SIZE  = 24
+	NFFS_INODE_HDR_SIZE = 20
+	NFFS_BLOCK_HDR_SIZE = 20
+)
+
+const (
+	NFFS_ID_ROOT_DIR  = 0
+	NFFS_ID_FILE_MIN  = 0x10000000
+	NFFS_ID_BLOCK_MIN = 0x80000000
+	NFFS_ID_NONE      = 0xffffffff
+	NFFS_AREA_ID_NONE = 0xff
+)
+
+const NFFS_FILENAME_MAX_LEN = 255
+const NFFS_BLOCK_MAX_DATA_SZ_MAX = 2048
+const NFFS_LOST_FOUND_NAME = "lost+found"
+
+type nffsBuilder struct {
+	params   Params
+	img      []byte
+	numAreas int
+
+	// Write position: the current area and the offset within it.
+	area int
+	off  int
+
+	nextDirId   uint32
+	nextFileId  uint32
+	nextBlockId uint32
+	stats       Stats
+}
+
+// CRC-16/CCITT (XMODEM), as computed by the crc16_ccitt() in mynewt's util
+// package.
+func crc16Ccitt(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func (b *nffsBuilder) areaBuf(idx int) []byte {
+	as := b.params.BlockSize
+	return b.img[idx*as : (idx+1)*as]
+}
+
+func (b *nffsBuilder) writeAreaHdr(idx int, id uint8) {
+	buf := b.areaBuf(idx)
+	for i, m := range nffsAreaMagic {
+		binary.LittleEndian.PutUint32(buf[4*i:], m)
+	}
+	binary.LittleEndian.PutUint32(buf[16:], uint32(b.params.BlockSize))
+	buf[20] = NFFS_AREA_VER
+	buf[21] = 0 // gc_seq
+	buf[22] = 0 // reserved
+	buf[23] = id
+}
+
+// Appends an object to the log; objects never span areas.
+func (b *nffsBuilder) write(obj []byte) error {
+	if b.off+len(obj) > b.params.BlockSize {
+		b.area++
+		b.off = NFFS_AREA_HDR_SIZE
+	}
+	if b.area >= b.numAreas {
+		return util.FmtNewtError("Files don't fit in a %d-byte NFFS "+
+			"image (%d data areas of %d bytes)", b.params.Size,
+			b.numAreas-1, b.params.BlockSize)
+	}
+
+	copy(b.areaBuf(b.area)[b.off:], obj)
+	b.off += len(obj)
+	return nil
+}
+
+func (b *nffsBuilder) writeInode(id uint32, parentId uint32,
+	lastBlockId uint32, name string) error {
+
+	if len(name) > NFFS_FILENAME_MAX_LEN {
+		return util.FmtNewtError("File name too long for NFFS (max %d): %s",
+			NFFS_FILENAME_MAX_LEN, name)
+	}
+
+	obj := make([]byte, NFFS_INODE_HDR_SIZE+len(name))
+	binary.LittleEndian.PutUint32(obj[0:], id)
+	binary.LittleEndian.PutUint32(obj[4:], parentId)
+	binary.LittleEndian.PutUint32(obj[8:], lastBlockId)
+	// seq (u16), reserved (u16) and flags (u8) are zero.
+	obj[17] = uint8(len(name))
+	copy(obj[NFFS_INODE_HDR_SIZE:], name)
+
+	crc := crc16Ccitt(0, obj[:18])
+	crc = crc16Ccitt(crc, obj[NFFS_INODE_HDR_SIZE:])
+	binary.LittleEndian.PutUint16(obj[18:], crc)
+
+	return b.write(obj)
+}
+
+func (b *nffsBuilder) writeBlock(id uint32, inodeId uint32, prevId uint32,
+	data []byte) error {
+
+	obj := make([]byte, NFFS_BLOCK_HDR_SIZE+len(data))
+	binary.LittleEndian.PutUint32(obj[0:], id)
+	binary.LittleEndian.PutUint32(obj[4:], inodeId)
+	binary.LittleEndian.PutUint32(obj[8:], prevId)
+	// seq (u16) and reserved (u16) are zero.
+	binary.LittleEndian.PutUint16(obj[16:], uint16(len(data)))
+	copy(obj[NFFS_BLOCK_HDR_SIZE:], data)
+
+	crc := crc16Ccitt(0, obj[:18])
+	crc = crc16Ccitt(crc, obj[NFFS_BLOCK_HDR_SIZE:])
+	binary.LittleEndian.PutUint16(obj[18:], crc)
+
+	return b.write(obj)
+}
+
+// The most data a single block carries, leaving room for at least two
+// blocks per area so that garbage collection can always make progress.
+func (b *nffsBuilder) maxBlockData() int {
+	return util.Min(NFFS_BLOCK_MAX_DATA_SZ_MAX,
+		(b.params.BlockSize-NFFS_AREA_HDR_SIZE)/2-NFFS_BLOCK_HDR_SIZE)
+}
+
+func (b *nffsBuilder) writeFile(n *node, parentId uint32) error {
+	fileId := b.nextFileId
+	b.nextFileId++
+
+	// Block ids are assigned up front so the inode, which is written
+	// first, can name the file's last block.
+	maxData := b.maxBlockData()
+	numBlocks := (len(n.data) + maxData - 1) / maxData
+	lastBlockId := uint32(NFFS_ID_NONE)
+	if numBlocks > 0 {
+		lastBlockId = b.nextBlockId + uint32(numBlocks) - 1
+	}
+
+	if err := b.writeInode(fileId, parentId, lastBlockId,
+		n.name); err != nil {
+
+		return err
+	}
+
+	prevId := uint32(NFFS_ID_NONE)
+	for off := 0; off < len(n.data); off += maxData {
+		end := util.Min(off+maxData, len(n.data))
+		blockId := b.nextBlockId
+		b.nextBlockId++
+
+		if err := b.writeBlock(blockId, fileId, prevId,
+			n.data[off:end]); err != nil {
+
+			return err
+		}
+		prevId = blockId
+	}
+
+	b.stats.Files++
+	b.stats.DataBytes += len(n.data)
+	return nil
+}
+
+func (b *nffsBuilder) writeDir(n *node, id uint32) error {
+	for _, child := range n.children {
+		if !child.isDir {
+			if err := b.writeFile(child, id); err != nil {
+				return err
+			}
+			continue
+		}
+
+		childId := b.nextDirId
+		b.nextDirId++
+		if err := b.writeInode(childId, id, NFFS_ID_NONE,
+			child.name); err != nil {
+
+			return err
+		}
+		b.stats.Dirs++
+
+		if err := b.writeDir(child, childId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func buildNffs(root *node, params Params) ([]byte, *Stats, error) {
+	minArea := NFFS_AREA_HDR_SIZE + 2*(NFFS_BLOCK_HDR_SIZE+64)
+	if params.BlockSize < minArea {
+		return nil, nil, util.FmtNewtError("Invalid NFFS area size %d; "+
+			"must be at least %d", params.BlockSize, minArea)
+	}
+	if params.Size%params.BlockSize != 0 {
+		return nil, nil, util.FmtNewtError("Flash area size %d is not a "+
+			"multiple of the NFFS area size %d", params.Size,
+			params.BlockSize)
+	}
+
+	b := &nffsBuilder{
+		params:      params,
+		img:         erased(params.Size),
+		numAreas:    params.Size / params.BlockSize,
+		area:        1,
+		off:         NFFS_AREA_HDR_SIZE,
+		nextDirId:   NFFS_ID_ROOT_DIR + 1,
+		nextFileId:  NFFS_ID_FILE_MIN,
+		nextBlockId: NFFS_ID_BLOCK_MIN,
+	}
+	if b.numAreas < 2 {
+		return nil, nil, util.FmtNewtError("A %d-byte flash area is too "+
+			"small for NFFS; it needs a scratch area and at least one "+
+			"data area", params.Size)
+	}
+	if b.numAreas > NFFS_AREA_ID_NONE {
+		return nil, nil, util.FmtNewtError("Too many NFFS areas (%d); "+
+			"use a larger area size", b.numAreas)
+	}
+
+	// Area 0 is the scratch area used by garbage collection.
+	b.writeAreaHdr(0, NFFS_AREA_ID_NONE)
+	for i := 1; i < b.numAreas; i++ {
+		b.writeAreaHdr(i, uint8(i))
+	}
+
+	if err := b.writeInode(NFFS_ID_ROOT_DIR, NFFS_ID_NONE, NFFS_ID_NONE,
+		""); err != nil {
+
+		return nil, nil, err
+	}
+
+	// NFFS expects a lost+found directory in the root.
+	hasLostFound := false
+	for _, child := range root.children {
+		if child.name == NFFS_LOST_FOUND_NAME && child.isDir {
+			hasLostFound = true
+		}
+	}
+	if !hasLostFound {
+		id := b.nextDirId
+		b.nextDirId++
+		if err := b.writeInode(id, NFFS_ID_ROOT_DIR, NFFS_ID_NONE,
+			NFFS_LOST_FOUND_NAME); err != nil {
+
+			return nil, nil, err
+		}
+	}
+
+	if err := b.writeDir(root, NFFS_ID_ROOT_DIR); err != nil {
+		return nil, nil, err
+	}
+
+	b.stats.Used = b.area*params.BlockSize + b.off
+	return b.img, &b.stats, nil
+}
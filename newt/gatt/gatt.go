@@ -0,0 +1,561 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package gatt generates NimBLE GATT service definitions from the pkg.gatt
+// section of a package's pkg.yml:
+//
+//     pkg.gatt:
+//         name: bleprph        # symbol prefix; default: package base name
+//         services:
+//             - uuid: 0x180d
+//               type: primary  # or secondary
+//               characteristics:
+//                   - uuid: 0x2a37
+//                     flags: [read, notify]
+//                     access_cb: gatt_svr_chr_access_hrm
+//                     val_handle: hrs_hrm_val_handle
+//                     descriptors:
+//                         - uuid: 0x2901
+//                           att_flags: [read]
+//                           access_cb: gatt_svr_dsc_access
+//
+// The package includes "gatt/<name>.h" and registers <name>_gatt_svcs.
+package gatt
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+const GATT_YAML_KEY = "pkg.gatt"
+
+// The client characteristic configuration descriptor; NimBLE adds it to
+// every characteristic that can notify or indicate.
+const GATT_UUID_CCCD = 0x2902
+
+var chrFlags = map[string]string{
+	"broadcast":       "BLE_GATT_CHR_F_BROADCAST",
+	"read":            "BLE_GATT_CHR_F_READ",
+	"write_no_rsp":    "BLE_GATT_CHR_F_WRITE_NO_RSP",
+	"write":           "BLE_GATT_CHR_F_WRITE",
+	"notify":          "BLE_GATT_CHR_F_NOTIFY",
+	"indicate":        "BLE_GATT_CHR_F_INDICATE",
+	"auth_sign_write": "BLE_GATT_CHR_F_AUTH_SIGN_WRITE",
+	"reliable_write":  "BLE_GATT_CHR_F_RELIABLE_WRITE",
+	"aux_write":       "BLE_GATT_CHR_F_AUX_WRITE",
+	"read_enc":        "BLE_GATT_CHR_F_READ_ENC",
+	"read_authen":     "BLE_GATT_CHR_F_READ_AUTHEN",
+	"read_author":     "BLE_GATT_CHR_F_READ_AUTHOR",
+	"write_enc":       "BLE_GATT_CHR_F_WRITE_ENC",
+	"write_authen":    "BLE_GATT_CHR_F_WRITE_AUTHEN",
+	"write_author":    "BLE_GATT_CHR_F_WRITE_AUTHOR",
+}
+
+var dscFlags = map[string]string{
+	"read":         "BLE_ATT_F_READ",
+	"write":        "BLE_ATT_F_WRITE",
+	"read_enc":     "BLE_ATT_F_READ_ENC",
+	"read_authen":  "BLE_ATT_F_READ_AUTHEN",
+	"read_author":  "BLE_ATT_F_READ_AUTHOR",
+	"write_enc":    "BLE_ATT_F_WRITE_ENC",
+	"write_authen": "BLE_ATT_F_WRITE_AUTHEN",
+	"write_author": "BLE_ATT_F_WRITE_AUTHOR",
+}
+
+var uuid128Re = regexp.MustCompile(
+	`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-` +
+		`[0-9a-fA-F]{12}$`)
+var identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type Uuid struct {
+	// 16, 32 or 128.
+	Bits int
+
+	// 16- and 32-bit UUIDs.
+	Value uint32
+
+	// 128-bit UUIDs, in the order they are written.
+	Bytes []byte
+}
+
+type Descriptor struct {
+	Uuid     Uuid
+	Flags    []string
+	AccessCb string
+}
+
+type Characteristic struct {
+	Uuid        Uuid
+	Flags       []string
+	AccessCb    string
+	ValHandle   string
+	Descriptors []*Descriptor
+}
+
+type Service struct {
+	Uuid            Uuid
+	Secondary       bool
+	Characteristics []*Characteristic
+}
+
+// The services declared by one package.
+type Gatt struct {
+	// Prefix of the generated symbols and name of the generated files.
+	Name     string
+	Services []*Service
+	Lpkg     *pkg.LocalPackage
+}
+
+func parseUuid(itf interface{}) (Uuid, error) {
+	s := strings.TrimSpace(cast.ToString(itf))
+
+	if uuid128Re.MatchString(s) {
+		b, _ := hex.DecodeString(strings.Replace(s, "-", "", -1))
+		return Uuid{Bits: 128, Bytes: b}, nil
+	}
+
+	n, err := util.AtoiNoOct(s)
+	if err != nil || n < 0 || int64(n) > 0xffffffff {
+		return Uuid{}, util.FmtNewtError("invalid UUID \"%s\"", s)
+	}
+	if n <= 0xffff {
+		return Uuid{Bits: 16, Value: uint32(n)}, nil
+	}
+	return Uuid{Bits: 32, Value: uint32(n)}, nil
+}
+
+func parseFlags(itf interface{}, valid map[string]string) ([]string,
+	error) {
+
+	flags := cast.ToStringSlice(itf)
+	for _, f := range flags {
+		if _, ok := valid[f]; !ok {
+			return nil, util.FmtNewtError("invalid flag \"%s\"", f)
+		}
+	}
+	return flags, nil
+}
+
+func parseIdent(m map[string]interface{}, key string,
+	required bool) (string, error) {
+
+	s := cast.ToString(m[key])
+	if s == "" {
+		if required {
+			return "", util.FmtNewtError("missing required \"%s\"", key)
+		}
+		return "", nil
+	}
+	if !identRe.MatchString(s) {
+		return "", util.FmtNewtError("%s \"%s\" is not a C identifier",
+			key, s)
+	}
+	return s, nil
+}
+
+func parseDescriptor(m map[string]interface{}) (*Descriptor, error) {
+	d := &Descriptor{}
+
+	var err error
+	if d.Uuid, err = parseUuid(m["uuid"]); err != nil {
+		return nil, err
+	}
+	if d.Uuid.Bits == 16 && d.Uuid.Value == GATT_UUID_CCCD {
+		return nil, util.FmtNewtError("the CCCD (0x2902) is added " +
+			"automatically; use the notify or indicate flag instead")
+	}
+	if d.Flags, err = parseFlags(m["att_flags"], dscFlags); err != nil {
+		return nil, err
+	}
+	if len(d.Flags) == 0 {
+		return nil, util.FmtNewtError("no att_flags")
+	}
+	if d.AccessCb, err = parseIdent(m, "access_cb", true); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func parseCharacteristic(m map[string]interface{}) (*Characteristic,
+	error) {
+
+	c := &Characteristic{}
+
+	var err error
+	if c.Uuid, err = parseUuid(m["uuid"]); err != nil {
+		return nil, err
+	}
+	if c.Flags, err = parseFlags(m["flags"], chrFlags); err != nil {
+		return nil, err
+	}
+	if len(c.Flags) == 0 {
+		return nil, util.FmtNewtError("no flags")
+	}
+	if c.AccessCb, err = parseIdent(m, "access_cb", true); err != nil {
+		return nil, err
+	}
+	if c.ValHandle, err = parseIdent(m, "val_handle", false); err != nil {
+		return nil, err
+	}
+
+	for i, itf := range cast.ToSlice(m["descriptors"]) {
+		d, err := parseDescriptor(cast.ToStringMap(itf))
+		if err != nil {
+			return nil, util.FmtNewtError("descriptor %d: %s", i,
+				err.Error())
+		}
+		c.Descriptors = append(c.Descriptors, d)
+	}
+
+	return c, nil
+}
+
+func parseService(m map[string]interface{}) (*Service, error) {
+	s := &Service{}
+
+	var err error
+	if s.Uuid, err = parseUuid(m["uuid"]); err != nil {
+		return nil, err
+	}
+
+	switch typ := cast.ToString(m["type"]); typ {
+	case "", "primary":
+	case "secondary":
+		s.Secondary = true
+	default:
+		return nil, util.FmtNewtError("invalid type \"%s\"; must be "+
+			"primary or secondary", typ)
+	}
+
+	for i, itf := range cast.ToSlice(m["characteristics"]) {
+		c, err := parseCharacteristic(cast.ToStringMap(itf))
+		if err != nil {
+			return nil, util.FmtNewtError("characteristic %d: %s", i,
+				err.Error())
+		}
+		s.Characteristics = append(s.Characteristics, c)
+	}
+
+	return s, nil
+}
+
+// Reads a package's GATT services.  Returns nil if the package doesn't
+// declare any.
+func Read(lpkg *pkg.LocalPackage) (*Gatt, error) {
+	itf := lpkg.PkgV.Get(GATT_YAML_KEY)
+	if itf == nil {
+		return nil, nil
+	}
+
+	m := cast.ToStringMap(itf)
+	g := &Gatt{Lpkg: lpkg}
+
+	g.Name = cast.ToString(m["name"])
+	if g.Name == "" {
+		g.Name = regexp.MustCompile(`[^A-Za-z0-9_]`).ReplaceAllString(
+			filepath.Base(lpkg.Name()), "_")
+	}
+	if !identRe.MatchString(g.Name) {
+		return nil, util.FmtNewtError("%s: %s name \"%s\" is not a C "+
+			"identifier", lpkg.FullName(), GATT_YAML_KEY, g.Name)
+	}
+
+	for i, itf := range cast.ToSlice(m["services"]) {
+		s, err := parseService(cast.ToStringMap(itf))
+		if err != nil {
+			return nil, util.FmtNewtError("%s: %s service %d: %s",
+				lpkg.FullName(), GATT_YAML_KEY, i, err.Error())
+		}
+		g.Services = append(g.Services, s)
+	}
+	if len(g.Services) == 0 {
+		return nil, util.FmtNewtError("%s: %s declares no services",
+			lpkg.FullName(), GATT_YAML_KEY)
+	}
+
+	return g, nil
+}
+
+// Relative path of the generated header; the generated include directory is
+// on every package's include path.
+func (g *Gatt) HeaderName() string {
+	return "gatt/" + g.Name + ".h"
+}
+
+func (g *Gatt) SrcName() string {
+	return g.Name + "_gatt.c"
+}
+
+func uuidType(u Uuid) string {
+	return fmt.Sprintf("ble_uuid%d_t", u.Bits)
+}
+
+func uuidInit(u Uuid) string {
+	if u.Bits != 128 {
+		return fmt.Sprintf("BLE_UUID%d_INIT(0x%04x)", u.Bits, u.Value)
+	}
+
+	// NimBLE stores 128-bit UUIDs little endian.
+	parts := make([]string, len(u.Bytes))
+	for i, b := range u.Bytes {
+		parts[len(u.Bytes)-1-i] = fmt.Sprintf("0x%02x", b)
+	}
+	return fmt.Sprintf("BLE_UUID128_INIT(%s,\n        %s)",
+		strings.Join(parts[:8], ", "), strings.Join(parts[8:], ", "))
+}
+
+func flagExpr(flags []string, names map[string]string) string {
+	exprs := make([]string, len(flags))
+	for i, f := range flags {
+		exprs[i] = names[f]
+	}
+	return strings.Join(exprs, " | ")
+}
+
+// Lists the package's access callbacks and value handles, each once, in the
+// order they appear.
+func (g *Gatt) symbols() ([]string, []string) {
+	cbs := []string{}
+	handles := []string{}
+	seen := map[string]bool{}
+
+	add := func(list *[]string, name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			*list = append(*list, name)
+		}
+	}
+
+	for _, s := range g.Services {
+		for _, c := range s.Characteristics {
+			add(&cbs, c.AccessCb)
+			add(&handles, c.ValHandle)
+			for _, d := range c.Descriptors {
+				add(&cbs, d.AccessCb)
+			}
+		}
+	}
+
+	return cbs, handles
+}
+
+// Identifies the pkg.yml entry that declares the package's services, or a
+// single service if idx is non-negative.
+func (g *Gatt) source(idx int) newtutil.YamlSource {
+	src := newtutil.YamlSource{
+		Pkg:  g.Lpkg.FullName(),
+		Path: g.Lpkg.BasePath() + "/" + pkg.PACKAGE_FILE_NAME,
+		Key:  []string{GATT_YAML_KEY},
+	}
+	if idx >= 0 {
+		src.Key = append(src.Key, "services", fmt.Sprintf("[%d]", idx))
+	}
+
+	return src
+}
+
+func (g *Gatt) writeHeader(w *newtutil.GenWriter) {
+	guard := "H_GATT_" + strings.ToUpper(g.Name) + "_"
+	cbs, handles := g.symbols()
+
+	fmt.Fprint(w, newtutil.GeneratedPreamble())
+	fmt.Fprintf(w, "#ifndef %s\n#define %s\n\n", guard, guard)
+	fmt.Fprintf(w, "#include <inttypes.h>\n")
+	fmt.Fprintf(w, "#include \"host/ble_hs.h\"\n\n")
+	fmt.Fprintf(w, "#ifdef __cplusplus\nextern \"C\" {\n#endif\n\n")
+
+	w.Begin("", g.source(-1))
+	fmt.Fprintf(w, "/* Services declared by %s; pass to ble_gatts_count_cfg() "+
+		"and\n * ble_gatts_add_svcs(). */\n", g.Lpkg.FullName())
+	fmt.Fprintf(w, "extern const struct ble_gatt_svc_def %s_gatt_svcs[];\n",
+		g.Name)
+
+	if len(handles) > 0 {
+		fmt.Fprintf(w, "\n/* Value handles; set when the services are "+
+			"registered. */\n")
+		for _, h := range handles {
+			fmt.Fprintf(w, "extern uint16_t %s;\n", h)
+		}
+	}
+
+	fmt.Fprintf(w, "\n/* Access callbacks; implemented by %s. */\n",
+		g.Lpkg.FullName())
+	for _, cb := range cbs {
+		fmt.Fprintf(w, "int %s(uint16_t conn_handle, uint16_t attr_handle,\n"+
+			"    struct ble_gatt_access_ctxt *ctxt, void *arg);\n", cb)
+	}
+	w.End()
+
+	fmt.Fprintf(w, "\n#ifdef __cplusplus\n}\n#endif\n\n#endif\n")
+}
+
+func (g *Gatt) writeSrc(w *newtutil.GenWriter) {
+	_, handles := g.symbols()
+
+	fmt.Fprint(w, newtutil.GeneratedPreamble())
+	fmt.Fprintf(w, "#include \"%s\"\n\n", g.HeaderName())
+
+	for _, h := range handles {
+		fmt.Fprintf(w, "uint16_t %s;\n", h)
+	}
+	if len(handles) > 0 {
+		fmt.Fprintf(w, "\n")
+	}
+
+	// UUIDs.
+	for i, s := range g.Services {
+		w.Begin("", g.source(i))
+		fmt.Fprintf(w, "static const %s %s_svc%d_uuid =\n    %s;\n",
+			uuidType(s.Uuid), g.Name, i, uuidInit(s.Uuid))
+		for j, c := range s.Characteristics {
+			fmt.Fprintf(w, "static const %s %s_svc%d_chr%d_uuid =\n    %s;\n",
+				uuidType(c.Uuid), g.Name, i, j, uuidInit(c.Uuid))
+			for k, d := range c.Descriptors {
+				fmt.Fprintf(w, "static const %s %s_svc%d_chr%d_dsc%d_uuid =\n"+
+					"    %s;\n", uuidType(d.Uuid), g.Name, i, j, k,
+					uuidInit(d.Uuid))
+			}
+		}
+		w.End()
+	}
+
+	// Descriptor and characteristic tables, each terminated by an empty
+	// entry.
+	for i, s := range g.Services {
+		fmt.Fprintf(w, "\n")
+		w.Begin("", g.source(i))
+		for j, c := range s.Characteristics {
+			if len(c.Descriptors) == 0 {
+				continue
+			}
+
+			fmt.Fprintf(w, "static struct ble_gatt_dsc_def "+
+				"%s_svc%d_chr%d_dscs[] = {\n", g.Name, i, j)
+			for k, d := range c.Descriptors {
+				fmt.Fprintf(w, "    {\n")
+				fmt.Fprintf(w, "        .uuid = &%s_svc%d_chr%d_dsc%d_uuid.u,\n",
+					g.Name, i, j, k)
+				fmt.Fprintf(w, "        .att_flags = %s,\n",
+					flagExpr(d.Flags, dscFlags))
+				fmt.Fprintf(w, "        .access_cb = %s,\n", d.AccessCb)
+				fmt.Fprintf(w, "    },\n")
+			}
+			fmt.Fprintf(w, "    { 0 },\n};\n\n")
+		}
+
+		fmt.Fprintf(w, "static const struct ble_gatt_chr_def "+
+			"%s_svc%d_chrs[] = {\n", g.Name, i)
+		for j, c := range s.Characteristics {
+			fmt.Fprintf(w, "    {\n")
+			fmt.Fprintf(w, "        .uuid = &%s_svc%d_chr%d_uuid.u,\n",
+				g.Name, i, j)
+			fmt.Fprintf(w, "        .access_cb = %s,\n", c.AccessCb)
+			if len(c.Descriptors) > 0 {
+				fmt.Fprintf(w, "        .descriptors = %s_svc%d_chr%d_dscs,\n",
+					g.Name, i, j)
+			}
+			fmt.Fprintf(w, "        .flags = %s,\n",
+				flagExpr(c.Flags, chrFlags))
+			if c.ValHandle != "" {
+				fmt.Fprintf(w, "        .val_handle = &%s,\n", c.ValHandle)
+			}
+			fmt.Fprintf(w, "    },\n")
+		}
+		fmt.Fprintf(w, "    { 0 },\n};\n")
+		w.End()
+	}
+
+	fmt.Fprintf(w, "\nconst struct ble_gatt_svc_def %s_gatt_svcs[] = {\n",
+		g.Name)
+	for i, s := range g.Services {
+		typ := "BLE_GATT_SVC_TYPE_PRIMARY"
+		if s.Secondary {
+			typ = "BLE_GATT_SVC_TYPE_SECONDARY"
+		}
+
+		w.Begin("    ", g.source(i))
+		fmt.Fprintf(w, "    {\n")
+		fmt.Fprintf(w, "        .type = %s,\n", typ)
+		fmt.Fprintf(w, "        .uuid = &%s_svc%d_uuid.u,\n", g.Name, i)
+		if len(s.Characteristics) > 0 {
+			fmt.Fprintf(w, "        .characteristics = %s_svc%d_chrs,\n",
+				g.Name, i)
+		}
+		fmt.Fprintf(w, "    },\n")
+		w.End()
+	}
+	fmt.Fprintf(w, "    { 0 },\n};\n")
+}
+
+func writeIfChanged(buf *newtutil.GenWriter, path string) error {
+	if err := buf.WriteSourceMap(path); err != nil {
+		return err
+	}
+
+	contents := buf.Bytes()
+	changed, err := util.FileContentsChanged(path, contents)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		log.Debugf("GATT code unchanged; not writing %s", path)
+		return nil
+	}
+
+	log.Debugf("GATT code changed; writing %s", path)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Writes the generated source file to srcDir and the header to includeDir.
+// Files whose contents are unchanged are not rewritten, so that dependent
+// objects aren't rebuilt.
+func (g *Gatt) EnsureWritten(srcDir string, includeDir string) error {
+	buf := newtutil.NewGenWriter()
+	g.writeHeader(buf)
+	if err := writeIfChanged(buf,
+		filepath.Join(includeDir, g.HeaderName())); err != nil {
+
+		return err
+	}
+
+	buf = newtutil.NewGenWriter()
+	g.writeSrc(buf)
+	return writeIfChanged(buf, filepath.Join(srcDir, g.SrcName()))
+}
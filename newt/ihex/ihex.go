@@ -0,0 +1,129 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package ihex writes Intel HEX files.
+package ihex
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"mynewt.apache.org/newt/util"
+)
+
+const (
+	RECORD_DATA    = 0x00
+	RECORD_EOF     = 0x01
+	RECORD_EXT_LIN = 0x04
+)
+
+// Number of data bytes per record.
+const RECORD_LEN = 16
+
+// A contiguous run of bytes at an absolute address.
+type Segment struct {
+	Addr uint32
+	Data []byte
+
+	// Describes where the data came from; used in error messages.
+	Source string
+}
+
+func writeRecord(buf *bytes.Buffer, typ byte, addr uint16, data []byte) {
+	sum := byte(len(data)) + byte(addr>>8) + byte(addr) + typ
+	for _, b := range data {
+		sum += b
+	}
+
+	fmt.Fprintf(buf, ":%02X%04X%02X", len(data), addr, typ)
+	for _, b := range data {
+		fmt.Fprintf(buf, "%02X", b)
+	}
+	fmt.Fprintf(buf, "%02X\n", byte(-int(sum)))
+}
+
+type segmentSorter struct {
+	segments []Segment
+}
+
+func (s segmentSorter) Len() int {
+	return len(s.segments)
+}
+func (s segmentSorter) Swap(i, j int) {
+	s.segments[i], s.segments[j] = s.segments[j], s.segments[i]
+}
+func (s segmentSorter) Less(i, j int) bool {
+	return s.segments[i].Addr < s.segments[j].Addr
+}
+
+// Encodes the specified segments as an Intel HEX file.  Segments are written
+// in address order and must not overlap.
+func Encode(segs []Segment) ([]byte, error) {
+	sorted := make([]Segment, len(segs))
+	copy(sorted, segs)
+	sort.Stable(segmentSorter{sorted})
+
+	for i := 1; i < len(sorted); i++ {
+		prev := sorted[i-1]
+		if uint64(prev.Addr)+uint64(len(prev.Data)) > uint64(sorted[i].Addr) {
+			return nil, util.FmtNewtError(
+				"%s (0x%08x-0x%08x) overlaps %s (0x%08x-0x%08x)",
+				prev.Source, prev.Addr, uint64(prev.Addr)+uint64(len(prev.Data)),
+				sorted[i].Source, sorted[i].Addr,
+				uint64(sorted[i].Addr)+uint64(len(sorted[i].Data)))
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	upper := -1
+
+	for _, seg := range sorted {
+		if uint64(seg.Addr)+uint64(len(seg.Data)) > 1<<32 {
+			return nil, util.FmtNewtError(
+				"%s extends beyond the 32-bit address space", seg.Source)
+		}
+
+		for off := 0; off < len(seg.Data); {
+			addr := seg.Addr + uint32(off)
+			if int(addr>>16) != upper {
+				upper = int(addr >> 16)
+				writeRecord(buf, RECORD_EXT_LIN, 0,
+					[]byte{byte(upper >> 8), byte(upper)})
+			}
+
+			// Records may not cross a 64 KB boundary.
+			n := RECORD_LEN
+			if rem := 0x10000 - int(addr&0xffff); rem < n {
+				n = rem
+			}
+			if rem := len(seg.Data) - off; rem < n {
+				n = rem
+			}
+
+			writeRecord(buf, RECORD_DATA, uint16(addr),
+				seg.Data[off:off+n])
+			off += n
+		}
+	}
+
+	writeRecord(buf, RECORD_EOF, 0, nil)
+
+	return buf.Bytes(), nil
+}
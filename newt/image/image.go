@@ -69,6 +69,19 @@ type Image struct {
 	SrcSkip    uint // Number of bytes to skip from the source image.
 	HeaderSize uint // If non-zero pad out the header to this size.
 	TotalSize  uint // Total size, in bytes, of the generated .img file.
+
+	// If non-zero, the .img file is padded with erased bytes to a multiple
+	// of this many bytes, the flash's write alignment.
+	Align uint
+
+	// If non-zero, the .img file is padded with erased bytes to this size,
+	// e.g., the size of the image slot.
+	PadSize uint
+
+	// If UseSecCounter is set, a security counter TLV containing SecCounter
+	// is added to the trailer.
+	UseSecCounter bool
+	SecCounter    uint32
 }
 
 type ImageHdr struct {
@@ -98,6 +111,9 @@ const (
 	IMAGE_HEADER_SIZE = 32
 )
 
+// The value of erased flash; padding is filled with it.
+const IMAGE_ERASED_VAL = 0xff
+
 /*
  * Image header flags.
  */
@@ -119,6 +135,7 @@ const (
 	IMAGE_TLV_RSA2048  = 2
 	IMAGE_TLV_ECDSA224 = 3
 	IMAGE_TLV_ECDSA256 = 4
+	IMAGE_TLV_SEC_CNT  = 0x50 /* Security counter (MCUboot) */
 )
 
 /*
@@ -160,8 +177,12 @@ type ImageManifest struct {
 	Pkgs       []*ImageManifestPkg `json:"pkgs"`
 	LoaderPkgs []*ImageManifestPkg `json:"loader_pkgs,omitempty"`
 	TgtVars    []string            `json:"target"`
+	Env        []string            `json:"env,omitempty"`
 	Repos      []ImageManifestRepo `json:"repos"`
 
+	// Contents of the build profile, if it is defined in project.yml.
+	BuildProfile *ImageManifestProfile `json:"build_profile,omitempty"`
+
 	PkgSizes       []*ImageManifestSizePkg `json:"pkgsz"`
 	LoaderPkgSizes []*ImageManifestSizePkg `json:"loader_pkgsz,omitempty"`
 }
@@ -171,6 +192,15 @@ type ImageManifestPkg struct {
 	Repo string `json:"repo"`
 }
 
+type ImageManifestProfile struct {
+	Name    string   `json:"name"`
+	Base    string   `json:"base"`
+	Cflags  []string `json:"cflags,omitempty"`
+	Lflags  []string `json:"lflags,omitempty"`
+	Aflags  []string `json:"aflags,omitempty"`
+	Defines []string `json:"defines,omitempty"`
+}
+
 type ImageManifestRepo struct {
 	Name   string `json:"name"`
 	Commit string `json:"commit"`
@@ -231,6 +261,13 @@ func (ver ImageVersion) String() string {
 		ver.Major, ver.Minor, ver.Rev, ver.BuildNum)
 }
 
+// Derives a security counter from the version, as MCUboot's imgtool does for
+// "--security-counter auto": the major, minor and revision numbers are packed
+// into the counter, so that it increases with every release.
+func (ver ImageVersion) SecurityCounter() uint32 {
+	return uint32(ver.Major)<<24 | uint32(ver.Minor)<<16 | uint32(ver.Rev)
+}
+
 func NewImage(srcBinPath string, dstImgPath string) (*Image, error) {
 	image := &Image{}
 
@@ -267,10 +304,15 @@ func (image *Image) SetVersion(versStr string) error {
 	return nil
 }
 
-func (image *Image) SetSigningKey(fileName string, keyId uint8) error {
+// Reads an RSA or EC private key in PEM format.  On success, exactly one of
+// the returned keys is non-nil.
+func ReadSigningKey(fileName string) (*rsa.PrivateKey, *ecdsa.PrivateKey,
+	error) {
+
 	data, err := ioutil.ReadFile(fileName)
 	if err != nil {
-		return util.NewNewtError(fmt.Sprintf("Error reading key file: %s", err))
+		return nil, nil, util.NewNewtError(fmt.Sprintf(
+			"Error reading key file: %s", err))
 	}
 
 	block, data := pem.Decode(data)
@@ -289,10 +331,10 @@ func (image *Image) SetSigningKey(fileName string, keyId uint8) error {
 		 */
 		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 		if err != nil {
-			return util.NewNewtError(fmt.Sprintf("Private key parsing "+
-				"failed: %s", err))
+			return nil, nil, util.NewNewtError(fmt.Sprintf(
+				"Private key parsing failed: %s", err))
 		}
-		image.SigningRSA = privateKey
+		return privateKey, nil, nil
 	}
 	if block != nil && block.Type == "EC PRIVATE KEY" {
 		/*
@@ -300,15 +342,24 @@ func (image *Image) SetSigningKey(fileName string, keyId uint8) error {
 		 */
 		privateKey, err := x509.ParseECPrivateKey(block.Bytes)
 		if err != nil {
-			return util.NewNewtError(fmt.Sprintf("Private key parsing "+
-				"failed: %s", err))
+			return nil, nil, util.NewNewtError(fmt.Sprintf(
+				"Private key parsing failed: %s", err))
 		}
-		image.SigningEC = privateKey
+		return nil, privateKey, nil
 	}
-	if image.SigningEC == nil && image.SigningRSA == nil {
-		return util.NewNewtError("Unknown private key format, EC/RSA private " +
-			"key in PEM format only.")
+
+	return nil, nil, util.NewNewtError("Unknown private key format, " +
+		"EC/RSA private key in PEM format only.")
+}
+
+func (image *Image) SetSigningKey(fileName string, keyId uint8) error {
+	rsaKey, ecKey, err := ReadSigningKey(fileName)
+	if err != nil {
+		return err
 	}
+
+	image.SigningRSA = rsaKey
+	image.SigningEC = ecKey
 	image.KeyId = keyId
 
 	return nil
@@ -369,6 +420,38 @@ func (image *Image) sigTlvType() uint8 {
 	}
 }
 
+// Returns the name of the algorithm the image is signed with (e.g.,
+// "ECDSA-P256"), or "" if the image is not signed.
+func (image *Image) SigAlgName() string {
+	if image.SigningRSA != nil {
+		return fmt.Sprintf("RSA-%d", image.SigningRSA.N.BitLen())
+	} else if image.SigningEC != nil {
+		return "ECDSA-" + strings.Replace(
+			image.SigningEC.Curve.Params().Name, "-", "", -1)
+	} else {
+		return ""
+	}
+}
+
+// Returns the number of bytes that Generate() adds to the source binary: the
+// (padded) header and the trailing TLVs.
+func (image *Image) Overhead() int {
+	hdrSz := IMAGE_HEADER_SIZE
+	if image.HeaderSize > IMAGE_HEADER_SIZE {
+		hdrSz = int(image.HeaderSize)
+	}
+
+	tlvSz := 4 + 32
+	if sigLen := image.sigLen(); sigLen != 0 {
+		tlvSz += 4 + int(sigLen)
+	}
+	if image.UseSecCounter {
+		tlvSz += 4 + 4
+	}
+
+	return hdrSz + tlvSz
+}
+
 func (image *Image) ReSign() error {
 	srcImg, err := os.Open(image.SourceImg)
 	if err != nil {
@@ -390,8 +473,9 @@ func (image *Image) ReSign() error {
 			image.SourceImg, err.Error()))
 	}
 
-	if uint32(srcInfo.Size()) != uint32(hdr.HdrSz)+hdr.ImgSz+uint32(hdr.TlvSz) ||
-		hdr.Magic != IMAGE_MAGIC {
+	// A padded image is larger than its contents.
+	imgSz := uint32(hdr.HdrSz) + hdr.ImgSz + uint32(hdr.TlvSz)
+	if uint32(srcInfo.Size()) < imgSz || hdr.Magic != IMAGE_MAGIC {
 
 		return util.NewNewtError(fmt.Sprintf("File %s is not an image\n",
 			image.SourceImg))
@@ -413,13 +497,25 @@ func (image *Image) ReSign() error {
 		image.SourceImg, int64(hdr.HdrSz), int64(hdr.HdrSz)+int64(hdr.ImgSz),
 		tmpBinName)
 	_, err = io.CopyN(tmpBin, srcImg, int64(hdr.ImgSz))
-	srcImg.Close()
 	tmpBin.Close()
 	if err != nil {
+		srcImg.Close()
 		return util.NewNewtError(fmt.Sprintf("Cannot copy to tmpfile %s: %s",
 			tmpBin.Name(), err.Error()))
 	}
 
+	// Carry the security counter over to the re-signed image.
+	err = image.readSecCounter(srcImg, int(hdr.TlvSz))
+	srcImg.Close()
+	if err != nil {
+		return util.NewNewtError(fmt.Sprintf("Can't read TLVs of %s: %s",
+			image.SourceImg, err.Error()))
+	}
+
+	if uint32(srcInfo.Size()) > imgSz {
+		image.PadSize = uint(srcInfo.Size())
+	}
+
 	image.SourceBin = tmpBinName
 	image.TargetImg = image.SourceImg
 	image.Version = hdr.Vers
@@ -428,6 +524,32 @@ func (image *Image) ReSign() error {
 	return image.Generate(nil)
 }
 
+// Scans the trailer TLVs of an existing image for a security counter.
+func (image *Image) readSecCounter(r io.Reader, tlvSz int) error {
+	for tlvSz >= 4 {
+		var tlv ImageTrailerTlv
+		if err := binary.Read(r, binary.LittleEndian, &tlv); err != nil {
+			return err
+		}
+		tlvSz -= 4 + int(tlv.Len)
+
+		if tlv.Type == IMAGE_TLV_SEC_CNT && tlv.Len == 4 {
+			if err := binary.Read(r, binary.LittleEndian,
+				&image.SecCounter); err != nil {
+
+				return err
+			}
+			image.UseSecCounter = true
+		} else if _, err := io.CopyN(ioutil.Discard, r,
+			int64(tlv.Len)); err != nil {
+
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (image *Image) Generate(loader *Image) error {
 	binFile, err := os.Open(image.SourceBin)
 	if err != nil {
@@ -494,6 +616,10 @@ func (image *Image) Generate(loader *Image) error {
 	hdr.TlvSz += 4 + 32
 	hdr.Flags |= IMAGE_F_SHA256
 
+	if image.UseSecCounter {
+		hdr.TlvSz += 4 + 4
+	}
+
 	if loader != nil {
 		hdr.Flags |= IMAGE_F_NON_BOOTABLE
 	}
@@ -612,6 +738,23 @@ func (image *Image) Generate(loader *Image) error {
 			err.Error()))
 	}
 
+	if image.UseSecCounter {
+		tlv := &ImageTrailerTlv{
+			Type: IMAGE_TLV_SEC_CNT,
+			Pad:  0,
+			Len:  4,
+		}
+		err = binary.Write(imgFile, binary.LittleEndian, tlv)
+		if err == nil {
+			err = binary.Write(imgFile, binary.LittleEndian,
+				image.SecCounter)
+		}
+		if err != nil {
+			return util.NewNewtError(fmt.Sprintf("Failed to serialize "+
+				"security counter: %s", err.Error()))
+		}
+	}
+
 	if image.SigningRSA != nil {
 		/*
 		 * If signing key was set, generate TLV for that.
@@ -692,7 +835,7 @@ func (image *Image) Generate(loader *Image) error {
 		}
 	}
 
-	util.StatusMessage(util.VERBOSITY_VERBOSE,
+	statusMessage(util.VERBOSITY_VERBOSE,
 		"Computed Hash for image %s as %s \n",
 		image.TargetImg, hex.EncodeToString(image.Hash))
 
@@ -702,11 +845,43 @@ func (image *Image) Generate(loader *Image) error {
 		return util.FmtNewtError("Failed to calculate file size of generated "+
 			"image %s: %s", image.TargetImg, err.Error())
 	}
-	image.TotalSize = uint(sz)
+
+	padSz, err := image.padding(uint(sz))
+	if err != nil {
+		return err
+	}
+	if padSz > 0 {
+		pad := bytes.Repeat([]byte{IMAGE_ERASED_VAL}, int(padSz))
+		if _, err := imgFile.Write(pad); err != nil {
+			return util.NewNewtError(fmt.Sprintf("Failed to pad image: %s",
+				err.Error()))
+		}
+	}
+	image.TotalSize = uint(sz) + padSz
 
 	return nil
 }
 
+// Returns the number of erased bytes to append to an image of the specified
+// size to satisfy the alignment and padding requirements.
+func (image *Image) padding(sz uint) (uint, error) {
+	padded := sz
+	if image.Align > 1 {
+		padded = (padded + image.Align - 1) / image.Align * image.Align
+	}
+
+	if image.PadSize != 0 {
+		if padded > image.PadSize {
+			return 0, util.FmtNewtError("Image %s is %d bytes; too large "+
+				"to pad to %d bytes", image.TargetImg, padded,
+				image.PadSize)
+		}
+		padded = image.PadSize
+	}
+
+	return padded - sz, nil
+}
+
 func CreateBuildId(app *Image, loader *Image) []byte {
 	return app.Hash
 }
@@ -861,3 +1036,8 @@ func (s *ImageManifestSizeSym) addArea(area string, areaSz uint32) {
 	}
 	s.Areas = append(s.Areas, a)
 }
+
+// Prints a status message according to the image subsystem's verbosity.
+func statusMessage(level int, message string, args ...interface{}) {
+	util.SubsysStatusMessage(util.SUBSYS_IMAGE, level, message, args...)
+}
@@ -0,0 +1,240 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package image
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// An image read back from flash contents or an .img file.
+type ParsedImage struct {
+	Header ImageHdr
+
+	// Header and body; the data the image hash covers.
+	Body []byte
+
+	// Trailer TLVs in order of appearance.
+	Tlvs []ParsedTlv
+}
+
+type ParsedTlv struct {
+	Type uint8
+	Data []byte
+}
+
+// Parses an image at the start of the specified data; anything past the
+// image's trailer (e.g., the rest of the flash area) is ignored.
+func ParseImage(data []byte) (*ParsedImage, error) {
+	img := &ParsedImage{}
+
+	r := bytes.NewReader(data)
+	if err := binary.Read(r, binary.LittleEndian, &img.Header); err != nil {
+		return nil, util.NewNewtError("Image header truncated")
+	}
+	hdr := img.Header
+	if hdr.Magic != IMAGE_MAGIC {
+		return nil, util.FmtNewtError("No image found (bad magic 0x%08x)",
+			hdr.Magic)
+	}
+
+	bodyEnd := int(hdr.HdrSz) + int(hdr.ImgSz)
+	tlvEnd := bodyEnd + int(hdr.TlvSz)
+	if int(hdr.HdrSz) < IMAGE_HEADER_SIZE || tlvEnd > len(data) {
+		return nil, util.FmtNewtError("Image sizes inconsistent: hdr=%d "+
+			"img=%d tlv=%d available=%d", hdr.HdrSz, hdr.ImgSz, hdr.TlvSz,
+			len(data))
+	}
+	img.Body = data[:bodyEnd]
+
+	for off := bodyEnd; off < tlvEnd; {
+		if off+4 > tlvEnd {
+			return nil, util.NewNewtError("Image trailer truncated")
+		}
+		tlv := ParsedTlv{Type: data[off]}
+		tlvLen := int(binary.LittleEndian.Uint16(data[off+2 : off+4]))
+		off += 4
+		if off+tlvLen > tlvEnd {
+			return nil, util.NewNewtError("Image trailer TLV truncated")
+		}
+		tlv.Data = data[off : off+tlvLen]
+		img.Tlvs = append(img.Tlvs, tlv)
+		off += tlvLen
+	}
+
+	return img, nil
+}
+
+func (img *ParsedImage) Tlv(typ uint8) *ParsedTlv {
+	for i, _ := range img.Tlvs {
+		if img.Tlvs[i].Type == typ {
+			return &img.Tlvs[i]
+		}
+	}
+	return nil
+}
+
+// Returns the hash stored in the image's trailer; nil if there is none.
+func (img *ParsedImage) Hash() []byte {
+	if tlv := img.Tlv(IMAGE_TLV_SHA256); tlv != nil {
+		return tlv.Data
+	}
+	return nil
+}
+
+// Recomputes the image hash and compares it with the stored one.  The hash
+// of a split app also covers its loader's hash, so it can't be verified in
+// isolation.
+func (img *ParsedImage) VerifyHash() error {
+	stored := img.Hash()
+	if stored == nil {
+		return util.NewNewtError("Image has no hash")
+	}
+	if img.Header.Flags&IMAGE_F_NON_BOOTABLE != 0 {
+		return util.NewNewtError("Split app image hash depends on its loader")
+	}
+
+	computed := sha256.Sum256(img.Body)
+	if !bytes.Equal(computed[:], stored) {
+		return util.FmtNewtError("Image hash mismatch: stored=%x "+
+			"computed=%x", stored, computed)
+	}
+
+	return nil
+}
+
+// Returns the name of the image's signature type; empty if it is unsigned.
+func (img *ParsedImage) SigType() string {
+	flags := img.Header.Flags
+	switch {
+	case flags&IMAGE_F_PKCS15_RSA2048_SHA256 != 0:
+		return "RSA2048-PKCS1.5"
+	case flags&IMAGE_F_PKCS1_PSS_RSA2048_SHA256 != 0:
+		return "RSA2048-PSS"
+	case flags&IMAGE_F_ECDSA224_SHA256 != 0:
+		return "ECDSA224"
+	case flags&IMAGE_F_ECDSA256_SHA256 != 0:
+		return "ECDSA256"
+	default:
+		return ""
+	}
+}
+
+// Verifies the image's signature with the specified public key.  The
+// signature covers the stored hash, which should be verified separately.
+func (img *ParsedImage) VerifySig(key crypto.PublicKey) error {
+	hash := img.Hash()
+	if hash == nil {
+		return util.NewNewtError("Image has no hash")
+	}
+
+	flags := img.Header.Flags
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		tlv := img.Tlv(IMAGE_TLV_RSA2048)
+		if tlv == nil {
+			return util.NewNewtError("Image has no RSA signature")
+		}
+
+		var err error
+		if flags&IMAGE_F_PKCS1_PSS_RSA2048_SHA256 != 0 {
+			opts := rsa.PSSOptions{
+				SaltLength: rsa.PSSSaltLengthEqualsHash,
+			}
+			err = rsa.VerifyPSS(k, crypto.SHA256, hash, tlv.Data, &opts)
+		} else {
+			err = rsa.VerifyPKCS1v15(k, crypto.SHA256, hash, tlv.Data)
+		}
+		if err != nil {
+			return util.FmtNewtError("Bad signature: %s", err.Error())
+		}
+
+	case *ecdsa.PublicKey:
+		tlv := img.Tlv(IMAGE_TLV_ECDSA256)
+		if tlv == nil {
+			tlv = img.Tlv(IMAGE_TLV_ECDSA224)
+		}
+		if tlv == nil {
+			return util.NewNewtError("Image has no ECDSA signature")
+		}
+
+		// The signature TLV is padded past the end of the ASN.1 data.
+		var sig ECDSASig
+		if _, err := asn1.Unmarshal(tlv.Data, &sig); err != nil {
+			return util.FmtNewtError("Malformed signature: %s",
+				err.Error())
+		}
+		if !ecdsa.Verify(k, hash, sig.R, sig.S) {
+			return util.NewNewtError("Bad signature")
+		}
+
+	default:
+		return util.NewNewtError("Unsupported key type")
+	}
+
+	return nil
+}
+
+// Reads a public key in PEM format for verifying signatures.  A private key
+// file is accepted as well; its public part is used.
+func ReadVerifyKey(fileName string) (crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, util.NewNewtError(fmt.Sprintf(
+			"Error reading key file: %s", err))
+	}
+
+	block, _ := pem.Decode(data)
+	if block != nil && block.Type == "PUBLIC KEY" {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, util.FmtNewtError("Public key parsing failed: %s",
+				err.Error())
+		}
+		return key, nil
+	}
+	if block != nil && block.Type == "RSA PUBLIC KEY" {
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, util.FmtNewtError("Public key parsing failed: %s",
+				err.Error())
+		}
+		return key, nil
+	}
+
+	rsaKey, ecKey, err := ReadSigningKey(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if rsaKey != nil {
+		return &rsaKey.PublicKey, nil
+	}
+	return &ecKey.PublicKey, nil
+}
@@ -35,12 +35,40 @@ import (
 	"mynewt.apache.org/newt/util"
 )
 
+type mfgManifestSection struct {
+	Device  int    `json:"device"`
+	Offset  int    `json:"offset"`
+	Address int    `json:"address"`
+	Size    int    `json:"size"`
+	BinPath string `json:"bin"`
+	HexPath string `json:"hex"`
+}
+
+type mfgManifestPart struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Area    string `json:"area,omitempty"`
+	Device  int    `json:"device"`
+	Offset  int    `json:"offset"`
+	Address int    `json:"address"`
+	Size    int    `json:"size"`
+	BinPath string `json:"bin"`
+	HexPath string `json:"hex"`
+}
+
 type mfgManifest struct {
 	BuildTime   string `json:"build_time"`
 	MfgHash     string `json:"mfg_hash"`
 	Version     string `json:"version"`
 	MetaSection int    `json:"meta_section"`
 	MetaOffset  int    `json:"meta_offset"`
+
+	// Layout of the meta region.
+	Meta metaLayout `json:"meta"`
+
+	// Paths are relative to the manifest's directory.
+	Sections []mfgManifestSection `json:"sections"`
+	Parts    []mfgManifestPart    `json:"parts"`
 }
 
 type mfgSection struct {
@@ -51,7 +79,8 @@ type mfgSection struct {
 type createState struct {
 	// {0:[section0], 1:[section1], ...}
 	dsMap      map[int]mfgSection
-	metaOffset int
+	parts      []mfgPart
+	meta       metaLayout
 	hashOffset int
 	hash       []byte
 }
@@ -69,10 +98,7 @@ func insertPartIntoBlob(section mfgSection, part mfgPart) {
 func (mi *MfgImage) partFromImage(
 	imgPath string, flashAreaName string) (mfgPart, error) {
 
-	part := mfgPart{
-		// Boot loader and images always go in device 0.
-		device: 0,
-	}
+	part := mfgPart{}
 
 	area, ok := mi.bsp.FlashMap.Areas[flashAreaName]
 	if !ok {
@@ -81,8 +107,11 @@ func (mi *MfgImage) partFromImage(
 			imgPath, flashAreaName)
 	}
 
+	// Images are placed in whichever device hosts their flash area.
+	part.device = area.Device
 	part.name = fmt.Sprintf("%s (%s)", flashAreaName, filepath.Base(imgPath))
 	part.offset = area.Offset
+	part.area = flashAreaName
 
 	var err error
 
@@ -112,9 +141,33 @@ func (mi *MfgImage) partFromImage(
 
 func partFromRawEntry(entry MfgRawEntry, entryIdx int) mfgPart {
 	return mfgPart{
-		name:   fmt.Sprintf("entry-%d (%s)", entryIdx, entry.filename),
-		offset: entry.offset,
-		data:   entry.data,
+		name:     fmt.Sprintf("entry-%d (%s)", entryIdx, entry.filename),
+		device:   entry.device,
+		offset:   entry.offset,
+		data:     entry.data,
+		kind:     MFG_PART_RAW,
+		area:     entry.area,
+		fileName: fmt.Sprintf("%s-%d", MFG_PART_RAW, entryIdx),
+	}
+}
+
+// Fills an entire flash area with the fill's pattern.
+func (mi *MfgImage) partFromFill(fill MfgFill) mfgPart {
+	area := mi.bsp.FlashMap.Areas[fill.area]
+
+	data := make([]byte, area.Size)
+	for i, _ := range data {
+		data[i] = fill.pattern[i%len(fill.pattern)]
+	}
+
+	return mfgPart{
+		name:     fmt.Sprintf("%s (fill)", fill.area),
+		device:   area.Device,
+		offset:   area.Offset,
+		data:     data,
+		kind:     MFG_PART_FILL,
+		area:     fill.area,
+		fileName: MFG_PART_FILL + "-" + fill.area,
 	}
 }
 
@@ -128,6 +181,8 @@ func (mi *MfgImage) targetParts() ([]mfgPart, error) {
 		if err != nil {
 			return nil, err
 		}
+		bootPart.kind = MFG_PART_BOOT
+		bootPart.fileName = MFG_PART_BOOT
 
 		parts = append(parts, bootPart)
 	}
@@ -144,10 +199,22 @@ func (mi *MfgImage) targetParts() ([]mfgPart, error) {
 			if err != nil {
 				return nil, err
 			}
+			part.kind = MFG_PART_IMAGE
+			part.fileName = fmt.Sprintf("%s%d", MFG_PART_IMAGE, i)
 			parts = append(parts, part)
 		}
 	}
 
+	for i, entry := range mi.targets {
+		part, err := mi.partFromImage(mi.dstTargetPath(i), entry.area)
+		if err != nil {
+			return nil, err
+		}
+		part.kind = MFG_PART_TARGET
+		part.fileName = fmt.Sprintf("%s%d", MFG_PART_TARGET, i)
+		parts = append(parts, part)
+	}
+
 	return parts, nil
 }
 
@@ -182,8 +249,16 @@ func sectionFromParts(parts []mfgPart) mfgSection {
 		blob[i] = 0xff
 	}
 
+	// Fills go in first; other parts in their areas replace them.
 	for _, part := range parts {
-		insertPartIntoBlob(section, part)
+		if part.kind == MFG_PART_FILL {
+			insertPartIntoBlob(section, part)
+		}
+	}
+	for _, part := range parts {
+		if part.kind != MFG_PART_FILL {
+			insertPartIntoBlob(section, part)
+		}
 	}
 
 	return section
@@ -198,12 +273,25 @@ func (mi *MfgImage) devicePartMap() (map[int][]mfgPart, error) {
 		dpMap[entry.device] = append(dpMap[entry.device], part)
 	}
 
-	// Insert the boot loader and image parts into section 0.
+	// Insert the boot loader and image parts into their host devices'
+	// sections.
 	targetParts, err := mi.targetParts()
 	if err != nil {
 		return nil, err
 	}
-	dpMap[0] = append(dpMap[0], targetParts...)
+	for _, part := range targetParts {
+		dpMap[part.device] = append(dpMap[part.device], part)
+	}
+
+	for _, fill := range mi.fills {
+		part := mi.partFromFill(fill)
+		dpMap[part.device] = append(dpMap[part.device], part)
+	}
+
+	if mi.provision != nil {
+		part := mi.provisionPart()
+		dpMap[part.device] = append(dpMap[part.device], part)
+	}
 
 	// Sort each part slice by offset.
 	for device, _ := range dpMap {
@@ -213,19 +301,42 @@ func (mi *MfgImage) devicePartMap() (map[int][]mfgPart, error) {
 	return dpMap, nil
 }
 
-func (mi *MfgImage) deviceSectionMap() (map[int]mfgSection, error) {
-	dpMap, err := mi.devicePartMap()
-	if err != nil {
-		return nil, err
-	}
+// Ensures no section covers an area that can't be bulk-erased.  Each section is
+// written from the start of its device, so it covers every area below the end
+// of its blob.
+func (mi *MfgImage) checkBulkErase(dsMap map[int]mfgSection) error {
+	for _, area := range mi.bsp.FlashMap.SortedAreas() {
+		if area.BulkErasable() {
+			continue
+		}
 
-	// Convert each part slice into a section.
-	dsMap := map[int]mfgSection{}
-	for device, parts := range dpMap {
-		dsMap[device] = sectionFromParts(parts)
+		section, ok := dsMap[area.Device]
+		if !ok || area.Offset >= len(section.blob) {
+			continue
+		}
+
+		return util.FmtNewtError(
+			"Manufacturing image section %d covers %s area %s "+
+				"(offset=0x%x); %s areas can't be part of a bulk-erase "+
+				"image; program them with `newt load --program-areas`",
+			area.Device, area.Type, area.Name, area.Offset, area.Type)
 	}
 
-	return dsMap, nil
+	return nil
+}
+
+type partDeviceSorter struct {
+	parts []mfgPart
+}
+
+func (s partDeviceSorter) Len() int {
+	return len(s.parts)
+}
+func (s partDeviceSorter) Swap(i, j int) {
+	s.parts[i], s.parts[j] = s.parts[j], s.parts[i]
+}
+func (s partDeviceSorter) Less(i, j int) bool {
+	return s.parts[i].device < s.parts[j].device
 }
 
 func (mi *MfgImage) createSections() (createState, error) {
@@ -237,17 +348,28 @@ func (mi *MfgImage) createSections() (createState, error) {
 		return cs, err
 	}
 
-	cs.dsMap, err = mi.deviceSectionMap()
+	dpMap, err := mi.devicePartMap()
 	if err != nil {
 		return cs, err
 	}
+	cs.dsMap = map[int]mfgSection{}
+	for device, parts := range dpMap {
+		cs.dsMap[device] = sectionFromParts(parts)
+		cs.parts = append(cs.parts, parts...)
+	}
+	sort.Stable(partDeviceSorter{cs.parts})
+
+	if _, ok := cs.dsMap[0]; !ok {
+		return cs, util.NewNewtError(
+			"Manufacturing image does not contain a section 0")
+	}
 
-	if len(cs.dsMap) < 1 {
-		panic("Invalid state; no section 0")
+	if err := mi.checkBulkErase(cs.dsMap); err != nil {
+		return cs, err
 	}
 
-	cs.metaOffset, cs.hashOffset, err = insertMeta(cs.dsMap[0].blob,
-		mi.bsp.FlashMap)
+	cs.meta, cs.hashOffset, err = insertMeta(cs.dsMap[0].blob,
+		mi.bsp.FlashMap, mi.metaTlvs)
 	if err != nil {
 		return cs, err
 	}
@@ -365,9 +487,40 @@ func (mi *MfgImage) copyBinFiles() error {
 		}
 	}
 
+	for i, entry := range mi.targets {
+		dstDir := MfgTargetBinDir(mi.basePkg.Name(), i)
+		for _, path := range targetEntryFromPaths(entry) {
+			if err := mi.copyBinFile(path, dstDir); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
+// An mfg.targets entry is copied like the boot loader if its raw binary is
+// placed, or like an image otherwise.
+func targetEntryFromPaths(entry MfgTargetEntry) []string {
+	if entry.bin {
+		return bootLoaderFromPaths(entry.target)
+	}
+	return appFromPaths(entry.target)
+}
+
+// Returns the path of the file an mfg.targets entry places in flash.
+func (mi *MfgImage) dstTargetPath(entryIdx int) string {
+	entry := mi.targets[entryIdx]
+
+	ext := ".img"
+	if entry.bin {
+		ext = ".elf.bin"
+	}
+
+	return MfgTargetBinDir(mi.basePkg.Name(), entryIdx) + "/" +
+		pkg.ShortName(entry.target.App()) + ext
+}
+
 func (mi *MfgImage) dstBootBinPath() string {
 	if mi.boot == nil {
 		return ""
@@ -431,6 +584,10 @@ func (mi *MfgImage) FromPaths() []string {
 		paths = append(paths, imageFromPaths(mi.images[1])...)
 	}
 
+	for _, entry := range mi.targets {
+		paths = append(paths, targetEntryFromPaths(entry)...)
+	}
+
 	for _, raw := range mi.rawEntries {
 		paths = append(paths, raw.filename)
 	}
@@ -451,14 +608,62 @@ func (mi *MfgImage) build() (createState, error) {
 	return cs, nil
 }
 
+func (mi *MfgImage) manifestRelPath(path string) string {
+	rel, err := filepath.Rel(MfgBinDir(mi.basePkg.Name()), path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
 func (mi *MfgImage) createManifest(cs createState) ([]byte, error) {
 	manifest := mfgManifest{
 		BuildTime:   time.Now().Format(time.RFC3339),
 		Version:     mi.version.String(),
 		MfgHash:     fmt.Sprintf("%x", cs.hash),
 		MetaSection: 0,
-		MetaOffset:  cs.metaOffset,
+		MetaOffset:  cs.meta.Offset,
+		Meta:        cs.meta,
+		Sections:    []mfgManifestSection{},
+		Parts:       []mfgManifestPart{},
+	}
+
+	for _, device := range mi.sectionIds() {
+		section, ok := cs.dsMap[device]
+		if !ok {
+			continue
+		}
+
+		manifest.Sections = append(manifest.Sections, mfgManifestSection{
+			Device: device,
+			Offset: section.offset,
+			Address: mi.bsp.FlashMap.DeviceAddress(device,
+				section.offset),
+			Size: len(section.blob) - section.offset,
+			BinPath: mi.manifestRelPath(
+				MfgSectionBinPath(mi.basePkg.Name(), device)),
+			HexPath: mi.manifestRelPath(
+				MfgSectionHexPath(mi.basePkg.Name(), device)),
+		})
+	}
+
+	for _, part := range cs.parts {
+		manifest.Parts = append(manifest.Parts, mfgManifestPart{
+			Name:   part.name,
+			Kind:   part.kind,
+			Area:   part.area,
+			Device: part.device,
+			Offset: part.offset,
+			Address: mi.bsp.FlashMap.DeviceAddress(part.device,
+				part.offset),
+			Size: len(part.data),
+			BinPath: mi.manifestRelPath(
+				MfgPartBinPath(mi.basePkg.Name(), part.fileName)),
+			HexPath: mi.manifestRelPath(
+				MfgPartHexPath(mi.basePkg.Name(), part.fileName)),
+		})
 	}
+
 	buffer, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
 		return nil, util.FmtNewtError("Failed to encode mfg manifest: %s",
@@ -491,6 +696,10 @@ func (mi *MfgImage) ToPaths() []string {
 		paths = appendNonEmptyStr(paths, mi.ImageManifestPath(i))
 	}
 
+	for i, _ := range mi.targets {
+		paths = append(paths, mi.dstTargetPath(i))
+	}
+
 	paths = append(paths, mi.SectionBinPaths()...)
 	paths = append(paths, mi.SectionHexPaths()...)
 	paths = append(paths, mi.ManifestPath())
@@ -498,6 +707,40 @@ func (mi *MfgImage) ToPaths() []string {
 	return paths
 }
 
+// Writes each part to its own binary and hex file, so that parts can be
+// programmed individually.  The hex files carry the parts' absolute
+// addresses.  Part contents are taken from the finished sections, so they
+// include the mfg meta region and whatever covers a fill.
+func (mi *MfgImage) writeParts(cs createState) ([]string, error) {
+	partsDir := MfgPartsDir(mi.basePkg.Name())
+	if err := os.MkdirAll(partsDir, 0755); err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	paths := []string{}
+	for _, part := range cs.parts {
+		blob := cs.dsMap[part.device].blob
+		data := blob[part.offset : part.offset+len(part.data)]
+
+		binPath := MfgPartBinPath(mi.basePkg.Name(), part.fileName)
+		if err := ioutil.WriteFile(binPath, data, 0644); err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+
+		hexPath := MfgPartHexPath(mi.basePkg.Name(), part.fileName)
+		if err := mi.compiler.ConvertBinToHex(binPath, hexPath,
+			mi.bsp.FlashMap.DeviceAddress(part.device,
+				part.offset)); err != nil {
+
+			return nil, err
+		}
+
+		paths = append(paths, binPath, hexPath)
+	}
+
+	return paths, nil
+}
+
 // @return                      [paths-of-artifacts], error
 func (mi *MfgImage) CreateMfgImage() ([]string, error) {
 	cs, err := mi.build()
@@ -517,7 +760,21 @@ func (mi *MfgImage) CreateMfgImage() ([]string, error) {
 			return nil, util.ChildNewtError(err)
 		}
 		hexPath := MfgSectionHexPath(mi.basePkg.Name(), device)
-		mi.compiler.ConvertBinToHex(sectionPath, hexPath, section.offset)
+		mi.compiler.ConvertBinToHex(sectionPath, hexPath,
+			mi.bsp.FlashMap.DeviceAddress(device, section.offset))
+	}
+
+	partPaths, err := mi.writeParts(cs)
+	if err != nil {
+		return nil, err
+	}
+
+	if mi.provision != nil {
+		devicePaths, err := mi.createProvisioned(cs)
+		if err != nil {
+			return nil, err
+		}
+		partPaths = append(partPaths, devicePaths...)
 	}
 
 	manifest, err := mi.createManifest(cs)
@@ -531,5 +788,5 @@ func (mi *MfgImage) CreateMfgImage() ([]string, error) {
 			err.Error())
 	}
 
-	return mi.ToPaths(), nil
+	return append(mi.ToPaths(), partPaths...), nil
 }
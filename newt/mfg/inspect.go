@@ -0,0 +1,415 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package mfg
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/flash"
+	"mynewt.apache.org/newt/newt/image"
+	"mynewt.apache.org/newt/util"
+)
+
+type InspectImage struct {
+	Version   string `json:"version"`
+	Flags     uint32 `json:"flags"`
+	Size      int    `json:"size"`
+	Hash      string `json:"hash"`
+	HashValid bool   `json:"hash_valid"`
+	HashError string `json:"hash_error,omitempty"`
+	SigType   string `json:"sig_type,omitempty"`
+	KeyId     int    `json:"key_id"`
+
+	// "valid", "not checked", or the reason verification failed; empty if
+	// the image is unsigned.
+	SigStatus string `json:"sig_status,omitempty"`
+}
+
+type InspectArea struct {
+	Id     int           `json:"id"`
+	Name   string        `json:"name"`
+	Device int           `json:"device"`
+	Offset int           `json:"offset"`
+	Size   int           `json:"size"`
+	Image  *InspectImage `json:"image,omitempty"`
+
+	// Why the area's image could not be parsed; empty if the area holds no
+	// image.
+	ImageError string `json:"image_error,omitempty"`
+}
+
+type InspectTlv struct {
+	// Name given in mfg.meta.tlvs, if the mfg manifest is available.
+	Name string `json:"name,omitempty"`
+
+	Type int    `json:"type"`
+	Data string `json:"data"`
+}
+
+type InspectSection struct {
+	Device int    `json:"device"`
+	Offset int    `json:"offset"`
+	Path   string `json:"path"`
+}
+
+// The result of inspecting a manufacturing image.
+type MfgInspection struct {
+	Sections    []InspectSection `json:"sections"`
+	MetaOffset  int              `json:"meta_offset"`
+	MetaVersion int              `json:"meta_version"`
+	MfgHash     string           `json:"mfg_hash"`
+	HashValid   bool             `json:"hash_valid"`
+	HashError   string           `json:"hash_error,omitempty"`
+	Areas       []InspectArea    `json:"areas"`
+	OtherTlvs   []InspectTlv     `json:"other_tlvs,omitempty"`
+
+	// Section contents, indexed by device, starting at device offset 0.
+	blobs map[int][]byte
+}
+
+// Returns the names of the flash areas the mfg image's BSP defines, indexed
+// by area ID.
+func (mi *MfgImage) AreaNames() map[int]string {
+	names := map[int]string{}
+	for name, area := range mi.bsp.FlashMap.Areas {
+		names[area.Id] = name
+	}
+	return names
+}
+
+// Locates the manifest describing a section file written by newt mfg
+// create.  Returns nil if there is no manifest.
+func findManifest(section0Path string) *mfgManifest {
+	dir := filepath.Dir(section0Path)
+	for i := 0; i < 3; i++ {
+		dir = filepath.Dir(dir)
+		data, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+		if err != nil {
+			continue
+		}
+
+		var manifest mfgManifest
+		if err := json.Unmarshal(data, &manifest); err != nil ||
+			manifest.MfgHash == "" {
+
+			continue
+		}
+
+		return &manifest
+	}
+
+	return nil
+}
+
+// Finds the meta region in section 0 by its footer.  Returns the region's
+// offset and size.
+func findMeta(blob []byte) (int, int, error) {
+	magic := make([]byte, 4)
+	binary.LittleEndian.PutUint32(magic, META_MAGIC)
+
+	for end := len(blob); end > 0; {
+		idx := bytes.LastIndex(blob[:end], magic)
+		if idx < 0 {
+			break
+		}
+		end = idx
+
+		if idx < META_FOOTER_SZ-4 {
+			continue
+		}
+		size := int(binary.LittleEndian.Uint16(blob[idx-4 : idx-2]))
+		start := idx + 4 - size
+		if size < 4+META_FOOTER_SZ || start < 0 ||
+			blob[start] != META_VERSION {
+
+			continue
+		}
+
+		return start, size, nil
+	}
+
+	return 0, 0, util.NewNewtError("No manufacturing meta region found")
+}
+
+func inspectImage(data []byte, key crypto.PublicKey) (*InspectImage,
+	error) {
+
+	img, err := image.ParseImage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := img.Header
+	ii := &InspectImage{
+		Version: fmt.Sprintf("%d.%d.%d.%d", hdr.Vers.Major, hdr.Vers.Minor,
+			hdr.Vers.Rev, hdr.Vers.BuildNum),
+		Flags:   hdr.Flags,
+		Size:    len(img.Body),
+		Hash:    hex.EncodeToString(img.Hash()),
+		SigType: img.SigType(),
+		KeyId:   int(hdr.KeyId),
+	}
+
+	if err := img.VerifyHash(); err != nil {
+		ii.HashError = err.Error()
+	} else {
+		ii.HashValid = true
+	}
+
+	if ii.SigType != "" {
+		if key == nil {
+			ii.SigStatus = "not checked"
+		} else if err := img.VerifySig(key); err != nil {
+			ii.SigStatus = err.Error()
+		} else {
+			ii.SigStatus = "valid"
+		}
+	}
+
+	return ii, nil
+}
+
+// Inspects a manufacturing image: parses the meta region of section 0,
+// verifies the mfg hash over all sections, and parses and verifies the image
+// in each flash area.  The other sections are expected next to section 0,
+// named as newt mfg create names them.  names maps flash area IDs to names;
+// it may be nil.  Image signatures are verified if key is not nil.
+func Inspect(section0Path string, names map[int]string,
+	key crypto.PublicKey) (*MfgInspection, error) {
+
+	offsets := map[int]int{}
+	tlvNames := map[int]string{}
+	if manifest := findManifest(section0Path); manifest != nil {
+		for _, s := range manifest.Sections {
+			offsets[s.Device] = s.Offset
+		}
+		for _, tlv := range manifest.Meta.Tlvs {
+			tlvNames[tlv.Offset] = tlv.Name
+		}
+	}
+
+	ins := &MfgInspection{
+		Areas: []InspectArea{},
+		blobs: map[int][]byte{},
+	}
+
+	load := func(device int, path string) error {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+
+		// Sections are written without the unused space that precedes
+		// their first part; restore it.
+		off := offsets[device]
+		blob := bytes.Repeat([]byte{0xff}, off)
+		ins.blobs[device] = append(blob, data...)
+		ins.Sections = append(ins.Sections, InspectSection{
+			Device: device,
+			Offset: off,
+			Path:   path,
+		})
+		return nil
+	}
+
+	if err := load(0, section0Path); err != nil {
+		return nil, err
+	}
+	blob0 := ins.blobs[0]
+
+	metaOff, metaSize, err := findMeta(blob0)
+	if err != nil {
+		return nil, err
+	}
+	ins.MetaOffset = metaOff
+	ins.MetaVersion = int(blob0[metaOff])
+
+	hashOff := -1
+	tlvEnd := metaOff + metaSize - META_FOOTER_SZ
+	for off := metaOff + 4; off+2 <= tlvEnd; {
+		typ := blob0[off]
+		size := int(blob0[off+1])
+		data := blob0[off+2 : util.IntMin(off+2+size, tlvEnd)]
+
+		switch {
+		case typ == META_TLV_CODE_HASH && size == META_HASH_SZ:
+			hashOff = off + 2
+			ins.MfgHash = hex.EncodeToString(data)
+
+		case typ == META_TLV_CODE_FLASH_AREA &&
+			size == META_TLV_FLASH_AREA_SZ:
+
+			area := InspectArea{
+				Id:     int(data[0]),
+				Device: int(data[1]),
+				Offset: int(binary.LittleEndian.Uint32(data[4:8])),
+				Size:   int(binary.LittleEndian.Uint32(data[8:12])),
+			}
+			area.Name = names[area.Id]
+			if area.Name == "" {
+				area.Name = defaultAreaName(area.Id)
+			}
+			ins.Areas = append(ins.Areas, area)
+
+		default:
+			ins.OtherTlvs = append(ins.OtherTlvs, InspectTlv{
+				Name: tlvNames[off],
+				Type: int(typ),
+				Data: hex.EncodeToString(data),
+			})
+		}
+
+		off += 2 + size
+	}
+
+	// Load the other sections that the flash map refers to.
+	devices := []int{}
+	for _, area := range ins.Areas {
+		devices = append(devices, area.Device)
+	}
+	sort.Ints(devices)
+	prefix := strings.TrimSuffix(section0Path, "-s0.bin")
+	for i, device := range devices {
+		if device == 0 || (i > 0 && devices[i-1] == device) ||
+			prefix == section0Path {
+
+			continue
+		}
+		path := fmt.Sprintf("%s-s%d.bin", prefix, device)
+		if util.NodeExist(path) {
+			if err := load(device, path); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if hashOff < 0 {
+		ins.HashError = "meta region has no hash"
+	} else {
+		ins.verifyHash(hashOff)
+	}
+
+	for i, _ := range ins.Areas {
+		area := &ins.Areas[i]
+		blob := ins.blobs[area.Device]
+		if blob == nil || area.Offset >= len(blob) {
+			continue
+		}
+
+		end := util.IntMin(area.Offset+area.Size, len(blob))
+		data := blob[area.Offset:end]
+		if len(data) < 4 ||
+			binary.LittleEndian.Uint32(data) != image.IMAGE_MAGIC {
+
+			continue
+		}
+
+		area.Image, err = inspectImage(data, key)
+		if err != nil {
+			area.ImageError = err.Error()
+		}
+	}
+
+	return ins, nil
+}
+
+// Names a flash area whose name the BSP doesn't provide.
+func defaultAreaName(id int) string {
+	for name, sysId := range flash.SYSTEM_AREA_NAME_ID_MAP {
+		if sysId == id {
+			return name
+		}
+	}
+	return fmt.Sprintf("user_id %d", id-flash.AREA_USER_ID_MIN)
+}
+
+// Recomputes the mfg hash as newt mfg create does: over all sections in
+// order, with the hash itself zeroed.
+func (ins *MfgInspection) verifyHash(hashOff int) {
+	devices := make([]int, 0, len(ins.blobs))
+	for device, _ := range ins.blobs {
+		devices = append(devices, device)
+	}
+	sort.Ints(devices)
+
+	sections := make([][]byte, len(devices))
+	for i, device := range devices {
+		sections[i] = ins.blobs[device]
+		if device == 0 {
+			sections[i] = append([]byte{}, sections[i]...)
+			copy(sections[i][hashOff:hashOff+META_HASH_SZ],
+				make([]byte, META_HASH_SZ))
+		}
+	}
+
+	computed := hex.EncodeToString(calcMetaHash(sections))
+	if computed != ins.MfgHash {
+		ins.HashError = fmt.Sprintf("mfg hash mismatch: stored=%s "+
+			"computed=%s", ins.MfgHash, computed)
+		return
+	}
+	ins.HashValid = true
+}
+
+// Writes the contents of a flash area, identified by name or numeric ID, to
+// a file.
+func (ins *MfgInspection) ExtractArea(nameOrId string, dstPath string) error {
+	var area *InspectArea
+	for i, _ := range ins.Areas {
+		a := &ins.Areas[i]
+		if strings.EqualFold(a.Name, nameOrId) ||
+			fmt.Sprintf("%d", a.Id) == nameOrId {
+
+			area = a
+			break
+		}
+	}
+	if area == nil {
+		return util.FmtNewtError("No flash area \"%s\" in the mfg image",
+			nameOrId)
+	}
+
+	blob := ins.blobs[area.Device]
+	if blob == nil {
+		return util.FmtNewtError("Section %d, which contains flash area "+
+			"%s, is not available", area.Device, area.Name)
+	}
+
+	// Parts of the area beyond the end of the section were never written.
+	data := bytes.Repeat([]byte{0xff}, area.Size)
+	if area.Offset < len(blob) {
+		copy(data, blob[area.Offset:util.IntMin(area.Offset+area.Size,
+			len(blob))])
+	}
+
+	if err := ioutil.WriteFile(dstPath, data, 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
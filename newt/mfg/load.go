@@ -20,6 +20,7 @@
 package mfg
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"sort"
@@ -28,6 +29,7 @@ import (
 
 	"github.com/spf13/cast"
 
+	"mynewt.apache.org/newt/newt/flash"
 	"mynewt.apache.org/newt/newt/pkg"
 	"mynewt.apache.org/newt/newt/project"
 	"mynewt.apache.org/newt/newt/target"
@@ -80,6 +82,20 @@ func (mi *MfgImage) loadTarget(targetName string) (
 	return tgt, nil
 }
 
+// Resolves a flash area referenced by an mfg entry.
+func (mi *MfgImage) loadArea(what string, entryIdx int,
+	areaName string) (flash.FlashArea, error) {
+
+	area, ok := mi.bsp.FlashMap.Areas[areaName]
+	if !ok {
+		return area, mi.loadError(
+			"%s entry %d references undefined flash area \"%s\"",
+			what, entryIdx, areaName)
+	}
+
+	return area, nil
+}
+
 func (mi *MfgImage) loadRawEntry(
 	entryIdx int, rawEntry map[string]string) (MfgRawEntry, error) {
 
@@ -87,16 +103,66 @@ func (mi *MfgImage) loadRawEntry(
 
 	var err error
 
+	raw.filename = rawEntry["file"]
+	if raw.filename == "" {
+		return raw, mi.loadError(
+			"raw entry %d missing required \"file\" field", entryIdx)
+	}
+
+	if !strings.HasPrefix(raw.filename, "/") {
+		raw.filename = mi.basePkg.BasePath() + "/" + raw.filename
+	}
+
+	raw.data, err = ioutil.ReadFile(raw.filename)
+	if err != nil {
+		return raw, mi.loadError(
+			"error loading file for raw entry %d; filename=%s: %s",
+			entryIdx, raw.filename, err.Error())
+	}
+
+	// An entry is either placed in a flash area, at an optional offset
+	// within it, or at an offset of a flash device.
+	raw.area = rawEntry["area"]
+	if raw.area != "" {
+		area, err := mi.loadArea("raw", entryIdx, raw.area)
+		if err != nil {
+			return raw, err
+		}
+
+		offset := 0
+		if offsetStr := rawEntry["offset"]; offsetStr != "" {
+			offset, err = util.AtoiNoOct(offsetStr)
+			if err != nil {
+				return raw, mi.loadError(
+					"raw entry %d contains invalid offset: %s", entryIdx,
+					offsetStr)
+			}
+		}
+
+		if offset+len(raw.data) > area.Size {
+			return raw, mi.loadError(
+				"raw entry %d (%s) does not fit in flash area %s; "+
+					"offset=%d size=%d flash-area-size=%d",
+				entryIdx, raw.filename, raw.area, offset, len(raw.data),
+				area.Size)
+		}
+
+		raw.device = area.Device
+		raw.offset = area.Offset + offset
+		return raw, nil
+	}
+
 	deviceStr := rawEntry["device"]
 	if deviceStr == "" {
 		return raw, mi.loadError(
-			"raw entry %d missing required \"device\" field", entryIdx)
+			"raw entry %d missing required \"device\" or \"area\" field",
+			entryIdx)
 	}
 
 	raw.device, err = util.AtoiNoOct(deviceStr)
 	if err != nil {
 		return raw, mi.loadError(
-			"raw entry %d contains invalid offset: %s", entryIdx, deviceStr)
+			"raw entry %d contains invalid device: %s", entryIdx, deviceStr)
 	}
 
 	offsetStr := rawEntry["offset"]
@@ -111,24 +177,150 @@ func (mi *MfgImage) loadRawEntry(
 			"raw entry %d contains invalid offset: %s", entryIdx, offsetStr)
 	}
 
-	raw.filename = rawEntry["file"]
-	if raw.filename == "" {
-		return raw, mi.loadError(
-			"raw entry %d missing required \"file\" field", entryIdx)
+	return raw, nil
+}
+
+func (mi *MfgImage) loadTargetEntry(
+	entryIdx int, yamlEntry map[string]string) (MfgTargetEntry, error) {
+
+	entry := MfgTargetEntry{}
+
+	name := yamlEntry["name"]
+	if name == "" {
+		return entry, mi.loadError(
+			"target entry %d missing required \"name\" field", entryIdx)
 	}
 
-	if !strings.HasPrefix(raw.filename, "/") {
-		raw.filename = mi.basePkg.BasePath() + "/" + raw.filename
+	var err error
+	entry.target, err = mi.loadTarget(name)
+	if err != nil {
+		return entry, err
+	}
+	if entry.target.LoaderName != "" {
+		return entry, mi.loadError("target entry %d: split image targets "+
+			"are not supported (%s)", entryIdx, name)
 	}
 
-	raw.data, err = ioutil.ReadFile(raw.filename)
+	entry.area = yamlEntry["area"]
+	if entry.area == "" {
+		return entry, mi.loadError(
+			"target entry %d missing required \"area\" field", entryIdx)
+	}
+	area, err := mi.loadArea("target", entryIdx, entry.area)
 	if err != nil {
-		return raw, mi.loadError(
-			"error loading file for raw entry %d; filename=%s: %s",
-			entryIdx, raw.filename, err.Error())
+		return entry, err
+	}
+	entry.device = area.Device
+
+	switch yamlEntry["format"] {
+	case "", "img":
+	case "bin":
+		entry.bin = true
+	default:
+		return entry, mi.loadError("target entry %d has invalid format "+
+			"\"%s\"; must be img or bin", entryIdx, yamlEntry["format"])
 	}
 
-	return raw, nil
+	return entry, nil
+}
+
+// Parses a fill pattern: a sequence of byte values separated by spaces or
+// commas (e.g., "0x00" or "0xde 0xad 0xbe 0xef").
+func parseFillPattern(patternStr string) ([]byte, error) {
+	pattern := []byte{}
+	fields := strings.FieldsFunc(patternStr, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	for _, field := range fields {
+		b, err := util.AtoiNoOct(field)
+		if err != nil || b < 0 || b > 0xff {
+			return nil, util.FmtNewtError("invalid byte value: %s", field)
+		}
+		pattern = append(pattern, byte(b))
+	}
+
+	if len(pattern) == 0 {
+		return nil, util.NewNewtError("empty pattern")
+	}
+
+	return pattern, nil
+}
+
+func (mi *MfgImage) loadFill(
+	entryIdx int, yamlEntry map[string]string) (MfgFill, error) {
+
+	fill := MfgFill{}
+
+	fill.area = yamlEntry["area"]
+	if fill.area == "" {
+		return fill, mi.loadError(
+			"fill entry %d missing required \"area\" field", entryIdx)
+	}
+	area, err := mi.loadArea("fill", entryIdx, fill.area)
+	if err != nil {
+		return fill, err
+	}
+	fill.device = area.Device
+
+	fill.pattern, err = parseFillPattern(yamlEntry["pattern"])
+	if err != nil {
+		return fill, mi.loadError("fill entry %d: %s", entryIdx,
+			err.Error())
+	}
+
+	return fill, nil
+}
+
+// Parses a TLV declared in mfg.meta.tlvs.  The value is encoded as a
+// provisioning field of the same format would be.
+func (mi *MfgImage) loadMetaTlv(
+	entryIdx int, yamlEntry map[string]string) (MfgMetaTlv, error) {
+
+	tlv := MfgMetaTlv{
+		name: yamlEntry["name"],
+	}
+	if tlv.name == "" {
+		return tlv, mi.loadError(
+			"meta TLV %d missing required \"name\" field", entryIdx)
+	}
+
+	typ, err := util.AtoiNoOct(yamlEntry["type"])
+	if err != nil || typ < META_TLV_CODE_CUSTOM_MIN || typ > 0xff {
+		return tlv, mi.loadError("meta TLV %s has invalid type \"%s\"; "+
+			"must be between %d and 255", tlv.name, yamlEntry["type"],
+			META_TLV_CODE_CUSTOM_MIN)
+	}
+	tlv.typ = uint8(typ)
+
+	f := provField{
+		name:  tlv.name,
+		typ:   yamlEntry["format"],
+		value: yamlEntry["value"],
+	}
+	switch f.typ {
+	case PROV_FIELD_STRING:
+		f.size = len(f.value)
+	case PROV_FIELD_BYTES:
+		f.size = len(strings.NewReplacer(":", "", "-", "", " ", "").
+			Replace(strings.TrimPrefix(f.value, "0x"))) / 2
+	default:
+		if _, ok := provIntSizes[f.typ]; !ok {
+			return tlv, mi.loadError("meta TLV %s has invalid format "+
+				"\"%s\"", tlv.name, f.typ)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := encodeProvField(f, f.value, buf); err != nil {
+		return tlv, mi.loadError("meta TLV %s: %s", tlv.name, err.Error())
+	}
+	if buf.Len() > 0xff {
+		return tlv, mi.loadError("meta TLV %s too large (%d bytes; "+
+			"maximum is 255)", tlv.name, buf.Len())
+	}
+	tlv.data = buf.Bytes()
+
+	return tlv, nil
 }
 
 func (mi *MfgImage) detectInvalidDevices() error {
@@ -185,7 +377,17 @@ func (mi *MfgImage) detectOverlaps() error {
 	sort.Ints(devices)
 
 	for _, device := range devices {
-		parts := dpMap[device]
+		// Fills are overwritten by whatever else is placed in their area.
+		parts := []mfgPart{}
+		for _, part := range dpMap[device] {
+			if part.kind != MFG_PART_FILL {
+				parts = append(parts, part)
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+
 		for i, part0 := range parts[:len(parts)-1] {
 			part0End := part0.offset + len(part0.data)
 			for _, part1 := range parts[i+1:] {
@@ -256,20 +458,6 @@ func Load(basePkg *pkg.LocalPackage) (*MfgImage, error) {
 			len(mi.images))
 	}
 
-	itf := v.Get("mfg.raw")
-	slice := cast.ToSlice(itf)
-	if slice != nil {
-		for i, entryItf := range slice {
-			yamlEntry := cast.ToStringMapString(entryItf)
-			entry, err := mi.loadRawEntry(i, yamlEntry)
-			if err != nil {
-				return nil, err
-			}
-
-			mi.rawEntries = append(mi.rawEntries, entry)
-		}
-	}
-
 	proj := project.GetProject()
 
 	bspLpkg, err := proj.ResolvePackage(mi.basePkg.Repo(),
@@ -308,6 +496,55 @@ func Load(basePkg *pkg.LocalPackage) (*MfgImage, error) {
 		}
 	}
 
+	for i, entryItf := range cast.ToSlice(v.Get("mfg.targets")) {
+		yamlEntry := cast.ToStringMapString(entryItf)
+		entry, err := mi.loadTargetEntry(i, yamlEntry)
+		if err != nil {
+			return nil, err
+		}
+
+		mi.targets = append(mi.targets, entry)
+	}
+
+	itf := v.Get("mfg.raw")
+	slice := cast.ToSlice(itf)
+	if slice != nil {
+		for i, entryItf := range slice {
+			yamlEntry := cast.ToStringMapString(entryItf)
+			entry, err := mi.loadRawEntry(i, yamlEntry)
+			if err != nil {
+				return nil, err
+			}
+
+			mi.rawEntries = append(mi.rawEntries, entry)
+		}
+	}
+
+	for i, entryItf := range cast.ToSlice(v.Get("mfg.fill")) {
+		yamlEntry := cast.ToStringMapString(entryItf)
+		fill, err := mi.loadFill(i, yamlEntry)
+		if err != nil {
+			return nil, err
+		}
+
+		mi.fills = append(mi.fills, fill)
+	}
+
+	yamlMeta := cast.ToStringMap(v.Get("mfg.meta"))
+	for i, tlvItf := range cast.ToSlice(yamlMeta["tlvs"]) {
+		tlv, err := mi.loadMetaTlv(i, cast.ToStringMapString(tlvItf))
+		if err != nil {
+			return nil, err
+		}
+
+		mi.metaTlvs = append(mi.metaTlvs, tlv)
+	}
+
+	mi.provision, err = mi.loadProvision(v.Get("mfg.provision"))
+	if err != nil {
+		return nil, err
+	}
+
 	if err := mi.detectInvalidDevices(); err != nil {
 		return nil, err
 	}
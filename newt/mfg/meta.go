@@ -50,7 +50,9 @@ import (
 // +-+-+-+-+-+--+-+-+-+-end of boot loader area+-+-+-+-+-+-+-+-+-+-+
 //
 // The number of TLVs is variable; two are shown above for illustrative
-// purposes.
+// purposes.  newt writes a flash area TLV for each area in the BSP's flash
+// map, then the custom TLVs declared in mfg.meta.tlvs, and finally the hash
+// TLV.
 //
 // Fields:
 // <Header>
@@ -71,6 +73,9 @@ const META_VERSION = 1
 const META_TLV_CODE_HASH = 0x01
 const META_TLV_CODE_FLASH_AREA = 0x02
 
+// Custom TLVs may not use the types newt writes itself.
+const META_TLV_CODE_CUSTOM_MIN = 0x03
+
 const META_HASH_SZ = 32
 const META_FOOTER_SZ = 8
 const META_TLV_HASH_SZ = META_HASH_SZ
@@ -107,6 +112,25 @@ type metaTlvHash struct {
 	hash   [META_HASH_SZ]byte
 }
 
+// Describes where a TLV ended up, so that tools can parse the region without
+// knowing how it was declared.
+type metaTlvLayout struct {
+	Name string `json:"name"`
+	Type int    `json:"type"`
+
+	// Offset of the TLV header within flash device 0.
+	Offset int `json:"offset"`
+
+	// Size of the TLV data, excluding the header.
+	Size int `json:"size"`
+}
+
+type metaLayout struct {
+	Offset int             `json:"offset"`
+	Size   int             `json:"size"`
+	Tlvs   []metaTlvLayout `json:"tlvs"`
+}
+
 func writeElem(elem interface{}, buf *bytes.Buffer) error {
 	/* XXX: Assume target platform uses little endian. */
 	if err := binary.Write(buf, binary.LittleEndian, elem); err != nil {
@@ -171,40 +195,81 @@ func writeZeroHash(buf *bytes.Buffer) error {
 	return writeElem(tlv, buf)
 }
 
-// @return						meta-offset, hash-offset, error
-func insertMeta(section0Data []byte, flashMap flash.FlashMap) (
-	int, int, error) {
+// Writes a TLV declared in mfg.meta.tlvs.
+func writeCustomTlv(tlv MfgMetaTlv, buf *bytes.Buffer) error {
+	if err := writeTlvHeader(tlv.typ, uint8(len(tlv.data)), buf); err != nil {
+		return err
+	}
+	buf.Write(tlv.data)
+	return nil
+}
 
+// @return						meta-layout, hash-offset, error
+func insertMeta(section0Data []byte, flashMap flash.FlashMap,
+	customTlvs []MfgMetaTlv) (metaLayout, int, error) {
+
+	layout := metaLayout{}
 	buf := &bytes.Buffer{}
 
+	addTlv := func(name string, typ uint8, write func() error) error {
+		off := buf.Len()
+		if err := write(); err != nil {
+			return err
+		}
+		layout.Tlvs = append(layout.Tlvs, metaTlvLayout{
+			Name:   name,
+			Type:   int(typ),
+			Offset: off,
+			Size:   buf.Len() - off - 2,
+		})
+		return nil
+	}
+
 	if err := writeHeader(buf); err != nil {
-		return 0, 0, err
+		return layout, 0, err
 	}
 
 	for _, area := range flashMap.SortedAreas() {
-		if err := writeFlashMapEntry(area, buf); err != nil {
-			return 0, 0, err
+		area := area
+		err := addTlv(area.Name, META_TLV_CODE_FLASH_AREA, func() error {
+			return writeFlashMapEntry(area, buf)
+		})
+		if err != nil {
+			return layout, 0, err
 		}
 	}
 
-	if err := writeZeroHash(buf); err != nil {
-		return 0, 0, err
+	for _, tlv := range customTlvs {
+		tlv := tlv
+		err := addTlv(tlv.name, tlv.typ, func() error {
+			return writeCustomTlv(tlv, buf)
+		})
+		if err != nil {
+			return layout, 0, err
+		}
+	}
+
+	err := addTlv("hash", META_TLV_CODE_HASH, func() error {
+		return writeZeroHash(buf)
+	})
+	if err != nil {
+		return layout, 0, err
 	}
 	hashSubOff := buf.Len() - META_HASH_SZ
 
 	if err := writeFooter(buf); err != nil {
-		return 0, 0, err
+		return layout, 0, err
 	}
 
 	// The meta region gets placed at the very end of the boot loader slot.
 	bootArea, ok := flashMap.Areas[flash.FLASH_AREA_NAME_BOOTLOADER]
 	if !ok {
-		return 0, 0,
+		return layout, 0,
 			util.NewNewtError("Required boot loader flash area missing")
 	}
 
 	if bootArea.Size < buf.Len() {
-		return 0, 0, util.FmtNewtError(
+		return layout, 0, util.FmtNewtError(
 			"Boot loader flash area too small to accommodate meta region; "+
 				"boot=%d meta=%d", bootArea.Size, buf.Len())
 	}
@@ -212,7 +277,7 @@ func insertMeta(section0Data []byte, flashMap flash.FlashMap) (
 	metaOff := bootArea.Offset + bootArea.Size - buf.Len()
 	for i := metaOff; i < bootArea.Size; i++ {
 		if section0Data[i] != 0xff {
-			return 0, 0, util.FmtNewtError(
+			return layout, 0, util.FmtNewtError(
 				"Boot loader extends into meta region; "+
 					"meta region starts at offset %d", metaOff)
 		}
@@ -222,7 +287,13 @@ func insertMeta(section0Data []byte, flashMap flash.FlashMap) (
 	// still zeroed.
 	copy(section0Data[metaOff:], buf.Bytes())
 
-	return metaOff, metaOff + hashSubOff, nil
+	layout.Offset = metaOff
+	layout.Size = buf.Len()
+	for i, _ := range layout.Tlvs {
+		layout.Tlvs[i].Offset += metaOff
+	}
+
+	return layout, metaOff + hashSubOff, nil
 }
 
 // Calculates the SHA256 hash, using the full manufacturing image as input.
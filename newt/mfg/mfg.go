@@ -33,15 +33,64 @@ type MfgRawEntry struct {
 	offset   int
 	filename string
 	data     []byte
+
+	// Flash area the entry is placed in; empty if it was specified by
+	// device and offset.
+	area string
+}
+
+// A built target placed in an arbitrary flash area (e.g., the image of a
+// second core).
+type MfgTargetEntry struct {
+	target *target.Target
+	area   string
+	device int
+
+	// Whether to place the raw binary (.elf.bin) rather than the image.
+	bin bool
 }
 
-// A chunk of data in the manufacturing image.  Can be a firmware image or a
-// raw entry (contents of a data file).
+// A flash area prefilled with a repeating byte pattern.
+type MfgFill struct {
+	area    string
+	device  int
+	pattern []byte
+}
+
+// A TLV declared in mfg.meta.tlvs, added to the meta region.
+type MfgMetaTlv struct {
+	name string
+	typ  uint8
+	data []byte
+}
+
+const (
+	MFG_PART_BOOT   = "boot"
+	MFG_PART_IMAGE  = "image"
+	MFG_PART_TARGET = "target"
+	MFG_PART_RAW    = "raw"
+	MFG_PART_FILL   = "fill"
+
+	MFG_PART_PROVISION = "provision"
+)
+
+// A chunk of data in the manufacturing image.  Can be a firmware image, a
+// raw entry (contents of a data file), or a flash area fill.
 type mfgPart struct {
 	device int
 	offset int
 	data   []byte
 	name   string
+
+	// One of the MFG_PART_[...] constants.
+	kind string
+
+	// Flash area containing the part; empty for raw entries specified by
+	// offset.
+	area string
+
+	// Base name of the part's output files.
+	fileName string
 }
 
 type MfgImage struct {
@@ -52,7 +101,11 @@ type MfgImage struct {
 
 	boot       *target.Target
 	images     []*target.Target
+	targets    []MfgTargetEntry
 	rawEntries []MfgRawEntry
+	fills      []MfgFill
+	provision  *MfgProvision
+	metaTlvs   []MfgMetaTlv
 
 	version image.ImageVersion
 }
@@ -83,6 +136,15 @@ func (mi *MfgImage) sectionIds() []int {
 	for _, entry := range mi.rawEntries {
 		idMap[entry.device] = struct{}{}
 	}
+	for _, entry := range mi.targets {
+		idMap[entry.device] = struct{}{}
+	}
+	for _, fill := range mi.fills {
+		idMap[fill.device] = struct{}{}
+	}
+	if mi.provision != nil {
+		idMap[mi.provision.device] = struct{}{}
+	}
 
 	ids := make([]int, 0, len(idMap))
 	for id, _ := range idMap {
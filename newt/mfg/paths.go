@@ -69,6 +69,50 @@ func MfgImageManifestPath(mfgPkgName string, imageIdx int) string {
 	return MfgImageBinDir(mfgPkgName, imageIdx) + "/manifest.json"
 }
 
+// Directory holding the build artifacts of an mfg.targets entry.
+func MfgTargetBinDir(mfgPkgName string, entryIdx int) string {
+	return MfgBinDir(mfgPkgName) + "/target" + strconv.Itoa(entryIdx)
+}
+
+func MfgPartsDir(mfgPkgName string) string {
+	return MfgBinDir(mfgPkgName) + "/parts"
+}
+
+func MfgPartBinPath(mfgPkgName string, partName string) string {
+	return MfgPartsDir(mfgPkgName) + "/" + partName + ".bin"
+}
+
+func MfgPartHexPath(mfgPkgName string, partName string) string {
+	return MfgPartsDir(mfgPkgName) + "/" + partName + ".hex"
+}
+
+func MfgDevicesDir(mfgPkgName string) string {
+	return MfgBinDir(mfgPkgName) + "/devices"
+}
+
+// Directory holding the provisioned image of a single device.
+func MfgDeviceDir(mfgPkgName string, deviceId string) string {
+	return MfgDevicesDir(mfgPkgName) + "/" + deviceId
+}
+
+func MfgDeviceSectionBinPath(mfgPkgName string, deviceId string,
+	sectionNum int) string {
+
+	return fmt.Sprintf("%s/%s-s%d.bin", MfgDeviceDir(mfgPkgName, deviceId),
+		filepath.Base(mfgPkgName), sectionNum)
+}
+
+func MfgDeviceSectionHexPath(mfgPkgName string, deviceId string,
+	sectionNum int) string {
+
+	return fmt.Sprintf("%s/%s-s%d.hex", MfgDeviceDir(mfgPkgName, deviceId),
+		filepath.Base(mfgPkgName), sectionNum)
+}
+
+func MfgProvisionReportPath(mfgPkgName string) string {
+	return MfgDevicesDir(mfgPkgName) + "/report.json"
+}
+
 func MfgSectionBinDir(mfgPkgName string) string {
 	return MfgBinDir(mfgPkgName) + "/sections"
 }
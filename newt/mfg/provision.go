@@ -0,0 +1,582 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package mfg
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/image"
+	"mynewt.apache.org/newt/util"
+)
+
+// Provisioning block field types.  Integers are little endian.
+const (
+	PROV_FIELD_U8     = "u8"
+	PROV_FIELD_U16    = "u16"
+	PROV_FIELD_U32    = "u32"
+	PROV_FIELD_U64    = "u64"
+	PROV_FIELD_STRING = "string"
+	PROV_FIELD_BYTES  = "bytes"
+	PROV_FIELD_CRC32  = "crc32"
+)
+
+var provIntSizes = map[string]int{
+	PROV_FIELD_U8:  1,
+	PROV_FIELD_U16: 2,
+	PROV_FIELD_U32: 4,
+	PROV_FIELD_U64: 8,
+}
+
+// A field of the per-device provisioning block.  Its value is either a
+// constant or comes from a column of the device's record.
+type provField struct {
+	name   string
+	typ    string
+	size   int
+	value  string
+	column string
+
+	// Store bytes in reverse order (e.g., BLE addresses, which are written
+	// most significant byte first but stored little endian).
+	reverse bool
+
+	// Keep the value out of the provisioning report (e.g., keys).
+	secret bool
+}
+
+// Generates a data block for each device of a production run and splices
+// it into a copy of the mfg image.
+type MfgProvision struct {
+	area   string
+	device int
+	offset int
+	fields []provField
+	size   int
+
+	// Device records read from the CSV file; column name to value.
+	records  []map[string]string
+	idColumn string
+
+	// Command run once per device whose KEY=VALUE output lines add to the
+	// device's record (e.g., keys generated by an HSM).
+	hsmCmd []string
+
+	signKey string
+}
+
+type provReportDevice struct {
+	Id        string            `json:"id"`
+	Fields    map[string]string `json:"fields"`
+	BlockHash string            `json:"block_sha256"`
+	MfgHash   string            `json:"mfg_hash"`
+	Files     []string          `json:"files"`
+}
+
+type provReport struct {
+	Mfg       string             `json:"mfg"`
+	Version   string             `json:"version"`
+	BuildTime string             `json:"build_time"`
+	Area      string             `json:"area"`
+	Device    int                `json:"device"`
+	Offset    int                `json:"offset"`
+	Address   int                `json:"address"`
+	Size      int                `json:"size"`
+	Devices   []provReportDevice `json:"devices"`
+}
+
+func (mi *MfgImage) loadProvField(idx int,
+	yamlField map[string]string) (provField, error) {
+
+	f := provField{
+		name:    yamlField["name"],
+		typ:     yamlField["type"],
+		value:   yamlField["value"],
+		column:  yamlField["column"],
+		reverse: cast.ToBool(yamlField["reverse"]),
+		secret:  cast.ToBool(yamlField["secret"]),
+	}
+	if f.name == "" {
+		return f, mi.loadError(
+			"provisioning field %d missing required \"name\" field", idx)
+	}
+
+	if size, ok := provIntSizes[f.typ]; ok {
+		f.size = size
+	} else {
+		switch f.typ {
+		case PROV_FIELD_CRC32:
+			f.size = 4
+			return f, nil
+
+		case PROV_FIELD_STRING, PROV_FIELD_BYTES:
+			size, err := util.AtoiNoOct(yamlField["size"])
+			if err != nil || size <= 0 {
+				return f, mi.loadError("provisioning field %s requires a "+
+					"positive \"size\"", f.name)
+			}
+			f.size = size
+
+		default:
+			return f, mi.loadError("provisioning field %s has invalid "+
+				"type \"%s\"", f.name, f.typ)
+		}
+	}
+
+	if (f.value == "") == (f.column == "") {
+		return f, mi.loadError("provisioning field %s requires exactly "+
+			"one of \"value\" and \"column\"", f.name)
+	}
+
+	return f, nil
+}
+
+func (mi *MfgImage) loadProvRecords(csvPath string) (
+	[]map[string]string, []string, error) {
+
+	if !filepath.IsAbs(csvPath) {
+		csvPath = mi.basePkg.BasePath() + "/" + csvPath
+	}
+
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return nil, nil, mi.loadError("error reading device records: %s",
+			err.Error())
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, nil, mi.loadError("error parsing %s: %s", csvPath,
+			err.Error())
+	}
+	if len(rows) < 2 {
+		return nil, nil, mi.loadError("%s contains no device records",
+			csvPath)
+	}
+
+	header := rows[0]
+	for i, _ := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	records := []map[string]string{}
+	for _, row := range rows[1:] {
+		rec := map[string]string{}
+		for i, val := range row {
+			rec[header[i]] = strings.TrimSpace(val)
+		}
+		records = append(records, rec)
+	}
+
+	return records, header, nil
+}
+
+func (mi *MfgImage) loadProvision(itf interface{}) (*MfgProvision, error) {
+	yamlProv := cast.ToStringMap(itf)
+	if len(yamlProv) == 0 {
+		return nil, nil
+	}
+
+	p := &MfgProvision{
+		area:    cast.ToString(yamlProv["area"]),
+		hsmCmd:  strings.Fields(cast.ToString(yamlProv["hsm_cmd"])),
+		signKey: cast.ToString(yamlProv["sign_key"]),
+	}
+
+	if p.area == "" {
+		return nil, mi.loadError(
+			"mfg.provision missing required \"area\" field")
+	}
+	area, err := mi.loadArea("provision", 0, p.area)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := 0
+	if offsetStr := cast.ToString(yamlProv["offset"]); offsetStr != "" {
+		offset, err = util.AtoiNoOct(offsetStr)
+		if err != nil {
+			return nil, mi.loadError(
+				"mfg.provision contains invalid offset: %s", offsetStr)
+		}
+	}
+	p.device = area.Device
+	p.offset = area.Offset + offset
+
+	for i, fieldItf := range cast.ToSlice(yamlProv["fields"]) {
+		f, err := mi.loadProvField(i, cast.ToStringMapString(fieldItf))
+		if err != nil {
+			return nil, err
+		}
+		p.fields = append(p.fields, f)
+		p.size += f.size
+	}
+	if len(p.fields) == 0 {
+		return nil, mi.loadError("mfg.provision defines no fields")
+	}
+	if offset+p.size > area.Size {
+		return nil, mi.loadError("provisioning block (%d bytes at offset "+
+			"%d) does not fit in flash area %s (%d bytes)", p.size, offset,
+			p.area, area.Size)
+	}
+
+	csvPath := cast.ToString(yamlProv["csv"])
+	if csvPath == "" {
+		return nil, mi.loadError(
+			"mfg.provision missing required \"csv\" field")
+	}
+	var header []string
+	p.records, header, err = mi.loadProvRecords(csvPath)
+	if err != nil {
+		return nil, err
+	}
+
+	p.idColumn = cast.ToString(yamlProv["id_column"])
+	if p.idColumn == "" {
+		p.idColumn = header[0]
+	}
+	if p.signKey != "" && !filepath.IsAbs(p.signKey) {
+		p.signKey = mi.basePkg.BasePath() + "/" + p.signKey
+	}
+
+	return p, nil
+}
+
+// Runs the HSM command for a device and returns the device's record extended
+// with the command's output.  The record's columns are passed to the command
+// as PROV_<COLUMN> environment variables.
+func (p *MfgProvision) completeRecord(rec map[string]string) (
+	map[string]string, error) {
+
+	full := map[string]string{}
+	for k, v := range rec {
+		full[k] = v
+	}
+	if len(p.hsmCmd) == 0 {
+		return full, nil
+	}
+
+	env := []string{}
+	for k, v := range rec {
+		env = append(env, "PROV_"+strings.ToUpper(k)+"="+v)
+	}
+	sort.Strings(env)
+
+	out, err := util.ShellCommand(p.hsmCmd, env)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) == 2 {
+			full[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	return full, nil
+}
+
+// Parses an integer field value: decimal (leading zeros allowed, as in
+// serial numbers) or 0x-prefixed hex.
+func parseProvInt(s string) (uint64, error) {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return strconv.ParseUint(s[2:], 16, 64)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func encodeProvField(f provField, val string,
+	buf *bytes.Buffer) error {
+
+	if size, ok := provIntSizes[f.typ]; ok {
+		n, err := parseProvInt(val)
+		if err != nil || (size < 8 && n >= 1<<uint(size*8)) {
+			return util.FmtNewtError("invalid %s value: %s", f.typ, val)
+		}
+
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, n)
+		buf.Write(b[:size])
+		return nil
+	}
+
+	switch f.typ {
+	case PROV_FIELD_STRING:
+		if len(val) > f.size {
+			return util.FmtNewtError("string too long (%d > %d bytes)",
+				len(val), f.size)
+		}
+		b := make([]byte, f.size)
+		copy(b, val)
+		buf.Write(b)
+
+	case PROV_FIELD_BYTES:
+		stripped := strings.NewReplacer(":", "", "-", "", " ", "").
+			Replace(val)
+		b, err := hex.DecodeString(strings.TrimPrefix(stripped, "0x"))
+		if err != nil {
+			return util.FmtNewtError("invalid hex value: %s", val)
+		}
+		if len(b) != f.size {
+			return util.FmtNewtError("value has %d bytes; expected %d",
+				len(b), f.size)
+		}
+		if f.reverse {
+			for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+				b[i], b[j] = b[j], b[i]
+			}
+		}
+		buf.Write(b)
+
+	case PROV_FIELD_CRC32:
+		binary.Write(buf, binary.LittleEndian,
+			crc32.ChecksumIEEE(buf.Bytes()))
+	}
+
+	return nil
+}
+
+// Builds a device's provisioning block.  Also returns the values of the
+// block's non-secret fields, for the report.
+func (p *MfgProvision) encode(rec map[string]string) ([]byte,
+	map[string]string, error) {
+
+	buf := new(bytes.Buffer)
+	public := map[string]string{}
+
+	for _, f := range p.fields {
+		val := f.value
+		if f.column != "" {
+			var ok bool
+			val, ok = rec[f.column]
+			if !ok {
+				return nil, nil, util.FmtNewtError(
+					"no value for column \"%s\" (field %s)", f.column,
+					f.name)
+			}
+		}
+
+		if err := encodeProvField(f, val, buf); err != nil {
+			return nil, nil, util.FmtNewtError("field %s: %s", f.name,
+				err.Error())
+		}
+
+		if !f.secret && f.typ != PROV_FIELD_CRC32 {
+			public[f.name] = val
+		}
+	}
+
+	return buf.Bytes(), public, nil
+}
+
+// Reserves the provisioning block's location in the generic image; it
+// stays unwritten (0xff) there.
+func (mi *MfgImage) provisionPart() mfgPart {
+	p := mi.provision
+
+	data := make([]byte, p.size)
+	for i, _ := range data {
+		data[i] = 0xff
+	}
+
+	return mfgPart{
+		name:     fmt.Sprintf("%s (provisioning block)", p.area),
+		device:   p.device,
+		offset:   p.offset,
+		data:     data,
+		kind:     MFG_PART_PROVISION,
+		area:     p.area,
+		fileName: MFG_PART_PROVISION,
+	}
+}
+
+var provIdRe = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+func signReport(keyPath string, data []byte) ([]byte, error) {
+	rsaKey, ecKey, err := image.ReadSigningKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(data)
+	if rsaKey != nil {
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256,
+			hash[:])
+		if err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+		return sig, nil
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, hash[:])
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	sig, err := asn1.Marshal(image.ECDSASig{R: r, S: s})
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	return sig, nil
+}
+
+// Writes a provisioned copy of each section for every device, and a report
+// listing each device's public field values and image hashes.  The report
+// is signed with the provisioning key, if one is configured.
+func (mi *MfgImage) createProvisioned(cs createState) ([]string, error) {
+	p := mi.provision
+	pkgName := mi.basePkg.Name()
+
+	devices := make([]int, 0, len(cs.dsMap))
+	for device, _ := range cs.dsMap {
+		devices = append(devices, device)
+	}
+	sort.Ints(devices)
+
+	report := provReport{
+		Mfg:       pkgName,
+		Version:   mi.version.String(),
+		BuildTime: time.Now().Format(time.RFC3339),
+		Area:      p.area,
+		Device:    p.device,
+		Offset:    p.offset,
+		Address:   mi.bsp.FlashMap.DeviceAddress(p.device, p.offset),
+		Size:      p.size,
+	}
+
+	paths := []string{}
+	ids := map[string]bool{}
+	for i, rec := range p.records {
+		id := provIdRe.ReplaceAllString(rec[p.idColumn], "_")
+		if id == "" {
+			return nil, util.FmtNewtError("device record %d has no %s",
+				i+1, p.idColumn)
+		}
+		if ids[id] {
+			return nil, util.FmtNewtError("duplicate device id: %s", id)
+		}
+		ids[id] = true
+
+		full, err := p.completeRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+		block, public, err := p.encode(full)
+		if err != nil {
+			return nil, util.FmtNewtError("device %s: %s", id, err.Error())
+		}
+
+		// Splice the block into copies of the sections and recompute the
+		// mfg hash over them.
+		blobs := make([][]byte, len(devices))
+		for j, device := range devices {
+			blobs[j] = append([]byte{}, cs.dsMap[device].blob...)
+			if device == p.device {
+				copy(blobs[j][p.offset:], block)
+			}
+		}
+		hashDst := blobs[0][cs.hashOffset : cs.hashOffset+META_HASH_SZ]
+		copy(hashDst, make([]byte, META_HASH_SZ))
+		hash := calcMetaHash(blobs)
+		copy(hashDst, hash)
+
+		if err := os.MkdirAll(MfgDeviceDir(pkgName, id), 0755); err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+
+		blockHash := sha256.Sum256(block)
+		entry := provReportDevice{
+			Id:        id,
+			Fields:    public,
+			BlockHash: hex.EncodeToString(blockHash[:]),
+			MfgHash:   hex.EncodeToString(hash),
+		}
+		for j, device := range devices {
+			offset := cs.dsMap[device].offset
+			binPath := MfgDeviceSectionBinPath(pkgName, id, device)
+			hexPath := MfgDeviceSectionHexPath(pkgName, id, device)
+
+			err := ioutil.WriteFile(binPath, blobs[j][offset:], 0644)
+			if err != nil {
+				return nil, util.ChildNewtError(err)
+			}
+			if err := mi.compiler.ConvertBinToHex(binPath, hexPath,
+				mi.bsp.FlashMap.DeviceAddress(device, offset)); err != nil {
+
+				return nil, err
+			}
+
+			paths = append(paths, binPath, hexPath)
+			entry.Files = append(entry.Files,
+				mi.manifestRelPath(binPath), mi.manifestRelPath(hexPath))
+		}
+
+		report.Devices = append(report.Devices, entry)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	reportPath := MfgProvisionReportPath(pkgName)
+	if err := ioutil.WriteFile(reportPath, data, 0644); err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	paths = append(paths, reportPath)
+
+	if p.signKey == "" {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"* Warning: provisioning report is unsigned; set "+
+				"mfg.provision.sign_key to sign it\n")
+		return paths, nil
+	}
+
+	sig, err := signReport(p.signKey, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(reportPath+".sig", sig, 0644); err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	paths = append(paths, reportPath+".sig")
+
+	return paths, nil
+}
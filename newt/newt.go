@@ -22,13 +22,17 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/spf13/cobra"
 
 	"mynewt.apache.org/newt/newt/cli"
 	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/project"
 	"mynewt.apache.org/newt/util"
 )
 
@@ -39,8 +43,68 @@ var newtVerbose bool
 var newtLogFile string
 var newtNumJobs int
 var newtHelp bool
+var newtOffline bool
+var newtEventLog string
+var newtDebugLogFile string
+
+// Per-subsystem verbosity, as <subsystem>=<verbosity>.
+var newtSubsysVerbosity []string
+
+var newtSettingsVerbosity = map[string]int{
+	"silent":  util.VERBOSITY_SILENT,
+	"quiet":   util.VERBOSITY_QUIET,
+	"default": util.VERBOSITY_DEFAULT,
+	"verbose": util.VERBOSITY_VERBOSE,
+}
+
+// Fills in util.SubsysVerbosity from the verbosity_<subsystem> settings and
+// then the --verbosity flags, which take precedence.  A subsystem left
+// unconfigured follows -q/-s/-v like the rest of newt's output.
+func applySubsysVerbosity() error {
+	for _, subsys := range util.Subsystems {
+		name := newtutil.NewtSettings.String("verbosity_" + subsys)
+		if verbosity, ok := newtSettingsVerbosity[name]; ok {
+			util.SubsysVerbosity[subsys] = verbosity
+		}
+	}
+
+	for _, arg := range newtSubsysVerbosity {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return util.FmtNewtError("Invalid verbosity \"%s\"; must be "+
+				"<subsystem>=<verbosity>", arg)
+		}
+
+		subsys := strings.TrimSpace(parts[0])
+		known := false
+		for _, s := range util.Subsystems {
+			if s == subsys {
+				known = true
+			}
+		}
+		if !known {
+			return util.FmtNewtError("Unknown subsystem \"%s\"; must be "+
+				"one of: %s", subsys, strings.Join(util.Subsystems, ", "))
+		}
+
+		verbosity, ok := newtSettingsVerbosity[strings.TrimSpace(parts[1])]
+		if !ok {
+			return util.FmtNewtError("Invalid verbosity \"%s\" for %s; "+
+				"must be silent, quiet, default, or verbose", parts[1],
+				subsys)
+		}
+		util.SubsysVerbosity[subsys] = verbosity
+	}
+
+	return nil
+}
 
 func newtDfltNumJobs() int {
+	jobs, err := strconv.Atoi(newtutil.NewtSettings.String("jobs"))
+	if err == nil {
+		return jobs
+	}
+
 	maxProcs := runtime.GOMAXPROCS(0)
 	numCpu := runtime.NumCPU()
 
@@ -54,6 +118,24 @@ func newtDfltNumJobs() int {
 	return numJobs
 }
 
+// Applies the user settings that aren't simply flag defaults.
+func applySettings() {
+	settings := newtutil.NewtSettings
+
+	if dirs := settings.String("toolchain_path"); dirs != "" {
+		os.Setenv("PATH", os.ExpandEnv(dirs)+string(os.PathListSeparator)+
+			os.Getenv("PATH"))
+	}
+
+	switch settings.String("color") {
+	case "always":
+		newtutil.NewtColor = true
+	case "auto":
+		info, err := os.Stderr.Stat()
+		newtutil.NewtColor = err == nil && info.Mode()&os.ModeCharDevice != 0
+	}
+}
+
 func newtCmd() *cobra.Command {
 	newtHelpText := cli.FormatHelp(`Newt allows you to create your own embedded 
 		application based on the Mynewt operating system.  Newt provides both 
@@ -65,6 +147,11 @@ func newtCmd() *cobra.Command {
 	newtHelpText += "\n\n" + cli.FormatHelp(`Please use the newt help command, 
 		and specify the name of the command you want help for, for help on 
 		how to use a specific command`)
+	newtHelpText += "\n\n" + cli.FormatHelp(`Newt exits with a status that
+		identifies the class of failure: 1 (general), 3 (resolve), 4
+		(compile), 5 (link), 6 (image), 7 (tool-missing), 8 (network).
+		With --json-errors, the error is written to stderr as a JSON
+		object with the class, exit_code and message.`)
 	newtHelpEx := "  newt\n"
 	newtHelpEx += "  newt help [<command-name>]\n"
 	newtHelpEx += "    For help on <command-name>.  If not specified, " +
@@ -77,7 +164,11 @@ func newtCmd() *cobra.Command {
 		Long:    newtHelpText,
 		Example: newtHelpEx,
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
-			verbosity := util.VERBOSITY_DEFAULT
+			dfltVerbosity := newtutil.NewtSettings.String("verbosity")
+			verbosity, ok := newtSettingsVerbosity[dfltVerbosity]
+			if !ok {
+				verbosity = util.VERBOSITY_DEFAULT
+			}
 			if newtSilent {
 				verbosity = util.VERBOSITY_SILENT
 			} else if newtQuiet {
@@ -96,8 +187,26 @@ func newtCmd() *cobra.Command {
 			if err != nil {
 				cli.NewtUsage(nil, err)
 			}
+			if newtDebugLogFile != "" {
+				if err := util.InitDebugLog(newtDebugLogFile); err != nil {
+					cli.NewtUsage(nil, err)
+				}
+			}
+			if err := applySubsysVerbosity(); err != nil {
+				cli.NewtUsage(nil, err)
+			}
 
 			newtutil.NewtNumJobs = newtNumJobs
+			applySettings()
+			if newtOffline {
+				newtutil.NewtOffline = true
+			}
+
+			if newtEventLog != "" {
+				if err := newtutil.OpenEventLog(newtEventLog); err != nil {
+					cli.NewtUsage(nil, err)
+				}
+			}
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 			cmd.Help()
@@ -114,10 +223,38 @@ func newtCmd() *cobra.Command {
 		"WARN", "Log level")
 	newtCmd.PersistentFlags().StringVarP(&newtLogFile, "outfile", "o",
 		"", "Filename to tee output to")
+	newtCmd.PersistentFlags().StringVarP(&newtDebugLogFile, "log-file", "",
+		"", "Write all output, including DEBUG-level logs, to this file "+
+			"regardless of verbosity")
+	newtCmd.PersistentFlags().StringSliceVarP(&newtSubsysVerbosity,
+		"verbosity", "", nil,
+		"Verbosity of one subsystem ("+strings.Join(util.Subsystems, ", ")+
+			"), as <subsystem>=silent|quiet|default|verbose")
 	newtCmd.PersistentFlags().IntVarP(&newtNumJobs, "jobs", "j",
 		newtDfltNumJobs(), "Number of concurrent build jobs")
 	newtCmd.PersistentFlags().BoolVarP(&newtHelp, "help", "h",
 		false, "Help for newt commands")
+	newtCmd.PersistentFlags().BoolVarP(&newtOffline, "offline", "",
+		false, "Forbid network access; only use repos already downloaded")
+	newtCmd.PersistentFlags().BoolVarP(&newtutil.NewtJson, "json", "",
+		false, "Print informational output in JSON format")
+	newtCmd.PersistentFlags().BoolVarP(&newtutil.NewtJsonErrors,
+		"json-errors", "", false,
+		"Report errors on stderr as JSON objects with a failure class")
+	newtCmd.PersistentFlags().StringVarP(&newtEventLog, "event-log", "",
+		"", "Write build events to the specified file as newline-delimited "+
+			"JSON")
+	newtCmd.PersistentFlags().BoolVarP(&newtutil.NewtNoParseCache,
+		"no-parse-cache", "", false,
+		"Don't use or update the cache of parsed YAML files")
+	newtCmd.PersistentFlags().BoolVarP(&newtutil.NewtAllowDirtyRepos,
+		"allow-dirty-repos", "", false,
+		"Use repos that fail integrity checks (local modifications, "+
+			"unexpected commits)")
+	newtCmd.PersistentFlags().BoolVarP(&newtutil.NewtStrictDeprecated,
+		"strict-deprecated", "", false,
+		"Treat the use of deprecated packages as an error instead of a "+
+			"warning")
 
 	versHelpText := cli.FormatHelp(`Display the Newt version number`)
 	versHelpEx := "  newt version"
@@ -137,17 +274,63 @@ func newtCmd() *cobra.Command {
 }
 
 func main() {
+	// Settings provide defaults for global flags, so they must be read
+	// before the flags are defined.
+	projDir := ""
+	if wd, err := os.Getwd(); err == nil {
+		projDir, _ = project.FindProjectDir(filepath.ToSlash(wd))
+	}
+	if settings, err := newtutil.LoadSettings(projDir); err != nil {
+		fmt.Fprintf(os.Stderr, "* Warning: %s\n", err.Error())
+	} else {
+		newtutil.NewtSettings = settings
+	}
+
 	cmd := newtCmd()
 
+	cli.RunPinnedNewt(projDir, cmd)
+
+	cli.AddAddr2LineCommands(cmd)
+	cli.AddAnalyzeCommands(cmd)
+	cli.AddAuditCommands(cmd)
+	cli.AddBspCommands(cmd)
 	cli.AddBuildCommands(cmd)
+	cli.AddBundleCommands(cmd)
+	cli.AddCompareCommands(cmd)
 	cli.AddCompleteCommands(cmd)
+	cli.AddConfigCommands(cmd)
+	cli.AddConfImageCommands(cmd)
+	cli.AddConnCommands(cmd)
+	cli.AddConsoleCommands(cmd)
+	cli.AddCoredumpCommands(cmd)
+	cli.AddDaemonCommands(cmd)
+	cli.AddDoctorCommands(cmd)
+	cli.AddElfDiffCommands(cmd)
+	cli.AddEmitCommands(cmd)
+	cli.AddFsImageCommands(cmd)
+	cli.AddFuzzCommands(cmd)
+	cli.AddGrepCommands(cmd)
+	cli.AddHistoryCommands(cmd)
+	cli.AddIdeCommands(cmd)
 	cli.AddImageCommands(cmd)
+	cli.AddIwyuCommands(cmd)
 	cli.AddPackageCommands(cmd)
+	cli.AddPeripheralsCommands(cmd)
 	cli.AddProjectCommands(cmd)
+	cli.AddReleaseCommands(cmd)
 	cli.AddRunCommands(cmd)
+	cli.AddSecureCommands(cmd)
+	cli.AddSelfCommands(cmd)
+	cli.AddSettingsCommands(cmd)
+	cli.AddSplitCommands(cmd)
+	cli.AddStackCommands(cmd)
 	cli.AddTargetCommands(cmd)
+	cli.AddToolchainCommands(cmd)
 	cli.AddValsCommands(cmd)
+	cli.AddVerifyCommands(cmd)
+	cli.AddMonitorCommands(cmd)
 	cli.AddMfgCommands(cmd)
+	cli.AddPluginCommands(cmd, projDir)
 
 	/* only pass the first two args to check for complete command */
 	if len(os.Args) > 2 {
@@ -168,4 +351,9 @@ func main() {
 	}
 
 	cmd.Execute()
+	newtutil.CloseEventLog()
+
+	if err := util.SaveConfigCache(); err != nil {
+		log.Debugf("Failed to save config cache: %s", err.Error())
+	}
 }
@@ -0,0 +1,248 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package newtutil
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newt/yaml"
+)
+
+// Connection profiles describe how to reach a developer's device (transport,
+// serial port, BLE peer).  They are kept per-project in
+// .newt/connections.yml, outside of version control, and targets refer to
+// them by name (target.connection).
+
+const CONN_PROFILES_FILENAME = "connections.yml"
+
+const (
+	CONN_TYPE_SERIAL = "serial"
+	CONN_TYPE_UDP    = "udp"
+	CONN_TYPE_BLE    = "ble"
+)
+
+var ConnTypes = []string{CONN_TYPE_SERIAL, CONN_TYPE_UDP, CONN_TYPE_BLE}
+
+type ConnProfile struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// Serial device (serial).
+	Port string `json:"port,omitempty"`
+
+	// Serial baud rate; 0 means the BSP's default (serial).
+	Baud int `json:"baud,omitempty"`
+
+	// <host>:<port> of the device's SMP server (udp).
+	Addr string `json:"addr,omitempty"`
+
+	// Advertised name of the device (ble).
+	Peer string `json:"peer,omitempty"`
+}
+
+// The profile attributes that can be specified as "name=value" pairs.
+var ConnProfileAttrs = []string{"type", "port", "baud", "addr", "peer"}
+
+func ConnProfilesPath(projDir string) string {
+	return filepath.Join(projDir, NEWTRC_DIR, CONN_PROFILES_FILENAME)
+}
+
+func (cp *ConnProfile) Validate() error {
+	switch cp.Type {
+	case CONN_TYPE_SERIAL:
+		if cp.Port == "" {
+			return util.FmtNewtError(
+				"Serial connection %s requires a port", cp.Name)
+		}
+	case CONN_TYPE_UDP:
+		if cp.Addr == "" {
+			return util.FmtNewtError(
+				"UDP connection %s requires an addr", cp.Name)
+		}
+	case CONN_TYPE_BLE:
+		if cp.Peer == "" {
+			return util.FmtNewtError(
+				"BLE connection %s requires a peer", cp.Name)
+		}
+	default:
+		return util.FmtNewtError("Connection %s has invalid type \"%s\"; "+
+			"must be one of: %s", cp.Name, cp.Type,
+			strings.Join(ConnTypes, ", "))
+	}
+
+	if cp.Baud < 0 {
+		return util.FmtNewtError("Connection %s has invalid baud rate %d",
+			cp.Name, cp.Baud)
+	}
+
+	return nil
+}
+
+// Sets a profile attribute from its string representation.
+func (cp *ConnProfile) SetAttr(name string, val string) error {
+	switch name {
+	case "type":
+		cp.Type = val
+	case "port":
+		cp.Port = val
+	case "baud":
+		baud, err := strconv.Atoi(val)
+		if err != nil {
+			return util.FmtNewtError("Invalid baud rate: %s", val)
+		}
+		cp.Baud = baud
+	case "addr":
+		cp.Addr = val
+	case "peer":
+		cp.Peer = val
+	default:
+		return util.FmtNewtError("Unknown connection attribute \"%s\"; "+
+			"must be one of: %s", name, strings.Join(ConnProfileAttrs, ", "))
+	}
+
+	return nil
+}
+
+// Returns the profile's image management connection string (serial:<dev>,
+// udp:<host>:<port> or ble:<peer>).
+func (cp *ConnProfile) SmpConn() string {
+	switch cp.Type {
+	case CONN_TYPE_UDP:
+		return "udp:" + cp.Addr
+	case CONN_TYPE_BLE:
+		return "ble:" + cp.Peer
+	default:
+		return "serial:" + cp.Port
+	}
+}
+
+// Returns the port to use for serial loading and mcumgr: the serial device,
+// or ble:<peer>.  UDP profiles have none.
+func (cp *ConnProfile) SerialPort() string {
+	switch cp.Type {
+	case CONN_TYPE_SERIAL:
+		return cp.Port
+	case CONN_TYPE_BLE:
+		return "ble:" + cp.Peer
+	default:
+		return ""
+	}
+}
+
+// Reads the project's connection profiles.  A missing file yields no
+// profiles.
+func ReadConnProfiles(projDir string) (map[string]*ConnProfile, error) {
+	profiles := map[string]*ConnProfile{}
+
+	path := ConnProfilesPath(projDir)
+	if util.NodeNotExist(path) {
+		return profiles, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, util.FmtNewtError("Error parsing %s: %s", path,
+			err.Error())
+	}
+
+	for name, v := range cast.ToStringMap(m["connections"]) {
+		attrs := cast.ToStringMap(v)
+		cp := &ConnProfile{
+			Name: name,
+			Type: cast.ToString(attrs["type"]),
+			Port: cast.ToString(attrs["port"]),
+			Baud: cast.ToInt(attrs["baud"]),
+			Addr: cast.ToString(attrs["addr"]),
+			Peer: cast.ToString(attrs["peer"]),
+		}
+		if err := cp.Validate(); err != nil {
+			return nil, util.FmtNewtError("%s: %s", path, err.Error())
+		}
+		profiles[name] = cp
+	}
+
+	return profiles, nil
+}
+
+// Returns the names of the specified profiles, sorted.
+func SortedConnProfileNames(profiles map[string]*ConnProfile) []string {
+	names := make([]string, 0, len(profiles))
+	for name, _ := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Replaces the project's connection profiles file with the specified
+// profiles.
+func WriteConnProfiles(projDir string,
+	profiles map[string]*ConnProfile) error {
+
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "### newt connection profiles\n")
+	fmt.Fprintf(&buf, "connections:\n")
+	for _, name := range SortedConnProfileNames(profiles) {
+		cp := profiles[name]
+		fmt.Fprintf(&buf, "    %s:\n", yaml.EscapeString(name))
+		fmt.Fprintf(&buf, "        type: %s\n", cp.Type)
+		if cp.Port != "" {
+			fmt.Fprintf(&buf, "        port: %s\n",
+				yaml.EscapeString(cp.Port))
+		}
+		if cp.Baud != 0 {
+			fmt.Fprintf(&buf, "        baud: %d\n", cp.Baud)
+		}
+		if cp.Addr != "" {
+			fmt.Fprintf(&buf, "        addr: %s\n",
+				yaml.EscapeString(cp.Addr))
+		}
+		if cp.Peer != "" {
+			fmt.Fprintf(&buf, "        peer: %s\n",
+				yaml.EscapeString(cp.Peer))
+		}
+	}
+
+	path := ConnProfilesPath(projDir)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
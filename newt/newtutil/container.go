@@ -0,0 +1,183 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package newtutil
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// A container image that toolchain commands (compiler, linker, binutils,
+// and the BSP's download and debug scripts) are run in, so that every
+// developer builds with the same tools.
+type ToolchainContainer struct {
+	// Absolute path of the container engine (docker or podman).
+	Engine string
+
+	Image string
+
+	// Extra arguments for "<engine> run" (e.g., "--privileged" to give
+	// debug scripts access to USB probes).
+	Args []string
+
+	// Host directories mounted into the container at the same path, so
+	// that paths in command lines need no translation.
+	Mounts []string
+}
+
+// The container toolchain commands are run in; nil to run them on the host.
+var NewtContainer *ToolchainContainer
+
+// Creates a container configuration.  If engine is empty, docker is used,
+// or podman if docker is not installed.
+func NewToolchainContainer(engine string, image string, args []string,
+	mounts []string) (*ToolchainContainer, error) {
+
+	if image == "" {
+		return nil, util.NewNewtError("Toolchain container has no image")
+	}
+
+	engines := []string{engine}
+	if engine == "" {
+		engines = []string{"docker", "podman"}
+	}
+
+	tc := &ToolchainContainer{
+		Image: image,
+		Args:  args,
+	}
+	for _, e := range engines {
+		if path, err := exec.LookPath(e); err == nil {
+			tc.Engine = path
+			break
+		}
+	}
+	if tc.Engine == "" {
+		// Commands that don't run the toolchain still work; the others
+		// fail when they try to execute the engine.
+		log.Warnf("Toolchain container %s requires %s, which is not "+
+			"installed", image, strings.Join(engines, " or "))
+		tc.Engine = engines[0]
+	}
+
+	// Mount each directory once; skip any inside another mount.
+	for _, m := range mounts {
+		m = filepath.Clean(m)
+		covered := false
+		for _, other := range mounts {
+			other = filepath.Clean(other)
+			if other != m && strings.HasPrefix(m, other+"/") {
+				covered = true
+			}
+		}
+		if !covered && !containsString(tc.Mounts, m) {
+			tc.Mounts = append(tc.Mounts, m)
+		}
+	}
+
+	log.Debugf("Toolchain container: %s %s; mounts: %s", tc.Engine,
+		tc.Image, strings.Join(tc.Mounts, " "))
+
+	return tc, nil
+}
+
+func containsString(slice []string, s string) bool {
+	for _, e := range slice {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (tc *ToolchainContainer) runCmd(cmd []string, env []string,
+	interactive bool) []string {
+
+	run := []string{tc.Engine, "run", "--rm"}
+	if interactive {
+		// Debug scripts connect to GDB servers on the host.
+		run = append(run, "-i", "--network", "host")
+		if fi, err := os.Stdin.Stat(); err == nil &&
+			fi.Mode()&os.ModeCharDevice != 0 {
+
+			run = append(run, "-t")
+		}
+	}
+
+	// Keep generated files owned by the invoking user.
+	if filepath.Base(tc.Engine) == "podman" {
+		run = append(run, "--userns=keep-id")
+	} else {
+		run = append(run, "--user",
+			fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()))
+	}
+
+	for _, m := range tc.Mounts {
+		run = append(run, "-v", m+":"+m)
+	}
+	if wd, err := os.Getwd(); err == nil {
+		run = append(run, "-w", wd)
+	}
+	for _, e := range env {
+		run = append(run, "-e", e)
+	}
+
+	run = append(run, tc.Args...)
+	run = append(run, tc.Image)
+	return append(run, cmd...)
+}
+
+// If set, rewrites each non-interactive toolchain command before it runs
+// (e.g., to trace the files it reads).  Not applied to commands run in a
+// toolchain container.
+var ToolchainWrapper func(cmd []string) []string
+
+// Prepares a toolchain command for execution with the specified environment
+// variables, which should include the target's (target.env) if a target is
+// being built.  The command is passed through ToolchainWrapper.  If a
+// toolchain container is configured, the command is rewritten to run inside
+// it and its environment is passed to the container.
+func ToolchainCmd(cmd []string, env []string) ([]string, []string) {
+	if NewtContainer == nil {
+		if ToolchainWrapper != nil {
+			cmd = ToolchainWrapper(cmd)
+		}
+		return cmd, env
+	}
+	return NewtContainer.runCmd(cmd, env, false), nil
+}
+
+// Like ToolchainCmd, but for commands that interact with the user's
+// terminal (e.g., a debugger).
+func InteractiveToolchainCmd(cmd []string, env []string) ([]string,
+	[]string) {
+
+	if NewtContainer == nil {
+		return cmd, env
+	}
+	return NewtContainer.runCmd(cmd, env, true), nil
+}
@@ -0,0 +1,109 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package newtutil
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Types of events written to the event log.
+const (
+	EVENT_BUILD_START = "build_start"
+	EVENT_BUILD_END   = "build_end"
+	EVENT_CACHE       = "cache"
+	EVENT_COMPILE     = "compile"
+	EVENT_DIAGNOSTIC  = "diagnostic"
+	EVENT_LINK        = "link"
+	EVENT_IMAGE       = "image"
+	EVENT_SIZE        = "size"
+)
+
+// Stream of build events, written as newline-delimited JSON objects.  Nil if
+// no event log was requested.
+var eventLog *os.File
+var eventMtx sync.Mutex
+
+// Opens the specified file as the event log, truncating it if it exists.
+func OpenEventLog(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	eventMtx.Lock()
+	defer eventMtx.Unlock()
+
+	eventLog = f
+	return nil
+}
+
+func CloseEventLog() {
+	eventMtx.Lock()
+	defer eventMtx.Unlock()
+
+	if eventLog != nil {
+		eventLog.Close()
+		eventLog = nil
+	}
+}
+
+// Indicates whether events are being recorded.  Callers can use this to avoid
+// gathering data that is only needed for the event log.
+func EventLogEnabled() bool {
+	eventMtx.Lock()
+	defer eventMtx.Unlock()
+
+	return eventLog != nil
+}
+
+// Writes a single event to the event log.  Each event is a JSON object
+// containing the event type and a timestamp in addition to the specified
+// fields.  This is a no-op if no event log is open.
+func EmitEvent(eventType string, fields map[string]interface{}) {
+	eventMtx.Lock()
+	defer eventMtx.Unlock()
+
+	if eventLog == nil {
+		return
+	}
+
+	obj := map[string]interface{}{}
+	for k, v := range fields {
+		obj[k] = v
+	}
+	obj["type"] = eventType
+	obj["time"] = time.Now().Format(time.RFC3339Nano)
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		util.ErrorMessage(util.VERBOSITY_QUIET,
+			"* Warning: failed to encode %s event: %s\n", eventType,
+			err.Error())
+		return
+	}
+
+	// Write each event in full so that consumers tailing the file never see
+	// a partial line.
+	eventLog.Write(append(data, '\n'))
+}
@@ -25,6 +25,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/spf13/cast"
@@ -39,6 +40,14 @@ var NewtVersionStr string = "Apache Newt version: 1.1.0-dev"
 var NewtBlinkyTag string = "develop"
 var NewtNumJobs int
 var NewtForce bool
+var NewtIgnoreLock bool
+var NewtOffline bool
+var NewtAllowDirtyRepos bool
+var NewtStrictDeprecated bool
+var NewtJson bool
+var NewtJsonErrors bool
+var NewtNoParseCache bool
+var NewtColor bool
 
 const NEWTRC_DIR string = ".newt"
 const REPOS_FILENAME string = "repos.yml"
@@ -130,6 +139,36 @@ func Newtrc() *viper.Viper {
 	return newtrc
 }
 
+// Calls fn once for each index in [0, n), using up to NewtNumJobs goroutines.
+// Returns once all calls have completed.
+func RunParallel(n int, fn func(i int)) {
+	numWorkers := NewtNumJobs
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	if numWorkers > n {
+		numWorkers = n
+	}
+
+	indices := make(chan int, n)
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indices {
+				fn(idx)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func GetSliceFeatures(v *viper.Viper, features map[string]bool,
 	key string) []interface{} {
 
@@ -239,6 +278,56 @@ func GetStringSliceFeatures(v *viper.Viper, features map[string]bool,
 	return strVals
 }
 
+// Like GetStringSliceFeatures, but also indicates which features contributed
+// each value.  Values listed under the plain key map to an empty slice;
+// values that are only present because of one or more features map to the
+// names of those features.  Each value appears once in the returned slice.
+func GetStringSliceFeatureSrcs(v *viper.Viper, features map[string]bool,
+	key string) ([]string, map[string][]string) {
+
+	vals := []string{}
+	srcs := map[string][]string{}
+	for _, val := range cast.ToStringSlice(v.Get(key)) {
+		if _, ok := srcs[val]; !ok {
+			vals = append(vals, val)
+			srcs[val] = []string{}
+		}
+	}
+
+	featureKeys := make([]string, 0, len(features))
+	for feature, _ := range features {
+		featureKeys = append(featureKeys, feature)
+	}
+	sort.Strings(featureKeys)
+
+	for _, feature := range featureKeys {
+		overwriteVal := v.Get(key + "." + feature + ".OVERWRITE")
+		if overwriteVal != nil {
+			vals = []string{}
+			srcs = map[string][]string{}
+			for _, val := range cast.ToStringSlice(overwriteVal) {
+				if _, ok := srcs[val]; !ok {
+					vals = append(vals, val)
+					srcs[val] = []string{feature}
+				}
+			}
+			return vals, srcs
+		}
+
+		for _, val := range cast.ToStringSlice(v.Get(key + "." + feature)) {
+			cur, ok := srcs[val]
+			if !ok {
+				vals = append(vals, val)
+			}
+			if !ok || len(cur) > 0 {
+				srcs[val] = append(cur, feature)
+			}
+		}
+	}
+
+	return vals, srcs
+}
+
 // Parses a string of the following form:
 //     [@repo]<path/to/package>
 //
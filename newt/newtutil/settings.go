@@ -0,0 +1,322 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package newtutil
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newt/yaml"
+)
+
+// User settings are read from $HOME/.newt/config.yml and from
+// .newt/config.yml in the project directory.  Project settings take
+// precedence over global ones; command line flags take precedence over both.
+
+const SETTINGS_FILENAME = "config.yml"
+
+const (
+	SETTINGS_SCOPE_GLOBAL  = "global"
+	SETTINGS_SCOPE_PROJECT = "project"
+)
+
+type SettingDef struct {
+	Name        string
+	Description string
+	Choices     []string
+	Numeric     bool
+
+	// Whether a numeric setting may be zero.
+	ZeroOk bool
+}
+
+var SettingDefs = []SettingDef{
+	{
+		Name:        "cache_dir",
+		Description: "Directory where downloaded repositories are cached",
+	},
+	{
+		Name: "compiler_launcher",
+		Description: "Command that compilations are run through (e.g., " +
+			"ccache)",
+	},
+	{
+		Name:        "color",
+		Description: "Colorize errors and warnings",
+		Choices:     []string{"auto", "always", "never"},
+	},
+	{
+		Name: "generated_edits",
+		Description: "What to do when a generated file was modified " +
+			"outside newt",
+		Choices: []string{"warn", "error"},
+	},
+	{
+		Name:        "jobs",
+		Description: "Default number of concurrent build jobs",
+		Numeric:     true,
+	},
+	{
+		Name:        "net_retries",
+		Description: "Number of times a failed repo download is retried",
+		Numeric:     true,
+		ZeroOk:      true,
+	},
+	{
+		Name: "net_timeout",
+		Description: "Seconds a repo download may stall before it is " +
+			"abandoned",
+		Numeric: true,
+	},
+	{
+		Name: "size_store",
+		Description: "File or http(s) URL where \"newt size record\" " +
+			"keeps package sizes",
+	},
+	{
+		Name: "toolchain_path",
+		Description: "Directories searched for toolchain executables " +
+			"before PATH",
+	},
+	{
+		Name:        "verbosity",
+		Description: "Default output verbosity",
+		Choices:     []string{"silent", "quiet", "default", "verbose"},
+	},
+	{
+		Name:        "verbosity_downloader",
+		Description: "Output verbosity of repo downloads",
+		Choices:     []string{"silent", "quiet", "default", "verbose"},
+	},
+	{
+		Name:        "verbosity_image",
+		Description: "Output verbosity of image creation",
+		Choices:     []string{"silent", "quiet", "default", "verbose"},
+	},
+	{
+		Name:        "verbosity_resolver",
+		Description: "Output verbosity of dependency resolution",
+		Choices:     []string{"silent", "quiet", "default", "verbose"},
+	},
+	{
+		Name:        "verbosity_toolchain",
+		Description: "Output verbosity of compiling and linking",
+		Choices:     []string{"silent", "quiet", "default", "verbose"},
+	},
+}
+
+type Settings struct {
+	paths map[string]string
+	vals  map[string]map[string]string
+}
+
+// The settings in effect for this invocation of newt.
+var NewtSettings = &Settings{
+	paths: map[string]string{},
+	vals:  map[string]map[string]string{},
+}
+
+func FindSettingDef(name string) *SettingDef {
+	for i, _ := range SettingDefs {
+		if SettingDefs[i].Name == name {
+			return &SettingDefs[i]
+		}
+	}
+
+	return nil
+}
+
+func (def *SettingDef) Validate(val string) error {
+	if def.Numeric {
+		min := 1
+		desc := "a positive"
+		if def.ZeroOk {
+			min = 0
+			desc = "a non-negative"
+		}
+		if n, err := strconv.Atoi(val); err != nil || n < min {
+			return util.FmtNewtError(
+				"Setting %s requires %s integer; got \"%s\"",
+				def.Name, desc, val)
+		}
+	}
+
+	if len(def.Choices) > 0 {
+		for _, choice := range def.Choices {
+			if val == choice {
+				return nil
+			}
+		}
+		return util.FmtNewtError("Setting %s must be one of: %s",
+			def.Name, strings.Join(def.Choices, ", "))
+	}
+
+	return nil
+}
+
+func globalSettingsPath() string {
+	usr, err := user.Current()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(usr.HomeDir, NEWTRC_DIR, SETTINGS_FILENAME)
+}
+
+func readSettingsFile(path string) (map[string]string, error) {
+	vals := map[string]string{}
+	if path == "" || util.NodeNotExist(path) {
+		return vals, nil
+	}
+
+	v, err := util.ReadConfig(filepath.Dir(path),
+		strings.TrimSuffix(SETTINGS_FILENAME, ".yml"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, def := range SettingDefs {
+		if val := v.GetString(def.Name); val != "" {
+			if err := def.Validate(val); err != nil {
+				return nil, util.FmtNewtError("%s: %s", path, err.Error())
+			}
+			vals[def.Name] = val
+		}
+	}
+
+	return vals, nil
+}
+
+// Reads the global settings file and, if projDir is not empty, the project's
+// settings file.
+func LoadSettings(projDir string) (*Settings, error) {
+	s := &Settings{
+		paths: map[string]string{
+			SETTINGS_SCOPE_GLOBAL: globalSettingsPath(),
+		},
+		vals: map[string]map[string]string{},
+	}
+	if projDir != "" {
+		s.paths[SETTINGS_SCOPE_PROJECT] = filepath.Join(projDir, NEWTRC_DIR,
+			SETTINGS_FILENAME)
+	}
+
+	for scope, path := range s.paths {
+		vals, err := readSettingsFile(path)
+		if err != nil {
+			return nil, err
+		}
+		s.vals[scope] = vals
+	}
+
+	return s, nil
+}
+
+// Returns the path of the settings file for the specified scope, or "" if
+// the scope is unavailable (e.g., project scope outside of a project).
+func (s *Settings) Path(scope string) string {
+	return s.paths[scope]
+}
+
+// Returns the effective value of a setting and the scope that provides it.
+// The scope is "" if the setting is not set.
+func (s *Settings) Get(name string) (string, string) {
+	for _, scope := range []string{SETTINGS_SCOPE_PROJECT,
+		SETTINGS_SCOPE_GLOBAL} {
+
+		if val, ok := s.vals[scope][name]; ok {
+			return val, scope
+		}
+	}
+
+	return "", ""
+}
+
+func (s *Settings) String(name string) string {
+	val, _ := s.Get(name)
+	return val
+}
+
+func (s *Settings) save(scope string) error {
+	path := s.paths[scope]
+	if path == "" {
+		return util.FmtNewtError("No %s settings file available", scope)
+	}
+
+	vals := s.vals[scope]
+	names := make([]string, 0, len(vals))
+	for name, _ := range vals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "### newt %s settings\n", scope)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s: %s\n", name, yaml.EscapeString(vals[name]))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+func (s *Settings) Set(scope string, name string, val string) error {
+	def := FindSettingDef(name)
+	if def == nil {
+		return util.FmtNewtError("Unknown setting: %s", name)
+	}
+	if err := def.Validate(val); err != nil {
+		return err
+	}
+
+	if s.vals[scope] == nil {
+		s.vals[scope] = map[string]string{}
+	}
+	s.vals[scope][name] = val
+
+	return s.save(scope)
+}
+
+func (s *Settings) Unset(scope string, name string) error {
+	if FindSettingDef(name) == nil {
+		return util.FmtNewtError("Unknown setting: %s", name)
+	}
+
+	if _, ok := s.vals[scope][name]; !ok {
+		return nil
+	}
+	delete(s.vals[scope], name)
+
+	return s.save(scope)
+}
@@ -0,0 +1,447 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package newtutil
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Source maps are written beside the generated file they describe, e.g.,
+// "syscfg.h" -> "syscfg.h.map.json".
+const SOURCE_MAP_SUFFIX = ".map.json"
+
+// Identifies the YAML setting that produced a block of generated code.
+type YamlSource struct {
+	// Full name of the package containing the YAML file.
+	Pkg string
+
+	// Path of the YAML file.
+	Path string
+
+	// Path of the key within the file, outermost first.  A component of the
+	// form "[n]" refers to the nth element of a sequence.
+	Key []string
+}
+
+func (src YamlSource) KeyString() string {
+	s := ""
+	for _, k := range src.Key {
+		if s != "" && !strings.HasPrefix(k, "[") {
+			s += "."
+		}
+		s += k
+	}
+	return s
+}
+
+// Describes the source without referencing the local filesystem, so that
+// generated code is identical across checkouts.
+func (src YamlSource) String() string {
+	return fmt.Sprintf("%s/%s: %s",
+		src.Pkg, filepath.Base(src.Path), src.KeyString())
+}
+
+// Maps a range of generated lines to the YAML setting that produced them.
+// Line numbers are one-based and inclusive.  YamlLine is zero if the key
+// could not be located in the YAML file.
+type SourceMapBlock struct {
+	FirstLine int    `json:"first_line"`
+	LastLine  int    `json:"last_line"`
+	Package   string `json:"package"`
+	YamlFile  string `json:"yaml_file"`
+	YamlKey   string `json:"yaml_key"`
+	YamlLine  int    `json:"yaml_line,omitempty"`
+}
+
+type SourceMap struct {
+	Generated string           `json:"generated"`
+	Blocks    []SourceMapBlock `json:"blocks"`
+
+	// SHA256 of the generated file, for detecting edits made outside newt.
+	Checksum string `json:"sha256,omitempty"`
+}
+
+// Accumulates a generated file and records which YAML settings produced
+// each block of it.  Generators wrap a block in calls to Begin() and End().
+type GenWriter struct {
+	buf    bytes.Buffer
+	lines  int
+	start  int
+	open   []YamlSource
+	blocks []SourceMapBlock
+}
+
+func NewGenWriter() *GenWriter {
+	return &GenWriter{}
+}
+
+func (gw *GenWriter) Write(p []byte) (int, error) {
+	gw.lines += bytes.Count(p, []byte{'\n'})
+	return gw.buf.Write(p)
+}
+
+func (gw *GenWriter) Bytes() []byte {
+	return gw.buf.Bytes()
+}
+
+// Writes a provenance comment for each of the specified sources and starts a
+// block attributed to them.  Any open block is ended first.
+func (gw *GenWriter) Begin(indent string, srcs ...YamlSource) {
+	gw.End()
+
+	gw.start = gw.lines + 1
+	for _, src := range srcs {
+		fmt.Fprintf(gw, "%s/* From %s */\n", indent, src.String())
+	}
+	gw.open = srcs
+}
+
+// Ends the current block.  A block's last line is the last line completed
+// before this call.
+func (gw *GenWriter) End() {
+	if len(gw.open) == 0 {
+		return
+	}
+
+	for _, src := range gw.open {
+		gw.blocks = append(gw.blocks, SourceMapBlock{
+			FirstLine: gw.start,
+			LastLine:  gw.lines,
+			Package:   src.Pkg,
+			YamlFile:  src.Path,
+			YamlKey:   src.KeyString(),
+			YamlLine:  YamlKeyLine(src.Path, src.Key),
+		})
+	}
+	gw.open = nil
+}
+
+func (gw *GenWriter) SourceMap(genPath string) SourceMap {
+	gw.End()
+
+	blocks := gw.blocks
+	if blocks == nil {
+		blocks = []SourceMapBlock{}
+	}
+
+	return SourceMap{
+		Generated: filepath.Base(genPath),
+		Blocks:    blocks,
+		Checksum:  fmt.Sprintf("%x", sha256.Sum256(gw.Bytes())),
+	}
+}
+
+// Writes the source map for the generated file at genPath.  As with the
+// generated files themselves, the map is only rewritten if its contents
+// change.  Before the old map is replaced, the generated file is checked
+// against the checksum it records; see checkUnmodified().
+func (gw *GenWriter) WriteSourceMap(genPath string) error {
+	if err := gw.checkUnmodified(genPath); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(gw.SourceMap(genPath), "", "    ")
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	b = append(b, '\n')
+
+	path := genPath + SOURCE_MAP_SUFFIX
+	changed, err := util.FileContentsChanged(path, b)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	log.Debugf("writing source map (%s)", path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Saved copies of hand-edited generated files get this suffix.
+const GEN_MODIFIED_SUFFIX = ".modified"
+
+// Reads the checksum recorded in a generated file's source map.  Returns ""
+// if there is no map or it predates checksums.
+func recordedChecksum(genPath string) string {
+	b, err := ioutil.ReadFile(genPath + SOURCE_MAP_SUFFIX)
+	if err != nil {
+		return ""
+	}
+
+	var sm SourceMap
+	if err := json.Unmarshal(b, &sm); err != nil {
+		return ""
+	}
+
+	return sm.Checksum
+}
+
+// Returns the range of lines in newLines that replace a differing range in
+// oldLines, as zero-based [first, end) indices.  Lines common to the start
+// and end of both are excluded.
+func changedLines(oldLines []string, newLines []string) (int, int) {
+	first := 0
+	for first < len(oldLines) && first < len(newLines) &&
+		oldLines[first] == newLines[first] {
+
+		first++
+	}
+
+	end := len(newLines)
+	oldEnd := len(oldLines)
+	for end > first && oldEnd > first && oldLines[oldEnd-1] == newLines[end-1] {
+		end--
+		oldEnd--
+	}
+
+	// A deletion replaces no lines; point at the line that follows it.
+	if end == first && end < len(newLines) {
+		end++
+	}
+
+	return first, end
+}
+
+// Describes where the changes between a generated file's edited and
+// regenerated contents should be made instead.
+func (gw *GenWriter) editSuggestions(edited []byte) []string {
+	oldLines := strings.Split(string(edited), "\n")
+	newLines := strings.Split(string(gw.Bytes()), "\n")
+	first, end := changedLines(oldLines, newLines)
+
+	seen := map[string]bool{}
+	suggestions := []string{}
+	for _, block := range gw.SourceMap("").Blocks {
+		if block.LastLine <= first || block.FirstLine > end {
+			continue
+		}
+
+		loc := block.YamlFile
+		if block.YamlLine > 0 {
+			loc += ":" + strconv.Itoa(block.YamlLine)
+		}
+		s := fmt.Sprintf("%s (%s)", loc, block.YamlKey)
+
+		// A setting's value is usually overridden rather than redefined.
+		if name := strings.TrimPrefix(block.YamlKey,
+			"syscfg.defs."); name != block.YamlKey {
+
+			s += "; or override it with syscfg.vals." + name +
+				" in the target's syscfg.yml"
+		}
+		if !seen[s] {
+			seen[s] = true
+			suggestions = append(suggestions, s)
+		}
+	}
+
+	return suggestions
+}
+
+// Detects a generated file that was edited outside newt, i.e., one that no
+// longer matches the checksum in its source map.  Such edits would be lost
+// when the file is regenerated, so the edited file is saved beside it and
+// the user is pointed at the YAML settings that produce the changed lines.
+// With the generated_edits setting at "error", the build fails instead.
+func (gw *GenWriter) checkUnmodified(genPath string) error {
+	checksum := recordedChecksum(genPath)
+	if checksum == "" {
+		return nil
+	}
+
+	edited, err := ioutil.ReadFile(genPath)
+	if err != nil {
+		return nil
+	}
+	if fmt.Sprintf("%x", sha256.Sum256(edited)) == checksum ||
+		bytes.Equal(edited, gw.Bytes()) {
+
+		return nil
+	}
+
+	msg := fmt.Sprintf("generated file %s was modified outside newt",
+		genPath)
+	if suggestions := gw.editSuggestions(edited); len(suggestions) > 0 {
+		msg += "; make the change in the YAML that produces it instead:\n" +
+			"    " + strings.Join(suggestions, "\n    ")
+	}
+
+	if NewtSettings.String("generated_edits") == "error" {
+		return util.FmtNewtError("%s\nRestore the file with `newt clean "+
+			"--generated`", msg)
+	}
+
+	savePath := genPath + GEN_MODIFIED_SUFFIX
+	if err := ioutil.WriteFile(savePath, edited, 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+	util.ErrorMessage(util.VERBOSITY_QUIET, "* Warning: %s\n"+
+		"The edited file was saved to %s; it will be regenerated.\n",
+		msg, savePath)
+
+	return nil
+}
+
+type yamlFileLines struct {
+	modTime time.Time
+	lines   []string
+}
+
+// Files are reread when they change so that a long-running newt process
+// doesn't report stale line numbers.
+var yamlLinesCache = map[string]yamlFileLines{}
+var yamlLinesMtx sync.Mutex
+
+func yamlLines(path string) []string {
+	yamlLinesMtx.Lock()
+	defer yamlLinesMtx.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+
+	if cached, ok := yamlLinesCache[path]; ok &&
+		cached.modTime.Equal(info.ModTime()) {
+
+		return cached.lines
+	}
+
+	var lines []string
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		f.Close()
+	}
+
+	yamlLinesCache[path] = yamlFileLines{
+		modTime: info.ModTime(),
+		lines:   lines,
+	}
+	return lines
+}
+
+func yamlIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func yamlKeyMatches(trimmed string, key string) bool {
+	for _, k := range []string{key, `"` + key + `"`, `'` + key + `'`} {
+		if strings.HasPrefix(trimmed, k+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// Finds the line on which a key is defined in a YAML file.  This is a
+// textual search rather than a parse; it handles the block style used by
+// Mynewt's YAML files.  If only part of the key can be found, the line of
+// the innermost component found is returned.  Returns 0 if the first
+// component can't be found.  Line numbers are one-based.
+func YamlKeyLine(path string, key []string) int {
+	lines := yamlLines(path)
+
+	parentIndent := -1
+	found := 0
+	i := 0
+	for _, k := range key {
+		idx := -1
+		if strings.HasPrefix(k, "[") && strings.HasSuffix(k, "]") {
+			if n, err := strconv.Atoi(k[1 : len(k)-1]); err == nil {
+				idx = n
+			}
+		}
+
+		childIndent := -1
+		matched := false
+		for ; i < len(lines); i++ {
+			trimmed := strings.TrimSpace(lines[i])
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+
+			indent := yamlIndent(lines[i])
+			dash := trimmed == "-" || strings.HasPrefix(trimmed, "- ")
+			if indent < parentIndent ||
+				(indent == parentIndent && !(idx >= 0 && dash)) {
+
+				// Left the parent's block.  A sequence may be indented
+				// level with its key.
+				break
+			}
+			if childIndent == -1 {
+				childIndent = indent
+			}
+			if indent != childIndent {
+				continue
+			}
+
+			if idx >= 0 {
+				if dash {
+					if idx == 0 {
+						matched = true
+						break
+					}
+					idx--
+				}
+			} else if yamlKeyMatches(trimmed, k) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return found
+		}
+
+		found = i + 1
+		parentIndent = yamlIndent(lines[i])
+		i++
+	}
+
+	return found
+}
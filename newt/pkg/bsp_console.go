@@ -0,0 +1,57 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package pkg
+
+import (
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+// Where a BSP's console UART appears on the host.  Read from the bsp.console
+// map in bsp.yml.
+type BspConsole struct {
+	// Serial device; may be a glob pattern (e.g.,
+	// "/dev/serial/by-id/usb-SEGGER_J-Link_*").
+	Port string
+
+	// Baud rate; 0 means the target's CONSOLE_UART_BAUD setting.
+	Baud int
+}
+
+func (bsp *BspPackage) readConsole(features map[string]bool) error {
+	fields := newtutil.GetStringMapFeatures(bsp.BspV, features,
+		"bsp.console")
+
+	bsp.Console = BspConsole{
+		Port: cast.ToString(fields["port"]),
+	}
+
+	if s := cast.ToString(fields["baud"]); s != "" {
+		baud, err := util.AtoiNoOct(s)
+		if err != nil || baud <= 0 {
+			return util.FmtNewtError("BSP \"%s\" specifies invalid console "+
+				"baud rate: %s", bsp.Name(), s)
+		}
+		bsp.Console.Baud = baud
+	}
+
+	return nil
+}
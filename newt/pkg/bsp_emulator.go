@@ -0,0 +1,102 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package pkg
+
+import (
+	"sort"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/interfaces"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+// How a BSP's hardware is emulated by a particular emulator (e.g., qemu or
+// renode).  Read from the bsp.emulator map in bsp.yml, which is keyed by
+// emulator name.
+type BspEmulator struct {
+	Name string
+
+	// Emulator executable; each emulator has a default.
+	Binary string
+
+	// qemu: machine name (-M).  renode: platform description (.repl).
+	Machine string
+
+	// qemu: CPU model (-cpu); optional.
+	Cpu string
+
+	// renode: UART whose output is the console (e.g., "sysbus.uart0").
+	Uart string
+
+	// renode: script (.resc) that sets up the machine; replaces Machine.
+	Script string
+
+	// Additional command line arguments.
+	Args []string
+}
+
+func (bsp *BspPackage) readEmulators(features map[string]bool) error {
+	bsp.Emulators = map[string]*BspEmulator{}
+
+	section := newtutil.GetStringMapFeatures(bsp.BspV, features,
+		"bsp.emulator")
+	names := make([]string, 0, len(section))
+	for name, _ := range section {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fields := cast.ToStringMap(section[name])
+		get := func(key string) string {
+			return cast.ToString(fields[key])
+		}
+
+		emu := &BspEmulator{
+			Name:    name,
+			Binary:  get("binary"),
+			Machine: get("machine"),
+			Cpu:     get("cpu"),
+			Uart:    get("uart"),
+			Args:    cast.ToStringSlice(fields["args"]),
+		}
+
+		if script := get("script"); script != "" {
+			path, err := interfaces.GetProject().ResolvePath(
+				bsp.Repo().Path(), script)
+			if err != nil {
+				return util.PreNewtError(err,
+					"BSP \"%s\" emulator \"%s\" specifies invalid script",
+					bsp.Name(), name)
+			}
+			emu.Script = path
+		}
+
+		if emu.Machine == "" && emu.Script == "" {
+			return util.FmtNewtError("BSP \"%s\" emulator \"%s\" specifies "+
+				"neither a machine nor a script", bsp.Name(), name)
+		}
+
+		bsp.Emulators[name] = emu
+	}
+
+	return nil
+}
@@ -20,7 +20,9 @@
 package pkg
 
 import (
+	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 
 	"mynewt.apache.org/newt/newt/flash"
@@ -40,7 +42,15 @@ type BspPackage struct {
 	Part2LinkerScripts []string /* scripts to link app to second partition */
 	DownloadScript     string
 	DebugScript        string
+	SvdFile            string /* CMSIS-SVD register description, for IDEs */
 	FlashMap           flash.FlashMap
+	MemoryRegions      []flash.MemoryRegion
+	Emulators          map[string]*BspEmulator
+	Probes             map[string]*BspProbe
+	DefaultProbe       string
+	SerialLoad         *BspSerialLoad
+	Console            BspConsole
+	Features           []string /* capabilities advertised by bsp.features */
 	BspV               *viper.Viper
 }
 
@@ -122,6 +132,10 @@ func (bsp *BspPackage) Reload(features map[string]bool) error {
 	bsp.Arch = newtutil.GetStringFeatures(bsp.BspV,
 		features, "bsp.arch")
 
+	bsp.Features = newtutil.GetStringSliceFeatures(bsp.BspV,
+		features, "bsp.features")
+	sort.Strings(bsp.Features)
+
 	bsp.LinkerScripts, err = bsp.resolveLinkerScriptSetting(
 		features, "bsp.linkerscript")
 	if err != nil {
@@ -144,6 +158,10 @@ func (bsp *BspPackage) Reload(features map[string]bool) error {
 	if err != nil {
 		return err
 	}
+	bsp.SvdFile, err = bsp.resolvePathSetting(features, "bsp.svd")
+	if err != nil {
+		return err
+	}
 
 	if bsp.CompilerName == "" {
 		return util.NewNewtError("BSP does not specify a compiler " +
@@ -164,6 +182,42 @@ func (bsp *BspPackage) Reload(features map[string]bool) error {
 	if err != nil {
 		return err
 	}
+	bsp.FlashMap.YamlSrc = newtutil.YamlSource{
+		Pkg:  bsp.FullName(),
+		Path: bsp.BasePath() + "/" + BSP_YAML_FILENAME,
+		Key:  []string{"bsp.flash_map"},
+	}
+
+	// Files to program into EEPROM and OTP areas are part of the BSP.
+	for name, area := range bsp.FlashMap.Areas {
+		if area.Data != "" && !filepath.IsAbs(area.Data) {
+			area.Data = filepath.Join(bsp.BasePath(), area.Data)
+			bsp.FlashMap.Areas[name] = area
+		}
+	}
+
+	// Memory regions are optional.  If they are specified, newt generates a
+	// linker script fragment describing the BSP's memory layout.
+	ymlRegions := newtutil.GetStringMapFeatures(bsp.BspV, features,
+		"bsp.memory_regions")
+	bsp.MemoryRegions, err = flash.ReadMemoryRegions(ymlRegions)
+	if err != nil {
+		return util.PreNewtError(err, "BSP \"%s\" specifies invalid "+
+			"memory regions", bsp.Name())
+	}
+
+	if err := bsp.readEmulators(features); err != nil {
+		return err
+	}
+	if err := bsp.readProbes(features); err != nil {
+		return err
+	}
+	if err := bsp.readSerialLoad(features); err != nil {
+		return err
+	}
+	if err := bsp.readConsole(features); err != nil {
+		return err
+	}
 
 	return nil
 }
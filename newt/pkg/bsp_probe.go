@@ -0,0 +1,120 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package pkg
+
+import (
+	"sort"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+// How a BSP's MCU is programmed and debugged through a particular debug probe
+// backend (e.g., pyocd or probe-rs).  Read from the bsp.probe map in bsp.yml,
+// which is keyed by backend name.
+type BspProbe struct {
+	Name string
+
+	// Backend executable; each backend has a default.
+	Binary string
+
+	// pyocd: target type (-t).  probe-rs: chip name (--chip).
+	Target string
+
+	// SWD/JTAG clock frequency in Hz; 0 means the backend's default.
+	Frequency int
+
+	// Port the GDB server listens on; 0 means the backend's default.
+	GdbPort int
+
+	// Additional command line arguments, appended to every invocation.
+	Args []string
+
+	// Additional arguments for programming write-once (OTP) areas, e.g., to
+	// unlock them.
+	OtpArgs []string
+
+	// Serial number of the probe to use when several are attached; set per
+	// invocation rather than read from bsp.yml.
+	Serial string
+}
+
+func (bsp *BspPackage) readProbes(features map[string]bool) error {
+	bsp.Probes = map[string]*BspProbe{}
+
+	section := newtutil.GetStringMapFeatures(bsp.BspV, features,
+		"bsp.probe")
+	names := make([]string, 0, len(section))
+	for name, _ := range section {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fields := cast.ToStringMap(section[name])
+		get := func(key string) string {
+			return cast.ToString(fields[key])
+		}
+
+		probe := &BspProbe{
+			Name:   name,
+			Binary: get("binary"),
+			Target: get("target"),
+			Args:   cast.ToStringSlice(fields["args"]),
+
+			OtpArgs: cast.ToStringSlice(fields["otp_args"]),
+		}
+		if probe.Target == "" {
+			probe.Target = get("chip")
+		}
+		if probe.Target == "" {
+			return util.FmtNewtError("BSP \"%s\" probe \"%s\" does not "+
+				"specify a target", bsp.Name(), name)
+		}
+
+		var err error
+		if s := get("frequency"); s != "" {
+			probe.Frequency, err = util.AtoiNoOct(s)
+			if err != nil || probe.Frequency <= 0 {
+				return util.FmtNewtError("BSP \"%s\" probe \"%s\" specifies "+
+					"invalid frequency: %s", bsp.Name(), name, s)
+			}
+		}
+		if s := get("gdb_port"); s != "" {
+			probe.GdbPort, err = util.AtoiNoOct(s)
+			if err != nil || probe.GdbPort <= 0 || probe.GdbPort > 65535 {
+				return util.FmtNewtError("BSP \"%s\" probe \"%s\" specifies "+
+					"invalid GDB port: %s", bsp.Name(), name, s)
+			}
+		}
+
+		bsp.Probes[name] = probe
+	}
+
+	bsp.DefaultProbe = newtutil.GetStringFeatures(bsp.BspV, features,
+		"bsp.probe_default")
+	if bsp.DefaultProbe != "" && bsp.Probes[bsp.DefaultProbe] == nil {
+		return util.FmtNewtError("BSP \"%s\" default probe \"%s\" is not "+
+			"described in bsp.probe", bsp.Name(), bsp.DefaultProbe)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package pkg
+
+import (
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+// How a BSP's MCU is programmed without a debug probe, through a bootloader
+// that speaks a serial protocol (mcumgr, nrf-dfu, or stm32).  Read from the
+// bsp.serial_load map in bsp.yml.
+type BspSerialLoad struct {
+	Protocol string
+
+	// Loader executable; each protocol has a default.
+	Binary string
+
+	// Serial baud rate; 0 means the protocol's default.
+	Baud int
+
+	// nrf-dfu: hardware version and required SoftDevice IDs of the
+	// generated DFU package.
+	HwVersion string
+	SdReq     string
+
+	// Additional command line arguments.
+	Args []string
+}
+
+func (bsp *BspPackage) readSerialLoad(features map[string]bool) error {
+	bsp.SerialLoad = nil
+
+	fields := newtutil.GetStringMapFeatures(bsp.BspV, features,
+		"bsp.serial_load")
+	if len(fields) == 0 {
+		return nil
+	}
+	get := func(key string) string {
+		return cast.ToString(fields[key])
+	}
+
+	sl := &BspSerialLoad{
+		Protocol:  get("protocol"),
+		Binary:    get("binary"),
+		HwVersion: get("hw_version"),
+		SdReq:     get("sd_req"),
+		Args:      cast.ToStringSlice(fields["args"]),
+	}
+	if sl.Protocol == "" {
+		return util.FmtNewtError("BSP \"%s\" does not specify a serial "+
+			"load protocol (bsp.serial_load.protocol)", bsp.Name())
+	}
+
+	if s := get("baud"); s != "" {
+		baud, err := util.AtoiNoOct(s)
+		if err != nil || baud <= 0 {
+			return util.FmtNewtError("BSP \"%s\" specifies invalid serial "+
+				"load baud rate: %s", bsp.Name(), s)
+		}
+		sl.Baud = baud
+	}
+
+	bsp.SerialLoad = sl
+	return nil
+}
@@ -75,6 +75,11 @@ type LocalPackage struct {
 	// Settings read from syscfg.yml.
 	SyscfgV *viper.Viper
 
+	// Packages whose syscfg values this package inherits, ordered from most
+	// distant to nearest.  A package's own values take precedence over
+	// inherited ones.  Only targets inherit settings.
+	SyscfgBases []*LocalPackage
+
 	// Names of all source yml files; used to determine if rebuild required.
 	cfgFilenames []string
 }
@@ -126,6 +131,46 @@ func (pkg *LocalPackage) Desc() *PackageDesc {
 	return pkg.desc
 }
 
+// Indicates that a package should no longer be used.  Specified in pkg.yml
+// as follows:
+//
+//	pkg.deprecated: "Superseded by the generic sensor driver."
+//	pkg.replacement: "@apache-mynewt-core/hw/drivers/sensors/foo"
+//
+// pkg.deprecated may also be set to true if there is nothing to add.
+type Deprecation struct {
+	Message     string
+	Replacement string
+}
+
+// Returns the package's deprecation notice, or nil if the package is not
+// deprecated.  Naming a replacement implies deprecation.
+func (pkg *LocalPackage) Deprecation() *Deprecation {
+	d := &Deprecation{
+		Replacement: strings.TrimSpace(
+			pkg.PkgV.GetString("pkg.replacement")),
+	}
+
+	deprecated := d.Replacement != ""
+	switch v := pkg.PkgV.Get("pkg.deprecated").(type) {
+	case bool:
+		deprecated = deprecated || v
+	case string:
+		d.Message = strings.TrimSpace(v)
+		if b, err := strconv.ParseBool(d.Message); err == nil {
+			d.Message = ""
+			deprecated = deprecated || b
+		} else if d.Message != "" {
+			deprecated = true
+		}
+	}
+
+	if !deprecated {
+		return nil
+	}
+	return d
+}
+
 func (pkg *LocalPackage) SetName(name string) {
 	pkg.name = name
 	// XXX: Also set "pkg.name" in viper object (possibly just remove cached
@@ -395,15 +440,14 @@ func LocalPackageSpecialName(dirName string) bool {
 	return ok
 }
 
-func ReadLocalPackageRecursive(repo *repo.Repo,
-	pkgList map[string]interfaces.PackageInterface, basePath string,
-	pkgName string, searchedMap map[string]struct{}) ([]string, error) {
-
-	var warnings []string
+// Collects the directories containing a pkg.yml file under the specified
+// package directory.  Subdirectories are listed before their parents.
+func findLocalPackageDirs(repo *repo.Repo, basePath string, pkgName string,
+	searchedMap map[string]struct{}, dirs *[]string) error {
 
 	dirList, err := repo.FilteredSearchList(pkgName, searchedMap)
 	if err != nil {
-		return warnings, util.NewNewtError(err.Error())
+		return util.NewNewtError(err.Error())
 	}
 
 	for _, name := range dirList {
@@ -411,39 +455,18 @@ func ReadLocalPackageRecursive(repo *repo.Repo,
 			continue
 		}
 
-		subWarnings, err := ReadLocalPackageRecursive(repo, pkgList,
-			basePath, filepath.Join(pkgName, name), searchedMap)
-		warnings = append(warnings, subWarnings...)
+		err := findLocalPackageDirs(repo, basePath,
+			filepath.Join(pkgName, name), searchedMap, dirs)
 		if err != nil {
-			return warnings, err
+			return err
 		}
 	}
 
-	if util.NodeNotExist(filepath.Join(basePath, pkgName,
-		PACKAGE_FILE_NAME)) {
-
-		return warnings, nil
+	if util.NodeExist(filepath.Join(basePath, pkgName, PACKAGE_FILE_NAME)) {
+		*dirs = append(*dirs, filepath.Join(basePath, pkgName))
 	}
 
-	pkg, err := LoadLocalPackage(repo, filepath.Join(basePath, pkgName))
-	if err != nil {
-		warnings = append(warnings, err.Error())
-		return warnings, nil
-	}
-
-	if oldPkg, ok := pkgList[pkg.Name()]; ok {
-		oldlPkg := oldPkg.(*LocalPackage)
-		warnings = append(warnings,
-			fmt.Sprintf("Multiple packages with same pkg.name=%s "+
-				"in repo %s; path1=%s path2=%s", oldlPkg.Name(), repo.Name(),
-				oldlPkg.BasePath(), pkg.BasePath()))
-
-		return warnings, nil
-	}
-
-	pkgList[pkg.Name()] = pkg
-
-	return warnings, nil
+	return nil
 }
 
 func ReadLocalPackages(repo *repo.Repo, basePath string) (
@@ -456,8 +479,40 @@ func ReadLocalPackages(repo *repo.Repo, basePath string) (
 	// twice.
 	searchedMap := map[string]struct{}{}
 
-	warnings, err := ReadLocalPackageRecursive(repo, *pkgMap,
-		basePath, "", searchedMap)
+	var dirs []string
+	if err := findLocalPackageDirs(repo, basePath, "", searchedMap,
+		&dirs); err != nil {
+
+		return pkgMap, nil, err
+	}
+
+	// Parsing YAML dominates the cost of loading a repo, so packages are
+	// loaded in parallel.  They are added to the map in directory order to
+	// keep duplicate-name handling deterministic.
+	pkgs := make([]*LocalPackage, len(dirs))
+	errs := make([]error, len(dirs))
+	newtutil.RunParallel(len(dirs), func(i int) {
+		pkgs[i], errs[i] = LoadLocalPackage(repo, dirs[i])
+	})
+
+	var warnings []string
+	for i, pkg := range pkgs {
+		if errs[i] != nil {
+			warnings = append(warnings, errs[i].Error())
+			continue
+		}
+
+		if oldPkg, ok := (*pkgMap)[pkg.Name()]; ok {
+			oldlPkg := oldPkg.(*LocalPackage)
+			warnings = append(warnings,
+				fmt.Sprintf("Multiple packages with same pkg.name=%s "+
+					"in repo %s; path1=%s path2=%s", oldlPkg.Name(),
+					repo.Name(), oldlPkg.BasePath(), pkg.BasePath()))
+			continue
+		}
+
+		(*pkgMap)[pkg.Name()] = pkg
+	}
 
-	return pkgMap, warnings, err
+	return pkgMap, warnings, nil
 }
@@ -36,6 +36,7 @@ const (
 	PACKAGE_TYPE_MFG
 	PACKAGE_TYPE_SDK
 	PACKAGE_TYPE_GENERATED
+	PACKAGE_TYPE_PREBUILT
 	PACKAGE_TYPE_LIB
 	PACKAGE_TYPE_BSP
 	PACKAGE_TYPE_UNITTEST
@@ -48,6 +49,7 @@ var PackageTypeNames = map[interfaces.PackageType]string{
 	PACKAGE_TYPE_MFG:       "mfg",
 	PACKAGE_TYPE_SDK:       "sdk",
 	PACKAGE_TYPE_GENERATED: "generated",
+	PACKAGE_TYPE_PREBUILT:  "prebuilt",
 	PACKAGE_TYPE_LIB:       "lib",
 	PACKAGE_TYPE_BSP:       "bsp",
 	PACKAGE_TYPE_UNITTEST:  "unittest",
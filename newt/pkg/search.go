@@ -0,0 +1,225 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package pkg
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+)
+
+// A YAML setting matched by a metadata search.  Line is zero if the key could
+// not be located in the file.
+type YamlMatch struct {
+	Package string `json:"package"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+}
+
+func yamlValueString(itf interface{}) string {
+	switch v := itf.(type) {
+	case []interface{}:
+		strs := make([]string, len(v))
+		for i, e := range v {
+			strs[i] = yamlValueString(e)
+		}
+		return "[" + strings.Join(strs, ", ") + "]"
+
+	case map[string]interface{}, map[interface{}]interface{}:
+		m := cast.ToStringMap(v)
+		keys := make([]string, 0, len(m))
+		for k, _ := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		strs := make([]string, len(keys))
+		for i, k := range keys {
+			strs[i] = k + ": " + yamlValueString(m[k])
+		}
+		return "{" + strings.Join(strs, ", ") + "}"
+
+	default:
+		return cast.ToString(v)
+	}
+}
+
+func isYamlMap(itf interface{}) bool {
+	switch itf.(type) {
+	case map[string]interface{}, map[interface{}]interface{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func newYamlMatch(lpkg *LocalPackage, filename string, key []string,
+	value interface{}) YamlMatch {
+
+	path := lpkg.BasePath() + "/" + filename
+
+	// Conditional settings are written as a single dotted key, e.g.,
+	// "pkg.cflags.TEST:".
+	line := 0
+	if len(key) >= 2 {
+		joined := append([]string{key[0] + "." + key[1]}, key[2:]...)
+		line = newtutil.YamlKeyLine(path, joined)
+	}
+	if line == 0 {
+		line = newtutil.YamlKeyLine(path, key)
+	}
+
+	return YamlMatch{
+		Package: lpkg.FullName(),
+		File:    path,
+		Line:    line,
+		Key:     strings.Join(key, "."),
+		Value:   yamlValueString(value),
+	}
+}
+
+type yamlMatchSorter struct {
+	matches []YamlMatch
+}
+
+func (s yamlMatchSorter) Len() int {
+	return len(s.matches)
+}
+func (s yamlMatchSorter) Swap(i, j int) {
+	s.matches[i], s.matches[j] = s.matches[j], s.matches[i]
+}
+func (s yamlMatchSorter) Less(i, j int) bool {
+	a := s.matches[i]
+	b := s.matches[j]
+	if a.Package != b.Package {
+		return a.Package < b.Package
+	}
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	return a.Key < b.Key
+}
+
+func sortYamlMatches(matches []YamlMatch) {
+	sort.Sort(yamlMatchSorter{matches})
+}
+
+// Searches the specified packages' syscfg.yml files for setting definitions
+// and overrides whose names match the given expression.  Conditional
+// overrides (syscfg.vals.<SETTING>) are included.
+func SearchSyscfg(lpkgs []*LocalPackage, re *regexp.Regexp) []YamlMatch {
+	matches := []YamlMatch{}
+
+	for _, lpkg := range lpkgs {
+		defs := cast.ToStringMap(lpkg.SyscfgV.Get("syscfg.defs"))
+		for name, itf := range defs {
+			if re.MatchString(name) {
+				m := cast.ToStringMap(itf)
+				matches = append(matches, newYamlMatch(lpkg,
+					SYSCFG_YAML_FILENAME, []string{"syscfg.defs", name},
+					m["value"]))
+			}
+		}
+
+		vals := cast.ToStringMap(lpkg.SyscfgV.Get("syscfg.vals"))
+		for name, itf := range vals {
+			if !isYamlMap(itf) {
+				if re.MatchString(name) {
+					matches = append(matches, newYamlMatch(lpkg,
+						SYSCFG_YAML_FILENAME, []string{"syscfg.vals", name},
+						itf))
+				}
+				continue
+			}
+
+			// A mapping here contains the overrides that apply when the
+			// named setting is enabled.
+			for subName, subItf := range cast.ToStringMap(itf) {
+				if re.MatchString(subName) {
+					matches = append(matches, newYamlMatch(lpkg,
+						SYSCFG_YAML_FILENAME,
+						[]string{"syscfg.vals." + name, subName}, subItf))
+				}
+			}
+		}
+	}
+
+	sortYamlMatches(matches)
+	return matches
+}
+
+// Searches the specified field of the packages' pkg.yml files for values that
+// match the given expression.  A field name without a prefix refers to a
+// "pkg." field, e.g., "cflags" means "pkg.cflags".  Each matching list
+// element or mapping entry is reported separately.
+func SearchPkgField(lpkgs []*LocalPackage, field string,
+	re *regexp.Regexp) []YamlMatch {
+
+	if !strings.Contains(field, ".") {
+		field = "pkg." + field
+	}
+
+	matches := []YamlMatch{}
+
+	var search func(lpkg *LocalPackage, key []string, itf interface{})
+	search = func(lpkg *LocalPackage, key []string, itf interface{}) {
+		switch v := itf.(type) {
+		case []interface{}:
+			for _, e := range v {
+				search(lpkg, key, e)
+			}
+
+		case map[string]interface{}, map[interface{}]interface{}:
+			for k, e := range cast.ToStringMap(v) {
+				subKey := append(append([]string{}, key...), k)
+				if re.MatchString(k) {
+					matches = append(matches, newYamlMatch(lpkg,
+						PACKAGE_FILE_NAME, subKey, e))
+				} else {
+					search(lpkg, subKey, e)
+				}
+			}
+
+		case nil:
+
+		default:
+			if re.MatchString(cast.ToString(v)) {
+				matches = append(matches, newYamlMatch(lpkg,
+					PACKAGE_FILE_NAME, key, v))
+			}
+		}
+	}
+
+	for _, lpkg := range lpkgs {
+		search(lpkg, []string{field}, lpkg.PkgV.Get(field))
+	}
+
+	sortYamlMatches(matches)
+	return matches
+}
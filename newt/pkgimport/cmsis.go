@@ -0,0 +1,339 @@
+/**
+* Licensed to the Apache Software Foundation (ASF) under one
+* or more contributor license agreements.  See the NOTICE file
+* distributed with this work for additional information
+* regarding copyright ownership.  The ASF licenses this file
+* to you under the Apache License, Version 2.0 (the
+* "License"); you may not use this file except in compliance
+* with the License.  You may obtain a copy of the License at
+*
+*  http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing,
+* software distributed under the License is distributed on an
+* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+* KIND, either express or implied.  See the License for the
+* specific language governing permissions and limitations
+* under the License.
+ */
+
+package pkgimport
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// The parts of a CMSIS-Pack description (.pdsc) that an import uses.
+type pdscFile struct {
+	Category  string `xml:"category,attr"`
+	Name      string `xml:"name,attr"`
+	Path      string `xml:"path,attr"`
+	Condition string `xml:"condition,attr"`
+}
+
+type pdscComponent struct {
+	Cclass      string     `xml:"Cclass,attr"`
+	Cgroup      string     `xml:"Cgroup,attr"`
+	Csub        string     `xml:"Csub,attr"`
+	Cvariant    string     `xml:"Cvariant,attr"`
+	IsDefault   bool       `xml:"isDefaultVariant,attr"`
+	Condition   string     `xml:"condition,attr"`
+	Description string     `xml:"description"`
+	Files       []pdscFile `xml:"files>file"`
+}
+
+type pdscBundle struct {
+	Cclass     string          `xml:"Cclass,attr"`
+	Components []pdscComponent `xml:"component"`
+}
+
+type pdscExpr struct {
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+type pdscCondition struct {
+	Id      string     `xml:"id,attr"`
+	Accept  []pdscExpr `xml:"accept"`
+	Require []pdscExpr `xml:"require"`
+	Deny    []pdscExpr `xml:"deny"`
+}
+
+type pdsc struct {
+	Vendor      string          `xml:"vendor"`
+	Name        string          `xml:"name"`
+	Description string          `xml:"description"`
+	Conditions  []pdscCondition `xml:"conditions>condition"`
+	Components  []pdscComponent `xml:"components>component"`
+	Bundles     []pdscBundle    `xml:"components>bundle"`
+}
+
+// Evaluates pack conditions against the attributes given in the import
+// declaration (e.g., "Dcore: Cortex-M4").  The compiler is always GCC.
+// Requirements on other components are assumed to be met; the import's deps
+// are expected to provide them.
+type pdscEvaluator struct {
+	conds map[string]*pdscCondition
+	attrs map[string]string
+
+	// Guards against conditions that refer to themselves.
+	active map[string]bool
+}
+
+func (e *pdscEvaluator) exprMatches(expr pdscExpr, deny bool) bool {
+	for _, attr := range expr.Attrs {
+		name := attr.Name.Local
+
+		var match bool
+		switch {
+		case name == "condition":
+			match = e.condHolds(attr.Value)
+		case strings.HasPrefix(name, "C"):
+			match = !deny
+		default:
+			val, ok := e.attrs[name]
+			if ok {
+				match, _ = path.Match(attr.Value, val)
+			}
+		}
+
+		if !match {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (e *pdscEvaluator) condHolds(id string) bool {
+	if id == "" {
+		return true
+	}
+
+	cond := e.conds[id]
+	if cond == nil {
+		log.Debugf("CMSIS-Pack condition %s not defined", id)
+		return false
+	}
+	if e.active[id] {
+		return false
+	}
+	e.active[id] = true
+	defer delete(e.active, id)
+
+	for _, expr := range cond.Require {
+		if !e.exprMatches(expr, false) {
+			return false
+		}
+	}
+
+	for _, expr := range cond.Deny {
+		if e.exprMatches(expr, true) {
+			return false
+		}
+	}
+
+	if len(cond.Accept) == 0 {
+		return true
+	}
+	for _, expr := range cond.Accept {
+		if e.exprMatches(expr, false) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns a component's identifier: "Cclass:Cgroup[:Csub][&Cvariant]".
+func (comp *pdscComponent) id() string {
+	id := comp.Cclass + ":" + comp.Cgroup
+	if comp.Csub != "" {
+		id += ":" + comp.Csub
+	}
+	if comp.Cvariant != "" {
+		id += "&" + comp.Cvariant
+	}
+	return id
+}
+
+// Indicates whether a component matches a pattern from the import's
+// "components" list.  A pattern without a variant matches every variant.
+func (comp *pdscComponent) matches(pattern string) bool {
+	id := comp.id()
+	if !strings.Contains(pattern, "&") {
+		id = strings.SplitN(id, "&", 2)[0]
+	}
+
+	match, _ := path.Match(pattern, id)
+	return match
+}
+
+// Locates the .pdsc file of an imported pack.  The import path may name the
+// file itself or the directory containing it.
+func (imp *Import) pdscPath() (string, error) {
+	if strings.HasSuffix(imp.Path, ".pdsc") {
+		return imp.Path, nil
+	}
+
+	matches, _ := filepath.Glob(imp.Path + "/*.pdsc")
+	switch len(matches) {
+	case 0:
+		return "", util.FmtNewtError("no .pdsc file in %s", imp.Path)
+	case 1:
+		return filepath.ToSlash(matches[0]), nil
+	default:
+		return "", util.FmtNewtError("multiple .pdsc files in %s; "+
+			"specify one in the import's path", imp.Path)
+	}
+}
+
+// Picks the components to import.  Without a "components" list, every
+// component whose condition holds is imported, using the default variant
+// where a component has several.
+func (imp *Import) selectComponents(desc *pdsc,
+	e *pdscEvaluator) ([]*pdscComponent, error) {
+
+	all := []*pdscComponent{}
+	for i, _ := range desc.Components {
+		all = append(all, &desc.Components[i])
+	}
+	for i, _ := range desc.Bundles {
+		bundle := &desc.Bundles[i]
+		for j, _ := range bundle.Components {
+			comp := &bundle.Components[j]
+			if comp.Cclass == "" {
+				comp.Cclass = bundle.Cclass
+			}
+			all = append(all, comp)
+		}
+	}
+
+	patterns := cast.ToStringSlice(imp.Settings["components"])
+	if len(patterns) > 0 {
+		comps := []*pdscComponent{}
+		for _, pattern := range patterns {
+			found := false
+			for _, comp := range all {
+				if comp.matches(pattern) && e.condHolds(comp.Condition) {
+					comps = append(comps, comp)
+					found = true
+				}
+			}
+			if !found {
+				return nil, util.FmtNewtError(
+					"no usable component matches \"%s\"", pattern)
+			}
+		}
+		return comps, nil
+	}
+
+	// Keep one variant per component, preferring the default one.
+	comps := []*pdscComponent{}
+	byId := map[string]int{}
+	for _, comp := range all {
+		if !e.condHolds(comp.Condition) {
+			continue
+		}
+
+		id := strings.SplitN(comp.id(), "&", 2)[0]
+		if idx, ok := byId[id]; ok {
+			if comp.IsDefault && !comps[idx].IsDefault {
+				comps[idx] = comp
+			}
+			continue
+		}
+
+		byId[id] = len(comps)
+		comps = append(comps, comp)
+	}
+
+	return comps, nil
+}
+
+func (imp *Import) readCmsisPack() (*contents, error) {
+	pdscPath, err := imp.pdscPath()
+	if err != nil {
+		return nil, err
+	}
+	packDir := filepath.ToSlash(filepath.Dir(pdscPath))
+
+	data, err := ioutil.ReadFile(pdscPath)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	desc := &pdsc{}
+	if err := xml.Unmarshal(data, desc); err != nil {
+		return nil, util.FmtNewtError("error parsing %s: %s", pdscPath,
+			err.Error())
+	}
+
+	e := &pdscEvaluator{
+		conds:  map[string]*pdscCondition{},
+		attrs:  cast.ToStringMapString(imp.Settings["attributes"]),
+		active: map[string]bool{},
+	}
+	for i, _ := range desc.Conditions {
+		e.conds[desc.Conditions[i].Id] = &desc.Conditions[i]
+	}
+	if _, ok := e.attrs["Tcompiler"]; !ok {
+		e.attrs["Tcompiler"] = "GCC"
+	}
+
+	comps, err := imp.selectComponents(desc, e)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &contents{
+		desc:     strings.TrimSpace(desc.Description),
+		cfgFiles: []string{pdscPath},
+	}
+	if c.desc == "" {
+		c.desc = desc.Vendor + "." + desc.Name
+	}
+
+	for _, comp := range comps {
+		for _, f := range comp.Files {
+			if !e.condHolds(f.Condition) {
+				continue
+			}
+
+			name := imp.relPath(packDir + "/" +
+				strings.TrimSuffix(f.Name, "/"))
+
+			switch f.Category {
+			case "source", "sourceC", "sourceCpp", "sourceAsm", "library":
+				c.srcFiles = appendUnique(c.srcFiles, name)
+
+			case "include":
+				c.includeDirs = appendUnique(c.includeDirs, name)
+
+			case "header":
+				dir := path.Dir(name)
+				if f.Path != "" {
+					dir = imp.relPath(packDir + "/" + f.Path)
+				}
+				c.includeDirs = appendUnique(c.includeDirs, dir)
+
+			case "preIncludeGlobal":
+				c.publicCflags = append(c.publicCflags,
+					"-include"+packDir+"/"+f.Name)
+
+			case "preIncludeLocal":
+				c.cflags = append(c.cflags, "-include"+packDir+"/"+f.Name)
+			}
+		}
+	}
+
+	return c, nil
+}
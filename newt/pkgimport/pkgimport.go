@@ -0,0 +1,249 @@
+/**
+* Licensed to the Apache Software Foundation (ASF) under one
+* or more contributor license agreements.  See the NOTICE file
+* distributed with this work for additional information
+* regarding copyright ownership.  The ASF licenses this file
+* to you under the Apache License, Version 2.0 (the
+* "License"); you may not use this file except in compliance
+* with the License.  You may obtain a copy of the License at
+*
+*  http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing,
+* software distributed under the License is distributed on an
+* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+* KIND, either express or implied.  See the License for the
+* specific language governing permissions and limitations
+* under the License.
+ */
+
+// Package pkgimport wraps source distributions that use another packaging
+// format as synthetic newt packages.  Imports are declared in project.yml:
+//
+//	project.imports:
+//	    ext/cmsis-dsp:
+//	        format: cmsis-pack
+//	        path: ext/ARM.CMSIS-DSP
+//	        components: ["CMSIS:DSP&Source"]
+//	        attributes:
+//	            Dcore: Cortex-M4
+//	    ext/libfoo:
+//	        format: zephyr-module
+//	        path: ext/libfoo
+//	        options:
+//	            CONFIG_LIBFOO_LOGGING: y
+//	        deps:
+//	            - "@apache-mynewt-core/sys/log/full"
+//
+// Each key is the name of the package that other packages depend on.
+package pkgimport
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newt/viper"
+)
+
+const (
+	FORMAT_CMSIS_PACK    = "cmsis-pack"
+	FORMAT_ZEPHYR_MODULE = "zephyr-module"
+)
+
+var Formats = []string{
+	FORMAT_CMSIS_PACK,
+	FORMAT_ZEPHYR_MODULE,
+}
+
+// An external package declared in project.yml.
+type Import struct {
+	Name   string
+	Format string
+
+	// Absolute path of the imported distribution.
+	Path string
+
+	// The synthetic package's directory: the import path, or its parent if
+	// the path names a file (e.g., a .pdsc file).
+	dir string
+
+	// Packages the synthetic package depends on.
+	Deps []string
+
+	// Format-specific settings; the remaining keys of the declaration.
+	Settings map[string]interface{}
+}
+
+// The parts of a synthetic package that an importer fills in.  All paths are
+// relative to the import's directory.
+type contents struct {
+	desc        string
+	srcFiles    []string
+	includeDirs []string
+
+	// Include directories and flags that apply only to the package's own
+	// sources.
+	privIncludeDirs []string
+	cflags          []string
+
+	// Flags that also apply to the package's dependers.
+	publicCflags []string
+
+	// Files the package is read from; a change to any of them triggers a
+	// rebuild.
+	cfgFiles []string
+}
+
+type importSorter struct {
+	imps []*Import
+}
+
+func (s importSorter) Len() int {
+	return len(s.imps)
+}
+func (s importSorter) Swap(i, j int) {
+	s.imps[i], s.imps[j] = s.imps[j], s.imps[i]
+}
+func (s importSorter) Less(i, j int) bool {
+	return s.imps[i].Name < s.imps[j].Name
+}
+
+// Reads the "project.imports" section of project.yml.  Relative paths are
+// relative to the project directory.  Imports are sorted by name.
+func ReadImports(v *viper.Viper, projDir string) ([]*Import, error) {
+	imps := []*Import{}
+
+	for name, itf := range cast.ToStringMap(v.Get("project.imports")) {
+		settings := cast.ToStringMap(itf)
+
+		imp := &Import{
+			Name:     name,
+			Format:   cast.ToString(settings["format"]),
+			Path:     cast.ToString(settings["path"]),
+			Deps:     cast.ToStringSlice(settings["deps"]),
+			Settings: settings,
+		}
+		delete(settings, "format")
+		delete(settings, "path")
+		delete(settings, "deps")
+
+		if imp.Path == "" {
+			return nil, util.FmtNewtError(
+				"project.imports entry %s does not specify a path", name)
+		}
+		if !filepath.IsAbs(imp.Path) {
+			imp.Path = filepath.Join(projDir, imp.Path)
+		}
+		imp.Path = filepath.ToSlash(filepath.Clean(imp.Path))
+
+		switch imp.Format {
+		case FORMAT_CMSIS_PACK, FORMAT_ZEPHYR_MODULE:
+		default:
+			return nil, util.FmtNewtError(
+				"project.imports entry %s has invalid format \"%s\"; "+
+					"must be one of: %s", name, imp.Format,
+				strings.Join(Formats, ", "))
+		}
+
+		imps = append(imps, imp)
+	}
+
+	sort.Sort(importSorter{imps})
+
+	return imps, nil
+}
+
+// Reads the imported distribution and wraps it in a package belonging to the
+// specified repo.
+func (imp *Import) Package(r *repo.Repo) (*pkg.LocalPackage, error) {
+	if util.NodeNotExist(imp.Path) {
+		return nil, util.FmtNewtError("Imported package %s: %s does not exist",
+			imp.Name, imp.Path)
+	}
+
+	imp.dir = imp.Path
+	if info, err := os.Stat(imp.Path); err == nil && !info.IsDir() {
+		imp.dir = filepath.ToSlash(filepath.Dir(imp.Path))
+	}
+
+	var c *contents
+	var err error
+
+	switch imp.Format {
+	case FORMAT_CMSIS_PACK:
+		c, err = imp.readCmsisPack()
+	case FORMAT_ZEPHYR_MODULE:
+		c, err = imp.readZephyrModule()
+	}
+	if err != nil {
+		return nil, util.FmtNewtError("Imported package %s: %s", imp.Name,
+			err.Error())
+	}
+
+	return imp.newPackage(r, c), nil
+}
+
+func (imp *Import) newPackage(r *repo.Repo, c *contents) *pkg.LocalPackage {
+	lpkg := pkg.NewLocalPackage(r, imp.dir)
+	lpkg.SetName(imp.Name)
+	lpkg.SetType(pkg.PACKAGE_TYPE_LIB)
+	lpkg.SetDesc(&pkg.PackageDesc{
+		Description: c.desc,
+		Keywords:    []string{imp.Format},
+	})
+
+	v := lpkg.PkgV
+	v.Set("pkg.name", imp.Name)
+	v.Set("pkg.type", pkg.PackageTypeNames[pkg.PACKAGE_TYPE_LIB])
+	v.Set("pkg.description", c.desc)
+	v.Set("pkg.deps", imp.Deps)
+	v.Set("pkg.src_files", c.srcFiles)
+	v.Set("pkg.public_include_dirs", c.includeDirs)
+	v.Set("pkg.private_include_dirs", c.privIncludeDirs)
+	v.Set("pkg.cflags", c.cflags)
+	v.Set("pkg.public_cflags", c.publicCflags)
+
+	for _, f := range c.cfgFiles {
+		lpkg.AddCfgFilename(f)
+	}
+
+	return lpkg
+}
+
+// Converts a path inside the import's directory to a relative one.  Paths
+// outside the directory are returned as absolute paths.
+func (imp *Import) relPath(path string) string {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if rel := strings.TrimPrefix(path, imp.dir+"/"); rel != path {
+		return rel
+	}
+	if path == imp.dir {
+		return "."
+	}
+	return path
+}
+
+// Formats a preprocessor definition as a compiler flag.
+func defineFlag(name string, val string) string {
+	if val == "" {
+		return "-D" + name
+	}
+	return fmt.Sprintf("-D%s=%s", name, val)
+}
+
+func appendUnique(ss []string, s string) []string {
+	for _, cur := range ss {
+		if cur == s {
+			return ss
+		}
+	}
+	return append(ss, s)
+}
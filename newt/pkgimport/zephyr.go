@@ -0,0 +1,666 @@
+/**
+* Licensed to the Apache Software Foundation (ASF) under one
+* or more contributor license agreements.  See the NOTICE file
+* distributed with this work for additional information
+* regarding copyright ownership.  The ASF licenses this file
+* to you under the Apache License, Version 2.0 (the
+* "License"); you may not use this file except in compliance
+* with the License.  You may obtain a copy of the License at
+*
+*  http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing,
+* software distributed under the License is distributed on an
+* "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+* KIND, either express or implied.  See the License for the
+* specific language governing permissions and limitations
+* under the License.
+ */
+
+package pkgimport
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// A Kconfig symbol declared by an imported module.
+type kconfigSym struct {
+	name string
+	typ  string
+	dflt string
+}
+
+// Reads the symbols declared in a Kconfig file and the files it includes.
+// Only what is needed to determine default values is understood: symbol
+// types and unconditional literal defaults.  Menus, dependencies and
+// conditional defaults are ignored.
+func readKconfig(path string, syms map[string]*kconfigSym,
+	optional bool) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		if optional && os.IsNotExist(err) {
+			return nil
+		}
+		return util.ChildNewtError(err)
+	}
+	defer f.Close()
+
+	var cur *kconfigSym
+	helpIndent := -1
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.Replace(scanner.Text(), "\t", "        ", -1)
+		trimmed := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		// Help text continues until a line that is indented no further than
+		// the "help" keyword.
+		if helpIndent >= 0 {
+			if trimmed == "" || indent > helpIndent {
+				continue
+			}
+			helpIndent = -1
+		}
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		fields := strings.Fields(trimmed)
+		keyword := fields[0]
+		arg := strings.TrimSpace(strings.TrimPrefix(trimmed, keyword))
+
+		switch keyword {
+		case "config", "menuconfig":
+			cur = syms[arg]
+			if cur == nil {
+				cur = &kconfigSym{name: arg}
+				syms[arg] = cur
+			}
+
+		case "bool", "tristate", "int", "hex", "string":
+			if cur != nil {
+				cur.typ = keyword
+			}
+
+		case "def_bool", "def_tristate", "def_int", "def_hex", "def_string":
+			if cur != nil {
+				cur.typ = strings.TrimPrefix(keyword, "def_")
+				cur.setDefault(arg)
+			}
+
+		case "default":
+			if cur != nil {
+				cur.setDefault(arg)
+			}
+
+		case "help", "---help---":
+			helpIndent = indent
+
+		case "source", "rsource", "osource", "orsource":
+			cur = nil
+			incl := strings.Trim(arg, "\"")
+			if strings.Contains(incl, "$") {
+				log.Debugf("Ignoring Kconfig include %s in %s", incl, path)
+				continue
+			}
+			if !filepath.IsAbs(incl) {
+				incl = filepath.Join(filepath.Dir(path), incl)
+			}
+			err := readKconfig(incl, syms, strings.HasPrefix(keyword, "o"))
+			if err != nil {
+				return err
+			}
+
+		case "menu", "endmenu", "choice", "endchoice", "if", "endif",
+			"comment", "mainmenu":
+
+			cur = nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Records a default value if it is the symbol's first unconditional literal
+// default.
+func (sym *kconfigSym) setDefault(arg string) {
+	if sym.dflt != "" || arg == "" || strings.Contains(arg, " if ") {
+		return
+	}
+
+	switch {
+	case arg == "y" || arg == "n":
+	case strings.HasPrefix(arg, "\"") && strings.HasSuffix(arg, "\""):
+		arg = strings.Trim(arg, "\"")
+	default:
+		if _, err := strconv.ParseInt(arg, 0, 64); err != nil {
+			// A reference to another symbol or an expression.
+			return
+		}
+	}
+
+	sym.dflt = arg
+}
+
+// Returns the preprocessor definition that Zephyr's autoconf.h would contain
+// for a symbol with the specified value; ok is false if there is none.
+func kconfigDefine(typ string, name string, val string) (string, bool) {
+	switch {
+	case val == "n" || (val == "" && typ != "string"):
+		return "", false
+	case val == "y" && (typ == "bool" || typ == "tristate" || typ == ""):
+		return defineFlag("CONFIG_"+name, "1"), true
+	case typ == "string":
+		return defineFlag("CONFIG_"+name, strconv.Quote(val)), true
+	default:
+		return defineFlag("CONFIG_"+name, val), true
+	}
+}
+
+// A command from a CMakeLists.txt file.
+type cmakeCmd struct {
+	name string
+	args []cmakeArg
+}
+
+type cmakeArg struct {
+	text   string
+	quoted bool
+}
+
+var cmakeCmdRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+
+// Splits a CMakeLists.txt file into commands.  Comments are discarded; the
+// arguments of each command are returned unexpanded.
+func parseCmake(text string) ([]cmakeCmd, error) {
+	cmds := []cmakeCmd{}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '#':
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+			continue
+		}
+
+		name := cmakeCmdRe.FindString(text[i:])
+		if name == "" {
+			return nil, util.FmtNewtError("unexpected character '%c'", c)
+		}
+		i += len(name)
+		for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+			i++
+		}
+		if i >= len(text) || text[i] != '(' {
+			return nil, util.FmtNewtError(
+				"expected '(' after command %s", name)
+		}
+		i++
+
+		cmd := cmakeCmd{name: strings.ToLower(name)}
+		depth := 0
+		var cur []byte
+		inArg := false
+		flush := func() {
+			if inArg {
+				cmd.args = append(cmd.args, cmakeArg{text: string(cur)})
+			}
+			cur = nil
+			inArg = false
+		}
+
+	args:
+		for ; i < len(text); i++ {
+			c := text[i]
+			switch {
+			case c == '"':
+				flush()
+				var quoted []byte
+				for i++; i < len(text) && text[i] != '"'; i++ {
+					if text[i] == '\\' && i+1 < len(text) {
+						i++
+					}
+					quoted = append(quoted, text[i])
+				}
+				cmd.args = append(cmd.args,
+					cmakeArg{text: string(quoted), quoted: true})
+
+			case c == '#':
+				flush()
+				for i < len(text) && text[i] != '\n' {
+					i++
+				}
+
+			case c == '(':
+				depth++
+				cur = append(cur, c)
+				inArg = true
+
+			case c == ')':
+				if depth == 0 {
+					flush()
+					i++
+					break args
+				}
+				depth--
+				cur = append(cur, c)
+
+			case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+				flush()
+
+			default:
+				cur = append(cur, c)
+				inArg = true
+			}
+		}
+
+		cmds = append(cmds, cmd)
+	}
+
+	return cmds, nil
+}
+
+var cmakeVarRe = regexp.MustCompile(`\$(ENV)?\{([^${}]*)\}`)
+
+// Evaluates the subset of CMake that Zephyr modules use to list their sources.
+type cmakeInterp struct {
+	imp  *Import
+	vars map[string]string
+	c    *contents
+}
+
+func (ci *cmakeInterp) expand(s string) string {
+	// Expand innermost references first so that nested ones work.
+	for {
+		exp := cmakeVarRe.ReplaceAllStringFunc(s, func(ref string) string {
+			m := cmakeVarRe.FindStringSubmatch(ref)
+			if m[1] != "" {
+				return os.Getenv(m[2])
+			}
+			return ci.vars[m[2]]
+		})
+		if exp == s {
+			return exp
+		}
+		s = exp
+	}
+}
+
+// Expands a command's arguments.  Unquoted arguments are split into list
+// elements.
+func (ci *cmakeInterp) expandArgs(args []cmakeArg) []string {
+	vals := []string{}
+	for _, arg := range args {
+		exp := ci.expand(arg.text)
+		if arg.quoted {
+			vals = append(vals, exp)
+			continue
+		}
+
+		for _, elem := range strings.Split(exp, ";") {
+			if elem != "" {
+				vals = append(vals, elem)
+			}
+		}
+	}
+
+	return vals
+}
+
+// Indicates whether a value counts as true in a CMake condition.
+func cmakeTruthy(val string) bool {
+	switch strings.ToUpper(val) {
+	case "", "0", "OFF", "NO", "FALSE", "N", "IGNORE", "NOTFOUND":
+		return false
+	}
+	return !strings.HasSuffix(val, "-NOTFOUND")
+}
+
+// Evaluates an if() condition.  Supports variable and constant tests,
+// DEFINED, NOT, AND and OR.
+func (ci *cmakeInterp) cond(args []string) bool {
+	for i, arg := range args {
+		if arg == "OR" {
+			return ci.cond(args[:i]) || ci.cond(args[i+1:])
+		}
+	}
+	for i, arg := range args {
+		if arg == "AND" {
+			return ci.cond(args[:i]) && ci.cond(args[i+1:])
+		}
+	}
+
+	switch {
+	case len(args) == 0:
+		return false
+	case args[0] == "NOT":
+		return !ci.cond(args[1:])
+	case args[0] == "DEFINED" && len(args) == 2:
+		_, ok := ci.vars[args[1]]
+		return ok
+	case len(args) == 1:
+		if val, ok := ci.vars[args[0]]; ok {
+			return cmakeTruthy(val)
+		}
+		switch strings.ToUpper(args[0]) {
+		case "1", "ON", "YES", "TRUE", "Y":
+			return true
+		}
+		n, err := strconv.ParseFloat(args[0], 64)
+		return err == nil && n != 0
+	}
+
+	log.Debugf("Unsupported CMake condition: %s", strings.Join(args, " "))
+	return false
+}
+
+// Converts paths relative to a CMake list directory to paths relative to
+// the import.
+func (ci *cmakeInterp) paths(dir string, args []string) []string {
+	paths := []string{}
+	for _, arg := range args {
+		// Generator expressions are only known at build time.
+		if strings.Contains(arg, "$<") {
+			continue
+		}
+		if !filepath.IsAbs(arg) {
+			arg = filepath.Join(dir, arg)
+		}
+		paths = append(paths, ci.imp.relPath(arg))
+	}
+
+	return paths
+}
+
+func definitions(args []string) []string {
+	flags := []string{}
+	for _, arg := range args {
+		flags = append(flags, "-D"+strings.TrimPrefix(arg, "-D"))
+	}
+	return flags
+}
+
+// Splits a conditional command (e.g., zephyr_sources_ifdef) into its base
+// name and indicates whether its condition holds.
+func (ci *cmakeInterp) conditional(name string,
+	args []string) (string, []string, bool) {
+
+	for _, suffix := range []string{"_ifdef", "_ifndef"} {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		if len(args) == 0 {
+			return "", nil, false
+		}
+
+		holds := cmakeTruthy(ci.vars[args[0]])
+		if suffix == "_ifndef" {
+			holds = !holds
+		}
+		return strings.TrimSuffix(name, suffix), args[1:], holds
+	}
+
+	return name, args, true
+}
+
+type cmakeIf struct {
+	outer bool
+	taken bool
+}
+
+// Evaluates a CMakeLists.txt file.  Each add_subdirectory() gets its own
+// copy of the variables, as in CMake.
+func (ci *cmakeInterp) run(dir string) error {
+	path := filepath.Join(dir, "CMakeLists.txt")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	ci.c.cfgFiles = append(ci.c.cfgFiles, filepath.ToSlash(path))
+
+	cmds, err := parseCmake(string(data))
+	if err != nil {
+		return util.FmtNewtError("error parsing %s: %s", path, err.Error())
+	}
+
+	ci.vars["CMAKE_CURRENT_SOURCE_DIR"] = filepath.ToSlash(dir)
+	ci.vars["CMAKE_CURRENT_LIST_DIR"] = filepath.ToSlash(dir)
+
+	active := true
+	ifs := []cmakeIf{}
+
+	for _, cmd := range cmds {
+		switch cmd.name {
+		case "if":
+			ifs = append(ifs, cmakeIf{outer: active})
+			active = active && ci.cond(ci.expandArgs(cmd.args))
+			ifs[len(ifs)-1].taken = active
+			continue
+
+		case "elseif", "else", "endif":
+			if len(ifs) == 0 {
+				return util.FmtNewtError("%s: %s() without if()", path,
+					cmd.name)
+			}
+			top := &ifs[len(ifs)-1]
+			switch cmd.name {
+			case "elseif":
+				active = top.outer && !top.taken &&
+					ci.cond(ci.expandArgs(cmd.args))
+				top.taken = top.taken || active
+			case "else":
+				active = top.outer && !top.taken
+				top.taken = true
+			case "endif":
+				active = top.outer
+				ifs = ifs[:len(ifs)-1]
+			}
+			continue
+		}
+
+		if !active {
+			continue
+		}
+
+		args := ci.expandArgs(cmd.args)
+		name, args, holds := ci.conditional(cmd.name, args)
+		if !holds {
+			continue
+		}
+
+		c := ci.c
+		switch name {
+		case "set":
+			if len(args) == 0 {
+				continue
+			}
+			vals := args[1:]
+			for i, val := range vals {
+				if val == "CACHE" || val == "PARENT_SCOPE" {
+					vals = vals[:i]
+					break
+				}
+			}
+			ci.vars[args[0]] = strings.Join(vals, ";")
+
+		case "list":
+			if len(args) >= 2 && args[0] == "APPEND" {
+				vals := append(strings.Split(ci.vars[args[1]], ";"),
+					args[2:]...)
+				if ci.vars[args[1]] == "" {
+					vals = vals[1:]
+				}
+				ci.vars[args[1]] = strings.Join(vals, ";")
+			}
+
+		case "zephyr_sources", "zephyr_library_sources":
+			for _, src := range ci.paths(dir, args) {
+				c.srcFiles = appendUnique(c.srcFiles, src)
+			}
+
+		case "zephyr_include_directories":
+			for _, incl := range ci.paths(dir, args) {
+				c.includeDirs = appendUnique(c.includeDirs, incl)
+			}
+
+		case "zephyr_library_include_directories":
+			for _, incl := range ci.paths(dir, args) {
+				c.privIncludeDirs = appendUnique(c.privIncludeDirs, incl)
+			}
+
+		case "zephyr_compile_definitions":
+			c.publicCflags = append(c.publicCflags, definitions(args)...)
+
+		case "zephyr_library_compile_definitions":
+			c.cflags = append(c.cflags, definitions(args)...)
+
+		case "zephyr_compile_options", "zephyr_library_compile_options":
+			c.cflags = append(c.cflags, args...)
+
+		case "add_subdirectory":
+			if len(args) == 0 {
+				continue
+			}
+			sub := args[0]
+			if !filepath.IsAbs(sub) {
+				sub = filepath.Join(dir, sub)
+			}
+
+			vars := make(map[string]string, len(ci.vars))
+			for k, v := range ci.vars {
+				vars[k] = v
+			}
+			subInterp := &cmakeInterp{imp: ci.imp, vars: vars, c: c}
+			if err := subInterp.run(sub); err != nil {
+				return err
+			}
+
+		default:
+			log.Debugf("Ignoring CMake command %s() in %s", cmd.name, path)
+		}
+	}
+
+	return nil
+}
+
+// Reads an imported Zephyr module.  The module's zephyr/module.yml, if
+// present, locates its CMakeLists.txt and Kconfig files; otherwise they are
+// expected in the zephyr directory.  Kconfig defaults can be overridden by
+// the import's "options".
+func (imp *Import) readZephyrModule() (*contents, error) {
+	name := filepath.Base(imp.dir)
+	cmakeDir := "zephyr"
+	kconfig := ""
+
+	if util.NodeExist(imp.dir + "/zephyr/module.yml") {
+		v, err := util.ReadConfig(imp.dir+"/zephyr", "module")
+		if err != nil {
+			return nil, err
+		}
+		if s := v.GetString("name"); s != "" {
+			name = s
+		}
+		if s := v.GetString("build.cmake"); s != "" {
+			cmakeDir = s
+		}
+		kconfig = v.GetString("build.kconfig")
+	}
+	if kconfig == "" {
+		kconfig = cmakeDir + "/Kconfig"
+	}
+
+	cmakeDir = filepath.Join(imp.dir, cmakeDir)
+	if util.NodeNotExist(cmakeDir + "/CMakeLists.txt") {
+		return nil, util.FmtNewtError("no CMakeLists.txt in %s", cmakeDir)
+	}
+
+	c := &contents{
+		desc: fmt.Sprintf("Zephyr module %s", name),
+	}
+
+	syms := map[string]*kconfigSym{}
+	kconfig = filepath.Join(imp.dir, kconfig)
+	if util.NodeExist(kconfig) {
+		if err := readKconfig(kconfig, syms, false); err != nil {
+			return nil, err
+		}
+		c.cfgFiles = append(c.cfgFiles, filepath.ToSlash(kconfig))
+	}
+
+	vals := map[string]string{}
+	for _, sym := range syms {
+		vals[sym.name] = sym.dflt
+	}
+	for opt, itf := range cast.ToStringMap(imp.Settings["options"]) {
+		val := cast.ToString(itf)
+		if b, ok := itf.(bool); ok {
+			val = "n"
+			if b {
+				val = "y"
+			}
+		}
+		vals[strings.TrimPrefix(opt, "CONFIG_")] = val
+	}
+
+	symNames := make([]string, 0, len(vals))
+	for symName, _ := range vals {
+		symNames = append(symNames, symName)
+	}
+	sort.Strings(symNames)
+
+	modVar := strings.ToUpper(
+		regexp.MustCompile(`[^A-Za-z0-9]`).ReplaceAllString(name, "_"))
+	ci := &cmakeInterp{
+		imp: imp,
+		vars: map[string]string{
+			"ZEPHYR_CURRENT_MODULE_DIR":        imp.dir,
+			"ZEPHYR_" + modVar + "_MODULE_DIR": imp.dir,
+			"ZEPHYR_" + modVar + "_CMAKE_DIR":  filepath.ToSlash(cmakeDir),
+			"ZEPHYR_CURRENT_CMAKE_DIR":         filepath.ToSlash(cmakeDir),
+		},
+		c: c,
+	}
+
+	for _, symName := range symNames {
+		typ := ""
+		if sym := syms[symName]; sym != nil {
+			typ = sym.typ
+		}
+
+		if def, ok := kconfigDefine(typ, symName, vals[symName]); ok {
+			c.publicCflags = append(c.publicCflags, def)
+			ci.vars["CONFIG_"+symName] = vals[symName]
+		}
+	}
+
+	if err := ci.run(cmakeDir); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
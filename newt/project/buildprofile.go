@@ -0,0 +1,139 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package project
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newt/viper"
+)
+
+// Compiler build profile that a custom profile builds on unless it specifies
+// one.
+const BUILD_PROFILE_DEFAULT_BASE = "default"
+
+// A build profile defined by the project rather than by the compiler
+// package.  Specified in project.yml:
+//
+//	project.build_profiles:
+//	    size:
+//	        base: optimized
+//	        cflags: [-Os, -flto]
+//	        lflags: [-flto]
+//	        defines: [LOG_LEVEL=3]
+//
+// A target selects the profile with target.build_profile.  The compiler
+// package's flags for the base profile are used, followed by the profile's
+// own flags; each define is passed as a -D flag.
+type BuildProfile struct {
+	Name    string
+	Base    string
+	Cflags  []string
+	Lflags  []string
+	Aflags  []string
+	Defines []string
+}
+
+func readBuildProfiles(v *viper.Viper) (map[string]*BuildProfile, error) {
+	profiles := map[string]*BuildProfile{}
+
+	raw := cast.ToStringMap(v.Get("project.build_profiles"))
+	for name, itf := range raw {
+		entry := cast.ToStringMap(itf)
+
+		p := &BuildProfile{
+			Name:    name,
+			Base:    cast.ToString(entry["base"]),
+			Cflags:  cast.ToStringSlice(entry["cflags"]),
+			Lflags:  cast.ToStringSlice(entry["lflags"]),
+			Aflags:  cast.ToStringSlice(entry["aflags"]),
+			Defines: cast.ToStringSlice(entry["defines"]),
+		}
+		if p.Base == "" {
+			p.Base = BUILD_PROFILE_DEFAULT_BASE
+		}
+
+		// A profile may extend the compiler profile of the same name, but
+		// not another project profile.
+		if _, ok := raw[p.Base]; ok && p.Base != name {
+			return nil, util.FmtNewtError(
+				"project.build_profiles entry %s: base profile %s must be "+
+					"a compiler profile, not a project one", name, p.Base)
+		}
+
+		profiles[name] = p
+	}
+
+	return profiles, nil
+}
+
+// Returns the compiler flags the profile adds: its cflags followed by a -D
+// flag for each define.
+func (p *BuildProfile) AllCflags() []string {
+	cflags := append([]string{}, p.Cflags...)
+	for _, d := range p.Defines {
+		cflags = append(cflags, "-D"+d)
+	}
+	return cflags
+}
+
+type buildProfileSorter struct {
+	profiles []*BuildProfile
+}
+
+func (s buildProfileSorter) Len() int {
+	return len(s.profiles)
+}
+func (s buildProfileSorter) Swap(i, j int) {
+	s.profiles[i], s.profiles[j] = s.profiles[j], s.profiles[i]
+}
+func (s buildProfileSorter) Less(i, j int) bool {
+	return s.profiles[i].Name < s.profiles[j].Name
+}
+
+// Returns the project's build profiles, sorted by name.
+func (proj *Project) BuildProfiles() []*BuildProfile {
+	profiles := make([]*BuildProfile, 0, len(proj.buildProfiles))
+	for _, p := range proj.buildProfiles {
+		profiles = append(profiles, p)
+	}
+	sort.Sort(buildProfileSorter{profiles})
+
+	return profiles
+}
+
+// Returns the project's build profile with the specified name, or nil if
+// the project does not define it (i.e., it is a compiler profile).
+func (proj *Project) BuildProfile(name string) *BuildProfile {
+	return proj.buildProfiles[strings.ToLower(name)]
+}
+
+// Returns the compiler build profile that a target's build profile maps to:
+// a project profile's base, or the profile itself.
+func (proj *Project) CompilerBuildProfile(name string) string {
+	if p := proj.BuildProfile(name); p != nil {
+		return p.Base
+	}
+	return name
+}
@@ -0,0 +1,541 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package project
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/util"
+)
+
+// A bundle is a gzipped tar archive containing everything needed to build a
+// project on a machine without network access:
+//
+//	bundle.json                 manifest
+//	project/                    the project, without its bin directory
+//	project/repos/<name>/       each installed repo, at its locked commit
+//	toolchains/<name>-<vers>/   optionally, the pinned toolchains
+const BUNDLE_MANIFEST_FILENAME = "bundle.json"
+const BUNDLE_PROJECT_DIR = "project"
+const BUNDLE_TOOLCHAINS_DIR = "toolchains"
+
+type BundleRepo struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit"`
+}
+
+type BundleToolchain struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Platform string `json:"platform"`
+}
+
+type BundleManifest struct {
+	NewtVersion string            `json:"newt_version"`
+	Project     string            `json:"project"`
+	Repos       []BundleRepo      `json:"repos"`
+	Toolchains  []BundleToolchain `json:"toolchains"`
+}
+
+// Collects the installed repos that go into a bundle, sorted by name.
+// Vendored repos are part of the project tree and are not listed.  Every
+// repo must be installed at the commit recorded in the lock file.
+func (proj *Project) bundleRepos() ([]*repo.Repo, error) {
+	if mismatches := proj.LockMismatches(); len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return nil, util.FmtNewtError("Repos don't match %s:\n    %s\n"+
+			"Run \"newt install\" to check out the locked commits.",
+			PROJECT_LOCK_FILE, strings.Join(mismatches, "\n    "))
+	}
+
+	if err := proj.CheckIntegrity(); err != nil {
+		return nil, err
+	}
+
+	rnames := make([]string, 0, len(proj.repos))
+	for rname, _ := range proj.repos {
+		rnames = append(rnames, rname)
+	}
+	sort.Strings(rnames)
+
+	repos := []*repo.Repo{}
+	for _, rname := range rnames {
+		r := proj.repos[rname]
+		if r.IsLocal() || r.IsVendored() {
+			continue
+		}
+		if r.IsOverridden() {
+			return nil, util.FmtNewtError(
+				"Repo %s is overridden by a local checkout (%s); remove "+
+					"the override before exporting a bundle", rname, r.Path())
+		}
+		if util.NodeNotExist(r.Path()) {
+			return nil, util.FmtNewtError(
+				"Repo %s is not installed; run \"newt install\" first", rname)
+		}
+
+		if proj.projLock.Commit(rname) == "" {
+			return nil, util.FmtNewtError(
+				"Repo %s is not locked; run \"newt install\" to update %s",
+				rname, PROJECT_LOCK_FILE)
+		}
+
+		repos = append(repos, r)
+	}
+
+	return repos, nil
+}
+
+// Writes archive entries for a directory tree.  Symbolic links are stored as
+// links; skip indicates, by path, entries to leave out.
+func tarTree(tw *tar.Writer, srcDir string, prefix string,
+	skip func(path string) bool) error {
+
+	return filepath.Walk(srcDir, func(p string, info os.FileInfo,
+		err error) error {
+
+		if err != nil {
+			return err
+		}
+		if skip != nil && skip(p) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(srcDir, p)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(prefix, filepath.ToSlash(rel))
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uid = 0
+		hdr.Gid = 0
+		hdr.Uname = ""
+		hdr.Gname = ""
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func tarBytes(tw *tar.Writer, name string, contents []byte) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(contents)),
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(contents)
+	return err
+}
+
+// Writes a bundle of the project and its repos to the specified file.  If
+// withToolchains is true, the installed versions of the project's pinned
+// toolchains are included too; they only work on machines of the same
+// platform.
+func (proj *Project) ExportBundle(outPath string,
+	withToolchains bool) (*BundleManifest, error) {
+
+	repos, err := proj.bundleRepos()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &BundleManifest{
+		NewtVersion: newtutil.NewtVersionStr,
+		Project:     proj.Name(),
+		Repos:       []BundleRepo{},
+		Toolchains:  []BundleToolchain{},
+	}
+
+	for _, r := range repos {
+		br := BundleRepo{
+			Name:   r.Name(),
+			Commit: proj.projLock.Commit(r.Name()),
+		}
+		if vers := proj.projState.GetInstalledVersion(r.Name()); vers != nil {
+			br.Version = vers.String()
+		}
+		m.Repos = append(m.Repos, br)
+	}
+
+	toolchainDirs := []string{}
+	if withToolchains {
+		for _, pin := range proj.ToolchainPins() {
+			dir := ToolchainInstallDir(pin.Name, pin.Version)
+			if util.NodeNotExist(dir) {
+				return nil, util.FmtNewtError(
+					"Toolchain %s %s is not installed; run \"newt "+
+						"toolchain install\" first", pin.Name, pin.Version)
+			}
+
+			toolchainDirs = append(toolchainDirs, dir)
+			m.Toolchains = append(m.Toolchains, BundleToolchain{
+				Name:     pin.Name,
+				Version:  pin.Version,
+				Platform: HostPlatform(),
+			})
+		}
+	}
+
+	absOut, err := filepath.Abs(outPath)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	// Write to a temporary name so that a failed export doesn't leave a
+	// truncated bundle behind.
+	tmpPath := outPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	defer os.Remove(tmpPath)
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	err = func() error {
+		mjson, err := json.MarshalIndent(m, "", "    ")
+		if err != nil {
+			return err
+		}
+		if err := tarBytes(tw, BUNDLE_MANIFEST_FILENAME,
+			append(mjson, '\n')); err != nil {
+
+			return err
+		}
+
+		// Repos are added separately; they may live in a workspace outside
+		// the project.
+		skipDirs := map[string]bool{
+			filepath.Join(proj.Path(), "bin"):          true,
+			filepath.Join(proj.Path(), repo.REPOS_DIR): true,
+			filepath.Clean(absOut):                     true,
+			filepath.Clean(absOut + ".tmp"):            true,
+		}
+		skip := func(p string) bool {
+			abs, err := filepath.Abs(p)
+			return err == nil && skipDirs[abs]
+		}
+
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "Adding project %s\n",
+			proj.Name())
+		if err := tarTree(tw, proj.Path(), BUNDLE_PROJECT_DIR,
+			skip); err != nil {
+
+			return err
+		}
+
+		for _, r := range repos {
+			util.StatusMessage(util.VERBOSITY_DEFAULT, "Adding repo %s\n",
+				r.Name())
+			if err := tarTree(tw, r.Path(), path.Join(BUNDLE_PROJECT_DIR,
+				repo.REPOS_DIR, r.Name()), nil); err != nil {
+
+				return err
+			}
+		}
+
+		for _, dir := range toolchainDirs {
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"Adding toolchain %s\n", filepath.Base(dir))
+			if err := tarTree(tw, dir, path.Join(BUNDLE_TOOLCHAINS_DIR,
+				filepath.Base(dir)), nil); err != nil {
+
+				return err
+			}
+		}
+
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gw.Close()
+	}()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, util.FmtNewtError("Failed to write bundle %s: %s",
+			outPath, err.Error())
+	}
+
+	if err := os.Rename(tmpPath, outPath); err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	return m, nil
+}
+
+// Returns the local path for a bundle entry, or "" if the entry doesn't
+// belong to a section being extracted.  Entries that would escape their
+// section are rejected.
+func bundleEntryPath(name string, projDir string,
+	toolchainDirs map[string]string) (string, error) {
+
+	clean := path.Clean("/" + name)[1:]
+	if clean != strings.TrimSuffix(name, "/") {
+		return "", util.FmtNewtError("Invalid bundle entry: %s", name)
+	}
+
+	parts := strings.SplitN(clean, "/", 3)
+	switch parts[0] {
+	case BUNDLE_PROJECT_DIR:
+		return filepath.Join(projDir,
+			filepath.FromSlash(strings.TrimPrefix(clean,
+				BUNDLE_PROJECT_DIR))), nil
+
+	case BUNDLE_TOOLCHAINS_DIR:
+		if len(parts) < 2 {
+			return "", nil
+		}
+		dir := toolchainDirs[parts[1]]
+		if dir == "" {
+			return "", nil
+		}
+		rest := ""
+		if len(parts) == 3 {
+			rest = parts[2]
+		}
+		return filepath.Join(dir, filepath.FromSlash(rest)), nil
+
+	default:
+		return "", nil
+	}
+}
+
+func readBundleManifest(tr *tar.Reader) (*BundleManifest, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name != BUNDLE_MANIFEST_FILENAME {
+		return nil, util.FmtNewtError("not a newt bundle; first entry is "+
+			"%s rather than %s", hdr.Name, BUNDLE_MANIFEST_FILENAME)
+	}
+
+	b, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &BundleManifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, util.FmtNewtError("invalid %s: %s",
+			BUNDLE_MANIFEST_FILENAME, err.Error())
+	}
+
+	return m, nil
+}
+
+// Reads the manifest of the specified bundle.
+func ReadBundleManifest(bundlePath string) (*BundleManifest, error) {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, util.FmtNewtError("Failed to read bundle %s: %s",
+			bundlePath, err.Error())
+	}
+
+	m, err := readBundleManifest(tar.NewReader(gr))
+	if err != nil {
+		return nil, util.FmtNewtError("Failed to read bundle %s: %s",
+			bundlePath, err.Error())
+	}
+
+	return m, nil
+}
+
+type bundleLink struct {
+	path   string
+	target string
+}
+
+// Extracts a bundle's project to dstDir, which must not exist or be empty.
+// Bundled toolchains are installed to the toolchains directory unless the
+// same version is already installed or they were built for a different
+// platform.
+func ImportBundle(bundlePath string, dstDir string) (*BundleManifest, error) {
+	if infos, err := ioutil.ReadDir(dstDir); err == nil && len(infos) > 0 {
+		return nil, util.FmtNewtError("Destination %s is not empty", dstDir)
+	}
+
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, util.FmtNewtError("Failed to read bundle %s: %s",
+			bundlePath, err.Error())
+	}
+	tr := tar.NewReader(gr)
+
+	m, err := readBundleManifest(tr)
+	if err != nil {
+		return nil, util.FmtNewtError("Failed to read bundle %s: %s",
+			bundlePath, err.Error())
+	}
+
+	toolchainDirs := map[string]string{}
+	for _, tc := range m.Toolchains {
+		name := tc.Name + "-" + tc.Version
+		dir := ToolchainInstallDir(tc.Name, tc.Version)
+		switch {
+		case tc.Platform != HostPlatform():
+			util.StatusMessage(util.VERBOSITY_QUIET,
+				"* Warning: not installing toolchain %s; it is for %s, "+
+					"not %s\n", name, tc.Platform, HostPlatform())
+		case util.NodeExist(dir):
+			util.StatusMessage(util.VERBOSITY_DEFAULT,
+				"Toolchain %s is already installed\n", name)
+		default:
+			toolchainDirs[name] = dir
+		}
+	}
+
+	// Links are created last so that no file is written through a link
+	// that points outside the destination.
+	links := []bundleLink{}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, util.FmtNewtError("Failed to read bundle %s: %s",
+				bundlePath, err.Error())
+		}
+
+		p, err := bundleEntryPath(hdr.Name, dstDir, toolchainDirs)
+		if err != nil {
+			return nil, err
+		}
+		if p == "" {
+			log.Debugf("Skipping bundle entry %s", hdr.Name)
+			continue
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, mode|0700); err != nil {
+				return nil, util.ChildNewtError(err)
+			}
+
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return nil, util.ChildNewtError(err)
+			}
+			out, err := os.OpenFile(p,
+				os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+			if err != nil {
+				return nil, util.ChildNewtError(err)
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return nil, util.FmtNewtError(
+					"Failed to extract %s: %s", hdr.Name, err.Error())
+			}
+
+		case tar.TypeSymlink:
+			links = append(links, bundleLink{p, hdr.Linkname})
+
+		default:
+			log.Debugf("Skipping bundle entry %s of type %c", hdr.Name,
+				hdr.Typeflag)
+		}
+	}
+
+	for _, l := range links {
+		if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+		if err := os.Symlink(l.target, l.path); err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+	}
+
+	if ws := findWorkspace(dstDir); ws != "" {
+		util.StatusMessage(util.VERBOSITY_QUIET,
+			"* Warning: %s is inside workspace %s; the project will use "+
+				"the workspace's repos rather than the bundled ones\n",
+			dstDir, ws)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,146 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package project
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/util"
+)
+
+// Checks that the commit checked out for a newly installed repo is the one
+// that repository.yml records for the installed version.  A mismatch means
+// the version's tag has been moved, e.g., by a force push.
+func (proj *Project) verifyInstalledCommit(r *repo.Repo,
+	vers *repo.Version) error {
+
+	rdesc, err := r.GetRepoDesc()
+	if err != nil {
+		return err
+	}
+
+	expected := rdesc.ExpectedCommit(vers)
+	if expected == "" {
+		return nil
+	}
+
+	commit, err := r.HeadCommit()
+	if err != nil {
+		return err
+	}
+
+	if commit == expected {
+		return nil
+	}
+
+	return proj.integrityFailure([]string{fmt.Sprintf(
+		"repository %s version %s is at commit %s; %s records %s",
+		r.Name(), vers.String(), commit, repo.REPO_FILE_NAME, expected)})
+}
+
+// Returns a description of each integrity problem with the installed repos:
+// local modifications, a commit other than the one recorded for the
+// installed version, or contents that don't match the tree hash in the lock
+// file.
+func (proj *Project) IntegrityProblems() []string {
+	problems := []string{}
+
+	for rname, r := range proj.repos {
+		if r.IsLocal() || r.IsVendored() || r.IsOverridden() ||
+			util.NodeNotExist(r.Path()) {
+
+			continue
+		}
+
+		dirty, err := r.HasLocalChanges()
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if dirty {
+			problems = append(problems, fmt.Sprintf(
+				"repository %s has local modifications", rname))
+		}
+
+		commit, err := r.HeadCommit()
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+
+		lockCommit := proj.projLock.Commit(rname)
+
+		// The lock file takes precedence over repository.yml; only check the
+		// recorded commit if the lock doesn't explain the current one.
+		if rdesc, err := r.GetRepoDesc(); err == nil && lockCommit != commit {
+			vers := proj.projState.GetInstalledVersion(rname)
+			expected := rdesc.ExpectedCommit(vers)
+			if expected != "" && expected != commit {
+				problems = append(problems, fmt.Sprintf(
+					"repository %s version %s is at commit %s; %s "+
+						"records %s", rname, vers.String(), commit,
+					repo.REPO_FILE_NAME, expected))
+			}
+		}
+
+		lockTree := proj.projLock.Tree(rname)
+		if lockTree != "" && lockCommit == commit {
+			tree, err := r.TreeHash()
+			if err != nil {
+				problems = append(problems, err.Error())
+			} else if tree != "" && tree != lockTree {
+				problems = append(problems, fmt.Sprintf(
+					"repository %s has tree %s; %s records %s",
+					rname, tree, PROJECT_LOCK_FILE, lockTree))
+			}
+		}
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// Reports integrity problems as an error, or as warnings if the user allowed
+// dirty repos.
+func (proj *Project) integrityFailure(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+
+	if newtutil.NewtAllowDirtyRepos {
+		for _, p := range problems {
+			util.StatusMessage(util.VERBOSITY_QUIET, "* Warning: %s\n", p)
+		}
+		return nil
+	}
+
+	return util.FmtNewtError("Repository integrity check failed:\n    %s\n"+
+		"Use --allow-dirty-repos to proceed anyway.",
+		strings.Join(problems, "\n    "))
+}
+
+// Verifies the integrity of all installed repos.  Fails unless the user
+// allowed dirty repos.
+func (proj *Project) CheckIntegrity() error {
+	return proj.integrityFailure(proj.IntegrityProblems())
+}
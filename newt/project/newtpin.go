@@ -0,0 +1,230 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package project
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/downloader"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+// Directory, inside the project's .newt directory, that "newt self update"
+// installs the project's newt version to.
+const NEWT_BIN_DIR = "bin"
+
+// Set in the environment of a pinned newt that another newt runs on its
+// behalf, so that the pinned one doesn't look for a pin again.
+const NEWT_PINNED_ENV = "NEWT_PINNED"
+
+// The newt version a project requires.  Specified in project.yml, either as
+// a bare version:
+//
+//	project.newt_version: "1.12.0"
+//
+// or with the release binaries newt can install on each host platform:
+//
+//	project.newt_version:
+//	    version: "1.12.0"
+//	    archives:
+//	        linux-amd64:
+//	            url: "https://example.com/newt_1.12.0_linux_amd64.tgz"
+//	            sha256: "<hex digest>"
+//
+// An archive URL may name the newt executable itself, or a .tgz, .tar.gz or
+// .zip file containing it.
+type NewtPin struct {
+	Version  string
+	Archives map[string]ToolchainArchive
+
+	// Whether project.yml requires offline operation (project.offline), in
+	// which case the pinned newt can't be downloaded.
+	Offline bool
+}
+
+// Reads the newt version pinned by the specified project's project.yml.
+// Returns nil if the project doesn't pin one.  Like ReadProjectCommands(),
+// this doesn't load the rest of the project.
+func ReadNewtPin(projDir string) (*NewtPin, error) {
+	v, err := util.ReadConfig(projDir,
+		strings.TrimSuffix(PROJECT_FILE_NAME, ".yml"))
+	if err != nil {
+		return nil, err
+	}
+
+	itf := v.Get("project.newt_version")
+	if itf == nil {
+		return nil, nil
+	}
+
+	pin := &NewtPin{
+		Archives: map[string]ToolchainArchive{},
+		Offline:  v.GetBool("project.offline"),
+	}
+
+	entry, err := cast.ToStringMapE(itf)
+	if err != nil {
+		pin.Version = cast.ToString(itf)
+	} else {
+		pin.Version = cast.ToString(entry["version"])
+		for platform, aitf := range cast.ToStringMap(entry["archives"]) {
+			a := cast.ToStringMapString(aitf)
+			if a["url"] == "" || a["sha256"] == "" {
+				return nil, util.FmtNewtError(
+					"project.newt_version: archive for %s must specify "+
+						"\"url\" and \"sha256\"", platform)
+			}
+			pin.Archives[platform] = ToolchainArchive{
+				Url:    a["url"],
+				Sha256: a["sha256"],
+			}
+		}
+	}
+
+	if _, err := newtutil.ParseVersion(pin.Version); err != nil {
+		return nil, util.FmtNewtError(
+			"project.newt_version: invalid version \"%s\"", pin.Version)
+	}
+
+	return pin, nil
+}
+
+// Indicates whether the running newt is the pinned version.
+func (pin *NewtPin) Matches() bool {
+	vers, err := newtutil.ParseVersion(pin.Version)
+	return err == nil && vers == newtutil.NewtVersion
+}
+
+// Describes where to get the pinned newt for this machine, or returns false
+// if the project doesn't provide a binary for it.
+func (pin *NewtPin) HostArchive() (ToolchainArchive, bool) {
+	a, ok := pin.Archives[HostPlatform()]
+	return a, ok
+}
+
+// Returns the path the pinned newt version is installed to.
+func NewtPinPath(projDir string, version string) string {
+	name := "newt-" + version
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	return filepath.Join(projDir, newtutil.NEWTRC_DIR, NEWT_BIN_DIR, name)
+}
+
+func isNewtArchive(url string) bool {
+	url = strings.ToLower(url)
+	for _, ext := range []string{".tgz", ".tar.gz", ".tar.xz", ".zip"} {
+		if strings.HasSuffix(url, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Finds the newt executable in an extracted release archive.
+func findNewtExe(dir string) string {
+	name := "newt"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	found := ""
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && found == "" && !info.IsDir() &&
+			info.Name() == name {
+
+			found = path
+		}
+		return nil
+	})
+
+	return found
+}
+
+// Downloads and verifies the pinned newt version and installs it into the
+// project.  Returns the path of the installed executable.
+func InstallNewtPin(projDir string, pin *NewtPin) (string, error) {
+	archive, ok := pin.HostArchive()
+	if !ok {
+		return "", util.FmtNewtError(
+			"project.newt_version has no archive for %s", HostPlatform())
+	}
+
+	ad := downloader.NewArchiveDownloader()
+	ad.Url = archive.Url
+	ad.Sha256 = archive.Sha256
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Downloading newt %s\n",
+		pin.Version)
+	srcPath, err := ad.Fetch()
+	if err != nil {
+		return "", err
+	}
+
+	dst := NewtPinPath(projDir, pin.Version)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	// Stage next to the final location so that the rename can't cross file
+	// systems, and a partial install is never used.
+	tmpDir, err := ioutil.TempDir(filepath.Dir(dst), ".install-")
+	if err != nil {
+		return "", util.ChildNewtError(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if isNewtArchive(archive.Url) {
+		if err := extractToolchain(srcPath, archive.Url, tmpDir); err != nil {
+			return "", err
+		}
+		srcPath = findNewtExe(tmpDir)
+		if srcPath == "" {
+			return "", util.FmtNewtError("%s does not contain a newt "+
+				"executable", archive.Url)
+		}
+	}
+
+	tmpExe := filepath.Join(tmpDir, filepath.Base(dst))
+	if srcPath != tmpExe {
+		if err := util.CopyFile(srcPath, tmpExe); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Chmod(tmpExe, 0755); err != nil {
+		return "", util.ChildNewtError(err)
+	}
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return "", util.ChildNewtError(err)
+	}
+	if err := os.Rename(tmpExe, dst); err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	return dst, nil
+}
@@ -0,0 +1,92 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package project
+
+import (
+	"sort"
+
+	"mynewt.apache.org/newt/newt/repo"
+)
+
+// Describes how an installed repo compares to the versions available
+// remotely.
+type RepoFreshness struct {
+	Name         string `json:"name"`
+	Requirement  string `json:"requirement"`
+	Installed    string `json:"installed"`
+	Compatible   string `json:"newest_compatible"`
+	Newest       string `json:"newest"`
+	UpToDate     bool   `json:"up_to_date"`
+	ErrorMessage string `json:"error,omitempty"`
+}
+
+func versString(vers *repo.Version) string {
+	if vers == nil {
+		return ""
+	}
+	return vers.String()
+}
+
+func (proj *Project) repoFreshness(r *repo.Repo) RepoFreshness {
+	rf := RepoFreshness{
+		Name:        r.Name(),
+		Requirement: r.VersionRequirementsString(),
+		Installed:   versString(proj.projState.GetInstalledVersion(r.Name())),
+	}
+
+	// Always fetch the latest description from the remote.
+	if err := r.DownloadDesc(); err != nil {
+		rf.ErrorMessage = err.Error()
+		return rf
+	}
+	rdesc, _, err := r.ReadDesc()
+	if err != nil {
+		rf.ErrorMessage = err.Error()
+		return rf
+	}
+
+	compat := rdesc.NewestSatisfying(r.VersionRequirements())
+	newest := rdesc.NewestVersion()
+
+	rf.Compatible = versString(compat)
+	rf.Newest = versString(newest)
+	rf.UpToDate = rf.Installed != "" && rf.Installed == rf.Newest
+
+	return rf
+}
+
+// Checks each repo in the project against its remote.  Repos whose
+// description cannot be retrieved are reported with an error message rather
+// than aborting the whole check.
+func (proj *Project) Outdated() []RepoFreshness {
+	rnames := []string{}
+	for rname, r := range proj.repos {
+		if !r.IsLocal() && !r.IsVendored() && !r.IsOverridden() {
+			rnames = append(rnames, rname)
+		}
+	}
+	sort.Strings(rnames)
+
+	rfs := make([]RepoFreshness, 0, len(rnames))
+	for _, rname := range rnames {
+		rfs = append(rfs, proj.repoFreshness(proj.repos[rname]))
+	}
+
+	return rfs
+}
@@ -0,0 +1,169 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package project
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/interfaces"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+// A repo declared in a package's pkg.repositories map rather than in
+// project.yml.
+type pkgRepo struct {
+	vars map[string]string
+
+	// Name of the first package to declare the repo.
+	declarer string
+
+	// Paths of the repo's packages that the project depends on.
+	paths map[string]struct{}
+}
+
+// Returns every dependency string listed in a package's pkg.deps settings,
+// regardless of the syscfg settings that condition them.
+func allPkgDeps(lpkg *pkg.LocalPackage) []string {
+	deps := []string{}
+	for _, key := range lpkg.PkgV.AllKeys() {
+		if key == "pkg.deps" || strings.HasPrefix(key, "pkg.deps.") {
+			deps = append(deps, cast.ToStringSlice(lpkg.PkgV.Get(key))...)
+		}
+	}
+
+	return deps
+}
+
+func mapsEqual(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Loads the repos declared by the local repo's packages.  A package can
+// depend on a package in a remote repo without the whole repo being listed in
+// project.yml:
+//
+//	pkg.deps:
+//	    - "@acme-utils/util/crc"
+//	pkg.repositories:
+//	    acme-utils:
+//	        type: github
+//	        user: acme
+//	        repo: newt-utils
+//	        vers: 1.2.0
+//
+// Only the depended-on packages are checked out (sparse checkout).  A repo
+// defined in project.yml takes precedence over a package's declaration.
+func (proj *Project) loadPkgRepos(
+	pkgMap *map[string]interfaces.PackageInterface) error {
+
+	names := make([]string, 0, len(*pkgMap))
+	for name, _ := range *pkgMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pkgRepos := map[string]*pkgRepo{}
+	rnames := []string{}
+
+	for _, name := range names {
+		lpkg := (*pkgMap)[name].(*pkg.LocalPackage)
+
+		repoMap := lpkg.PkgV.GetStringMap("pkg.repositories")
+		for rname, itf := range repoMap {
+			if proj.repos[rname] != nil {
+				continue
+			}
+
+			vars := cast.ToStringMapString(itf)
+			pr := pkgRepos[rname]
+			if pr == nil {
+				pr = &pkgRepo{
+					vars:     vars,
+					declarer: lpkg.FullName(),
+					paths:    map[string]struct{}{},
+				}
+				pkgRepos[rname] = pr
+				rnames = append(rnames, rname)
+			} else if !mapsEqual(pr.vars, vars) {
+				return util.FmtNewtError(
+					"Conflicting definitions of repository %s in packages "+
+						"%s and %s", rname, pr.declarer, lpkg.FullName())
+			}
+		}
+	}
+
+	if len(pkgRepos) == 0 {
+		return nil
+	}
+
+	// Determine which packages of each declared repo are needed.
+	for _, name := range names {
+		lpkg := (*pkgMap)[name].(*pkg.LocalPackage)
+		for _, dep := range allPkgDeps(lpkg) {
+			rname, pkgName, err := newtutil.ParsePackageString(dep)
+			if err != nil {
+				return err
+			}
+			if pr := pkgRepos[rname]; pr != nil {
+				pr.paths[pkgName] = struct{}{}
+			}
+		}
+	}
+
+	sort.Strings(rnames)
+	for _, rname := range rnames {
+		pr := pkgRepos[rname]
+
+		repoVars := map[string]string{}
+		for k, v := range pr.vars {
+			repoVars[k] = v
+		}
+
+		// Packages listed explicitly in the declaration are checked out in
+		// addition to the depended-on ones.  This is needed for packages
+		// that other packages in the same repo depend on.
+		paths := strings.FieldsFunc(repoVars["sparse"], func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+		for p, _ := range pr.paths {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		repoVars["sparse"] = strings.Join(paths, ",")
+
+		if err := proj.loadRepoVars(rname, repoVars); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,777 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package project
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// A package template built into newt.  Unlike the downloaded templates,
+// these take parameters and are rendered with text/template.  In addition to
+// the template's own variables, every template can refer to:
+//
+//	name:  The full name of the new package (e.g., hw/drivers/foo).
+//	base:  The last element of the package name (e.g., foo).
+//	ident: A C identifier derived from the package name (e.g., foo).
+//	IDENT: ident in upper case; used as a prefix for syscfg settings.
+type pkgTemplate struct {
+	desc  string
+	vars  map[string]templateVar
+	files map[string]string
+}
+
+const pkgTemplateHeader = `#ifndef H_{{.IDENT}}_
+#define H_{{.IDENT}}_
+
+#include <inttypes.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+`
+
+const pkgTemplateFooter = `
+#ifdef __cplusplus
+}
+#endif
+
+#endif
+`
+
+const pkgTemplateDesc = `pkg.name: "{{.name}}"
+pkg.description: "%s"
+pkg.author: "Apache Mynewt <dev@mynewt.apache.org>"
+pkg.homepage: "http://mynewt.apache.org/"
+pkg.keywords:
+`
+
+const pkgTemplateBusSyscfg = `{{- if eq .bus "i2c"}}
+    {{.IDENT}}_I2C_NUM:
+        description: 'I2C interface the device is connected to.'
+        value: 0
+    {{.IDENT}}_I2C_ADDR:
+        description: 'I2C address of the device.'
+        value: 0
+{{- else if eq .bus "spi"}}
+    {{.IDENT}}_SPI_NUM:
+        description: 'SPI interface the device is connected to.'
+        value: 0
+    {{.IDENT}}_SPI_CS_PIN:
+        description: 'GPIO pin used as the device''s chip select.'
+        value: -1
+    {{.IDENT}}_SPI_BAUDRATE:
+        description: 'SPI clock rate, in kHz.'
+        value: 1000
+{{- else}}
+    {{.IDENT}}_UART_NUM:
+        description: 'UART the device is connected to.'
+        value: 0
+    {{.IDENT}}_UART_BAUD:
+        description: 'UART baud rate.'
+        value: 115200
+{{- end}}
+`
+
+var pkgTemplates = map[string]*pkgTemplate{
+	"driver": &pkgTemplate{
+		desc: "Device driver",
+		vars: map[string]templateVar{
+			"bus": templateVar{
+				name:    "bus",
+				dflt:    "i2c",
+				desc:    "bus the device is attached to",
+				choices: []string{"i2c", "spi", "uart"},
+			},
+		},
+		files: map[string]string{
+			"pkg.yml": fmt.Sprintf(pkgTemplateDesc,
+				"Driver for {{.base}} ({{.bus}}).") + `    - {{.bus}}
+
+pkg.deps:
+    - "@apache-mynewt-core/kernel/os"
+    - "@apache-mynewt-core/hw/hal"
+
+pkg.init:
+    {{.ident}}_pkg_init: 500
+`,
+			"syscfg.yml": "syscfg.defs:" + pkgTemplateBusSyscfg,
+			"include/{{.base}}/{{.base}}.h": pkgTemplateHeader + `
+{{- if eq .bus "uart"}}
+int {{.ident}}_send(const uint8_t *buf, int len);
+{{- else}}
+int {{.ident}}_read(uint8_t reg, uint8_t *buf, int len);
+int {{.ident}}_write(uint8_t reg, const uint8_t *buf, int len);
+{{- end}}
+void {{.ident}}_pkg_init(void);
+` + pkgTemplateFooter,
+			"src/{{.base}}.c": `#include <assert.h>
+#include <string.h>
+#include "sysinit/sysinit.h"
+#include "syscfg/syscfg.h"
+#include "os/os.h"
+{{- if eq .bus "i2c"}}
+#include "hal/hal_i2c.h"
+{{- else if eq .bus "spi"}}
+#include "hal/hal_gpio.h"
+#include "hal/hal_spi.h"
+{{- else}}
+#include "hal/hal_uart.h"
+{{- end}}
+#include "{{.base}}/{{.base}}.h"
+{{if eq .bus "i2c"}}
+#define {{.IDENT}}_TIMEOUT      (OS_TICKS_PER_SEC / 10)
+#define {{.IDENT}}_MAX_WRITE    16
+
+int
+{{.ident}}_read(uint8_t reg, uint8_t *buf, int len)
+{
+    struct hal_i2c_master_data data;
+    int rc;
+
+    data.address = MYNEWT_VAL({{.IDENT}}_I2C_ADDR);
+    data.len = 1;
+    data.buffer = &reg;
+
+    rc = hal_i2c_master_write(MYNEWT_VAL({{.IDENT}}_I2C_NUM), &data,
+                              {{.IDENT}}_TIMEOUT, 0);
+    if (rc != 0) {
+        return rc;
+    }
+
+    data.len = len;
+    data.buffer = buf;
+
+    return hal_i2c_master_read(MYNEWT_VAL({{.IDENT}}_I2C_NUM), &data,
+                               {{.IDENT}}_TIMEOUT, 1);
+}
+
+int
+{{.ident}}_write(uint8_t reg, const uint8_t *buf, int len)
+{
+    struct hal_i2c_master_data data;
+    uint8_t payload[{{.IDENT}}_MAX_WRITE + 1];
+
+    if (len > {{.IDENT}}_MAX_WRITE) {
+        return SYS_EINVAL;
+    }
+
+    payload[0] = reg;
+    memcpy(payload + 1, buf, len);
+
+    data.address = MYNEWT_VAL({{.IDENT}}_I2C_ADDR);
+    data.len = len + 1;
+    data.buffer = payload;
+
+    return hal_i2c_master_write(MYNEWT_VAL({{.IDENT}}_I2C_NUM), &data,
+                                {{.IDENT}}_TIMEOUT, 1);
+}
+
+void
+{{.ident}}_pkg_init(void)
+{
+    /* Ensure this function only gets called by sysinit. */
+    SYSINIT_ASSERT_ACTIVE();
+
+    /* TODO: Probe and configure the device. */
+}
+{{- else if eq .bus "spi"}}
+/* Set in the register address of a read transaction. */
+#define {{.IDENT}}_SPI_READ     0x80
+
+static int
+{{.ident}}_xfer(uint8_t reg, uint8_t *buf, int len, int read)
+{
+    int i;
+
+    hal_gpio_write(MYNEWT_VAL({{.IDENT}}_SPI_CS_PIN), 0);
+
+    hal_spi_tx_val(MYNEWT_VAL({{.IDENT}}_SPI_NUM),
+                   read ? reg | {{.IDENT}}_SPI_READ : reg);
+    for (i = 0; i < len; i++) {
+        if (read) {
+            buf[i] = hal_spi_tx_val(MYNEWT_VAL({{.IDENT}}_SPI_NUM), 0);
+        } else {
+            hal_spi_tx_val(MYNEWT_VAL({{.IDENT}}_SPI_NUM), buf[i]);
+        }
+    }
+
+    hal_gpio_write(MYNEWT_VAL({{.IDENT}}_SPI_CS_PIN), 1);
+
+    return 0;
+}
+
+int
+{{.ident}}_read(uint8_t reg, uint8_t *buf, int len)
+{
+    return {{.ident}}_xfer(reg, buf, len, 1);
+}
+
+int
+{{.ident}}_write(uint8_t reg, const uint8_t *buf, int len)
+{
+    return {{.ident}}_xfer(reg, (uint8_t *)buf, len, 0);
+}
+
+void
+{{.ident}}_pkg_init(void)
+{
+    struct hal_spi_settings settings;
+    int rc;
+
+    /* Ensure this function only gets called by sysinit. */
+    SYSINIT_ASSERT_ACTIVE();
+
+    settings.data_order = HAL_SPI_MSB_FIRST;
+    settings.data_mode = HAL_SPI_MODE0;
+    settings.baudrate = MYNEWT_VAL({{.IDENT}}_SPI_BAUDRATE);
+    settings.word_size = HAL_SPI_WORD_SIZE_8BIT;
+
+    rc = hal_gpio_init_out(MYNEWT_VAL({{.IDENT}}_SPI_CS_PIN), 1);
+    SYSINIT_PANIC_ASSERT(rc == 0);
+
+    rc = hal_spi_config(MYNEWT_VAL({{.IDENT}}_SPI_NUM), &settings);
+    SYSINIT_PANIC_ASSERT(rc == 0);
+
+    rc = hal_spi_enable(MYNEWT_VAL({{.IDENT}}_SPI_NUM));
+    SYSINIT_PANIC_ASSERT(rc == 0);
+
+    /* TODO: Probe and configure the device. */
+}
+{{- else}}
+static int
+{{.ident}}_tx_char(void *arg)
+{
+    /* Only blocking transmits are used; nothing is ever queued. */
+    return -1;
+}
+
+static int
+{{.ident}}_rx_char(void *arg, uint8_t byte)
+{
+    /* TODO: Handle the received byte. */
+    return 0;
+}
+
+int
+{{.ident}}_send(const uint8_t *buf, int len)
+{
+    int i;
+
+    for (i = 0; i < len; i++) {
+        hal_uart_blocking_tx(MYNEWT_VAL({{.IDENT}}_UART_NUM), buf[i]);
+    }
+
+    return 0;
+}
+
+void
+{{.ident}}_pkg_init(void)
+{
+    int rc;
+
+    /* Ensure this function only gets called by sysinit. */
+    SYSINIT_ASSERT_ACTIVE();
+
+    rc = hal_uart_init_cbs(MYNEWT_VAL({{.IDENT}}_UART_NUM),
+                           {{.ident}}_tx_char, NULL,
+                           {{.ident}}_rx_char, NULL);
+    SYSINIT_PANIC_ASSERT(rc == 0);
+
+    rc = hal_uart_config(MYNEWT_VAL({{.IDENT}}_UART_NUM),
+                         MYNEWT_VAL({{.IDENT}}_UART_BAUD), 8, 1,
+                         HAL_UART_PARITY_NONE, HAL_UART_FLOW_CTL_NONE);
+    SYSINIT_PANIC_ASSERT(rc == 0);
+}
+{{- end}}
+`,
+		},
+	},
+
+	"sensor": &pkgTemplate{
+		desc: "Sensor driver implementing the sensor API",
+		vars: map[string]templateVar{
+			"bus": templateVar{
+				name:    "bus",
+				dflt:    "i2c",
+				desc:    "bus the sensor is attached to",
+				choices: []string{"i2c", "spi"},
+			},
+		},
+		files: map[string]string{
+			"pkg.yml": fmt.Sprintf(pkgTemplateDesc,
+				"Sensor driver for {{.base}}.") + `    - sensor
+    - {{.bus}}
+
+pkg.deps:
+    - "@apache-mynewt-core/kernel/os"
+    - "@apache-mynewt-core/hw/hal"
+    - "@apache-mynewt-core/hw/sensor"
+`,
+			"syscfg.yml": "syscfg.defs:" + pkgTemplateBusSyscfg,
+			"include/{{.base}}/{{.base}}.h": pkgTemplateHeader + `
+#include "os/os.h"
+#include "sensor/sensor.h"
+
+struct {{.ident}} {
+    struct os_dev dev;
+    struct sensor sensor;
+};
+
+/**
+ * Initializes the sensor device.  Passed to os_dev_create() along with a
+ * struct sensor_itf describing the {{.bus}} interface.
+ */
+int {{.ident}}_init(struct os_dev *dev, void *arg);
+` + pkgTemplateFooter,
+			"src/{{.base}}.c": `#include <assert.h>
+#include <string.h>
+#include "os/os.h"
+#include "sysinit/sysinit.h"
+#include "syscfg/syscfg.h"
+{{- if eq .bus "i2c"}}
+#include "hal/hal_i2c.h"
+{{- else}}
+#include "hal/hal_gpio.h"
+#include "hal/hal_spi.h"
+{{- end}}
+#include "sensor/sensor.h"
+#include "sensor/temperature.h"
+#include "{{.base}}/{{.base}}.h"
+
+static int {{.ident}}_sensor_read(struct sensor *, sensor_type_t,
+                                  sensor_data_func_t, void *, uint32_t);
+static int {{.ident}}_sensor_get_config(struct sensor *, sensor_type_t,
+                                        struct sensor_cfg *);
+
+static const struct sensor_driver g_{{.ident}}_sensor_driver = {
+    .sd_read = {{.ident}}_sensor_read,
+    .sd_get_config = {{.ident}}_sensor_get_config,
+};
+
+static int
+{{.ident}}_read_temp(struct sensor_itf *itf, float *temp)
+{
+    /* TODO: Read the measurement over {{.bus}} and convert it. */
+    *temp = 0.0f;
+    return 0;
+}
+
+static int
+{{.ident}}_sensor_read(struct sensor *sensor, sensor_type_t type,
+                       sensor_data_func_t data_func, void *data_arg,
+                       uint32_t timeout)
+{
+    struct sensor_temp_data std;
+    struct sensor_itf *itf;
+    int rc;
+
+    if (!(type & SENSOR_TYPE_TEMPERATURE)) {
+        return SYS_EINVAL;
+    }
+
+    itf = SENSOR_GET_ITF(sensor);
+
+    rc = {{.ident}}_read_temp(itf, &std.std_temp);
+    if (rc != 0) {
+        return rc;
+    }
+    std.std_temp_is_valid = 1;
+
+    return data_func(sensor, data_arg, &std, SENSOR_TYPE_TEMPERATURE);
+}
+
+static int
+{{.ident}}_sensor_get_config(struct sensor *sensor, sensor_type_t type,
+                             struct sensor_cfg *cfg)
+{
+    if (!(type & SENSOR_TYPE_TEMPERATURE)) {
+        return SYS_EINVAL;
+    }
+
+    cfg->sc_valtype = SENSOR_VALUE_TYPE_FLOAT;
+
+    return 0;
+}
+
+int
+{{.ident}}_init(struct os_dev *dev, void *arg)
+{
+    struct {{.ident}} *{{.ident}};
+    struct sensor *sensor;
+    int rc;
+
+    if (dev == NULL || arg == NULL) {
+        return SYS_ENODEV;
+    }
+
+    {{.ident}} = (struct {{.ident}} *)dev;
+    sensor = &{{.ident}}->sensor;
+
+    rc = sensor_init(sensor, dev);
+    if (rc != 0) {
+        return rc;
+    }
+
+    rc = sensor_set_driver(sensor, SENSOR_TYPE_TEMPERATURE,
+                           (struct sensor_driver *)&g_{{.ident}}_sensor_driver);
+    if (rc != 0) {
+        return rc;
+    }
+
+    rc = sensor_set_interface(sensor, arg);
+    if (rc != 0) {
+        return rc;
+    }
+
+    return sensor_mgr_register(sensor);
+}
+`,
+		},
+	},
+
+	"ble-svc": &pkgTemplate{
+		desc: "BLE GATT service",
+		files: map[string]string{
+			"pkg.yml": fmt.Sprintf(pkgTemplateDesc,
+				"BLE GATT service {{.base}}.") + `    - ble
+    - bluetooth
+
+pkg.deps:
+    - "@apache-mynewt-nimble/nimble/host"
+
+pkg.init:
+    {{.ident}}_init: 303
+`,
+			"syscfg.yml": `syscfg.defs:
+    {{.IDENT}}_NOTIFY:
+        description: 'Allow the characteristic to be notified.'
+        value: 1
+`,
+			"include/{{.base}}/{{.base}}.h": pkgTemplateHeader + `
+/* 128-bit UUIDs of the service and its characteristic. */
+#define {{.IDENT}}_SVC_UUID \
+    BLE_UUID128_INIT({{.svc_uuid}})
+#define {{.IDENT}}_CHR_UUID \
+    BLE_UUID128_INIT({{.chr_uuid}})
+
+void {{.ident}}_init(void);
+` + pkgTemplateFooter,
+			"src/{{.base}}.c": `#include <assert.h>
+#include <string.h>
+#include "sysinit/sysinit.h"
+#include "syscfg/syscfg.h"
+#include "host/ble_hs.h"
+#include "{{.base}}/{{.base}}.h"
+
+static const ble_uuid128_t {{.ident}}_svc_uuid = {{.IDENT}}_SVC_UUID;
+static const ble_uuid128_t {{.ident}}_chr_uuid = {{.IDENT}}_CHR_UUID;
+
+static uint16_t {{.ident}}_chr_val_handle;
+static uint8_t {{.ident}}_chr_val;
+
+static int {{.ident}}_access(uint16_t conn_handle, uint16_t attr_handle,
+                             struct ble_gatt_access_ctxt *ctxt, void *arg);
+
+static const struct ble_gatt_svc_def {{.ident}}_defs[] = {
+    {
+        .type = BLE_GATT_SVC_TYPE_PRIMARY,
+        .uuid = &{{.ident}}_svc_uuid.u,
+        .characteristics = (struct ble_gatt_chr_def[]) { {
+            .uuid = &{{.ident}}_chr_uuid.u,
+            .access_cb = {{.ident}}_access,
+            .val_handle = &{{.ident}}_chr_val_handle,
+            .flags = BLE_GATT_CHR_F_READ | BLE_GATT_CHR_F_WRITE
+#if MYNEWT_VAL({{.IDENT}}_NOTIFY)
+                     | BLE_GATT_CHR_F_NOTIFY
+#endif
+                     ,
+        }, {
+            0, /* No more characteristics in this service. */
+        } },
+    },
+
+    {
+        0, /* No more services. */
+    },
+};
+
+static int
+{{.ident}}_access(uint16_t conn_handle, uint16_t attr_handle,
+                  struct ble_gatt_access_ctxt *ctxt, void *arg)
+{
+    int rc;
+
+    switch (ctxt->op) {
+    case BLE_GATT_ACCESS_OP_READ_CHR:
+        rc = os_mbuf_append(ctxt->om, &{{.ident}}_chr_val,
+                            sizeof {{.ident}}_chr_val);
+        return rc == 0 ? 0 : BLE_ATT_ERR_INSUFFICIENT_RES;
+
+    case BLE_GATT_ACCESS_OP_WRITE_CHR:
+        if (OS_MBUF_PKTLEN(ctxt->om) != sizeof {{.ident}}_chr_val) {
+            return BLE_ATT_ERR_INVALID_ATTR_VALUE_LEN;
+        }
+
+        rc = ble_hs_mbuf_to_flat(ctxt->om, &{{.ident}}_chr_val,
+                                 sizeof {{.ident}}_chr_val, NULL);
+        return rc == 0 ? 0 : BLE_ATT_ERR_UNLIKELY;
+
+    default:
+        assert(0);
+        return BLE_ATT_ERR_UNLIKELY;
+    }
+}
+
+void
+{{.ident}}_init(void)
+{
+    int rc;
+
+    /* Ensure this function only gets called by sysinit. */
+    SYSINIT_ASSERT_ACTIVE();
+
+    rc = ble_gatts_count_cfg({{.ident}}_defs);
+    SYSINIT_PANIC_ASSERT(rc == 0);
+
+    rc = ble_gatts_add_svcs({{.ident}}_defs);
+    SYSINIT_PANIC_ASSERT(rc == 0);
+}
+`,
+		},
+	},
+
+	"unittest": &pkgTemplate{
+		desc: "Unit test package",
+		vars: map[string]templateVar{
+			"parent": templateVar{
+				name: "parent",
+				desc: "package under test; defaults to the new " +
+					"package's parent directory",
+			},
+		},
+		files: map[string]string{
+			"pkg.yml": `pkg.name: "{{.name}}"
+pkg.type: unittest
+pkg.description: "Unit tests for {{.parent}}."
+pkg.author: "Apache Mynewt <dev@mynewt.apache.org>"
+pkg.homepage: "http://mynewt.apache.org/"
+pkg.keywords:
+
+pkg.deps:
+    - "{{.parent}}"
+    - "@apache-mynewt-core/test/testutil"
+`,
+			"syscfg.yml": `# Settings the tests require; e.g.,
+#
+# syscfg.vals:
+#     OS_MAIN_STACK_SIZE: 1024
+`,
+			"src/{{.ident}}.c": `#include "sysinit/sysinit.h"
+#include "syscfg/syscfg.h"
+#include "testutil/testutil.h"
+
+TEST_CASE({{.ident}}_case_basic)
+{
+    /* TODO: Exercise {{.parent}}. */
+    TEST_ASSERT(1);
+}
+
+TEST_SUITE({{.ident}}_suite)
+{
+    {{.ident}}_case_basic();
+}
+
+#if MYNEWT_VAL(SELFTEST)
+int
+main(int argc, char **argv)
+{
+    sysinit();
+
+    {{.ident}}_suite();
+
+    return tu_any_failed;
+}
+#endif
+`,
+		},
+	},
+}
+
+// Returns the names of the built-in package templates.
+func PkgTemplateNames() []string {
+	names := make([]string, 0, len(pkgTemplates))
+	for name, _ := range pkgTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Describes the built-in package template with the specified name.
+func PkgTemplateDesc(name string) string {
+	pt := pkgTemplates[name]
+	if pt == nil {
+		return ""
+	}
+
+	return pt.desc
+}
+
+// Formats 16 random bytes as the argument list of BLE_UUID128_INIT().  The
+// list is split across two lines of a macro definition.
+func randomUuid128() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	strs := make([]string, len(b))
+	for i, v := range b {
+		strs[i] = fmt.Sprintf("0x%02x", v)
+	}
+
+	return strings.Join(strs[:8], ", ") + ", \\\n                     " +
+		strings.Join(strs[8:], ", "), nil
+}
+
+// Calculates the values the templates refer to in addition to their
+// declared variables.
+func pkgTemplateBuiltins(template string, fullName string,
+	userVals map[string]string) (map[string]interface{}, error) {
+
+	base := path.Base(fullName)
+	ident := util.CIdentifier(base)
+
+	vals := map[string]interface{}{
+		"name": fullName,
+		"base": base,
+	}
+
+	switch template {
+	case "unittest":
+		// A test package is conventionally named <pkg>/test; derive its
+		// identifiers from the package under test.
+		parent := userVals["parent"]
+		if parent == "" {
+			parent = path.Dir(fullName)
+			userVals["parent"] = parent
+		}
+		ident = util.CIdentifier(path.Base(parent)) + "_test"
+
+	case "ble-svc":
+		for _, key := range []string{"svc_uuid", "chr_uuid"} {
+			uuid, err := randomUuid128()
+			if err != nil {
+				return nil, err
+			}
+			vals[key] = uuid
+		}
+	}
+
+	vals["ident"] = ident
+	vals["IDENT"] = strings.ToUpper(ident)
+
+	return vals, nil
+}
+
+func (pw *PackageWriter) ConfigureTemplate(template string, loc string,
+	userVals map[string]string) error {
+
+	pt := pkgTemplates[template]
+	if pt == nil {
+		return util.FmtNewtError("Unknown package template \"%s\"; "+
+			"must be one of: %s", template,
+			strings.Join(PkgTemplateNames(), ", "))
+	}
+
+	fullName := path.Clean(loc)
+	targetPath := pw.project.Path() + "/" + fullName
+	if util.NodeExist(targetPath) {
+		return util.FmtNewtError("Cannot place a new package in "+
+			"%s, path already exists.", targetPath)
+	}
+
+	builtins, err := pkgTemplateBuiltins(template, fullName, userVals)
+	if err != nil {
+		return err
+	}
+
+	vals, err := templateValues(pt.vars, userVals)
+	if err != nil {
+		return err
+	}
+	for k, v := range builtins {
+		vals[k] = v
+	}
+
+	pw.fullName = fullName
+	pw.targetPath = targetPath
+	pw.template = template
+	pw.builtin = pt
+	pw.vals = vals
+
+	return nil
+}
+
+func (pw *PackageWriter) writeBuiltin() error {
+	names := make([]string, 0, len(pw.builtin.files))
+	for name, _ := range pw.builtin.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		relPath, err := renderTemplateString(name, name, pw.vals)
+		if err != nil {
+			return err
+		}
+
+		contents, err := renderTemplateString(name, pw.builtin.files[name],
+			pw.vals)
+		if err != nil {
+			return err
+		}
+
+		dstPath := pw.targetPath + "/" + relPath
+		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+			return util.ChildNewtError(err)
+		}
+		if err := ioutil.WriteFile(dstPath, []byte(contents),
+			0644); err != nil {
+
+			return util.ChildNewtError(err)
+		}
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Package %s created from template %s in %s.\n", pw.fullName,
+		pw.template, pw.targetPath)
+
+	return nil
+}
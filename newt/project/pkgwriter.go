@@ -44,6 +44,11 @@ type PackageWriter struct {
 	template   string
 	fullName   string
 	project    *Project
+
+	// Set when the package is created from a built-in template; see
+	// ConfigureTemplate().
+	builtin *pkgTemplate
+	vals    map[string]interface{}
 }
 
 var TemplateRepoMap = map[string]templateRepo{
@@ -164,6 +169,14 @@ func (pw *PackageWriter) fixupPkg() error {
 }
 
 func (pw *PackageWriter) WritePackage() error {
+	if pw.builtin != nil {
+		if err := pw.writeBuiltin(); err != nil {
+			os.RemoveAll(pw.targetPath)
+			return err
+		}
+		return nil
+	}
+
 	dl := pw.downloader
 
 	dl.User = pw.repo.owner
@@ -25,15 +25,20 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cast"
 
 	"mynewt.apache.org/newt/newt/compat"
 	"mynewt.apache.org/newt/newt/downloader"
 	"mynewt.apache.org/newt/newt/interfaces"
 	"mynewt.apache.org/newt/newt/newtutil"
 	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/pkgimport"
 	"mynewt.apache.org/newt/newt/repo"
 	"mynewt.apache.org/newt/util"
 	"mynewt.apache.org/newt/viper"
@@ -43,6 +48,13 @@ var globalProject *Project = nil
 
 const PROJECT_FILE_NAME = "project.yml"
 
+// Optional, typically untracked, file containing developer-specific
+// repository.overrides entries.
+const PROJECT_OVERRIDES_FILE_NAME = "project-overrides.yml"
+
+// Cache of parsed YAML files, relative to the project base directory.
+const PARSE_CACHE_PATH = "bin/.parsecache"
+
 var ignoreSearchDirs []string = []string{
 	"bin",
 	"repos",
@@ -58,11 +70,27 @@ type Project struct {
 	packages interfaces.PackageList
 
 	projState *ProjectState
+	projLock  *ProjectLock
 
 	// Repositories configured on this project
 	repos    map[string]*repo.Repo
 	warnings []string
 
+	// Repo name -> path of a working copy to use instead of the repo.
+	overrides map[string]string
+
+	// Directory of the enclosing workspace, if any.
+	workspace string
+
+	// Toolchain versions the project requires, by executable name.
+	toolchainPins map[string]*ToolchainPin
+
+	// Build profiles defined in project.yml, by name.
+	buildProfiles map[string]*BuildProfile
+
+	// External packages wrapped as packages of the local repo.
+	imports []*pkgimport.Import
+
 	localRepo *repo.Repo
 
 	v *viper.Viper
@@ -173,6 +201,36 @@ func (proj *Project) LocalRepo() *repo.Repo {
 	return proj.localRepo
 }
 
+// Returns the external checkers declared in the "project.checkers" section of
+// project.yml, mapping each checker name to its command template.
+func (proj *Project) Checkers() map[string]string {
+	return proj.v.GetStringMapString("project.checkers")
+}
+
+// Indicates whether project.yml requires packages to only include headers
+// from their direct dependencies ("project.strict_includes").
+func (proj *Project) StrictIncludes() bool {
+	return proj.v.GetBool("project.strict_includes")
+}
+
+// Indicates whether project.yml requests that packages only be passed the
+// include paths of their dependencies ("project.prune_includes").
+func (proj *Project) PruneIncludes() bool {
+	return proj.v.GetBool("project.prune_includes")
+}
+
+// Indicates whether project.yml requests that targets share the object files
+// they compile identically ("project.shared_objects").
+func (proj *Project) SharedObjects() bool {
+	return proj.v.GetBool("project.shared_objects")
+}
+
+// Returns the template that build artifacts are named with
+// ("project.artifact_name"), or "" if project.yml does not specify one.
+func (proj *Project) ArtifactNameTemplate() string {
+	return proj.v.GetString("project.artifact_name")
+}
+
 func (proj *Project) Warnings() []string {
 	return proj.warnings
 }
@@ -305,7 +363,7 @@ func (proj *Project) checkDeps(r *repo.Repo) error {
 func (proj *Project) UpdateRepos() error {
 	repoList := proj.Repos()
 	for _, r := range repoList {
-		if r.IsLocal() {
+		if r.IsLocal() || r.IsVendored() || r.IsOverridden() {
 			continue
 		}
 
@@ -317,6 +375,102 @@ func (proj *Project) UpdateRepos() error {
 	return nil
 }
 
+type repoInstallResult struct {
+	name string
+	vers *repo.Version // nil if the repo was already up to date.
+	err  error
+}
+
+// Installs or upgrades a single repo.  Returns the installed version, or nil
+// if the repo did not need to be changed.
+func (proj *Project) installRepo(r *repo.Repo, upgrade bool,
+	force bool) (*repo.Version, error) {
+
+	// Check the version requirements on this repository, and see
+	// whether or not we need to install/upgrade it.
+	skip, err := proj.checkVersionRequirements(r, upgrade, force)
+	if err != nil {
+		return nil, err
+	}
+	if skip {
+		return nil, nil
+	}
+
+	// Do the hard work of actually copying and installing the repository.
+	rvers, err := r.Install(upgrade || force)
+	if err != nil {
+		return nil, err
+	}
+
+	if upgrade {
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "%s successfully upgraded to version %s\n",
+			r.Name(), rvers.String())
+	} else {
+		util.StatusMessage(util.VERBOSITY_VERBOSE, "%s successfully installed version %s\n",
+			r.Name(), rvers.String())
+	}
+
+	if err := proj.verifyInstalledCommit(r, rvers); err != nil {
+		return nil, err
+	}
+
+	// A plain install reproduces the locked state of the repo, if any.
+	if !upgrade {
+		if err := proj.ApplyLock(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return rvers, nil
+}
+
+// Prints the outcome for each repo if any of them failed to install.  Returns
+// an error if there were failures.
+func reportInstallFailures(results []repoInstallResult, upgrade bool) error {
+	failures := 0
+	for _, res := range results {
+		if res.err != nil {
+			failures++
+		}
+	}
+	if failures == 0 {
+		return nil
+	}
+
+	op := "install"
+	done := "installed"
+	if upgrade {
+		op = "upgrade"
+		done = "upgraded"
+	}
+
+	util.StatusMessage(util.VERBOSITY_QUIET, "\nRepository %s summary:\n", op)
+	for _, res := range results {
+		var status string
+		switch {
+		case res.err != nil:
+			// Show only the first line of the error; git output can be long.
+			msg := strings.TrimSpace(res.err.Error())
+			if i := strings.Index(msg, "\n"); i >= 0 {
+				msg = msg[:i] + " ..."
+			}
+			status = "FAILED: " + msg
+
+		case res.vers == nil:
+			status = "unchanged"
+
+		default:
+			status = done + " version " + res.vers.String()
+		}
+
+		util.StatusMessage(util.VERBOSITY_QUIET, "    %s: %s\n",
+			res.name, status)
+	}
+
+	return util.FmtNewtError("Failed to %s %d of %d repositories",
+		op, failures, len(results))
+}
+
 func (proj *Project) Install(upgrade bool, force bool) error {
 	repoList := proj.Repos()
 
@@ -338,36 +492,35 @@ func (proj *Project) Install(upgrade bool, force bool) error {
 		return err
 	}
 
-	for rname, r := range proj.Repos() {
-		if r.IsLocal() {
-			continue
-		}
-		// Check the version requirements on this repository, and see
-		// whether or not we need to install/upgrade it.
-		skip, err := proj.checkVersionRequirements(r, upgrade, force)
-		if err != nil {
-			return err
-		}
-		if skip {
+	// A failure to install one repo doesn't prevent the others from being
+	// installed; the failures are summarized at the end.
+	rnames := make([]string, 0, len(proj.Repos()))
+	for rname, _ := range proj.Repos() {
+		rnames = append(rnames, rname)
+	}
+	sort.Strings(rnames)
+
+	results := []repoInstallResult{}
+	for _, rname := range rnames {
+		r := proj.Repos()[rname]
+		if r.IsLocal() || r.IsVendored() || r.IsOverridden() {
 			continue
 		}
 
-		// Do the hard work of actually copying and installing the repository.
-		rvers, err := r.Install(upgrade || force)
+		rvers, err := proj.installRepo(r, upgrade, force)
+		results = append(results, repoInstallResult{
+			name: rname,
+			vers: rvers,
+			err:  err,
+		})
 		if err != nil {
-			return err
-		}
-
-		if upgrade {
-			util.StatusMessage(util.VERBOSITY_VERBOSE, "%s successfully upgraded to version %s\n",
-				r.Name(), rvers.String())
-		} else {
-			util.StatusMessage(util.VERBOSITY_VERBOSE, "%s successfully installed version %s\n",
-				r.Name(), rvers.String())
+			continue
 		}
 
 		// Update the project state with the new repository version information.
-		proj.projState.Replace(rname, rvers)
+		if rvers != nil {
+			proj.projState.Replace(rname, rvers)
+		}
 	}
 
 	// Save the project state, including any updates or changes to the project
@@ -376,13 +529,132 @@ func (proj *Project) Install(upgrade bool, force bool) error {
 		return err
 	}
 
+	if err := reportInstallFailures(results, upgrade); err != nil {
+		// Don't lock a partially upgraded set of repos.
+		return err
+	}
+
+	// An upgrade always regenerates the lock file.  An install only creates
+	// one if the project doesn't have one yet.
+	if upgrade || proj.projLock.IsEmpty() {
+		if err := proj.projLock.Update(proj.Repos()); err != nil {
+			return err
+		}
+		if err := proj.projLock.Save(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// Checks out the commit recorded for the specified repo in the project lock
+// file.  This is a no-op if the repo isn't locked or if the user asked for the
+// lock file to be ignored.
+func (proj *Project) ApplyLock(r *repo.Repo) error {
+	if newtutil.NewtIgnoreLock {
+		return nil
+	}
+
+	commit := proj.projLock.Commit(r.Name())
+	if commit == "" {
+		return nil
+	}
+
+	cur, err := r.HeadCommit()
+	if err != nil {
+		return err
+	}
+	if cur == commit {
+		return nil
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE,
+		"Checking out locked commit %s of %s\n", commit, r.Name())
+	return r.CheckoutCommit(commit)
+}
+
+// Returns a description of each installed repo whose checked out commit
+// differs from the one in the project lock file.
+// Returns the commit the lock file pins the specified repo to, or "" if the
+// repo is not locked.
+func (proj *Project) LockedCommit(rname string) string {
+	return proj.projLock.Commit(rname)
+}
+
+func (proj *Project) LockMismatches() []string {
+	mismatches := []string{}
+
+	for rname, r := range proj.repos {
+		commit := proj.projLock.Commit(rname)
+		if r.IsLocal() || r.IsVendored() || r.IsOverridden() ||
+			commit == "" || util.NodeNotExist(r.Path()) {
+
+			continue
+		}
+
+		cur, err := r.HeadCommit()
+		if err != nil {
+			log.Debugf("%s", err.Error())
+			continue
+		}
+
+		if cur != commit {
+			mismatches = append(mismatches, fmt.Sprintf(
+				"repository %s is at commit %s; %s specifies %s",
+				rname, cur, PROJECT_LOCK_FILE, commit))
+		}
+	}
+
+	return mismatches
+}
+
 func (proj *Project) Upgrade(force bool) error {
 	return proj.Install(true, force)
 }
 
+// Reads an integer network setting.  As with the cache directory, the user's
+// settings take precedence over project.yml, since network conditions are a
+// property of the machine.  Returns -1 if the setting is unspecified.
+func readNetSetting(v *viper.Viper, name string, min int) (int, error) {
+	str := newtutil.NewtSettings.String(name)
+	src := "setting " + name
+	if str == "" {
+		str = v.GetString("project." + name)
+		src = "project." + name
+	}
+	if str == "" {
+		return -1, nil
+	}
+
+	n, err := strconv.Atoi(str)
+	if err != nil || n < min {
+		return 0, util.FmtNewtError("Invalid %s: \"%s\"", src, str)
+	}
+
+	return n, nil
+}
+
+func applyNetSettings(v *viper.Viper) error {
+	timeout, err := readNetSetting(v, "net_timeout", 1)
+	if err != nil {
+		return err
+	}
+	if timeout >= 0 {
+		downloader.SetNetTimeout(time.Duration(timeout) * time.Second)
+	}
+
+	retries, err := readNetSetting(v, "net_retries", 0)
+	if err != nil {
+		return err
+	}
+	if retries >= 0 {
+		downloader.SetNetRetries(retries)
+	}
+
+	return nil
+}
+
 func (proj *Project) loadRepo(rname string, v *viper.Viper) error {
 	varName := fmt.Sprintf("repository.%s", rname)
 
@@ -391,11 +663,26 @@ func (proj *Project) loadRepo(rname string, v *viper.Viper) error {
 		return util.NewNewtError(fmt.Sprintf("Missing configuration for "+
 			"repository %s.", rname))
 	}
+
+	return proj.loadRepoVars(rname, repoVars)
+}
+
+func (proj *Project) loadRepoVars(rname string,
+	repoVars map[string]string) error {
+
 	if repoVars["type"] == "" {
 		return util.NewNewtError(fmt.Sprintf("Missing type for repository " +
 			rname))
 	}
 
+	if repoVars["type"] == "vendor" {
+		return proj.loadVendoredRepo(rname, repoVars)
+	}
+
+	if path := proj.overrides[rname]; path != "" {
+		return proj.loadOverrideRepo(rname, path)
+	}
+
 	dl, err := downloader.LoadDownloader(rname, repoVars)
 	if err != nil {
 		return err
@@ -440,6 +727,89 @@ func (proj *Project) loadRepo(rname string, v *viper.Viper) error {
 	return nil
 }
 
+func (proj *Project) loadVendoredRepo(rname string,
+	repoVars map[string]string) error {
+
+	path := repoVars["path"]
+	if path == "" {
+		return util.FmtNewtError("Missing path for vendored repository %s",
+			rname)
+	}
+
+	r, err := repo.NewVendoredRepo(rname, path)
+	if err != nil {
+		return err
+	}
+
+	for _, ignDir := range ignoreSearchDirs {
+		r.AddIgnoreDir(ignDir)
+	}
+
+	// The vendored sources live inside the project; don't treat them as
+	// packages of the local repo.
+	proj.localRepo.AddIgnoreDir(filepath.Clean(path))
+
+	log.Debugf("Loaded vendored repository %s (path: %s)", rname, path)
+
+	proj.repos[r.Name()] = r
+	return nil
+}
+
+func (proj *Project) loadOverrideRepo(rname string, path string) error {
+	r, err := repo.NewOverrideRepo(rname, path)
+	if err != nil {
+		return err
+	}
+
+	if util.NodeNotExist(r.Path()) {
+		return util.FmtNewtError(
+			"Override path for repository %s does not exist: %s", rname, path)
+	}
+
+	for _, ignDir := range ignoreSearchDirs {
+		r.AddIgnoreDir(ignDir)
+	}
+
+	// A working copy inside the project must not also be scanned as part of
+	// the local repo.
+	if rel, err := filepath.Rel(proj.BasePath, r.Path()); err == nil &&
+		!strings.HasPrefix(rel, "..") {
+
+		proj.localRepo.AddIgnoreDir(rel)
+	}
+
+	proj.warnings = append(proj.warnings, fmt.Sprintf(
+		"repository %s overridden by %s", rname, r.Path()))
+
+	proj.repos[r.Name()] = r
+	return nil
+}
+
+// Reads repository.overrides from project.yml and from the optional
+// overrides file.  Entries in the overrides file take precedence.
+func (proj *Project) readOverrides(v *viper.Viper) error {
+	proj.overrides = v.GetStringMapString("repository.overrides")
+
+	if util.NodeNotExist(proj.BasePath + "/" + PROJECT_OVERRIDES_FILE_NAME) {
+		return nil
+	}
+
+	ov, err := util.ReadConfig(proj.BasePath,
+		strings.TrimSuffix(PROJECT_OVERRIDES_FILE_NAME, ".yml"))
+	if err != nil {
+		return err
+	}
+
+	if proj.overrides == nil {
+		proj.overrides = map[string]string{}
+	}
+	for rname, path := range ov.GetStringMapString("repository.overrides") {
+		proj.overrides[rname] = path
+	}
+
+	return nil
+}
+
 func (proj *Project) checkNewtVer() error {
 	compatSms := proj.v.GetStringMapString("project.newt_compatibility")
 	// If this project doesn't have a newt compatibility map, just assume there
@@ -470,7 +840,83 @@ func (proj *Project) checkNewtVer() error {
 	}
 }
 
+// Reads the URL rewrites from the project.mirrors list.  Each entry has the
+// form {from: <url-prefix>, to: <replacement>}.  A list is used rather than a
+// map because URLs contain dots, which viper treats as key separators.
+func readMirrors(v *viper.Viper) (map[string]string, error) {
+	mirrors := map[string]string{}
+
+	for _, itf := range cast.ToSlice(v.Get("project.mirrors")) {
+		entry := cast.ToStringMapString(itf)
+		if entry["from"] == "" || entry["to"] == "" {
+			return nil, util.FmtNewtError(
+				"Invalid project.mirrors entry: %v; must specify "+
+					"\"from\" and \"to\"", itf)
+		}
+		mirrors[entry["from"]] = entry["to"]
+	}
+
+	return mirrors, nil
+}
+
+// Reads the container toolchain commands are run in, if any:
+//
+//	toolchain.container:
+//	    image: "ghcr.io/example/mynewt-toolchain:12.2"
+//	    engine: podman
+//	    args: ["--privileged"]
+//
+// A plain image name may be given instead of a map.  The
+// NEWT_TOOLCHAIN_CONTAINER environment variable overrides the image; set it
+// to "none" to use the host's toolchain.
+func (proj *Project) readToolchainContainer(v *viper.Viper) error {
+	var engine string
+	var image string
+	var args []string
+
+	switch val := v.Get("toolchain.container").(type) {
+	case nil:
+	case string:
+		image = val
+	default:
+		entry := cast.ToStringMap(val)
+		engine = cast.ToString(entry["engine"])
+		image = cast.ToString(entry["image"])
+		args = cast.ToStringSlice(entry["args"])
+	}
+
+	if env := os.Getenv("NEWT_TOOLCHAIN_CONTAINER"); env == "none" {
+		image = ""
+	} else if env != "" {
+		image = env
+	}
+
+	if image == "" {
+		newtutil.NewtContainer = nil
+		return nil
+	}
+
+	// Repos are inside the project unless it belongs to a workspace.
+	mounts := []string{proj.BasePath}
+	if proj.workspace != "" {
+		mounts = append(mounts, proj.workspace, repo.ReposDir())
+	}
+
+	tc, err := newtutil.NewToolchainContainer(engine, image, args, mounts)
+	if err != nil {
+		return err
+	}
+	newtutil.NewtContainer = tc
+
+	return nil
+}
+
 func (proj *Project) loadConfig() error {
+	if !newtutil.NewtNoParseCache {
+		util.EnableConfigCache(
+			filepath.Join(proj.BasePath, PARSE_CACHE_PATH), proj.BasePath)
+	}
+
 	v, err := util.ReadConfig(proj.BasePath,
 		strings.TrimSuffix(PROJECT_FILE_NAME, ".yml"))
 	if err != nil {
@@ -486,8 +932,66 @@ func (proj *Project) loadConfig() error {
 		return err
 	}
 
+	proj.projLock, err = LoadProjectLock()
+	if err != nil {
+		return err
+	}
+
 	proj.name = v.GetString("project.name")
 
+	if err := proj.loadWorkspace(); err != nil {
+		return err
+	}
+
+	// A project can require offline operation (e.g., on air-gapped build
+	// machines) regardless of the command line.
+	if v.GetBool("project.offline") {
+		newtutil.NewtOffline = true
+	}
+
+	mirrors, err := readMirrors(v)
+	if err != nil {
+		return err
+	}
+	downloader.SetMirrors(mirrors)
+
+	// The cache directory can be overridden per machine (e.g., by a CI
+	// agent).
+	cacheDir := os.Getenv("NEWT_CACHE_DIR")
+	if cacheDir == "" {
+		cacheDir = newtutil.NewtSettings.String("cache_dir")
+	}
+	if cacheDir == "" {
+		cacheDir = v.GetString("project.cache_dir")
+	}
+	if cacheDir != "" {
+		downloader.SetCacheDir(os.ExpandEnv(cacheDir))
+	}
+
+	if err := applyNetSettings(v); err != nil {
+		return err
+	}
+
+	if err := proj.readToolchainContainer(v); err != nil {
+		return err
+	}
+
+	proj.toolchainPins, err = readToolchainPins(v)
+	if err != nil {
+		return err
+	}
+	useInstalledToolchains(proj.toolchainPins)
+
+	proj.buildProfiles, err = readBuildProfiles(v)
+	if err != nil {
+		return err
+	}
+
+	proj.imports, err = pkgimport.ReadImports(v, proj.BasePath)
+	if err != nil {
+		return err
+	}
+
 	// Local repository always included in initialization
 	r, err := repo.NewLocalRepo(proj.name)
 	if err != nil {
@@ -500,6 +1004,10 @@ func (proj *Project) loadConfig() error {
 		r.AddIgnoreDir(ignDir)
 	}
 
+	if err := proj.readOverrides(v); err != nil {
+		return err
+	}
+
 	rstrs := v.GetStringSlice("project.repositories")
 	for _, repoName := range rstrs {
 		if err := proj.loadRepo(repoName, v); err != nil {
@@ -644,7 +1152,9 @@ func (proj *Project) ResolvePath(
 	}
 }
 
-func findProjectDir(dir string) (string, error) {
+// Searches the specified directory and its ancestors for a project file.
+// Returns the directory containing it.
+func FindProjectDir(dir string) (string, error) {
 	for {
 		projFile := path.Clean(dir) + "/" + PROJECT_FILE_NAME
 
@@ -665,13 +1175,92 @@ func findProjectDir(dir string) (string, error) {
 	return dir, nil
 }
 
+// Reads the external commands declared in the "project.commands" section of
+// the specified project's project.yml.  The result maps each command name to
+// the path of its executable; relative paths are resolved against the
+// project directory.  The rest of the project is not loaded, so this is cheap
+// enough to call before any command runs.
+func ReadProjectCommands(projDir string) (map[string]string, error) {
+	v, err := util.ReadConfig(projDir,
+		strings.TrimSuffix(PROJECT_FILE_NAME, ".yml"))
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := map[string]string{}
+	for name, path := range v.GetStringMapString("project.commands") {
+		if !filepath.IsAbs(path) {
+			path = projDir + "/" + path
+		}
+		cmds[name] = path
+	}
+
+	return cmds, nil
+}
+
+// Adds the packages declared in "project.imports" to the local repo's
+// package list.
+func (proj *Project) loadImports(
+	list *map[string]interfaces.PackageInterface) error {
+
+	for _, imp := range proj.imports {
+		if old, ok := (*list)[imp.Name]; ok {
+			return util.FmtNewtError(
+				"Imported package %s conflicts with package in %s",
+				imp.Name, old.(*pkg.LocalPackage).BasePath())
+		}
+
+		lpkg, err := imp.Package(proj.localRepo)
+		if err != nil {
+			return err
+		}
+		(*list)[imp.Name] = lpkg
+
+		log.Debugf("Imported %s package %s from %s", imp.Format, imp.Name,
+			imp.Path)
+	}
+
+	return nil
+}
+
 func (proj *Project) loadPackageList() error {
 	proj.packages = interfaces.PackageList{}
 
-	// Go through a list of repositories, starting with local, and search for
-	// packages / store them in the project package list.
+	start := time.Now()
+	defer func() {
+		numPkgs := 0
+		for _, list := range proj.packages {
+			numPkgs += len(*list)
+		}
+		util.StatusMessage(util.VERBOSITY_VERBOSE,
+			"Loaded %d packages in %s\n", numPkgs, time.Since(start))
+	}()
+
+	// Read the local repo first; its packages may declare additional repos
+	// (pkg.repositories).
+	list, warnings, err := pkg.ReadLocalPackages(proj.localRepo,
+		proj.localRepo.Path())
+	if err != nil {
+		util.StatusMessage(util.VERBOSITY_QUIET, "%s\n", err.Error())
+	} else {
+		proj.packages[proj.localRepo.Name()] = list
+		if err := proj.loadImports(list); err != nil {
+			return err
+		}
+		if err := proj.loadPkgRepos(list); err != nil {
+			return err
+		}
+	}
+	proj.warnings = append(proj.warnings, warnings...)
+
+	// Go through the remaining repositories, and search for packages / store
+	// them in the project package list.
 	repos := proj.Repos()
 	for name, repo := range repos {
+		if repo == proj.localRepo {
+			continue
+		}
+
 		list, warnings, err := pkg.ReadLocalPackages(repo, repo.Path())
 		if err != nil {
 			/* Failed to read the repo's package list.  Report the failure as a
@@ -711,7 +1300,7 @@ func (proj *Project) PackagesOfType(pkgType interfaces.PackageType) []interfaces
 }
 
 func LoadProject(dir string) (*Project, error) {
-	projDir, err := findProjectDir(dir)
+	projDir, err := FindProjectDir(dir)
 	if err != nil {
 		return nil, err
 	}
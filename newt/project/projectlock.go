@@ -0,0 +1,165 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package project
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/interfaces"
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/util"
+)
+
+const PROJECT_LOCK_FILE = "project.lock"
+
+// The project lock records the exact commit of each installed repo.  It gets
+// written by "newt upgrade" and is honored by "newt install" and "newt sync",
+// allowing a team to reproduce the same set of repos without pinning commits
+// in project.yml.
+type ProjectLock struct {
+	commits map[string]string
+
+	// Optional hash of each repo's checked out contents (e.g., the git tree).
+	trees map[string]string
+}
+
+func (pl *ProjectLock) Commit(rname string) string {
+	return pl.commits[rname]
+}
+
+func (pl *ProjectLock) Tree(rname string) string {
+	return pl.trees[rname]
+}
+
+func (pl *ProjectLock) Replace(rname string, commit string, tree string) {
+	pl.commits[rname] = commit
+	if tree == "" {
+		delete(pl.trees, rname)
+	} else {
+		pl.trees[rname] = tree
+	}
+}
+
+func (pl *ProjectLock) IsEmpty() bool {
+	return len(pl.commits) == 0
+}
+
+func (pl *ProjectLock) LockFile() string {
+	return interfaces.GetProject().Path() + "/" + PROJECT_LOCK_FILE
+}
+
+func (pl *ProjectLock) Save() error {
+	file, err := os.Create(pl.LockFile())
+	if err != nil {
+		return util.NewNewtError(err.Error())
+	}
+	defer file.Close()
+
+	names := make([]string, 0, len(pl.commits))
+	for name, _ := range pl.commits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	file.WriteString("# Generated by newt upgrade; do not edit.\n")
+	for _, name := range names {
+		line := name + "," + pl.commits[name]
+		if tree := pl.trees[name]; tree != "" {
+			line += "," + tree
+		}
+		file.WriteString(line + "\n")
+	}
+
+	return nil
+}
+
+func (pl *ProjectLock) Init() error {
+	pl.commits = map[string]string{}
+	pl.trees = map[string]string{}
+
+	path := pl.LockFile()
+
+	// A missing lock file just means no repo commits are locked.
+	if util.NodeNotExist(path) {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return util.NewNewtError(err.Error())
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// name,commit[,tree]
+		line := strings.Split(text, ",")
+		if len(line) != 2 && len(line) != 3 {
+			return util.FmtNewtError(
+				"Invalid format for line in %s file: %s",
+				PROJECT_LOCK_FILE, text)
+		}
+
+		pl.commits[line[0]] = line[1]
+		if len(line) == 3 {
+			pl.trees[line[0]] = line[2]
+		}
+	}
+
+	return nil
+}
+
+// Records the current commit of each installed repo.
+func (pl *ProjectLock) Update(repos map[string]*repo.Repo) error {
+	for rname, r := range repos {
+		if r.IsLocal() || r.IsVendored() || r.IsOverridden() ||
+			util.NodeNotExist(r.Path()) {
+
+			continue
+		}
+
+		commit, err := r.HeadCommit()
+		if err != nil {
+			return err
+		}
+		tree, err := r.TreeHash()
+		if err != nil {
+			return err
+		}
+		pl.Replace(rname, commit, tree)
+	}
+
+	return nil
+}
+
+func LoadProjectLock() (*ProjectLock, error) {
+	pl := &ProjectLock{}
+	if err := pl.Init(); err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
@@ -0,0 +1,275 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package project
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Describes the parameters of a project template.  If present, this file
+// lives at the root of the template repo and is not copied into the new
+// project.
+const PROJECT_TEMPLATE_FILE_NAME = "template.yml"
+
+// Files with this suffix are rendered with text/template; the suffix is
+// removed from the generated file's name.
+const PROJECT_TEMPLATE_SUFFIX = ".tmpl"
+
+type templateVar struct {
+	name   string
+	dflt   interface{}
+	isBool bool
+	desc   string
+
+	// If non-empty, the only values the variable accepts.
+	choices []string
+}
+
+func (tv *templateVar) accepts(val string) bool {
+	if len(tv.choices) == 0 {
+		return true
+	}
+
+	for _, c := range tv.choices {
+		if c == val {
+			return true
+		}
+	}
+
+	return false
+}
+
+func readTemplateVars(dir string) (map[string]templateVar, error) {
+	tvars := map[string]templateVar{}
+
+	if util.NodeNotExist(dir + "/" + PROJECT_TEMPLATE_FILE_NAME) {
+		return tvars, nil
+	}
+
+	v, err := util.ReadConfig(dir,
+		strings.TrimSuffix(PROJECT_TEMPLATE_FILE_NAME, ".yml"))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, itf := range v.GetStringMap("template.vars") {
+		fields := cast.ToStringMap(itf)
+
+		tv := templateVar{
+			name: name,
+			dflt: fields["default"],
+			desc: cast.ToString(fields["description"]),
+		}
+		_, tv.isBool = tv.dflt.(bool)
+
+		tvars[name] = tv
+	}
+
+	return tvars, nil
+}
+
+// Combines the user-specified values with the template's defaults.  Boolean
+// variables (those with a boolean default) accept the usual true/false
+// spellings so that templates can use them in {{if}} actions.
+func templateValues(tvars map[string]templateVar,
+	userVals map[string]string) (map[string]interface{}, error) {
+
+	vals := map[string]interface{}{}
+
+	for name, val := range userVals {
+		tv, ok := tvars[name]
+		if !ok {
+			return nil, util.FmtNewtError(
+				"Template has no variable named \"%s\"", name)
+		}
+
+		if tv.isBool {
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return nil, util.FmtNewtError(
+					"Template variable %s requires a boolean value; "+
+						"have \"%s\"", name, val)
+			}
+			vals[name] = b
+		} else {
+			if !tv.accepts(val) {
+				return nil, util.FmtNewtError(
+					"Invalid value for template variable %s: \"%s\"; "+
+						"must be one of: %s",
+					name, val, strings.Join(tv.choices, ", "))
+			}
+			vals[name] = val
+		}
+	}
+
+	missing := []string{}
+	for name, tv := range tvars {
+		if _, ok := vals[name]; ok {
+			continue
+		}
+
+		if tv.dflt == nil {
+			str := name
+			if tv.desc != "" {
+				str += " (" + tv.desc + ")"
+			}
+			missing = append(missing, str)
+		} else if tv.isBool {
+			vals[name] = tv.dflt
+		} else {
+			vals[name] = cast.ToString(tv.dflt)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, util.FmtNewtError(
+			"Missing values for template variables: %s",
+			strings.Join(missing, ", "))
+	}
+
+	return vals, nil
+}
+
+func renderTemplateString(name string, text string,
+	vals map[string]interface{}) (string, error) {
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", util.FmtNewtError("Error parsing template %s: %s",
+			name, err.Error())
+	}
+
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, vals); err != nil {
+		return "", util.FmtNewtError("Error rendering template %s: %s",
+			name, err.Error())
+	}
+
+	return buf.String(), nil
+}
+
+// Copies a template directory, rendering path names and .tmpl files.  An
+// entry whose name renders to an empty string is skipped, which allows
+// templates to include files conditionally (e.g., a directory named
+// "{{if .ble}}ble{{end}}").
+func renderTemplateDir(srcDir string, dstDir string,
+	vals map[string]interface{}) error {
+
+	infos, err := ioutil.ReadDir(srcDir)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	for _, info := range infos {
+		if info.Name() == ".git" {
+			continue
+		}
+
+		srcPath := srcDir + "/" + info.Name()
+
+		name, err := renderTemplateString(srcPath, info.Name(), vals)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+
+		if info.IsDir() {
+			if err := renderTemplateDir(srcPath, dstDir+"/"+name,
+				vals); err != nil {
+
+				return err
+			}
+			continue
+		}
+
+		if !strings.HasSuffix(name, PROJECT_TEMPLATE_SUFFIX) {
+			if err := util.CopyFile(srcPath, dstDir+"/"+name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := ioutil.ReadFile(srcPath)
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+
+		contents, err := renderTemplateString(srcPath, string(data), vals)
+		if err != nil {
+			return err
+		}
+
+		dstPath := dstDir + "/" +
+			strings.TrimSuffix(name, PROJECT_TEMPLATE_SUFFIX)
+		if err := ioutil.WriteFile(dstPath, []byte(contents),
+			info.Mode().Perm()); err != nil {
+
+			return util.ChildNewtError(err)
+		}
+	}
+
+	return nil
+}
+
+// Creates a new project in dstDir from the template in srcDir, substituting
+// the specified variables.
+func CreateFromTemplate(srcDir string, dstDir string,
+	userVals map[string]string) error {
+
+	tvars, err := readTemplateVars(srcDir)
+	if err != nil {
+		return err
+	}
+
+	vals, err := templateValues(tvars, userVals)
+	if err != nil {
+		return err
+	}
+
+	if err := renderTemplateDir(srcDir, dstDir, vals); err != nil {
+		os.RemoveAll(dstDir)
+		return err
+	}
+
+	if err := os.RemoveAll(filepath.Join(dstDir,
+		PROJECT_TEMPLATE_FILE_NAME)); err != nil {
+
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
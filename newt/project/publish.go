@@ -0,0 +1,183 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package project
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/compat"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/util"
+)
+
+type PublishOpts struct {
+	// Push the release tag to this remote; empty to skip pushing.
+	Remote string
+
+	// Validate only; don't create or push a tag.
+	DryRun bool
+}
+
+func gitCommand(dir string, args ...string) ([]byte, error) {
+	cmd := append([]string{"git", "-C", dir}, args...)
+	return util.ShellCommand(cmd, nil)
+}
+
+// Reads the repository.yml file at the base of the project and checks that
+// its version map is usable.
+//
+// @return                      The tag that the specified version maps to.
+func (proj *Project) validateRepoFile(vers string) (string, error) {
+	if util.NodeNotExist(proj.Path() + "/" + repo.REPO_FILE_NAME) {
+		return "", util.FmtNewtError(
+			"No %s file in %s; not a publishable repository",
+			repo.REPO_FILE_NAME, proj.Path())
+	}
+
+	v, err := util.ReadConfig(proj.Path(),
+		strings.TrimSuffix(repo.REPO_FILE_NAME, ".yml"))
+	if err != nil {
+		return "", err
+	}
+
+	name := v.GetString("repo.name")
+	if name == "" {
+		return "", util.FmtNewtError("%s: missing repo.name",
+			repo.REPO_FILE_NAME)
+	}
+
+	versMap := v.GetStringMapString("repo.versions")
+	rdesc, err := repo.NewRepoDesc(name, versMap)
+	if err != nil {
+		return "", util.FmtNewtError("%s: invalid version map: %s",
+			repo.REPO_FILE_NAME, err.Error())
+	}
+
+	if problems := rdesc.Validate(); len(problems) > 0 {
+		return "", util.FmtNewtError("%s: invalid version map:\n    %s",
+			repo.REPO_FILE_NAME, strings.Join(problems, "\n    "))
+	}
+
+	if _, err := compat.ReadNcMap(v); err != nil {
+		return "", err
+	}
+
+	pubVers, err := repo.LoadVersion(vers)
+	if err != nil {
+		return "", err
+	}
+	if pubVers == nil || pubVers.Stability() != repo.VERSION_STABILITY_NONE {
+		return "", util.FmtNewtError(
+			"Invalid release version: %s; must have the form X.Y.Z", vers)
+	}
+
+	tag, _, ok := rdesc.MatchVersion(pubVers)
+	if !ok {
+		return "", util.FmtNewtError(
+			"%s does not list version %s; add an entry mapping it to the "+
+				"release tag to repo.versions", repo.REPO_FILE_NAME, vers)
+	}
+
+	return tag, nil
+}
+
+// Parses every package in the repo.  Publishing fails if any package is
+// invalid.
+func (proj *Project) validatePackages() error {
+	pkgMap, warnings, err := pkg.ReadLocalPackages(proj.localRepo,
+		proj.localRepo.Path())
+	if err != nil {
+		return err
+	}
+
+	if len(warnings) > 0 {
+		sort.Strings(warnings)
+		return util.FmtNewtError("Package errors:\n    %s",
+			strings.Join(warnings, "\n    "))
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE, "%d packages parsed\n",
+		len(*pkgMap))
+	return nil
+}
+
+// Validates the repo for release as the specified version, tags the current
+// commit, and optionally pushes the tag.
+func (proj *Project) Publish(vers string, opts PublishOpts) error {
+	tag, err := proj.validateRepoFile(vers)
+	if err != nil {
+		return err
+	}
+
+	if err := proj.validatePackages(); err != nil {
+		return err
+	}
+
+	status, err := gitCommand(proj.Path(), "status", "--porcelain",
+		"--untracked-files=no")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(status)) != "" {
+		return util.FmtNewtError(
+			"Repository has uncommitted changes; commit them first")
+	}
+
+	if _, err := gitCommand(proj.Path(), "rev-parse", "--verify", "--quiet",
+		"refs/tags/"+tag); err == nil {
+
+		return util.FmtNewtError("Tag %s already exists", tag)
+	}
+
+	if opts.DryRun {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"Repository is ready to publish version %s as tag %s\n",
+			vers, tag)
+		return nil
+	}
+
+	if _, err := gitCommand(proj.Path(), "tag", "-a", tag, "-m",
+		fmt.Sprintf("Release %s", vers)); err != nil {
+
+		return err
+	}
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Tagged version %s as %s\n",
+		vers, tag)
+
+	if opts.Remote != "" {
+		if newtutil.NewtOffline {
+			return util.FmtNewtError(
+				"Cannot push tag %s: newt is in offline mode", tag)
+		}
+
+		if _, err := gitCommand(proj.Path(), "push", opts.Remote,
+			"refs/tags/"+tag); err != nil {
+
+			return err
+		}
+		util.StatusMessage(util.VERBOSITY_DEFAULT, "Pushed %s to %s\n",
+			tag, opts.Remote)
+	}
+
+	return nil
+}
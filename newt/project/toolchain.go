@@ -0,0 +1,273 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package project
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/downloader"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+	"mynewt.apache.org/newt/viper"
+)
+
+// Directory, inside the user's .newt directory, that "newt toolchain
+// install" installs toolchains to.  NEWT_TOOLCHAINS_DIR overrides it.
+const TOOLCHAINS_DIR = "toolchains"
+
+type ToolchainArchive struct {
+	Url    string
+	Sha256 string
+}
+
+// A toolchain version that a project requires.  Specified in project.yml:
+//
+//	toolchain.pins:
+//	    arm-none-eabi-gcc:
+//	        version: "12.2"
+//	        archives:
+//	            linux-amd64:
+//	                url: "https://example.com/arm-none-eabi-12.2.tar.xz"
+//	                sha256: "<hex digest>"
+//
+// The pin's name is the compiler executable it applies to.  Archives are
+// keyed by host platform ("<GOOS>-<GOARCH>").
+type ToolchainPin struct {
+	Name     string
+	Version  string
+	Archives map[string]ToolchainArchive
+}
+
+func readToolchainPins(v *viper.Viper) (map[string]*ToolchainPin, error) {
+	pins := map[string]*ToolchainPin{}
+
+	for name, itf := range cast.ToStringMap(v.Get("toolchain.pins")) {
+		entry := cast.ToStringMap(itf)
+
+		pin := &ToolchainPin{
+			Name:     name,
+			Version:  cast.ToString(entry["version"]),
+			Archives: map[string]ToolchainArchive{},
+		}
+		if pin.Version == "" {
+			return nil, util.FmtNewtError(
+				"toolchain.pins entry %s does not specify a version", name)
+		}
+
+		archives := cast.ToStringMap(entry["archives"])
+		for platform, aitf := range archives {
+			a := cast.ToStringMapString(aitf)
+			if a["url"] == "" || a["sha256"] == "" {
+				return nil, util.FmtNewtError(
+					"toolchain.pins entry %s: archive for %s must specify "+
+						"\"url\" and \"sha256\"", name, platform)
+			}
+			pin.Archives[platform] = ToolchainArchive{
+				Url:    a["url"],
+				Sha256: a["sha256"],
+			}
+		}
+
+		pins[name] = pin
+	}
+
+	return pins, nil
+}
+
+type toolchainPinSorter struct {
+	pins []*ToolchainPin
+}
+
+func (s toolchainPinSorter) Len() int {
+	return len(s.pins)
+}
+func (s toolchainPinSorter) Swap(i, j int) {
+	s.pins[i], s.pins[j] = s.pins[j], s.pins[i]
+}
+func (s toolchainPinSorter) Less(i, j int) bool {
+	return s.pins[i].Name < s.pins[j].Name
+}
+
+// Returns the project's toolchain pins, sorted by name.
+func (proj *Project) ToolchainPins() []*ToolchainPin {
+	pins := make([]*ToolchainPin, 0, len(proj.toolchainPins))
+	for _, pin := range proj.toolchainPins {
+		pins = append(pins, pin)
+	}
+	sort.Sort(toolchainPinSorter{pins})
+
+	return pins
+}
+
+// Returns the pin for the specified toolchain executable, or nil if the
+// project does not pin it.
+func (proj *Project) ToolchainPin(name string) *ToolchainPin {
+	return proj.toolchainPins[name]
+}
+
+// The platform key of the machine newt is running on (e.g., linux-amd64).
+func HostPlatform() string {
+	return runtime.GOOS + "-" + runtime.GOARCH
+}
+
+func ToolchainsDir() string {
+	if dir := os.Getenv("NEWT_TOOLCHAINS_DIR"); dir != "" {
+		return dir
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(usr.HomeDir, newtutil.NEWTRC_DIR, TOOLCHAINS_DIR)
+}
+
+// Returns the directory that the specified toolchain version is installed
+// to.
+func ToolchainInstallDir(name string, version string) string {
+	return filepath.Join(ToolchainsDir(), name+"-"+version)
+}
+
+// Puts the bin directory of each installed pinned toolchain at the front of
+// PATH, so that builds use the pinned version.
+func useInstalledToolchains(pins map[string]*ToolchainPin) {
+	names := make([]string, 0, len(pins))
+	for name, _ := range pins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		binDir := filepath.Join(
+			ToolchainInstallDir(name, pins[name].Version), "bin")
+		if util.NodeExist(binDir) {
+			log.Debugf("Using installed toolchain %s", binDir)
+			os.Setenv("PATH", binDir+string(os.PathListSeparator)+
+				os.Getenv("PATH"))
+		}
+	}
+}
+
+func extractToolchain(archivePath string, url string, dstDir string) error {
+	var cmd []string
+	if strings.HasSuffix(strings.ToLower(url), ".zip") {
+		cmd = []string{"unzip", "-q", archivePath, "-d", dstDir}
+	} else {
+		// tar detects the compression itself and, unlike newt's own
+		// extractor, preserves the symlinks toolchains rely on.
+		cmd = []string{"tar", "-xf", archivePath, "-C", dstDir}
+	}
+
+	if _, err := util.ShellCommand(cmd, nil); err != nil {
+		return util.FmtNewtError("Failed to extract %s: %s", url,
+			strings.TrimSpace(err.Error()))
+	}
+
+	return nil
+}
+
+// Downloads, verifies and extracts a toolchain archive into the toolchains
+// directory.  If the archive contains a single top-level directory, its
+// contents become the toolchain's root.  Returns the installation
+// directory.
+func InstallToolchain(name string, version string,
+	archive ToolchainArchive) (string, error) {
+
+	dir := ToolchainInstallDir(name, version)
+	if util.NodeExist(dir) {
+		return "", util.FmtNewtError("%s %s is already installed in %s",
+			name, version, dir)
+	}
+
+	ad := downloader.NewArchiveDownloader()
+	ad.Url = archive.Url
+	ad.Sha256 = archive.Sha256
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Downloading %s %s\n", name,
+		version)
+	archivePath, err := ad.Fetch()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(ToolchainsDir(), 0755); err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	// Extract next to the final location so that the rename can't cross
+	// file systems.
+	tmpDir, err := ioutil.TempDir(ToolchainsDir(), ".install-")
+	if err != nil {
+		return "", util.ChildNewtError(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Extracting %s %s\n", name,
+		version)
+	if err := extractToolchain(archivePath, archive.Url, tmpDir); err != nil {
+		return "", err
+	}
+
+	root := tmpDir
+	infos, err := ioutil.ReadDir(tmpDir)
+	if err != nil {
+		return "", util.ChildNewtError(err)
+	}
+	if len(infos) == 1 && infos[0].IsDir() {
+		root = filepath.Join(tmpDir, infos[0].Name())
+	}
+
+	if err := os.Rename(root, dir); err != nil {
+		return "", util.ChildNewtError(err)
+	}
+
+	exe := filepath.Join(dir, "bin", name)
+	if runtime.GOOS == "windows" {
+		exe += ".exe"
+	}
+	if util.NodeNotExist(exe) {
+		util.StatusMessage(util.VERBOSITY_QUIET,
+			"* Warning: %s does not contain %s\n", archive.Url,
+			filepath.Join("bin", filepath.Base(exe)))
+	}
+
+	return dir, nil
+}
+
+// Describes where to get a pinned toolchain for this machine.
+func (pin *ToolchainPin) HostArchive() (ToolchainArchive, error) {
+	a, ok := pin.Archives[HostPlatform()]
+	if !ok {
+		return a, util.FmtNewtError(
+			"toolchain.pins entry %s has no archive for %s",
+			pin.Name, HostPlatform())
+	}
+
+	return a, nil
+}
@@ -0,0 +1,299 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/util"
+)
+
+const VENDOR_DIR = "vendor"
+const VENDOR_PROVENANCE_FILE = "vendor.yml"
+
+// Describes where a vendored repo came from.
+type vendorProvenance struct {
+	name    string
+	vars    map[string]string
+	vers    string
+	commit  string
+	pkgList []string
+}
+
+func (proj *Project) vendorPath(rname string) string {
+	return VENDOR_DIR + "/" + rname
+}
+
+// Copies only the specified packages (plus the repository.yml file) from a
+// repo into its vendor directory.
+func copyRepoPkgs(r *repo.Repo, lpkgs []*pkg.LocalPackage,
+	dst string) ([]string, error) {
+
+	relPaths := make([]string, 0, len(lpkgs))
+	for _, lpkg := range lpkgs {
+		rel, err := filepath.Rel(r.Path(), lpkg.BasePath())
+		if err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+		relPaths = append(relPaths, filepath.ToSlash(rel))
+	}
+	sort.Strings(relPaths)
+
+	// Packages can be nested inside other packages.  Only copy the outermost
+	// directory in such cases.
+	copied := []string{}
+	for _, rel := range relPaths {
+		nested := false
+		for _, c := range copied {
+			if strings.HasPrefix(rel, c+"/") {
+				nested = true
+				break
+			}
+		}
+		if nested {
+			continue
+		}
+
+		if err := util.CopyDir(r.Path()+"/"+rel, dst+"/"+rel); err != nil {
+			return nil, err
+		}
+		copied = append(copied, rel)
+	}
+
+	repoFile := r.Path() + "/" + repo.REPO_FILE_NAME
+	if util.NodeExist(repoFile) {
+		if err := util.CopyFile(repoFile,
+			dst+"/"+repo.REPO_FILE_NAME); err != nil {
+
+			return nil, err
+		}
+	}
+
+	return relPaths, nil
+}
+
+func (proj *Project) vendorRepo(r *repo.Repo,
+	lpkgs []*pkg.LocalPackage) (vendorProvenance, error) {
+
+	prov := vendorProvenance{
+		name: r.Name(),
+		vars: proj.v.GetStringMapString("repository." + r.Name()),
+	}
+
+	if vers := proj.projState.GetInstalledVersion(r.Name()); vers != nil {
+		prov.vers = vers.String()
+	}
+
+	commit, err := r.HeadCommit()
+	if err != nil {
+		log.Debugf("%s", err.Error())
+	} else {
+		prov.commit = commit
+	}
+
+	dst := proj.Path() + "/" + proj.vendorPath(r.Name())
+	if err := os.RemoveAll(dst); err != nil {
+		return prov, util.ChildNewtError(err)
+	}
+
+	util.StatusMessage(util.VERBOSITY_DEFAULT, "Vendoring %s -> %s\n",
+		r.Name(), proj.vendorPath(r.Name()))
+
+	if lpkgs == nil {
+		if err := util.CopyDir(r.Path(), dst); err != nil {
+			return prov, err
+		}
+	} else {
+		prov.pkgList, err = copyRepoPkgs(r, lpkgs, dst)
+		if err != nil {
+			return prov, err
+		}
+	}
+
+	// The vendored copy is committed to the project, not tracked by git on
+	// its own.
+	if err := os.RemoveAll(dst + "/.git"); err != nil {
+		return prov, util.ChildNewtError(err)
+	}
+
+	return prov, nil
+}
+
+func writeProvenance(path string, provs []vendorProvenance) error {
+	buf := bytes.Buffer{}
+
+	fmt.Fprintf(&buf, "### Generated by newt vendor on %s.\n",
+		time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&buf, "### %s\n", newtutil.NewtVersionStr)
+
+	for _, prov := range provs {
+		fmt.Fprintf(&buf, "\nvendor.%s:\n", prov.name)
+
+		keys := make([]string, 0, len(prov.vars))
+		for k, _ := range prov.vars {
+			// Never record credentials.
+			if k == "token" || k == "login" || k == "password" {
+				continue
+			}
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(&buf, "    source:\n")
+		for _, k := range keys {
+			fmt.Fprintf(&buf, "        %s: %s\n", k, prov.vars[k])
+		}
+		if prov.vers != "" {
+			fmt.Fprintf(&buf, "    version: \"%s\"\n", prov.vers)
+		}
+		if prov.commit != "" {
+			fmt.Fprintf(&buf, "    commit: %s\n", prov.commit)
+		}
+		if prov.pkgList != nil {
+			fmt.Fprintf(&buf, "    packages:\n")
+			for _, p := range prov.pkgList {
+				fmt.Fprintf(&buf, "        - %s\n", p)
+			}
+		}
+	}
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Replaces the definition of each specified repo in project.yml with a
+// reference to its vendored copy.  The rest of the file, including comments,
+// is left intact.
+func (proj *Project) rewriteRepoDefs(rnames []string) error {
+	path := proj.Path() + "/" + PROJECT_FILE_NAME
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	rnameMap := map[string]struct{}{}
+	for _, rname := range rnames {
+		rnameMap[rname] = struct{}{}
+	}
+
+	lines := strings.Split(string(data), "\n")
+	out := []string{}
+	skipping := false
+	for _, line := range lines {
+		if skipping {
+			// The repo's definition ends at the next unindented line.
+			if line == "" || line[0] == ' ' || line[0] == '\t' {
+				continue
+			}
+			skipping = false
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "repository.") &&
+			strings.HasSuffix(trimmed, ":") && line[0] != ' ' {
+
+			rname := strings.TrimSuffix(
+				strings.TrimPrefix(trimmed, "repository."), ":")
+			if _, ok := rnameMap[rname]; ok {
+				out = append(out, line,
+					"    type: vendor",
+					"    path: "+proj.vendorPath(rname),
+					"")
+				skipping = true
+				continue
+			}
+		}
+
+		out = append(out, line)
+	}
+
+	contents := strings.TrimRight(strings.Join(out, "\n"), "\n") + "\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Copies every installed external repo into the project's vendor directory
+// and points project.yml at the copies.
+//
+// If usedPkgs is non-nil, only the packages it contains are vendored; it maps
+// repo name to packages.  Repos absent from the map are not vendored.
+func (proj *Project) Vendor(usedPkgs map[string][]*pkg.LocalPackage) error {
+	rnames := []string{}
+	for rname, r := range proj.repos {
+		if r.IsLocal() || r.IsVendored() || r.IsOverridden() {
+			continue
+		}
+		if usedPkgs != nil && usedPkgs[rname] == nil {
+			continue
+		}
+		if util.NodeNotExist(r.Path()) {
+			return util.FmtNewtError(
+				"Repository %s is not installed; run \"newt install\" first",
+				rname)
+		}
+		rnames = append(rnames, rname)
+	}
+	sort.Strings(rnames)
+
+	if len(rnames) == 0 {
+		util.StatusMessage(util.VERBOSITY_DEFAULT,
+			"No repositories to vendor\n")
+		return nil
+	}
+
+	provs := make([]vendorProvenance, 0, len(rnames))
+	for _, rname := range rnames {
+		var lpkgs []*pkg.LocalPackage
+		if usedPkgs != nil {
+			lpkgs = usedPkgs[rname]
+		}
+
+		prov, err := proj.vendorRepo(proj.repos[rname], lpkgs)
+		if err != nil {
+			return err
+		}
+		provs = append(provs, prov)
+	}
+
+	if err := writeProvenance(proj.Path()+"/"+VENDOR_DIR+"/"+
+		VENDOR_PROVENANCE_FILE, provs); err != nil {
+
+		return err
+	}
+
+	return proj.rewriteRepoDefs(rnames)
+}
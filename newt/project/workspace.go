@@ -0,0 +1,107 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/repo"
+	"mynewt.apache.org/newt/util"
+)
+
+// Marks the root of a workspace: a directory containing several projects
+// that share one copy of each repo.
+const WORKSPACE_FILE_NAME = "workspace.yml"
+
+// Environment variable that names the workspace directory explicitly.
+const WORKSPACE_ENV = "NEWT_WORKSPACE"
+
+// Searches the ancestors of the project directory for a workspace file.
+// Returns "" if the project is not part of a workspace.
+func findWorkspace(projDir string) string {
+	if dir := os.Getenv(WORKSPACE_ENV); dir != "" {
+		return dir
+	}
+
+	dir, err := filepath.Abs(projDir)
+	if err != nil {
+		return ""
+	}
+
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+
+		if util.NodeExist(filepath.Join(dir, WORKSPACE_FILE_NAME)) {
+			return dir
+		}
+	}
+}
+
+// Configures the project to use its workspace's shared repos directory, if
+// the project belongs to a workspace.  Each project keeps its own
+// project.state and project.lock.
+func (proj *Project) loadWorkspace() error {
+	wsDir := findWorkspace(proj.BasePath)
+	if wsDir == "" {
+		return nil
+	}
+
+	wsFile := filepath.Join(wsDir, WORKSPACE_FILE_NAME)
+	if util.NodeNotExist(wsFile) {
+		return util.FmtNewtError("Workspace %s has no %s file", wsDir,
+			WORKSPACE_FILE_NAME)
+	}
+
+	v, err := util.ReadConfig(wsDir,
+		strings.TrimSuffix(WORKSPACE_FILE_NAME, ".yml"))
+	if err != nil {
+		return err
+	}
+
+	reposDir := v.GetString("workspace.repos_dir")
+	if reposDir == "" {
+		reposDir = repo.REPOS_DIR
+	}
+	if !filepath.IsAbs(reposDir) {
+		reposDir = filepath.Join(wsDir, reposDir)
+	}
+	reposDir = filepath.ToSlash(filepath.Clean(reposDir))
+
+	log.Debugf("Project %s is in workspace %s; repos dir: %s",
+		proj.BasePath, wsDir, reposDir)
+
+	proj.workspace = wsDir
+	repo.SetReposDir(reposDir)
+
+	return nil
+}
+
+// Returns the directory of the workspace containing the project, or "" if
+// the project is standalone.
+func (proj *Project) Workspace() string {
+	return proj.workspace
+}
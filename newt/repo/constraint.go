@@ -0,0 +1,236 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package repo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/newt/interfaces"
+	"mynewt.apache.org/newt/util"
+)
+
+// The source of a constraint imposed by the project itself rather than by
+// another repo.
+const CONSTRAINT_SOURCE_PROJECT = "project.yml"
+
+// A version requirement that a project or repo imposes on a repo.
+type versConstraint struct {
+	source string
+	reqs   []interfaces.VersionReqInterface
+}
+
+func reqsString(reqs []interfaces.VersionReqInterface) string {
+	if len(reqs) == 0 {
+		return "any version"
+	}
+
+	strs := make([]string, len(reqs))
+	for i, req := range reqs {
+		strs[i] = req.CompareType() + req.Version().String()
+	}
+	return strings.Join(strs, " ")
+}
+
+func versionsString(versions []*Version) string {
+	if len(versions) == 0 {
+		return "none"
+	}
+
+	strs := make([]string, len(versions))
+	for i, vers := range versions {
+		strs[i] = vers.String()
+	}
+	return strings.Join(strs, ", ")
+}
+
+type versionSorter struct {
+	versions []*Version
+}
+
+func (s versionSorter) Len() int {
+	return len(s.versions)
+}
+func (s versionSorter) Swap(i, j int) {
+	s.versions[i], s.versions[j] = s.versions[j], s.versions[i]
+}
+func (s versionSorter) Less(i, j int) bool {
+	return s.versions[i].CompareVersions(s.versions[i], s.versions[j]) > 0
+}
+
+// Returns the numbered (i.e., not "-latest" / "-stable" aliases or tags)
+// versions in the repo description, newest first.
+func (rd *RepoDesc) concreteVersions() []*Version {
+	versions := []*Version{}
+	for vers, _ := range rd.vers {
+		if vers.Stability() == VERSION_STABILITY_NONE && vers.Tag() == "" {
+			versions = append(versions, vers)
+		}
+	}
+
+	sort.Sort(versionSorter{versions})
+
+	return versions
+}
+
+func (rd *RepoDesc) satisfyingVersions(
+	reqs []interfaces.VersionReqInterface) []*Version {
+
+	versions := []*Version{}
+	for _, vers := range rd.concreteVersions() {
+		if rd.SatisfiesVersion(vers, reqs) {
+			versions = append(versions, vers)
+		}
+	}
+
+	return versions
+}
+
+// Returns the versions that satisfy every constraint, newest first.  The
+// constraint at index skip is ignored (-1 to consider all of them).
+func (rd *RepoDesc) intersectConstraints(cs []versConstraint,
+	skip int) []*Version {
+
+	versions := []*Version{}
+	for _, vers := range rd.concreteVersions() {
+		ok := true
+		for i, c := range cs {
+			if i != skip && !rd.SatisfiesVersion(vers, c.reqs) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			versions = append(versions, vers)
+		}
+	}
+
+	return versions
+}
+
+// Describes a set of constraints that no version of a repo satisfies, along
+// with changes that would resolve the conflict.
+func conflictText(rname string, rd *RepoDesc, cs []versConstraint) string {
+	text := fmt.Sprintf("Conflict detected.  No version of repository %s "+
+		"satisfies all requirements:\n", rname)
+
+	for _, c := range cs {
+		text += fmt.Sprintf("    %s requires %s (satisfied by: %s)\n",
+			c.source, reqsString(c.reqs),
+			versionsString(rd.satisfyingVersions(c.reqs)))
+	}
+	text += fmt.Sprintf("Available versions: %s\n",
+		versionsString(rd.concreteVersions()))
+
+	suggestions := []string{}
+	for i, c := range cs {
+		versions := rd.intersectConstraints(cs, i)
+		if len(versions) == 0 {
+			continue
+		}
+
+		if c.source == CONSTRAINT_SOURCE_PROJECT {
+			suggestions = append(suggestions, fmt.Sprintf(
+				"change the \"vers\" setting of %s in project.yml to "+
+					"one of: %s", rname, versionsString(versions)))
+		} else {
+			suggestions = append(suggestions, fmt.Sprintf(
+				"use a version of %s whose requirement on %s allows one "+
+					"of: %s", c.source, rname, versionsString(versions)))
+		}
+	}
+
+	if len(suggestions) == 0 {
+		text += "No single requirement change resolves the conflict; " +
+			"several repositories need different versions.\n"
+	} else {
+		text += "Possible resolutions:\n"
+		for _, s := range suggestions {
+			text += "    * " + s + "\n"
+		}
+	}
+
+	return text
+}
+
+// Selects a version of the repo that satisfies every constraint.  If the
+// version selected by the project's own requirement does not satisfy the
+// other constraints, the newest version that does is used instead.
+func resolveConstraints(r *Repo, cs []versConstraint) error {
+	rd := r.rdesc
+	if rd == nil {
+		return util.FmtNewtError(
+			"Repository description for %s not downloaded", r.Name())
+	}
+
+	versions := rd.intersectConstraints(cs, -1)
+	if len(versions) == 0 {
+		return util.NewNewtError(
+			strings.TrimSuffix(conflictText(r.Name(), rd, cs), "\n"))
+	}
+
+	_, vers, ok := rd.Match(r)
+	if ok {
+		for _, v := range versions {
+			if v == vers {
+				return nil
+			}
+		}
+	}
+
+	best := versions[0]
+	util.StatusMessage(util.VERBOSITY_DEFAULT,
+		"Selecting %s version %s to satisfy repository dependencies\n",
+		r.Name(), best.String())
+	log.Debugf("Constraints on %s: %v", r.Name(), cs)
+
+	reqs, err := LoadVersionMatches(fmt.Sprintf("==%d.%d.%d",
+		best.Major(), best.Minor(), best.Revision()))
+	if err != nil {
+		return err
+	}
+	r.versreq = reqs
+
+	return nil
+}
+
+// Returns the newest numbered version in the repo description, or nil if
+// there are none.
+func (rd *RepoDesc) NewestVersion() *Version {
+	versions := rd.concreteVersions()
+	if len(versions) == 0 {
+		return nil
+	}
+	return versions[0]
+}
+
+// Returns the newest numbered version satisfying the specified requirements,
+// or nil if there are none.
+func (rd *RepoDesc) NewestSatisfying(
+	reqs []interfaces.VersionReqInterface) *Version {
+
+	versions := rd.satisfyingVersions(reqs)
+	if len(versions) == 0 {
+		return nil
+	}
+	return versions[0]
+}
@@ -25,6 +25,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -46,6 +47,22 @@ const REPO_DEFAULT_PERMS = 0755
 const REPO_FILE_NAME = "repository.yml"
 const REPOS_DIR = "repos"
 
+// Directory that repos are downloaded into.  If empty, the project's own
+// repos directory is used.  A workspace sets this so that its projects share
+// a single copy of each repo.
+var reposDir string
+
+func SetReposDir(dir string) {
+	reposDir = dir
+}
+
+func ReposDir() string {
+	if reposDir != "" {
+		return reposDir
+	}
+	return interfaces.GetProject().Path() + "/" + REPOS_DIR
+}
+
 type Repo struct {
 	name       string
 	downloader downloader.Downloader
@@ -56,12 +73,17 @@ type Repo struct {
 	ignDirs    []string
 	updated    bool
 	local      bool
+	vendored   bool
+	overridden bool
 	ncMap      compat.NewtCompatMap
 }
 
 type RepoDesc struct {
 	name string
 	vers map[*Version]string
+
+	// Version string -> commit that the version's tag must point to.
+	commits map[string]string
 }
 
 type RepoDependency struct {
@@ -200,59 +222,107 @@ func pickVersion(repo *Repo, versions []*Version) ([]*Version, error) {
 }
 
 func CheckDeps(upgrade bool, checkRepos map[string]*Repo) error {
-	// For each dependency, get it's version
-	depArray := map[string][]*Version{}
+	// Collect the constraints imposed on each repo, starting with the
+	// project's own requirement.
+	constraints := map[string][]versConstraint{}
 
-	for _, checkRepo := range checkRepos {
+	names := make([]string, 0, len(checkRepos))
+	for name, _ := range checkRepos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		checkRepo := checkRepos[name]
 		for _, rd := range checkRepo.Deps() {
 			lookupRepo := checkRepos[rd.Name()]
-
-			_, vers, ok := lookupRepo.rdesc.Match(rd.Storerepo)
-			if !ok {
-				return util.NewNewtError(fmt.Sprintf("No "+
-					"matching version for dependent repository %s", rd.name))
+			if lookupRepo == nil {
+				return util.FmtNewtError(
+					"Repository %s depends on unknown repository %s",
+					checkRepo.Name(), rd.Name())
 			}
-			log.Debugf("Dependency for %s: %s (%s)", checkRepo.Name(), rd.Name(), vers.String())
 
-			_, ok = depArray[rd.Name()]
-			if !ok {
-				depArray[rd.Name()] = []*Version{}
+			if constraints[rd.Name()] == nil {
+				constraints[rd.Name()] = []versConstraint{{
+					source: CONSTRAINT_SOURCE_PROJECT,
+					reqs:   lookupRepo.VersionRequirements(),
+				}}
 			}
-			depArray[rd.Name()] = append(depArray[rd.Name()], vers)
+			constraints[rd.Name()] = append(constraints[rd.Name()],
+				versConstraint{
+					source: checkRepo.Name(),
+					reqs:   rd.versreq,
+				})
 		}
 	}
 
-	for repoName, depVersList := range depArray {
-		if len(depVersList) <= 1 {
+	for _, repoName := range names {
+		cs := constraints[repoName]
+		if cs == nil {
 			continue
 		}
 
-		pickVer := false
-		for _, depVers := range depVersList {
-			if depVers.Tag() != "" {
-				pickVer = true
-				break
+		// Tags cannot be ordered, so ranges involving them cannot be
+		// intersected.  Have the user pick one.
+		hasTag := false
+		for _, c := range cs {
+			for _, req := range c.reqs {
+				if req.Version().Tag() != "" {
+					hasTag = true
+				}
 			}
 		}
-		if pickVer {
-			newArray, err := pickVersion(checkRepos[repoName],
-				depArray[repoName])
-			depArray[repoName] = newArray
-			if err != nil {
+
+		if hasTag {
+			if err := checkTagDeps(checkRepos[repoName], cs); err != nil {
+				return err
+			}
+		} else {
+			if err := resolveConstraints(checkRepos[repoName],
+				cs); err != nil {
+
 				return err
 			}
 		}
 	}
-	for repoName, depVersList := range depArray {
-		for _, depVers := range depVersList {
-			for _, curVers := range depVersList {
-				if depVers.CompareVersions(depVers, curVers) != 0 ||
-					depVers.Stability() != curVers.Stability() {
-					return util.NewNewtError(fmt.Sprintf(
-						"Conflict detected.  Repository %s has multiple dependency versions on %s. "+
-							"Notion of repository version is %s, whereas required is %s ",
-						repoName, curVers, depVers))
-				}
+
+	return nil
+}
+
+func checkTagDeps(r *Repo, cs []versConstraint) error {
+	depVersList := []*Version{}
+
+	// The first constraint is the project's own; only dependencies matter
+	// here.
+	for _, c := range cs[1:] {
+		depRepo := &Repo{name: c.source, versreq: c.reqs}
+		_, vers, ok := r.rdesc.Match(depRepo)
+		if !ok {
+			return util.NewNewtError(fmt.Sprintf("No "+
+				"matching version for dependent repository %s", r.Name()))
+		}
+		log.Debugf("Dependency for %s: %s (%s)", c.source, r.Name(),
+			vers.String())
+
+		depVersList = append(depVersList, vers)
+	}
+
+	if len(depVersList) > 1 {
+		var err error
+		depVersList, err = pickVersion(r, depVersList)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, depVers := range depVersList {
+		for _, curVers := range depVersList {
+			if depVers.CompareVersions(depVers, curVers) != 0 ||
+				depVers.Stability() != curVers.Stability() {
+				return util.FmtNewtError(
+					"Conflict detected.  Repository %s is required at "+
+						"multiple versions: %s and %s",
+					r.Name(), curVers, depVers)
 			}
 		}
 	}
@@ -365,6 +435,65 @@ func (rd *RepoDesc) Init(name string, versBranchMap map[string]string) error {
 	return nil
 }
 
+// Checks the version map for entries that cannot be used: numbered versions
+// without a branch, and stability aliases (e.g., "1-latest") that do not
+// refer to a numbered version in the map.
+func (rd *RepoDesc) Validate() []string {
+	problems := []string{}
+
+	for vers, branch := range rd.vers {
+		if branch == "" {
+			problems = append(problems, fmt.Sprintf(
+				"version %s does not map to a branch or tag", vers))
+			continue
+		}
+
+		if vers.Stability() == VERSION_STABILITY_NONE {
+			continue
+		}
+
+		target, err := LoadVersion(branch)
+		if err != nil || target == nil {
+			problems = append(problems, fmt.Sprintf(
+				"version %s refers to invalid version \"%s\"", vers, branch))
+			continue
+		}
+		target.stability = VERSION_STABILITY_NONE
+		if _, _, ok := rd.MatchVersion(target); !ok {
+			problems = append(problems, fmt.Sprintf(
+				"version %s refers to unlisted version %s", vers, branch))
+		}
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+func (rd *RepoDesc) setCommits(commitMap map[string]string) error {
+	rd.commits = map[string]string{}
+	for versStr, commit := range commitMap {
+		vers, err := LoadVersion(versStr)
+		if err != nil {
+			return err
+		}
+		if vers == nil {
+			continue
+		}
+		rd.commits[vers.String()] = strings.TrimSpace(commit)
+	}
+
+	return nil
+}
+
+// Returns the commit that repository.yml records for the specified version
+// (repo.commits), or "" if none is recorded.
+func (rd *RepoDesc) ExpectedCommit(vers *Version) string {
+	if vers == nil {
+		return ""
+	}
+	return rd.commits[vers.String()]
+}
+
 func (rd *RepoDesc) String() string {
 	name := rd.name + "@"
 	for k, v := range rd.vers {
@@ -407,6 +536,19 @@ func (r *Repo) IsLocal() bool {
 	return r.local
 }
 
+// Indicates whether the repo's sources are committed to the project (see
+// "newt vendor").  Vendored repos are never downloaded or upgraded.
+func (r *Repo) IsVendored() bool {
+	return r.vendored
+}
+
+// Indicates whether the repo has been redirected to a working copy by a
+// repository.overrides entry.  Like vendored repos, overridden repos are left
+// alone by install, upgrade, and sync.
+func (r *Repo) IsOverridden() bool {
+	return r.overridden
+}
+
 func (r *Repo) VersionRequirements() []interfaces.VersionReqInterface {
 	return r.versreq
 }
@@ -421,13 +563,11 @@ func (r *Repo) VersionRequirementsString() string {
 }
 
 func (r *Repo) repoFilePath() string {
-	return interfaces.GetProject().Path() + "/" + REPOS_DIR + "/" +
-		".configs/" + r.name + "/"
+	return ReposDir() + "/.configs/" + r.name + "/"
 }
 
 func (r *Repo) patchesFilePath() string {
-	return interfaces.GetProject().Path() + "/" + REPOS_DIR +
-		"/.patches/"
+	return ReposDir() + "/.patches/"
 }
 
 func (r *Repo) downloadRepo(branchName string) error {
@@ -511,6 +651,48 @@ func (r *Repo) currentBranch() (string, error) {
 	return filepath.Base(branch), nil
 }
 
+// Retrieves the hash of the commit currently checked out in the repo.
+func (r *Repo) HeadCommit() (string, error) {
+	commit, err := r.downloader.HeadCommit(r.Path())
+	if err != nil {
+		return "", util.FmtNewtError(
+			"Error finding current commit for \"%s\" : %s",
+			r.Name(), err.Error())
+	}
+	return commit, nil
+}
+
+// Returns the hash of the repo's checked out contents; "" if the repo type
+// has no such hash.
+func (r *Repo) TreeHash() (string, error) {
+	tree, err := r.downloader.TreeHash(r.Path())
+	if err != nil {
+		return "", util.FmtNewtError(
+			"Error finding tree hash for \"%s\" : %s",
+			r.Name(), err.Error())
+	}
+	return tree, nil
+}
+
+// Indicates whether tracked files in the repo have been modified.
+func (r *Repo) HasLocalChanges() (bool, error) {
+	diff, err := r.downloader.LocalDiff(r.Path())
+	if err != nil {
+		return false, err
+	}
+	return len(diff) > 0, nil
+}
+
+// Checks out the specified commit, leaving the repo in a detached HEAD state.
+func (r *Repo) CheckoutCommit(commit string) error {
+	if err := r.downloader.CheckoutCommit(r.Path(), commit); err != nil {
+		return util.FmtNewtError(
+			"Error checking out commit %s of \"%s\" : %s",
+			commit, r.Name(), err.Error())
+	}
+	return nil
+}
+
 func (r *Repo) Install(force bool) (*Version, error) {
 	exists := util.NodeExist(r.Path())
 	if exists && !force {
@@ -666,6 +848,16 @@ func (r *Repo) DownloadDesc() error {
 		}
 	}
 
+	// In offline mode, fall back to the description downloaded by a previous
+	// install.  It may not list the latest versions, so anything computed
+	// from it (e.g., "newt outdated") may be stale.
+	if newtutil.NewtOffline && util.NodeExist(cpath+"/"+REPO_FILE_NAME) {
+		util.StatusMessage(util.VERBOSITY_QUIET,
+			"WARNING: Offline; using the cached description of repository "+
+				"%s, which may be stale\n", r.Name())
+		return nil
+	}
+
 	dl.SetBranch("master")
 	if err := dl.FetchFile(REPO_FILE_NAME,
 		cpath+"/"+REPO_FILE_NAME); err != nil {
@@ -746,6 +938,11 @@ func (r *Repo) ReadDesc() (*RepoDesc, []*Repo, error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	if err := rdesc.setCommits(
+		v.GetStringMapString("repo.commits")); err != nil {
+
+		return nil, nil, err
+	}
 	r.rdesc = rdesc
 
 	repos, err := r.readDepRepos(v)
@@ -777,8 +974,19 @@ func (r *Repo) Init(repoName string, rversreq string, d downloader.Downloader) e
 
 	if r.local {
 		r.localPath = filepath.ToSlash(filepath.Clean(path))
+	} else if r.overridden {
+		// Override paths may be absolute or relative to the project base.
+		if !filepath.IsAbs(r.localPath) {
+			r.localPath = path + "/" + r.localPath
+		}
+		r.localPath = filepath.ToSlash(filepath.Clean(r.localPath))
+	} else if r.vendored {
+		// The vendored path was set by the caller; it is relative to the
+		// project base.
+		r.localPath = filepath.ToSlash(filepath.Clean(path + "/" +
+			r.localPath))
 	} else {
-		r.localPath = filepath.ToSlash(filepath.Clean(path + "/" + REPOS_DIR + "/" + r.name))
+		r.localPath = filepath.ToSlash(filepath.Clean(ReposDir() + "/" + r.name))
 	}
 
 	return nil
@@ -823,6 +1031,32 @@ func NewRepo(repoName string, rversreq string, d downloader.Downloader) (*Repo,
 	return r, nil
 }
 
+func NewVendoredRepo(repoName string, vendorPath string) (*Repo, error) {
+	r := &Repo{
+		vendored:  true,
+		localPath: vendorPath,
+	}
+
+	if err := r.Init(repoName, "", nil); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func NewOverrideRepo(repoName string, overridePath string) (*Repo, error) {
+	r := &Repo{
+		overridden: true,
+		localPath:  overridePath,
+	}
+
+	if err := r.Init(repoName, "", nil); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
 func NewLocalRepo(repoName string) (*Repo, error) {
 	r := &Repo{
 		local: true,
@@ -0,0 +1,227 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package resolve
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/util"
+)
+
+// Returns the hard (i.e., non-API) dependencies of a package, sorted by name.
+// API dependencies are excluded because packages commonly require an API
+// supplied by one of their own dependers (e.g., a BSP and the HAL).
+func hardDeps(rpkg *ResolvePackage) []*ResolveDep {
+	deps := []*ResolveDep{}
+	for _, dep := range rpkg.Deps {
+		if dep.Api == "" {
+			deps = append(deps, dep)
+		}
+	}
+
+	return SortResolveDeps(deps)
+}
+
+type componentSorter struct {
+	comps [][]*ResolvePackage
+}
+
+func (s componentSorter) Len() int {
+	return len(s.comps)
+}
+func (s componentSorter) Swap(i, j int) {
+	s.comps[i], s.comps[j] = s.comps[j], s.comps[i]
+}
+func (s componentSorter) Less(i, j int) bool {
+	return s.comps[i][0].Lpkg.FullName() < s.comps[j][0].Lpkg.FullName()
+}
+
+// Partitions the hard dependency graph into strongly connected components
+// (Tarjan's algorithm).  Only components that contain a cycle are returned:
+// those with several packages, or a single package that depends on itself.
+func cyclicComponents(rpkgs []*ResolvePackage) [][]*ResolvePackage {
+	index := map[*ResolvePackage]int{}
+	lowlink := map[*ResolvePackage]int{}
+	onStack := map[*ResolvePackage]bool{}
+	var stack []*ResolvePackage
+	var comps [][]*ResolvePackage
+
+	var strongConnect func(rpkg *ResolvePackage)
+	strongConnect = func(rpkg *ResolvePackage) {
+		index[rpkg] = len(index)
+		lowlink[rpkg] = index[rpkg]
+		stack = append(stack, rpkg)
+		onStack[rpkg] = true
+
+		selfDep := false
+		for _, dep := range hardDeps(rpkg) {
+			if dep.Rpkg == rpkg {
+				selfDep = true
+			}
+
+			if _, ok := index[dep.Rpkg]; !ok {
+				strongConnect(dep.Rpkg)
+				if lowlink[dep.Rpkg] < lowlink[rpkg] {
+					lowlink[rpkg] = lowlink[dep.Rpkg]
+				}
+			} else if onStack[dep.Rpkg] && index[dep.Rpkg] < lowlink[rpkg] {
+				lowlink[rpkg] = index[dep.Rpkg]
+			}
+		}
+
+		if lowlink[rpkg] != index[rpkg] {
+			return
+		}
+
+		var comp []*ResolvePackage
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			comp = append(comp, top)
+			if top == rpkg {
+				break
+			}
+		}
+
+		if len(comp) > 1 || selfDep {
+			comps = append(comps, SortResolvePkgs(comp))
+		}
+	}
+
+	for _, rpkg := range SortResolvePkgs(rpkgs) {
+		if _, ok := index[rpkg]; !ok {
+			strongConnect(rpkg)
+		}
+	}
+
+	sort.Sort(componentSorter{comps})
+
+	return comps
+}
+
+// Finds a shortest cycle that starts and ends at the first package of a
+// cyclic component.  The returned dependencies form the cycle in order.
+func componentCycle(comp []*ResolvePackage) []*ResolveDep {
+	members := map[*ResolvePackage]bool{}
+	for _, rpkg := range comp {
+		members[rpkg] = true
+	}
+
+	start := comp[0]
+	prev := map[*ResolvePackage]*ResolveDep{}
+	from := map[*ResolvePackage]*ResolvePackage{}
+	queue := []*ResolvePackage{start}
+
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		for _, dep := range hardDeps(cur) {
+			if !members[dep.Rpkg] {
+				continue
+			}
+
+			if dep.Rpkg == start {
+				cycle := []*ResolveDep{dep}
+				for p := cur; p != start; p = from[p] {
+					cycle = append([]*ResolveDep{prev[p]}, cycle...)
+				}
+				return cycle
+			}
+
+			if _, ok := prev[dep.Rpkg]; !ok {
+				prev[dep.Rpkg] = dep
+				from[dep.Rpkg] = cur
+				queue = append(queue, dep.Rpkg)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the location ("<file>:<line>") of the pkg.yml entry that makes one
+// package depend on another.  The line number is omitted if the entry can't
+// be found.
+func depLocation(lpkg *pkg.LocalPackage, depLpkg *pkg.LocalPackage) string {
+	relPath := strings.TrimPrefix(lpkg.RelativePath(), "/")
+	if relPath != "" {
+		relPath += "/"
+	}
+	loc := relPath + pkg.PACKAGE_FILE_NAME
+
+	data, err := ioutil.ReadFile(lpkg.BasePath() + "/" + pkg.PACKAGE_FILE_NAME)
+	if err != nil {
+		return loc
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "-") {
+			continue
+		}
+		line = strings.Trim(strings.TrimSpace(line[1:]), "\"'")
+
+		_, name, err := newtutil.ParsePackageString(line)
+		if err == nil && name == depLpkg.Name() {
+			return fmt.Sprintf("%s:%d", loc, i+1)
+		}
+	}
+
+	return loc
+}
+
+// Checks the resolved packages for dependency cycles.  Each cycle is
+// reported with the pkg.yml entry responsible for every link.
+func (r *Resolver) detectCycles() error {
+	comps := cyclicComponents(r.rpkgSlice())
+	if len(comps) == 0 {
+		return nil
+	}
+
+	str := "Dependency cycle detected:"
+	if len(comps) > 1 {
+		str = fmt.Sprintf("%d dependency cycles detected:", len(comps))
+	}
+
+	for _, comp := range comps {
+		str += "\n"
+		parent := comp[0]
+		for _, dep := range componentCycle(comp) {
+			cond := ""
+			if len(dep.Settings) > 0 {
+				cond = " (if " + strings.Join(dep.Settings, "|") + ")"
+			}
+
+			str += fmt.Sprintf("    %s --> %s%s [%s]\n",
+				parent.Lpkg.FullName(), dep.Rpkg.Lpkg.FullName(), cond,
+				depLocation(parent.Lpkg, dep.Rpkg.Lpkg))
+			parent = dep.Rpkg
+		}
+	}
+
+	return util.NewNewtError(strings.TrimSpace(str))
+}
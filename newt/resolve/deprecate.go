@@ -0,0 +1,134 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package resolve
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"mynewt.apache.org/newt/newt/pkg"
+)
+
+// A deprecated package that is still part of a resolution.
+type DeprecatedPkg struct {
+	Package     string `json:"package"`
+	Message     string `json:"message,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+
+	// The packages that still reference the deprecated one: its dependers,
+	// plus the target if the package is one of the target's seeds (e.g., its
+	// app or BSP).
+	Dependents []string `json:"dependents"`
+}
+
+type deprecatedPkgSorter struct {
+	deps []DeprecatedPkg
+}
+
+func (s deprecatedPkgSorter) Len() int {
+	return len(s.deps)
+}
+func (s deprecatedPkgSorter) Swap(i, j int) {
+	s.deps[i], s.deps[j] = s.deps[j], s.deps[i]
+}
+func (s deprecatedPkgSorter) Less(i, j int) bool {
+	return s.deps[i].Package < s.deps[j].Package
+}
+
+// Finds the deprecated packages in the resolution's master set, sorted by
+// name.
+func (res *Resolution) findDeprecations() []DeprecatedPkg {
+	dependents := map[*ResolvePackage]map[string]bool{}
+	addDependent := func(rpkg *ResolvePackage, name string) {
+		if dependents[rpkg] == nil {
+			dependents[rpkg] = map[string]bool{}
+		}
+		dependents[rpkg][name] = true
+	}
+
+	for _, rpkg := range res.MasterSet.Rpkgs {
+		for dep, _ := range rpkg.Deps {
+			if dep != rpkg {
+				addDependent(dep, rpkg.Lpkg.FullName())
+			}
+		}
+	}
+
+	targetName := ""
+	for _, seed := range res.Seeds {
+		if seed.Lpkg.Type() == pkg.PACKAGE_TYPE_TARGET {
+			targetName = seed.Lpkg.FullName()
+		}
+	}
+	if targetName != "" {
+		for _, seed := range res.Seeds {
+			if seed.Lpkg.Type() != pkg.PACKAGE_TYPE_TARGET {
+				addDependent(seed, targetName)
+			}
+		}
+	}
+
+	deps := []DeprecatedPkg{}
+	for _, rpkg := range res.MasterSet.Rpkgs {
+		d := rpkg.Lpkg.Deprecation()
+		if d == nil {
+			continue
+		}
+
+		dp := DeprecatedPkg{
+			Package:     rpkg.Lpkg.FullName(),
+			Message:     d.Message,
+			Replacement: d.Replacement,
+			Dependents:  []string{},
+		}
+		for name, _ := range dependents[rpkg] {
+			dp.Dependents = append(dp.Dependents, name)
+		}
+		sort.Strings(dp.Dependents)
+
+		deps = append(deps, dp)
+	}
+
+	sort.Sort(deprecatedPkgSorter{deps})
+
+	return deps
+}
+
+// Describes each deprecated package in the resolution, one per line.
+func (res *Resolution) DeprecationText() string {
+	str := ""
+	for _, d := range res.Deprecations {
+		str += fmt.Sprintf("package %s is deprecated", d.Package)
+		if d.Replacement != "" {
+			str += fmt.Sprintf("; use %s instead", d.Replacement)
+		}
+		if d.Message != "" {
+			str += fmt.Sprintf(" (%s)", d.Message)
+		}
+		if len(d.Dependents) > 0 {
+			str += fmt.Sprintf("; referenced by: %s",
+				strings.Join(d.Dependents, ", "))
+		}
+		str += "\n"
+	}
+
+	return str
+}
@@ -0,0 +1,146 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package resolve
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/spf13/cast"
+
+	"mynewt.apache.org/newt/newt/pkg"
+)
+
+// A dependency or API requirement declared in a package's pkg.yml, along with
+// the syscfg setting guarding it.
+type DeclaredDep struct {
+	// "dep" for a pkg.deps entry; "req_api" for a pkg.req_apis entry.
+	Kind string `json:"kind"`
+
+	// The dependency string or required API as written in pkg.yml.
+	Name string `json:"name"`
+
+	// The setting guarding the entry; "" if the entry is unconditional.
+	Setting string `json:"setting,omitempty"`
+
+	// Whether the entry is an OVERWRITE list that replaces all others when
+	// its setting is enabled.
+	Overwrite bool `json:"overwrite,omitempty"`
+
+	// The setting's value in the target, and whether the target defines it
+	// at all.
+	Value   string `json:"value,omitempty"`
+	Defined bool   `json:"defined"`
+
+	// Whether the entry applies in the target.
+	Active bool `json:"active"`
+}
+
+var declaredDepKinds = []struct {
+	kind string
+	key  string
+}{
+	{"dep", "pkg.deps"},
+	{"req_api", "pkg.req_apis"},
+}
+
+// Lists every dependency and API requirement a package declares, including
+// conditional ones, and evaluates each condition against the resolution's
+// syscfg.  Dependencies are listed before API requirements; unconditional
+// entries come first, followed by conditional ones in setting order.
+func (res *Resolution) DeclaredDeps(lpkg *pkg.LocalPackage) []DeclaredDep {
+	features := res.Cfg.FeaturesForLpkg(lpkg)
+
+	// Settings are matched case-insensitively, as in the rest of newt.
+	settingNames := map[string]string{}
+	for name, _ := range res.Cfg.Settings {
+		settingNames[strings.ToLower(name)] = name
+	}
+	for name, _ := range lpkg.InjectedSettings() {
+		settingNames[strings.ToLower(name)] = name
+	}
+
+	allKeys := lpkg.PkgV.AllKeys()
+	sort.Strings(allKeys)
+
+	decls := []DeclaredDep{}
+	for _, k := range declaredDepKinds {
+		var kindDecls []DeclaredDep
+
+		for _, name := range cast.ToStringSlice(lpkg.PkgV.Get(k.key)) {
+			kindDecls = append(kindDecls, DeclaredDep{
+				Kind:   k.kind,
+				Name:   name,
+				Active: true,
+			})
+		}
+
+		// Conditional entries; each key has the form
+		// <base-key>.<setting>[.OVERWRITE].
+		activeOverwrite := ""
+		for _, key := range allKeys {
+			if !strings.HasPrefix(key, k.key+".") {
+				continue
+			}
+
+			setting := strings.TrimPrefix(key, k.key+".")
+			overwrite := strings.HasSuffix(setting, ".overwrite")
+			setting = strings.TrimSuffix(setting, ".overwrite")
+
+			d := DeclaredDep{
+				Kind:      k.kind,
+				Setting:   strings.ToUpper(setting),
+				Overwrite: overwrite,
+			}
+			if name, ok := settingNames[setting]; ok {
+				d.Setting = name
+				d.Defined = true
+				if entry, ok := res.Cfg.Settings[name]; ok {
+					d.Value = entry.Value
+				} else {
+					d.Value = lpkg.InjectedSettings()[name]
+				}
+			}
+			d.Active = features[d.Setting]
+
+			// The first enabled OVERWRITE list (in setting order) replaces
+			// every other list.
+			if overwrite && d.Active && activeOverwrite == "" {
+				activeOverwrite = d.Setting
+			}
+
+			for _, name := range cast.ToStringSlice(lpkg.PkgV.Get(key)) {
+				d.Name = name
+				kindDecls = append(kindDecls, d)
+			}
+		}
+
+		if activeOverwrite != "" {
+			for i, _ := range kindDecls {
+				kindDecls[i].Active = kindDecls[i].Overwrite &&
+					kindDecls[i].Setting == activeOverwrite
+			}
+		}
+
+		decls = append(decls, kindDecls...)
+	}
+
+	return decls
+}
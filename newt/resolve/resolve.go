@@ -34,12 +34,22 @@ import (
 	"mynewt.apache.org/newt/util"
 )
 
+// Upper bound on the number of times syscfg is recalculated while resolving a
+// target.  Each reload may only add packages, so reaching this indicates a
+// pathological configuration.
+const RESOLVE_MAX_CFG_ITERATIONS = 1000
+
 type Resolver struct {
 	apis             map[string]*ResolvePackage
 	pkgMap           map[*pkg.LocalPackage]*ResolvePackage
 	injectedSettings map[string]string
 	flashMap         flash.FlashMap
 	cfg              syscfg.Cfg
+
+	// Every package seen supplying each API, and the package (by name) the
+	// target prefers for any API with several suppliers.
+	apiCandidates map[string][]*ResolvePackage
+	apiPrefs      map[string]string
 }
 
 type ResolveDep struct {
@@ -49,7 +59,12 @@ type ResolveDep struct {
 	// Name of API that generated the dependency; "" if a hard dependency.
 	Api string
 
-	// XXX: slice of syscfg settings that generated this dependency.
+	// Syscfg settings that enabled this dependency; empty if the dependency
+	// is unconditional.
+	Settings []string
+
+	// Whether the dependency has been listed unconditionally.
+	unconditional bool
 }
 
 type ResolvePackage struct {
@@ -77,19 +92,33 @@ type Resolution struct {
 	ApiMap          map[string]*ResolvePackage
 	UnsatisfiedApis map[string][]*ResolvePackage
 
+	// APIs supplied by several packages without a valid preference, mapped
+	// to all of their suppliers.
+	ApiConflicts map[string][]*ResolvePackage
+	apiPrefs     map[string]string
+
 	LpkgRpkgMap map[*pkg.LocalPackage]*ResolvePackage
 
+	// The packages resolution started from (target, BSP, app, etc.).  Every
+	// other package is reachable from at least one of these.
+	Seeds []*ResolvePackage
+
 	// Contains all dependencies; union of loader and app.
 	MasterSet *ResolveSet
 
 	LoaderSet *ResolveSet
 	AppSet    *ResolveSet
+
+	// Packages marked deprecated in their pkg.yml that the target still
+	// uses.
+	Deprecations []DeprecatedPkg
 }
 
 func newResolver(
 	seedPkgs []*pkg.LocalPackage,
 	injectedSettings map[string]string,
-	flashMap flash.FlashMap) *Resolver {
+	flashMap flash.FlashMap,
+	apiPrefs map[string]string) *Resolver {
 
 	r := &Resolver{
 		apis:             map[string]*ResolvePackage{},
@@ -97,11 +126,16 @@ func newResolver(
 		injectedSettings: injectedSettings,
 		flashMap:         flashMap,
 		cfg:              syscfg.NewCfg(),
+		apiCandidates:    map[string][]*ResolvePackage{},
+		apiPrefs:         apiPrefs,
 	}
 
 	if injectedSettings == nil {
 		r.injectedSettings = map[string]string{}
 	}
+	if apiPrefs == nil {
+		r.apiPrefs = map[string]string{}
+	}
 
 	for _, lpkg := range seedPkgs {
 		r.addPkg(lpkg)
@@ -114,6 +148,7 @@ func newResolution() *Resolution {
 	r := &Resolution{
 		ApiMap:          map[string]*ResolvePackage{},
 		UnsatisfiedApis: map[string][]*ResolvePackage{},
+		ApiConflicts:    map[string][]*ResolvePackage{},
 	}
 
 	r.MasterSet = &ResolveSet{Res: r}
@@ -162,6 +197,35 @@ func (rpkg *ResolvePackage) AddDep(apiPkg *ResolvePackage, api string) bool {
 	}
 }
 
+// Records the syscfg settings that caused a dependency to be listed.  An empty
+// settings slice indicates the dependency is unconditional, which overrides
+// any settings recorded previously.
+func (dep *ResolveDep) addSettings(settings []string) {
+	if dep.unconditional {
+		return
+	}
+
+	if len(settings) == 0 {
+		dep.unconditional = true
+		dep.Settings = nil
+		return
+	}
+
+	for _, s := range settings {
+		found := false
+		for _, cur := range dep.Settings {
+			if cur == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dep.Settings = append(dep.Settings, s)
+		}
+	}
+	sort.Strings(dep.Settings)
+}
+
 func (r *Resolver) rpkgSlice() []*ResolvePackage {
 	rpkgs := make([]*ResolvePackage, len(r.pkgMap))
 
@@ -200,20 +264,47 @@ func (r *Resolver) addPkg(lpkg *pkg.LocalPackage) (*ResolvePackage, bool) {
 	return rpkg, true
 }
 
-// @return bool                 true if this is a new API.
+// Indicates whether the specified package is named by the target's
+// preference for the specified API.
+func (r *Resolver) apiPreferred(apiString string, rpkg *ResolvePackage) bool {
+	pref := r.apiPrefs[apiString]
+	return pref != "" &&
+		(pref == rpkg.Lpkg.FullName() || pref == rpkg.Lpkg.Name())
+}
+
+// Records a package as a supplier of an API.  If several packages supply the
+// same API, the one named in the target's preferences is selected.
+//
+// @return bool                 true if the API is new or its supplier
+//                                  changed.
 func (r *Resolver) addApi(apiString string, rpkg *ResolvePackage) bool {
+	found := false
+	for _, cand := range r.apiCandidates[apiString] {
+		if cand == rpkg {
+			found = true
+			break
+		}
+	}
+	if !found {
+		r.apiCandidates[apiString] = append(r.apiCandidates[apiString], rpkg)
+	}
+
 	curRpkg := r.apis[apiString]
 	if curRpkg == nil {
 		r.apis[apiString] = rpkg
 		return true
-	} else {
-		if curRpkg != rpkg {
-			util.StatusMessage(util.VERBOSITY_QUIET,
-				"Warning: API conflict: %s (%s <-> %s)\n", apiString,
-				curRpkg.Lpkg.Name(), rpkg.Lpkg.Name())
-		}
-		return false
 	}
+
+	if curRpkg != rpkg && !r.apiPreferred(apiString, curRpkg) &&
+		r.apiPreferred(apiString, rpkg) {
+
+		log.Debugf("API %s: preferring %s over %s", apiString,
+			rpkg.Lpkg.FullName(), curRpkg.Lpkg.FullName())
+		r.apis[apiString] = rpkg
+		return true
+	}
+
+	return false
 }
 
 // Searches for a package which can satisfy bpkg's API requirement.  If such a
@@ -243,19 +334,15 @@ func (r *Resolver) satisfyApi(rpkg *ResolvePackage, reqApi string) bool {
 // @return bool                 true if a new dependency was detected as a
 //                                  result of satisfying an API for this
 //                                  package.
-func (r *Resolver) satisfyApis(rpkg *ResolvePackage) bool {
+func (r *Resolver) satisfyApis(rpkg *ResolvePackage, info *depInfo) bool {
 	// Assume all this package's APIs are satisfied and that no new
 	// dependencies will be detected.
 	rpkg.apisSatisfied = true
 	newDeps := false
 
-	features := r.cfg.FeaturesForLpkg(rpkg.Lpkg)
-
 	// Determine if any of the package's API requirements can now be satisfied.
 	// If so, another full iteration is required.
-	reqApis := newtutil.GetStringSliceFeatures(rpkg.Lpkg.PkgV, features,
-		"pkg.req_apis")
-	for _, reqApi := range reqApis {
+	for _, reqApi := range info.reqApis {
 		reqStatus := rpkg.reqApiMap[reqApi]
 		if !reqStatus {
 			apiSatisfied := r.satisfyApi(rpkg, reqApi)
@@ -274,39 +361,100 @@ func (r *Resolver) satisfyApis(rpkg *ResolvePackage) bool {
 	return newDeps
 }
 
-// @return bool                 True if this this function changed the resolver
-//                                  state; another full iteration is required
-//                                  in this case.
-//         error                non-nil on failure.
-func (r *Resolver) loadDepsForPkg(rpkg *ResolvePackage) (bool, error) {
-	features := r.cfg.FeaturesForLpkg(rpkg.Lpkg)
+// A package's dependencies, APIs, and API requirements, evaluated against the
+// current syscfg features.  Reading these only inspects shared state, so it is
+// done for many packages in parallel; the results are then applied to the
+// resolver one package at a time.
+type depInfo struct {
+	deps     []*pkg.LocalPackage
+	depConds [][]string
+	apis     []string
+	reqApis  []string
+	err      error
+}
 
-	changed := false
-	newDeps := newtutil.GetStringSliceFeatures(rpkg.Lpkg.PkgV, features,
-		"pkg.deps")
+func (r *Resolver) readDepInfo(rpkg *ResolvePackage,
+	features map[string]bool) *depInfo {
+
+	info := &depInfo{}
+
+	depStrs, depSrcs := newtutil.GetStringSliceFeatureSrcs(rpkg.Lpkg.PkgV,
+		features, "pkg.deps")
 	depender := rpkg.Lpkg.Name()
-	for _, newDepStr := range newDeps {
-		newDep, err := pkg.NewDependency(rpkg.Lpkg.Repo(), newDepStr)
+	for _, depStr := range depStrs {
+		dep, err := pkg.NewDependency(rpkg.Lpkg.Repo(), depStr)
 		if err != nil {
-			return false, err
+			info.err = err
+			return info
 		}
 
-		lpkg, err := r.resolveDep(newDep, depender)
+		lpkg, err := r.resolveDep(dep, depender)
 		if err != nil {
-			return false, err
+			info.err = err
+			return info
+		}
+
+		info.deps = append(info.deps, lpkg)
+		info.depConds = append(info.depConds, depSrcs[depStr])
+	}
+
+	info.apis = newtutil.GetStringSliceFeatures(rpkg.Lpkg.PkgV, features,
+		"pkg.apis")
+	info.reqApis = newtutil.GetStringSliceFeatures(rpkg.Lpkg.PkgV, features,
+		"pkg.req_apis")
+
+	return info
+}
+
+// Reads the dependency information of each specified package in parallel.
+// Packages that are already fully resolved are skipped; their entries are
+// nil.
+func (r *Resolver) readDepInfos(rpkgs []*ResolvePackage) []*depInfo {
+	infos := make([]*depInfo, len(rpkgs))
+
+	// Most packages see the same feature set; only calculate a package-
+	// specific one for packages with injected settings.
+	features := r.cfg.Features()
+
+	newtutil.RunParallel(len(rpkgs), func(i int) {
+		rpkg := rpkgs[i]
+		if rpkg.depsResolved && rpkg.apisSatisfied {
+			return
+		}
+
+		if len(rpkg.Lpkg.InjectedSettings()) > 0 {
+			infos[i] = r.readDepInfo(rpkg, r.cfg.FeaturesForLpkg(rpkg.Lpkg))
+		} else {
+			infos[i] = r.readDepInfo(rpkg, features)
 		}
+	})
+
+	return infos
+}
+
+// @return bool                 True if this this function changed the resolver
+//                                  state; another full iteration is required
+//                                  in this case.
+//         error                non-nil on failure.
+func (r *Resolver) loadDepsForPkg(rpkg *ResolvePackage,
+	info *depInfo) (bool, error) {
 
+	if info.err != nil {
+		return false, info.err
+	}
+
+	changed := false
+	for i, lpkg := range info.deps {
 		depRpkg, _ := r.addPkg(lpkg)
 		if rpkg.AddDep(depRpkg, "") {
 			changed = true
 		}
+		rpkg.Deps[depRpkg].addSettings(info.depConds[i])
 	}
 
 	// Determine if this package supports any APIs that we haven't seen
 	// yet.  If so, another full iteration is required.
-	apis := newtutil.GetStringSliceFeatures(rpkg.Lpkg.PkgV, features,
-		"pkg.apis")
-	for _, api := range apis {
+	for _, api := range info.apis {
 		if r.addApi(api, rpkg) {
 			changed = true
 		}
@@ -324,12 +472,14 @@ func (r *Resolver) loadDepsForPkg(rpkg *ResolvePackage) (bool, error) {
 //
 // @return bool                 true if >=1 dependencies were resolved.
 //         error                non-nil on failure.
-func (r *Resolver) resolvePkg(rpkg *ResolvePackage) (bool, error) {
+func (r *Resolver) resolvePkg(rpkg *ResolvePackage,
+	info *depInfo) (bool, error) {
+
 	var err error
 	newDeps := false
 
 	if !rpkg.depsResolved {
-		newDeps, err = r.loadDepsForPkg(rpkg)
+		newDeps, err = r.loadDepsForPkg(rpkg, info)
 		if err != nil {
 			return false, err
 		}
@@ -338,7 +488,7 @@ func (r *Resolver) resolvePkg(rpkg *ResolvePackage) (bool, error) {
 	}
 
 	if !rpkg.apisSatisfied {
-		newApiDep := r.satisfyApis(rpkg)
+		newApiDep := r.satisfyApis(rpkg, info)
 		if newApiDep {
 			newDeps = true
 		}
@@ -380,8 +530,17 @@ func (r *Resolver) resolveDepsOnce() (bool, error) {
 	newDeps := false
 	for {
 		reprocess := false
-		for _, rpkg := range r.pkgMap {
-			newDeps, err := r.resolvePkg(rpkg)
+
+		// Packages discovered during this pass are processed in the next
+		// one.
+		rpkgs := r.rpkgSlice()
+		infos := r.readDepInfos(rpkgs)
+		for i, rpkg := range rpkgs {
+			if infos[i] == nil {
+				continue
+			}
+
+			newDeps, err := r.resolvePkg(rpkg, infos[i])
 			if err != nil {
 				return false, err
 			}
@@ -420,7 +579,14 @@ func (r *Resolver) resolveDepsAndCfg() error {
 		return err
 	}
 
-	for {
+	for i := 0; ; i++ {
+		if i >= RESOLVE_MAX_CFG_ITERATIONS {
+			return util.FmtNewtError("Dependency resolution did not "+
+				"converge after %d syscfg reloads; settings and "+
+				"conditional dependencies may be enabling each other "+
+				"indefinitely", i)
+		}
+
 		cfgChanged, err := r.reloadCfg()
 		if err != nil {
 			return err
@@ -442,6 +608,8 @@ func (r *Resolver) resolveDepsAndCfg() error {
 		}
 
 		if !newDeps && !cfgChanged {
+			statusMessage(util.VERBOSITY_VERBOSE, "Resolved %d packages "+
+				"after %d syscfg passes\n", len(r.pkgMap), i+1)
 			break
 		}
 	}
@@ -503,11 +671,35 @@ func (r *Resolver) apiResolution() (
 	return apiMap, unsatisfied
 }
 
+// Determines which APIs are supplied by more than one package without a
+// preference that selects one of them.  An API whose preference names a
+// package that doesn't supply it is also reported.
+func (r *Resolver) apiConflicts() map[string][]*ResolvePackage {
+	conflicts := map[string][]*ResolvePackage{}
+
+	for api, cands := range r.apiCandidates {
+		selected := false
+		for _, cand := range cands {
+			if r.apiPreferred(api, cand) {
+				selected = true
+				break
+			}
+		}
+
+		if !selected && (len(cands) > 1 || r.apiPrefs[api] != "") {
+			conflicts[api] = SortResolvePkgs(cands)
+		}
+	}
+
+	return conflicts
+}
+
 func ResolveFull(
 	loaderSeeds []*pkg.LocalPackage,
 	appSeeds []*pkg.LocalPackage,
 	injectedSettings map[string]string,
-	flashMap flash.FlashMap) (*Resolution, error) {
+	flashMap flash.FlashMap,
+	apiPrefs map[string]string) (*Resolution, error) {
 
 	// First, calculate syscfg and determine which package provides each
 	// required API.  Syscfg and APIs are project-wide; that is, they are
@@ -516,12 +708,16 @@ func ResolveFull(
 	// calculated here as a byproduct.
 
 	allSeeds := append(loaderSeeds, appSeeds...)
-	r := newResolver(allSeeds, injectedSettings, flashMap)
+	r := newResolver(allSeeds, injectedSettings, flashMap, apiPrefs)
 
 	if err := r.resolveDepsAndCfg(); err != nil {
 		return nil, err
 	}
 
+	if err := r.detectCycles(); err != nil {
+		return nil, err
+	}
+
 	res := newResolution()
 	res.Cfg = r.cfg
 	if err := r.resolveApiDeps(); err != nil {
@@ -532,10 +728,23 @@ func ResolveFull(
 	// unsatisfied.
 	apiMap := map[string]*ResolvePackage{}
 	apiMap, res.UnsatisfiedApis = r.apiResolution()
+	res.ApiConflicts = r.apiConflicts()
+	res.apiPrefs = r.apiPrefs
 
 	res.LpkgRpkgMap = r.pkgMap
+	seedMap := map[*ResolvePackage]struct{}{}
+	for _, lpkg := range allSeeds {
+		if rpkg := r.pkgMap[lpkg]; rpkg != nil {
+			seedMap[rpkg] = struct{}{}
+		}
+	}
+	for rpkg, _ := range seedMap {
+		res.Seeds = append(res.Seeds, rpkg)
+	}
+	res.Seeds = SortResolvePkgs(res.Seeds)
 
 	res.MasterSet.Rpkgs = r.rpkgSlice()
+	res.Deprecations = res.findDeprecations()
 
 	// If there is no loader, then the set of all packages is just the app
 	// packages.  We already resolved the necessary dependency information when
@@ -561,7 +770,7 @@ func ResolveFull(
 	}
 
 	// Resolve loader dependencies.
-	r = newResolver(loaderSeeds, injectedSettings, flashMap)
+	r = newResolver(loaderSeeds, injectedSettings, flashMap, apiPrefs)
 	r.cfg = res.Cfg
 
 	var err error
@@ -579,7 +788,7 @@ func ResolveFull(
 		}
 	}
 
-	r = newResolver(appSeeds, injectedSettings, flashMap)
+	r = newResolver(appSeeds, injectedSettings, flashMap, apiPrefs)
 	r.cfg = res.Cfg
 
 	res.AppSet.Rpkgs, err = r.resolveDeps()
@@ -590,6 +799,78 @@ func ResolveFull(
 	return res, nil
 }
 
+// Finds a shortest dependency path from one of the resolution's seed
+// packages to the specified package.  The returned path starts with the seed
+// and ends with the package; it is nil if the package is unreachable.
+func (res *Resolution) DepPath(dst *ResolvePackage) []*ResolvePackage {
+	prev := map[*ResolvePackage]*ResolvePackage{}
+	queue := []*ResolvePackage{}
+	for _, seed := range res.Seeds {
+		prev[seed] = nil
+		queue = append(queue, seed)
+	}
+
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		if cur == dst {
+			var path []*ResolvePackage
+			for p := cur; p != nil; p = prev[p] {
+				path = append([]*ResolvePackage{p}, path...)
+			}
+			return path
+		}
+
+		deps := make([]*ResolveDep, 0, len(cur.Deps))
+		for _, dep := range cur.Deps {
+			deps = append(deps, dep)
+		}
+		for _, dep := range SortResolveDeps(deps) {
+			if _, ok := prev[dep.Rpkg]; !ok {
+				prev[dep.Rpkg] = cur
+				queue = append(queue, dep.Rpkg)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (res *Resolution) apiConflictText() string {
+	apiNames := make([]string, 0, len(res.ApiConflicts))
+	for api, _ := range res.ApiConflicts {
+		apiNames = append(apiNames, api)
+	}
+	sort.Strings(apiNames)
+
+	str := "API conflicts detected:\n"
+	for _, api := range apiNames {
+		str += fmt.Sprintf("    * %s, supplied by:\n", api)
+		for _, rpkg := range res.ApiConflicts[api] {
+			names := []string{}
+			for _, p := range res.DepPath(rpkg) {
+				names = append(names, p.Lpkg.FullName())
+			}
+			str += fmt.Sprintf("        %s (%s)\n", rpkg.Lpkg.FullName(),
+				strings.Join(names, " --> "))
+		}
+
+		if pref := res.apiPrefs[api]; pref != "" {
+			str += fmt.Sprintf("      target.api_preferences selects %s, "+
+				"which does not supply this API\n", pref)
+		}
+	}
+	str += "Select a supplier for each API in target.yml; e.g.,\n" +
+		"    target.api_preferences:\n"
+	for _, api := range apiNames {
+		str += fmt.Sprintf("        %s: %s\n", api,
+			res.ApiConflicts[api][0].Lpkg.FullName())
+	}
+
+	return str
+}
+
 func (res *Resolution) ErrorText() string {
 	str := ""
 
@@ -616,6 +897,10 @@ func (res *Resolution) ErrorText() string {
 		}
 	}
 
+	if len(res.ApiConflicts) > 0 {
+		str += res.apiConflictText()
+	}
+
 	str += res.Cfg.ErrorText()
 
 	return strings.TrimSpace(str)
@@ -624,3 +909,8 @@ func (res *Resolution) ErrorText() string {
 func (res *Resolution) WarningText() string {
 	return res.Cfg.WarningText()
 }
+
+// Prints a status message according to the resolver's verbosity.
+func statusMessage(level int, message string, args ...interface{}) {
+	util.SubsysStatusMessage(util.SUBSYS_RESOLVER, level, message, args...)
+}
@@ -0,0 +1,220 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package resolve
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"mynewt.apache.org/newt/newt/flash"
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/pkg"
+	"mynewt.apache.org/newt/newt/project"
+)
+
+// Number of library packages in the synthetic project; roughly the size of a
+// large product tree.
+const benchNumPkgs = 600
+
+// Number of settings each library package defines.
+const benchNumSettings = 2
+
+// Every benchApiInterval-th package provides an API that the package after it
+// requires.
+const benchApiInterval = 50
+
+// Every benchCondInterval-th package has a dependency that is conditional on
+// one of its own settings, forcing syscfg reloads during resolution.
+const benchCondInterval = 100
+
+func benchWriteFile(b *testing.B, path string, lines []string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		b.Fatal(err)
+	}
+
+	contents := strings.Join(lines, "\n") + "\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func benchPkgName(i int) string {
+	return fmt.Sprintf("lib/p%d", i)
+}
+
+// Writes a project containing an app and benchNumPkgs library packages.  The
+// libraries form a binary tree with extra cross links, so most packages are
+// reached through several paths.
+func benchWriteProject(b *testing.B, dir string) {
+	benchWriteFile(b, filepath.Join(dir, "project.yml"), []string{
+		"project.name: bench",
+	})
+
+	benchWriteFile(b, filepath.Join(dir, "apps/bench/pkg.yml"), []string{
+		"pkg.name: apps/bench",
+		"pkg.type: app",
+		"pkg.deps:",
+		"    - " + benchPkgName(0),
+	})
+
+	for i := 0; i < benchNumPkgs; i++ {
+		deps := []string{}
+		for _, d := range []int{2*i + 1, 2*i + 2, 3*i + 5} {
+			if d < benchNumPkgs {
+				deps = append(deps, "    - "+benchPkgName(d))
+			}
+		}
+
+		lines := []string{
+			"pkg.name: " + benchPkgName(i),
+			"pkg.type: lib",
+		}
+		if len(deps) > 0 {
+			lines = append(lines, "pkg.deps:")
+			lines = append(lines, deps...)
+		}
+
+		if i%benchApiInterval == 0 {
+			lines = append(lines, "pkg.apis:",
+				fmt.Sprintf("    - bench_api_%d", i))
+		}
+		if i%benchApiInterval == 1 {
+			lines = append(lines, "pkg.req_apis:",
+				fmt.Sprintf("    - bench_api_%d", i-1))
+		}
+
+		cond := i%benchCondInterval == 0 && i+1 < benchNumPkgs
+		if cond {
+			lines = append(lines,
+				fmt.Sprintf("pkg.deps.BENCH_P%d_COND:", i),
+				"    - "+benchPkgName(i+1))
+		}
+
+		benchWriteFile(b, filepath.Join(dir, benchPkgName(i), "pkg.yml"),
+			lines)
+
+		defs := []string{"syscfg.defs:"}
+		for s := 0; s < benchNumSettings; s++ {
+			defs = append(defs,
+				fmt.Sprintf("    BENCH_P%d_VAL%d:", i, s),
+				"        description: 'Benchmark setting'",
+				fmt.Sprintf("        value: %d", s))
+		}
+		if cond {
+			defs = append(defs,
+				fmt.Sprintf("    BENCH_P%d_COND:", i),
+				"        description: 'Benchmark condition'",
+				"        value: 1")
+		}
+
+		benchWriteFile(b, filepath.Join(dir, benchPkgName(i), "syscfg.yml"),
+			defs)
+	}
+}
+
+// Creates the synthetic project and makes it the current one.  Returns a
+// function that undoes this.
+func benchSetup(b *testing.B) func() {
+	dir, err := ioutil.TempDir("", "newt-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchWriteProject(b, dir)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		b.Fatal(err)
+	}
+
+	return func() {
+		project.ResetProject()
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func benchLoadProject(b *testing.B) *project.Project {
+	project.ResetProject()
+	proj, err := project.TryGetProject()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return proj
+}
+
+// Runs the specified benchmark serially and, on a multi-core host, with one
+// job per CPU.
+func benchJobs(b *testing.B, fn func(b *testing.B)) {
+	saved := newtutil.NewtNumJobs
+	defer func() { newtutil.NewtNumJobs = saved }()
+
+	jobsList := []int{1}
+	if runtime.NumCPU() > 1 {
+		jobsList = append(jobsList, runtime.NumCPU())
+	}
+
+	for _, jobs := range jobsList {
+		newtutil.NewtNumJobs = jobs
+		b.Run(fmt.Sprintf("jobs=%d", jobs), fn)
+	}
+}
+
+func BenchmarkLoadPackages(b *testing.B) {
+	defer benchSetup(b)()
+
+	benchJobs(b, func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			benchLoadProject(b)
+		}
+	})
+}
+
+func BenchmarkResolveFull(b *testing.B) {
+	defer benchSetup(b)()
+
+	proj := benchLoadProject(b)
+	app, err := proj.ResolvePackage(proj.LocalRepo(), "apps/bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	benchJobs(b, func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			res, err := ResolveFull(nil, []*pkg.LocalPackage{app}, nil,
+				flash.FlashMap{}, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(res.MasterSet.Rpkgs) != benchNumPkgs+1 {
+				b.Fatalf("resolved %d packages; expected %d",
+					len(res.MasterSet.Rpkgs), benchNumPkgs+1)
+			}
+		}
+	})
+}
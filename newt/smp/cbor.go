@@ -0,0 +1,346 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package smp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// A minimal CBOR (RFC 7049) codec covering what the mcumgr groups exchange:
+// integers, strings, arrays, maps, booleans and null.  Devices encode maps
+// with indefinite lengths, so those are decoded too.
+
+const (
+	cborMajorUint   = 0
+	cborMajorNegint = 1
+	cborMajorBytes  = 2
+	cborMajorText   = 3
+	cborMajorArray  = 4
+	cborMajorMap    = 5
+	cborMajorTag    = 6
+	cborMajorSimple = 7
+)
+
+const cborBreak = 0xff
+
+func cborHead(buf *bytes.Buffer, major byte, val uint64) {
+	m := major << 5
+	switch {
+	case val < 24:
+		buf.WriteByte(m | byte(val))
+	case val <= math.MaxUint8:
+		buf.WriteByte(m | 24)
+		buf.WriteByte(byte(val))
+	case val <= math.MaxUint16:
+		buf.WriteByte(m | 25)
+		binary.Write(buf, binary.BigEndian, uint16(val))
+	case val <= math.MaxUint32:
+		buf.WriteByte(m | 26)
+		binary.Write(buf, binary.BigEndian, uint32(val))
+	default:
+		buf.WriteByte(m | 27)
+		binary.Write(buf, binary.BigEndian, val)
+	}
+}
+
+func cborEncodeInt(buf *bytes.Buffer, v int64) {
+	if v < 0 {
+		cborHead(buf, cborMajorNegint, uint64(-1-v))
+	} else {
+		cborHead(buf, cborMajorUint, uint64(v))
+	}
+}
+
+func cborEncodeItem(buf *bytes.Buffer, itf interface{}) error {
+	switch v := itf.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+	case bool:
+		if v {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case int:
+		cborEncodeInt(buf, int64(v))
+	case int64:
+		cborEncodeInt(buf, v)
+	case uint64:
+		cborHead(buf, cborMajorUint, v)
+	case []byte:
+		cborHead(buf, cborMajorBytes, uint64(len(v)))
+		buf.Write(v)
+	case string:
+		cborHead(buf, cborMajorText, uint64(len(v)))
+		buf.WriteString(v)
+	case []interface{}:
+		cborHead(buf, cborMajorArray, uint64(len(v)))
+		for _, elem := range v {
+			if err := cborEncodeItem(buf, elem); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k, _ := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		cborHead(buf, cborMajorMap, uint64(len(v)))
+		for _, k := range keys {
+			cborEncodeItem(buf, k)
+			if err := cborEncodeItem(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return util.FmtNewtError("can't encode %T as CBOR", itf)
+	}
+
+	return nil
+}
+
+func CborEncode(itf interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := cborEncodeItem(buf, itf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type cborDecoder struct {
+	data []byte
+	off  int
+}
+
+func (d *cborDecoder) errorf(format string, args ...interface{}) error {
+	return util.FmtNewtError("invalid CBOR at offset %d: %s", d.off,
+		fmt.Sprintf(format, args...))
+}
+
+func (d *cborDecoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.off) {
+		return nil, d.errorf("truncated")
+	}
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+// Reads an item's initial byte and argument.  indefinite is set for
+// indefinite-length strings, arrays and maps.
+func (d *cborDecoder) head() (major byte, info byte, val uint64,
+	indefinite bool, err error) {
+
+	b, err := d.take(1)
+	if err != nil {
+		return
+	}
+	major = b[0] >> 5
+	info = b[0] & 0x1f
+
+	switch {
+	case info < 24:
+		val = uint64(info)
+	case info <= 27:
+		var arg []byte
+		arg, err = d.take(1 << (info - 24))
+		if err != nil {
+			return
+		}
+		for _, c := range arg {
+			val = val<<8 | uint64(c)
+		}
+	case info == 31:
+		indefinite = true
+	default:
+		err = d.errorf("reserved additional info %d", info)
+	}
+
+	return
+}
+
+func (d *cborDecoder) atBreak() bool {
+	if d.off < len(d.data) && d.data[d.off] == cborBreak {
+		d.off++
+		return true
+	}
+	return false
+}
+
+func (d *cborDecoder) decodeString(major byte, val uint64,
+	indefinite bool) ([]byte, error) {
+
+	if !indefinite {
+		return d.take(val)
+	}
+
+	// Concatenate definite-length chunks until the break.
+	out := []byte{}
+	for !d.atBreak() {
+		m, _, n, indef, err := d.head()
+		if err != nil {
+			return nil, err
+		}
+		if m != major || indef {
+			return nil, d.errorf("bad string chunk")
+		}
+		chunk, err := d.take(n)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, chunk...)
+	}
+	return out, nil
+}
+
+func (d *cborDecoder) item() (interface{}, error) {
+	major, info, val, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborMajorUint:
+		return val, nil
+
+	case cborMajorNegint:
+		return -1 - int64(val), nil
+
+	case cborMajorBytes:
+		return d.decodeString(major, val, indefinite)
+
+	case cborMajorText:
+		b, err := d.decodeString(major, val, indefinite)
+		return string(b), err
+
+	case cborMajorArray:
+		arr := []interface{}{}
+		for i := uint64(0); indefinite || i < val; i++ {
+			if indefinite && d.atBreak() {
+				break
+			}
+			elem, err := d.item()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, elem)
+		}
+		return arr, nil
+
+	case cborMajorMap:
+		m := map[string]interface{}{}
+		for i := uint64(0); indefinite || i < val; i++ {
+			if indefinite && d.atBreak() {
+				break
+			}
+			k, err := d.item()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.item()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, d.errorf("non-string map key")
+			}
+			m[key] = v
+		}
+		return m, nil
+
+	case cborMajorTag:
+		// Tags only annotate the item that follows.
+		return d.item()
+
+	default:
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25:
+			return float64(halfToFloat(uint16(val))), nil
+		case 26:
+			return float64(math.Float32frombits(uint32(val))), nil
+		case 27:
+			return math.Float64frombits(val), nil
+		default:
+			return val, nil
+		}
+	}
+}
+
+func halfToFloat(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := int(h>>10) & 0x1f
+	frac := uint32(h & 0x3ff)
+
+	switch exp {
+	case 0:
+		f := float32(frac) / 1024 / 16384
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | frac<<13)
+	default:
+		return math.Float32frombits(sign | uint32(exp+112)<<23 | frac<<13)
+	}
+}
+
+// Decodes a CBOR map, as carried by every mcumgr request and response.
+func CborDecodeMap(data []byte) (map[string]interface{}, error) {
+	d := &cborDecoder{data: data}
+	itf, err := d.item()
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := itf.(map[string]interface{})
+	if !ok {
+		return nil, util.FmtNewtError("expected a CBOR map; got %T", itf)
+	}
+	return m, nil
+}
+
+// Returns a decoded integer field's value; 0 if it is absent or not an
+// integer.
+func CborInt(itf interface{}) int64 {
+	switch v := itf.(type) {
+	case uint64:
+		return int64(v)
+	case int64:
+		return v
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,206 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package smp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"time"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Erasing the secondary slot before the first chunk is acknowledged can take
+// several seconds.
+const SMP_ERASE_TIMEOUT = 30 * time.Second
+
+// An image slot, as reported by the image group's state command.
+type ImageSlot struct {
+	Image     int    `json:"image"`
+	Slot      int    `json:"slot"`
+	Version   string `json:"version"`
+	Hash      string `json:"hash"`
+	Bootable  bool   `json:"bootable"`
+	Pending   bool   `json:"pending"`
+	Confirmed bool   `json:"confirmed"`
+	Active    bool   `json:"active"`
+	Permanent bool   `json:"permanent"`
+}
+
+func parseImageState(rsp map[string]interface{}) []ImageSlot {
+	slots := []ImageSlot{}
+
+	images, _ := rsp["images"].([]interface{})
+	for _, itf := range images {
+		m, ok := itf.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		hash, _ := m["hash"].([]byte)
+		version, _ := m["version"].(string)
+		flag := func(name string) bool {
+			b, _ := m[name].(bool)
+			return b
+		}
+
+		slots = append(slots, ImageSlot{
+			Image:     int(CborInt(m["image"])),
+			Slot:      int(CborInt(m["slot"])),
+			Version:   version,
+			Hash:      hex.EncodeToString(hash),
+			Bootable:  flag("bootable"),
+			Pending:   flag("pending"),
+			Confirmed: flag("confirmed"),
+			Active:    flag("active"),
+			Permanent: flag("permanent"),
+		})
+	}
+
+	return slots
+}
+
+// Reads the state of the device's image slots.
+func (c *Client) ImageState() ([]ImageSlot, error) {
+	rsp, err := c.Request(SMP_OP_READ, SMP_GROUP_IMAGE, SMP_ID_IMAGE_STATE,
+		map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseImageState(rsp), nil
+}
+
+func (c *Client) setImageState(hash string,
+	confirm bool) ([]ImageSlot, error) {
+
+	req := map[string]interface{}{"confirm": confirm}
+	if hash != "" {
+		b, err := hex.DecodeString(hash)
+		if err != nil {
+			return nil, util.FmtNewtError("Invalid image hash: %s", hash)
+		}
+		req["hash"] = b
+	}
+
+	rsp, err := c.Request(SMP_OP_WRITE, SMP_GROUP_IMAGE, SMP_ID_IMAGE_STATE,
+		req)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseImageState(rsp), nil
+}
+
+// Marks the image with the specified hash pending: the bootloader swaps it
+// in on the next reset, and reverts unless it is then confirmed.
+func (c *Client) ImageTest(hash string) ([]ImageSlot, error) {
+	return c.setImageState(hash, false)
+}
+
+// Makes an image permanent.  An empty hash confirms the running image.
+func (c *Client) ImageConfirm(hash string) ([]ImageSlot, error) {
+	return c.setImageState(hash, true)
+}
+
+// The largest chunk of image data that fits in one upload request at the
+// specified offset.
+func (c *Client) uploadChunkSize(off int, total int) (int, error) {
+	req := map[string]interface{}{
+		"off":  int64(off),
+		"data": []byte{},
+	}
+	if off == 0 {
+		req["len"] = int64(total)
+	}
+	enc, err := CborEncode(req)
+	if err != nil {
+		return 0, err
+	}
+
+	// The data's length prefix grows by up to four bytes.
+	size := c.Mtu() - SMP_HDR_SIZE - len(enc) - 4
+	if size <= 0 {
+		return 0, util.FmtNewtError("MTU %d is too small for image "+
+			"upload", c.Mtu())
+	}
+	return size, nil
+}
+
+// Uploads an image to the device's secondary slot.  progress, if not nil, is
+// called after each acknowledged chunk.
+func (c *Client) ImageUpload(data []byte,
+	progress func(off int, total int)) error {
+
+	timeout := c.Timeout
+	defer func() { c.Timeout = timeout }()
+
+	off := 0
+	for off < len(data) {
+		size, err := c.uploadChunkSize(off, len(data))
+		if err != nil {
+			return err
+		}
+		end := util.Min(off+size, len(data))
+
+		req := map[string]interface{}{
+			"off":  int64(off),
+			"data": data[off:end],
+		}
+		if off == 0 {
+			req["len"] = int64(len(data))
+			c.Timeout = SMP_ERASE_TIMEOUT
+		} else {
+			c.Timeout = timeout
+		}
+
+		rsp, err := c.Request(SMP_OP_WRITE, SMP_GROUP_IMAGE,
+			SMP_ID_IMAGE_UPLOAD, req)
+		if err != nil {
+			return util.PreNewtError(err, "Image upload failed at offset %d",
+				off)
+		}
+
+		// The device says where to continue; normally the end of the
+		// chunk.
+		next := int(CborInt(rsp["off"]))
+		if next <= off {
+			return util.FmtNewtError("Image upload stalled at offset %d",
+				off)
+		}
+		off = next
+
+		if progress != nil {
+			progress(util.Min(off, len(data)), len(data))
+		}
+	}
+
+	return nil
+}
+
+// Returns the slot holding the image with the specified hash, or nil.
+func FindImageSlot(slots []ImageSlot, hash string) *ImageSlot {
+	for i, _ := range slots {
+		if bytes.EqualFold([]byte(slots[i].Hash), []byte(hash)) {
+			return &slots[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package smp implements the client side of the Simple Management Protocol
+// used by mcumgr, so that newt can manage devices without newtmgr or the
+// mcumgr CLI.
+package smp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+const SMP_HDR_SIZE = 8
+
+// Operations.
+const (
+	SMP_OP_READ      = 0
+	SMP_OP_READ_RSP  = 1
+	SMP_OP_WRITE     = 2
+	SMP_OP_WRITE_RSP = 3
+)
+
+// Groups and the commands used from each.
+const (
+	SMP_GROUP_OS    = 0
+	SMP_GROUP_IMAGE = 1
+)
+
+const (
+	SMP_ID_OS_RESET = 5
+
+	SMP_ID_IMAGE_STATE  = 0
+	SMP_ID_IMAGE_UPLOAD = 1
+)
+
+// Result codes (the "rc" response field).
+var smpErrNames = map[int]string{
+	1: "unknown error",
+	2: "out of memory",
+	3: "invalid argument",
+	4: "timed out",
+	5: "no such entry",
+	6: "bad state",
+	7: "message too large",
+	8: "not supported",
+}
+
+const SMP_DEFAULT_TIMEOUT = 5 * time.Second
+const SMP_RETRIES = 3
+
+type Client struct {
+	t       Transport
+	seq     uint8
+	Timeout time.Duration
+}
+
+func NewClient(t Transport) *Client {
+	return &Client{
+		t:       t,
+		Timeout: SMP_DEFAULT_TIMEOUT,
+	}
+}
+
+func (c *Client) Close() error {
+	return c.t.Close()
+}
+
+func (c *Client) Mtu() int {
+	return c.t.Mtu()
+}
+
+type smpHdr struct {
+	Op    uint8
+	Flags uint8
+	Len   uint16
+	Group uint16
+	Seq   uint8
+	Id    uint8
+}
+
+// Sends a request and waits for its response, retrying on timeouts.
+// Returns the response's CBOR map; a nonzero "rc" is returned as an error.
+func (c *Client) Request(op uint8, group uint16, id uint8,
+	req map[string]interface{}) (map[string]interface{}, error) {
+
+	payload, err := CborEncode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.seq++
+	hdr := smpHdr{
+		Op:    op,
+		Len:   uint16(len(payload)),
+		Group: group,
+		Seq:   c.seq,
+		Id:    id,
+	}
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, hdr)
+	buf.Write(payload)
+	if buf.Len() > c.t.Mtu() {
+		return nil, util.FmtNewtError("SMP request (%d bytes) exceeds "+
+			"the MTU (%d)", buf.Len(), c.t.Mtu())
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := c.t.Send(buf.Bytes()); err != nil {
+			return nil, err
+		}
+
+		// Only timeouts are retried; an error response is final.
+		rsp, err := c.awaitRsp(hdr)
+		if err == nil || rsp != nil {
+			return rsp, err
+		}
+		if attempt+1 >= SMP_RETRIES {
+			return nil, err
+		}
+		log.Debugf("smp: %s; retrying", err.Error())
+	}
+}
+
+func (c *Client) awaitRsp(req smpHdr) (map[string]interface{}, error) {
+	deadline := time.Now().Add(c.Timeout)
+
+	for {
+		pkt, err := c.t.Recv(deadline)
+		if err != nil {
+			return nil, err
+		}
+		if len(pkt) < SMP_HDR_SIZE {
+			continue
+		}
+
+		var hdr smpHdr
+		binary.Read(bytes.NewReader(pkt), binary.BigEndian, &hdr)
+		if hdr.Op&0x07 != req.Op+1 || hdr.Group != req.Group ||
+			hdr.Id != req.Id || hdr.Seq != req.Seq {
+
+			log.Debugf("smp: ignoring unexpected response %+v", hdr)
+			continue
+		}
+
+		body := pkt[SMP_HDR_SIZE:]
+		if int(hdr.Len) < len(body) {
+			body = body[:hdr.Len]
+		}
+		rsp, err := CborDecodeMap(body)
+		if err != nil {
+			return nil, err
+		}
+
+		if rc := int(CborInt(rsp["rc"])); rc != 0 {
+			name := smpErrNames[rc]
+			if name == "" {
+				name = fmt.Sprintf("error %d", rc)
+			}
+			return rsp, util.FmtNewtError("Device returned %s (rc=%d)",
+				name, rc)
+		}
+
+		return rsp, nil
+	}
+}
+
+// Resets the device.
+func (c *Client) Reset() error {
+	_, err := c.Request(SMP_OP_WRITE, SMP_GROUP_OS, SMP_ID_OS_RESET,
+		map[string]interface{}{})
+	return err
+}
@@ -0,0 +1,259 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package smp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Carries SMP packets to and from a device.
+type Transport interface {
+	Send(pkt []byte) error
+
+	// Returns the next packet received, or an error if none arrives before
+	// the deadline.
+	Recv(deadline time.Time) ([]byte, error)
+
+	// Largest packet the device accepts.
+	Mtu() int
+
+	Close() error
+}
+
+// Serial framing, as implemented by mynewt's nmgr_uart / mcumgr's SMP over
+// console: each packet is prefixed with its length and suffixed with a
+// CRC16, base64 encoded and split into lines of at most
+// SERIAL_FRAME_MAX bytes.  The first line of a packet starts with
+// SERIAL_PKT_START; the rest start with SERIAL_PKT_CONT.
+var SERIAL_PKT_START = []byte{0x06, 0x09}
+var SERIAL_PKT_CONT = []byte{0x04, 0x14}
+
+const SERIAL_FRAME_MAX = 127
+const SERIAL_DEFAULT_MTU = 256
+
+type SerialTransport struct {
+	f      *os.File
+	r      *bufio.Reader
+	mtu    int
+	lineCh chan []byte
+	errCh  chan error
+}
+
+// Uses an open serial device, configured in raw mode, as an SMP transport.
+func NewSerialTransport(f *os.File, mtu int) *SerialTransport {
+	if mtu <= 0 {
+		mtu = SERIAL_DEFAULT_MTU
+	}
+
+	st := &SerialTransport{
+		f:      f,
+		r:      bufio.NewReader(f),
+		mtu:    mtu,
+		lineCh: make(chan []byte, 16),
+		errCh:  make(chan error, 1),
+	}
+
+	// Serial devices don't reliably support read deadlines, so lines are
+	// read in the background.
+	go func() {
+		for {
+			line, err := st.r.ReadBytes('\n')
+			if err != nil {
+				st.errCh <- err
+				return
+			}
+			st.lineCh <- line
+		}
+	}()
+
+	return st
+}
+
+// CRC-16/CCITT (XMODEM), as computed by mynewt's crc16_ccitt().
+func crc16Ccitt(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func (st *SerialTransport) Mtu() int {
+	return st.mtu
+}
+
+func (st *SerialTransport) Send(pkt []byte) error {
+	raw := &bytes.Buffer{}
+	binary.Write(raw, binary.BigEndian, uint16(len(pkt)+2))
+	raw.Write(pkt)
+	binary.Write(raw, binary.BigEndian, crc16Ccitt(0, pkt))
+
+	b64 := base64.StdEncoding.EncodeToString(raw.Bytes())
+
+	out := &bytes.Buffer{}
+	maxChunk := SERIAL_FRAME_MAX - len(SERIAL_PKT_START) - 1
+	for off := 0; off < len(b64); off += maxChunk {
+		if off == 0 {
+			out.Write(SERIAL_PKT_START)
+		} else {
+			out.Write(SERIAL_PKT_CONT)
+		}
+		out.WriteString(b64[off:util.Min(off+maxChunk, len(b64))])
+		out.WriteByte('\n')
+	}
+
+	if _, err := st.f.Write(out.Bytes()); err != nil {
+		return util.ChildNewtError(err)
+	}
+	return nil
+}
+
+func (st *SerialTransport) Recv(deadline time.Time) ([]byte, error) {
+	b64 := []byte{}
+	started := false
+
+	timer := time.NewTimer(deadline.Sub(time.Now()))
+	defer timer.Stop()
+
+	for {
+		var line []byte
+		select {
+		case line = <-st.lineCh:
+		case err := <-st.errCh:
+			return nil, util.ChildNewtError(err)
+		case <-timer.C:
+			return nil, util.NewNewtError("Timed out waiting for response")
+		}
+
+		line = bytes.TrimRight(line, "\r\n")
+		switch {
+		case bytes.HasPrefix(line, SERIAL_PKT_START):
+			b64 = append([]byte{}, line[len(SERIAL_PKT_START):]...)
+			started = true
+		case started && bytes.HasPrefix(line, SERIAL_PKT_CONT):
+			b64 = append(b64, line[len(SERIAL_PKT_CONT):]...)
+		default:
+			// Console output sharing the UART.
+			log.Debugf("smp: ignoring line: %q", line)
+			continue
+		}
+
+		raw := make([]byte, base64.StdEncoding.DecodedLen(len(b64)))
+		n, err := base64.StdEncoding.Decode(raw, b64)
+		if err != nil {
+			// Wait for the rest of the packet; a partial base64 string
+			// may not decode.
+			continue
+		}
+		raw = raw[:n]
+		if len(raw) < 2 {
+			continue
+		}
+
+		pktLen := int(binary.BigEndian.Uint16(raw))
+		if len(raw)-2 < pktLen {
+			continue
+		}
+		started = false
+
+		body := raw[2 : 2+pktLen]
+		if pktLen < 2 || crc16Ccitt(0, body) != 0 {
+			log.Debugf("smp: dropping packet with bad CRC")
+			continue
+		}
+
+		return body[:pktLen-2], nil
+	}
+}
+
+func (st *SerialTransport) Close() error {
+	return st.f.Close()
+}
+
+// SMP over UDP: one packet per datagram.  Used by simulated devices.
+type UdpTransport struct {
+	conn *net.UDPConn
+	mtu  int
+}
+
+const UDP_DEFAULT_MTU = 1024
+
+func NewUdpTransport(addr string, mtu int) (*UdpTransport, error) {
+	if mtu <= 0 {
+		mtu = UDP_DEFAULT_MTU
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	return &UdpTransport{conn: conn, mtu: mtu}, nil
+}
+
+func (ut *UdpTransport) Mtu() int {
+	return ut.mtu
+}
+
+func (ut *UdpTransport) Send(pkt []byte) error {
+	if _, err := ut.conn.Write(pkt); err != nil {
+		return util.ChildNewtError(err)
+	}
+	return nil
+}
+
+func (ut *UdpTransport) Recv(deadline time.Time) ([]byte, error) {
+	ut.conn.SetReadDeadline(deadline)
+
+	buf := make([]byte, 65536)
+	n, err := ut.conn.Read(buf)
+	if err != nil {
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			return nil, util.NewNewtError("Timed out waiting for response")
+		}
+		return nil, util.ChildNewtError(err)
+	}
+
+	return buf[:n], nil
+}
+
+func (ut *UdpTransport) Close() error {
+	return ut.conn.Close()
+}
@@ -0,0 +1,486 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+// Package svd reads CMSIS-SVD files, which describe the memory-mapped
+// peripherals of a device: their registers and the bitfields within them.
+package svd
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+type EnumValue struct {
+	Name        string
+	Description string
+	Value       uint64
+}
+
+type Field struct {
+	Name        string
+	Description string
+	Lsb         int
+	Width       int
+	Enums       []EnumValue
+}
+
+type Register struct {
+	Name        string
+	Description string
+
+	// Offset from the peripheral's base address.
+	Offset uint32
+
+	// Size in bits.
+	Size int
+
+	Access     string
+	ReadAction string
+	Fields     []Field
+}
+
+type Peripheral struct {
+	Name        string
+	Description string
+	BaseAddress uint32
+	Registers   []Register
+}
+
+type Device struct {
+	Name        string
+	Peripherals []*Peripheral
+}
+
+// Raw XML structure.  Only the elements newt uses are decoded.
+
+type xmlEnumValue struct {
+	Name        string `xml:"name"`
+	Description string `xml:"description"`
+	Value       string `xml:"value"`
+}
+
+type xmlField struct {
+	Name        string         `xml:"name"`
+	Description string         `xml:"description"`
+	BitOffset   string         `xml:"bitOffset"`
+	BitWidth    string         `xml:"bitWidth"`
+	Lsb         string         `xml:"lsb"`
+	Msb         string         `xml:"msb"`
+	BitRange    string         `xml:"bitRange"`
+	Enums       []xmlEnumValue `xml:"enumeratedValues>enumeratedValue"`
+}
+
+type xmlDim struct {
+	Dim          string `xml:"dim"`
+	DimIncrement string `xml:"dimIncrement"`
+	DimIndex     string `xml:"dimIndex"`
+}
+
+type xmlRegister struct {
+	xmlDim
+	Name          string     `xml:"name"`
+	Description   string     `xml:"description"`
+	AddressOffset string     `xml:"addressOffset"`
+	Size          string     `xml:"size"`
+	Access        string     `xml:"access"`
+	ReadAction    string     `xml:"readAction"`
+	Fields        []xmlField `xml:"fields>field"`
+}
+
+type xmlCluster struct {
+	xmlDim
+	Name          string        `xml:"name"`
+	AddressOffset string        `xml:"addressOffset"`
+	Registers     []xmlRegister `xml:"register"`
+	Clusters      []xmlCluster  `xml:"cluster"`
+}
+
+type xmlPeripheral struct {
+	Name        string `xml:"name"`
+	DerivedFrom string `xml:"derivedFrom,attr"`
+	Description string `xml:"description"`
+	BaseAddress string `xml:"baseAddress"`
+	Size        string `xml:"size"`
+	Access      string `xml:"access"`
+	Registers   struct {
+		Registers []xmlRegister `xml:"register"`
+		Clusters  []xmlCluster  `xml:"cluster"`
+	} `xml:"registers"`
+}
+
+type xmlDevice struct {
+	Name        string          `xml:"name"`
+	Size        string          `xml:"size"`
+	Access      string          `xml:"access"`
+	Peripherals []xmlPeripheral `xml:"peripherals>peripheral"`
+}
+
+// Parses an SVD integer: decimal, 0x-prefixed hex, or #-prefixed binary.
+func parseInt(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "#") {
+		return strconv.ParseUint(s[1:], 2, 64)
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return strconv.ParseUint(s[2:], 16, 64)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func parseIntDefault(s string, dflt uint64) (uint64, error) {
+	if strings.TrimSpace(s) == "" {
+		return dflt, nil
+	}
+	return parseInt(s)
+}
+
+// Expands a dim element into the names and offsets of its instances.  The
+// name contains "%s", which is replaced by each index; array names keep their
+// brackets (e.g., "PSEL[1]").
+func expandDim(d xmlDim, name string, offset uint64) ([]string, []uint64,
+	error) {
+
+	if d.Dim == "" {
+		return []string{name}, []uint64{offset}, nil
+	}
+
+	dim, err := parseInt(d.Dim)
+	if err != nil {
+		return nil, nil, err
+	}
+	incr, err := parseInt(d.DimIncrement)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var indices []string
+	switch {
+	case d.DimIndex == "":
+		for i := uint64(0); i < dim; i++ {
+			indices = append(indices, strconv.FormatUint(i, 10))
+		}
+	case strings.Contains(d.DimIndex, "-"):
+		// A range of numbers ("0-3") or letters ("A-D").
+		parts := strings.SplitN(d.DimIndex, "-", 2)
+		if first, err := strconv.Atoi(parts[0]); err == nil {
+			for i := uint64(0); i < dim; i++ {
+				indices = append(indices, strconv.Itoa(first+int(i)))
+			}
+		} else if len(parts[0]) == 1 {
+			for i := uint64(0); i < dim; i++ {
+				indices = append(indices, string(rune(parts[0][0])+rune(i)))
+			}
+		}
+	default:
+		indices = strings.Split(d.DimIndex, ",")
+	}
+	if uint64(len(indices)) != dim {
+		return nil, nil, util.FmtNewtError("Invalid dimIndex \"%s\" for %s",
+			d.DimIndex, name)
+	}
+
+	names := make([]string, dim)
+	offsets := make([]uint64, dim)
+	for i, idx := range indices {
+		names[i] = strings.Replace(name, "%s", strings.TrimSpace(idx), 1)
+		offsets[i] = offset + uint64(i)*incr
+	}
+
+	return names, offsets, nil
+}
+
+func buildField(xf xmlField) (Field, error) {
+	f := Field{
+		Name:        xf.Name,
+		Description: cleanDesc(xf.Description),
+	}
+
+	var msb, lsb uint64
+	var err error
+	switch {
+	case xf.BitRange != "":
+		r := strings.Trim(strings.TrimSpace(xf.BitRange), "[]")
+		parts := strings.Split(r, ":")
+		if len(parts) != 2 {
+			return f, util.FmtNewtError("Invalid bitRange \"%s\"",
+				xf.BitRange)
+		}
+		if msb, err = parseInt(parts[0]); err != nil {
+			return f, err
+		}
+		if lsb, err = parseInt(parts[1]); err != nil {
+			return f, err
+		}
+	case xf.Lsb != "":
+		if lsb, err = parseInt(xf.Lsb); err != nil {
+			return f, err
+		}
+		if msb, err = parseInt(xf.Msb); err != nil {
+			return f, err
+		}
+	default:
+		if lsb, err = parseInt(xf.BitOffset); err != nil {
+			return f, err
+		}
+		width, err := parseIntDefault(xf.BitWidth, 1)
+		if err != nil {
+			return f, err
+		}
+		msb = lsb + width - 1
+	}
+	if msb < lsb {
+		return f, util.FmtNewtError("Invalid bit range for field %s",
+			xf.Name)
+	}
+	f.Lsb = int(lsb)
+	f.Width = int(msb - lsb + 1)
+
+	for _, xe := range xf.Enums {
+		// Values with "x" (don't care) bits can't be matched exactly.
+		v, err := parseInt(xe.Value)
+		if err != nil {
+			continue
+		}
+		f.Enums = append(f.Enums, EnumValue{
+			Name:        xe.Name,
+			Description: cleanDesc(xe.Description),
+			Value:       v,
+		})
+	}
+
+	return f, nil
+}
+
+type regDefaults struct {
+	size   uint64
+	access string
+}
+
+type fieldSorter struct {
+	fields []Field
+}
+
+func (s fieldSorter) Len() int {
+	return len(s.fields)
+}
+func (s fieldSorter) Swap(i, j int) {
+	s.fields[i], s.fields[j] = s.fields[j], s.fields[i]
+}
+func (s fieldSorter) Less(i, j int) bool {
+	return s.fields[i].Lsb > s.fields[j].Lsb
+}
+
+func buildRegisters(xregs []xmlRegister, xclusters []xmlCluster,
+	base uint64, prefix string, dflt regDefaults) ([]Register, error) {
+
+	regs := []Register{}
+
+	for _, xr := range xregs {
+		off, err := parseInt(xr.AddressOffset)
+		if err != nil {
+			return nil, util.FmtNewtError(
+				"Invalid addressOffset for register %s", xr.Name)
+		}
+		size, err := parseIntDefault(xr.Size, dflt.size)
+		if err != nil {
+			return nil, err
+		}
+		access := xr.Access
+		if access == "" {
+			access = dflt.access
+		}
+
+		fields := make([]Field, 0, len(xr.Fields))
+		for _, xf := range xr.Fields {
+			f, err := buildField(xf)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
+		}
+		sort.Sort(fieldSorter{fields})
+
+		names, offsets, err := expandDim(xr.xmlDim, xr.Name, base+off)
+		if err != nil {
+			return nil, err
+		}
+		for i, name := range names {
+			regs = append(regs, Register{
+				Name:        prefix + name,
+				Description: cleanDesc(xr.Description),
+				Offset:      uint32(offsets[i]),
+				Size:        int(size),
+				Access:      access,
+				ReadAction:  xr.ReadAction,
+				Fields:      fields,
+			})
+		}
+	}
+
+	for _, xc := range xclusters {
+		off, err := parseInt(xc.AddressOffset)
+		if err != nil {
+			return nil, util.FmtNewtError(
+				"Invalid addressOffset for cluster %s", xc.Name)
+		}
+
+		names, offsets, err := expandDim(xc.xmlDim, xc.Name, base+off)
+		if err != nil {
+			return nil, err
+		}
+		for i, name := range names {
+			sub, err := buildRegisters(xc.Registers, xc.Clusters,
+				offsets[i], prefix+name+".", dflt)
+			if err != nil {
+				return nil, err
+			}
+			regs = append(regs, sub...)
+		}
+	}
+
+	return regs, nil
+}
+
+func cleanDesc(desc string) string {
+	return strings.Join(strings.Fields(desc), " ")
+}
+
+type registerSorter struct {
+	regs []Register
+}
+
+func (s registerSorter) Len() int {
+	return len(s.regs)
+}
+func (s registerSorter) Swap(i, j int) {
+	s.regs[i], s.regs[j] = s.regs[j], s.regs[i]
+}
+func (s registerSorter) Less(i, j int) bool {
+	return s.regs[i].Offset < s.regs[j].Offset
+}
+
+// Reads and parses an SVD file.
+func Load(path string) (*Device, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+
+	var xd xmlDevice
+	if err := xml.Unmarshal(data, &xd); err != nil {
+		return nil, util.FmtNewtError("Failed to parse SVD file %s: %s",
+			path, err.Error())
+	}
+
+	byName := map[string]*xmlPeripheral{}
+	for i, _ := range xd.Peripherals {
+		byName[xd.Peripherals[i].Name] = &xd.Peripherals[i]
+	}
+
+	devSize, err := parseIntDefault(xd.Size, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	dev := &Device{Name: xd.Name}
+	for _, xp := range xd.Peripherals {
+		base, err := parseInt(xp.BaseAddress)
+		if err != nil {
+			return nil, util.FmtNewtError(
+				"Invalid baseAddress for peripheral %s", xp.Name)
+		}
+
+		// A derived peripheral inherits the registers of another; only
+		// its name and address differ.
+		src := &xp
+		if xp.DerivedFrom != "" {
+			src = byName[xp.DerivedFrom]
+			if src == nil {
+				return nil, util.FmtNewtError(
+					"Peripheral %s derived from unknown peripheral %s",
+					xp.Name, xp.DerivedFrom)
+			}
+		}
+
+		dflt := regDefaults{size: devSize, access: xd.Access}
+		if dflt.size, err = parseIntDefault(src.Size, dflt.size); err != nil {
+			return nil, err
+		}
+		if src.Access != "" {
+			dflt.access = src.Access
+		}
+
+		regs, err := buildRegisters(src.Registers.Registers,
+			src.Registers.Clusters, 0, "", dflt)
+		if err != nil {
+			return nil, util.FmtNewtError("Peripheral %s: %s", xp.Name,
+				err.Error())
+		}
+		sort.Sort(registerSorter{regs})
+
+		desc := xp.Description
+		if desc == "" {
+			desc = src.Description
+		}
+		dev.Peripherals = append(dev.Peripherals, &Peripheral{
+			Name:        xp.Name,
+			Description: cleanDesc(desc),
+			BaseAddress: uint32(base),
+			Registers:   regs,
+		})
+	}
+
+	return dev, nil
+}
+
+// Looks up a peripheral by name, ignoring case.  Returns nil if there is no
+// such peripheral.
+func (d *Device) Peripheral(name string) *Peripheral {
+	for _, p := range d.Peripherals {
+		if strings.EqualFold(p.Name, name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Indicates whether a register can be read without side effects.
+func (r *Register) Readable() bool {
+	return r.Access != "write-only" && r.Access != "writeOnce" &&
+		r.ReadAction == ""
+}
+
+// Extracts a field's value from its register's value.
+func (f *Field) Extract(regVal uint64) uint64 {
+	return (regVal >> uint(f.Lsb)) & (1<<uint(f.Width) - 1)
+}
+
+// Returns the enumerated value matching a field value; nil if none does.
+func (f *Field) Enum(val uint64) *EnumValue {
+	for i, _ := range f.Enums {
+		if f.Enums[i].Value == val {
+			return &f.Enums[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package syscfg
+
+import (
+	"fmt"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Rough msys requirements of the NimBLE host: a base number of blocks, plus
+// some per connection for queued ACL data, ATT responses and L2CAP signaling.
+const BLE_MSYS_BASE_BLOCKS = 4
+const BLE_MSYS_BLOCKS_PER_CONN = 3
+
+// A likely NimBLE misconfiguration: settings that are valid on their own but
+// don't work together.  Such mistakes otherwise only surface as runtime
+// asserts or failed allocations.
+type CfgBleIssue struct {
+	Text string
+
+	// The settings to adjust.
+	SettingNames []string
+}
+
+// Host features and the controller features they rely on.
+var bleFeaturePairs = [][2]string{
+	{"BLE_EXT_ADV", "BLE_LL_CFG_FEAT_LL_EXT_ADV"},
+	{"BLE_PERIODIC_ADV", "BLE_LL_CFG_FEAT_LL_PERIODIC_ADV"},
+	{"BLE_ISO", "BLE_LL_CFG_FEAT_LL_ISO"},
+}
+
+// Returns the integer value of a setting; false if the setting is undefined
+// or not a plain integer (e.g., an expression).
+func (cfg *Cfg) intValue(name string) (int, bool) {
+	entry, ok := cfg.Settings[name]
+	if !ok {
+		return 0, false
+	}
+
+	val, err := util.AtoiNoOct(entry.Value)
+	if err != nil {
+		return 0, false
+	}
+
+	return val, true
+}
+
+// Returns the boolean value of a setting; false if the setting is undefined.
+func (cfg *Cfg) boolValue(name string) (bool, bool) {
+	entry, ok := cfg.Settings[name]
+	if !ok {
+		return false, false
+	}
+
+	return entry.IsTrue(), true
+}
+
+func (cfg *Cfg) addBleIssue(text string, settingNames ...string) {
+	cfg.BleIssues = append(cfg.BleIssues, CfgBleIssue{
+		Text:         text,
+		SettingNames: settingNames,
+	})
+}
+
+func (cfg *Cfg) detectBleBufferIssues() {
+	conns, ok := cfg.intValue("BLE_MAX_CONNECTIONS")
+	if !ok {
+		return
+	}
+
+	if blocks, ok := cfg.intValue("MSYS_1_BLOCK_COUNT"); ok {
+		min := BLE_MSYS_BASE_BLOCKS + BLE_MSYS_BLOCKS_PER_CONN*conns
+		if blocks < min {
+			cfg.addBleIssue(fmt.Sprintf(
+				"MSYS_1_BLOCK_COUNT (%d) is low for BLE_MAX_CONNECTIONS "+
+					"(%d); the host may run out of mbufs.  Increase "+
+					"MSYS_1_BLOCK_COUNT to at least %d or reduce "+
+					"BLE_MAX_CONNECTIONS",
+				blocks, conns, min),
+				"MSYS_1_BLOCK_COUNT", "BLE_MAX_CONNECTIONS")
+		}
+	}
+
+	if bufs, ok := cfg.intValue("BLE_ACL_BUF_COUNT"); ok && bufs < conns {
+		cfg.addBleIssue(fmt.Sprintf(
+			"BLE_ACL_BUF_COUNT (%d) is less than BLE_MAX_CONNECTIONS (%d); "+
+				"a busy connection can starve the others.  Increase "+
+				"BLE_ACL_BUF_COUNT to at least %d",
+			bufs, conns, conns),
+			"BLE_ACL_BUF_COUNT", "BLE_MAX_CONNECTIONS")
+	}
+}
+
+func (cfg *Cfg) detectBleControllerIssues() {
+	pktSize, ok := cfg.intValue("BLE_LL_MAX_PKT_SIZE")
+	if !ok {
+		return
+	}
+
+	if bufSize, ok := cfg.intValue("BLE_ACL_BUF_SIZE"); ok &&
+		bufSize < pktSize {
+
+		cfg.addBleIssue(fmt.Sprintf(
+			"BLE_ACL_BUF_SIZE (%d) is smaller than the controller's "+
+				"BLE_LL_MAX_PKT_SIZE (%d); data length extension cannot be "+
+				"used fully.  Increase BLE_ACL_BUF_SIZE to %d or reduce "+
+				"BLE_LL_MAX_PKT_SIZE",
+			bufSize, pktSize, pktSize),
+			"BLE_ACL_BUF_SIZE", "BLE_LL_MAX_PKT_SIZE")
+	}
+
+	for _, name := range []string{
+		"BLE_LL_CONN_INIT_MAX_TX_BYTES",
+		"BLE_LL_SUPP_MAX_TX_BYTES",
+		"BLE_LL_SUPP_MAX_RX_BYTES",
+	} {
+		if bytes, ok := cfg.intValue(name); ok && bytes > pktSize {
+			cfg.addBleIssue(fmt.Sprintf(
+				"%s (%d) exceeds BLE_LL_MAX_PKT_SIZE (%d).  Reduce %s or "+
+					"increase BLE_LL_MAX_PKT_SIZE",
+				name, bytes, pktSize, name),
+				name, "BLE_LL_MAX_PKT_SIZE")
+		}
+	}
+}
+
+func (cfg *Cfg) detectBleRoleIssues() {
+	central, okC := cfg.boolValue("BLE_ROLE_CENTRAL")
+	peripheral, okP := cfg.boolValue("BLE_ROLE_PERIPHERAL")
+	if !okC || !okP {
+		return
+	}
+
+	if conns, ok := cfg.intValue("BLE_MAX_CONNECTIONS"); ok {
+		if conns > 0 && !central && !peripheral {
+			cfg.addBleIssue(fmt.Sprintf(
+				"BLE_MAX_CONNECTIONS is %d, but neither BLE_ROLE_CENTRAL nor "+
+					"BLE_ROLE_PERIPHERAL is enabled; no connection can be "+
+					"established.  Enable a connection role or set "+
+					"BLE_MAX_CONNECTIONS to 0", conns),
+				"BLE_ROLE_CENTRAL", "BLE_ROLE_PERIPHERAL",
+				"BLE_MAX_CONNECTIONS")
+		}
+		if conns == 0 && (central || peripheral) {
+			cfg.addBleIssue(
+				"BLE_MAX_CONNECTIONS is 0, but a connection role "+
+					"(BLE_ROLE_CENTRAL or BLE_ROLE_PERIPHERAL) is enabled.  "+
+					"Increase BLE_MAX_CONNECTIONS or disable the role",
+				"BLE_MAX_CONNECTIONS", "BLE_ROLE_CENTRAL",
+				"BLE_ROLE_PERIPHERAL")
+		}
+	}
+
+	if observer, ok := cfg.boolValue("BLE_ROLE_OBSERVER"); ok &&
+		central && !observer {
+
+		cfg.addBleIssue(
+			"BLE_ROLE_CENTRAL is enabled without BLE_ROLE_OBSERVER; the "+
+				"device cannot scan for peers to connect to.  Enable "+
+				"BLE_ROLE_OBSERVER",
+			"BLE_ROLE_OBSERVER", "BLE_ROLE_CENTRAL")
+	}
+
+	if broadcaster, ok := cfg.boolValue("BLE_ROLE_BROADCASTER"); ok &&
+		peripheral && !broadcaster {
+
+		cfg.addBleIssue(
+			"BLE_ROLE_PERIPHERAL is enabled without BLE_ROLE_BROADCASTER; "+
+				"the device cannot advertise to be connected to.  Enable "+
+				"BLE_ROLE_BROADCASTER",
+			"BLE_ROLE_BROADCASTER", "BLE_ROLE_PERIPHERAL")
+	}
+}
+
+func (cfg *Cfg) detectBleFeatureIssues() {
+	for _, pair := range bleFeaturePairs {
+		host, okH := cfg.boolValue(pair[0])
+		ctlr, okC := cfg.boolValue(pair[1])
+		if okH && okC && host && !ctlr {
+			cfg.addBleIssue(fmt.Sprintf(
+				"The host enables %s, but the controller does not support "+
+					"it (%s is disabled).  Enable %s or disable %s",
+				pair[0], pair[1], pair[1], pair[0]),
+				pair[1], pair[0])
+		}
+	}
+}
+
+// Checks the resolved settings for common NimBLE host and controller
+// misconfigurations.  Checks whose settings are undefined (e.g., no
+// controller in the build) or not plain integers are skipped.
+func (cfg *Cfg) detectBleIssues() {
+	cfg.detectBleBufferIssues()
+	cfg.detectBleControllerIssues()
+	cfg.detectBleRoleIssues()
+	cfg.detectBleFeatureIssues()
+}
+
+// Returns a description of the detected NimBLE misconfigurations, with the
+// history of each setting to adjust; "" if there are none.
+func (cfg *Cfg) BleWarningText() string {
+	if len(cfg.BleIssues) == 0 {
+		return ""
+	}
+
+	str := "Possible NimBLE misconfigurations:\n"
+	historyMap := map[string][]CfgPoint{}
+	for _, issue := range cfg.BleIssues {
+		str += "    * " + issue.Text + ".\n"
+		for _, name := range issue.SettingNames {
+			historyMap[name] = cfg.Settings[name].History
+		}
+	}
+
+	return str + historyText(historyMap)
+}
@@ -0,0 +1,115 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package syscfg
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+func lineIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+// Removes the overrides of the specified settings from a syscfg.yml file,
+// including overrides in feature-conditional "syscfg.vals.<feature>"
+// sections.  The file is edited in place so that definitions, comments and
+// the order of the remaining entries are preserved.  Returns the number of
+// overrides removed.
+func RemoveValsFromFile(path string, names []string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, util.ChildNewtError(err)
+	}
+
+	remove := map[string]bool{}
+	for _, name := range names {
+		remove[name] = true
+	}
+
+	lines := strings.Split(string(data), "\n")
+	kept := make([]string, 0, len(lines))
+
+	inVals := false
+	skipIndent := -1
+	blanks := []string{}
+	removed := 0
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		indent := lineIndent(line)
+
+		// Drop continuation lines of a removed entry.  Blank lines are only
+		// dropped if more continuation lines follow them.
+		if skipIndent >= 0 {
+			if trimmed == "" {
+				blanks = append(blanks, line)
+				continue
+			}
+			if indent > skipIndent {
+				blanks = nil
+				continue
+			}
+			kept = append(kept, blanks...)
+			blanks = nil
+			skipIndent = -1
+		}
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			kept = append(kept, line)
+			continue
+		}
+
+		if indent == 0 {
+			key := strings.TrimSpace(strings.SplitN(trimmed, ":", 2)[0])
+			inVals = key == "syscfg.vals" ||
+				strings.HasPrefix(key, "syscfg.vals.")
+			kept = append(kept, line)
+			continue
+		}
+
+		if inVals {
+			key := strings.TrimSpace(strings.SplitN(trimmed, ":", 2)[0])
+			key = strings.Trim(key, "'\"")
+			if remove[key] {
+				removed++
+				skipIndent = indent
+				continue
+			}
+		}
+
+		kept = append(kept, line)
+	}
+
+	kept = append(kept, blanks...)
+
+	if removed == 0 {
+		return 0, nil
+	}
+
+	err = ioutil.WriteFile(path, []byte(strings.Join(kept, "\n")), 0644)
+	if err != nil {
+		return 0, util.ChildNewtError(err)
+	}
+
+	return removed, nil
+}
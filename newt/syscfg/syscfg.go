@@ -20,12 +20,9 @@
 package syscfg
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"os"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -115,6 +112,10 @@ type Cfg struct {
 	PriorityViolations []CfgPriority
 
 	FlashConflicts []CfgFlashConflict
+
+	//// Warnings
+	// Likely NimBLE misconfigurations; see detectBleIssues().
+	BleIssues []CfgBleIssue
 }
 
 func NewCfg() Cfg {
@@ -368,7 +369,19 @@ func (cfg *Cfg) readValsOnce(lpkg *pkg.LocalPackage,
 		}
 	}
 
-	values := newtutil.GetStringMapFeatures(v, lfeatures, "syscfg.vals")
+	values := map[string]interface{}{}
+	for _, base := range lpkg.SyscfgBases {
+		baseVals := newtutil.GetStringMapFeatures(base.SyscfgV, lfeatures,
+			"syscfg.vals")
+		for k, v := range baseVals {
+			values[k] = v
+		}
+	}
+	ownVals := newtutil.GetStringMapFeatures(v, lfeatures, "syscfg.vals")
+	for k, v := range ownVals {
+		values[k] = v
+	}
+
 	for k, v := range values {
 		entry, ok := cfg.Settings[k]
 		if ok {
@@ -619,11 +632,11 @@ func (cfg *Cfg) WarningText() string {
 		}
 	}
 
-	if str == "" {
-		return ""
+	if str != "" {
+		str += "\n" + historyText(historyMap)
 	}
 
-	str += "\n" + historyText(historyMap)
+	str += cfg.BleWarningText()
 
 	return str
 }
@@ -763,6 +776,7 @@ func Read(lpkgs []*pkg.LocalPackage, apis []string,
 	cfg.detectAmbiguities()
 	cfg.detectViolations()
 	cfg.detectFlashConflicts(flashMap)
+	cfg.detectBleIssues()
 
 	return cfg, nil
 }
@@ -891,8 +905,32 @@ func EntriesByPkg(cfg Cfg) map[string][]CfgEntry {
 	return pkgEntries
 }
 
+// Lists the syscfg.yml keys that produced a setting's value: its definition
+// and, if overridden, the most recent override.  Values injected by newt have
+// no YAML source.
+func entrySources(entry CfgEntry) []newtutil.YamlSource {
+	srcs := []newtutil.YamlSource{}
+
+	add := func(lpkg *pkg.LocalPackage, key string) {
+		if lpkg != nil {
+			srcs = append(srcs, newtutil.YamlSource{
+				Pkg:  lpkg.FullName(),
+				Path: lpkg.BasePath() + "/" + pkg.SYSCFG_YAML_FILENAME,
+				Key:  []string{key, entry.Name},
+			})
+		}
+	}
+
+	add(entry.History[0].Source, "syscfg.defs")
+	if len(entry.History) > 1 {
+		add(mostRecentPoint(entry).Source, "syscfg.vals")
+	}
+
+	return srcs
+}
+
 func writeSettingsOnePkg(cfg Cfg, pkgName string, pkgEntries []CfgEntry,
-	w io.Writer) {
+	w *newtutil.GenWriter) {
 
 	names := make([]string, len(pkgEntries), len(pkgEntries))
 	for i, entry := range pkgEntries {
@@ -915,12 +953,14 @@ func writeSettingsOnePkg(cfg Cfg, pkgName string, pkgEntries []CfgEntry,
 			fmt.Fprintf(w, "\n")
 		}
 
+		w.Begin("", entrySources(entry)...)
 		writeComment(entry, w)
 		writeDefine(settingName(n), entry.Value, w)
+		w.End()
 	}
 }
 
-func writeSettings(cfg Cfg, w io.Writer) {
+func writeSettings(cfg Cfg, w *newtutil.GenWriter) {
 	// Group settings by package name so that the generated header file is
 	// easier to read.
 	pkgEntries := EntriesByPkg(cfg)
@@ -938,7 +978,7 @@ func writeSettings(cfg Cfg, w io.Writer) {
 	}
 }
 
-func write(cfg Cfg, w io.Writer) {
+func write(cfg Cfg, w *newtutil.GenWriter) {
 	fmt.Fprintf(w, newtutil.GeneratedPreamble())
 
 	fmt.Fprintf(w, "#ifndef H_MYNEWT_SYSCFG_\n")
@@ -953,6 +993,97 @@ func write(cfg Cfg, w io.Writer) {
 	fmt.Fprintf(w, "#endif\n")
 }
 
+// Extracts the setting values from the contents of a generated syscfg
+// header.  Settings that the header undefines map to an empty string.
+func headerSettings(contents []byte) map[string]string {
+	settings := map[string]string{}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+		if len(fields) < 2 ||
+			!strings.HasPrefix(fields[1], SYSCFG_PREFIX_SETTING) {
+
+			continue
+		}
+
+		name := strings.TrimPrefix(fields[1], SYSCFG_PREFIX_SETTING)
+		switch {
+		case fields[0] == "#undef":
+			settings[name] = ""
+		case fields[0] == "#define" && len(fields) == 3:
+			val := strings.TrimPrefix(fields[2], "(")
+			settings[name] = strings.TrimSuffix(val, ")")
+		}
+	}
+
+	return settings
+}
+
+// Reports the settings responsible for regenerating the syscfg header at the
+// specified path.  Nothing is reported if the header did not previously exist
+// or if the user did not request verbose output.
+func reportHeaderChanges(path string, contents []byte) {
+	if util.Verbosity < util.VERBOSITY_VERBOSE {
+		return
+	}
+
+	oldContents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	oldSettings := headerSettings(oldContents)
+	newSettings := headerSettings(contents)
+
+	names := make([]string, 0, len(oldSettings)+len(newSettings))
+	for name, _ := range oldSettings {
+		names = append(names, name)
+	}
+	for name, _ := range newSettings {
+		if _, ok := oldSettings[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	display := func(val string) string {
+		if val == "" {
+			return "undefined"
+		}
+		return val
+	}
+
+	lines := []string{}
+	for _, name := range names {
+		oldVal, oldOk := oldSettings[name]
+		newVal, newOk := newSettings[name]
+		oldVal = display(oldVal)
+		newVal = display(newVal)
+
+		switch {
+		case !oldOk:
+			lines = append(lines, fmt.Sprintf("    %s: added (%s)",
+				name, newVal))
+		case !newOk:
+			lines = append(lines, fmt.Sprintf("    %s: removed (was %s)",
+				name, oldVal))
+		case oldVal != newVal:
+			lines = append(lines, fmt.Sprintf("    %s: %s --> %s",
+				name, oldVal, newVal))
+		}
+	}
+
+	if len(lines) == 0 {
+		util.StatusMessage(util.VERBOSITY_VERBOSE,
+			"Regenerating %s; setting values unchanged\n", path)
+		return
+	}
+
+	util.StatusMessage(util.VERBOSITY_VERBOSE,
+		"Regenerating %s; changed settings:\n%s\n", path,
+		strings.Join(lines, "\n"))
+}
+
 func EnsureWritten(cfg Cfg, includeDir string) error {
 	// XXX: Detect these problems at error text generation time.
 	if err := calcPriorities(cfg, CFG_SETTING_TYPE_TASK_PRIO,
@@ -961,11 +1092,15 @@ func EnsureWritten(cfg Cfg, includeDir string) error {
 		return err
 	}
 
-	buf := bytes.Buffer{}
-	write(cfg, &buf)
+	buf := newtutil.NewGenWriter()
+	write(cfg, buf)
 
 	path := includeDir + "/" + HEADER_PATH
 
+	if err := buf.WriteSourceMap(path); err != nil {
+		return err
+	}
+
 	writeReqd, err := util.FileContentsChanged(path, buf.Bytes())
 	if err != nil {
 		return err
@@ -976,13 +1111,10 @@ func EnsureWritten(cfg Cfg, includeDir string) error {
 	}
 
 	log.Debugf("syscfg changed; writing header file (%s).", path)
+	reportHeaderChanges(path, buf.Bytes())
 
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return util.NewNewtError(err.Error())
-	}
-
-	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
-		return util.NewNewtError(err.Error())
+	if err := util.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return err
 	}
 
 	return nil
@@ -20,12 +20,8 @@
 package sysinit
 
 import (
-	"bytes"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 
@@ -90,7 +86,23 @@ func (s initFuncSorter) Less(i, j int) bool {
 
 	// Same stage and function name?
 	log.Warnf("Warning: Identical sysinit functions detected: %s", a.name)
-	return true
+
+	// 3: Sort by package name so that the generated code is deterministic.
+	return a.pkg.FullName() < b.pkg.FullName()
+}
+
+// Identifies the pkg.yml key that declares an init function.
+func (f *initFunc) source() newtutil.YamlSource {
+	key := []string{"pkg.init", f.name}
+	if _, ok := f.pkg.PkgV.GetStringMapString("pkg.init")[f.name]; !ok {
+		key = []string{"pkg.init_function"}
+	}
+
+	return newtutil.YamlSource{
+		Pkg:  f.pkg.FullName(),
+		Path: f.pkg.BasePath() + "/" + pkg.PACKAGE_FILE_NAME,
+		Key:  key,
+	}
 }
 
 func sortedInitFuncs(pkgs []*pkg.LocalPackage) []*initFunc {
@@ -121,7 +133,7 @@ func writePrototypes(pkgs []*pkg.LocalPackage, w io.Writer) {
 	}
 }
 
-func writeCalls(sortedInitFuncs []*initFunc, w io.Writer) {
+func writeCalls(sortedInitFuncs []*initFunc, w *newtutil.GenWriter) {
 	prevStage := -1
 	dupCount := 0
 
@@ -138,14 +150,16 @@ func writeCalls(sortedInitFuncs []*initFunc, w io.Writer) {
 			dupCount += 1
 		}
 
+		w.Begin("    ", f.source())
 		fmt.Fprintf(w, "    /* %d.%d: %s (%s) */\n",
 			f.stage, dupCount, f.name, f.pkg.Name())
 		fmt.Fprintf(w, "    %s();\n", f.name)
+		w.End()
 	}
 }
 
 func write(pkgs []*pkg.LocalPackage, isLoader bool,
-	w io.Writer) {
+	w *newtutil.GenWriter) {
 
 	fmt.Fprintf(w, newtutil.GeneratedPreamble())
 
@@ -173,26 +187,11 @@ func write(pkgs []*pkg.LocalPackage, isLoader bool,
 	fmt.Fprintf(w, "#endif\n")
 }
 
-func writeRequired(contents []byte, path string) (bool, error) {
-	oldSrc, err := ioutil.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// File doesn't exist; write required.
-			return true, nil
-		}
-
-		return true, util.NewNewtError(err.Error())
-	}
-
-	rc := bytes.Compare(oldSrc, contents)
-	return rc != 0, nil
-}
-
 func EnsureWritten(pkgs []*pkg.LocalPackage, srcDir string, targetName string,
 	isLoader bool) error {
 
-	buf := bytes.Buffer{}
-	write(pkgs, isLoader, &buf)
+	buf := newtutil.NewGenWriter()
+	write(pkgs, isLoader, buf)
 
 	var path string
 	if isLoader {
@@ -201,7 +200,11 @@ func EnsureWritten(pkgs []*pkg.LocalPackage, srcDir string, targetName string,
 		path = fmt.Sprintf("%s/%s-sysinit-app.c", srcDir, targetName)
 	}
 
-	writeReqd, err := writeRequired(buf.Bytes(), path)
+	if err := buf.WriteSourceMap(path); err != nil {
+		return err
+	}
+
+	writeReqd, err := util.FileContentsChanged(path, buf.Bytes())
 	if err != nil {
 		return err
 	}
@@ -212,13 +215,11 @@ func EnsureWritten(pkgs []*pkg.LocalPackage, srcDir string, targetName string,
 	}
 
 	log.Debugf("sysinit changed; writing src file (%s).", path)
+	util.StatusMessage(util.VERBOSITY_VERBOSE,
+		"Regenerating %s; package init functions changed\n", path)
 
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return util.NewNewtError(err.Error())
-	}
-
-	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
-		return util.NewNewtError(err.Error())
+	if err := util.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return err
 	}
 
 	return nil
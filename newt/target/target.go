@@ -25,6 +25,8 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/spf13/cast"
+
 	"mynewt.apache.org/newt/newt/pkg"
 	"mynewt.apache.org/newt/newt/project"
 	"mynewt.apache.org/newt/newt/repo"
@@ -34,6 +36,33 @@ import (
 
 const TARGET_FILENAME string = "target.yml"
 const DEFAULT_BUILD_PROFILE string = "default"
+const TARGET_BUDGET_PREFIX string = "target.budget."
+const TARGET_INHERITS_VAR string = "target.inherits"
+const TARGET_HOOK_PREFIX string = "target.hooks."
+const TARGET_SANITIZERS_VAR string = "target.sanitizers"
+const TARGET_HISTORY_VAR string = "target.history"
+const TARGET_COMPANIONS_VAR string = "target.companions"
+const TARGET_CONNECTION_VAR string = "target.connection"
+const TARGET_ENV_PREFIX string = "target.env."
+const TARGET_ROM_VERSION_VAR string = "target.rom_version"
+const TARGET_ARTIFACT_NAME_VAR string = "target.artifact_name"
+const TARGET_LOADER_KEEP_VAR string = "target.loader_keep"
+
+// Image layout settings; each may also be specified by the BSP, as
+// "bsp.image_<setting>".
+const TARGET_IMAGE_PREFIX string = "target.image_"
+
+// Values of target.loader_keep: which of the app's symbols from packages
+// shared with the loader are kept in a split image's loader.
+const TARGET_LOADER_KEEP_ALL string = "all"
+const TARGET_LOADER_KEEP_REFERENCED string = "referenced"
+
+// Directory, inside the target's package, holding the frozen ROM symbol
+// lists of a split image target.
+const TARGET_ROM_DIR string = "rom"
+const DEFAULT_HISTORY_DEPTH int = 10
+const TARGET_STACK_PREFIX string = "target.stack."
+const TARGET_API_PREF_PREFIX string = "target.api_preferences."
 
 var globalTargetMap map[string]*Target
 
@@ -45,8 +74,12 @@ type Target struct {
 	LoaderName   string
 	BuildProfile string
 
-	// target.yml configuration structure
+	// target.yml configuration structure.  Only contains the settings
+	// specified by this target itself; see EffectiveVars().
 	Vars map[string]string
+
+	// The target named by "target.inherits", if any.
+	base *Target
 }
 
 func NewTarget(basePkg *pkg.LocalPackage) *Target {
@@ -77,19 +110,20 @@ func (target *Target) Load(basePkg *pkg.LocalPackage) error {
 
 	target.Vars = map[string]string{}
 
+	// Map-valued settings (e.g., target.api_preferences) are flattened into
+	// "<setting>.<key>" entries.
 	settings := v.AllSettings()
 	for k, v := range settings {
-		target.Vars[k] = v.(string)
+		if m, err := cast.ToStringMapE(v); err == nil {
+			for sk, sv := range m {
+				target.Vars[k+"."+sk] = cast.ToString(sv)
+			}
+		} else {
+			target.Vars[k] = cast.ToString(v)
+		}
 	}
 
-	target.BspName = target.Vars["target.bsp"]
-	target.AppName = target.Vars["target.app"]
-	target.LoaderName = target.Vars["target.loader"]
-	target.BuildProfile = target.Vars["target.build_profile"]
-
-	if target.BuildProfile == "" {
-		target.BuildProfile = DEFAULT_BUILD_PROFILE
-	}
+	target.applyVars()
 
 	// Note: App not required in the case of unit tests.
 
@@ -100,6 +134,168 @@ func (target *Target) Load(basePkg *pkg.LocalPackage) error {
 	return nil
 }
 
+// Populates the named fields from the target's effective settings.
+func (target *Target) applyVars() {
+	vars := target.EffectiveVars()
+
+	target.BspName = vars["target.bsp"]
+	target.AppName = vars["target.app"]
+	target.LoaderName = vars["target.loader"]
+	target.BuildProfile = vars["target.build_profile"]
+
+	if target.BuildProfile == "" {
+		target.BuildProfile = DEFAULT_BUILD_PROFILE
+	}
+}
+
+// Returns the target this one inherits from, or nil if it does not inherit.
+func (target *Target) Base() *Target {
+	return target.base
+}
+
+// Returns the chain of targets this one inherits from, starting with its
+// direct base.
+func (target *Target) Ancestors() []*Target {
+	ancestors := []*Target{}
+	for t := target.base; t != nil; t = t.base {
+		ancestors = append(ancestors, t)
+	}
+
+	return ancestors
+}
+
+// Returns the target's settings merged with those of the targets it inherits
+// from.  Settings in a derived target take precedence.
+func (target *Target) EffectiveVars() map[string]string {
+	vars := map[string]string{}
+	if target.base != nil {
+		vars = target.base.EffectiveVars()
+	}
+	for k, v := range target.Vars {
+		vars[k] = v
+	}
+
+	// The inheritance link itself is not inherited.
+	if _, ok := target.Vars[TARGET_INHERITS_VAR]; !ok {
+		delete(vars, TARGET_INHERITS_VAR)
+	}
+
+	return vars
+}
+
+// Returns the target that provides the effective value of the specified
+// setting, or nil if the setting is not specified anywhere in the chain.
+func (target *Target) VarSource(key string) *Target {
+	for t := target; t != nil; t = t.base {
+		if _, ok := t.Vars[key]; ok {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// Returns the target's syscfg overrides merged with those of the targets it
+// inherits from.  Feature-conditional values are not included.
+func (target *Target) SyscfgVals() map[string]string {
+	vals := map[string]string{}
+	if target.base != nil {
+		vals = target.base.SyscfgVals()
+	}
+	for k, v := range target.basePkg.SyscfgV.GetStringMapString("syscfg.vals") {
+		vals[k] = v
+	}
+
+	return vals
+}
+
+// Returns the target that provides the effective value of the specified
+// syscfg override, or nil if no target in the chain overrides it.
+func (target *Target) SyscfgValSource(name string) *Target {
+	for t := target; t != nil; t = t.base {
+		vals := t.basePkg.SyscfgV.GetStringMapString("syscfg.vals")
+		if _, ok := vals[name]; ok {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// Finds the target with the specified name.  Unqualified names are looked up
+// in the inheriting target's repo and in its "targets" directory.
+func lookupTarget(targetMap map[string]*Target, from *Target,
+	name string) *Target {
+
+	if t := targetMap[name]; t != nil {
+		return t
+	}
+
+	dir := filepath.Dir(from.Name())
+	candidates := []string{name, dir + "/" + name}
+	for _, t := range targetMap {
+		if t.basePkg.Repo() != from.basePkg.Repo() {
+			continue
+		}
+		for _, c := range candidates {
+			if t.Name() == c {
+				return t
+			}
+		}
+	}
+
+	return nil
+}
+
+// Links the specified target to the target it inherits from, recursively
+// resolving the base first.  The path argument contains the targets currently
+// being resolved and is used to detect cycles.
+func resolveBase(targetMap map[string]*Target, t *Target,
+	path []*Target) error {
+
+	baseName := t.Vars[TARGET_INHERITS_VAR]
+	if baseName == "" || t.base != nil {
+		return nil
+	}
+
+	for i, p := range path {
+		if p == t {
+			names := []string{}
+			for _, c := range path[i:] {
+				names = append(names, c.FullName())
+			}
+			names = append(names, t.FullName())
+			return util.FmtNewtError("target inheritance cycle: %s",
+				strings.Join(names, " -> "))
+		}
+	}
+
+	base := lookupTarget(targetMap, t, baseName)
+	if base == nil {
+		return util.FmtNewtError("could not resolve base target: %s",
+			baseName)
+	}
+
+	if err := resolveBase(targetMap, base, append(path, t)); err != nil {
+		return err
+	}
+
+	t.base = base
+	t.applyVars()
+
+	// Changes to a base target's settings must trigger a rebuild of the
+	// derived target.
+	t.basePkg.SyscfgBases = nil
+	ancestors := t.Ancestors()
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		a := ancestors[i]
+		t.basePkg.SyscfgBases = append(t.basePkg.SyscfgBases, a.basePkg)
+		t.basePkg.AddCfgFilename(a.basePkg.BasePath() + "/" + TARGET_FILENAME)
+	}
+
+	return nil
+}
+
 func (target *Target) Validate(appRequired bool) error {
 	if target.BspName == "" {
 		return util.NewNewtError("Target does not specify a BSP package " +
@@ -206,6 +402,190 @@ func (target *Target) Bsp() *pkg.LocalPackage {
 	return target.resolvePackageName(target.BspName)
 }
 
+// Returns the memory budgets specified in target.yml, keyed by budget name
+// (e.g., "target.budget.bss: 48kb" produces an entry "bss" => "48kb").
+func (target *Target) Budgets() map[string]string {
+	budgets := map[string]string{}
+	for k, v := range target.EffectiveVars() {
+		if strings.HasPrefix(k, TARGET_BUDGET_PREFIX) {
+			budgets[strings.TrimPrefix(k, TARGET_BUDGET_PREFIX)] = v
+		}
+	}
+
+	return budgets
+}
+
+// Returns the stack sizes specified in target.yml, keyed by entry function
+// (e.g., "target.stack.ble_hs_task: BLE_HS_STACK_SIZE" produces an entry
+// "ble_hs_task" => "BLE_HS_STACK_SIZE").
+func (target *Target) StackSizes() map[string]string {
+	sizes := map[string]string{}
+	for k, v := range target.EffectiveVars() {
+		if strings.HasPrefix(k, TARGET_STACK_PREFIX) {
+			sizes[strings.TrimPrefix(k, TARGET_STACK_PREFIX)] = v
+		}
+	}
+
+	return sizes
+}
+
+// Returns the API provider preferences specified in target.yml, keyed by API
+// (e.g., "target.api_preferences: {log: sys/log/full}" produces an entry
+// "log" => "sys/log/full").
+func (target *Target) ApiPreferences() map[string]string {
+	prefs := map[string]string{}
+	for k, v := range target.EffectiveVars() {
+		if strings.HasPrefix(k, TARGET_API_PREF_PREFIX) {
+			prefs[strings.TrimPrefix(k, TARGET_API_PREF_PREFIX)] = v
+		}
+	}
+
+	return prefs
+}
+
+// Returns the shell command configured for the specified build hook (e.g.,
+// "target.hooks.post_link"), or "" if the target doesn't define one.
+func (target *Target) Hook(name string) string {
+	return target.EffectiveVars()[TARGET_HOOK_PREFIX+name]
+}
+
+// Returns the sanitizers (e.g., "address", "undefined") the target's code
+// should be built with.
+func (target *Target) Sanitizers() []string {
+	return strings.FieldsFunc(target.EffectiveVars()[TARGET_SANITIZERS_VAR],
+		func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// Returns the targets built along with this one ("target.companions"), e.g.,
+// the network core image of a dual-core MCU.  Companions may not have
+// companions of their own.
+func (target *Target) Companions() ([]*Target, error) {
+	names := strings.FieldsFunc(target.EffectiveVars()[TARGET_COMPANIONS_VAR],
+		func(r rune) bool { return r == ',' || r == ' ' })
+
+	companions := []*Target{}
+	for _, name := range names {
+		c := lookupTarget(GetTargets(), target, name)
+		if c == nil {
+			return nil, util.FmtNewtError(
+				"Target %s: unknown companion target \"%s\"",
+				target.FullName(), name)
+		}
+		if c == target {
+			return nil, util.FmtNewtError(
+				"Target %s lists itself as a companion", target.FullName())
+		}
+		if c.EffectiveVars()[TARGET_COMPANIONS_VAR] != "" {
+			return nil, util.FmtNewtError(
+				"Companion target %s of %s has companions of its own",
+				c.FullName(), target.FullName())
+		}
+		if c.AppName == "" {
+			return nil, util.FmtNewtError(
+				"Companion target %s of %s does not specify an app",
+				c.FullName(), target.FullName())
+		}
+
+		companions = append(companions, c)
+	}
+
+	return companions, nil
+}
+
+// Returns the number of builds kept in the target's build history
+// ("target.history"); 0 disables the history.
+func (target *Target) HistoryDepth() (int, error) {
+	str := target.EffectiveVars()[TARGET_HISTORY_VAR]
+	if str == "" {
+		return DEFAULT_HISTORY_DEPTH, nil
+	}
+
+	depth, err := util.AtoiNoOct(str)
+	if err != nil || depth < 0 {
+		return 0, util.FmtNewtError("Invalid %s value: \"%s\"",
+			TARGET_HISTORY_VAR, str)
+	}
+
+	return depth, nil
+}
+
+// Returns the environment variables ("NAME=value", sorted by name) the
+// target exports to toolchain commands and hooks.  target.yml keys are
+// case-insensitive, so names are converted to upper case; a variable set in a
+// derived target overrides one of the same name in any case in the targets it
+// inherits from.
+func (target *Target) Env() []string {
+	// Apply the settings of the root of the chain first.
+	chain := append([]*Target{target}, target.Ancestors()...)
+
+	vals := map[string]string{}
+	for i := len(chain) - 1; i >= 0; i-- {
+		for k, v := range chain[i].Vars {
+			if strings.HasPrefix(k, TARGET_ENV_PREFIX) {
+				name := strings.ToUpper(strings.TrimPrefix(k, TARGET_ENV_PREFIX))
+				vals[name] = v
+			}
+		}
+	}
+
+	env := make([]string, 0, len(vals))
+	for name, v := range vals {
+		env = append(env, name+"="+v)
+	}
+	sort.Strings(env)
+
+	return env
+}
+
+// Returns the name of the connection profile used to reach the target's
+// device, or "" if none is set.
+func (target *Target) ConnectionName() string {
+	return target.EffectiveVars()[TARGET_CONNECTION_VAR]
+}
+
+// Returns the version of the frozen ROM symbol list the loader is checked
+// against, or "" if none is set.
+func (target *Target) RomVersion() string {
+	return target.EffectiveVars()[TARGET_ROM_VERSION_VAR]
+}
+
+// Returns which app symbols a split image's loader keeps
+// ("target.loader_keep"): every symbol from a shared package in the app's
+// tentative executable (the default), or only those the app's own packages
+// reference.
+func (target *Target) LoaderKeep() (string, error) {
+	str := target.EffectiveVars()[TARGET_LOADER_KEEP_VAR]
+	switch str {
+	case "":
+		return TARGET_LOADER_KEEP_ALL, nil
+	case TARGET_LOADER_KEEP_ALL, TARGET_LOADER_KEEP_REFERENCED:
+		return str, nil
+	default:
+		return "", util.FmtNewtError(
+			"Invalid %s value: \"%s\"; must be %s or %s",
+			TARGET_LOADER_KEEP_VAR, str, TARGET_LOADER_KEEP_ALL,
+			TARGET_LOADER_KEEP_REFERENCED)
+	}
+}
+
+// Returns the value of an image layout setting (e.g., "header_size"), or ""
+// if the target doesn't specify it.
+func (target *Target) ImageSetting(name string) string {
+	return target.EffectiveVars()[TARGET_IMAGE_PREFIX+name]
+}
+
+// Returns the path of the frozen ROM symbol list with the specified version.
+func (target *Target) RomSymbolsPath(version string) string {
+	return filepath.Join(target.basePkg.BasePath(), TARGET_ROM_DIR,
+		version+".yml")
+}
+
+// Returns the template that the target's artifacts are named with, or "" if
+// the target does not specify one.
+func (target *Target) ArtifactNameTemplate() string {
+	return target.EffectiveVars()[TARGET_ARTIFACT_NAME_VAR]
+}
+
 func (target *Target) BinBasePath() string {
 	appPkg := target.App()
 	if appPkg == nil {
@@ -274,6 +654,29 @@ func buildTargetMap() error {
 		}
 	}
 
+	// Resolve inheritance once all targets are known.  Sort for consistent
+	// warning output.
+	names := make([]string, 0, len(globalTargetMap))
+	for name, _ := range globalTargetMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	broken := []string{}
+	for _, name := range names {
+		t := globalTargetMap[name]
+		if err := resolveBase(globalTargetMap, t, nil); err != nil {
+			nerr := err.(*util.NewtError)
+			util.ErrorMessage(util.VERBOSITY_QUIET,
+				"Warning: failed to load target \"%s\": %s\n", t.Name(),
+				nerr.Text)
+			broken = append(broken, name)
+		}
+	}
+	for _, name := range broken {
+		delete(globalTargetMap, name)
+	}
+
 	return nil
 }
 
@@ -0,0 +1,74 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package target
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEnv(t *testing.T) {
+	base := &Target{
+		Vars: map[string]string{
+			"target.app":         "apps/blinky",
+			"target.env.cc_opts": "-O2",
+			"target.env.FOO":     "base",
+			"target.env.Bar":     "1",
+		},
+	}
+	derived := &Target{
+		Vars: map[string]string{
+			"target.inherits":    "targets/base",
+			"target.env.foo":     "derived",
+			"target.env.verbose": "yes",
+		},
+		base: base,
+	}
+
+	tests := []struct {
+		name   string
+		target *Target
+		env    []string
+	}{
+		{
+			name:   "no variables",
+			target: &Target{Vars: map[string]string{"target.app": "a"}},
+			env:    []string{},
+		},
+		{
+			name:   "upper case names",
+			target: base,
+			env:    []string{"BAR=1", "CC_OPTS=-O2", "FOO=base"},
+		},
+		{
+			name:   "inherited and overridden in another case",
+			target: derived,
+			env: []string{"BAR=1", "CC_OPTS=-O2", "FOO=derived",
+				"VERBOSE=yes"},
+		},
+	}
+
+	for _, test := range tests {
+		env := test.target.Env()
+		if !reflect.DeepEqual(env, test.env) {
+			t.Errorf("%s: got %q, want %q", test.name, env, test.env)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package toolchain
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Suffix of the temporary file a build artifact is written to before it is
+// renamed into place.
+const ARTIFACT_TMP_SUFFIX = ".tmp"
+
+// The signatures of a regular and of a thin static library, and the size of
+// the header preceding each archive member.
+const ARCHIVE_MAGIC = "!<arch>\n"
+const ARCHIVE_MAGIC_THIN = "!<thin>\n"
+const ARCHIVE_HDR_SIZE = 60
+
+// Runs a toolchain command that writes the specified file, such that the file
+// is either completely written or left untouched.  The command writes to a
+// temporary file instead, which is renamed over the destination file once
+// the command succeeds.  An interrupted build thus never leaves behind a
+// truncated artifact with a fresh modification time, which the dependency
+// tracker would consider up to date.
+//
+// @param cmd                   The command to run; every argument equal to
+//                                  dstFile is replaced with the temporary
+//                                  file's path.
+// @param env                   Additional environment variables for the
+//                                  command.
+// @param dstFile               The file that the command generates.
+func runAtomicToolCmd(cmd []string, env []string, dstFile string,
+	maxDbgOutputChrs int) ([]byte, error) {
+
+	tmpFile := dstFile + ARTIFACT_TMP_SUFFIX
+	os.Remove(tmpFile)
+
+	tmpCmd := make([]string, len(cmd))
+	for i, arg := range cmd {
+		if arg == dstFile {
+			arg = tmpFile
+		}
+		tmpCmd[i] = arg
+	}
+
+	out, err := runToolCmd(tmpCmd, env, maxDbgOutputChrs)
+	if err != nil {
+		// Don't let a stale artifact outlive the failed command.
+		os.Remove(tmpFile)
+		os.Remove(dstFile)
+		return out, err
+	}
+
+	if err := os.Rename(tmpFile, dstFile); err != nil {
+		os.Remove(tmpFile)
+		return out, util.ChildNewtError(err)
+	}
+
+	return out, nil
+}
+
+// Writes the specified file via a temporary file, such that the file is
+// either completely written or left untouched.
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := util.FixLongPath(path + ARTIFACT_TMP_SUFFIX)
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		os.Remove(tmpPath)
+		return util.ChildNewtError(err)
+	}
+
+	if err := os.Rename(tmpPath, util.FixLongPath(path)); err != nil {
+		os.Remove(tmpPath)
+		return util.ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Indicates whether the specified file is a complete static library: it
+// starts with an archive signature, and its member headers are intact and
+// account for the whole file.  A thin archive's members other than its
+// symbol and name tables are stored outside of it.
+func archiveValid(path string) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	thin := false
+	switch {
+	case bytes.HasPrefix(data, []byte(ARCHIVE_MAGIC)):
+	case bytes.HasPrefix(data, []byte(ARCHIVE_MAGIC_THIN)):
+		thin = true
+	default:
+		return false
+	}
+
+	off := len(ARCHIVE_MAGIC)
+	for off < len(data) {
+		if off+ARCHIVE_HDR_SIZE > len(data) {
+			return false
+		}
+		hdr := data[off : off+ARCHIVE_HDR_SIZE]
+		if string(hdr[58:60]) != "`\n" {
+			return false
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(string(hdr[48:58])))
+		if err != nil || size < 0 {
+			return false
+		}
+		off += ARCHIVE_HDR_SIZE
+
+		// A thin archive only contains the data of its symbol and name
+		// tables.
+		name := strings.TrimSpace(string(hdr[0:16]))
+		if thin && name != "/" && name != "//" && name != "/SYM64/" {
+			continue
+		}
+
+		// Member data is padded to an even length.
+		off += size + size%2
+	}
+
+	return off == len(data) || off == len(data)+1
+}
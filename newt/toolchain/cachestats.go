@@ -0,0 +1,270 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package toolchain
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Hit and miss counts of the compilation caches used by one build: the
+// project's object store (see EnableObjStore()) and the compiler launcher
+// (see SetLauncher()).  A single instance is shared by all of a target's
+// compilers.
+type CacheStats struct {
+	mtx sync.Mutex
+
+	ObjStoreEnabled bool
+	ObjStoreHits    int
+	ObjStoreMisses  int
+
+	// The launcher's hits and misses are only known if it is ccache, which
+	// logs the result of each compilation to LauncherLog.
+	Launcher            string
+	LauncherRuns        int
+	LauncherStatsKnown  bool
+	LauncherHits        int
+	LauncherMisses      int
+	LauncherUncacheable int
+	LauncherLog         string
+}
+
+// Runs compilations through the specified command (e.g., "ccache").  The
+// launcher is not part of the recorded commands, so enabling or disabling it
+// doesn't trigger a rebuild.
+func (c *Compiler) SetLauncher(launcher []string) {
+	c.launcher = launcher
+}
+
+// Records the compiler's cache hits and misses in the specified statistics.
+func (c *Compiler) SetCacheStats(s *CacheStats) {
+	c.cacheStats = s
+}
+
+// Environment variable that tells ccache (4.0 and later) where to log the
+// result of each compilation.
+const CCACHE_STATSLOG_ENV = "CCACHE_STATSLOG"
+
+func isCcache(launcher []string) bool {
+	if len(launcher) == 0 {
+		return false
+	}
+
+	name := strings.TrimSuffix(filepath.Base(launcher[0]), ".exe")
+	return name == "ccache"
+}
+
+// Creates the statistics of a build.  If the compiler launcher is ccache, its
+// results are logged to statsLog, which is truncated.
+func NewCacheStats(launcher []string, objStore bool,
+	statsLog string) *CacheStats {
+
+	s := &CacheStats{
+		ObjStoreEnabled: objStore,
+		Launcher:        strings.Join(launcher, " "),
+	}
+
+	if isCcache(launcher) {
+		os.MkdirAll(filepath.Dir(statsLog), 0755)
+		if err := os.Remove(statsLog); err == nil || os.IsNotExist(err) {
+			s.LauncherLog = statsLog
+		}
+	}
+
+	return s
+}
+
+// Indicates whether any compilation cache is in use.
+func (s *CacheStats) Enabled() bool {
+	return s != nil && (s.ObjStoreEnabled || s.Launcher != "")
+}
+
+// Indicates whether any file was looked up in a cache.
+func (s *CacheStats) Active() bool {
+	if !s.Enabled() {
+		return false
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.ObjStoreHits+s.ObjStoreMisses+s.LauncherRuns > 0
+}
+
+func (s *CacheStats) recordObjStore(hit bool) {
+	if s == nil {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if hit {
+		s.ObjStoreHits++
+	} else {
+		s.ObjStoreMisses++
+	}
+}
+
+// Counts a compilation run through the launcher, and returns the environment
+// to run it with.
+func (s *CacheStats) launcherEnv() []string {
+	if s == nil {
+		return nil
+	}
+
+	s.mtx.Lock()
+	s.LauncherRuns++
+	s.mtx.Unlock()
+
+	if s.LauncherLog == "" {
+		return nil
+	}
+
+	return []string{CCACHE_STATSLOG_ENV + "=" + s.LauncherLog}
+}
+
+// Tallies the results that ccache logged for the build.  The log consists of
+// a "# <source file>" line for each compilation, followed by the names of the
+// counters it incremented (e.g., "direct_cache_hit", "cache_miss").
+func (s *CacheStats) Collect() {
+	if s == nil || s.LauncherLog == "" {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.LauncherRuns == 0 {
+		s.LauncherStatsKnown = true
+		return
+	}
+
+	lines, err := util.ReadLines(s.LauncherLog)
+	if err != nil {
+		// ccache is too old to log statistics.
+		return
+	}
+
+	s.LauncherStatsKnown = true
+	s.LauncherHits = 0
+	s.LauncherMisses = 0
+	s.LauncherUncacheable = 0
+
+	result := ""
+	flush := func() {
+		switch result {
+		case "hit":
+			s.LauncherHits++
+		case "miss":
+			s.LauncherMisses++
+		case "none":
+			s.LauncherUncacheable++
+		}
+		result = ""
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#"):
+			flush()
+			result = "none"
+
+		case strings.HasSuffix(line, "cache_hit"):
+			result = "hit"
+
+		case line == "cache_miss":
+			if result != "hit" {
+				result = "miss"
+			}
+		}
+	}
+	flush()
+}
+
+func cacheRatio(hits int, misses int) string {
+	if hits+misses == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.0f%%", float64(hits)*100/float64(hits+misses))
+}
+
+// Describes the statistics in one line per cache.
+func (s *CacheStats) Lines() []string {
+	if !s.Enabled() {
+		return nil
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	lines := []string{}
+	if s.ObjStoreEnabled {
+		lines = append(lines, fmt.Sprintf(
+			"object store: %d hits, %d misses (%s hit rate)",
+			s.ObjStoreHits, s.ObjStoreMisses,
+			cacheRatio(s.ObjStoreHits, s.ObjStoreMisses)))
+	}
+	if s.Launcher != "" {
+		if s.LauncherStatsKnown {
+			line := fmt.Sprintf("%s: %d hits, %d misses (%s hit rate)",
+				s.Launcher, s.LauncherHits, s.LauncherMisses,
+				cacheRatio(s.LauncherHits, s.LauncherMisses))
+			if s.LauncherUncacheable > 0 {
+				line += fmt.Sprintf(", %d uncacheable",
+					s.LauncherUncacheable)
+			}
+			lines = append(lines, line)
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: statistics unavailable",
+				s.Launcher))
+		}
+	}
+
+	return lines
+}
+
+// Fields describing the statistics in a build event.
+func (s *CacheStats) EventFields() map[string]interface{} {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	fields := map[string]interface{}{}
+	if s.ObjStoreEnabled {
+		fields["objstore_hits"] = s.ObjStoreHits
+		fields["objstore_misses"] = s.ObjStoreMisses
+	}
+	if s.Launcher != "" {
+		fields["launcher"] = s.Launcher
+		if s.LauncherStatsKnown {
+			fields["launcher_hits"] = s.LauncherHits
+			fields["launcher_misses"] = s.LauncherMisses
+			fields["launcher_uncacheable"] = s.LauncherUncacheable
+		}
+	}
+
+	return fields
+}
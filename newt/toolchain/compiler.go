@@ -23,7 +23,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
@@ -64,6 +63,11 @@ type Compiler struct {
 	objPathList   map[string]bool
 	LinkerScripts []string
 
+	// Linker script fragments included by the linker scripts (e.g.,
+	// generated memory maps).  Their directories are added to the linker's
+	// search path.
+	LinkerIncludes []string
+
 	// Needs to be locked whenever a mutable field in this struct is accessed
 	// during a build.  Currently, objPathList is the only such member.
 	mutex *sync.Mutex
@@ -76,9 +80,14 @@ type Compiler struct {
 	odPath                string
 	osPath                string
 	ocPath                string
+	gdbPath               string
+	a2lPath               string
 	ldResolveCircularDeps bool
 	ldMapFile             bool
 	ldBinFile             bool
+	arThin                bool
+	ldIncrementalFlags    []string
+	depsSeparate          bool
 	baseDir               string
 	srcDir                string
 	dstDir                string
@@ -96,6 +105,33 @@ type Compiler struct {
 	lclInfoAdded bool
 
 	extraDeps []string
+
+	// Prefixes of additional order-sensitive flags; see normalizeFlags().
+	orderedFlags []string
+
+	// Name of the package being compiled; used to attribute diagnostics.
+	pkgName string
+
+	// Incremental relinking; see EnableIncremental().
+	thinArchives  bool
+	ldIncremental bool
+
+	// Object files shared with other targets; see EnableObjStore().
+	objStore *objStore
+
+	// Command that compilations are run through (e.g., ccache); see
+	// SetLauncher().
+	launcher []string
+
+	// Cache statistics of the build; see SetCacheStats().
+	cacheStats *CacheStats
+
+	// Per-file compile times of the build; see SetCompileTimes().
+	compileTimes *CompileTimes
+
+	// Additional environment variables for the toolchain commands; see
+	// SetEnv().
+	env []string
 }
 
 type CompilerJob struct {
@@ -171,6 +207,51 @@ func addFlags(flagType string, orig []string, new []string) []string {
 	return combined
 }
 
+// Compares a set of declared flags against the flags actually in use and
+// describes each incompatibility.  A declared flag of the form "-x=y" matches
+// any actual flag with the same base and value; several values may be
+// declared for the same base.  A declared flag without a value must be
+// present in the actual set.
+func IncompatibleFlags(declared []string, actual []string) []string {
+	allowed := map[string][]string{}
+	bases := []string{}
+	for _, f := range declared {
+		base := flagsBase(f)
+		if _, ok := allowed[base]; !ok {
+			bases = append(bases, base)
+		}
+		allowed[base] = append(allowed[base], f)
+	}
+
+	actualMap := flagsMap(actual)
+
+	problems := []string{}
+	for _, base := range bases {
+		cur := actualMap[base]
+		if cur == "" {
+			problems = append(problems, fmt.Sprintf(
+				"requires %s; not used by target",
+				strings.Join(allowed[base], " or ")))
+			continue
+		}
+
+		found := false
+		for _, f := range allowed[base] {
+			if f == cur {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf(
+				"requires %s; target uses %s",
+				strings.Join(allowed[base], " or "), cur))
+		}
+	}
+
+	return problems
+}
+
 func (ci *CompilerInfo) AddCflags(cflags []string) {
 	ci.Cflags = addFlags("cflag", ci.Cflags, cflags)
 }
@@ -198,7 +279,7 @@ func NewCompiler(compilerDir string, dstDir string,
 
 	c.depTracker = NewDepTracker(c)
 
-	util.StatusMessage(util.VERBOSITY_VERBOSE,
+	statusMessage(util.VERBOSITY_VERBOSE,
 		"Loading compiler %s, buildProfile %s\n", compilerDir,
 		buildProfile)
 	err := c.load(compilerDir, buildProfile)
@@ -247,10 +328,15 @@ func (c *Compiler) load(compilerDir string, buildProfile string) error {
 	c.odPath = newtutil.GetStringFeatures(v, features, "compiler.path.objdump")
 	c.osPath = newtutil.GetStringFeatures(v, features, "compiler.path.objsize")
 	c.ocPath = newtutil.GetStringFeatures(v, features, "compiler.path.objcopy")
+	c.gdbPath = newtutil.GetStringFeatures(v, features, "compiler.path.gdb")
+	c.a2lPath = newtutil.GetStringFeatures(v, features,
+		"compiler.path.addr2line")
 
 	c.lclInfo.Cflags = loadFlags(v, features, "compiler.flags")
 	c.lclInfo.Lflags = loadFlags(v, features, "compiler.ld.flags")
 	c.lclInfo.Aflags = loadFlags(v, features, "compiler.as.flags")
+	c.orderedFlags = newtutil.GetStringSliceFeatures(v, features,
+		"compiler.flags.ordered")
 
 	c.ldResolveCircularDeps, err = newtutil.GetBoolFeatures(v, features,
 		"compiler.ld.resolve_circular_deps")
@@ -270,6 +356,24 @@ func (c *Compiler) load(compilerDir string, buildProfile string) error {
 		return err
 	}
 
+	c.arThin, err = newtutil.GetBoolFeatures(v, features,
+		"compiler.archive.thin")
+	if err != nil {
+		return err
+	}
+	c.ldIncrementalFlags = loadFlags(v, features,
+		"compiler.ld.incremental_flags")
+
+	c.depsSeparate, err = newtutil.GetBoolFeatures(v, features,
+		"compiler.deps.separate")
+	if err != nil {
+		return err
+	}
+
+	if err := checkToolchainPin(c.ccPath); err != nil {
+		return err
+	}
+
 	if len(c.lclInfo.Cflags) == 0 {
 		// Assume no Cflags implies an unsupported build profile.
 		return util.FmtNewtError("Compiler doesn't support build profile "+
@@ -280,14 +384,63 @@ func (c *Compiler) load(compilerDir string, buildProfile string) error {
 	return nil
 }
 
+// Indicates which incremental relinking features the toolchain supports:
+// thin archives (compiler.archive.thin) and an incremental linker mode
+// (compiler.ld.incremental_flags).
+func (c *Compiler) IncrementalSupport() (bool, bool) {
+	return c.arThin, len(c.ldIncrementalFlags) > 0
+}
+
+// Enables the incremental relinking features the toolchain supports.  Thin
+// archives only reference the package's object files, so rearchiving a
+// package after a one-file edit doesn't copy every object; objcopy can't
+// rewrite them, so thinArchives must be false if archives get
+// post-processed.
+func (c *Compiler) EnableIncremental(thinArchives bool) {
+	c.thinArchives = thinArchives && c.arThin
+	c.ldIncremental = true
+}
+
+// Sets additional environment variables ("NAME=value") for the toolchain
+// commands the compiler runs (e.g., the target's).  They are part of each
+// recorded command, so changing them triggers a rebuild.
+func (c *Compiler) SetEnv(env []string) {
+	c.env = env
+}
+
+// Replaces the C compiler specified by the compiler package.  The new
+// compiler is also used for assembly and linking if the package uses its C
+// compiler for those.
+func (c *Compiler) SetCcPath(ccPath string) {
+	if c.asPath == c.ccPath {
+		c.asPath = ccPath
+	}
+	c.ccPath = ccPath
+}
+
 func (c *Compiler) AddInfo(info *CompilerInfo) {
 	c.info.AddCompilerInfo(info)
 }
 
+// Returns the C flags that would be applied to a source file compiled with
+// this compiler, including those from the compiler package itself.
+func (c *Compiler) Cflags() []string {
+	cflags := append([]string{}, c.info.Cflags...)
+	if !c.lclInfoAdded {
+		cflags = addFlags("cflag", cflags, c.lclInfo.Cflags)
+	}
+
+	return cflags
+}
+
 func (c *Compiler) DstDir() string {
 	return c.dstDir
 }
 
+func (c *Compiler) SetPkgName(pkgName string) {
+	c.pkgName = pkgName
+}
+
 func (c *Compiler) SetSrcDir(srcDir string) {
 	c.srcDir = filepath.ToSlash(filepath.Clean(srcDir))
 }
@@ -331,26 +484,25 @@ func (c *Compiler) includesStrings() []string {
 
 	tokens := make([]string, len(includes))
 	for i, s := range includes {
-		s = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(s)), c.baseDir+"/")
-		tokens[i] = "-I" + s
+		tokens[i] = "-I" + c.relPath(s)
 	}
 
 	return tokens
 }
 
 func (c *Compiler) cflagsStrings() []string {
-	cflags := util.SortFields(c.info.Cflags...)
-	return cflags
+	return c.normalizeFlags(c.info.Cflags...)
 }
 
-func (c *Compiler) aflagsStrings() []string {
-	aflags := util.SortFields(c.info.Aflags...)
-	return aflags
+// Returns the flags passed to the assembler: the compiler flags followed by
+// the assembler flags.
+func (c *Compiler) asmFlagsStrings() []string {
+	return c.normalizeFlags(append(append([]string{}, c.info.Cflags...),
+		c.info.Aflags...)...)
 }
 
 func (c *Compiler) lflagsStrings() []string {
-	lflags := util.SortFields(c.info.Lflags...)
-	return lflags
+	return c.normalizeFlags(c.info.Lflags...)
 }
 
 func (c *Compiler) depsString() string {
@@ -358,11 +510,17 @@ func (c *Compiler) depsString() string {
 	return strings.Join(extraDeps, " ") + "\n"
 }
 
+// Returns the specified path relative to the project base directory, with
+// forward slashes.  Tools run from the base directory, so this keeps their
+// command lines short and clear of Windows' 260-character path limit.
+func (c *Compiler) relPath(path string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)),
+		filepath.ToSlash(c.baseDir)+"/")
+}
+
 func (c *Compiler) dstFilePath(srcPath string) string {
-	relSrcPath := strings.TrimPrefix(filepath.ToSlash(srcPath), c.baseDir+"/")
-	relDstPath := strings.TrimSuffix(relSrcPath, filepath.Ext(srcPath))
-	dstPath := fmt.Sprintf("%s/%s", c.dstDir, relDstPath)
-	return dstPath
+	relDstPath := strings.TrimSuffix(c.relPath(srcPath), filepath.Ext(srcPath))
+	return filepath.Join(c.dstDir, filepath.FromSlash(relDstPath))
 }
 
 // Calculates the command-line invocation necessary to compile the specified C
@@ -389,7 +547,7 @@ func (c *Compiler) CompileFileCmd(file string, compilerType int) (
 		// Include both the compiler flags and the assembler flags.
 		// XXX: This is not great.  We don't have a way of specifying compiler
 		// flags without also passing them to the assembler.
-		flags = append(c.cflagsStrings(), c.aflagsStrings()...)
+		flags = c.asmFlagsStrings()
 	case COMPILER_TYPE_CPP:
 		cmdName = c.cppPath
 		flags = c.cflagsStrings()
@@ -397,62 +555,102 @@ func (c *Compiler) CompileFileCmd(file string, compilerType int) (
 		return nil, util.NewNewtError("Unknown compiler type")
 	}
 
-	srcPath := strings.TrimPrefix(file, c.baseDir+"/")
 	cmd := []string{cmdName}
 	cmd = append(cmd, flags...)
 	cmd = append(cmd, c.includesStrings()...)
 	cmd = append(cmd, []string{
 		"-c",
 		"-o",
-		objPath,
-		srcPath,
+		c.relPath(objPath),
+		c.relPath(file),
 	}...)
 
 	return cmd, nil
 }
 
-// Generates a dependency Makefile (.d) for the specified source C file.
+// Indicates whether the dependency file of a source file of the specified
+// type is written while the file compiles (-MMD -MF), so that it is always
+// as fresh as the object file.  Otherwise, a separate preprocessor pass
+// generates it; see GenDepsForFile().  The compiler package can force the
+// separate pass with compiler.deps.separate, for compilers that don't
+// support -MMD.  Assembly files only get their dependencies from the
+// assembler if it is the compiler driver.
+func (c *Compiler) depsOnCompile(compilerType int) bool {
+	if c.depsSeparate {
+		return false
+	}
+
+	switch compilerType {
+	case COMPILER_TYPE_C, COMPILER_TYPE_CPP:
+		return true
+	case COMPILER_TYPE_ASM:
+		return c.asPath == c.ccPath
+	default:
+		return false
+	}
+}
+
+// Returns the dependency line for the extra dependencies (.yml files), which
+// is appended to each dependency file.  Its target is the object file as
+// named by the compiler's -MT option, so that ParseDepsFile() treats it as
+// part of the same rule.
+func (c *Compiler) extraDepsLine(file string) []byte {
+	objPath := c.dstFilePath(file) + ".o"
+	return []byte(c.relPath(objPath) + ": " + c.depsString())
+}
+
+// Generates a dependency Makefile (.d) for the specified source C file with a
+// separate preprocessor pass; only used if the compiler doesn't write it
+// during compilation (see depsOnCompile()).
 //
 // @param file                  The name of the source file.
 func (c *Compiler) GenDepsForFile(file string) error {
+	objPath := c.dstFilePath(file) + ".o"
 	depPath := c.dstFilePath(file) + ".d"
 	depDir := filepath.Dir(depPath)
 	if util.NodeNotExist(depDir) {
-		os.MkdirAll(depDir, 0755)
+		util.MkdirAll(depDir, 0755)
 	}
 
-	srcPath := strings.TrimPrefix(file, c.baseDir+"/")
+	srcPath := c.relPath(file)
 	cmd := []string{c.ccPath}
 	cmd = append(cmd, c.cflagsStrings()...)
 	cmd = append(cmd, c.includesStrings()...)
-	cmd = append(cmd, []string{"-MM", "-MG", srcPath}...)
+	cmd = append(cmd, []string{"-MM", "-MG", "-MT" + c.relPath(objPath),
+		srcPath}...)
 
-	o, err := util.ShellCommandLimitDbgOutput(cmd, nil, 0)
+	o, err := runToolCmd(cmd, c.env, 0)
 	if err != nil {
 		return err
 	}
 
-	// Write the compiler output to a dependency file.
-	f, err := os.OpenFile(depPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
-	if err != nil {
-		return util.ChildNewtError(err)
-	}
-	defer f.Close()
+	// Append the extra dependencies (.yml files) to the compiler output.  A
+	// partially written dependency file would hide dependencies, so the file
+	// is written atomically.
+	o = append(o, c.extraDepsLine(file)...)
 
-	if _, err := f.Write(o); err != nil {
-		return util.ChildNewtError(err)
-	}
+	return writeFileAtomic(depPath, o)
+}
 
-	// Append the extra dependencies (.yml files) to the .d file.
-	objFile := strings.TrimSuffix(file, filepath.Ext(file)) + ".o"
-	if _, err := f.WriteString(objFile + ": " + c.depsString()); err != nil {
-		return util.NewNewtError(err.Error())
-	}
+// Runs a toolchain executable with the specified additional environment
+// variables, in the toolchain container if the project specifies one.
+func runToolCmd(cmd []string, env []string,
+	maxDbgOutputChrs int) ([]byte, error) {
 
-	return nil
+	cmd, env = newtutil.ToolchainCmd(cmd, env)
+	return util.ShellCommandLimitDbgOutput(cmd, env, maxDbgOutputChrs)
 }
 
-func serializeCommand(cmd []string) []byte {
+// Serializes a command and the environment variables it runs with for
+// comparison with the recorded command of an earlier build.
+func serializeCommand(cmd []string, env []string) []byte {
+	// The target's environment variables can affect the tools, so a change
+	// to them requires a rebuild just as a change to the command does.
+	if len(env) > 0 {
+		cmd = append(append([]string{}, cmd...), "# env:")
+		cmd = append(cmd, env...)
+	}
+
 	// Use a newline as the separator rather than a space to disambiguate cases
 	// where arguments contain spaces.
 	return []byte(strings.Join(cmd, "\n"))
@@ -465,15 +663,12 @@ func serializeCommand(cmd []string) []byte {
 // @param dstFile               The output file whose build invocation is being
 //                                  recorded.
 // @param cmd                   The command strings to write.
-func writeCommandFile(dstFile string, cmd []string) error {
+// @param env                   The environment variables the command ran
+//                                  with.
+func writeCommandFile(dstFile string, cmd []string, env []string) error {
 	cmdPath := dstFile + ".cmd"
-	content := serializeCommand(cmd)
-	err := ioutil.WriteFile(cmdPath, content, 0644)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	content := serializeCommand(cmd, env)
+	return writeFileAtomic(cmdPath, content)
 }
 
 // Adds the info from the compiler package to the common set if it hasn't
@@ -495,7 +690,7 @@ func (c *Compiler) CompileFile(file string, compilerType int) error {
 	objPath := c.dstFilePath(file) + ".o"
 	objDir := filepath.Dir(objPath)
 	if util.NodeNotExist(objDir) {
-		os.MkdirAll(objDir, 0755)
+		util.MkdirAll(objDir, 0755)
 	}
 
 	c.mutex.Lock()
@@ -507,24 +702,90 @@ func (c *Compiler) CompileFile(file string, compilerType int) error {
 		return err
 	}
 
-	srcPath := strings.TrimPrefix(file, c.baseDir+"/")
+	depPath := c.dstFilePath(file) + ".d"
+
+	// Another target may already have compiled this file identically.
+	useStore := c.objStoreUsable(file, compilerType)
+	if useStore {
+		entry := c.objStore.lookup(cmd, c.env)
+		c.cacheStats.recordObjStore(entry != "")
+		if entry != "" {
+			reportProgress("Reusing", c.pkgName, c.relPath(file))
+			return c.fetchObject(entry, cmd, objPath, depPath, file)
+		}
+	}
+
+	srcPath := c.relPath(file)
 	switch compilerType {
 	case COMPILER_TYPE_C:
-		util.StatusMessage(util.VERBOSITY_DEFAULT, "Compiling %s\n", srcPath)
+		reportProgress("Compiling", c.pkgName, srcPath)
 	case COMPILER_TYPE_CPP:
-		util.StatusMessage(util.VERBOSITY_DEFAULT, "Compiling %s\n", srcPath)
+		reportProgress("Compiling", c.pkgName, srcPath)
 	case COMPILER_TYPE_ASM:
-		util.StatusMessage(util.VERBOSITY_DEFAULT, "Assembling %s\n", srcPath)
+		reportProgress("Assembling", c.pkgName, srcPath)
 	default:
 		return util.NewNewtError("Unknown compiler type")
 	}
 
-	_, err = util.ShellCommand(cmd, nil)
+	// The dependency file is written alongside the object file, so that no
+	// separate pass is needed.  Its options are left out of the recorded
+	// command, which only depends on the object file's path anyway.
+	runCmd := cmd
+	depTmpPath := depPath + ARTIFACT_TMP_SUFFIX
+	depsOnCompile := c.depsOnCompile(compilerType)
+	if depsOnCompile {
+		runCmd = append(append([]string{}, cmd...),
+			"-MMD", "-MF", c.relPath(depTmpPath), "-MT"+c.relPath(objPath))
+	}
+
+	// The launcher is left out of the recorded command; it doesn't affect
+	// the object file.
+	env := c.env
+	if len(c.launcher) > 0 {
+		runCmd = append(append([]string{}, c.launcher...), runCmd...)
+		env = append(append([]string{}, c.env...),
+			c.cacheStats.launcherEnv()...)
+	}
+
+	start := time.Now()
+	out, err := runAtomicToolCmd(runCmd, env, c.relPath(objPath), -1)
+	RecordDiagnostics(c.pkgName, out)
+	duration := time.Since(start)
+	newtutil.EmitEvent(newtutil.EVENT_COMPILE, map[string]interface{}{
+		"package":     c.pkgName,
+		"file":        srcPath,
+		"success":     err == nil,
+		"duration_ms": duration.Nanoseconds() / 1e6,
+	})
+	if err == nil && len(c.launcher) == 0 {
+		c.compileTimes.record(filepath.ToSlash(c.relPath(objPath)), duration)
+	}
 	if err != nil {
+		os.Remove(depTmpPath)
+		return err
+	}
+
+	if depsOnCompile {
+		deps, err := ioutil.ReadFile(util.FixLongPath(depTmpPath))
+		os.Remove(depTmpPath)
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+		allDeps := append(append([]byte{}, deps...), c.extraDepsLine(file)...)
+		if err := writeFileAtomic(depPath, allDeps); err != nil {
+			return err
+		}
+
+		if useStore {
+			c.objStore.add(cmd, c.env, objPath, deps, depPath, out)
+		}
+	}
+
+	if err := c.recordDepsChecksums(objPath, depPath); err != nil {
 		return err
 	}
 
-	err = writeCommandFile(objPath, cmd)
+	err = writeCommandFile(objPath, cmd, c.env)
 	if err != nil {
 		return err
 	}
@@ -535,6 +796,32 @@ func (c *Compiler) CompileFile(file string, compilerType int) error {
 	return nil
 }
 
+// Takes an object file that another target compiled with the same command
+// from the object store, in place of compiling it.
+func (c *Compiler) fetchObject(entry string, cmd []string, objPath string,
+	depPath string, file string) error {
+
+	log.Debugf("Reusing %s from the object store (%s)", objPath, entry)
+
+	out, err := c.objStore.fetch(entry, objPath, depPath,
+		c.extraDepsLine(file))
+	if err != nil {
+		return err
+	}
+	RecordDiagnostics(c.pkgName, out)
+
+	if err := c.recordDepsChecksums(objPath, depPath); err != nil {
+		return err
+	}
+	if err := writeCommandFile(objPath, cmd, c.env); err != nil {
+		return err
+	}
+
+	c.depTracker.MostRecent = time.Now()
+
+	return nil
+}
+
 func (c *Compiler) shouldIgnoreFile(file string) bool {
 	file = strings.TrimPrefix(file, c.srcDir)
 	for _, re := range c.info.IgnoreFiles {
@@ -657,14 +944,14 @@ func (c *Compiler) CopyArchive(filename string) error {
 		return nil
 	}
 
-	tgtFile := c.dstDir + "/" + filepath.Base(filename)
+	tgtFile := filepath.Join(c.dstDir, filepath.Base(filename))
 	copyRequired, err := c.depTracker.CopyRequired(filename)
 	if err != nil {
 		return err
 	}
 	if copyRequired {
 		err = util.CopyFile(filename, tgtFile)
-		util.StatusMessage(util.VERBOSITY_DEFAULT, "copying %s\n",
+		statusMessage(util.VERBOSITY_DEFAULT, "copying %s\n",
 			filepath.ToSlash(tgtFile))
 	}
 
@@ -697,8 +984,9 @@ func (c *Compiler) processEntry(node os.FileInfo, cType int,
 	prevSrcDir := c.srcDir
 	prevDstDir := c.dstDir
 
+	// The source directory is kept in slash form, like the files under it.
 	c.srcDir += "/" + node.Name()
-	c.dstDir += "/" + node.Name()
+	c.dstDir = filepath.Join(c.dstDir, node.Name())
 
 	entries, err := c.RecursiveCollectEntries(cType, ignDirs)
 
@@ -747,7 +1035,7 @@ func (c *Compiler) RecursiveCollectEntries(cType int,
 	}
 
 	for _, ext := range exts {
-		files, _ := filepath.Glob(c.srcDir + "/*." + ext)
+		files, _ := filepath.Glob(filepath.Join(c.srcDir, "*."+ext))
 		for _, file := range files {
 			file = filepath.ToSlash(file)
 			entries = append(entries, CompilerJob{
@@ -761,6 +1049,61 @@ func (c *Compiler) RecursiveCollectEntries(cType int,
 	return entries, nil
 }
 
+// Collects compile jobs for an explicit list of files.  Each file's compiler
+// is chosen by its extension; static libraries (".a") are copied as is.
+func (c *Compiler) CollectFileEntries(files []string) ([]CompilerJob, error) {
+	// Make sure the compiler package info is added to the global set.
+	c.ensureLclInfoAdded()
+
+	entries := []CompilerJob{}
+	for _, file := range files {
+		file = filepath.ToSlash(file)
+		if util.NodeNotExist(file) {
+			return nil, util.FmtNewtError(
+				"Specified source file %s does not exist", file)
+		}
+
+		cType := COMPILER_TYPE_ARCHIVE
+		if filepath.Ext(file) != ".a" {
+			var err error
+			cType, err = CompilerTypeForFile(file)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, CompilerJob{
+			Filename:     file,
+			Compiler:     c,
+			CompilerType: cType,
+		})
+	}
+
+	return entries, nil
+}
+
+// Determines which compiler handles the specified source file, based on the
+// file's extension.
+func CompilerTypeForFile(file string) (int, error) {
+	ext := strings.TrimPrefix(filepath.Ext(file), ".")
+
+	cTypes := []int{COMPILER_TYPE_C, COMPILER_TYPE_CPP, COMPILER_TYPE_ASM}
+	for _, cType := range cTypes {
+		exts, err := compilerTypeToExts(cType)
+		if err != nil {
+			return -1, err
+		}
+
+		for _, e := range exts {
+			if e == ext {
+				return cType, nil
+			}
+		}
+	}
+
+	return -1, util.FmtNewtError("Unrecognized source file type: %s", file)
+}
+
 func RunJob(record CompilerJob) error {
 	switch record.CompilerType {
 	case COMPILER_TYPE_C:
@@ -777,6 +1120,55 @@ func RunJob(record CompilerJob) error {
 	}
 }
 
+// Determines whether the specified job has any work to do.  Ignored files
+// and up-to-date objects are fully handled here; only jobs that must still
+// produce output are reported as required.  Required jobs are executed with
+// RunRequiredJob.
+func JobRequired(record CompilerJob) (bool, error) {
+	c := record.Compiler
+	filename := filepath.ToSlash(record.Filename)
+
+	switch record.CompilerType {
+	case COMPILER_TYPE_C, COMPILER_TYPE_CPP, COMPILER_TYPE_ASM:
+		if c.shouldIgnoreFile(filename) {
+			log.Infof("Ignoring %s because package dictates it.", filename)
+			return false, nil
+		}
+
+		required, err := c.depTracker.CompileRequired(filename,
+			record.CompilerType)
+		if err != nil {
+			return false, err
+		}
+		if !required {
+			return false, c.SkipSourceFile(filename)
+		}
+		return true, nil
+
+	case COMPILER_TYPE_ARCHIVE:
+		return true, nil
+
+	default:
+		return false, util.NewNewtError("Wrong compiler type specified to " +
+			"JobRequired")
+	}
+}
+
+// Executes a job that JobRequired has reported as required.  Unlike RunJob,
+// no dependency check is performed.
+func RunRequiredJob(record CompilerJob) error {
+	switch record.CompilerType {
+	case COMPILER_TYPE_C, COMPILER_TYPE_CPP, COMPILER_TYPE_ASM:
+		return record.Compiler.CompileFile(filepath.ToSlash(record.Filename),
+			record.CompilerType)
+	case COMPILER_TYPE_ARCHIVE:
+		return record.Compiler.CopyArchive(record.Filename)
+	default:
+		return util.NewNewtError("Wrong compiler type specified to " +
+			"RunRequiredJob")
+	}
+}
+
 func (c *Compiler) getObjFiles(baseObjFiles []string) []string {
 	c.mutex.Lock()
 	for objName, _ := range c.objPathList {
@@ -828,22 +1220,65 @@ func (c *Compiler) CompileBinaryCmd(dstFile string, options map[string]bool,
 		}
 	}
 
-	cmd = append(cmd, c.lflagsStrings()...)
+	cmd = append(cmd, c.linkerFlags()...)
+	if c.ldIncremental {
+		cmd = append(cmd, c.ldIncrementalFlags...)
+	}
 
 	/* so we don't get multiple global definitions of the same vartiable */
 	//cmd += " -Wl,--warn-common "
 
-	for _, ls := range c.LinkerScripts {
-		cmd = append(cmd, "-T")
-		cmd = append(cmd, ls)
-	}
 	if options["mapFile"] {
-		cmd = append(cmd, "-Wl,-Map="+dstFile+".map")
+		// The cross reference table lets `newt size --why` report who
+		// references a symbol.
+		cmd = append(cmd, "-Wl,-Map="+dstFile+".map", "-Wl,--cref")
 	}
 
 	return cmd
 }
 
+// Returns the linker flags, linker scripts and linker search directories
+// that follow the object files in a link command.
+func (c *Compiler) linkerFlags() []string {
+	flags := c.lflagsStrings()
+	for _, ls := range c.LinkerScripts {
+		flags = append(flags, "-T", ls)
+	}
+	for _, dir := range c.linkerIncludeDirs() {
+		flags = append(flags, "-L"+dir)
+	}
+
+	return flags
+}
+
+// Returns the flags used to link an executable: those that precede the
+// object files, and those that follow them.
+func (c *Compiler) LinkFlags() ([]string, []string) {
+	c.ensureLclInfoAdded()
+	return c.cflagsStrings(), c.linkerFlags()
+}
+
+// Indicates whether the linker must resolve circular dependencies among the
+// object files (i.e., whether they are wrapped in --start-group and
+// --end-group).
+func (c *Compiler) LinkGroups() bool {
+	return c.ldResolveCircularDeps
+}
+
+// Returns the paths of the C compiler, C++ compiler and assembler.
+func (c *Compiler) ToolPaths() (string, string, string) {
+	return c.ccPath, c.cppPath, c.asPath
+}
+
+func (c *Compiler) linkerIncludeDirs() []string {
+	dirs := []string{}
+	for _, li := range c.LinkerIncludes {
+		dirs = append(dirs, filepath.ToSlash(filepath.Dir(li)))
+	}
+
+	return util.UniqueStrings(dirs)
+}
+
 // Links the specified elf file.
 //
 // @param dstFile               The filename of the destination elf file to
@@ -859,23 +1294,36 @@ func (c *Compiler) CompileBinary(dstFile string, options map[string]bool,
 
 	objList := c.getObjFiles(util.UniqueStrings(objFiles))
 
-	util.StatusMessage(util.VERBOSITY_DEFAULT, "Linking %s\n", dstFile)
-	util.StatusMessage(util.VERBOSITY_VERBOSE, "Linking %s with input files %s\n",
+	statusMessage(util.VERBOSITY_DEFAULT, "Linking %s\n", dstFile)
+	statusMessage(util.VERBOSITY_VERBOSE, "Linking %s with input files %s\n",
 		dstFile, objList)
 
 	if elfLib != "" {
-		util.StatusMessage(util.VERBOSITY_VERBOSE, "Linking %s with rom image %s\n",
+		statusMessage(util.VERBOSITY_VERBOSE, "Linking %s with rom image %s\n",
 			dstFile, elfLib)
 	}
 
 	cmd := c.CompileBinaryCmd(dstFile, options, objFiles, keepSymbols, elfLib)
-	_, err := util.ShellCommand(cmd, nil)
+
+	var err error
+	if c.ldIncremental {
+		// An incremental link updates the existing elf file in place.  Its
+		// command is only recorded once the link succeeds; an elf file
+		// without a recorded command may be the remains of an interrupted
+		// link, so it is relinked from scratch.
+		if util.NodeNotExist(dstFile + ".cmd") {
+			os.Remove(dstFile)
+		}
+		os.Remove(dstFile + ".cmd")
+		_, err = runToolCmd(cmd, c.env, -1)
+	} else {
+		_, err = runAtomicToolCmd(cmd, c.env, dstFile, -1)
+	}
 	if err != nil {
 		return err
 	}
 
-	err = writeCommandFile(dstFile, cmd)
-	if err != nil {
+	if err := writeCommandFile(dstFile, cmd, c.env); err != nil {
 		return err
 	}
 
@@ -909,7 +1357,7 @@ func (c *Compiler) generateExtras(elfFilename string,
 			elfFilename,
 			binFile,
 		}
-		_, err := util.ShellCommand(cmd, nil)
+		_, err := runAtomicToolCmd(cmd, c.env, binFile, -1)
 		if err != nil {
 			return err
 		}
@@ -929,7 +1377,7 @@ func (c *Compiler) generateExtras(elfFilename string,
 			"-wxdS",
 			elfFilename,
 		}
-		o, err := util.ShellCommandLimitDbgOutput(cmd, nil, 0)
+		o, err := runToolCmd(cmd, c.env, 0)
 		if err != nil {
 			// XXX: gobjdump appears to always crash.  Until we get that sorted
 			// out, don't fail the link process if lst generation fails.
@@ -949,7 +1397,7 @@ func (c *Compiler) generateExtras(elfFilename string,
 				sect,
 				elfFilename,
 			}
-			o, err := util.ShellCommandLimitDbgOutput(cmd, nil, 0)
+			o, err := runToolCmd(cmd, c.env, 0)
 			if err != nil {
 				if _, err := f.Write(o); err != nil {
 					return util.NewNewtError(err.Error())
@@ -961,7 +1409,7 @@ func (c *Compiler) generateExtras(elfFilename string,
 			c.osPath,
 			elfFilename,
 		}
-		o, err = util.ShellCommandLimitDbgOutput(cmd, nil, 0)
+		o, err = runToolCmd(cmd, c.env, 0)
 		if err != nil {
 			return err
 		}
@@ -978,13 +1426,41 @@ func (c *Compiler) PrintSize(elfFilename string) (string, error) {
 		c.osPath,
 		elfFilename,
 	}
-	o, err := util.ShellCommand(cmd, nil)
+	o, err := runToolCmd(cmd, c.env, -1)
 	if err != nil {
 		return "", err
 	}
 	return string(o), nil
 }
 
+// Returns the output of the size utility in sysv format, listing the size of
+// each section of the specified elf file.
+func (c *Compiler) PrintSectionSizes(elfFilename string) (string, error) {
+	o, err := runToolCmd([]string{c.osPath, "-A", elfFilename}, c.env,
+		-1)
+	if err != nil {
+		return "", err
+	}
+	return string(o), nil
+}
+
+func (c *Compiler) elfOptions() map[string]bool {
+	return map[string]bool{"mapFile": c.ldMapFile,
+		"listFile": true, "binFile": c.ldBinFile}
+}
+
+// Indicates whether CompileElf() would link the specified elf file, or
+// whether it is up to date.
+func (c *Compiler) ElfLinkRequired(binFile string, objFiles []string,
+	keepSymbols []string, elfLib string) (bool, error) {
+
+	// Make sure the compiler package info is added to the global set.
+	c.ensureLclInfoAdded()
+
+	return c.depTracker.LinkRequired(binFile, c.elfOptions(), objFiles,
+		keepSymbols, elfLib)
+}
+
 // Links the specified elf file and generates some associated artifacts (lst,
 // bin, and map files).
 //
@@ -995,20 +1471,16 @@ func (c *Compiler) PrintSize(elfFilename string) (string, error) {
 // @param objFiles              An array of the source .o and .a filenames.
 func (c *Compiler) CompileElf(binFile string, objFiles []string,
 	keepSymbols []string, elfLib string) error {
-	options := map[string]bool{"mapFile": c.ldMapFile,
-		"listFile": true, "binFile": c.ldBinFile}
-
-	// Make sure the compiler package info is added to the global set.
-	c.ensureLclInfoAdded()
+	options := c.elfOptions()
 
-	linkRequired, err := c.depTracker.LinkRequired(binFile, options,
-		objFiles, keepSymbols, elfLib)
+	linkRequired, err := c.ElfLinkRequired(binFile, objFiles, keepSymbols,
+		elfLib)
 	if err != nil {
 		return err
 	}
 	if linkRequired {
-		if err := os.MkdirAll(filepath.Dir(binFile), 0755); err != nil {
-			return util.NewNewtError(err.Error())
+		if err := util.MkdirAll(filepath.Dir(binFile), 0755); err != nil {
+			return err
 		}
 		err := c.CompileBinary(binFile, options, objFiles, keepSymbols, elfLib)
 		if err != nil {
@@ -1045,6 +1517,53 @@ func (c *Compiler) ParseLibraryCmd(libraryFile string) []string {
 	}
 }
 
+// Returns the command that disassembles the executable sections of the
+// specified file.
+func (c *Compiler) DisassembleCmd(file string) []string {
+	return []string{
+		c.odPath,
+		"-d",
+		file,
+	}
+}
+
+// Returns the path of a binutils tool matching this toolchain, derived from
+// the objdump path (e.g., arm-none-eabi-objdump -> arm-none-eabi-gdb).
+func (c *Compiler) siblingToolPath(tool string) string {
+	if strings.HasSuffix(c.odPath, "objdump") {
+		return strings.TrimSuffix(c.odPath, "objdump") + tool
+	}
+	return tool
+}
+
+// Returns the debugger matching this toolchain: compiler.path.gdb, or a gdb
+// next to objdump.
+func (c *Compiler) GdbPath() string {
+	if c.gdbPath != "" {
+		return c.gdbPath
+	}
+	return c.siblingToolPath("gdb")
+}
+
+// Returns the command that maps addresses in an executable to function names
+// and source lines, including inlined frames.  Each address is echoed before
+// its frames.
+func (c *Compiler) Addr2LineCmd(file string, addrs []string) []string {
+	a2l := c.a2lPath
+	if a2l == "" {
+		a2l = c.siblingToolPath("addr2line")
+	}
+
+	cmd := []string{a2l, "-e", file, "-a", "-f", "-i", "-C"}
+	return append(cmd, addrs...)
+}
+
+// Returns the command that writes the debug information of the specified
+// executable to a separate file, for use alongside a stripped image.
+func (c *Compiler) KeepDebugCmd(infile string, outfile string) []string {
+	return []string{c.ocPath, "--only-keep-debug", infile, outfile}
+}
+
 func (c *Compiler) CopySymbolsCmd(infile string, outfile string, sm *symbol.SymbolMap) []string {
 
 	cmd := []string{c.ocPath, "-S"}
@@ -1069,9 +1588,14 @@ func (c *Compiler) CopySymbolsCmd(infile string, outfile string, sm *symbol.Symb
 func (c *Compiler) CompileArchiveCmd(archiveFile string,
 	objFiles []string) []string {
 
+	flags := "rcs"
+	if c.thinArchives {
+		flags += "T"
+	}
+
 	cmd := []string{
 		c.arPath,
-		"rcs",
+		flags,
 		archiveFile,
 	}
 	cmd = append(cmd, c.getObjFiles(objFiles)...)
@@ -1145,8 +1669,8 @@ func (c *Compiler) CompileArchive(archiveFile string) error {
 		return nil
 	}
 
-	if err := os.MkdirAll(filepath.Dir(archiveFile), 0755); err != nil {
-		return util.NewNewtError(err.Error())
+	if err := util.MkdirAll(filepath.Dir(archiveFile), 0755); err != nil {
+		return err
 	}
 
 	// Delete the old archive, if it exists.
@@ -1158,28 +1682,28 @@ func (c *Compiler) CompileArchive(archiveFile string) error {
 	}
 
 	if len(objList) == 0 {
-		util.StatusMessage(util.VERBOSITY_VERBOSE,
+		statusMessage(util.VERBOSITY_VERBOSE,
 			"Not archiving %s; no object files\n", archiveFile)
 		return nil
 	}
 
-	util.StatusMessage(util.VERBOSITY_DEFAULT, "Archiving %s",
-		path.Base(archiveFile))
-	util.StatusMessage(util.VERBOSITY_VERBOSE, " with object files %s",
+	statusMessage(util.VERBOSITY_DEFAULT, "Archiving %s",
+		filepath.Base(archiveFile))
+	statusMessage(util.VERBOSITY_VERBOSE, " with object files %s",
 		strings.Join(objList, " "))
-	util.StatusMessage(util.VERBOSITY_DEFAULT, "\n")
+	statusMessage(util.VERBOSITY_DEFAULT, "\n")
 
 	if err != nil && !os.IsNotExist(err) {
 		return util.NewNewtError(err.Error())
 	}
 
 	cmd := c.CompileArchiveCmd(archiveFile, objFiles)
-	_, err = util.ShellCommand(cmd, nil)
+	_, err = runAtomicToolCmd(cmd, c.env, archiveFile, -1)
 	if err != nil {
 		return err
 	}
 
-	err = writeCommandFile(archiveFile, cmd)
+	err = writeCommandFile(archiveFile, cmd, c.env)
 	if err != nil {
 		return err
 	}
@@ -1223,7 +1747,7 @@ func ParseObjectLine(line string, r *regexp.Regexp) (error, *symbol.SymbolInfo)
 	data := answer[0]
 
 	if len(data) != 6 {
-		util.StatusMessage(util.VERBOSITY_DEFAULT,
+		statusMessage(util.VERBOSITY_DEFAULT,
 			"Not enough content in object file line --- %s", line)
 		return nil, nil
 	}
@@ -1235,7 +1759,7 @@ func ParseObjectLine(line string, r *regexp.Regexp) (error, *symbol.SymbolInfo)
 	v, err := strconv.ParseUint(data[1], 16, 32)
 
 	if err != nil {
-		util.StatusMessage(util.VERBOSITY_DEFAULT,
+		statusMessage(util.VERBOSITY_DEFAULT,
 			"Could not convert location from object file line --- %s", line)
 		return nil, nil
 	}
@@ -1245,7 +1769,7 @@ func ParseObjectLine(line string, r *regexp.Regexp) (error, *symbol.SymbolInfo)
 	v, err = strconv.ParseUint(data[4], 16, 32)
 
 	if err != nil {
-		util.StatusMessage(util.VERBOSITY_DEFAULT,
+		statusMessage(util.VERBOSITY_DEFAULT,
 			"Could not convert size form object file line --- %s", line)
 		return nil, nil
 	}
@@ -1261,7 +1785,7 @@ func (c *Compiler) RenameSymbols(sm *symbol.SymbolMap, libraryFile string, ext s
 
 	cmd := c.RenameSymbolsCmd(sm, libraryFile, ext)
 
-	_, err := util.ShellCommand(cmd, nil)
+	_, err := runToolCmd(cmd, c.env, -1)
 
 	return err
 }
@@ -1269,7 +1793,7 @@ func (c *Compiler) RenameSymbols(sm *symbol.SymbolMap, libraryFile string, ext s
 func (c *Compiler) ParseLibrary(libraryFile string) (error, []byte) {
 	cmd := c.ParseLibraryCmd(libraryFile)
 
-	out, err := util.ShellCommand(cmd, nil)
+	out, err := runToolCmd(cmd, c.env, -1)
 	if err != nil {
 		return err, nil
 	}
@@ -1279,7 +1803,7 @@ func (c *Compiler) ParseLibrary(libraryFile string) (error, []byte) {
 func (c *Compiler) CopySymbols(infile string, outfile string, sm *symbol.SymbolMap) error {
 	cmd := c.CopySymbolsCmd(infile, outfile, sm)
 
-	_, err := util.ShellCommand(cmd, nil)
+	_, err := runAtomicToolCmd(cmd, c.env, outfile, -1)
 	if err != nil {
 		return err
 	}
@@ -1298,9 +1822,14 @@ func (c *Compiler) ConvertBinToHex(inFile string, outFile string, baseAddr int)
 		inFile,
 		outFile,
 	}
-	_, err := util.ShellCommand(cmd, nil)
+	_, err := runToolCmd(cmd, c.env, -1)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// Prints a status message according to the toolchain's verbosity.
+func statusMessage(level int, message string, args ...interface{}) {
+	util.SubsysStatusMessage(util.SUBSYS_TOOLCHAIN, level, message, args...)
+}
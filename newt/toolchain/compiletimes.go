@@ -0,0 +1,58 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package toolchain
+
+import (
+	"sync"
+	"time"
+)
+
+// How long each file compiled during a build took to compile, keyed by the
+// object file's path.  A single instance is shared by all of a target's
+// compilers.  Files taken from the object store are not compiled, so they are
+// not recorded; neither is anything compiled through a launcher, whose cache
+// hits would make the times meaningless.
+type CompileTimes struct {
+	mtx   sync.Mutex
+	Files map[string]time.Duration
+}
+
+func NewCompileTimes() *CompileTimes {
+	return &CompileTimes{
+		Files: map[string]time.Duration{},
+	}
+}
+
+// Records how long each file the compiler compiles takes in the specified
+// set.
+func (c *Compiler) SetCompileTimes(ct *CompileTimes) {
+	c.compileTimes = ct
+}
+
+func (ct *CompileTimes) record(objPath string, d time.Duration) {
+	if ct == nil {
+		return
+	}
+
+	ct.mtx.Lock()
+	defer ct.mtx.Unlock()
+
+	ct.Files[objPath] = d
+}
@@ -46,61 +46,159 @@ func NewDepTracker(c *Compiler) DepTracker {
 	return tracker
 }
 
-// @return string               The name of the dependent file (i.e., the first
-//                                  .o file encountered).
-// @return []string             Populated with the dependencies' filenames.
-func parseDepsLine(line string) (string, []string, error) {
-	tokens := strings.Fields(line)
-	if len(tokens) == 0 {
-		return "", nil, nil
+// Splits a logical line of a Makefile dependency file into its rule's
+// targets and prerequisites, undoing the escapes that gcc and clang apply to
+// file names: "\ " (space), "\#", "$$" and doubled backslashes preceding an
+// escaped space.  Other backslashes (e.g., in Windows paths) are literal, as
+// are colons not followed by whitespace (e.g., in drive letters).  An
+// unescaped '#' starts a comment.
+//
+// @return []string             The rule's targets; empty for a blank line.
+// @return []string             The rule's prerequisites.
+func parseDepsLine(line string) ([]string, []string, error) {
+	var targets []string
+	var deps []string
+	var word []byte
+	inDeps := false
+
+	endWord := func() {
+		if word == nil {
+			return
+		}
+		if inDeps {
+			deps = append(deps, string(word))
+		} else {
+			targets = append(targets, string(word))
+		}
+		word = nil
+	}
+
+	isSpace := func(i int) bool {
+		return i >= len(line) || line[i] == ' ' || line[i] == '\t'
+	}
+
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case ch == '\\':
+			// Count the run of backslashes.
+			n := 1
+			for i+n < len(line) && line[i+n] == '\\' {
+				n++
+			}
+			next := byte(0)
+			if i+n < len(line) {
+				next = line[i+n]
+			}
+
+			switch next {
+			case ' ', '\t':
+				// 2k backslashes stand for k; an odd one escapes the
+				// whitespace.
+				word = append(word, []byte(strings.Repeat("\\", n/2))...)
+				if n%2 == 1 {
+					word = append(word, next)
+				} else {
+					endWord()
+				}
+				i += n
+			case '#':
+				word = append(word, []byte(strings.Repeat("\\", n-1))...)
+				word = append(word, '#')
+				i += n
+			default:
+				word = append(word, []byte(strings.Repeat("\\", n))...)
+				i += n - 1
+			}
+
+		case ch == '$' && i+1 < len(line) && line[i+1] == '$':
+			word = append(word, '$')
+			i++
+
+		case ch == '#':
+			endWord()
+			i = len(line)
+
+		case ch == ' ' || ch == '\t':
+			endWord()
+
+		case ch == ':' && !inDeps && isSpace(i+1):
+			endWord()
+			inDeps = true
+
+		default:
+			word = append(word, ch)
+		}
+	}
+	endWord()
+
+	if len(targets) == 0 && len(deps) == 0 {
+		return nil, nil, nil
+	}
+	if !inDeps || len(targets) == 0 {
+		return nil, nil, util.NewNewtError("rule missing target")
 	}
 
-	dFileTok := tokens[0]
-	if dFileTok[len(dFileTok)-1:] != ":" {
-		return "", nil, util.NewNewtError("line missing ':'")
+	return targets, deps, nil
+}
+
+// Reads a Makefile dependency file and returns its logical lines, with
+// continuations joined.  Both Unix and Windows line endings are accepted, as
+// is a leading byte order mark.  Other bytes are left alone; they are part of
+// filenames, which must match the filesystem's.
+func readDepsLines(filename string) ([]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, util.NewNewtError(err.Error())
 	}
+	data = bytes.TrimPrefix(data, []byte(util.UTF8_BOM))
 
-	dFileName := dFileTok[:len(dFileTok)-1]
-	return dFileName, tokens[1:], nil
+	text := strings.Replace(string(data), "\r\n", "\n", -1)
+	text = strings.Replace(text, "\\\n", " ", -1)
 
+	return strings.Split(text, "\n"), nil
 }
 
-// Parses a dependency (.d) file generated by gcc.  On success, the returned
-// string array is populated with the dependency filenames.  This function
-// expects each line of a dependency file to have the following format:
+// Parses a dependency (.d) file generated by gcc or clang.  On success, the
+// returned string array is populated with the dependency filenames.  This
+// function expects the file to contain Makefile rules of the following
+// format:
 //
-// <file>.o: <file>.c a.h b.h c.h \
+// <file>.o [<file>.d]: <file>.c a.h b.h c.h \
 //  d.h e.h f.h
 //
-// Only the first dependent object(<file>.o) is considered.
+// Only the rules of the first target (<file>.o) are considered; others, such
+// as the phony header rules that -MP adds, are ignored.
 //
 // @return []string             Populated with the dependencies' filenames.
 func ParseDepsFile(filename string) ([]string, error) {
-	lines, err := util.ReadLines(filename)
+	lines, err := readDepsLines(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(lines) == 0 {
-		return []string{}, nil
-	}
-
 	var dFile string
 	allDeps := []string{}
 	for _, line := range lines {
-		src, deps, err := parseDepsLine(line)
+		targets, deps, err := parseDepsLine(line)
 		if err != nil {
 			return nil, util.FmtNewtError(
 				"Invalid Makefile dependency file \"%s\"; %s",
 				filename, err.Error())
 		}
+		if len(targets) == 0 {
+			continue
+		}
 
 		if dFile == "" {
-			dFile = src
+			dFile = targets[0]
 		}
 
-		if src == dFile {
-			allDeps = append(allDeps, deps...)
+		for _, t := range targets {
+			if t == dFile {
+				allDeps = append(allDeps, deps...)
+				break
+			}
 		}
 	}
 
@@ -134,14 +232,14 @@ func (tracker *DepTracker) ProcessFileTime(file string) error {
 // @return                      true if the command has changed or if the
 //                                  destination file was never built;
 //                              false otherwise.
-func commandHasChanged(dstFile string, cmd []string) bool {
+func commandHasChanged(dstFile string, cmd []string, env []string) bool {
 	cmdFile := dstFile + ".cmd"
 	prevCmd, err := ioutil.ReadFile(cmdFile)
 	if err != nil {
 		return true
 	}
 
-	curCmd := serializeCommand(cmd)
+	curCmd := serializeCommand(cmd, env)
 
 	changed := bytes.Compare(prevCmd, curCmd) != 0
 	return changed
@@ -152,9 +250,14 @@ func commandHasChanged(dstFile string, cmd []string) bool {
 //     * The destination object file does not exist.
 //     * The existing object file was built with a different compiler
 //       invocation.
+//     * The compiler writes dependency files, and the object file's is
+//       missing or older than the source file.
 //     * The source file has a newer modification time than the object file.
 //     * One or more included header files has a newer modification time than
 //       the object file.
+// If the filesystem's timestamps are too coarse to be compared, the last
+// three checks are replaced by a comparison of the source and header files'
+// contents with those the object was built from.
 func (tracker *DepTracker) CompileRequired(srcFile string,
 	compilerType int) (bool, error) {
 
@@ -168,23 +271,40 @@ func (tracker *DepTracker) CompileRequired(srcFile string,
 		return false, err
 	}
 
-	if commandHasChanged(objPath, cmd) {
-		util.StatusMessage(util.VERBOSITY_VERBOSE, "%s - rebuild required; "+
+	// If the compiler writes the dependency file, it is exactly as fresh as
+	// the object file, and a missing or stale one means the object must be
+	// rebuilt.  Otherwise, the file is generated by a separate pass.
+	depsOnCompile := tracker.compiler.depsOnCompile(compilerType)
+
+	if commandHasChanged(objPath, cmd, tracker.compiler.env) {
+		statusMessage(util.VERBOSITY_VERBOSE, "%s - rebuild required; "+
 			"different command\n", srcFile)
-		err := tracker.compiler.GenDepsForFile(srcFile)
-		if err != nil {
-			return false, err
+		if !depsOnCompile {
+			err := tracker.compiler.GenDepsForFile(srcFile)
+			if err != nil {
+				return false, err
+			}
 		}
 		return true, nil
 	}
 
 	if util.NodeNotExist(depPath) {
+		if depsOnCompile {
+			statusMessage(util.VERBOSITY_VERBOSE, "%s - rebuild required; "+
+				"no dependency file\n", srcFile)
+			return true, nil
+		}
+
 		err := tracker.compiler.GenDepsForFile(srcFile)
 		if err != nil {
 			return false, err
 		}
 	}
 
+	if tracker.compiler.coarseMtimes() {
+		return tracker.contentsChanged(srcFile, objPath, depsOnCompile)
+	}
+
 	srcModTime, err := util.FileModificationTime(srcFile)
 	if err != nil {
 		return false, err
@@ -198,7 +318,7 @@ func (tracker *DepTracker) CompileRequired(srcFile string,
 	// If the object doesn't exist or is older than the source file, a build is
 	// required; no need to check dependencies.
 	if srcModTime.After(objModTime) {
-		util.StatusMessage(util.VERBOSITY_VERBOSE, "%s - rebuild required; "+
+		statusMessage(util.VERBOSITY_VERBOSE, "%s - rebuild required; "+
 			"source newer than obj\n", srcFile)
 		return true, nil
 	}
@@ -212,6 +332,12 @@ func (tracker *DepTracker) CompileRequired(srcFile string,
 	}
 
 	if srcModTime.After(depModTime) {
+		if depsOnCompile {
+			statusMessage(util.VERBOSITY_VERBOSE, "%s - rebuild required; "+
+				"source newer than dependency file\n", srcFile)
+			return true, nil
+		}
+
 		err := tracker.compiler.GenDepsForFile(srcFile)
 		if err != nil {
 			return false, err
@@ -231,7 +357,7 @@ func (tracker *DepTracker) CompileRequired(srcFile string,
 			// the dependency file is out of date, so it needs to be deleted.
 			// We cannot regenerate it now because the source file might be
 			// including a nonexistent header.
-			util.StatusMessage(util.VERBOSITY_VERBOSE,
+			statusMessage(util.VERBOSITY_VERBOSE,
 				"%s - rebuild required; dependency \"%s\" has been deleted\n",
 				srcFile, dep)
 			os.Remove(depPath)
@@ -244,7 +370,7 @@ func (tracker *DepTracker) CompileRequired(srcFile string,
 		}
 
 		if depModTime.After(objModTime) {
-			util.StatusMessage(util.VERBOSITY_VERBOSE, "%s - rebuild required; obj older than dependency (%s)\n", srcFile, dep)
+			statusMessage(util.VERBOSITY_VERBOSE, "%s - rebuild required; obj older than dependency (%s)\n", srcFile, dep)
 			return true, nil
 		}
 	}
@@ -252,9 +378,33 @@ func (tracker *DepTracker) CompileRequired(srcFile string,
 	return false, nil
 }
 
+// Determines if the source or header files of an object have changed since it
+// was built, according to the checksums recorded with the object.
+func (tracker *DepTracker) contentsChanged(srcFile string, objPath string,
+	depsOnCompile bool) (bool, error) {
+
+	if depsChecksumsMatch(depsChecksumsPath(objPath), samePath) {
+		return false, nil
+	}
+
+	statusMessage(util.VERBOSITY_VERBOSE, "%s - rebuild required; "+
+		"source or dependency contents changed\n", srcFile)
+
+	// The source may include different headers now.
+	if !depsOnCompile {
+		err := tracker.compiler.GenDepsForFile(srcFile)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
 // Determines if the specified static library needs to be rearchived.  The
 // library needs to be archived if any of the following is true:
-//     * The destination library file does not exist.
+//     * The destination library file does not exist or is not a valid
+//       archive.
 //     * The existing library file was built with a different compiler
 //       invocation.
 //     * One or more source object files has a newer modification time than the
@@ -265,7 +415,15 @@ func (tracker *DepTracker) ArchiveRequired(archiveFile string,
 	// If the archive was previously built with a different set of options, a
 	// rebuild is required.
 	cmd := tracker.compiler.CompileArchiveCmd(archiveFile, objFiles)
-	if commandHasChanged(archiveFile, cmd) {
+	if commandHasChanged(archiveFile, cmd, tracker.compiler.env) {
+		return true, nil
+	}
+
+	// An archive left behind by an interrupted or failed build can't be
+	// trusted, however recent it is.
+	if util.NodeExist(archiveFile) && !archiveValid(archiveFile) {
+		statusMessage(util.VERBOSITY_VERBOSE, "%s - rearchive required; "+
+			"invalid archive\n", archiveFile)
 		return true, nil
 	}
 
@@ -333,8 +491,8 @@ func (tracker *DepTracker) LinkRequired(dstFile string,
 	// If the elf file was previously built with a different set of options, a
 	// rebuild is required.
 	cmd := tracker.compiler.CompileBinaryCmd(dstFile, options, objFiles, keepSymbols, elfLib)
-	if commandHasChanged(dstFile, cmd) {
-		util.StatusMessage(util.VERBOSITY_VERBOSE, "%s - link required; "+
+	if commandHasChanged(dstFile, cmd, tracker.compiler.env) {
+		statusMessage(util.VERBOSITY_VERBOSE, "%s - link required; "+
 			"different command\n", dstFile)
 		return true, nil
 	}
@@ -354,7 +512,7 @@ func (tracker *DepTracker) LinkRequired(dstFile string,
 			return false, err
 		}
 		if elfDstModTime.After(dstModTime) {
-			util.StatusMessage(util.VERBOSITY_VERBOSE, "%s - link required; "+
+			statusMessage(util.VERBOSITY_VERBOSE, "%s - link required; "+
 				"old elf file\n", elfLib)
 			return true, nil
 		}
@@ -362,15 +520,19 @@ func (tracker *DepTracker) LinkRequired(dstFile string,
 
 	// Check timestamp of each .o file in the project.
 	if tracker.MostRecent.After(dstModTime) {
-		util.StatusMessage(util.VERBOSITY_VERBOSE, "%s - link required; "+
+		statusMessage(util.VERBOSITY_VERBOSE, "%s - link required; "+
 			"source newer than elf\n", dstFile)
 		return true, nil
 	}
 
-	// Check timestamp of the linker script and all input libraries.
+	// Check timestamp of the linker scripts, any generated scripts they
+	// include, and all input libraries.
 	for _, ls := range tracker.compiler.LinkerScripts {
 		objFiles = append(objFiles, ls)
 	}
+	for _, li := range tracker.compiler.LinkerIncludes {
+		objFiles = append(objFiles, li)
+	}
 	for _, obj := range objFiles {
 		objModTime, err := util.FileModificationTime(obj)
 		if err != nil {
@@ -378,7 +540,7 @@ func (tracker *DepTracker) LinkRequired(dstFile string,
 		}
 
 		if objModTime.After(dstModTime) {
-			util.StatusMessage(util.VERBOSITY_VERBOSE, "%s - rebuild "+
+			statusMessage(util.VERBOSITY_VERBOSE, "%s - rebuild "+
 				"required; obj older than dependency (%s)\n", dstFile, obj)
 			return true, nil
 		}
@@ -431,7 +593,7 @@ func (tracker *DepTracker) RomElfBuildRequired(dstFile string, elfFile string,
 //       target file.
 func (tracker *DepTracker) CopyRequired(srcFile string) (bool, error) {
 
-	tgtFile := tracker.compiler.DstDir() + "/" + filepath.Base(srcFile)
+	tgtFile := filepath.Join(tracker.compiler.DstDir(), filepath.Base(srcFile))
 
 	// If the target doesn't exist or is older than source file, a copy
 	// is required.
@@ -450,3 +612,41 @@ func (tracker *DepTracker) CopyRequired(srcFile string) (bool, error) {
 	// The target is up to date.
 	return false, nil
 }
+
+// Determines if files generated by newt (e.g., GATT service tables) need to
+// be regenerated.  Regeneration is required if any of the following is true:
+//     * One or more destination files do not exist.
+//     * One or more source files have a newer modification time than the
+//       oldest destination file.
+func (tracker *DepTracker) GenRequired(dstFiles []string,
+	srcFiles []string) (bool, error) {
+
+	oldest := time.Time{}
+	for i, dst := range dstFiles {
+		if util.NodeNotExist(dst) {
+			return true, nil
+		}
+
+		modTime, err := util.FileModificationTime(dst)
+		if err != nil {
+			return false, err
+		}
+		if i == 0 || modTime.Before(oldest) {
+			oldest = modTime
+		}
+	}
+
+	for _, src := range srcFiles {
+		modTime, err := util.FileModificationTime(src)
+		if err != nil {
+			return false, err
+		}
+		if modTime.After(oldest) {
+			statusMessage(util.VERBOSITY_VERBOSE, "%s - regeneration "+
+				"required; source newer than generated files\n", src)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
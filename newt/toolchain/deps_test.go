@@ -0,0 +1,276 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package toolchain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Dependency files as written by gcc and clang, and the dependencies
+// ParseDepsFile() should find in them.
+var depsFileTests = []struct {
+	name string
+	text string
+	deps []string
+}{
+	{
+		// gcc -MMD -MP -MF x.d -MT 'bin/t/x y.o' 'x y.c'; gcc does not escape
+		// the -MT target.
+		name: "gcc escapes",
+		text: "bin/t/x y.o: x\\ y.c my\\ dir/a\\ b.h inc/cost$$.h " +
+			"inc/hash\\#.h \\\n" +
+			" inc/back\\\\\\ .h\n" +
+			"my\\ dir/a\\ b.h:\n" +
+			"inc/cost$$.h:\n" +
+			"inc/hash\\#.h:\n" +
+			"inc/back\\\\\\ .h:\n",
+		deps: []string{
+			"x y.c",
+			"my dir/a b.h",
+			"inc/cost$.h",
+			"inc/hash#.h",
+			"inc/back\\ .h",
+		},
+	},
+	{
+		// gcc -MMD -MP -MT bin/t/a.o -MT bin/t/a.d
+		name: "gcc several targets",
+		text: "bin/t/a.o bin/t/a.d: a.c inc/cost$$.h\n" +
+			"inc/cost$$.h:\n",
+		deps: []string{"a.c", "inc/cost$.h"},
+	},
+	{
+		// gcc -MD; the phony rules of -MP must not be mistaken for
+		// dependencies of the object file.
+		name: "gcc system headers",
+		text: "bin/t/a.o: a.c /usr/include/stdc-predef.h inc/a.h\n" +
+			"/usr/include/stdc-predef.h:\n" +
+			"inc/a.h:\n",
+		deps: []string{"a.c", "/usr/include/stdc-predef.h", "inc/a.h"},
+	},
+	{
+		// clang -MMD -MP indents continuation lines by two spaces.
+		name: "clang escapes",
+		text: "bin/t/x.o: x.c my\\ dir/a\\ b.h inc/cost$$.h \\\n" +
+			"  inc/hash\\#.h inc/back\\\\\\ .h\n" +
+			"\n" +
+			"my\\ dir/a\\ b.h:\n" +
+			"\n" +
+			"inc/cost$$.h:\n" +
+			"\n" +
+			"inc/hash\\#.h:\n" +
+			"\n" +
+			"inc/back\\\\\\ .h:\n",
+		deps: []string{
+			"x.c",
+			"my dir/a b.h",
+			"inc/cost$.h",
+			"inc/hash#.h",
+			"inc/back\\ .h",
+		},
+	},
+	{
+		// A native Windows compiler; backslashes not preceding a space are
+		// literal and a drive letter's colon doesn't end the target list.
+		name: "windows paths",
+		text: "C:/proj/bin/t/a.o: C:\\proj\\a.c \\\n" +
+			"  C:\\proj\\inc\\a\\ b.h c:/proj/inc/c.h\n",
+		deps: []string{
+			"C:\\proj\\a.c",
+			"C:\\proj\\inc\\a b.h",
+			"c:/proj/inc/c.h",
+		},
+	},
+	{
+		name: "crlf",
+		text: "bin/t/a.o: a.c \\\r\n inc/a.h\r\ninc/a.h:\r\n",
+		deps: []string{"a.c", "inc/a.h"},
+	},
+	{
+		name: "bom",
+		text: "\xef\xbb\xbfbin/t/a.o: a.c inc/a.h\n",
+		deps: []string{"a.c", "inc/a.h"},
+	},
+	{
+		// The .yml dependencies newt appends in a rule of their own.
+		name: "several rules",
+		text: "bin/t/a.o: a.c \\\n inc/a.h \\\n inc/b.h\n" +
+			"inc/a.h:\n" +
+			"bin/t/a.o: pkg/pkg.yml\n",
+		deps: []string{"a.c", "inc/a.h", "inc/b.h", "pkg/pkg.yml"},
+	},
+	{
+		name: "empty",
+		text: "",
+		deps: []string{},
+	},
+}
+
+func writeDepsFile(t *testing.T, text string) string {
+	f, err := ioutil.TempFile("", "newt-deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func TestParseDepsFile(t *testing.T) {
+	for _, test := range depsFileTests {
+		path := writeDepsFile(t, test.text)
+		deps, err := ParseDepsFile(path)
+		os.Remove(path)
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(deps, test.deps) {
+			t.Errorf("%s: wrong dependencies\ngot:  %q\nwant: %q",
+				test.name, deps, test.deps)
+		}
+	}
+}
+
+func TestParseDepsFileInvalid(t *testing.T) {
+	path := writeDepsFile(t, "bin/t/a.o a.c inc/a.h\n")
+	defer os.Remove(path)
+
+	if _, err := ParseDepsFile(path); err == nil {
+		t.Errorf("rule without a colon accepted")
+	}
+}
+
+// Checks that the .yml dependencies appended to a dependency file are part of
+// the object file's rule, as named by -MT, and so are returned by
+// ParseDepsFile().
+func TestExtraDepsLineMatchesTarget(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "newt-deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	c := &Compiler{
+		baseDir: baseDir,
+		dstDir:  filepath.Join(baseDir, "bin", "targets", "t", "app"),
+		extraDeps: []string{
+			filepath.Join(baseDir, "pkg", "pkg.yml"),
+			filepath.Join(baseDir, "targets", "t", "target.yml"),
+		},
+	}
+
+	src := filepath.Join(baseDir, "pkg", "src", "main.c")
+	objPath := c.dstFilePath(src) + ".o"
+	depPath := c.dstFilePath(src) + ".d"
+
+	// What the compiler writes when given -MT<obj>.
+	deps := c.relPath(objPath) + ": pkg/src/main.c \\\n" +
+		" pkg/include/pkg/pkg.h\n"
+	data := append([]byte(deps), c.extraDepsLine(src)...)
+
+	if err := os.MkdirAll(filepath.Dir(depPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(depPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseDepsFile(depPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+
+	want := []string{
+		filepath.Join(baseDir, "pkg", "pkg.yml"),
+		filepath.Join(baseDir, "targets", "t", "target.yml"),
+		"pkg/include/pkg/pkg.h",
+		"pkg/src/main.c",
+	}
+	sort.Strings(want)
+
+	if len(got) != len(want) {
+		t.Fatalf("wrong dependencies in %s:\n%s\ngot:  %q\nwant: %q",
+			depPath, data, got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("wrong dependencies in %s:\n%s\ngot:  %q\nwant: %q",
+				depPath, data, got, want)
+		}
+	}
+}
+
+// Checks that a change to the environment variables the toolchain runs with
+// requires a rebuild, and that a command recorded without any still matches.
+func TestCommandHasChangedEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "newt-cmd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, "a.o")
+	cmd := []string{"gcc", "-c", "-o", "a.o", "a.c"}
+
+	tests := []struct {
+		name    string
+		written []string
+		env     []string
+		changed bool
+	}{
+		{"no env", nil, nil, false},
+		{"same env", []string{"FOO=1"}, []string{"FOO=1"}, false},
+		{"changed value", []string{"FOO=1"}, []string{"FOO=2"}, true},
+		{"added", nil, []string{"FOO=1"}, true},
+		{"removed", []string{"FOO=1"}, nil, true},
+	}
+
+	for _, test := range tests {
+		if err := writeCommandFile(dst, cmd, test.written); err != nil {
+			t.Fatal(err)
+		}
+		if commandHasChanged(dst, cmd, test.env) != test.changed {
+			t.Errorf("%s: command changed: got %v, want %v", test.name,
+				!test.changed, test.changed)
+		}
+	}
+
+	// A command file from before environment variables were recorded.
+	if err := ioutil.WriteFile(dst+".cmd",
+		[]byte("gcc\n-c\n-o\na.o\na.c"), 0644); err != nil {
+
+		t.Fatal(err)
+	}
+	if commandHasChanged(dst, cmd, nil) {
+		t.Errorf("command file without environment variables rejected")
+	}
+}
@@ -0,0 +1,141 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package toolchain
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/util"
+)
+
+const (
+	DIAG_SEVERITY_ERROR   = "error"
+	DIAG_SEVERITY_WARNING = "warning"
+	DIAG_SEVERITY_NOTE    = "note"
+)
+
+// A single compiler diagnostic, parsed from gcc or clang output.
+type Diagnostic struct {
+	Package  string `json:"package"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+var diagRe = regexp.MustCompile(
+	`^(.+?):(\d+):(?:(\d+):)?\s*(fatal error|error|warning|note):\s*(.*)$`)
+
+// Diagnostics collected during this invocation of newt.  The same header is
+// often compiled by many packages and targets; each diagnostic is only
+// recorded once, attributed to the first package that reported it.
+var diagMtx sync.Mutex
+var diagSeen = map[Diagnostic]bool{}
+var diags []Diagnostic
+
+func ParseDiagnostics(output string) []Diagnostic {
+	result := []Diagnostic{}
+
+	for _, line := range strings.Split(output, "\n") {
+		m := diagRe.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil {
+			continue
+		}
+
+		d := Diagnostic{
+			File:     m[1],
+			Severity: m[4],
+			Message:  m[5],
+		}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		if d.Severity == "fatal error" {
+			d.Severity = DIAG_SEVERITY_ERROR
+		}
+
+		result = append(result, d)
+	}
+
+	return result
+}
+
+// Parses compiler output and records any diagnostics it contains.
+func RecordDiagnostics(pkgName string, output []byte) {
+	if len(output) == 0 {
+		return
+	}
+
+	// Compilers quote source lines in whatever encoding the source uses.
+	parsed := ParseDiagnostics(string(util.DecodeText(output)))
+	for i, _ := range parsed {
+		parsed[i].Package = pkgName
+	}
+
+	AddDiagnostics(parsed)
+}
+
+// Records diagnostics that newt itself detected (e.g., before linking).
+// Each one's Package must be set.
+func AddDiagnostics(ds []Diagnostic) {
+	diagMtx.Lock()
+	defer diagMtx.Unlock()
+
+	for _, d := range ds {
+		key := d
+		key.Package = ""
+		if diagSeen[key] {
+			continue
+		}
+		diagSeen[key] = true
+
+		diags = append(diags, d)
+
+		newtutil.EmitEvent(newtutil.EVENT_DIAGNOSTIC, map[string]interface{}{
+			"package":  d.Package,
+			"file":     d.File,
+			"line":     d.Line,
+			"column":   d.Column,
+			"severity": d.Severity,
+			"message":  d.Message,
+		})
+	}
+}
+
+// Returns all diagnostics recorded so far, in the order they were reported.
+func Diagnostics() []Diagnostic {
+	diagMtx.Lock()
+	defer diagMtx.Unlock()
+
+	return append([]Diagnostic{}, diags...)
+}
+
+// Discards all recorded diagnostics.  Used between builds of separate targets.
+func ResetDiagnostics() {
+	diagMtx.Lock()
+	defer diagMtx.Unlock()
+
+	diagSeen = map[Diagnostic]bool{}
+	diags = nil
+}
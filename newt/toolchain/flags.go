@@ -0,0 +1,158 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package toolchain
+
+import (
+	"sort"
+	"strings"
+)
+
+// Options that take their argument as a separate word.  An option and its
+// argument are treated as a single flag when flags are normalized.
+var flagArgOptions = map[string]bool{
+	"-D":             true,
+	"-I":             true,
+	"-L":             true,
+	"-MF":            true,
+	"-MQ":            true,
+	"-MT":            true,
+	"-T":             true,
+	"-U":             true,
+	"-Xassembler":    true,
+	"-Xlinker":       true,
+	"-Xpreprocessor": true,
+	"--param":        true,
+	"-e":             true,
+	"-idirafter":     true,
+	"-imacros":       true,
+	"-include":       true,
+	"-iquote":        true,
+	"-isystem":       true,
+	"-u":             true,
+	"-x":             true,
+	"-z":             true,
+}
+
+// Prefixes of flags whose relative order is significant.  A compiler
+// definition can add to these with "compiler.flags.ordered".
+var defaultOrderedFlags = []string{
+	"-O",
+	"-x",
+	"-l",
+	"-Wl,--start-group",
+	"-Wl,--end-group",
+	"-Wl,--whole-archive",
+	"-Wl,--no-whole-archive",
+	"-Wl,--as-needed",
+	"-Wl,--no-as-needed",
+}
+
+// Splits whitespace-separated flag strings into flags, joining options with
+// their separate arguments (e.g., "-include", "foo.h").
+func splitFlags(flagStrs []string) [][]string {
+	words := []string{}
+	for _, s := range flagStrs {
+		words = append(words, strings.Fields(s)...)
+	}
+
+	flags := [][]string{}
+	for i := 0; i < len(words); i++ {
+		if flagArgOptions[words[i]] && i+1 < len(words) {
+			flags = append(flags, []string{words[i], words[i+1]})
+			i++
+		} else {
+			flags = append(flags, []string{words[i]})
+		}
+	}
+
+	return flags
+}
+
+func (c *Compiler) flagIsOrdered(flag string) bool {
+	for _, prefixes := range [][]string{defaultOrderedFlags, c.orderedFlags} {
+		for _, p := range prefixes {
+			if strings.HasPrefix(flag, p) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type flagGroupSorter struct {
+	groups [][]string
+}
+
+func (s flagGroupSorter) Len() int {
+	return len(s.groups)
+}
+func (s flagGroupSorter) Swap(i, j int) {
+	s.groups[i], s.groups[j] = s.groups[j], s.groups[i]
+}
+func (s flagGroupSorter) Less(i, j int) bool {
+	return strings.Join(s.groups[i], " ") <
+		strings.Join(s.groups[j], " ")
+}
+
+// Puts a set of flags in a canonical form, so that flags that differ only in
+// order or duplication produce identical commands and don't trigger
+// rebuilds.  Duplicates are removed and flags are sorted, except for
+// order-sensitive flags (see flagIsOrdered()).  Those follow the sorted flags
+// in their original order, keeping only the last of any duplicates, since the
+// last one takes effect.
+func (c *Compiler) normalizeFlags(flagStrs ...string) []string {
+	flags := splitFlags(flagStrs)
+
+	keys := make([]string, len(flags))
+	for i, f := range flags {
+		keys[i] = strings.Join(f, " ")
+	}
+
+	lastOrdered := map[string]int{}
+	for i, f := range flags {
+		if c.flagIsOrdered(f[0]) {
+			lastOrdered[keys[i]] = i
+		}
+	}
+
+	unordered := [][]string{}
+	ordered := [][]string{}
+	seen := map[string]bool{}
+	for i, f := range flags {
+		if _, ok := lastOrdered[keys[i]]; ok {
+			if lastOrdered[keys[i]] == i {
+				ordered = append(ordered, f)
+			}
+		} else if !seen[keys[i]] {
+			seen[keys[i]] = true
+			unordered = append(unordered, f)
+		}
+	}
+
+	sort.Sort(flagGroupSorter{unordered})
+
+	words := []string{}
+	for _, f := range append(unordered, ordered...) {
+		words = append(words, f...)
+	}
+
+	return words
+}
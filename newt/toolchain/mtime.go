@@ -0,0 +1,151 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package toolchain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Timestamp granularities that are too coarse to order an edit after the
+// build that preceded it, coarsest first (e.g., 2s for FAT, 1s for ext3, HFS+
+// and some network mounts).
+var coarseMtimeGranularities = []time.Duration{
+	2 * time.Second,
+	time.Second,
+	100 * time.Millisecond,
+}
+
+// The timestamp written to the probe file.  Its odd second and sub-second
+// part show how the filesystem rounds timestamps.
+var mtimeProbeTime = time.Unix(1000000001, 555555555)
+
+// Granularity of each probed directory's filesystem; every directory is only
+// probed (and warned about) once.
+var mtimeGranularities = map[string]time.Duration{}
+var mtimeGranularitiesMutex sync.Mutex
+
+// Determines the timestamp granularity of the filesystem containing the
+// specified directory by setting a probe file's modification time and reading
+// it back.
+//
+// @return                      The granularity if it is coarse; 0 otherwise.
+func probeMtimeGranularity(dir string) (time.Duration, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	f, err := ioutil.TempFile(dir, ".mtime-probe")
+	if err != nil {
+		return 0, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	err = os.Chtimes(f.Name(), mtimeProbeTime, mtimeProbeTime)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(f.Name())
+	if err != nil {
+		return 0, err
+	}
+
+	stored := info.ModTime()
+	if stored.Equal(mtimeProbeTime) {
+		return 0, nil
+	}
+
+	for _, g := range coarseMtimeGranularities {
+		if stored.UnixNano()%int64(g) == 0 {
+			return g, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// Returns the timestamp granularity of the filesystem containing the
+// specified directory if it is coarse, or 0 otherwise.  A warning is printed
+// the first time a coarse filesystem is encountered.
+func mtimeGranularity(dir string) time.Duration {
+	mtimeGranularitiesMutex.Lock()
+	defer mtimeGranularitiesMutex.Unlock()
+
+	if g, ok := mtimeGranularities[dir]; ok {
+		return g
+	}
+
+	g, err := probeMtimeGranularity(dir)
+	if err != nil {
+		log.Debugf("Failed to probe the timestamp granularity of %s: %s",
+			dir, err.Error())
+	}
+	if g != 0 {
+		util.StatusMessage(util.VERBOSITY_QUIET,
+			"WARNING: The filesystem containing %s only stores timestamps "+
+				"to %s; detecting changes by file contents instead.\n",
+			dir, g.String())
+	}
+
+	mtimeGranularities[dir] = g
+	return g
+}
+
+// Indicates whether modification times are too coarse to tell if the
+// compiler's objects are up to date.  In that case, each object records the
+// checksums of its dependencies (see recordDepsChecksums()), and the
+// dependency tracker compares those instead.
+func (c *Compiler) coarseMtimes() bool {
+	return mtimeGranularity(filepath.Join(c.baseDir, "bin")) != 0
+}
+
+func depsChecksumsPath(objPath string) string {
+	return objPath + ".sum"
+}
+
+func samePath(path string) string {
+	return path
+}
+
+// Records the checksums of a freshly built object's dependencies if the
+// dependency tracker needs them; see coarseMtimes().
+func (c *Compiler) recordDepsChecksums(objPath string, depPath string) error {
+	sumPath := depsChecksumsPath(objPath)
+	if !c.coarseMtimes() || util.NodeNotExist(depPath) {
+		os.Remove(sumPath)
+		return nil
+	}
+
+	sums, err := depsChecksums(depPath, samePath)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(sumPath, sums)
+}
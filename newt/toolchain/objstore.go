@@ -0,0 +1,275 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package toolchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Stands for the target's bin directory in the commands, dependency files,
+// and compiler output recorded in the shared object store.
+const OBJ_STORE_TARGET_TOKEN = "@NEWT_TARGET_BIN@"
+
+// A project-wide store of object files, which lets targets that compile a
+// source file with identical flags share one compilation.  Entries are keyed
+// by the compile command, with the target's bin directory abstracted away
+// (generated include paths differ per target).  Since headers can still
+// differ (e.g., each target's syscfg.h), every entry records the contents of
+// the headers it was compiled against, and a key can hold one entry per
+// variant.
+//
+// Each entry consists of four files in the key's directory:
+//
+//	<entry>.o       The object file.
+//	<entry>.d       The compiler-generated dependency file.
+//	<entry>.out     The compiler's output (i.e., diagnostics).
+//	<entry>.sum     Checksums of the object's dependencies.
+type objStore struct {
+	dir       string
+	targetDir string
+}
+
+// Enables sharing of object files with other targets through the store in
+// the specified directory.  targetDir is the bin directory of the target
+// being built.  Sources in the target's bin directory (i.e., generated code)
+// are always compiled locally.
+func (c *Compiler) EnableObjStore(storeDir string, targetDir string) {
+	c.objStore = &objStore{
+		dir:       storeDir,
+		targetDir: c.relPath(targetDir),
+	}
+}
+
+// Indicates whether the specified file can be shared through the object
+// store.  The store depends on the compiler's dependency files to verify
+// entries.
+func (c *Compiler) objStoreUsable(file string, compilerType int) bool {
+	return c.objStore != nil && c.depsOnCompile(compilerType) &&
+		!strings.HasPrefix(c.relPath(file), c.objStore.targetDir+"/")
+}
+
+func (s *objStore) normalize(text string) string {
+	return strings.Replace(text, s.targetDir+"/",
+		OBJ_STORE_TARGET_TOKEN+"/", -1)
+}
+
+func (s *objStore) denormalize(text string) string {
+	return strings.Replace(text, OBJ_STORE_TARGET_TOKEN+"/",
+		s.targetDir+"/", -1)
+}
+
+func (s *objStore) keyDir(cmd []string, env []string) string {
+	norm := make([]string, len(cmd))
+	for i, arg := range cmd {
+		norm[i] = s.normalize(arg)
+	}
+
+	sum := sha256.Sum256(serializeCommand(norm, env))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Builds a checksum list from the dependency file of a fresh compile.  Each
+// line holds a checksum and a filename, as transformed by pathFn.
+func depsChecksums(depPath string,
+	pathFn func(path string) string) ([]byte, error) {
+
+	deps, err := ParseDepsFile(depPath)
+	if err != nil {
+		return nil, err
+	}
+	deps = util.SortFields(deps...)
+
+	lines := make([]string, 0, len(deps))
+	for _, dep := range deps {
+		sum, err := fileChecksum(dep)
+		if err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+		lines = append(lines, sum+" "+pathFn(dep))
+	}
+	sort.Strings(lines)
+
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// Indicates whether the files in a checksum list still have the recorded
+// contents.  pathFn maps each listed filename to the file to check.
+func depsChecksumsMatch(sumPath string,
+	pathFn func(path string) string) bool {
+
+	lines, err := util.ReadLines(sumPath)
+	if err != nil || len(lines) == 0 {
+		return false
+	}
+
+	for _, line := range lines {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return false
+		}
+
+		sum, err := fileChecksum(pathFn(fields[1]))
+		if err != nil || sum != fields[0] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Searches the store for an object built with the specified command against
+// the current contents of its dependencies.  Returns the base path of the
+// matching entry, or "" if there is none.
+func (s *objStore) lookup(cmd []string, env []string) string {
+	sums, _ := filepath.Glob(filepath.Join(s.keyDir(cmd, env), "*.sum"))
+	for _, sumPath := range sums {
+		if depsChecksumsMatch(sumPath, s.denormalize) {
+			return strings.TrimSuffix(sumPath, ".sum")
+		}
+	}
+
+	return ""
+}
+
+// Writes a file of a store entry.  Other newt processes may be filling the
+// same entry concurrently, so each writer uses its own temporary file.
+func writeObjStoreFile(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path),
+		filepath.Base(path)+ARTIFACT_TMP_SUFFIX)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	f.Close()
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// Copies an entry into the target's bin directory.
+//
+// @return                      The compiler output recorded with the entry.
+func (s *objStore) fetch(entry string, objPath string, depPath string,
+	extraDeps []byte) ([]byte, error) {
+
+	obj, err := ioutil.ReadFile(entry + ".o")
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	deps, err := ioutil.ReadFile(entry + ".d")
+	if err != nil {
+		return nil, util.ChildNewtError(err)
+	}
+	out, _ := ioutil.ReadFile(entry + ".out")
+
+	if err := writeFileAtomic(objPath, obj); err != nil {
+		return nil, err
+	}
+
+	deps = append([]byte(s.denormalize(string(deps))), extraDeps...)
+	if err := writeFileAtomic(depPath, deps); err != nil {
+		return nil, err
+	}
+
+	return []byte(s.denormalize(string(out))), nil
+}
+
+// Adds a freshly compiled object to the store.  The store is only a cache, so
+// failures are logged rather than reported.  deps is the dependency file as
+// the compiler wrote it; depPath is the final one, which also lists the
+// compiler's extra dependencies.
+func (s *objStore) add(cmd []string, env []string, objPath string,
+	deps []byte, depPath string, out []byte) {
+
+	err := func() error {
+		sum, err := depsChecksums(depPath, s.normalize)
+		if err != nil {
+			return err
+		}
+
+		keyDir := s.keyDir(cmd, env)
+		if err := os.MkdirAll(keyDir, 0755); err != nil {
+			return err
+		}
+
+		sumHash := sha256.Sum256(sum)
+		entry := filepath.Join(keyDir, hex.EncodeToString(sumHash[:8]))
+
+		obj, err := ioutil.ReadFile(objPath)
+		if err != nil {
+			return err
+		}
+
+		// The checksum list goes last; an entry without one is ignored.
+		files := []struct {
+			ext  string
+			data []byte
+		}{
+			{".o", obj},
+			{".d", []byte(s.normalize(string(deps)))},
+			{".out", []byte(s.normalize(string(out)))},
+			{".sum", sum},
+		}
+		for _, f := range files {
+			if err := writeObjStoreFile(entry+f.ext, f.data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		log.Debugf("Failed to add %s to the object store: %s",
+			objPath, err.Error())
+	}
+}
@@ -0,0 +1,96 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package toolchain
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"mynewt.apache.org/newt/newt/newtutil"
+	"mynewt.apache.org/newt/newt/project"
+	"mynewt.apache.org/newt/util"
+)
+
+// Result of checking each compiler executable against the project's pins;
+// every compiler package is checked once per newt invocation.
+var pinChecks = map[string]error{}
+var pinChecksMtx sync.Mutex
+
+// Reports whether an installed version satisfies a pin.  A pin matches its
+// own version and every more specific one (e.g., "12.2" matches "12.2.1").
+func pinMatches(pinned string, installed string) bool {
+	return installed == pinned || strings.HasPrefix(installed, pinned+".")
+}
+
+func checkPin(pin *project.ToolchainPin, ccPath string) error {
+	installHint := "run \"newt toolchain install " + pin.Name + "@" +
+		pin.Version + "\""
+
+	path, err := exec.LookPath(ccPath)
+	if err != nil {
+		return util.FmtNewtError(
+			"Project requires %s %s, which is not in PATH; %s",
+			pin.Name, pin.Version, installHint).WithClass(
+			util.ERR_CLASS_TOOL_MISSING)
+	}
+
+	out, err := util.ShellCommand(
+		[]string{path, "-dumpfullversion", "-dumpversion"}, nil)
+	if err != nil {
+		return util.FmtNewtError("Failed to determine the version of %s: %s",
+			path, strings.TrimSpace(err.Error()))
+	}
+
+	version := strings.TrimSpace(string(out))
+	if !pinMatches(pin.Version, version) {
+		return util.FmtNewtError(
+			"Project requires %s %s, but %s is version %s; %s",
+			pin.Name, pin.Version, path, version, installHint)
+	}
+
+	return nil
+}
+
+// Verifies that the C compiler in use is the version the project pins, if
+// the project pins it.  Toolchains in a container are not checked; the
+// image determines their version.
+func checkToolchainPin(ccPath string) error {
+	if ccPath == "" || newtutil.NewtContainer != nil {
+		return nil
+	}
+
+	pin := project.GetProject().ToolchainPin(filepath.Base(ccPath))
+	if pin == nil {
+		return nil
+	}
+
+	pinChecksMtx.Lock()
+	defer pinChecksMtx.Unlock()
+
+	err, ok := pinChecks[ccPath]
+	if !ok {
+		err = checkPin(pin, ccPath)
+		pinChecks[ccPath] = err
+	}
+
+	return err
+}
@@ -0,0 +1,111 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package toolchain
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"mynewt.apache.org/newt/util"
+)
+
+// Tracks the number of compile jobs started so far out of the total computed
+// before the build began.
+type progressState struct {
+	mutex sync.Mutex
+	total int
+	done  int
+
+	// Whether progress is shown as a single, continuously rewritten status
+	// line rather than one line per file.
+	tty bool
+
+	// Whether the status line currently occupies the cursor's line.
+	lineActive bool
+}
+
+var progress progressState
+
+// Only redraw a status line when stdout is a terminal and nothing else is
+// going to be interleaved with it (verbose output, shell commands).
+func progressUseTty() bool {
+	if util.Verbosity != util.VERBOSITY_DEFAULT || util.PrintShellCmds {
+		return false
+	}
+
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Resets the progress counters for a build consisting of the specified number
+// of compile jobs.
+func StartProgress(total int) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	progress.total = total
+	progress.done = 0
+	progress.tty = progressUseTty()
+	progress.lineActive = false
+}
+
+// Terminates the status line, if one is displayed, so that subsequent output
+// starts on a fresh line.
+func FinishProgress() {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	if progress.lineActive {
+		statusMessage(util.VERBOSITY_DEFAULT, "\n")
+		progress.lineActive = false
+	}
+	progress.total = 0
+}
+
+// Reports the start of a single compile job.  Without a known total (i.e.,
+// the compiler is being driven outside of a build), a plain status line is
+// printed.
+func reportProgress(verb string, pkgName string, srcPath string) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+
+	if progress.total == 0 {
+		statusMessage(util.VERBOSITY_DEFAULT, "%s %s\n", verb, srcPath)
+		return
+	}
+
+	progress.done++
+	width := len(strconv.Itoa(progress.total))
+	prefix := fmt.Sprintf("[ %*d/%d ]", width, progress.done, progress.total)
+
+	if progress.tty && pkgName != "" {
+		statusMessage(util.VERBOSITY_DEFAULT, "\r\033[K%s %s %s ...",
+			prefix, verb, pkgName)
+		progress.lineActive = true
+	} else {
+		statusMessage(util.VERBOSITY_DEFAULT, "%s %s %s\n",
+			prefix, verb, srcPath)
+	}
+}
@@ -0,0 +1,331 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package util
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Persistent cache of parsed YAML configuration files.  Each entry is keyed by
+// the file's path and is valid as long as the file's modification time and
+// size are unchanged, so only files that were edited since the last run get
+// reparsed.
+
+const CONFIG_CACHE_VERSION = 1
+
+// Files modified less than this long ago are not cached.  A subsequent edit
+// within the file system's timestamp granularity would otherwise go
+// unnoticed.
+const configCacheMinAge = 2 * time.Second
+
+const (
+	cacheKindNil = iota
+	cacheKindString
+	cacheKindInt
+	cacheKindInt64
+	cacheKindUint64
+	cacheKindFloat
+	cacheKindBool
+	cacheKindList
+	cacheKindMap
+	cacheKindStringMap
+)
+
+// A gob-friendly representation of a parsed YAML value.  Maps are stored as
+// alternating keys and values in Elems.
+type cacheVal struct {
+	Kind  int
+	Str   string
+	Int   int64
+	Uint  uint64
+	Float float64
+	Bool  bool
+	Elems []cacheVal
+}
+
+type configCacheEntry struct {
+	ModTime int64
+	Size    int64
+	Config  cacheVal
+}
+
+type configCacheFile struct {
+	Version int
+	Entries map[string]*configCacheEntry
+}
+
+type configCache struct {
+	path string
+	root string
+
+	// Protects entries and dirty; packages are loaded concurrently.
+	mtx     sync.Mutex
+	entries map[string]*configCacheEntry
+	dirty   bool
+}
+
+var globalConfigCache *configCache
+
+func encodeCacheVal(val interface{}) (cacheVal, bool) {
+	switch v := val.(type) {
+	case nil:
+		return cacheVal{Kind: cacheKindNil}, true
+	case string:
+		return cacheVal{Kind: cacheKindString, Str: v}, true
+	case int:
+		return cacheVal{Kind: cacheKindInt, Int: int64(v)}, true
+	case int64:
+		return cacheVal{Kind: cacheKindInt64, Int: v}, true
+	case uint64:
+		return cacheVal{Kind: cacheKindUint64, Uint: v}, true
+	case float64:
+		return cacheVal{Kind: cacheKindFloat, Float: v}, true
+	case bool:
+		return cacheVal{Kind: cacheKindBool, Bool: v}, true
+
+	case []interface{}:
+		cv := cacheVal{Kind: cacheKindList}
+		for _, elem := range v {
+			ce, ok := encodeCacheVal(elem)
+			if !ok {
+				return cv, false
+			}
+			cv.Elems = append(cv.Elems, ce)
+		}
+		return cv, true
+
+	case map[interface{}]interface{}:
+		cv := cacheVal{Kind: cacheKindMap}
+		for key, elem := range v {
+			ck, ok := encodeCacheVal(key)
+			if !ok {
+				return cv, false
+			}
+			ce, ok := encodeCacheVal(elem)
+			if !ok {
+				return cv, false
+			}
+			cv.Elems = append(cv.Elems, ck, ce)
+		}
+		return cv, true
+
+	case map[string]interface{}:
+		cv := cacheVal{Kind: cacheKindStringMap}
+		for key, elem := range v {
+			ce, ok := encodeCacheVal(elem)
+			if !ok {
+				return cv, false
+			}
+			cv.Elems = append(cv.Elems,
+				cacheVal{Kind: cacheKindString, Str: key}, ce)
+		}
+		return cv, true
+
+	default:
+		// Unexpected type; don't cache the file.
+		return cacheVal{}, false
+	}
+}
+
+func decodeCacheVal(cv cacheVal) interface{} {
+	switch cv.Kind {
+	case cacheKindString:
+		return cv.Str
+	case cacheKindInt:
+		return int(cv.Int)
+	case cacheKindInt64:
+		return cv.Int
+	case cacheKindUint64:
+		return cv.Uint
+	case cacheKindFloat:
+		return cv.Float
+	case cacheKindBool:
+		return cv.Bool
+
+	case cacheKindList:
+		list := make([]interface{}, len(cv.Elems))
+		for i, elem := range cv.Elems {
+			list[i] = decodeCacheVal(elem)
+		}
+		return list
+
+	case cacheKindMap:
+		m := make(map[interface{}]interface{}, len(cv.Elems)/2)
+		for i := 0; i+1 < len(cv.Elems); i += 2 {
+			m[decodeCacheVal(cv.Elems[i])] = decodeCacheVal(cv.Elems[i+1])
+		}
+		return m
+
+	case cacheKindStringMap:
+		m := make(map[string]interface{}, len(cv.Elems)/2)
+		for i := 0; i+1 < len(cv.Elems); i += 2 {
+			m[cv.Elems[i].Str] = decodeCacheVal(cv.Elems[i+1])
+		}
+		return m
+
+	default:
+		return nil
+	}
+}
+
+// Enables the configuration cache.  Files under the specified root directory
+// are cached in the specified cache file.  A missing or unreadable cache file
+// is treated as an empty cache.
+func EnableConfigCache(cachePath string, root string) {
+	cc := &configCache{
+		path:    cachePath,
+		root:    filepath.Clean(root) + string(filepath.Separator),
+		entries: map[string]*configCacheEntry{},
+	}
+	globalConfigCache = cc
+
+	f, err := os.Open(cachePath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	cf := configCacheFile{}
+	if err := gob.NewDecoder(f).Decode(&cf); err != nil {
+		log.Debugf("Ignoring unreadable config cache %s: %s", cachePath,
+			err.Error())
+		return
+	}
+
+	if cf.Version == CONFIG_CACHE_VERSION && cf.Entries != nil {
+		cc.entries = cf.Entries
+	}
+}
+
+// Writes the configuration cache back to disk if any entries changed.
+func SaveConfigCache() error {
+	cc := globalConfigCache
+	if cc == nil {
+		return nil
+	}
+
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+
+	if !cc.dirty {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cc.path), 0755); err != nil {
+		return ChildNewtError(err)
+	}
+
+	// Write to a per-process temporary file first so that a concurrent newt
+	// process never sees a partially written cache.
+	tmpPath := fmt.Sprintf("%s.%d.tmp", cc.path, os.Getpid())
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return ChildNewtError(err)
+	}
+
+	cf := configCacheFile{
+		Version: CONFIG_CACHE_VERSION,
+		Entries: cc.entries,
+	}
+	err = gob.NewEncoder(f).Encode(&cf)
+	f.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return ChildNewtError(err)
+	}
+
+	if err := os.Rename(tmpPath, cc.path); err != nil {
+		os.Remove(tmpPath)
+		return ChildNewtError(err)
+	}
+
+	cc.dirty = false
+	return nil
+}
+
+func (cc *configCache) covers(path string) bool {
+	return strings.HasPrefix(path, cc.root)
+}
+
+// Returns the cached configuration for the specified file, or nil if there is
+// no valid entry.
+func (cc *configCache) lookup(path string) map[string]interface{} {
+	if !cc.covers(path) {
+		return nil
+	}
+
+	cc.mtx.Lock()
+	entry := cc.entries[path]
+	cc.mtx.Unlock()
+	if entry == nil {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.ModTime().UnixNano() != entry.ModTime ||
+		info.Size() != entry.Size {
+
+		cc.mtx.Lock()
+		delete(cc.entries, path)
+		cc.dirty = true
+		cc.mtx.Unlock()
+		return nil
+	}
+
+	cfg, ok := decodeCacheVal(entry.Config).(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	return cfg
+}
+
+func (cc *configCache) store(path string, cfg map[string]interface{}) {
+	if !cc.covers(path) {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) < configCacheMinAge {
+		return
+	}
+
+	cv, ok := encodeCacheVal(cfg)
+	if !ok {
+		return
+	}
+
+	cc.mtx.Lock()
+	cc.entries[path] = &configCacheEntry{
+		ModTime: info.ModTime().UnixNano(),
+		Size:    info.Size(),
+		Config:  cv,
+	}
+	cc.dirty = true
+	cc.mtx.Unlock()
+}
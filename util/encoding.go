@@ -0,0 +1,118 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package util
+
+import (
+	"bytes"
+	"io/ioutil"
+	"unicode/utf8"
+)
+
+// Some editors start UTF-8 files with a byte order mark.
+const UTF8_BOM = "\xef\xbb\xbf"
+
+// Windows-1252 characters in the 0x80-0x9f range.  The others match their
+// Unicode code points, as do the bytes Windows-1252 leaves undefined.
+var cp1252Runes = [32]rune{
+	0x20ac, 0x0081, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008d, 0x017d, 0x008f,
+	0x0090, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0x009d, 0x017e, 0x0178,
+}
+
+func cp1252Rune(b byte) rune {
+	if b >= 0x80 && b < 0xa0 {
+		return cp1252Runes[b-0x80]
+	}
+	return rune(b)
+}
+
+// Encoding problems in a text file.
+type TextEncodingIssues struct {
+	// Whether the file starts with a UTF-8 byte order mark.
+	Bom bool
+
+	// Number of bytes that aren't part of a valid UTF-8 sequence, and the
+	// one-based line of the first.
+	InvalidBytes int
+	InvalidLine  int
+}
+
+func (issues TextEncodingIssues) Any() bool {
+	return issues.Bom || issues.InvalidBytes > 0
+}
+
+func CheckTextEncoding(data []byte) TextEncodingIssues {
+	issues := TextEncodingIssues{
+		Bom: bytes.HasPrefix(data, []byte(UTF8_BOM)),
+	}
+
+	line := 1
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			if issues.InvalidBytes == 0 {
+				issues.InvalidLine = line
+			}
+			issues.InvalidBytes++
+		} else if r == '\n' {
+			line++
+		}
+		data = data[size:]
+	}
+
+	return issues
+}
+
+// Converts text from a file of unknown encoding to UTF-8.  A byte order mark
+// is removed, and bytes that aren't part of a valid UTF-8 sequence are taken
+// to be Windows-1252 characters, which is what they usually are in vendor
+// code.  Valid UTF-8 is returned unchanged.
+func DecodeText(data []byte) []byte {
+	data = bytes.TrimPrefix(data, []byte(UTF8_BOM))
+	if utf8.Valid(data) {
+		return data
+	}
+
+	buf := make([]byte, 0, len(data)+len(data)/8)
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			r = cp1252Rune(data[0])
+		}
+
+		var enc [utf8.UTFMax]byte
+		n := utf8.EncodeRune(enc[:], r)
+		buf = append(buf, enc[:n]...)
+		data = data[size:]
+	}
+
+	return buf
+}
+
+// Reads a text file and converts it to UTF-8; see DecodeText().
+func ReadTextFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(FixLongPath(path))
+	if err != nil {
+		return nil, ChildNewtError(err)
+	}
+
+	return DecodeText(data), nil
+}
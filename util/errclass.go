@@ -0,0 +1,136 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package util
+
+import (
+	"net"
+	"os"
+	"os/exec"
+)
+
+// Classes of failure, for wrappers that need to tell them apart without
+// parsing messages.  Each class exits with its own status; see ExitCode().
+const (
+	ERR_CLASS_GENERAL      = "general"
+	ERR_CLASS_RESOLVE      = "resolve"
+	ERR_CLASS_COMPILE      = "compile"
+	ERR_CLASS_LINK         = "link"
+	ERR_CLASS_IMAGE        = "image"
+	ERR_CLASS_TOOL_MISSING = "tool-missing"
+	ERR_CLASS_NETWORK      = "network"
+)
+
+// Exit statuses are part of newt's interface; existing values must not
+// change.
+var errClassExitCodes = map[string]int{
+	ERR_CLASS_GENERAL:      1,
+	ERR_CLASS_RESOLVE:      3,
+	ERR_CLASS_COMPILE:      4,
+	ERR_CLASS_LINK:         5,
+	ERR_CLASS_IMAGE:        6,
+	ERR_CLASS_TOOL_MISSING: 7,
+	ERR_CLASS_NETWORK:      8,
+}
+
+// Returns the error classes in order of exit status.
+func ErrClasses() []string {
+	return []string{
+		ERR_CLASS_GENERAL,
+		ERR_CLASS_RESOLVE,
+		ERR_CLASS_COMPILE,
+		ERR_CLASS_LINK,
+		ERR_CLASS_IMAGE,
+		ERR_CLASS_TOOL_MISSING,
+		ERR_CLASS_NETWORK,
+	}
+}
+
+// Returns the class that exits with the specified status, e.g., for a child
+// newt process.
+func ErrClassFromExitCode(code int) string {
+	for class, c := range errClassExitCodes {
+		if c == code {
+			return class
+		}
+	}
+
+	return ERR_CLASS_GENERAL
+}
+
+// Sets the error's class, replacing any it already has.
+func (se *NewtError) WithClass(class string) *NewtError {
+	se.Class = class
+	return se
+}
+
+// Assigns a class to an error that doesn't have one yet.  The first class
+// assigned is the most specific, so it is kept; e.g., a compiler that can't
+// be found stays a missing tool rather than becoming a compile error.
+func ClassifyError(err error, class string) error {
+	if err == nil {
+		return nil
+	}
+
+	newtErr, ok := err.(*NewtError)
+	if !ok {
+		newtErr = ChildNewtError(err)
+	}
+	if newtErr.Class == "" {
+		newtErr.Class = class
+	}
+
+	return newtErr
+}
+
+// Infers the class of an error that didn't originate in newt.
+func inferErrClass(err error) string {
+	switch e := err.(type) {
+	case *exec.Error:
+		return ERR_CLASS_TOOL_MISSING
+	case *os.PathError:
+		if os.IsNotExist(e) && e.Op == "fork/exec" {
+			return ERR_CLASS_TOOL_MISSING
+		}
+	case net.Error:
+		return ERR_CLASS_NETWORK
+	}
+
+	return ""
+}
+
+// Returns the class of the specified error.
+func ErrClass(err error) string {
+	class := ""
+	if newtErr, ok := err.(*NewtError); ok {
+		class = newtErr.Class
+	} else if err != nil {
+		class = inferErrClass(err)
+	}
+
+	if class == "" {
+		return ERR_CLASS_GENERAL
+	}
+	return class
+}
+
+// Returns the exit status for the specified error's class.
+func ExitCode(err error) int {
+	return errClassExitCodes[ErrClass(err)]
+}
@@ -0,0 +1,60 @@
+//go:build windows
+// +build windows
+
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package util
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Paths at least this long are given the extended-length prefix.  Creating a
+// directory is limited to 248 characters (MAX_PATH less room for an 8.3 file
+// name), so that is the threshold rather than MAX_PATH itself.
+const longPathMin = 248
+
+// Converts a path that is too long for the Win32 file API into its
+// extended-length form (e.g., "\\?\C:\very\long\path").  Such paths bypass
+// the MAX_PATH (260 character) limit, but must be absolute and use
+// backslashes only.  Shorter paths, and paths that are already prefixed, are
+// returned unchanged.
+//
+// The result is only meant to be passed to file system calls; it should not
+// be displayed or handed to external tools.
+func FixLongPath(path string) string {
+	if len(path) < longPathMin || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	abs = filepath.Clean(filepath.FromSlash(abs))
+
+	// UNC paths (\\server\share\...) have their own prefix.
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+
+	return `\\?\` + abs
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+// +build !windows
+
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// Kills the specified process.  If the process leads its own process group
+// (i.e., it was started with Setpgid), the rest of the group is killed too so
+// that children spawned by a wrapper script don't outlive it.
+func KillProcessTree(proc *os.Process) error {
+	pgid, err := syscall.Getpgid(proc.Pid)
+	if err == nil && pgid == proc.Pid {
+		return syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+
+	return proc.Kill()
+}
@@ -45,6 +45,18 @@ var Verbosity int
 var PrintShellCmds bool
 var logFile *os.File
 
+// Receives every status message and DEBUG-level log entry, regardless of the
+// console's verbosity and log level; see InitDebugLog().
+var debugLogFile *os.File
+
+// Console output passed through to the log hook while a debug log file is
+// open.
+var logWriter io.Writer = os.Stderr
+
+// The working directory newt was started in; used to resolve a relative
+// os.Args[0] after newt has changed into the project directory.
+var startDir, _ = os.Getwd()
+
 func ParseEqualsPair(v string) (string, string, error) {
 	s := strings.Split(v, "=")
 	return s[0], s[1], nil
@@ -54,6 +66,9 @@ type NewtError struct {
 	Parent     error
 	Text       string
 	StackTrace []byte
+
+	// One of the ERR_CLASS_[...] constants, or "" if unclassified.
+	Class string
 }
 
 const (
@@ -63,6 +78,24 @@ const (
 	VERBOSITY_VERBOSE = 3
 )
 
+// Subsystems whose status messages can be given their own verbosity.
+const (
+	SUBSYS_RESOLVER   = "resolver"
+	SUBSYS_TOOLCHAIN  = "toolchain"
+	SUBSYS_DOWNLOADER = "downloader"
+	SUBSYS_IMAGE      = "image"
+)
+
+var Subsystems = []string{
+	SUBSYS_RESOLVER,
+	SUBSYS_TOOLCHAIN,
+	SUBSYS_DOWNLOADER,
+	SUBSYS_IMAGE,
+}
+
+// Per-subsystem verbosity.  A subsystem not in this map uses Verbosity.
+var SubsysVerbosity = map[string]int{}
+
 func (se *NewtError) Error() string {
 	return se.Text
 }
@@ -91,25 +124,43 @@ func PreNewtError(err error, format string, args ...interface{}) *NewtError {
 }
 
 func ChildNewtError(parent error) *NewtError {
+	class := ""
 	for {
 		newtErr, ok := parent.(*NewtError)
-		if !ok || newtErr == nil || newtErr.Parent == nil {
+		if !ok || newtErr == nil {
+			break
+		}
+		if class == "" {
+			class = newtErr.Class
+		}
+		if newtErr.Parent == nil {
 			break
 		}
 		parent = newtErr.Parent
 	}
+	if class == "" {
+		class = inferErrClass(parent)
+	}
 
 	newtErr := NewNewtError(parent.Error())
 	newtErr.Parent = parent
+	newtErr.Class = class
 	return newtErr
 }
 
-// Print Silent, Quiet and Verbose aware status messages to stdout.
-func WriteMessage(f *os.File, level int, message string,
+func writeMessage(f *os.File, verbosity int, level int, message string,
 	args ...interface{}) {
 
-	if Verbosity >= level {
-		str := fmt.Sprintf(message, args...)
+	if verbosity < level && debugLogFile == nil {
+		return
+	}
+
+	str := fmt.Sprintf(message, args...)
+	if debugLogFile != nil {
+		debugLogFile.WriteString(str)
+	}
+
+	if verbosity >= level {
 		f.WriteString(str)
 		f.Sync()
 
@@ -119,6 +170,13 @@ func WriteMessage(f *os.File, level int, message string,
 	}
 }
 
+// Print Silent, Quiet and Verbose aware status messages to stdout.
+func WriteMessage(f *os.File, level int, message string,
+	args ...interface{}) {
+
+	writeMessage(f, Verbosity, level, message, args...)
+}
+
 // Print Silent, Quiet and Verbose aware status messages to stdout.
 func StatusMessage(level int, message string, args ...interface{}) {
 	WriteMessage(os.Stdout, level, message, args...)
@@ -129,8 +187,45 @@ func ErrorMessage(level int, message string, args ...interface{}) {
 	WriteMessage(os.Stderr, level, message, args...)
 }
 
+// Returns the verbosity in effect for the specified subsystem.
+func SubsysVerbosityLevel(subsys string) int {
+	if verbosity, ok := SubsysVerbosity[subsys]; ok {
+		return verbosity
+	}
+	return Verbosity
+}
+
+// Print status messages to stdout according to the specified subsystem's
+// verbosity.
+func SubsysStatusMessage(subsys string, level int, message string,
+	args ...interface{}) {
+
+	writeMessage(os.Stdout, SubsysVerbosityLevel(subsys), level, message,
+		args...)
+}
+
+// Returns the absolute path of the running newt executable.  This is derived
+// from os.Args[0]: a bare command name is looked up in $PATH, anything else is
+// resolved relative to the directory newt was started in.
+func Executable() (string, error) {
+	exe := os.Args[0]
+	if filepath.Base(exe) == exe {
+		path, err := exec.LookPath(exe)
+		if err != nil {
+			return "", ChildNewtError(err)
+		}
+		exe = path
+	}
+
+	if !filepath.IsAbs(exe) {
+		exe = filepath.Join(startDir, exe)
+	}
+
+	return filepath.Clean(exe), nil
+}
+
 func NodeExist(path string) bool {
-	if _, err := os.Stat(path); err == nil {
+	if _, err := os.Stat(FixLongPath(path)); err == nil {
 		return true
 	} else {
 		return false
@@ -139,7 +234,7 @@ func NodeExist(path string) bool {
 
 // Check whether the node (either dir or file) specified by path exists
 func NodeNotExist(path string) bool {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	if _, err := os.Stat(FixLongPath(path)); os.IsNotExist(err) {
 		return true
 	} else {
 		return false
@@ -147,7 +242,7 @@ func NodeNotExist(path string) bool {
 }
 
 func FileModificationTime(path string) (time.Time, error) {
-	fileInfo, err := os.Stat(path)
+	fileInfo, err := os.Stat(FixLongPath(path))
 	if err != nil {
 		epoch := time.Unix(0, 0)
 		if os.IsNotExist(err) {
@@ -161,7 +256,7 @@ func FileModificationTime(path string) (time.Time, error) {
 }
 
 func ChildDirs(path string) ([]string, error) {
-	children, err := ioutil.ReadDir(path)
+	children, err := ioutil.ReadDir(FixLongPath(path))
 	if err != nil {
 		return nil, NewNewtError(err.Error())
 	}
@@ -225,12 +320,54 @@ func initLog(level log.Level, logFilename string) error {
 		writer = io.MultiWriter(os.Stderr, logFile)
 	}
 
+	logWriter = writer
 	log.SetOutput(writer)
 	log.SetFormatter(&logFormatter{})
 
 	return nil
 }
 
+// Passes log entries at or above the console's log level through to the
+// console (and outfile, if any) while everything goes to the debug log file.
+type consoleLogHook struct {
+	level log.Level
+}
+
+func (h *consoleLogHook) Levels() []log.Level {
+	levels := []log.Level{}
+	for _, level := range log.AllLevels {
+		if level <= h.level {
+			levels = append(levels, level)
+		}
+	}
+	return levels
+}
+
+func (h *consoleLogHook) Fire(entry *log.Entry) error {
+	b, err := (&logFormatter{}).Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = logWriter.Write(b)
+	return err
+}
+
+// Opens a log file that captures DEBUG-level log output and every status
+// message, however quiet the console is.  Must be called after Init().
+func InitDebugLog(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return ChildNewtError(err)
+	}
+	debugLogFile = f
+
+	log.AddHook(&consoleLogHook{level: log.GetLevel()})
+	log.SetLevel(log.DebugLevel)
+	log.SetOutput(f)
+
+	return nil
+}
+
 // Initialize the util module
 func Init(logLevel log.Level, logFile string, verbosity int) error {
 	// Configure logging twice.  First just configure the filter for stderr;
@@ -261,13 +398,33 @@ func ReadConfig(path string, name string) (*viper.Viper, error) {
 	v.SetConfigName(name)
 	v.AddConfigPath(path)
 
+	cachePath := ""
+	if globalConfigCache != nil {
+		if abs, err := filepath.Abs(filepath.Join(path, name+".yml")); err == nil {
+			cachePath = abs
+			if cfg := globalConfigCache.lookup(cachePath); cfg != nil {
+				v.SetConfigMap(cfg)
+				return v, nil
+			}
+		}
+	}
+
+	v.SetConfigDecoder(DecodeText)
 	err := v.ReadInConfig()
 	if err != nil {
 		return nil, NewNewtError(fmt.Sprintf("Error reading %s.yml: %s",
 			filepath.Join(path, name), err.Error()))
-	} else {
-		return v, nil
 	}
+
+	if cachePath != "" {
+		if used, err := filepath.Abs(v.ConfigFileUsed()); err == nil &&
+			used == cachePath {
+
+			globalConfigCache.store(cachePath, v.ConfigMap())
+		}
+	}
+
+	return v, nil
 }
 
 // Execute the specified process and block until it completes.  Additionally,
@@ -320,7 +477,7 @@ func ShellCommandLimitDbgOutput(
 		if len(o) > 0 {
 			return o, NewNewtError(string(o))
 		} else {
-			return o, NewNewtError(err.Error())
+			return o, ChildNewtError(err)
 		}
 	} else {
 		return o, nil
@@ -371,7 +528,7 @@ func ShellInteractiveCommand(cmdStr []string, env []string) error {
 	proc, err := os.StartProcess(cmdStr[0], cmdStr, &pa)
 	if err != nil {
 		signal.Stop(c)
-		return NewNewtError(err.Error())
+		return ChildNewtError(err)
 	}
 
 	// Release and exit
@@ -385,7 +542,7 @@ func ShellInteractiveCommand(cmdStr []string, env []string) error {
 }
 
 func CopyFile(srcFile string, dstFile string) error {
-	in, err := os.Open(srcFile)
+	in, err := os.Open(FixLongPath(srcFile))
 	if err != nil {
 		return ChildNewtError(err)
 	}
@@ -396,13 +553,12 @@ func CopyFile(srcFile string, dstFile string) error {
 		return ChildNewtError(err)
 	}
 
-	dstDir := filepath.Dir(dstFile)
-	if err := os.MkdirAll(dstDir, os.ModePerm); err != nil {
-		return ChildNewtError(err)
+	if err := MkdirAll(filepath.Dir(dstFile), os.ModePerm); err != nil {
+		return err
 	}
 
-	out, err := os.OpenFile(dstFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC,
-		info.Mode())
+	out, err := os.OpenFile(FixLongPath(dstFile),
+		os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
 	if err != nil {
 		return ChildNewtError(err)
 	}
@@ -416,7 +572,7 @@ func CopyFile(srcFile string, dstFile string) error {
 }
 
 func CopyDir(srcDirStr, dstDirStr string) error {
-	srcDir, err := os.Open(srcDirStr)
+	srcDir, err := os.Open(FixLongPath(srcDirStr))
 	if err != nil {
 		return ChildNewtError(err)
 	}
@@ -426,8 +582,8 @@ func CopyDir(srcDirStr, dstDirStr string) error {
 		return ChildNewtError(err)
 	}
 
-	if err := os.MkdirAll(filepath.Dir(dstDirStr), info.Mode()); err != nil {
-		return ChildNewtError(err)
+	if err := MkdirAll(filepath.Dir(dstDirStr), info.Mode()); err != nil {
+		return err
 	}
 
 	infos, err := srcDir.Readdir(-1)
@@ -436,8 +592,8 @@ func CopyDir(srcDirStr, dstDirStr string) error {
 	}
 
 	for _, info := range infos {
-		src := srcDirStr + "/" + info.Name()
-		dst := dstDirStr + "/" + info.Name()
+		src := filepath.Join(srcDirStr, info.Name())
+		dst := filepath.Join(dstDirStr, info.Name())
 		if info.IsDir() {
 			if err := CopyDir(src, dst); err != nil {
 				return err
@@ -457,7 +613,7 @@ func MoveFile(srcFile string, destFile string) error {
 		return err
 	}
 
-	if err := os.RemoveAll(srcFile); err != nil {
+	if err := os.RemoveAll(FixLongPath(srcFile)); err != nil {
 		return ChildNewtError(err)
 	}
 
@@ -469,7 +625,7 @@ func MoveDir(srcDir string, destDir string) error {
 		return err
 	}
 
-	if err := os.RemoveAll(srcDir); err != nil {
+	if err := os.RemoveAll(FixLongPath(srcDir)); err != nil {
 		return ChildNewtError(err)
 	}
 
@@ -478,15 +634,15 @@ func MoveDir(srcDir string, destDir string) error {
 
 // Reads each line from the specified text file into an array of strings.  If a
 // line ends with a backslash, it is concatenated with the following line.
+// The text is converted to UTF-8 first; see DecodeText().
 func ReadLines(path string) ([]string, error) {
-	file, err := os.Open(path)
+	data, err := ioutil.ReadFile(FixLongPath(path))
 	if err != nil {
 		return nil, NewNewtError(err.Error())
 	}
-	defer file.Close()
 
 	lines := []string{}
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(bytes.NewReader(DecodeText(data)))
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -580,7 +736,7 @@ func IsNotExist(err error) bool {
 }
 
 func FileContentsChanged(path string, newContents []byte) (bool, error) {
-	oldContents, err := ioutil.ReadFile(path)
+	oldContents, err := ioutil.ReadFile(FixLongPath(path))
 	if err != nil {
 		if os.IsNotExist(err) {
 			// File doesn't exist; write required.
@@ -594,6 +750,30 @@ func FileContentsChanged(path string, newContents []byte) (bool, error) {
 	return rc != 0, nil
 }
 
+// Creates a directory and any missing parents.  Unlike os.MkdirAll(), this
+// handles paths longer than the Windows MAX_PATH limit.
+func MkdirAll(path string, perm os.FileMode) error {
+	if err := os.MkdirAll(FixLongPath(path), perm); err != nil {
+		return ChildNewtError(err)
+	}
+
+	return nil
+}
+
+// Writes a file, creating its parent directory if necessary.  Like
+// MkdirAll(), this handles paths longer than the Windows MAX_PATH limit.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	if err := MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(FixLongPath(path), data, perm); err != nil {
+		return ChildNewtError(err)
+	}
+
+	return nil
+}
+
 func CIdentifier(s string) string {
 	s = strings.Replace(s, "/", "_", -1)
 	s = strings.Replace(s, "-", "_", -1)
@@ -155,6 +155,9 @@ type Viper struct {
 	typeByDefValue bool
 
 	onConfigChange func(fsnotify.Event)
+
+	// Applied to the configuration file's contents; see SetConfigDecoder.
+	configDecoder func([]byte) []byte
 }
 
 // Returns an initialized Viper instance.
@@ -935,12 +938,31 @@ func (v *Viper) ReadInConfig() error {
 	if err != nil {
 		return err
 	}
+	if v.configDecoder != nil {
+		file = v.configDecoder(file)
+	}
 
 	v.config = make(map[string]interface{})
 
 	return v.unmarshalReader(bytes.NewReader(file), v.config)
 }
 
+// SetConfigDecoder sets a function that ReadInConfig applies to the
+// contents of the configuration file before parsing it, e.g., to convert it
+// to UTF-8.
+func (v *Viper) SetConfigDecoder(fn func([]byte) []byte) {
+	v.configDecoder = fn
+}
+
+// ConfigMap returns the settings read from the configuration file.
+func (v *Viper) ConfigMap() map[string]interface{} { return v.config }
+
+// SetConfigMap replaces the settings read from the configuration file.  This
+// allows a previously read configuration to be restored without reparsing.
+func (v *Viper) SetConfigMap(cfg map[string]interface{}) {
+	v.config = cfg
+}
+
 // MergeInConfig merges a new configuration with an existing config.
 func MergeInConfig() error { return v.MergeInConfig() }
 func (v *Viper) MergeInConfig() error {